@@ -0,0 +1,25 @@
+#!/bin/bash
+# Asks the policy service of the BillionMail core whether the delivery of a message is
+# a duplicate: exits 0 when it is, so that duplicates.sieve discards it.
+#
+# Usage: duplicate-check.sh <recipient> <message-id>
+#
+# Any failure exits 1 and the message is delivered, an unreachable core never loses mail.
+
+POLICY_HOST=${POLICY_HOST:-core}
+POLICY_PORT=${POLICY_PORT:-10040}
+
+# One attribute per line, a line break would end the request
+recipient=${1//[$'\r\n']/}
+message_id=${2//[$'\r\n']/}
+
+[ -n "$recipient" ] && [ -n "$message_id" ] || exit 1
+
+exec 3<>"/dev/tcp/${POLICY_HOST}/${POLICY_PORT}" 2>/dev/null || exit 1
+
+printf 'protocol_state=DELIVERY\nrecipient=%s\nmessage_id=%s\n\n' "$recipient" "$message_id" >&3
+
+read -r -t 5 reply <&3 || exit 1
+exec 3<&-
+
+[[ "$reply" == action=DISCARD* ]]
@@ -0,0 +1,177 @@
+-- Inbound policy hook of BillionMail.
+--
+-- Once an inbound message is scanned its authentication results and score are sent to
+-- the policy service of the core, the postfix policy protocol at the SCAN stage which
+-- postfix itself never sends. The action answered is applied by the milter: REJECT
+-- rejects the message, HOLD puts it in the postfix hold queue, TAG adds the spam header.
+--
+-- The settings are rendered by the core in local.d/billionmail.conf, the hook only runs
+-- when a policy of the core needs it. An unreachable core never blocks mail, the
+-- message then gets the actions of rspamd alone.
+
+local rspamd_logger = require "rspamd_logger"
+local rspamd_tcp = require "rspamd_tcp"
+local rspamd_util = require "rspamd_util"
+
+local N = 'billionmail'
+
+local opts = rspamd_config:get_all_opt(N)
+if not opts or not opts.inbound_policy then
+  return
+end
+
+local policy_host = opts.policy_host or 'core'
+local policy_port = tonumber(opts.policy_port) or 10040
+local policy_timeout = tonumber(opts.policy_timeout) or 5.0
+
+-- DKIM_TRACE options are "domain:result", the result a single character
+local dkim_results = {
+  ['+'] = 'pass',
+  ['-'] = 'fail',
+  ['?'] = 'temperror',
+  ['~'] = 'permerror',
+}
+
+local spf_results = {
+  { 'R_SPF_ALLOW', 'pass' },
+  { 'R_SPF_FAIL', 'fail' },
+  { 'R_SPF_SOFTFAIL', 'softfail' },
+  { 'R_SPF_NEUTRAL', 'neutral' },
+  { 'R_SPF_DNSFAIL', 'temperror' },
+  { 'R_SPF_PERMFAIL', 'permerror' },
+  { 'R_SPF_NA', 'none' },
+}
+
+-- value of an attribute, one line
+local function attr(value)
+  return (tostring(value or ''):gsub('[\r\n]', ' '))
+end
+
+local function org_domain(domain)
+  if not domain or domain == '' then
+    return ''
+  end
+  return string.lower(rspamd_util.get_tld(domain) or domain)
+end
+
+local function first_address(addresses)
+  if addresses and addresses[1] then
+    return addresses[1]
+  end
+  return {}
+end
+
+local function scan_request(task)
+  local header_from = string.lower(first_address(task:get_from('mime')).domain or '')
+  local from_org = org_domain(header_from)
+
+  local envelope = first_address(task:get_from('smtp'))
+  local spf_domain = envelope.domain
+  if not spf_domain or spf_domain == '' then
+    spf_domain = task:get_helo()
+  end
+
+  local spf = 'none'
+  for _, r in ipairs(spf_results) do
+    if task:has_symbol(r[1]) then
+      spf = r[2]
+      break
+    end
+  end
+
+  local dkim, dkim_aligned = {}, false
+  local trace = task:get_symbol('DKIM_TRACE')
+  if trace and trace[1] and trace[1].options then
+    for _, opt in ipairs(trace[1].options) do
+      local domain, result = string.match(opt, '^(.+):(.)$')
+      if domain then
+        result = dkim_results[result] or 'neutral'
+        table.insert(dkim, string.lower(domain) .. ':' .. result)
+        if result == 'pass' and from_org ~= '' and org_domain(domain) == from_org then
+          dkim_aligned = true
+        end
+      end
+    end
+  end
+
+  local recipients = {}
+  for _, rcpt in ipairs(task:get_recipients('smtp') or {}) do
+    if rcpt.addr and rcpt.addr ~= '' then
+      table.insert(recipients, string.lower(rcpt.addr))
+    end
+  end
+
+  local score = 0
+  local metric = task:get_metric_score('default')
+  if metric then
+    score = metric[1]
+  end
+
+  local ip = task:get_from_ip()
+
+  local lines = {
+    'protocol_state=SCAN',
+    'queue_id=' .. attr(task:get_queue_id()),
+    'client_address=' .. attr(ip and ip:is_valid() and tostring(ip) or ''),
+    'client_name=' .. attr(task:get_hostname()),
+    'sender=' .. attr(envelope.addr),
+    'recipients=' .. attr(table.concat(recipients, ',')),
+    'header_from=' .. attr(header_from),
+    'header_from_org=' .. attr(from_org),
+    'spf=' .. spf,
+    'spf_aligned=' .. tostring(spf == 'pass' and from_org ~= '' and org_domain(spf_domain) == from_org),
+    'dkim=' .. attr(table.concat(dkim, ',')),
+    'dkim_aligned=' .. tostring(dkim_aligned),
+    'spam_score=' .. string.format('%.2f', score),
+  }
+
+  return table.concat(lines, '\n') .. '\n\n'
+end
+
+local function apply_action(task, reply)
+  local action, text = string.match(reply, 'action=(%S+)%s*([^\n]*)')
+  if not action then
+    return
+  end
+
+  if text == '' then
+    text = nil
+  end
+
+  if action == 'REJECT' then
+    task:set_pre_result('reject', text or 'Rejected by the inbound policy', N)
+  elseif action == 'HOLD' then
+    task:set_pre_result('quarantine', text or 'Held by the inbound policy', N)
+  elseif action == 'TAG' then
+    task:set_pre_result('add header', text or 'Tagged by the inbound policy', N)
+  end
+end
+
+rspamd_config:register_symbol({
+  name = 'BILLIONMAIL_INBOUND_POLICY',
+  type = 'postfilter',
+  flags = 'empty',
+  callback = function(task)
+    -- Authenticated submissions are outbound mail
+    if task:get_user() then
+      return
+    end
+
+    rspamd_tcp.request({
+      task = task,
+      host = policy_host,
+      port = policy_port,
+      timeout = policy_timeout,
+      data = scan_request(task),
+      read = true,
+      stop_pattern = '\n\n',
+      callback = function(err, data)
+        if err then
+          rspamd_logger.warnx(task, 'inbound policy service %s:%s unavailable: %s', policy_host, policy_port, err)
+          return
+        end
+        apply_action(task, tostring(data))
+      end,
+    })
+  end,
+})
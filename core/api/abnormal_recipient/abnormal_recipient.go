@@ -19,4 +19,10 @@ type IAbnormalRecipientV1 interface {
 	SetAbnormalSwitch(ctx context.Context, req *v1.SetAbnormalSwitchReq) (res *v1.SetAbnormalSwitchRes, err error)
 	ClearabnormalRecipient(ctx context.Context, req *v1.ClearabnormalRecipientReq) (res *v1.ClearabnormalRecipientRes, err error)
 	GetScanLog(ctx context.Context, req *v1.GetScanLogReq) (res *v1.GetScanLogRes, err error)
+	BounceWebhook(ctx context.Context, req *v1.BounceWebhookReq) (res *v1.BounceWebhookRes, err error)
+	SuppressionWebhook(ctx context.Context, req *v1.SuppressionWebhookReq) (res *v1.SuppressionWebhookRes, err error)
+	GetBounceWebhookConfig(ctx context.Context, req *v1.GetBounceWebhookConfigReq) (res *v1.GetBounceWebhookConfigRes, err error)
+	SetBounceWebhookConfig(ctx context.Context, req *v1.SetBounceWebhookConfigReq) (res *v1.SetBounceWebhookConfigRes, err error)
+	ExportSuppressions(ctx context.Context, req *v1.ExportSuppressionsReq) (res *v1.ExportSuppressionsRes, err error)
+	ImportSuppressions(ctx context.Context, req *v1.ImportSuppressionsReq) (res *v1.ImportSuppressionsRes, err error)
 }
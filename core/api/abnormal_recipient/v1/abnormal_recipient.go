@@ -12,7 +12,7 @@ type AbnormalRecipient struct {
 	Description string `json:"description" dc:"Description"`
 	Count       int    `json:"count"       dc:"Count"`
 	AddType     int    `json:"add_type"    dc:"Add Type"`
-	// AddType:Description     1: Manually added, 2: Automatically scanned, 3: Manually scanned
+	// AddType:Description     1: Manually added, 2: Automatically scanned, 3: Manually scanned, 4: Imported
 }
 
 type ListAbnormalRecipientReq struct {
@@ -99,3 +99,82 @@ type GetScanLogReq struct {
 type GetScanLogRes struct {
 	api_v1.StandardRes
 }
+
+type BounceWebhookReq struct {
+	g.Meta   `path:"/abnormal_recipient/bounce_webhook" method:"post" tags:"Abnormal Recipient" summary:"Receive bounce and complaint webhooks of external relays"`
+	Provider string `json:"provider" v:"required|in:ses,sendgrid,mailgun" dc:"Relay provider" in:"query"`
+}
+
+type BounceWebhookRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Events int `json:"events" dc:"Number of accepted events"`
+	} `json:"data"`
+}
+
+type SuppressionWebhookReq struct {
+	g.Meta   `path:"/abnormal_recipient/suppression_webhook" method:"post" tags:"Abnormal Recipient" summary:"Receive complaint and unsubscribe webhooks of external ESPs"`
+	Provider string `json:"provider" v:"required|in:ses,sendgrid,mailgun" dc:"ESP provider" in:"query"`
+}
+
+type SuppressionWebhookRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Events     int `json:"events" dc:"Number of complaints and unsubscribes"`
+		Duplicates int `json:"duplicates" dc:"Events already received"`
+		Added      int `json:"added" dc:"Addresses newly suppressed"`
+		Updated    int `json:"updated" dc:"Suppressions moved to an earlier date"`
+		Unchanged  int `json:"unchanged" dc:"Addresses already suppressed earlier"`
+	} `json:"data"`
+}
+
+type GetBounceWebhookConfigReq struct {
+	g.Meta        `path:"/abnormal_recipient/bounce_webhook/config" method:"get" tags:"Abnormal Recipient" summary:"Get bounce webhook verification settings"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetBounceWebhookConfigRes struct {
+	api_v1.StandardRes
+	Data struct {
+		SESTopicArns      []string `json:"ses_topic_arns" dc:"Accepted SNS topic ARNs"`
+		SendGridPublicKey string   `json:"sendgrid_public_key" dc:"SendGrid verification key"`
+		MailgunSigningKey bool     `json:"mailgun_signing_key" dc:"Whether a Mailgun signing key is set"`
+	} `json:"data"`
+}
+
+type SetBounceWebhookConfigReq struct {
+	g.Meta            `path:"/abnormal_recipient/bounce_webhook/set_config" method:"post" tags:"Abnormal Recipient" summary:"Set bounce webhook verification settings"`
+	Authorization     string   `json:"authorization" dc:"Authorization" in:"header"`
+	SESTopicArns      []string `json:"ses_topic_arns" dc:"Accepted SNS topic ARNs"`
+	SendGridPublicKey string   `json:"sendgrid_public_key" dc:"SendGrid verification key"`
+	MailgunSigningKey string   `json:"mailgun_signing_key" dc:"Mailgun signing key, empty keeps the current key"`
+}
+
+type SetBounceWebhookConfigRes struct {
+	api_v1.StandardRes
+}
+
+type ExportSuppressionsReq struct {
+	g.Meta        `path:"/abnormal_recipient/export_suppressions" method:"get" tags:"Abnormal Recipient" summary:"Export the suppression list"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Format        string `json:"format" v:"in:csv,json" d:"csv" dc:"File format, csv or json"`
+	Scope         string `json:"scope" v:"in:global,list" dc:"Only global or list suppressions, all when empty"`
+	GroupId       int    `json:"group_id" dc:"Only the list suppressions of this contact group"`
+}
+
+type ExportSuppressionsRes struct {
+	api_v1.StandardRes
+}
+
+type ImportSuppressionsReq struct {
+	g.Meta        `path:"/abnormal_recipient/import_suppressions" method:"post" tags:"Abnormal Recipient" summary:"Import a suppression list"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	FileData      string `json:"file_data" v:"required" dc:"Content of an exported suppression list, CSV or JSON"`
+	Format        string `json:"format" v:"in:csv,json" dc:"File format, detected when empty"`
+	Scope         string `json:"scope" v:"in:global,list" dc:"Apply every record with this scope, as in the file when empty"`
+	GroupId       int    `json:"group_id" dc:"Apply the list suppressions to this contact group instead of their list_id"`
+}
+
+type ImportSuppressionsRes struct {
+	api_v1.StandardRes
+}
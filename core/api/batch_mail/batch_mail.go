@@ -18,6 +18,8 @@ type IBatchMailV1 interface {
 	ApiTemplatesDelete(ctx context.Context, req *v1.ApiTemplatesDeleteReq) (res *v1.ApiTemplatesDeleteRes, err error)
 	ApiMailSend(ctx context.Context, req *v1.ApiMailSendReq) (res *v1.ApiMailSendRes, err error)
 	ApiMailBatchSend(ctx context.Context, req *v1.ApiMailBatchSendReq) (res *v1.ApiMailBatchSendRes, err error)
+	ApiMailPersonalizedSend(ctx context.Context, req *v1.ApiMailPersonalizedSendReq) (res *v1.ApiMailPersonalizedSendRes, err error)
+	ApiMailTransactionalSend(ctx context.Context, req *v1.ApiMailTransactionalSendReq) (res *v1.ApiMailTransactionalSendRes, err error)
 	ListTasks(ctx context.Context, req *v1.ListTasksReq) (res *v1.ListTasksRes, err error)
 	TaskInfo(ctx context.Context, req *v1.TaskInfoReq) (res *v1.TaskInfoRes, err error)
 	TaskOverview(ctx context.Context, req *v1.TaskOverviewReq) (res *v1.TaskOverviewRes, err error)
@@ -27,7 +29,21 @@ type IBatchMailV1 interface {
 	TaskMailProviderStat(ctx context.Context, req *v1.TaskMailProviderStatReq) (res *v1.TaskMailProviderStatRes, err error)
 	GetTaskMailLogs(ctx context.Context, req *v1.GetTaskMailLogsReq) (res *v1.GetTaskMailLogsRes, err error)
 	SendTestEmail(ctx context.Context, req *v1.SendTestEmailReq) (res *v1.SendTestEmailRes, err error)
+	SendCampaignPreview(ctx context.Context, req *v1.SendCampaignPreviewReq) (res *v1.SendCampaignPreviewRes, err error)
+	SetTaskABTest(ctx context.Context, req *v1.SetTaskABTestReq) (res *v1.SetTaskABTestRes, err error)
+	TaskLiveStream(ctx context.Context, req *v1.TaskLiveStreamReq) (res *v1.TaskLiveStreamRes, err error)
 	TaskStatChart(ctx context.Context, req *v1.TaskStatChartReq) (res *v1.TaskStatChartRes, err error)
+	ExportTaskResults(ctx context.Context, req *v1.ExportTaskResultsReq) (res *v1.ExportTaskResultsRes, err error)
+	TaskLinkStats(ctx context.Context, req *v1.TaskLinkStatsReq) (res *v1.TaskLinkStatsRes, err error)
+	GetFrequencyCap(ctx context.Context, req *v1.GetFrequencyCapReq) (res *v1.GetFrequencyCapRes, err error)
+	SetFrequencyCap(ctx context.Context, req *v1.SetFrequencyCapReq) (res *v1.SetFrequencyCapRes, err error)
+	GetCircuitBreaker(ctx context.Context, req *v1.GetCircuitBreakerReq) (res *v1.GetCircuitBreakerRes, err error)
+	SetCircuitBreaker(ctx context.Context, req *v1.SetCircuitBreakerReq) (res *v1.SetCircuitBreakerRes, err error)
+	GetSendGuarantee(ctx context.Context, req *v1.GetSendGuaranteeReq) (res *v1.GetSendGuaranteeRes, err error)
+	SetSendGuarantee(ctx context.Context, req *v1.SetSendGuaranteeReq) (res *v1.SetSendGuaranteeRes, err error)
+	GetVERP(ctx context.Context, req *v1.GetVERPReq) (res *v1.GetVERPRes, err error)
+	SetVERP(ctx context.Context, req *v1.SetVERPReq) (res *v1.SetVERPRes, err error)
+	SendAdvice(ctx context.Context, req *v1.SendAdviceReq) (res *v1.SendAdviceRes, err error)
 	UpdateTaskInfo(ctx context.Context, req *v1.UpdateTaskInfoReq) (res *v1.UpdateTaskInfoRes, err error)
 	UpdateTaskSpeed(ctx context.Context, req *v1.UpdateTaskSpeedReq) (res *v1.UpdateTaskSpeedRes, err error)
 	PauseTask(ctx context.Context, req *v1.PauseTaskReq) (res *v1.PauseTaskRes, err error)
@@ -35,4 +51,8 @@ type IBatchMailV1 interface {
 	Unsubscribe(ctx context.Context, req *v1.UnsubscribeReq) (res *v1.UnsubscribeRes, err error)
 	GetUserGroups(ctx context.Context, req *v1.GetUserGroupsReq) (res *v1.GetUserGroupsRes, err error)
 	UnsubscribeNew(ctx context.Context, req *v1.UnsubscribeNewReq) (res *v1.UnsubscribeNewRes, err error)
+	GetMailCategories(ctx context.Context, req *v1.GetMailCategoriesReq) (res *v1.GetMailCategoriesRes, err error)
+	SetMailCategories(ctx context.Context, req *v1.SetMailCategoriesReq) (res *v1.SetMailCategoriesRes, err error)
+	GetPreferences(ctx context.Context, req *v1.GetPreferencesReq) (res *v1.GetPreferencesRes, err error)
+	SetPreferences(ctx context.Context, req *v1.SetPreferencesReq) (res *v1.SetPreferencesRes, err error)
 }
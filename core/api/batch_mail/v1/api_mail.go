@@ -153,6 +153,7 @@ type ApiMailSendReq struct {
 	Addresser     string            `json:"addresser" dc:"addresser"`
 	Recipient     string            `json:"recipient" dc:"recipient"`
 	Attribs       map[string]string `json:"attribs" dc:"Custom properties"`
+	Priority      string            `json:"priority" dc:"Queue priority: high, normal or bulk, normal by default"`
 }
 
 type ApiMailSendRes struct {
@@ -166,8 +167,56 @@ type ApiMailBatchSendReq struct {
 	Addresser     string            `json:"addresser" dc:"addresser"`
 	Recipients    []string          `json:"recipients" dc:"recipients"`
 	Attribs       map[string]string `json:"attribs" dc:"Custom properties"`
+	Priority      string            `json:"priority" dc:"Queue priority: high, normal or bulk, normal by default"`
 }
 
 type ApiMailBatchSendRes struct {
 	api_v1.StandardRes
 }
+
+type ApiMailRecipient struct {
+	Recipient string            `json:"recipient" dc:"recipient"`
+	Headers   map[string]string `json:"headers" dc:"Custom headers of this message"`
+	Attribs   map[string]string `json:"attribs" dc:"Custom properties of this message"`
+}
+
+type ApiMailRecipientResult struct {
+	Recipient string `json:"recipient" dc:"recipient"`
+	Accepted  bool   `json:"accepted" dc:"Queued for sending"`
+	MessageId string `json:"message_id,omitempty" dc:"Message-ID of the queued message"`
+	Error     string `json:"error,omitempty" dc:"Reason of the rejection"`
+}
+
+type ApiMailPersonalizedSendReq struct {
+	g.Meta        `path:"/batch_mail/api/personalized_send" method:"post" tags:"ApiMail" summary:"call api send mail with per-recipient headers and properties"`
+	Authorization string              `json:"authorization" dc:"Authorization" in:"header"`
+	ApiKey        string              `json:"x-api-key" dc:"API Key" in:"header"`
+	Addresser     string              `json:"addresser" dc:"addresser"`
+	Recipients    []*ApiMailRecipient `json:"recipients" dc:"recipients with their own headers and properties"`
+	Priority      string              `json:"priority" dc:"Queue priority: high, normal or bulk, normal by default"`
+}
+
+type ApiMailPersonalizedSendRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Accepted int                       `json:"accepted" dc:"Number of queued messages"`
+		Rejected int                       `json:"rejected" dc:"Number of rejected recipients"`
+		Results  []*ApiMailRecipientResult `json:"results" dc:"Status of each recipient, in the request order"`
+	} `json:"data"`
+}
+
+type ApiMailTransactionalSendReq struct {
+	g.Meta        `path:"/batch_mail/api/transactional_send" method:"post" tags:"ApiMail" summary:"call api send mail right away to many recipients"`
+	Authorization string              `json:"authorization" dc:"Authorization" in:"header"`
+	ApiKey        string              `json:"x-api-key" dc:"API Key" in:"header"`
+	Recipients    []*ApiMailRecipient `json:"recipients" dc:"recipients with their own headers and properties"`
+}
+
+type ApiMailTransactionalSendRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Accepted int                       `json:"accepted" dc:"Number of messages accepted by the mail server"`
+		Rejected int                       `json:"rejected" dc:"Number of failed recipients"`
+		Results  []*ApiMailRecipientResult `json:"results" dc:"Status of each recipient, in the request order"`
+	} `json:"data"`
+}
@@ -41,12 +41,25 @@ type EmailTask struct {
 	BouncedCount            int       `json:"bouncedCount"     description:""`
 	DeferredCount           int       `json:"deferredCount"    description:""`
 	StatsUpdateTime         int       `json:"statsUpdateTime"  description:""`
+	CappedCount             int       `json:"capped_count"    dc:"recipients skipped by the frequency cap"`
+	Category                string    `json:"category"        dc:"mail category, empty when uncategorized"`
+	OptedOutCount           int       `json:"opted_out_count" dc:"recipients skipped, opted out of the category"`
+	SendingDomain           string    `json:"sending_domain"  dc:"From domain of the messages, empty: the domain of the addresser"`
+	SendingIp               string    `json:"sending_ip"      dc:"outbound IP, empty: the route of the domain"`
 	GroupId                 int       `json:"group_id"        dc:"Group ID"`
 	GroupName               string    `json:"group_name"      dc:"Group Name"`
 	Tags                    []TagInfo `json:"tags"           dc:"Task Tags"`
 	UseTagFilter            int       `json:"use_tag_filter" dc:"Whether to use tag filter (1: yes, 0: no)"`
 	TagLogic                string    `json:"tag_logic" dc:"Tag logic (AND: must have all tags, OR: have any tag, NOT)"`
 	TagIdsRaw               string    `json:"-"              dc:"Tag IDs raw data for internal processing"`
+
+	SendingIdentity SendingIdentity `json:"sending_identity" dc:"effective identity the messages are sent with"`
+}
+
+type SendingIdentity struct {
+	From   string `json:"from"   dc:"From address of the messages"`
+	Domain string `json:"domain" dc:"domain signing the messages"`
+	Ip     string `json:"ip"     dc:"outbound IP the messages are sent from, empty when unknown"`
 }
 
 type GroupInfo struct {
@@ -155,6 +168,14 @@ type CreateTaskReq struct {
 
 	TagIds   []int  `json:"tag_ids" dc:"tag ids for filtering contacts"`
 	TagLogic string `json:"tag_logic" v:"in:AND,OR,NOT" dc:"tag logic (AND: must have all tags, OR: have any tag, NOT)" default:"AND"`
+
+	SendLocalHour   int    `json:"send_local_hour" v:"min:-1|max:23" dc:"deliver at this hour in the local time of each recipient, -1: send right away" default:"-1"`
+	DefaultTimezone string `json:"default_timezone" dc:"IANA time zone of the recipients without one, the server's when empty"`
+
+	Category string `json:"category" dc:"mail category, the recipients opted out of it are skipped. Empty: uncategorized"`
+
+	SendingDomain string `json:"sending_domain" dc:"From domain of the messages, signed by its DKIM key. Empty: the domain of the addresser"`
+	SendingIp     string `json:"sending_ip" dc:"outbound IP of the multi-IP pool of the sending domain. Empty: the route of the domain"`
 }
 
 type CreateTaskRes struct {
@@ -245,6 +266,46 @@ type SendTestEmailRes struct {
 	api_v1.StandardRes
 }
 
+type SendCampaignPreviewReq struct {
+	g.Meta        `path:"/batch_mail/task/send_preview" method:"post" tags:"BatchMail" summary:"Send a rendered preview of a task to one address"`
+	Authorization string            `json:"authorization" dc:"Authorization" in:"header"`
+	TaskId        int               `json:"task_id" v:"required" dc:"Task ID"`
+	Recipient     string            `json:"recipient" v:"required|email" dc:"Recipient"`
+	SampleData    map[string]string `json:"sample_data" dc:"Subscriber fields used to render the preview, the recipient's contact or synthetic data when empty"`
+}
+
+type SendCampaignPreviewRes struct {
+	api_v1.StandardRes
+	Data struct {
+		MessageId string `json:"message_id" dc:"Message-ID of the preview"`
+	} `json:"data"`
+}
+
+type SetTaskABTestReq struct {
+	g.Meta        `path:"/batch_mail/task/set_ab_test" method:"post" tags:"BatchMail" summary:"Set the A/B subject test of a task"`
+	Authorization string   `json:"authorization" dc:"Authorization" in:"header"`
+	TaskId        int      `json:"task_id" v:"required" dc:"Task ID"`
+	Subjects      []string `json:"subjects" dc:"Variant subjects, at least two, empty removes the A/B test"`
+	TestFraction  int      `json:"test_fraction" d:"20" v:"between:1,99" dc:"Percentage of the recipients in the test cohort"`
+	Metric        string   `json:"metric" d:"open" v:"in:open,click" dc:"Winning metric (open, click)"`
+	WaitSeconds   int      `json:"wait_seconds" d:"14400" v:"min:0" dc:"Wait after the test cohort is sent before choosing the winner"`
+}
+
+type SetTaskABTestRes struct {
+	api_v1.StandardRes
+}
+
+type TaskLiveStreamReq struct {
+	g.Meta        `path:"/batch_mail/task/live_stream" method:"get" tags:"BatchMail" summary:"Stream the live send progress of a task as Server-Sent Events"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	TaskId        int    `json:"task_id" v:"required" dc:"Task ID"`
+	LastEventId   int64  `json:"last_event_id" dc:"Resume after this event, the Last-Event-ID header is used when unset"`
+}
+
+type TaskLiveStreamRes struct {
+	api_v1.StandardRes
+}
+
 type TaskStatChartReq struct {
 	g.Meta        `path:"/batch_mail/task/stat_chart" method:"get" tags:"BatchMail" summary:"get task stat chart"`
 	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
@@ -263,9 +324,157 @@ type TaskStatChartRes struct {
 		BounceRateChart interface{} `json:"bounce_rate_chart" dc:"bounce rate chart"`
 		OpenRateChart   interface{} `json:"open_rate_chart" dc:"open rate chart"`
 		ClickRateChart  interface{} `json:"click_rate_chart" dc:"click rate chart"`
+		AbTest          interface{} `json:"ab_test" dc:"A/B subject test with the variant results and the winner, null without one"`
+	} `json:"data"`
+}
+
+type ExportTaskResultsReq struct {
+	g.Meta        `path:"/batch_mail/task/export_results" method:"get" tags:"BatchMail" summary:"Export the per-recipient results of a task"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	TaskId        int    `json:"task_id" v:"required" dc:"Task ID"`
+	Format        string `json:"format" d:"csv" v:"in:csv,json,csv.gz,json.gz" dc:"Export format (csv, json, csv.gz, json.gz)"`
+}
+
+type ExportTaskResultsRes struct {
+	api_v1.StandardRes
+}
+
+type TaskLinkStatsReq struct {
+	g.Meta        `path:"/batch_mail/task/link_stats" method:"get" tags:"BatchMail" summary:"Get the clicks of each link of a task"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	TaskId        int    `json:"task_id" v:"required" dc:"Task ID"`
+	Format        string `json:"format" d:"json" v:"in:json,csv" dc:"Response format, csv downloads the heatmap data"`
+}
+
+type TaskLinkStatsRes struct {
+	api_v1.StandardRes
+}
+
+type FrequencyCap struct {
+	MaxSends   int `json:"max_sends" dc:"Maximum campaign emails per subscriber in the window, 0: no cap"`
+	WindowDays int `json:"window_days" dc:"Window in days"`
+}
+
+type GetFrequencyCapReq struct {
+	g.Meta        `path:"/batch_mail/frequency_cap" method:"get" tags:"BatchMail" summary:"Get the per-subscriber frequency caps of the campaigns"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetFrequencyCapRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Default FrequencyCap         `json:"default" dc:"Cap of every list without its own"`
+		Lists   map[int]FrequencyCap `json:"lists" dc:"Caps of the lists, by group ID"`
+	} `json:"data"`
+}
+
+type SetFrequencyCapReq struct {
+	g.Meta        `path:"/batch_mail/frequency_cap/set" method:"post" tags:"BatchMail" summary:"Set the per-subscriber frequency cap, globally or of a list"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	GroupId       int    `json:"group_id" v:"min:0" dc:"Group ID of the list, 0 for the global cap"`
+	MaxSends      int    `json:"max_sends" v:"min:0" dc:"Maximum campaign emails per subscriber in the window, 0: no cap"`
+	WindowDays    int    `json:"window_days" v:"min:0" dc:"Window in days"`
+	Inherit       bool   `json:"inherit" dc:"Remove the cap of the list, it uses the global one again"`
+}
+
+type SetFrequencyCapRes struct {
+	api_v1.StandardRes
+}
+
+type CircuitBreaker struct {
+	Enabled          bool    `json:"enabled" dc:"Pause the campaigns whose rates are past the thresholds"`
+	MinSent          int     `json:"min_sent" dc:"Messages sent in the window before the rates count"`
+	WindowMinutes    int     `json:"window_minutes" dc:"Rolling window of the rates in minutes"`
+	MaxBounceRate    float64 `json:"max_bounce_rate" dc:"Maximum bounce rate in percent, 0: not checked"`
+	MaxComplaintRate float64 `json:"max_complaint_rate" dc:"Maximum complaint rate in percent, 0: not checked"`
+}
+
+type GetCircuitBreakerReq struct {
+	g.Meta        `path:"/batch_mail/circuit_breaker" method:"get" tags:"BatchMail" summary:"Get the bounce and complaint rate thresholds pausing the campaigns"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetCircuitBreakerRes struct {
+	api_v1.StandardRes
+	Data CircuitBreaker `json:"data"`
+}
+
+type SetCircuitBreakerReq struct {
+	g.Meta           `path:"/batch_mail/circuit_breaker/set" method:"post" tags:"BatchMail" summary:"Set the bounce and complaint rate thresholds pausing the campaigns"`
+	Authorization    string  `json:"authorization" dc:"Authorization" in:"header"`
+	Enabled          bool    `json:"enabled" dc:"Pause the campaigns whose rates are past the thresholds"`
+	MinSent          int     `json:"min_sent" v:"required|min:1" dc:"Messages sent in the window before the rates count"`
+	WindowMinutes    int     `json:"window_minutes" v:"required|min:1" dc:"Rolling window of the rates in minutes"`
+	MaxBounceRate    float64 `json:"max_bounce_rate" v:"min:0|max:100" dc:"Maximum bounce rate in percent, 0: not checked"`
+	MaxComplaintRate float64 `json:"max_complaint_rate" v:"min:0|max:100" dc:"Maximum complaint rate in percent, 0: not checked"`
+}
+
+type SetCircuitBreakerRes struct {
+	api_v1.StandardRes
+}
+
+type GetSendGuaranteeReq struct {
+	g.Meta        `path:"/batch_mail/send_guarantee" method:"get" tags:"BatchMail" summary:"Get the delivery of the recipients whose send a crash interrupted"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetSendGuaranteeRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Guarantee string `json:"guarantee" dc:"at_most_once: marked sent, never sent twice; at_least_once: sent again, never missed"`
 	} `json:"data"`
 }
 
+type SetSendGuaranteeReq struct {
+	g.Meta        `path:"/batch_mail/send_guarantee/set" method:"post" tags:"BatchMail" summary:"Set the delivery of the recipients whose send a crash interrupted"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Guarantee     string `json:"guarantee" v:"required|in:at_most_once,at_least_once" dc:"at_most_once: marked sent, never sent twice; at_least_once: sent again, never missed"`
+}
+
+type SetSendGuaranteeRes struct {
+	api_v1.StandardRes
+}
+
+type VERP struct {
+	Enabled       bool   `json:"enabled" dc:"Send the campaigns with a return path encoding the campaign and the recipient"`
+	Domain        string `json:"domain" dc:"Return-path domain, a local domain whose SPF record authorizes this server"`
+	Prefix        string `json:"prefix" dc:"Local part of the bounce address, bounces when empty"`
+	BounceAddress string `json:"bounce_address" dc:"Address the bounces are returned to, a mailbox of the domain"`
+}
+
+type GetVERPReq struct {
+	g.Meta        `path:"/batch_mail/verp" method:"get" tags:"BatchMail" summary:"Get the VERP return path of the campaigns"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetVERPRes struct {
+	api_v1.StandardRes
+	Data VERP `json:"data"`
+}
+
+type SetVERPReq struct {
+	g.Meta        `path:"/batch_mail/verp/set" method:"post" tags:"BatchMail" summary:"Set the VERP return path of the campaigns"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Enabled       bool   `json:"enabled" dc:"Send the campaigns with a return path encoding the campaign and the recipient"`
+	Domain        string `json:"domain" dc:"Return-path domain, a local domain whose SPF record authorizes this server"`
+	Prefix        string `json:"prefix" dc:"Local part of the bounce address, bounces when empty"`
+}
+
+type SetVERPRes struct {
+	api_v1.StandardRes
+}
+
+type SendAdviceReq struct {
+	g.Meta        `path:"/batch_mail/send_advice" method:"get" tags:"BatchMail" summary:"Get the reputation risk and the recommended ramp of a planned send"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	GroupId       int    `json:"group_id" v:"required|min:1" dc:"Group ID of the list"`
+	PlannedVolume int    `json:"planned_volume" v:"min:0" dc:"Messages to send, 0: the mailable contacts of the list"`
+}
+
+type SendAdviceRes struct {
+	api_v1.StandardRes
+}
+
 type UpdateTaskInfoReq struct {
 	g.Meta        `path:"/batch_mail/task/update" method:"post" tags:"BatchMail" summary:"update task info"`
 	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
@@ -281,6 +490,11 @@ type UpdateTaskInfoReq struct {
 	StartTime     int    `json:"start_time" dc:"start time"`
 	TagIds        []int  `json:"tag_ids" dc:"tag ids for filtering contacts"`
 	TagLogic      string `json:"tag_logic" v:"in:AND,OR,NOT" dc:"tag logic (AND: must have all tags, OR: have any tag, NOT)"`
+	Category      string `json:"category" dc:"mail category"`
+	Uncategorized bool   `json:"uncategorized" dc:"Remove the category of the task"`
+	SendingDomain string `json:"sending_domain" dc:"From domain of the messages, signed by its DKIM key"`
+	SendingIp     string `json:"sending_ip" dc:"outbound IP of the multi-IP pool of the sending domain"`
+	DefaultRoute  bool   `json:"default_route" dc:"Send as the addresser through the route of its domain again"`
 }
 type UpdateTaskInfoRes struct {
 	api_v1.StandardRes
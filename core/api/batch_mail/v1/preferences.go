@@ -0,0 +1,62 @@
+package v1
+
+import (
+	"billionmail-core/utility/types/api_v1"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+type MailCategory struct {
+	Key         string `json:"key" v:"required" dc:"Category key, lowercase letters, digits, - and _"`
+	Name        string `json:"name" dc:"Name shown in the preference center"`
+	Description string `json:"description" dc:"Description shown in the preference center"`
+}
+
+type CategoryPreference struct {
+	MailCategory
+	Subscribed bool `json:"subscribed" dc:"Whether the subscriber receives the campaigns of the category"`
+}
+
+type GetMailCategoriesReq struct {
+	g.Meta        `path:"/batch_mail/categories" method:"get" tags:"BatchMail" summary:"Get the mail categories subscribers opt in or out of"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetMailCategoriesRes struct {
+	api_v1.StandardRes
+	Data []MailCategory `json:"data"`
+}
+
+type SetMailCategoriesReq struct {
+	g.Meta        `path:"/batch_mail/categories/set" method:"post" tags:"BatchMail" summary:"Set the mail categories subscribers opt in or out of"`
+	Authorization string         `json:"authorization" dc:"Authorization" in:"header"`
+	Categories    []MailCategory `json:"categories" dc:"Categories in the order of the preference center"`
+}
+
+type SetMailCategoriesRes struct {
+	api_v1.StandardRes
+}
+
+type GetPreferencesReq struct {
+	g.Meta        `path:"/preferences" method:"post" tags:"Unsubscribe" summary:"Get the category preferences of a subscriber for the preference center"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Jwt           string `json:"jwt" v:"required" dc:"JWT token of the unsubscribe link"`
+}
+
+type GetPreferencesRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Email       string               `json:"email" dc:"Email address"`
+		Preferences []CategoryPreference `json:"preferences" dc:"Preference of every category"`
+	} `json:"data"`
+}
+
+type SetPreferencesReq struct {
+	g.Meta        `path:"/preferences/update" method:"post" tags:"Unsubscribe" summary:"Update the category preferences of a subscriber from the preference center"`
+	Authorization string          `json:"authorization" dc:"Authorization" in:"header"`
+	Jwt           string          `json:"jwt" v:"required" dc:"JWT token of the unsubscribe link"`
+	Preferences   map[string]bool `json:"preferences" v:"required" dc:"Whether the subscriber receives each category, by category key"`
+}
+
+type SetPreferencesRes struct {
+	api_v1.StandardRes
+}
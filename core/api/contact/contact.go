@@ -32,4 +32,6 @@ type IContactV1 interface {
 	EditContactsNDP(ctx context.Context, req *v1.EditContactsNDPReq) (res *v1.EditContactsNDPRes, err error)
 	DeleteContactsNDP(ctx context.Context, req *v1.DeleteContactsNDPReq) (res *v1.DeleteContactsNDPRes, err error)
 	BatchTagContacts(ctx context.Context, req *v1.BatchTagContactsReq) (res *v1.BatchTagContactsRes, err error)
+	GetGroupListHeaders(ctx context.Context, req *v1.GetGroupListHeadersReq) (res *v1.GetGroupListHeadersRes, err error)
+	SetGroupListHeaders(ctx context.Context, req *v1.SetGroupListHeadersReq) (res *v1.SetGroupListHeadersRes, err error)
 }
@@ -70,6 +70,7 @@ type Contact struct {
 	Attribs      map[string]string `json:"attribs"`
 	LastActiveAt int               `json:"last_active_at" dc:"Last Active At"`
 	Tags         []TagInfo         `json:"tags"        dc:"Contact Tags"`
+	Timezone     string            `json:"timezone"    dc:"IANA Time Zone, empty when unknown"`
 }
 
 type CreateGroupReq struct {
@@ -351,6 +352,7 @@ type EditContactsReq struct {
 	Active        int    `json:"active"`
 	GroupIds      []int  `json:"group_ids"`
 	Attribs       string `json:"attribs"`
+	Timezone      string `json:"timezone" dc:"IANA time zone of the contact, e.g. Europe/Berlin, unchanged when empty"`
 }
 
 type EditContactsRes struct {
@@ -387,6 +389,7 @@ type EditContactsNDPReq struct {
 	Active        int    `json:"active"`
 	Status        int    `json:"status"`
 	Attribs       string `json:"attribs"`
+	Timezone      string `json:"timezone" dc:"IANA time zone of the contact, e.g. Europe/Berlin, unchanged when empty"`
 }
 
 type EditContactsNDPRes struct {
@@ -413,3 +416,32 @@ type BatchTagContactsReq struct {
 type BatchTagContactsRes struct {
 	api_v1.StandardRes
 }
+
+type ListHeaderConfig struct {
+	Enabled       bool   `json:"enabled" dc:"Add the mailing-list headers to campaigns sent to the group"`
+	ListId        string `json:"list_id" dc:"List-ID label, derived from the group name and sender domain when empty"`
+	Precedence    string `json:"precedence" dc:"Precedence header (bulk, list), empty to omit"`
+	AutoSubmitted string `json:"auto_submitted" dc:"Auto-Submitted header (auto-generated), empty to omit"`
+}
+
+type GetGroupListHeadersReq struct {
+	g.Meta        `path:"/contact/group/get_list_headers" method:"get" tags:"Contact" summary:"Get the mailing-list headers settings of a group"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	GroupId       int    `json:"group_id" v:"required" dc:"Group ID"`
+}
+
+type GetGroupListHeadersRes struct {
+	api_v1.StandardRes
+	Data ListHeaderConfig `json:"data" dc:"List headers settings"`
+}
+
+type SetGroupListHeadersReq struct {
+	g.Meta        `path:"/contact/group/set_list_headers" method:"post" tags:"Contact" summary:"Set the mailing-list headers settings of a group"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	GroupId       int    `json:"group_id" v:"required" dc:"Group ID"`
+	ListHeaderConfig
+}
+
+type SetGroupListHeadersRes struct {
+	api_v1.StandardRes
+}
@@ -52,6 +52,7 @@ type Domain struct {
 	HasBrandInfo   int            `json:"hasbrandinfo"        dc:"Brand information : 1-exist, 0-not exist"`
 	MultiIPDomains *MultiIPDomain `json:"multi_ip_domains" dc:"Multiple IP domains"`
 	CurrentUsage   int64          `json:"current_usage" dc:"Domain Current usage"`
+	EncryptAtRest  int            `json:"encrypt_at_rest" dc:"Delivered messages encrypted at rest: 1-yes, 0-no"`
 
 	BlackCheckResult *BlacklistCheckResult `json:"black_check_result" dc:"Last blacklist check result"`
 	BlackCheckLog    string                `json:"black_check_log" dc:"Path to blacklist check log file"`
@@ -143,6 +144,7 @@ type MultiIPDomain struct {
 	PostfixIP      string `json:"postfix_ip"`
 	Aliases        string `json:"aliases"`
 	SMTPServerName string `json:"smtp_server_name"`
+	HeloName       string `json:"helo_name" orm:"-"`
 	Active         int    `json:"active"`
 	CreateTime     int    `json:"create_time"`
 	UpdateTime     int    `json:"update_time"`
@@ -186,6 +188,36 @@ type FreshDNSRecordsRes struct {
 	Data DNSRecords `json:"data" dc:"DNS records"`
 }
 
+type GetDNSCacheStatsReq struct {
+	g.Meta        `path:"/domains/dns_cache_stats" tags:"Domain" method:"get" sm:"Get DNS cache metrics" in:"query"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type DNSCacheStats struct {
+	Hits         int64 `json:"hits"          dc:"Lookups answered from the cache"`
+	NegativeHits int64 `json:"negative_hits" dc:"Cached missing records among the hits"`
+	Misses       int64 `json:"misses"        dc:"Lookups sent to the upstream resolvers"`
+	Shared       int64 `json:"shared"        dc:"Lookups waiting for the same query in flight"`
+	Failures     int64 `json:"failures"      dc:"Failed upstream queries"`
+	Entries      int   `json:"entries"       dc:"Records currently cached"`
+}
+
+type GetDNSCacheStatsRes struct {
+	api_v1.StandardRes
+	Data DNSCacheStats `json:"data" dc:"DNS cache metrics"`
+}
+
+type SetDomainEncryptionReq struct {
+	g.Meta        `path:"/domains/set_encryption" tags:"Domain" method:"post" sm:"Enable or disable the encryption at rest of the delivered messages" in:"body"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Domain        string `json:"domain" v:"required|domain" dc:"Domain"`
+	Enabled       int    `json:"enabled" v:"in:0,1" dc:"Encryption at rest 1: Enabled 0: Disabled"`
+}
+
+type SetDomainEncryptionRes struct {
+	api_v1.StandardRes
+}
+
 type SetSSLReq struct {
 	g.Meta        `path:"/domains/set_ssl" tags:"Domain" method:"post" sm:"Set SSL" in:"body"`
 	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
@@ -35,3 +35,19 @@ type TestMultiIPDomainConfigReq struct {
 type TestMultiIPDomainConfigRes struct {
 	api_v1.StandardRes
 }
+
+// SetMultiIPHeloNameReq Request to set the HELO name of an outbound IP
+type SetMultiIPHeloNameReq struct {
+	g.Meta        `path:"/multi_ip_domain/helo_name/set" method:"post" summary:"Set the HELO name an outbound IP greets the receivers with"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	OutboundIP    string `json:"outbound_ip" v:"required|ipv4" dc:"Outbound IP of the pool"`
+	HeloName      string `json:"helo_name" dc:"HELO name, the reverse DNS of the IP; empty: the host name of the server"`
+}
+
+// SetMultiIPHeloNameRes Response for setting the HELO name of an outbound IP
+type SetMultiIPHeloNameRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Warnings []string `json:"warnings" dc:"HELO names not matching the DNS of their IP"`
+	} `json:"data"`
+}
@@ -19,4 +19,7 @@ type IEmailTemplateV1 interface {
 	GetTemplate(ctx context.Context, req *v1.GetTemplateReq) (res *v1.GetTemplateRes, err error)
 	GetAllTemplates(ctx context.Context, req *v1.GetAllTemplatesReq) (res *v1.GetAllTemplatesRes, err error)
 	CheckEmailContent(ctx context.Context, req *v1.CheckEmailContentReq) (res *v1.CheckEmailContentRes, err error)
+	ListTemplateVersions(ctx context.Context, req *v1.ListTemplateVersionsReq) (res *v1.ListTemplateVersionsRes, err error)
+	GetTemplateVersion(ctx context.Context, req *v1.GetTemplateVersionReq) (res *v1.GetTemplateVersionRes, err error)
+	RevertTemplate(ctx context.Context, req *v1.RevertTemplateReq) (res *v1.RevertTemplateRes, err error)
 }
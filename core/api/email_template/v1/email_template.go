@@ -17,6 +17,18 @@ type EmailTemplate struct {
 	Chat_id    string `json:"chat_id"     description:"Exclusive AI Email"   orm:"chat_id"`
 }
 
+// TemplateVersion defines a saved version of a template
+type TemplateVersion struct {
+	Id         int    `json:"id"           description:"Version ID"      orm:"id"`
+	TemplateId int    `json:"template_id"  description:"Template ID"     orm:"template_id"`
+	Version    int    `json:"version"      description:"Version Number"  orm:"version"`
+	TempName   string `json:"temp_name"    description:"Template Name"   orm:"temp_name"`
+	Content    string `json:"html_content" description:"Email Content"   orm:"content"`
+	Render     string `json:"drag_data"    description:"Render Data"     orm:"render"`
+	CreateTime int    `json:"create_time"  description:"Save Time"       orm:"create_time"`
+	Current    bool   `json:"current"      description:"Current Version"`
+}
+
 // CreateTemplateReq Create template request
 type CreateTemplateReq struct {
 	g.Meta        `path:"/email_template/create" method:"post" tags:"EmailTemplate" summary:"Create email template"`
@@ -128,3 +140,43 @@ type CheckEmailContentRes struct {
 		Items []ScoreItem `json:"items"`
 	} `json:"data"`
 }
+
+// ListTemplateVersionsReq List the versions of a template request
+type ListTemplateVersionsReq struct {
+	g.Meta        `path:"/email_template/versions" method:"get" tags:"EmailTemplate" summary:"List the versions of an email template"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Id            int    `json:"id" v:"required" dc:"Template ID"`
+}
+
+type ListTemplateVersionsRes struct {
+	api_v1.StandardRes
+	Data []*TemplateVersion `json:"data" dc:"Versions, newest first, without their content"`
+}
+
+// GetTemplateVersionReq Get a version of a template request
+type GetTemplateVersionReq struct {
+	g.Meta        `path:"/email_template/version" method:"get" tags:"EmailTemplate" summary:"Get a version of an email template"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Id            int    `json:"id" v:"required" dc:"Template ID"`
+	Version       int    `json:"version" v:"required|min:1" dc:"Version Number"`
+}
+
+type GetTemplateVersionRes struct {
+	api_v1.StandardRes
+	Data *TemplateVersion `json:"data" dc:"Version Data"`
+}
+
+// RevertTemplateReq Revert a template to a version request
+type RevertTemplateReq struct {
+	g.Meta        `path:"/email_template/revert" method:"post" tags:"EmailTemplate" summary:"Make an old version of an email template current"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Id            int    `json:"id" v:"required" dc:"Template ID"`
+	Version       int    `json:"version" v:"required|min:1" dc:"Version Number"`
+}
+
+type RevertTemplateRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Version int `json:"version" dc:"Number of the new current version"`
+	} `json:"data"`
+}
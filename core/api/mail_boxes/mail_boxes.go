@@ -20,4 +20,14 @@ type IMailBoxesV1 interface {
 	GetAllEmail(ctx context.Context, req *v1.GetAllEmailReq) (res *v1.GetAllEmailRes, err error)
 	ExportMailbox(ctx context.Context, req *v1.ExportMailboxReq) (res *v1.ExportMailboxRes, err error)
 	ImportMailbox(ctx context.Context, req *v1.ImportMailboxReq) (res *v1.ImportMailboxRes, err error)
+	GetStaleMailboxes(ctx context.Context, req *v1.GetStaleMailboxesReq) (res *v1.GetStaleMailboxesRes, err error)
+	SetMailboxConnLimit(ctx context.Context, req *v1.SetMailboxConnLimitReq) (res *v1.SetMailboxConnLimitRes, err error)
+	GetConnLimits(ctx context.Context, req *v1.GetConnLimitsReq) (res *v1.GetConnLimitsRes, err error)
+	SetConnLimits(ctx context.Context, req *v1.SetConnLimitsReq) (res *v1.SetConnLimitsRes, err error)
+	GetMailboxSessions(ctx context.Context, req *v1.GetMailboxSessionsReq) (res *v1.GetMailboxSessionsRes, err error)
+	SetMailboxSending(ctx context.Context, req *v1.SetMailboxSendingReq) (res *v1.SetMailboxSendingRes, err error)
+	GetDeletedMailboxes(ctx context.Context, req *v1.GetDeletedMailboxesReq) (res *v1.GetDeletedMailboxesRes, err error)
+	RestoreMailbox(ctx context.Context, req *v1.RestoreMailboxReq) (res *v1.RestoreMailboxRes, err error)
+	GetFolderACLs(ctx context.Context, req *v1.GetFolderACLsReq) (res *v1.GetFolderACLsRes, err error)
+	SetFolderACL(ctx context.Context, req *v1.SetFolderACLReq) (res *v1.SetFolderACLRes, err error)
 }
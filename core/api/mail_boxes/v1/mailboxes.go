@@ -7,20 +7,25 @@ import (
 
 // Mailbox defines the mailbox entity
 type Mailbox struct {
-	Username       string `json:"username"        dc:"Email address"`
-	Password       string `json:"password"        dc:"Password"`
-	PasswordEncode string `json:"password_encode" dc:"Encoded password"`
-	FullName       string `json:"full_name"       dc:"Full name"`
-	IsAdmin        int    `json:"is_admin"        dc:"Is administrator: 1-yes, 0-no"`
-	Maildir        string `json:"maildir"         dc:"Mailbox directory"`
-	Quota          int64  `json:"quota"           dc:"Mailbox quota"`
-	LocalPart      string `json:"local_part"      dc:"Local part (username)"`
-	Domain         string `json:"domain"          dc:"Domain name"`
-	CreateTime     int64  `json:"create_time"     dc:"Creation time"`
-	UpdateTime     int64  `json:"update_time"     dc:"Update time"`
-	Active         int    `json:"active"          dc:"Status: 1-enabled, 0-disabled"`
-	UsedQuota      int64  `json:"used_quota"           dc:"Used Mailbox quota"`
-	QuotaActive    int    `json:"quota_active"    dc:"Quota switch 1: On 0: Off"`
+	Username          string `json:"username"        dc:"Email address"`
+	Password          string `json:"password"        dc:"Password"`
+	PasswordEncode    string `json:"password_encode" dc:"Encoded password"`
+	FullName          string `json:"full_name"       dc:"Full name"`
+	IsAdmin           int    `json:"is_admin"        dc:"Is administrator: 1-yes, 0-no"`
+	Maildir           string `json:"maildir"         dc:"Mailbox directory"`
+	Quota             int64  `json:"quota"           dc:"Mailbox quota"`
+	LocalPart         string `json:"local_part"      dc:"Local part (username)"`
+	Domain            string `json:"domain"          dc:"Domain name"`
+	CreateTime        int64  `json:"create_time"     dc:"Creation time"`
+	UpdateTime        int64  `json:"update_time"     dc:"Update time"`
+	Active            int    `json:"active"          dc:"Status: 1-enabled, 0-disabled"`
+	UsedQuota         int64  `json:"used_quota"           dc:"Used Mailbox quota"`
+	QuotaActive       int    `json:"quota_active"    dc:"Quota switch 1: On 0: Off"`
+	LastLoginTime     int64  `json:"last_login_time" dc:"Last successful login time, 0 when never logged in"`
+	LastLoginProtocol string `json:"last_login_protocol" dc:"Protocol of the last login: imap, pop3 or smtp"`
+	SendingDisabled   int    `json:"sending_disabled" dc:"Sending switch 1: Disabled 0: Enabled"`
+	DeletedTime       int64  `json:"deleted_time" dc:"Soft deletion time, 0 when not deleted"`
+	MaxConnections    int    `json:"max_connections" dc:"Maximum concurrent IMAP and POP3 sessions, 0 for the default limit"`
 }
 
 type AddMailboxReq struct {
@@ -44,9 +49,9 @@ type BatchAddMailboxReq struct {
 	g.Meta        `path:"/mailbox/batch_create" tags:"MailBox" method:"post" summary:"Batch create mailbox" in:"body"`
 	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
 	Domain        string `json:"domain" v:"required|domain" dc:"Domain"`
-	Quota  int    `json:"quota"   dc:"Quota" d:"5242880"`
-	Count  int    `json:"count" v:"required|min:2" dc:"Count" d:"10"`
-	Prefix string `json:"prefix" v:"regex:[\\w-]{0,}" dc:"Email name prefix, optional" d:"user"`
+	Quota         int    `json:"quota"   dc:"Quota" d:"5242880"`
+	Count         int    `json:"count" v:"required|min:2" dc:"Count" d:"10"`
+	Prefix        string `json:"prefix" v:"regex:[\\w-]{0,}" dc:"Email name prefix, optional" d:"user"`
 	QuotaActive   int    `json:"quota_active" v:"in:0,1" dc:"Quota switch 1: On 0: Off" d:"1"`
 }
 
@@ -146,3 +151,133 @@ type ImportMailboxReq struct {
 type ImportMailboxRes struct {
 	api_v1.StandardRes
 }
+
+type GetStaleMailboxesReq struct {
+	g.Meta        `path:"/mailbox/stale" tags:"MailBox" method:"get" summary:"Get mailboxes without login for a number of days" in:"query"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Domain        string `json:"domain" v:"domain" dc:"Domain"`
+	InactiveDays  int    `json:"inactive_days" v:"min:1" dc:"Days without login" d:"90"`
+}
+
+type GetStaleMailboxesRes struct {
+	api_v1.StandardRes
+	Data []Mailbox `json:"data"`
+}
+
+type SetMailboxSendingReq struct {
+	g.Meta        `path:"/mailbox/set_sending" tags:"MailBox" method:"post" summary:"Enable or disable sending of a mailbox" in:"body"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Username      string `json:"username" v:"required|email" dc:"Email address"`
+	Enabled       int    `json:"enabled" v:"in:0,1" dc:"Sending switch 1: Enabled 0: Disabled"`
+}
+
+type SetMailboxSendingRes struct {
+	api_v1.StandardRes
+}
+
+type SetMailboxConnLimitReq struct {
+	g.Meta         `path:"/mailbox/set_connection_limit" tags:"MailBox" method:"post" summary:"Set the maximum concurrent IMAP and POP3 sessions of a mailbox" in:"body"`
+	Authorization  string `json:"authorization" dc:"Authorization" in:"header"`
+	Username       string `json:"username" v:"required|email" dc:"Email address"`
+	MaxConnections int    `json:"max_connections" v:"min:0" dc:"Maximum concurrent sessions, 0 for the default limit"`
+}
+
+type SetMailboxConnLimitRes struct {
+	api_v1.StandardRes
+}
+
+type GetConnLimitsReq struct {
+	g.Meta        `path:"/mailbox/connection_limits" tags:"MailBox" method:"get" summary:"Get the default limits of the concurrent IMAP and POP3 sessions"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetConnLimitsRes struct {
+	api_v1.StandardRes
+	Data struct {
+		MaxPerAccount int `json:"max_per_account" dc:"Maximum sessions of a mailbox, 0 for unlimited"`
+		MaxPerIP      int `json:"max_per_ip" dc:"Maximum sessions of a mailbox from one address, 0 for unlimited"`
+	} `json:"data"`
+}
+
+type SetConnLimitsReq struct {
+	g.Meta        `path:"/mailbox/connection_limits/set" tags:"MailBox" method:"post" summary:"Set the default limits of the concurrent IMAP and POP3 sessions" in:"body"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	MaxPerAccount int    `json:"max_per_account" v:"min:0" dc:"Maximum sessions of a mailbox, 0 for unlimited"`
+	MaxPerIP      int    `json:"max_per_ip" v:"min:0" dc:"Maximum sessions of a mailbox from one address, 0 for unlimited"`
+}
+
+type SetConnLimitsRes struct {
+	api_v1.StandardRes
+}
+
+type GetMailboxSessionsReq struct {
+	g.Meta        `path:"/mailbox/sessions" tags:"MailBox" method:"get" summary:"Get the open IMAP and POP3 sessions by mailbox"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+// MailboxSessions open IMAP and POP3 sessions of a mailbox
+type MailboxSessions struct {
+	Username   string         `json:"username" dc:"Email address"`
+	Sessions   int            `json:"sessions" dc:"Open sessions"`
+	ByProtocol map[string]int `json:"by_protocol" dc:"Open sessions by protocol, imap or pop3"`
+	IPs        []string       `json:"ips" dc:"Client addresses"`
+	Limit      int            `json:"limit" dc:"Maximum sessions of the mailbox, 0 for unlimited"`
+}
+
+type GetMailboxSessionsRes struct {
+	api_v1.StandardRes
+	Data []MailboxSessions `json:"data"`
+}
+
+type GetDeletedMailboxesReq struct {
+	g.Meta        `path:"/mailbox/deleted" tags:"MailBox" method:"get" summary:"Get the deleted mailboxes still restorable" in:"query"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Domain        string `json:"domain" v:"domain" dc:"Domain"`
+}
+
+type GetDeletedMailboxesRes struct {
+	api_v1.StandardRes
+	Data []Mailbox `json:"data"`
+}
+
+type RestoreMailboxReq struct {
+	g.Meta        `path:"/mailbox/restore" tags:"MailBox" method:"post" summary:"Restore a deleted mailbox within its grace period" in:"body"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Username      string `json:"username" v:"required|email" dc:"Email address"`
+}
+
+type RestoreMailboxRes struct {
+	api_v1.StandardRes
+}
+
+type FolderACL struct {
+	Owner      string `json:"owner" dc:"Mailbox owning the folder"`
+	Folder     string `json:"folder" dc:"Folder, / separated"`
+	Grantee    string `json:"grantee" dc:"Mailbox the rights are granted to"`
+	Rights     string `json:"rights" dc:"RFC 4314 rights letters"`
+	UpdateTime int64  `json:"update_time" dc:"Update time"`
+}
+
+type GetFolderACLsReq struct {
+	g.Meta        `path:"/mailbox/folder_acl" tags:"MailBox" method:"get" summary:"Get the rights granted on the folders of a mailbox and to it" in:"query"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Username      string `json:"username" v:"required|email" dc:"Email address"`
+}
+
+type GetFolderACLsRes struct {
+	api_v1.StandardRes
+	Data []FolderACL `json:"data"`
+}
+
+type SetFolderACLReq struct {
+	g.Meta        `path:"/mailbox/folder_acl/set" tags:"MailBox" method:"post" summary:"Grant or revoke the rights of a mailbox on a folder of a shared mailbox" in:"body"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Username      string `json:"username" v:"required|email" dc:"Email address of the shared mailbox"`
+	Folder        string `json:"folder" v:"required" dc:"Folder, e.g. INBOX or Projects/2024"`
+	Grantee       string `json:"grantee" v:"required|email" dc:"Email address of the mailbox granted the rights"`
+	Rights        string `json:"rights" dc:"RFC 4314 rights letters, e.g. lrs to read or lrswipkxte to manage the messages, empty to revoke"`
+}
+
+type SetFolderACLRes struct {
+	api_v1.StandardRes
+}
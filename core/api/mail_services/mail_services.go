@@ -21,8 +21,15 @@ type IMailServicesV1 interface {
 	AddMailForward(ctx context.Context, req *v1.AddMailForwardReq) (res *v1.AddMailForwardRes, err error)
 	EditMailForward(ctx context.Context, req *v1.EditMailForwardReq) (res *v1.EditMailForwardRes, err error)
 	DeleteMailForward(ctx context.Context, req *v1.DeleteMailForwardReq) (res *v1.DeleteMailForwardRes, err error)
+	GetMailForwardSRS(ctx context.Context, req *v1.GetMailForwardSRSReq) (res *v1.GetMailForwardSRSRes, err error)
+	SetMailForwardSRS(ctx context.Context, req *v1.SetMailForwardSRSReq) (res *v1.SetMailForwardSRSRes, err error)
+	RotateMailForwardSRSSecret(ctx context.Context, req *v1.RotateMailForwardSRSSecretReq) (res *v1.RotateMailForwardSRSSecretRes, err error)
 	GetPostfixQueueList(ctx context.Context, req *v1.GetPostfixQueueListReq) (res *v1.GetPostfixQueueListRes, err error)
 	GetPostfixQueueInfo(ctx context.Context, req *v1.GetPostfixQueueInfoReq) (res *v1.GetPostfixQueueInfoRes, err error)
+	GetPostfixQueueAttempts(ctx context.Context, req *v1.GetPostfixQueueAttemptsReq) (res *v1.GetPostfixQueueAttemptsRes, err error)
+	InspectPostfixQueueMessage(ctx context.Context, req *v1.InspectPostfixQueueMessageReq) (res *v1.InspectPostfixQueueMessageRes, err error)
+	DownloadPostfixQueuePart(ctx context.Context, req *v1.DownloadPostfixQueuePartReq) (res *v1.DownloadPostfixQueuePartRes, err error)
+	ExportTraceBundle(ctx context.Context, req *v1.ExportTraceBundleReq) (res *v1.ExportTraceBundleRes, err error)
 	DeletePostfixQueueById(ctx context.Context, req *v1.DeletePostfixQueueByIdReq) (res *v1.DeletePostfixQueueByIdRes, err error)
 	DeleteAllDeferredQueue(ctx context.Context, req *v1.DeleteAllDeferredQueueReq) (res *v1.DeleteAllDeferredQueueRes, err error)
 	FlushPostfixQueue(ctx context.Context, req *v1.FlushPostfixQueueReq) (res *v1.FlushPostfixQueueRes, err error)
@@ -30,4 +37,23 @@ type IMailServicesV1 interface {
 	SetPostfixConfig(ctx context.Context, req *v1.SetPostfixConfigReq) (res *v1.SetPostfixConfigRes, err error)
 	SetAllPostfixConfig(ctx context.Context, req *v1.SetAllPostfixConfigReq) (res *v1.SetAllPostfixConfigRes, err error)
 	GetPostfixConfig(ctx context.Context, req *v1.GetPostfixConfigReq) (res *v1.GetPostfixConfigRes, err error)
+	GetSubmissionBackpressure(ctx context.Context, req *v1.GetSubmissionBackpressureReq) (res *v1.GetSubmissionBackpressureRes, err error)
+	SetSubmissionBackpressure(ctx context.Context, req *v1.SetSubmissionBackpressureReq) (res *v1.SetSubmissionBackpressureRes, err error)
+	GetSenderRates(ctx context.Context, req *v1.GetSenderRatesReq) (res *v1.GetSenderRatesRes, err error)
+	SetSenderRate(ctx context.Context, req *v1.SetSenderRateReq) (res *v1.SetSenderRateRes, err error)
+	GetSenderRateUsage(ctx context.Context, req *v1.GetSenderRateUsageReq) (res *v1.GetSenderRateUsageRes, err error)
+	GetMailJournal(ctx context.Context, req *v1.GetMailJournalReq) (res *v1.GetMailJournalRes, err error)
+	SetMailJournal(ctx context.Context, req *v1.SetMailJournalReq) (res *v1.SetMailJournalRes, err error)
+	InspectInboundMessage(ctx context.Context, req *v1.InspectInboundMessageReq) (res *v1.InspectInboundMessageRes, err error)
+	GetInboundDMARC(ctx context.Context, req *v1.GetInboundDMARCReq) (res *v1.GetInboundDMARCRes, err error)
+	SetInboundDMARC(ctx context.Context, req *v1.SetInboundDMARCReq) (res *v1.SetInboundDMARCRes, err error)
+	SetInboundDMARCDomainMode(ctx context.Context, req *v1.SetInboundDMARCDomainModeReq) (res *v1.SetInboundDMARCDomainModeRes, err error)
+	GetInboundDisposition(ctx context.Context, req *v1.GetInboundDispositionReq) (res *v1.GetInboundDispositionRes, err error)
+	SetInboundDisposition(ctx context.Context, req *v1.SetInboundDispositionReq) (res *v1.SetInboundDispositionRes, err error)
+	GetInboundDuplicates(ctx context.Context, req *v1.GetInboundDuplicatesReq) (res *v1.GetInboundDuplicatesRes, err error)
+	SetInboundDuplicates(ctx context.Context, req *v1.SetInboundDuplicatesReq) (res *v1.SetInboundDuplicatesRes, err error)
+	SetInboundDuplicateMailbox(ctx context.Context, req *v1.SetInboundDuplicateMailboxReq) (res *v1.SetInboundDuplicateMailboxRes, err error)
+	EnableMailTrace(ctx context.Context, req *v1.EnableMailTraceReq) (res *v1.EnableMailTraceRes, err error)
+	DisableMailTrace(ctx context.Context, req *v1.DisableMailTraceReq) (res *v1.DisableMailTraceRes, err error)
+	GetMailTraceList(ctx context.Context, req *v1.GetMailTraceListReq) (res *v1.GetMailTraceListRes, err error)
 }
@@ -0,0 +1,28 @@
+package v1
+
+import (
+	"billionmail-core/utility/types/api_v1"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+type DKIMResult struct {
+	Domain    string `json:"domain" dc:"Signing domain (d=)"`
+	Selector  string `json:"selector" dc:"Key selector (s=)"`
+	Algorithm string `json:"algorithm" dc:"Signature algorithm"`
+	Result    string `json:"result" dc:"pass, fail, neutral, temperror or permerror"`
+	Reason    string `json:"reason" dc:"Reason of the result"`
+}
+
+type InspectInboundMessageReq struct {
+	g.Meta        `path:"/inbound/message/inspect" method:"get" summary:"Inspect the authentication results of an inbound message"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	MessageId     string `json:"message_id" v:"required" dc:"Message-ID of the inbound message"`
+}
+
+type InspectInboundMessageRes struct {
+	api_v1.StandardRes
+	Data struct {
+		MessageId string       `json:"message_id" dc:"Message-ID"`
+		Dkim      []DKIMResult `json:"dkim" dc:"DKIM result of every signature"`
+	} `json:"data"`
+}
@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"billionmail-core/utility/types/api_v1"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+type InboundDMARC struct {
+	Mode              string            `json:"mode" dc:"report or enforce, the mode of the recipient domains without their own"`
+	DomainModes       map[string]string `json:"domain_modes" dc:"report or enforce by recipient domain"`
+	TrustedForwarders []string          `json:"trusted_forwarders" dc:"IPs, CIDRs or host suffixes whose mail is never enforced"`
+}
+
+type GetInboundDMARCReq struct {
+	g.Meta        `path:"/inbound/dmarc/get" method:"get" summary:"Get the DMARC policy enforcement of the inbound mail"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetInboundDMARCRes struct {
+	api_v1.StandardRes
+	Data InboundDMARC `json:"data"`
+}
+
+type SetInboundDMARCReq struct {
+	g.Meta            `path:"/inbound/dmarc/set" method:"post" summary:"Set the DMARC policy enforcement of the inbound mail"`
+	Authorization     string            `json:"authorization" dc:"Authorization" in:"header"`
+	Mode              string            `json:"mode" v:"in:report,enforce" dc:"report or enforce, the mode of the recipient domains without their own, report when empty"`
+	DomainModes       map[string]string `json:"domain_modes" dc:"report or enforce by recipient domain"`
+	TrustedForwarders []string          `json:"trusted_forwarders" dc:"IPs, CIDRs or host suffixes whose mail is never enforced"`
+}
+
+type SetInboundDMARCRes struct {
+	api_v1.StandardRes
+}
+
+type SetInboundDMARCDomainModeReq struct {
+	g.Meta        `path:"/inbound/dmarc/set_domain_mode" method:"post" summary:"Set the DMARC enforcement mode of a recipient domain"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Domain        string `json:"domain" v:"required" dc:"Recipient domain"`
+	Mode          string `json:"mode" v:"in:report,enforce" dc:"report or enforce, empty to follow the global mode"`
+}
+
+type SetInboundDMARCDomainModeRes struct {
+	api_v1.StandardRes
+}
+
+type InboundDisposition struct {
+	Enabled            bool     `json:"enabled" dc:"Apply the disposition to the inbound mail"`
+	TagScore           float64  `json:"tag_score" dc:"Tagged as spam at or above it"`
+	QuarantineScore    float64  `json:"quarantine_score" dc:"Quarantined at or above it"`
+	RejectScore        float64  `json:"reject_score" dc:"Rejected at or above it"`
+	SPFFailPenalty     float64  `json:"spf_fail_penalty" dc:"Added to the spam score on a SPF fail"`
+	SPFSoftfailPenalty float64  `json:"spf_softfail_penalty" dc:"Added to the spam score on a SPF softfail"`
+	DKIMFailPenalty    float64  `json:"dkim_fail_penalty" dc:"Added to the spam score when no DKIM signature verified"`
+	DMARCFailPenalty   float64  `json:"dmarc_fail_penalty" dc:"Added to the spam score on a DMARC fail"`
+	AcceptForged       bool     `json:"accept_forged" dc:"Only score the messages failing SPF, DKIM and DMARC instead of rejecting them"`
+	TrustedForwarders  []string `json:"trusted_forwarders" dc:"IPs, CIDRs or host suffixes whose authentication failures are ignored, those of the DMARC enforcement when empty"`
+}
+
+type GetInboundDispositionReq struct {
+	g.Meta        `path:"/inbound/disposition/get" method:"get" summary:"Get the disposition settings of the inbound mail"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetInboundDispositionRes struct {
+	api_v1.StandardRes
+	Data InboundDisposition `json:"data"`
+}
+
+type SetInboundDispositionReq struct {
+	g.Meta             `path:"/inbound/disposition/set" method:"post" summary:"Set the disposition settings of the inbound mail"`
+	Authorization      string   `json:"authorization" dc:"Authorization" in:"header"`
+	Enabled            bool     `json:"enabled" dc:"Apply the disposition to the inbound mail"`
+	TagScore           float64  `json:"tag_score" v:"min:0" dc:"Tagged as spam at or above it, 0 for the default"`
+	QuarantineScore    float64  `json:"quarantine_score" v:"min:0" dc:"Quarantined at or above it, 0 for the default"`
+	RejectScore        float64  `json:"reject_score" v:"min:0" dc:"Rejected at or above it, 0 for the default"`
+	SPFFailPenalty     float64  `json:"spf_fail_penalty" v:"min:0" dc:"Added to the spam score on a SPF fail, 0 for the default"`
+	SPFSoftfailPenalty float64  `json:"spf_softfail_penalty" v:"min:0" dc:"Added to the spam score on a SPF softfail, 0 for the default"`
+	DKIMFailPenalty    float64  `json:"dkim_fail_penalty" v:"min:0" dc:"Added to the spam score when no DKIM signature verified, 0 for the default"`
+	DMARCFailPenalty   float64  `json:"dmarc_fail_penalty" v:"min:0" dc:"Added to the spam score on a DMARC fail, 0 for the default"`
+	AcceptForged       bool     `json:"accept_forged" dc:"Only score the messages failing SPF, DKIM and DMARC instead of rejecting them"`
+	TrustedForwarders  []string `json:"trusted_forwarders" dc:"IPs, CIDRs or host suffixes whose authentication failures are ignored"`
+}
+
+type SetInboundDispositionRes struct {
+	api_v1.StandardRes
+}
+
+type InboundDuplicates struct {
+	Mailboxes     []string `json:"mailboxes" dc:"Mailboxes opted in the duplicate suppression"`
+	WindowSeconds int      `json:"window_seconds" dc:"Redeliveries within it are suppressed"`
+	Suppressed    int64    `json:"suppressed" dc:"Duplicate deliveries suppressed in the last 24 hours"`
+	Tracked       int      `json:"tracked" dc:"Deliveries remembered within the window"`
+}
+
+type GetInboundDuplicatesReq struct {
+	g.Meta        `path:"/inbound/duplicates/get" method:"get" summary:"Get the duplicate delivery suppression and its metric"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetInboundDuplicatesRes struct {
+	api_v1.StandardRes
+	Data InboundDuplicates `json:"data"`
+}
+
+type SetInboundDuplicatesReq struct {
+	g.Meta        `path:"/inbound/duplicates/set" method:"post" summary:"Set the duplicate delivery suppression"`
+	Authorization string   `json:"authorization" dc:"Authorization" in:"header"`
+	Mailboxes     []string `json:"mailboxes" dc:"Mailboxes opted in the duplicate suppression"`
+	WindowSeconds int      `json:"window_seconds" v:"min:0|max:86400" dc:"Redeliveries within it are suppressed, 600 when 0"`
+}
+
+type SetInboundDuplicatesRes struct {
+	api_v1.StandardRes
+}
+
+type SetInboundDuplicateMailboxReq struct {
+	g.Meta        `path:"/inbound/duplicates/set_mailbox" method:"post" summary:"Opt a mailbox in or out of the duplicate delivery suppression"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Mailbox       string `json:"mailbox" v:"required|email" dc:"Mailbox"`
+	Enabled       bool   `json:"enabled" dc:"Suppress the duplicate deliveries to the mailbox"`
+}
+
+type SetInboundDuplicateMailboxRes struct {
+	api_v1.StandardRes
+}
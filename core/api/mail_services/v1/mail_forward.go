@@ -64,3 +64,36 @@ type DeleteMailForwardReq struct {
 type DeleteMailForwardRes struct {
 	api_v1.StandardRes
 }
+
+type GetMailForwardSRSReq struct {
+	g.Meta        `path:"/mail_forward/srs/get" method:"get" summary:"get the domains rewriting the envelope sender of forwarded mail (SRS)"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetMailForwardSRSRes struct {
+	api_v1.StandardRes
+	Data struct {
+		Domains []string `json:"domains" dc:"Domains with SRS enabled"`
+		Secrets int      `json:"secrets" dc:"Secrets accepted for the rewritten addresses, the first signs"`
+	} `json:"data"`
+}
+
+type SetMailForwardSRSReq struct {
+	g.Meta        `path:"/mail_forward/srs/set" method:"post" summary:"enable or disable SRS for the mail received by a domain"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Domain        string `json:"domain" v:"required" dc:"Domain name"`
+	Enabled       bool   `json:"enabled" dc:"Rewrite the envelope sender of the mail received by the domain"`
+}
+
+type SetMailForwardSRSRes struct {
+	api_v1.StandardRes
+}
+
+type RotateMailForwardSRSSecretReq struct {
+	g.Meta        `path:"/mail_forward/srs/rotate_secret" method:"post" summary:"rotate the SRS signing secret, the previous ones still verify"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type RotateMailForwardSRSSecretRes struct {
+	api_v1.StandardRes
+}
@@ -0,0 +1,42 @@
+package v1
+
+import (
+	"billionmail-core/utility/types/api_v1"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+type MailJournalRule struct {
+	Domain   string `json:"domain" v:"required" dc:"Journaled local domain"`
+	Inbound  bool   `json:"inbound" dc:"Journal the messages to the domain"`
+	Outbound bool   `json:"outbound" dc:"Journal the messages sent by the authenticated users of the domain"`
+	Address  string `json:"address" dc:"Archive address the journal reports are mailed to"`
+	Store    string `json:"store" dc:"Absolute directory the journal reports are written to"`
+}
+
+type MailJournal struct {
+	Enabled bool              `json:"enabled" dc:"Whether the mail is journaled"`
+	Sender  string            `json:"sender" dc:"Local mailbox the journal reports are submitted from, never journaled itself"`
+	Rules   []MailJournalRule `json:"rules" dc:"Journal rules, one per domain"`
+}
+
+type GetMailJournalReq struct {
+	g.Meta        `path:"/mail_journal/get" method:"get" summary:"Get the journaling settings"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetMailJournalRes struct {
+	api_v1.StandardRes
+	Data MailJournal `json:"data"`
+}
+
+type SetMailJournalReq struct {
+	g.Meta        `path:"/mail_journal/set" method:"post" summary:"Set the journaling of the inbound and outbound mail to an archive"`
+	Authorization string            `json:"authorization" dc:"Authorization" in:"header"`
+	Enabled       bool              `json:"enabled" dc:"Whether the mail is journaled"`
+	Sender        string            `json:"sender" dc:"Local mailbox the journal reports are submitted from, required with an archive address"`
+	Rules         []MailJournalRule `json:"rules" dc:"Journal rules, one per domain"`
+}
+
+type SetMailJournalRes struct {
+	api_v1.StandardRes
+}
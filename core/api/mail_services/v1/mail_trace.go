@@ -0,0 +1,48 @@
+package v1
+
+import (
+	"billionmail-core/utility/types/api_v1"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+type MailTrace struct {
+	Id        string `json:"id" dc:"Trace ID"`
+	Sender    string `json:"sender" dc:"Sender pattern"`
+	Recipient string `json:"recipient" dc:"Recipient pattern"`
+	IP        string `json:"ip" dc:"Server IP pattern or CIDR"`
+	ExpiresAt int64  `json:"expires_at" dc:"Unix time the trace is disabled"`
+}
+
+type EnableMailTraceReq struct {
+	g.Meta        `path:"/mail_trace/enable" method:"post" summary:"Trace the SMTP conversation of matching outgoing mails"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Sender        string `json:"sender" dc:"Sender glob pattern, e.g. *@example.com"`
+	Recipient     string `json:"recipient" dc:"Recipient glob pattern"`
+	IP            string `json:"ip" dc:"Server IP glob pattern or CIDR"`
+	TTL           int    `json:"ttl" v:"min:0|max:1440" d:"30" dc:"Minutes until the trace is disabled, at most 1440"`
+}
+
+type EnableMailTraceRes struct {
+	api_v1.StandardRes
+	Data MailTrace `json:"data"`
+}
+
+type DisableMailTraceReq struct {
+	g.Meta        `path:"/mail_trace/disable" method:"post" summary:"Disable a mail trace"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Id            string `json:"id" v:"required" dc:"Trace ID"`
+}
+
+type DisableMailTraceRes struct {
+	api_v1.StandardRes
+}
+
+type GetMailTraceListReq struct {
+	g.Meta        `path:"/mail_trace/list" method:"get" summary:"List the active mail traces"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetMailTraceListRes struct {
+	api_v1.StandardRes
+	Data []MailTrace `json:"data"`
+}
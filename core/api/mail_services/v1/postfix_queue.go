@@ -15,6 +15,22 @@ type QueueMailItem struct {
 	QueueName    string      `json:"queue_name" dc:"Queue name"`
 	ForcedExpire bool        `json:"forced_expire" dc:"Forced expire flag"`
 	Recipient    string      `json:"recipient" dc:"Recipient"`
+	Attempts     int         `json:"attempts" dc:"Number of delivery attempts"`
+	LastAttempt  int64       `json:"last_attempt,omitempty" dc:"Last attempt timestamp"`
+	LastRelay    string      `json:"last_relay,omitempty" dc:"Target MX of the last attempt"`
+	LastCode     int         `json:"last_code,omitempty" dc:"SMTP reply code of the last attempt"`
+	LastResponse string      `json:"last_response,omitempty" dc:"Response of the last attempt"`
+	NextAttempt  int64       `json:"next_attempt,omitempty" dc:"Timestamp of the next attempt under the retry schedule"`
+	BounceAt     int64       `json:"bounce_at,omitempty" dc:"Timestamp of the bounce, set when no attempt is left before the max lifetime"`
+	Priority     string      `json:"priority" dc:"Priority of the message: high, normal or bulk"`
+}
+
+type QueueAttempt struct {
+	Time  int64  `json:"time" dc:"Attempt timestamp"`
+	Relay string `json:"relay" dc:"Target MX"`
+	Code  int    `json:"code" dc:"SMTP reply code, 0 without SMTP reply"`
+	Dsn   string `json:"dsn" dc:"Delivery status code"`
+	Error string `json:"error" dc:"Response or error text"`
 }
 
 type Recipient struct {
@@ -46,6 +62,48 @@ type GetPostfixQueueInfoRes struct {
 	api_v1.StandardRes
 }
 
+type GetPostfixQueueAttemptsReq struct {
+	g.Meta        `path:"/postfix_queue/attempts" method:"get" summary:"Get the delivery attempt history of a queued mail"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	QueueID       string `json:"queue_id" v:"required" dc:"Queue ID"`
+}
+
+type GetPostfixQueueAttemptsRes struct {
+	api_v1.StandardRes
+	Data []QueueAttempt `json:"data" dc:"Attempts, oldest first"`
+}
+
+type InspectPostfixQueueMessageReq struct {
+	g.Meta        `path:"/postfix_queue/inspect" method:"get" summary:"Inspect the headers, MIME structure, authentication results and spam score of a queued mail"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	QueueID       string `json:"queue_id" v:"required" dc:"Queue ID"`
+}
+
+type InspectPostfixQueueMessageRes struct {
+	api_v1.StandardRes
+}
+
+type DownloadPostfixQueuePartReq struct {
+	g.Meta        `path:"/postfix_queue/part" method:"get" summary:"Download one decoded MIME part of a queued mail"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	QueueID       string `json:"queue_id" v:"required" dc:"Queue ID"`
+	Path          string `json:"path" dc:"Part path from the inspection, e.g. 1.2, empty for a single part mail"`
+}
+
+type DownloadPostfixQueuePartRes struct {
+	api_v1.StandardRes
+}
+
+type ExportTraceBundleReq struct {
+	g.Meta        `path:"/postfix_queue/trace_bundle" method:"get" summary:"Download the support bundle of a mail: its log lines, delivery records and authentication results"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	TraceID       string `json:"trace_id" v:"required" dc:"Message-ID or queue ID of the mail"`
+}
+
+type ExportTraceBundleRes struct {
+	api_v1.StandardRes
+}
+
 type DeletePostfixQueueByIdReq struct {
 	g.Meta        `path:"/postfix_queue/delete_by_id" method:"post" summary:"Delete specified queue mails (batch supported)"`
 	Authorization string   `json:"authorization" dc:"Authorization" in:"header"`
@@ -119,3 +177,83 @@ type GetPostfixConfigReq struct {
 type GetPostfixConfigRes struct {
 	api_v1.StandardRes
 }
+
+type SubmissionBackpressure struct {
+	MaxQueueDepth    int    `json:"max_queue_depth" dc:"Queue depth above which the submissions are deferred, 0 disables the backpressure"`
+	ResumeQueueDepth int    `json:"resume_queue_depth" dc:"Queue depth at or below which the submissions are accepted again"`
+	Engaged          bool   `json:"engaged" dc:"Whether the submissions are being deferred"`
+	QueueDepth       int    `json:"queue_depth" dc:"Messages waiting for delivery at the last reading"`
+	CheckedAt        int64  `json:"checked_at" dc:"Timestamp of the last reading"`
+	EngagedSince     int64  `json:"engaged_since,omitempty" dc:"Timestamp the backpressure engaged"`
+	Deferred         int64  `json:"deferred" dc:"Recipients deferred since the service started"`
+	Error            string `json:"error,omitempty" dc:"Failure of the last reading"`
+}
+
+type GetSubmissionBackpressureReq struct {
+	g.Meta        `path:"/postfix_queue/backpressure" method:"get" summary:"Get the queue depth backpressure of the submissions"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetSubmissionBackpressureRes struct {
+	api_v1.StandardRes
+	Data SubmissionBackpressure `json:"data"`
+}
+
+type SetSubmissionBackpressureReq struct {
+	g.Meta           `path:"/postfix_queue/set_backpressure" method:"post" summary:"Set the queue depths of the submission backpressure"`
+	Authorization    string `json:"authorization" dc:"Authorization" in:"header"`
+	MaxQueueDepth    int    `json:"max_queue_depth" v:"min:0" dc:"Queue depth above which the submissions are deferred, 0 disables the backpressure"`
+	ResumeQueueDepth int    `json:"resume_queue_depth" v:"min:0" dc:"Queue depth at or below which the submissions are accepted again, 80% of the max when 0"`
+}
+
+type SetSubmissionBackpressureRes struct {
+	api_v1.StandardRes
+}
+
+type SenderRate struct {
+	Sender      string `json:"sender" dc:"Authenticated sender"`
+	MsgsPerMin  int    `json:"msgs_per_min" dc:"Messages per minute, 0 is unlimited"`
+	BytesPerMin int64  `json:"bytes_per_min" dc:"Bytes per minute, 0 is unlimited"`
+}
+
+type GetSenderRatesReq struct {
+	g.Meta        `path:"/postfix_queue/sender_rates" method:"get" summary:"Get the outbound rate limits of the authenticated senders"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetSenderRatesRes struct {
+	api_v1.StandardRes
+	Data []SenderRate `json:"data"`
+}
+
+type SetSenderRateReq struct {
+	g.Meta        `path:"/postfix_queue/set_sender_rate" method:"post" summary:"Set the outbound rate limit of an authenticated sender"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Sender        string `json:"sender" v:"required" dc:"Authenticated sender"`
+	MsgsPerMin    int    `json:"msgs_per_min" v:"min:0" dc:"Messages per minute, 0 is unlimited"`
+	BytesPerMin   int64  `json:"bytes_per_min" v:"min:0" dc:"Bytes per minute, 0 is unlimited. Both 0 remove the limit"`
+}
+
+type SetSenderRateRes struct {
+	api_v1.StandardRes
+}
+
+type SenderRateUsage struct {
+	Sender      string `json:"sender" dc:"Authenticated sender"`
+	MsgsPerMin  int    `json:"msgs_per_min" dc:"Messages per minute, 0 is unlimited"`
+	BytesPerMin int64  `json:"bytes_per_min" dc:"Bytes per minute, 0 is unlimited"`
+	MsgsUsed    int    `json:"msgs_used" dc:"Messages within the last minute"`
+	BytesUsed   int64  `json:"bytes_used" dc:"Bytes within the last minute"`
+	Accepted    int64  `json:"accepted" dc:"Messages accepted since the service started"`
+	Deferred    int64  `json:"deferred" dc:"Messages deferred since the service started"`
+}
+
+type GetSenderRateUsageReq struct {
+	g.Meta        `path:"/postfix_queue/sender_rate_usage" method:"get" summary:"Get the consumption of the limited senders"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetSenderRateUsageRes struct {
+	api_v1.StandardRes
+	Data []SenderRateUsage `json:"data"`
+}
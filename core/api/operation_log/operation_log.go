@@ -15,4 +15,18 @@ type IOperationLogV1 interface {
 	GetOperationType(ctx context.Context, req *v1.GetOperationTypeReq) (res *v1.GetOperationTypeRes, err error)
 	GetOutputLog(ctx context.Context, req *v1.GetOutputLogReq) (res *v1.GetOutputLogRes, err error)
 	GetLatestOutputLog(ctx context.Context, req *v1.GetLatestOutputLogReq) (res *v1.GetLatestOutputLogRes, err error)
+	GetRecentOutputLog(ctx context.Context, req *v1.GetRecentOutputLogReq) (res *v1.GetRecentOutputLogRes, err error)
+	GetLogDiskUsage(ctx context.Context, req *v1.GetLogDiskUsageReq) (res *v1.GetLogDiskUsageRes, err error)
+	EstimateReclaimable(ctx context.Context, req *v1.EstimateReclaimableReq) (res *v1.EstimateReclaimableRes, err error)
+	GetTenantLogDiskUsage(ctx context.Context, req *v1.GetTenantLogDiskUsageReq) (res *v1.GetTenantLogDiskUsageRes, err error)
+	GetDailyLogStats(ctx context.Context, req *v1.GetDailyLogStatsReq) (res *v1.GetDailyLogStatsRes, err error)
+	GetLogCacheStats(ctx context.Context, req *v1.GetLogCacheStatsReq) (res *v1.GetLogCacheStatsRes, err error)
+	GetMaintenanceHealth(ctx context.Context, req *v1.GetMaintenanceHealthReq) (res *v1.GetMaintenanceHealthRes, err error)
+	GetLogPins(ctx context.Context, req *v1.GetLogPinsReq) (res *v1.GetLogPinsRes, err error)
+	PinLog(ctx context.Context, req *v1.PinLogReq) (res *v1.PinLogRes, err error)
+	UnpinLog(ctx context.Context, req *v1.UnpinLogReq) (res *v1.UnpinLogRes, err error)
+	GetIncidentHolds(ctx context.Context, req *v1.GetIncidentHoldsReq) (res *v1.GetIncidentHoldsRes, err error)
+	CreateIncidentHold(ctx context.Context, req *v1.CreateIncidentHoldReq) (res *v1.CreateIncidentHoldRes, err error)
+	CloseIncident(ctx context.Context, req *v1.CloseIncidentReq) (res *v1.CloseIncidentRes, err error)
+	CleanupDirectory(ctx context.Context, req *v1.CleanupDirectoryReq) (res *v1.CleanupDirectoryRes, err error)
 }
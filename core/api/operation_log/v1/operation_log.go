@@ -54,3 +54,134 @@ type GetLatestOutputLogReq struct {
 type GetLatestOutputLogRes struct {
 	api_v1.StandardRes
 }
+
+type GetRecentOutputLogReq struct {
+	g.Meta        `path:"/operation_log/output/recent" method:"get" tags:"Output Log" summary:"Get recent output log lines from memory"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Component     string `json:"component" dc:"Log component, default core"`
+	Lines         int    `json:"lines" v:"min:0|max:1000" dc:"Number of lines, default 200" d:"200"`
+}
+type GetRecentOutputLogRes struct {
+	api_v1.StandardRes
+}
+
+type GetLogDiskUsageReq struct {
+	g.Meta        `path:"/operation_log/disk_usage" method:"get" tags:"Output Log" summary:"Get the disk usage of the logs by directory and group"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+type GetLogDiskUsageRes struct {
+	api_v1.StandardRes
+}
+
+type EstimateReclaimableReq struct {
+	g.Meta        `path:"/operation_log/reclaimable" method:"get" tags:"Output Log" summary:"Estimate the space the log maintenance would free, without running it"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+type EstimateReclaimableRes struct {
+	api_v1.StandardRes
+}
+
+type GetTenantLogDiskUsageReq struct {
+	g.Meta        `path:"/operation_log/tenant_disk_usage" method:"get" tags:"Output Log" summary:"Get the disk usage of the logs of each tenant"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Tenant        string `json:"tenant" dc:"Tenant, every tenant when empty"`
+}
+type GetTenantLogDiskUsageRes struct {
+	api_v1.StandardRes
+}
+
+type GetDailyLogStatsReq struct {
+	g.Meta        `path:"/operation_log/daily_stats" method:"get" tags:"Output Log" summary:"Get the archived log volume per day and log group"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Since         string `json:"since" v:"date" dc:"First day, e.g. 2025-01-01, open when empty"`
+	Until         string `json:"until" v:"date" dc:"Last day, e.g. 2025-01-31, open when empty"`
+}
+type GetDailyLogStatsRes struct {
+	api_v1.StandardRes
+}
+
+type GetLogCacheStatsReq struct {
+	g.Meta        `path:"/operation_log/log_cache_stats" method:"get" tags:"Output Log" summary:"Get the hit and miss counters of the cache of the decompressed logs"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+type GetLogCacheStatsRes struct {
+	api_v1.StandardRes
+}
+
+type GetMaintenanceHealthReq struct {
+	g.Meta        `path:"/operation_log/maintenance_health" method:"get" tags:"Output Log" summary:"Get whether the scheduled log maintenance keeps succeeding"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+type GetMaintenanceHealthRes struct {
+	api_v1.StandardRes
+}
+
+type GetLogPinsReq struct {
+	g.Meta        `path:"/operation_log/pins" method:"get" tags:"Output Log" summary:"List the logs pinned against compression and deletion"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+type GetLogPinsRes struct {
+	api_v1.StandardRes
+}
+
+type PinLogReq struct {
+	g.Meta        `path:"/operation_log/pin" method:"post" tags:"Output Log" summary:"Pin a log so the maintenance neither compresses nor deletes it"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Path          string `json:"path" v:"required" dc:"Log, operation log day or archive, relative to the logs directory"`
+	TTL           int    `json:"ttl" v:"min:0" dc:"Seconds until the pin expires, 0 until unpinned"`
+	Reason        string `json:"reason" dc:"Reason of the pin, e.g. the incident"`
+}
+type PinLogRes struct {
+	api_v1.StandardRes
+}
+
+type UnpinLogReq struct {
+	g.Meta        `path:"/operation_log/unpin" method:"post" tags:"Output Log" summary:"Unpin a log, the next maintenance run handles it again"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Path          string `json:"path" v:"required" dc:"Pinned path, relative to the logs directory"`
+}
+type UnpinLogRes struct {
+	api_v1.StandardRes
+}
+
+type GetIncidentHoldsReq struct {
+	g.Meta        `path:"/operation_log/incidents" method:"get" tags:"Output Log" summary:"List the open incidents and the logs each holds"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+type GetIncidentHoldsRes struct {
+	api_v1.StandardRes
+}
+
+type CreateIncidentHoldReq struct {
+	g.Meta        `path:"/operation_log/incident/create" method:"post" tags:"Output Log" summary:"Hold every log of a time window until the incident is closed"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Name          string `json:"name" v:"required" dc:"Incident name"`
+	Since         int64  `json:"since" v:"required|min:1" dc:"Start of the window, unix seconds"`
+	Until         int64  `json:"until" v:"min:0" dc:"End of the window, unix seconds, 0 holds the new logs as well until closed"`
+}
+type CreateIncidentHoldRes struct {
+	api_v1.StandardRes
+}
+
+type CloseIncidentReq struct {
+	g.Meta        `path:"/operation_log/incident/close" method:"post" tags:"Output Log" summary:"Close an incident, the next maintenance run handles its logs again"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Name          string `json:"name" v:"required" dc:"Incident name"`
+}
+type CloseIncidentRes struct {
+	api_v1.StandardRes
+}
+
+type CleanupDirectoryReq struct {
+	g.Meta        `path:"/operation_log/cleanup_dir" method:"post" tags:"Output Log" summary:"Apply the log retention and compression to a directory out of the logs tree"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	Dir           string `json:"dir" v:"required" dc:"Absolute directory, e.g. the old logs left by a migration"`
+	Name          string `json:"name" dc:"Group of its logs, the archives are stored below external/<name> of the logs directory, cleanup when empty"`
+	Pattern       string `json:"pattern" dc:"Regular expression of the file names of its logs, *.log when empty"`
+	FilesToKeep   int    `json:"files_to_keep" v:"min:0" dc:"Newest logs kept, the configured retention when 0"`
+	MaxAgeDays    int    `json:"max_age_days" v:"min:0" dc:"Logs older than it are deleted, the configured retention when 0"`
+	DryRun        bool   `json:"dry_run" dc:"Only report what the cleanup would delete and compress"`
+}
+type CleanupDirectoryRes struct {
+	api_v1.StandardRes
+}
@@ -27,4 +27,6 @@ type ISettingsV1 interface {
 	SetBlacklistAutoScan(ctx context.Context, req *v1.SetBlacklistAutoScanReq) (res *v1.SetBlacklistAutoScanRes, err error)
 	SetBlacklistAlert(ctx context.Context, req *v1.SetBlacklistAlertReq) (res *v1.SetBlacklistAlertRes, err error)
 	SetBlacklistAlertSettings(ctx context.Context, req *v1.SetBlacklistAlertSettingsReq) (res *v1.SetBlacklistAlertSettingsRes, err error)
+	GetOperationsDigestConfig(ctx context.Context, req *v1.GetOperationsDigestConfigReq) (res *v1.GetOperationsDigestConfigRes, err error)
+	SetOperationsDigestConfig(ctx context.Context, req *v1.SetOperationsDigestConfigReq) (res *v1.SetOperationsDigestConfigRes, err error)
 }
@@ -292,3 +292,31 @@ type SetBlacklistAlertSettingsReq struct {
 type SetBlacklistAlertSettingsRes struct {
 	api_v1.StandardRes
 }
+
+type OperationsDigestConfig struct {
+	Enabled         bool     `json:"enabled" dc:"Send the periodic operations digest"`
+	Recipients      []string `json:"recipients" dc:"Admin addresses receiving the digest"`
+	IntervalHours   int      `json:"interval_hours" dc:"Hours between two digests, default 168"`
+	MaintenanceRuns int      `json:"maintenance_runs" dc:"Number of recent maintenance runs listed, default 7"`
+}
+
+type GetOperationsDigestConfigReq struct {
+	g.Meta        `path:"/settings/get_operations_digest_config" tags:"Settings" method:"get" summary:"Get operations digest configuration"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+}
+
+type GetOperationsDigestConfigRes struct {
+	api_v1.StandardRes
+	Data OperationsDigestConfig `json:"data"`
+}
+
+type SetOperationsDigestConfigReq struct {
+	g.Meta        `path:"/settings/set_operations_digest_config" tags:"Settings" method:"post" summary:"Set operations digest configuration"`
+	Authorization string `json:"authorization" dc:"Authorization" in:"header"`
+	OperationsDigestConfig
+	SendNow bool `json:"send_now" dc:"Send a digest right away"`
+}
+
+type SetOperationsDigestConfigRes struct {
+	api_v1.StandardRes
+}
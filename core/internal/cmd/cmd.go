@@ -24,8 +24,10 @@ import (
 	"billionmail-core/internal/controller/tags"
 	"billionmail-core/internal/service/database_initialization"
 	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/log_maintenance"
 	"billionmail-core/internal/service/maillog_stat"
 	"billionmail-core/internal/service/middlewares"
+	"billionmail-core/internal/service/ops_digest"
 	"billionmail-core/internal/service/phpfpm"
 	"billionmail-core/internal/service/public"
 	rbac2 "billionmail-core/internal/service/rbac"
@@ -42,6 +44,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gcmd"
@@ -59,6 +62,40 @@ var (
 				return nil
 			}
 
+			// Exercise the log compression, verification and restore on a temporary tree
+			if v := parser.GetOpt("log-selftest"); v != nil {
+				report := log_maintenance.SelfTest(ctx)
+				fmt.Println(gjson.MustEncodeString(report))
+				if !report.Passed {
+					return fmt.Errorf("log maintenance self-test failed")
+				}
+				return nil
+			}
+
+			// Work off the whole backlog of old logs in one run rather than in batches
+			if v := parser.GetOpt("log-backlog-full"); v != nil {
+				cfg := log_maintenance.DefaultService().Config()
+				cfg.BacklogFull = true
+				result := log_maintenance.RunMaintenance(ctx, cfg)
+				fmt.Println(gjson.MustEncodeString(result))
+				return result.Err()
+			}
+
+			// Keep recent log lines in memory for the output log tail
+			log_maintenance.InstallRecentLogsHandler()
+
+			// Alert the operators right away when the log volume turns read-only
+			ops_digest.InstallReadOnlyAlert()
+
+			// Alert the operators when an archive cannot be confirmed in its sink
+			ops_digest.InstallUnconfirmedArchiveAlert()
+
+			// Alert the operators when the scheduled log maintenance stops running
+			ops_digest.InstallStaleMaintenanceAlert()
+
+			// Alert the operators when the circuit breaker pauses a campaign
+			ops_digest.InstallCircuitBreakerAlert()
+
 			// Init Database
 			err = database_initialization.InitDatabase()
 
@@ -153,6 +190,18 @@ var (
 				"/subscribe_success.html":        {},
 				"/unsubscribe_success.html":      {},
 				"/subscribe_form_code.html":      {},
+
+				// API sends beyond send and batch_send, authenticated by the API key
+				"/api/batch_mail/api/personalized_send":  {},
+				"/api/batch_mail/api/transactional_send": {},
+
+				// Preference center, authenticated by the token of the unsubscribe link
+				"/api/preferences":        {},
+				"/api/preferences/update": {},
+
+				// Relay bounce and ESP suppression webhooks, authenticated by the provider signature
+				"/api/abnormal_recipient/bounce_webhook":      {},
+				"/api/abnormal_recipient/suppression_webhook": {},
 			}
 
 			// Bind Server Hooks
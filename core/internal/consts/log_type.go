@@ -25,6 +25,7 @@ var (
 		Login             string
 		PostfixQueue      string
 		Tag    			  string
+		LogMaintenance    string
 	}{
 		Task:              "Email Marketing Task",
 		Template:          "Email Template",
@@ -44,6 +45,7 @@ var (
 		Login:             "Login",
 		PostfixQueue:      "Postfix Queue",
 		Tag:      		   "Contacts-Tag",
+		LogMaintenance:    "Log Maintenance",
 	}
 )
 var (
@@ -0,0 +1,29 @@
+package abnormal_recipient
+
+import (
+	"billionmail-core/api/abnormal_recipient/v1"
+	"billionmail-core/internal/service/abnormal_recipient"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+func (c *ControllerV1) BounceWebhook(ctx context.Context, req *v1.BounceWebhookReq) (res *v1.BounceWebhookRes, err error) {
+	res = &v1.BounceWebhookRes{}
+
+	r := g.RequestFromCtx(ctx)
+
+	n, err := abnormal_recipient.HandleBounceWebhook(ctx, req.Provider, r.Header, r.GetBody())
+	if err != nil {
+		g.Log().Warning(ctx, "Rejected bounce webhook", req.Provider, r.GetClientIp(), err)
+		r.Response.WriteHeader(400)
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to process bounce webhook: {}", err.Error())))
+		return
+	}
+
+	res.Data.Events = n
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
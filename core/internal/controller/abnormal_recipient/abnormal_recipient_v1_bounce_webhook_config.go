@@ -0,0 +1,49 @@
+package abnormal_recipient
+
+import (
+	"billionmail-core/api/abnormal_recipient/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/abnormal_recipient"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) GetBounceWebhookConfig(ctx context.Context, req *v1.GetBounceWebhookConfigReq) (res *v1.GetBounceWebhookConfigRes, err error) {
+	res = &v1.GetBounceWebhookConfigRes{}
+
+	cfg := abnormal_recipient.GetBounceWebhookConfig(ctx)
+
+	res.Data.SESTopicArns = cfg.SESTopicArns
+	res.Data.SendGridPublicKey = cfg.SendGridPublicKey
+	res.Data.MailgunSigningKey = cfg.MailgunSigningKey != ""
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
+
+func (c *ControllerV1) SetBounceWebhookConfig(ctx context.Context, req *v1.SetBounceWebhookConfigReq) (res *v1.SetBounceWebhookConfigRes, err error) {
+	res = &v1.SetBounceWebhookConfigRes{}
+
+	cfg := abnormal_recipient.GetBounceWebhookConfig(ctx)
+	cfg.SESTopicArns = req.SESTopicArns
+	cfg.SendGridPublicKey = req.SendGridPublicKey
+
+	if req.MailgunSigningKey != "" {
+		cfg.MailgunSigningKey = req.MailgunSigningKey
+	}
+
+	if err = abnormal_recipient.SetBounceWebhookConfig(ctx, cfg); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save bounce webhook settings: {}", err.Error())))
+		return
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.AbnormalRecipient,
+		Log:  "Update bounce webhook settings successfully",
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
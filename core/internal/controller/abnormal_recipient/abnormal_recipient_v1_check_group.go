@@ -5,6 +5,7 @@ import (
 	"billionmail-core/internal/consts"
 	"billionmail-core/internal/model/entity"
 	"billionmail-core/internal/service/abnormal_recipient"
+	"billionmail-core/internal/service/dns_resolver"
 	"billionmail-core/internal/service/public"
 	"context"
 	"fmt"
@@ -177,7 +178,7 @@ func ValidateMXRecord(record DNSRecord, domain string, aRecordHosts ...string) (
 	var err error
 
 	for i := 0; i < 3; i++ {
-		mxRecords, err = net.LookupMX(domain)
+		mxRecords, err = dns_resolver.Default().LookupMX(context.Background(), domain)
 		if err == nil {
 			break
 		}
@@ -0,0 +1,53 @@
+package abnormal_recipient
+
+import (
+	"billionmail-core/api/abnormal_recipient/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/abnormal_recipient"
+	"billionmail-core/internal/service/public"
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+func (c *ControllerV1) ExportSuppressions(ctx context.Context, req *v1.ExportSuppressionsReq) (res *v1.ExportSuppressionsRes, err error) {
+	res = &v1.ExportSuppressionsRes{}
+
+	r := g.RequestFromCtx(ctx)
+	if r == nil {
+		return nil, gerror.New("Unable to obtain the request context")
+	}
+
+	contentType := "text/csv"
+	if req.Format == abnormal_recipient.SuppressionFormatJSON {
+		contentType = "application/json"
+	}
+	fileName := "suppressions-" + time.Now().Format("20060102") + "." + req.Format
+
+	r.Response.Header().Set("Content-Type", contentType)
+	r.Response.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+
+	err = abnormal_recipient.ExportSuppressions(ctx, r.Response.BufferWriter, abnormal_recipient.SuppressionOptions{
+		Format:  req.Format,
+		Scope:   req.Scope,
+		GroupId: req.GroupId,
+	})
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to export the suppression list: %v", err)
+		// Never hand out a truncated list
+		r.Response.ClearBuffer()
+		r.Response.Header().Del("Content-Disposition")
+		r.Response.Header().Set("Content-Type", "application/json")
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to export the suppression list: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.AbnormalRecipient,
+		Log:  "Export the suppression list successfully",
+	})
+
+	return
+}
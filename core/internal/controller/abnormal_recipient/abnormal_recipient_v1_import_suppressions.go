@@ -0,0 +1,37 @@
+package abnormal_recipient
+
+import (
+	"billionmail-core/api/abnormal_recipient/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/abnormal_recipient"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) ImportSuppressions(ctx context.Context, req *v1.ImportSuppressionsReq) (res *v1.ImportSuppressionsRes, err error) {
+	res = &v1.ImportSuppressionsRes{}
+
+	result, err := abnormal_recipient.ImportSuppressions(ctx, strings.NewReader(req.FileData), abnormal_recipient.SuppressionOptions{
+		Format:  req.Format,
+		Scope:   req.Scope,
+		GroupId: req.GroupId,
+	})
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to import the suppression list: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.AbnormalRecipient,
+		Log:  fmt.Sprintf("Import the suppression list: %d added, %d updated", result.Added, result.Updated),
+		Data: result,
+	})
+
+	res.Data = result
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,33 @@
+package abnormal_recipient
+
+import (
+	"billionmail-core/api/abnormal_recipient/v1"
+	"billionmail-core/internal/service/abnormal_recipient"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+func (c *ControllerV1) SuppressionWebhook(ctx context.Context, req *v1.SuppressionWebhookReq) (res *v1.SuppressionWebhookRes, err error) {
+	res = &v1.SuppressionWebhookRes{}
+
+	r := g.RequestFromCtx(ctx)
+
+	result, err := abnormal_recipient.HandleSuppressionWebhook(ctx, req.Provider, r.Header, r.GetBody())
+	if err != nil {
+		g.Log().Warning(ctx, "Rejected suppression webhook", req.Provider, r.GetClientIp(), err)
+		r.Response.WriteHeader(400)
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to process suppression webhook: {}", err.Error())))
+		return
+	}
+
+	res.Data.Events = result.Events
+	res.Data.Duplicates = result.Duplicates
+	res.Data.Added = result.Added
+	res.Data.Updated = result.Updated
+	res.Data.Unchanged = result.Unchanged
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
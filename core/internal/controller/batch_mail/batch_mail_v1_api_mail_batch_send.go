@@ -1,6 +1,7 @@
 package batch_mail
 
 import (
+	"billionmail-core/internal/service/batch_mail"
 	"billionmail-core/internal/service/contact"
 	"billionmail-core/internal/service/mail_service"
 	"billionmail-core/internal/service/public"
@@ -42,6 +43,14 @@ func (c *ControllerV1) ApiMailBatchSend(ctx context.Context, req *v1.ApiMailBatc
 		return res, nil
 	}
 
+	// check priority
+	priority, err := batch_mail.ParsePriority(req.Priority)
+	if err != nil {
+		res.Code = 1006
+		res.SetError(gerror.New(public.LangCtx(ctx, "Invalid priority: {}", req.Priority)))
+		return res, nil
+	}
+
 	// 4. check recipient
 	if len(req.Recipients) == 0 {
 		res.Code = 1003
@@ -118,6 +127,7 @@ func (c *ControllerV1) ApiMailBatchSend(ctx context.Context, req *v1.ApiMailBatc
 			"send_time":     0,
 			"create_time":   now,
 			"attribs":       req.Attribs,
+			"priority":      priority,
 		})
 	}
 
@@ -0,0 +1,170 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/contact"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) ApiMailPersonalizedSend(ctx context.Context, req *v1.ApiMailPersonalizedSendReq) (res *v1.ApiMailPersonalizedSendRes, err error) {
+	res = &v1.ApiMailPersonalizedSendRes{}
+	clientIP := g.RequestFromCtx(ctx).GetClientIp()
+
+	// 1. check API Key
+	apiTemplate, err := getApiTemplateByKey(ctx, req.ApiKey, clientIP)
+	if err != nil {
+		res.Code = 1001
+		res.SetError(gerror.New(public.LangCtx(ctx, err.Error())))
+		return res, nil
+	}
+
+	// 2. check client IP
+	err = CheckClientIP(ctx, apiTemplate.Id, clientIP)
+	if err != nil {
+		res.Code = 1002
+		res.SetError(gerror.New(public.LangCtx(ctx, err.Error())))
+		return res, nil
+	}
+
+	// 3. check email template
+	_, err = getEmailTemplateById(ctx, apiTemplate.TemplateId)
+	if err != nil {
+		res.Code = 1004
+		res.SetError(gerror.New(public.LangCtx(ctx, "Email template does not exist")))
+		return res, nil
+	}
+
+	// check priority
+	priority, err := batch_mail.ParsePriority(req.Priority)
+	if err != nil {
+		res.Code = 1006
+		res.SetError(gerror.New(public.LangCtx(ctx, "Invalid priority: {}", req.Priority)))
+		return res, nil
+	}
+
+	// 4. check recipients
+	if len(req.Recipients) == 0 {
+		res.Code = 1003
+		res.SetError(gerror.New(public.LangCtx(ctx, "Recipients cannot be empty")))
+		return res, nil
+	}
+
+	// 5. process addresser
+	addresser := req.Addresser
+	if addresser == "" {
+		addresser = apiTemplate.Addresser
+	}
+
+	sender, err := mail_service.NewEmailSenderWithLocal(addresser)
+	if err != nil {
+		res.Code = 1005
+		res.SetError(gerror.New(public.LangCtx(ctx, "create email sender failed: {}", err.Error())))
+		return res, nil
+	}
+	sender.Close()
+
+	// 6. validate each recipient, the valid ones are queued and sent individually by the API mail queue
+	results := make([]*v1.ApiMailRecipientResult, 0, len(req.Recipients))
+	batchData := make([]g.Map, 0, len(req.Recipients))
+	queued := make([]*v1.ApiMailRecipientResult, 0, len(req.Recipients))
+	now := int(time.Now().Unix())
+
+	for _, r := range req.Recipients {
+		result := &v1.ApiMailRecipientResult{}
+		results = append(results, result)
+
+		if r == nil {
+			result.Error = public.LangCtx(ctx, "Invalid recipient")
+			continue
+		}
+
+		result.Recipient = strings.TrimSpace(r.Recipient)
+		if result.Recipient == "" || !strings.Contains(result.Recipient, "@") {
+			result.Error = public.LangCtx(ctx, "Invalid recipient")
+			continue
+		}
+
+		if err := batch_mail.ValidateCustomHeaders(r.Headers); err != nil {
+			result.Error = public.LangCtx(ctx, "Invalid headers: {}", err.Error())
+			continue
+		}
+
+		if apiTemplate.GroupId > 0 {
+			// Add to the specified existing group
+			_, err = contact.AddContactToGroup(ctx, result.Recipient, apiTemplate.GroupId)
+		} else {
+			// Use the old logic to create an API-specific group
+			_, err = ensureContactAndGroup(ctx, result.Recipient, apiTemplate.Id)
+		}
+		if err != nil {
+			g.Log().Warningf(ctx, "Failed to process recipient %s with API ID %d: %v", result.Recipient, apiTemplate.Id, err)
+			result.Error = public.LangCtx(ctx, "Failed to process recipient: {}", err.Error())
+			continue
+		}
+
+		headers := r.Headers
+		if headers == nil {
+			headers = map[string]string{}
+		}
+
+		result.MessageId = strings.Trim(sender.GenerateMessageID(), "<>")
+		batchData = append(batchData, g.Map{
+			"api_id":        apiTemplate.Id,
+			"recipient":     result.Recipient,
+			"message_id":    result.MessageId,
+			"addresser":     addresser,
+			"status":        0,
+			"error_message": "",
+			"send_time":     0,
+			"create_time":   now,
+			"attribs":       r.Attribs,
+			"headers":       headers,
+			"priority":      priority,
+		})
+		queued = append(queued, result)
+	}
+
+	batchSize := 1000
+	for i := 0; i < len(batchData); i += batchSize {
+		end := i + batchSize
+		if end > len(batchData) {
+			end = len(batchData)
+		}
+		_, err = g.DB().Model("api_mail_logs").Batch(batchSize).Insert(batchData[i:end])
+		if err != nil {
+			g.Log().Errorf(ctx, "Failed to record email log: %v", err)
+			for _, result := range queued[i:] {
+				result.MessageId = ""
+				result.Error = public.LangCtx(ctx, "Failed to record email log: {}", err.Error())
+			}
+			queued = queued[:i]
+			break
+		}
+	}
+
+	for _, result := range queued {
+		result.Accepted = true
+	}
+
+	res.Data.Results = results
+	res.Data.Accepted = len(queued)
+	res.Data.Rejected = len(results) - len(queued)
+
+	if len(queued) == 0 {
+		res.Code = 1003
+		res.SetError(gerror.New(public.LangCtx(ctx, "No valid recipients")))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Batch email send request accepted, {} emails queued", len(queued)))
+	return res, nil
+}
@@ -3,6 +3,7 @@ package batch_mail
 import (
 	"billionmail-core/api/batch_mail/v1"
 	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/batch_mail"
 	"billionmail-core/internal/service/contact"
 	"billionmail-core/internal/service/mail_service"
 	"billionmail-core/internal/service/public"
@@ -55,6 +56,14 @@ func (c *ControllerV1) ApiMailSend(ctx context.Context, req *v1.ApiMailSendReq)
 		return res, nil
 	}
 
+	// check priority
+	priority, err := batch_mail.ParsePriority(req.Priority)
+	if err != nil {
+		res.Code = 1006
+		res.SetError(gerror.New(public.LangCtx(ctx, "Invalid priority: {}", req.Priority)))
+		return res, nil
+	}
+
 	// 3. check recipient
 	if req.Recipient == "" || !strings.Contains(req.Recipient, "@") {
 		res.Code = 1003
@@ -89,7 +98,7 @@ func (c *ControllerV1) ApiMailSend(ctx context.Context, req *v1.ApiMailSendReq)
 	}
 
 	// 6. Join the sender queue
-	err = recordApiMailLog(ctx, apiTemplate, req.Recipient, req.Addresser, req.Attribs)
+	err = recordApiMailLog(ctx, apiTemplate, req.Recipient, req.Addresser, req.Attribs, priority)
 	if err != nil {
 		res.Code = 1005
 		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to record email log: {}", err.Error())))
@@ -101,7 +110,7 @@ func (c *ControllerV1) ApiMailSend(ctx context.Context, req *v1.ApiMailSendReq)
 }
 
 // 记录到日志表，状态为待发送
-func recordApiMailLog(ctx context.Context, apiTemplate *entity.ApiTemplates, recipient, addresser string, attribs map[string]string) error {
+func recordApiMailLog(ctx context.Context, apiTemplate *entity.ApiTemplates, recipient, addresser string, attribs map[string]string, priority int) error {
 	// 生成消息ID
 
 	sender, err := mail_service.NewEmailSenderWithLocal(addresser)
@@ -125,6 +134,7 @@ func recordApiMailLog(ctx context.Context, apiTemplate *entity.ApiTemplates, rec
 		"send_time":     0,
 		"create_time":   now,
 		"attribs":       attribs,
+		"priority":      priority,
 	})
 
 	return err
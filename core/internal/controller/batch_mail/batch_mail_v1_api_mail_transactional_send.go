@@ -0,0 +1,94 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/contact"
+	"billionmail-core/internal/service/public"
+	"context"
+	"strings"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) ApiMailTransactionalSend(ctx context.Context, req *v1.ApiMailTransactionalSendReq) (res *v1.ApiMailTransactionalSendRes, err error) {
+	res = &v1.ApiMailTransactionalSendRes{}
+	clientIP := g.RequestFromCtx(ctx).GetClientIp()
+
+	// 1. check API Key
+	apiTemplate, err := getApiTemplateByKey(ctx, req.ApiKey, clientIP)
+	if err != nil {
+		res.Code = 1001
+		res.SetError(gerror.New(public.LangCtx(ctx, err.Error())))
+		return res, nil
+	}
+
+	// 2. check client IP
+	err = CheckClientIP(ctx, apiTemplate.Id, clientIP)
+	if err != nil {
+		res.Code = 1002
+		res.SetError(gerror.New(public.LangCtx(ctx, err.Error())))
+		return res, nil
+	}
+
+	// 3. check recipients
+	if len(req.Recipients) == 0 {
+		res.Code = 1003
+		res.SetError(gerror.New(public.LangCtx(ctx, "Recipients cannot be empty")))
+		return res, nil
+	}
+
+	// 4. add the recipients to the contacts of the API, the batch renders their properties
+	recipients := make([]batch_mail.RecipientData, 0, len(req.Recipients))
+	for _, r := range req.Recipients {
+		if r == nil {
+			recipients = append(recipients, batch_mail.RecipientData{})
+			continue
+		}
+
+		recipient := strings.TrimSpace(r.Recipient)
+		if strings.Contains(recipient, "@") {
+			if apiTemplate.GroupId > 0 {
+				_, err = contact.AddContactToGroup(ctx, recipient, apiTemplate.GroupId)
+			} else {
+				_, err = ensureContactAndGroup(ctx, recipient, apiTemplate.Id)
+			}
+			if err != nil {
+				g.Log().Warningf(ctx, "Failed to process recipient %s with API ID %d: %v", recipient, apiTemplate.Id, err)
+			}
+		}
+
+		recipients = append(recipients, batch_mail.RecipientData{Recipient: recipient, Headers: r.Headers, Attribs: r.Attribs})
+	}
+
+	// 5. send right away
+	batch, err := batch_mail.SendBatch(ctx, apiTemplate.Id, recipients)
+	if err != nil {
+		res.Code = 1004
+		res.SetError(gerror.New(public.LangCtx(ctx, "Send failed: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data.Results = make([]*v1.ApiMailRecipientResult, 0, len(batch.Results))
+	for _, r := range batch.Results {
+		res.Data.Results = append(res.Data.Results, &v1.ApiMailRecipientResult{
+			Recipient: r.Recipient,
+			Accepted:  r.Accepted,
+			MessageId: r.MessageId,
+			Error:     r.Error,
+		})
+	}
+	res.Data.Accepted = batch.Accepted
+	res.Data.Rejected = batch.Failed
+
+	if batch.Accepted == 0 {
+		res.Code = 1005
+		res.SetError(gerror.New(public.LangCtx(ctx, "No email was sent")))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "{} emails sent, {} failed", batch.Accepted, batch.Failed))
+	return res, nil
+}
@@ -0,0 +1,76 @@
+package batch_mail
+
+import (
+	"billionmail-core/api/batch_mail/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// exportFlushSize buffered bytes sent to the client at once while exporting
+const exportFlushSize = 256 << 10
+
+// streamWriter sends the response buffer to the client as it grows. The last chunk stays
+// buffered, so the response middleware does not write its own body after the export
+type streamWriter struct {
+	r       *ghttp.Request
+	flushed bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.r.Response.BufferLength() >= exportFlushSize {
+		s.r.Response.Flush()
+		s.flushed = true
+	}
+	return s.r.Response.BufferWriter.Write(p)
+}
+
+func (c *ControllerV1) ExportTaskResults(ctx context.Context, req *v1.ExportTaskResultsReq) (res *v1.ExportTaskResultsRes, err error) {
+	res = &v1.ExportTaskResultsRes{}
+
+	r := g.RequestFromCtx(ctx)
+	if r == nil {
+		return nil, gerror.New("Unable to obtain the request context")
+	}
+
+	contentType := "text/csv"
+	switch {
+	case strings.HasSuffix(req.Format, ".gz"):
+		contentType = "application/gzip"
+	case req.Format == batch_mail.CampaignResultsJSON:
+		contentType = "application/json"
+	}
+	fileName := fmt.Sprintf("task-%d-results.%s", req.TaskId, req.Format)
+
+	r.Response.Header().Set("Content-Type", contentType)
+	r.Response.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+
+	w := &streamWriter{r: r}
+	if err = batch_mail.ExportCampaignResults(ctx, req.TaskId, req.Format, w); err != nil {
+		g.Log().Errorf(ctx, "Failed to export the results of task %d: %v", req.TaskId, err)
+		if w.flushed {
+			// Part of the export is already sent, the client gets a truncated file
+			return nil, nil
+		}
+
+		r.Response.ClearBuffer()
+		r.Response.Header().Del("Content-Disposition")
+		r.Response.Header().Set("Content-Type", "application/json")
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to export the task results: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Task,
+		Log:  fmt.Sprintf("Export the results of task %d successfully", req.TaskId),
+	})
+
+	return
+}
@@ -0,0 +1,26 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) GetCircuitBreaker(ctx context.Context, req *v1.GetCircuitBreakerReq) (res *v1.GetCircuitBreakerRes, err error) {
+	res = &v1.GetCircuitBreakerRes{}
+
+	cfg := batch_mail.GetCircuitBreakerConfig(ctx)
+
+	res.Data = v1.CircuitBreaker{
+		Enabled:          cfg.Enabled,
+		MinSent:          cfg.MinSent,
+		WindowMinutes:    cfg.WindowMinutes,
+		MaxBounceRate:    cfg.MaxBounceRate,
+		MaxComplaintRate: cfg.MaxComplaintRate,
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
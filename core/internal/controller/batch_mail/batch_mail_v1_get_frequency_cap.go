@@ -0,0 +1,24 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) GetFrequencyCap(ctx context.Context, req *v1.GetFrequencyCapReq) (res *v1.GetFrequencyCapRes, err error) {
+	res = &v1.GetFrequencyCapRes{}
+
+	settings := batch_mail.GetFrequencyCapSettings(ctx)
+
+	res.Data.Default = v1.FrequencyCap{MaxSends: settings.Default.MaxSends, WindowDays: settings.Default.WindowDays}
+	res.Data.Lists = make(map[int]v1.FrequencyCap, len(settings.Lists))
+	for groupId, c := range settings.Lists {
+		res.Data.Lists[groupId] = v1.FrequencyCap{MaxSends: c.MaxSends, WindowDays: c.WindowDays}
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
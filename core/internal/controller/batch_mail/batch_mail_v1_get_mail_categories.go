@@ -0,0 +1,23 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) GetMailCategories(ctx context.Context, req *v1.GetMailCategoriesReq) (res *v1.GetMailCategoriesRes, err error) {
+	res = &v1.GetMailCategoriesRes{}
+
+	categories := batch_mail.GetMailCategories(ctx)
+
+	res.Data = make([]v1.MailCategory, 0, len(categories))
+	for _, category := range categories {
+		res.Data = append(res.Data, v1.MailCategory{Key: category.Key, Name: category.Name, Description: category.Description})
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
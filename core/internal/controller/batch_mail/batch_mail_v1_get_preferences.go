@@ -0,0 +1,41 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) GetPreferences(ctx context.Context, req *v1.GetPreferencesReq) (res *v1.GetPreferencesRes, err error) {
+	res = &v1.GetPreferencesRes{}
+
+	claims, err := batch_mail.ParseUnsubscribeJWT(req.Jwt)
+	if err != nil || claims.Email == "" {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Invalid token")))
+		return res, nil
+	}
+
+	preferences, err := batch_mail.GetPreferences(ctx, claims.Email)
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to get the preferences of %s: %v", claims.Email, err)
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the preferences")))
+		return res, nil
+	}
+
+	res.Data.Email = claims.Email
+	res.Data.Preferences = make([]v1.CategoryPreference, 0, len(preferences))
+	for _, p := range preferences {
+		res.Data.Preferences = append(res.Data.Preferences, v1.CategoryPreference{
+			MailCategory: v1.MailCategory{Key: p.Key, Name: p.Name, Description: p.Description},
+			Subscribed:   p.Subscribed,
+		})
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
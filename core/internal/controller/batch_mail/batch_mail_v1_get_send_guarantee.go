@@ -0,0 +1,18 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) GetSendGuarantee(ctx context.Context, req *v1.GetSendGuaranteeReq) (res *v1.GetSendGuaranteeRes, err error) {
+	res = &v1.GetSendGuaranteeRes{}
+
+	res.Data.Guarantee = string(batch_mail.GetSendGuarantee(ctx))
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
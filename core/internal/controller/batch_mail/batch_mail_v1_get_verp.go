@@ -0,0 +1,27 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) GetVERP(ctx context.Context, req *v1.GetVERPReq) (res *v1.GetVERPRes, err error) {
+	res = &v1.GetVERPRes{}
+
+	s := mail_service.GetVERPSettings(ctx)
+
+	res.Data = v1.VERP{
+		Enabled: s.Enabled,
+		Domain:  s.Domain,
+		Prefix:  s.Prefix,
+	}
+	if s.Domain != "" {
+		res.Data.BounceAddress = s.BounceAddress()
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -108,7 +108,8 @@ func (c *ControllerV1) ListTasks(ctx context.Context, req *v1.ListTasksReq) (res
 		detail.Deferred = task.DeferredCount
 		detail.ErrorCount = task.BouncedCount + task.DeferredCount
 
-		sentCount := detail.SentCount
+		// recipients skipped by the frequency cap or their preferences are done without a send
+		sentCount := detail.SentCount + task.CappedCount + task.OptedOutCount
 
 		if task.RecipientCount <= 0 {
 
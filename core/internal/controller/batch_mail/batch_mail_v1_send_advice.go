@@ -0,0 +1,25 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SendAdvice(ctx context.Context, req *v1.SendAdviceReq) (res *v1.SendAdviceRes, err error) {
+	res = &v1.SendAdviceRes{}
+
+	advice, err := batch_mail.CampaignSendAdvice(ctx, req.GroupId, req.PlannedVolume)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the send advice: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = advice
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
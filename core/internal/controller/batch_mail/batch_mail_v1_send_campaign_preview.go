@@ -0,0 +1,32 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SendCampaignPreview(ctx context.Context, req *v1.SendCampaignPreviewReq) (res *v1.SendCampaignPreviewRes, err error) {
+	res = &v1.SendCampaignPreviewRes{}
+
+	messageId, err := batch_mail.SendCampaignPreview(ctx, req.TaskId, req.Recipient, req.SampleData)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "send email to {} failed: {}", req.Recipient, err)))
+		return res, nil
+	}
+	res.Data.MessageId = messageId
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Task,
+		Log:  fmt.Sprintf("Send preview of task %d to %s successfully", req.TaskId, req.Recipient),
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "send email successfully"))
+	return
+}
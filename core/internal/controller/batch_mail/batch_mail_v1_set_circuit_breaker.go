@@ -0,0 +1,38 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SetCircuitBreaker(ctx context.Context, req *v1.SetCircuitBreakerReq) (res *v1.SetCircuitBreakerRes, err error) {
+	res = &v1.SetCircuitBreakerRes{}
+
+	cfg := batch_mail.CircuitBreakerConfig{
+		Enabled:          req.Enabled,
+		MinSent:          req.MinSent,
+		WindowMinutes:    req.WindowMinutes,
+		MaxBounceRate:    req.MaxBounceRate,
+		MaxComplaintRate: req.MaxComplaintRate,
+	}
+
+	if err = batch_mail.SetCircuitBreakerConfig(ctx, cfg); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save the circuit breaker: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Task,
+		Log:  fmt.Sprintf("Campaign circuit breaker set: enabled %t, bounce rate %g%%, complaint rate %g%% after %d messages in %d minutes", cfg.Enabled, cfg.MaxBounceRate, cfg.MaxComplaintRate, cfg.MinSent, cfg.WindowMinutes),
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Circuit breaker saved"))
+	return res, nil
+}
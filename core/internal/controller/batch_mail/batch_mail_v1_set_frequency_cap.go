@@ -0,0 +1,28 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SetFrequencyCap(ctx context.Context, req *v1.SetFrequencyCapReq) (res *v1.SetFrequencyCapRes, err error) {
+	res = &v1.SetFrequencyCapRes{}
+
+	var frequencyCap *batch_mail.FrequencyCap
+	if !req.Inherit || req.GroupId == 0 {
+		frequencyCap = &batch_mail.FrequencyCap{MaxSends: req.MaxSends, WindowDays: req.WindowDays}
+	}
+
+	if err = batch_mail.SetFrequencyCap(ctx, req.GroupId, frequencyCap); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save the frequency cap: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Frequency cap saved"))
+	return res, nil
+}
@@ -0,0 +1,28 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SetMailCategories(ctx context.Context, req *v1.SetMailCategoriesReq) (res *v1.SetMailCategoriesRes, err error) {
+	res = &v1.SetMailCategoriesRes{}
+
+	categories := make([]batch_mail.MailCategory, 0, len(req.Categories))
+	for _, category := range req.Categories {
+		categories = append(categories, batch_mail.MailCategory{Key: category.Key, Name: category.Name, Description: category.Description})
+	}
+
+	if err = batch_mail.SetMailCategories(ctx, categories); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save the mail categories: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Mail categories saved"))
+	return res, nil
+}
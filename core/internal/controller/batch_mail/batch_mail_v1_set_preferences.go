@@ -0,0 +1,38 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/contact_activity"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SetPreferences(ctx context.Context, req *v1.SetPreferencesReq) (res *v1.SetPreferencesRes, err error) {
+	res = &v1.SetPreferencesRes{}
+
+	claims, err := batch_mail.ParseUnsubscribeJWT(req.Jwt)
+	if err != nil || claims.Email == "" {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Invalid token")))
+		return res, nil
+	}
+
+	if err = batch_mail.SetPreferences(ctx, claims.Email, req.Preferences); err != nil {
+		g.Log().Errorf(ctx, "Failed to save the preferences of %s: %v", claims.Email, err)
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save the preferences: {}", err.Error())))
+		return res, nil
+	}
+
+	if claims.GroupId > 0 {
+		contact_activity.UpdateActivityByEmailAndGroup(claims.Email, claims.GroupId)
+	}
+
+	g.Log().Infof(ctx, "Preferences of %s updated: %v", claims.Email, req.Preferences)
+
+	res.SetSuccess(public.LangCtx(ctx, "Your preferences have been saved"))
+	return res, nil
+}
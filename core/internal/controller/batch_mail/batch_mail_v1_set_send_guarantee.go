@@ -0,0 +1,29 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SetSendGuarantee(ctx context.Context, req *v1.SetSendGuaranteeReq) (res *v1.SetSendGuaranteeRes, err error) {
+	res = &v1.SetSendGuaranteeRes{}
+
+	if err = batch_mail.SetSendGuarantee(ctx, batch_mail.SendGuarantee(req.Guarantee)); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save the send guarantee: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Task,
+		Log:  "Campaign send guarantee after a crash set to " + req.Guarantee,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Send guarantee saved"))
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SetTaskABTest(ctx context.Context, req *v1.SetTaskABTestReq) (res *v1.SetTaskABTestRes, err error) {
+	res = &v1.SetTaskABTestRes{}
+
+	err = batch_mail.SetABTest(ctx, req.TaskId, batch_mail.ABTestConfig{
+		Subjects:     req.Subjects,
+		TestFraction: req.TestFraction,
+		Metric:       req.Metric,
+		WaitSeconds:  req.WaitSeconds,
+	})
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the A/B test: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Task,
+		Log:  fmt.Sprintf("Set the A/B test of task %d: %d subjects", req.TaskId, len(req.Subjects)),
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,38 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) SetVERP(ctx context.Context, req *v1.SetVERPReq) (res *v1.SetVERPRes, err error) {
+	res = &v1.SetVERPRes{}
+
+	s := mail_service.VERPSettings{
+		Enabled: req.Enabled,
+		Domain:  req.Domain,
+		Prefix:  req.Prefix,
+	}
+
+	if err = batch_mail.SetVERPSettings(ctx, s); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save the VERP settings: {}", err.Error())))
+		return res, nil
+	}
+
+	s = mail_service.GetVERPSettings(ctx)
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Task,
+		Log:  fmt.Sprintf("Campaign VERP return path set: enabled %t, bounce address %s", s.Enabled, s.BounceAddress()),
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "VERP settings saved"))
+	return res, nil
+}
@@ -0,0 +1,57 @@
+package batch_mail
+
+import (
+	"billionmail-core/api/batch_mail/v1"
+	"billionmail-core/internal/service/maillog_stat"
+	"billionmail-core/internal/service/public"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+func (c *ControllerV1) TaskLinkStats(ctx context.Context, req *v1.TaskLinkStatsReq) (res *v1.TaskLinkStatsRes, err error) {
+	res = &v1.TaskLinkStatsRes{}
+
+	stats, err := maillog_stat.CampaignLinkStats(ctx, req.TaskId)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the link statistics: {}", err.Error())))
+		return res, nil
+	}
+
+	if req.Format != "csv" {
+		res.Data = stats
+		res.SetSuccess(public.LangCtx(ctx, "Success"))
+		return res, nil
+	}
+
+	r := g.RequestFromCtx(ctx)
+	if r == nil {
+		return nil, gerror.New("Unable to obtain the request context")
+	}
+
+	r.Response.Header().Set("Content-Type", "text/csv")
+	r.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=task-%d-links.csv", req.TaskId))
+
+	// One row per link position, the heatmap of the message content
+	w := csv.NewWriter(r.Response.BufferWriter)
+	_ = w.Write([]string{"url", "link", "clicks", "unique_clickers", "url_clicks", "url_unique_clickers"})
+	for _, stat := range stats {
+		for _, position := range stat.Positions {
+			_ = w.Write([]string{
+				stat.Url,
+				strconv.Itoa(position.Link),
+				strconv.Itoa(position.Clicks),
+				strconv.Itoa(position.UniqueClickers),
+				strconv.Itoa(stat.Clicks),
+				strconv.Itoa(stat.UniqueClickers),
+			})
+		}
+	}
+	w.Flush()
+
+	return nil, w.Error()
+}
@@ -0,0 +1,21 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/batch_mail/v1"
+)
+
+func (c *ControllerV1) TaskLiveStream(ctx context.Context, req *v1.TaskLiveStreamReq) (res *v1.TaskLiveStreamRes, err error) {
+	err = batch_mail.StreamCampaignEvents(ctx, req.TaskId, req.LastEventId)
+	if err != nil {
+		res = &v1.TaskLiveStreamRes{}
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to stream the task progress: {}", err.Error())))
+		return res, nil
+	}
+	return
+}
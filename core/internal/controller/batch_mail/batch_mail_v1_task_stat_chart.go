@@ -40,6 +40,16 @@ func (c *ControllerV1) TaskStatChart(ctx context.Context, req *v1.TaskStatChartR
 		return
 	}
 
+	abTest, err := batch_mail.GetABTest(ctx, taskInfo.Id)
+	if err != nil {
+		res.Code = 500
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the A/B test: {}", err.Error())))
+		return
+	}
+	if abTest != nil {
+		res.Data.AbTest = abTest
+	}
+
 	//statService := batch_mail.NewTaskStatService()
 	//
 	//chartData := statService.GetTaskStatChart(req.TaskId, req.Domain, req.StartTime, req.EndTime)
@@ -5,6 +5,7 @@ import (
 	"billionmail-core/internal/service/batch_mail"
 	"billionmail-core/internal/service/public"
 	"context"
+	"strings"
 
 	"github.com/gogf/gf/v2/errors/gerror"
 	"github.com/gogf/gf/v2/frame/g"
@@ -17,13 +18,16 @@ func (c *ControllerV1) UpdateTaskInfo(ctx context.Context, req *v1.UpdateTaskInf
 	res = &v1.UpdateTaskInfoRes{}
 
 	var task struct {
-		Id          int `json:"id"`
-		TaskProcess int `json:"task_process"`
-		Pause       int `json:"pause"`
+		Id            int    `json:"id"`
+		TaskProcess   int    `json:"task_process"`
+		Pause         int    `json:"pause"`
+		Addresser     string `json:"addresser"`
+		SendingDomain string `json:"sending_domain"`
+		SendingIp     string `json:"sending_ip"`
 	}
 
 	err = g.DB().Model("email_tasks").
-		Fields("id, task_process, pause").
+		Fields("id, task_process, pause, addresser, sending_domain, sending_ip").
 		Where("id", req.TaskId).
 		Scan(&task)
 
@@ -66,6 +70,8 @@ func (c *ControllerV1) UpdateTaskInfo(ctx context.Context, req *v1.UpdateTaskInf
 	}
 	if req.TemplateId > 0 {
 		updateData["template_id"] = req.TemplateId
+		// The template chosen again is pinned at its current version on resume
+		updateData["template_version"] = 0
 	}
 	if req.Threads > 0 {
 		updateData["threads"] = req.Threads
@@ -80,6 +86,37 @@ func (c *ControllerV1) UpdateTaskInfo(ctx context.Context, req *v1.UpdateTaskInf
 	if req.TagLogic == "AND" || req.TagLogic == "OR" || req.TagLogic == "NOT" {
 		updateData["tag_logic"] = req.TagLogic
 	}
+	if req.Uncategorized {
+		updateData["category"] = ""
+	} else if req.Category != "" {
+		if !batch_mail.IsMailCategory(ctx, req.Category) {
+			res.SetError(gerror.New(public.LangCtx(ctx, "Unknown mail category {}", req.Category)))
+			return
+		}
+		updateData["category"] = req.Category
+	}
+	if req.DefaultRoute {
+		updateData["sending_domain"] = ""
+		updateData["sending_ip"] = ""
+	} else if req.SendingDomain != "" || req.SendingIp != "" || req.Addresser != "" {
+		// The identity is validated again against the addresser it is sent with
+		addresser, domain, ip := task.Addresser, task.SendingDomain, task.SendingIp
+		if req.Addresser != "" {
+			addresser = req.Addresser
+		}
+		if req.SendingDomain != "" {
+			domain = strings.ToLower(strings.TrimSpace(req.SendingDomain))
+			updateData["sending_domain"] = domain
+		}
+		if req.SendingIp != "" {
+			ip = strings.TrimSpace(req.SendingIp)
+			updateData["sending_ip"] = ip
+		}
+		if _, err = batch_mail.ResolveSendingIdentity(ctx, addresser, domain, ip); err != nil {
+			res.SetError(err)
+			return
+		}
+	}
 	if len(updateData) == 0 {
 		res.SetError(gerror.New(public.LangCtx(ctx, "No valid update fields")))
 		return
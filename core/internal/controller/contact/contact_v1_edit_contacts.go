@@ -20,6 +20,14 @@ import (
 func (c *ControllerV1) EditContacts(ctx context.Context, req *v1.EditContactsReq) (res *v1.EditContactsRes, err error) {
 	res = &v1.EditContactsRes{}
 
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			res.Code = 400
+			res.SetError(gerror.New(public.LangCtx(ctx, "Invalid time zone {}", req.Timezone)))
+			return res, nil
+		}
+	}
+
 	var attribs map[string]string
 	if req.Attribs != "" {
 
@@ -73,8 +81,9 @@ func (c *ControllerV1) EditContacts(ctx context.Context, req *v1.EditContactsReq
 		}
 
 		var existingContact struct {
-			Attribs map[string]string `json:"attribs"`
-			Status  int               `json:"status"`
+			Attribs  map[string]string `json:"attribs"`
+			Status   int               `json:"status"`
+			Timezone string            `json:"timezone"`
 		}
 		err := g.DB().Model("bm_contacts").
 			Where("email", req.Emails).
@@ -94,6 +103,11 @@ func (c *ControllerV1) EditContacts(ctx context.Context, req *v1.EditContactsReq
 			finalAttribs = make(map[string]string)
 		}
 
+		timezone := existingContact.Timezone
+		if req.Timezone != "" {
+			timezone = req.Timezone
+		}
+
 		// 3. If group relations need to be updatedrelations need to be updated
 		if len(req.GroupIds) > 0 {
 			// Delete old contact recordsd contact records
@@ -116,6 +130,7 @@ func (c *ControllerV1) EditContacts(ctx context.Context, req *v1.EditContactsReq
 					"create_time": now,
 					"attribs":     finalAttribs,
 					"status":      existingContact.Status,
+					"timezone":    timezone,
 				})
 			}
 
@@ -127,6 +142,14 @@ func (c *ControllerV1) EditContacts(ctx context.Context, req *v1.EditContactsReq
 					return gerror.New(public.LangCtx(ctx, "Failed to create new contact records"))
 				}
 			}
+		} else if req.Timezone != "" {
+			_, err := g.DB().Model("bm_contacts").
+				Where("email", req.Emails).
+				Data(g.Map{"timezone": req.Timezone}).
+				Update()
+			if err != nil {
+				return gerror.New(public.LangCtx(ctx, "Failed to update the contact time zone"))
+			}
 		}
 
 		return nil
@@ -143,6 +166,7 @@ func (c *ControllerV1) EditContacts(ctx context.Context, req *v1.EditContactsReq
 		"group_ids": req.GroupIds,
 		"active":    req.Active,
 		"attribs":   attribs,
+		"timezone":  req.Timezone,
 	}
 
 	_ = public.WriteLog(ctx, public.LogParams{
@@ -12,6 +12,7 @@ import (
 	"github.com/gogf/gf/v2/errors/gerror"
 	"github.com/gogf/gf/v2/frame/g"
 	"strings"
+	"time"
 )
 
 func (c *ControllerV1) EditContactsNDP(ctx context.Context, req *v1.EditContactsNDPReq) (res *v1.EditContactsNDPRes, err error) {
@@ -22,6 +23,13 @@ func (c *ControllerV1) EditContactsNDP(ctx context.Context, req *v1.EditContacts
 		return
 	}
 
+	if req.Timezone != "" {
+		if _, err = time.LoadLocation(req.Timezone); err != nil {
+			res.SetError(gerror.New(public.LangCtx(ctx, "Invalid time zone {}", req.Timezone)))
+			return
+		}
+	}
+
 	var attribs map[string]string
 	if req.Attribs != "" {
 		attribsStr := strings.Trim(req.Attribs, "\"")
@@ -72,6 +80,9 @@ func (c *ControllerV1) EditContactsNDP(ctx context.Context, req *v1.EditContacts
 		if attribs != nil {
 			updateData["attribs"] = attribs
 		}
+		if req.Timezone != "" {
+			updateData["timezone"] = req.Timezone
+		}
 		if len(updateData) == 0 {
 			return gerror.New(public.LangCtx(ctx, "No valid fields to update"))
 		}
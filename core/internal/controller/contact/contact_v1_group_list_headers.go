@@ -0,0 +1,66 @@
+package contact
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/contact"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/contact/v1"
+)
+
+func (c *ControllerV1) GetGroupListHeaders(ctx context.Context, req *v1.GetGroupListHeadersReq) (res *v1.GetGroupListHeadersRes, err error) {
+	res = &v1.GetGroupListHeadersRes{}
+
+	group, err := contact.GetGroup(ctx, req.GroupId)
+	if err != nil || group.Id == 0 {
+		res.Code = 404
+		res.SetError(gerror.New(public.LangCtx(ctx, "Group not found")))
+		return res, nil
+	}
+
+	cfg := contact.GetListHeaderConfig(ctx, group.Id)
+	res.Data = v1.ListHeaderConfig{
+		Enabled:       cfg.Enabled,
+		ListId:        cfg.ListId,
+		Precedence:    cfg.Precedence,
+		AutoSubmitted: cfg.AutoSubmitted,
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
+
+func (c *ControllerV1) SetGroupListHeaders(ctx context.Context, req *v1.SetGroupListHeadersReq) (res *v1.SetGroupListHeadersRes, err error) {
+	res = &v1.SetGroupListHeadersRes{}
+
+	group, err := contact.GetGroup(ctx, req.GroupId)
+	if err != nil || group.Id == 0 {
+		res.Code = 404
+		res.SetError(gerror.New(public.LangCtx(ctx, "Group not found")))
+		return res, nil
+	}
+
+	err = contact.SetListHeaderConfig(ctx, group.Id, contact.ListHeaderConfig{
+		Enabled:       req.Enabled,
+		ListId:        req.ListId,
+		Precedence:    req.Precedence,
+		AutoSubmitted: req.AutoSubmitted,
+	})
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to update group: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.ContactsGroup,
+		Log:  fmt.Sprintf("Update the mailing-list headers for group: %s", group.Name),
+		Data: req.ListHeaderConfig,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Group updated successfully"))
+	return
+}
@@ -48,6 +48,7 @@ func (c *ControllerV1) ListContacts(ctx context.Context, req *v1.ListContactsReq
 				Groups:     make([]v1.GroupInfo, 0),
 				Status:     contactOne.Status,
 				Attribs:    contactOne.Attribs,
+				Timezone:   contactOne.Timezone,
 			}
 			emailMap[contactOne.Email] = contactInfo
 			apiList = append(apiList, contactInfo)
@@ -2,6 +2,7 @@ package domains
 
 import (
 	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/dns_resolver"
 	"billionmail-core/internal/service/domains"
 	"billionmail-core/internal/service/public"
 	"context"
@@ -12,6 +13,8 @@ import (
 func (c *ControllerV1) FreshDNSRecords(ctx context.Context, req *v1.FreshDNSRecordsReq) (res *v1.FreshDNSRecordsRes, err error) {
 	res = &v1.FreshDNSRecordsRes{}
 
+	// The records were likely just changed, do not check them against the cache
+	dns_resolver.Default().Forget(req.Domain)
 	domains.FreshRecords(ctx, req.Domain)
 
 	res.Data = domains.GetRecordsInCache(req.Domain)
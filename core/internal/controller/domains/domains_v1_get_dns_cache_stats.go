@@ -0,0 +1,26 @@
+package domains
+
+import (
+	"billionmail-core/internal/service/dns_resolver"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/domains/v1"
+)
+
+func (c *ControllerV1) GetDNSCacheStats(ctx context.Context, req *v1.GetDNSCacheStatsReq) (res *v1.GetDNSCacheStatsRes, err error) {
+	res = &v1.GetDNSCacheStatsRes{}
+
+	stats := dns_resolver.Default().Stats()
+	res.Data = v1.DNSCacheStats{
+		Hits:         stats.Hits,
+		NegativeHits: stats.NegativeHits,
+		Misses:       stats.Misses,
+		Shared:       stats.Shared,
+		Failures:     stats.Failures,
+		Entries:      stats.Entries,
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
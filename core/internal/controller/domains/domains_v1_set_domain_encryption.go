@@ -0,0 +1,23 @@
+package domains
+
+import (
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/domains/v1"
+)
+
+func (c *ControllerV1) SetDomainEncryption(ctx context.Context, req *v1.SetDomainEncryptionReq) (res *v1.SetDomainEncryptionRes, err error) {
+	res = &v1.SetDomainEncryptionRes{}
+
+	if err = mail_boxes.SetDomainEncryption(ctx, req.Domain, req.Enabled == 1); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the encryption of the domain: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,32 @@
+package domains
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/multi_ip_domain"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/domains/v1"
+)
+
+func (c *ControllerV1) SetMultiIPHeloName(ctx context.Context, req *v1.SetMultiIPHeloNameReq) (res *v1.SetMultiIPHeloNameRes, err error) {
+	res = &v1.SetMultiIPHeloNameRes{}
+
+	if err = multi_ip_domain.SetHeloName(ctx, req.OutboundIP, req.HeloName); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save the HELO name: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data.Warnings = multi_ip_domain.ValidateHeloNames(ctx)
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Domain,
+		Log:  "Set the HELO name of outbound IP " + req.OutboundIP + " to " + req.HeloName,
+		Data: req,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "HELO name saved, apply the multi-IP configuration to take effect"))
+	return res, nil
+}
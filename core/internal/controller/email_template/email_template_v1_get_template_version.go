@@ -0,0 +1,31 @@
+package email_template
+
+import (
+	"billionmail-core/internal/service/email_template"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/email_template/v1"
+)
+
+func (c *ControllerV1) GetTemplateVersion(ctx context.Context, req *v1.GetTemplateVersionReq) (res *v1.GetTemplateVersionRes, err error) {
+	res = &v1.GetTemplateVersionRes{}
+
+	version, err := email_template.GetTemplateVersion(ctx, req.Id, req.Version)
+	if err != nil {
+		res.Code = 500
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get template version {}", err.Error())))
+		return res, nil
+	}
+	if version == nil {
+		res.Code = 404
+		res.SetError(gerror.New(public.LangCtx(ctx, "Template version not found")))
+		return res, nil
+	}
+
+	res.Data = version
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
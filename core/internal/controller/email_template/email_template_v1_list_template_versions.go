@@ -0,0 +1,26 @@
+package email_template
+
+import (
+	"billionmail-core/internal/service/email_template"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/email_template/v1"
+)
+
+func (c *ControllerV1) ListTemplateVersions(ctx context.Context, req *v1.ListTemplateVersionsReq) (res *v1.ListTemplateVersionsRes, err error) {
+	res = &v1.ListTemplateVersionsRes{}
+
+	versions, err := email_template.ListTemplateVersions(ctx, req.Id)
+	if err != nil {
+		res.Code = 500
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get template versions {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = versions
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
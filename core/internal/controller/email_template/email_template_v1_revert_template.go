@@ -0,0 +1,46 @@
+package email_template
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/email_template"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/email_template/v1"
+)
+
+func (c *ControllerV1) RevertTemplate(ctx context.Context, req *v1.RevertTemplateReq) (res *v1.RevertTemplateRes, err error) {
+	res = &v1.RevertTemplateRes{}
+
+	template, err := email_template.GetTemplate(ctx, req.Id)
+	if err != nil {
+		res.Code = 500
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get template")))
+		return res, nil
+	}
+	if template == nil {
+		res.Code = 400
+		res.SetError(gerror.New(public.LangCtx(ctx, "Template not found")))
+		return res, nil
+	}
+
+	version, err := email_template.RevertTemplate(ctx, req.Id, req.Version)
+	if err != nil {
+		res.Code = 500
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to revert template {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Template,
+		Log:  fmt.Sprintf("Revert template :%s to version %d successfully", template.TempName, req.Version),
+		Data: req,
+	})
+
+	res.Data.Version = version
+	res.SetSuccess(public.LangCtx(ctx, "Template reverted successfully"))
+	return
+}
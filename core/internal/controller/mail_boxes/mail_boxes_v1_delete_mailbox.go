@@ -7,6 +7,7 @@ import (
 	"fmt"
 
 	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
 
 	"billionmail-core/api/mail_boxes/v1"
 	"billionmail-core/internal/service/mail_boxes"
@@ -30,6 +31,25 @@ func (c *ControllerV1) DeleteMailbox(ctx context.Context, req *v1.DeleteMailboxR
 		return nil, gerror.New("No valid email addresses provided")
 	}
 
+	// Soft deleted unless the grace period is disabled, the mailboxes can be restored meanwhile
+	if mail_boxes.GetMailboxDeletionPolicy(ctx).GraceDays > 0 {
+		var affected int
+		for _, email := range validEmails {
+			if err := mail_boxes.DisableMailbox(ctx, email); err != nil {
+				g.Log().Warningf(ctx, "Failed to delete the mailbox %s: %v", email, err)
+				continue
+			}
+			affected++
+		}
+
+		if affected == 0 {
+			res.SetSuccess("No mailboxes were deleted (they may not exist)")
+		} else {
+			res.SetSuccess(fmt.Sprintf("Successfully deleted %d mailbox(es), restorable during the grace period", affected))
+		}
+		return
+	}
+
 	affected, err := mail_boxes.DeleteBatch(ctx, validEmails)
 	if err != nil {
 		return nil, err
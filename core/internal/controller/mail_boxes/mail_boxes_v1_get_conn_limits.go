@@ -0,0 +1,19 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+)
+
+func (c *ControllerV1) GetConnLimits(ctx context.Context, req *v1.GetConnLimitsReq) (res *v1.GetConnLimitsRes, err error) {
+	res = &v1.GetConnLimitsRes{}
+
+	limits := mail_boxes.GetConnLimits(ctx)
+	res.Data.MaxPerAccount = limits.MaxPerAccount
+	res.Data.MaxPerIP = limits.MaxPerIP
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,23 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) GetDeletedMailboxes(ctx context.Context, req *v1.GetDeletedMailboxesReq) (res *v1.GetDeletedMailboxesRes, err error) {
+	res = &v1.GetDeletedMailboxesRes{}
+
+	res.Data, err = mail_boxes.DeletedMailboxes(ctx, req.Domain)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the deleted mailboxes: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,34 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) GetFolderACLs(ctx context.Context, req *v1.GetFolderACLsReq) (res *v1.GetFolderACLsRes, err error) {
+	res = &v1.GetFolderACLsRes{}
+
+	acls, err := mail_boxes.GetFolderACLs(ctx, req.Username)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the folder rights: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = make([]v1.FolderACL, 0, len(acls))
+	for _, acl := range acls {
+		res.Data = append(res.Data, v1.FolderACL{
+			Owner:      acl.Owner,
+			Folder:     acl.Folder,
+			Grantee:    acl.Grantee,
+			Rights:     acl.Rights,
+			UpdateTime: acl.UpdateTime,
+		})
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,34 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) GetMailboxSessions(ctx context.Context, req *v1.GetMailboxSessionsReq) (res *v1.GetMailboxSessionsRes, err error) {
+	res = &v1.GetMailboxSessionsRes{}
+
+	sessions, err := mail_boxes.GetMailboxSessions(ctx)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the mailbox sessions: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = make([]v1.MailboxSessions, 0, len(sessions))
+	for _, s := range sessions {
+		res.Data = append(res.Data, v1.MailboxSessions{
+			Username:   s.Username,
+			Sessions:   s.Sessions,
+			ByProtocol: s.ByProtocol,
+			IPs:        s.IPs,
+			Limit:      s.Limit,
+		})
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
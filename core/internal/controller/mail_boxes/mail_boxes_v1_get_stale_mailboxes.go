@@ -0,0 +1,33 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) GetStaleMailboxes(ctx context.Context, req *v1.GetStaleMailboxesReq) (res *v1.GetStaleMailboxesRes, err error) {
+	res = &v1.GetStaleMailboxesRes{}
+
+	mailboxes, err := mail_boxes.StaleMailboxes(ctx, time.Duration(req.InactiveDays)*24*time.Hour)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the stale mailboxes: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = make([]v1.Mailbox, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		if req.Domain != "" && !strings.EqualFold(mailbox.Domain, req.Domain) {
+			continue
+		}
+		res.Data = append(res.Data, mailbox)
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,22 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) RestoreMailbox(ctx context.Context, req *v1.RestoreMailboxReq) (res *v1.RestoreMailboxRes, err error) {
+	res = &v1.RestoreMailboxRes{}
+
+	if err = mail_boxes.RestoreMailbox(ctx, req.Username); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to restore the mailbox: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,23 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) SetConnLimits(ctx context.Context, req *v1.SetConnLimitsReq) (res *v1.SetConnLimitsRes, err error) {
+	res = &v1.SetConnLimitsRes{}
+
+	err = mail_boxes.SetConnLimits(ctx, mail_boxes.ConnLimits{MaxPerAccount: req.MaxPerAccount, MaxPerIP: req.MaxPerIP})
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the session limits: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,22 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) SetFolderACL(ctx context.Context, req *v1.SetFolderACLReq) (res *v1.SetFolderACLRes, err error) {
+	res = &v1.SetFolderACLRes{}
+
+	if err = mail_boxes.SetFolderACL(ctx, req.Username, req.Folder, req.Grantee, req.Rights); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the folder rights: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
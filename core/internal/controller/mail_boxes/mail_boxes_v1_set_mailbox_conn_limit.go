@@ -0,0 +1,22 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) SetMailboxConnLimit(ctx context.Context, req *v1.SetMailboxConnLimitReq) (res *v1.SetMailboxConnLimitRes, err error) {
+	res = &v1.SetMailboxConnLimitRes{}
+
+	if err = mail_boxes.SetMailboxConnLimits(ctx, req.Username, req.MaxConnections); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the session limit of the mailbox: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
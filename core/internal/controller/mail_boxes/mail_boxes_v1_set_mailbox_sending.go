@@ -0,0 +1,22 @@
+package mail_boxes
+
+import (
+	"billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) SetMailboxSending(ctx context.Context, req *v1.SetMailboxSendingReq) (res *v1.SetMailboxSendingRes, err error) {
+	res = &v1.SetMailboxSendingRes{}
+
+	if err = mail_boxes.SetSendingEnabled(ctx, req.Username, req.Enabled == 1); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the sending of the mailbox: {}", err.Error())))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return
+}
@@ -0,0 +1,23 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) DisableMailTrace(ctx context.Context, req *v1.DisableMailTraceReq) (res *v1.DisableMailTraceRes, err error) {
+	res = &v1.DisableMailTraceRes{}
+
+	if !mail_service.DisableTrace(req.Id) {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Trace not found or already expired")))
+		return res, nil
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
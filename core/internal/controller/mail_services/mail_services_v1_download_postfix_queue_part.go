@@ -0,0 +1,39 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+	"mime"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) DownloadPostfixQueuePart(ctx context.Context, req *v1.DownloadPostfixQueuePartReq) (res *v1.DownloadPostfixQueuePartRes, err error) {
+	res = &v1.DownloadPostfixQueuePartRes{}
+
+	part, err := inbound.GetMessagePart(ctx, req.QueueID, req.Path)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the mail part: {}", err.Error())))
+		return res, nil
+	}
+
+	r := g.RequestFromCtx(ctx)
+	if r == nil {
+		return nil, gerror.New("Unable to obtain the request context")
+	}
+
+	fileName := part.Filename
+	if fileName == "" {
+		fileName = req.QueueID + "-" + req.Path + ".bin"
+	}
+
+	r.Response.Header().Set("Content-Type", "application/octet-stream")
+	r.Response.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	r.Response.Write(part.Data)
+
+	return
+}
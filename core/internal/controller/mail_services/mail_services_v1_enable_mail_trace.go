@@ -0,0 +1,48 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) EnableMailTrace(ctx context.Context, req *v1.EnableMailTraceReq) (res *v1.EnableMailTraceRes, err error) {
+	res = &v1.EnableMailTraceRes{}
+
+	trace, err := mail_service.EnableTrace(mail_service.TraceMatch{
+		Sender:    req.Sender,
+		Recipient: req.Recipient,
+		IP:        req.IP,
+	}, time.Duration(req.TTL)*time.Minute)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to enable the trace: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = toMailTrace(trace)
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.PostfixQueue,
+		Log:  "Enable mail trace " + trace.Id,
+		Data: trace.Match,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
+
+func toMailTrace(trace mail_service.ActiveTrace) v1.MailTrace {
+	return v1.MailTrace{
+		Id:        trace.Id,
+		Sender:    trace.Match.Sender,
+		Recipient: trace.Match.Recipient,
+		IP:        trace.Match.IP,
+		ExpiresAt: trace.ExpiresAt.Unix(),
+	}
+}
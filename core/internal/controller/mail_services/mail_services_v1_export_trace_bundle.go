@@ -0,0 +1,72 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/maillog_stat"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"mime"
+	"regexp"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+// bundleFlushSize buffered bytes sent to the client at once while exporting
+const bundleFlushSize = 256 << 10
+
+var bundleNameUnsafe = regexp.MustCompile(`[^0-9A-Za-z._-]+`)
+
+// bundleWriter sends the response buffer to the client as it grows. The last chunk stays
+// buffered, so the response middleware does not write its own body after the export
+type bundleWriter struct {
+	r       *ghttp.Request
+	flushed bool
+}
+
+func (s *bundleWriter) Write(p []byte) (int, error) {
+	if s.r.Response.BufferLength() >= bundleFlushSize {
+		s.r.Response.Flush()
+		s.flushed = true
+	}
+	return s.r.Response.BufferWriter.Write(p)
+}
+
+func (c *ControllerV1) ExportTraceBundle(ctx context.Context, req *v1.ExportTraceBundleReq) (res *v1.ExportTraceBundleRes, err error) {
+	res = &v1.ExportTraceBundleRes{}
+
+	r := g.RequestFromCtx(ctx)
+	if r == nil {
+		return nil, gerror.New("Unable to obtain the request context")
+	}
+
+	fileName := "trace-" + bundleNameUnsafe.ReplaceAllString(req.TraceID, "_") + ".zip"
+	r.Response.Header().Set("Content-Type", "application/zip")
+	r.Response.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+
+	w := &bundleWriter{r: r}
+	if err = maillog_stat.ExportTraceBundle(ctx, req.TraceID, w); err != nil {
+		g.Log().Errorf(ctx, "Failed to export the support bundle of %s: %v", req.TraceID, err)
+		if w.flushed {
+			// Part of the bundle is already sent, the client gets a truncated file
+			return nil, nil
+		}
+
+		r.Response.ClearBuffer()
+		r.Response.Header().Del("Content-Disposition")
+		r.Response.Header().Set("Content-Type", "application/json")
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to export the support bundle: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.PostfixQueue,
+		Log:  fmt.Sprintf("Export the support bundle of mail %s", req.TraceID),
+	})
+
+	return
+}
@@ -0,0 +1,34 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetInboundDisposition(ctx context.Context, req *v1.GetInboundDispositionReq) (res *v1.GetInboundDispositionRes, err error) {
+	res = &v1.GetInboundDispositionRes{}
+
+	cfg := inbound.GetDispositionConfig(ctx)
+
+	res.Data = v1.InboundDisposition{
+		Enabled:            cfg.Enabled,
+		TagScore:           cfg.TagScore,
+		QuarantineScore:    cfg.QuarantineScore,
+		RejectScore:        cfg.RejectScore,
+		SPFFailPenalty:     cfg.SPFFailPenalty,
+		SPFSoftfailPenalty: cfg.SPFSoftfailPenalty,
+		DKIMFailPenalty:    cfg.DKIMFailPenalty,
+		DMARCFailPenalty:   cfg.DMARCFailPenalty,
+		AcceptForged:       cfg.AcceptForged,
+		TrustedForwarders:  cfg.TrustedForwarders,
+	}
+	if res.Data.TrustedForwarders == nil {
+		res.Data.TrustedForwarders = []string{}
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,30 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetInboundDMARC(ctx context.Context, req *v1.GetInboundDMARCReq) (res *v1.GetInboundDMARCRes, err error) {
+	res = &v1.GetInboundDMARCRes{}
+
+	cfg := inbound.GetDMARCEnforcementConfig(ctx)
+
+	res.Data = v1.InboundDMARC{
+		Mode:              cfg.Mode,
+		DomainModes:       cfg.DomainModes,
+		TrustedForwarders: cfg.TrustedForwarders,
+	}
+	if res.Data.DomainModes == nil {
+		res.Data.DomainModes = map[string]string{}
+	}
+	if res.Data.TrustedForwarders == nil {
+		res.Data.TrustedForwarders = []string{}
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetInboundDuplicates(ctx context.Context, req *v1.GetInboundDuplicatesReq) (res *v1.GetInboundDuplicatesRes, err error) {
+	res = &v1.GetInboundDuplicatesRes{}
+
+	stats, err := inbound.GetDuplicateStats(ctx)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the duplicate deliveries: {}", err.Error())))
+		return res, nil
+	}
+
+	cfg := inbound.GetDuplicateSuppressionConfig(ctx)
+
+	res.Data = v1.InboundDuplicates{
+		Mailboxes:     cfg.Mailboxes,
+		WindowSeconds: cfg.WindowSeconds,
+		Suppressed:    stats.Suppressed,
+		Tracked:       stats.Tracked,
+	}
+	if res.Data.Mailboxes == nil {
+		res.Data.Mailboxes = []string{}
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
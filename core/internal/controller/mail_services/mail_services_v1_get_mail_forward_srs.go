@@ -0,0 +1,27 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetMailForwardSRS(ctx context.Context, req *v1.GetMailForwardSRSReq) (res *v1.GetMailForwardSRSRes, err error) {
+	res = &v1.GetMailForwardSRSRes{}
+
+	secrets, err := mail_service.GetSRSSecrets(ctx)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "get SRS secrets failed: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data.Domains = mail_service.GetSRSDomains(ctx)
+	res.Data.Secrets = len(secrets)
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
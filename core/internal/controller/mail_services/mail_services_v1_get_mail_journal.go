@@ -0,0 +1,32 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/journal"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetMailJournal(ctx context.Context, req *v1.GetMailJournalReq) (res *v1.GetMailJournalRes, err error) {
+	res = &v1.GetMailJournalRes{}
+
+	cfg := journal.GetJournalConfig(ctx)
+	res.Data = v1.MailJournal{
+		Enabled: cfg.Enabled,
+		Sender:  cfg.Sender,
+		Rules:   make([]v1.MailJournalRule, 0, len(cfg.Rules)),
+	}
+	for _, r := range cfg.Rules {
+		res.Data.Rules = append(res.Data.Rules, v1.MailJournalRule{
+			Domain:   r.Domain,
+			Inbound:  r.Inbound,
+			Outbound: r.Outbound,
+			Address:  r.Address,
+			Store:    r.Store,
+		})
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,22 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetMailTraceList(ctx context.Context, req *v1.GetMailTraceListReq) (res *v1.GetMailTraceListRes, err error) {
+	res = &v1.GetMailTraceListRes{}
+
+	traces := mail_service.ActiveTraces()
+	res.Data = make([]v1.MailTrace, 0, len(traces))
+	for _, trace := range traces {
+		res.Data = append(res.Data, toMailTrace(trace))
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,35 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/maillog_stat"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetPostfixQueueAttempts(ctx context.Context, req *v1.GetPostfixQueueAttemptsReq) (res *v1.GetPostfixQueueAttemptsRes, err error) {
+	res = &v1.GetPostfixQueueAttemptsRes{}
+
+	attempts, err := maillog_stat.QueueAttempts(ctx, req.QueueID)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get delivery attempts: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = make([]v1.QueueAttempt, 0, len(attempts))
+	for _, a := range attempts {
+		res.Data = append(res.Data, v1.QueueAttempt{
+			Time:  a.Time,
+			Relay: a.Relay,
+			Code:  a.Code,
+			Dsn:   a.Dsn,
+			Error: a.Error,
+		})
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
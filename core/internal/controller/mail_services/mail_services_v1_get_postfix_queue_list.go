@@ -1,10 +1,14 @@
 package mail_services
 
 import (
+	"billionmail-core/internal/service/batch_mail"
 	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/maillog_stat"
 	"billionmail-core/internal/service/public"
 	"context"
 	"strings"
+	"time"
 
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/errors/gerror"
@@ -85,6 +89,42 @@ func (c *ControllerV1) GetPostfixQueueList(ctx context.Context, req *v1.GetPostf
 			})
 		}
 	}
+
+	// Surface the last delivery attempt of each message
+	ids := make([]string, 0, len(list))
+	for _, item := range list {
+		ids = append(ids, item.QueueID)
+	}
+	if attempts, err := maillog_stat.LatestQueueAttempts(ctx, ids); err == nil {
+		policy := mail_service.GetRetryPolicy(ctx)
+		for i := range list {
+			if a, ok := attempts[list[i].QueueID]; ok {
+				list[i].Attempts = a.Attempts
+				list[i].LastAttempt = a.Last.Time
+				list[i].LastRelay = a.Last.Relay
+				list[i].LastCode = a.Last.Code
+				list[i].LastResponse = a.Last.Error
+			}
+
+			next, bounce := policy.NextAttempt(time.Unix(list[i].ArrivalTime, 0), time.Unix(list[i].LastAttempt, 0), list[i].Attempts)
+			if bounce {
+				list[i].BounceAt = next.Unix()
+			} else {
+				list[i].NextAttempt = next.Unix()
+			}
+		}
+	} else {
+		g.Log().Warning(ctx, "Failed to load the delivery attempts:", err)
+	}
+
+	if priorities, err := batch_mail.MessagePriorities(ctx, ids); err == nil {
+		for i := range list {
+			list[i].Priority = priorities[list[i].QueueID]
+		}
+	} else {
+		g.Log().Warning(ctx, "Failed to load the message priorities:", err)
+	}
+
 	res.Data.List = list
 	res.Data.Total = len(list)
 	res.SetSuccess(public.LangCtx(ctx, "get postfix queue list success"))
@@ -0,0 +1,30 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/smtp_policy"
+	"context"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetSenderRateUsage(ctx context.Context, req *v1.GetSenderRateUsageReq) (res *v1.GetSenderRateUsageRes, err error) {
+	res = &v1.GetSenderRateUsageRes{}
+
+	usage := smtp_policy.GetSenderRateUsage(ctx)
+	res.Data = make([]v1.SenderRateUsage, 0, len(usage))
+	for _, u := range usage {
+		res.Data = append(res.Data, v1.SenderRateUsage{
+			Sender:      u.Sender,
+			MsgsPerMin:  u.MsgsPerMin,
+			BytesPerMin: u.BytesPerMin,
+			MsgsUsed:    u.MsgsUsed,
+			BytesUsed:   u.BytesUsed,
+			Accepted:    u.Accepted,
+			Deferred:    u.Deferred,
+		})
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,30 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/smtp_policy"
+	"context"
+	"sort"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetSenderRates(ctx context.Context, req *v1.GetSenderRatesReq) (res *v1.GetSenderRatesRes, err error) {
+	res = &v1.GetSenderRatesRes{}
+
+	res.Data = make([]v1.SenderRate, 0)
+	for sender, rate := range smtp_policy.GetSenderRates(ctx) {
+		res.Data = append(res.Data, v1.SenderRate{
+			Sender:      sender,
+			MsgsPerMin:  rate.MsgsPerMin,
+			BytesPerMin: rate.BytesPerMin,
+		})
+	}
+
+	sort.Slice(res.Data, func(i, j int) bool {
+		return res.Data[i].Sender < res.Data[j].Sender
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
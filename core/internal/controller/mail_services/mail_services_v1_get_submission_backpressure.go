@@ -0,0 +1,28 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/smtp_policy"
+	"context"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) GetSubmissionBackpressure(ctx context.Context, req *v1.GetSubmissionBackpressureReq) (res *v1.GetSubmissionBackpressureRes, err error) {
+	res = &v1.GetSubmissionBackpressureRes{}
+
+	state := smtp_policy.GetBackpressureState(ctx)
+	res.Data = v1.SubmissionBackpressure{
+		MaxQueueDepth:    state.MaxQueueDepth,
+		ResumeQueueDepth: state.ResumeQueueDepth,
+		Engaged:          state.Engaged,
+		QueueDepth:       state.QueueDepth,
+		CheckedAt:        state.CheckedAt,
+		EngagedSince:     state.EngagedSince,
+		Deferred:         state.Deferred,
+		Error:            state.Error,
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
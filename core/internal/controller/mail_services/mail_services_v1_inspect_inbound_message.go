@@ -0,0 +1,36 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) InspectInboundMessage(ctx context.Context, req *v1.InspectInboundMessageReq) (res *v1.InspectInboundMessageRes, err error) {
+	res = &v1.InspectInboundMessageRes{}
+
+	results, err := inbound.GetDKIMResults(ctx, req.MessageId)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get DKIM results: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data.MessageId = req.MessageId
+	res.Data.Dkim = make([]v1.DKIMResult, 0, len(results))
+	for _, r := range results {
+		res.Data.Dkim = append(res.Data.Dkim, v1.DKIMResult{
+			Domain:    r.Domain,
+			Selector:  r.Selector,
+			Algorithm: r.Algorithm,
+			Result:    r.Result,
+			Reason:    r.Reason,
+		})
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
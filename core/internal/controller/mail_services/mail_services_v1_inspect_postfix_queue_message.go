@@ -0,0 +1,25 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) InspectPostfixQueueMessage(ctx context.Context, req *v1.InspectPostfixQueueMessageReq) (res *v1.InspectPostfixQueueMessageRes, err error) {
+	res = &v1.InspectPostfixQueueMessageRes{}
+
+	view, err := inbound.InspectMessage(ctx, req.QueueID)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to inspect the mail: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = view
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
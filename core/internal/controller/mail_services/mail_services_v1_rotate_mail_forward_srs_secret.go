@@ -0,0 +1,29 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) RotateMailForwardSRSSecret(ctx context.Context, req *v1.RotateMailForwardSRSSecretReq) (res *v1.RotateMailForwardSRSSecretRes, err error) {
+	res = &v1.RotateMailForwardSRSSecretRes{}
+
+	if _, err = mail_service.RotateSRSSecret(ctx); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "rotate SRS secret failed: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.MailForward,
+		Log:  "Rotate the SRS secret successfully",
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,44 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetInboundDisposition(ctx context.Context, req *v1.SetInboundDispositionReq) (res *v1.SetInboundDispositionRes, err error) {
+	res = &v1.SetInboundDispositionRes{}
+
+	cfg := inbound.DispositionConfig{
+		Enabled:            req.Enabled,
+		TagScore:           req.TagScore,
+		QuarantineScore:    req.QuarantineScore,
+		RejectScore:        req.RejectScore,
+		SPFFailPenalty:     req.SPFFailPenalty,
+		SPFSoftfailPenalty: req.SPFSoftfailPenalty,
+		DKIMFailPenalty:    req.DKIMFailPenalty,
+		DMARCFailPenalty:   req.DMARCFailPenalty,
+		AcceptForged:       req.AcceptForged,
+		TrustedForwarders:  req.TrustedForwarders,
+	}
+
+	if err = inbound.SetDispositionConfig(ctx, cfg); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the inbound disposition: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Service,
+		Log:  fmt.Sprintf("Set the inbound disposition: enabled %t, tag %g, quarantine %g, reject %g", req.Enabled, req.TagScore, req.QuarantineScore, req.RejectScore),
+		Data: cfg,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,37 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetInboundDMARC(ctx context.Context, req *v1.SetInboundDMARCReq) (res *v1.SetInboundDMARCRes, err error) {
+	res = &v1.SetInboundDMARCRes{}
+
+	cfg := inbound.DMARCEnforcementConfig{
+		Mode:              req.Mode,
+		DomainModes:       req.DomainModes,
+		TrustedForwarders: req.TrustedForwarders,
+	}
+
+	if err = inbound.SetDMARCEnforcementConfig(ctx, cfg); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the DMARC enforcement: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Service,
+		Log:  fmt.Sprintf("Set the DMARC enforcement of the inbound mail: mode %s, %d domain modes", req.Mode, len(req.DomainModes)),
+		Data: cfg,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetInboundDMARCDomainMode(ctx context.Context, req *v1.SetInboundDMARCDomainModeReq) (res *v1.SetInboundDMARCDomainModeRes, err error) {
+	res = &v1.SetInboundDMARCDomainModeRes{}
+
+	if err = inbound.SetDMARCDomainMode(ctx, req.Domain, req.Mode); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the DMARC enforcement of {}: {}", req.Domain, err.Error())))
+		return res, nil
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = "global"
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Service,
+		Log:  fmt.Sprintf("Set the DMARC enforcement of %s: %s", req.Domain, mode),
+		Data: req.Domain,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
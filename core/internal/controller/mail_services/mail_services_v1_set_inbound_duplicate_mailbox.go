@@ -0,0 +1,31 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetInboundDuplicateMailbox(ctx context.Context, req *v1.SetInboundDuplicateMailboxReq) (res *v1.SetInboundDuplicateMailboxRes, err error) {
+	res = &v1.SetInboundDuplicateMailboxRes{}
+
+	if err = inbound.SetDuplicateSuppression(ctx, req.Mailbox, req.Enabled); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the duplicate suppression of {}: {}", req.Mailbox, err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Service,
+		Log:  fmt.Sprintf("Set the duplicate suppression of %s: %t", req.Mailbox, req.Enabled),
+		Data: req.Mailbox,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetInboundDuplicates(ctx context.Context, req *v1.SetInboundDuplicatesReq) (res *v1.SetInboundDuplicatesRes, err error) {
+	res = &v1.SetInboundDuplicatesRes{}
+
+	cfg := inbound.DuplicateSuppressionConfig{
+		Mailboxes:     req.Mailboxes,
+		WindowSeconds: req.WindowSeconds,
+	}
+
+	if err = inbound.SetDuplicateSuppressionConfig(ctx, cfg); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the duplicate suppression: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Service,
+		Log:  fmt.Sprintf("Set the duplicate suppression: %d mailboxes, window %d seconds", len(req.Mailboxes), req.WindowSeconds),
+		Data: cfg,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,30 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetMailForwardSRS(ctx context.Context, req *v1.SetMailForwardSRSReq) (res *v1.SetMailForwardSRSRes, err error) {
+	res = &v1.SetMailForwardSRSRes{}
+
+	if err = mail_service.SetSRSDomain(ctx, req.Domain, req.Enabled); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "set SRS failed: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.MailForward,
+		Log:  fmt.Sprintf("Set SRS of %s: %t", req.Domain, req.Enabled),
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,46 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/journal"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetMailJournal(ctx context.Context, req *v1.SetMailJournalReq) (res *v1.SetMailJournalRes, err error) {
+	res = &v1.SetMailJournalRes{}
+
+	cfg := journal.JournalConfig{
+		Enabled: req.Enabled,
+		Sender:  req.Sender,
+		Rules:   make([]journal.JournalRule, 0, len(req.Rules)),
+	}
+	for _, r := range req.Rules {
+		cfg.Rules = append(cfg.Rules, journal.JournalRule{
+			Domain:   r.Domain,
+			Inbound:  r.Inbound,
+			Outbound: r.Outbound,
+			Address:  r.Address,
+			Store:    r.Store,
+		})
+	}
+
+	if err = journal.SetJournalConfig(ctx, cfg); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the mail journal: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.BCC,
+		Log:  fmt.Sprintf("Set mail journal: enabled %t, %d domains", cfg.Enabled, len(cfg.Rules)),
+		Data: cfg,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,30 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/smtp_policy"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetSenderRate(ctx context.Context, req *v1.SetSenderRateReq) (res *v1.SetSenderRateRes, err error) {
+	res = &v1.SetSenderRateRes{}
+
+	if err = smtp_policy.SetSenderRate(ctx, req.Sender, req.MsgsPerMin, req.BytesPerMin); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the sender rate: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.PostfixQueue,
+		Log:  fmt.Sprintf("Set sending rate of %s: %d messages, %d bytes per minute", req.Sender, req.MsgsPerMin, req.BytesPerMin),
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,35 @@
+package mail_services
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/smtp_policy"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/mail_services/v1"
+)
+
+func (c *ControllerV1) SetSubmissionBackpressure(ctx context.Context, req *v1.SetSubmissionBackpressureReq) (res *v1.SetSubmissionBackpressureRes, err error) {
+	res = &v1.SetSubmissionBackpressureRes{}
+
+	cfg := smtp_policy.BackpressureConfig{
+		MaxQueueDepth:    req.MaxQueueDepth,
+		ResumeQueueDepth: req.ResumeQueueDepth,
+	}
+
+	if err = smtp_policy.SetBackpressureConfig(ctx, cfg); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to set the submission backpressure: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.PostfixQueue,
+		Log:  fmt.Sprintf("Set submission backpressure: max queue depth %d, resume queue depth %d", cfg.MaxQueueDepth, cfg.ResumeQueueDepth),
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -30,6 +30,18 @@ var ExcludedPaths = map[string]struct{}{
 	"/subscribe_success.html":        {},
 	"/unsubscribe_success.html":      {},
 	"/subscribe_form_code.html":      {},
+
+	// API sends beyond send and batch_send, authenticated by the API key
+	"/api/batch_mail/api/personalized_send":  {},
+	"/api/batch_mail/api/transactional_send": {},
+
+	// Preference center, authenticated by the token of the unsubscribe link
+	"/api/preferences":        {},
+	"/api/preferences/update": {},
+
+	// Relay bounce and ESP suppression webhooks, authenticated by the provider signature
+	"/api/abnormal_recipient/bounce_webhook":      {},
+	"/api/abnormal_recipient/suppression_webhook": {},
 }
 
 func isExcludedPath(path string) bool {
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/rbac"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gcfg"
+	"github.com/gogf/gf/v2/os/gsession"
+)
+
+// TestApiKeyPathsPassTheMiddlewares sends the API key calls through the IP whitelist and
+// the JWT authentication as mounted by the server, from a client out of the whitelist
+// and without a JWT
+func TestApiKeyPathsPassTheMiddlewares(t *testing.T) {
+	// The configuration of the server is local to the installs, the JWT only needs a secret
+	adapter := g.Cfg().GetAdapter().(*gcfg.AdapterFile)
+	adapter.SetContent("jwt:\n  secret: test\n")
+	defer adapter.ClearContent()
+
+	root := t.TempDir()
+	defer func(orig string) { public.ROOT_PATH = orig }(public.ROOT_PATH)
+	public.ROOT_PATH = filepath.Join(root, "core")
+
+	env := filepath.Join(root, ".env")
+	setWhitelist := func(enabled bool) {
+		content := fmt.Sprintf("DBPASS=test\nIP_WHITELIST_ENABLE=%t\n", enabled)
+		if err := os.WriteFile(env, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setWhitelist(true)
+
+	s := g.Server("middleware-test")
+	s.SetAddr("127.0.0.1:0")
+	s.SetDumpRouterMap(false)
+	s.SetSessionStorage(gsession.NewStorageMemory())
+	s.Use(IPWhitelist)
+	s.Group("/api", func(group *ghttp.RouterGroup) {
+		group.Middleware(rbac.JWT().JWTAuthMiddleware)
+		group.ALL("/*", func(r *ghttp.Request) {
+			r.Response.Write("reached")
+		})
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	post := func(path string) string {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d%s", s.GetListenedPort(), path), strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	for _, path := range []string{
+		"/api/batch_mail/api/send",
+		"/api/batch_mail/api/batch_send",
+		"/api/batch_mail/api/personalized_send",
+		"/api/batch_mail/api/transactional_send",
+	} {
+		if body := post(path); body != "reached" {
+			t.Errorf("%s rejected: %s", path, body)
+		}
+	}
+
+	// The other APIs still need a JWT
+	setWhitelist(false)
+	if body := post("/api/batch_mail/task/list"); body == "reached" {
+		t.Error("API reached without a JWT")
+	}
+}
@@ -0,0 +1,3 @@
+package middleware
+
+import _ "billionmail-core/internal/testlog"
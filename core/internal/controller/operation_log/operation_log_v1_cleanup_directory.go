@@ -0,0 +1,43 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) CleanupDirectory(ctx context.Context, req *v1.CleanupDirectoryReq) (res *v1.CleanupDirectoryRes, err error) {
+	res = &v1.CleanupDirectoryRes{}
+
+	cleanup, err := log_maintenance.CleanupDirectory(ctx, req.Dir, log_maintenance.CleanupPolicy{
+		Name:    req.Name,
+		Pattern: req.Pattern,
+		Retention: log_maintenance.RetentionPolicy{
+			FilesToKeep: req.FilesToKeep,
+			MaxAge:      time.Duration(req.MaxAgeDays) * 24 * time.Hour,
+		},
+		DryRun: req.DryRun,
+	})
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to clean up the directory: {}", err.Error())))
+		return res, nil
+	}
+
+	if !cleanup.DryRun {
+		_ = public.WriteLog(ctx, public.LogParams{
+			Type: consts.LOGTYPE.LogMaintenance,
+			Log:  fmt.Sprintf("Cleaned up the logs of %s: %d deleted, %d compressed", cleanup.Dir, len(cleanup.Deleted), len(cleanup.Compressed)),
+			Data: cleanup,
+		})
+	}
+
+	res.Data = cleanup
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
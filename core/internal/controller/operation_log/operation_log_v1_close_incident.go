@@ -0,0 +1,28 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) CloseIncident(ctx context.Context, req *v1.CloseIncidentReq) (res *v1.CloseIncidentRes, err error) {
+	res = &v1.CloseIncidentRes{}
+
+	if err = log_maintenance.CloseIncident(ctx, req.Name); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to close the incident: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.LogMaintenance,
+		Log:  "Closed incident " + req.Name,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
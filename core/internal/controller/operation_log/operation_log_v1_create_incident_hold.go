@@ -0,0 +1,38 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) CreateIncidentHold(ctx context.Context, req *v1.CreateIncidentHoldReq) (res *v1.CreateIncidentHoldRes, err error) {
+	res = &v1.CreateIncidentHoldRes{}
+
+	var until time.Time
+	if req.Until > 0 {
+		until = time.Unix(req.Until, 0)
+	}
+
+	hold, err := log_maintenance.CreateIncidentHold(ctx, req.Name, time.Unix(req.Since, 0), until)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to hold the logs of the incident: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.LogMaintenance,
+		Log:  fmt.Sprintf("Incident %s holds %d logs", hold.Name, len(hold.Files)),
+		Data: hold,
+	})
+
+	res.Data = hold
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
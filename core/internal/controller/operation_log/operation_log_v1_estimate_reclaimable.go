@@ -0,0 +1,24 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) EstimateReclaimable(ctx context.Context, req *v1.EstimateReclaimableReq) (res *v1.EstimateReclaimableRes, err error) {
+	res = &v1.EstimateReclaimableRes{}
+
+	estimate, err := log_maintenance.EstimateReclaimable(ctx)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to estimate the reclaimable log space: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = estimate
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,33 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) GetDailyLogStats(ctx context.Context, req *v1.GetDailyLogStatsReq) (res *v1.GetDailyLogStatsRes, err error) {
+	res = &v1.GetDailyLogStatsRes{}
+
+	var since, until time.Time
+	if req.Since != "" {
+		since, _ = time.ParseInLocation("2006-01-02", req.Since, time.Local)
+	}
+	if req.Until != "" {
+		until, _ = time.ParseInLocation("2006-01-02", req.Until, time.Local)
+	}
+
+	stats, err := log_maintenance.DailyLogStats(ctx, since, until)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the daily log statistics: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = stats
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,16 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+)
+
+func (c *ControllerV1) GetIncidentHolds(ctx context.Context, req *v1.GetIncidentHoldsReq) (res *v1.GetIncidentHoldsRes, err error) {
+	res = &v1.GetIncidentHoldsRes{}
+
+	res.Data = log_maintenance.IncidentHolds(ctx)
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
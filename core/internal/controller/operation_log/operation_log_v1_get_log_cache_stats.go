@@ -0,0 +1,16 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+)
+
+func (c *ControllerV1) GetLogCacheStats(ctx context.Context, req *v1.GetLogCacheStatsReq) (res *v1.GetLogCacheStatsRes, err error) {
+	res = &v1.GetLogCacheStatsRes{}
+
+	res.Data = log_maintenance.LogCacheStatistics()
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
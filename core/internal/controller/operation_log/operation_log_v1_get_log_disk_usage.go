@@ -0,0 +1,24 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) GetLogDiskUsage(ctx context.Context, req *v1.GetLogDiskUsageReq) (res *v1.GetLogDiskUsageRes, err error) {
+	res = &v1.GetLogDiskUsageRes{}
+
+	report, err := log_maintenance.LogDiskUsage(ctx)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the log disk usage: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = report
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,16 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+)
+
+func (c *ControllerV1) GetLogPins(ctx context.Context, req *v1.GetLogPinsReq) (res *v1.GetLogPinsRes, err error) {
+	res = &v1.GetLogPinsRes{}
+
+	res.Data = log_maintenance.PinnedLogs(ctx)
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,16 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+)
+
+func (c *ControllerV1) GetMaintenanceHealth(ctx context.Context, req *v1.GetMaintenanceHealthReq) (res *v1.GetMaintenanceHealthRes, err error) {
+	res = &v1.GetMaintenanceHealthRes{}
+
+	res.Data = log_maintenance.Health()
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -1,8 +1,8 @@
 package operation_log
 
 import (
+	"billionmail-core/internal/service/log_maintenance"
 	"billionmail-core/internal/service/public"
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -81,21 +81,7 @@ endLoop:
 }
 
 func readLines(path, keyword string) ([]string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if keyword == "" || strings.Contains(line, keyword) {
-			lines = append(lines, line)
-		}
-	}
-	return lines, scanner.Err()
+	return log_maintenance.SearchLogs(path, keyword, log_maintenance.DisplayRedactor())
 }
 
 func reverseLines(lines []string) {
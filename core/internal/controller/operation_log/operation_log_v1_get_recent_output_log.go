@@ -0,0 +1,19 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+)
+
+func (c *ControllerV1) GetRecentOutputLog(ctx context.Context, req *v1.GetRecentOutputLogReq) (res *v1.GetRecentOutputLogRes, err error) {
+	res = &v1.GetRecentOutputLogRes{}
+
+	res.Data = map[string]interface{}{
+		"components": log_maintenance.RecentLogComponents(),
+		"list":       log_maintenance.RecentLogs(req.Component, req.Lines),
+	}
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
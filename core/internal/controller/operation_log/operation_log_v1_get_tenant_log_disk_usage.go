@@ -0,0 +1,24 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) GetTenantLogDiskUsage(ctx context.Context, req *v1.GetTenantLogDiskUsageReq) (res *v1.GetTenantLogDiskUsageRes, err error) {
+	res = &v1.GetTenantLogDiskUsageRes{}
+
+	reports, err := log_maintenance.TenantLogDiskUsage(ctx, req.Tenant)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to get the log disk usage of the tenants: {}", err.Error())))
+		return res, nil
+	}
+
+	res.Data = reports
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,33 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) PinLog(ctx context.Context, req *v1.PinLogReq) (res *v1.PinLogRes, err error) {
+	res = &v1.PinLogRes{}
+
+	pin, err := log_maintenance.PinLog(ctx, req.Path, time.Duration(req.TTL)*time.Second, req.Reason)
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to pin the log: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.LogMaintenance,
+		Log:  fmt.Sprintf("Pinned log %s: %s", pin.Path, pin.Reason),
+		Data: pin,
+	})
+
+	res.Data = pin
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
@@ -0,0 +1,28 @@
+package operation_log
+
+import (
+	"billionmail-core/api/operation_log/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+)
+
+func (c *ControllerV1) UnpinLog(ctx context.Context, req *v1.UnpinLogReq) (res *v1.UnpinLogRes, err error) {
+	res = &v1.UnpinLogRes{}
+
+	if err = log_maintenance.UnpinLog(ctx, req.Path); err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to unpin the log: {}", err.Error())))
+		return res, nil
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.LogMaintenance,
+		Log:  "Unpinned log " + req.Path,
+	})
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
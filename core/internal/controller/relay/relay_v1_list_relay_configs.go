@@ -3,6 +3,7 @@ package relay
 import (
 	"billionmail-core/api/relay/v1"
 	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/dns_resolver"
 	"billionmail-core/internal/service/public"
 	relay_service "billionmail-core/internal/service/relay"
 	"context"
@@ -195,7 +196,7 @@ func GetRealSPFRecord(ctx context.Context, domain string) (string, error) {
 
 	var spf string
 	var finalErr error
-	txts, err := net.LookupTXT(domain)
+	txts, err := dns_resolver.Default().LookupTXT(ctx, domain)
 	if err != nil {
 		g.Log().Debug(ctx, "DNS TXT query failed:", domain, "Error:", err)
 		finalErr = err
@@ -0,0 +1,51 @@
+package settings
+
+import (
+	"billionmail-core/internal/service/ops_digest"
+	"billionmail-core/internal/service/public"
+	"context"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+
+	"billionmail-core/api/settings/v1"
+)
+
+func (c *ControllerV1) GetOperationsDigestConfig(ctx context.Context, req *v1.GetOperationsDigestConfigReq) (res *v1.GetOperationsDigestConfigRes, err error) {
+	res = &v1.GetOperationsDigestConfigRes{}
+
+	cfg := ops_digest.GetConfig(ctx)
+	res.Data = v1.OperationsDigestConfig{
+		Enabled:         cfg.Enabled,
+		Recipients:      cfg.Recipients,
+		IntervalHours:   cfg.IntervalHours,
+		MaintenanceRuns: cfg.MaintenanceRuns,
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
+
+func (c *ControllerV1) SetOperationsDigestConfig(ctx context.Context, req *v1.SetOperationsDigestConfigReq) (res *v1.SetOperationsDigestConfigRes, err error) {
+	res = &v1.SetOperationsDigestConfigRes{}
+
+	err = ops_digest.SetConfig(ctx, ops_digest.Config{
+		Enabled:         req.Enabled,
+		Recipients:      req.Recipients,
+		IntervalHours:   req.IntervalHours,
+		MaintenanceRuns: req.MaintenanceRuns,
+	})
+	if err != nil {
+		res.SetError(gerror.New(public.LangCtx(ctx, "Failed to save operations digest settings: {}", err.Error())))
+		return res, nil
+	}
+
+	if req.SendNow {
+		if err = ops_digest.SendOperationsDigest(ctx); err != nil {
+			res.SetError(gerror.New(public.LangCtx(ctx, "Settings saved, but sending the digest failed: {}", err.Error())))
+			return res, nil
+		}
+	}
+
+	res.SetSuccess(public.LangCtx(ctx, "Success"))
+	return res, nil
+}
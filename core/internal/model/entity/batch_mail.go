@@ -44,6 +44,7 @@ type Contact struct {
 	Status       int               `json:"Status" dc:"1:Confirmed   0:Unconfirmed"`
 	Attribs      map[string]string `json:"attribs"`
 	LastActiveAt int               `json:"last_active_at" dc:"Last Active At"`
+	Timezone     string            `json:"timezone"    dc:"IANA Time Zone, empty when unknown"`
 }
 
 // EmailTemplate Entity
@@ -90,6 +91,15 @@ type EmailTask struct {
 	TagIds          []int  `json:"tag_ids"         dc:"Tag IDs (parsed array)"`
 	TagLogic        string `json:"tag_logic"       dc:"Tag Logic (AND/OR/NOT)"`
 	UseTagFilter    int    `json:"use_tag_filter"  dc:"Use Tag Filter (0: no, 1: yes)"`
+	SendLocalHour   int    `json:"send_local_hour" dc:"Local Hour of the Recipients to Deliver at (-1: no time zone window)"`
+	DefaultTimezone string `json:"default_timezone" dc:"Time Zone of the Recipients without One"`
+	CappedCount     int    `json:"capped_count"    dc:"Recipients Skipped by the Frequency Cap"`
+	PauseReason     string `json:"pause_reason"    dc:"Reason of the Automatic Pause"`
+	TemplateVersion int    `json:"template_version" dc:"Template Version Pinned at the Start (0: not started)"`
+	Category        string `json:"category"        dc:"Mail Category (empty: uncategorized)"`
+	OptedOutCount   int    `json:"opted_out_count" dc:"Recipients Skipped, Opted out of the Category"`
+	SendingDomain   string `json:"sending_domain"  dc:"From Domain Signing the Messages (empty: the addresser's)"`
+	SendingIp       string `json:"sending_ip"      dc:"Outbound IP of the Multi-IP Pool (empty: the route of the domain)"`
 }
 
 // MarshalJSON implements custom JSON marshaling to convert TagIdsRaw to TagIds array
@@ -126,13 +136,42 @@ func (e *EmailTask) AfterFind() {
 }
 
 type RecipientInfo struct {
-	Id         int    `json:"id"          dc:"Recipient ID"`
-	TaskId     int    `json:"task_id"     dc:"Task ID"`
-	Recipient  string `json:"recipient"   dc:"Recipient Email"`
-	IsSent     int    `json:"is_sent"     dc:"Send Status"`
-	SentTime   int    `json:"sent_time"   dc:"Send Time"`
-	MessageId  string `json:"message_id"  dc:"Email Message-ID"`
-	CreateTime int    `json:"create_time" dc:"Create Time"`
+	Id              int    `json:"id"          dc:"Recipient ID"`
+	TaskId          int    `json:"task_id"     dc:"Task ID"`
+	Recipient       string `json:"recipient"   dc:"Recipient Email"`
+	IsSent          int    `json:"is_sent"     dc:"Send Status"`
+	SentTime        int    `json:"sent_time"   dc:"Send Time"`
+	MessageId       string `json:"message_id"  dc:"Email Message-ID"`
+	CreateTime      int    `json:"create_time" dc:"Create Time"`
+	AbVariant       int    `json:"ab_variant"  dc:"A/B Test Variant (-1: not in the test cohort)"`
+	Timezone        string `json:"timezone"    dc:"Time Zone of the Recipient"`
+	FrequencyCapped int    `json:"frequency_capped" dc:"Skipped by the Frequency Cap (1: over the cap, not sent)"`
+	OptedOut        int    `json:"opted_out"   dc:"Skipped by the Subscriber Preferences (1: opted out of the category, not sent)"`
+}
+
+// EmailTaskAbTest A/B subject test of a task
+type EmailTaskAbTest struct {
+	TaskId        int    `json:"task_id"         dc:"Task ID"`
+	TestFraction  int    `json:"test_fraction"   dc:"Percentage of the recipients in the test cohort"`
+	Metric        string `json:"metric"          dc:"Winning metric (open, click)"`
+	WaitSeconds   int    `json:"wait_seconds"    dc:"Wait after the test cohort is sent before choosing the winner"`
+	Status        int    `json:"status"          dc:"Status (0: not started 1: testing 2: winner chosen)"`
+	Winner        int    `json:"winner"          dc:"Winning variant (-1: not chosen yet)"`
+	TestStartedAt int    `json:"test_started_at" dc:"Test Start Time"`
+	EvaluatedAt   int    `json:"evaluated_at"    dc:"Evaluation Time"`
+	CreateTime    int    `json:"create_time"     dc:"Create Time"`
+}
+
+// EmailTaskAbVariant subject variant of an A/B test and its result
+type EmailTaskAbVariant struct {
+	Id      int     `json:"id"      dc:"Variant ID"`
+	TaskId  int     `json:"task_id" dc:"Task ID"`
+	Variant int     `json:"variant" dc:"Variant Index"`
+	Subject string  `json:"subject" dc:"Subject"`
+	Sent    int     `json:"sent"    dc:"Sent Count"`
+	Opened  int     `json:"opened"  dc:"Opened Count"`
+	Clicked int     `json:"clicked" dc:"Clicked Count"`
+	Rate    float64 `json:"rate"    dc:"Rate of the winning metric"`
 }
 
 type AbnormalRecipient struct {
@@ -142,7 +181,8 @@ type AbnormalRecipient struct {
 	Description string `json:"description" dc:"Description"`
 	Count       int    `json:"count"       dc:"Count"`
 	AddType     int    `json:"add_type"    dc:"Add Type"`
-	// AddType 1: Manually added, 2: Automatically scanned, 3: Manually scanned
+	// AddType 1: Manually added, 2: Automatically scanned, 3: Manually scanned, 4: Imported, 5: Synced from an ESP
+	Source string `json:"source"      dc:"ESP the suppression was synced from"`
 }
 
 type MailTemplateContext struct {
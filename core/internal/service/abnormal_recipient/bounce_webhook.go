@@ -0,0 +1,712 @@
+package abnormal_recipient
+
+import (
+	"billionmail-core/internal/service/maillog_stat"
+	"billionmail-core/internal/service/public"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Bounce and complaint webhooks of third-party relays (Amazon SES, SendGrid, Mailgun).
+// Relays report bounces over HTTP instead of DSNs, the payloads are normalized to
+// BounceEvent and fed to the same abnormal recipient suppression as local bounces.
+// Every payload must carry a valid provider signature and a recent signed timestamp,
+// each event is applied once: it is recorded by its provider event ID in
+// esp_webhook_events and a redelivered or replayed event is skipped.
+// -----------------------------
+
+const bounceWebhookOptionKey = "bounce_webhook_config"
+
+// Maximum accepted age of a signed webhook timestamp, protects against replays
+const bounceWebhookMaxSkew = 10 * time.Minute
+
+// Maximum accepted age of a SNS notification, SNS keeps the timestamp of the first
+// attempt when it retries a delivery
+const snsMaxAge = time.Hour
+
+// bounceEventPrefix namespace of the events in esp_webhook_events, the suppression
+// webhook records the same events under the bare provider and applies them on its own
+const bounceEventPrefix = "bounce/"
+
+// Bounce webhook providers
+const (
+	BounceProviderSES      = "ses"
+	BounceProviderSendGrid = "sendgrid"
+	BounceProviderMailgun  = "mailgun"
+)
+
+// Bounce event types
+const (
+	BounceTypeHard        = "hard_bounce"
+	BounceTypeSoft        = "soft_bounce"
+	BounceTypeComplaint   = "complaint"
+	BounceTypeUnsubscribe = "unsubscribe"
+)
+
+// BounceEvent provider independent bounce or complaint
+type BounceEvent struct {
+	Provider  string    `json:"provider"`
+	EventID   string    `json:"event_id"` // unique ID of the event at the provider, empty when it has none
+	Type      string    `json:"type"`
+	Recipient string    `json:"recipient"`
+	MessageID string    `json:"message_id"`
+	Status    string    `json:"status"` // enhanced status code when known, e.g. 5.1.1
+	Reason    string    `json:"reason"`
+	Time      time.Time `json:"time"`
+}
+
+// BounceWebhookConfig verification material of each provider, a provider without it is rejected
+type BounceWebhookConfig struct {
+	SESTopicArns      []string `json:"ses_topic_arns"`      // accepted SNS topics
+	SendGridPublicKey string   `json:"sendgrid_public_key"` // base64 ECDSA verification key
+	MailgunSigningKey string   `json:"mailgun_signing_key"` // HTTP webhook signing key
+}
+
+// GetBounceWebhookConfig returns the stored webhook verification settings
+func GetBounceWebhookConfig(ctx context.Context) (cfg BounceWebhookConfig) {
+	_ = public.OptionsMgrInstance.GetOption(ctx, bounceWebhookOptionKey, &cfg)
+	return cfg
+}
+
+// SetBounceWebhookConfig stores the webhook verification settings
+func SetBounceWebhookConfig(ctx context.Context, cfg BounceWebhookConfig) error {
+	if cfg.SendGridPublicKey != "" {
+		if _, err := parseSendGridPublicKey(cfg.SendGridPublicKey); err != nil {
+			return err
+		}
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, bounceWebhookOptionKey, cfg)
+}
+
+// HandleBounceWebhook verifies and parses a webhook request of the provider,
+// then feeds the events to the suppression list
+func HandleBounceWebhook(ctx context.Context, provider string, header http.Header, body []byte) (int, error) {
+	events, err := parseBounceWebhook(ctx, provider, header, body)
+	if err != nil {
+		return 0, err
+	}
+
+	events, err = claimBounceEvents(ctx, events)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	if err = IngestBounceEvents(ctx, events); err != nil {
+		releaseBounceEvents(ctx, events)
+		return 0, err
+	}
+
+	return len(events), nil
+}
+
+// claimBounceEvents records the events in esp_webhook_events and returns those not
+// received before
+func claimBounceEvents(ctx context.Context, events []BounceEvent) ([]BounceEvent, error) {
+	claimed := make([]BounceEvent, 0, len(events))
+
+	for _, e := range events {
+		e.EventID = espEventKey(e)
+
+		res, err := g.DB().Model("esp_webhook_events").Ctx(ctx).Data(g.Map{
+			"provider":    bounceEventPrefix + e.Provider,
+			"event_id":    e.EventID,
+			"recipient":   strings.ToLower(strings.TrimSpace(e.Recipient)),
+			"type":        e.Type,
+			"event_time":  e.Time.Unix(),
+			"create_time": time.Now().Unix(),
+		}).InsertIgnore()
+		if err != nil {
+			releaseBounceEvents(ctx, claimed)
+			return nil, fmt.Errorf("Failed to record bounce webhook event: %w", err)
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			g.Log().Debugf(ctx, "Skipped the %s event %s already received", e.Provider, e.EventID)
+			continue
+		}
+		claimed = append(claimed, e)
+	}
+
+	return claimed, nil
+}
+
+// releaseBounceEvents forgets events that could not be applied, so that the provider
+// can deliver them again
+func releaseBounceEvents(ctx context.Context, events []BounceEvent) {
+	for _, e := range events {
+		_, err := g.DB().Model("esp_webhook_events").Ctx(ctx).
+			Where("provider", bounceEventPrefix+e.Provider).
+			Where("event_id", e.EventID).
+			Delete()
+		if err != nil {
+			g.Log().Warning(ctx, "Release bounce webhook event failed", e.EventID, err)
+		}
+	}
+}
+
+// parseBounceWebhook verifies the signature of a webhook request of the provider and
+// returns its events
+func parseBounceWebhook(ctx context.Context, provider string, header http.Header, body []byte) ([]BounceEvent, error) {
+	cfg := GetBounceWebhookConfig(ctx)
+
+	switch provider {
+	case BounceProviderSES:
+		return parseSESWebhook(ctx, cfg, body, time.Now())
+	case BounceProviderSendGrid:
+		return parseSendGridWebhook(cfg, header, body, time.Now())
+	case BounceProviderMailgun:
+		return parseMailgunWebhook(cfg, body, time.Now())
+	}
+
+	return nil, fmt.Errorf("unsupported bounce webhook provider: %s", provider)
+}
+
+// IngestBounceEvents applies the events like local DSNs: hard bounces and complaints
+// count towards the abnormal recipient suppression, soft bounces are ignored
+func IngestBounceEvents(ctx context.Context, events []BounceEvent) error {
+	details := make([]RecipientDetail, 0, len(events))
+
+	for _, e := range events {
+		recipient := strings.ToLower(strings.TrimSpace(e.Recipient))
+		if recipient == "" {
+			continue
+		}
+
+		switch e.Type {
+		case BounceTypeHard:
+			details = append(details, RecipientDetail{Email: recipient, ErrorReason: strings.TrimSpace(e.Status + " " + e.Reason)})
+		case BounceTypeComplaint:
+			details = append(details, RecipientDetail{Email: recipient, ErrorReason: "complaint " + e.Reason})
+
+			_, err := g.DB().Model("mailstat_complaints").Ctx(ctx).Insert(g.Map{
+				"postfix_message_id": complaintPostfixMessageId(ctx, e),
+				"recipient":          recipient,
+				"log_time_millis":    e.Time.UnixMilli(),
+			})
+			if err != nil {
+				g.Log().Warning(ctx, "Record relay complaint failed", recipient, err)
+			}
+		default:
+			g.Log().Debugf(ctx, "Ignored %s %s of %s: %s", e.Provider, e.Type, recipient, e.Reason)
+		}
+	}
+
+	if len(details) == 0 {
+		return nil
+	}
+
+	return BatchUpsertAbnormalRecipientsWithDetails(ctx, details, 2, "Relay webhook")
+}
+
+// complaintPostfixMessageId the postfix queue ID of the message complained about, looked
+// up by its Message-ID, empty when unknown: the ID of a relay is not a queue ID
+func complaintPostfixMessageId(ctx context.Context, e BounceEvent) string {
+	messageId := strings.Trim(strings.TrimSpace(e.MessageID), "<>")
+	if messageId == "" {
+		return ""
+	}
+
+	id, err := maillog_stat.SearchPostfixMessageIdByMessageId(messageId)
+	if err != nil {
+		g.Log().Debugf(ctx, "Lookup of the complaint message %s failed: %v", messageId, err)
+		return ""
+	}
+	return id
+}
+
+// -----------------------------
+// Amazon SES (SNS notifications)
+// -----------------------------
+
+var snsCertHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageId        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		MessageId     string `json:"messageId"`
+		CommonHeaders struct {
+			MessageId string `json:"messageId"`
+		} `json:"commonHeaders"`
+	} `json:"mail"`
+	Bounce struct {
+		BounceType        string `json:"bounceType"`
+		Timestamp         string `json:"timestamp"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			Status         string `json:"status"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint struct {
+		Timestamp             string `json:"timestamp"`
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+func parseSESWebhook(ctx context.Context, cfg BounceWebhookConfig, body []byte, now time.Time) ([]BounceEvent, error) {
+	var msg snsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid SNS message: %w", err)
+	}
+
+	allowed := false
+	for _, arn := range cfg.SESTopicArns {
+		if arn == msg.TopicArn {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("SNS topic %s is not configured", msg.TopicArn)
+	}
+
+	if err := verifySNSSignature(msg, now); err != nil {
+		return nil, err
+	}
+
+	if err := checkSNSTimestamp(msg.Timestamp, now); err != nil {
+		return nil, err
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		// Confirm the subscription so SNS starts delivering notifications
+		resp, err := snsHTTPClient.Get(msg.SubscribeURL)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		g.Log().Infof(ctx, "Confirmed SNS subscription of topic %s", msg.TopicArn)
+		return nil, nil
+	case "Notification":
+	default:
+		return nil, nil
+	}
+
+	var n sesNotification
+	if err := json.Unmarshal([]byte(msg.Message), &n); err != nil {
+		return nil, fmt.Errorf("invalid SES notification: %w", err)
+	}
+
+	// The Message-ID header when SES reports it, its own ID otherwise
+	messageId := n.Mail.CommonHeaders.MessageId
+	if messageId == "" {
+		messageId = n.Mail.MessageId
+	}
+
+	events := make([]BounceEvent, 0)
+
+	switch n.NotificationType {
+	case "Bounce":
+		t, _ := time.Parse(time.RFC3339, n.Bounce.Timestamp)
+		typ := BounceTypeSoft
+		if n.Bounce.BounceType == "Permanent" {
+			typ = BounceTypeHard
+		}
+		for _, r := range n.Bounce.BouncedRecipients {
+			events = append(events, BounceEvent{
+				Provider:  BounceProviderSES,
+				EventID:   msg.MessageId + ":" + r.EmailAddress,
+				Type:      typ,
+				Recipient: r.EmailAddress,
+				MessageID: messageId,
+				Status:    r.Status,
+				Reason:    r.DiagnosticCode,
+				Time:      t,
+			})
+		}
+	case "Complaint":
+		t, _ := time.Parse(time.RFC3339, n.Complaint.Timestamp)
+		for _, r := range n.Complaint.ComplainedRecipients {
+			events = append(events, BounceEvent{
+				Provider:  BounceProviderSES,
+				EventID:   msg.MessageId + ":" + r.EmailAddress,
+				Type:      BounceTypeComplaint,
+				Recipient: r.EmailAddress,
+				MessageID: messageId,
+				Reason:    n.Complaint.ComplaintFeedbackType,
+				Time:      t,
+			})
+		}
+	}
+
+	return events, nil
+}
+
+var snsHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// snsCertificates signing certificates by URL, fetched once and kept until they expire
+var snsCertificates = struct {
+	sync.Mutex
+	certs map[string]*x509.Certificate
+}{certs: make(map[string]*x509.Certificate)}
+
+// fetchSNSCertificate downloads a signing certificate, replaceable in tests
+var fetchSNSCertificate = func(certURL string) ([]byte, error) {
+	resp, err := snsHTTPClient.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch SNS signing certificate: %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+// snsSigningCertificate the certificate of certURL, from the cache when still valid
+func snsSigningCertificate(certURL string, now time.Time) (*x509.Certificate, error) {
+	snsCertificates.Lock()
+	defer snsCertificates.Unlock()
+
+	if cert, ok := snsCertificates.certs[certURL]; ok && now.Before(cert.NotAfter) {
+		return cert, nil
+	}
+
+	pemData, err := fetchSNSCertificate(certURL)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("invalid SNS signing certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("SNS signing certificate is not valid at %s", now.UTC().Format(time.RFC3339))
+	}
+
+	snsCertificates.certs[certURL] = cert
+	return cert, nil
+}
+
+// verifySNSSignature checks the SNS message signature with the AWS signing certificate
+func verifySNSSignature(msg snsMessage, now time.Time) error {
+	certURL, err := url.Parse(msg.SigningCertURL)
+	if err != nil || certURL.Scheme != "https" || !snsCertHostPattern.MatchString(certURL.Hostname()) {
+		return fmt.Errorf("untrusted SNS signing certificate URL: %s", msg.SigningCertURL)
+	}
+
+	if msg.Type == "SubscriptionConfirmation" {
+		if u, err := url.Parse(msg.SubscribeURL); err != nil || u.Scheme != "https" || !snsCertHostPattern.MatchString(u.Hostname()) {
+			return fmt.Errorf("untrusted SNS subscribe URL: %s", msg.SubscribeURL)
+		}
+	}
+
+	cert, err := snsSigningCertificate(certURL.String(), now)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unexpected SNS signing key type")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid SNS signature encoding")
+	}
+
+	payload := snsStringToSign(msg)
+
+	if msg.SignatureVersion == "2" {
+		sum := sha256.Sum256([]byte(payload))
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], signature)
+	}
+
+	sum := sha1.Sum([]byte(payload))
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA1, sum[:], signature)
+}
+
+// checkSNSTimestamp rejects the SNS messages older than snsMaxAge or signed in the future
+func checkSNSTimestamp(ts string, now time.Time) error {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(ts))
+	if err != nil {
+		return fmt.Errorf("invalid SNS timestamp: %q", ts)
+	}
+
+	if now.Sub(t) > snsMaxAge || t.Sub(now) > bounceWebhookMaxSkew {
+		return fmt.Errorf("SNS timestamp outside of the accepted window")
+	}
+
+	return nil
+}
+
+// snsStringToSign canonical form of the signed SNS fields
+func snsStringToSign(msg snsMessage) string {
+	var b strings.Builder
+	add := func(k, v string) {
+		b.WriteString(k + "\n" + v + "\n")
+	}
+
+	add("Message", msg.Message)
+	add("MessageId", msg.MessageId)
+
+	if msg.Type == "Notification" {
+		if msg.Subject != "" {
+			add("Subject", msg.Subject)
+		}
+	} else {
+		add("SubscribeURL", msg.SubscribeURL)
+	}
+
+	add("Timestamp", msg.Timestamp)
+
+	if msg.Type != "Notification" {
+		add("Token", msg.Token)
+	}
+
+	add("TopicArn", msg.TopicArn)
+	add("Type", msg.Type)
+
+	return b.String()
+}
+
+// -----------------------------
+// SendGrid event webhook
+// -----------------------------
+
+type sendGridEvent struct {
+	Email       string `json:"email"`
+	Event       string `json:"event"`
+	Type        string `json:"type"`
+	Reason      string `json:"reason"`
+	Status      string `json:"status"`
+	SgMessageId string `json:"sg_message_id"`
+	SgEventId   string `json:"sg_event_id"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+func parseSendGridPublicKey(key string) (*ecdsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SendGrid public key encoding")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SendGrid public key: %w", err)
+	}
+
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("SendGrid public key is not an ECDSA key")
+	}
+
+	return ecPub, nil
+}
+
+func parseSendGridWebhook(cfg BounceWebhookConfig, header http.Header, body []byte, now time.Time) ([]BounceEvent, error) {
+	if cfg.SendGridPublicKey == "" {
+		return nil, fmt.Errorf("SendGrid webhook verification key is not configured")
+	}
+
+	pub, err := parseSendGridPublicKey(cfg.SendGridPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+	if err = checkWebhookTimestamp(ts, now); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(header.Get("X-Twilio-Email-Event-Webhook-Signature"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SendGrid signature encoding")
+	}
+
+	sum := sha256.Sum256(append([]byte(ts), body...))
+	if !ecdsa.VerifyASN1(pub, sum[:], signature) {
+		return nil, fmt.Errorf("invalid SendGrid webhook signature")
+	}
+
+	var items []sendGridEvent
+	if err = json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("invalid SendGrid payload: %w", err)
+	}
+
+	events := make([]BounceEvent, 0, len(items))
+	for _, item := range items {
+		e := BounceEvent{
+			Provider:  BounceProviderSendGrid,
+			EventID:   item.SgEventId,
+			Recipient: item.Email,
+			MessageID: item.SgMessageId,
+			Status:    item.Status,
+			Reason:    item.Reason,
+			Time:      time.Unix(item.Timestamp, 0),
+		}
+
+		switch item.Event {
+		case "bounce":
+			// "blocked" is SendGrid's name for a temporary rejection
+			e.Type = BounceTypeHard
+			if item.Type == "blocked" {
+				e.Type = BounceTypeSoft
+			}
+		case "deferred":
+			e.Type = BounceTypeSoft
+		case "spamreport":
+			e.Type = BounceTypeComplaint
+		case "unsubscribe", "group_unsubscribe":
+			e.Type = BounceTypeUnsubscribe
+		default:
+			continue
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// -----------------------------
+// Mailgun webhook
+// -----------------------------
+
+type mailgunWebhook struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+	EventData struct {
+		Id             string  `json:"id"`
+		Event          string  `json:"event"`
+		Severity       string  `json:"severity"`
+		Recipient      string  `json:"recipient"`
+		Timestamp      float64 `json:"timestamp"`
+		DeliveryStatus struct {
+			Code        int    `json:"code"`
+			Description string `json:"description"`
+			Message     string `json:"message"`
+		} `json:"delivery-status"`
+		Message struct {
+			Headers struct {
+				MessageId string `json:"message-id"`
+			} `json:"headers"`
+		} `json:"message"`
+	} `json:"event-data"`
+}
+
+func parseMailgunWebhook(cfg BounceWebhookConfig, body []byte, now time.Time) ([]BounceEvent, error) {
+	if cfg.MailgunSigningKey == "" {
+		return nil, fmt.Errorf("Mailgun webhook signing key is not configured")
+	}
+
+	var hook mailgunWebhook
+	if err := json.Unmarshal(body, &hook); err != nil {
+		return nil, fmt.Errorf("invalid Mailgun payload: %w", err)
+	}
+
+	if err := checkWebhookTimestamp(hook.Signature.Timestamp, now); err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, []byte(cfg.MailgunSigningKey))
+	mac.Write([]byte(hook.Signature.Timestamp + hook.Signature.Token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(hook.Signature.Signature))) {
+		return nil, fmt.Errorf("invalid Mailgun webhook signature")
+	}
+
+	d := hook.EventData
+	e := BounceEvent{
+		Provider:  BounceProviderMailgun,
+		EventID:   d.Id,
+		Recipient: d.Recipient,
+		MessageID: d.Message.Headers.MessageId,
+		Reason:    strings.TrimSpace(d.DeliveryStatus.Description + " " + d.DeliveryStatus.Message),
+		Time:      time.Unix(int64(d.Timestamp), 0),
+	}
+
+	if d.DeliveryStatus.Code > 0 {
+		e.Status = strconv.Itoa(d.DeliveryStatus.Code)
+	}
+
+	switch d.Event {
+	case "failed":
+		e.Type = BounceTypeSoft
+		if d.Severity == "permanent" {
+			e.Type = BounceTypeHard
+		}
+	case "complained":
+		e.Type = BounceTypeComplaint
+	case "unsubscribed":
+		e.Type = BounceTypeUnsubscribe
+	default:
+		return nil, nil
+	}
+
+	return []BounceEvent{e}, nil
+}
+
+// checkWebhookTimestamp rejects signed timestamps outside of the accepted skew
+func checkWebhookTimestamp(ts string, now time.Time) error {
+	sec, err := strconv.ParseInt(strings.TrimSpace(ts), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp: %q", ts)
+	}
+
+	diff := now.Sub(time.Unix(sec, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+
+	if diff > bounceWebhookMaxSkew {
+		return fmt.Errorf("webhook timestamp outside of the accepted window")
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package abnormal_recipient
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSNSCertURL = "https://sns.eu-west-1.amazonaws.com/SimpleNotificationService-test.pem"
+
+// snsSigner signs SNS messages with a self-signed certificate served by a fake fetch
+type snsSigner struct {
+	key     *rsa.PrivateKey
+	fetches int
+}
+
+func newSNSSigner(t *testing.T, now time.Time) *snsSigner {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	s := &snsSigner{key: key}
+
+	orig := fetchSNSCertificate
+	fetchSNSCertificate = func(certURL string) ([]byte, error) {
+		s.fetches++
+		return certPEM, nil
+	}
+	snsCertificates.Lock()
+	snsCertificates.certs = make(map[string]*x509.Certificate)
+	snsCertificates.Unlock()
+	t.Cleanup(func() { fetchSNSCertificate = orig })
+
+	return s
+}
+
+func (s *snsSigner) sign(t *testing.T, msg snsMessage) snsMessage {
+	t.Helper()
+
+	msg.SigningCertURL = testSNSCertURL
+
+	var sig []byte
+	var err error
+	if msg.SignatureVersion == "2" {
+		sum := sha256.Sum256([]byte(snsStringToSign(msg)))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	} else {
+		sum := sha1.Sum([]byte(snsStringToSign(msg)))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, sum[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg.Signature = base64.StdEncoding.EncodeToString(sig)
+	return msg
+}
+
+func sesBounceMessage(now time.Time) snsMessage {
+	notification := `{"notificationType":"Bounce","mail":{"messageId":"ses-1","commonHeaders":{"messageId":"<abc@example.com>"}},
+		"bounce":{"bounceType":"Permanent","timestamp":"` + now.Format(time.RFC3339) + `",
+		"bouncedRecipients":[{"emailAddress":"bob@example.org","status":"5.1.1","diagnosticCode":"unknown user"}]}}`
+
+	return snsMessage{
+		Type:             "Notification",
+		MessageId:        "sns-1",
+		TopicArn:         "arn:aws:sns:eu-west-1:123:bounces",
+		Message:          notification,
+		Timestamp:        now.UTC().Format("2006-01-02T15:04:05.000Z"),
+		SignatureVersion: "2",
+	}
+}
+
+func TestSNSSignature(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	signer := newSNSSigner(t, now)
+
+	for _, version := range []string{"1", "2"} {
+		msg := sesBounceMessage(now)
+		msg.SignatureVersion = version
+		msg = signer.sign(t, msg)
+
+		if err := verifySNSSignature(msg, now); err != nil {
+			t.Errorf("version %s: %v", version, err)
+		}
+
+		tampered := msg
+		tampered.Message = strings.Replace(msg.Message, "bob@", "carol@", 1)
+		if err := verifySNSSignature(tampered, now); err == nil {
+			t.Errorf("version %s: tampered message accepted", version)
+		}
+	}
+
+	// The certificate is fetched once for its URL
+	if signer.fetches != 1 {
+		t.Errorf("certificate fetched %d times, want 1", signer.fetches)
+	}
+
+	msg := signer.sign(t, sesBounceMessage(now))
+	msg.SigningCertURL = "https://sns.example.com/cert.pem"
+	if err := verifySNSSignature(msg, now); err == nil {
+		t.Error("untrusted certificate URL accepted")
+	}
+
+	// Not valid anymore, the cached certificate included
+	if err := verifySNSSignature(signer.sign(t, sesBounceMessage(now)), now.Add(48*time.Hour)); err == nil {
+		t.Error("expired certificate accepted")
+	}
+}
+
+func TestSNSTimestamp(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		ts    string
+		valid bool
+	}{
+		{now.Format(time.RFC3339), true},
+		{now.Add(-30 * time.Minute).Format("2006-01-02T15:04:05.000Z"), true},
+		{now.Add(-2 * time.Hour).Format(time.RFC3339), false},
+		{now.Add(20 * time.Minute).Format(time.RFC3339), false},
+		{"yesterday", false},
+	} {
+		if err := checkSNSTimestamp(tc.ts, now); (err == nil) != tc.valid {
+			t.Errorf("%s: %v, want valid %t", tc.ts, err, tc.valid)
+		}
+	}
+}
+
+func TestSESWebhook(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	signer := newSNSSigner(t, now)
+	cfg := BounceWebhookConfig{SESTopicArns: []string{"arn:aws:sns:eu-west-1:123:bounces"}}
+	ctx := context.Background()
+
+	body, _ := json.Marshal(signer.sign(t, sesBounceMessage(now)))
+	events, err := parseSESWebhook(ctx, cfg, body, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	e := events[0]
+	if e.Type != BounceTypeHard || e.EventID != "sns-1:bob@example.org" || e.MessageID != "<abc@example.com>" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	// A replay of a captured notification once the window is over
+	if _, err := parseSESWebhook(ctx, cfg, body, now.Add(2*time.Hour)); err == nil {
+		t.Error("stale notification accepted")
+	}
+
+	if _, err := parseSESWebhook(ctx, BounceWebhookConfig{}, body, now); err == nil {
+		t.Error("notification of a topic not configured accepted")
+	}
+}
+
+func TestSendGridWebhook(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	cfg := BounceWebhookConfig{SendGridPublicKey: base64.StdEncoding.EncodeToString(der)}
+
+	body := []byte(`[{"email":"bob@example.org","event":"bounce","type":"bounce","reason":"unknown user","sg_event_id":"sg-1","timestamp":` +
+		strconv.FormatInt(now.Unix(), 10) + `},{"email":"carol@example.org","event":"delivered","sg_event_id":"sg-2"}]`)
+
+	signed := func(ts time.Time, body []byte) http.Header {
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		sum := sha256.Sum256(append([]byte(stamp), body...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := http.Header{}
+		h.Set("X-Twilio-Email-Event-Webhook-Timestamp", stamp)
+		h.Set("X-Twilio-Email-Event-Webhook-Signature", base64.StdEncoding.EncodeToString(sig))
+		return h
+	}
+
+	events, err := parseSendGridWebhook(cfg, signed(now, body), body, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != BounceTypeHard || events[0].EventID != "sg-1" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	if _, err := parseSendGridWebhook(cfg, signed(now, body), append([]byte(" "), body...), now); err == nil {
+		t.Error("tampered payload accepted")
+	}
+	if _, err := parseSendGridWebhook(cfg, signed(now, body), body, now.Add(time.Hour)); err == nil {
+		t.Error("replayed payload accepted")
+	}
+}
+
+func TestMailgunWebhookReplay(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte(ts + "token"))
+	body := fmt.Sprintf(`{"signature":{"timestamp":%q,"token":"token","signature":%q},
+		"event-data":{"id":"evt-1","event":"failed","severity":"permanent","recipient":"bob@example.org","timestamp":%d}}`,
+		ts, hex.EncodeToString(mac.Sum(nil)), now.Unix())
+	cfg := BounceWebhookConfig{MailgunSigningKey: "key"}
+
+	if _, err := parseMailgunWebhook(cfg, []byte(body), now.Add(time.Hour)); err == nil {
+		t.Error("replayed payload accepted")
+	}
+	if _, err := parseMailgunWebhook(BounceWebhookConfig{MailgunSigningKey: "other"}, []byte(body), now); err == nil {
+		t.Error("payload signed with another key accepted")
+	}
+}
@@ -0,0 +1,217 @@
+package abnormal_recipient
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gvalid"
+)
+
+// -----------------------------
+// Suppression sync of external ESPs. When part of the mail goes out through an ESP, the
+// complaints and unsubscribes it records come back over the same signed webhooks as the
+// relay bounces and suppress the address globally at once, for the campaigns sent from
+// here too. Each event is recorded by its provider event ID in esp_webhook_events, a
+// redelivered event is skipped. The merge only ever moves a suppression to an earlier
+// date, so duplicates and events arriving out of order leave the same list. The provider
+// of the earliest event is kept as the source of the suppression. A resubscribe at the
+// ESP does not lift a suppression.
+// -----------------------------
+
+// addTypeESP add_type of the abnormal recipients suppressed by an ESP webhook
+const addTypeESP = 5
+
+// SuppressionSyncResult outcome of a suppression webhook
+type SuppressionSyncResult struct {
+	Events     int `json:"events"`     // complaints and unsubscribes in the payload
+	Duplicates int `json:"duplicates"` // events already received
+	Added      int `json:"added"`      // addresses newly suppressed
+	Updated    int `json:"updated"`    // suppressions moved to an earlier date
+	Unchanged  int `json:"unchanged"`  // already suppressed earlier
+}
+
+// HandleSuppressionWebhook verifies and parses a webhook request of the provider, then
+// merges its complaints and unsubscribes into the suppression list
+func HandleSuppressionWebhook(ctx context.Context, provider string, header http.Header, body []byte) (SuppressionSyncResult, error) {
+	events, err := parseBounceWebhook(ctx, provider, header, body)
+	if err != nil {
+		return SuppressionSyncResult{}, err
+	}
+
+	return SyncSuppressions(ctx, events)
+}
+
+// SyncSuppressions merges the complaints and unsubscribes of events into the suppression
+// list, the other events are ignored
+func SyncSuppressions(ctx context.Context, events []BounceEvent) (SuppressionSyncResult, error) {
+	var result SuppressionSyncResult
+
+	list := suppressionEvents(events, time.Now())
+	result.Events = len(list)
+	if len(list) == 0 {
+		return result, nil
+	}
+
+	err := g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		for _, e := range list {
+			res, err := tx.Model("esp_webhook_events").Data(g.Map{
+				"provider":    e.Provider,
+				"event_id":    e.EventID,
+				"recipient":   e.Recipient,
+				"type":        e.Type,
+				"event_time":  e.Time.Unix(),
+				"create_time": time.Now().Unix(),
+			}).InsertIgnore()
+			if err != nil {
+				return fmt.Errorf("Failed to record ESP webhook event: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				result.Duplicates++
+				continue
+			}
+
+			if err = mergeESPSuppression(ctx, tx, e, &result); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return SuppressionSyncResult{}, err
+	}
+
+	return result, nil
+}
+
+// suppressionEvents the valid complaints and unsubscribes of events, normalized, with
+// the events repeated in the payload removed
+func suppressionEvents(events []BounceEvent, now time.Time) []BounceEvent {
+	seen := make(map[string]bool, len(events))
+	list := make([]BounceEvent, 0, len(events))
+
+	for _, e := range events {
+		if e.Type != BounceTypeComplaint && e.Type != BounceTypeUnsubscribe {
+			continue
+		}
+
+		e.Recipient = strings.ToLower(strings.TrimSpace(e.Recipient))
+		if e.Recipient == "" || gvalid.New().Rules("email").Data(e.Recipient).Run(context.Background()) != nil {
+			continue
+		}
+		// The key of an event without ID is taken before its time is defaulted, so that
+		// a redelivery gets the same key
+		e.EventID = espEventKey(e)
+		if e.Time.IsZero() || e.Time.After(now) {
+			e.Time = now
+		}
+
+		key := e.Provider + "|" + e.EventID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		list = append(list, e)
+	}
+
+	return list
+}
+
+// espEventKey ID of the event at its provider, a hash of its content when the provider
+// sent none
+func espEventKey(e BounceEvent) string {
+	if e.EventID != "" {
+		return e.EventID
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{e.Type, e.Recipient, e.MessageID, e.Time.UTC().Format(time.RFC3339Nano)}, "|")))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// espSuppressionReason description of a suppression by an ESP event
+func espSuppressionReason(e BounceEvent) string {
+	label := "Complaint"
+	if e.Type == BounceTypeUnsubscribe {
+		label = "Unsubscribed"
+	}
+
+	reason := fmt.Sprintf("%s at %s", label, e.Provider)
+	if r := strings.TrimSpace(e.Reason); r != "" {
+		reason += " - " + r
+	}
+	if len(reason) > 255 {
+		// Cut on a rune boundary, a split rune is not valid UTF-8
+		cut := 255
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+	return reason
+}
+
+// mergeESPSuppression suppresses the recipient of e, or moves its suppression to the
+// date of e when earlier
+func mergeESPSuppression(ctx context.Context, tx gdb.TX, e BounceEvent, result *SuppressionSyncResult) error {
+	var existing struct {
+		Id         int   `json:"id"`
+		Count      int   `json:"count"`
+		CreateTime int64 `json:"create_time"`
+	}
+	if err := tx.Model("abnormal_recipient").Fields("id, count, create_time").Where("recipient", e.Recipient).Scan(&existing); err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("Failed to query abnormal recipient: %w", err)
+	}
+
+	if existing.Id == 0 {
+		_, err := tx.Model("abnormal_recipient").Data(g.Map{
+			"recipient":   e.Recipient,
+			"count":       suppressionThreshold,
+			"add_type":    addTypeESP,
+			"description": espSuppressionReason(e),
+			"source":      e.Provider,
+			"create_time": e.Time.Unix(),
+		}).Insert()
+		if err != nil {
+			return fmt.Errorf("Failed to insert abnormal recipient: %w", err)
+		}
+		result.Added++
+		return nil
+	}
+
+	// A recipient counted below the threshold is suppressed from the date of the event,
+	// not from its first bounce, so that an earlier event arriving later still wins
+	suppressed := existing.Count >= suppressionThreshold
+	if suppressed && e.Time.Unix() >= existing.CreateTime {
+		result.Unchanged++
+		return nil
+	}
+
+	data := g.Map{
+		"create_time": e.Time.Unix(),
+		"description": espSuppressionReason(e),
+		"source":      e.Provider,
+	}
+	if !suppressed {
+		data["count"] = suppressionThreshold
+		data["add_type"] = addTypeESP
+	}
+
+	if _, err := tx.Model("abnormal_recipient").Where("id", existing.Id).Data(data).Update(); err != nil {
+		return fmt.Errorf("Failed to update abnormal recipient: %w", err)
+	}
+
+	if suppressed {
+		result.Updated++
+	} else {
+		result.Added++
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package abnormal_recipient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestMailgunUnsubscribeEvent(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte(ts + "token"))
+	body := fmt.Sprintf(`{"signature":{"timestamp":%q,"token":"token","signature":%q},
+		"event-data":{"id":"evt-1","event":"unsubscribed","recipient":"Bob@Example.com","timestamp":%d}}`,
+		ts, hex.EncodeToString(mac.Sum(nil)), now.Unix())
+
+	events, err := parseMailgunWebhook(BounceWebhookConfig{MailgunSigningKey: "key"}, []byte(body), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != BounceTypeUnsubscribe || events[0].EventID != "evt-1" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestSuppressionEvents(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	events := []BounceEvent{
+		{Provider: BounceProviderSendGrid, EventID: "a", Type: BounceTypeComplaint, Recipient: " Bob@Example.com ", Time: now.Add(-time.Hour)},
+		{Provider: BounceProviderSendGrid, EventID: "a", Type: BounceTypeComplaint, Recipient: "bob@example.com", Time: now.Add(-time.Hour)},
+		{Provider: BounceProviderMailgun, EventID: "a", Type: BounceTypeUnsubscribe, Recipient: "bob@example.com", Time: now.Add(time.Hour)},
+		{Provider: BounceProviderSES, Type: BounceTypeHard, Recipient: "carol@example.com", Time: now},
+		{Provider: BounceProviderSES, Type: BounceTypeComplaint, Recipient: "not-an-address", Time: now},
+		{Provider: BounceProviderSES, Type: BounceTypeComplaint, Recipient: "dave@example.com", MessageID: "m1"},
+		{Provider: BounceProviderSES, Type: BounceTypeComplaint, Recipient: "dave@example.com", MessageID: "m1"},
+	}
+
+	list := suppressionEvents(events, now)
+	if len(list) != 3 {
+		t.Fatalf("expected 3 events, got %+v", list)
+	}
+	if list[0].Recipient != "bob@example.com" {
+		t.Errorf("recipient not normalized: %q", list[0].Recipient)
+	}
+	if list[1].Provider != BounceProviderMailgun || !list[1].Time.Equal(now) {
+		t.Errorf("event IDs of another provider merged or future time kept: %+v", list[1])
+	}
+	if again := suppressionEvents(events[6:], now.Add(time.Hour)); len(again) != 1 || again[0].EventID == "" || again[0].EventID != list[2].EventID {
+		t.Errorf("event without ID has no stable key: %+v, %+v", list[2], again)
+	}
+}
+
+func TestSuppressionReasonTruncatedOnRune(t *testing.T) {
+	e := BounceEvent{Provider: BounceProviderSES, Type: BounceTypeComplaint, Reason: strings.Repeat("é", 200)}
+
+	reason := espSuppressionReason(e)
+	if len(reason) > 255 {
+		t.Fatalf("reason not truncated: %d bytes", len(reason))
+	}
+	if !utf8.ValidString(reason) {
+		t.Errorf("reason cut inside a rune: %q", reason[len(reason)-4:])
+	}
+}
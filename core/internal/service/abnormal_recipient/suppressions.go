@@ -0,0 +1,712 @@
+package abnormal_recipient
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gvalid"
+)
+
+// -----------------------------
+// Export and import of the suppression list, to move it between installations or
+// share it between lists. A global suppression is an abnormal recipient counted at
+// least suppressionThreshold times, it is never mailed by any campaign. A list
+// suppression is an unsubscribe from one contact group.
+// Format, CSV with a header row or a JSON array of the same fields:
+//
+//	address,reason,suppressed_at,scope,list_id
+//	bob@example.com,Hard bounce,2025-01-02T03:04:05Z,global,
+//	carol@example.com,Unsubscribed,2025-02-01T00:00:00Z,list,12
+//
+// On import the same address is kept once per scope and list, with the earliest
+// suppression and its reason, also against what is already stored.
+// -----------------------------
+
+const (
+	SuppressionScopeGlobal = "global"
+	SuppressionScopeList   = "list"
+
+	SuppressionFormatCSV  = "csv"
+	SuppressionFormatJSON = "json"
+
+	// suppressionThreshold count from which an abnormal recipient is skipped by campaigns
+	suppressionThreshold = 3
+
+	// addTypeImported add_type of the abnormal recipients added by an import
+	addTypeImported = 4
+
+	suppressionPageSize = 1000
+)
+
+var suppressionColumns = []string{"address", "reason", "suppressed_at", "scope", "list_id"}
+
+// Suppression one suppressed address
+type Suppression struct {
+	Address      string    `json:"address"`
+	Reason       string    `json:"reason"`
+	SuppressedAt time.Time `json:"suppressed_at"`
+	Scope        string    `json:"scope"`             // SuppressionScopeGlobal or SuppressionScopeList
+	ListId       int       `json:"list_id,omitempty"` // contact group of a list suppression
+}
+
+// SuppressionOptions format and scope of an export or import
+type SuppressionOptions struct {
+	Format string `json:"format"` // SuppressionFormatCSV (default) or SuppressionFormatJSON
+
+	// Scope on export only the suppressions of this scope, all when empty. On import
+	// every record is applied with this scope when set, e.g. "global" to suppress the
+	// unsubscribes of another list everywhere
+	Scope string `json:"scope"`
+
+	// GroupId on export only the list suppressions of this group. On import the list
+	// suppressions are applied to this group instead of their list_id, which shares
+	// the suppressions of one list with another
+	GroupId int `json:"group_id"`
+}
+
+// SuppressionImportResult outcome of an import
+type SuppressionImportResult struct {
+	Read       int      `json:"read"`       // records in the input
+	Duplicates int      `json:"duplicates"` // records merged into an earlier one of the input
+	Invalid    int      `json:"invalid"`    // records skipped, see Errors
+	Added      int      `json:"added"`      // suppressions created
+	Updated    int      `json:"updated"`    // stored suppressions moved to an earlier date
+	Unchanged  int      `json:"unchanged"`  // already suppressed earlier
+	Errors     []string `json:"errors,omitempty"`
+}
+
+const maxImportErrors = 100
+
+func (r *SuppressionImportResult) invalid(format string, args ...interface{}) {
+	r.Invalid++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// suppressionKey identity of a suppression for the deduplication
+func suppressionKey(s Suppression) string {
+	return s.Scope + "|" + strconv.Itoa(s.ListId) + "|" + strings.ToLower(s.Address)
+}
+
+// ---------------- Export ----------------
+
+// suppressionWriter streams suppressions in one format
+type suppressionWriter interface {
+	write(s Suppression) error
+	close() error
+}
+
+type csvSuppressionWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvSuppressionWriter) write(s Suppression) error {
+	listId := ""
+	if s.ListId > 0 {
+		listId = strconv.Itoa(s.ListId)
+	}
+	return c.w.Write([]string{s.Address, s.Reason, s.SuppressedAt.UTC().Format(time.RFC3339), s.Scope, listId})
+}
+
+func (c *csvSuppressionWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonSuppressionWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonSuppressionWriter) write(s Suppression) error {
+	s.SuppressedAt = s.SuppressedAt.UTC()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	j.count++
+
+	_, err = io.WriteString(j.w, sep+string(data))
+	return err
+}
+
+func (j *jsonSuppressionWriter) close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+func newSuppressionWriter(w io.Writer, format string) (suppressionWriter, error) {
+	switch strings.ToLower(format) {
+	case "", SuppressionFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(suppressionColumns); err != nil {
+			return nil, err
+		}
+		return &csvSuppressionWriter{w: cw}, nil
+	case SuppressionFormatJSON:
+		return &jsonSuppressionWriter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// ExportSuppressions streams the suppression list to w, page by page
+func ExportSuppressions(ctx context.Context, w io.Writer, opts SuppressionOptions) error {
+	if opts.Scope != "" && opts.Scope != SuppressionScopeGlobal && opts.Scope != SuppressionScopeList {
+		return fmt.Errorf("unsupported scope %q", opts.Scope)
+	}
+
+	out, err := newSuppressionWriter(w, opts.Format)
+	if err != nil {
+		return err
+	}
+
+	if opts.Scope != SuppressionScopeList {
+		if err = exportGlobalSuppressions(ctx, out); err != nil {
+			return err
+		}
+	}
+
+	if opts.Scope != SuppressionScopeGlobal {
+		if err = exportListSuppressions(ctx, out, opts.GroupId); err != nil {
+			return err
+		}
+	}
+
+	return out.close()
+}
+
+func exportGlobalSuppressions(ctx context.Context, out suppressionWriter) error {
+	lastId := 0
+
+	for {
+		var rows []struct {
+			Id          int    `json:"id"`
+			Recipient   string `json:"recipient"`
+			Description string `json:"description"`
+			CreateTime  int64  `json:"create_time"`
+		}
+
+		err := g.DB().Model("abnormal_recipient").Ctx(ctx).
+			Fields("id, recipient, description, create_time").
+			Where("count >= ?", suppressionThreshold).
+			Where("id > ?", lastId).
+			Order("id ASC").
+			Limit(suppressionPageSize).
+			Scan(&rows)
+		if err != nil {
+			return fmt.Errorf("Failed to query the suppressed recipients: %w", err)
+		}
+
+		for _, row := range rows {
+			if err = out.write(Suppression{
+				Address:      row.Recipient,
+				Reason:       row.Description,
+				SuppressedAt: time.Unix(row.CreateTime, 0),
+				Scope:        SuppressionScopeGlobal,
+			}); err != nil {
+				return err
+			}
+			lastId = row.Id
+		}
+
+		if len(rows) < suppressionPageSize {
+			return nil
+		}
+	}
+}
+
+func exportListSuppressions(ctx context.Context, out suppressionWriter, groupId int) error {
+	// The earliest unsubscribe of every address and list, with its reason
+	query := `SELECT DISTINCT ON (LOWER(email), group_id) email, group_id, unsubscribe_time, reason
+		FROM unsubscribe_records WHERE group_id > 0`
+	args := []interface{}{}
+	if groupId > 0 {
+		query += " AND group_id = ?"
+		args = append(args, groupId)
+	}
+	query += " ORDER BY LOWER(email), group_id, unsubscribe_time, id LIMIT ? OFFSET ?"
+
+	for offset := 0; ; offset += suppressionPageSize {
+		rows, err := g.DB().GetAll(ctx, query, append(args, suppressionPageSize, offset)...)
+		if err != nil {
+			return fmt.Errorf("Failed to query the unsubscribes: %w", err)
+		}
+
+		for _, row := range rows {
+			reason := row["reason"].String()
+			if reason == "" {
+				reason = "Unsubscribed"
+			}
+
+			if err = out.write(Suppression{
+				Address:      row["email"].String(),
+				Reason:       reason,
+				SuppressedAt: time.Unix(row["unsubscribe_time"].Int64(), 0),
+				Scope:        SuppressionScopeList,
+				ListId:       row["group_id"].Int(),
+			}); err != nil {
+				return err
+			}
+		}
+
+		if len(rows) < suppressionPageSize {
+			return nil
+		}
+	}
+}
+
+// ---------------- Import ----------------
+
+// ImportSuppressions reads suppressions from r and applies them, keeping the earliest
+// suppression of every address on conflict
+func ImportSuppressions(ctx context.Context, r io.Reader, opts SuppressionOptions) (SuppressionImportResult, error) {
+	if opts.Scope != "" && opts.Scope != SuppressionScopeGlobal && opts.Scope != SuppressionScopeList {
+		return SuppressionImportResult{}, fmt.Errorf("unsupported scope %q", opts.Scope)
+	}
+
+	list, result, err := parseSuppressions(ctx, r, opts)
+	if err != nil {
+		return result, err
+	}
+
+	list, err = dropUnknownLists(ctx, list, &result)
+	if err != nil {
+		return result, err
+	}
+
+	global := make([]Suppression, 0, len(list))
+	lists := make([]Suppression, 0)
+	for _, s := range list {
+		if s.Scope == SuppressionScopeGlobal {
+			global = append(global, s)
+		} else {
+			lists = append(lists, s)
+		}
+	}
+
+	for start := 0; start < len(global); start += suppressionPageSize {
+		end := min(start+suppressionPageSize, len(global))
+		if err = importGlobalSuppressions(ctx, global[start:end], &result); err != nil {
+			return result, err
+		}
+	}
+
+	for start := 0; start < len(lists); start += suppressionPageSize {
+		end := min(start+suppressionPageSize, len(lists))
+		if err = importListSuppressions(ctx, lists[start:end], &result); err != nil {
+			return result, err
+		}
+	}
+
+	g.Log().Infof(ctx, "Suppressions imported: %d read, %d added, %d updated, %d unchanged, %d duplicates, %d invalid",
+		result.Read, result.Added, result.Updated, result.Unchanged, result.Duplicates, result.Invalid)
+
+	return result, nil
+}
+
+// parseSuppressions reads, validates and deduplicates the records of r, in input order
+func parseSuppressions(ctx context.Context, r io.Reader, opts SuppressionOptions) ([]Suppression, SuppressionImportResult, error) {
+	var result SuppressionImportResult
+
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\ufeff" {
+		_, _ = br.Discard(3)
+	}
+
+	format := strings.ToLower(opts.Format)
+	if format == "" {
+		// A JSON export starts with its array
+		if b, err := peekNonSpace(br); err == nil && b == '[' {
+			format = SuppressionFormatJSON
+		} else {
+			format = SuppressionFormatCSV
+		}
+	}
+
+	var records []map[string]string
+	var err error
+	switch format {
+	case SuppressionFormatCSV:
+		records, err = readSuppressionCSV(br)
+	case SuppressionFormatJSON:
+		records, err = readSuppressionJSON(br)
+	default:
+		err = fmt.Errorf("unsupported format %q", opts.Format)
+	}
+	if err != nil {
+		return nil, result, err
+	}
+
+	now := time.Now()
+	index := make(map[string]int)
+	list := make([]Suppression, 0, len(records))
+
+	for i, record := range records {
+		result.Read++
+		line := i + 1
+
+		s, err := suppressionFromRecord(ctx, record, now)
+		if err != nil {
+			result.invalid("record %d: %v", line, err)
+			continue
+		}
+
+		if opts.Scope != "" {
+			s.Scope = opts.Scope
+		}
+		if s.Scope == SuppressionScopeGlobal {
+			s.ListId = 0
+		} else if opts.GroupId > 0 {
+			s.ListId = opts.GroupId
+		}
+		if s.Scope == SuppressionScopeList && s.ListId <= 0 {
+			result.invalid("record %d: list suppression of %s without list_id", line, s.Address)
+			continue
+		}
+
+		key := suppressionKey(s)
+		if j, ok := index[key]; ok {
+			result.Duplicates++
+			if s.SuppressedAt.Before(list[j].SuppressedAt) {
+				list[j].SuppressedAt, list[j].Reason = s.SuppressedAt, s.Reason
+			}
+			continue
+		}
+
+		index[key] = len(list)
+		list = append(list, s)
+	}
+
+	return list, result, nil
+}
+
+// peekNonSpace the first byte of r that is not white space, without consuming it
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for i := 1; ; i++ {
+		b, err := br.Peek(i)
+		if err != nil {
+			return 0, err
+		}
+		if c := b[i-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, nil
+		}
+	}
+}
+
+func readSuppressionCSV(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	hasAddress := false
+	for i, h := range header {
+		columns[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if columns[i] == "address" || columns[i] == "email" {
+			hasAddress = true
+		}
+	}
+	if !hasAddress {
+		return nil, fmt.Errorf("CSV header needs an address column, got %v", header)
+	}
+
+	records := make([]map[string]string, 0)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		record := make(map[string]string, len(columns))
+		for i, value := range row {
+			if i < len(columns) {
+				record[columns[i]] = strings.TrimSpace(value)
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+func readSuppressionJSON(r io.Reader) ([]map[string]string, error) {
+	var raw []map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	records := make([]map[string]string, 0, len(raw))
+	for _, item := range raw {
+		record := make(map[string]string, len(item))
+		for k, v := range item {
+			switch value := v.(type) {
+			case string:
+				record[strings.ToLower(k)] = strings.TrimSpace(value)
+			case float64:
+				record[strings.ToLower(k)] = strconv.FormatFloat(value, 'f', -1, 64)
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// suppressionFromRecord validates one input record, a missing date is now
+func suppressionFromRecord(ctx context.Context, record map[string]string, now time.Time) (Suppression, error) {
+	s := Suppression{
+		Address: record["address"],
+		Reason:  record["reason"],
+		Scope:   strings.ToLower(record["scope"]),
+	}
+	if s.Address == "" {
+		s.Address = record["email"]
+	}
+
+	if s.Address == "" {
+		return s, fmt.Errorf("missing address")
+	}
+	if err := gvalid.New().Rules("email").Data(s.Address).Run(ctx); err != nil {
+		return s, fmt.Errorf("invalid address %q", s.Address)
+	}
+
+	switch s.Scope {
+	case "":
+		s.Scope = SuppressionScopeGlobal
+	case SuppressionScopeGlobal, SuppressionScopeList:
+	default:
+		return s, fmt.Errorf("unknown scope %q", s.Scope)
+	}
+
+	if v := record["list_id"]; v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 0 {
+			return s, fmt.Errorf("invalid list_id %q", v)
+		}
+		s.ListId = id
+	}
+
+	s.SuppressedAt = now
+	if v := record["suppressed_at"]; v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			s.SuppressedAt = t
+		} else if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+			s.SuppressedAt = time.Unix(unix, 0)
+		} else {
+			return s, fmt.Errorf("invalid suppressed_at %q", v)
+		}
+	}
+	if s.SuppressedAt.After(now) {
+		s.SuppressedAt = now
+	}
+
+	if len(s.Reason) > 255 {
+		s.Reason = s.Reason[:255]
+	}
+	if s.Reason == "" {
+		s.Reason = "Imported"
+	}
+
+	return s, nil
+}
+
+// dropUnknownLists skips the list suppressions of contact groups that do not exist
+func dropUnknownLists(ctx context.Context, list []Suppression, result *SuppressionImportResult) ([]Suppression, error) {
+	ids := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, s := range list {
+		if s.Scope == SuppressionScopeList && !seen[s.ListId] {
+			seen[s.ListId] = true
+			ids = append(ids, s.ListId)
+		}
+	}
+	if len(ids) == 0 {
+		return list, nil
+	}
+
+	values, err := g.DB().Model("bm_contact_groups").Ctx(ctx).WhereIn("id", ids).Array("id")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query the contact groups: %w", err)
+	}
+	known := make(map[int]bool, len(values))
+	for _, v := range values {
+		known[v.Int()] = true
+	}
+
+	kept := list[:0]
+	for _, s := range list {
+		if s.Scope == SuppressionScopeList && !known[s.ListId] {
+			result.invalid("%s: list %d does not exist", s.Address, s.ListId)
+			continue
+		}
+		kept = append(kept, s)
+	}
+
+	return kept, nil
+}
+
+// importGlobalSuppressions suppresses the addresses for every campaign
+func importGlobalSuppressions(ctx context.Context, list []Suppression, result *SuppressionImportResult) error {
+	addresses := make([]string, len(list))
+	for i, s := range list {
+		addresses[i] = s.Address
+	}
+
+	return g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		var existing []struct {
+			Id         int    `json:"id"`
+			Recipient  string `json:"recipient"`
+			Count      int    `json:"count"`
+			CreateTime int64  `json:"create_time"`
+		}
+		if err := tx.Model("abnormal_recipient").Fields("id, recipient, count, create_time").WhereIn("recipient", addresses).Scan(&existing); err != nil {
+			return fmt.Errorf("Failed to query existing abnormal recipients: %w", err)
+		}
+		existMap := make(map[string]int, len(existing))
+		for i, e := range existing {
+			existMap[e.Recipient] = i
+		}
+
+		insertList := make([]g.Map, 0)
+		for _, s := range list {
+			i, ok := existMap[s.Address]
+			if !ok {
+				insertList = append(insertList, g.Map{
+					"recipient":   s.Address,
+					"count":       suppressionThreshold,
+					"add_type":    addTypeImported,
+					"description": s.Reason,
+					"create_time": s.SuppressedAt.Unix(),
+				})
+				continue
+			}
+
+			e := existing[i]
+			data := g.Map{}
+			if e.Count < suppressionThreshold {
+				data["count"] = suppressionThreshold
+			}
+			if s.SuppressedAt.Unix() < e.CreateTime {
+				data["create_time"] = s.SuppressedAt.Unix()
+				data["description"] = s.Reason
+			}
+			if len(data) == 0 {
+				result.Unchanged++
+				continue
+			}
+
+			if _, err := tx.Model("abnormal_recipient").Where("id", e.Id).Data(data).Update(); err != nil {
+				return fmt.Errorf("Failed to update abnormal recipient: %w", err)
+			}
+			result.Updated++
+		}
+
+		if len(insertList) > 0 {
+			res, err := tx.Model("abnormal_recipient").Data(insertList).InsertIgnore()
+			if err != nil {
+				return fmt.Errorf("Failed to insert abnormal recipients: %w", err)
+			}
+			added, _ := res.RowsAffected()
+			result.Added += int(added)
+			result.Unchanged += len(insertList) - int(added)
+		}
+
+		return nil
+	})
+}
+
+// importListSuppressions unsubscribes the addresses from their list
+func importListSuppressions(ctx context.Context, list []Suppression, result *SuppressionImportResult) error {
+	addresses := make([]string, len(list))
+	for i, s := range list {
+		addresses[i] = s.Address
+	}
+
+	return g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		var existing []struct {
+			Id              int    `json:"id"`
+			Email           string `json:"email"`
+			GroupId         int    `json:"group_id"`
+			UnsubscribeTime int64  `json:"unsubscribe_time"`
+		}
+		if err := tx.Model("unsubscribe_records").
+			Fields("id, email, group_id, unsubscribe_time").
+			WhereIn("email", addresses).
+			Order("unsubscribe_time ASC, id ASC").
+			Scan(&existing); err != nil {
+			return fmt.Errorf("Failed to query the unsubscribes: %w", err)
+		}
+
+		// The earliest record of every address and list
+		earliest := make(map[string]int, len(existing))
+		for i, e := range existing {
+			key := suppressionKey(Suppression{Address: e.Email, Scope: SuppressionScopeList, ListId: e.GroupId})
+			if _, ok := earliest[key]; !ok {
+				earliest[key] = i
+			}
+		}
+
+		for _, s := range list {
+			if i, ok := earliest[suppressionKey(s)]; !ok {
+				if _, err := tx.Model("unsubscribe_records").Data(g.Map{
+					"email":            s.Address,
+					"group_id":         s.ListId,
+					"unsubscribe_time": s.SuppressedAt.Unix(),
+					"reason":           s.Reason,
+				}).Insert(); err != nil {
+					return fmt.Errorf("Failed to record unsubscribe: %w", err)
+				}
+				result.Added++
+			} else if e := existing[i]; s.SuppressedAt.Unix() < e.UnsubscribeTime {
+				if _, err := tx.Model("unsubscribe_records").Where("id", e.Id).Data(g.Map{
+					"unsubscribe_time": s.SuppressedAt.Unix(),
+					"reason":           s.Reason,
+				}).Update(); err != nil {
+					return fmt.Errorf("Failed to update unsubscribe: %w", err)
+				}
+				result.Updated++
+			} else {
+				result.Unchanged++
+			}
+
+			// A list suppression is effective through the inactive contact
+			if _, err := tx.Model("bm_contacts").
+				Where("email", s.Address).
+				Where("group_id", s.ListId).
+				Data(g.Map{"active": 0}).
+				Update(); err != nil {
+				return fmt.Errorf("Failed to unsubscribe contact: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package abnormal_recipient
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSuppressionsDedupKeepsEarliest(t *testing.T) {
+	input := "address,reason,suppressed_at,scope,list_id\r\n" +
+		"bob@example.com,Complaint,2025-03-01T00:00:00Z,global,\r\n" +
+		"BOB@example.com,Hard bounce,2025-01-01T00:00:00Z,global,\r\n" +
+		"bob@example.com,Unsubscribed,2025-02-01T00:00:00Z,list,7\r\n" +
+		"not-an-address,,,,\r\n" +
+		"carol@example.com,Unsubscribed,1735689600,list,\r\n"
+
+	list, result, err := parseSuppressions(context.Background(), strings.NewReader(input), SuppressionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Read != 5 || result.Duplicates != 1 || result.Invalid != 2 || len(list) != 2 {
+		t.Fatalf("result %+v, %d suppressions", result, len(list))
+	}
+	if list[0].Reason != "Hard bounce" || !list[0].SuppressedAt.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("earliest suppression not kept: %+v", list[0])
+	}
+	if list[1].Scope != SuppressionScopeList || list[1].ListId != 7 {
+		t.Errorf("list suppression: %+v", list[1])
+	}
+
+	// Sharing with another list, the list given on import wins
+	list, result, err = parseSuppressions(context.Background(), strings.NewReader(input), SuppressionOptions{Scope: SuppressionScopeList, GroupId: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || result.Duplicates != 2 {
+		t.Fatalf("result %+v, %d suppressions", result, len(list))
+	}
+	for _, s := range list {
+		if s.Scope != SuppressionScopeList || s.ListId != 9 {
+			t.Errorf("not moved to list 9: %+v", s)
+		}
+	}
+	if list[0].Reason != "Hard bounce" {
+		t.Errorf("earliest suppression not kept: %+v", list[0])
+	}
+}
+
+func TestSuppressionsJSONRoundTrip(t *testing.T) {
+	want := []Suppression{
+		{Address: "bob@example.com", Reason: "Hard bounce", SuppressedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), Scope: SuppressionScopeGlobal},
+		{Address: "carol@example.com", Reason: "Unsubscribed", SuppressedAt: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), Scope: SuppressionScopeList, ListId: 12},
+	}
+
+	for _, format := range []string{SuppressionFormatCSV, SuppressionFormatJSON} {
+		var buf bytes.Buffer
+		out, err := newSuppressionWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range want {
+			if err = out.write(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = out.close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The format is detected on import
+		got, result, err := parseSuppressions(context.Background(), &buf, SuppressionOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if result.Invalid != 0 || len(got) != len(want) {
+			t.Fatalf("%s: %+v", format, result)
+		}
+		for i := range want {
+			if got[i].Address != want[i].Address || got[i].Reason != want[i].Reason || got[i].Scope != want[i].Scope ||
+				got[i].ListId != want[i].ListId || !got[i].SuppressedAt.Equal(want[i].SuppressedAt) {
+				t.Errorf("%s: got %+v, want %+v", format, got[i], want[i])
+			}
+		}
+	}
+}
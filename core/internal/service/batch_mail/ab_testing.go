@@ -0,0 +1,338 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/model/entity"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// A/B subject test of a task: the variant subjects are sent round-robin to a random test
+// cohort, after the cohort is sent and the wait has elapsed the variant with the best
+// open or click rate wins and the remaining recipients get its subject.
+
+const (
+	ABMetricOpen  = "open"
+	ABMetricClick = "click"
+
+	abStatusPending = 0
+	abStatusTesting = 1
+	abStatusDecided = 2
+
+	maxABVariants = 10
+)
+
+// ABTestConfig A/B test settings of a task
+type ABTestConfig struct {
+	Subjects     []string // variant subjects, at least two
+	TestFraction int      // percentage of the recipients in the test cohort
+	Metric       string   // ABMetricOpen or ABMetricClick
+	WaitSeconds  int      // wait after the test cohort is sent before choosing the winner
+}
+
+// ABTest A/B test of a task with its variants and results
+type ABTest struct {
+	entity.EmailTaskAbTest
+	WinnerSubject string                       `json:"winner_subject"`
+	Variants      []*entity.EmailTaskAbVariant `json:"variants"`
+}
+
+// subjectFor subject of a recipient, ok is false when the task subject applies
+func (t *ABTest) subjectFor(variant int) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+
+	if variant < 0 {
+		if t.Status != abStatusDecided {
+			return "", false
+		}
+		variant = t.Winner
+	}
+
+	for _, v := range t.Variants {
+		if v.Variant == variant {
+			return v.Subject, true
+		}
+	}
+
+	return "", false
+}
+
+// SetABTest defines the A/B test of a task that has not started yet, no subjects removes it
+func SetABTest(ctx context.Context, taskId int, cfg ABTestConfig) error {
+	task, err := GetTaskInfo(ctx, taskId)
+	if err != nil {
+		return err
+	}
+	if task == nil || task.Id == 0 {
+		return fmt.Errorf("task %d not found", taskId)
+	}
+	if task.TaskProcess != 0 {
+		return fmt.Errorf("the A/B test can only be changed before the task starts sending")
+	}
+
+	subjects := make([]string, 0, len(cfg.Subjects))
+	for _, subject := range cfg.Subjects {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			subjects = append(subjects, subject)
+		}
+	}
+
+	if len(subjects) > 0 {
+		if len(subjects) < 2 {
+			return fmt.Errorf("an A/B test needs at least two subjects")
+		}
+		if len(subjects) > maxABVariants {
+			return fmt.Errorf("an A/B test has at most %d subjects", maxABVariants)
+		}
+		if cfg.TestFraction < 1 || cfg.TestFraction > 99 {
+			return fmt.Errorf("the test fraction must be between 1 and 99 percent")
+		}
+		if cfg.Metric != ABMetricOpen && cfg.Metric != ABMetricClick {
+			return fmt.Errorf("unknown winning metric: %s", cfg.Metric)
+		}
+		if cfg.WaitSeconds < 0 {
+			return fmt.Errorf("the wait must not be negative")
+		}
+	}
+
+	return g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		if _, err := tx.Model("email_task_ab_variants").Where("task_id", taskId).Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.Model("email_task_ab_tests").Where("task_id", taskId).Delete(); err != nil {
+			return err
+		}
+
+		if len(subjects) == 0 {
+			return nil
+		}
+
+		_, err := tx.Model("email_task_ab_tests").Insert(g.Map{
+			"task_id":       taskId,
+			"test_fraction": cfg.TestFraction,
+			"metric":        cfg.Metric,
+			"wait_seconds":  cfg.WaitSeconds,
+			"status":        abStatusPending,
+			"winner":        -1,
+		})
+		if err != nil {
+			return err
+		}
+
+		variants := make(g.List, 0, len(subjects))
+		for i, subject := range subjects {
+			variants = append(variants, g.Map{"task_id": taskId, "variant": i, "subject": subject})
+		}
+
+		_, err = tx.Model("email_task_ab_variants").Insert(variants)
+		return err
+	})
+}
+
+// GetABTest returns the A/B test of a task, nil when it has none
+func GetABTest(ctx context.Context, taskId int) (*ABTest, error) {
+	var test ABTest
+
+	record, err := g.DB().Model("email_task_ab_tests").Where("task_id", taskId).One()
+	if err != nil || record.IsEmpty() {
+		return nil, err
+	}
+	if err = record.Struct(&test.EmailTaskAbTest); err != nil {
+		return nil, err
+	}
+
+	err = g.DB().Model("email_task_ab_variants").
+		Where("task_id", taskId).
+		Order("variant ASC").
+		Scan(&test.Variants)
+	if err != nil {
+		return nil, err
+	}
+
+	if test.Status == abStatusDecided {
+		test.WinnerSubject, _ = test.subjectFor(test.Winner)
+	}
+
+	return &test, nil
+}
+
+// prepareABTest moves the A/B test of the task along before recipients are fetched:
+// the cohort is assigned when the task starts, the winner chosen once the cohort is
+// sent and the wait has elapsed. nil when the task has no A/B test
+func (e *TaskExecutor) prepareABTest(ctx context.Context, task *entity.EmailTask) (*ABTest, error) {
+	test, err := GetABTest(ctx, task.Id)
+	if err != nil || test == nil {
+		return nil, err
+	}
+
+	if len(test.Variants) < 2 {
+		g.Log().Warningf(ctx, "Task %d: A/B test without variants, ignored", task.Id)
+		return nil, nil
+	}
+
+	switch test.Status {
+	case abStatusPending:
+		if err = assignABCohort(ctx, test); err != nil {
+			return nil, fmt.Errorf("failed to assign the A/B test cohort: %w", err)
+		}
+
+	case abStatusTesting:
+		pending, err := g.DB().Model("recipient_info").
+			Where("task_id", task.Id).
+			Where("ab_variant >= 0").
+			WhereNot("is_sent", 1).
+			Count()
+		if err != nil {
+			return nil, err
+		}
+		if pending > 0 {
+			break
+		}
+
+		lastSent, err := g.DB().Model("recipient_info").
+			Where("task_id", task.Id).
+			Where("ab_variant >= 0").
+			Max("sent_time")
+		if err != nil {
+			return nil, err
+		}
+
+		if due := int64(lastSent) + int64(test.WaitSeconds); time.Now().Unix() < due {
+			g.Log().Debugf(ctx, "Task %d: waiting for the A/B test results until %s", task.Id, time.Unix(due, 0).Format("2006-01-02 15:04:05"))
+			break
+		}
+
+		if err = evaluateABTest(ctx, test); err != nil {
+			return nil, fmt.Errorf("failed to evaluate the A/B test: %w", err)
+		}
+	}
+
+	return test, nil
+}
+
+// assignABCohort spreads the variants round-robin over a random sample of the recipients
+func assignABCohort(ctx context.Context, test *ABTest) error {
+	return g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		total, err := tx.Model("recipient_info").Where("task_id", test.TaskId).Count()
+		if err != nil {
+			return err
+		}
+
+		size := (total*test.TestFraction + 99) / 100
+		if size < len(test.Variants) {
+			size = len(test.Variants)
+		}
+
+		_, err = tx.Exec(`UPDATE recipient_info SET ab_variant = c.rn % ?
+			FROM (
+				SELECT id, ROW_NUMBER() OVER () - 1 AS rn FROM (
+					SELECT id FROM recipient_info WHERE task_id = ? AND is_sent = 0 ORDER BY random() LIMIT ?
+				) s
+			) c
+			WHERE recipient_info.id = c.id`, len(test.Variants), test.TaskId, size)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().Unix()
+		_, err = tx.Model("email_task_ab_tests").
+			Where("task_id", test.TaskId).
+			Data(g.Map{"status": abStatusTesting, "test_started_at": now}).
+			Update()
+		if err != nil {
+			return err
+		}
+
+		test.Status = abStatusTesting
+		test.TestStartedAt = int(now)
+
+		g.Log().Infof(ctx, "Task %d: A/B test of %d subjects started on %d of %d recipients", test.TaskId, len(test.Variants), min(size, total), total)
+		return nil
+	})
+}
+
+// evaluateABTest records the results of the variants and the winner
+func evaluateABTest(ctx context.Context, test *ABTest) error {
+	var stats []struct {
+		Variant int `json:"variant"`
+		Sent    int `json:"sent"`
+		Opened  int `json:"opened"`
+		Clicked int `json:"clicked"`
+	}
+
+	err := g.DB().GetScan(ctx, &stats, `SELECT ri.ab_variant AS variant, COUNT(1) AS sent,
+			COUNT(o.message_id) AS opened, COUNT(c.message_id) AS clicked
+		FROM recipient_info ri
+		LEFT JOIN (SELECT DISTINCT message_id FROM mailstat_opened WHERE campaign_id = ?) o ON o.message_id = ri.message_id
+		LEFT JOIN (SELECT DISTINCT message_id FROM mailstat_clicked WHERE campaign_id = ?) c ON c.message_id = ri.message_id
+		WHERE ri.task_id = ? AND ri.ab_variant >= 0 AND ri.is_sent = 1
+		GROUP BY ri.ab_variant`, test.TaskId, test.TaskId, test.TaskId)
+	if err != nil {
+		return err
+	}
+
+	byVariant := make(map[int]int, len(stats))
+	for i, s := range stats {
+		byVariant[s.Variant] = i
+	}
+
+	winner, best := 0, -1.0
+	for _, v := range test.Variants {
+		v.Sent, v.Opened, v.Clicked, v.Rate = 0, 0, 0, 0
+		if i, ok := byVariant[v.Variant]; ok {
+			v.Sent, v.Opened, v.Clicked = stats[i].Sent, stats[i].Opened, stats[i].Clicked
+		}
+
+		if v.Sent > 0 {
+			hits := v.Opened
+			if test.Metric == ABMetricClick {
+				hits = v.Clicked
+			}
+			v.Rate = float64(hits) / float64(v.Sent) * 100
+		}
+
+		// Ties go to the earlier variant
+		if v.Rate > best {
+			winner, best = v.Variant, v.Rate
+		}
+	}
+
+	now := time.Now().Unix()
+
+	err = g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		for _, v := range test.Variants {
+			_, err := tx.Model("email_task_ab_variants").
+				Where("task_id", test.TaskId).
+				Where("variant", v.Variant).
+				Data(g.Map{"sent": v.Sent, "opened": v.Opened, "clicked": v.Clicked, "rate": v.Rate}).
+				Update()
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Model("email_task_ab_tests").
+			Where("task_id", test.TaskId).
+			Data(g.Map{"status": abStatusDecided, "winner": winner, "evaluated_at": now}).
+			Update()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	test.Status = abStatusDecided
+	test.Winner = winner
+	test.EvaluatedAt = int(now)
+	test.WinnerSubject, _ = test.subjectFor(winner)
+
+	g.Log().Infof(ctx, "Task %d: A/B test won by variant %d (%s rate %.2f%%), sending %q to the remaining recipients",
+		test.TaskId, winner, test.Metric, best, test.WinnerSubject)
+	return nil
+}
@@ -0,0 +1,230 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/mail_service"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Transactional batches: one API template sent right away to many recipients, each with
+// its own properties and headers. Recipients are handled by chunks, a chunk is rendered
+// and sent before the next one is read, so memory does not grow with the batch. The
+// SMTP connections are reused for the recipients of the same domain and the sending
+// speed is bounded by a rate controller. Every message is recorded in api_mail_logs with
+// its final status, its Message-ID tracks it in the mail logs and statistics.
+
+const (
+	transactionalChunkSize    = 100 // recipients rendered and sent at once
+	transactionalWorkers      = 5   // messages sent at the same time
+	transactionalMaxPerMinute = 600
+)
+
+// RecipientData one recipient of a transactional batch
+type RecipientData struct {
+	Recipient string            `json:"recipient"`
+	Headers   map[string]string `json:"headers"` // custom headers of this message
+	Attribs   map[string]string `json:"attribs"` // template properties of this message
+}
+
+// RecipientResult outcome of one recipient of a batch
+type RecipientResult struct {
+	Recipient string `json:"recipient"`
+	Accepted  bool   `json:"accepted"` // accepted by the mail server
+	MessageId string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BatchResult outcome of a batch, the results are in the order of the recipients
+type BatchResult struct {
+	Accepted int               `json:"accepted"`
+	Failed   int               `json:"failed"`
+	Results  []RecipientResult `json:"results"`
+}
+
+// SendBatch renders the API template templateID for each recipient and sends it. The
+// error is only set when nothing can be sent, the failures of single recipients are in
+// their results
+func SendBatch(ctx context.Context, templateID int, recipients []RecipientData) (BatchResult, error) {
+	result := BatchResult{Results: make([]RecipientResult, len(recipients))}
+
+	var apiTemplate entity.ApiTemplates
+	if err := g.DB().Model("api_templates").Ctx(ctx).Where("id", templateID).Where("active", 1).Scan(&apiTemplate); err != nil || apiTemplate.Id == 0 {
+		return result, fmt.Errorf("API template %d not found or inactive", templateID)
+	}
+
+	var emailTemplate entity.EmailTemplate
+	if err := g.DB().Model("email_templates").Ctx(ctx).Where("id", apiTemplate.TemplateId).Scan(&emailTemplate); err != nil || emailTemplate.Id == 0 {
+		return result, fmt.Errorf("email template %d not found", apiTemplate.TemplateId)
+	}
+
+	pool := mail_service.NewSenderPool(mail_service.SenderPoolConfig{})
+	defer pool.Close()
+
+	b := &transactionalBatch{
+		apiTemplate:   apiTemplate,
+		emailTemplate: emailTemplate,
+		pool:          pool,
+		rate:          NewSimpleRateController(transactionalMaxPerMinute),
+	}
+
+	for start := 0; start < len(recipients); start += transactionalChunkSize {
+		end := start + transactionalChunkSize
+		if end > len(recipients) {
+			end = len(recipients)
+		}
+
+		if ctx.Err() != nil {
+			for i := start; i < len(recipients); i++ {
+				result.Results[i] = RecipientResult{Recipient: recipients[i].Recipient, Error: ctx.Err().Error()}
+			}
+			break
+		}
+
+		b.sendChunk(ctx, recipients[start:end], result.Results[start:end])
+	}
+
+	for _, r := range result.Results {
+		if r.Accepted {
+			result.Accepted++
+		} else {
+			result.Failed++
+		}
+	}
+
+	return result, nil
+}
+
+// transactionalBatch state shared by the chunks of a batch
+type transactionalBatch struct {
+	apiTemplate   entity.ApiTemplates
+	emailTemplate entity.EmailTemplate
+	pool          *mail_service.SenderPool
+	rate          *SimpleRateController
+}
+
+// sendChunk sends the messages of a chunk of recipients and records them
+func (b *transactionalBatch) sendChunk(ctx context.Context, recipients []RecipientData, results []RecipientResult) {
+	emails := make([]string, 0, len(recipients))
+	for i := range recipients {
+		recipients[i].Recipient = strings.TrimSpace(recipients[i].Recipient)
+		emails = append(emails, recipients[i].Recipient)
+	}
+
+	var contacts []entity.Contact
+	if err := g.DB().Model("bm_contacts").Ctx(ctx).WhereIn("email", emails).Scan(&contacts); err != nil {
+		g.Log().Warningf(ctx, "Failed to load the contacts of a transactional batch: %v", err)
+	}
+	byEmail := make(map[string]entity.Contact, len(contacts))
+	for _, c := range contacts {
+		byEmail[c.Email] = c
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < transactionalWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = b.send(ctx, recipients[i], byEmail[recipients[i].Recipient])
+			}
+		}()
+	}
+	for i := range recipients {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	now := time.Now().Unix()
+	logs := make([]g.Map, 0, len(results))
+	for i, r := range results {
+		if r.MessageId == "" {
+			continue
+		}
+
+		status, errorMessage := StatusSuccess, ""
+		if !r.Accepted {
+			status, errorMessage = StatusFailed, r.Error
+		}
+
+		headers := recipients[i].Headers
+		if headers == nil {
+			headers = map[string]string{}
+		}
+
+		logs = append(logs, g.Map{
+			"api_id":        b.apiTemplate.Id,
+			"recipient":     r.Recipient,
+			"message_id":    r.MessageId,
+			"addresser":     b.apiTemplate.Addresser,
+			"status":        status,
+			"error_message": errorMessage,
+			"send_time":     now,
+			"create_time":   now,
+			"attribs":       recipients[i].Attribs,
+			"headers":       headers,
+		})
+	}
+
+	if len(logs) > 0 {
+		if _, err := g.DB().Model("api_mail_logs").Ctx(ctx).Insert(logs); err != nil {
+			g.Log().Errorf(ctx, "Failed to record the messages of a transactional batch: %v", err)
+		}
+	}
+}
+
+// send renders and sends the message of one recipient
+func (b *transactionalBatch) send(ctx context.Context, r RecipientData, contact entity.Contact) RecipientResult {
+	result := RecipientResult{Recipient: r.Recipient}
+
+	if r.Recipient == "" || !strings.Contains(r.Recipient, "@") {
+		result.Error = "invalid recipient"
+		return result
+	}
+	if err := ValidateCustomHeaders(r.Headers); err != nil {
+		result.Error = "invalid headers: " + err.Error()
+		return result
+	}
+
+	if err := b.rate.Wait(ctx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	sender, err := b.pool.Get(b.apiTemplate.Addresser, r.Recipient)
+	if err != nil {
+		result.Error = "failed to create sender: " + err.Error()
+		return result
+	}
+
+	log := ApiMailLog{
+		ApiId:     b.apiTemplate.Id,
+		Recipient: r.Recipient,
+		Addresser: b.apiTemplate.Addresser,
+		MessageId: strings.Trim(sender.GenerateMessageID(), "<>"),
+		Attribs:   r.Attribs,
+		Headers:   r.Headers,
+	}
+	result.MessageId = log.MessageId
+
+	content, subject := processMailContentAndSubject(ctx, b.emailTemplate.Content, b.apiTemplate.Subject, &b.apiTemplate, contact, log)
+
+	err = sendApiMailWithSender(ctx, &b.apiTemplate, subject, content, log, sender)
+	b.pool.Put(sender, err)
+	b.rate.RecordSend()
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Accepted = true
+	return result
+}
@@ -0,0 +1,93 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/mail_service"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxCustomHeaders     = 50
+	maxCustomHeaderValue = 998 // line length limit of RFC 5322
+)
+
+// reservedHeaders headers set by the send path, callers cannot override them
+var reservedHeaders = map[string]bool{
+	"from":                      true,
+	"to":                        true,
+	"cc":                        true,
+	"bcc":                       true,
+	"subject":                   true,
+	"date":                      true,
+	"sender":                    true,
+	"return-path":               true,
+	"message-id":                true,
+	"mime-version":              true,
+	"content-type":              true,
+	"content-transfer-encoding": true,
+	"realname":                  true,
+}
+
+// ValidateCustomHeaders checks the custom headers of an API message: names are RFC 5322
+// field names outside the reserved ones, values carry no line breaks
+func ValidateCustomHeaders(headers map[string]string) error {
+	if len(headers) > maxCustomHeaders {
+		return fmt.Errorf("at most %d custom headers are allowed", maxCustomHeaders)
+	}
+
+	for name, value := range headers {
+		if name == "" {
+			return fmt.Errorf("empty header name")
+		}
+
+		for i := 0; i < len(name); i++ {
+			// printable US-ASCII except the colon
+			if c := name[i]; c < 33 || c > 126 || c == ':' {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+		}
+
+		if reservedHeaders[strings.ToLower(name)] {
+			return fmt.Errorf("header %s cannot be set", name)
+		}
+
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("header %s contains a line break", name)
+		}
+
+		if len(name)+2+len(value) > maxCustomHeaderValue {
+			return fmt.Errorf("header %s is too long", name)
+		}
+	}
+
+	return nil
+}
+
+// applyCustomHeaders adds the custom headers of an API message, the headers of the
+// send path win over them
+func applyCustomHeaders(message *mail_service.Message, headers map[string]string) error {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	// Stored before this check existed, or modified since
+	if err := ValidateCustomHeaders(headers); err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		exists := false
+		for existing := range message.Headers {
+			if strings.EqualFold(existing, key) {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			message.SetHeader(key, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package batch_mail
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Priorities of the queued API mails, so that a password reset does not wait behind a
+// bulk send. The pending messages are selected by their virtual time: the time of their
+// submission minus a head start of priorityHeadStart per priority level. A high message
+// overtakes the bulk messages submitted up to twice the head start before it, and a bulk
+// message waiting longer than that goes ahead of the new high messages, so bulk keeps
+// making progress under a steady flow of high priority mail. The bulk messages are paced
+// by their own rate controller, a high or normal message never waits for a token of the
+// bulk budget and does not consume it.
+
+const (
+	PriorityBulk   = 0
+	PriorityNormal = 1 // default of the submissions
+	PriorityHigh   = 2
+)
+
+// priorityHeadStart advance of each priority level in the queue, in seconds
+const priorityHeadStart = 5 * 60
+
+// apiBulkMaxPerMinute sending speed of the bulk API mails
+const apiBulkMaxPerMinute = 600
+
+var priorityNames = map[int]string{
+	PriorityBulk:   "bulk",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+// ParsePriority the priority level of its name, normal when empty
+func ParsePriority(name string) (int, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return PriorityNormal, nil
+	}
+	for level, n := range priorityNames {
+		if n == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid priority %q, expected high, normal or bulk", name)
+}
+
+// PriorityName the name of a priority level
+func PriorityName(level int) string {
+	if name, ok := priorityNames[level]; ok {
+		return name
+	}
+	return priorityNames[PriorityNormal]
+}
+
+// pendingOrder the selection order of the pending messages, by virtual time
+func pendingOrder() string {
+	return fmt.Sprintf("create_time - priority * %d ASC, id ASC", priorityHeadStart)
+}
+
+// MessagePriorities returns the priority name of the queued messages of postfix, keyed
+// by postfix queue ID. The API mails have the priority of their submission, the mails
+// of the campaigns are bulk and the other mails normal
+func MessagePriorities(ctx context.Context, postfixMessageIDs []string) (map[string]string, error) {
+	priorities := make(map[string]string, len(postfixMessageIDs))
+	if len(postfixMessageIDs) == 0 {
+		return priorities, nil
+	}
+
+	var rows []struct {
+		PostfixMessageId string `json:"postfix_message_id"`
+		ApiPriority      *int   `json:"api_priority"`
+		Campaign         bool   `json:"campaign"`
+	}
+	err := g.DB().Model("mailstat_message_ids mi").Ctx(ctx).
+		Fields("mi.postfix_message_id, MAX(aml.priority) AS api_priority, COUNT(ri.id) > 0 AS campaign").
+		LeftJoin("api_mail_logs aml", "aml.message_id = mi.message_id").
+		LeftJoin("recipient_info ri", "ri.message_id = mi.message_id").
+		WhereIn("mi.postfix_message_id", postfixMessageIDs).
+		Group("mi.postfix_message_id").
+		Scan(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range postfixMessageIDs {
+		priorities[id] = PriorityName(PriorityNormal)
+	}
+	for _, r := range rows {
+		switch {
+		case r.ApiPriority != nil:
+			priorities[r.PostfixMessageId] = PriorityName(*r.ApiPriority)
+		case r.Campaign:
+			priorities[r.PostfixMessageId] = PriorityName(PriorityBulk)
+		}
+	}
+
+	return priorities, nil
+}
@@ -35,7 +35,9 @@ type ApiMailLog struct {
 	Recipient string
 	Addresser string
 	MessageId string
+	Priority  int
 	Attribs   map[string]string `json:"attribs"`
+	Headers   map[string]string `json:"headers"`
 }
 
 // Cache data structure
@@ -198,20 +200,33 @@ func ProcessApiMailQueue(ctx context.Context) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeout*time.Second)
 	defer cancel()
 
-	// Process all pending emails in batches
-	lastMaxId := int64(0)
+	bulkRate := NewSimpleRateController(apiBulkMaxPerMinute)
+
+	// Process all pending emails in batches, the most urgent first
+	seen := make(map[int64]bool)
 	for {
 		// Get a batch of pending emails
-		var mailLogs []ApiMailLog
+		var pendingLogs []ApiMailLog
 		err := g.DB().Model("api_mail_logs").
 			Where("status = ?", StatusPending).
-			Where("id > ?", lastMaxId).
-			Order("id ASC").
+			Order(pendingOrder()).
 			Limit(BatchSize).
 			Ctx(ctx).
-			Scan(&mailLogs)
+			Scan(&pendingLogs)
+
+		if err != nil || len(pendingLogs) == 0 {
+			break
+		}
 
-		if err != nil || len(mailLogs) == 0 {
+		// A message whose status could not be updated stays pending, it is not sent twice
+		mailLogs := make([]ApiMailLog, 0, len(pendingLogs))
+		for _, log := range pendingLogs {
+			if !seen[log.Id] {
+				seen[log.Id] = true
+				mailLogs = append(mailLogs, log)
+			}
+		}
+		if len(mailLogs) == 0 {
 			break
 		}
 
@@ -294,6 +309,13 @@ func ProcessApiMailQueue(ctx context.Context) {
 								}
 								cache.Contacts[log.Recipient] = contact
 							}
+							if log.Priority == PriorityBulk {
+								if err := bulkRate.Wait(ctx); err != nil {
+									// Left pending for the next run
+									continue
+								}
+								bulkRate.RecordSend()
+							}
 							content, subject := processMailContentAndSubject(ctx, emailTemplate.Content, apiTemplate.Subject, &apiTemplate, contact, log)
 							err := sendApiMailWithSender(ctx, &apiTemplate, subject, content, log, sender)
 							if err != nil {
@@ -316,12 +338,7 @@ func ProcessApiMailQueue(ctx context.Context) {
 		}
 		wg.Wait()
 
-		// lastMaxId = mailLogs[len(mailLogs)-1].Id
-		if len(mailLogs) > 0 {
-			lastMaxId = mailLogs[len(mailLogs)-1].Id
-		}
-		// offset += len(mailLogs)
-		if len(mailLogs) < BatchSize {
+		if len(pendingLogs) < BatchSize {
 			break
 		}
 	}
@@ -348,6 +365,9 @@ func sendApiMailWithSender(ctx context.Context, apiTemplate *entity.ApiTemplates
 	if apiTemplate.FullName != "" {
 		message.SetRealName(apiTemplate.FullName)
 	}
+	if err := applyCustomHeaders(&message, log.Headers); err != nil {
+		return err
+	}
 
 	if err := sender.Send(message, []string{log.Recipient}); err != nil {
 		return err
@@ -446,6 +466,10 @@ func sendApiMail(ctx context.Context, apiTemplate *entity.ApiTemplates, subject
 	if apiTemplate.FullName != "" {
 		message.SetRealName(apiTemplate.FullName)
 	}
+	if err = applyCustomHeaders(&message, log.Headers); err != nil {
+		updateLogStatus(ctx, log.Id, 3, err.Error())
+		return err
+	}
 	// send email
 
 	err = sender.Send(message, []string{log.Recipient})
@@ -101,6 +101,8 @@ func GetTasksWithPage(ctx context.Context, page, pageSize int, keyword string, s
 				m[val.Int()].EstimatedTimeWithWarmup, _ = warmup.WarmupCampaign().CalculateEstimatedTime(ctx, val.Int64(), serverIP)
 			}
 		}
+
+		fillSendingIdentities(ctx, list, serverIP)
 	}
 
 	return total, list, err
@@ -114,6 +116,9 @@ func DeleteTask(ctx context.Context, id int) error {
 	_, err := g.DB().Model("email_tasks").
 		Where("id", id).
 		Delete()
+	if err == nil {
+		removeSendJournal(id)
+	}
 	return err
 }
 
@@ -235,6 +240,7 @@ func ImportRecipients(ctx context.Context, taskId int, contacts []*entity.Contac
 				"sent_time":   0,
 				"message_id":  "",
 				"create_time": now,
+				"timezone":    contact.Timezone,
 			}
 		}
 
@@ -283,6 +289,7 @@ func ImportRecipientsTx(ctx context.Context, tx gdb.TX, taskId int, contacts []*
 				"sent_time":   0,
 				"message_id":  "",
 				"create_time": now,
+				"timezone":    contact.Timezone,
 			}
 		}
 		result, err := tx.Ctx(ctx).Model("recipient_info").InsertIgnore(values)
@@ -406,6 +413,20 @@ func CreateTaskWithRecipients(ctx context.Context, req *v1.CreateTaskReq, addTyp
 	var err error
 	err = g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
 
+		if req.DefaultTimezone != "" {
+			if _, e := time.LoadLocation(req.DefaultTimezone); e != nil {
+				return gerror.New(public.LangCtx(ctx, "Invalid time zone {}", req.DefaultTimezone))
+			}
+		}
+
+		if req.Category != "" && !IsMailCategory(ctx, req.Category) {
+			return gerror.New(public.LangCtx(ctx, "Unknown mail category {}", req.Category))
+		}
+
+		if _, e := ResolveSendingIdentity(ctx, req.Addresser, req.SendingDomain, req.SendingIp); e != nil {
+			return e
+		}
+
 		now := time.Now().Unix()
 		taskName := fmt.Sprintf("task_%d", now)
 		var tagIdsJson string
@@ -414,28 +435,33 @@ func CreateTaskWithRecipients(ctx context.Context, req *v1.CreateTaskReq, addTyp
 		}
 
 		res, e := tx.Ctx(ctx).Model("email_tasks").Insert(g.Map{
-			"task_name":       taskName,
-			"addresser":       req.Addresser,
-			"subject":         req.Subject,
-			"full_name":       req.FullName,
-			"recipient_count": 0,
-			"task_process":    0,
-			"pause":           0,
-			"template_id":     req.TemplateId,
-			"is_record":       req.IsRecord,
-			"unsubscribe":     req.Unsubscribe,
-			"threads":         req.Threads,
-			"track_open":      req.TrackOpen,
-			"track_click":     req.TrackClick,
-			"start_time":      req.StartTime,
-			"create_time":     now,
-			"update_time":     now,
-			"active":          1,
-			"remark":          req.Remark,
-			"add_type":        addType,
-			"group_id":        req.GroupId,
-			"tag_ids":         tagIdsJson,
-			"tag_logic":       req.TagLogic,
+			"task_name":        taskName,
+			"addresser":        req.Addresser,
+			"subject":          req.Subject,
+			"full_name":        req.FullName,
+			"recipient_count":  0,
+			"task_process":     0,
+			"pause":            0,
+			"template_id":      req.TemplateId,
+			"is_record":        req.IsRecord,
+			"unsubscribe":      req.Unsubscribe,
+			"threads":          req.Threads,
+			"track_open":       req.TrackOpen,
+			"track_click":      req.TrackClick,
+			"start_time":       req.StartTime,
+			"create_time":      now,
+			"update_time":      now,
+			"active":           1,
+			"remark":           req.Remark,
+			"add_type":         addType,
+			"group_id":         req.GroupId,
+			"tag_ids":          tagIdsJson,
+			"tag_logic":        req.TagLogic,
+			"send_local_hour":  req.SendLocalHour,
+			"default_timezone": req.DefaultTimezone,
+			"category":         req.Category,
+			"sending_domain":   strings.ToLower(strings.TrimSpace(req.SendingDomain)),
+			"sending_ip":       strings.TrimSpace(req.SendingIp),
 		})
 		if e != nil {
 			return gerror.New(public.LangCtx(ctx, "Failed to create task {}", e.Error()))
@@ -544,12 +570,19 @@ func UpdateTaskPauseStatus(ctx context.Context, taskId int, isPaused bool) error
 		processValue = 3
 	}
 
+	data := g.Map{
+		"pause":        pauseValue,
+		"task_process": processValue,
+	}
+	if !isPaused {
+		// The circuit breaker starts a new window, the messages sent before the resume no longer count
+		data["pause_reason"] = ""
+		data["breaker_since"] = time.Now().Unix()
+	}
+
 	_, err := g.DB().Model("email_tasks").
 		Where("id", taskId).
-		Data(g.Map{
-			"pause":        pauseValue,
-			"task_process": processValue,
-		}).
+		Data(data).
 		Update()
 
 	if err != nil {
@@ -0,0 +1,370 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/abnormal_recipient"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Per-recipient results of a campaign for import into a CRM: the delivery outcome from
+// the mail log statistics and the opens and clicks of the tracking. The recipients are
+// read and written page by page, so large campaigns are streamed rather than buffered.
+
+// Export formats of the campaign results
+const (
+	CampaignResultsCSV    = "csv"
+	CampaignResultsJSON   = "json"
+	CampaignResultsCSVGz  = "csv.gz"
+	CampaignResultsJSONGz = "json.gz"
+)
+
+// campaignResultsPageSize recipients read per query
+const campaignResultsPageSize = 1000
+
+// Delivery states of a recipient
+const (
+	RecipientStatusPending   = "pending"   // not sent yet
+	RecipientStatusSent      = "sent"      // handed to postfix, no delivery logged yet
+	RecipientStatusDelivered = "delivered" // accepted by the receiving server
+
+	RecipientStatusFrequencyCapped = "frequency_capped" // skipped, over the frequency cap
+	RecipientStatusOptedOut        = "opted_out"        // skipped, opted out of the category
+)
+
+var campaignResultColumns = []string{
+	"address", "status", "bounce_type", "dsn", "open_count", "click_count",
+	"sent_at", "status_at", "first_open_at", "last_open_at", "first_click_at", "last_click_at",
+}
+
+// CampaignResult outcome of one recipient of a campaign. Times are zero when the event
+// did not happen
+type CampaignResult struct {
+	Address      string    `json:"address"`
+	Status       string    `json:"status"`      // pending, sent, delivered or the postfix status (bounced, deferred, expired...)
+	BounceType   string    `json:"bounce_type"` // hard_bounce, soft_bounce or empty
+	Dsn          string    `json:"dsn"`
+	OpenCount    int       `json:"open_count"`
+	ClickCount   int       `json:"click_count"`
+	SentAt       time.Time `json:"sent_at"`
+	StatusAt     time.Time `json:"status_at"` // time of the logged delivery status
+	FirstOpenAt  time.Time `json:"first_open_at"`
+	LastOpenAt   time.Time `json:"last_open_at"`
+	FirstClickAt time.Time `json:"first_click_at"`
+	LastClickAt  time.Time `json:"last_click_at"`
+}
+
+// deliveryOutcome status and bounce type of a recipient from its send state and the
+// logged postfix status
+func deliveryOutcome(isSent int, status, dsn string) (string, string) {
+	if isSent != 1 {
+		return RecipientStatusPending, ""
+	}
+
+	switch {
+	case status == "":
+		return RecipientStatusSent, ""
+	case status == "sent":
+		return RecipientStatusDelivered, ""
+	case status == "bounced" && strings.HasPrefix(dsn, "5."):
+		return status, abnormal_recipient.BounceTypeHard
+	case status == "bounced" || status == "deferred" || status == "expired":
+		return status, abnormal_recipient.BounceTypeSoft
+	}
+	return status, ""
+}
+
+// campaignResultWriter encodes the results in one of the export formats
+type campaignResultWriter interface {
+	write(r CampaignResult) error
+	close() error
+}
+
+type csvCampaignResultWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvCampaignResultWriter) write(r CampaignResult) error {
+	return c.w.Write([]string{
+		r.Address, r.Status, r.BounceType, r.Dsn, strconv.Itoa(r.OpenCount), strconv.Itoa(r.ClickCount),
+		formatResultTime(r.SentAt), formatResultTime(r.StatusAt),
+		formatResultTime(r.FirstOpenAt), formatResultTime(r.LastOpenAt),
+		formatResultTime(r.FirstClickAt), formatResultTime(r.LastClickAt),
+	})
+}
+
+func (c *csvCampaignResultWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonCampaignResultWriter writes a JSON array one element at a time
+type jsonCampaignResultWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonCampaignResultWriter) write(r CampaignResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	j.count++
+
+	if _, err = io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonCampaignResultWriter) close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+func formatResultTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func unixOrZero(sec int64) time.Time {
+	if sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// newCampaignResultWriter the writer of format, with the gzip stream to close after it
+// for the compressed formats
+func newCampaignResultWriter(w io.Writer, format string) (campaignResultWriter, io.Closer, error) {
+	var compressed io.Closer
+
+	base, gz := strings.CutSuffix(format, ".gz")
+	if base != CampaignResultsCSV && base != CampaignResultsJSON {
+		return nil, nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	if gz {
+		zw := gzip.NewWriter(w)
+		w, compressed = zw, zw
+	}
+
+	if base == CampaignResultsJSON {
+		return &jsonCampaignResultWriter{w: w}, compressed, nil
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(campaignResultColumns); err != nil {
+		return nil, nil, err
+	}
+	return &csvCampaignResultWriter{w: cw}, compressed, nil
+}
+
+// ExportCampaignResults streams the per-recipient results of a campaign to w in format
+// (csv, json, or csv.gz and json.gz for gzip compressed output)
+func ExportCampaignResults(ctx context.Context, campaignID int, format string, w io.Writer) error {
+	task, err := GetTaskInfo(ctx, campaignID)
+	if err != nil {
+		return err
+	}
+	if task == nil || task.Id == 0 {
+		return fmt.Errorf("task %d not found", campaignID)
+	}
+
+	out, compressed, err := newCampaignResultWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	lastId := 0
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		results, last, err := campaignResultsPage(ctx, campaignID, lastId)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range results {
+			if err = out.write(r); err != nil {
+				return err
+			}
+		}
+
+		if len(results) < campaignResultsPageSize {
+			break
+		}
+		lastId = last
+	}
+
+	if err = out.close(); err != nil {
+		return err
+	}
+	if compressed != nil {
+		return compressed.Close()
+	}
+	return nil
+}
+
+// campaignResultsPage the results of the recipients after lastId, with the id of the last one
+func campaignResultsPage(ctx context.Context, campaignID, lastId int) ([]CampaignResult, int, error) {
+	var recipients []struct {
+		Id        int    `json:"id"`
+		Recipient string `json:"recipient"`
+		IsSent    int    `json:"is_sent"`
+		SentTime  int64  `json:"sent_time"`
+		MessageId string `json:"message_id"`
+		Capped    int    `json:"frequency_capped"`
+		OptedOut  int    `json:"opted_out"`
+	}
+
+	err := g.DB().Model("recipient_info").Ctx(ctx).
+		Fields("id, recipient, is_sent, sent_time, message_id, frequency_capped, opted_out").
+		Where("task_id", campaignID).
+		Where("id > ?", lastId).
+		Order("id ASC").
+		Limit(campaignResultsPageSize).
+		Scan(&recipients)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to query the recipients: %w", err)
+	}
+	if len(recipients) == 0 {
+		return nil, lastId, nil
+	}
+
+	messageIds := make([]string, 0, len(recipients))
+	addresses := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		if r.MessageId != "" {
+			messageIds = append(messageIds, r.MessageId)
+		}
+		addresses = append(addresses, r.Recipient)
+	}
+
+	// The latest logged status of every message
+	deliveries := make(map[string]delivery)
+	if len(messageIds) > 0 {
+		rows, err := g.DB().GetAll(ctx, `SELECT DISTINCT ON (mi.message_id) mi.message_id, sm.status, sm.dsn, sm.log_time
+			FROM mailstat_message_ids mi JOIN mailstat_send_mails sm ON sm.postfix_message_id = mi.postfix_message_id
+			WHERE mi.message_id IN (?)
+			ORDER BY mi.message_id, sm.log_time_millis DESC`, messageIds)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Failed to query the delivery status: %w", err)
+		}
+
+		for _, row := range rows {
+			deliveries[row["message_id"].String()] = delivery{
+				status: row["status"].String(),
+				dsn:    row["dsn"].String(),
+				at:     row["log_time"].Int64(),
+			}
+		}
+	}
+
+	opens, err := campaignEngagement(ctx, "mailstat_opened", campaignID, addresses)
+	if err != nil {
+		return nil, 0, err
+	}
+	clicks, err := campaignEngagement(ctx, "mailstat_clicked", campaignID, addresses)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]CampaignResult, 0, len(recipients))
+	for _, r := range recipients {
+		d := deliveries[r.MessageId]
+		status, bounceType := deliveryOutcome(r.IsSent, d.status, d.dsn)
+		if r.Capped == 1 {
+			status = RecipientStatusFrequencyCapped
+		}
+		if r.OptedOut == 1 {
+			status = RecipientStatusOptedOut
+		}
+
+		open := opens[strings.ToLower(r.Recipient)]
+		click := clicks[strings.ToLower(r.Recipient)]
+
+		results = append(results, CampaignResult{
+			Address:      r.Recipient,
+			Status:       status,
+			BounceType:   bounceType,
+			Dsn:          d.dsn,
+			OpenCount:    open.count,
+			ClickCount:   click.count,
+			SentAt:       unixOrZero(r.SentTime),
+			StatusAt:     unixOrZero(d.at),
+			FirstOpenAt:  unixOrZero(open.first),
+			LastOpenAt:   unixOrZero(open.last),
+			FirstClickAt: unixOrZero(click.first),
+			LastClickAt:  unixOrZero(click.last),
+		})
+	}
+
+	return results, recipients[len(recipients)-1].Id, nil
+}
+
+// delivery logged postfix status of a message
+type delivery struct {
+	status, dsn string
+	at          int64
+}
+
+// engagement tracked opens or clicks of a recipient
+type engagement struct {
+	count       int
+	first, last int64
+}
+
+// campaignEngagement the tracked events of table (mailstat_opened or mailstat_clicked)
+// of the campaign by lowercased recipient
+func campaignEngagement(ctx context.Context, table string, campaignID int, addresses []string) (map[string]engagement, error) {
+	rows, err := g.DB().Model(table).Ctx(ctx).
+		Fields("LOWER(recipient) AS recipient, COUNT(*) AS events, MIN(log_time) AS first_at, MAX(log_time) AS last_at").
+		Where("campaign_id", campaignID).
+		WhereIn("LOWER(recipient)", lowerAll(addresses)).
+		Group("LOWER(recipient)").
+		All()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query %s: %w", table, err)
+	}
+
+	result := make(map[string]engagement, len(rows))
+	for _, row := range rows {
+		result[row["recipient"].String()] = engagement{
+			count: row["events"].Int(),
+			first: row["first_at"].Int64(),
+			last:  row["last_at"].Int64(),
+		}
+	}
+	return result, nil
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(v)
+	}
+	return lowered
+}
@@ -0,0 +1,219 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Circuit breaker of the campaigns: while a campaign is sending, its bounce and complaint
+// rates over the messages sent in the last WindowMinutes are checked every minute. Once
+// at least MinSent messages are in the window, a rate past its maximum pauses the
+// campaign with the reason and the operators are notified. A campaign resumed by hand
+// starts a new window, so it is not paused again for the messages sent before.
+
+const circuitBreakerOptionKey = "campaign_circuit_breaker"
+
+// CircuitBreakerConfig thresholds of the breaker, rates in percent, a rate of 0 is not checked
+type CircuitBreakerConfig struct {
+	Enabled          bool    `json:"enabled"`
+	MinSent          int     `json:"min_sent"`           // messages in the window before the rates count
+	WindowMinutes    int     `json:"window_minutes"`     // rolling window of the rates
+	MaxBounceRate    float64 `json:"max_bounce_rate"`    // percent of the sent messages bounced
+	MaxComplaintRate float64 `json:"max_complaint_rate"` // percent of the sent messages reported as spam
+}
+
+// DefaultCircuitBreakerConfig disabled, with thresholds above the usual mailbox provider limits
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Enabled:          false,
+		MinSent:          200,
+		WindowMinutes:    60,
+		MaxBounceRate:    5,
+		MaxComplaintRate: 0.3,
+	}
+}
+
+// GetCircuitBreakerConfig returns the configured thresholds, the defaults when unset
+func GetCircuitBreakerConfig(ctx context.Context) CircuitBreakerConfig {
+	cfg := DefaultCircuitBreakerConfig()
+	_ = public.OptionsMgrInstance.GetOption(ctx, circuitBreakerOptionKey, &cfg)
+	return cfg
+}
+
+// SetCircuitBreakerConfig validates and saves the thresholds
+func SetCircuitBreakerConfig(ctx context.Context, cfg CircuitBreakerConfig) error {
+	if cfg.MinSent < 1 || cfg.WindowMinutes < 1 {
+		return fmt.Errorf("invalid circuit breaker window: %d messages over %d minutes", cfg.MinSent, cfg.WindowMinutes)
+	}
+	if cfg.MaxBounceRate < 0 || cfg.MaxBounceRate > 100 || cfg.MaxComplaintRate < 0 || cfg.MaxComplaintRate > 100 {
+		return fmt.Errorf("invalid circuit breaker rates: bounce %g%%, complaint %g%%", cfg.MaxBounceRate, cfg.MaxComplaintRate)
+	}
+	return public.OptionsMgrInstance.SetOption(ctx, circuitBreakerOptionKey, cfg)
+}
+
+// BreakerCounts delivery outcomes of the messages of a campaign in the window
+type BreakerCounts struct {
+	Sent       int `json:"sent"`
+	Bounced    int `json:"bounced"`
+	Complained int `json:"complained"`
+}
+
+// trip returns the reason to pause for the counts, empty while under the thresholds
+func (c CircuitBreakerConfig) trip(counts BreakerCounts) string {
+	if counts.Sent == 0 || counts.Sent < c.MinSent {
+		return ""
+	}
+
+	rate := func(n int) float64 {
+		return float64(n) * 100 / float64(counts.Sent)
+	}
+
+	if c.MaxBounceRate > 0 && rate(counts.Bounced) > c.MaxBounceRate {
+		return fmt.Sprintf("bounce rate %.2f%% (%d of %d) over the %g%% limit", rate(counts.Bounced), counts.Bounced, counts.Sent, c.MaxBounceRate)
+	}
+	if c.MaxComplaintRate > 0 && rate(counts.Complained) > c.MaxComplaintRate {
+		return fmt.Sprintf("complaint rate %.2f%% (%d of %d) over the %g%% limit", rate(counts.Complained), counts.Complained, counts.Sent, c.MaxComplaintRate)
+	}
+
+	return ""
+}
+
+var (
+	breakerMu       sync.Mutex
+	breakerNotifier func(ctx context.Context, task *entity.EmailTask, reason string)
+)
+
+// OnCircuitBreak registers fn to be told when the breaker pauses a campaign
+func OnCircuitBreak(fn func(ctx context.Context, task *entity.EmailTask, reason string)) {
+	breakerMu.Lock()
+	breakerNotifier = fn
+	breakerMu.Unlock()
+}
+
+// PauseCampaign pauses a sending campaign and records why, through its executor when it
+// is running so the batch in flight completes first
+func PauseCampaign(ctx context.Context, taskId int, reason string) error {
+	if executor := GetTaskExecutor(taskId); executor != nil {
+		if err := executor.PauseTask(taskId); err != nil {
+			return err
+		}
+	} else if err := UpdateTaskPauseStatus(ctx, taskId, true); err != nil {
+		return err
+	}
+
+	_, err := g.DB().Model("email_tasks").Ctx(ctx).
+		Where("id", taskId).
+		Data(g.Map{"pause_reason": reason}).
+		Update()
+	if err != nil {
+		return fmt.Errorf("failed to save the pause reason: %w", err)
+	}
+
+	return nil
+}
+
+// CheckCircuitBreakers pauses the sending campaigns whose rates are past the thresholds
+func CheckCircuitBreakers(ctx context.Context) {
+	cfg := GetCircuitBreakerConfig(ctx)
+	if !cfg.Enabled {
+		return
+	}
+
+	var tasks []*entity.EmailTask
+	err := g.DB().Model("email_tasks").Ctx(ctx).
+		Where("task_process", 1).
+		Where("pause", 0).
+		Fields("*, tag_ids as TagIdsRaw").
+		Scan(&tasks)
+	if err != nil {
+		g.Log().Warning(ctx, "Circuit breaker: failed to list the sending campaigns:", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, task := range tasks {
+		counts, err := breakerCounts(ctx, task.Id, now-int64(cfg.WindowMinutes)*60)
+		if err != nil {
+			g.Log().Warningf(ctx, "Circuit breaker: failed to count the outcomes of task %d: %v", task.Id, err)
+			continue
+		}
+
+		reason := cfg.trip(counts)
+		if reason == "" {
+			continue
+		}
+
+		if err := PauseCampaign(ctx, task.Id, "Circuit breaker: "+reason); err != nil {
+			g.Log().Errorf(ctx, "Circuit breaker: failed to pause task %d: %v", task.Id, err)
+			continue
+		}
+
+		g.Log().Warningf(ctx, "Circuit breaker paused task %d (%s): %s", task.Id, task.Subject, reason)
+
+		_ = public.WriteLog(ctx, public.LogParams{
+			Type: consts.LOGTYPE.Task,
+			Log:  fmt.Sprintf("Circuit breaker paused task %s: %s", task.Subject, reason),
+			Data: counts,
+		})
+
+		breakerMu.Lock()
+		fn := breakerNotifier
+		breakerMu.Unlock()
+		if fn != nil {
+			fn(ctx, task, reason)
+		}
+	}
+}
+
+// breakerCounts outcomes of the messages of the task sent from since, or from its last
+// resume when later. The recipients skipped by the frequency cap were never sent
+func breakerCounts(ctx context.Context, taskId int, since int64) (BreakerCounts, error) {
+	var counts BreakerCounts
+
+	resumed, err := g.DB().Model("email_tasks").Ctx(ctx).Where("id", taskId).Value("breaker_since")
+	if err != nil {
+		return counts, err
+	}
+	if resumed.Int64() > since {
+		since = resumed.Int64()
+	}
+
+	sent, err := g.DB().Model("recipient_info").Ctx(ctx).
+		Where("task_id", taskId).
+		Where("is_sent", 1).
+		Where("frequency_capped", 0).
+		Where("opted_out", 0).
+		WhereGTE("sent_time", since).
+		Count()
+	if err != nil {
+		return counts, err
+	}
+	counts.Sent = sent
+
+	if sent == 0 {
+		return counts, nil
+	}
+
+	outcomes, err := g.DB().GetOne(ctx, `SELECT
+			COUNT(DISTINCT CASE WHEN sm.status = 'bounced' THEN mi.message_id END) AS bounced,
+			COUNT(DISTINCT CASE WHEN c.id IS NOT NULL THEN mi.message_id END) AS complained
+		FROM recipient_info r
+		INNER JOIN mailstat_message_ids mi ON mi.message_id = r.message_id
+		LEFT JOIN mailstat_send_mails sm ON sm.postfix_message_id = mi.postfix_message_id
+		LEFT JOIN mailstat_complaints c ON c.postfix_message_id = mi.postfix_message_id
+		WHERE r.task_id = ? AND r.is_sent = 1 AND r.frequency_capped = 0 AND r.opted_out = 0 AND r.sent_time >= ?`, taskId, since)
+	if err != nil {
+		return counts, err
+	}
+	counts.Bounced = outcomes["bounced"].Int()
+	counts.Complained = outcomes["complained"].Int()
+
+	return counts, nil
+}
@@ -0,0 +1,154 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Frequency capping of the campaigns: a subscriber receives at most MaxSends campaign
+// emails over WindowDays, counted across every campaign from the messages sent to it.
+// The cap of a task is the one of its list (group) when set, else the global one.
+// The recipients over the cap are not sent, they are marked as sent with
+// frequency_capped = 1 and counted in the capped_count of their task.
+
+const frequencyCapOptionKey = "campaign_frequency_cap"
+
+// FrequencyCap limit of the campaign emails per subscriber, disabled when MaxSends is 0
+type FrequencyCap struct {
+	MaxSends   int `json:"max_sends"`
+	WindowDays int `json:"window_days"`
+}
+
+// FrequencyCapSettings global cap and the overrides of the lists, by group ID
+type FrequencyCapSettings struct {
+	Default FrequencyCap         `json:"default"`
+	Lists   map[int]FrequencyCap `json:"lists"`
+}
+
+// GetFrequencyCapSettings returns the configured caps, disabled when unset
+func GetFrequencyCapSettings(ctx context.Context) FrequencyCapSettings {
+	s := FrequencyCapSettings{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, frequencyCapOptionKey, &s)
+	return s
+}
+
+// SetFrequencyCap validates and saves the cap of a list, the global one for group 0.
+// A nil cap removes the override of the list
+func SetFrequencyCap(ctx context.Context, groupId int, c *FrequencyCap) error {
+	if c != nil && (c.MaxSends < 0 || c.WindowDays < 0 || (c.MaxSends > 0 && c.WindowDays == 0)) {
+		return fmt.Errorf("invalid frequency cap: %d emails per %d days", c.MaxSends, c.WindowDays)
+	}
+
+	s := GetFrequencyCapSettings(ctx)
+
+	switch {
+	case groupId == 0 && c != nil:
+		s.Default = *c
+	case groupId == 0:
+		s.Default = FrequencyCap{}
+	case c != nil:
+		if s.Lists == nil {
+			s.Lists = make(map[int]FrequencyCap)
+		}
+		s.Lists[groupId] = *c
+	default:
+		delete(s.Lists, groupId)
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, frequencyCapOptionKey, s)
+}
+
+// capOf the cap applying to the tasks of a list
+func (s FrequencyCapSettings) capOf(groupId int) FrequencyCap {
+	if c, ok := s.Lists[groupId]; ok {
+		return c
+	}
+	return s.Default
+}
+
+// enabled reports whether the cap limits anything
+func (c FrequencyCap) enabled() bool {
+	return c.MaxSends > 0 && c.WindowDays > 0
+}
+
+// cappedRecipients the recipients of a batch who already received the maximum number of
+// campaign emails within the window, by recipient_info ID
+func (c FrequencyCap) cappedRecipients(ctx context.Context, recipients []*entity.RecipientInfo) (map[int]bool, error) {
+	capped := make(map[int]bool)
+	if !c.enabled() || len(recipients) == 0 {
+		return capped, nil
+	}
+
+	emails := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		emails = append(emails, r.Recipient)
+	}
+
+	since := time.Now().AddDate(0, 0, -c.WindowDays).Unix()
+
+	var counts []struct {
+		Recipient string `json:"recipient"`
+		Sends     int    `json:"sends"`
+	}
+
+	err := g.DB().Model("recipient_info").Ctx(ctx).
+		Fields("recipient, COUNT(1) AS sends").
+		WhereIn("recipient", emails).
+		Where("is_sent", 1).
+		Where("frequency_capped", 0).
+		WhereNot("message_id", "").
+		WhereGTE("sent_time", since).
+		Group("recipient").
+		Scan(&counts)
+	if err != nil {
+		return nil, err
+	}
+
+	over := make(map[string]bool, len(counts))
+	for _, count := range counts {
+		if count.Sends >= c.MaxSends {
+			over[count.Recipient] = true
+		}
+	}
+
+	for _, r := range recipients {
+		if over[r.Recipient] {
+			capped[r.Id] = true
+		}
+	}
+
+	return capped, nil
+}
+
+// skipCapped records the capped recipients of a task as processed without sending
+func skipCapped(ctx context.Context, taskId int, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		_, err := tx.Model("recipient_info").
+			WhereIn("id", ids).
+			Data(g.Map{
+				"is_sent":          1,
+				"frequency_capped": 1,
+				"sent_time":        time.Now().Unix(),
+			}).
+			Update()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Model("email_tasks").
+			Where("id", taskId).
+			Data(g.Map{"capped_count": gdb.Raw(fmt.Sprintf("capped_count + %d", len(ids)))}).
+			Update()
+		return err
+	})
+}
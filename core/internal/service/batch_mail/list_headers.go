@@ -0,0 +1,53 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/contact"
+	"billionmail-core/internal/service/mail_service"
+	"context"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// loadListHeaders mailing-list headers of the task's group, nil when the task has no group
+func loadListHeaders(ctx context.Context, task *entity.EmailTask) map[string]string {
+	if task == nil || task.GroupId == 0 {
+		return nil
+	}
+
+	group, err := contact.GetGroup(ctx, task.GroupId)
+	if err != nil {
+		g.Log().Warningf(ctx, "Task %d: failed to load group %d for the list headers: %v", task.Id, task.GroupId, err)
+		return nil
+	}
+
+	senderDomain := ""
+	if i := strings.LastIndex(task.Addresser, "@"); i >= 0 {
+		senderDomain = task.Addresser[i+1:]
+	}
+
+	return contact.ListHeaders(group, senderDomain, contact.GetListHeaderConfig(ctx, task.GroupId))
+}
+
+// applyListHeaders adds the list headers the message does not carry yet,
+// the List-Unsubscribe headers are left untouched
+func applyListHeaders(message *mail_service.Message, headers map[string]string) {
+	for key, value := range headers {
+		if strings.HasPrefix(strings.ToLower(key), "list-unsubscribe") {
+			continue
+		}
+
+		exists := false
+		for existing := range message.Headers {
+			if strings.EqualFold(existing, key) {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			message.SetHeader(key, value)
+		}
+	}
+}
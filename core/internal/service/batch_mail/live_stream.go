@@ -0,0 +1,210 @@
+package batch_mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// Live progress of a campaign send as Server-Sent Events. Every event is a snapshot of
+// the campaign counters with the changes since the previous event, its id is the time
+// of the snapshot in milliseconds. A client reconnecting with Last-Event-ID first gets
+// the changes since that event, so nothing is missed across the reconnection.
+
+const (
+	maxLiveStreams     = 16
+	livePollInterval   = 2 * time.Second
+	liveHeartbeatEvery = 15 * time.Second
+)
+
+var liveStreams atomic.Int32
+
+// ErrTooManyLiveStreams the concurrent stream cap is reached
+var ErrTooManyLiveStreams = errors.New("too many live campaign streams, try again later")
+
+// LiveCounts counters of a campaign send
+type LiveCounts struct {
+	Sent     int `json:"sent"`
+	Deferred int `json:"deferred"`
+	Bounced  int `json:"bounced"`
+	Opened   int `json:"opened"`
+}
+
+// LiveEvent payload of a live campaign event
+type LiveEvent struct {
+	TaskId      int        `json:"task_id"`
+	TaskProcess int        `json:"task_process"` // 0: pending 1: sending 2: completed
+	Pause       int        `json:"pause"`
+	Total       int        `json:"total"`
+	Progress    int        `json:"progress"` // percentage of the recipients sent
+	Counts      LiveCounts `json:"counts"`
+	Since       LiveCounts `json:"since"` // changes since the previous event, or since Last-Event-ID
+}
+
+// StreamCampaignEvents streams the live progress of a campaign to the request of ctx until
+// the campaign completes or the client goes away. lastEventId resumes after an earlier event,
+// the Last-Event-ID header of a reconnecting EventSource is used when it is 0
+func StreamCampaignEvents(ctx context.Context, taskId int, lastEventId int64) error {
+	r := g.RequestFromCtx(ctx)
+	if r == nil {
+		return errors.New("no request to stream to")
+	}
+
+	task, err := GetTaskInfo(ctx, taskId)
+	if err != nil {
+		return err
+	}
+	if task == nil || task.Id == 0 {
+		return fmt.Errorf("task %d not found", taskId)
+	}
+
+	if liveStreams.Add(1) > maxLiveStreams {
+		liveStreams.Add(-1)
+		return ErrTooManyLiveStreams
+	}
+	defer liveStreams.Add(-1)
+
+	if lastEventId == 0 {
+		lastEventId, _ = strconv.ParseInt(r.GetHeader("Last-Event-ID"), 10, 64)
+	}
+
+	r.Response.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	r.Response.Header().Set("Cache-Control", "no-cache")
+	r.Response.Header().Set("Connection", "keep-alive")
+	r.Response.Header().Set("X-Accel-Buffering", "no")
+	r.Response.WriteHeader(200)
+
+	ticker := time.NewTicker(livePollInterval)
+	defer ticker.Stop()
+
+	var previous *LiveEvent
+	lastWrite := time.Now()
+	since := lastEventId
+
+	for {
+		now := time.Now().UnixMilli()
+
+		event, err := liveSnapshot(ctx, taskId, since)
+		if err != nil {
+			g.Log().Warningf(ctx, "Task %d: live stream snapshot failed: %v", taskId, err)
+		} else if previous == nil || event.Counts != previous.Counts || event.TaskProcess != previous.TaskProcess || event.Pause != previous.Pause {
+			name := "progress"
+			if event.TaskProcess == 2 {
+				name = "complete"
+			}
+
+			if err = writeLiveEvent(r, now, name, event); err != nil {
+				return nil
+			}
+
+			previous, since, lastWrite = event, now, time.Now()
+
+			if event.TaskProcess == 2 {
+				return nil
+			}
+		} else if time.Since(lastWrite) >= liveHeartbeatEvery {
+			// Keeps proxies from closing an idle stream
+			r.Response.Write(": ping\n\n")
+			r.Response.Flush()
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func writeLiveEvent(r *ghttp.Request, id int64, name string, event *LiveEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.Response.Writef("id: %d\nevent: %s\ndata: %s\n\n", id, name, data)
+	r.Response.Flush()
+
+	return r.Context().Err()
+}
+
+// liveSnapshot counters of the campaign, Since holds the changes after sinceMillis
+func liveSnapshot(ctx context.Context, taskId int, sinceMillis int64) (*LiveEvent, error) {
+	task, err := GetTaskInfo(ctx, taskId)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &LiveEvent{TaskId: taskId, TaskProcess: task.TaskProcess, Pause: task.Pause}
+
+	total, err := g.DB().Model("recipient_info").Where("task_id", taskId).Count()
+	if err != nil {
+		return nil, err
+	}
+	event.Total = total
+
+	if event.Counts, err = liveCounts(ctx, taskId, 0); err != nil {
+		return nil, err
+	}
+
+	if sinceMillis > 0 {
+		if event.Since, err = liveCounts(ctx, taskId, sinceMillis); err != nil {
+			return nil, err
+		}
+	} else {
+		event.Since = event.Counts
+	}
+
+	if total > 0 {
+		event.Progress = event.Counts.Sent * 100 / total
+	}
+
+	return event, nil
+}
+
+// liveCounts counters of the campaign, only the events after sinceMillis when it is set
+func liveCounts(ctx context.Context, taskId int, sinceMillis int64) (LiveCounts, error) {
+	var counts LiveCounts
+
+	sent, err := g.DB().Model("recipient_info").
+		Where("task_id", taskId).
+		Where("is_sent", 1).
+		Where("sent_time * 1000 > ?", sinceMillis).
+		Count()
+	if err != nil {
+		return counts, err
+	}
+	counts.Sent = sent
+
+	delivery, err := g.DB().GetOne(ctx, `SELECT
+			COUNT(DISTINCT CASE WHEN sm.status = 'deferred' THEN mi.message_id END) AS deferred,
+			COUNT(DISTINCT CASE WHEN sm.status = 'bounced' THEN mi.message_id END) AS bounced
+		FROM recipient_info r
+		INNER JOIN mailstat_message_ids mi ON mi.message_id = r.message_id
+		INNER JOIN mailstat_send_mails sm ON sm.postfix_message_id = mi.postfix_message_id
+		WHERE r.task_id = ? AND sm.log_time_millis > ?`, taskId, sinceMillis)
+	if err != nil {
+		return counts, err
+	}
+	counts.Deferred = delivery["deferred"].Int()
+	counts.Bounced = delivery["bounced"].Int()
+
+	opened, err := g.DB().GetValue(ctx, `SELECT COUNT(DISTINCT message_id) FROM mailstat_opened
+		WHERE campaign_id = ? AND log_time_millis > ?`, taskId, sinceMillis)
+	if err != nil {
+		return counts, err
+	}
+	counts.Opened = opened.Int()
+
+	return counts, nil
+}
@@ -0,0 +1,211 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Subscriber preferences: the campaigns are sorted into mail categories, a subscriber
+// opts in or out of each category from the preference center instead of leaving the
+// list. A subscriber without a preference for a category is subscribed to it. The
+// preference center is authenticated by the token of the unsubscribe link, the global
+// unsubscribe of the list still applies on top of the preferences. The preferences are
+// read with each batch of recipients, a change applies to the next batch of the running
+// campaigns. The recipients opted out of the category of their task are not sent, they
+// are marked as sent with opted_out = 1 and counted in the opted_out_count of their task.
+
+const mailCategoriesOptionKey = "mail_categories"
+
+var categoryKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// MailCategory category of campaigns subscribers opt in or out of
+type MailCategory struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CategoryPreference whether a subscriber receives the campaigns of a category
+type CategoryPreference struct {
+	MailCategory
+	Subscribed bool `json:"subscribed"`
+}
+
+// GetMailCategories returns the configured categories, in their configured order
+func GetMailCategories(ctx context.Context) []MailCategory {
+	var categories []MailCategory
+	_ = public.OptionsMgrInstance.GetOption(ctx, mailCategoriesOptionKey, &categories)
+	return categories
+}
+
+// SetMailCategories validates and saves the categories. The preferences of a removed
+// category are kept, its campaigns are sent to every recipient
+func SetMailCategories(ctx context.Context, categories []MailCategory) error {
+	seen := make(map[string]bool, len(categories))
+	for i, c := range categories {
+		if !categoryKeyPattern.MatchString(c.Key) {
+			return fmt.Errorf("invalid category key %q: lowercase letters, digits, - and _", c.Key)
+		}
+		if seen[c.Key] {
+			return fmt.Errorf("duplicate category %q", c.Key)
+		}
+		seen[c.Key] = true
+
+		if categories[i].Name = strings.TrimSpace(c.Name); categories[i].Name == "" {
+			categories[i].Name = c.Key
+		}
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, mailCategoriesOptionKey, categories)
+}
+
+// IsMailCategory reports whether key is a configured category
+func IsMailCategory(ctx context.Context, key string) bool {
+	for _, c := range GetMailCategories(ctx) {
+		if c.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPreferences returns the preference of email for every configured category
+func GetPreferences(ctx context.Context, email string) ([]CategoryPreference, error) {
+	var rows []struct {
+		Category   string `json:"category"`
+		Subscribed int    `json:"subscribed"`
+	}
+
+	err := g.DB().Model("subscriber_preferences").Ctx(ctx).
+		Fields("category, subscribed").
+		Where("email", email).
+		Scan(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribed := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		subscribed[row.Category] = row.Subscribed == 1
+	}
+
+	categories := GetMailCategories(ctx)
+	preferences := make([]CategoryPreference, 0, len(categories))
+	for _, c := range categories {
+		s, ok := subscribed[c.Key]
+		preferences = append(preferences, CategoryPreference{MailCategory: c, Subscribed: s || !ok})
+	}
+
+	return preferences, nil
+}
+
+// SetPreferences saves the preferences of email, by category key. The categories
+// missing from preferences are left as they are
+func SetPreferences(ctx context.Context, email string, preferences map[string]bool) error {
+	if len(preferences) == 0 {
+		return nil
+	}
+
+	categories := GetMailCategories(ctx)
+	known := make(map[string]bool, len(categories))
+	for _, c := range categories {
+		known[c.Key] = true
+	}
+
+	now := time.Now().Unix()
+	data := make(g.List, 0, len(preferences))
+	for category, subscribed := range preferences {
+		if !known[category] {
+			return fmt.Errorf("unknown category %q", category)
+		}
+
+		s := 0
+		if subscribed {
+			s = 1
+		}
+		data = append(data, g.Map{
+			"email":       email,
+			"category":    category,
+			"subscribed":  s,
+			"update_time": now,
+		})
+	}
+
+	_, err := g.DB().Model("subscriber_preferences").Ctx(ctx).
+		OnConflict("email", "category").
+		OnDuplicate("subscribed", "update_time").
+		Data(data).
+		Save()
+	return err
+}
+
+// optedOutRecipients the recipients of a batch who opted out of category, by
+// recipient_info ID. Nobody is opted out of an empty or removed category
+func optedOutRecipients(ctx context.Context, category string, recipients []*entity.RecipientInfo) (map[int]bool, error) {
+	optedOut := make(map[int]bool)
+	if category == "" || len(recipients) == 0 || !IsMailCategory(ctx, category) {
+		return optedOut, nil
+	}
+
+	emails := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		emails = append(emails, r.Recipient)
+	}
+
+	values, err := g.DB().Model("subscriber_preferences").Ctx(ctx).
+		WhereIn("email", emails).
+		Where("category", category).
+		Where("subscribed", 0).
+		Array("email")
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]bool, len(values))
+	for _, v := range values {
+		out[v.String()] = true
+	}
+
+	for _, r := range recipients {
+		if out[r.Recipient] {
+			optedOut[r.Id] = true
+		}
+	}
+
+	return optedOut, nil
+}
+
+// skipOptedOut records the opted out recipients of a task as processed without sending
+func skipOptedOut(ctx context.Context, taskId int, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		_, err := tx.Model("recipient_info").
+			WhereIn("id", ids).
+			Data(g.Map{
+				"is_sent":   1,
+				"opted_out": 1,
+				"sent_time": time.Now().Unix(),
+			}).
+			Update()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Model("email_tasks").
+			Where("id", taskId).
+			Data(g.Map{"opted_out_count": gdb.Raw(fmt.Sprintf("opted_out_count + %d", len(ids)))}).
+			Update()
+		return err
+	})
+}
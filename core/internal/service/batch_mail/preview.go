@@ -0,0 +1,133 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/domains"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/maillog_stat"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// SendCampaignPreview sends one rendered message of a campaign to toAddr and returns its Message-ID.
+// sampleData fills the subscriber fields, without it the contact of toAddr in the campaign group
+// is used, or else a synthetic subscriber. The tracking links are preview ones recording nothing,
+// the message is not added to the campaign recipients so its statistics and the suppression list
+// are left untouched
+func SendCampaignPreview(ctx context.Context, campaignID int, toAddr string, sampleData map[string]string) (string, error) {
+	toAddr = strings.TrimSpace(toAddr)
+	if toAddr == "" {
+		return "", fmt.Errorf("no preview recipient specified")
+	}
+
+	task, err := GetTaskInfo(ctx, campaignID)
+	if err != nil {
+		return "", err
+	}
+	if task.Id == 0 {
+		return "", fmt.Errorf("task %d not found", campaignID)
+	}
+
+	// A throwaway executor, so the running one of the campaign keeps its state
+	e := &TaskExecutor{ctx: ctx}
+
+	template, err := e.getTaskTemplate(ctx, task, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to get template: %w", err)
+	}
+
+	content := e.processEmailContent(ctx, template.Content, task)
+	contact := previewContact(ctx, task, toAddr, sampleData)
+
+	// The unsubscribe link of a preview must not unsubscribe anyone
+	unsubscribeURL := ""
+	if task.Unsubscribe == 1 {
+		unsubscribeURL = "#"
+	}
+
+	engine := GetTemplateEngine()
+
+	renderedContent, err := engine.RenderEmailTemplate(ctx, content, contact, task, unsubscribeURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to render email content: %w", err)
+	}
+
+	renderedSubject, err := engine.RenderEmailTemplate(ctx, task.Subject, contact, task, unsubscribeURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to render email subject: %w", err)
+	}
+
+	renderedContent = e.restoreErrorVariables(renderedContent)
+	renderedSubject = e.restoreErrorVariables(renderedSubject)
+
+	sender, err := mail_service.NewEmailSenderWithLocal(task.Addresser)
+	if err != nil {
+		return "", fmt.Errorf("create email sender failed: %w", err)
+	}
+	defer sender.Close()
+
+	messageID := sender.GenerateMessageID()
+
+	tracker := maillog_stat.NewMailTracker(renderedContent, task.Id, messageID, toAddr, domains.GetBaseURL())
+	tracker.SetPreview(true)
+	tracker.TrackLinks()
+	tracker.AppendTrackingPixel()
+
+	message := mail_service.NewMessage(renderedSubject, tracker.GetHTML())
+	message.SetMessageID(messageID)
+
+	if task.FullName != "" {
+		message.SetRealName(task.FullName)
+	}
+
+	applyListHeaders(&message, loadListHeaders(ctx, task))
+
+	if err = sender.Send(message, []string{toAddr}); err != nil {
+		return "", fmt.Errorf("send email failed: %w", err)
+	}
+
+	g.Log().Infof(ctx, "Task %d: preview sent to %s", task.Id, toAddr)
+
+	return messageID, nil
+}
+
+// previewContact subscriber data of a preview
+func previewContact(ctx context.Context, task *entity.EmailTask, toAddr string, sampleData map[string]string) *entity.Contact {
+	if len(sampleData) > 0 {
+		contact := &entity.Contact{Email: toAddr, GroupId: task.GroupId, Active: 1, Status: 1, Attribs: make(map[string]string)}
+		for k, v := range sampleData {
+			if strings.EqualFold(k, "email") {
+				contact.Email = v
+				continue
+			}
+			contact.Attribs[k] = v
+		}
+		return contact
+	}
+
+	var contact entity.Contact
+	q := g.DB().Model("bm_contacts").Where("email", toAddr)
+	if task.GroupId > 0 {
+		q = q.Where("group_id", task.GroupId)
+	}
+	if err := q.OrderDesc("create_time").Limit(1).Scan(&contact); err == nil && contact.Id != 0 {
+		return &contact
+	}
+
+	// Synthetic subscriber, the custom fields of the group's contacts are filled with their names
+	contact = entity.Contact{Email: toAddr, GroupId: task.GroupId, Active: 1, Status: 1, Attribs: make(map[string]string)}
+
+	if task.GroupId > 0 {
+		var sample entity.Contact
+		if err := g.DB().Model("bm_contacts").Where("group_id", task.GroupId).Limit(1).Scan(&sample); err == nil {
+			for k := range sample.Attribs {
+				contact.Attribs[k] = "[" + k + "]"
+			}
+		}
+	}
+
+	return &contact
+}
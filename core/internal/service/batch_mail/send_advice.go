@@ -0,0 +1,311 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/warmup"
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Send advice: before a campaign is launched, its planned volume is compared with what
+// the server sent over the last adviceWindowDays. A send up to safeGrowthFactor times the
+// busiest day of the window is considered safe, a sender without history starts at
+// coldStartVolume a day like the first day of the IP warmup. While the IP of the server
+// warms up, the capacity the warmup allows today caps the safe volume. The bounce,
+// complaint and open rates of the window and the stale contacts of the list add
+// warnings, the suppressed contacts are counted but never mailed. A send over the safe volume gets a ramp: the volume to send each
+// day, growing by safeGrowthFactor, or like the warmup schedule while warming up. The
+// advice is informative only, nothing is enforced at launch.
+
+const (
+	AdviceRiskLow    = "low"
+	AdviceRiskMedium = "medium"
+	AdviceRiskHigh   = "high"
+
+	adviceWindowDays = 30
+
+	safeGrowthFactor   = 2.0  // growth of the daily volume considered safe
+	warmupGrowthFactor = 1.3  // daily growth of the warmup schedule
+	coldStartVolume    = 1000 // safe daily volume of a sender without history
+	maxRampDays        = 30   // the rest of the volume goes on the last day
+
+	minSentForRates     = 500  // messages in the window before the rates count
+	adviceBounceRate    = 2.0  // percent of the sent messages bounced
+	adviceComplaintRate = 0.1  // percent of the sent messages reported as spam
+	adviceOpenRate      = 10.0 // percent of the delivered messages opened
+	adviceStaleShare    = 25.0 // percent of the contacts of the list not engaged
+	staleContactDays    = 180  // days without engagement after which a contact is stale
+)
+
+// SendHistory sending of the server over the advice window, rates in percent
+type SendHistory struct {
+	Days          int     `json:"days"`
+	ActiveDays    int     `json:"active_days"` // days with at least one message sent
+	TotalSent     int     `json:"total_sent"`
+	PeakDaily     int     `json:"peak_daily"`
+	AverageDaily  int     `json:"average_daily"` // over the active days
+	BounceRate    float64 `json:"bounce_rate"`
+	ComplaintRate float64 `json:"complaint_rate"`
+	OpenRate      float64 `json:"open_rate"`
+}
+
+// ListHealth contacts of the list about to be mailed
+type ListHealth struct {
+	Active     int `json:"active"`     // subscribed contacts
+	Suppressed int `json:"suppressed"` // subscribed but globally suppressed, not mailed
+	Stale      int `json:"stale"`      // not engaged for staleContactDays
+}
+
+// SendWarning one reason the send may hurt the reputation of the server
+type SendWarning struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"` // AdviceRiskMedium or AdviceRiskHigh
+	Message  string `json:"message"`
+}
+
+// RampStep volume to send on a day of the ramp, counted from the launch
+type RampStep struct {
+	Day    int `json:"day"`
+	Volume int `json:"volume"`
+}
+
+// SendAdvice recommendations for a planned send
+type SendAdvice struct {
+	ListId        int           `json:"list_id"`
+	PlannedVolume int           `json:"planned_volume"`
+	SafeVolume    int           `json:"safe_volume"` // daily volume considered safe
+	Risk          string        `json:"risk"`
+	History       SendHistory   `json:"history"`
+	List          ListHealth    `json:"list"`
+	Warming       bool          `json:"warming"`      // the IP of the server is warming up
+	WarmupDaily   int           `json:"warmup_daily"` // messages the warmup allows today
+	Warnings      []SendWarning `json:"warnings"`
+	Ramp          []RampStep    `json:"ramp"` // empty when the send may go at once
+}
+
+// CampaignSendAdvice returns the recommendations for sending plannedVolume messages to
+// the list listID, the mailable contacts of the list when plannedVolume is 0
+func CampaignSendAdvice(ctx context.Context, listID, plannedVolume int) (*SendAdvice, error) {
+	if plannedVolume < 0 {
+		return nil, fmt.Errorf("invalid planned volume %d", plannedVolume)
+	}
+
+	exists, err := g.DB().Model("bm_contact_groups").Ctx(ctx).Where("id", listID).Count()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("contact group %d not found", listID)
+	}
+
+	list, err := listHealth(ctx, listID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the contacts of the list: %w", err)
+	}
+
+	history, err := sendHistory(ctx, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the sending history: %w", err)
+	}
+
+	capacity, warming := 0, false
+	if serverIP, _ := public.GetServerIP(); serverIP != "" {
+		if capacity, warming, err = warmup.WarmupCampaign().DailyCapacity(ctx, serverIP); err != nil {
+			g.Log().Warningf(ctx, "CampaignSendAdvice: failed to get the warmup capacity of %s: %v", serverIP, err)
+		}
+	}
+
+	if plannedVolume == 0 {
+		plannedVolume = max(list.Active-list.Suppressed, 0)
+	}
+
+	advice := adviseSend(plannedVolume, history, list, capacity, warming)
+	advice.ListId = listID
+	return advice, nil
+}
+
+// listHealth counts the active, suppressed and stale contacts of the list
+func listHealth(ctx context.Context, listID int) (ListHealth, error) {
+	var health ListHealth
+	var err error
+
+	health.Active, err = g.DB().Model("bm_contacts").Ctx(ctx).
+		Where("group_id", listID).
+		Where("active", 1).
+		Count()
+	if err != nil {
+		return health, err
+	}
+
+	suppressed, err := g.DB().GetValue(ctx, `SELECT COUNT(*) FROM bm_contacts c
+		INNER JOIN abnormal_recipient a ON a.recipient = c.email
+		WHERE c.group_id = ? AND c.active = 1 AND a.count >= 3`, listID)
+	if err != nil {
+		return health, err
+	}
+	health.Suppressed = suppressed.Int()
+
+	// Contacts subscribed for longer than the stale period without any engagement in it
+	staleBefore := time.Now().AddDate(0, 0, -staleContactDays).Unix()
+	health.Stale, err = g.DB().Model("bm_contacts").Ctx(ctx).
+		Where("group_id", listID).
+		Where("active", 1).
+		WhereLT("create_time", staleBefore).
+		Where("(last_active_at IS NULL OR last_active_at < ?)", staleBefore).
+		Count()
+	return health, err
+}
+
+// sendHistory the campaign messages sent over the advice window ending at now
+func sendHistory(ctx context.Context, now time.Time) (SendHistory, error) {
+	history := SendHistory{Days: adviceWindowDays}
+	since := now.AddDate(0, 0, -adviceWindowDays).Unix()
+
+	days, err := g.DB().GetAll(ctx, `SELECT (sent_time - ?) / 86400 AS day, COUNT(*) AS sent
+		FROM recipient_info
+		WHERE is_sent = 1 AND frequency_capped = 0 AND opted_out = 0 AND sent_time >= ?
+		GROUP BY day`, since, since)
+	if err != nil {
+		return history, err
+	}
+	for _, day := range days {
+		sent := day["sent"].Int()
+		history.ActiveDays++
+		history.TotalSent += sent
+		history.PeakDaily = max(history.PeakDaily, sent)
+	}
+	if history.ActiveDays > 0 {
+		history.AverageDaily = history.TotalSent / history.ActiveDays
+	}
+
+	if history.TotalSent == 0 {
+		return history, nil
+	}
+
+	outcomes, err := g.DB().GetOne(ctx, `SELECT
+			COUNT(DISTINCT CASE WHEN sm.status = 'bounced' THEN mi.message_id END) AS bounced,
+			COUNT(DISTINCT CASE WHEN c.id IS NOT NULL THEN mi.message_id END) AS complained,
+			COUNT(DISTINCT CASE WHEN o.postfix_message_id IS NOT NULL THEN mi.message_id END) AS opened
+		FROM recipient_info r
+		INNER JOIN mailstat_message_ids mi ON mi.message_id = r.message_id
+		LEFT JOIN mailstat_send_mails sm ON sm.postfix_message_id = mi.postfix_message_id
+		LEFT JOIN mailstat_complaints c ON c.postfix_message_id = mi.postfix_message_id
+		LEFT JOIN mailstat_opened o ON o.postfix_message_id = mi.postfix_message_id
+		WHERE r.is_sent = 1 AND r.frequency_capped = 0 AND r.opted_out = 0 AND r.sent_time >= ?`, since)
+	if err != nil {
+		return history, err
+	}
+
+	bounced := outcomes["bounced"].Int()
+	history.BounceRate = percent(bounced, history.TotalSent)
+	history.ComplaintRate = percent(outcomes["complained"].Int(), history.TotalSent)
+	history.OpenRate = percent(outcomes["opened"].Int(), history.TotalSent-bounced)
+
+	return history, nil
+}
+
+// percent part of total in percent, 0 when total is not positive
+func percent(part, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return math.Round(float64(part)*10000/float64(total)) / 100
+}
+
+// adviseSend builds the advice for sending planned messages with the history, the list
+// and the warmup capacity of today when warming
+func adviseSend(planned int, history SendHistory, list ListHealth, capacity int, warming bool) *SendAdvice {
+	advice := &SendAdvice{
+		PlannedVolume: planned,
+		Risk:          AdviceRiskLow,
+		History:       history,
+		List:          list,
+		Warming:       warming,
+		WarmupDaily:   capacity,
+		Warnings:      []SendWarning{},
+		Ramp:          []RampStep{},
+	}
+
+	warn := func(code, severity, format string, args ...interface{}) {
+		advice.Warnings = append(advice.Warnings, SendWarning{Code: code, Severity: severity, Message: fmt.Sprintf(format, args...)})
+		if severity == AdviceRiskHigh || advice.Risk == AdviceRiskLow {
+			advice.Risk = severity
+		}
+	}
+
+	safe := coldStartVolume
+	if history.PeakDaily > 0 {
+		safe = max(int(float64(history.PeakDaily)*safeGrowthFactor), coldStartVolume)
+	}
+	growth := safeGrowthFactor
+	if warming {
+		safe = min(safe, max(capacity, 1))
+		growth = warmupGrowthFactor
+	}
+	advice.SafeVolume = safe
+
+	switch {
+	case planned <= safe:
+	case warming:
+		warn("warmup_capacity", AdviceRiskMedium,
+			"The server IP is warming up and may send %d messages today, the send of %d messages will be spread over several days", capacity, planned)
+	case history.TotalSent == 0:
+		warn("no_history", AdviceRiskHigh,
+			"No campaign was sent in the last %d days, %d messages at once from a cold sender risk being blocked", history.Days, planned)
+	case float64(planned) > float64(safe)*1.5:
+		warn("volume_spike", AdviceRiskHigh,
+			"%d messages is %.1f times the busiest day of the last %d days (%d)", planned, float64(planned)/float64(history.PeakDaily), history.Days, history.PeakDaily)
+	default:
+		warn("volume_spike", AdviceRiskMedium,
+			"%d messages is above the %d messages a day considered safe from the recent history", planned, safe)
+	}
+
+	if history.TotalSent >= minSentForRates {
+		if history.ComplaintRate >= adviceComplaintRate {
+			warn("complaint_rate", AdviceRiskHigh,
+				"%.2f%% of the recent messages were reported as spam, above %.2f%%", history.ComplaintRate, adviceComplaintRate)
+		}
+		if history.BounceRate >= adviceBounceRate {
+			warn("bounce_rate", AdviceRiskMedium,
+				"%.2f%% of the recent messages bounced, above %.2f%%", history.BounceRate, adviceBounceRate)
+		}
+		if history.OpenRate < adviceOpenRate {
+			warn("low_engagement", AdviceRiskMedium,
+				"Only %.2f%% of the recent messages were opened", history.OpenRate)
+		}
+	}
+
+	if share := percent(list.Stale, list.Active); share >= adviceStaleShare {
+		warn("stale_contacts", AdviceRiskMedium,
+			"%d contacts of the list (%.2f%%) did not engage in the last %d days, consider a re-engagement campaign first", list.Stale, share, staleContactDays)
+	}
+
+	if planned > safe {
+		advice.Ramp = sendRamp(planned, safe, growth)
+	}
+
+	return advice
+}
+
+// sendRamp splits planned messages over days, starting at first a day and growing by
+// growth, the rest goes on the last day past maxRampDays
+func sendRamp(planned, first int, growth float64) []RampStep {
+	var ramp []RampStep
+	step := float64(first)
+
+	for remaining := planned; remaining > 0; {
+		volume := min(remaining, int(step))
+		if len(ramp) == maxRampDays-1 {
+			volume = remaining
+		}
+		ramp = append(ramp, RampStep{Day: len(ramp) + 1, Volume: volume})
+		remaining -= volume
+		step *= growth
+	}
+
+	return ramp
+}
@@ -0,0 +1,307 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/public"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Send journal of the campaigns: the recipients are fetched with is_sent = 2 and marked
+// 1 by batches once sent, a crash in between leaves them fetched. So before a message
+// leaves, its recipient is appended to the journal of the task as sending, synced to
+// disk, and once the server answered as sent or failed. When the task runs again,
+// recoverSends settles the fetched recipients from the journal: without a sending
+// entry the message never left and the recipient goes back to the queue, with its
+// outcome it is marked as the batch would have. A recipient sending without an outcome
+// may or may not have been delivered, the delivery guarantee decides: at-least-once
+// sends it again, at-most-once marks it sent. The journal is removed with the task
+// completed.
+//
+// The journal is append-only, one line per entry, a line cut by the crash is ignored:
+//
+//	sending <recipient id> <unix time> <message id>
+//	sent <recipient id> <unix time>
+//	failed <recipient id> <unix time>
+
+const (
+	sendGuaranteeOptionKey = "campaign_send_guarantee"
+
+	sendJournalDir = "data/send_journal"
+)
+
+// SendGuarantee delivery of the recipients whose send was interrupted by a crash
+type SendGuarantee string
+
+const (
+	// SendAtMostOnce the recipients possibly sent are marked sent, none receives the
+	// campaign twice but one may miss it
+	SendAtMostOnce SendGuarantee = "at_most_once"
+	// SendAtLeastOnce the recipients possibly sent are sent again, none misses the
+	// campaign but one may receive it twice
+	SendAtLeastOnce SendGuarantee = "at_least_once"
+)
+
+// journal states of a recipient
+const (
+	journalSending = "sending"
+	journalSent    = "sent"
+	journalFailed  = "failed"
+)
+
+// errJournal the sending entry of a recipient could not be written, it is not sent
+var errJournal = errors.New("send journal unavailable")
+
+// GetSendGuarantee returns the configured guarantee, at-most-once when unset
+func GetSendGuarantee(ctx context.Context) SendGuarantee {
+	var guarantee SendGuarantee
+	_ = public.OptionsMgrInstance.GetOption(ctx, sendGuaranteeOptionKey, &guarantee)
+	if guarantee != SendAtLeastOnce {
+		return SendAtMostOnce
+	}
+	return guarantee
+}
+
+// SetSendGuarantee validates and saves the guarantee
+func SetSendGuarantee(ctx context.Context, guarantee SendGuarantee) error {
+	if guarantee != SendAtMostOnce && guarantee != SendAtLeastOnce {
+		return fmt.Errorf("invalid send guarantee %q, expected %s or %s", guarantee, SendAtMostOnce, SendAtLeastOnce)
+	}
+	return public.OptionsMgrInstance.SetOption(ctx, sendGuaranteeOptionKey, guarantee)
+}
+
+// journalEntry the last state of a recipient in the journal
+type journalEntry struct {
+	State     string
+	MessageID string
+	Time      int64
+}
+
+// sendJournal the journal of a task open for appending, safe for concurrent use
+type sendJournal struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func sendJournalPath(taskId int) string {
+	return public.AbsPath(sendJournalDir, strconv.Itoa(taskId)+".log")
+}
+
+// openSendJournal opens the journal at path for appending, created when missing
+func openSendJournal(path string) (*sendJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &sendJournal{f: f}, nil
+}
+
+// append writes an entry and syncs it to disk
+func (j *sendJournal) append(fields ...string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return os.ErrClosed
+	}
+	if _, err := j.f.WriteString(strings.Join(fields, " ") + "\n"); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// send journals the send of a message around send: the message does not leave when the
+// sending entry cannot be written, the error is then errJournal
+func (j *sendJournal) send(ctx context.Context, recipientId int, messageID string, send func() error) error {
+	id := strconv.Itoa(recipientId)
+
+	if err := j.append(journalSending, id, strconv.FormatInt(time.Now().Unix(), 10), messageID); err != nil {
+		return fmt.Errorf("%w: %v", errJournal, err)
+	}
+
+	err := send()
+
+	state := journalSent
+	if err != nil {
+		state = journalFailed
+	}
+	// Left sending, the recovery applies the guarantee to the recipient
+	if jErr := j.append(state, id, strconv.FormatInt(time.Now().Unix(), 10)); jErr != nil {
+		g.Log().Warningf(ctx, "failed to journal recipient %d as %s: %v", recipientId, state, jErr)
+	}
+	return err
+}
+
+// Close closes the journal, the entries are kept
+func (j *sendJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// readSendJournal the last state of every recipient in the journal at path, none when
+// the journal does not exist
+func readSendJournal(path string) (map[int]journalEntry, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[int]journalEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the last line is cut when the crash happened while writing it
+	if i := bytes.LastIndexByte(content, '\n'); i < len(content)-1 {
+		content = content[:i+1]
+	}
+
+	entries := make(map[int]journalEntry)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		unix, _ := strconv.ParseInt(fields[2], 10, 64)
+
+		switch fields[0] {
+		case journalSending:
+			entry := journalEntry{State: journalSending, Time: unix}
+			if len(fields) > 3 {
+				entry.MessageID = fields[3]
+			}
+			entries[id] = entry
+		case journalSent, journalFailed:
+			entry := entries[id]
+			entry.State = fields[0]
+			entry.Time = unix
+			entries[id] = entry
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// removeSendJournal removes the journal of a task
+func removeSendJournal(taskId int) {
+	if err := os.Remove(sendJournalPath(taskId)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		g.Log().Warningf(context.Background(), "failed to remove the send journal of task %d: %v", taskId, err)
+	}
+}
+
+// sendRecovery how the fetched recipients of a task are settled
+type sendRecovery struct {
+	Requeue []int                // back to the queue, sent by the next batches
+	Sent    map[int]journalEntry // marked sent, with the message ID and time of the journal
+	Failed  map[int]journalEntry // marked sent, as the failed sends are
+}
+
+// planSendRecovery settles the fetched recipients from the journal entries
+func planSendRecovery(fetched []int, entries map[int]journalEntry, guarantee SendGuarantee) sendRecovery {
+	plan := sendRecovery{Sent: map[int]journalEntry{}, Failed: map[int]journalEntry{}}
+
+	for _, id := range fetched {
+		entry, ok := entries[id]
+		switch {
+		case !ok:
+			plan.Requeue = append(plan.Requeue, id)
+		case entry.State == journalSent:
+			plan.Sent[id] = entry
+		case entry.State == journalFailed:
+			plan.Failed[id] = entry
+		case guarantee == SendAtLeastOnce:
+			plan.Requeue = append(plan.Requeue, id)
+		default:
+			plan.Sent[id] = entry
+		}
+	}
+	return plan
+}
+
+// recoverSends settles the recipients of a task left fetched by a run interrupted,
+// before the task is sent again. It returns the number put back in the queue
+func recoverSends(ctx context.Context, taskId int) (int, error) {
+	values, err := g.DB().Model("recipient_info").
+		Where("task_id", taskId).
+		Where("is_sent", 2).
+		Fields("id").
+		Array()
+	if err != nil {
+		return 0, err
+	}
+	if len(values) == 0 {
+		return 0, nil
+	}
+	fetched := make([]int, 0, len(values))
+	for _, v := range values {
+		fetched = append(fetched, v.Int())
+	}
+
+	entries, err := readSendJournal(sendJournalPath(taskId))
+	if err != nil {
+		return 0, fmt.Errorf("read the send journal of task %d: %w", taskId, err)
+	}
+
+	guarantee := GetSendGuarantee(ctx)
+	plan := planSendRecovery(fetched, entries, guarantee)
+
+	if len(plan.Requeue) > 0 {
+		if _, err = g.DB().Model("recipient_info").
+			WhereIn("id", plan.Requeue).
+			Data(g.Map{"is_sent": 0}).
+			Update(); err != nil {
+			return 0, err
+		}
+	}
+
+	for id, entry := range plan.Sent {
+		if _, err = g.DB().Model("recipient_info").
+			Where("id", id).
+			Data(g.Map{
+				"is_sent":    1,
+				"sent_time":  entry.Time,
+				"message_id": strings.Trim(entry.MessageID, "<>"),
+			}).
+			Update(); err != nil {
+			return 0, err
+		}
+	}
+
+	for id, entry := range plan.Failed {
+		if _, err = g.DB().Model("recipient_info").
+			Where("id", id).
+			Data(g.Map{
+				"is_sent":   1,
+				"sent_time": entry.Time,
+			}).
+			Update(); err != nil {
+			return 0, err
+		}
+	}
+
+	g.Log().Infof(ctx, "task %d: recovered %d fetched recipients with the %s guarantee, %d back in the queue, %d sent, %d failed",
+		taskId, len(fetched), guarantee, len(plan.Requeue), len(plan.Sent), len(plan.Failed))
+	return len(plan.Requeue), nil
+}
@@ -0,0 +1,242 @@
+package batch_mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crashSimulation a task sent by batches as TaskExecutor does, with recipient_info
+// reduced to the is_sent of every recipient. crashAt stops the run at that step as a
+// crash would, 0 runs to the end
+type crashSimulation struct {
+	path      string
+	isSent    map[int]int
+	delivered map[int]int
+
+	step    int
+	crashAt int
+	// the recipient whose send the crash interrupted, and whether it was delivered
+	ambiguous          int
+	ambiguousDelivered bool
+}
+
+const simulatedRecipients = 7
+
+func newCrashSimulation(t *testing.T) *crashSimulation {
+	s := &crashSimulation{
+		path:      filepath.Join(t.TempDir(), "1.log"),
+		isSent:    map[int]int{},
+		delivered: map[int]int{},
+	}
+	for id := 1; id <= simulatedRecipients; id++ {
+		s.isSent[id] = 0
+	}
+	return s
+}
+
+// crashed counts a step, it reports whether the run crashes there
+func (s *crashSimulation) crashed() bool {
+	s.step++
+	return s.crashAt > 0 && s.step == s.crashAt
+}
+
+// run sends the unsent recipients by batches of 3, it reports whether the run crashed
+func (s *crashSimulation) run(t *testing.T) bool {
+	journal, err := openSendJournal(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	for {
+		var batch []int
+		for id := 1; id <= simulatedRecipients && len(batch) < 3; id++ {
+			if s.isSent[id] == 0 {
+				batch = append(batch, id)
+			}
+		}
+		if len(batch) == 0 {
+			return false
+		}
+		for _, id := range batch {
+			s.isSent[id] = 2
+		}
+		if s.crashed() {
+			return true
+		}
+
+		var sent []int
+		for _, id := range batch {
+			if s.crashed() {
+				return true
+			}
+
+			crash := false
+			err := journal.send(context.Background(), id, "<m"+string(rune('0'+id))+"@example.com>", func() error {
+				// the crash cuts the run before or after the message leaves, the
+				// outcome is not journaled
+				if crash = s.crashed(); crash {
+					s.ambiguous = id
+					journal.Close()
+					return nil
+				}
+				s.delivered[id]++
+				if crash = s.crashed(); crash {
+					s.ambiguous, s.ambiguousDelivered = id, true
+					journal.Close()
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("send to recipient %d: %v", id, err)
+			}
+			if crash {
+				return true
+			}
+			sent = append(sent, id)
+		}
+
+		if s.crashed() {
+			return true
+		}
+		for _, id := range sent {
+			s.isSent[id] = 1
+		}
+	}
+}
+
+// recover settles the fetched recipients as recoverSends does
+func (s *crashSimulation) recover(t *testing.T, guarantee SendGuarantee) {
+	entries, err := readSendJournal(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetched []int
+	for id, state := range s.isSent {
+		if state == 2 {
+			fetched = append(fetched, id)
+		}
+	}
+
+	plan := planSendRecovery(fetched, entries, guarantee)
+	for _, id := range plan.Requeue {
+		s.isSent[id] = 0
+	}
+	for id := range plan.Sent {
+		s.isSent[id] = 1
+	}
+	for id := range plan.Failed {
+		s.isSent[id] = 1
+	}
+}
+
+func TestSendRecoveryAfterCrash(t *testing.T) {
+	for _, guarantee := range []SendGuarantee{SendAtMostOnce, SendAtLeastOnce} {
+		for crashAt := 1; ; crashAt++ {
+			s := newCrashSimulation(t)
+			s.crashAt = crashAt
+			if !s.run(t) {
+				break
+			}
+
+			s.recover(t, guarantee)
+			s.crashAt = 0
+			if s.run(t) {
+				t.Fatalf("%s, crash at step %d: the run after the recovery crashed", guarantee, crashAt)
+			}
+
+			for id := 1; id <= simulatedRecipients; id++ {
+				if s.isSent[id] != 1 {
+					t.Errorf("%s, crash at step %d: recipient %d left with is_sent %d", guarantee, crashAt, id, s.isSent[id])
+				}
+
+				want := 1
+				if id == s.ambiguous {
+					switch {
+					case guarantee == SendAtMostOnce && !s.ambiguousDelivered:
+						want = 0
+					case guarantee == SendAtLeastOnce && s.ambiguousDelivered:
+						want = 2
+					}
+				}
+				if s.delivered[id] != want {
+					t.Errorf("%s, crash at step %d: recipient %d delivered %d times, want %d", guarantee, crashAt, id, s.delivered[id], want)
+				}
+			}
+		}
+	}
+}
+
+func TestReadSendJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.log")
+
+	entries, err := readSendJournal(path)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("missing journal: %v, %v", entries, err)
+	}
+
+	content := "sending 1 100 <a@example.com>\n" +
+		"sent 1 101\n" +
+		"sending 2 102 <b@example.com>\n" +
+		"failed 2 103\n" +
+		"sending 3 104 <c@example.com>\n" +
+		"garbage\n" +
+		"sending 4 105 <d@example.com>\n" +
+		"sent 4" // cut by the crash, 4 was not recorded as sent
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = readSendJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]journalEntry{
+		1: {State: journalSent, MessageID: "<a@example.com>", Time: 101},
+		2: {State: journalFailed, MessageID: "<b@example.com>", Time: 103},
+		3: {State: journalSending, MessageID: "<c@example.com>", Time: 104},
+		4: {State: journalSending, MessageID: "<d@example.com>", Time: 105},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries %v, want %v", entries, want)
+	}
+	for id, entry := range want {
+		if entries[id] != entry {
+			t.Errorf("recipient %d: %+v, want %+v", id, entries[id], entry)
+		}
+	}
+
+	plan := planSendRecovery([]int{1, 2, 3, 4, 5}, entries, SendAtMostOnce)
+	if len(plan.Requeue) != 1 || plan.Requeue[0] != 5 || len(plan.Sent) != 3 || len(plan.Failed) != 1 {
+		t.Errorf("at-most-once plan %+v", plan)
+	}
+	if plan.Sent[3].MessageID != "<c@example.com>" {
+		t.Errorf("recipient 3 marked sent without its message ID: %+v", plan.Sent[3])
+	}
+
+	plan = planSendRecovery([]int{1, 2, 3, 4, 5}, entries, SendAtLeastOnce)
+	if len(plan.Requeue) != 3 || len(plan.Sent) != 1 || len(plan.Failed) != 1 {
+		t.Errorf("at-least-once plan %+v", plan)
+	}
+}
+
+func TestSendJournalNotWritable(t *testing.T) {
+	journal, err := openSendJournal(filepath.Join(t.TempDir(), "1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	sent := false
+	err = journal.send(context.Background(), 1, "<a@example.com>", func() error {
+		sent = true
+		return nil
+	})
+	if !errors.Is(err, errJournal) || sent {
+		t.Errorf("sent without a journal: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/model/entity"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Time zone send windows: a task with a send_local_hour delivers to every recipient
+// at that hour of the recipient's local time. The recipients of a time zone are
+// released once its first such hour after the task start has come, so the send
+// spreads across the day zone by zone. The released recipients go through the usual
+// batches, so the task and global rate limits still bound the send.
+
+// sendWindowRefresh how long the set of released time zones is reused
+const sendWindowRefresh = time.Minute
+
+// sendWindow released time zones of a windowed task
+type sendWindow struct {
+	taskId   int
+	hour     int
+	start    time.Time
+	fallback *time.Location
+
+	ready   []string // released time zones, "" stands for the recipients without one
+	checked time.Time
+}
+
+// newSendWindow the send window of the task, nil when the task is not windowed
+func newSendWindow(ctx context.Context, task *entity.EmailTask) *sendWindow {
+	if task.SendLocalHour < 0 || task.SendLocalHour > 23 {
+		return nil
+	}
+
+	fallback := time.Local
+	if task.DefaultTimezone != "" {
+		if loc, err := time.LoadLocation(task.DefaultTimezone); err == nil {
+			fallback = loc
+		} else {
+			g.Log().Warningf(ctx, "Task %d: unknown default time zone %s, using the server's", task.Id, task.DefaultTimezone)
+		}
+	}
+
+	start := time.Unix(int64(task.StartTime), 0)
+	if task.StartTime <= 0 {
+		start = time.Unix(int64(task.CreateTime), 0)
+	}
+
+	return &sendWindow{taskId: task.Id, hour: task.SendLocalHour, start: start, fallback: fallback}
+}
+
+// sendWindowDue the first time at or after start when the local time of loc is hour:00
+func sendWindowDue(start time.Time, hour int, loc *time.Location) time.Time {
+	local := start.In(loc)
+
+	due := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, loc)
+	if due.Before(local) {
+		due = time.Date(local.Year(), local.Month(), local.Day()+1, hour, 0, 0, 0, loc)
+	}
+
+	return due
+}
+
+// location time zone of a recipient, the fallback one when it is unknown or invalid
+func (w *sendWindow) location(timezone string) *time.Location {
+	if timezone == "" {
+		return w.fallback
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return w.fallback
+	}
+	return loc
+}
+
+// readyZones the time zones of the unsent recipients whose send hour has come
+func (w *sendWindow) readyZones(ctx context.Context, now time.Time) ([]string, error) {
+	if w.ready != nil && now.Sub(w.checked) < sendWindowRefresh {
+		return w.ready, nil
+	}
+
+	values, err := g.DB().Model("recipient_info").
+		Fields("DISTINCT timezone").
+		Where("task_id", w.taskId).
+		Where("is_sent", 0).
+		Array()
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make([]string, 0, len(values))
+	var next time.Time
+
+	for _, v := range values {
+		timezone := v.String()
+
+		due := sendWindowDue(w.start, w.hour, w.location(timezone))
+		if !now.Before(due) {
+			ready = append(ready, timezone)
+		} else if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	sort.Strings(ready)
+
+	if !next.IsZero() {
+		g.Log().Debugf(ctx, "Task %d: %d time zones released, the next one at %s", w.taskId, len(ready), next.Format(time.RFC3339))
+	}
+
+	w.ready, w.checked = ready, now
+	return ready, nil
+}
@@ -0,0 +1,172 @@
+package batch_mail
+
+import (
+	"billionmail-core/api/batch_mail/v1"
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/domains"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/smtp_policy"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"strings"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Sending identity of the campaigns, so that the campaigns of several clients keep
+// separate reputations. A campaign may be sent From its sending domain, whose DKIM key
+// signs the messages, and from an outbound IP of the multi-IP pool of that domain. The
+// envelope sender stays the addresser, the mailbox the messages are submitted with and
+// the bounces return to, which must be allowed to send as the From address. Without
+// them a campaign is sent as its addresser through the route of the addresser's domain.
+
+// SendingIdentity the identity the messages of a campaign are sent with
+type SendingIdentity struct {
+	From      string // From address
+	Domain    string // domain of From, signing the messages
+	Ip        string // outbound IP, empty: the route of the domain
+	transport string // master.cf transport bound to Ip
+}
+
+// ResolveSendingIdentity validates the sending domain and IP of a campaign of addresser,
+// either may be empty
+func ResolveSendingIdentity(ctx context.Context, addresser, domain, ip string) (SendingIdentity, error) {
+	id, err := sendingIdentityOf(ctx, addresser, domain, ip)
+	if err != nil {
+		return id, err
+	}
+
+	if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+		if exists, err := domains.Exists(ctx, id.Domain); err != nil || !exists {
+			return id, gerror.New(public.LangCtx(ctx, "Sending domain {} is not a local domain", id.Domain))
+		}
+
+		if err = smtp_policy.AuthorizeSender(ctx, addresser, id.From); err != nil {
+			return id, gerror.New(public.LangCtx(ctx, "Addresser {} may not send as {}", addresser, id.From))
+		}
+
+		record, err := domains.GetDKIMRecord(id.Domain, true)
+		if err != nil || !record.Valid {
+			return id, gerror.New(public.LangCtx(ctx, "The DKIM record of the sending domain {} is not valid", id.Domain))
+		}
+	}
+
+	return id, nil
+}
+
+// taskSendingIdentity the identity of a task, as resolved when it was saved
+func taskSendingIdentity(ctx context.Context, task *entity.EmailTask) (SendingIdentity, error) {
+	return sendingIdentityOf(ctx, task.Addresser, task.SendingDomain, task.SendingIp)
+}
+
+// sendingIdentityOf the identity of addresser with the sending domain and IP, the IP is
+// looked up in the pool of the domain
+func sendingIdentityOf(ctx context.Context, addresser, domain, ip string) (SendingIdentity, error) {
+	addresser = strings.ToLower(strings.TrimSpace(addresser))
+	local, addresserDomain, ok := strings.Cut(addresser, "@")
+	if !ok || local == "" || addresserDomain == "" {
+		return SendingIdentity{}, gerror.New(public.LangCtx(ctx, "Invalid addresser {}", addresser))
+	}
+
+	id := SendingIdentity{From: addresser, Domain: addresserDomain}
+	if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" && domain != addresserDomain {
+		id.From = local + "@" + domain
+		id.Domain = domain
+	}
+
+	if ip = strings.TrimSpace(ip); ip == "" {
+		return id, nil
+	}
+	if net.ParseIP(ip) == nil {
+		return id, gerror.New(public.LangCtx(ctx, "Invalid sending IP {}", ip))
+	}
+
+	transport, err := g.DB().Model("bm_multi_ip_domain").Ctx(ctx).
+		Where("domain", id.Domain).
+		Where("outbound_ip", ip).
+		Where("active", 1).
+		WhereNot("status", "failed").
+		Value("smtp_server_name")
+	if err != nil {
+		return id, gerror.New(public.LangCtx(ctx, "Failed to look up the sending IP {}: {}", ip, err.Error()))
+	}
+	if transport.IsEmpty() {
+		return id, gerror.New(public.LangCtx(ctx, "Sending IP {} is not in the outbound IP pool of {}", ip, id.Domain))
+	}
+
+	id.Ip = ip
+	id.transport = transport.String()
+	return id, nil
+}
+
+// apply sets the From of a message sent with the identity, and routes it to recipient
+// through its IP until release is called
+func (id SendingIdentity) apply(message *mail_service.Message, addresser, fullName, recipient string) (release func()) {
+	if !strings.EqualFold(id.From, addresser) {
+		name := strings.Split(id.From, "@")[0]
+		if fullName != "" {
+			name = mime.QEncoding.Encode("UTF-8", fullName)
+		}
+		message.SetHeader("From", fmt.Sprintf("%s <%s>", name, id.From))
+	}
+
+	if id.transport == "" {
+		return func() {}
+	}
+
+	// The policy service sees the envelope sender
+	sender := addresser
+	if message.ReturnPath != "" {
+		sender = message.ReturnPath
+	}
+	return smtp_policy.RouteSubmission(addresser, sender, recipient, id.transport)
+}
+
+// fillSendingIdentities sets the effective sending identity of the tasks: the IP of a
+// task without its own is the first of the pool of its domain, the server's without pool
+func fillSendingIdentities(ctx context.Context, list []*v1.EmailTask, serverIP string) {
+	domainIPs := make(map[string]string)
+	for _, task := range list {
+		id, _ := sendingIdentityOf(ctx, task.Addresser, task.SendingDomain, "")
+		task.SendingIdentity = v1.SendingIdentity{From: id.From, Domain: id.Domain, Ip: strings.TrimSpace(task.SendingIp)}
+		if task.SendingIdentity.Ip == "" && id.Domain != "" {
+			domainIPs[id.Domain] = ""
+		}
+	}
+	if len(domainIPs) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(domainIPs))
+	for domain := range domainIPs {
+		names = append(names, domain)
+	}
+	rows, err := g.DB().Model("bm_multi_ip_domain").Ctx(ctx).
+		Fields("domain, outbound_ip").
+		WhereIn("domain", names).
+		Where("active", 1).
+		WhereNot("status", "failed").
+		OrderAsc("id").
+		All()
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to look up the outbound IPs of the campaigns: %v", err)
+	}
+	for _, row := range rows {
+		if domain := row["domain"].String(); domainIPs[domain] == "" {
+			domainIPs[domain] = row["outbound_ip"].String()
+		}
+	}
+
+	for _, task := range list {
+		if task.SendingIdentity.Ip != "" {
+			continue
+		}
+		if task.SendingIdentity.Ip = domainIPs[task.SendingIdentity.Domain]; task.SendingIdentity.Ip == "" {
+			task.SendingIdentity.Ip = serverIP
+		}
+	}
+}
@@ -148,6 +148,11 @@ type TaskExecutor struct {
 	// task configuration cache
 	taskConfig   *entity.EmailTask
 	configLoaded time.Time
+	listHeaders  map[string]string
+	identity     SendingIdentity
+	abTest       *ABTest
+	sendWindow   *sendWindow
+	frequencyCap FrequencyCap
 
 	spintaxTemplate *SpintaxTemplate
 
@@ -158,6 +163,12 @@ type TaskExecutor struct {
 	pool *ants.Pool
 	wg   sync.WaitGroup
 
+	// SMTP connections reused between messages to the same domain
+	senderPool *mail_service.SenderPool
+
+	// sends of the recipients, recovered after a crash
+	journal *sendJournal
+
 	// metrics
 	sentCount   atomic.Int64
 	failedCount atomic.Int64
@@ -174,6 +185,7 @@ type SendResult struct {
 	Success     bool
 	MessageID   string
 	Error       error
+	Unsent      bool // the message did not leave, the recipient is left fetched for the recovery
 }
 
 // NewTaskExecutor create task executor
@@ -193,6 +205,7 @@ func NewTaskExecutor(ctx context.Context) *TaskExecutor {
 		pauseChan:      make(chan struct{}, 1),
 		resumeChan:     make(chan struct{}, 1),
 		rateController: NewSimpleRateController(1000),
+		senderPool:     mail_service.NewSenderPool(mail_service.SenderPoolConfig{}),
 	}
 
 	return executor
@@ -261,11 +274,24 @@ func (e *TaskExecutor) ProcessTask(ctx context.Context) error {
 
 	e.ctx = context.WithValue(e.ctx, "warmupAssociated", warmupAssociated)
 
+	// settle the recipients of an interrupted run before sending again
+	if _, err := recoverSends(ctx, task.Id); err != nil {
+		g.Log().Error(ctx, "failed to recover the sends of task %d: %v", task.Id, err)
+		return fmt.Errorf("failed to recover sends: %w", err)
+	}
+
+	e.journal, err = openSendJournal(sendJournalPath(task.Id))
+	if err != nil {
+		g.Log().Error(ctx, "failed to open the send journal: %v", err)
+		return fmt.Errorf("failed to open the send journal: %w", err)
+	}
+	defer e.journal.Close()
+
 	// configure rate controller
 	e.configureRateController(task)
 
-	// get template info
-	template, err := e.getTemplateInfo(ctx, task.TemplateId)
+	// get template info, at the version pinned by the task
+	template, err := e.getTaskTemplate(ctx, task, true)
 	if err != nil {
 		g.Log().Error(ctx, "failed to get template: %v", err)
 		return fmt.Errorf("failed to get template: %w", err)
@@ -298,6 +324,7 @@ func (e *TaskExecutor) ProcessTask(ctx context.Context) error {
 	}
 
 	defer e.pool.Release()
+	defer e.senderPool.Close()
 
 	// update task status to running
 	if task.TaskProcess == 0 {
@@ -369,6 +396,8 @@ func (e *TaskExecutor) ProcessTask(ctx context.Context) error {
 		}
 		completeMsg := fmt.Sprintf("task %d is successfully marked as completed", task.Id)
 		g.Log().Info(ctx, completeMsg)
+		e.journal.Close()
+		removeSendJournal(task.Id)
 		RemoveTaskExecutor(task.Id) // The executor is removed at the end of the task
 	}
 
@@ -400,6 +429,7 @@ func (e *TaskExecutor) Stop() {
 	if e.pool != nil {
 		e.pool.Release()
 	}
+	e.senderPool.Close()
 
 	e.isRunning.Store(false)
 }
@@ -417,7 +447,8 @@ func (e *TaskExecutor) PauseTask(taskId int) error {
 	// Wait for the current batch processing to be completed
 	e.waitForCurrentBatch()
 
-	// Reset the records that have been retrieved but not sent (is_sent = 2 -> is_sent = 0)
+	// Reset the records that have been retrieved but not sent (is_sent = 2 -> is_sent = 0),
+	// those sent meanwhile are marked from the send journal
 	resetCount, err := e.resetFetchedRecords(taskId)
 	if err != nil {
 		e.isPaused.Store(false)
@@ -451,18 +482,8 @@ func (e *TaskExecutor) waitForCurrentBatch() {
 }
 
 func (e *TaskExecutor) resetFetchedRecords(taskId int) (int64, error) {
-	result, err := g.DB().Model("recipient_info").
-		Where("task_id", taskId).
-		Where("is_sent", 2).
-		Data(g.Map{"is_sent": 0}).
-		Update()
-
-	if err != nil {
-		return 0, err
-	}
-
-	rowsAffected, _ := result.RowsAffected()
-	return rowsAffected, nil
+	requeued, err := recoverSends(context.Background(), taskId)
+	return int64(requeued), err
 }
 
 func (e *TaskExecutor) ResumeTask(taskId int) error {
@@ -534,6 +555,12 @@ func (e *TaskExecutor) loadTaskConfig(taskId int) error {
 
 	e.taskConfig = task
 	e.configLoaded = time.Now()
+	e.listHeaders = loadListHeaders(context.Background(), task)
+	if identity, err := taskSendingIdentity(context.Background(), task); err != nil {
+		g.Log().Warningf(context.Background(), "Task %d: sending identity kept: %v", taskId, err)
+	} else {
+		e.identity = identity
+	}
 
 	g.Log().Infof(context.Background(), "Task %d: config loaded into cache", taskId)
 	return nil
@@ -553,6 +580,30 @@ func (e *TaskExecutor) processTaskRecipients(ctx context.Context, task *entity.E
 	const batchSize = 50
 	var lastId = 0
 
+	// mailing-list headers are the same for every recipient of the task
+	e.listHeaders = loadListHeaders(ctx, task)
+
+	// a campaign is never sent from another identity than its own
+	identity, err := taskSendingIdentity(ctx, task)
+	if err != nil {
+		return fmt.Errorf("sending identity of task %d: %w", task.Id, err)
+	}
+	e.identity = identity
+
+	// while an A/B test runs only its cohort is sent, the others wait for the winner
+	abTest, err := e.prepareABTest(ctx, task)
+	if err != nil {
+		return err
+	}
+	e.abTest = abTest
+
+	// with a time zone window only the recipients whose local send hour has come are sent,
+	// the others are picked up by a later run of the task
+	e.sendWindow = newSendWindow(ctx, task)
+
+	// subscribers who received enough campaign emails lately are skipped
+	e.frequencyCap = GetFrequencyCapSettings(ctx).capOf(task.GroupId)
+
 	// add performance monitoring timer
 	statsTicker := time.NewTicker(15 * time.Second)
 	defer statsTicker.Stop()
@@ -611,6 +662,8 @@ func (e *TaskExecutor) processTaskRecipients(ctx context.Context, task *entity.E
 			// wait for resume signal
 			select {
 			case <-e.resumeChan:
+				// the recipients fetched before the pause are back in the queue
+				lastId = 0
 
 				if e.taskConfig != nil {
 					g.Log().Infof(ctx, "Using updated task config after resume: addresser=%s, subject=%s, template_id=%d, full_name=%s",
@@ -618,7 +671,7 @@ func (e *TaskExecutor) processTaskRecipients(ctx context.Context, task *entity.E
 
 					task = e.taskConfig
 
-					template, err := e.getTemplateInfo(ctx, task.TemplateId)
+					template, err := e.getTaskTemplate(ctx, task, true)
 					if err != nil {
 						g.Log().Errorf(ctx, "failed to get updated template: %v", err)
 					} else {
@@ -669,10 +722,24 @@ func (e *TaskExecutor) processTaskRecipients(ctx context.Context, task *entity.E
 func (e *TaskExecutor) getNextRecipientBatch(ctx context.Context, taskId, lastId, batchSize int) ([]*entity.RecipientInfo, error) {
 	var recipients []*entity.RecipientInfo
 
-	err := g.DB().Model("recipient_info").
+	model := g.DB().Model("recipient_info").
 		Where("task_id", taskId).
 		Where("is_sent", 0).
-		Where("id > ?", lastId).
+		Where("id > ?", lastId)
+
+	if e.abTest != nil && e.abTest.Status != abStatusDecided {
+		model = model.Where("ab_variant >= 0")
+	}
+
+	if e.sendWindow != nil {
+		zones, err := e.sendWindow.readyZones(ctx, time.Now())
+		if err != nil || len(zones) == 0 {
+			return recipients, err
+		}
+		model = model.WhereIn("timezone", zones)
+	}
+
+	err := model.
 		Order("id ASC").
 		Limit(batchSize).
 		Scan(&recipients)
@@ -735,19 +802,16 @@ func (e *TaskExecutor) processRecipientBatch(ctx context.Context, task *entity.E
 		}
 	}
 
+	recipients = e.skipFrequencyCapped(ctx, task, recipients)
+	recipients = e.skipOptedOut(ctx, task, recipients)
+
 	updates := make(map[int]int)
 
 	// submit send task for each recipient
 	for _, recipient := range recipients {
-		// check again if paused or canceled
+		// paused, the rest of the batch is put back in the queue by PauseTask
 		if e.isPaused.Load() {
-			select {
-			case <-e.resumeChan:
-				// resumed
-			case <-ctx.Done():
-				safeClose() // safe close channel
-				return ctx.Err()
-			}
+			break
 		}
 
 		select {
@@ -826,6 +890,7 @@ func (e *TaskExecutor) processRecipientBatch(ctx context.Context, task *entity.E
 				RecipientID: recipient.Id,
 				Success:     false,
 				Error:       fmt.Errorf("failed to submit to worker pool: %w", err),
+				Unsent:      true,
 			}
 
 			// safe send result
@@ -891,6 +956,69 @@ func (e *TaskExecutor) processRecipientBatch(ctx context.Context, task *entity.E
 	}
 }
 
+// skipFrequencyCapped records the recipients over the frequency cap and returns the others
+func (e *TaskExecutor) skipFrequencyCapped(ctx context.Context, task *entity.EmailTask, recipients []*entity.RecipientInfo) []*entity.RecipientInfo {
+	capped, err := e.frequencyCap.cappedRecipients(ctx, recipients)
+	if err != nil {
+		g.Log().Warningf(ctx, "task %d: failed to check the frequency cap, sending anyway: %v", task.Id, err)
+		return recipients
+	}
+	if len(capped) == 0 {
+		return recipients
+	}
+
+	allowed := make([]*entity.RecipientInfo, 0, len(recipients)-len(capped))
+	ids := make([]int, 0, len(capped))
+	for _, r := range recipients {
+		if capped[r.Id] {
+			ids = append(ids, r.Id)
+		} else {
+			allowed = append(allowed, r)
+		}
+	}
+
+	// left fetched on failure, the next run of the task checks them again
+	if err = skipCapped(ctx, task.Id, ids); err != nil {
+		g.Log().Errorf(ctx, "task %d: failed to record %d frequency capped recipients: %v", task.Id, len(ids), err)
+	} else {
+		g.Log().Debugf(ctx, "task %d: %d recipients over the frequency cap skipped", task.Id, len(ids))
+	}
+
+	return allowed
+}
+
+// skipOptedOut records the recipients opted out of the category of the task and
+// returns the others
+func (e *TaskExecutor) skipOptedOut(ctx context.Context, task *entity.EmailTask, recipients []*entity.RecipientInfo) []*entity.RecipientInfo {
+	optedOut, err := optedOutRecipients(ctx, task.Category, recipients)
+	if err != nil {
+		g.Log().Warningf(ctx, "task %d: failed to check the subscriber preferences, sending anyway: %v", task.Id, err)
+		return recipients
+	}
+	if len(optedOut) == 0 {
+		return recipients
+	}
+
+	allowed := make([]*entity.RecipientInfo, 0, len(recipients)-len(optedOut))
+	ids := make([]int, 0, len(optedOut))
+	for _, r := range recipients {
+		if optedOut[r.Id] {
+			ids = append(ids, r.Id)
+		} else {
+			allowed = append(allowed, r)
+		}
+	}
+
+	// left fetched on failure, the next run of the task checks them again
+	if err = skipOptedOut(ctx, task.Id, ids); err != nil {
+		g.Log().Errorf(ctx, "task %d: failed to record %d opted out recipients: %v", task.Id, len(ids), err)
+	} else {
+		g.Log().Debugf(ctx, "task %d: %d recipients opted out of %s skipped", task.Id, len(ids), task.Category)
+	}
+
+	return allowed
+}
+
 // processSendResults
 func (e *TaskExecutor) processSendResults(ctx context.Context, resultChan <-chan *SendResult) {
 	const batchSize = 50
@@ -992,6 +1120,11 @@ func (e *TaskExecutor) processSendResults(ctx context.Context, resultChan <-chan
 				return
 			}
 
+			if result.Unsent {
+				// left fetched, the next run of the task sends it
+				continue
+			}
+
 			if result.Success {
 				successResults = append(successResults, result)
 			} else {
@@ -1085,6 +1218,10 @@ func (e *TaskExecutor) personalizeEmail(ctx context.Context, content string, tas
 		emailtask = *task
 	}
 
+	if subject, ok := e.abTest.subjectFor(recipient.AbVariant); ok {
+		emailtask.Subject = subject
+	}
+
 	// Unsubscribe
 	var renderedContent, renderedSubject string
 	engine := GetTemplateEngine()
@@ -1172,6 +1309,7 @@ func (e *TaskExecutor) sendEmail(ctx context.Context, task *entity.EmailTask, re
 			RecipientID: recipient.Id,
 			Success:     false,
 			Error:       ctx.Err(),
+			Unsent:      true,
 		}
 	default:
 		// continue execution
@@ -1185,7 +1323,7 @@ func (e *TaskExecutor) sendEmail(ctx context.Context, task *entity.EmailTask, re
 	// get rendered content and subject
 	renderedContent, renderedSubject := e.personalizeEmail(ctx, content, currentTask, recipient)
 
-	sender, err := mail_service.NewEmailSenderWithLocal(currentTask.Addresser)
+	sender, err := e.senderPool.Get(currentTask.Addresser, recipient.Recipient)
 	if err != nil {
 		g.Log().Error(ctx, "create email sender failed: %v", err)
 		return &SendResult{
@@ -1194,7 +1332,6 @@ func (e *TaskExecutor) sendEmail(ctx context.Context, task *entity.EmailTask, re
 			Error:       fmt.Errorf("create email sender failed: %w", err),
 		}
 	}
-	defer sender.Close()
 	// set message ID
 	messageID := sender.GenerateMessageID()
 
@@ -1215,11 +1352,34 @@ func (e *TaskExecutor) sendEmail(ctx context.Context, task *entity.EmailTask, re
 		message.SetRealName(currentTask.FullName)
 	}
 
+	// mailing-list headers of the group
+	applyListHeaders(&message, e.listHeaders)
+
 	//g.Log().Infof(ctx, "sendEmail - final check before sending: sender=%s, display_name=%s, subject=%s, recipient=%s",
 	//	currentTask.Addresser, currentTask.FullName, renderedSubject, recipient.Recipient)
 
-	// send email
-	err = sender.Send(message, []string{recipient.Recipient})
+	// Return path attributing the bounces to the recipient
+	message.ReturnPath = verpReturnPath(ctx, currentTask.Id, recipient.Recipient)
+
+	// From and route of the sending identity of the campaign
+	release := e.identity.apply(&message, currentTask.Addresser, currentTask.FullName, recipient.Recipient)
+
+	// send email, the connection is kept for the next message to the same domain
+	err = e.journal.send(ctx, recipient.Id, messageID, func() error {
+		return sender.Send(message, []string{recipient.Recipient})
+	})
+	release()
+	if errors.Is(err, errJournal) {
+		e.senderPool.Put(sender, nil)
+		g.Log().Error(ctx, "email to %s not sent: %v", recipient.Recipient, err)
+		return &SendResult{
+			RecipientID: recipient.Id,
+			Success:     false,
+			Error:       err,
+			Unsent:      true,
+		}
+	}
+	e.senderPool.Put(sender, err)
 	if err != nil {
 		g.Log().Error(ctx, "send email to %s failed: %v", recipient.Recipient, err)
 		return &SendResult{
@@ -1437,6 +1597,7 @@ func (e *TaskExecutor) GetMetrics() map[string]interface{} {
 		"current_speed": e.rateController.GetCurrentRate(),
 		"max_rate":      e.rateController.GetMaxRate(),
 		"duration_sec":  duration,
+		"sender_pool":   e.senderPool.Stats(),
 	}
 }
 
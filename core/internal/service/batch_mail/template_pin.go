@@ -0,0 +1,54 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/email_template"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// getTaskTemplate returns the template of a task at the version it pinned. With pin, a
+// task without one pins the current version, so the edits saved while it sends do not
+// change what its remaining recipients receive. Without pin the current version is used
+func (e *TaskExecutor) getTaskTemplate(ctx context.Context, task *entity.EmailTask, pin bool) (*entity.EmailTemplate, error) {
+	template, err := e.getTemplateInfo(ctx, task.TemplateId)
+	if err != nil {
+		return nil, err
+	}
+
+	version := task.TemplateVersion
+	if version == 0 {
+		if !pin {
+			return template, nil
+		}
+
+		if version, err = email_template.SnapshotTemplate(ctx, task.TemplateId); err != nil {
+			return nil, fmt.Errorf("failed to pin the template version: %w", err)
+		}
+		_, err = g.DB().Model("email_tasks").Ctx(ctx).
+			Where("id", task.Id).
+			Data(g.Map{"template_version": version}).
+			Update()
+		if err != nil {
+			return nil, fmt.Errorf("failed to pin the template version: %w", err)
+		}
+		task.TemplateVersion = version
+
+		g.Log().Infof(ctx, "Task %d: template %d pinned at version %d", task.Id, task.TemplateId, version)
+	}
+
+	pinned, err := email_template.GetTemplateVersion(ctx, task.TemplateId, version)
+	if err != nil {
+		return nil, err
+	}
+	if pinned == nil {
+		return nil, fmt.Errorf("version %d of template %d not found", version, task.TemplateId)
+	}
+
+	template.Content = pinned.Content
+	template.Render = pinned.Render
+
+	return template, nil
+}
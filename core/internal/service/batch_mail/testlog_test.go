@@ -0,0 +1,3 @@
+package batch_mail
+
+import _ "billionmail-core/internal/testlog"
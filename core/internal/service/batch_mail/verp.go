@@ -0,0 +1,378 @@
+package batch_mail
+
+import (
+	"billionmail-core/internal/service/abnormal_recipient"
+	"billionmail-core/internal/service/domains"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/relay"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Bounce attribution of the campaigns by VERP, see mail_service.VERPReturnPath. Each
+// campaign message is sent with a return path encoding its campaign and recipient, the
+// bounce address prefix@domain is routed by the transport map to the receiver below,
+// postfix matching the tagged addresses through its recipient_delimiter. The return-path
+// domain must be a local domain with an SPF record authorizing this server, as it is the
+// domain SPF checks, and postfix only accepts the bounces once the bounce address is a
+// mailbox of it. A failed delivery reported by a bounce marks the message of its recipient
+// bounced in the delivery records, a permanent failure also counts towards the abnormal
+// recipient suppression. A message without a delivery report is accepted and ignored.
+
+const (
+	verpListenAddr    = ":10027"
+	verpTransport     = "smtp:[core]:10027" // route of the bounce address as seen by postfix
+	verpIdleTimeout   = 5 * time.Minute
+	verpMaxBounceSize = 10 << 20
+)
+
+var (
+	verpOnce   sync.Once
+	verpRouted string // bounce address routed to the receiver, guarded by verpMutex
+	verpMutex  sync.Mutex
+)
+
+// SetVERPSettings validates the return-path domain of the campaigns, stores the settings
+// and routes the bounce address to the receiver
+func SetVERPSettings(ctx context.Context, s mail_service.VERPSettings) error {
+	if s.Enabled {
+		s.Domain = strings.ToLower(strings.TrimSpace(s.Domain))
+		if exists, err := domains.Exists(ctx, s.Domain); err != nil || !exists {
+			return gerror.New(public.LangCtx(ctx, "Return-path domain {} is not a local domain", s.Domain))
+		}
+
+		record, err := domains.GetSPFRecord(s.Domain, true)
+		if err != nil || !record.Valid {
+			return gerror.New(public.LangCtx(ctx, "The SPF record of the return-path domain {} does not authorize this server", s.Domain))
+		}
+	}
+
+	if err := mail_service.SaveVERPSettings(ctx, s); err != nil {
+		return err
+	}
+
+	s = mail_service.GetVERPSettings(ctx)
+	if s.Enabled {
+		count, err := g.DB().Model("mailbox").Ctx(ctx).Where("username", s.BounceAddress()).Where("active", 1).Count()
+		if err != nil || count == 0 {
+			g.Log().Warningf(ctx, "VERP: %s is not an active mailbox, postfix rejects the bounces returned to it", s.BounceAddress())
+		}
+	}
+
+	routeVERPBounces(ctx)
+	return relay.SyncTransportMapToPostfix(ctx)
+}
+
+// routeVERPBounces routes the current bounce address to the receiver, none when VERP is
+// disabled
+func routeVERPBounces(ctx context.Context) {
+	s := mail_service.GetVERPSettings(ctx)
+
+	verpMutex.Lock()
+	defer verpMutex.Unlock()
+
+	if verpRouted != "" {
+		relay.UnregisterTransport(verpRouted)
+		verpRouted = ""
+	}
+	if s.Enabled {
+		verpRouted = s.BounceAddress()
+		relay.RegisterTransport(verpRouted, verpTransport)
+	}
+}
+
+// verpReturnPath the return path of the message of task to recipient, empty without VERP
+func verpReturnPath(ctx context.Context, taskId int, recipient string) string {
+	returnPath, err := mail_service.VERPReturnPath(ctx, taskId, recipient)
+	if err != nil {
+		g.Log().Warningf(ctx, "VERP: no return path for %s of task %d, sent with the addresser: %v", recipient, taskId, err)
+		return ""
+	}
+	return returnPath
+}
+
+// StartVERPReceiver routes the bounce address and listens for the bounces, it is a no-op
+// when already started
+func StartVERPReceiver(ctx context.Context) {
+	verpOnce.Do(func() {
+		routeVERPBounces(ctx)
+
+		ln, err := net.Listen("tcp", verpListenAddr)
+		if err != nil {
+			g.Log().Warning(ctx, "Start VERP bounce receiver failed: ", err)
+			return
+		}
+
+		g.Log().Infof(ctx, "VERP bounce receiver listening on %s", verpListenAddr)
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					g.Log().Warning(ctx, "VERP bounce receiver accept failed: ", err)
+					time.Sleep(time.Second)
+					continue
+				}
+
+				go serveVERPBounces(ctx, conn)
+			}
+		}()
+	})
+}
+
+// verpRecipient a recipient of a campaign a bounce is returned for
+type verpRecipient struct {
+	taskId    int
+	recipient string
+}
+
+// serveVERPBounces handles the SMTP session of one postfix connection
+func serveVERPBounces(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...any) bool {
+		return tp.PrintfLine(format, args...) == nil
+	}
+
+	if !reply("220 bounces ESMTP") {
+		return
+	}
+
+	var rcpts []verpRecipient
+	for {
+		_ = conn.SetDeadline(time.Now().Add(verpIdleTimeout))
+
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if !reply("250-bounces\r\n250-8BITMIME\r\n250 ENHANCEDSTATUSCODES") {
+				return
+			}
+		case "HELO":
+			if !reply("250 bounces") {
+				return
+			}
+		case "MAIL", "RSET":
+			rcpts = nil
+			if !reply("250 2.0.0 Ok") {
+				return
+			}
+		case "RCPT":
+			taskId, recipient, err := mail_service.VERPDecode(ctx, smtpPath(arg))
+			if err != nil {
+				g.Log().Debugf(ctx, "VERP: bounce recipient %s rejected: %v", smtpPath(arg), err)
+				if !reply("550 5.1.1 Unknown bounce recipient") {
+					return
+				}
+				continue
+			}
+			rcpts = append(rcpts, verpRecipient{taskId: taskId, recipient: recipient})
+			if !reply("250 2.1.5 Ok") {
+				return
+			}
+		case "DATA":
+			if len(rcpts) == 0 {
+				if !reply("503 5.5.1 No valid recipient") {
+					return
+				}
+				continue
+			}
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+
+			dr := tp.DotReader()
+			report, err := readDeliveryReport(io.LimitReader(dr, verpMaxBounceSize))
+			if _, drainErr := io.Copy(io.Discard, dr); drainErr != nil {
+				return
+			}
+
+			if err == nil {
+				for _, r := range rcpts {
+					err = attributeVERPBounce(ctx, r, report)
+					if err != nil {
+						break
+					}
+				}
+			} else {
+				g.Log().Infof(ctx, "VERP: message returned for %s of task %d ignored: %v", rcpts[0].recipient, rcpts[0].taskId, err)
+				err = nil
+			}
+			rcpts = nil
+
+			if err != nil {
+				g.Log().Warningf(ctx, "VERP: failed to attribute a bounce: %v", err)
+				if !reply("451 4.3.0 Bounce not recorded, try again later") {
+					return
+				}
+				continue
+			}
+			if !reply("250 2.0.0 Ok: recorded") {
+				return
+			}
+		case "NOOP":
+			if !reply("250 2.0.0 Ok") {
+				return
+			}
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			if !reply("502 5.5.2 Command not recognized") {
+				return
+			}
+		}
+	}
+}
+
+// smtpPath the address of the path argument of a MAIL or RCPT command
+func smtpPath(arg string) string {
+	if i := strings.Index(arg, "<"); i >= 0 {
+		arg = arg[i+1:]
+		if j := strings.Index(arg, ">"); j >= 0 {
+			arg = arg[:j]
+		}
+	}
+	return strings.TrimSpace(arg)
+}
+
+// deliveryReport the outcome of the first recipient of an RFC 3464 delivery report
+type deliveryReport struct {
+	action string // failed, delayed, delivered, relayed or expanded
+	status string // enhanced status code, e.g. 5.1.1
+	reason string // diagnostic of the remote server
+}
+
+// readDeliveryReport the delivery report of a bounce, an error when it has none
+func readDeliveryReport(r io.Reader) (deliveryReport, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return deliveryReport{}, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return deliveryReport{}, fmt.Errorf("not a delivery report")
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return deliveryReport{}, fmt.Errorf("no delivery status in the report")
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType != "message/delivery-status" && partType != "message/global-delivery-status" {
+			continue
+		}
+
+		return parseDeliveryStatus(part)
+	}
+}
+
+// parseDeliveryStatus the first per-recipient fields of a delivery-status part, after
+// its per-message fields
+func parseDeliveryStatus(r io.Reader) (deliveryReport, error) {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	if _, err := tp.ReadMIMEHeader(); err != nil {
+		return deliveryReport{}, fmt.Errorf("malformed delivery status: %w", err)
+	}
+
+	fields, err := tp.ReadMIMEHeader()
+	if err != nil && len(fields) == 0 {
+		return deliveryReport{}, fmt.Errorf("delivery status without recipient: %w", err)
+	}
+
+	report := deliveryReport{
+		action: strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+		status: strings.TrimSpace(fields.Get("Status")),
+	}
+	if _, diagnostic, ok := strings.Cut(fields.Get("Diagnostic-Code"), ";"); ok {
+		report.reason = strings.Join(strings.Fields(diagnostic), " ")
+	}
+	if report.action == "" {
+		return deliveryReport{}, fmt.Errorf("delivery status without action")
+	}
+	return report, nil
+}
+
+// attributeVERPBounce records the outcome report tells of the message of a campaign to
+// its recipient
+func attributeVERPBounce(ctx context.Context, r verpRecipient, report deliveryReport) error {
+	if report.action != "failed" {
+		g.Log().Debugf(ctx, "VERP: %s report for %s of task %d ignored", report.action, r.recipient, r.taskId)
+		return nil
+	}
+
+	messageId, err := g.DB().Model("recipient_info").Ctx(ctx).
+		Where("task_id", r.taskId).
+		Where("LOWER(recipient) = ?", r.recipient).
+		Value("message_id")
+	if err != nil {
+		return err
+	}
+	if messageId.IsEmpty() {
+		g.Log().Warningf(ctx, "VERP: bounce for %s of task %d, which sent it no message", r.recipient, r.taskId)
+		return nil
+	}
+
+	postfixIds, err := g.DB().Model("mailstat_message_ids").Ctx(ctx).
+		Where("message_id", messageId.String()).
+		Array("postfix_message_id")
+	if err != nil {
+		return err
+	}
+
+	description := strings.TrimSpace(report.status + " " + report.reason)
+	if len(postfixIds) > 0 {
+		_, err = g.DB().Model("mailstat_send_mails").Ctx(ctx).
+			Data(g.Map{
+				"status":          "bounced",
+				"dsn":             report.status,
+				"description":     "VERP bounce: " + description,
+				"log_time_millis": time.Now().UnixMilli(),
+			}).
+			WhereIn("postfix_message_id", postfixIds).
+			Where("LOWER(recipient) = ?", r.recipient).
+			Update()
+		if err != nil {
+			return err
+		}
+	}
+
+	g.Log().Infof(ctx, "VERP: message %s of task %d to %s bounced: %s", messageId.String(), r.taskId, r.recipient, description)
+
+	if !strings.HasPrefix(report.status, "5.") {
+		return nil
+	}
+	return abnormal_recipient.IngestBounceEvents(ctx, []abnormal_recipient.BounceEvent{{
+		Provider:  "verp",
+		Type:      abnormal_recipient.BounceTypeHard,
+		Recipient: r.recipient,
+		MessageID: messageId.String(),
+		Status:    report.status,
+		Reason:    report.reason,
+		Time:      time.Now(),
+	}})
+}
@@ -0,0 +1,137 @@
+package contact
+
+import (
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------
+// Mailing-list headers of the campaigns sent to a group (RFC 2919, RFC 3834).
+// Settings are stored per group, groups without settings use the defaults.
+// The List-Unsubscribe headers are not managed here and are never overwritten.
+// -----------------------------
+
+const listHeadersOptionKey = "list_headers"
+
+// ListHeaderConfig mailing-list headers settings of a group
+type ListHeaderConfig struct {
+	Enabled       bool   `json:"enabled"`
+	ListId        string `json:"list_id"`        // list-id label, derived from the group name when empty
+	Precedence    string `json:"precedence"`     // bulk or list, empty omits the header
+	AutoSubmitted string `json:"auto_submitted"` // auto-generated, empty omits the header
+}
+
+// DefaultListHeaderConfig settings of the groups without their own
+func DefaultListHeaderConfig() ListHeaderConfig {
+	return ListHeaderConfig{
+		Enabled:       true,
+		Precedence:    "bulk",
+		AutoSubmitted: "auto-generated",
+	}
+}
+
+var listIdLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+func getAllListHeaderConfigs(ctx context.Context) map[string]ListHeaderConfig {
+	configs := make(map[string]ListHeaderConfig)
+	_ = public.OptionsMgrInstance.GetOption(ctx, listHeadersOptionKey, &configs)
+	return configs
+}
+
+// GetListHeaderConfig returns the mailing-list headers settings of a group
+func GetListHeaderConfig(ctx context.Context, groupId int) ListHeaderConfig {
+	if cfg, ok := getAllListHeaderConfigs(ctx)[strconv.Itoa(groupId)]; ok {
+		return cfg
+	}
+	return DefaultListHeaderConfig()
+}
+
+// SetListHeaderConfig saves the mailing-list headers settings of a group
+func SetListHeaderConfig(ctx context.Context, groupId int, cfg ListHeaderConfig) error {
+	cfg.ListId = strings.ToLower(strings.TrimSpace(cfg.ListId))
+	if cfg.ListId != "" && !listIdLabelPattern.MatchString(cfg.ListId) {
+		return fmt.Errorf("invalid list id %q, expected dot separated labels of letters, digits and hyphens", cfg.ListId)
+	}
+
+	cfg.Precedence = strings.ToLower(strings.TrimSpace(cfg.Precedence))
+	if cfg.Precedence != "" && cfg.Precedence != "bulk" && cfg.Precedence != "list" {
+		return fmt.Errorf("invalid precedence %q, expected bulk or list", cfg.Precedence)
+	}
+
+	cfg.AutoSubmitted = strings.ToLower(strings.TrimSpace(cfg.AutoSubmitted))
+	if cfg.AutoSubmitted != "" && cfg.AutoSubmitted != "auto-generated" {
+		return fmt.Errorf("invalid auto-submitted value %q, expected auto-generated", cfg.AutoSubmitted)
+	}
+
+	configs := getAllListHeaderConfigs(ctx)
+	configs[strconv.Itoa(groupId)] = cfg
+
+	return public.OptionsMgrInstance.SetOption(ctx, listHeadersOptionKey, configs)
+}
+
+// ListHeaders builds the mailing-list headers of a group, senderDomain is the namespace of the list id
+func ListHeaders(group *entity.ContactGroup, senderDomain string, cfg ListHeaderConfig) map[string]string {
+	headers := make(map[string]string)
+	if !cfg.Enabled || group == nil || group.Id == 0 {
+		return headers
+	}
+
+	label := cfg.ListId
+	if label == "" {
+		label = listIdSlug(group.Name, group.Id)
+		if senderDomain != "" {
+			label += "." + strings.ToLower(senderDomain)
+		}
+	}
+
+	listId := "<" + label + ">"
+	if name := strings.TrimSpace(strings.NewReplacer(`"`, "", `\`, "").Replace(group.Name)); name != "" {
+		phrase := mime.QEncoding.Encode("UTF-8", name)
+		if phrase == name {
+			phrase = `"` + name + `"`
+		}
+		listId = phrase + " " + listId
+	}
+	headers["List-ID"] = listId
+
+	if cfg.Precedence != "" {
+		headers["Precedence"] = cfg.Precedence
+	}
+	if cfg.AutoSubmitted != "" {
+		headers["Auto-Submitted"] = cfg.AutoSubmitted
+	}
+
+	return headers
+}
+
+// listIdSlug list-id label of a group, e.g. "newsletter-12"
+func listIdSlug(name string, id int) string {
+	var b strings.Builder
+	hyphen := false
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > 40 {
+		slug = strings.TrimSuffix(slug[:40], "-")
+	}
+	if slug == "" {
+		return "list-" + strconv.Itoa(id)
+	}
+
+	return slug + "-" + strconv.Itoa(id)
+}
@@ -48,6 +48,7 @@ func init() {
                 status INTEGER DEFAULT 0, -- 0: Unconfirmed, 1: Confirmed
                 attribs   JSONB DEFAULT '{}'::jsonb,
 				last_active_at INTEGER DEFAULT 0,
+				timezone VARCHAR(64) NOT NULL DEFAULT '', -- IANA time zone of the subscriber, empty when unknown
                 FOREIGN KEY (group_id) REFERENCES bm_contact_groups(id) ON DELETE SET NULL,
                 UNIQUE(group_id, email)
             )`,
@@ -64,6 +65,17 @@ func init() {
     			UNIQUE(temp_name)
             )`,
 
+			`CREATE TABLE IF NOT EXISTS email_template_versions (
+                id SERIAL PRIMARY KEY,
+                template_id INTEGER NOT NULL,
+                version INTEGER NOT NULL,  -- numbered from 1 per template, the latest is the current content
+                temp_name VARCHAR(255) NOT NULL DEFAULT '',
+                content TEXT NOT NULL DEFAULT '',
+                render TEXT NOT NULL DEFAULT '',
+                create_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
+                UNIQUE(template_id, version)
+            )`,
+
 			`CREATE TABLE IF NOT EXISTS email_tasks (
                 id SERIAL PRIMARY KEY,
                 task_name VARCHAR(255) NOT NULL,
@@ -93,7 +105,17 @@ func init() {
 				group_id INTEGER NOT NULL DEFAULT 0,   
 				stats_update_time INTEGER NOT NULL DEFAULT 0,
 				tag_ids TEXT DEFAULT '', -- JSON array of tag ids for filtering contacts
-				tag_logic VARCHAR(10) DEFAULT 'AND' -- Tag logic (AND: must have all tags, OR: have any tag)
+				tag_logic VARCHAR(10) DEFAULT 'AND', -- Tag logic (AND: must have all tags, OR: have any tag)
+				send_local_hour SMALLINT NOT NULL DEFAULT -1, -- local hour of the recipients to deliver at, -1: no time zone window
+				default_timezone VARCHAR(64) NOT NULL DEFAULT '', -- time zone of the recipients without one, the server's when empty
+				capped_count INTEGER NOT NULL DEFAULT 0, -- recipients skipped by the frequency cap
+				pause_reason TEXT NOT NULL DEFAULT '', -- why the task was paused automatically
+				breaker_since INTEGER NOT NULL DEFAULT 0, -- start of the circuit breaker window, the last resume
+				template_version INTEGER NOT NULL DEFAULT 0, -- template version pinned when the task started
+				category VARCHAR(64) NOT NULL DEFAULT '', -- mail category of the campaign, empty when uncategorized
+				opted_out_count INTEGER NOT NULL DEFAULT 0, -- recipients skipped, opted out of the category
+				sending_domain VARCHAR(255) NOT NULL DEFAULT '', -- From domain signing the messages, the addresser's when empty
+				sending_ip VARCHAR(45) NOT NULL DEFAULT '' -- outbound IP of the multi-IP pool, the route of the domain when empty
     
             )`,
 
@@ -105,17 +127,56 @@ func init() {
                 sent_time INTEGER NOT NULL DEFAULT 0,
                 message_id TEXT NOT NULL,
                 create_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
+                ab_variant INTEGER NOT NULL DEFAULT -1, -- A/B test variant, -1: not in the test cohort
+                timezone VARCHAR(64) NOT NULL DEFAULT '', -- time zone of the contact at import, empty when unknown
+                frequency_capped SMALLINT NOT NULL DEFAULT 0, -- 1: skipped, the recipient was over the frequency cap
+                opted_out SMALLINT NOT NULL DEFAULT 0, -- 1: skipped, the recipient opted out of the category of the task
                 FOREIGN KEY (task_id) REFERENCES email_tasks(id) ON DELETE CASCADE,
                 UNIQUE(task_id, recipient)
             )`,
 
+			`CREATE TABLE IF NOT EXISTS email_task_ab_tests (
+                task_id INTEGER PRIMARY KEY,
+                test_fraction INTEGER NOT NULL DEFAULT 20, -- percentage of the recipients in the test cohort
+                metric VARCHAR(10) NOT NULL DEFAULT 'open', -- open, click
+                wait_seconds INTEGER NOT NULL DEFAULT 0,
+                status SMALLINT NOT NULL DEFAULT 0, -- 0: not started 1: testing 2: winner chosen
+                winner INTEGER NOT NULL DEFAULT -1,
+                test_started_at INTEGER NOT NULL DEFAULT 0,
+                evaluated_at INTEGER NOT NULL DEFAULT 0,
+                create_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
+                FOREIGN KEY (task_id) REFERENCES email_tasks(id) ON DELETE CASCADE
+            )`,
+
+			`CREATE TABLE IF NOT EXISTS email_task_ab_variants (
+                id SERIAL PRIMARY KEY,
+                task_id INTEGER NOT NULL,
+                variant INTEGER NOT NULL,
+                subject TEXT NOT NULL,
+                sent INTEGER NOT NULL DEFAULT 0,
+                opened INTEGER NOT NULL DEFAULT 0,
+                clicked INTEGER NOT NULL DEFAULT 0,
+                rate DOUBLE PRECISION NOT NULL DEFAULT 0,
+                FOREIGN KEY (task_id) REFERENCES email_tasks(id) ON DELETE CASCADE,
+                UNIQUE(task_id, variant)
+            )`,
+
 			`CREATE TABLE IF NOT EXISTS unsubscribe_records (
                 id SERIAL PRIMARY KEY,
                 email VARCHAR(320) NOT NULL,
                 group_id INTEGER,
                 template_id INTEGER,
                 task_id INTEGER,
-                unsubscribe_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())
+                unsubscribe_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
+                reason VARCHAR(255) NOT NULL DEFAULT ''
+            )`,
+
+			`CREATE TABLE IF NOT EXISTS subscriber_preferences (
+                email VARCHAR(320) NOT NULL,
+                category VARCHAR(64) NOT NULL,
+                subscribed SMALLINT NOT NULL DEFAULT 1, -- 0: opted out of the category
+                update_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
+                PRIMARY KEY (email, category)
             )`,
 
 			`CREATE TABLE IF NOT EXISTS abnormal_recipient (
@@ -128,6 +189,16 @@ func init() {
     			UNIQUE(recipient)
             )`,
 
+			`CREATE TABLE IF NOT EXISTS esp_webhook_events (
+				provider VARCHAR(32) NOT NULL,
+				event_id VARCHAR(255) NOT NULL,
+				recipient VARCHAR(320) NOT NULL,
+				type VARCHAR(32) NOT NULL,
+				event_time INTEGER NOT NULL DEFAULT 0,
+				create_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
+				PRIMARY KEY (provider, event_id)
+			)`,
+
 			`CREATE TABLE IF NOT EXISTS api_templates (
                 id SERIAL PRIMARY KEY,
                 api_key VARCHAR(64) NOT NULL,
@@ -156,6 +227,8 @@ func init() {
                 status SMALLINT NOT NULL DEFAULT 0, -- 0:to send, 2:send, 3:send failed
                 error_message TEXT, 
 				attribs JSONB DEFAULT '{}'::jsonb,
+				headers JSONB DEFAULT '{}'::jsonb, -- custom headers of the message
+				priority SMALLINT NOT NULL DEFAULT 1, -- 0:bulk, 1:normal, 2:high
                 send_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW()),
                 create_time INTEGER NOT NULL DEFAULT EXTRACT(EPOCH FROM NOW())
 
@@ -200,6 +273,7 @@ func init() {
 			`CREATE INDEX IF NOT EXISTS idx_recipient_info_is_sent ON recipient_info(is_sent)`,
 			`CREATE INDEX IF NOT EXISTS idx_recipient_info_message_id ON recipient_info(message_id)`,
 			`CREATE INDEX IF NOT EXISTS idx_recipient_info_task_sent ON recipient_info(task_id, is_sent)`,
+			`CREATE INDEX IF NOT EXISTS idx_recipient_info_recipient_sent ON recipient_info(recipient, sent_time)`,
 			`CREATE INDEX IF NOT EXISTS idx_email_tasks_task_process ON email_tasks(task_process)`,
 			`CREATE INDEX IF NOT EXISTS idx_bm_tags_group_id ON bm_tags(group_id)`,
 			`CREATE INDEX IF NOT EXISTS idx_bm_contact_tags_contact_id ON bm_contact_tags(contact_id)`,
@@ -217,12 +291,18 @@ func init() {
 		_ = AddColumnIfNotExists("bm_contacts", "attribs", "JSONB", "'{}'::jsonb", false)
 		_ = AddColumnIfNotExists("bm_contacts", "status", "INTEGER", "1", true)
 		_ = AddColumnIfNotExists("bm_contacts", "last_active_at", "INTEGER", "0", true)
+		_ = AddColumnIfNotExists("bm_contacts", "timezone", "VARCHAR(64)", "''", true)
+
+		// unsubscribe_records
+		_ = AddColumnIfNotExists("unsubscribe_records", "reason", "VARCHAR(255)", "''", true)
 
 		//  api_mail_logs
 		_ = AddColumnIfNotExists("api_mail_logs", "status", "SMALLINT", "0", true)
 		_ = AddColumnIfNotExists("api_mail_logs", "error_message", "TEXT", "''", false)
 		_ = AddColumnIfNotExists("api_mail_logs", "create_time", "INTEGER", "EXTRACT(EPOCH FROM NOW())", true)
 		_ = AddColumnIfNotExists("api_mail_logs", "attribs", "JSONB", "'{}'::jsonb", false)
+		_ = AddColumnIfNotExists("api_mail_logs", "headers", "JSONB", "'{}'::jsonb", false)
+		_ = AddColumnIfNotExists("api_mail_logs", "priority", "SMALLINT", "1", true)
 
 		//bm_contact_groups
 		_ = AddColumnIfNotExists("bm_contact_groups", "token", "VARCHAR(30)", "''", true)
@@ -257,6 +337,25 @@ func init() {
 		_ = AddColumnIfNotExists("email_tasks", "group_id", "INTEGER", "0", true)
 		_ = AddColumnIfNotExists("email_tasks", "tag_ids", "TEXT", "''", false)
 		_ = AddColumnIfNotExists("email_tasks", "tag_logic", "VARCHAR(10)", "'AND'", false)
+		_ = AddColumnIfNotExists("email_tasks", "send_local_hour", "SMALLINT", "-1", true)
+		_ = AddColumnIfNotExists("email_tasks", "default_timezone", "VARCHAR(64)", "''", true)
+		_ = AddColumnIfNotExists("email_tasks", "capped_count", "INTEGER", "0", true)
+		_ = AddColumnIfNotExists("email_tasks", "pause_reason", "TEXT", "''", true)
+		_ = AddColumnIfNotExists("email_tasks", "breaker_since", "INTEGER", "0", true)
+		_ = AddColumnIfNotExists("email_tasks", "template_version", "INTEGER", "0", true)
+		_ = AddColumnIfNotExists("email_tasks", "category", "VARCHAR(64)", "''", true)
+		_ = AddColumnIfNotExists("email_tasks", "opted_out_count", "INTEGER", "0", true)
+		_ = AddColumnIfNotExists("email_tasks", "sending_domain", "VARCHAR(255)", "''", true)
+		_ = AddColumnIfNotExists("email_tasks", "sending_ip", "VARCHAR(45)", "''", true)
+
+		// recipient_info
+		_ = AddColumnIfNotExists("recipient_info", "ab_variant", "INTEGER", "-1", true)
+		_ = AddColumnIfNotExists("recipient_info", "timezone", "VARCHAR(64)", "''", true)
+		_ = AddColumnIfNotExists("recipient_info", "frequency_capped", "SMALLINT", "0", true)
+		_ = AddColumnIfNotExists("recipient_info", "opted_out", "SMALLINT", "0", true)
+
+		// abnormal_recipient
+		_ = AddColumnIfNotExists("abnormal_recipient", "source", "VARCHAR(64)", "''", true)
 
 		//api_templates
 		_ = AddColumnIfNotExists("api_templates", "group_id", "INTEGER", "0", true)
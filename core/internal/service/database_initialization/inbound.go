@@ -0,0 +1,79 @@
+package database_initialization
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+func init() {
+	registerHandler(func() {
+		sqlList := []string{
+			`-- DMARC decisions of inbound mail, used for DMARC reporting
+			CREATE TABLE IF NOT EXISTS bm_dmarc_decisions (
+				id BIGSERIAL PRIMARY KEY,
+				header_from VARCHAR(255) NOT NULL DEFAULT '',
+				source_ip VARCHAR(64) NOT NULL DEFAULT '',
+				spf_aligned BOOLEAN NOT NULL DEFAULT FALSE,
+				dkim_aligned BOOLEAN NOT NULL DEFAULT FALSE,
+				policy VARCHAR(20) NOT NULL DEFAULT '',
+				action VARCHAR(20) NOT NULL DEFAULT '',
+				enforced BOOLEAN NOT NULL DEFAULT FALSE,
+				reason TEXT NOT NULL DEFAULT '',
+				create_time INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_dmarc_decisions_from_time ON bm_dmarc_decisions(header_from, create_time);`,
+			`-- DKIM verification results of inbound mail, one row per signature
+			CREATE TABLE IF NOT EXISTS bm_inbound_dkim_results (
+				id BIGSERIAL PRIMARY KEY,
+				message_id VARCHAR(998) NOT NULL DEFAULT '',
+				domain VARCHAR(255) NOT NULL DEFAULT '',
+				selector VARCHAR(255) NOT NULL DEFAULT '',
+				algorithm VARCHAR(32) NOT NULL DEFAULT '',
+				result VARCHAR(20) NOT NULL DEFAULT '',
+				reason TEXT NOT NULL DEFAULT '',
+				create_time INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_inbound_dkim_results_message_id ON bm_inbound_dkim_results(message_id);`,
+			`-- Senders seen by each local recipient, trusted once a message was accepted
+			CREATE TABLE IF NOT EXISTS bm_first_contacts (
+				sender VARCHAR(255) NOT NULL,
+				recipient VARCHAR(255) NOT NULL,
+				trusted BOOLEAN NOT NULL DEFAULT FALSE,
+				first_seen INTEGER NOT NULL DEFAULT 0,
+				last_seen INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (sender, recipient)
+			)`,
+			`-- Inbound messages held in the postfix hold queue for review
+			CREATE TABLE IF NOT EXISTS bm_quarantine (
+				id BIGSERIAL PRIMARY KEY,
+				queue_id VARCHAR(64) NOT NULL DEFAULT '',
+				sender VARCHAR(255) NOT NULL DEFAULT '',
+				recipients TEXT NOT NULL DEFAULT '',
+				client_address VARCHAR(64) NOT NULL DEFAULT '',
+				reason VARCHAR(32) NOT NULL DEFAULT '',
+				detail TEXT NOT NULL DEFAULT '',
+				status VARCHAR(16) NOT NULL DEFAULT 'held',
+				create_time INTEGER NOT NULL DEFAULT 0,
+				update_time INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_quarantine_status_time ON bm_quarantine(status, create_time);`,
+			`-- Deliveries to the mailboxes opted in the duplicate suppression
+			CREATE TABLE IF NOT EXISTS bm_inbound_deliveries (
+				recipient VARCHAR(255) NOT NULL,
+				message_id VARCHAR(998) NOT NULL,
+				first_seen INTEGER NOT NULL DEFAULT 0,
+				duplicates INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (recipient, message_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_inbound_deliveries_first_seen ON bm_inbound_deliveries(first_seen);`,
+		}
+
+		for _, sql := range sqlList {
+			_, err := g.DB().Exec(context.Background(), sql)
+			if err != nil {
+				g.Log().Error(context.Background(), "Failed to create inbound tables:", err)
+				return
+			}
+		}
+	})
+}
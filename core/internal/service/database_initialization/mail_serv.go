@@ -40,6 +40,8 @@ func init() {
 				active SMALLINT NOT NULL DEFAULT 1,
 				used_quota BIGINT NOT NULL DEFAULT 0,	
 				quota_active SMALLINT NOT NULL DEFAULT 1,
+				last_login_time int NOT NULL default 0,
+				last_login_protocol varchar(16) NOT NULL default '',
 				PRIMARY KEY (username)
 			)`,
 
@@ -109,6 +111,39 @@ func init() {
     				UNIQUE (ip)
     			
 			)`,
+			`--  mailbox_crypt_keys, key pairs of the mailboxes encrypted at rest, read by dovecot
+			CREATE TABLE IF NOT EXISTS mailbox_crypt_keys (
+				username varchar(255) NOT NULL,
+				public_key TEXT NOT NULL,  -- base64 encoded PEM
+				private_key TEXT NOT NULL, -- base64 encoded PEM, derived from the master key of the core
+				create_time int NOT NULL default 0,
+				PRIMARY KEY (username)
+			)`,
+			`--  mailbox_folder_acl, rights granted on the folders of the shared mailboxes, enforced by dovecot
+			CREATE TABLE IF NOT EXISTS mailbox_folder_acl (
+				owner varchar(255) NOT NULL,
+				folder varchar(255) NOT NULL,
+				grantee varchar(255) NOT NULL,
+				rights varchar(32) NOT NULL DEFAULT '', -- RFC 4314 letters
+				update_time int NOT NULL default 0,
+				PRIMARY KEY (owner, folder, grantee)
+			)`,
+			`--  bm_mail_journal, envelopes of the journaled messages
+			CREATE TABLE IF NOT EXISTS bm_mail_journal (
+				token varchar(64) NOT NULL,
+				queue_id varchar(64) NOT NULL DEFAULT '',
+				directions varchar(32) NOT NULL DEFAULT '', -- inbound, outbound or both
+				domains TEXT NOT NULL DEFAULT '',
+				sender varchar(255) NOT NULL DEFAULT '',
+				recipients TEXT NOT NULL DEFAULT '',
+				client_address varchar(64) NOT NULL DEFAULT '',
+				client_name varchar(255) NOT NULL DEFAULT '',
+				sasl_username varchar(255) NOT NULL DEFAULT '',
+				create_time int NOT NULL default 0,
+				journal_time int NOT NULL default 0, -- 0 until the report was delivered
+				PRIMARY KEY (token)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_mail_journal_create_time ON bm_mail_journal(create_time);`,
 		}
 
 		for _, sql := range sqlList {
@@ -123,11 +158,25 @@ func init() {
 		_ = AddColumnIfNotExists("domain", "urls", "TEXT[]", "'{}'::TEXT[]", false)
 		_ = AddColumnIfNotExists("domain", "hasbrandinfo", "SMALLINT", "0", false)
 		_ = AddColumnIfNotExists("domain", "current_usage", "BIGINT", "0", true)
+		_ = AddColumnIfNotExists("domain", "encrypt_at_rest", "SMALLINT", "0", true)
 
 
 		// mailbox used quota column
 		_ = AddColumnIfNotExists("mailbox", "used_quota", "BIGINT", "0", true)
 		_ = AddColumnIfNotExists("mailbox", "quota_active", "SMALLINT", "1", true)
 
+		// mailbox last login columns
+		_ = AddColumnIfNotExists("mailbox", "last_login_time", "INTEGER", "0", true)
+		_ = AddColumnIfNotExists("mailbox", "last_login_protocol", "VARCHAR(16)", "''", true)
+
+		// mailbox sending switch column
+		_ = AddColumnIfNotExists("mailbox", "sending_disabled", "SMALLINT", "0", true)
+
+		// mailbox soft deletion column
+		_ = AddColumnIfNotExists("mailbox", "deleted_time", "INTEGER", "0", true)
+
+		// mailbox session limit column
+		_ = AddColumnIfNotExists("mailbox", "max_connections", "INTEGER", "0", true)
+
 	})
 }
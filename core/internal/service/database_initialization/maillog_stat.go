@@ -100,6 +100,23 @@ func init() {
 			CREATE INDEX IF NOT EXISTS deferredMails_postfixMessageId_logTime ON mailstat_deferred_mails (postfix_message_id, log_time)`,
 			`CREATE INDEX IF NOT EXISTS deferredMails_logTime_millis ON mailstat_deferred_mails (log_time_millis)`,
 
+			`-- Outbound TLS sessions for the SMTP TLS reports
+			CREATE TABLE IF NOT EXISTS mailstat_tls_sessions (
+				id SERIAL PRIMARY KEY,
+				postfix_message_id TEXT NOT NULL DEFAULT '',
+				log_time_millis BIGINT NOT NULL DEFAULT (EXTRACT(EPOCH FROM CURRENT_TIMESTAMP)::INTEGER),
+				log_time INTEGER GENERATED ALWAYS AS (log_time_millis / 1000) STORED,
+				policy_domain TEXT NOT NULL DEFAULT '',
+				mx_host TEXT NOT NULL DEFAULT '',
+				receiving_ip TEXT NOT NULL DEFAULT '',
+				result TEXT NOT NULL DEFAULT '',
+				detail TEXT NOT NULL DEFAULT '',
+				UNIQUE (postfix_message_id, policy_domain, log_time_millis)
+			)`,
+
+			`-- Indexes
+			CREATE INDEX IF NOT EXISTS tlsSessions_logTime_policyDomain ON mailstat_tls_sessions (log_time, policy_domain)`,
+
 			`-- Mail open tracking records
 			CREATE TABLE IF NOT EXISTS mailstat_opened (
 				id SERIAL PRIMARY KEY,
@@ -154,5 +171,10 @@ func init() {
 				return
 			}
 		}
+
+		// clicked link of the message and its URL without tracking parameters
+		_ = AddColumnIfNotExists("mailstat_clicked", "link_index", "INTEGER", "0", true)
+		_ = AddColumnIfNotExists("mailstat_clicked", "normalized_url", "TEXT", "''", true)
+		_, _ = g.DB().Exec(context.Background(), `CREATE INDEX IF NOT EXISTS clicked_campaignId_normalizedUrl ON mailstat_clicked (campaign_id, normalized_url)`)
 	})
 }
@@ -0,0 +1,466 @@
+package dns_resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// -----------------------------
+// Shared caching resolver of the MX, SPF, DKIM and DMARC lookups and the DNS record
+// checks. Answers are cached for their TTL, bounded by MinTTL and MaxTTL, and missing
+// names or records for the SOA minimum of their zone, bounded by NegativeTTL (RFC 2308).
+// Concurrent lookups of the same record share one query, at most MaxConcurrent queries
+// are in flight and each is bounded by Timeout. Failures other than a missing record are
+// not cached, the next lookup queries again.
+// -----------------------------
+
+const (
+	DefaultTimeout       = 5 * time.Second
+	DefaultMaxConcurrent = 32
+	DefaultMinTTL        = 5 * time.Second
+	DefaultMaxTTL        = time.Hour
+	DefaultNegativeTTL   = 5 * time.Minute
+
+	defaultResolvConf = "/etc/resolv.conf"
+	maxCacheEntries   = 10000
+)
+
+// Config settings of a resolver, the defaults for the zero fields
+type Config struct {
+	Servers       []string // "host:port" of the upstream resolvers, those of /etc/resolv.conf when empty
+	Timeout       time.Duration
+	MaxConcurrent int
+	MinTTL        time.Duration
+	MaxTTL        time.Duration
+	NegativeTTL   time.Duration
+}
+
+// Stats cache metrics of a resolver since its creation
+type Stats struct {
+	Hits         int64 `json:"hits"`          // lookups answered from the cache
+	NegativeHits int64 `json:"negative_hits"` // of which missing records
+	Misses       int64 `json:"misses"`        // lookups sent upstream
+	Shared       int64 `json:"shared"`        // lookups waiting for a query in flight
+	Failures     int64 `json:"failures"`      // upstream queries failed, not cached
+	Entries      int   `json:"entries"`       // records currently cached
+}
+
+// Resolver caching DNS resolver, safe for concurrent use
+type Resolver struct {
+	cfg Config
+	sem chan struct{}
+
+	// exchange sends one query upstream, replaced in tests
+	exchange func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+	now      func() time.Time
+
+	mu       sync.Mutex
+	cache    map[cacheKey]*cacheEntry
+	inflight map[cacheKey]*pendingQuery
+
+	hits, negativeHits, misses, shared, failures atomic.Int64
+}
+
+type cacheKey struct {
+	name  string
+	qtype uint16
+}
+
+type cacheEntry struct {
+	answer  []dns.RR
+	missing bool // NXDOMAIN or no record of the type
+	expires time.Time
+}
+
+type pendingQuery struct {
+	done  chan struct{}
+	entry *cacheEntry
+	err   error
+}
+
+var (
+	defaultResolver     *Resolver
+	defaultResolverOnce sync.Once
+)
+
+// Default the resolver shared by the services, set up from /etc/resolv.conf
+func Default() *Resolver {
+	defaultResolverOnce.Do(func() {
+		defaultResolver = New(Config{})
+	})
+	return defaultResolver
+}
+
+// New creates a resolver with cfg
+func New(cfg Config) *Resolver {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = DefaultMaxConcurrent
+	}
+	if cfg.MinTTL <= 0 {
+		cfg.MinTTL = DefaultMinTTL
+	}
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = DefaultMaxTTL
+	}
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = DefaultNegativeTTL
+	}
+	if len(cfg.Servers) == 0 {
+		cfg.Servers = systemServers()
+	}
+
+	r := &Resolver{
+		cfg:      cfg,
+		sem:      make(chan struct{}, cfg.MaxConcurrent),
+		now:      time.Now,
+		cache:    make(map[cacheKey]*cacheEntry),
+		inflight: make(map[cacheKey]*pendingQuery),
+	}
+	r.exchange = r.exchangeUpstream
+
+	return r
+}
+
+// systemServers the resolvers of /etc/resolv.conf, the local resolver when unreadable
+func systemServers() []string {
+	conf, err := dns.ClientConfigFromFile(defaultResolvConf)
+	if err != nil || len(conf.Servers) == 0 {
+		return []string{"127.0.0.1:53"}
+	}
+
+	servers := make([]string, 0, len(conf.Servers))
+	for _, s := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(s, conf.Port))
+	}
+	return servers
+}
+
+// Stats returns the cache metrics
+func (r *Resolver) Stats() Stats {
+	r.mu.Lock()
+	entries := len(r.cache)
+	r.mu.Unlock()
+
+	return Stats{
+		Hits:         r.hits.Load(),
+		NegativeHits: r.negativeHits.Load(),
+		Misses:       r.misses.Load(),
+		Shared:       r.shared.Load(),
+		Failures:     r.failures.Load(),
+		Entries:      entries,
+	}
+}
+
+// Flush empties the cache
+func (r *Resolver) Flush() {
+	r.mu.Lock()
+	r.cache = make(map[cacheKey]*cacheEntry)
+	r.mu.Unlock()
+}
+
+// Forget drops the cached records of a domain and of the names below it, so a record
+// just changed is queried again
+func (r *Resolver) Forget(domain string) {
+	domain = dns.Fqdn(strings.ToLower(domain))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.cache {
+		if key.name == domain || strings.HasSuffix(key.name, "."+domain) {
+			delete(r.cache, key)
+		}
+	}
+}
+
+// LookupTXT the TXT records of name, the strings of each record joined
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	answer, err := r.lookup(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	txts := make([]string, 0, len(answer))
+	for _, rr := range answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, strings.Join(txt.Txt, ""))
+		}
+	}
+	return txts, nil
+}
+
+// LookupMX the MX records of name, by preference
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	answer, err := r.lookup(ctx, name, dns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+
+	mxs := make([]*net.MX, 0, len(answer))
+	for _, rr := range answer {
+		if mx, ok := rr.(*dns.MX); ok {
+			mxs = append(mxs, &net.MX{Host: mx.Mx, Pref: mx.Preference})
+		}
+	}
+	sortMX(mxs)
+	return mxs, nil
+}
+
+// LookupIP the IPv4 and IPv6 addresses of host, an IP address is returned as is
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	var ips []net.IP
+	var firstErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		answer, err := r.lookup(ctx, host, qtype)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, rr := range answer {
+			switch a := rr.(type) {
+			case *dns.A:
+				ips = append(ips, a.A)
+			case *dns.AAAA:
+				ips = append(ips, a.AAAA)
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		if firstErr == nil {
+			firstErr = notFound(host)
+		}
+		return nil, firstErr
+	}
+	return ips, nil
+}
+
+// LookupAddr the names of an IP address, from its PTR records
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	name, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+
+	answer, err := r.lookup(ctx, name, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(answer))
+	for _, rr := range answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			names = append(names, ptr.Ptr)
+		}
+	}
+	return names, nil
+}
+
+// lookup the records of the type at name, of the cache while fresh. The answer is
+// shared with the cache and must not be modified
+func (r *Resolver) lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	key := cacheKey{name: dns.Fqdn(strings.ToLower(name)), qtype: qtype}
+
+	r.mu.Lock()
+	if e, ok := r.cache[key]; ok && r.now().Before(e.expires) {
+		r.mu.Unlock()
+		r.hits.Add(1)
+		if e.missing {
+			r.negativeHits.Add(1)
+			return nil, notFound(name)
+		}
+		return e.answer, nil
+	}
+
+	if p, ok := r.inflight[key]; ok {
+		r.mu.Unlock()
+		r.shared.Add(1)
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return nil, ctxError(name, ctx.Err())
+		}
+		return entryResult(name, p.entry, p.err)
+	}
+
+	p := &pendingQuery{done: make(chan struct{})}
+	r.inflight[key] = p
+	r.mu.Unlock()
+
+	r.misses.Add(1)
+	p.entry, p.err = r.query(ctx, key)
+
+	r.mu.Lock()
+	delete(r.inflight, key)
+	if p.err == nil {
+		if len(r.cache) >= maxCacheEntries {
+			r.evictExpired()
+		}
+		r.cache[key] = p.entry
+	}
+	r.mu.Unlock()
+	close(p.done)
+
+	if p.err != nil {
+		r.failures.Add(1)
+	}
+	return entryResult(name, p.entry, p.err)
+}
+
+func entryResult(name string, e *cacheEntry, err error) ([]dns.RR, error) {
+	if err != nil {
+		return nil, err
+	}
+	if e.missing {
+		return nil, notFound(name)
+	}
+	return e.answer, nil
+}
+
+// query sends the question upstream within the concurrency limit and the timeout
+func (r *Resolver) query(ctx context.Context, key cacheKey) (*cacheEntry, error) {
+	select {
+	case r.sem <- struct{}{}:
+		defer func() { <-r.sem }()
+	case <-ctx.Done():
+		return nil, ctxError(key.name, ctx.Err())
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(key.name, key.qtype)
+	msg.RecursionDesired = true
+
+	reply, err := r.exchange(qctx, msg)
+	if err != nil {
+		if errors.Is(qctx.Err(), context.DeadlineExceeded) {
+			return nil, &net.DNSError{Err: "i/o timeout", Name: key.name, IsTimeout: true, IsTemporary: true}
+		}
+		return nil, &net.DNSError{Err: err.Error(), Name: key.name, IsTemporary: true}
+	}
+
+	switch reply.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return &cacheEntry{missing: true, expires: r.now().Add(r.negativeTTL(reply))}, nil
+	default:
+		return nil, &net.DNSError{Err: "server answered " + dns.RcodeToString[reply.Rcode], Name: key.name, IsTemporary: true}
+	}
+
+	answer := make([]dns.RR, 0, len(reply.Answer))
+	var ttl uint32
+	for _, rr := range reply.Answer {
+		if rr.Header().Rrtype != key.qtype {
+			// CNAME chains, the records of the target are in the answer as well
+			continue
+		}
+		if len(answer) == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+		answer = append(answer, rr)
+	}
+
+	if len(answer) == 0 {
+		return &cacheEntry{missing: true, expires: r.now().Add(r.negativeTTL(reply))}, nil
+	}
+
+	return &cacheEntry{answer: answer, expires: r.now().Add(r.boundTTL(time.Duration(ttl) * time.Second))}, nil
+}
+
+// exchangeUpstream sends the query to the upstream resolvers in turn, over TCP when the
+// UDP answer is truncated
+func (r *Resolver) exchangeUpstream(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	for _, server := range r.cfg.Servers {
+		client := &dns.Client{Timeout: r.cfg.Timeout}
+		reply, _, err := client.ExchangeContext(ctx, msg, server)
+		if err == nil && reply.Truncated {
+			client.Net = "tcp"
+			reply, _, err = client.ExchangeContext(ctx, msg, server)
+		}
+		if err == nil {
+			return reply, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// negativeTTL the caching time of a missing record, from the SOA of the authority section
+func (r *Resolver) negativeTTL(reply *dns.Msg) time.Duration {
+	ttl := r.cfg.NegativeTTL
+	for _, rr := range reply.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			soaTTL := soa.Minttl
+			if soa.Hdr.Ttl < soaTTL {
+				soaTTL = soa.Hdr.Ttl
+			}
+			if d := time.Duration(soaTTL) * time.Second; d < ttl {
+				ttl = d
+			}
+		}
+	}
+	if ttl < r.cfg.MinTTL {
+		ttl = r.cfg.MinTTL
+	}
+	return ttl
+}
+
+func (r *Resolver) boundTTL(ttl time.Duration) time.Duration {
+	if ttl < r.cfg.MinTTL {
+		return r.cfg.MinTTL
+	}
+	if ttl > r.cfg.MaxTTL {
+		return r.cfg.MaxTTL
+	}
+	return ttl
+}
+
+// evictExpired drops the expired entries, all of them when none expired, the caller holds the mutex
+func (r *Resolver) evictExpired() {
+	now := r.now()
+	for key, e := range r.cache {
+		if !now.Before(e.expires) {
+			delete(r.cache, key)
+		}
+	}
+	if len(r.cache) >= maxCacheEntries {
+		r.cache = make(map[cacheKey]*cacheEntry)
+	}
+}
+
+// notFound the error of a missing name or record, as returned by package net
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func ctxError(name string, err error) error {
+	return &net.DNSError{Err: err.Error(), Name: name, IsTimeout: errors.Is(err, context.DeadlineExceeded)}
+}
+
+// sortMX orders the records by preference, as net.LookupMX does
+func sortMX(mxs []*net.MX) {
+	sort.SliceStable(mxs, func(i, j int) bool { return mxs[i].Pref < mxs[j].Pref })
+}
@@ -0,0 +1,125 @@
+package dns_resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeUpstream answers TXT queries of example.com and NXDOMAIN for the other names
+func fakeUpstream(queries *atomic.Int64, delay time.Duration) func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	return func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+		queries.Add(1)
+		time.Sleep(delay)
+
+		reply := new(dns.Msg)
+		reply.SetReply(msg)
+
+		q := msg.Question[0]
+		if q.Name != "example.com." {
+			reply.Rcode = dns.RcodeNameError
+			reply.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "com.", Rrtype: dns.TypeSOA, Ttl: 900}, Minttl: 30}}
+			return reply, nil
+		}
+
+		reply.Answer = []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Ttl: 120}, Txt: []string{"v=spf1 ", "-all"}}}
+		return reply, nil
+	}
+}
+
+func TestResolverCache(t *testing.T) {
+	var queries atomic.Int64
+	now := time.Unix(1700000000, 0)
+
+	r := New(Config{Servers: []string{"127.0.0.1:53"}})
+	r.exchange = fakeUpstream(&queries, 0)
+	r.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		txts, err := r.LookupTXT(ctx, "Example.com")
+		if err != nil || len(txts) != 1 || txts[0] != "v=spf1 -all" {
+			t.Fatalf("lookup %d: %v, %v", i, txts, err)
+		}
+	}
+	if queries.Load() != 1 {
+		t.Errorf("%d upstream queries, want 1", queries.Load())
+	}
+
+	// Expired after its TTL
+	now = now.Add(121 * time.Second)
+	if _, err := r.LookupTXT(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if queries.Load() != 2 {
+		t.Errorf("%d upstream queries after the TTL, want 2", queries.Load())
+	}
+
+	// Missing names are cached for the SOA minimum
+	for i := 0; i < 2; i++ {
+		_, err := r.LookupTXT(ctx, "missing.example.org")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatalf("missing name: %v", err)
+		}
+	}
+	if queries.Load() != 3 {
+		t.Errorf("%d upstream queries, want 3", queries.Load())
+	}
+	now = now.Add(31 * time.Second)
+	_, _ = r.LookupTXT(ctx, "missing.example.org")
+	if queries.Load() != 4 {
+		t.Errorf("%d upstream queries after the negative TTL, want 4", queries.Load())
+	}
+
+	stats := r.Stats()
+	if stats.Hits != 3 || stats.NegativeHits != 1 || stats.Misses != 4 || stats.Entries != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestResolverSharesQueriesInFlight(t *testing.T) {
+	var queries atomic.Int64
+
+	r := New(Config{Servers: []string{"127.0.0.1:53"}, MaxConcurrent: 2})
+	r.exchange = fakeUpstream(&queries, 50*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.LookupTXT(context.Background(), "example.com"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if queries.Load() != 1 {
+		t.Errorf("%d upstream queries for concurrent lookups, want 1", queries.Load())
+	}
+}
+
+func TestResolverTimeout(t *testing.T) {
+	r := New(Config{Servers: []string{"127.0.0.1:53"}, Timeout: 20 * time.Millisecond})
+	r.exchange = func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	_, err := r.LookupMX(context.Background(), "example.com")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsTimeout {
+		t.Fatalf("timeout: %v", err)
+	}
+	if r.Stats().Entries != 0 {
+		t.Error("failure cached")
+	}
+}
@@ -2,11 +2,11 @@ package domains
 
 import (
 	v1 "billionmail-core/api/domains/v1"
+	"billionmail-core/internal/service/dns_resolver"
 	"billionmail-core/internal/service/public"
 	"context"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/text/gregex"
-	"net"
 	"strings"
 )
 
@@ -17,7 +17,7 @@ func ValidateARecord(record v1.DNSRecord) bool {
 	}
 
 	// Query A records
-	ips, err := net.LookupIP(record.Host)
+	ips, err := dns_resolver.Default().LookupIP(context.Background(), record.Host)
 	if err != nil {
 		return false
 	}
@@ -52,7 +52,7 @@ func ValidateTXTRecord(record v1.DNSRecord, domain string) bool {
 	} else {
 		// Query TXT records
 		var err error
-		txtRecords, err = net.LookupTXT(domain)
+		txtRecords, err = dns_resolver.Default().LookupTXT(context.Background(), domain)
 		if err != nil {
 			// g.Log().Error(context.Background(), "query txt records failed", err)
 			return false
@@ -140,7 +140,7 @@ func ValidateMXRecord(record v1.DNSRecord, domain string, aRecordHosts ...string
 
 	for _, d := range domains {
 		// Query MX records
-		mxRecords, err := net.LookupMX(d)
+		mxRecords, err := dns_resolver.Default().LookupMX(context.Background(), d)
 		if err != nil {
 			// g.Log().Error(context.Background(), "query mx record failed", err)
 			return false
@@ -168,7 +168,7 @@ func ValidatePTRRecord(record v1.DNSRecord) bool {
 	}
 
 	// Query PTR records
-	ptrRecords, err := net.LookupAddr(record.Host)
+	ptrRecords, err := dns_resolver.Default().LookupAddr(context.Background(), record.Host)
 	if err != nil {
 		return false
 	}
@@ -34,7 +34,14 @@ func CreateTemplate(ctx context.Context, name string, addType int, content, rend
 		return 0, err
 	}
 	id, err := result.LastInsertId()
-	return int(id), err
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = SnapshotTemplate(ctx, int(id)); err != nil {
+		g.Log().Warningf(ctx, "Failed to record the first version of template %d: %v", id, err)
+	}
+	return int(id), nil
 }
 
 // DeleteTemplate
@@ -43,7 +50,10 @@ func DeleteTemplate(ctx context.Context, id int) error {
 		Ctx(ctx).
 		Where("id", id).
 		Delete()
-	return err
+	if err != nil {
+		return err
+	}
+	return deleteTemplateVersions(ctx, id)
 }
 
 // GetTemplate
@@ -56,8 +66,13 @@ func GetTemplate(ctx context.Context, id int) (*v1.EmailTemplate, error) {
 	return template, err
 }
 
-// UpdateTemplate
+// UpdateTemplate saves the template as a new version
 func UpdateTemplate(ctx context.Context, id int, name, content, render string) error {
+	// The content being replaced is kept when it was saved without a version
+	if _, err := SnapshotTemplate(ctx, id); err != nil {
+		return err
+	}
+
 	data := g.Map{
 		"update_time": time.Now().Unix(),
 	}
@@ -76,6 +91,11 @@ func UpdateTemplate(ctx context.Context, id int, name, content, render string) e
 		Where("id", id).
 		Data(data).
 		Update()
+	if err != nil {
+		return err
+	}
+
+	_, err = SnapshotTemplate(ctx, id)
 	return err
 }
 
@@ -0,0 +1,142 @@
+package email_template
+
+import (
+	"billionmail-core/api/email_template/v1"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Versions of the templates. Every save records the content as a new immutable version,
+// a full copy numbered from 1 per template. The latest version is the current content,
+// a revert saves the content of an old version as a new one so the history stays linear.
+// A campaign pins the version current when it starts, an edit during the send does not
+// change what the remaining recipients receive.
+
+// SnapshotTemplate records the current content of a template as a new version, unless
+// the latest version already has it, and returns the number of that version. The
+// content saved without a version, before versioning or by the AI editor, is recorded
+// the first time it is needed
+func SnapshotTemplate(ctx context.Context, id int) (int, error) {
+	version := 0
+
+	err := g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		var template *v1.EmailTemplate
+		// The lock orders the concurrent snapshots of the template
+		if err := tx.Model("email_templates").Ctx(ctx).Where("id", id).LockUpdate().Scan(&template); err != nil {
+			return err
+		}
+		if template == nil {
+			return fmt.Errorf("template %d not found", id)
+		}
+
+		var latest *v1.TemplateVersion
+		err := tx.Model("email_template_versions").Ctx(ctx).
+			Where("template_id", id).
+			Order("version DESC").
+			Limit(1).
+			Scan(&latest)
+		if err != nil {
+			return err
+		}
+
+		if latest != nil && latest.TempName == template.TempName && latest.Content == template.Content && latest.Render == template.Render {
+			version = latest.Version
+			return nil
+		}
+
+		version = 1
+		if latest != nil {
+			version = latest.Version + 1
+		}
+
+		_, err = tx.Model("email_template_versions").Ctx(ctx).Insert(g.Map{
+			"template_id": id,
+			"version":     version,
+			"temp_name":   template.TempName,
+			"content":     template.Content,
+			"render":      template.Render,
+			"create_time": time.Now().Unix(),
+		})
+		return err
+	})
+
+	return version, err
+}
+
+// ListTemplateVersions returns the versions of a template without their content, newest
+// first. The latest is the current one
+func ListTemplateVersions(ctx context.Context, id int) ([]*v1.TemplateVersion, error) {
+	// The content saved since the latest version is part of the history
+	if _, err := SnapshotTemplate(ctx, id); err != nil {
+		return nil, err
+	}
+
+	versions := make([]*v1.TemplateVersion, 0)
+	err := g.DB().Model("email_template_versions").Ctx(ctx).
+		Fields("id, template_id, version, temp_name, create_time").
+		Where("template_id", id).
+		Order("version DESC").
+		Scan(&versions)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) > 0 {
+		versions[0].Current = true
+	}
+
+	return versions, nil
+}
+
+// GetTemplateVersion returns a version of a template with its content, nil when it does not exist
+func GetTemplateVersion(ctx context.Context, id, version int) (*v1.TemplateVersion, error) {
+	var v *v1.TemplateVersion
+	err := g.DB().Model("email_template_versions").Ctx(ctx).
+		Where("template_id", id).
+		Where("version", version).
+		Scan(&v)
+	return v, err
+}
+
+// RevertTemplate makes the content of an old version current, saved as a new version.
+// The name is kept, it may have been taken by another template since. It returns the
+// number of the new version
+func RevertTemplate(ctx context.Context, id, version int) (int, error) {
+	old, err := GetTemplateVersion(ctx, id, version)
+	if err != nil {
+		return 0, err
+	}
+	if old == nil {
+		return 0, fmt.Errorf("version %d of template %d not found", version, id)
+	}
+
+	// Keep the content being replaced when it was never recorded
+	if _, err = SnapshotTemplate(ctx, id); err != nil {
+		return 0, err
+	}
+
+	_, err = g.DB().Model("email_templates").Ctx(ctx).
+		Where("id", id).
+		Data(g.Map{
+			"content":     old.Content,
+			"render":      old.Render,
+			"update_time": time.Now().Unix(),
+		}).
+		Update()
+	if err != nil {
+		return 0, err
+	}
+
+	// Differs from the latest version unless that one was reverted to
+	return SnapshotTemplate(ctx, id)
+}
+
+// deleteTemplateVersions removes the history of a deleted template
+func deleteTemplateVersions(ctx context.Context, id int) error {
+	_, err := g.DB().Model("email_template_versions").Ctx(ctx).Where("template_id", id).Delete()
+	return err
+}
@@ -0,0 +1,407 @@
+package inbound
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/public"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -----------------------------
+// ARC sealing of forwarded mail (RFC 8617). The forwarder records the authentication
+// results it saw in an ARC-Authentication-Results header, signs the message with an
+// ARC-Message-Signature and seals the chain of ARC sets with an ARC-Seal, all with the
+// DKIM key of its domain, so the next hops can still trust the original results after
+// the message was modified. Rspamd seals the mail forwarded by postfix, see
+// WriteARCSigningConfig, ARCSeal does it for the messages relayed by the core service.
+// -----------------------------
+
+// ARC chain validation states, the cv= values of ARC-Seal
+const (
+	ARCNone = "none"
+	ARCPass = "pass"
+	ARCFail = "fail"
+)
+
+const (
+	arcMaxInstances = 50
+	arcSelector     = "default" // selector of the DKIM keys generated for the domains
+)
+
+// arcSignedHeaders header fields covered by the ARC-Message-Signature when present
+var arcSignedHeaders = []string{
+	"From", "To", "Cc", "Subject", "Date", "Message-ID", "Reply-To", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding", "List-Id", "List-Unsubscribe",
+	"DKIM-Signature",
+}
+
+var errARCChainFailed = errors.New("the ARC chain already failed, it must not be sealed again")
+
+// arcNow the time of the t= tags, replaceable in tests
+var arcNow = time.Now
+
+// arcSet the three headers of one ARC instance
+type arcSet struct {
+	instance int
+	aar      *dkimHeader
+	ams      *dkimHeader
+	seal     *dkimHeader
+}
+
+// ARCSeal adds an ARC set to a forwarded message with the DKIM key of domain. authResults
+// is the payload of the Authentication-Results of this server, e.g.
+// "mx.example.com; spf=pass smtp.mailfrom=example.org; dkim=pass header.d=example.org"
+func ARCSeal(ctx context.Context, domain string, msg []byte, authResults string) ([]byte, error) {
+	key, err := loadARCKey(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return arcSeal(ctx, strings.ToLower(domain), arcSelector, key, msg, authResults)
+}
+
+// loadARCKey the private DKIM key of a domain
+func loadARCKey(domain string) (crypto.Signer, error) {
+	data, err := os.ReadFile(public.AbsPath(filepath.Join(consts.RSPAMD_LIB_PATH, "dkim", domain, arcSelector+".private")))
+	if err != nil {
+		return nil, fmt.Errorf("no DKIM key for %s: %w", domain, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("malformed DKIM key of %s", domain)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("malformed DKIM key of %s: %w", domain, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported DKIM key of %s", domain)
+	}
+	return signer, nil
+}
+
+func arcSeal(ctx context.Context, domain, selector string, key crypto.Signer, msg []byte, authResults string) ([]byte, error) {
+	headers, body, err := splitDKIMMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := "rsa-sha256"
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		algorithm = "ed25519-sha256"
+	}
+
+	sets, err := arcSets(headers)
+	if err != nil {
+		return nil, fmt.Errorf("malformed ARC chain: %w", err)
+	}
+	if len(sets) > 0 && parseDKIMTags(headerValue(sets[len(sets)-1].seal))["cv"] == ARCFail {
+		return nil, errARCChainFailed
+	}
+
+	cv := verifyARCSets(ctx, sets, nil, headers, body)
+	instance := len(sets) + 1
+	if instance > arcMaxInstances {
+		return nil, fmt.Errorf("the ARC chain has more than %d sets", arcMaxInstances)
+	}
+
+	timestamp := arcNow().Unix()
+
+	// The authserv-id is the sealing domain when the results carry none
+	if first, _, _ := strings.Cut(authResults, ";"); strings.Contains(first, "=") || strings.TrimSpace(authResults) == "" {
+		authResults = strings.TrimSuffix(domain+"; "+strings.TrimSpace(authResults), "; ")
+	}
+	aar := dkimHeader{Name: "ARC-Authentication-Results", Raw: fmt.Sprintf("ARC-Authentication-Results: i=%d; %s\r\n", instance, strings.TrimSpace(authResults))}
+
+	// ARC-Message-Signature, a DKIM signature without v= tag
+	var names []string
+	for _, name := range arcSignedHeaders {
+		for _, h := range headers {
+			if strings.EqualFold(h.Name, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	bh := sha256.Sum256(canonicalizeDKIMBody(body, "relaxed"))
+
+	amsRaw := fmt.Sprintf("ARC-Message-Signature: i=%d; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d;\r\n\th=%s;\r\n\tbh=%s; b=\r\n",
+		instance, algorithm, domain, selector, timestamp, strings.Join(names, ":"), base64.StdEncoding.EncodeToString(bh[:]))
+	ams := &dkimSignature{header: dkimHeader{Name: "ARC-Message-Signature", Raw: amsRaw}, headers: names, headerC: "relaxed"}
+
+	b, err := arcSign(key, []byte(dkimSignedHeaders(ams, headers)))
+	if err != nil {
+		return nil, err
+	}
+	amsHeader := dkimHeader{Name: "ARC-Message-Signature", Raw: strings.TrimSuffix(amsRaw, "\r\n") + b + "\r\n"}
+
+	// ARC-Seal over every set, this one included
+	sealRaw := fmt.Sprintf("ARC-Seal: i=%d; a=%s; t=%d; cv=%s;\r\n\td=%s; s=%s; b=\r\n", instance, algorithm, timestamp, cv, domain, selector)
+	current := arcSet{instance: instance, aar: &aar, ams: &amsHeader, seal: &dkimHeader{Name: "ARC-Seal", Raw: sealRaw}}
+	b, err = arcSign(key, []byte(arcSealData(append(sets, current))))
+	if err != nil {
+		return nil, err
+	}
+	sealHeader := strings.TrimSuffix(sealRaw, "\r\n") + b + "\r\n"
+
+	var out bytes.Buffer
+	out.WriteString(sealHeader)
+	out.WriteString(amsHeader.Raw)
+	out.WriteString(aar.Raw)
+	for _, h := range headers {
+		out.WriteString(h.Raw)
+	}
+	out.WriteString("\r\n")
+	out.Write(body)
+
+	return out.Bytes(), nil
+}
+
+// arcSign signs the sha256 digest of data, base64 encoded
+func arcSign(key crypto.Signer, data []byte) (string, error) {
+	digest := sha256.Sum256(data)
+
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		opts = crypto.Hash(0)
+	}
+
+	signature, err := key.Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// VerifyARC validates the ARC chain of a message: none without ARC headers, pass when
+// every seal and the latest message signature verify
+func VerifyARC(ctx context.Context, msg []byte) (string, error) {
+	headers, body, err := splitDKIMMessage(msg)
+	if err != nil {
+		return "", err
+	}
+
+	sets, err := arcSets(headers)
+	return verifyARCSets(ctx, sets, err, headers, body), nil
+}
+
+func verifyARCSets(ctx context.Context, sets []arcSet, setsErr error, headers []dkimHeader, body []byte) string {
+	if setsErr != nil {
+		return ARCFail
+	}
+	if len(sets) == 0 {
+		return ARCNone
+	}
+
+	for _, set := range sets {
+		cv := parseDKIMTags(headerValue(set.seal))["cv"]
+		if (set.instance == 1 && cv != ARCNone) || (set.instance > 1 && cv != ARCPass) {
+			return ARCFail
+		}
+	}
+
+	// Only the latest message signature has to match the message as it is now
+	latest := sets[len(sets)-1]
+	tags := parseDKIMTags(headerValue(latest.ams))
+
+	bodyC := "relaxed"
+	headerC := "relaxed"
+	if c, ok := tags["c"]; ok {
+		headerC, bodyC, _ = strings.Cut(strings.ToLower(c), "/")
+		if bodyC == "" {
+			bodyC = "simple"
+		}
+	} else {
+		headerC, bodyC = "simple", "simple"
+	}
+
+	bh := sha256.Sum256(canonicalizeDKIMBody(body, bodyC))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bh[:]) {
+		return ARCFail
+	}
+
+	ams := &dkimSignature{header: *latest.ams, headerC: headerC}
+	for _, name := range strings.Split(tags["h"], ":") {
+		if name = strings.TrimSpace(name); name != "" {
+			ams.headers = append(ams.headers, name)
+		}
+	}
+	if !arcVerify(ctx, tags, []byte(dkimSignedHeaders(ams, headers))) {
+		return ARCFail
+	}
+
+	for i := range sets {
+		if !arcVerify(ctx, parseDKIMTags(headerValue(sets[i].seal)), []byte(arcSealData(sets[:i+1]))) {
+			return ARCFail
+		}
+	}
+
+	return ARCPass
+}
+
+// arcVerify checks the b= signature of an ARC header over data with the key of its d= and s= tags
+func arcVerify(ctx context.Context, tags map[string]string, data []byte) bool {
+	keyAlgorithm, hashName, _ := strings.Cut(strings.ToLower(tags["a"]), "-")
+	if hashName != "sha256" || (keyAlgorithm != "rsa" && keyAlgorithm != "ed25519") {
+		return false
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return false
+	}
+
+	key, err := lookupDKIMKey(ctx, &dkimSignature{domain: strings.ToLower(tags["d"]), selector: tags["s"]}, keyAlgorithm, hashName)
+	if err != nil {
+		return false
+	}
+
+	digest := sha256.Sum256(data)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, digest[:], signature)
+	}
+	return false
+}
+
+// arcSealData the canonicalized headers an ARC-Seal covers: the sets in instance order,
+// each AAR, AMS then AS, the last seal with an empty b= value and no trailing CRLF
+func arcSealData(sets []arcSet) string {
+	var buf strings.Builder
+
+	for i, set := range sets {
+		buf.WriteString(canonicalizeDKIMHeader(set.aar.Raw, "relaxed"))
+		buf.WriteString(canonicalizeDKIMHeader(set.ams.Raw, "relaxed"))
+
+		if i == len(sets)-1 {
+			buf.WriteString(strings.TrimSuffix(canonicalizeDKIMHeader(stripDKIMSignatureValue(set.seal.Raw), "relaxed"), "\r\n"))
+		} else {
+			buf.WriteString(canonicalizeDKIMHeader(set.seal.Raw, "relaxed"))
+		}
+	}
+
+	return buf.String()
+}
+
+// arcSets the ARC sets of the message in instance order, an error when the chain is
+// malformed: a missing or duplicate header or a gap in the instances
+func arcSets(headers []dkimHeader) ([]arcSet, error) {
+	byInstance := make(map[int]*arcSet)
+
+	for i := range headers {
+		h := &headers[i]
+
+		var slot **dkimHeader
+		set := func(instance int) *arcSet {
+			if byInstance[instance] == nil {
+				byInstance[instance] = &arcSet{instance: instance}
+			}
+			return byInstance[instance]
+		}
+
+		instance, ok := arcInstance(h)
+		switch {
+		case strings.EqualFold(h.Name, "ARC-Authentication-Results"):
+			if !ok {
+				return nil, errors.New("ARC-Authentication-Results without instance")
+			}
+			slot = &set(instance).aar
+		case strings.EqualFold(h.Name, "ARC-Message-Signature"):
+			if !ok {
+				return nil, errors.New("ARC-Message-Signature without instance")
+			}
+			slot = &set(instance).ams
+		case strings.EqualFold(h.Name, "ARC-Seal"):
+			if !ok {
+				return nil, errors.New("ARC-Seal without instance")
+			}
+			slot = &set(instance).seal
+		default:
+			continue
+		}
+
+		if *slot != nil {
+			return nil, fmt.Errorf("duplicate %s of instance %d", h.Name, instance)
+		}
+		*slot = h
+	}
+
+	sets := make([]arcSet, 0, len(byInstance))
+	for _, set := range byInstance {
+		sets = append(sets, *set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].instance < sets[j].instance })
+
+	for i, set := range sets {
+		if set.instance != i+1 || set.instance > arcMaxInstances {
+			return nil, fmt.Errorf("ARC instance %d out of sequence", set.instance)
+		}
+		if set.aar == nil || set.ams == nil || set.seal == nil {
+			return nil, fmt.Errorf("incomplete ARC set %d", set.instance)
+		}
+	}
+
+	return sets, nil
+}
+
+// arcInstance the i= tag of an ARC header
+func arcInstance(h *dkimHeader) (int, bool) {
+	if !strings.HasPrefix(strings.ToUpper(h.Name), "ARC-") {
+		return 0, false
+	}
+
+	instance, err := strconv.Atoi(parseDKIMTags(headerValue(h))["i"])
+	if err != nil || instance < 1 {
+		return 0, false
+	}
+	return instance, true
+}
+
+// headerValue the value of a header field, after the colon
+func headerValue(h *dkimHeader) string {
+	_, value, _ := strings.Cut(h.Raw, ":")
+	return value
+}
+
+// WriteARCSigningConfig writes the rspamd arc module configuration: the mail received
+// for local recipients and forwarded is sealed with the DKIM key of the recipient domain.
+// Rspamd reads it on its next restart
+func WriteARCSigningConfig() error {
+	content := "# Generated by BillionMail, do not edit\n" +
+		"sign_authenticated = false;\n" +
+		"sign_local = false;\n" +
+		"sign_inbound = true;\n" +
+		"use_domain_sign_inbound = \"recipient\";\n" +
+		"allow_envfrom_empty = true;\n" +
+		"use_esld = false;\n" +
+		"selector = \"" + arcSelector + "\";\n" +
+		"path = \"/var/lib/rspamd/dkim/$domain/" + arcSelector + ".private\";\n"
+
+	_, err := public.WriteFile(public.AbsPath(filepath.Join(consts.RSPAMD_LOCAL_D_PATH, "arc.conf")), content)
+	return err
+}
@@ -0,0 +1,153 @@
+package inbound
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The seal covers the sets in instance order, AAR, AMS then AS, relaxed, the b= value of
+// the last seal empty and without trailing CRLF (RFC 8617 5.1.1)
+func TestARCSealData(t *testing.T) {
+	headers, _, err := splitDKIMMessage([]byte("ARC-Seal: i=2; a=rsa-sha256; cv=pass; d=b.example; s=s;\r\n\tb=SEAL2\r\n" +
+		"ARC-Message-Signature: i=2; d=b.example; b=AMS2\r\n" +
+		"ARC-Authentication-Results: i=2; b.example; arc=pass\r\n" +
+		"ARC-Seal: i=1; a=rsa-sha256; cv=none; d=a.example; s=s; b=SEAL1\r\n" +
+		"ARC-Message-Signature: i=1; d=a.example;  b=AMS1\r\n" +
+		"ARC-Authentication-Results: i=1; a.example; spf=pass\r\n" +
+		"From: a@a.example\r\n\r\nbody\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sets, err := arcSets(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 || sets[0].instance != 1 || sets[1].instance != 2 {
+		t.Fatalf("sets not in instance order: %+v", sets)
+	}
+
+	expected := "arc-authentication-results:i=1; a.example; spf=pass\r\n" +
+		"arc-message-signature:i=1; d=a.example; b=AMS1\r\n" +
+		"arc-seal:i=1; a=rsa-sha256; cv=none; d=a.example; s=s; b=SEAL1\r\n" +
+		"arc-authentication-results:i=2; b.example; arc=pass\r\n" +
+		"arc-message-signature:i=2; d=b.example; b=AMS2\r\n" +
+		"arc-seal:i=2; a=rsa-sha256; cv=pass; d=b.example; s=s; b="
+	if got := arcSealData(sets); got != expected {
+		t.Errorf("seal data = %q", got)
+	}
+
+	// Incomplete and duplicate sets
+	if _, err = arcSets(headers[1:]); err == nil {
+		t.Error("expected an error for a set without seal")
+	}
+	if _, err = arcSets(append(headers, headers[0])); err == nil {
+		t.Error("expected an error for a duplicate seal")
+	}
+}
+
+func TestARCSeal(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]string{
+		"default._domainkey.forwarder.example": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der),
+		"ed._domainkey.list.example":           "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub),
+	}
+
+	defer func(orig func(context.Context, string) ([]string, error)) { lookupTXT = orig }(lookupTXT)
+	lookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		if record, ok := records[name]; ok {
+			return []string{record}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	defer func(orig func() time.Time) { arcNow = orig }(arcNow)
+	arcNow = func() time.Time { return time.Unix(1700000000, 0) }
+
+	ctx := context.Background()
+	message := "From: sender@origin.example\r\nTo: user@forwarder.example\r\nSubject: Hello\r\nMessage-ID: <1@origin.example>\r\n\r\nHello  world\r\n"
+
+	if cv, _ := VerifyARC(ctx, []byte(message)); cv != ARCNone {
+		t.Errorf("unsealed message: cv = %s", cv)
+	}
+
+	// First hop
+	sealed, err := arcSeal(ctx, "forwarder.example", "default", rsaKey, []byte(message), "spf=pass smtp.mailfrom=origin.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{"ARC-Seal: i=1; a=rsa-sha256; t=1700000000; cv=none;", "ARC-Message-Signature: i=1;", "ARC-Authentication-Results: i=1; forwarder.example; spf=pass"} {
+		if !strings.Contains(string(sealed), prefix) {
+			t.Errorf("sealed message lacks %q:\n%s", prefix, sealed)
+		}
+	}
+	if !strings.Contains(string(sealed), "h=From:To:Subject:Message-ID;") {
+		t.Errorf("unexpected signed headers:\n%s", sealed)
+	}
+
+	if cv, _ := VerifyARC(ctx, sealed); cv != ARCPass {
+		t.Fatalf("first hop: cv = %s", cv)
+	}
+
+	// A mailing list rewrites the subject and seals again with an ed25519 key
+	modified := strings.Replace(string(sealed), "Subject: Hello", "Subject: [list] Hello", 1)
+	if cv, _ := VerifyARC(ctx, []byte(modified)); cv != ARCFail {
+		t.Errorf("modified message: cv = %s", cv)
+	}
+
+	resealed, err := arcSeal(ctx, "list.example", "ed", edKey, []byte(strings.Replace(modified, "[list] ", "", 1)), "list.example; arc=pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resealed), "ARC-Seal: i=2; a=ed25519-sha256; t=1700000000; cv=pass;") ||
+		!strings.Contains(string(resealed), "ARC-Authentication-Results: i=2; list.example; arc=pass") {
+		t.Errorf("unexpected second set:\n%s", resealed)
+	}
+	if cv, _ := VerifyARC(ctx, resealed); cv != ARCPass {
+		t.Fatalf("second hop: cv = %s", cv)
+	}
+
+	// Whitespace changes pass relaxed canonicalization, a modified body does not
+	if cv, _ := VerifyARC(ctx, []byte(strings.Replace(string(resealed), "Hello  world", "Hello world ", 1))); cv != ARCPass {
+		t.Errorf("relaxed body change: cv = %s", cv)
+	}
+
+	tampered := []byte(strings.Replace(string(resealed), "Hello  world", "Goodbye world", 1))
+	if cv, _ := VerifyARC(ctx, tampered); cv != ARCFail {
+		t.Errorf("modified body: cv = %s", cv)
+	}
+
+	// A broken chain is sealed with cv=fail, after which it is not extended anymore
+	failed, err := arcSeal(ctx, "forwarder.example", "default", rsaKey, tampered, "arc=fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(failed), "ARC-Seal: i=3; a=rsa-sha256; t=1700000000; cv=fail;") {
+		t.Errorf("unexpected third set:\n%s", failed)
+	}
+	if _, err = arcSeal(ctx, "forwarder.example", "default", rsaKey, failed, "arc=fail"); err != errARCChainFailed {
+		t.Errorf("sealing a failed chain: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package inbound
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Disposition of an inbound message, the single place combining the authentication
+// results and the spam score into what is done with it: accepted, tagged as spam,
+// quarantined or rejected. The SPF, DKIM and DMARC failures add their penalty to the
+// spam score and the total is compared with the thresholds. A message failing all
+// three is forged, it is rejected whatever its score. The authentication failures of
+// the mail relayed by a trusted forwarder are ignored, forwarding breaks them, its
+// spam score still counts. Every decision is logged with the factors that made it.
+// Once enabled the disposition is applied to the messages scanned by rspamd through the
+// inbound policy hook, it then overrides the actions of rspamd.
+// -----------------------------
+
+const dispositionOptionKey = "inbound_disposition"
+
+// DispositionAction what is done with an inbound message
+type DispositionAction string
+
+const (
+	DispositionAccept     DispositionAction = "accept"
+	DispositionTag        DispositionAction = "tag"
+	DispositionQuarantine DispositionAction = "quarantine"
+	DispositionReject     DispositionAction = "reject"
+)
+
+// DispositionConfig thresholds and penalties of the disposition, a zero threshold or
+// penalty takes its default
+type DispositionConfig struct {
+	// Enabled applies the disposition to the inbound mail, off by default
+	Enabled bool `json:"enabled"`
+
+	TagScore        float64 `json:"tag_score"`        // tagged at or above it
+	QuarantineScore float64 `json:"quarantine_score"` // quarantined at or above it
+	RejectScore     float64 `json:"reject_score"`     // rejected at or above it
+
+	SPFFailPenalty     float64 `json:"spf_fail_penalty"`
+	SPFSoftfailPenalty float64 `json:"spf_softfail_penalty"`
+	DKIMFailPenalty    float64 `json:"dkim_fail_penalty"` // signed, no signature verified
+	DMARCFailPenalty   float64 `json:"dmarc_fail_penalty"`
+
+	// AcceptForged only scores the forged messages instead of rejecting them
+	AcceptForged bool `json:"accept_forged"`
+
+	// TrustedForwarders IPs, CIDRs or host suffixes whose authentication failures are
+	// ignored, the trusted forwarders of the DMARC enforcement when empty
+	TrustedForwarders []string `json:"trusted_forwarders"`
+}
+
+// Disposition outcome of EvaluateInboundDisposition
+type Disposition struct {
+	Action  DispositionAction `json:"action"`
+	Score   float64           `json:"score"`   // spam score with the penalties
+	Factors []string          `json:"factors"` // what contributed to the decision
+}
+
+// DefaultDispositionConfig tags at the add header score of rspamd, a single failure never
+// quarantines a message scored clean
+func DefaultDispositionConfig() DispositionConfig {
+	return DispositionConfig{
+		TagScore:        6,
+		QuarantineScore: 10,
+		RejectScore:     15,
+
+		SPFFailPenalty:     2,
+		SPFSoftfailPenalty: 1,
+		DKIMFailPenalty:    2,
+		DMARCFailPenalty:   4,
+	}
+}
+
+// GetDispositionConfig returns the configured settings, the defaults for what is unset
+func GetDispositionConfig(ctx context.Context) DispositionConfig {
+	cfg := DispositionConfig{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, dispositionOptionKey, &cfg)
+	cfg = cfg.withDefaults()
+
+	if len(cfg.TrustedForwarders) == 0 {
+		cfg.TrustedForwarders = GetDMARCEnforcementConfig(ctx).TrustedForwarders
+	}
+
+	return cfg
+}
+
+// SetDispositionConfig validates and saves the settings, rspamd is restarted when the
+// disposition is enabled or disabled
+func SetDispositionConfig(ctx context.Context, cfg DispositionConfig) error {
+	if cfg.TagScore < 0 || cfg.QuarantineScore < 0 || cfg.RejectScore < 0 ||
+		cfg.SPFFailPenalty < 0 || cfg.SPFSoftfailPenalty < 0 || cfg.DKIMFailPenalty < 0 || cfg.DMARCFailPenalty < 0 {
+		return fmt.Errorf("negative disposition threshold or penalty")
+	}
+
+	if c := cfg.withDefaults(); c.TagScore > c.QuarantineScore || c.QuarantineScore > c.RejectScore {
+		return fmt.Errorf("disposition thresholds must increase from tag %g to quarantine %g to reject %g",
+			c.TagScore, c.QuarantineScore, c.RejectScore)
+	}
+
+	for _, f := range cfg.TrustedForwarders {
+		if strings.TrimSpace(f) == "" {
+			return fmt.Errorf("empty trusted forwarder entry")
+		}
+	}
+
+	enabled := GetDispositionConfig(ctx).Enabled
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, dispositionOptionKey, cfg); err != nil {
+		return err
+	}
+
+	if cfg.Enabled == enabled {
+		return nil
+	}
+
+	if err := WriteInboundPolicyConfig(ctx); err != nil {
+		return err
+	}
+
+	return restartRspamd(ctx)
+}
+
+// withDefaults fills the thresholds and penalties left unset
+func (c DispositionConfig) withDefaults() DispositionConfig {
+	def := DefaultDispositionConfig()
+
+	for _, v := range []struct{ value, def *float64 }{
+		{&c.TagScore, &def.TagScore},
+		{&c.QuarantineScore, &def.QuarantineScore},
+		{&c.RejectScore, &def.RejectScore},
+		{&c.SPFFailPenalty, &def.SPFFailPenalty},
+		{&c.SPFSoftfailPenalty, &def.SPFSoftfailPenalty},
+		{&c.DKIMFailPenalty, &def.DKIMFailPenalty},
+		{&c.DMARCFailPenalty, &def.DMARCFailPenalty},
+	} {
+		if *v.value == 0 {
+			*v.value = *v.def
+		}
+	}
+
+	return c
+}
+
+// EvaluateInboundDisposition decides what is done with an inbound message. spf is the
+// SPF result, dkim the verified signatures, dmarc the alignment of the message, unset
+// when DMARC was not evaluated, and spamScore the score of rspamd, nil when the message
+// was not scanned
+func EvaluateInboundDisposition(ctx context.Context, spf string, dkim []DKIMResult, dmarc DMARCResult, spamScore *float64) Disposition {
+	d := GetDispositionConfig(ctx).evaluate(spf, dkim, dmarc, spamScore)
+
+	g.Log().Infof(ctx, "Inbound disposition of the mail from %s (%s): %s, score %.2f, %s",
+		dmarc.HeaderFrom, dmarc.SourceIP, d.Action, d.Score, strings.Join(d.Factors, "; "))
+
+	return d
+}
+
+// evaluate is the pure decision part of EvaluateInboundDisposition
+func (c DispositionConfig) evaluate(spf string, dkim []DKIMResult, dmarc DMARCResult, spamScore *float64) Disposition {
+	d := Disposition{Action: DispositionAccept, Factors: make([]string, 0)}
+
+	if spamScore != nil {
+		d.Score = *spamScore
+		d.Factors = append(d.Factors, fmt.Sprintf("spam score %.2f", *spamScore))
+	} else {
+		d.Factors = append(d.Factors, "not scanned")
+	}
+
+	spf = strings.ToLower(strings.TrimSpace(spf))
+	spfFail := spf == "fail"
+	dkimFail := dkimFailed(dkim)
+	dmarcFail := dmarc.HeaderFrom != "" && !dmarc.Pass()
+
+	if (spfFail || spf == "softfail" || dkimFail || dmarcFail) && isTrustedForwarder(c.TrustedForwarders, dmarc) {
+		d.Factors = append(d.Factors, "authentication failures ignored, sent by trusted forwarder")
+		return c.decide(d)
+	}
+
+	penalize := func(failed bool, penalty float64, factor string) {
+		if failed {
+			d.Score += penalty
+			d.Factors = append(d.Factors, fmt.Sprintf("%s +%g", factor, penalty))
+		}
+	}
+	penalize(spfFail, c.SPFFailPenalty, "spf fail")
+	penalize(spf == "softfail", c.SPFSoftfailPenalty, "spf softfail")
+	penalize(dkimFail, c.DKIMFailPenalty, "dkim fail")
+	penalize(dmarcFail, c.DMARCFailPenalty, "dmarc fail")
+
+	if spfFail && dkimFail && dmarcFail {
+		if !c.AcceptForged {
+			d.Action = DispositionReject
+			d.Factors = append(d.Factors, "forged: spf, dkim and dmarc fail")
+			return d
+		}
+		d.Factors = append(d.Factors, "forged, scored only")
+	}
+
+	return c.decide(d)
+}
+
+// decide the action of the score
+func (c DispositionConfig) decide(d Disposition) Disposition {
+	switch {
+	case d.Score >= c.RejectScore:
+		d.Action = DispositionReject
+		d.Factors = append(d.Factors, fmt.Sprintf("score at or above the reject threshold %g", c.RejectScore))
+	case d.Score >= c.QuarantineScore:
+		d.Action = DispositionQuarantine
+		d.Factors = append(d.Factors, fmt.Sprintf("score at or above the quarantine threshold %g", c.QuarantineScore))
+	case d.Score >= c.TagScore:
+		d.Action = DispositionTag
+		d.Factors = append(d.Factors, fmt.Sprintf("score at or above the tag threshold %g", c.TagScore))
+	default:
+		d.Action = DispositionAccept
+	}
+
+	return d
+}
+
+// dkimFailed reports a signed message none of whose signatures verified and one at least
+// failed, an unsigned message or a temporary error is not a failure
+func dkimFailed(results []DKIMResult) bool {
+	failed := false
+	for _, r := range results {
+		switch strings.ToLower(r.Result) {
+		case "pass":
+			return false
+		case "fail", "permerror":
+			failed = true
+		}
+	}
+	return failed
+}
@@ -0,0 +1,61 @@
+package inbound
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDispositionEvaluate(t *testing.T) {
+	cfg := DefaultDispositionConfig()
+	cfg.TrustedForwarders = []string{"192.0.2.0/24"}
+
+	score := func(s float64) *float64 { return &s }
+	pass := []DKIMResult{{Domain: "example.com", Result: "pass"}}
+	fail := []DKIMResult{{Domain: "example.com", Result: "fail"}}
+	aligned := DMARCResult{HeaderFrom: "example.com", SourceIP: "198.51.100.1", SPFAligned: true, DKIMAligned: true}
+	unaligned := DMARCResult{HeaderFrom: "example.com", SourceIP: "198.51.100.1"}
+	forwarded := DMARCResult{HeaderFrom: "example.com", SourceIP: "192.0.2.10"}
+
+	for _, tc := range []struct {
+		name   string
+		spf    string
+		dkim   []DKIMResult
+		dmarc  DMARCResult
+		score  *float64
+		action DispositionAction
+		factor string
+	}{
+		{"clean", "pass", pass, aligned, score(1), DispositionAccept, "spam score 1.00"},
+		{"not scanned", "pass", nil, DMARCResult{}, nil, DispositionAccept, "not scanned"},
+		{"temporary dkim error", "pass", []DKIMResult{{Result: "temperror"}}, aligned, score(5), DispositionAccept, ""},
+		{"spammy", "pass", pass, aligned, score(7), DispositionTag, "tag threshold"},
+		{"softfail tips it", "softfail", pass, aligned, score(5.5), DispositionTag, "spf softfail +1"},
+		{"dmarc fail", "fail", pass, unaligned, score(4), DispositionQuarantine, "dmarc fail +4"},
+		{"very spammy", "pass", pass, aligned, score(20), DispositionReject, "reject threshold"},
+		{"forged", "fail", fail, unaligned, score(0), DispositionReject, "forged"},
+		{"trusted forwarder", "fail", fail, forwarded, score(1), DispositionAccept, "trusted forwarder"},
+		{"trusted forwarder spam", "fail", fail, forwarded, score(11), DispositionQuarantine, "trusted forwarder"},
+	} {
+		d := cfg.evaluate(tc.spf, tc.dkim, tc.dmarc, tc.score)
+		if d.Action != tc.action {
+			t.Errorf("%s: %s, want %s: %v", tc.name, d.Action, tc.action, d.Factors)
+		}
+		if tc.factor != "" && !strings.Contains(strings.Join(d.Factors, "; "), tc.factor) {
+			t.Errorf("%s: factors %v, want %q", tc.name, d.Factors, tc.factor)
+		}
+	}
+
+	// Forged mail only scored on request
+	cfg.AcceptForged = true
+	if d := cfg.evaluate("fail", fail, unaligned, score(0)); d.Action != DispositionTag || d.Score != 8 {
+		t.Errorf("forged accepted: %s, score %g: %v", d.Action, d.Score, d.Factors)
+	}
+}
+
+func TestDispositionConfigDefaults(t *testing.T) {
+	cfg := DispositionConfig{QuarantineScore: 12}.withDefaults()
+	def := DefaultDispositionConfig()
+	if cfg.QuarantineScore != 12 || cfg.TagScore != def.TagScore || cfg.DMARCFailPenalty != def.DMARCFailPenalty {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+}
@@ -0,0 +1,632 @@
+package inbound
+
+import (
+	"billionmail-core/internal/service/dns_resolver"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// DKIM verification of inbound mail (RFC 6376, ed25519 keys of RFC 8463).
+// Every DKIM-Signature header of a message gets its own result, the results are
+// stored by Message-ID so they can be inspected later.
+// -----------------------------
+
+// DKIM results, named like the dkim= values of Authentication-Results (RFC 8601)
+const (
+	DKIMPass      = "pass"
+	DKIMFail      = "fail"
+	DKIMNeutral   = "neutral"   // the signature could not be evaluated, e.g. an unsupported algorithm
+	DKIMTempError = "temperror" // the key could not be retrieved, a later attempt may succeed
+	DKIMPermError = "permerror" // the signature is malformed
+)
+
+const (
+	maxDKIMSignatures = 10
+	minDKIMRSABits    = 1024 // RFC 8301
+)
+
+// lookupTXT resolves the DKIM key records through the shared cache, replaceable in tests
+var lookupTXT = func(ctx context.Context, name string) ([]string, error) {
+	return dns_resolver.Default().LookupTXT(ctx, name)
+}
+
+// DKIMResult verification result of one DKIM-Signature header
+type DKIMResult struct {
+	Domain    string `json:"domain"`   // d= tag
+	Selector  string `json:"selector"` // s= tag
+	Algorithm string `json:"algorithm"`
+	Result    string `json:"result"` // pass, fail, neutral, temperror or permerror
+	Reason    string `json:"reason"`
+}
+
+// dkimHeader one header field of the message, Raw includes the folding and the trailing CRLF
+type dkimHeader struct {
+	Name string
+	Raw  string
+}
+
+// dkimSignature parsed DKIM-Signature header
+type dkimSignature struct {
+	header    dkimHeader
+	tags      map[string]string
+	domain    string
+	selector  string
+	algorithm string
+	headers   []string
+	bodyHash  []byte
+	signature []byte
+	headerC   string
+	bodyC     string
+	length    int64 // -1 without l=
+}
+
+// VerifyDKIM verifies every DKIM-Signature of the raw message. A message without
+// signatures has no results, the error is only set when the message cannot be parsed.
+func VerifyDKIM(ctx context.Context, raw []byte) ([]DKIMResult, error) {
+	headers, body, err := splitDKIMMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DKIMResult, 0)
+
+	for _, h := range headers {
+		if !strings.EqualFold(h.Name, "DKIM-Signature") {
+			continue
+		}
+
+		if len(results) == maxDKIMSignatures {
+			g.Log().Warningf(ctx, "Message carries more than %d DKIM signatures, the rest is ignored", maxDKIMSignatures)
+			break
+		}
+
+		results = append(results, verifyDKIMSignature(ctx, h, headers, body))
+	}
+
+	return results, nil
+}
+
+// splitDKIMMessage splits the message into its header fields and body, line ends are
+// normalized to CRLF first
+func splitDKIMMessage(raw []byte) ([]dkimHeader, []byte, error) {
+	msg := bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	msg = bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+
+	var head, body []byte
+	if bytes.HasPrefix(msg, []byte("\r\n")) {
+		body = msg[2:]
+	} else if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		head, body = msg[:i+2], msg[i+4:]
+	} else {
+		head = msg
+	}
+
+	var headers []dkimHeader
+	for _, line := range strings.SplitAfter(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		// Continuation of a folded header
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(headers) == 0 {
+				return nil, nil, errors.New("message starts with a folded header line")
+			}
+			headers[len(headers)-1].Raw += line
+			continue
+		}
+
+		name, _, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed header line %q", strings.TrimSpace(line))
+		}
+		headers = append(headers, dkimHeader{Name: strings.TrimRight(name, " \t"), Raw: line})
+	}
+
+	if len(headers) == 0 {
+		return nil, nil, errors.New("message has no headers")
+	}
+
+	return headers, body, nil
+}
+
+// verifyDKIMSignature verifies one signature of the message
+func verifyDKIMSignature(ctx context.Context, h dkimHeader, headers []dkimHeader, body []byte) DKIMResult {
+	sig, err := parseDKIMSignature(h)
+	result := DKIMResult{}
+	if sig != nil {
+		result.Domain, result.Selector, result.Algorithm = sig.domain, sig.selector, sig.algorithm
+	}
+
+	if err != nil {
+		result.Result, result.Reason = DKIMPermError, err.Error()
+		return result
+	}
+
+	keyAlgorithm, hashName, _ := strings.Cut(sig.algorithm, "-")
+
+	var newHash func() hash.Hash
+	var cryptoHash crypto.Hash
+	switch hashName {
+	case "sha256":
+		newHash, cryptoHash = sha256.New, crypto.SHA256
+	case "sha1":
+		newHash, cryptoHash = sha1.New, crypto.SHA1
+	default:
+		result.Result, result.Reason = DKIMNeutral, "unsupported algorithm "+sig.algorithm
+		return result
+	}
+	if keyAlgorithm != "rsa" && keyAlgorithm != "ed25519" {
+		result.Result, result.Reason = DKIMNeutral, "unsupported algorithm "+sig.algorithm
+		return result
+	}
+
+	if x, ok := sig.tags["x"]; ok {
+		if expires, err := strconv.ParseInt(x, 10, 64); err == nil && time.Now().Unix() > expires {
+			result.Result, result.Reason = DKIMFail, "signature expired"
+			return result
+		}
+	}
+
+	key, err := lookupDKIMKey(ctx, sig, keyAlgorithm, hashName)
+	if err != nil {
+		var dkimErr *dkimKeyError
+		if errors.As(err, &dkimErr) {
+			result.Result = dkimErr.result
+		} else {
+			result.Result = DKIMTempError
+		}
+		result.Reason = err.Error()
+		return result
+	}
+
+	// Body hash first, it tells a modified body apart from a bad signature
+	canonBody := canonicalizeDKIMBody(body, sig.bodyC)
+	if sig.length >= 0 {
+		if sig.length > int64(len(canonBody)) {
+			result.Result, result.Reason = DKIMFail, "body is shorter than the l= length"
+			return result
+		}
+		canonBody = canonBody[:sig.length]
+	}
+
+	bh := newHash()
+	bh.Write(canonBody)
+	if !bytes.Equal(bh.Sum(nil), sig.bodyHash) {
+		result.Result, result.Reason = DKIMFail, "body hash did not verify"
+		return result
+	}
+
+	hh := newHash()
+	hh.Write([]byte(dkimSignedHeaders(sig, headers)))
+	digest := hh.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, cryptoHash, digest, sig.signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, sig.signature) {
+			err = errors.New("ed25519 verification failed")
+		}
+	}
+
+	if err != nil {
+		result.Result, result.Reason = DKIMFail, "signature did not verify"
+		return result
+	}
+
+	result.Result, result.Reason = DKIMPass, "signature verified"
+	return result
+}
+
+// parseDKIMSignature parses and checks the tags of a DKIM-Signature header. The
+// returned signature carries the domain and selector as far as they could be read,
+// even along with an error
+func parseDKIMSignature(h dkimHeader) (*dkimSignature, error) {
+	_, value, _ := strings.Cut(h.Raw, ":")
+
+	sig := &dkimSignature{header: h, tags: parseDKIMTags(value), length: -1}
+	tags := sig.tags
+
+	sig.domain = strings.ToLower(tags["d"])
+	sig.selector = tags["s"]
+	sig.algorithm = strings.ToLower(tags["a"])
+
+	for _, required := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if tags[required] == "" {
+			return sig, fmt.Errorf("missing %s= tag", required)
+		}
+	}
+
+	if tags["v"] != "1" {
+		return sig, fmt.Errorf("unsupported version %s", tags["v"])
+	}
+
+	var err error
+	if sig.signature, err = base64.StdEncoding.DecodeString(tags["b"]); err != nil {
+		return sig, errors.New("malformed b= tag")
+	}
+	if sig.bodyHash, err = base64.StdEncoding.DecodeString(tags["bh"]); err != nil {
+		return sig, errors.New("malformed bh= tag")
+	}
+
+	hasFrom := false
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		sig.headers = append(sig.headers, name)
+		hasFrom = hasFrom || strings.EqualFold(name, "From")
+	}
+	if !hasFrom {
+		return sig, errors.New("From is not signed")
+	}
+
+	sig.headerC, sig.bodyC = "simple", "simple"
+	if c, ok := tags["c"]; ok {
+		headerC, bodyC, hasBody := strings.Cut(strings.ToLower(c), "/")
+		sig.headerC = headerC
+		if hasBody {
+			sig.bodyC = bodyC
+		}
+	}
+	for _, c := range []string{sig.headerC, sig.bodyC} {
+		if c != "simple" && c != "relaxed" {
+			return sig, fmt.Errorf("unknown canonicalization %s", c)
+		}
+	}
+
+	if l, ok := tags["l"]; ok {
+		if sig.length, err = strconv.ParseInt(l, 10, 64); err != nil || sig.length < 0 {
+			return sig, errors.New("malformed l= tag")
+		}
+	}
+
+	// The identity must be the signing domain or one of its subdomains
+	if i, ok := tags["i"]; ok {
+		at := strings.LastIndex(i, "@")
+		if at < 0 {
+			return sig, errors.New("malformed i= tag")
+		}
+		identity := strings.ToLower(i[at+1:])
+		if identity != sig.domain && !strings.HasSuffix(identity, "."+sig.domain) {
+			return sig, errors.New("i= domain is not within d= domain")
+		}
+	}
+
+	return sig, nil
+}
+
+// parseDKIMTags parses a tag=value list, the whitespace inside the values is removed
+// as the base64 values may be folded
+func parseDKIMTags(s string) map[string]string {
+	tags := make(map[string]string)
+
+	for _, part := range strings.Split(s, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+
+		k = strings.TrimSpace(k)
+		if _, exists := tags[k]; exists || k == "" {
+			continue
+		}
+
+		tags[k] = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, v)
+	}
+
+	return tags
+}
+
+// dkimKeyError failure to obtain a usable key, result is the DKIM result it leads to
+type dkimKeyError struct {
+	result string
+	msg    string
+}
+
+func (e *dkimKeyError) Error() string { return e.msg }
+
+// lookupDKIMKey retrieves the public key of the signature from <selector>._domainkey.<domain>
+func lookupDKIMKey(ctx context.Context, sig *dkimSignature, keyAlgorithm, hashName string) (crypto.PublicKey, error) {
+	name := sig.selector + "._domainkey." + sig.domain
+
+	records, err := lookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, &dkimKeyError{DKIMTempError, "no key record at " + name}
+		}
+		return nil, &dkimKeyError{DKIMTempError, fmt.Sprintf("key lookup of %s failed: %v", name, err)}
+	}
+
+	var lastErr error = &dkimKeyError{DKIMTempError, "no key record at " + name}
+
+	for _, record := range records {
+		key, err := parseDKIMKey(record, keyAlgorithm, hashName)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// parseDKIMKey parses a v=DKIM1 key record for the algorithm of the signature
+func parseDKIMKey(record, keyAlgorithm, hashName string) (crypto.PublicKey, error) {
+	tags := parseDKIMTags(record)
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, &dkimKeyError{DKIMPermError, "not a DKIM1 key record"}
+	}
+
+	k := "rsa"
+	if v, ok := tags["k"]; ok {
+		k = strings.ToLower(v)
+	}
+	if k != keyAlgorithm {
+		return nil, &dkimKeyError{DKIMPermError, fmt.Sprintf("key type %s does not match the signature", k)}
+	}
+
+	if h, ok := tags["h"]; ok {
+		accepted := false
+		for _, name := range strings.Split(h, ":") {
+			accepted = accepted || strings.EqualFold(name, hashName)
+		}
+		if !accepted {
+			return nil, &dkimKeyError{DKIMPermError, "hash algorithm not accepted by the key"}
+		}
+	}
+
+	p, ok := tags["p"]
+	if !ok {
+		return nil, &dkimKeyError{DKIMPermError, "key record without p= tag"}
+	}
+	if p == "" {
+		return nil, &dkimKeyError{DKIMFail, "key revoked"}
+	}
+
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, &dkimKeyError{DKIMPermError, "malformed key data"}
+	}
+
+	if k == "ed25519" {
+		if len(der) != ed25519.PublicKeySize {
+			return nil, &dkimKeyError{DKIMPermError, "malformed ed25519 key"}
+		}
+		return ed25519.PublicKey(der), nil
+	}
+
+	var pub *rsa.PublicKey
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		pub, _ = key.(*rsa.PublicKey)
+	} else if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		pub = key
+	}
+	if pub == nil {
+		return nil, &dkimKeyError{DKIMPermError, "malformed rsa key"}
+	}
+	if pub.N.BitLen() < minDKIMRSABits {
+		return nil, &dkimKeyError{DKIMPermError, fmt.Sprintf("rsa key of %d bits is too short", pub.N.BitLen())}
+	}
+
+	return pub, nil
+}
+
+// dkimSignedHeaders the canonicalized header data the signature covers: the signed
+// headers picked bottom up, then the DKIM-Signature itself with an empty b= value
+func dkimSignedHeaders(sig *dkimSignature, headers []dkimHeader) string {
+	used := make(map[int]bool)
+	var buf strings.Builder
+
+	for _, name := range sig.headers {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(headers[i].Name, name) {
+				continue
+			}
+			used[i] = true
+			buf.WriteString(canonicalizeDKIMHeader(headers[i].Raw, sig.headerC))
+			break
+		}
+	}
+
+	self := canonicalizeDKIMHeader(stripDKIMSignatureValue(sig.header.Raw), sig.headerC)
+	buf.WriteString(strings.TrimSuffix(self, "\r\n"))
+
+	return buf.String()
+}
+
+// stripDKIMSignatureValue empties the b= tag of a DKIM-Signature header, leaving the rest untouched
+func stripDKIMSignatureValue(raw string) string {
+	colon := strings.Index(raw, ":")
+	parts := strings.Split(raw[colon+1:], ";")
+
+	for i, part := range parts {
+		k, _, ok := strings.Cut(part, "=")
+		if ok && strings.TrimSpace(k) == "b" {
+			parts[i] = part[:strings.Index(part, "=")+1]
+			if strings.HasSuffix(part, "\r\n") && i == len(parts)-1 {
+				parts[i] += "\r\n"
+			}
+		}
+	}
+
+	return raw[:colon+1] + strings.Join(parts, ";")
+}
+
+// canonicalizeDKIMHeader one header field in the simple or relaxed form
+func canonicalizeDKIMHeader(raw, c string) string {
+	if c == "simple" {
+		return raw
+	}
+
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = collapseDKIMWhitespace(value)
+
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + strings.Trim(value, " ") + "\r\n"
+}
+
+// canonicalizeDKIMBody the body in the simple or relaxed form
+func canonicalizeDKIMBody(body []byte, c string) []byte {
+	lines := strings.SplitAfter(string(body), "\r\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if c == "relaxed" {
+			line = collapseDKIMWhitespace(strings.TrimSuffix(line, "\r\n"))
+			line = strings.TrimRight(line, " ") + "\r\n"
+		} else if !strings.HasSuffix(line, "\r\n") {
+			line += "\r\n"
+		}
+		buf.WriteString(line)
+	}
+
+	// Trailing empty lines are ignored
+	out := buf.Bytes()
+	for bytes.HasSuffix(out, []byte("\r\n\r\n")) {
+		out = out[:len(out)-2]
+	}
+
+	if len(out) == 0 || bytes.Equal(out, []byte("\r\n")) {
+		// An empty body is a single CRLF in simple and nothing in relaxed
+		if c == "simple" {
+			return []byte("\r\n")
+		}
+		return []byte{}
+	}
+
+	return out
+}
+
+// collapseDKIMWhitespace reduces every run of spaces and tabs to a single space
+func collapseDKIMWhitespace(s string) string {
+	var buf strings.Builder
+	space := false
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			buf.WriteByte(' ')
+			space = false
+		}
+		buf.WriteByte(s[i])
+	}
+	if space {
+		buf.WriteByte(' ')
+	}
+
+	return buf.String()
+}
+
+// normalizeMessageId the Message-ID without its angle brackets
+func normalizeMessageId(messageId string) string {
+	return strings.Trim(strings.TrimSpace(messageId), "<>")
+}
+
+// VerifyAndRecordDKIM verifies the DKIM signatures of the raw message and stores the
+// results under its Message-ID
+func VerifyAndRecordDKIM(ctx context.Context, raw []byte) ([]DKIMResult, error) {
+	results, err := VerifyDKIM(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, _, _ := splitDKIMMessage(raw)
+
+	messageId := ""
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, "Message-ID") {
+			_, value, _ := strings.Cut(h.Raw, ":")
+			messageId = strings.ReplaceAll(value, "\r\n", "")
+			break
+		}
+	}
+
+	if normalizeMessageId(messageId) == "" {
+		return results, errors.New("message has no Message-ID, DKIM results not stored")
+	}
+
+	return results, RecordDKIMResults(ctx, messageId, results)
+}
+
+// RecordDKIMResults stores the DKIM results of a message, replacing those of an earlier verification
+func RecordDKIMResults(ctx context.Context, messageId string, results []DKIMResult) error {
+	messageId = normalizeMessageId(messageId)
+	if messageId == "" {
+		return errors.New("empty message id")
+	}
+
+	now := time.Now().Unix()
+
+	return g.DB().Transaction(ctx, func(ctx context.Context, tx gdb.TX) error {
+		if _, err := tx.Model("bm_inbound_dkim_results").Ctx(ctx).Where("message_id", messageId).Delete(); err != nil {
+			return err
+		}
+
+		if len(results) == 0 {
+			return nil
+		}
+
+		rows := make(g.List, 0, len(results))
+		for _, r := range results {
+			rows = append(rows, g.Map{
+				"message_id":  messageId,
+				"domain":      r.Domain,
+				"selector":    r.Selector,
+				"algorithm":   r.Algorithm,
+				"result":      r.Result,
+				"reason":      r.Reason,
+				"create_time": now,
+			})
+		}
+
+		_, err := tx.Model("bm_inbound_dkim_results").Ctx(ctx).Insert(rows)
+		return err
+	})
+}
+
+// GetDKIMResults the stored DKIM results of a message, in signature order
+func GetDKIMResults(ctx context.Context, messageId string) ([]DKIMResult, error) {
+	results := make([]DKIMResult, 0)
+
+	err := g.DB().Model("bm_inbound_dkim_results").Ctx(ctx).
+		Fields("domain, selector, algorithm, result, reason").
+		Where("message_id", normalizeMessageId(messageId)).
+		OrderAsc("id").
+		Scan(&results)
+
+	return results, err
+}
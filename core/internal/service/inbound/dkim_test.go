@@ -0,0 +1,173 @@
+package inbound
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Example of RFC 6376 3.4.5
+func TestDKIMCanonicalization(t *testing.T) {
+	headers, body, err := splitDKIMMessage([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var relaxed, simple string
+	for _, h := range headers {
+		relaxed += canonicalizeDKIMHeader(h.Raw, "relaxed")
+		simple += canonicalizeDKIMHeader(h.Raw, "simple")
+	}
+
+	if relaxed != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("relaxed headers = %q", relaxed)
+	}
+	if simple != "A: X\r\nB : Y\t\r\n\tZ  \r\n" {
+		t.Errorf("simple headers = %q", simple)
+	}
+
+	if got := string(canonicalizeDKIMBody(body, "relaxed")); got != " C\r\nD E\r\n" {
+		t.Errorf("relaxed body = %q", got)
+	}
+	if got := string(canonicalizeDKIMBody(body, "simple")); got != " C \r\nD \t E\r\n" {
+		t.Errorf("simple body = %q", got)
+	}
+
+	if got := string(canonicalizeDKIMBody(nil, "simple")); got != "\r\n" {
+		t.Errorf("empty simple body = %q", got)
+	}
+	if got := string(canonicalizeDKIMBody(nil, "relaxed")); got != "" {
+		t.Errorf("empty relaxed body = %q", got)
+	}
+}
+
+// signDKIM adds a relaxed/relaxed DKIM-Signature to the message
+func signDKIM(t *testing.T, message, domain, selector, algorithm string, key crypto.Signer) string {
+	t.Helper()
+
+	headers, body, err := splitDKIMMessage([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bh := sha256.Sum256(canonicalizeDKIMBody(body, "relaxed"))
+	raw := "DKIM-Signature: v=1; a=" + algorithm + "; c=relaxed/relaxed; d=" + domain + "; s=" + selector +
+		";\r\n\th=From:Subject; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b=\r\n"
+
+	sig := &dkimSignature{
+		header:  dkimHeader{Name: "DKIM-Signature", Raw: raw},
+		headers: []string{"From", "Subject"},
+		headerC: "relaxed",
+	}
+	digest := sha256.Sum256([]byte(dkimSignedHeaders(sig, headers)))
+
+	var signature []byte
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		signature, err = key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	} else {
+		signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw = strings.TrimSuffix(raw, "\r\n") + base64.StdEncoding.EncodeToString(signature) + "\r\n"
+	return raw + message
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]string{
+		"rsa._domainkey.example.com": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der),
+		"ed._domainkey.example.org":  "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub),
+		"old._domainkey.example.com": "v=DKIM1; p=",
+	}
+
+	defer func(orig func(context.Context, string) ([]string, error)) { lookupTXT = orig }(lookupTXT)
+	lookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		if record, ok := records[name]; ok {
+			return []string{record}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	message := "From: sender@example.com\r\nSubject: Hello\r\nMessage-ID: <1@example.com>\r\n\r\nHello  world\r\n"
+
+	// Two signatures, the outer one from a forwarder with an ed25519 key
+	signed := signDKIM(t, message, "example.com", "rsa", "rsa-sha256", rsaKey)
+	signed = signDKIM(t, signed, "example.org", "ed", "ed25519-sha256", edKey)
+
+	results, err := VerifyDKIM(context.Background(), []byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Result != DKIMPass {
+			t.Errorf("%s/%s: %s (%s), expected pass", r.Domain, r.Selector, r.Result, r.Reason)
+		}
+	}
+	if results[0].Domain != "example.org" || results[1].Selector != "rsa" {
+		t.Errorf("results not in signature order: %+v", results)
+	}
+
+	// Whitespace changes pass relaxed canonicalization, content changes do not
+	results, _ = VerifyDKIM(context.Background(), []byte(strings.Replace(signed, "Hello  world", "Hello world ", 1)))
+	if results[0].Result != DKIMPass || results[1].Result != DKIMPass {
+		t.Errorf("relaxed body change: %+v", results)
+	}
+
+	results, _ = VerifyDKIM(context.Background(), []byte(strings.Replace(signed, "Hello  world", "Goodbye world", 1)))
+	if results[1].Result != DKIMFail || results[1].Reason != "body hash did not verify" {
+		t.Errorf("modified body: %+v", results[1])
+	}
+
+	results, _ = VerifyDKIM(context.Background(), []byte(strings.Replace(signed, "Subject: Hello", "Subject: Hi", 1)))
+	if results[1].Result != DKIMFail || results[1].Reason != "signature did not verify" {
+		t.Errorf("modified subject: %+v", results[1])
+	}
+
+	// Missing and revoked keys
+	missing := signDKIM(t, message, "example.com", "gone", "rsa-sha256", rsaKey)
+	if results, _ = VerifyDKIM(context.Background(), []byte(missing)); results[0].Result != DKIMTempError {
+		t.Errorf("missing key: %+v", results[0])
+	}
+
+	revoked := signDKIM(t, message, "example.com", "old", "rsa-sha256", rsaKey)
+	if results, _ = VerifyDKIM(context.Background(), []byte(revoked)); results[0].Result != DKIMFail {
+		t.Errorf("revoked key: %+v", results[0])
+	}
+
+	// Malformed signature
+	malformed := "DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=rsa; h=Subject; bh=; b=\r\n" + message
+	if results, _ = VerifyDKIM(context.Background(), []byte(malformed)); results[0].Result != DKIMPermError {
+		t.Errorf("malformed signature: %+v", results[0])
+	}
+
+	if results, _ = VerifyDKIM(context.Background(), []byte(message)); len(results) != 0 {
+		t.Errorf("unsigned message: %+v", results)
+	}
+}
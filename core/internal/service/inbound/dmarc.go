@@ -0,0 +1,363 @@
+package inbound
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	mRand "math/rand"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// DMARC policy enforcement for inbound mail.
+// Enforcement is opt-in: by default the decision is only recorded ("report only")
+// and the message is always accepted. The mode is set per recipient domain, the
+// domains without one follow the global mode. Trusted forwarders bypass enforcement so
+// legitimately forwarded mail whose alignment got broken is not rejected.
+// The decisions are taken by the policy service for the messages scanned by rspamd,
+// see the inbound policy hook.
+// -----------------------------
+
+const dmarcPolicyOptionKey = "inbound_dmarc_policy"
+
+// DMARC actions
+type DMARCAction string
+
+const (
+	DMARCActionAccept     DMARCAction = "accept"
+	DMARCActionQuarantine DMARCAction = "quarantine"
+	DMARCActionReject     DMARCAction = "reject"
+)
+
+// DMARC enforcement modes
+const (
+	DMARCModeReport  = "report"
+	DMARCModeEnforce = "enforce"
+)
+
+// DMARCResult alignment result of an inbound message
+type DMARCResult struct {
+	HeaderFrom      string `json:"header_from"` // RFC5322.From domain
+	SourceIP        string `json:"source_ip"`
+	SourceHost      string `json:"source_host"`      // Reverse DNS of the connecting client, optional
+	RecipientDomain string `json:"recipient_domain"` // Local domain the message is delivered to, selects the mode
+	SPFAligned      bool   `json:"spf_aligned"`
+	DKIMAligned     bool   `json:"dkim_aligned"`
+}
+
+// Pass reports whether the message passed DMARC
+func (r DMARCResult) Pass() bool {
+	return r.SPFAligned || r.DKIMAligned
+}
+
+// DMARCPolicy policy published by the sending domain
+type DMARCPolicy struct {
+	Policy          string `json:"p"`   // none, quarantine, reject
+	SubdomainPolicy string `json:"sp"`  // policy for subdomains
+	Percent         int    `json:"pct"` // 0-100, default 100
+	IsSubdomain     bool   `json:"-"`   // the From domain is a subdomain of the policy domain
+}
+
+// DMARCEnforcementConfig operator settings
+type DMARCEnforcementConfig struct {
+	Mode              string            `json:"mode"`               // report or enforce, default report
+	DomainModes       map[string]string `json:"domain_modes"`       // mode by recipient domain, Mode when unset
+	TrustedForwarders []string          `json:"trusted_forwarders"` // IPs, CIDRs or host suffixes that are never enforced
+}
+
+// enforced reports whether the policy is enforced for a recipient domain at least
+func (c DMARCEnforcementConfig) enforced() bool {
+	if c.Mode == DMARCModeEnforce {
+		return true
+	}
+	for _, mode := range c.DomainModes {
+		if mode == DMARCModeEnforce {
+			return true
+		}
+	}
+	return false
+}
+
+// ModeOf the mode of a recipient domain
+func (c DMARCEnforcementConfig) ModeOf(domain string) string {
+	if mode, ok := c.DomainModes[strings.ToLower(strings.TrimSuffix(domain, "."))]; ok {
+		return mode
+	}
+	return c.Mode
+}
+
+// DMARCDecision outcome of ApplyDMARCPolicy
+type DMARCDecision struct {
+	Action    DMARCAction `json:"action"`    // action that should be taken
+	Published string      `json:"published"` // policy as published by the domain
+	Enforced  bool        `json:"enforced"`  // whether Action was derived from the policy
+	Reason    string      `json:"reason"`
+}
+
+// ParseDMARCRecord parses a "v=DMARC1; p=...;" TXT record
+func ParseDMARCRecord(txt string) (policy DMARCPolicy, err error) {
+	policy.Percent = 100
+
+	tags := strings.Split(txt, ";")
+	if len(tags) == 0 || !strings.EqualFold(strings.TrimSpace(tags[0]), "v=DMARC1") {
+		return policy, fmt.Errorf("not a DMARC record: %s", txt)
+	}
+
+	for _, tag := range tags[1:] {
+		k, v, ok := strings.Cut(strings.TrimSpace(tag), "=")
+		if !ok {
+			continue
+		}
+
+		v = strings.ToLower(strings.TrimSpace(v))
+
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "p":
+			policy.Policy = v
+		case "sp":
+			policy.SubdomainPolicy = v
+		case "pct":
+			if n, e := strconv.Atoi(v); e == nil && n >= 0 && n <= 100 {
+				policy.Percent = n
+			}
+		}
+	}
+
+	switch policy.Policy {
+	case "none", "quarantine", "reject":
+	default:
+		return policy, fmt.Errorf("invalid DMARC policy: %q", policy.Policy)
+	}
+
+	return policy, nil
+}
+
+// LookupDMARCPolicy the policy published by the From domain, or by its organizational
+// domain when the From domain publishes none. found is false when neither does
+func LookupDMARCPolicy(ctx context.Context, fromDomain, orgDomain string) (policy DMARCPolicy, found bool) {
+	fromDomain = strings.ToLower(strings.TrimSuffix(fromDomain, "."))
+	orgDomain = strings.ToLower(strings.TrimSuffix(orgDomain, "."))
+
+	domains := []string{fromDomain}
+	if orgDomain != "" && orgDomain != fromDomain {
+		domains = append(domains, orgDomain)
+	}
+
+	for _, domain := range domains {
+		if domain == "" {
+			continue
+		}
+
+		records, err := lookupTXT(ctx, "_dmarc."+domain)
+		if err != nil {
+			continue
+		}
+
+		for _, txt := range records {
+			if p, err := ParseDMARCRecord(txt); err == nil {
+				p.IsSubdomain = domain != fromDomain
+				return p, true
+			}
+		}
+	}
+
+	return policy, false
+}
+
+// GetDMARCEnforcementConfig returns the stored settings, defaults to report only
+func GetDMARCEnforcementConfig(ctx context.Context) (cfg DMARCEnforcementConfig) {
+	if err := public.OptionsMgrInstance.GetOption(ctx, dmarcPolicyOptionKey, &cfg); err != nil {
+		cfg = DMARCEnforcementConfig{}
+	}
+
+	if cfg.Mode != DMARCModeEnforce {
+		cfg.Mode = DMARCModeReport
+	}
+	for domain, mode := range cfg.DomainModes {
+		if mode != DMARCModeEnforce {
+			cfg.DomainModes[domain] = DMARCModeReport
+		}
+	}
+
+	return cfg
+}
+
+// SetDMARCDomainMode sets the mode of a recipient domain, an empty mode makes it follow
+// the global one
+func SetDMARCDomainMode(ctx context.Context, domain, mode string) error {
+	cfg := GetDMARCEnforcementConfig(ctx)
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+
+	if cfg.DomainModes == nil {
+		cfg.DomainModes = map[string]string{}
+	}
+	if mode == "" {
+		delete(cfg.DomainModes, domain)
+	} else {
+		cfg.DomainModes[domain] = mode
+	}
+
+	return SetDMARCEnforcementConfig(ctx, cfg)
+}
+
+// SetDMARCEnforcementConfig stores the settings and syncs them to rspamd
+func SetDMARCEnforcementConfig(ctx context.Context, cfg DMARCEnforcementConfig) error {
+	if cfg.Mode != DMARCModeEnforce {
+		cfg.Mode = DMARCModeReport
+	}
+
+	modes := make(map[string]string, len(cfg.DomainModes))
+	for domain, mode := range cfg.DomainModes {
+		domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if domain == "" {
+			return fmt.Errorf("empty DMARC enforcement domain")
+		}
+		if mode != DMARCModeEnforce && mode != DMARCModeReport {
+			return fmt.Errorf("invalid DMARC enforcement mode %q of %s, expected %s or %s", mode, domain, DMARCModeReport, DMARCModeEnforce)
+		}
+		modes[domain] = mode
+	}
+	cfg.DomainModes = modes
+
+	for _, f := range cfg.TrustedForwarders {
+		if strings.TrimSpace(f) == "" {
+			return fmt.Errorf("empty trusted forwarder entry")
+		}
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, dmarcPolicyOptionKey, cfg); err != nil {
+		return err
+	}
+
+	return SyncDMARCPolicyToRspamd(ctx)
+}
+
+// ApplyDMARCPolicy decides what to do with an inbound message according to the
+// published policy of the sending domain and records the decision for reporting
+func ApplyDMARCPolicy(ctx context.Context, result DMARCResult, published DMARCPolicy) DMARCDecision {
+	cfg := GetDMARCEnforcementConfig(ctx)
+	decision := decideDMARC(cfg, result, published, mRand.Intn(100))
+
+	recordDMARCDecision(ctx, result, decision)
+
+	return decision
+}
+
+// decideDMARC is the pure decision part of ApplyDMARCPolicy, sample is in [0, 100)
+func decideDMARC(cfg DMARCEnforcementConfig, result DMARCResult, published DMARCPolicy, sample int) DMARCDecision {
+	policy := published.Policy
+	if published.IsSubdomain && published.SubdomainPolicy != "" {
+		policy = published.SubdomainPolicy
+	}
+
+	decision := DMARCDecision{Action: DMARCActionAccept, Published: policy}
+
+	switch {
+	case result.Pass():
+		decision.Reason = "dmarc pass"
+	case policy == "" || policy == "none":
+		decision.Reason = "dmarc fail, domain policy is none"
+	case isTrustedForwarder(cfg.TrustedForwarders, result):
+		decision.Reason = "dmarc fail, sent by trusted forwarder"
+	case published.Percent < 100 && sample >= published.Percent:
+		// RFC 7489 6.6.4: messages outside the sampled percentage get the next weaker policy
+		if policy == "reject" {
+			policy = "quarantine"
+		} else {
+			policy = "none"
+		}
+		decision.Reason = fmt.Sprintf("dmarc fail, not sampled by pct=%d, applying %s", published.Percent, policy)
+		if policy == "quarantine" {
+			decision.Action = DMARCActionQuarantine
+		}
+	default:
+		decision.Action = DMARCAction(policy)
+		decision.Reason = "dmarc fail, applying domain policy " + policy
+	}
+
+	if decision.Action != DMARCActionAccept {
+		if cfg.ModeOf(result.RecipientDomain) == DMARCModeEnforce {
+			decision.Enforced = true
+		} else {
+			decision.Reason += " (report only)"
+			decision.Action = DMARCActionAccept
+		}
+	}
+
+	return decision
+}
+
+// isTrustedForwarder checks the connecting client against the trusted forwarder list
+func isTrustedForwarder(forwarders []string, result DMARCResult) bool {
+	ip := net.ParseIP(result.SourceIP)
+	host := strings.ToLower(strings.TrimSuffix(result.SourceHost, "."))
+
+	for _, f := range forwarders {
+		f = strings.ToLower(strings.TrimSpace(f))
+
+		if strings.Contains(f, "/") {
+			if _, ipNet, err := net.ParseCIDR(f); err == nil && ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if fip := net.ParseIP(f); fip != nil {
+			if ip != nil && fip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		if host != "" && (host == f || strings.HasSuffix(host, "."+strings.TrimPrefix(f, "."))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordDMARCDecision stores the decision for DMARC reporting
+func recordDMARCDecision(ctx context.Context, result DMARCResult, decision DMARCDecision) {
+	_, err := g.DB().Model("bm_dmarc_decisions").Ctx(ctx).Insert(g.Map{
+		"header_from":  strings.ToLower(result.HeaderFrom),
+		"source_ip":    result.SourceIP,
+		"spf_aligned":  result.SPFAligned,
+		"dkim_aligned": result.DKIMAligned,
+		"policy":       decision.Published,
+		"action":       string(decision.Action),
+		"enforced":     decision.Enforced,
+		"reason":       decision.Reason,
+		"create_time":  time.Now().Unix(),
+	})
+
+	if err != nil {
+		g.Log().Warning(ctx, "Record DMARC decision failed", err)
+	}
+}
+
+// SyncDMARCPolicyToRspamd writes the rspamd configuration of the enforcement and
+// restarts rspamd to apply it. The dmarc module of rspamd only adds its symbols, its
+// own actions know neither the recipient domains nor the trusted forwarders: the
+// policy is applied by the inbound policy hook, enabled while a domain is enforced.
+func SyncDMARCPolicyToRspamd(ctx context.Context) error {
+	content := "# Generated by BillionMail, do not edit\n" +
+		"# The DMARC policy is enforced by the inbound policy hook (billionmail.conf)\n"
+
+	if _, err := public.WriteFile(public.AbsPath(filepath.Join(consts.RSPAMD_LOCAL_D_PATH, "dmarc.conf")), content); err != nil {
+		return err
+	}
+
+	if err := WriteInboundPolicyConfig(ctx); err != nil {
+		return err
+	}
+
+	return restartRspamd(ctx)
+}
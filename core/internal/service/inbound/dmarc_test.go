@@ -0,0 +1,91 @@
+package inbound
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestDMARCDomainModes(t *testing.T) {
+	cfg := DMARCEnforcementConfig{
+		Mode:        DMARCModeReport,
+		DomainModes: map[string]string{"strict.example": DMARCModeEnforce, "lax.example": DMARCModeReport},
+	}
+	reject := DMARCPolicy{Policy: "reject", Percent: 100}
+
+	for _, tc := range []struct {
+		recipient string
+		action    DMARCAction
+		enforced  bool
+	}{
+		{"strict.example", DMARCActionReject, true},
+		{"STRICT.example.", DMARCActionReject, true},
+		{"lax.example", DMARCActionAccept, false},
+		{"other.example", DMARCActionAccept, false},
+	} {
+		d := decideDMARC(cfg, DMARCResult{HeaderFrom: "sender.example", RecipientDomain: tc.recipient}, reject, 0)
+		if d.Action != tc.action || d.Enforced != tc.enforced {
+			t.Errorf("%s: %+v, want %s enforced %t", tc.recipient, d, tc.action, tc.enforced)
+		}
+	}
+
+	// The domains without a mode follow the global one
+	cfg.Mode = DMARCModeEnforce
+	if d := decideDMARC(cfg, DMARCResult{RecipientDomain: "other.example"}, reject, 0); d.Action != DMARCActionReject {
+		t.Errorf("global enforcement not applied: %+v", d)
+	}
+	if d := decideDMARC(cfg, DMARCResult{RecipientDomain: "lax.example"}, reject, 0); d.Action != DMARCActionAccept {
+		t.Errorf("report only domain enforced: %+v", d)
+	}
+}
+
+func TestDMARCEnforced(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  DMARCEnforcementConfig
+		want bool
+	}{
+		{DMARCEnforcementConfig{Mode: DMARCModeReport}, false},
+		{DMARCEnforcementConfig{Mode: DMARCModeEnforce}, true},
+		{DMARCEnforcementConfig{Mode: DMARCModeReport, DomainModes: map[string]string{"lax.example": DMARCModeReport}}, false},
+		// A single enforced domain needs the hook under a global report mode
+		{DMARCEnforcementConfig{Mode: DMARCModeReport, DomainModes: map[string]string{"strict.example": DMARCModeEnforce}}, true},
+	} {
+		if got := tc.cfg.enforced(); got != tc.want {
+			t.Errorf("%+v: enforced %t, want %t", tc.cfg, got, tc.want)
+		}
+	}
+}
+
+func TestLookupDMARCPolicy(t *testing.T) {
+	records := map[string][]string{
+		"_dmarc.example.com":      {"v=DMARC1; p=reject; sp=quarantine"},
+		"_dmarc.mail.example.org": {"v=spf1 -all", "v=DMARC1; p=none"},
+	}
+
+	defer func(orig func(context.Context, string) ([]string, error)) { lookupTXT = orig }(lookupTXT)
+	lookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		if txt, ok := records[name]; ok {
+			return txt, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		from, org string
+		found     bool
+		policy    string
+		subdomain bool
+	}{
+		{"example.com", "example.com", true, "reject", false},
+		{"News.Example.com.", "example.com", true, "reject", true},
+		{"mail.example.org", "example.org", true, "none", false},
+		{"other.example.net", "example.net", false, "", false},
+	} {
+		p, found := LookupDMARCPolicy(ctx, tc.from, tc.org)
+		if found != tc.found || p.Policy != tc.policy || p.IsSubdomain != tc.subdomain {
+			t.Errorf("%s: %+v found %t, want %s subdomain %t found %t", tc.from, p, found, tc.policy, tc.subdomain, tc.found)
+		}
+	}
+}
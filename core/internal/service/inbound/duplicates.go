@@ -0,0 +1,177 @@
+package inbound
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Suppression of duplicate deliveries. A misconfigured forwarder may deliver the same
+// message to a mailbox several times, for the opted-in mailboxes a delivery is a
+// duplicate when the same Message-ID reached the same recipient within the window.
+// Dovecot asks the policy service at each LMTP delivery through the sieve script run
+// before the scripts of the user (conf/dovecot/sieve), a duplicate is discarded. The
+// deliveries are remembered in the database for a day, the longest window, so the
+// suppression and its metric survive a restart of the core.
+// -----------------------------
+
+const duplicateSuppressionOptionKey = "inbound_duplicate_suppression"
+
+const (
+	defaultDuplicateWindow = 10 * time.Minute
+	maxDuplicateWindow     = 24 * time.Hour
+)
+
+// DuplicateSuppressionConfig opted-in mailboxes
+type DuplicateSuppressionConfig struct {
+	Mailboxes     []string `json:"mailboxes"`      // recipient addresses the suppression applies to
+	WindowSeconds int      `json:"window_seconds"` // 600 when unset
+}
+
+// DuplicateStats metric of the suppression
+type DuplicateStats struct {
+	Suppressed int64 `json:"suppressed"` // duplicate deliveries suppressed in the last 24 hours
+	Tracked    int   `json:"tracked"`    // deliveries currently remembered within the window
+}
+
+// lastDuplicateSweep unix time of the last removal of the expired deliveries
+var lastDuplicateSweep atomic.Int64
+
+// GetDuplicateSuppressionConfig returns the configured settings, no mailbox opted in when unset
+func GetDuplicateSuppressionConfig(ctx context.Context) DuplicateSuppressionConfig {
+	cfg := DuplicateSuppressionConfig{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, duplicateSuppressionOptionKey, &cfg)
+	return cfg
+}
+
+// SetDuplicateSuppressionConfig validates and saves the settings
+func SetDuplicateSuppressionConfig(ctx context.Context, cfg DuplicateSuppressionConfig) error {
+	if cfg.WindowSeconds < 0 || time.Duration(cfg.WindowSeconds)*time.Second > maxDuplicateWindow {
+		return fmt.Errorf("invalid duplicate window of %d seconds", cfg.WindowSeconds)
+	}
+
+	for i, mailbox := range cfg.Mailboxes {
+		mailbox = strings.ToLower(strings.TrimSpace(mailbox))
+		if !strings.Contains(mailbox, "@") {
+			return fmt.Errorf("invalid mailbox %q", mailbox)
+		}
+		cfg.Mailboxes[i] = mailbox
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, duplicateSuppressionOptionKey, cfg)
+}
+
+// SetDuplicateSuppression opts a mailbox in or out of the suppression
+func SetDuplicateSuppression(ctx context.Context, mailbox string, enabled bool) error {
+	mailbox = strings.ToLower(strings.TrimSpace(mailbox))
+
+	cfg := GetDuplicateSuppressionConfig(ctx)
+	mailboxes := make([]string, 0, len(cfg.Mailboxes)+1)
+	for _, m := range cfg.Mailboxes {
+		if m != mailbox {
+			mailboxes = append(mailboxes, m)
+		}
+	}
+	if enabled {
+		mailboxes = append(mailboxes, mailbox)
+	}
+	cfg.Mailboxes = mailboxes
+
+	return SetDuplicateSuppressionConfig(ctx, cfg)
+}
+
+// applies reports whether the deliveries to recipient are checked
+func (c DuplicateSuppressionConfig) applies(recipient string) bool {
+	for _, mailbox := range c.Mailboxes {
+		if mailbox == recipient {
+			return true
+		}
+	}
+	return false
+}
+
+func (c DuplicateSuppressionConfig) window() time.Duration {
+	if c.WindowSeconds > 0 {
+		return time.Duration(c.WindowSeconds) * time.Second
+	}
+	return defaultDuplicateWindow
+}
+
+// IsDuplicateDelivery reports whether the message already reached the recipient within
+// the window, the delivery should then be suppressed. The first delivery is remembered,
+// a recipient not opted in and a message without Message-ID are never duplicates
+func IsDuplicateDelivery(ctx context.Context, recipient, messageID string) (bool, error) {
+	recipient = strings.ToLower(strings.TrimSpace(recipient))
+	messageID = normalizeMessageId(messageID)
+	if recipient == "" || messageID == "" {
+		return false, nil
+	}
+
+	cfg := GetDuplicateSuppressionConfig(ctx)
+	if !cfg.applies(recipient) {
+		return false, nil
+	}
+
+	now := time.Now().Unix()
+	sweepDuplicateDeliveries(ctx, now)
+
+	// A delivery within the window counts a duplicate and keeps the time of the first,
+	// one after it starts a new window
+	row, err := g.DB().GetOne(ctx, `INSERT INTO bm_inbound_deliveries (recipient, message_id, first_seen, duplicates)
+		VALUES (?, ?, ?, 0)
+		ON CONFLICT (recipient, message_id) DO UPDATE SET
+			duplicates = CASE WHEN bm_inbound_deliveries.first_seen > ? THEN bm_inbound_deliveries.duplicates + 1 ELSE 0 END,
+			first_seen = CASE WHEN bm_inbound_deliveries.first_seen > ? THEN bm_inbound_deliveries.first_seen ELSE EXCLUDED.first_seen END
+		RETURNING duplicates`,
+		recipient, messageID, now, now-int64(cfg.window().Seconds()), now-int64(cfg.window().Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	return row["duplicates"].Int64() > 0, nil
+}
+
+// GetDuplicateStats returns the metric of the suppression
+func GetDuplicateStats(ctx context.Context) (stats DuplicateStats, err error) {
+	now := time.Now().Unix()
+
+	suppressed, err := g.DB().Model("bm_inbound_deliveries").Ctx(ctx).
+		Where("first_seen > ?", now-int64(maxDuplicateWindow.Seconds())).
+		Sum("duplicates")
+	if err != nil {
+		return stats, err
+	}
+
+	tracked, err := g.DB().Model("bm_inbound_deliveries").Ctx(ctx).
+		Where("first_seen > ?", now-int64(GetDuplicateSuppressionConfig(ctx).window().Seconds())).
+		Count()
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Suppressed = int64(suppressed)
+	stats.Tracked = tracked
+	return stats, nil
+}
+
+// sweepDuplicateDeliveries forgets the deliveries older than the longest window, at most
+// once a minute
+func sweepDuplicateDeliveries(ctx context.Context, now int64) {
+	last := lastDuplicateSweep.Load()
+	if now-last < 60 || !lastDuplicateSweep.CompareAndSwap(last, now) {
+		return
+	}
+
+	_, err := g.DB().Model("bm_inbound_deliveries").Ctx(ctx).
+		Where("first_seen <= ?", now-int64(maxDuplicateWindow.Seconds())).
+		Delete()
+	if err != nil {
+		g.Log().Warning(ctx, "Failed to sweep the remembered deliveries:", err)
+	}
+}
@@ -0,0 +1,47 @@
+package inbound
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDuplicateWindow(t *testing.T) {
+	if w := (DuplicateSuppressionConfig{WindowSeconds: 60}).window(); w != time.Minute {
+		t.Errorf("window = %s, want 1m", w)
+	}
+}
+
+func TestDuplicateSuppressionConfigInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	for _, cfg := range []DuplicateSuppressionConfig{
+		{WindowSeconds: -1},
+		{WindowSeconds: int(maxDuplicateWindow.Seconds()) + 1},
+		{Mailboxes: []string{"not a mailbox"}},
+	} {
+		if err := SetDuplicateSuppressionConfig(ctx, cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestDuplicateDeliveryIgnored(t *testing.T) {
+	// Checked before the settings and the database are read
+	for _, tc := range [][2]string{{"", "<id-1@example.com>"}, {"a@example.com", ""}, {"a@example.com", "<>"}} {
+		if dup, err := IsDuplicateDelivery(context.Background(), tc[0], tc[1]); dup || err != nil {
+			t.Errorf("%q %q: duplicate %t, %v", tc[0], tc[1], dup, err)
+		}
+	}
+}
+
+func TestDuplicateSuppressionApplies(t *testing.T) {
+	cfg := DuplicateSuppressionConfig{Mailboxes: []string{"a@example.com"}}
+
+	if !cfg.applies("a@example.com") || cfg.applies("b@example.com") {
+		t.Error("suppression applies to the wrong mailboxes")
+	}
+	if cfg.window() != defaultDuplicateWindow {
+		t.Errorf("window = %s", cfg.window())
+	}
+}
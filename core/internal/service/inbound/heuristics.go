@@ -0,0 +1,352 @@
+package inbound
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// -----------------------------
+// Built-in spam heuristics, for installs without rspamd or when it is unreachable.
+// Each rule that matches adds its weight to the score of the message, a message
+// scoring at least the threshold is spam. The rules look at missing or malformed
+// headers, shouting subjects and bodies, link-heavy bodies, executable attachments and
+// known spam phrases. The weights, phrases and threshold are configurable.
+// -----------------------------
+
+const heuristicsOptionKey = "inbound_heuristics"
+
+// Heuristic rules, the keys of the weights
+const (
+	HeuristicMissingFrom      = "missing_from"
+	HeuristicMissingDate      = "missing_date"
+	HeuristicMissingMessageId = "missing_message_id"
+	HeuristicMissingSubject   = "missing_subject"
+	HeuristicInvalidFrom      = "invalid_from"
+	HeuristicInvalidDate      = "invalid_date"
+	HeuristicFutureDate       = "future_date"
+	HeuristicInvalidMessageId = "invalid_message_id"
+	HeuristicReplyToMismatch  = "reply_to_mismatch"
+	HeuristicSubjectCaps      = "subject_caps"
+	HeuristicBodyCaps         = "body_caps"
+	HeuristicManyLinks        = "many_links"
+	HeuristicIPLink           = "ip_link"
+	HeuristicHTMLOnly         = "html_only"
+	HeuristicAttachment       = "suspicious_attachment"
+	HeuristicSpamPhrase       = "spam_phrase" // per phrase found
+)
+
+// Heuristic verdicts
+const (
+	HeuristicVerdictHam  = "ham"
+	HeuristicVerdictSpam = "spam"
+)
+
+const (
+	heuristicMaxLinks       = 10
+	heuristicMaxPhraseHits  = 5   // phrases counted at most
+	heuristicCapsRatio      = 0.7 // share of upper case letters of a shouting text
+	heuristicMinCapsLetters = 10
+)
+
+var (
+	heuristicLinkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>()]+`)
+
+	// Executables and scripts run by a double click
+	suspiciousAttachmentExts = map[string]bool{
+		".exe": true, ".scr": true, ".bat": true, ".cmd": true, ".com": true, ".pif": true,
+		".js": true, ".jse": true, ".vbs": true, ".vbe": true, ".wsf": true, ".hta": true,
+		".jar": true, ".msi": true, ".ps1": true, ".lnk": true, ".iso": true, ".img": true,
+	}
+)
+
+// HeuristicsConfig weights of the rules, spam phrases and verdict threshold
+type HeuristicsConfig struct {
+	Weights   map[string]float64 `json:"weights"`   // by rule, the defaults for the rules absent
+	Phrases   []string           `json:"phrases"`   // matched case-insensitively in the subject and text
+	Threshold float64            `json:"threshold"` // spam at or above it
+}
+
+// DefaultHeuristicsConfig weights tuned so a single rule never makes a message spam
+func DefaultHeuristicsConfig() HeuristicsConfig {
+	return HeuristicsConfig{
+		Weights: map[string]float64{
+			HeuristicMissingFrom:      3,
+			HeuristicMissingDate:      1.5,
+			HeuristicMissingMessageId: 1.5,
+			HeuristicMissingSubject:   0.5,
+			HeuristicInvalidFrom:      2.5,
+			HeuristicInvalidDate:      1.5,
+			HeuristicFutureDate:       2,
+			HeuristicInvalidMessageId: 1.5,
+			HeuristicReplyToMismatch:  1,
+			HeuristicSubjectCaps:      1.5,
+			HeuristicBodyCaps:         1.5,
+			HeuristicManyLinks:        1.5,
+			HeuristicIPLink:           2.5,
+			HeuristicHTMLOnly:         0.5,
+			HeuristicAttachment:       4,
+			HeuristicSpamPhrase:       1,
+		},
+		Phrases: []string{
+			"act now", "100% free", "risk free", "winner", "you have been selected",
+			"claim your prize", "no credit check", "guaranteed income", "work from home",
+			"lowest price", "click here", "limited time offer", "viagra", "crypto giveaway",
+			"urgent response", "wire transfer", "verify your account",
+		},
+		Threshold: 6,
+	}
+}
+
+// GetHeuristicsConfig returns the configured settings, the defaults for what is unset
+func GetHeuristicsConfig(ctx context.Context) HeuristicsConfig {
+	cfg := HeuristicsConfig{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, heuristicsOptionKey, &cfg)
+	return cfg.withDefaults()
+}
+
+// SetHeuristicsConfig validates and saves the settings
+func SetHeuristicsConfig(ctx context.Context, cfg HeuristicsConfig) error {
+	known := DefaultHeuristicsConfig().Weights
+	for rule, weight := range cfg.Weights {
+		if _, ok := known[rule]; !ok {
+			return fmt.Errorf("unknown heuristic rule %q", rule)
+		}
+		if weight < 0 {
+			return fmt.Errorf("negative weight of heuristic rule %s", rule)
+		}
+	}
+
+	if cfg.Threshold < 0 {
+		return fmt.Errorf("invalid heuristic threshold %g", cfg.Threshold)
+	}
+
+	for i, phrase := range cfg.Phrases {
+		cfg.Phrases[i] = strings.ToLower(strings.TrimSpace(phrase))
+		if cfg.Phrases[i] == "" {
+			return fmt.Errorf("empty spam phrase")
+		}
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, heuristicsOptionKey, cfg)
+}
+
+// withDefaults fills the weights, phrases and threshold left unset
+func (c HeuristicsConfig) withDefaults() HeuristicsConfig {
+	def := DefaultHeuristicsConfig()
+
+	weights := def.Weights
+	for rule, weight := range c.Weights {
+		weights[rule] = weight
+	}
+	c.Weights = weights
+
+	if c.Phrases == nil {
+		c.Phrases = def.Phrases
+	}
+	if c.Threshold == 0 {
+		c.Threshold = def.Threshold
+	}
+
+	return c
+}
+
+// Verdict of a score
+func (c HeuristicsConfig) Verdict(score float64) string {
+	if score >= c.Threshold {
+		return HeuristicVerdictSpam
+	}
+	return HeuristicVerdictHam
+}
+
+// HeuristicScore scores the raw message with the configured rules. The reasons name the
+// rules that matched, with their detail and weight
+func HeuristicScore(ctx context.Context, msg []byte) (score float64, reasons []string) {
+	return GetHeuristicsConfig(ctx).score(msg, time.Now())
+}
+
+// heuristicScorer state of the scoring of one message
+type heuristicScorer struct {
+	cfg     HeuristicsConfig
+	score   float64
+	reasons []string
+}
+
+func (s *heuristicScorer) hit(rule, detail string) {
+	weight := s.cfg.Weights[rule]
+	if weight == 0 {
+		return
+	}
+
+	s.score += weight
+	if detail != "" {
+		rule += ": " + detail
+	}
+	s.reasons = append(s.reasons, fmt.Sprintf("%s (+%g)", rule, weight))
+}
+
+// score scores the message at now
+func (c HeuristicsConfig) score(msg []byte, now time.Time) (float64, []string) {
+	s := &heuristicScorer{cfg: c, reasons: make([]string, 0)}
+
+	headers, root, _ := parseRawMessage(msg)
+	values := make(map[string]string, len(headers))
+	for _, h := range headers {
+		name := strings.ToLower(h.Name)
+		if _, ok := values[name]; !ok {
+			values[name] = h.Value
+		}
+	}
+
+	s.checkHeaders(values, now)
+
+	var text strings.Builder
+	text.WriteString(values["subject"])
+	text.WriteByte('\n')
+
+	hasPlain, hasHTML := false, false
+	walkMessageParts(root, func(part *MessagePart) {
+		if part.Filename != "" || part.Disposition == "attachment" {
+			s.checkAttachment(part.Filename)
+			return
+		}
+
+		switch part.ContentType {
+		case "text/plain":
+			hasPlain = true
+			text.Write(part.body)
+			text.WriteByte('\n')
+		case "text/html":
+			hasHTML = true
+			text.Write(part.body)
+			text.WriteByte('\n')
+		}
+	})
+
+	if hasHTML && !hasPlain {
+		s.hit(HeuristicHTMLOnly, "")
+	}
+
+	s.checkBody(text.String())
+
+	return s.score, s.reasons
+}
+
+// checkHeaders the missing, malformed and forged headers, the shouting subject
+func (s *heuristicScorer) checkHeaders(values map[string]string, now time.Time) {
+	from, ok := values["from"]
+	if !ok {
+		s.hit(HeuristicMissingFrom, "")
+	} else if addr, err := mail.ParseAddress(from); err != nil {
+		s.hit(HeuristicInvalidFrom, from)
+	} else if replyTo, err := mail.ParseAddress(values["reply-to"]); err == nil && addressDomain(replyTo.Address) != addressDomain(addr.Address) {
+		// Replies diverted away from the apparent sender
+		s.hit(HeuristicReplyToMismatch, replyTo.Address)
+	}
+
+	date, ok := values["date"]
+	if !ok {
+		s.hit(HeuristicMissingDate, "")
+	} else if t, err := mail.ParseDate(date); err != nil {
+		s.hit(HeuristicInvalidDate, date)
+	} else if t.After(now.Add(24 * time.Hour)) {
+		s.hit(HeuristicFutureDate, date)
+	}
+
+	id, ok := values["message-id"]
+	if !ok {
+		s.hit(HeuristicMissingMessageId, "")
+	} else if local, domain, found := strings.Cut(normalizeMessageId(id), "@"); !found || local == "" || domain == "" {
+		s.hit(HeuristicInvalidMessageId, id)
+	}
+
+	subject, ok := values["subject"]
+	if !ok || strings.TrimSpace(subject) == "" {
+		s.hit(HeuristicMissingSubject, "")
+	} else if shouting(subject) {
+		s.hit(HeuristicSubjectCaps, "")
+	}
+}
+
+// checkBody the shouting text, links and spam phrases of the subject and text parts
+func (s *heuristicScorer) checkBody(text string) {
+	if shouting(text) {
+		s.hit(HeuristicBodyCaps, "")
+	}
+
+	links := heuristicLinkPattern.FindAllString(text, -1)
+	if len(links) > heuristicMaxLinks {
+		s.hit(HeuristicManyLinks, fmt.Sprintf("%d links", len(links)))
+	}
+	for _, link := range links {
+		if u, err := url.Parse(link); err == nil && net.ParseIP(strings.Trim(u.Hostname(), "[]")) != nil {
+			s.hit(HeuristicIPLink, u.Host)
+			break
+		}
+	}
+
+	lower := strings.ToLower(text)
+	found := make([]string, 0)
+	for _, phrase := range s.cfg.Phrases {
+		if phrase != "" && strings.Contains(lower, phrase) {
+			found = append(found, phrase)
+		}
+	}
+	sort.Strings(found)
+	if len(found) > heuristicMaxPhraseHits {
+		found = found[:heuristicMaxPhraseHits]
+	}
+	for _, phrase := range found {
+		s.hit(HeuristicSpamPhrase, phrase)
+	}
+}
+
+// checkAttachment executable attachments, and documents hiding an executable extension
+func (s *heuristicScorer) checkAttachment(filename string) {
+	name := strings.ToLower(strings.TrimSpace(filename))
+	if suspiciousAttachmentExts[path.Ext(name)] {
+		s.hit(HeuristicAttachment, filename)
+	}
+}
+
+func addressDomain(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	return strings.ToLower(domain)
+}
+
+// shouting reports a text whose letters are mostly upper case
+func shouting(text string) bool {
+	letters, upper := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= heuristicMinCapsLetters && float64(upper) >= heuristicCapsRatio*float64(letters)
+}
+
+// walkMessageParts calls fn on every leaf part of the tree
+func walkMessageParts(part *MessagePart, fn func(part *MessagePart)) {
+	if part == nil {
+		return
+	}
+	if len(part.Parts) == 0 {
+		fn(part)
+		return
+	}
+	for _, child := range part.Parts {
+		walkMessageParts(child, fn)
+	}
+}
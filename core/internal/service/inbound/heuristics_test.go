@@ -0,0 +1,72 @@
+package inbound
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeuristicScore(t *testing.T) {
+	cfg := DefaultHeuristicsConfig()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	ham := "From: Alice <alice@example.com>\r\n" +
+		"To: bob@example.org\r\n" +
+		"Subject: Meeting notes\r\n" +
+		"Date: Fri, 1 Mar 2024 10:00:00 +0000\r\n" +
+		"Message-ID: <notes-1@example.com>\r\n" +
+		"\r\n" +
+		"Hi Bob, the notes of today are at https://example.com/notes.\r\n"
+
+	score, reasons := cfg.score([]byte(ham), now)
+	if score != 0 || len(reasons) != 0 {
+		t.Errorf("ham scored %g: %v", score, reasons)
+	}
+	if cfg.Verdict(score) != HeuristicVerdictHam {
+		t.Errorf("ham verdict %s", cfg.Verdict(score))
+	}
+
+	spam := "From: winner\r\n" +
+		"Subject: YOU HAVE BEEN SELECTED WINNER\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>Click here to claim your prize: http://203.0.113.7/claim</p>\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=\"invoice.pdf.exe\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"TVo=\r\n" +
+		"--b--\r\n"
+
+	score, reasons = cfg.score([]byte(spam), now)
+	if cfg.Verdict(score) != HeuristicVerdictSpam {
+		t.Errorf("spam scored %g: %v", score, reasons)
+	}
+
+	for _, rule := range []string{HeuristicInvalidFrom, HeuristicMissingDate, HeuristicMissingMessageId, HeuristicSubjectCaps,
+		HeuristicIPLink, HeuristicHTMLOnly, HeuristicAttachment, HeuristicSpamPhrase} {
+		found := false
+		for _, reason := range reasons {
+			found = found || strings.HasPrefix(reason, rule)
+		}
+		if !found {
+			t.Errorf("rule %s not reported: %v", rule, reasons)
+		}
+	}
+}
+
+func TestHeuristicWeights(t *testing.T) {
+	cfg := HeuristicsConfig{Weights: map[string]float64{HeuristicMissingFrom: 0, HeuristicMissingDate: 10}}.withDefaults()
+
+	score, reasons := cfg.score([]byte("Subject: hello\r\nMessage-ID: <a@b>\r\n\r\nbody\r\n"), time.Now())
+	if score != 10 || len(reasons) != 1 || reasons[0] != "missing_date (+10)" {
+		t.Errorf("score %g, reasons %v", score, reasons)
+	}
+	if cfg.Weights[HeuristicAttachment] != DefaultHeuristicsConfig().Weights[HeuristicAttachment] {
+		t.Error("default weight of an unset rule not kept")
+	}
+}
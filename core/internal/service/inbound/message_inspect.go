@@ -0,0 +1,436 @@
+package inbound
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// -----------------------------
+// Inspection of a single raw message for debugging: the headers in their order,
+// the MIME tree with the content type and decoded size of every part, the
+// authentication results and the spam score. Bodies are not part of the view,
+// except the start of the text parts, a part is downloaded on its own.
+// -----------------------------
+
+const (
+	maxMIMEDepth      = 16
+	maxMIMEParts      = 500
+	maxTextPreview    = 4096
+	maxInspectedBytes = 64 << 20
+)
+
+var (
+	queueIdPattern      = regexp.MustCompile(`^[0-9A-Za-z]{5,32}$`)
+	authResultPattern   = regexp.MustCompile(`(?i)\b(spf|dmarc)\s*=\s*([a-z]+)`)
+	spamdScorePattern   = regexp.MustCompile(`\[\s*(-?\d+(?:\.\d+)?)\s*/`)
+	receivedSPFPattern  = regexp.MustCompile(`(?i)^\s*([a-z]+)`)
+	errPartNotFound     = errors.New("message part not found")
+	errMessageTooLarge  = errors.New("message too large to inspect")
+	errInvalidMessageId = errors.New("invalid queue id")
+)
+
+// fetchRawMessage raw content of a message in the postfix queue, replaceable in tests
+var fetchRawMessage = func(ctx context.Context, id string) ([]byte, error) {
+	if !queueIdPattern.MatchString(id) {
+		return nil, errInvalidMessageId
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return nil, err
+	}
+	defer dk.Close()
+
+	result, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postcat", "-bh", "-q", id}, "root")
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || result.ExitCode != 0 {
+		output := ""
+		if result != nil {
+			output = strings.TrimSpace(result.Output)
+		}
+		return nil, fmt.Errorf("postcat failed: %s", output)
+	}
+
+	return stripPostcatBanners(result.Output), nil
+}
+
+// QueuedMessage raw content of a message in the postfix queue, e.g. a held one
+func QueuedMessage(ctx context.Context, id string) ([]byte, error) {
+	return fetchRawMessage(ctx, id)
+}
+
+// stripPostcatBanners removes the "*** ..." record banners postcat prints around the content
+func stripPostcatBanners(output string) []byte {
+	lines := strings.SplitAfter(output, "\n")
+
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.HasPrefix(line, "*** ") && strings.HasSuffix(strings.TrimRight(line, "\r\n"), "***") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	return []byte(strings.Join(kept, ""))
+}
+
+// MessageHeader one header field, the value unfolded and with its encoded words decoded
+type MessageHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// MessagePart node of the MIME tree. Path numbers the parts like IMAP, "1.2" is the second
+// part of the first one, the root of the message has an empty path
+type MessagePart struct {
+	Path        string         `json:"path"`
+	ContentType string         `json:"content_type"`
+	Charset     string         `json:"charset,omitempty"`
+	Encoding    string         `json:"encoding,omitempty"`
+	Disposition string         `json:"disposition,omitempty"`
+	Filename    string         `json:"filename,omitempty"`
+	Size        int            `json:"size"`              // decoded size of a leaf part
+	Preview     string         `json:"preview,omitempty"` // start of a text part
+	Truncated   bool           `json:"truncated,omitempty"`
+	Error       string         `json:"error,omitempty"` // why the part could not be parsed or decoded
+	Parts       []*MessagePart `json:"parts,omitempty"`
+
+	body []byte // decoded content of a leaf part
+}
+
+// MessageAuth authentication results of a message. SPF and DMARC are taken from the
+// Authentication-Results added on reception, DKIM is verified again
+type MessageAuth struct {
+	DKIM  []DKIMResult `json:"dkim"`
+	SPF   string       `json:"spf,omitempty"`
+	DMARC string       `json:"dmarc,omitempty"`
+}
+
+// MessageView parsed structure of a raw message
+type MessageView struct {
+	Id        string          `json:"id"`
+	Size      int             `json:"size"`
+	Headers   []MessageHeader `json:"headers"`
+	Structure *MessagePart    `json:"structure"`
+	Auth      MessageAuth     `json:"auth"`
+	SpamScore *float64        `json:"spam_score"` // null when the message was not scanned
+	Errors    []string        `json:"errors,omitempty"`
+}
+
+// MessagePartContent decoded content of one part, for download
+type MessagePartContent struct {
+	ContentType string
+	Filename    string
+	Data        []byte
+}
+
+// InspectMessage parses the queued message id
+func InspectMessage(ctx context.Context, id string) (MessageView, error) {
+	raw, err := fetchRawMessage(ctx, id)
+	if err != nil {
+		return MessageView{Id: id}, err
+	}
+
+	view := InspectRawMessage(ctx, raw)
+	view.Id = id
+
+	return view, nil
+}
+
+// GetMessagePart the decoded content of the part at path of the queued message id
+func GetMessagePart(ctx context.Context, id, path string) (*MessagePartContent, error) {
+	raw, err := fetchRawMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, root, _ := parseRawMessage(raw)
+	part := findMessagePart(root, path)
+	if part == nil || part.Parts != nil {
+		return nil, errPartNotFound
+	}
+	if part.Error != "" {
+		return nil, errors.New(part.Error)
+	}
+
+	return &MessagePartContent{ContentType: part.ContentType, Filename: part.Filename, Data: part.body}, nil
+}
+
+// InspectRawMessage parses a raw message, malformed parts are reported in their Error
+// instead of failing the whole view
+func InspectRawMessage(ctx context.Context, raw []byte) MessageView {
+	view := MessageView{Size: len(raw), Headers: make([]MessageHeader, 0)}
+
+	if len(raw) > maxInspectedBytes {
+		view.Errors = append(view.Errors, errMessageTooLarge.Error())
+		return view
+	}
+
+	headers, root, err := parseRawMessage(raw)
+	if err != nil {
+		view.Errors = append(view.Errors, err.Error())
+	}
+	view.Headers = headers
+	view.Structure = root
+
+	view.Auth.DKIM, err = VerifyDKIM(ctx, raw)
+	if err != nil {
+		view.Errors = append(view.Errors, "dkim: "+err.Error())
+	}
+	if view.Auth.DKIM == nil {
+		view.Auth.DKIM = make([]DKIMResult, 0)
+	}
+
+	for _, h := range headers {
+		switch strings.ToLower(h.Name) {
+		case "authentication-results":
+			for _, m := range authResultPattern.FindAllStringSubmatch(h.Value, -1) {
+				method, result := strings.ToLower(m[1]), strings.ToLower(m[2])
+				if method == "spf" && view.Auth.SPF == "" {
+					view.Auth.SPF = result
+				}
+				if method == "dmarc" && view.Auth.DMARC == "" {
+					view.Auth.DMARC = result
+				}
+			}
+		case "received-spf":
+			if m := receivedSPFPattern.FindStringSubmatch(h.Value); m != nil && view.Auth.SPF == "" {
+				view.Auth.SPF = strings.ToLower(m[1])
+			}
+		case "x-spamd-result", "x-rspamd-score", "x-spam-score":
+			if view.SpamScore == nil {
+				view.SpamScore = parseSpamScore(h.Value)
+			}
+		}
+	}
+
+	return view
+}
+
+// parseSpamScore reads "default: False [3.40 / 15.00]" of rspamd or a plain number
+func parseSpamScore(value string) *float64 {
+	s := strings.TrimSpace(value)
+	if m := spamdScorePattern.FindStringSubmatch(s); m != nil {
+		s = m[1]
+	}
+
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &score
+}
+
+// parseRawMessage splits the message into its ordered headers and MIME tree
+func parseRawMessage(raw []byte) ([]MessageHeader, *MessagePart, error) {
+	fields, body, err := splitDKIMMessage(raw)
+	if err != nil {
+		return make([]MessageHeader, 0), &MessagePart{ContentType: "text/plain", Error: err.Error()}, err
+	}
+
+	headers := make([]MessageHeader, 0, len(fields))
+	mimeHeader := make(textproto.MIMEHeader)
+
+	for _, f := range fields {
+		_, value, _ := strings.Cut(f.Raw, ":")
+		value = strings.TrimSpace(strings.ReplaceAll(value, "\r\n", ""))
+
+		headers = append(headers, MessageHeader{Name: f.Name, Value: decodeHeaderValue(value)})
+		mimeHeader.Add(f.Name, value)
+	}
+
+	count := 0
+	return headers, parseMessagePart(mimeHeader, body, "", 0, &count), nil
+}
+
+// decodeHeaderValue decodes the RFC 2047 encoded words, the value is kept as is when it cannot be
+func decodeHeaderValue(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// parseMessagePart builds the node of one part and its children
+func parseMessagePart(header textproto.MIMEHeader, body []byte, path string, depth int, count *int) *MessagePart {
+	*count++
+	part := &MessagePart{Path: path, ContentType: "text/plain", Charset: "us-ascii"}
+
+	var errs []string
+
+	if ct := header.Get("Content-Type"); ct != "" {
+		mediaType, params, err := mime.ParseMediaType(ct)
+		if err != nil {
+			// RFC 2045 5.2: an invalid Content-Type is treated as plain text
+			errs = append(errs, "invalid content type: "+err.Error())
+		} else {
+			part.ContentType = mediaType
+			part.Charset = params["charset"]
+			part.Filename = decodeHeaderValue(params["name"])
+		}
+	}
+
+	part.Encoding = strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding")))
+
+	if cd := header.Get("Content-Disposition"); cd != "" {
+		if disposition, params, err := mime.ParseMediaType(cd); err == nil {
+			part.Disposition = disposition
+			if name := params["filename"]; name != "" {
+				part.Filename = decodeHeaderValue(name)
+			}
+		} else {
+			errs = append(errs, "invalid content disposition: "+err.Error())
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(part.ContentType, "multipart/"):
+		part.Charset = ""
+		if err := parseMultipart(part, header, body, depth, count); err != nil {
+			errs = append(errs, err.Error())
+		}
+
+	case part.ContentType == "message/rfc822" && depth < maxMIMEDepth:
+		part.Charset = ""
+		fields, nestedBody, err := splitDKIMMessage(body)
+		if err != nil {
+			errs = append(errs, "invalid attached message: "+err.Error())
+			break
+		}
+		nested := make(textproto.MIMEHeader)
+		for _, f := range fields {
+			_, value, _ := strings.Cut(f.Raw, ":")
+			nested.Add(f.Name, strings.TrimSpace(strings.ReplaceAll(value, "\r\n", "")))
+		}
+		part.Parts = []*MessagePart{parseMessagePart(nested, nestedBody, childPath(path, 1), depth+1, count)}
+
+	default:
+		decoded, err := decodePartBody(body, part.Encoding)
+		if err != nil {
+			errs = append(errs, "decoding failed: "+err.Error())
+		}
+		part.body = decoded
+		part.Size = len(decoded)
+
+		if strings.HasPrefix(part.ContentType, "text/") && part.Disposition != "attachment" {
+			part.Preview, part.Truncated = textPreview(decoded, part.Charset)
+		}
+	}
+
+	part.Error = strings.Join(errs, "; ")
+	return part
+}
+
+// parseMultipart parses the children of a multipart part
+func parseMultipart(part *MessagePart, header textproto.MIMEHeader, body []byte, depth int, count *int) error {
+	_, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	boundary := params["boundary"]
+	if boundary == "" {
+		return errors.New("multipart without boundary")
+	}
+	if depth >= maxMIMEDepth {
+		return errors.New("too deeply nested")
+	}
+
+	part.Parts = make([]*MessagePart, 0)
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+
+	for i := 1; ; i++ {
+		if *count >= maxMIMEParts {
+			return fmt.Errorf("more than %d parts, the rest is not shown", maxMIMEParts)
+		}
+
+		p, err := reader.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("part %s: %v", childPath(part.Path, i), err)
+		}
+
+		data, err := io.ReadAll(p)
+		if err != nil {
+			// What could be read is still shown
+			child := parseMessagePart(p.Header, data, childPath(part.Path, i), depth+1, count)
+			child.Error = strings.TrimPrefix(child.Error+"; truncated: "+err.Error(), "; ")
+			part.Parts = append(part.Parts, child)
+			return nil
+		}
+
+		part.Parts = append(part.Parts, parseMessagePart(p.Header, data, childPath(part.Path, i), depth+1, count))
+	}
+}
+
+func childPath(parent string, i int) string {
+	if parent == "" {
+		return strconv.Itoa(i)
+	}
+	return parent + "." + strconv.Itoa(i)
+}
+
+// decodePartBody decodes the transfer encoding, what was decoded before an error is returned with it
+func decodePartBody(body []byte, encoding string) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.TrimRight(body, " \t\r\n")))
+	case "quoted-printable":
+		r = quotedprintable.NewReader(bytes.NewReader(body))
+	default:
+		return body, nil
+	}
+
+	return io.ReadAll(r)
+}
+
+// textPreview start of a text part, only UTF-8 compatible charsets are shown
+func textPreview(data []byte, charset string) (string, bool) {
+	switch strings.ToLower(charset) {
+	case "", "us-ascii", "utf-8", "utf8":
+	default:
+		return "", false
+	}
+
+	truncated := len(data) > maxTextPreview
+	if truncated {
+		data = data[:maxTextPreview]
+		// Do not cut a character in the middle
+		for len(data) > 0 && !utf8.Valid(data) {
+			data = data[:len(data)-1]
+		}
+	}
+
+	return strings.ToValidUTF8(string(data), "�"), truncated
+}
+
+// findMessagePart the part at path in the tree
+func findMessagePart(root *MessagePart, path string) *MessagePart {
+	if root == nil || root.Path == path {
+		return root
+	}
+
+	for _, child := range root.Parts {
+		if child.Path == path || strings.HasPrefix(path, child.Path+".") {
+			return findMessagePart(child, path)
+		}
+	}
+
+	return nil
+}
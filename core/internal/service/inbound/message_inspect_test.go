@@ -0,0 +1,83 @@
+package inbound
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestInspectRawMessage(t *testing.T) {
+	message := strings.Join([]string{
+		"Authentication-Results: mx.example.net; spf=pass smtp.mailfrom=example.com; dmarc=fail header.from=example.com",
+		"X-Spamd-Result: default: False [3.40 / 15.00]",
+		"From: sender@example.com",
+		"Subject: =?UTF-8?B?SGVsbG8gd29ybGQ=?=",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="outer"`,
+		"",
+		"--outer",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Caf=C3=A9",
+		"--outer",
+		`Content-Type: application/pdf; name="report.pdf"`,
+		"Content-Disposition: attachment",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"JVBERi0xLjQK",
+		"--outer",
+		"Content-Type: image/png",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"not base64 !!",
+		"--outer",
+		"Content-Type: multipart/alternative",
+		"",
+		"no boundary",
+		"--outer--",
+		"",
+	}, "\r\n")
+
+	view := InspectRawMessage(context.Background(), []byte(message))
+
+	if len(view.Errors) != 0 {
+		t.Errorf("unexpected errors: %v", view.Errors)
+	}
+	if view.Headers[3].Value != "Hello world" {
+		t.Errorf("subject = %q", view.Headers[3].Value)
+	}
+	if view.Auth.SPF != "pass" || view.Auth.DMARC != "fail" {
+		t.Errorf("auth = %+v", view.Auth)
+	}
+	if view.SpamScore == nil || *view.SpamScore != 3.4 {
+		t.Errorf("spam score = %v", view.SpamScore)
+	}
+
+	root := view.Structure
+	if root.ContentType != "multipart/mixed" || len(root.Parts) != 4 {
+		t.Fatalf("root = %+v", root)
+	}
+
+	text := root.Parts[0]
+	if text.Path != "1" || text.Preview != "Café" || text.Size != 5 {
+		t.Errorf("text part = %+v", text)
+	}
+
+	pdf := root.Parts[1]
+	if pdf.Filename != "report.pdf" || pdf.Size != 9 || pdf.Preview != "" || pdf.Error != "" {
+		t.Errorf("attachment = %+v", pdf)
+	}
+
+	// Malformed parts are reported on their own
+	if root.Parts[2].Error == "" || root.Parts[3].Error == "" {
+		t.Errorf("malformed parts not reported: %+v %+v", root.Parts[2], root.Parts[3])
+	}
+
+	if part := findMessagePart(root, "2"); part != pdf || string(part.body) != "%PDF-1.4\n" {
+		t.Errorf("part 2 = %+v", part)
+	}
+	if findMessagePart(root, "5") != nil || findMessagePart(root, "1.1") != nil {
+		t.Error("missing parts found")
+	}
+}
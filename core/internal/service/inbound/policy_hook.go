@@ -0,0 +1,50 @@
+package inbound
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// -----------------------------
+// Inbound policy hook of rspamd (conf/rspamd/rspamd.local.lua). Once rspamd scanned an
+// inbound message it queries the policy service of the core at the SCAN stage with the
+// authentication results and the score, and applies the action answered: the milter
+// rejects the message, holds it in the postfix queue or adds the spam header. The hook is only enabled while
+// a policy needs it, an unreachable core never blocks mail.
+// -----------------------------
+
+// Address of the policy service as seen by rspamd, smtp_policy.PolicyListenAddr
+const (
+	inboundPolicyHost = "core"
+	inboundPolicyPort = 10040
+)
+
+// WriteInboundPolicyConfig writes the settings of the hook, rspamd reads them on its next restart
+func WriteInboundPolicyConfig(ctx context.Context) error {
+	enabled := GetDMARCEnforcementConfig(ctx).enforced() || GetDispositionConfig(ctx).Enabled
+
+	content := "# Generated by BillionMail, do not edit\n" +
+		"billionmail {\n" +
+		fmt.Sprintf("  policy_host = %q;\n", inboundPolicyHost) +
+		fmt.Sprintf("  policy_port = %d;\n", inboundPolicyPort) +
+		fmt.Sprintf("  inbound_policy = %t;\n", enabled) +
+		"}\n"
+
+	_, err := public.WriteFile(public.AbsPath(filepath.Join(consts.RSPAMD_LOCAL_D_PATH, "billionmail.conf")), content)
+	return err
+}
+
+// restartRspamd applies the written configuration
+func restartRspamd(ctx context.Context) error {
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	return dk.RestartContainerByName(ctx, consts.SERVICES.Rspamd)
+}
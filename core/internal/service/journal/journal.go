@@ -0,0 +1,158 @@
+package journal
+
+import (
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/relay"
+	"context"
+	"fmt"
+	"net/mail"
+	"path/filepath"
+	"strings"
+)
+
+// -----------------------------
+// Journaling of the mail for legal archiving. For the domains with a journal rule the
+// policy service adds a BCC of every inbound and/or outbound message to an address of
+// the internal journal domain, which the transport map routes to the journal receiver
+// of this service. The receiver wraps each copy in a journal report, the envelope
+// recorded by the policy service (sender, every recipient, client, authentication)
+// followed by the untouched message, and streams it to the archive address of the
+// rule and/or writes it to its store directory, the message is never held in memory.
+// The reports are submitted authenticated as the journal sender, whose transactions
+// are never journaled, so a report reaching a journaled domain is not journaled again.
+// -----------------------------
+
+const journalOptionKey = "mail_journal"
+
+const (
+	// JournalDomain internal domain of the journal copies, never resolved by DNS
+	JournalDomain = "journal.billionmail.invalid"
+
+	ReceiverListenAddr = ":10026"
+	receiverTransport  = "smtp:[core]:10026" // route of JournalDomain as seen by postfix
+
+	// JournalHeader header of the journal reports, its value is the journal token
+	JournalHeader = "X-BillionMail-Journal"
+)
+
+// Directions of the journaled mail
+const (
+	DirectionInbound  = "inbound"
+	DirectionOutbound = "outbound"
+)
+
+// JournalRule journaling of a local domain
+type JournalRule struct {
+	Domain   string `json:"domain"`
+	Inbound  bool   `json:"inbound"`  // messages to the domain
+	Outbound bool   `json:"outbound"` // messages sent by the authenticated users of the domain
+	Address  string `json:"address"`  // archive address the journal reports are mailed to
+	Store    string `json:"store"`    // absolute directory the journal reports are written to
+}
+
+// JournalConfig journaling settings
+type JournalConfig struct {
+	Enabled bool          `json:"enabled"`
+	Sender  string        `json:"sender"` // local mailbox the reports are submitted from, required with an archive address
+	Rules   []JournalRule `json:"rules"`
+}
+
+func init() {
+	relay.RegisterTransport(JournalDomain, receiverTransport)
+}
+
+// GetJournalConfig returns the configured settings, journaling disabled when unset
+func GetJournalConfig(ctx context.Context) JournalConfig {
+	cfg := JournalConfig{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, journalOptionKey, &cfg)
+	return cfg
+}
+
+// SetJournalConfig validates and saves the settings, and routes the journal domain to
+// the receiver
+func SetJournalConfig(ctx context.Context, cfg JournalConfig) error {
+	if err := normalizeConfig(&cfg); err != nil {
+		return err
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, journalOptionKey, cfg); err != nil {
+		return err
+	}
+
+	return SyncJournalToPostfix(ctx)
+}
+
+// SyncJournalToPostfix renders the route of the journal domain to the transport map
+func SyncJournalToPostfix(ctx context.Context) error {
+	return relay.SyncTransportMapToPostfix(ctx)
+}
+
+// normalizeConfig validates the settings and lowercases the domains and addresses
+func normalizeConfig(cfg *JournalConfig) error {
+	cfg.Sender = strings.ToLower(strings.TrimSpace(cfg.Sender))
+	if cfg.Sender != "" {
+		if _, err := mail.ParseAddress(cfg.Sender); err != nil {
+			return fmt.Errorf("invalid journal sender %q", cfg.Sender)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		r.Domain = strings.ToLower(strings.TrimSpace(r.Domain))
+		r.Address = strings.ToLower(strings.TrimSpace(r.Address))
+		r.Store = strings.TrimSpace(r.Store)
+
+		if r.Domain == "" || strings.Contains(r.Domain, "@") {
+			return fmt.Errorf("invalid domain %q", r.Domain)
+		}
+		if seen[r.Domain] {
+			return fmt.Errorf("duplicate journal rule of %s", r.Domain)
+		}
+		seen[r.Domain] = true
+
+		if !r.Inbound && !r.Outbound {
+			return fmt.Errorf("journal rule of %s journals neither direction", r.Domain)
+		}
+		if r.Address == "" && r.Store == "" {
+			return fmt.Errorf("journal rule of %s has neither an archive address nor a store", r.Domain)
+		}
+		if r.Address != "" {
+			if _, err := mail.ParseAddress(r.Address); err != nil {
+				return fmt.Errorf("invalid archive address %q", r.Address)
+			}
+			if r.Address == cfg.Sender {
+				return fmt.Errorf("the archive address of %s is the journal sender", r.Domain)
+			}
+			if cfg.Sender == "" {
+				return fmt.Errorf("a journal sender is required to mail the reports of %s", r.Domain)
+			}
+		}
+		if r.Store != "" {
+			if !filepath.IsAbs(r.Store) {
+				return fmt.Errorf("journal store %q of %s is not an absolute path", r.Store, r.Domain)
+			}
+			r.Store = filepath.Clean(r.Store)
+		}
+	}
+
+	return nil
+}
+
+// rule the rule of domain, nil when it is not journaled
+func (c JournalConfig) rule(domain string) *JournalRule {
+	for i := range c.Rules {
+		if c.Rules[i].Domain == domain {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+// domainOf the domain of an address, lowercased
+func domainOf(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return strings.ToLower(address[i+1:])
+	}
+	return ""
+}
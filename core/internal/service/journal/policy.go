@@ -0,0 +1,243 @@
+package journal
+
+import (
+	"billionmail-core/internal/service/smtp_policy"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// journalTransactionTTL transactions forgotten when they never reached the end of the
+// message
+const journalTransactionTTL = 30 * time.Minute
+
+var tokenPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Envelope the envelope of a journaled message, recorded in bm_mail_journal
+type Envelope struct {
+	Token         string `json:"token"`
+	QueueId       string `json:"queue_id"`
+	Directions    string `json:"directions"` // inbound, outbound or both, comma separated
+	Domains       string `json:"domains"`    // journaled domains, comma separated
+	Sender        string `json:"sender"`
+	Recipients    string `json:"recipients"` // comma separated
+	ClientAddress string `json:"client_address"`
+	ClientName    string `json:"client_name"`
+	SaslUsername  string `json:"sasl_username"`
+	CreateTime    int64  `json:"create_time"`
+	JournalTime   int64  `json:"journal_time"` // 0 until the report was delivered
+}
+
+// journalTransaction the recipients of an SMTP transaction seen by the check
+type journalTransaction struct {
+	token      string
+	recipients []string
+	domains    map[string]bool
+	directions map[string]bool
+	seen       time.Time
+}
+
+var (
+	transactionsMutex sync.Mutex
+	transactions      = make(map[string]*journalTransaction)
+	transactionsSwept time.Time
+)
+
+func init() {
+	// Registered after every check of the policy service, a recipient they refuse is
+	// not part of the envelope and the BCC never replaces their action
+	smtp_policy.RegisterCheck("journal", checkJournal)
+}
+
+// checkJournal collects the recipients of each transaction and adds the journal BCC at
+// the first recipient of a journaled domain
+func checkJournal(ctx context.Context, req smtp_policy.PolicyRequest) string {
+	switch req.Stage() {
+	case "RCPT":
+		return journalRecipient(ctx, req)
+	case "END-OF-MESSAGE":
+		endJournalTransaction(ctx, req)
+	}
+	return smtp_policy.ActionDunno
+}
+
+func journalRecipient(ctx context.Context, req smtp_policy.PolicyRequest) string {
+	cfg := GetJournalConfig(ctx)
+	if !cfg.Enabled || len(cfg.Rules) == 0 {
+		return smtp_policy.ActionDunno
+	}
+
+	instance := req.Get("instance")
+	sasl := strings.ToLower(req.Get("sasl_username"))
+	// The journal reports are never journaled
+	if instance == "" || (sasl != "" && sasl == cfg.Sender) {
+		return smtp_policy.ActionDunno
+	}
+
+	sender := strings.ToLower(req.Get("sender"))
+	recipient := strings.ToLower(req.Get("recipient"))
+
+	var domains, directions []string
+	if r := cfg.rule(domainOf(recipient)); r != nil && r.Inbound {
+		domains = append(domains, r.Domain)
+		directions = append(directions, DirectionInbound)
+	}
+	if r := cfg.rule(domainOf(sender)); r != nil && r.Outbound && sasl != "" {
+		domains = append(domains, r.Domain)
+		directions = append(directions, DirectionOutbound)
+	}
+
+	transactionsMutex.Lock()
+	defer transactionsMutex.Unlock()
+
+	sweepJournalTransactions()
+
+	t := transactions[instance]
+	if t == nil {
+		t = &journalTransaction{domains: make(map[string]bool), directions: make(map[string]bool)}
+		transactions[instance] = t
+	}
+	t.recipients = append(t.recipients, recipient)
+	t.seen = time.Now()
+	for i := range domains {
+		t.domains[domains[i]] = true
+		t.directions[directions[i]] = true
+	}
+
+	if len(t.domains) == 0 {
+		return smtp_policy.ActionDunno
+	}
+
+	env := Envelope{
+		Directions: joinSet(t.directions),
+		Domains:    joinSet(t.domains),
+		Recipients: strings.Join(t.recipients, ","),
+	}
+
+	if t.token != "" {
+		// The transaction has its BCC, the envelope gains the recipient
+		_, err := g.DB().Model("bm_mail_journal").Ctx(ctx).
+			Data(g.Map{"directions": env.Directions, "domains": env.Domains, "recipients": env.Recipients}).
+			Where("token", t.token).
+			Update()
+		if err != nil {
+			g.Log().Warningf(ctx, "Failed to update the journal envelope %s: %v", t.token, err)
+		}
+		return smtp_policy.ActionDunno
+	}
+
+	token, err := newToken()
+	if err != nil {
+		g.Log().Warning(ctx, "Failed to generate a journal token: ", err)
+		return smtp_policy.ActionDunno
+	}
+
+	env.Token = token
+	env.Sender = sender
+	env.ClientAddress = req.Get("client_address")
+	env.ClientName = req.Get("client_name")
+	env.SaslUsername = sasl
+	env.CreateTime = time.Now().Unix()
+
+	if _, err = g.DB().Model("bm_mail_journal").Ctx(ctx).Insert(env); err != nil {
+		// Without its envelope the copy could not be journaled
+		g.Log().Warningf(ctx, "Failed to record the journal envelope of %s: %v", sender, err)
+		return smtp_policy.ActionDunno
+	}
+
+	t.token = token
+	return "BCC " + journalAddress(token)
+}
+
+// endJournalTransaction records the queue ID of a journaled message
+func endJournalTransaction(ctx context.Context, req smtp_policy.PolicyRequest) {
+	instance := req.Get("instance")
+
+	transactionsMutex.Lock()
+	t := transactions[instance]
+	delete(transactions, instance)
+	transactionsMutex.Unlock()
+
+	if t == nil || t.token == "" {
+		return
+	}
+
+	_, err := g.DB().Model("bm_mail_journal").Ctx(ctx).
+		Data(g.Map{"queue_id": req.Get("queue_id")}).
+		Where("token", t.token).
+		Update()
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to update the journal envelope %s: %v", t.token, err)
+	}
+}
+
+// sweepJournalTransactions forgets the transactions that never ended, the caller holds
+// the mutex
+func sweepJournalTransactions() {
+	now := time.Now()
+	if now.Sub(transactionsSwept) < time.Minute {
+		return
+	}
+	transactionsSwept = now
+
+	for instance, t := range transactions {
+		if now.Sub(t.seen) > journalTransactionTTL {
+			delete(transactions, instance)
+		}
+	}
+}
+
+// journalAddress the address of the journal copy of token
+func journalAddress(token string) string {
+	return "journal+" + token + "@" + JournalDomain
+}
+
+// tokenOf the token of a journal address, empty when it is not one
+func tokenOf(address string) string {
+	local, domain, ok := strings.Cut(strings.ToLower(address), "@")
+	if !ok || domain != JournalDomain {
+		return ""
+	}
+	token, ok := strings.CutPrefix(local, "journal+")
+	if !ok || !tokenPattern.MatchString(token) {
+		return ""
+	}
+	return token
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// joinSet the sorted, comma separated members of set
+func joinSet(set map[string]bool) string {
+	members := make([]string, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return strings.Join(members, ",")
+}
+
+// loadEnvelope the envelope of token, nil when there is none
+func loadEnvelope(ctx context.Context, token string) (*Envelope, error) {
+	var env *Envelope
+	err := g.DB().Model("bm_mail_journal").Ctx(ctx).Where("token", token).Scan(&env)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return env, err
+}
@@ -0,0 +1,198 @@
+package journal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Receiver of the journal copies: a minimal SMTP server postfix delivers the journal
+// domain to. A transaction carries one copy, a further recipient is deferred to its own
+// transaction. The copy is accepted once its report was delivered to every target, a
+// failure defers it and postfix retries.
+
+const receiverIdleTimeout = 5 * time.Minute
+
+var receiverOnce sync.Once
+
+// Start listens for the journal copies, it is a no-op when already started
+func Start(ctx context.Context) {
+	receiverOnce.Do(func() {
+		ln, err := net.Listen("tcp", ReceiverListenAddr)
+		if err != nil {
+			g.Log().Warning(ctx, "Start mail journal receiver failed: ", err)
+			return
+		}
+
+		g.Log().Infof(ctx, "Mail journal receiver listening on %s", ReceiverListenAddr)
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					g.Log().Warning(ctx, "Mail journal receiver accept failed: ", err)
+					time.Sleep(time.Second)
+					continue
+				}
+
+				go serveReceiver(ctx, conn)
+			}
+		}()
+	})
+}
+
+// serveReceiver handles the SMTP session of one postfix connection
+func serveReceiver(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...any) bool {
+		return tp.PrintfLine(format, args...) == nil
+	}
+
+	if !reply("220 %s ESMTP journal", JournalDomain) {
+		return
+	}
+
+	var token string
+	for {
+		_ = conn.SetDeadline(time.Now().Add(receiverIdleTimeout))
+
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if !reply("250-%s\r\n250-8BITMIME\r\n250 ENHANCEDSTATUSCODES", JournalDomain) {
+				return
+			}
+		case "HELO":
+			if !reply("250 %s", JournalDomain) {
+				return
+			}
+		case "MAIL":
+			token = ""
+			if !reply("250 2.1.0 Ok") {
+				return
+			}
+		case "RCPT":
+			var ok bool
+			token, ok = receiverRecipient(ctx, reply, token, arg)
+			if !ok {
+				return
+			}
+		case "DATA":
+			if token == "" {
+				if !reply("503 5.5.1 No valid recipient") {
+					return
+				}
+				continue
+			}
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+
+			dr := tp.DotReader()
+			err := receiveCopy(ctx, token, dr)
+			// What the journaling left unread is drained up to the end of the data
+			if _, drainErr := io.Copy(io.Discard, dr); drainErr != nil {
+				return
+			}
+			token = ""
+
+			if err != nil {
+				g.Log().Warningf(ctx, "Failed to journal a message: %v", err)
+				if !reply("451 4.3.0 Journaling failed, try again later") {
+					return
+				}
+				continue
+			}
+			if !reply("250 2.0.0 Ok: journaled") {
+				return
+			}
+		case "RSET":
+			token = ""
+			if !reply("250 2.0.0 Ok") {
+				return
+			}
+		case "NOOP":
+			if !reply("250 2.0.0 Ok") {
+				return
+			}
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			if !reply("502 5.5.2 Command not recognized") {
+				return
+			}
+		}
+	}
+}
+
+// receiverRecipient answers a RCPT command, it returns the token of the transaction and
+// whether the session goes on
+func receiverRecipient(ctx context.Context, reply func(string, ...any) bool, token, arg string) (string, bool) {
+	address := arg
+	if i := strings.Index(address, "<"); i >= 0 {
+		address = address[i+1:]
+		if j := strings.Index(address, ">"); j >= 0 {
+			address = address[:j]
+		}
+	}
+
+	if token != "" {
+		return token, reply("452 4.5.3 One journal copy per transaction")
+	}
+
+	rcptToken := tokenOf(address)
+	if rcptToken == "" {
+		return "", reply("550 5.1.1 Unknown journal recipient")
+	}
+
+	env, err := loadEnvelope(ctx, rcptToken)
+	if err != nil {
+		return "", reply("451 4.3.0 Journal envelope unavailable")
+	}
+	if env == nil {
+		g.Log().Warningf(ctx, "Journal copy %s without envelope rejected", rcptToken)
+		return "", reply("550 5.1.1 Unknown journal recipient")
+	}
+
+	return rcptToken, reply("250 2.1.5 Ok")
+}
+
+// receiveCopy journals the copy of token read from r and marks its envelope journaled
+func receiveCopy(ctx context.Context, token string, r io.Reader) error {
+	env, err := loadEnvelope(ctx, token)
+	if err != nil {
+		return err
+	}
+	if env == nil {
+		return fmt.Errorf("journal envelope %s not found", token)
+	}
+
+	if err = journalMessage(ctx, GetJournalConfig(ctx), *env, r); err != nil {
+		return fmt.Errorf("journal %s: %w", token, err)
+	}
+
+	_, err = g.DB().Model("bm_mail_journal").Ctx(ctx).
+		Data(g.Map{"journal_time": time.Now().Unix()}).
+		Where("token", token).
+		Update()
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to mark the journal envelope %s: %v", token, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package journal
+
+import (
+	"billionmail-core/internal/service/mail_service"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxHeaderBytes header of a journaled message kept to describe it in the report, the
+// rest of the message is streamed
+const maxHeaderBytes = 256 << 10
+
+// journalTargets where the report of a message goes, from the rules of its domains
+type journalTargets struct {
+	addresses []string
+	stores    []string
+}
+
+// targetsOf the archive addresses and stores of the journaled domains of env
+func (c JournalConfig) targetsOf(env Envelope) journalTargets {
+	var t journalTargets
+	seen := make(map[string]bool)
+
+	for _, domain := range strings.Split(env.Domains, ",") {
+		r := c.rule(domain)
+		if r == nil {
+			continue
+		}
+		if r.Address != "" && !seen["a:"+r.Address] {
+			seen["a:"+r.Address] = true
+			t.addresses = append(t.addresses, r.Address)
+		}
+		if r.Store != "" && !seen["s:"+r.Store] {
+			seen["s:"+r.Store] = true
+			t.stores = append(t.stores, r.Store)
+		}
+	}
+
+	return t
+}
+
+// journalMessage delivers the journal report of the message read from r to the
+// targets of env. The stores are written first, a failure of the archive address
+// fails the whole delivery so postfix retries it, the stores are then rewritten
+func journalMessage(ctx context.Context, cfg JournalConfig, env Envelope, r io.Reader) (err error) {
+	targets := cfg.targetsOf(env)
+	if len(targets.addresses) == 0 && len(targets.stores) == 0 {
+		return fmt.Errorf("no journal rule of %s", env.Domains)
+	}
+
+	header, body, err := splitHeader(r)
+	if err != nil {
+		return err
+	}
+
+	// The stores get the report as it is written, through temporary files renamed once
+	// the report is complete
+	day := time.Unix(env.CreateTime, 0).Format(time.DateOnly)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+			if err != nil {
+				_ = os.Remove(f.Name())
+			}
+		}
+	}()
+
+	var writers []io.Writer
+	for _, store := range targets.stores {
+		dir := filepath.Join(store, day)
+		if err = os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+		var f *os.File
+		if f, err = os.CreateTemp(dir, "."+env.Token+"-*.tmp"); err != nil {
+			return err
+		}
+		files = append(files, f)
+		writers = append(writers, f)
+	}
+
+	write := func(w io.Writer) error {
+		return writeReport(io.MultiWriter(append(writers, w)...), cfg, env, targets.addresses, header, body)
+	}
+
+	if len(targets.addresses) > 0 {
+		var sender *mail_service.EmailSender
+		if sender, err = mail_service.NewEmailSenderWithLocal(cfg.Sender); err != nil {
+			return fmt.Errorf("journal sender %s: %w", cfg.Sender, err)
+		}
+		defer sender.Close()
+
+		if err = sender.SendStream(targets.addresses, write); err != nil {
+			return err
+		}
+	} else if err = write(io.Discard); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err = f.Sync(); err != nil {
+			return err
+		}
+		if err = os.Rename(f.Name(), filepath.Join(filepath.Dir(f.Name()), env.Token+".eml")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitHeader reads the header of a message, up to maxHeaderBytes, and returns it with
+// a reader of the rest
+func splitHeader(r io.Reader) ([]byte, io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	var header bytes.Buffer
+	for header.Len() < maxHeaderBytes {
+		line, err := br.ReadSlice('\n')
+		header.Write(line)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, nil, err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+	}
+
+	return header.Bytes(), br, nil
+}
+
+// writeReport writes the journal report: the envelope of the message followed by the
+// message as it was received
+func writeReport(w io.Writer, cfg JournalConfig, env Envelope, to []string, header []byte, body io.Reader) error {
+	original, _ := mail.ReadMessage(bytes.NewReader(append(append([]byte(nil), header...), "\r\n\r\n"...)))
+	var subject, messageId string
+	if original != nil {
+		subject = original.Header.Get("Subject")
+		messageId = original.Header.Get("Message-Id")
+	}
+
+	mw := multipart.NewWriter(w)
+
+	reportSubject := "Journal report"
+	if subject != "" {
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+			subject = decoded
+		}
+		reportSubject += ": " + subject
+	}
+
+	var h strings.Builder
+	from := cfg.Sender
+	if from == "" {
+		from = "journal@" + JournalDomain
+	}
+	fmt.Fprintf(&h, "From: Journal <%s>\r\n", from)
+	if len(to) > 0 {
+		fmt.Fprintf(&h, "To: %s\r\n", strings.Join(to, ", "))
+	}
+	fmt.Fprintf(&h, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", reportSubject))
+	fmt.Fprintf(&h, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&h, "Message-ID: <%s@%s>\r\n", env.Token, JournalDomain)
+	fmt.Fprintf(&h, "%s: %s\r\n", JournalHeader, env.Token)
+	h.WriteString("Auto-Submitted: auto-generated\r\n")
+	h.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&h, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n\r\n", mw.Boundary())
+	if _, err := io.WriteString(w, h.String()); err != nil {
+		return err
+	}
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(part, envelopeText(env, messageId)); err != nil {
+		return err
+	}
+
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"message/rfc822"},
+		"Content-Disposition": {`attachment; filename="message.eml"`},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = part.Write(header); err != nil {
+		return err
+	}
+	if _, err = io.Copy(part, body); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(part, "\r\n"); err != nil {
+		return err
+	}
+
+	return mw.Close()
+}
+
+// envelopeText the envelope part of a report, one field per line
+func envelopeText(env Envelope, messageId string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Sender: %s\r\n", env.Sender)
+	if messageId != "" {
+		fmt.Fprintf(&b, "Message-ID: %s\r\n", messageId)
+	}
+	for _, rcpt := range strings.Split(env.Recipients, ",") {
+		if rcpt != "" {
+			fmt.Fprintf(&b, "To: %s\r\n", rcpt)
+		}
+	}
+	fmt.Fprintf(&b, "Direction: %s\r\n", env.Directions)
+	fmt.Fprintf(&b, "Journaled-Domains: %s\r\n", env.Domains)
+	if env.QueueId != "" {
+		fmt.Fprintf(&b, "Queue-ID: %s\r\n", env.QueueId)
+	}
+	fmt.Fprintf(&b, "Client: %s [%s]\r\n", env.ClientName, env.ClientAddress)
+	if env.SaslUsername != "" {
+		fmt.Fprintf(&b, "Authenticated-As: %s\r\n", env.SaslUsername)
+	}
+	fmt.Fprintf(&b, "Received: %s\r\n", time.Unix(env.CreateTime, 0).UTC().Format(time.RFC1123Z))
+	return b.String()
+}
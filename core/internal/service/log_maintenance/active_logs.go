@@ -0,0 +1,97 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gregex"
+)
+
+// Active logs: the log a writer currently appends to is never compressed nor deleted. It
+// is identified explicitly when possible, by the ActiveLog hook of the configuration,
+// which asks the logger for its current target by default, or by the ActiveLink symlink
+// of its group pointing at it. Without an explicit signal the protected window and the
+// newest-log floor of the retention apply as before. The link itself is never archived.
+
+// ActiveLogFunc returns the path of the log of group being written in dir, empty when it
+// cannot tell
+type ActiveLogFunc func(dir string, group LogGroup) string
+
+// LoggerActiveLog the file the logger of the server currently writes, when it writes to
+// dir and its file name belongs to group
+func LoggerActiveLog(dir string, group LogGroup) string {
+	cfg := g.Log().GetConfig()
+	if cfg.Path == "" || cfg.File == "" || !samePath(cfg.Path, dir) {
+		return ""
+	}
+
+	// As the logger names its file
+	name, err := gregex.ReplaceStringFunc(`{.+?}`, cfg.File, func(s string) string {
+		return gtime.New(timeNow()).Format(strings.Trim(s, "{}"))
+	})
+	if err != nil || group.Pattern == nil || !group.Pattern.MatchString(name) {
+		return ""
+	}
+
+	return filepath.Join(dir, name)
+}
+
+func samePath(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
+
+// activeLogs the logs of dir being written, by cleaned path
+func (m *maintenanceRun) activeLogs(ctx context.Context, dir string) map[string]bool {
+	groups := m.logGroups
+	if groups == nil {
+		groups = defaultLogGroups
+	}
+
+	active := make(map[string]bool)
+	for _, group := range groups {
+		if m.cfg.ActiveLog != nil {
+			if path := m.cfg.ActiveLog(dir, group); path != "" {
+				active[filepath.Clean(path)] = true
+			}
+		}
+
+		if group.ActiveLink == "" {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(dir, group.ActiveLink))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				g.Log().Warningf(ctx, "Active log link %s of group %s unreadable: %v", group.ActiveLink, group.Name, err)
+			}
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		active[filepath.Clean(target)] = true
+	}
+
+	return active
+}
+
+// isActiveLink reports whether the file is the active log link of a group
+func (m *maintenanceRun) isActiveLink(path string) bool {
+	groups := m.logGroups
+	if groups == nil {
+		groups = defaultLogGroups
+	}
+
+	name := filepath.Base(path)
+	for _, group := range groups {
+		if group.ActiveLink != "" && group.ActiveLink == name {
+			return true
+		}
+	}
+	return false
+}
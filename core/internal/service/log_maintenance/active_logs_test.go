@@ -0,0 +1,96 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+func TestActiveLogNeverCompressed(t *testing.T) {
+	base := t.TempDir()
+	older := newStandardLog(t, base, "access-20200101.log", []byte("older\n"))
+	linked := newStandardLog(t, base, "access-20200102.log", []byte("written through the link\n"))
+	newest := newStandardLog(t, base, "access-20200103.log", []byte("newest\n"))
+	hooked := newStandardLog(t, base, "error-20200101.log", []byte("written per the logger\n"))
+	dir := filepath.Dir(older)
+
+	// The writer of the access logs appends to an older file than the newest one
+	if err := os.Symlink("access-20200102.log", filepath.Join(dir, "current.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	groups := DefaultLogGroups()
+	groups[0].ActiveLink = "current.log"
+	groups = append(groups, LogGroup{Name: "current", Pattern: regexp.MustCompile(`^current\.log$`)})
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{
+		BasePath:  base,
+		LogGroups: groups,
+		Retention: RetentionPolicy{FilesToKeep: 1},
+		ActiveLog: func(d string, group LogGroup) string {
+			if group.Name == "error" && d == dir {
+				return hooked
+			}
+			return ""
+		},
+	})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	for _, path := range []string{linked, hooked} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("active log %s should be left alone: %v", path, err)
+		}
+		if _, err := os.Stat(path + ".gz"); !os.IsNotExist(err) {
+			t.Errorf("active log %s should not be archived, stat err: %v", path, err)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "current.log")); err != nil || target != "access-20200102.log" {
+		t.Errorf("active link should be kept: %q, %v", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "current.log.gz")); !os.IsNotExist(err) {
+		t.Errorf("active link should not be archived, stat err: %v", err)
+	}
+
+	// Without a signal the retention applies, the older log beyond the kept one is deleted
+	if _, err := os.Stat(older); !os.IsNotExist(err) {
+		t.Errorf("older log should be deleted, stat err: %v", err)
+	}
+	if _, err := os.Stat(newest + ".gz"); err != nil {
+		t.Errorf("newest log should be archived: %v", err)
+	}
+}
+
+func TestLoggerActiveLog(t *testing.T) {
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	timeNow = func() time.Time { return time.Date(2025, 3, 31, 15, 30, 0, 0, time.Local) }
+
+	dir := t.TempDir()
+	logger := g.Log()
+	orig := logger.GetConfig()
+	defer func() { _ = logger.SetConfig(orig) }()
+
+	cfg := orig
+	cfg.Path = dir
+	cfg.File = "{Y-m-d}.log"
+	if err := logger.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	date := DefaultLogGroups()[3]
+	if got := LoggerActiveLog(dir, date); got != filepath.Join(dir, "2025-03-31.log") {
+		t.Errorf("active log of the date group %q", got)
+	}
+	if got := LoggerActiveLog(dir, DefaultLogGroups()[0]); got != "" {
+		t.Errorf("active log of the access group %q", got)
+	}
+	if got := LoggerActiveLog(t.TempDir(), date); got != "" {
+		t.Errorf("active log of another directory %q", got)
+	}
+}
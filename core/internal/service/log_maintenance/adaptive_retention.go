@@ -0,0 +1,186 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Adaptive retention of the standard logs: rather than a fixed number of logs per group,
+// the history kept is what fits on the log volume with AdaptiveHeadroom bytes left free.
+// The FilesToKeep counts no longer delete anything, every old log is compressed, then
+// the archives of the standard logs are deleted oldest first only while the free space
+// is below the headroom. The archives younger than AdaptiveMinHistory, and the logs of
+// the protected window, are kept whatever the free space: the floor wins over the
+// headroom. MaxAge still deletes the logs past it. The operation log archives, the audit
+// trail, are never deleted by it.
+
+// DefaultAdaptiveMinHistory history always kept by the adaptive retention
+const DefaultAdaptiveMinHistory = 7 * 24 * time.Hour
+
+// AdaptiveRetentionResult outcome of the adaptive retention of a run
+type AdaptiveRetentionResult struct {
+	Headroom  int64 `json:"headroom"`
+	FreeBytes int64 `json:"free_bytes"` // free space once done
+	Deleted   int   `json:"deleted"`
+	Freed     int64 `json:"freed"`
+
+	// RetainedSince date of the oldest standard log archive kept, RetainedWindow the
+	// history it makes. Zero when no archive is kept
+	RetainedSince  time.Time     `json:"retained_since"`
+	RetainedWindow time.Duration `json:"retained_window"`
+
+	// Floor the headroom was not met, the archives left are within AdaptiveMinHistory
+	Floor bool `json:"floor,omitempty"`
+}
+
+// adaptive reports whether the adaptive retention replaces the FilesToKeep counts
+func (m *maintenanceRun) adaptive() bool {
+	return m.cfg.AdaptiveHeadroom > 0
+}
+
+// adaptiveMinHistory the configured floor, DefaultAdaptiveMinHistory when unset
+func (m *maintenanceRun) adaptiveMinHistory() time.Duration {
+	if m.cfg.AdaptiveMinHistory <= 0 {
+		return DefaultAdaptiveMinHistory
+	}
+	return m.cfg.AdaptiveMinHistory
+}
+
+// datedArchive an archive of the sink with the date of its content
+type datedArchive struct {
+	name string
+	date time.Time
+}
+
+// archivesOldestFirst the archives of the sink accepted by keep, sorted oldest first.
+// The undated archives come last, they are kept the longest
+func (m *maintenanceRun) archivesOldestFirst(ctx context.Context, sink *LocalSink, keep func(name string) bool) ([]datedArchive, error) {
+	names, err := sink.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make([]datedArchive, 0, len(names))
+	for _, name := range names {
+		if inQuarantine(name) || !keep(name) {
+			continue
+		}
+		date, _ := m.archiveDate(ctx, name)
+		archives = append(archives, datedArchive{name: name, date: date})
+	}
+
+	sort.SliceStable(archives, func(i, j int) bool {
+		di, dj := archives[i].date, archives[j].date
+		if di.IsZero() != dj.IsZero() {
+			return dj.IsZero()
+		}
+		return di.Before(dj)
+	})
+
+	return archives, nil
+}
+
+// standardLogArchive reports the archives of the standard logs and their rollups, the
+// archives of directories are those of the operation logs and the artifacts
+func standardLogArchive(name string) bool {
+	if strings.HasSuffix(name, rollupExt) {
+		return true
+	}
+	_, _, dir, ok := SplitArchiveName(name)
+	return ok && !dir
+}
+
+// adaptiveCleanup deletes the oldest standard log archives while the free space is below
+// the headroom, down to the floor
+func (m *maintenanceRun) adaptiveCleanup(ctx context.Context) *AdaptiveRetentionResult {
+	result := &AdaptiveRetentionResult{Headroom: m.cfg.AdaptiveHeadroom}
+
+	free, err := diskFree(m.cfg.BasePath)
+	if err != nil {
+		g.Log().Warningf(ctx, "Adaptive retention: failed to check the free space of %s: %v", m.cfg.BasePath, err)
+		return nil
+	}
+
+	// Deleting remote archives frees nothing here
+	sink, ok := m.cfg.Sink.(*LocalSink)
+	if !ok {
+		g.Log().Warningf(ctx, "Adaptive retention: archives are not stored locally, nothing is deleted")
+		result.FreeBytes = free
+		return result
+	}
+
+	archives, err := m.archivesOldestFirst(ctx, sink, standardLogArchive)
+	if err != nil {
+		g.Log().Errorf(ctx, "Adaptive retention: failed to list the archives: %v", err)
+		m.fail(ErrScan, sink.Root, err)
+		return nil
+	}
+
+	floor := timeNow().Add(-m.adaptiveMinHistory())
+	kept := archives[:0:0]
+
+	for i, a := range archives {
+		if free >= m.cfg.AdaptiveHeadroom || ctx.Err() != nil {
+			kept = append(kept, archives[i:]...)
+			break
+		}
+
+		if a.date.IsZero() || !a.date.Before(floor) {
+			result.Floor = true
+			kept = append(kept, archives[i:]...)
+			break
+		}
+
+		path, err := sink.Path(a.name)
+		if err != nil || m.archivePinned(a.name) {
+			kept = append(kept, a)
+			continue
+		}
+		var size int64
+		if info, err := os.Stat(path); err == nil {
+			size = info.Size()
+		}
+
+		if err := m.deleteArchive(ctx, a.name); err != nil {
+			g.Log().Errorf(ctx, "Adaptive retention: failed to delete %s: %v", a.name, err)
+			m.fail(ErrDelete, a.name, err)
+			kept = append(kept, a)
+			continue
+		}
+
+		g.Log().Infof(ctx, "Adaptive retention: deleted %s (%d bytes), %d bytes free below the %d bytes headroom",
+			a.name, size, free, m.cfg.AdaptiveHeadroom)
+		m.audit(ctx, AuditDelete, path, size, fmt.Sprintf("the log volume keeps %d bytes free", m.cfg.AdaptiveHeadroom), "")
+		result.Deleted++
+		result.Freed += size
+		m.bytesReclaimed.Add(size)
+
+		if now, err := diskFree(m.cfg.BasePath); err == nil {
+			free = now
+		} else {
+			free += size
+		}
+	}
+	result.FreeBytes = free
+
+	for _, a := range kept {
+		if !a.date.IsZero() {
+			result.RetainedSince = a.date
+			result.RetainedWindow = timeNow().Sub(a.date).Round(time.Hour)
+			break
+		}
+	}
+
+	if result.Floor {
+		g.Log().Warningf(ctx, "Adaptive retention: %d bytes free, below the %d bytes headroom, the archives left are within the %s minimum history",
+			free, m.cfg.AdaptiveHeadroom, m.adaptiveMinHistory())
+	}
+
+	return result
+}
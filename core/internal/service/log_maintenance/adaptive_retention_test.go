@@ -0,0 +1,65 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRetention(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "core")
+
+	recent := "access-" + time.Now().AddDate(0, 0, -1).Format("20060102") + ".log.gz"
+	archives := []string{"access-20250101.log.gz", "access-20250102.log.gz", "access-20250103.log.gz", "access-20250104.log.gz", recent}
+	for _, name := range archives {
+		newStandardLog(t, base, name, []byte("archive"))
+	}
+	// More logs than the default count kept, the adaptive retention compresses them all
+	for i := 0; i < standardLogsKept+5; i++ {
+		newStandardLog(t, base, "error-"+time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n"))
+	}
+
+	// Every deleted archive of the initial ones frees 1000 bytes
+	defer func(orig func(string) (int64, error)) { diskFree = orig }(diskFree)
+	diskFree = func(string) (int64, error) {
+		free := int64(0)
+		for _, name := range archives {
+			if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+				free += 1000
+			}
+		}
+		return free, nil
+	}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, AdaptiveHeadroom: 2500})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if r.Adaptive == nil || r.Adaptive.Deleted != 3 || r.Adaptive.FreeBytes != 3000 || r.Adaptive.Floor {
+		t.Fatalf("adaptive retention = %+v", r.Adaptive)
+	}
+	if want := time.Date(2025, 1, 4, 0, 0, 0, 0, time.Local); !r.Adaptive.RetainedSince.Equal(want) {
+		t.Errorf("retained since %s, want %s", r.Adaptive.RetainedSince, want)
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "error-*.log"))
+	compressed, _ := filepath.Glob(filepath.Join(dir, "error-*.log.gz"))
+	if len(logs) != 0 || len(compressed) != standardLogsKept+5 {
+		t.Errorf("%d logs left uncompressed, %d compressed, want every log compressed", len(logs), len(compressed))
+	}
+
+	// The headroom cannot be met, the floor keeps the recent archive
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, AdaptiveHeadroom: 1 << 40})
+	if r.Adaptive == nil || !r.Adaptive.Floor {
+		t.Fatalf("adaptive retention = %+v", r.Adaptive)
+	}
+	if _, err := os.Stat(filepath.Join(dir, recent)); err != nil {
+		t.Errorf("the archive within the minimum history should be kept: %v", err)
+	}
+	if r.Adaptive.RetainedWindow > 48*time.Hour {
+		t.Errorf("retained window %s, only the recent archive should be left", r.Adaptive.RetainedWindow)
+	}
+}
@@ -0,0 +1,185 @@
+package log_maintenance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata of the archives of the standard logs, for the listings that should not
+// decompress every archive. The lines are counted and their timestamps noted while the
+// log is streamed into its archive, and the totals are recorded in the manifest after
+// the checksum line, as a comment line sha256sum ignores:
+//
+//	# lines=1250 bytes=98304 first=2025-03-01T00:00:02Z last=2025-03-01T23:59:58Z
+//
+// The counts are those of the archived content, after redaction. The timestamps are
+// looked for at the start of each line, a log without any has none.
+
+const metadataPrefix = "# "
+
+// metadataProbeBytes beginning of each line searched for its timestamp
+const metadataProbeBytes = 64
+
+var errNoMetadata = errors.New("archive has no metadata")
+
+// LogArchiveMetadata content of the log stored in an archive
+type LogArchiveMetadata struct {
+	Lines          int64     `json:"lines"`
+	Bytes          int64     `json:"bytes"` // uncompressed size
+	FirstTimestamp time.Time `json:"first_timestamp"`
+	LastTimestamp  time.Time `json:"last_timestamp"`
+}
+
+// ArchiveMetadata returns the metadata recorded for an archive of the scheduled
+// maintenance, path is its name or its absolute path within the logs tree. It fails
+// when the archive has no manifest or a manifest without metadata
+func ArchiveMetadata(ctx context.Context, path string) (LogArchiveMetadata, error) {
+	cfg := DefaultService().Config()
+
+	name := filepath.ToSlash(path)
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(cfg.BasePath, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return LogArchiveMetadata{}, fmt.Errorf("archive %s is outside of the logs tree", path)
+		}
+		name = filepath.ToSlash(rel)
+	}
+
+	m := newMaintenanceRun(ctx, cfg)
+	if m.cfg.Sink == nil {
+		m.cfg.Sink = NewLocalSink(cfg.BasePath)
+	}
+
+	meta, ok, err := m.readMetadata(ctx, name)
+	if err != nil {
+		return LogArchiveMetadata{}, err
+	}
+	if !ok {
+		return LogArchiveMetadata{}, fmt.Errorf("%s: %w", name, errNoMetadata)
+	}
+	return meta, nil
+}
+
+// metadataWriter gathers the metadata of the content written to it
+type metadataWriter struct {
+	meta LogArchiveMetadata
+	loc  *time.Location
+
+	head    []byte // beginning of the current line
+	pending bool   // the current line has content
+}
+
+func newMetadataWriter(loc *time.Location) *metadataWriter {
+	return &metadataWriter{loc: loc, head: make([]byte, 0, metadataProbeBytes)}
+}
+
+func (w *metadataWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	w.meta.Bytes += int64(n)
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		line := p
+		if i >= 0 {
+			line = p[:i]
+		}
+
+		if room := metadataProbeBytes - len(w.head); room > 0 {
+			w.head = append(w.head, line[:min(room, len(line))]...)
+		}
+		w.pending = w.pending || len(line) > 0
+
+		if i < 0 {
+			break
+		}
+		w.endLine()
+		p = p[i+1:]
+	}
+
+	return n, nil
+}
+
+// endLine accounts the current line
+func (w *metadataWriter) endLine() {
+	w.meta.Lines++
+
+	if match := logContentDatePattern.Find(w.head); match != nil {
+		if t, ok := parseLogTimestamp(string(match), w.loc); ok {
+			if w.meta.FirstTimestamp.IsZero() {
+				w.meta.FirstTimestamp = t
+			}
+			w.meta.LastTimestamp = t
+		}
+	}
+
+	w.head = w.head[:0]
+	w.pending = false
+}
+
+// metadata the metadata of the whole content, a last line without line ending counts
+func (w *metadataWriter) metadata() *LogArchiveMetadata {
+	if w.pending {
+		w.endLine()
+	}
+	meta := w.meta
+	return &meta
+}
+
+// metadataLine the manifest line of meta
+func metadataLine(meta LogArchiveMetadata) string {
+	line := fmt.Sprintf("%slines=%d bytes=%d", metadataPrefix, meta.Lines, meta.Bytes)
+	if !meta.FirstTimestamp.IsZero() {
+		line += " first=" + meta.FirstTimestamp.Format(time.RFC3339)
+		line += " last=" + meta.LastTimestamp.Format(time.RFC3339)
+	}
+	return line + "\n"
+}
+
+// parseMetadata reads the metadata line of a manifest, ok is false when it has none
+func parseMetadata(manifest []byte) (meta LogArchiveMetadata, ok bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		line, found := strings.CutPrefix(scanner.Text(), metadataPrefix)
+		if !found {
+			continue
+		}
+
+		for _, field := range strings.Fields(line) {
+			key, value, _ := strings.Cut(field, "=")
+			var err error
+			switch key {
+			case "lines":
+				meta.Lines, err = strconv.ParseInt(value, 10, 64)
+			case "bytes":
+				meta.Bytes, err = strconv.ParseInt(value, 10, 64)
+			case "first":
+				meta.FirstTimestamp, err = time.Parse(time.RFC3339, value)
+			case "last":
+				meta.LastTimestamp, err = time.Parse(time.RFC3339, value)
+			}
+			if err != nil {
+				return LogArchiveMetadata{}, false
+			}
+		}
+		return meta, true
+	}
+	return LogArchiveMetadata{}, false
+}
+
+// readMetadata returns the metadata recorded in the manifest of an archive
+func (m *maintenanceRun) readMetadata(ctx context.Context, name string) (LogArchiveMetadata, bool, error) {
+	data, ok, err := m.readManifestData(ctx, name)
+	if err != nil || !ok {
+		return LogArchiveMetadata{}, false, err
+	}
+
+	meta, ok := parseMetadata(data)
+	return meta, ok, nil
+}
@@ -0,0 +1,51 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestArchiveMetadata(t *testing.T) {
+	base := t.TempDir()
+	content := "2025-03-01 00:00:02 [INFO] started\n" +
+		"  continued without timestamp\n" +
+		"2025-03-01T23:59:58 [WARN] stopping\n" +
+		"trailing line without line ending"
+	path := newStandardLog(t, base, "error-20250301.log", []byte(content))
+	newStandardLog(t, base, "error-20250302.log", []byte("newest\n"))
+
+	cfg := MaintenanceConfig{BasePath: base, Location: time.UTC}
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base)})
+	meta, ok, err := m.readMetadata(context.Background(), "core/error-20250301.log.gz")
+	if err != nil || !ok {
+		t.Fatalf("metadata %v, %v", ok, err)
+	}
+	want := LogArchiveMetadata{
+		Lines:          4,
+		Bytes:          int64(len(content)),
+		FirstTimestamp: time.Date(2025, 3, 1, 0, 0, 2, 0, time.UTC),
+		LastTimestamp:  time.Date(2025, 3, 1, 23, 59, 58, 0, time.UTC),
+	}
+	if !meta.FirstTimestamp.Equal(want.FirstTimestamp) || !meta.LastTimestamp.Equal(want.LastTimestamp) || meta.Lines != want.Lines || meta.Bytes != want.Bytes {
+		t.Errorf("metadata %+v, want %+v", meta, want)
+	}
+
+	// The manifest still verifies
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("log not compressed")
+	}
+	if v := RunVerification(context.Background(), VerifyConfig{BasePath: base}); v.Errors != 0 {
+		t.Errorf("verification failures: %v", v.Err())
+	}
+
+	if _, ok := parseMetadata([]byte("0123  a.log.gz\n")); ok {
+		t.Error("metadata found in a manifest without")
+	}
+}
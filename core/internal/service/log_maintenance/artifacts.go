@@ -0,0 +1,286 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Archiving of directories of arbitrary artifacts, such as crash dumps, profiles or temporary
+// exports, with the compress-verify-retain logic of the operation logs. A directory becomes
+// a verified <name>.tar.gz next to it, a file a <name>.gz, and the source is only deleted
+// once its archive is stored and verified. The archives are then limited by the retention
+// of the options. The log maintenance runs use the same code for the operation logs.
+
+// ArchiveOptions settings of an artifact archiving
+type ArchiveOptions struct {
+	// Sink archive destination, the local disk next to the artifacts by default. Names
+	// are relative to the directory holding the artifacts
+	Sink ArchiveSink
+
+	// Retention optional limit of the archives: the newest FilesToKeep are kept (all when
+	// 0) and those older than MaxAge are deleted (no age limit when 0)
+	Retention RetentionPolicy
+
+	// NormalizeArchives, FilePerm and DirPerm as in MaintenanceConfig
+	NormalizeArchives bool
+	FilePerm          os.FileMode
+	DirPerm           os.FileMode
+}
+
+// ArchiveResult outcome of an artifact archiving
+type ArchiveResult struct {
+	Archived       int   `json:"archived"` // artifacts archived and deleted
+	Deleted        int   `json:"deleted"`  // archives deleted by the retention
+	BytesProcessed int64 `json:"bytes_processed"`
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+
+	// Failures the failed operations as *MaintenanceError, see Err
+	Failures []error `json:"-"`
+}
+
+// Err the failures of the archiving joined, nil when there was none
+func (r ArchiveResult) Err() error {
+	return errors.Join(r.Failures...)
+}
+
+// newArtifactRun a run archiving the artifacts below base
+func newArtifactRun(ctx context.Context, base string, opts ArchiveOptions) *maintenanceRun {
+	cfg := MaintenanceConfig{
+		BasePath:          base,
+		Sink:              opts.Sink,
+		NormalizeArchives: opts.NormalizeArchives,
+		FilePerm:          permOrDefault(opts.FilePerm, DefaultFilePerm),
+		DirPerm:           permOrDefault(opts.DirPerm, DefaultDirPerm),
+		Location:          time.Local,
+	}
+	if cfg.Sink == nil {
+		cfg.Sink = &LocalSink{Root: base, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm}
+	}
+
+	return newMaintenanceRun(ctx, cfg)
+}
+
+// ArchiveDirectory compresses dir into <dir>.tar.gz, verifies the archive, deletes dir and
+// applies the retention of the options to the archives next to it. It returns the archive
+// name in the sink
+func ArchiveDirectory(ctx context.Context, dir string, opts ArchiveOptions) (string, error) {
+	dir = filepath.Clean(dir)
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+
+	m := newArtifactRun(ctx, filepath.Dir(dir), opts)
+
+	name, err := m.archiveName(dir, ArchiveExt(m.archiveCodec(), true))
+	if err != nil {
+		return "", err
+	}
+
+	exists, err := m.cfg.Sink.Exists(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists || m.replaceExistingArchive(ctx, dir, name) {
+		if err = m.archiveDir(ctx, dir, name, m.archiveLock(false)); err != nil {
+			return "", err
+		}
+	} else if _, err = os.Stat(dir); err == nil {
+		return "", fmt.Errorf("archive %s of %s already exists", name, dir)
+	}
+
+	m.applyArtifactRetention(ctx, opts.Retention)
+
+	return name, errors.Join(m.failureList.list()...)
+}
+
+// ArchiveByAge archives every file and directory of dir last modified more than olderThan
+// ago, a directory by the newest file it holds, then applies the retention of the options
+// to the archives of dir. Archives, manifests and in-progress files are never archived
+func ArchiveByAge(ctx context.Context, dir string, olderThan time.Duration, opts ArchiveOptions) (ArchiveResult, error) {
+	dir = filepath.Clean(dir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+
+	m := newArtifactRun(ctx, dir, opts)
+	cutoff := timeNow().Add(-olderThan)
+	result := ArchiveResult{}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+
+		source := filepath.Join(dir, entry.Name())
+		if !artifactCandidate(entry.Name()) {
+			continue
+		}
+
+		info, err := os.Lstat(source)
+		if err != nil || specialFile(info) || info.Mode()&os.ModeSymlink != 0 || !newestModTime(source, info).Before(cutoff) {
+			continue
+		}
+
+		if info.IsDir() {
+			name, err := m.archiveName(source, ArchiveExt(m.archiveCodec(), true))
+			if err != nil {
+				m.fail(ErrCompress, source, err)
+				continue
+			}
+			if exists, err := m.cfg.Sink.Exists(ctx, name); err != nil || (exists && !m.replaceExistingArchive(ctx, source, name)) {
+				continue
+			}
+			if err = m.archiveDir(ctx, source, name, m.archiveLock(false)); err != nil {
+				g.Log().Errorf(ctx, "Archiving of artifact directory %s failed: %v", source, err)
+				continue
+			}
+		} else if err = m.archiveArtifactFile(ctx, source, info); err != nil {
+			g.Log().Errorf(ctx, "Archiving of artifact %s failed: %v", source, err)
+			continue
+		}
+
+		result.Archived++
+	}
+
+	result.Deleted = m.applyArtifactRetention(ctx, opts.Retention)
+	result.BytesProcessed = m.bytesProcessed.Load()
+	result.BytesReclaimed = m.bytesReclaimed.Load()
+	result.Failures = m.failureList.list()
+
+	return result, ctx.Err()
+}
+
+// archiveArtifactFile stores the verified archive of a file, then deletes the file
+func (m *maintenanceRun) archiveArtifactFile(ctx context.Context, source string, info os.FileInfo) error {
+	written, err := m.upload(ctx, source, func(ctx context.Context) (int64, error) {
+		return m.compressFile(ctx, source)
+	})
+	if err == nil {
+		var name string
+		if name, err = m.standardArchiveName(source, info); err == nil {
+			err = m.verifyArchive(ctx, name, newRateLimiter(0))
+		}
+	}
+	if err != nil {
+		m.fail(ErrCompress, source, err)
+		m.fileDone(source, 0, 0)
+		return &MaintenanceError{Kind: ErrCompress, Path: source, Err: err}
+	}
+
+	if err = os.Remove(source); err != nil {
+		m.fail(ErrDelete, source, err)
+		m.fileDone(source, info.Size(), 0)
+		return &MaintenanceError{Kind: ErrDelete, Path: source, Err: err}
+	}
+
+	m.fileDone(source, info.Size(), info.Size()-written)
+	return nil
+}
+
+// artifactCandidate reports whether an entry of an artifact directory may be archived
+func artifactCandidate(name string) bool {
+	if _, ok := CodecOf(name); ok {
+		return false
+	}
+	return !strings.HasSuffix(name, manifestExt) && !strings.HasSuffix(name, ".partial")
+}
+
+// newestModTime the modification time of a file, of the newest file below a directory or
+// of the directory itself when it holds none
+func newestModTime(source string, info os.FileInfo) time.Time {
+	if !info.IsDir() {
+		return info.ModTime()
+	}
+
+	var newest time.Time
+	filepath.Walk(source, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	if newest.IsZero() {
+		return info.ModTime()
+	}
+	return newest
+}
+
+// applyArtifactRetention deletes the archives at the top of the sink beyond the retention,
+// the newest first by their sink timestamps, by name when the sink has none. It returns the
+// number of archives deleted
+func (m *maintenanceRun) applyArtifactRetention(ctx context.Context, policy RetentionPolicy) int {
+	if policy.FilesToKeep <= 0 && policy.MaxAge <= 0 {
+		return 0
+	}
+
+	lister, ok := m.cfg.Sink.(ArchiveLister)
+	if !ok {
+		g.Log().Warningf(ctx, "Archive sink of %s cannot list its archives, retention skipped", m.cfg.BasePath)
+		return 0
+	}
+
+	names, err := lister.List(ctx)
+	if err != nil {
+		m.fail(ErrScan, m.cfg.BasePath, err)
+		return 0
+	}
+
+	type archive struct {
+		name    string
+		modTime time.Time
+	}
+
+	ts, _ := m.cfg.Sink.(ArchiveTimestamper)
+	archives := make([]archive, 0, len(names))
+	for _, name := range names {
+		if path.Dir(name) != "." {
+			continue
+		}
+		a := archive{name: name}
+		if ts != nil {
+			a.modTime, _ = ts.ModTime(ctx, name)
+		}
+		archives = append(archives, a)
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		if !archives[i].modTime.Equal(archives[j].modTime) {
+			return archives[i].modTime.After(archives[j].modTime)
+		}
+		return archives[i].name > archives[j].name
+	})
+
+	deleted := 0
+	cutoff := timeNow().Add(-policy.MaxAge)
+	for i, a := range archives {
+		expired := policy.MaxAge > 0 && !a.modTime.IsZero() && a.modTime.Before(cutoff)
+		if (policy.FilesToKeep <= 0 || i < policy.FilesToKeep) && !expired {
+			continue
+		}
+
+		if err := m.deleteArchive(ctx, a.name); err != nil {
+			m.fail(ErrDelete, a.name, err)
+			continue
+		}
+		deleted++
+	}
+
+	return deleted
+}
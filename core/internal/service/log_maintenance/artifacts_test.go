@@ -0,0 +1,70 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveByAge(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().AddDate(0, 0, -3)
+
+	dump := filepath.Join(dir, "dump-1")
+	if err := os.MkdirAll(dump, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		filepath.Join(dump, "core"):       "core dump",
+		filepath.Join(dir, "profile.out"): "profile",
+		filepath.Join(dir, "new.out"):     "recent",
+		filepath.Join(dir, "old.tar.gz"):  "expired archive",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(path) != "new.out" {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	r, err := ArchiveByAge(context.Background(), dir, 24*time.Hour, ArchiveOptions{Retention: RetentionPolicy{MaxAge: 48 * time.Hour}})
+	if err != nil || r.Err() != nil {
+		t.Fatalf("unexpected failures: %v %v", err, r.Err())
+	}
+	if r.Archived != 2 || r.Deleted != 1 {
+		t.Errorf("archived %d, deleted %d, want 2 and 1", r.Archived, r.Deleted)
+	}
+
+	for _, name := range []string{"dump-1.tar.gz", "profile.out.gz", "new.out"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s missing: %v", name, err)
+		}
+	}
+	for _, name := range []string{"dump-1", "profile.out", "old.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not deleted: %v", name, err)
+		}
+	}
+
+	// A directory archived on its own
+	exports := filepath.Join(dir, "exports")
+	if err := os.MkdirAll(exports, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(exports, "contacts.csv"), []byte("a@example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := ArchiveDirectory(context.Background(), exports, ArchiveOptions{})
+	if err != nil || name != "exports.tar.gz" {
+		t.Fatalf("ArchiveDirectory = %s, %v", name, err)
+	}
+	if _, err := os.Stat(exports); !os.IsNotExist(err) {
+		t.Errorf("archived directory not deleted: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package log_maintenance
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Audit trail of the destructive actions. Every log deleted by the retention and every
+// source removed once its archive is stored yields one AuditEvent naming the rule that
+// triggered it. DefaultConfig records them in the operation log, whose day directories
+// are themselves archived by the maintenance, so the trail outlives the files it lists.
+
+// Audited actions
+const (
+	AuditDelete           = "delete"            // deleted without archive
+	AuditRemoveCompressed = "remove_compressed" // removed once its archive was stored
+	AuditQuarantine       = "quarantine"        // moved to the quarantine of the corrupt logs
+	AuditTrash            = "trash"             // staged in the trash, deleted after TrashGrace
+)
+
+// AuditEvent one file or directory deleted by a run
+type AuditEvent struct {
+	Action  string    `json:"action"`
+	Path    string    `json:"path"`
+	Bytes   int64     `json:"bytes"`
+	Rule    string    `json:"rule"`              // retention rule that triggered it
+	Archive string    `json:"archive,omitempty"` // archive replacing it, AuditRemoveCompressed only
+	Actor   string    `json:"actor"`             // account that started the run, "scheduler" when none
+	Time    time.Time `json:"time"`
+}
+
+// AuditFunc receives the audit events of a run, it may be called from concurrent uploads
+type AuditFunc func(ctx context.Context, event AuditEvent)
+
+// WriteAuditLog records an audit event in the operation log
+func WriteAuditLog(ctx context.Context, event AuditEvent) {
+	log := fmt.Sprintf("Log maintenance %s %s (%d bytes): %s", event.Action, event.Path, event.Bytes, event.Rule)
+	if event.Archive != "" {
+		log += ", archived to " + event.Archive
+	}
+
+	if err := public.WriteLog(ctx, public.LogParams{Type: consts.LOGTYPE.LogMaintenance, Log: log, Data: event}); err != nil {
+		g.Log().Warningf(ctx, "Failed to write the audit event of %s: %v", event.Path, err)
+	}
+}
+
+// audit emits the event of a completed deletion, when an audit hook is configured
+func (m *maintenanceRun) audit(ctx context.Context, action, path string, bytes int64, rule, archive string) {
+	if m.cfg.Audit == nil {
+		return
+	}
+
+	actor := "scheduler"
+	if id := public.GetCurrentAccountId(ctx); id != 0 {
+		actor = fmt.Sprintf("account %d", id)
+	}
+
+	m.cfg.Audit(ctx, AuditEvent{
+		Action:  action,
+		Path:    path,
+		Bytes:   bytes,
+		Rule:    rule,
+		Archive: archive,
+		Actor:   actor,
+		Time:    timeNow(),
+	})
+}
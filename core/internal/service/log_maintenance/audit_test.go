@@ -0,0 +1,50 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditEvents(t *testing.T) {
+	base, source := newOperationLogTree(t)
+
+	expired := newStandardLog(t, base, "access-20250101.log", []byte("old\n"))
+	date := time.Now().AddDate(0, 0, -10)
+	if err := os.Chtimes(expired, date, date); err != nil {
+		t.Fatal(err)
+	}
+	compressed := newStandardLog(t, base, "access-20250102.log", []byte("recent\n"))
+
+	var mu sync.Mutex
+	events := make(map[string]AuditEvent)
+	cfg := MaintenanceConfig{
+		BasePath:  base,
+		Retention: RetentionPolicy{MaxAge: 7 * 24 * time.Hour},
+		Audit: func(_ context.Context, event AuditEvent) {
+			mu.Lock()
+			events[event.Path] = event
+			mu.Unlock()
+		},
+	}
+
+	if r := RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 audit events, got %+v", events)
+	}
+
+	if e := events[expired]; e.Action != AuditDelete || e.Bytes != 4 || e.Rule != "group access keeps logs for 168h0m0s" || e.Archive != "" || e.Actor != "scheduler" {
+		t.Errorf("audit event of the expired log = %+v", e)
+	}
+	if e := events[compressed]; e.Action != AuditRemoveCompressed || e.Bytes != 7 || e.Archive != "core/access-20250102.log.gz" {
+		t.Errorf("audit event of the compressed log = %+v", e)
+	}
+	if e := events[source]; e.Action != AuditRemoveCompressed || e.Bytes != 3*64*1024 || e.Rule != operationLogRule || e.Archive != "core/operation_log/2000-01-01.tar.gz" {
+		t.Errorf("audit event of the operation log day = %+v", e)
+	}
+}
@@ -0,0 +1,135 @@
+package log_maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Migration of the backlog found when the maintenance is adopted on a long running
+// server. Until the backlog is worked off, a run compresses at most BacklogBatch
+// standard logs and operation log days, the oldest of each group first, and leaves the
+// others due to the next runs, so the first deployment does not compress years of logs
+// in one IO storm. The retention deletions are not bounded, they are cheap. The progress
+// is kept in the logs tree next to the history. The migration completes with the first
+// run leaving nothing due, on a server without backlog that is the first run.
+// BacklogFull lifts the bound and completes it in one run.
+
+const backlogFile = ".maintenance_backlog.json"
+
+// DefaultBacklogBatch logs compressed per run by the backlog migration of DefaultConfig
+const DefaultBacklogBatch = 200
+
+// BacklogMigration progress of the backlog migration
+type BacklogMigration struct {
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"` // zero while in progress
+	Runs        int       `json:"runs"`
+	Processed   int       `json:"processed"` // logs compressed by the migration runs
+	Deferred    int       `json:"deferred"`  // logs due left to the next runs by the last one
+	Full        bool      `json:"full,omitempty"`
+}
+
+// Completed reports whether the backlog is worked off
+func (b BacklogMigration) Completed() bool {
+	return !b.CompletedAt.IsZero()
+}
+
+// backlogRun the migration as applied by a run
+type backlogRun struct {
+	state    BacklogMigration
+	batch    int // 0 when unbounded
+	claimed  int
+	deferred int
+}
+
+// BacklogMigrationState returns the progress of the migration of the default configuration
+func BacklogMigrationState() BacklogMigration {
+	return loadBacklog(DefaultService().Config().BasePath)
+}
+
+func loadBacklog(basePath string) BacklogMigration {
+	state := BacklogMigration{}
+	if data, err := os.ReadFile(filepath.Join(basePath, backlogFile)); err == nil {
+		_ = json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+// startBacklog sets up the migration of the run, none once it is completed or disabled
+func (m *maintenanceRun) startBacklog(ctx context.Context) {
+	if m.cfg.BacklogBatch <= 0 {
+		return
+	}
+
+	state := loadBacklog(m.cfg.BasePath)
+	if state.Completed() {
+		return
+	}
+
+	if state.StartedAt.IsZero() {
+		state.StartedAt = timeNow()
+	}
+
+	m.backlog = &backlogRun{state: state, batch: m.cfg.BacklogBatch}
+	if m.cfg.BacklogFull {
+		m.backlog.batch = 0
+		m.backlog.state.Full = true
+		g.Log().Infof(ctx, "Backlog migration: processing the whole backlog in this run")
+	}
+}
+
+// claimBacklog reports whether the run may compress one more log, a log it may not is
+// left due to the next runs
+func (m *maintenanceRun) claimBacklog() bool {
+	b := m.backlog
+	if b == nil {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if b.batch > 0 && b.claimed >= b.batch {
+		b.deferred++
+		return false
+	}
+	b.claimed++
+	return true
+}
+
+// finishBacklog records the progress of the run, it returns the migration state when one
+// was applied
+func (m *maintenanceRun) finishBacklog(ctx context.Context) *BacklogMigration {
+	b := m.backlog
+	if b == nil {
+		return nil
+	}
+
+	state := b.state
+	state.Runs++
+	state.Processed += b.claimed
+	state.Deferred = b.deferred
+
+	// A run stopped by its runtime did not see everything due
+	if b.deferred == 0 && !m.partial {
+		state.CompletedAt = timeNow()
+		g.Log().Infof(ctx, "Backlog migration completed after %d runs, %d logs compressed", state.Runs, state.Processed)
+	} else if b.deferred > 0 {
+		g.Log().Infof(ctx, "Backlog migration: %d logs compressed in this run, %d left to the next runs", b.claimed, b.deferred)
+	}
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = writeFile(filepath.Join(m.cfg.BasePath, backlogFile), data, m.cfg.FilePerm)
+	}
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to save the backlog migration progress: %v", err)
+	}
+
+	return &state
+}
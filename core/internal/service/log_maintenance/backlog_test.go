@@ -0,0 +1,74 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBacklogMigration(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "core")
+	for i := 0; i < 5; i++ {
+		newStandardLog(t, base, "error-"+time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n"))
+	}
+	cfg := MaintenanceConfig{BasePath: base, BacklogBatch: 2}
+
+	counts := func() (int, int) {
+		logs, _ := filepath.Glob(filepath.Join(dir, "error-*.log"))
+		compressed, _ := filepath.Glob(filepath.Join(dir, "error-*.log.gz"))
+		return len(logs), len(compressed)
+	}
+
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if r.Backlog == nil || r.Backlog.Processed != 2 || r.Backlog.Deferred != 3 || r.Backlog.Completed() {
+		t.Fatalf("first run backlog = %+v", r.Backlog)
+	}
+	if logs, compressed := counts(); logs != 3 || compressed != 2 {
+		t.Fatalf("%d logs left, %d compressed, want 3 and 2", logs, compressed)
+	}
+	// The oldest first
+	if _, err := os.Stat(filepath.Join(dir, "error-20250301.log.gz")); err != nil {
+		t.Errorf("oldest log not compressed first: %v", err)
+	}
+
+	// The progress is persisted, the next runs carry on
+	if state := loadBacklog(base); state.Runs != 1 || state.Processed != 2 {
+		t.Fatalf("persisted backlog = %+v", state)
+	}
+	RunMaintenance(context.Background(), cfg)
+	r = RunMaintenance(context.Background(), cfg)
+	if r.Backlog == nil || !r.Backlog.Completed() || r.Backlog.Runs != 3 || r.Backlog.Processed != 5 {
+		t.Fatalf("last run backlog = %+v", r.Backlog)
+	}
+	if logs, compressed := counts(); logs != 0 || compressed != 5 {
+		t.Fatalf("%d logs left, %d compressed, want every log compressed", logs, compressed)
+	}
+
+	// Once completed the batch no longer applies
+	for i := 0; i < 3; i++ {
+		newStandardLog(t, base, "error-"+time.Date(2025, 4, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n"))
+	}
+	r = RunMaintenance(context.Background(), cfg)
+	if r.Backlog != nil {
+		t.Errorf("completed migration applied again: %+v", r.Backlog)
+	}
+	if logs, _ := counts(); logs != 0 {
+		t.Errorf("%d logs left after the migration", logs)
+	}
+
+	// BacklogFull works off everything in one run
+	full := t.TempDir()
+	for i := 0; i < 5; i++ {
+		newStandardLog(t, full, "error-"+time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n"))
+	}
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: full, BacklogBatch: 2, BacklogFull: true})
+	if r.Backlog == nil || !r.Backlog.Completed() || !r.Backlog.Full || r.Backlog.Processed != 5 || r.Backlog.Deferred != 0 {
+		t.Fatalf("full run backlog = %+v", r.Backlog)
+	}
+}
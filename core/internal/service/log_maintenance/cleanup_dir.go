@@ -0,0 +1,255 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// On demand cleanup of a directory out of the configuration, e.g. the old logs left by a
+// migration. The directory is handled for the one run as an external log source: its
+// logs matching the pattern form one group, deleted and compressed by the retention
+// of the policy with the same protections as the scheduled runs, and archived below
+// external/<name> of BasePath. A dry run only reports what the cleanup would do, with
+// the savings estimated like EstimateReclaimable. The directory must be an existing
+// absolute directory, neither a system directory nor overlapping the logs tree.
+
+// DefaultCleanupName group of the logs of a directory cleanup when the policy names none
+const DefaultCleanupName = "cleanup"
+
+// defaultCleanupPattern logs of a directory cleanup when the policy has no pattern
+const defaultCleanupPattern = `\.log$`
+
+// ErrCleanupDir the directory cannot be cleaned up, see CleanupDirectory
+var ErrCleanupDir = errors.New("invalid cleanup directory")
+
+// cleanupForbidden system directories never cleaned up, nor any directory below them
+var cleanupForbidden = []string{"/bin", "/boot", "/dev", "/etc", "/lib", "/lib64", "/proc", "/sbin", "/sys", "/usr"}
+
+// cleanupTopLevel directories only cleaned up below them
+var cleanupTopLevel = []string{"/", "/home", "/opt", "/root", "/srv", "/tmp", "/var"}
+
+// CleanupPolicy how CleanupDirectory handles the logs of the directory
+type CleanupPolicy struct {
+	Name    string // group of the logs, DefaultCleanupName when empty
+	Pattern string // matched against the file names, *.log when empty
+
+	// Retention of the logs, its zero fields fall back to the Retention of the
+	// configuration
+	Retention RetentionPolicy
+
+	// DryRun nothing is deleted nor compressed
+	DryRun bool
+}
+
+// DirectoryCleanup outcome of a directory cleanup
+type DirectoryCleanup struct {
+	Dir        string       `json:"dir"`
+	Name       string       `json:"name"`
+	DryRun     bool         `json:"dry_run"`
+	Deleted    []string     `json:"deleted"`    // logs the retention deletes
+	Compressed []string     `json:"compressed"` // logs due for compression
+	Estimate   ReclaimUsage `json:"estimate"`   // space the cleanup frees, estimated
+
+	// Result the run, nil on a dry run
+	Result *MaintenanceResult `json:"result,omitempty"`
+}
+
+// CleanupDirectory applies the retention and compression of the maintenance to the logs
+// of dir, ErrCleanupDir when dir cannot be cleaned up
+func CleanupDirectory(ctx context.Context, dir string, policy CleanupPolicy) (DirectoryCleanup, error) {
+	return cleanupDirectory(ctx, DefaultService().Config(), dir, policy)
+}
+
+// cleanupDirectory the cleanup of dir with the settings of cfg
+func cleanupDirectory(ctx context.Context, cfg MaintenanceConfig, dir string, policy CleanupPolicy) (DirectoryCleanup, error) {
+	if policy.Name == "" {
+		policy.Name = DefaultCleanupName
+	}
+	if policy.Pattern == "" {
+		policy.Pattern = defaultCleanupPattern
+	}
+
+	cleanup := DirectoryCleanup{Name: policy.Name, DryRun: policy.DryRun}
+
+	dir, err := validateCleanupDir(dir)
+	if err != nil {
+		return cleanup, err
+	}
+	cleanup.Dir = dir
+
+	src, err := NewExternalLogSource(policy.Name, dir, policy.Pattern, policy.Retention)
+	if err != nil {
+		return cleanup, fmt.Errorf("%w: %v", ErrCleanupDir, err)
+	}
+	cfg.ExternalSources = append(cfg.ExternalSources[:len(cfg.ExternalSources):len(cfg.ExternalSources)], src)
+	if err = validateExternalSources(cfg); err != nil {
+		return cleanup, fmt.Errorf("%w: %v", ErrCleanupDir, err)
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+
+	plan := newMaintenanceRun(ctx, cfg)
+	plan.loadRunPins(ctx)
+
+	actions, err := plan.plannedStandardLogs(ctx, dir)
+	if err != nil {
+		return cleanup, fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+	cleanup.Deleted, cleanup.Compressed, cleanup.Estimate = plan.cleanupPlan(ctx, actions)
+
+	if policy.DryRun {
+		return cleanup, nil
+	}
+
+	result := runCleanup(ctx, cfg, dir)
+	cleanup.Result = &result
+	return cleanup, nil
+}
+
+// validateCleanupDir the cleaned, symbolic link free path of dir, ErrCleanupDir when it
+// is not an absolute directory or is a system directory
+func validateCleanupDir(dir string) (string, error) {
+	if dir == "" || !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("%w: %q is not an absolute path", ErrCleanupDir, dir)
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCleanupDir, err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCleanupDir, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%w: %s is not a directory", ErrCleanupDir, resolved)
+	}
+
+	for _, forbidden := range cleanupForbidden {
+		if within(forbidden, resolved) {
+			return "", fmt.Errorf("%w: %s is a system directory", ErrCleanupDir, resolved)
+		}
+	}
+	for _, top := range cleanupTopLevel {
+		if resolved == top {
+			return "", fmt.Errorf("%w: %s is a system directory", ErrCleanupDir, resolved)
+		}
+	}
+
+	return resolved, nil
+}
+
+// cleanupPlan the logs of actions deleted and compressed, and the space they free with
+// the sampled compression ratio
+func (m *maintenanceRun) cleanupPlan(ctx context.Context, actions []reclaimAction) (deleted, compressed []string, usage ReclaimUsage) {
+	var samples []string
+	for _, a := range actions {
+		if !a.delete && a.size > 0 {
+			samples = append(samples, a.path)
+		}
+	}
+	ratio := 1.0
+	if len(samples) > 0 {
+		ratio = m.sampleRatio(ctx, samples)
+	}
+
+	deleted, compressed = []string{}, []string{}
+	for _, a := range actions {
+		if a.delete {
+			deleted = append(deleted, a.path)
+			usage.DeletedFiles++
+			usage.DeletedBytes += a.size
+			continue
+		}
+		compressed = append(compressed, a.path)
+		usage.CompressedFiles++
+		usage.CompressedBytes += a.size
+		usage.SavedBytes += max(int64(float64(a.size)*(1-ratio)), 0)
+	}
+
+	return deleted, compressed, usage
+}
+
+// runCleanup deletes and compresses the logs of dir, in the run slot of the maintenance.
+// Nothing is done on a standby nor on a read-only volume
+func runCleanup(ctx context.Context, cfg MaintenanceConfig, dir string) MaintenanceResult {
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	cfg.FilePerm = permOrDefault(cfg.FilePerm, DefaultFilePerm)
+	cfg.DirPerm = permOrDefault(cfg.DirPerm, DefaultDirPerm)
+	if cfg.Sink == nil {
+		cfg.Sink = &LocalSink{Root: cfg.BasePath, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm, Fsync: cfg.Fsync, FsyncDir: cfg.Fsync}
+	}
+	// The progress belongs to the scheduled runs
+	cfg.Progress = nil
+
+	if err := checkLogBase(ctx, cfg); err != nil {
+		return baseMissingRun(ctx, cfg, err)
+	}
+	if reason := standbyReason(ctx, cfg); reason != "" {
+		return MaintenanceResult{StartedAt: time.Now(), Standby: reason}
+	}
+
+	startedAt := time.Now()
+	m := startMaintenanceRun(ctx, cfg, startedAt)
+	defer m.index.save(ctx)
+
+	now := timeNow().In(cfg.Location)
+	oneDayAgo := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, cfg.Location)
+
+	var locked []string
+	if m.checkWritable(ctx) {
+		m.processStandardLogDirs(ctx, []string{dir}, oneDayAgo)
+		m.waitUploads()
+		locked = m.retryLockedLogs(ctx)
+	}
+
+	deleted, deletionRate := m.deletionStats()
+
+	result := MaintenanceResult{
+		StartedAt:      startedAt,
+		Duration:       time.Since(startedAt),
+		FilesDone:      int(m.filesDone.Load()),
+		BytesProcessed: m.bytesProcessed.Load(),
+		BytesReclaimed: m.bytesReclaimed.Load(),
+		Errors:         int(m.failures.Load()),
+		Failures:       m.failureList.list(),
+		Partial:        m.partial,
+		LastFile:       m.lastFile,
+
+		SkippedEmpty: m.skippedEmpty,
+		DeletedEmpty: m.deletedEmpty,
+		Locked:       locked,
+		OverBudget:   m.overBudgetLogs,
+		Corrupt:      m.corrupt,
+		Protected:    m.protected,
+		Pinned:       m.pinned,
+		Stray:        m.stray,
+
+		UploadsSucceeded: int(m.uploadsSucceeded.Load()),
+		UploadsFailed:    int(m.uploadsFailed.Load()),
+		UploadsRetried:   int(m.uploadsRetried.Load()),
+		PeakUploads:      m.peakUploads(),
+
+		Deleted:      deleted,
+		DeletionRate: deletionRate,
+
+		ReadOnly:  m.readOnly,
+		WORMLocks: m.wormLocks,
+
+		Groups:   groupNames(m.compressedGroups),
+		BasePath: cfg.BasePath,
+	}
+
+	g.Log().Infof(ctx, "Cleanup of %s completed, %d files done, %d bytes reclaimed", dir, result.FilesDone, result.BytesReclaimed)
+
+	return result
+}
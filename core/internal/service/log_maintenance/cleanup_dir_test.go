@@ -0,0 +1,78 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanupDirectory(t *testing.T) {
+	base, leftover := t.TempDir(), t.TempDir()
+
+	write := func(name string, daysAgo int) string {
+		path := filepath.Join(leftover, name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte(name+"\n"), 100), 0600); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().AddDate(0, 0, -daysAgo)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	oldest := write("app-1.log", 9)
+	older := write("app-2.log", 8)
+	newest := write("app-3.log", 7)
+	other := write("notes.txt", 30)
+
+	cfg := MaintenanceConfig{BasePath: base}
+	policy := CleanupPolicy{Retention: RetentionPolicy{FilesToKeep: 2}, DryRun: true}
+
+	dry, err := cleanupDirectory(context.Background(), cfg, leftover, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Deleted) != 1 || dry.Deleted[0] != oldest || len(dry.Compressed) != 2 || dry.Result != nil {
+		t.Fatalf("dry run = %+v, want %s deleted and the 2 others compressed", dry, oldest)
+	}
+	if dry.Estimate.DeletedBytes == 0 || dry.Estimate.SavedBytes == 0 {
+		t.Errorf("dry run estimate = %+v, want deleted and saved bytes", dry.Estimate)
+	}
+	for _, path := range []string{oldest, older, newest} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("dry run should leave %s: %v", path, err)
+		}
+	}
+
+	policy.DryRun = false
+	done, err := cleanupDirectory(context.Background(), cfg, leftover, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Result == nil || done.Result.Errors != 0 {
+		t.Fatalf("cleanup result = %+v", done.Result)
+	}
+	if _, err := os.Stat(oldest); !os.IsNotExist(err) {
+		t.Errorf("log beyond the retention should be deleted, stat err: %v", err)
+	}
+	for _, path := range []string{older, newest} {
+		if _, err := os.Stat(filepath.Join(base, externalDir, DefaultCleanupName, filepath.Base(path)+".gz")); err != nil {
+			t.Errorf("%s should be archived below the logs tree: %v", filepath.Base(path), err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("file not matching the pattern should be left alone: %v", err)
+	}
+
+	for _, dir := range []string{"relative/logs", "/etc", "/usr/local", "/", filepath.Join(base, "core"), base, filepath.Join(leftover, "app-3.log")} {
+		_ = os.MkdirAll(filepath.Join(base, "core"), 0700)
+		if _, err := cleanupDirectory(context.Background(), cfg, dir, policy); !errors.Is(err, ErrCleanupDir) {
+			t.Errorf("cleanup of %s: err = %v, want ErrCleanupDir", dir, err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package log_maintenance
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Registry of the archive codecs, the only place mapping the file extensions to the
+// codecs. A codec is registered with its extension, its reader and writer, and the magic
+// bytes starting its streams. The magic bytes identify the archives whose name is
+// ambiguous, without a known extension or whose content was converted under the old
+// name, and win over the extension when they tell another codec. The archives of a
+// directory are tar streams compressed by the codec, named with ".tar" before the
+// codec extension.
+
+// CompressionCodec compression format of the archives
+type CompressionCodec interface {
+	// Name short name used in the configuration, e.g. "gzip"
+	Name() string
+	// Ext file name suffix of the compressed files, e.g. ".gz"
+	Ext() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// LeveledCodec a codec whose compression level can be set, see
+// MaintenanceConfig.CompressionLevel
+type LeveledCodec interface {
+	CompressionCodec
+	// NewWriterLevel is NewWriter compressing at level, it fails on a level out of range
+	NewWriterLevel(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+// Available codecs
+var (
+	GzipCodec CompressionCodec = gzipCodec{}
+	ZstdCodec CompressionCodec = zstdCodec{}
+)
+
+// maxMagicLen longest magic bytes accepted, the bytes read to sniff a stream
+const maxMagicLen = 16
+
+type registeredCodec struct {
+	codec CompressionCodec
+	magic []byte
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      []registeredCodec
+)
+
+func init() {
+	mustRegisterCodec(GzipCodec, []byte{0x1f, 0x8b})
+	mustRegisterCodec(ZstdCodec, []byte{0x28, 0xb5, 0x2f, 0xfd})
+}
+
+func mustRegisterCodec(c CompressionCodec, magic []byte) {
+	if err := RegisterCodec(c, magic); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterCodec adds a codec, its name and extension must not be registered yet. magic
+// starts every stream written by the codec, nil when it has none: its archives are then
+// only recognized by their extension
+func RegisterCodec(c CompressionCodec, magic []byte) error {
+	name, ext := c.Name(), c.Ext()
+	if name == "" || name != strings.ToLower(name) {
+		return fmt.Errorf("invalid codec name %q, expected lower case", name)
+	}
+	if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext, "/\\") || ext == ".tar" {
+		return fmt.Errorf("invalid extension %q of codec %s", ext, name)
+	}
+	if len(magic) > maxMagicLen {
+		return fmt.Errorf("magic bytes of codec %s longer than %d bytes", name, maxMagicLen)
+	}
+
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+
+	for _, r := range codecs {
+		switch {
+		case r.codec.Name() == name:
+			return fmt.Errorf("codec %s already registered", name)
+		case r.codec.Ext() == ext:
+			return fmt.Errorf("extension %s of codec %s already registered by %s", ext, name, r.codec.Name())
+		case len(magic) > 0 && len(r.magic) > 0 && (bytes.HasPrefix(magic, r.magic) || bytes.HasPrefix(r.magic, magic)):
+			return fmt.Errorf("magic bytes of codec %s collide with those of %s", name, r.codec.Name())
+		}
+	}
+
+	codecs = append(codecs, registeredCodec{codec: c, magic: append([]byte(nil), magic...)})
+	return nil
+}
+
+// Codecs returns the registered codecs, in registration order
+func Codecs() []CompressionCodec {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+
+	list := make([]CompressionCodec, len(codecs))
+	for i, r := range codecs {
+		list[i] = r.codec
+	}
+	return list
+}
+
+// CodecByName returns the codec of a configuration name, gzip when empty
+func CodecByName(name string) (CompressionCodec, bool) {
+	if name == "" {
+		return GzipCodec, true
+	}
+
+	for _, c := range Codecs() {
+		if c.Name() == strings.ToLower(name) {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// CodecOf returns the codec an archive was written with, from its file name. The
+// longest matching extension wins
+func CodecOf(name string) (CompressionCodec, bool) {
+	var found CompressionCodec
+	for _, c := range Codecs() {
+		if strings.HasSuffix(name, c.Ext()) && (found == nil || len(c.Ext()) > len(found.Ext())) {
+			found = c
+		}
+	}
+
+	return found, found != nil
+}
+
+// SniffCodec returns the codec whose magic bytes start header
+func SniffCodec(header []byte) (CompressionCodec, bool) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+
+	for _, r := range codecs {
+		if len(r.magic) > 0 && bytes.HasPrefix(header, r.magic) {
+			return r.codec, true
+		}
+	}
+
+	return nil, false
+}
+
+// ArchiveExt the suffix of the archives written with codec, of a directory when dir
+func ArchiveExt(codec CompressionCodec, dir bool) string {
+	if dir {
+		return ".tar" + codec.Ext()
+	}
+	return codec.Ext()
+}
+
+// SplitArchiveName returns the name of the file or directory an archive was made of and
+// the codec of its extension, dir tells the archives of a directory. ok is false when
+// the extension is of no registered codec
+func SplitArchiveName(name string) (source string, codec CompressionCodec, dir bool, ok bool) {
+	if codec, ok = CodecOf(name); !ok {
+		return name, nil, false, false
+	}
+
+	source = strings.TrimSuffix(name, codec.Ext())
+	if trimmed := strings.TrimSuffix(source, ".tar"); trimmed != source {
+		return trimmed, codec, true, true
+	}
+	return source, codec, false, true
+}
+
+// NewCodecReader decompresses the stream of the archive name. The codec is sniffed from
+// the first bytes of the stream, the extension of name is used when they match none of
+// the registered codecs. It returns the codec used
+func NewCodecReader(name string, r io.Reader) (io.ReadCloser, CompressionCodec, error) {
+	// Read exactly the header, r may be hashed or counted by the caller
+	header := make([]byte, maxMagicLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	header = header[:n]
+
+	codec, ok := SniffCodec(header)
+	if !ok {
+		if codec, ok = CodecOf(name); !ok {
+			return nil, nil, fmt.Errorf("unknown archive format: %s", name)
+		}
+	}
+
+	reader, err := codec.NewReader(io.MultiReader(bytes.NewReader(header), r))
+	if err != nil {
+		return nil, codec, err
+	}
+	return reader, codec, nil
+}
+
+// archiveCodec the codec the archives are written with, that of the filter chain.
+// RecompressTo converts them afterwards
+func (m *maintenanceRun) archiveCodec() CompressionCodec {
+	chain, err := m.filters()
+	if err != nil {
+		// The archives then fail to be written, see filters
+		return GzipCodec
+	}
+	return chain.Codec()
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Ext() string { return ".gz" }
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// NewWriterLevel accepts the levels of compress/gzip, 1 to 9, 0 storing without
+// compression and -2 for Huffman only
+func (gzipCodec) NewWriterLevel(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+// NewReader reads every member of a multistream gzip file, such as .gz files appended to
+// one another, as one content. Zero padding between the members is skipped
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	zr.Multistream(false)
+
+	return &multistreamReader{r: br, zr: zr}, nil
+}
+
+// multistreamReader reads the gzip members one by one, so the padding between them can be
+// skipped, which gzip.Reader refuses as an invalid header
+type multistreamReader struct {
+	r  *bufio.Reader
+	zr *gzip.Reader
+}
+
+func (m *multistreamReader) Read(p []byte) (int, error) {
+	for {
+		n, err := m.zr.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+
+		// End of a member, continue with the next one if any
+		if more, perr := m.nextMember(); perr != nil {
+			return n, perr
+		} else if !more {
+			return n, io.EOF
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// nextMember skips the padding and starts reading the next member, false at the end
+func (m *multistreamReader) nextMember() (bool, error) {
+	for {
+		b, err := m.r.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != 0 {
+			break
+		}
+	}
+	if err := m.r.UnreadByte(); err != nil {
+		return false, err
+	}
+
+	if err := m.zr.Reset(m.r); err != nil {
+		return false, err
+	}
+	m.zr.Multistream(false)
+
+	return true, nil
+}
+
+func (m *multistreamReader) Close() error {
+	return m.zr.Close()
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+func (zstdCodec) Ext() string { return ".zst" }
+
+func (zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+// NewWriterLevel accepts the levels of the zstd command, 1 to 22, mapped to the nearest
+// level of the encoder
+func (zstdCodec) NewWriterLevel(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < 1 || level > 22 {
+		return nil, fmt.Errorf("zstd: invalid compression level %d, expected 1 to 22", level)
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
@@ -0,0 +1,182 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGzipMultistream(t *testing.T) {
+	var archive, want bytes.Buffer
+	for i := 0; i < 5; i++ {
+		part := []byte(strings.Repeat(fmt.Sprintf("member %d line\n", i), 100*(i+1)))
+		want.Write(part)
+
+		w, err := GzipCodec.NewWriter(&archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(part); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Block padding left by some appending tools
+		if i == 2 {
+			archive.Write(make([]byte, 512))
+		}
+	}
+
+	base := t.TempDir()
+	name := "core/access-20250101.log.gz"
+	if err := os.MkdirAll(filepath.Join(base, "core"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, name), archive.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base)})
+	rc, err := m.openLog(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("read %d bytes of the %d of the concatenated members", len(got), want.Len())
+	}
+}
+
+// prefixCodec stores the content as is after its magic bytes
+type prefixCodec struct {
+	name, ext, magic string
+}
+
+func (c prefixCodec) Name() string { return c.name }
+
+func (c prefixCodec) Ext() string { return c.ext }
+
+func (c prefixCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if _, err := io.WriteString(w, c.magic); err != nil {
+		return nil, err
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func (c prefixCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	magic := make([]byte, len(c.magic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != c.magic {
+		return nil, fmt.Errorf("not a %s stream", c.name)
+	}
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+var registerPlainCodec sync.Once
+
+func TestCodecRegistry(t *testing.T) {
+	plain := prefixCodec{name: "plain", ext: ".plain", magic: "PLAIN1"}
+	registerPlainCodec.Do(func() {
+		if err := RegisterCodec(plain, []byte(plain.magic)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, c := range []struct {
+		codec CompressionCodec
+		magic string
+	}{
+		{prefixCodec{name: "gzip", ext: ".gzip"}, ""},
+		{prefixCodec{name: "other", ext: ".gz"}, ""},
+		{prefixCodec{name: "other", ext: ".other"}, "\x1f\x8b\x08"},
+		{prefixCodec{name: "Other", ext: ".other"}, ""},
+		{prefixCodec{name: "other", ext: ".tar"}, ""},
+	} {
+		if err := RegisterCodec(c.codec, []byte(c.magic)); err == nil {
+			t.Errorf("registration of %s %s %q should be refused", c.codec.Name(), c.codec.Ext(), c.magic)
+		}
+	}
+
+	if c, ok := CodecByName("plain"); !ok || c != CompressionCodec(plain) {
+		t.Errorf("CodecByName(plain) = %v, %t", c, ok)
+	}
+
+	for name, want := range map[string]struct {
+		source string
+		codec  CompressionCodec
+		dir    bool
+	}{
+		"access-20250101.log.gz":    {"access-20250101.log", GzipCodec, false},
+		"2025-01-02.tar.zst":        {"2025-01-02", ZstdCodec, true},
+		"access-20250101.log.plain": {"access-20250101.log", plain, false},
+		"access-20250101.log":       {"access-20250101.log", nil, false},
+	} {
+		source, codec, dir, ok := SplitArchiveName(name)
+		if source != want.source || codec != want.codec || dir != want.dir || ok != (want.codec != nil) {
+			t.Errorf("SplitArchiveName(%s) = %s, %v, %t, %t", name, source, codec, dir, ok)
+		}
+	}
+
+	// The new codec goes through the whole pipeline, from the compression to the
+	// retention counting and the reads
+	base := t.TempDir()
+	newStandardLog(t, base, "access-20250102.log", []byte("registered codec\n"))
+	cfg := MaintenanceConfig{BasePath: base, DateSource: LogDateFromName, RecompressTo: "plain"}
+	if r := RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(filepath.Join(base, "core", "access-20250102.log.plain")); err != nil {
+		t.Fatalf("archive not converted to the registered codec: %v", err)
+	}
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base)})
+	read := func(name string) string {
+		t.Helper()
+		rc, err := m.openLog(context.Background(), name)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		return string(data)
+	}
+	if got := read("core/access-20250102.log.plain"); got != "registered codec\n" {
+		t.Errorf("read %q", got)
+	}
+	if usage, err := logDiskUsage(context.Background(), MaintenanceConfig{BasePath: base}); err != nil || usage.Total.CompressedFiles != 1 {
+		t.Errorf("disk usage %+v, %v, want the archive counted as compressed", usage.Total, err)
+	}
+
+	// The content wins over an ambiguous extension: zstd stored as .gz
+	var buf bytes.Buffer
+	zw, _ := ZstdCodec.NewWriter(&buf)
+	io.WriteString(zw, "zstd under a gzip name\n")
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(base, "core", "access-20250103.log.gz"), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("core/access-20250103.log.gz"); got != "zstd under a gzip name\n" {
+		t.Errorf("read %q", got)
+	}
+	if codec, ok := SniffCodec(buf.Bytes()); !ok || codec != ZstdCodec {
+		t.Errorf("SniffCodec = %v, %t", codec, ok)
+	}
+}
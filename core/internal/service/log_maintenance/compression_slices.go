@@ -0,0 +1,223 @@
+package log_maintenance
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+)
+
+// Staggered compression of the standard logs. Compressing every group in the daily run
+// concentrates its IO in one burst, with CompressionSlices the logs of the groups of a
+// slice are compressed Offset after the run instead, e.g. the access logs right away and
+// the error logs 20 minutes later. The run still applies the retention to every group,
+// a slice only compresses. A slice run is recorded in the history like the daily run,
+// with its slice name and the groups it compressed. While the backlog migration is in
+// progress nothing is left to the slices, the run bounded by BacklogBatch does it all.
+
+// CompressionSlice groups whose logs are compressed Offset after the daily run
+type CompressionSlice struct {
+	Name   string        `json:"name"`
+	Offset time.Duration `json:"offset"` // below 24h, the next daily run starts then
+	Groups []string      `json:"groups"`
+}
+
+// validateSlices rejects the slices sharing a name or a group
+func validateSlices(slices []CompressionSlice) error {
+	names := make(map[string]bool)
+	groups := make(map[string]string)
+
+	for _, slice := range slices {
+		if !logGroupNamePattern.MatchString(slice.Name) || names[slice.Name] {
+			return fmt.Errorf("invalid compression slice %q", slice.Name)
+		}
+		names[slice.Name] = true
+
+		if slice.Offset < 0 || slice.Offset >= 24*time.Hour {
+			return fmt.Errorf("compression slice %s: offset %s not within a day", slice.Name, slice.Offset)
+		}
+		if len(slice.Groups) == 0 {
+			return fmt.Errorf("compression slice %s has no group", slice.Name)
+		}
+		for _, group := range slice.Groups {
+			if other, ok := groups[group]; ok {
+				return fmt.Errorf("log group %s is in compression slices %s and %s", group, other, slice.Name)
+			}
+			groups[group] = slice.Name
+		}
+	}
+
+	return nil
+}
+
+// sliceOf the name of the compression slice of a group, empty when the daily run compresses it
+func (m *maintenanceRun) sliceOf(group string) string {
+	for _, slice := range m.cfg.CompressionSlices {
+		for _, name := range slice.Groups {
+			if name == group {
+				return slice.Name
+			}
+		}
+	}
+	return ""
+}
+
+// skipGroup reports whether the run leaves the group alone, the run of a slice only
+// handles the groups of its slice
+func (m *maintenanceRun) skipGroup(group string) bool {
+	return m.slice != nil && m.sliceOf(group) != m.slice.Name
+}
+
+// deferCompression reports whether the compression of a log of the group is left to its
+// slice, the retention still applies to it
+func (m *maintenanceRun) deferCompression(group string) bool {
+	if m.slice != nil || m.backlog != nil || m.sliceOf(group) == "" {
+		return false
+	}
+
+	if m.deferredGroups == nil {
+		m.deferredGroups = make(map[string]bool)
+	}
+	m.deferredGroups[group] = true
+	return true
+}
+
+// compressing records the group of a log the run compresses
+func (m *maintenanceRun) compressing(group string) {
+	if m.compressedGroups == nil {
+		m.compressedGroups = make(map[string]bool)
+	}
+	m.compressedGroups[group] = true
+}
+
+// groupNames the sorted names of a set of groups
+func groupNames(groups map[string]bool) []string {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scheduleSlices starts the timers of the compression slices of cfg, the slices still
+// pending from the previous run are dropped, their logs are now due to the new ones
+func (s *Service) scheduleSlices(ctx context.Context, cfg MaintenanceConfig) {
+	s.slicesMutex.Lock()
+	defer s.slicesMutex.Unlock()
+
+	for _, timer := range s.pendingSlices {
+		timer.Stop()
+	}
+	s.pendingSlices = s.pendingSlices[:0]
+
+	for _, slice := range cfg.CompressionSlices {
+		slice := slice
+		s.pendingSlices = append(s.pendingSlices, time.AfterFunc(slice.Offset, func() {
+			runSlice(ctx, cfg, slice)
+		}))
+	}
+}
+
+// RunSlice compresses the logs of the groups of the named compression slice of the
+// active configuration now
+func (s *Service) RunSlice(ctx context.Context, name string) (MaintenanceResult, error) {
+	cfg := s.Config()
+	for _, slice := range cfg.CompressionSlices {
+		if slice.Name == name {
+			return runSlice(ctx, cfg, slice), nil
+		}
+	}
+	return MaintenanceResult{}, fmt.Errorf("unknown compression slice %q", name)
+}
+
+func runSlice(ctx context.Context, cfg MaintenanceConfig, slice CompressionSlice) MaintenanceResult {
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	if cfg.BasePath == "" {
+		cfg.BasePath = public.AbsPath("../logs")
+	}
+	cfg.FilePerm = permOrDefault(cfg.FilePerm, DefaultFilePerm)
+	cfg.DirPerm = permOrDefault(cfg.DirPerm, DefaultDirPerm)
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.Sink == nil {
+		cfg.Sink = &LocalSink{Root: cfg.BasePath, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm, Fsync: cfg.Fsync, FsyncDir: cfg.Fsync}
+	}
+
+	startedAt := time.Now()
+	result := MaintenanceResult{StartedAt: startedAt, Slice: slice.Name}
+
+	if reason := standbyReason(ctx, cfg); reason != "" {
+		g.Log().Debugf(ctx, "Compression slice %s skipped, %s", slice.Name, reason)
+		result.Standby = reason
+		return result
+	}
+	if cfg.BacklogBatch > 0 && !loadBacklog(cfg.BasePath).Completed() {
+		g.Log().Debugf(ctx, "Compression slice %s skipped, the backlog migration compresses every group", slice.Name)
+		return result
+	}
+
+	m := startMaintenanceRun(ctx, cfg, startedAt)
+	m.slice = &slice
+	defer m.index.save(ctx)
+
+	now := timeNow().In(cfg.Location)
+	oneDayAgo := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, cfg.Location)
+
+	dirs := m.standardLogDirs()
+
+	if m.checkWritable(ctx) {
+		for _, dir := range dirs {
+			if m.outOfTime() {
+				break
+			}
+			if gfile.Exists(dir) {
+				m.processStandardLogs(ctx, dir, oneDayAgo)
+			}
+		}
+		m.waitUploads()
+		result.Locked = m.retryLockedLogs(ctx)
+	}
+
+	result.Duration = time.Since(startedAt)
+	result.FilesDone = int(m.filesDone.Load())
+	result.BytesProcessed = m.bytesProcessed.Load()
+	result.BytesReclaimed = m.bytesReclaimed.Load()
+	result.Errors = int(m.failures.Load())
+	result.Failures = m.failureList.list()
+	result.Partial = m.partial
+	result.LastFile = m.lastFile
+	result.UploadsSucceeded = int(m.uploadsSucceeded.Load())
+	result.UploadsFailed = int(m.uploadsFailed.Load())
+	result.UploadsRetried = int(m.uploadsRetried.Load())
+	result.ReadOnly = m.readOnly
+	result.WORMLocks = m.wormLocks
+	result.Pinned = m.pinned
+	result.Groups = groupNames(m.compressedGroups)
+
+	if result.ReadOnly {
+		return result
+	}
+
+	groups := "none"
+	if len(result.Groups) > 0 {
+		groups = strings.Join(result.Groups, ", ")
+	}
+	g.Log().Infof(ctx, "Compression slice %s completed, groups compressed: %s, %d bytes reclaimed", slice.Name, groups, result.BytesReclaimed)
+
+	recordRun(ctx, cfg.BasePath, cfg.FilePerm, result)
+
+	return result
+}
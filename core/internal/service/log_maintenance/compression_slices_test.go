@@ -0,0 +1,59 @@
+package log_maintenance
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompressionSlices(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "core")
+	for i := 0; i < 3; i++ {
+		day := time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")
+		newStandardLog(t, base, "access-"+day+".log", []byte("line\n"))
+		newStandardLog(t, base, "error-"+day+".log", []byte("line\n"))
+	}
+
+	slices := []CompressionSlice{{Name: "errors", Offset: 20 * time.Minute, Groups: []string{"error"}}}
+	if err := validateConfig(MaintenanceConfig{CompressionSlices: append(slices, CompressionSlice{Name: "again", Groups: []string{"error"}})}); err == nil {
+		t.Error("a group in two slices accepted")
+	}
+	cfg := MaintenanceConfig{BasePath: base, CompressionSlices: slices, Retention: RetentionPolicy{FilesToKeep: 2}}
+
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if !reflect.DeepEqual(r.Groups, []string{"access"}) || !reflect.DeepEqual(r.DeferredGroups, []string{"error"}) {
+		t.Fatalf("run compressed %v, deferred %v", r.Groups, r.DeferredGroups)
+	}
+	// The retention still applies to the deferred group
+	if logs, _ := filepath.Glob(filepath.Join(dir, "error-*.log")); len(logs) != 2 {
+		t.Errorf("%d error logs left, want the 2 kept uncompressed", len(logs))
+	}
+	if archives, _ := filepath.Glob(filepath.Join(dir, "access-*.log.gz")); len(archives) != 2 {
+		t.Errorf("%d access logs compressed, want 2", len(archives))
+	}
+
+	s := NewService(cfg)
+	if _, err := s.RunSlice(context.Background(), "unknown"); err == nil {
+		t.Error("unknown slice run")
+	}
+	r, err := s.RunSlice(context.Background(), "errors")
+	if err != nil || r.Errors != 0 {
+		t.Fatalf("slice run failed: %v %v", err, r.Err())
+	}
+	if r.Slice != "errors" || !reflect.DeepEqual(r.Groups, []string{"error"}) {
+		t.Errorf("slice run %s compressed %v", r.Slice, r.Groups)
+	}
+	if logs, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(logs) != 0 {
+		t.Errorf("logs left after the slice run: %v", logs)
+	}
+
+	if history := loadHistory(base); len(history) != 2 || history[0].Slice != "errors" || history[1].Slice != "" {
+		t.Errorf("history %+v", history)
+	}
+}
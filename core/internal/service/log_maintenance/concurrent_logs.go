@@ -0,0 +1,32 @@
+package log_maintenance
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"golang.org/x/sync/errgroup"
+)
+
+// Concurrent processing of the standard logs and the operation logs. With
+// ConcurrentOperationLogs the operation log days are archived while the standard logs
+// are, rather than once they are all done, their files are distinct and their uploads
+// share the upload pool. The counters of the run are atomic and the lists it collects
+// are guarded by its mutex, the failures of both are accounted in the same result. The
+// locked standard logs are retried once both are done.
+
+// processConcurrently runs the processing of each log kind in its own goroutine and
+// waits for all of them
+func (m *maintenanceRun) processConcurrently(ctx context.Context, kinds ...func()) {
+	var group errgroup.Group
+
+	for _, process := range kinds {
+		group.Go(func() error {
+			process()
+			return ctx.Err()
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		g.Log().Warningf(ctx, "Log maintenance interrupted: %v", err)
+	}
+}
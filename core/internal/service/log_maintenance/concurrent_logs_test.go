@@ -0,0 +1,37 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConcurrentOperationLogs(t *testing.T) {
+	base, source := newOperationLogTree(t)
+	var logs []string
+	for i := 0; i < 3; i++ {
+		logs = append(logs, newStandardLog(t, base, "error-"+time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n")))
+	}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, ConcurrentOperationLogs: true, MaxConcurrentUploads: 4})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	if _, err := os.Stat(source + ".tar.gz"); err != nil {
+		t.Errorf("operation log day not archived: %v", err)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("operation log day not removed, stat err: %v", err)
+	}
+	// The newest log of the group is protected
+	for _, path := range logs[:2] {
+		if _, err := os.Stat(path + ".gz"); err != nil {
+			t.Errorf("log %s not compressed: %v", path, err)
+		}
+	}
+	if r.FilesDone != 4 {
+		t.Errorf("files done %d, want 4", r.FilesDone)
+	}
+}
@@ -0,0 +1,224 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// Settings of the scheduled maintenance in the configuration file of the server, its
+// log_maintenance section. The keys are the snake_case names of the MaintenanceConfig
+// fields of a plain type, strings, numbers, booleans, durations and lists of strings,
+// and of its retention policies and tenants as nested sections. The durations are
+// strings, e.g. "36h" or "30d". codec sets the codec of the default filter chain. A key
+// missing keeps the default of DefaultConfig, a key unknown fails the loading, a typo
+// must not silently keep a default. signing is read by ConfiguredSigning.
+//
+//	log_maintenance:
+//	  base_path: "/var/log/billionmail"
+//	  codec: "zstd"
+//	  compression_level: 9
+//	  exclude_logs: ["debug-*.log"]
+//	  run_interval: "24h"
+//	  retention:
+//	    files_to_keep: 14
+//	    max_age: "90d"
+//	  retention_overrides:
+//	    access:
+//	      files_to_keep: 60
+
+const (
+	maintenanceConfigKey = "log_maintenance"
+
+	configCodecKey = "codec"
+)
+
+// configSections the keys of the section read elsewhere
+var configSections = map[string]bool{"signing": true}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadConfig returns DefaultConfig with the settings of the log_maintenance section of
+// the configuration applied, validated. DefaultConfig when the server has no
+// configuration file
+func LoadConfig(ctx context.Context) (MaintenanceConfig, error) {
+	if !g.Cfg().Available(ctx) {
+		return DefaultConfig(), nil
+	}
+
+	v, err := g.Cfg().Get(ctx, maintenanceConfigKey)
+	if err != nil {
+		return DefaultConfig(), err
+	}
+	return configFromSection(v.Map())
+}
+
+// configFromSection applies the settings of a log_maintenance section to DefaultConfig
+func configFromSection(section map[string]interface{}) (MaintenanceConfig, error) {
+	cfg := DefaultConfig()
+	defaultBase := cfg.BasePath
+
+	settings := make(map[string]interface{}, len(section))
+	for key, value := range section {
+		if !configSections[key] && key != configCodecKey {
+			settings[key] = value
+		}
+	}
+	if err := setConfigFields(reflect.ValueOf(&cfg).Elem(), maintenanceConfigKey, settings); err != nil {
+		return DefaultConfig(), err
+	}
+
+	if codec, ok := section[configCodecKey]; ok {
+		if _, set := section["filters"]; set {
+			return DefaultConfig(), fmt.Errorf("%s.%s and %s.filters are both set, the codec is part of the filters", maintenanceConfigKey, configCodecKey, maintenanceConfigKey)
+		}
+		cfg.Filters = []string{FilterRedact, strings.ToLower(gconv.String(codec))}
+	}
+
+	if cfg.BasePath != defaultBase {
+		if !filepath.IsAbs(cfg.BasePath) {
+			return DefaultConfig(), fmt.Errorf("%s.base_path %q is not an absolute path", maintenanceConfigKey, cfg.BasePath)
+		}
+		cfg.BasePath = filepath.Clean(cfg.BasePath)
+		cfg.Sink = NewLocalSink(cfg.BasePath)
+	}
+
+	if err := validateConfig(cfg); err != nil {
+		return DefaultConfig(), fmt.Errorf("%s: %w", maintenanceConfigKey, err)
+	}
+	return cfg, nil
+}
+
+// configFields the fields of struct type t settable from the configuration, by key
+func configFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Tag.Get("json") == "-" || !configurable(f.Type) {
+			continue
+		}
+		fields[gstr.CaseSnake(f.Name)] = i
+	}
+	return fields
+}
+
+// configurable reports whether a field of type t can be set from the configuration
+func configurable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	case reflect.Struct:
+		return true
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.Struct
+	}
+	return false
+}
+
+// setConfigFields sets the fields of the struct v from the keys of section, path is the
+// key of the section in the error messages
+func setConfigFields(v reflect.Value, path string, section map[string]interface{}) error {
+	fields := configFields(v.Type())
+
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		index, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %s.%s", path, key)
+		}
+		if err := setConfigValue(v.Field(index), path+"."+key, section[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setConfigValue sets the field f from the configuration value of key
+func setConfigValue(f reflect.Value, key string, value interface{}) error {
+	if f.Kind() == reflect.Struct || f.Kind() == reflect.Map {
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is not a section", key)
+		}
+		if f.Kind() == reflect.Struct {
+			return setConfigFields(f, key, section)
+		}
+
+		if f.IsNil() {
+			f.Set(reflect.MakeMapWithSize(f.Type(), len(section)))
+		}
+		for name, entry := range section {
+			elem := reflect.New(f.Type().Elem()).Elem()
+			if err := setConfigValue(elem, key+"."+name, entry); err != nil {
+				return err
+			}
+			f.SetMapIndex(reflect.ValueOf(name), elem)
+		}
+		return nil
+	}
+
+	if f.Kind() == reflect.Slice {
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s is not a list", key)
+		}
+		f.Set(reflect.ValueOf(gconv.Strings(list)))
+		return nil
+	}
+
+	switch value.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return fmt.Errorf("%s is not a single value", key)
+	}
+	s := strings.TrimSpace(gconv.String(value))
+
+	switch {
+	case f.Type() == durationType:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: duration %v without a unit, e.g. \"24h\"", key, value)
+		}
+		d, err := gtime.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", key, s)
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.String:
+		f.SetString(s)
+	case f.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", key, s)
+		}
+		f.SetBool(b)
+	case f.Kind() == reflect.Int || f.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || f.OverflowInt(n) {
+			return fmt.Errorf("%s: invalid integer %q", key, s)
+		}
+		f.SetInt(n)
+	case f.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", key, s)
+		}
+		f.SetFloat(n)
+	}
+	return nil
+}
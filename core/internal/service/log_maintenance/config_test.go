@@ -0,0 +1,80 @@
+package log_maintenance
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFromSection(t *testing.T) {
+	base := t.TempDir()
+	section := map[string]interface{}{
+		"base_path":           base,
+		"codec":               "zstd",
+		"compression_level":   19,
+		"max_runtime":         "2h",
+		"run_interval":        "12h",
+		"exclude_logs":        []interface{}{"debug-*.log"},
+		"guard_recent_months": true,
+		"corrupt_threshold":   0.5,
+		"retention": map[string]interface{}{
+			"files_to_keep": 14,
+			"max_age":       "90d",
+		},
+		"retention_overrides": map[string]interface{}{
+			"access": map[string]interface{}{"files_to_keep": "60", "force_compress": "true"},
+		},
+		"signing": map[string]interface{}{"key_file": "/etc/keys/signing.pem"},
+	}
+
+	cfg, err := configFromSection(section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BasePath != base || cfg.Sink.(*LocalSink).Root != base {
+		t.Errorf("base path %s, sink %+v", cfg.BasePath, cfg.Sink)
+	}
+	if strings.Join(cfg.Filters, ",") != "redact,zstd" || cfg.CompressionLevel != 19 {
+		t.Errorf("filters %v level %d", cfg.Filters, cfg.CompressionLevel)
+	}
+	if cfg.MaxRuntime != 2*time.Hour || cfg.RunInterval != 12*time.Hour || !cfg.GuardRecentMonths || cfg.CorruptThreshold != 0.5 {
+		t.Errorf("settings not applied: %+v", cfg)
+	}
+	if len(cfg.ExcludeLogs) != 1 || cfg.ExcludeLogs[0] != "debug-*.log" {
+		t.Errorf("exclude logs %v", cfg.ExcludeLogs)
+	}
+	if cfg.Retention != (RetentionPolicy{FilesToKeep: 14, MaxAge: 90 * 24 * time.Hour}) ||
+		cfg.RetentionOverrides["access"] != (RetentionPolicy{FilesToKeep: 60, ForceCompress: true}) {
+		t.Errorf("retention %+v, overrides %+v", cfg.Retention, cfg.RetentionOverrides)
+	}
+	// The keys missing keep their default
+	if cfg.BacklogBatch != DefaultBacklogBatch || cfg.Audit == nil || cfg.FilePerm != DefaultFilePerm {
+		t.Errorf("defaults lost: %+v", cfg)
+	}
+
+	if cfg, err = configFromSection(nil); err != nil || cfg.BasePath != DefaultConfig().BasePath {
+		t.Errorf("empty section: %s %v", cfg.BasePath, err)
+	}
+
+	for name, bad := range map[string]map[string]interface{}{
+		"unknown key":           {"max_run_time": "2h"},
+		"unknown nested key":    {"retention": map[string]interface{}{"keep": 3}},
+		"duration unitless":     {"max_runtime": 7200},
+		"invalid duration":      {"max_runtime": "two hours"},
+		"invalid integer":       {"backlog_batch": "many"},
+		"invalid boolean":       {"fsync": "sometimes"},
+		"list expected":         {"exclude_logs": "debug-*.log"},
+		"section expected":      {"retention": 14},
+		"invalid value":         {"empty_logs": "shred"},
+		"negative duration":     {"run_interval": "-1h"},
+		"relative base path":    {"base_path": "logs"},
+		"codec and filters":     {"codec": "zstd", "filters": []interface{}{"redact", "gzip"}},
+		"unknown codec":         {"codec": "brotli"},
+		"level out of range":    {"codec": "gzip", "compression_level": 12},
+		"function not settable": {"audit": "none"},
+	} {
+		if _, err := configFromSection(bad); err == nil {
+			t.Errorf("%s: %v accepted", name, bad)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Confirmation of the stored archives before their source is deleted. Once an archive
+// is stored its object is read back from the sink, its size and, when the sink knows
+// it, the MD5 of its content must match what was written. A failed or mismatching read
+// is retried ConfirmRetries times after ConfirmRetryDelay, a store that is slow to show
+// a new object still gets it confirmed. An archive that stays unconfirmed fails its
+// upload without a retry: the source is kept for the next run and the registered
+// OnUnconfirmedArchive is told. Sinks without ArchiveStater only confirm the archive
+// exists.
+
+const (
+	DefaultConfirmRetries    = 3
+	DefaultConfirmRetryDelay = time.Second
+)
+
+// unconfirmedNotifyInterval minimum time between two notifications, an unreachable sink
+// leaves every archive of a run unconfirmed
+const unconfirmedNotifyInterval = time.Hour
+
+var errArchiveUnconfirmed = errors.New("archive not confirmed by the sink")
+
+func isUnconfirmed(err error) bool {
+	return errors.Is(err, errArchiveUnconfirmed)
+}
+
+// ArchiveInfo what a sink reports of a stored archive
+type ArchiveInfo struct {
+	Size int64
+	MD5  string // hex MD5 of the content, empty when the sink does not know it
+}
+
+// ArchiveStater optional sink capability, reads back the size of the stored archives
+type ArchiveStater interface {
+	// Stat returns the info of the archive stored under name, an error wrapping
+	// fs.ErrNotExist when there is none
+	Stat(ctx context.Context, name string) (ArchiveInfo, error)
+}
+
+var (
+	unconfirmedMu       sync.Mutex
+	unconfirmedNotifier func(ctx context.Context, name string, err error)
+	unconfirmedNotified time.Time
+)
+
+// OnUnconfirmedArchive registers fn to be told when an archive could not be confirmed
+// and its source was kept. It is called at most once an hour
+func OnUnconfirmedArchive(fn func(ctx context.Context, name string, err error)) {
+	unconfirmedMu.Lock()
+	unconfirmedNotifier = fn
+	unconfirmedMu.Unlock()
+}
+
+func notifyUnconfirmed(ctx context.Context, name string, err error) {
+	unconfirmedMu.Lock()
+	fn := unconfirmedNotifier
+	now := timeNow()
+	if fn == nil || now.Sub(unconfirmedNotified) < unconfirmedNotifyInterval {
+		unconfirmedMu.Unlock()
+		return
+	}
+	unconfirmedNotified = now
+	unconfirmedMu.Unlock()
+
+	fn(ctx, name, err)
+}
+
+// confirmArchive reads back the archive stored under name until the sink reports the
+// size and MD5 written, it fails with errArchiveUnconfirmed once the retries are spent
+func (m *maintenanceRun) confirmArchive(ctx context.Context, name string, size int64, md5 string) error {
+	retries := m.cfg.ConfirmRetries
+	if retries == 0 {
+		retries = DefaultConfirmRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+	delay := m.cfg.ConfirmRetryDelay
+	if delay <= 0 {
+		delay = DefaultConfirmRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := m.checkStored(ctx, name, size, md5)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= retries || ctx.Err() != nil {
+			err = fmt.Errorf("%s %w after %d attempts: %v", name, errArchiveUnconfirmed, attempt+1, err)
+			g.Log().Errorf(ctx, "Archive %s could not be confirmed, its source is kept: %v", name, err)
+			notifyUnconfirmed(ctx, name, err)
+			return err
+		}
+
+		g.Log().Warningf(ctx, "Archive %s not confirmed yet, checking again in %s: %v", name, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// checkStored compares the archive reported by the sink with what was written
+func (m *maintenanceRun) checkStored(ctx context.Context, name string, size int64, md5 string) error {
+	stater, ok := m.cfg.Sink.(ArchiveStater)
+	if !ok {
+		exists, err := m.cfg.Sink.Exists(ctx, name)
+		if err == nil && !exists {
+			err = errors.New("archive missing from the sink")
+		}
+		return err
+	}
+
+	info, err := stater.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if info.Size != size {
+		return fmt.Errorf("sink reports %d bytes, %d were written", info.Size, size)
+	}
+	if info.MD5 != "" && !strings.EqualFold(info.MD5, md5) {
+		return fmt.Errorf("sink reports MD5 %s, %s was written", info.MD5, md5)
+	}
+	return nil
+}
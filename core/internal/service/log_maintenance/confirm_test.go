@@ -0,0 +1,101 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// confirmingSink reports each archive missing for its first reads, as many as failures,
+// and with a short size when lost is set. It counts the uploads and reads of each archive
+type confirmingSink struct {
+	*LocalSink
+	failures int
+	lost     bool
+
+	mu    sync.Mutex
+	puts  map[string]int
+	stats map[string]int
+}
+
+func (s *confirmingSink) Put(ctx context.Context, name string, r io.Reader) error {
+	s.mu.Lock()
+	s.puts[name]++
+	s.mu.Unlock()
+	return s.LocalSink.Put(ctx, name, r)
+}
+
+func (s *confirmingSink) Stat(ctx context.Context, name string) (ArchiveInfo, error) {
+	s.mu.Lock()
+	s.stats[name]++
+	attempt := s.stats[name]
+	s.mu.Unlock()
+
+	if attempt <= s.failures {
+		return ArchiveInfo{}, fmt.Errorf("object %s: %w", name, os.ErrNotExist)
+	}
+	info, err := s.LocalSink.Stat(ctx, name)
+	if s.lost {
+		info.Size /= 2
+	}
+	return info, err
+}
+
+func TestArchiveConfirmedBeforeDelete(t *testing.T) {
+	const archive = "core/error-20250301.log.gz"
+
+	// A store slow to show the new objects
+	base := t.TempDir()
+	path := newStandardLog(t, base, "error-20250301.log", []byte("line\n"))
+
+	sink := &confirmingSink{LocalSink: NewLocalSink(base), failures: 2, puts: map[string]int{}, stats: map[string]int{}}
+	cfg := MaintenanceConfig{BasePath: base, Sink: sink, ConfirmRetries: 2, ConfirmRetryDelay: time.Millisecond}
+	if r := RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("confirmed log not deleted: %v", err)
+	}
+	if sink.stats[archive] != 3 || sink.puts[archive] != 1 {
+		t.Errorf("archive read back %d times and uploaded %d times, want 3 and 1", sink.stats[archive], sink.puts[archive])
+	}
+
+	// A store that keeps failing the reads, then one that lost part of the archive
+	for _, sink := range []*confirmingSink{{failures: 10}, {lost: true}} {
+		base := t.TempDir()
+		path := newStandardLog(t, base, "error-20250301.log", []byte("line\n"))
+
+		sink.LocalSink, sink.puts, sink.stats = NewLocalSink(base), map[string]int{}, map[string]int{}
+
+		var alerted []string
+		unconfirmedNotified = time.Time{}
+		OnUnconfirmedArchive(func(ctx context.Context, name string, err error) {
+			alerted = append(alerted, name)
+		})
+
+		cfg := MaintenanceConfig{BasePath: base, Sink: sink, ConfirmRetries: 2, ConfirmRetryDelay: time.Millisecond}
+		r := RunMaintenance(context.Background(), cfg)
+		OnUnconfirmedArchive(nil)
+
+		if r.Errors != 1 || !isUnconfirmed(r.Err()) || r.UploadsFailed != 1 {
+			t.Errorf("want one unconfirmed upload, got %d failures, %d failed uploads: %v", r.Errors, r.UploadsFailed, r.Err())
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("source of the unconfirmed archive not kept: %v", err)
+		}
+		if sink.stats[archive] != 3 || sink.puts[archive] != 1 {
+			t.Errorf("archive read back %d times and uploaded %d times, want 3 and 1", sink.stats[archive], sink.puts[archive])
+		}
+		if len(alerted) != 1 || alerted[0] != archive {
+			t.Errorf("alerted of %v, want %s", alerted, archive)
+		}
+	}
+
+	if err := validateConfig(MaintenanceConfig{ConfirmRetryDelay: -time.Second}); err == nil {
+		t.Error("negative confirmation delay accepted")
+	}
+}
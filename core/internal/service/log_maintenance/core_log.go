@@ -3,219 +3,1669 @@ package log_maintenance
 import (
 	"archive/tar"
 	"billionmail-core/internal/service/public"
-	"compress/gzip"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gfile"
 )
 
-func CompressAndCleanupLogs(ctx context.Context) {
+// MaintenanceConfig settings of a log maintenance run
+type MaintenanceConfig struct {
+	BasePath string      // root of the logs tree
+	Sink     ArchiveSink // archive destination, defaults to the local disk under BasePath
+
+	// CreateBase creates a missing BasePath with DirPerm, e.g. on a fresh install, instead
+	// of failing the run with ErrLogBaseMissing
+	CreateBase bool
+
+	// DateSource how the age of a standard log file is determined, LogDateFromModTime by default
+	DateSource string
+
+	// DateLayouts optional layouts of the dated logs, e.g. "20060102" or "2006-01-02_15"
+	// for an hourly rotation. When set the date group matches the logs named after them,
+	// dated by their name and kept by days, see date_layouts.go
+	DateLayouts []string
+
+	// EmptyLogs handling of the zero-byte standard logs due for compression, EmptyLogsSkip by default
+	EmptyLogs string
+
+	// LockRetries rounds of retrying the logs locked by their writer, DefaultLockRetries
+	// when unset, negative to skip them right away. LockRetryDelay is waited before each
+	// round, DefaultLockRetryDelay when unset
+	LockRetries    int
+	LockRetryDelay time.Duration
+
+	// Location time zone of the dates in the log names and contents and of the retention
+	// cutoffs, the server's local time zone when unset
+	Location *time.Location `json:"-"`
+
+	// OperationLogLocation time zone of the day directories of the operation logs, the
+	// one their writer uses (public.OperationLogLocation) when unset
+	OperationLogLocation *time.Location `json:"-"`
+
+	// GuardRecentMonths never archives the operation log days named after the current or
+	// the previous month, compared as YYYY-MM strings apart from the date cutoff, so a
+	// skewed clock or a wrong time zone cannot archive recent audit logs early. The days it
+	// keeps are archived once their month is two months old. Off by default
+	GuardRecentMonths bool
+
+	// RecompressTo optional codec name, e.g. "zstd". When set, archives stored with
+	// RecompressFrom (gzip by default) are converted at the end of the run
+	RecompressFrom string
+	RecompressTo   string
+
+	// MaxRuntime optional bound of a run. Once exceeded no new file operation is
+	// started, the run ends as partial and the next run resumes by re-scanning
+	MaxRuntime time.Duration
+
+	// BacklogBatch optional bound of the logs compressed per run until the backlog of old
+	// logs found by the first run is worked off, see BacklogMigration. BacklogFull lifts
+	// the bound for the run and completes the migration in it
+	BacklogBatch int
+	BacklogFull  bool
+
+	// FilePerm and DirPerm modes of the archives, temporary files, index and
+	// directories created by the run, DefaultFilePerm and DefaultDirPerm when unset.
+	// They apply to the default local sink, a configured Sink uses its own
+	FilePerm os.FileMode
+	DirPerm  os.FileMode
+
+	// MergeGroups optional groups of service access logs merged into one log per day
+	// before the standard logs are processed, the merged logs are archived with them
+	MergeGroups []MergeGroup
+
+	// MinFreeBytes optional free space threshold of the log volume. Below it the run
+	// starts by deleting the oldest archives until the threshold is met again, before
+	// compressing anything
+	MinFreeBytes int64
+
+	// AdaptiveHeadroom optional free space kept on the log volume by the adaptive
+	// retention, which then replaces the FilesToKeep counts: the old logs are all
+	// compressed and the oldest standard log archives deleted only while the free space
+	// is below it. The archives younger than AdaptiveMinHistory (DefaultAdaptiveMinHistory
+	// when unset) are always kept
+	AdaptiveHeadroom   int64
+	AdaptiveMinHistory time.Duration
+
+	// Fsync flushes each archive and its directory to disk before the source is deleted,
+	// so a crash or power loss right after maintenance cannot lose both. It adds a disk
+	// flush per archive, which slows maintenance of many small files on busy disks, and
+	// is off by default: without it a crash may leave a source deleted whose archive was
+	// still in the page cache. It applies to the default local sink, a configured Sink
+	// uses its own
+	Fsync bool
+
+	// NormalizeArchives clears ownership and access times in the tar headers so the
+	// same directory content yields a byte-identical archive. Off by default to keep
+	// the original ownership in the archives
+	NormalizeArchives bool
+
+	// MaxArchiveBytes optional cap on the size of one stored object. The archive of an
+	// operation log day that could exceed it is split into volumes of at most this size,
+	// see volumes.go
+	MaxArchiveBytes int64
+
+	// RollupAfter optional age after which the archives of the standard log groups are
+	// bundled into one rollup per RollupGranularity period (RollupMonthly by default)
+	RollupAfter       time.Duration
+	RollupGranularity string
+
+	// MaxConcurrentUploads optional number of archives stored in the sink at the same
+	// time, uploads run one by one when it is 0 or 1. UploadTimeout bounds each attempt,
+	// a failed upload is retried UploadRetries times (DefaultUploadRetries when 0, never
+	// when negative) after UploadRetryDelay (DefaultUploadRetryDelay when unset). A source
+	// is only deleted once its own upload succeeded
+	MaxConcurrentUploads int
+	UploadTimeout        time.Duration
+	UploadRetries        int
+	UploadRetryDelay     time.Duration
+
+	// DeleteWorkers optional number of expired standard logs deleted at the same time by
+	// the retention, they are deleted one by one when it is 0 or 1. See deletions.go
+	DeleteWorkers int
+
+	// ConfirmRetries number of times a stored archive is read back again before its
+	// source is kept as unconfirmed (DefaultConfirmRetries when 0, never when negative),
+	// after ConfirmRetryDelay (DefaultConfirmRetryDelay when unset). See confirmArchive
+	ConfirmRetries    int
+	ConfirmRetryDelay time.Duration
+
+	// LogDirs optional options of the directories of the standard logs, keyed by their
+	// path relative to BasePath: "core", "core/out" or the target of a merge group. With
+	// Recursive their subdirectories are managed too, see LogDirOptions
+	LogDirs map[string]LogDirOptions
+
+	// ExternalSources optional directories out of BasePath other processes export their
+	// logs to, each managed as a group of its own, see ExternalLogSource
+	ExternalSources []ExternalLogSource `json:"-"`
+
+	// FileTimeout budget of the compression of one standard log, DefaultFileTimeout when
+	// unset, unbounded when negative. A log exceeding it is left intact for the next run
+	FileTimeout time.Duration
+
+	// ConcurrentOperationLogs processes the operation logs at the same time as the
+	// standard logs instead of after them, shortening the run on the servers with several
+	// disks or cores to spare. Off by default
+	ConcurrentOperationLogs bool
+
+	// CorruptLogs optional handling of the standard logs found corrupt before their
+	// compression, CorruptLogsQuarantine, CorruptLogsCompress or CorruptLogsSkip. The
+	// logs are not checked when unset. CorruptThreshold ratio of the invalid lines of a
+	// corrupt log, DefaultCorruptThreshold when unset
+	CorruptLogs      string
+	CorruptThreshold float64
+
+	// StrayFiles handling of the editor, temporary and hidden files left in the log
+	// directories, StrayFilesIgnore by default. StrayFileAge the age StrayFilesDelete
+	// deletes them at, DefaultStrayFileAge when unset, see stray_files.go
+	StrayFiles   string
+	StrayFileAge time.Duration
+
+	// ProtectedWindow logs modified within it are never deleted nor compressed, whatever
+	// the retention settings, DefaultProtectedWindow when unset. The newest log of each
+	// group is never deleted either
+	ProtectedWindow time.Duration
+
+	// ExcludeLogs optional patterns of the standard logs left as they are, neither deleted
+	// nor compressed, whatever the retention. A pattern with a slash is matched with
+	// path.Match against the path of the log relative to BasePath, a pattern without
+	// against its name. See decisions.go for the precedence of the rules
+	ExcludeLogs []string
+
+	// TrashGrace optional staging of the logs the retention deletes: they are moved to
+	// the trash of BasePath and deleted for good once older than it, or the oldest first
+	// while the trash exceeds TrashMaxBytes. The logs are deleted right away when unset,
+	// see trash.go
+	TrashGrace    time.Duration
+	TrashMaxBytes int64
+
+	// Namer optional naming scheme of the archives, DefaultNamer when unset. An archive
+	// named outside of BasePath fails, its source is kept
+	Namer Namer `json:"-"`
+
+	// RestoreDir parent of the operation log days restored by RestoreOperationLogDay, a
+	// directory below the system temporary directory when unset. Restored days older than
+	// RestoreTTL (DefaultRestoreTTL when unset) are deleted by the next restore or run
+	RestoreDir string
+	RestoreTTL time.Duration
+
+	// LogGroups optional groups of the standard logs, DefaultLogGroups when unset. Each
+	// group keeps its own newest logs. LogUnmanaged logs the *.log files matching none,
+	// which are otherwise skipped silently
+	LogGroups    []LogGroup `json:"-"`
+	LogUnmanaged bool
+
+	// ActiveLog optional hook naming the log being written of a group, never compressed
+	// nor deleted, in addition to the ActiveLink of the groups. DefaultConfig asks the
+	// logger of the server (LoggerActiveLog)
+	ActiveLog ActiveLogFunc `json:"-"`
+
+	// Role optional hook returning the role of this node when it shares the log volume
+	// with a standby, RolePrimary or RoleStandby. Only the primary deletes and compresses,
+	// on a standby the runs are skipped or, with Standby set to StandbyVerify, limited to
+	// a read-only verification. DefaultConfig reads it from the configuration
+	// (ConfiguredRole), every node is a primary when unset
+	Role    RoleFunc `json:"-"`
+	Standby string
+
+	// Audit optional hook receiving an AuditEvent for each log deleted and each source
+	// removed after compression. DefaultConfig writes them to the operation log
+	// (WriteAuditLog)
+	Audit AuditFunc `json:"-"`
+
+	// Signing optional hook returning the keys the archives are signed with, each archive
+	// stored then gets a signature of its hash next to it, see signing.go. DefaultConfig
+	// reads them from the configuration (ConfiguredSigning), nothing is signed when unset
+	Signing SigningFunc `json:"-"`
+
+	// Redactor optional redaction of the standard logs as they are compressed, the
+	// archives then hold the redacted lines only. Off by default, see DisplayRedactor
+	// for the lines shown through the API
+	Redactor *Redactor `json:"-"`
+
+	// Filters the filter chain of the archives, in order from the log to the stored bytes,
+	// DefaultFilters when empty. It holds one codec and the redaction when Redactor is
+	// set, see filters.go
+	Filters []string
+
+	// CompressionLevel level of the codec of the filter chain, its default level when 0.
+	// The codec must be a LeveledCodec, e.g. 1 to 9 for gzip and 1 to 22 for zstd
+	CompressionLevel int
+
+	// Retention optional retention of the standard logs of every group, the newest
+	// standardLogsKept logs without age limit when unset. RetentionOverrides replaces it
+	// for the groups named, its zero fields fall back to Retention
+	Retention          RetentionPolicy
+	RetentionOverrides map[string]RetentionPolicy
+
+	// ExistingArchives handling of the operation log directories whose archive already
+	// exists, left by an interrupted run. ExistingArchivesVerify by default
+	ExistingArchives string
+
+	// PartitionArchives stores the archives of the standard logs below YYYY/MM/ of their
+	// log directory by the date of the log, the active logs stay flat. Off by default
+	PartitionArchives bool
+
+	// CompressOnRotation compresses the log of the previous day as soon as its writer
+	// opened the log of a new day, rather than at the next daily run. Off by default
+	CompressOnRotation bool
+
+	// CompressionSlices optional staggering of the compression of the standard logs, the
+	// groups of a slice are compressed after the daily run rather than by it, see
+	// CompressionSlice. Every group not in a slice is compressed by the run
+	CompressionSlices []CompressionSlice
+
+	// Roots optional logs trees on other disks, each maintained by its own run in parallel
+	// with the tree of BasePath, the result sums them up. The free space thresholds apply
+	// to the filesystem of each tree. The merge groups, the compression slices and the
+	// compression on rotation only cover the tree of BasePath, the other trees compress
+	// every group in the run
+	Roots []LogRoot
+
+	// Tenants optional retention of the tenants of a shared deployment, keyed by tenant.
+	// When set, each directory below tenants/ of BasePath is the logs tree of a tenant,
+	// maintained on its own like a root, see TenantPolicy. Nil disables the tenants
+	Tenants map[string]TenantPolicy
+
+	// Push optional shipping of the lines of some log groups to a Loki or OTLP log store,
+	// run by PushLogs apart from the maintenance runs, see LogPush. Nil disables it
+	Push *LogPush
+
+	// RunInterval interval of the scheduled runs, DefaultRunInterval when unset. The
+	// watchdog reports the maintenance stale once no run completed within StaleAfter
+	// intervals (DefaultStaleAfter when unset), see watchdog.go
+	RunInterval time.Duration
+	StaleAfter  int
+
+	// Progress optional channel receiving live updates, closed when the run ends.
+	// Updates are dropped when the consumer is not ready, so it never stalls the run
+	Progress chan<- MaintenanceProgress `json:"-"`
+}
+
+// MaintenanceProgress incremental update of a running maintenance
+type MaintenanceProgress struct {
+	CurrentFile    string `json:"current_file"`
+	BytesProcessed int64  `json:"bytes_processed"` // size of the source logs handled so far
+	BytesReclaimed int64  `json:"bytes_reclaimed"` // disk space freed so far
+	FilesDone      int    `json:"files_done"`
+	FilesRemaining int    `json:"files_remaining"`
+}
+
+// MaintenanceResult outcome of a maintenance run
+type MaintenanceResult struct {
+	StartedAt      time.Time     `json:"started_at"`
+	Duration       time.Duration `json:"duration"`
+	FilesDone      int           `json:"files_done"`
+	BytesProcessed int64         `json:"bytes_processed"`
+	BytesReclaimed int64         `json:"bytes_reclaimed"`
+	Errors         int           `json:"errors"` // file operations that failed
+
+	// Failures the failed operations as *MaintenanceError, see Err
+	Failures []error `json:"-"`
+
+	// Partial the run stopped at MaxRuntime, LastFile is the last file handled before stopping
+	Partial  bool   `json:"partial"`
+	LastFile string `json:"last_file,omitempty"`
+
+	// SkippedEmpty zero-byte logs left uncompressed, DeletedEmpty those deleted (EmptyLogsDelete)
+	SkippedEmpty int `json:"skipped_empty,omitempty"`
+	DeletedEmpty int `json:"deleted_empty,omitempty"`
+
+	// Locked logs still locked by their writer after the retries, left for the next run
+	Locked []string `json:"locked,omitempty"`
+
+	// OverBudget logs whose compression exceeded FileTimeout, left for the next run
+	OverBudget []string `json:"over_budget,omitempty"`
+
+	// Corrupt logs found corrupt and quarantined or skipped, see CorruptLogs
+	Corrupt []string `json:"corrupt,omitempty"`
+
+	// Protected logs the retention policy would have deleted, kept by ProtectedWindow
+	Protected []string `json:"protected,omitempty"`
 
+	// Stray files found in the log directories, see StrayFiles
+	Stray []StrayFile `json:"stray,omitempty"`
+
+	// Overlapping names of the logs matching several log groups, kept in the first
+	Overlapping []string `json:"overlapping,omitempty"`
+
+	// UploadsSucceeded archives stored in the sink, UploadsFailed those that failed after
+	// the retries, their sources were kept. UploadsRetried failed attempts that were retried
+	UploadsSucceeded int `json:"uploads_succeeded"`
+	UploadsFailed    int `json:"uploads_failed,omitempty"`
+	UploadsRetried   int `json:"uploads_retried,omitempty"`
+	PeakUploads      int `json:"peak_uploads,omitempty"` // most uploads in flight at once
+
+	// Deleted standard logs the retention deleted or staged in the trash, DeletionRate
+	// their throughput in logs per second spent deleting
+	Deleted      int     `json:"deleted,omitempty"`
+	DeletionRate float64 `json:"deletion_rate,omitempty"`
+
+	// Emergency free space was below MinFreeBytes, the oldest archives were deleted first
+	Emergency        bool  `json:"emergency,omitempty"`
+	EmergencyDeleted int   `json:"emergency_deleted,omitempty"`
+	EmergencyFreed   int64 `json:"emergency_freed,omitempty"`
+
+	// DiskFull a compression ran out of space and switched the run to the emergency
+	// cleanup, the logs that still did not fit failed with ErrDiskFull
+	DiskFull bool `json:"disk_full,omitempty"`
+
+	// ReadOnly the log volume was read-only, the run stopped before deleting or compressing
+	// anything, see ErrReadOnlyFilesystem
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// BaseMissing BasePath did not exist or was not a directory, the run did nothing, see
+	// ErrLogBaseMissing
+	BaseMissing bool `json:"base_missing,omitempty"`
+
+	// Standby why the node did not delete nor compress anything, see Role. Verification
+	// is the read-only verification run instead with StandbyVerify
+	Standby      string        `json:"standby,omitempty"`
+	Verification *VerifyResult `json:"verification,omitempty"`
+
+	// Adaptive outcome of the adaptive retention, see AdaptiveHeadroom
+	Adaptive *AdaptiveRetentionResult `json:"adaptive,omitempty"`
+
+	// WORMLocks object locks verified on the archives stored by the run, see ArchiveLocker
+	WORMLocks []AppliedLock `json:"worm_locks,omitempty"`
+
+	// Pinned logs, operation log days and archives left as they are, see PinLog
+	Pinned []string `json:"pinned,omitempty"`
+
+	// Recovered operation log directories left partially removed by an earlier run, whose
+	// removal was completed, see completeInterruptedRemovals
+	Recovered []string `json:"recovered,omitempty"`
+
+	// Backlog progress of the backlog migration while this run applied it, see BacklogBatch
+	Backlog *BacklogMigration `json:"backlog,omitempty"`
+
+	// Trash the sweep of the staged logs at the end of the run, see TrashGrace
+	Trash *TrashSweep `json:"trash,omitempty"`
+
+	// Slice the compression slice of the run, empty for the daily run. Groups the groups
+	// whose logs the run compressed, DeferredGroups those left to their slice
+	Slice          string   `json:"slice,omitempty"`
+	Groups         []string `json:"groups,omitempty"`
+	DeferredGroups []string `json:"deferred_groups,omitempty"`
+
+	// BasePath the logs tree of the run, Tenant its tenant. Roots the result of each tree
+	// when Roots or Tenants are configured, this result is then their sum
+	BasePath string              `json:"base_path,omitempty"`
+	Tenant   string              `json:"tenant,omitempty"`
+	Roots    []MaintenanceResult `json:"roots,omitempty"`
+}
+
+// DefaultConfig returns the configuration used by the scheduled maintenance
+func DefaultConfig() MaintenanceConfig {
 	baseLogPath := public.AbsPath("../logs")
+
+	return MaintenanceConfig{
+		BasePath: baseLogPath,
+		Sink:     NewLocalSink(baseLogPath),
+		FilePerm: DefaultFilePerm,
+		DirPerm:  DefaultDirPerm,
+
+		ActiveLog: LoggerActiveLog,
+		Audit:     WriteAuditLog,
+		Role:      ConfiguredRole,
+		Signing:   ConfiguredSigning,
+
+		// The scheduled run repeats daily, stay well within that window
+		MaxRuntime: 6 * time.Hour,
+
+		BacklogBatch: DefaultBacklogBatch,
+	}
+}
+
+// standardLogsKept newest logs of a group kept, the older ones are deleted
+const standardLogsKept = 30
+
+// maintenanceRun state of a single maintenance run
+// newMaintenanceRun the run of cfg with its effective date cache and valid log groups.
+// The runs over the logs add the index, the uploads and the pins, see startMaintenanceRun
+func newMaintenanceRun(ctx context.Context, cfg MaintenanceConfig) *maintenanceRun {
+	return &maintenanceRun{
+		cfg:       cfg,
+		dates:     make(map[string]time.Time),
+		logGroups: validLogGroups(ctx, cfg.LogGroups),
+	}
+}
+
+// startMaintenanceRun the run of a maintenance pass started at startedAt: the archive
+// index loaded, the upload pool, the pins and the deadline of MaxRuntime. The caller
+// saves the index once done
+func startMaintenanceRun(ctx context.Context, cfg MaintenanceConfig, startedAt time.Time) *maintenanceRun {
+	m := newMaintenanceRun(ctx, cfg)
+	m.index = loadArchiveIndex(cfg.BasePath, cfg.FilePerm)
+	m.uploads = newUploadPool(cfg.MaxConcurrentUploads)
+	m.loadRunPins(ctx)
+
+	if cfg.MaxRuntime > 0 {
+		m.deadline = startedAt.Add(cfg.MaxRuntime)
+	}
+	return m
+}
+
+type maintenanceRun struct {
+	cfg MaintenanceConfig
+
+	logGroups []LogGroup // set by RunMaintenance, the defaults when nil
+
+	index *archiveIndex
+	dates map[string]time.Time // effective date cache
+
+	deadline time.Time // zero when the run is unbounded
+	partial  bool
+
+	mu       sync.Mutex // guards lastFile, locked, wormLocks, pinned, partial, corrupt, partialArchives, overlapping, trashBatch and stray, updated concurrently
+	lastFile string
+
+	emergency        bool
+	emergencyDeleted int
+	emergencyFreed   int64
+
+	diskFullMu sync.Mutex // guards the emergency fields once the uploads run, see diskFullCleanup
+	diskFull   bool
+
+	readOnly bool // set by checkWritable
+
+	trashBatch string // batch of the logs staged in the trash by the run, see discardLog
+
+	stray []StrayFile // see handleStrayFiles
+
+	backlog *backlogRun // nil without backlog migration
+
+	pins   map[string]bool // absolute paths pinned, see PinLog
+	pinned []string
+
+	recovered []string // see completeInterruptedRemovals
+
+	deletions *deletionPool // created by the first deletion, see startDeletion
+
+	signingOnce sync.Once // loads signingKeys, see signing
+	signingKeys *ArchiveSigning
+	signingErr  error
+
+	slice            *CompressionSlice // set on the run of a compression slice
+	compressedGroups map[string]bool
+	deferredGroups   map[string]bool
+
+	skippedEmpty   int
+	deletedEmpty   int
+	locked         []lockedLog
+	overBudgetLogs []string
+	corrupt        []string
+	protected      []string
+	wormLocks      []AppliedLock
+
+	partialArchives map[string]partialArchive // loaded on first use, see discardPartialArchive
+
+	overlapping map[string][]string // file names matching several groups, their groups
+
+	filesTotal     int
+	filesDone      atomic.Int64
+	bytesProcessed atomic.Int64
+	bytesReclaimed atomic.Int64
+	failures       atomic.Int64
+	failureList    failureList
+
+	uploads          *uploadPool
+	uploadsSucceeded atomic.Int64
+	uploadsFailed    atomic.Int64
+	uploadsRetried   atomic.Int64
+}
+
+func CompressAndCleanupLogs(ctx context.Context) {
+	DefaultService().Run(ctx)
+}
+
+// RunMaintenance compresses and cleans up the logs with the given configuration
+func RunMaintenance(ctx context.Context, cfg MaintenanceConfig) MaintenanceResult {
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	if cfg.BasePath == "" {
+		cfg.BasePath = public.AbsPath("../logs")
+	}
+
+	if len(cfg.Roots) > 0 || cfg.Tenants != nil {
+		return runRoots(ctx, cfg)
+	}
+	return runMaintenance(ctx, cfg)
+}
+
+// runMaintenance the run of the logs tree of cfg.BasePath, the caller holds runMutex
+func runMaintenance(ctx context.Context, cfg MaintenanceConfig) MaintenanceResult {
+	cfg.FilePerm = permOrDefault(cfg.FilePerm, DefaultFilePerm)
+	cfg.DirPerm = permOrDefault(cfg.DirPerm, DefaultDirPerm)
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.OperationLogLocation == nil {
+		cfg.OperationLogLocation = public.OperationLogLocation()
+	}
+
+	if cfg.Sink == nil {
+		cfg.Sink = &LocalSink{Root: cfg.BasePath, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm, Fsync: cfg.Fsync, FsyncDir: cfg.Fsync}
+	}
+
+	if err := checkLogBase(ctx, cfg); err != nil {
+		if cfg.Progress != nil {
+			close(cfg.Progress)
+		}
+		return baseMissingRun(ctx, cfg, err)
+	}
+
+	// Nothing is written on a standby, not even the index nor the history
+	if reason := standbyReason(ctx, cfg); reason != "" {
+		if cfg.Progress != nil {
+			close(cfg.Progress)
+		}
+		return standbyRun(ctx, cfg, reason)
+	}
+
+	startedAt := time.Now()
+	m := startMaintenanceRun(ctx, cfg, startedAt)
+	defer m.index.save(ctx)
+
+	if cfg.Progress != nil {
+		defer close(cfg.Progress)
+	}
+
+	baseLogPath := cfg.BasePath
 	operationLogDir := filepath.Join(baseLogPath, "core", "operation_log")
 
-	standardLogDirs := []string{
-		filepath.Join(baseLogPath, "core"),
-		filepath.Join(baseLogPath, "core", "out"),
+	standardLogDirs := m.standardLogDirs()
+
+	now := timeNow().In(cfg.Location)
+	oneDayAgo := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, cfg.Location)
+
+	oneMonthAgo := operationLogCutoff(timeNow().In(cfg.OperationLogLocation))
+
+	if cfg.Progress != nil {
+		m.filesTotal = m.countCandidates(standardLogDirs, operationLogDir)
+	}
+
+	// --- Probe the volume, nothing is deleted nor compressed on a read-only one ---
+	var locked []string
+	var adaptive *AdaptiveRetentionResult
+	var backlog *BacklogMigration
+	var trash *TrashSweep
+	if m.checkWritable(ctx) {
+		m.startBacklog(ctx)
+
+		// Complete first the removals an interrupted run left, before the directories are
+		// taken for incompletely archived ones
+		if gfile.Exists(operationLogDir) {
+			m.completeInterruptedRemovals(ctx, operationLogDir)
+		}
+
+		// --- 0. Make room first when the volume is nearly full ---
+		if cfg.MinFreeBytes > 0 {
+			m.emergencyCleanup(ctx)
+		}
+
+		// --- 1. Merge the access logs of the merge groups ---
+		for _, group := range cfg.MergeGroups {
+			if m.outOfTime() {
+				break
+			}
+			m.mergeAccessLogs(ctx, group, oneDayAgo)
+		}
+
+		// --- 2. Handle regular logs (core, out) ---
+		// --- 3. Special processing operation log (operation_log) ---
+		if cfg.ConcurrentOperationLogs {
+			m.processConcurrently(ctx,
+				func() { m.processStandardLogDirs(ctx, standardLogDirs, oneDayAgo) },
+				func() { m.processOperationLogDir(ctx, operationLogDir, oneMonthAgo) },
+			)
+			m.waitUploads()
+			locked = m.retryLockedLogs(ctx)
+		} else {
+			m.processStandardLogDirs(ctx, standardLogDirs, oneDayAgo)
+			m.waitUploads()
+			locked = m.retryLockedLogs(ctx)
+
+			m.processOperationLogDir(ctx, operationLogDir, oneMonthAgo)
+			m.waitUploads()
+		}
+
+		m.cleanupRestored(ctx)
+
+		// --- 4. Optional compaction of the old archives into rollups ---
+		if cfg.RollupAfter > 0 {
+			m.compactArchives(ctx, []string{"core", "core/out"})
+		}
+
+		// --- 5. Optional adaptive retention, by the free space left ---
+		if m.adaptive() {
+			adaptive = m.adaptiveCleanup(ctx)
+		}
+
+		// --- 6. Optional recompression of the existing archives ---
+		if cfg.RecompressTo != "" {
+			from, okFrom := CodecByName(cfg.RecompressFrom)
+			to, okTo := CodecByName(cfg.RecompressTo)
+
+			if !okFrom || !okTo {
+				g.Log().Warningf(ctx, "Unknown recompression codec %q -> %q; skipped.", cfg.RecompressFrom, cfg.RecompressTo)
+			} else if _, err := m.recompress(ctx, from, to); err != nil {
+				g.Log().Errorf(ctx, "Recompression of the archives failed: %v", err)
+				m.fail(ErrCompress, baseLogPath, err)
+			}
+		}
+
+		// --- 7. Delete the staged logs past their grace period ---
+		if cfg.TrashGrace > 0 {
+			sweep, err := m.sweepTrash(ctx, false)
+			if err != nil {
+				g.Log().Warningf(ctx, "Sweep of the log trash failed: %v", err)
+				m.fail(ErrDelete, filepath.Join(baseLogPath, trashDir), err)
+			}
+			trash = &sweep
+		}
+
+		backlog = m.finishBacklog(ctx)
+	}
+
+	deleted, deletionRate := m.deletionStats()
+
+	overlapping, described := m.overlappingLogs()
+	if len(overlapping) > 0 {
+		g.Log().Warningf(ctx, "Logs matching several log groups, each kept in the first: %s", described)
+	}
+
+	result := MaintenanceResult{
+		StartedAt:      startedAt,
+		Duration:       time.Since(startedAt),
+		FilesDone:      int(m.filesDone.Load()),
+		BytesProcessed: m.bytesProcessed.Load(),
+		BytesReclaimed: m.bytesReclaimed.Load(),
+		Errors:         int(m.failures.Load()),
+		Failures:       m.failureList.list(),
+		Partial:        m.partial,
+		LastFile:       m.lastFile,
+
+		SkippedEmpty: m.skippedEmpty,
+		DeletedEmpty: m.deletedEmpty,
+		Locked:       locked,
+		OverBudget:   m.overBudgetLogs,
+		Corrupt:      m.corrupt,
+		Protected:    m.protected,
+		Pinned:       m.pinned,
+		Recovered:    m.recovered,
+		Overlapping:  overlapping,
+		Stray:        m.stray,
+
+		UploadsSucceeded: int(m.uploadsSucceeded.Load()),
+		UploadsFailed:    int(m.uploadsFailed.Load()),
+		UploadsRetried:   int(m.uploadsRetried.Load()),
+		PeakUploads:      m.peakUploads(),
+
+		Deleted:      deleted,
+		DeletionRate: deletionRate,
+
+		Emergency:        m.emergency,
+		EmergencyDeleted: m.emergencyDeleted,
+		EmergencyFreed:   m.emergencyFreed,
+		DiskFull:         m.diskFull,
+
+		ReadOnly: m.readOnly,
+
+		WORMLocks: m.wormLocks,
+		Adaptive:  adaptive,
+		Backlog:   backlog,
+		Trash:     trash,
+
+		Groups:         groupNames(m.compressedGroups),
+		DeferredGroups: groupNames(m.deferredGroups),
+	}
+
+	if result.ReadOnly {
+		// The history cannot be written either, the failure is in the result and the logs
+		return result
+	}
+
+	if result.Partial {
+		g.Log().Warningf(ctx, "Log maintenance stopped after %s (max runtime %s), %d files done, last %s, %d bytes reclaimed",
+			result.Duration.Round(time.Second), cfg.MaxRuntime, result.FilesDone, result.LastFile, result.BytesReclaimed)
+	} else {
+		g.Log().Infof(ctx, "Log maintenance completed, %d bytes reclaimed", result.BytesReclaimed)
+	}
+	if len(result.DeferredGroups) > 0 {
+		g.Log().Infof(ctx, "Compression of the groups %s left to their compression slices", strings.Join(result.DeferredGroups, ", "))
+	}
+
+	recordRun(ctx, cfg.BasePath, cfg.FilePerm, result)
+
+	return result
+}
+
+// location time zone of the log dates, set by RunMaintenance
+func (m *maintenanceRun) location() *time.Location {
+	if m.cfg.Location == nil {
+		return time.Local
+	}
+	return m.cfg.Location
+}
+
+// operationLogLocation time zone of the operation log days, set by RunMaintenance
+func (m *maintenanceRun) operationLogLocation() *time.Location {
+	if m.cfg.OperationLogLocation == nil {
+		return m.location()
+	}
+	return m.cfg.OperationLogLocation
+}
+
+// outOfTime reports whether the run exceeded MaxRuntime, no new file operation should start then
+func (m *maintenanceRun) outOfTime() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.partial {
+		return true
+	}
+
+	if !m.deadline.IsZero() && time.Now().After(m.deadline) {
+		m.partial = true
 	}
 
-	now := time.Now()
-	oneDayAgo := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return m.partial
+}
 
-	oneMonthAgo := now.AddDate(0, -1, 0)
+// countCandidates counts the files and directories a run will visit, used for progress reporting
+func (m *maintenanceRun) countCandidates(standardLogDirs []string, operationLogDir string) int {
+	total := 0
 
-	// --- 1. Handle regular logs (core, out) ---
 	for _, dir := range standardLogDirs {
+		files, _ := m.scanLogDir(context.Background(), dir)
+		for _, file := range files {
+			if m.groupOfLog(file) != "" {
+				total++
+			}
+		}
+	}
+
+	entries, _ := os.ReadDir(operationLogDir)
+	for _, entry := range entries {
+		if _, err := time.ParseInLocation("2006-01-02", entry.Name(), m.operationLogLocation()); err == nil && entry.IsDir() {
+			total++
+		}
+	}
+
+	return total
+}
+
+// fileDone accounts a visited file and sends a progress update
+func (m *maintenanceRun) fileDone(path string, processed, reclaimed int64) {
+	done := m.filesDone.Add(1)
+	m.mu.Lock()
+	m.lastFile = path
+	m.mu.Unlock()
+	m.bytesProcessed.Add(processed)
+	m.bytesReclaimed.Add(reclaimed)
+
+	if m.cfg.Progress == nil {
+		return
+	}
+
+	remaining := m.filesTotal - int(done)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	select {
+	case m.cfg.Progress <- MaintenanceProgress{
+		CurrentFile:    path,
+		BytesProcessed: m.bytesProcessed.Load(),
+		BytesReclaimed: m.bytesReclaimed.Load(),
+		FilesDone:      int(done),
+		FilesRemaining: remaining,
+	}:
+	default:
+	}
+}
+
+// Namer returns the path of the archive of sourcePath, a log file or an operation log
+// directory. ext is the suffix of the archive format, the codec extension such as ".gz",
+// preceded by ".tar" for a directory. Relative results are resolved against BasePath.
+// Rollups only bundle the archives stored in the directory of their logs
+type Namer func(sourcePath, ext string) string
+
+// DefaultNamer stores the archive next to its source, e.g. access-20250101.log.gz
+func DefaultNamer(sourcePath, ext string) string {
+	return sourcePath + ext
+}
+
+// archiveName returns the sink name of an archive produced from a path below BasePath
+// or of an external source, as named by the configured Namer
+func (m *maintenanceRun) archiveName(path, ext string) (string, error) {
+	namer := m.cfg.Namer
+	if namer == nil {
+		namer = DefaultNamer
+	}
+
+	target := filepath.Clean(namer(m.archivePathOf(path), ext))
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(m.cfg.BasePath, target)
+	}
+	if target == filepath.Clean(path) {
+		return "", fmt.Errorf("archive of %s would replace its source", path)
+	}
+
+	rel, err := filepath.Rel(m.cfg.BasePath, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive %s of %s is outside of the log base %s", target, path, m.cfg.BasePath)
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// processStandardLogDirs processes the standard logs of the existing dirs
+func (m *maintenanceRun) processStandardLogDirs(ctx context.Context, dirs []string, oneDayAgo time.Time) {
+	for _, dir := range dirs {
+		if m.outOfTime() {
+			break
+		}
+
 		if !gfile.Exists(dir) {
 			g.Log().Debugf(ctx, "Regular log directory '%s' does not exist; skipped.", dir)
 			continue
 		}
 
-		processStandardLogs(ctx, dir, oneDayAgo)
-	}
-	// --- 2. Special processing operation log (operation_log) ---
-	if !gfile.Exists(operationLogDir) {
-		g.Log().Debugf(ctx, "Operation log directory '%s' does not exist. Skipping.", operationLogDir)
-	} else {
-		processOperationLogs(ctx, operationLogDir, oneMonthAgo)
+		m.handleStrayFiles(ctx, dir)
+		m.processStandardLogs(ctx, dir, oneDayAgo)
 	}
+}
 
+// retryLockedLogs retries the logs skipped as locked, once their uploads are done, and
+// returns those still locked
+func (m *maintenanceRun) retryLockedLogs(ctx context.Context) []string {
+	if m.cfg.LockRetries >= 0 {
+		m.retryLocked(ctx)
+	}
+	return m.lockedPaths(ctx)
 }
-func processStandardLogs(ctx context.Context, dir string, oneDayAgo time.Time) {
 
-	allLogFiles, err := gfile.ScanDir(dir, "*.log", false)
+func (m *maintenanceRun) processStandardLogs(ctx context.Context, dir string, oneDayAgo time.Time) {
+
+	allLogFiles, err := m.scanLogDir(ctx, dir)
 	if err != nil {
 		g.Log().Errorf(ctx, "Failed to scan log directory %s: %v", dir, err)
+		m.fail(ErrScan, dir, err)
 		return
 	}
 
-	// Group by file name prefix
+	// Group by file name
 	logGroups := make(map[string][]string)
-	dateLogPattern := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\.log$`)
+	active := m.activeLogsOf(ctx, dir, allLogFiles)
 
 	for _, file := range allLogFiles {
-		filename := filepath.Base(file)
-		if strings.HasPrefix(filename, "access-") {
-			logGroups["access"] = append(logGroups["access"], file)
-		} else if strings.HasPrefix(filename, "error-") {
-			logGroups["error"] = append(logGroups["error"], file)
-		} else if dateLogPattern.MatchString(filename) {
-			logGroups["date"] = append(logGroups["date"], file)
+		if info, err := os.Lstat(file); err == nil && specialFile(info) {
+			g.Log().Warningf(ctx, "Log %s is not a regular file (%s), skipped", file, info.Mode().Type())
+			continue
+		}
+		if m.isActiveLink(file) {
+			continue
+		}
+		if group := m.groupOfLog(file); group != "" {
+			logGroups[group] = append(logGroups[group], file)
+		} else if m.cfg.LogUnmanaged {
+			g.Log().Infof(ctx, "Log %s matches no log group, left unmanaged", file)
 		}
 	}
 
 	// Process each group independently
-	for _, files := range logGroups {
+	for group, files := range logGroups {
+		if m.skipGroup(group) {
+			continue
+		}
+
+		// The logs are stat once for the sort and the retention decisions
+		stats := make(map[string]logStat, len(files))
+		for _, file := range files {
+			info, err := os.Stat(file)
+			stats[file] = logStat{info: info, err: err}
+		}
+
 		sort.Slice(files, func(i, j int) bool {
-			infoI, _ := os.Stat(files[i])
-			infoJ, _ := os.Stat(files[j])
+			infoI, infoJ := stats[files[i]].info, stats[files[j]].info
 			if infoI == nil || infoJ == nil {
 				return false
 			}
-			return infoI.ModTime().Before(infoJ.ModTime())
+			return m.effectiveDate(files[i], infoI).Before(m.effectiveDate(files[j], infoJ))
 		})
 
 		// Cleaning and compression logic
-		filesToKeep := 30
+		policy := m.retentionOf(group)
+		keepFrom := m.keepFrom(group, files, policy.FilesToKeep)
 		// Start traversing from the oldest file
 		for i, path := range files {
-			// If the file index is less than the number of files to be deleted, then delete them directly.
-			if i < len(files)-filesToKeep {
-				g.Log().Infof(ctx, "The number of logs has exceeded the limit. Delete the old logs: %s", path)
-				os.Remove(path)
-				continue
+			if m.outOfTime() {
+				m.waitDeletions()
+				return
 			}
 
-			info, err := os.Stat(path)
-			if err != nil {
-				continue
+			info, statErr := stats[path].info, stats[path].err
+			file := logCandidate{
+				path:      path,
+				info:      info,
+				newest:    i == len(files)-1,
+				overCount: !m.adaptive() && i < keepFrom,
+				active:    active[filepath.Clean(path)],
+				cutoff:    oneDayAgo,
+			}
+			action := m.decideAction(file, policy)
+
+			// The stat of the group may be old by the time a log is compressed
+			if action == ActionCompress {
+				if file.info, statErr = os.Stat(path); statErr != nil {
+					m.fileDone(path, 0, 0)
+					continue
+				}
+				info = file.info
+				action = m.decideAction(file, policy)
 			}
-			// Only compress files from today and earlier.
-			if info.ModTime().Before(oneDayAgo) {
 
-				if err := compressFile(path); err == nil {
-					os.Remove(path)
+			switch action {
+			case ActionPinned:
+				m.keepPinned(ctx, path)
+
+			case ActionExcluded:
+				g.Log().Debugf(ctx, "Log %s is excluded, left as is", path)
+				m.fileDone(path, 0, 0)
+
+			case ActionActive:
+				// Being written, whatever its date and rank
+				g.Log().Debugf(ctx, "Log %s is being written, skipped", path)
+				m.fileDone(path, 0, 0)
+
+			case ActionProtected:
+				// The newest log is only protected from the deletions
+				m.keepProtected(ctx, path, info, file.newest && (file.overCount || m.expiredLog(path, info, policy)))
+
+			case ActionDelete:
+				// Files beyond the number kept or older than the maximum age are deleted directly.
+				// The adaptive retention keeps them all, then deletes by the free space
+				var size int64
+				if statErr == nil {
+					size = info.Size()
+				}
+				expired := m.expiredLog(path, info, policy)
+				rule := fmt.Sprintf("group %s keeps its %d newest logs", group, policy.FilesToKeep)
+				if group == dateLogGroup && len(m.cfg.DateLayouts) > 0 {
+					rule = fmt.Sprintf("group %s keeps its logs of the %d newest days", group, policy.FilesToKeep)
+				}
+				if expired {
+					rule = fmt.Sprintf("group %s keeps logs for %s", group, policy.MaxAge)
+					g.Log().Infof(ctx, "The log is older than the %s retention of group %s. Delete it: %s", policy.MaxAge, group, path)
 				} else {
-					g.Log().Errorf(ctx, "Compression of file %s failed: %v", path, err)
+					g.Log().Infof(ctx, "The number of logs has exceeded the limit. Delete the old logs: %s", path)
+				}
+				m.startDeletion(func() bool {
+					trashed, err := m.discardLog(path)
+					if err != nil {
+						g.Log().Warningf(ctx, "Failed to delete the old log %s: %v", path, err)
+						m.fail(ErrDelete, path, err)
+						size = 0
+					} else if trashed != "" {
+						m.audit(ctx, AuditTrash, path, size, rule, "")
+					} else {
+						m.audit(ctx, AuditDelete, path, size, rule, "")
+					}
+					m.fileDone(path, size, size)
+					return err == nil
+				})
+
+			case ActionCompress:
+				if info.Size() == 0 && m.cfg.EmptyLogs != EmptyLogsCompress {
+					m.handleEmptyLog(ctx, path)
+					continue
+				}
+				if m.deferCompression(group) || !m.claimBacklog() {
+					m.fileDone(path, 0, 0)
+					continue
 				}
+				if m.handleCorruptLog(ctx, path, info) {
+					continue
+				}
+				m.compressing(group)
+				m.compressStandardLog(ctx, path, info)
+
+			default:
+				m.fileDone(path, 0, 0)
 			}
 		}
+
+		m.waitDeletions()
+	}
+}
+
+// logStat the stat of a log, taken once for the retention of its group
+type logStat struct {
+	info os.FileInfo
+	err  error
+}
+
+// compressStandardLog archives a standard log and removes it once the archive is stored.
+// A log locked by its writer is kept for retryLocked instead
+func (m *maintenanceRun) compressStandardLog(ctx context.Context, path string, info os.FileInfo) {
+	if err := checkUnlocked(path); err != nil {
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("%s %w", path, errLogVanished)
+		}
+		m.standardLogFailed(ctx, path, info, err)
+		return
 	}
+
+	m.startUpload(func() {
+		fctx, cancel := m.fileBudget(ctx)
+		defer cancel()
+
+		written, err := m.upload(fctx, path, func(ctx context.Context) (int64, error) {
+			return m.archiveFile(ctx, path)
+		})
+		if err != nil && !isOverBudget(err) && isOverBudget(context.Cause(fctx)) {
+			err = fmt.Errorf("%w: %v", errFileBudget, err)
+		}
+		if err != nil {
+			m.standardLogFailed(ctx, path, info, err)
+			return
+		}
+
+		m.removeStandardLog(ctx, path, info, written)
+	})
+}
+
+// standardLogFailed accounts a standard log that could not be archived
+func (m *maintenanceRun) standardLogFailed(ctx context.Context, path string, info os.FileInfo, err error) {
+	if isLocked(err) {
+		g.Log().Debugf(ctx, "Log %s is locked, retried later: %v", path, err)
+		m.mu.Lock()
+		m.locked = append(m.locked, lockedLog{path: path, info: info})
+		m.mu.Unlock()
+		return
+	}
+
+	if isRotated(err) {
+		g.Log().Infof(ctx, "Log %v, left for the next run", err)
+		m.fileDone(path, 0, 0)
+		return
+	}
+
+	if isOverBudget(err) {
+		m.overBudget(ctx, path)
+		return
+	}
+
+	kind := ErrCompress
+	if isDiskFull(err) {
+		kind = ErrDiskFull
+	}
+
+	g.Log().Errorf(ctx, "Compression of file %s failed: %v", path, err)
+	m.fail(kind, path, err)
+	m.fileDone(path, 0, 0)
+}
+
+// removeStandardLog deletes a standard log whose archive is stored
+func (m *maintenanceRun) removeStandardLog(ctx context.Context, path string, info os.FileInfo, written int64) {
+	reclaimed := int64(0)
+	if err := os.Remove(path); err == nil {
+		reclaimed = info.Size() - written
+		archive, _ := m.standardArchiveName(path, info)
+		m.audit(ctx, AuditRemoveCompressed, path, info.Size(), "standard logs are compressed after one day", archive)
+	} else {
+		g.Log().Warningf(ctx, "Failed to delete the compressed log %s: %v", path, err)
+		m.fail(ErrDelete, path, err)
+	}
+	m.fileDone(path, info.Size(), reclaimed)
+}
+
+// handleEmptyLog skips a zero-byte log due for compression, or deletes it with EmptyLogsDelete
+func (m *maintenanceRun) handleEmptyLog(ctx context.Context, path string) {
+	defer m.fileDone(path, 0, 0)
+
+	if m.cfg.EmptyLogs != EmptyLogsDelete {
+		m.skippedEmpty++
+		return
+	}
+
+	if err := os.Remove(path); err != nil {
+		g.Log().Warningf(ctx, "Failed to delete the empty log %s: %v", path, err)
+		m.fail(ErrDelete, path, err)
+		return
+	}
+	m.deletedEmpty++
+	m.audit(ctx, AuditDelete, path, 0, "empty logs are deleted (EmptyLogsDelete)", "")
+}
+
+// operationLogCutoff local midnight of the day one month before now, operation log
+// directories dated before it are archived. The day is clamped to the end of shorter
+// months, so on March 31 the cutoff is February 28 (or 29) rather than March 3
+func operationLogCutoff(now time.Time) time.Time {
+	year, month, day := now.Date()
+
+	firstOfPrevious := time.Date(year, month-1, 1, 0, 0, 0, 0, now.Location())
+	if last := firstOfPrevious.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+
+	return time.Date(firstOfPrevious.Year(), firstOfPrevious.Month(), day, 0, 0, 0, 0, now.Location())
+}
+
+// guardedMonths the YYYY-MM names of the current and the previous month with
+// GuardRecentMonths, nil without
+func (m *maintenanceRun) guardedMonths() map[string]bool {
+	if !m.cfg.GuardRecentMonths {
+		return nil
+	}
+
+	now := timeNow().In(m.operationLogLocation())
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	return map[string]bool{
+		now.Format("2006-01"):                            true,
+		firstOfMonth.AddDate(0, 0, -1).Format("2006-01"): true,
+	}
+}
+
+// operationLogRule retention rule of the operation log directories, in the audit events
+const operationLogRule = "operation log days are compressed after one month"
+
+// processOperationLogDir processes the operation logs of dir when it exists
+func (m *maintenanceRun) processOperationLogDir(ctx context.Context, dir string, oneMonthAgo time.Time) {
+	if !gfile.Exists(dir) {
+		g.Log().Debugf(ctx, "Operation log directory '%s' does not exist. Skipping.", dir)
+		return
+	}
+
+	m.processOperationLogs(ctx, dir, oneMonthAgo)
 }
 
 // processOperationLogs Handle operation log: Compress the entire date directory from one month ago
-func processOperationLogs(ctx context.Context, dir string, oneMonthAgo time.Time) {
+func (m *maintenanceRun) processOperationLogs(ctx context.Context, dir string, oneMonthAgo time.Time) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return
 	}
 
+	guarded := m.guardedMonths()
+
 	for _, entry := range entries {
+		if m.outOfTime() {
+			return
+		}
+
 		// Only process the directories, and the directory names should be in the format of YYYY-MM-DD
 		if !entry.IsDir() {
 			continue
 		}
 		dirName := entry.Name()
-		dirDate, err := time.Parse("2006-01-02", dirName)
+		dirDate, err := time.ParseInLocation("2006-01-02", dirName, m.operationLogLocation())
 		if err != nil {
 			continue
 		}
 
+		sourceDir := filepath.Join(dir, dirName)
+
 		// If the directory date is one month ago, then compress it
-		if dirDate.Before(oneMonthAgo) {
-			sourceDir := filepath.Join(dir, dirName)
-			targetArchive := sourceDir + ".tar.gz"
+		if !dirDate.Before(oneMonthAgo) {
+			m.fileDone(sourceDir, 0, 0)
+			continue
+		}
+		if guarded[dirName[:len("2006-01")]] {
+			g.Log().Warningf(ctx, "Operation log directory %s is dated before the cutoff %s but named after a recent month, kept by GuardRecentMonths",
+				sourceDir, oneMonthAgo.Format("2006-01-02"))
+			m.fileDone(sourceDir, 0, 0)
+			continue
+		}
+		if m.keepPinned(ctx, sourceDir) {
+			continue
+		}
 
-			if gfile.Exists(targetArchive) {
-				continue
-			}
+		targetArchive, err := m.archiveName(sourceDir, ArchiveExt(m.archiveCodec(), true))
+		if err != nil {
+			g.Log().Errorf(ctx, "Cannot name the archive of operation log directory %s: %v", sourceDir, err)
+			m.fail(ErrCompress, sourceDir, err)
+			m.fileDone(sourceDir, 0, 0)
+			continue
+		}
 
-			if err := compressDirToTarGz(sourceDir, targetArchive); err == nil {
+		if exists, err := m.archiveStored(ctx, targetArchive); err != nil || (exists && !m.replaceExistingArchive(ctx, sourceDir, targetArchive)) {
+			m.fileDone(sourceDir, 0, 0)
+			continue
+		}
 
-				if err := os.RemoveAll(sourceDir); err != nil {
-					g.Log().Errorf(ctx, "Failed to delete the original operation log directory %s: %v", sourceDir, err)
-				}
-			} else {
-				g.Log().Errorf(ctx, "Compression operation log directory %s failed: %v", sourceDir, err)
-			}
+		if !m.claimBacklog() {
+			m.fileDone(sourceDir, 0, 0)
+			continue
 		}
+
+		m.startUpload(func() {
+			if err := m.archiveDir(ctx, sourceDir, targetArchive, m.archiveLock(true)); err != nil {
+				g.Log().Errorf(ctx, "Archiving of operation log directory %s failed: %v", sourceDir, err)
+			}
+		})
 	}
 }
 
-// compressDirToTarGz Compress the entire directory into a .tar.gz file
-func compressDirToTarGz(source, target string) error {
-	targetFile, err := os.Create(target)
+// archiveDir stores the verified archive of a directory under name, with lock when not
+// nil, then removes the directory. The failure is recorded in the run and returned as a
+// *MaintenanceError, a failed archive is discarded and the directory kept
+func (m *maintenanceRun) archiveDir(ctx context.Context, source, name string, lock *ObjectLock) error {
+	size := dirSize(source)
+
+	written, err := m.upload(ctx, name, func(ctx context.Context) (int64, error) {
+		return m.compressDirToTarGz(ctx, source, name, lock)
+	})
 	if err != nil {
-		return err
+		err = m.discardPartialArchive(ctx, source, name, err)
+		m.fail(ErrCompress, source, err)
+		m.fileDone(source, 0, 0)
+		return &MaintenanceError{Kind: ErrCompress, Path: source, Err: err}
+	}
+
+	m.notePartialArchive(ctx, name, nil)
+
+	if err = os.RemoveAll(source); err != nil {
+		m.fail(ErrDelete, source, err)
+		m.fileDone(source, size, 0)
+		return &MaintenanceError{Kind: ErrDelete, Path: source, Err: err}
 	}
-	defer targetFile.Close()
+	m.audit(ctx, AuditRemoveCompressed, source, size, operationLogRule, name)
+
+	m.fileDone(source, size, size-written)
+	return nil
+}
+
+// specialFile reports FIFOs, sockets and device nodes, reading them may block forever
+func specialFile(info os.FileInfo) bool {
+	return info.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice|os.ModeCharDevice|os.ModeIrregular) != 0
+}
 
-	gzWriter := gzip.NewWriter(targetFile)
-	defer gzWriter.Close()
+// compressDirToTarGz Compress the entire directory into a tar archive compressed with the
+// archive codec, e.g. .tar.gz, stored in the sink, as volumes when it could exceed
+// MaxArchiveBytes.
+// Entries are written sorted by path, with NormalizeArchives the same content always
+// yields a byte-identical archive
+func (m *maintenanceRun) compressDirToTarGz(ctx context.Context, source, target string, lock *ObjectLock) (int64, error) {
+	entries, err := archiveEntries(ctx, source)
+	if err != nil {
+		return 0, err
+	}
 
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
+	walked := make([]string, 0, len(entries))
+	split := m.splitArchive(entries)
 
-	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+	written, err := m.putArchiveOrVolumes(ctx, target, lock, split, func(w io.Writer) error {
+		chain, err := m.filters()
 		if err != nil {
 			return err
 		}
-
-		header, err := tar.FileInfoHeader(info, info.Name())
+		cw, err := chain.NewWriter(w, false, nil)
 		if err != nil {
 			return err
 		}
 
-		relPath, err := filepath.Rel(source, path)
+		tarWriter := tar.NewWriter(cw)
+
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			header, err := tar.FileInfoHeader(entry.info, entry.info.Name())
+			if err != nil {
+				return err
+			}
+			header.Name = entry.name
+
+			if m.cfg.NormalizeArchives {
+				normalizeTarHeader(header)
+			}
+
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+			walked = append(walked, header.Name)
+
+			if !entry.info.IsDir() {
+				if err := copyFileInto(tarWriter, entry.path); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tarWriter.Close(); err != nil {
+			return err
+		}
+
+		return cw.Close()
+	})
+	if err != nil {
+		return written, err
+	}
+
+	// Never let the caller delete the source unless the archive is complete
+	if err = m.verifyTarArchive(ctx, target, walked); err != nil {
+		delErr := m.deleteArchive(ctx, target)
+		if split {
+			delErr = m.deleteVolumes(ctx, target)
+		}
+		if delErr != nil {
+			g.Log().Warningf(ctx, "Failed to delete the incomplete archive %s: %v", target, delErr)
+		}
+		return written, err
+	}
+
+	if lock == nil {
+		return written, nil
+	}
+	if split {
+		return written, m.verifyVolumeLocks(ctx, target, *lock)
+	}
+	return written, m.verifyArchiveLock(ctx, target, *lock)
+}
+
+// dirEntry a file or directory of an operation log directory, name is its archive entry name
+type dirEntry struct {
+	path string
+	name string
+	info os.FileInfo
+}
+
+// archiveEntries the entries of the archive of a directory, sorted by name. FIFOs,
+// sockets and device nodes are left out
+func archiveEntries(ctx context.Context, source string) ([]dirEntry, error) {
+	entries := make([]dirEntry, 0)
+
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		header.Name = relPath
 
-		if err := tarWriter.WriteHeader(header); err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(tarWriter, file)
+		if specialFile(info) {
+			g.Log().Warningf(ctx, "%s is not a regular file (%s), left out of the archive", path, info.Mode().Type())
+			return nil
+		}
+
+		relPath, err := filepath.Rel(source, path)
+		if err != nil {
 			return err
 		}
 
+		entries = append(entries, dirEntry{path: path, name: filepath.ToSlash(relPath), info: info})
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	return entries, nil
+}
+
+// normalizeTarHeader clears the header fields that vary between runs or hosts
+func normalizeTarHeader(header *tar.Header) {
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	header.ModTime = header.ModTime.Truncate(time.Second)
+	header.Format = tar.FormatPAX
+	header.PAXRecords = nil
 }
 
-// compressFile Compress a single file into the .gz format
-func compressFile(sourcePath string) error {
-	sourceFile, err := os.Open(sourcePath)
+// copyFileInto copies the content of the file at path into w
+func copyFileInto(w io.Writer, path string) error {
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// verifyTarArchive reads the stored archive back and checks it holds exactly the walked entries
+func (m *maintenanceRun) verifyTarArchive(ctx context.Context, name string, walked []string) error {
+	rc, err := m.openArchive(ctx, name)
+	if err != nil {
+		return fmt.Errorf("verify archive %s: %w", name, err)
+	}
+	defer rc.Close()
+
+	reader, _, err := m.unwrapArchive(name, rc)
+	if err != nil {
+		return fmt.Errorf("verify archive %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	expected := make(map[string]struct{}, len(walked))
+	for _, n := range walked {
+		expected[n] = struct{}{}
+	}
+
+	tarReader := tar.NewReader(reader)
+	count := 0
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("verify archive %s: corrupted after %d of %d entries: %w", name, count, len(walked), err)
+		}
+
+		if _, ok := expected[header.Name]; !ok {
+			return fmt.Errorf("verify archive %s: unexpected entry %q", name, header.Name)
+		}
+		delete(expected, header.Name)
+		count++
+
+		// Reading the content checks the entry was fully written
+		if _, err = io.Copy(io.Discard, tarReader); err != nil {
+			return fmt.Errorf("verify archive %s: truncated entry %q: %w", name, header.Name, err)
+		}
+	}
+
+	if len(expected) > 0 || count != len(walked) {
+		missing := make([]string, 0, len(expected))
+		for n := range expected {
+			missing = append(missing, n)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("verify archive %s: %d of %d entries present, missing %v", name, count, len(walked), missing)
+	}
+
+	return nil
+}
+
+// compressFile Compress a single file with the archive codec and store it in the sink,
+// from its open handle, so a rotation of the source during the copy does not break it
+func (m *maintenanceRun) compressFile(ctx context.Context, sourcePath string) (int64, error) {
+	written, err := m.compressLog(ctx, sourcePath)
+	if isDiskFull(err) {
+		var size int64
+		if info, statErr := os.Stat(sourcePath); statErr == nil {
+			size = info.Size()
+		}
+		if m.diskFullCleanup(ctx, sourcePath, size, err) {
+			g.Log().Warningf(ctx, "Retrying the compression of %s after the emergency cleanup", sourcePath)
+			written, err = m.compressLog(ctx, sourcePath)
+		}
+	}
+	return written, err
+}
+
+// compressLog is one attempt of compressFile, the partial archive of an attempt out of
+// space is removed
+func (m *maintenanceRun) compressLog(ctx context.Context, sourcePath string) (int64, error) {
+	sourceFile, err := openLog(sourcePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, fmt.Errorf("%s %w", sourcePath, errLogVanished)
+		}
+		return 0, err
+	}
 	defer sourceFile.Close()
 
-	destPath := sourcePath + ".gz"
-	destFile, err := os.Create(destPath)
+	opened, err := sourceFile.Stat()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer destFile.Close()
 
-	gzWriter := gzip.NewWriter(destFile)
-	defer gzWriter.Close()
+	destName, err := m.standardArchiveName(sourcePath, opened)
+	if err != nil {
+		return 0, err
+	}
 
-	_, err = io.Copy(gzWriter, sourceFile)
-	return err
+	// An archive already stored under the name is not partial
+	var existed bool
+	if _, local := localSinkOf(m.cfg.Sink); local {
+		existed, _ = m.cfg.Sink.Exists(ctx, destName)
+	}
+
+	lock := m.archiveLock(false)
+	meta := newMetadataWriter(m.location())
+	written, err := m.storeArchive(ctx, destName, lock, meta, func(w io.Writer) error {
+		chain, err := m.filters()
+		if err != nil {
+			return err
+		}
+		cw, err := chain.NewWriter(w, true, meta)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(cw, &ctxReader{ctx: ctx, r: sourceFile}); err != nil {
+			cw.Close()
+			return err
+		}
+
+		return cw.Close()
+	})
+
+	if isDiskFull(err) {
+		m.removePartialArchive(ctx, destName, existed)
+	}
+
+	if err == nil && lock != nil {
+		err = m.verifyArchiveLock(ctx, destName, *lock)
+	}
+
+	// The archive is stored, but the source path now names another file
+	if err == nil && !sameFileAt(sourcePath, opened) {
+		err = fmt.Errorf("%s %w", sourcePath, errLogRotated)
+	}
+
+	// Lines appended while it was read may be missing from the archive
+	if err == nil && grownSince(sourcePath, opened) {
+		err = fmt.Errorf("%s %w", sourcePath, errLogAppended)
+	}
+
+	return written, err
+}
+
+// putArchive streams the output of write into the sink under name along with its
+// checksum manifest, it returns the archive size
+func (m *maintenanceRun) putArchive(ctx context.Context, name string, write func(w io.Writer) error) (int64, error) {
+	return m.putLockedArchive(ctx, name, nil, write)
+}
+
+// putLockedArchive is putArchive storing the archive under lock when not nil, the sink
+// is then an ArchiveLocker. The manifest is not locked
+func (m *maintenanceRun) putLockedArchive(ctx context.Context, name string, lock *ObjectLock, write func(w io.Writer) error) (int64, error) {
+	return m.storeArchive(ctx, name, lock, nil, write)
+}
+
+// storeArchive is putLockedArchive recording in the manifest the metadata gathered by
+// meta while write ran, when not nil
+func (m *maintenanceRun) storeArchive(ctx context.Context, name string, lock *ObjectLock, meta *metadataWriter, write func(w io.Writer) error) (int64, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		err := write(pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	hash, sum := sha256.New(), md5.New()
+	counter := &countingReader{r: io.TeeReader(pr, io.MultiWriter(hash, sum))}
+	var err error
+	if lock != nil {
+		err = m.cfg.Sink.(ArchiveLocker).PutLocked(ctx, name, counter, *lock)
+	} else {
+		err = m.cfg.Sink.Put(ctx, name, counter)
+	}
+
+	// Unblock the writer if the sink stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	if writeErr := <-done; err == nil && writeErr != nil {
+		err = writeErr
+	}
+
+	if err == nil {
+		var md *LogArchiveMetadata
+		if meta != nil {
+			md = meta.metadata()
+		}
+		err = m.writeManifest(ctx, name, hex.EncodeToString(hash.Sum(nil)), md, true)
+	}
+	if err == nil {
+		err = m.confirmArchive(ctx, name, counter.n, hex.EncodeToString(sum.Sum(nil)))
+	}
+	if err == nil {
+		err = m.signArchive(ctx, name, hex.EncodeToString(hash.Sum(nil)))
+	}
+
+	return counter.n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// dirSize total size of the regular files below dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
 }
@@ -0,0 +1,353 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// truncatingSink stores only the first half of every archive, it simulates a
+// destination that silently lost data
+type truncatingSink struct {
+	*LocalSink
+}
+
+func (s truncatingSink) Put(ctx context.Context, name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.LocalSink.Put(ctx, name, bytes.NewReader(data[:len(data)/2]))
+}
+
+func newOperationLogTree(t *testing.T) (base, source string) {
+	t.Helper()
+
+	base = t.TempDir()
+	source = filepath.Join(base, "core", "operation_log", "2000-01-01")
+
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.json", "b.json", "c.json"} {
+		content := make([]byte, 64*1024)
+		for i := range content {
+			content[i] = byte(i*31 + len(name))
+		}
+		if err := os.WriteFile(filepath.Join(source, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return base, source
+}
+
+func TestOperationLogsArchivedAndRemoved(t *testing.T) {
+	base, source := newOperationLogTree(t)
+
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Fatalf("source directory should be removed after a verified archive, stat err: %v", err)
+	}
+
+	if _, err := os.Stat(source + ".tar.gz"); err != nil {
+		t.Fatalf("archive missing: %v", err)
+	}
+}
+
+func TestInterruptedWalkKeepsSource(t *testing.T) {
+	base, source := newOperationLogTree(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	RunMaintenance(ctx, MaintenanceConfig{BasePath: base})
+
+	entries, err := os.ReadDir(source)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("source directory must be intact after an interrupted walk, got %d entries, err: %v", len(entries), err)
+	}
+
+	if _, err := os.Stat(source + ".tar.gz"); !os.IsNotExist(err) {
+		t.Fatalf("no archive should be left behind, stat err: %v", err)
+	}
+}
+
+func TestIncompleteArchiveKeepsSource(t *testing.T) {
+	base, source := newOperationLogTree(t)
+
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Sink: truncatingSink{NewLocalSink(base)}})
+
+	entries, err := os.ReadDir(source)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("source directory must be intact when the archive is incomplete, got %d entries, err: %v", len(entries), err)
+	}
+
+	if _, err := os.Stat(source + ".tar.gz"); !os.IsNotExist(err) {
+		t.Fatalf("the incomplete archive should be deleted, stat err: %v", err)
+	}
+}
+
+func TestMaintenanceErrorKinds(t *testing.T) {
+	cause := &os.PathError{Op: "remove", Path: "core/a.log", Err: os.ErrPermission}
+	r := MaintenanceResult{Failures: []error{
+		&MaintenanceError{Kind: ErrDelete, Path: "core/a.log", Err: cause},
+	}}
+
+	err := r.Err()
+	if !errors.Is(err, ErrDelete) || errors.Is(err, ErrCompress) || !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("unexpected kinds of %v", err)
+	}
+
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || pathErr != cause {
+		t.Errorf("cause not reachable from %v", err)
+	}
+
+	if (MaintenanceResult{}).Err() != nil {
+		t.Errorf("a run without failures has no error")
+	}
+}
+
+// newStandardLog writes an old standard log into base/core
+func newStandardLog(t *testing.T, base, name string, content []byte) string {
+	t.Helper()
+
+	dir := filepath.Join(base, "core")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().AddDate(0, 0, -3)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestEmptyLogsSkippedOrDeleted(t *testing.T) {
+	base := t.TempDir()
+	empty := newStandardLog(t, base, "error-20200101.log", nil)
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	if r.SkippedEmpty != 1 || r.Errors != 0 {
+		t.Fatalf("skipped %d empty logs with %d errors, want 1 and 0", r.SkippedEmpty, r.Errors)
+	}
+	if _, err := os.Stat(empty); err != nil {
+		t.Fatalf("skipped empty log should be kept: %v", err)
+	}
+	if _, err := os.Stat(empty + ".gz"); !os.IsNotExist(err) {
+		t.Fatalf("empty log should not be compressed, stat err: %v", err)
+	}
+
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, EmptyLogs: EmptyLogsDelete})
+	if r.DeletedEmpty != 1 {
+		t.Fatalf("deleted %d empty logs, want 1", r.DeletedEmpty)
+	}
+	if _, err := os.Stat(empty); !os.IsNotExist(err) {
+		t.Fatalf("empty log should be deleted, stat err: %v", err)
+	}
+}
+
+func TestNamerPlacesArchivesAndStaysInBase(t *testing.T) {
+	base, _ := newOperationLogTree(t)
+	newStandardLog(t, base, "error-20200101.log", []byte("2020-01-01 00:00:00 line\n"))
+
+	// Archives in an archive/ folder of their directory
+	namer := func(sourcePath, ext string) string {
+		return filepath.Join(filepath.Dir(sourcePath), "archive", filepath.Base(sourcePath)+ext)
+	}
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Namer: namer})
+	if r.Errors != 0 {
+		t.Fatalf("run failed: %v", r.Failures)
+	}
+
+	for _, archive := range []string{
+		filepath.Join(base, "core", "archive", "error-20200101.log.gz"),
+		filepath.Join(base, "core", "operation_log", "archive", "2000-01-01.tar.gz"),
+	} {
+		if _, err := os.Stat(archive); err != nil {
+			t.Errorf("archive not created: %v", err)
+		}
+	}
+
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base), Namer: namer, RestoreDir: t.TempDir()})
+	if _, err := m.restoreOperationLogDay(context.Background(), "2000-01-01"); err != nil {
+		t.Errorf("restore with the namer: %v", err)
+	}
+
+	// A namer escaping the base fails and keeps the sources
+	base, source := newOperationLogTree(t)
+	logPath := newStandardLog(t, base, "error-20200101.log", []byte("2020-01-01 00:00:00 line\n"))
+
+	outside := t.TempDir()
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Namer: func(sourcePath, ext string) string {
+		return filepath.Join(outside, filepath.Base(sourcePath)+ext)
+	}})
+	if r.Errors != 2 {
+		t.Errorf("%d errors, want 2: %v", r.Errors, r.Failures)
+	}
+	for _, p := range []string{source, logPath} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("source %s not kept: %v", p, err)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("archives written outside of the base: %v", entries)
+	}
+}
+
+func TestSpecialFilesSkipped(t *testing.T) {
+	base, source := newOperationLogTree(t)
+	if err := syscall.Mkfifo(filepath.Join(source, "d.json"), 0644); err != nil {
+		t.Skipf("cannot create a FIFO: %v", err)
+	}
+
+	logPath := newStandardLog(t, base, "error-20200101.log", []byte("error line\n"))
+	fifo := filepath.Join(base, "core", "access-20200101.log")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan MaintenanceResult, 1)
+	go func() {
+		done <- RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	}()
+
+	var r MaintenanceResult
+	select {
+	case r = <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("maintenance hangs on a FIFO")
+	}
+
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("operation log directory should be archived: %v", err)
+	}
+	if _, err := os.Stat(logPath + ".gz"); err != nil {
+		t.Errorf("regular log should be archived: %v", err)
+	}
+	if info, err := os.Lstat(fifo); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("FIFO should be left alone: %v", err)
+	}
+}
+
+func TestLogRotatedDuringRun(t *testing.T) {
+	defer func(orig func(string) (*os.File, error)) { openLog = orig }(openLog)
+
+	// Rotated away between the scan and the open: skipped, not a failure
+	base := t.TempDir()
+	path := newStandardLog(t, base, "access-20200101.log", []byte("old line\n"))
+
+	openLog = func(name string) (*os.File, error) {
+		if err := os.Rename(name, name+".1"); err != nil {
+			t.Fatal(err)
+		}
+		return os.Open(name)
+	}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	if r.Errors != 0 {
+		t.Fatalf("a vanished log should be skipped: %v", r.Err())
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("the rotated log should be left alone: %v", err)
+	}
+	if _, err := os.Stat(path + ".gz"); !os.IsNotExist(err) {
+		t.Errorf("no archive should be written for a vanished log, stat err: %v", err)
+	}
+
+	// Rotated once opened: archived from the handle, the new log at the path is kept
+	base = t.TempDir()
+	path = newStandardLog(t, base, "access-20200101.log", []byte("old line\n"))
+
+	openLog = func(name string) (*os.File, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.Rename(name, name+".1"); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(name, []byte("new line\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return f, nil
+	}
+
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, ProtectedWindow: time.Nanosecond})
+	if r.Errors != 0 {
+		t.Fatalf("a rotated log should not fail the run: %v", r.Err())
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "new line\n" {
+		t.Errorf("the log replacing the rotated one should be kept: %q, %v", content, err)
+	}
+	archive, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatalf("the opened log should be archived: %v", err)
+	}
+	defer archive.Close()
+	reader, err := GzipCodec.NewReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := io.ReadAll(reader); string(content) != "old line\n" {
+		t.Errorf("archive should hold the content of the opened log, got %q", content)
+	}
+}
+
+func TestGuardRecentMonths(t *testing.T) {
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	timeNow = func() time.Time { return time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC) }
+
+	for _, guard := range []bool{false, true} {
+		base := t.TempDir()
+		opDir := filepath.Join(base, "core", "operation_log")
+		// The cutoff is February 28th: both days are due for archiving by the dates
+		for _, day := range []string{"2025-01-20", "2025-02-10"} {
+			if err := os.MkdirAll(filepath.Join(opDir, day), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(opDir, day, "a.json"), []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cfg := MaintenanceConfig{BasePath: base, OperationLogLocation: time.UTC, GuardRecentMonths: guard}
+
+		estimate := newMaintenanceRun(context.Background(), cfg).plannedOperationLogs(opDir)
+		if want := map[bool]int{false: 2, true: 1}[guard]; len(estimate) != want {
+			t.Errorf("guard %t: %d days planned, want %d", guard, len(estimate), want)
+		}
+
+		RunMaintenance(context.Background(), cfg)
+
+		if _, err := os.Stat(filepath.Join(opDir, "2025-01-20.tar.gz")); err != nil {
+			t.Errorf("guard %t: day of January should be archived: %v", guard, err)
+		}
+		_, err := os.Stat(filepath.Join(opDir, "2025-02-10"))
+		if guard && err != nil {
+			t.Errorf("day of the previous month should be kept by the guard: %v", err)
+		}
+		if !guard && err == nil {
+			t.Error("day before the cutoff should be archived without the guard")
+		}
+	}
+}
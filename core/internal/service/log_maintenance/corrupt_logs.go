@@ -0,0 +1,168 @@
+package log_maintenance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Standard logs corrupt at the source, e.g. partially overwritten with zeros by a crash
+// or a bad disk. Compressing them would archive junk as if it was a log. With CorruptLogs
+// set each log is checked before its compression: a line is invalid when it holds NUL
+// bytes or invalid UTF-8, and in a JSON log (one whose first line is a JSON object) when
+// it does not parse. A log with more invalid lines than CorruptThreshold is quarantined
+// as it is below corrupt/ of BasePath for forensics, compressed anyway or left in place,
+// with a warning each time.
+
+// Handling of the corrupt standard logs
+const (
+	CorruptLogsQuarantine = "quarantine" // moved below corrupt/ uncompressed
+	CorruptLogsCompress   = "compress"   // compressed like any other log
+	CorruptLogsSkip       = "skip"       // left in place uncompressed
+)
+
+// DefaultCorruptThreshold ratio of invalid lines above which a log is corrupt
+const DefaultCorruptThreshold = 0.1
+
+// corruptDir quarantine of the corrupt logs, below BasePath
+const corruptDir = "corrupt"
+
+// corruption the invalid lines of a log
+type corruption struct {
+	lines   int
+	invalid int
+	json    bool
+}
+
+func (c corruption) ratio() float64 {
+	if c.lines == 0 {
+		return 0
+	}
+	return float64(c.invalid) / float64(c.lines)
+}
+
+// checkLogCorruption counts the invalid lines of the log at path
+func checkLogCorruption(path string) (corruption, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return corruption{}, err
+	}
+	defer f.Close()
+
+	var c corruption
+	reader := bufio.NewReaderSize(f, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimRight(line, "\r\n")
+			if c.lines == 0 {
+				c.json = bytes.HasPrefix(bytes.TrimSpace(line), []byte("{"))
+			}
+			c.lines++
+			if !validLogLine(line, c.json) {
+				c.invalid++
+			}
+		}
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+}
+
+// validLogLine reports whether a line reads as a log line
+func validLogLine(line []byte, jsonLog bool) bool {
+	if bytes.IndexByte(line, 0) >= 0 || !utf8.Valid(line) {
+		return false
+	}
+	if jsonLog && len(bytes.TrimSpace(line)) > 0 {
+		return json.Valid(line)
+	}
+	return true
+}
+
+// corruptThreshold the effective threshold of the invalid lines
+func (m *maintenanceRun) corruptThreshold() float64 {
+	if m.cfg.CorruptThreshold > 0 {
+		return m.cfg.CorruptThreshold
+	}
+	return DefaultCorruptThreshold
+}
+
+// handleCorruptLog checks a log due for compression, it reports whether the log was
+// found corrupt and handled instead of compressed
+func (m *maintenanceRun) handleCorruptLog(ctx context.Context, path string, info os.FileInfo) bool {
+	if m.cfg.CorruptLogs == "" {
+		return false
+	}
+
+	c, err := checkLogCorruption(path)
+	if err != nil || c.ratio() <= m.corruptThreshold() {
+		// An unreadable log fails its compression, which reports it
+		return false
+	}
+
+	kind := "log"
+	if c.json {
+		kind = "JSON log"
+	}
+	g.Log().Warningf(ctx, "Log %s looks corrupt, %d of its %d lines are not valid %s lines", path, c.invalid, c.lines, kind)
+
+	switch m.cfg.CorruptLogs {
+	case CorruptLogsCompress:
+		return false
+	case CorruptLogsSkip:
+		m.noteCorrupt(path)
+		m.fileDone(path, 0, 0)
+		return true
+	}
+
+	target, err := m.quarantineCorrupt(path)
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to quarantine the corrupt log %s, left in place: %v", path, err)
+		m.fail(ErrCompress, path, err)
+		m.fileDone(path, 0, 0)
+		return true
+	}
+
+	g.Log().Warningf(ctx, "Corrupt log %s quarantined to %s", path, target)
+	m.noteCorrupt(path)
+	m.audit(ctx, AuditQuarantine, path, info.Size(), fmt.Sprintf("logs with more than %.0f%% invalid lines are quarantined", m.corruptThreshold()*100), "")
+	m.fileDone(path, info.Size(), 0)
+	return true
+}
+
+// quarantineCorrupt moves a log below the corrupt quarantine, at its path relative to
+// BasePath. It returns the new path
+func (m *maintenanceRun) quarantineCorrupt(path string) (string, error) {
+	rel, err := filepath.Rel(m.cfg.BasePath, path)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(m.cfg.BasePath, corruptDir, rel)
+	if err = mkdirAll(filepath.Dir(target), permOrDefault(m.cfg.DirPerm, DefaultDirPerm)); err != nil {
+		return "", err
+	}
+	if _, err = os.Lstat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+
+	return target, os.Rename(path, target)
+}
+
+func (m *maintenanceRun) noteCorrupt(path string) {
+	m.mu.Lock()
+	m.corrupt = append(m.corrupt, path)
+	m.mu.Unlock()
+}
@@ -0,0 +1,63 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCorruptLogs(t *testing.T) {
+	base := t.TempDir()
+	jsonLog := newStandardLog(t, base, "error-20250301.log", []byte("{\"level\":\"info\"}\n{\"level\":\n\x00\x00\x00\x00\n{\"level\":\"warn\"}\n"))
+	zeroed := newStandardLog(t, base, "error-20250302.log", append([]byte("2025-03-02 00:00:01 started\n"), make([]byte, 256)...))
+	valid := newStandardLog(t, base, "error-20250303.log", []byte("2025-03-03 00:00:01 started\n\xff one bad byte\n"+strings.Repeat("line\n", 20)))
+	newStandardLog(t, base, "error-20250304.log", []byte("newest\n"))
+
+	cfg := MaintenanceConfig{BasePath: base, CorruptLogs: CorruptLogsQuarantine}
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if len(r.Corrupt) != 2 {
+		t.Errorf("corrupt logs %v, want 2", r.Corrupt)
+	}
+
+	// The corrupt logs are quarantined as they are, a few invalid lines are tolerated
+	for _, path := range []string{jsonLog, zeroed} {
+		if _, err := os.Stat(path + ".gz"); !os.IsNotExist(err) {
+			t.Errorf("corrupt log %s archived", path)
+		}
+		rel, _ := filepath.Rel(base, path)
+		if _, err := os.Stat(filepath.Join(base, corruptDir, rel)); err != nil {
+			t.Errorf("corrupt log %s not quarantined: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(valid + ".gz"); err != nil {
+		t.Errorf("log with few invalid lines not compressed: %v", err)
+	}
+
+	// Skipped, the log stays in place
+	skipped := newStandardLog(t, base, "error-20250228.log", make([]byte, 64))
+	cfg.CorruptLogs = CorruptLogsSkip
+	if r = RunMaintenance(context.Background(), cfg); len(r.Corrupt) != 1 {
+		t.Errorf("corrupt logs %v, want 1", r.Corrupt)
+	}
+	if _, err := os.Stat(skipped); err != nil {
+		t.Errorf("skipped corrupt log not left in place: %v", err)
+	}
+
+	// Compressed anyway
+	cfg.CorruptLogs = CorruptLogsCompress
+	if r = RunMaintenance(context.Background(), cfg); len(r.Corrupt) != 0 || r.Errors != 0 {
+		t.Errorf("corrupt logs %v, failures %v", r.Corrupt, r.Err())
+	}
+	if _, err := os.Stat(skipped + ".gz"); err != nil {
+		t.Errorf("corrupt log not compressed anyway: %v", err)
+	}
+
+	if err := validateConfig(MaintenanceConfig{CorruptLogs: "repair"}); err == nil {
+		t.Error("unknown corrupt logs handling accepted")
+	}
+}
@@ -0,0 +1,228 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Daily statistics of the archived logs, the trend of the log volume for the dashboards
+// and the capacity planning. They are derived from the archive names and sizes only,
+// nothing is decompressed: the day of an archive is the date in its name, else its
+// modification time, and the archives bundled in a rollup are read from the tar headers.
+// The statistics of the tree are computed once and served from a cache until
+// dailyStatsTTL elapsed or a maintenance run changed the archives.
+
+// dailyStatsTTL how long the computed statistics are served from the cache
+const dailyStatsTTL = 10 * time.Minute
+
+// operationLogGroup group of the operation log days in the daily statistics
+const operationLogGroup = "operation_log"
+
+// DailyLogStat archives of a log group dated on one day
+type DailyLogStat struct {
+	Day   string `json:"day"` // 2006-01-02
+	Group string `json:"group"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"` // stored, compressed size
+}
+
+// dailyStatsCache the statistics of a logs tree, valid while the history of the runs is
+// unchanged and until expires
+type dailyStatsCache struct {
+	stats   []DailyLogStat
+	history time.Time
+	expires time.Time
+}
+
+var (
+	dailyStatsMutex  sync.Mutex
+	dailyStatsCached = make(map[string]dailyStatsCache)
+)
+
+// DailyLogStats returns the archives of the default configuration per day and log
+// group, from since to until inclusive, sorted by day then group. A zero bound is open
+func DailyLogStats(ctx context.Context, since, until time.Time) ([]DailyLogStat, error) {
+	return dailyLogStats(ctx, DefaultService().Config(), since, until)
+}
+
+func dailyLogStats(ctx context.Context, cfg MaintenanceConfig, since, until time.Time) ([]DailyLogStat, error) {
+	all, err := cachedDailyStats(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := cfg.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	from, to := "", ""
+	if !since.IsZero() {
+		from = since.In(loc).Format("2006-01-02")
+	}
+	if !until.IsZero() {
+		to = until.In(loc).Format("2006-01-02")
+	}
+
+	stats := make([]DailyLogStat, 0)
+	for _, s := range all {
+		if (from == "" || s.Day >= from) && (to == "" || s.Day <= to) {
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
+// cachedDailyStats the statistics of the whole tree, computed again once the cache is stale
+func cachedDailyStats(ctx context.Context, cfg MaintenanceConfig) ([]DailyLogStat, error) {
+	var history time.Time
+	if info, err := os.Stat(filepath.Join(cfg.BasePath, historyFile)); err == nil {
+		history = info.ModTime()
+	}
+
+	dailyStatsMutex.Lock()
+	defer dailyStatsMutex.Unlock()
+
+	if c, ok := dailyStatsCached[cfg.BasePath]; ok && c.history.Equal(history) && timeNow().Before(c.expires) {
+		return c.stats, nil
+	}
+
+	stats, err := computeDailyStats(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dailyStatsCached[cfg.BasePath] = dailyStatsCache{stats: stats, history: history, expires: timeNow().Add(dailyStatsTTL)}
+	return stats, nil
+}
+
+// computeDailyStats walks the archives of the tree, the quarantine left out
+func computeDailyStats(ctx context.Context, cfg MaintenanceConfig) ([]DailyLogStat, error) {
+	m := newMaintenanceRun(ctx, cfg)
+	days := make(map[[2]string]*DailyLogStat)
+
+	add := func(name string, size int64, modTime time.Time) {
+		source, _, dir, ok := SplitArchiveName(path.Base(name))
+		if !ok {
+			return
+		}
+
+		group := operationLogGroup
+		if !dir {
+			group = m.logGroupOf(source)
+		} else if path.Base(path.Dir(name)) != "operation_log" {
+			group = ""
+		}
+		if group == "" {
+			return
+		}
+
+		date, ok := dateFromName(source, m.location())
+		if !ok {
+			date = modTime.In(m.location())
+		}
+
+		key := [2]string{date.Format("2006-01-02"), group}
+		s, ok := days[key]
+		if !ok {
+			s = &DailyLogStat{Day: key[0], Group: group}
+			days[key] = s
+		}
+		s.Files++
+		s.Bytes += size
+	}
+
+	err := filepath.WalkDir(cfg.BasePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable directory is left out, the rest of the tree still counts
+			if p != cfg.BasePath {
+				g.Log().Warningf(ctx, "Failed to read %s for the daily log statistics: %v", p, err)
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, _ := filepath.Rel(cfg.BasePath, p)
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == quarantineDir || rel == trashDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		if strings.HasSuffix(d.Name(), rollupExt) {
+			if err := rollupStats(p, rel, info.ModTime(), add); err != nil {
+				g.Log().Warningf(ctx, "Failed to read the rollup %s for the daily log statistics: %v", rel, err)
+			}
+			return nil
+		}
+
+		add(rel, info.Size(), info.ModTime())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]DailyLogStat, 0, len(days))
+	for _, s := range days {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Day != stats[j].Day {
+			return stats[i].Day < stats[j].Day
+		}
+		return stats[i].Group < stats[j].Group
+	})
+
+	return stats, nil
+}
+
+// rollupStats adds the archives of a rollup from its tar headers, the content is skipped
+func rollupStats(p, rel string, modTime time.Time, add func(name string, size int64, modTime time.Time)) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entryTime := header.ModTime
+		if entryTime.IsZero() {
+			entryTime = modTime
+		}
+		add(path.Join(path.Dir(rel), header.Name), header.Size, entryTime)
+	}
+}
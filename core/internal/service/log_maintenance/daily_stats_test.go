@@ -0,0 +1,71 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDailyLogStats(t *testing.T) {
+	base := t.TempDir()
+	write := func(rel string, content []byte) {
+		p := filepath.Join(base, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("core/error-20250301.log.gz", make([]byte, 100))
+	write("core/error-20250301.log.gz.sha256", make([]byte, 10))
+	write("core/access-20250301.log.gz", make([]byte, 50))
+	write("core/operation_log/2025-03-02.tar.gz", make([]byte, 30))
+	write("quarantine/core/error-20250302.log.gz", make([]byte, 70))
+
+	var rollup bytes.Buffer
+	tw := tar.NewWriter(&rollup)
+	for _, name := range []string{"error-20250303.log.gz", "error-20250304.log.gz"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 20, ModTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(make([]byte, 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	write("core/error-2025-03.rollup.tar", rollup.Bytes())
+
+	cfg := MaintenanceConfig{BasePath: base}
+	stats, err := dailyLogStats(context.Background(), cfg, time.Time{}, time.Date(2025, 3, 3, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DailyLogStat{
+		{Day: "2025-03-01", Group: "access", Files: 1, Bytes: 50},
+		{Day: "2025-03-01", Group: "error", Files: 1, Bytes: 100},
+		{Day: "2025-03-02", Group: operationLogGroup, Files: 1, Bytes: 30},
+		{Day: "2025-03-03", Group: "error", Files: 1, Bytes: 20},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("stats %+v, want %+v", stats, want)
+	}
+
+	// Served from the cache until a run is recorded
+	write("core/error-20250305.log.gz", make([]byte, 10))
+	since := time.Date(2025, 3, 4, 0, 0, 0, 0, time.Local)
+	if stats, _ := dailyLogStats(context.Background(), cfg, since, time.Time{}); len(stats) != 1 {
+		t.Errorf("cached stats %+v", stats)
+	}
+	recordRun(context.Background(), base, DefaultFilePerm, MaintenanceResult{StartedAt: time.Now()})
+	if stats, _ := dailyLogStats(context.Background(), cfg, since, time.Time{}); len(stats) != 2 {
+		t.Errorf("stats after a run %+v", stats)
+	}
+}
@@ -0,0 +1,140 @@
+package log_maintenance
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alternate formats of the dated logs. The date group matches the YYYY-MM-DD.log files,
+// other components name theirs YYYYMMDD.log or rotate them hourly as YYYY-MM-DD_HH.log.
+// With DateLayouts set the group matches instead the <stem>.log files whose stem parses
+// with one of the layouts, Go reference time layouts made of the year 2006, the month
+// 01, the day 02, the hour 15, the minute 04, the second 05 and separators. The date
+// of those logs is the one of their name, to the hour, whatever DateSource, and the
+// FilesToKeep of the group counts days so an hourly rotation keeps as long as a daily
+// one.
+
+// dateLogGroup the group of the dated logs
+const dateLogGroup = "date"
+
+// dateLayoutElements the elements a date layout is made of, and their pattern
+var dateLayoutElements = []struct {
+	element string
+	pattern string
+}{
+	{"2006", `\d{4}`},
+	{"01", `\d{2}`},
+	{"02", `\d{2}`},
+	{"15", `\d{2}`},
+	{"04", `\d{2}`},
+	{"05", `\d{2}`},
+}
+
+// dateLayoutPatterns compiled patterns of the layouts, by layout
+var dateLayoutPatterns sync.Map
+
+// compileDateLayout the pattern of the log names of a layout
+func compileDateLayout(layout string) (*regexp.Regexp, error) {
+	if re, ok := dateLayoutPatterns.Load(layout); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	seen := make(map[string]bool)
+
+	for rest := layout; rest != ""; {
+		matched := false
+		for _, e := range dateLayoutElements {
+			if strings.HasPrefix(rest, e.element) {
+				if seen[e.element] {
+					return nil, fmt.Errorf("date layout %q repeats %s", layout, e.element)
+				}
+				seen[e.element] = true
+				pattern.WriteString(e.pattern)
+				rest = rest[len(e.element):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		c := rest[0]
+		if c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '/' || c == '\\' || c >= 0x80 {
+			return nil, fmt.Errorf("date layout %q: unsupported element at %q", layout, rest)
+		}
+		pattern.WriteString(regexp.QuoteMeta(rest[:1]))
+		rest = rest[1:]
+	}
+
+	if !seen["2006"] || !seen["01"] || !seen["02"] {
+		return nil, fmt.Errorf("date layout %q needs the year, the month and the day", layout)
+	}
+
+	pattern.WriteString(`\.log$`)
+	re := regexp.MustCompile(pattern.String())
+	dateLayoutPatterns.Store(layout, re)
+	return re, nil
+}
+
+// validateDateLayouts rejects the layouts the date group cannot match logs with
+func validateDateLayouts(layouts []string) error {
+	for _, layout := range layouts {
+		if _, err := compileDateLayout(layout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// layoutDate the date of a log named after one of the DateLayouts, in the location of
+// the run
+func (m *maintenanceRun) layoutDate(name string) (time.Time, bool) {
+	for _, layout := range m.cfg.DateLayouts {
+		re, err := compileDateLayout(layout)
+		if err != nil || !re.MatchString(name) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, strings.TrimSuffix(name, ".log"), m.location()); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// matchesGroup reports whether the log named filename belongs to group
+func (m *maintenanceRun) matchesGroup(group LogGroup, filename string) bool {
+	if group.Name == dateLogGroup && len(m.cfg.DateLayouts) > 0 {
+		_, ok := m.layoutDate(filename)
+		return ok
+	}
+	return group.Pattern.MatchString(filename)
+}
+
+// keepFrom index of the first of files, sorted oldest first, the FilesToKeep of group
+// keeps. The dated logs of DateLayouts keep their keep newest days
+func (m *maintenanceRun) keepFrom(group string, files []string, keep int) int {
+	if group != dateLogGroup || len(m.cfg.DateLayouts) == 0 {
+		return len(files) - keep
+	}
+
+	days := make(map[string]bool)
+	for i := len(files) - 1; i >= 0; i-- {
+		t, ok := m.layoutDate(filepath.Base(files[i]))
+		if !ok {
+			continue
+		}
+		day := t.Format(time.DateOnly)
+		if !days[day] && len(days) == keep {
+			return i + 1
+		}
+		days[day] = true
+	}
+	return 0
+}
@@ -0,0 +1,127 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHourlyDateLayout(t *testing.T) {
+	base := t.TempDir()
+
+	// Three days of an hourly rotation, 24 logs a day
+	today := time.Now()
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+	var days [][]string
+	for d := 5; d >= 3; d-- {
+		var logs []string
+		for h := 0; h < 24; h++ {
+			name := today.AddDate(0, 0, -d).Add(time.Duration(h)*time.Hour).Format("2006-01-02_15") + ".log"
+			logs = append(logs, newStandardLog(t, base, name, []byte(name+"\n")))
+		}
+		days = append(days, logs)
+	}
+
+	cfg := MaintenanceConfig{
+		BasePath:    base,
+		Location:    time.Local,
+		DateLayouts: []string{"2006-01-02_15"},
+		Retention:   RetentionPolicy{FilesToKeep: 2},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMaintenanceRun(context.Background(), cfg)
+	if group := m.logGroupOf(filepath.Base(days[0][13])); group != dateLogGroup {
+		t.Errorf("hourly log in group %q, want %q", group, dateLogGroup)
+	}
+	info, err := os.Stat(days[0][13])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.effectiveDate(days[0][13], info), today.AddDate(0, 0, -5).Add(13*time.Hour); !got.Equal(want) {
+		t.Errorf("date of the hourly log = %v, want %v from its name", got, want)
+	}
+
+	var mu sync.Mutex
+	deleted := make(map[string]bool)
+	cfg.Audit = func(_ context.Context, e AuditEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Action == AuditDelete {
+			deleted[e.Path] = true
+		}
+	}
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	// FilesToKeep counts days: the oldest day goes, all 24 logs of it
+	if len(deleted) != 24 {
+		t.Errorf("%d logs deleted, want the 24 of the oldest day", len(deleted))
+	}
+	for _, path := range days[0] {
+		if !deleted[path] {
+			t.Errorf("log %s of the oldest day should be deleted", path)
+		}
+	}
+	for _, logs := range days[1:] {
+		for _, path := range logs {
+			if deleted[path] {
+				t.Errorf("log %s of a kept day should not be deleted", path)
+			}
+		}
+	}
+
+	if err := validateDateLayouts([]string{"2006-01"}); err == nil {
+		t.Error("a layout without the day should be rejected")
+	}
+	if err := validateDateLayouts([]string{"access-20060102"}); err == nil {
+		t.Error("a layout with letters should be rejected")
+	}
+}
+
+func TestDateLayoutMonthRetention(t *testing.T) {
+	base := t.TempDir()
+
+	// Every log was copied 3 days ago, only the names tell their age
+	today := time.Now()
+	ages := []int{45, 31, 29, 2}
+	var logs []string
+	for _, age := range ages {
+		name := today.AddDate(0, 0, -age).Format("20060102") + ".log"
+		logs = append(logs, newStandardLog(t, base, name, []byte(name+"\n")))
+	}
+	daily := newStandardLog(t, base, "2025-01-02.log", []byte("daily\n"))
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{
+		BasePath:    base,
+		DateLayouts: []string{"20060102"},
+		Retention:   RetentionPolicy{FilesToKeep: 100, MaxAge: 30 * 24 * time.Hour},
+	})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	for i, path := range logs {
+		_, err := os.Stat(path)
+		if ages[i] > 30 && !os.IsNotExist(err) {
+			t.Errorf("log of %d days ago should be deleted by the month retention, stat err: %v", ages[i], err)
+		}
+		if ages[i] <= 30 && os.IsNotExist(err) {
+			if _, gzErr := os.Stat(path + ".gz"); gzErr != nil {
+				t.Errorf("log of %d days ago should be kept", ages[i])
+			}
+		}
+	}
+
+	// The layouts replace the default pattern of the group
+	if _, err := os.Stat(daily); err != nil {
+		t.Errorf("log out of the layouts should be left alone: %v", err)
+	}
+}
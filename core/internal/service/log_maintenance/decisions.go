@@ -0,0 +1,138 @@
+package log_maintenance
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Decision of what a run does with a standard log. The pins, the exclusions, the active
+// logs, the protected floor, the retention and the compression cutoff may each target the
+// same log, decideAction settles them in a fixed order, the first rule that applies wins:
+//
+//  1. a pinned log is left as is (PinLog)
+//  2. a log matching ExcludeLogs is left as is
+//  3. a log being written is left as is (ActiveLog, ActiveLink)
+//  4. a log the retention would delete, beyond the newest FilesToKeep or older than MaxAge,
+//     is kept when the protected floor covers it: the newest log of its group or a log
+//     modified within ProtectedWindow. Otherwise it is deleted
+//  5. a log dated before today, or of a ForceCompress group and not the newest of it, is
+//     compressed unless modified within ProtectedWindow. A rotated log its writer moved
+//     on from is compressed within the window too
+//  6. any other log is left for a later run
+//
+// ForceCompress thus lifts the compression cutoff only, never a rule above it, and no
+// rule deletes a log a safety rule keeps. Every path deleting or compressing standard
+// logs, and the estimates of what they would do, decide through decideAction.
+
+// Action what a run does with a standard log
+type Action int
+
+const (
+	ActionLeave     Action = iota // not due yet, left for a later run
+	ActionPinned                  // left as is, pinned
+	ActionExcluded                // left as is, matches ExcludeLogs
+	ActionActive                  // left as is, being written
+	ActionProtected               // kept by the protected floor
+	ActionDelete                  // deleted by the retention
+	ActionCompress                // compressed and removed
+)
+
+var actionNames = [...]string{"leave", "pinned", "excluded", "active", "protected", "delete", "compress"}
+
+func (a Action) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return fmt.Sprintf("action(%d)", int(a))
+	}
+	return actionNames[a]
+}
+
+// logCandidate a standard log up for a decision, with what its group tells of it
+type logCandidate struct {
+	path      string
+	info      os.FileInfo // nil when its stat failed
+	newest    bool        // the newest log of its group
+	overCount bool        // beyond the newest FilesToKeep of its group
+	active    bool        // being written
+	rotated   bool        // its writer opened a newer log, see CompressOnRotation
+	cutoff    time.Time   // the logs dated before it are due for compression
+}
+
+// decideAction what the run does with file under the retention policy of its group
+func (m *maintenanceRun) decideAction(file logCandidate, policy RetentionPolicy) Action {
+	switch {
+	case m.pins[filepath.Clean(file.path)]:
+		return ActionPinned
+	case m.excludedLog(file.path):
+		return ActionExcluded
+	case file.active:
+		return ActionActive
+	}
+
+	if file.overCount || m.expiredLog(file.path, file.info, policy) {
+		// A log whose stat failed is most likely gone, there is nothing to protect
+		if file.info != nil && (file.newest || m.recentlyModified(file.info)) {
+			return ActionProtected
+		}
+		return ActionDelete
+	}
+
+	if file.info == nil {
+		return ActionLeave
+	}
+	if !m.effectiveDate(file.path, file.info).Before(file.cutoff) && !file.rotated && (!policy.ForceCompress || file.newest) {
+		return ActionLeave
+	}
+	if !file.rotated && m.recentlyModified(file.info) {
+		return ActionProtected
+	}
+	return ActionCompress
+}
+
+// expiredLog reports whether the log is older than the maximum age of the policy
+func (m *maintenanceRun) expiredLog(path string, info os.FileInfo, policy RetentionPolicy) bool {
+	return info != nil && policy.MaxAge > 0 && m.effectiveDate(path, info).Before(timeNow().Add(-policy.MaxAge))
+}
+
+// excludedLog reports whether the log matches one of ExcludeLogs
+func (m *maintenanceRun) excludedLog(file string) bool {
+	if len(m.cfg.ExcludeLogs) == 0 {
+		return false
+	}
+
+	base := filepath.Base(file)
+	rel, err := filepath.Rel(m.cfg.BasePath, file)
+	if err != nil || !filepath.IsLocal(rel) {
+		rel = ""
+	}
+
+	for _, pattern := range m.cfg.ExcludeLogs {
+		name := base
+		if strings.Contains(pattern, "/") {
+			if rel == "" {
+				continue
+			}
+			name = filepath.ToSlash(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateExcludeLogs checks the patterns of ExcludeLogs
+func validateExcludeLogs(patterns []string) error {
+	for _, pattern := range patterns {
+		if pattern == "" || strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("invalid log exclusion %q", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid log exclusion %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
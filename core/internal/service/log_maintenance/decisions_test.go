@@ -0,0 +1,182 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecideActionPrecedence(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "core")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	ages := map[string]time.Time{
+		"old":    now.AddDate(0, 0, -3),
+		"today":  now.Add(-90 * time.Minute),
+		"recent": now.Add(-10 * time.Minute),
+	}
+	infos := make(map[string]os.FileInfo)
+	for age, mtime := range ages {
+		for _, excluded := range []bool{false, true} {
+			name := "access-" + age + ".log"
+			if excluded {
+				name = "keep-" + age + ".log"
+			}
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte("line\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			infos[path] = info
+		}
+	}
+
+	// The documented precedence, spelled out for every combination of the rules
+	want := func(pinned, excluded, active, newest, recent, deleting, due bool) Action {
+		switch {
+		case pinned:
+			return ActionPinned
+		case excluded:
+			return ActionExcluded
+		case active:
+			return ActionActive
+		case deleting && (newest || recent):
+			return ActionProtected
+		case deleting:
+			return ActionDelete
+		case !due:
+			return ActionLeave
+		case recent:
+			return ActionProtected
+		}
+		return ActionCompress
+	}
+
+	cutoff := now.Add(-2 * time.Hour)
+	for path, info := range infos {
+		age := strings.TrimSuffix(strings.SplitN(filepath.Base(path), "-", 2)[1], ".log")
+		excluded := strings.HasPrefix(filepath.Base(path), "keep-")
+
+		for bits := 0; bits < 1<<6; bits++ {
+			pinned, active, newest := bits&1 != 0, bits&2 != 0, bits&4 != 0
+			overCount, maxAge, force := bits&8 != 0, bits&16 != 0, bits&32 != 0
+
+			m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, ExcludeLogs: []string{"keep-*.log"}})
+			if pinned {
+				m.pins = map[string]bool{path: true}
+			}
+			policy := RetentionPolicy{FilesToKeep: 1, ForceCompress: force}
+			if maxAge {
+				policy.MaxAge = 48 * time.Hour
+			}
+
+			file := logCandidate{path: path, info: info, newest: newest, overCount: overCount, active: active, cutoff: cutoff}
+			got := m.decideAction(file, policy)
+
+			recent := age == "recent"
+			deleting := overCount || (maxAge && age == "old")
+			due := age == "old" || (force && !newest)
+			if exp := want(pinned, excluded, active, newest, recent, deleting, due); got != exp {
+				t.Errorf("%s pinned=%v active=%v newest=%v over count=%v max age=%v force=%v: %v, want %v",
+					filepath.Base(path), pinned, active, newest, overCount, maxAge, force, got, exp)
+			}
+
+			// No rule overrides a safety rule
+			if got == ActionDelete && (pinned || excluded || active || newest || recent) {
+				t.Errorf("%s deleted despite a safety rule (bits %06b)", filepath.Base(path), bits)
+			}
+			if got == ActionCompress && (pinned || excluded || active || recent) {
+				t.Errorf("%s compressed despite a safety rule (bits %06b)", filepath.Base(path), bits)
+			}
+		}
+	}
+
+	// A rotated log is compressed within the protected window, never when excluded
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, ExcludeLogs: []string{"core/keep-*.log"}})
+	for name, exp := range map[string]Action{"access-recent.log": ActionCompress, "keep-recent.log": ActionExcluded} {
+		path := filepath.Join(dir, name)
+		if got := m.decideAction(logCandidate{path: path, info: infos[path], rotated: true, cutoff: cutoff}, RetentionPolicy{}); got != exp {
+			t.Errorf("rotated %s: %v, want %v", name, got, exp)
+		}
+	}
+
+	if validateConfig(MaintenanceConfig{ExcludeLogs: []string{"[bad"}}) == nil {
+		t.Error("invalid exclusion accepted")
+	}
+}
+
+func TestConflictingRulesInRun(t *testing.T) {
+	base := t.TempDir()
+	var logs []string
+	for i := 0; i < 5; i++ {
+		logs = append(logs, newStandardLog(t, base, "error-"+time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n")))
+	}
+	// Of today, written to an hour and a half ago
+	today := filepath.Join(base, "core", "trace-"+time.Now().Format("20060102")+".log")
+	older := filepath.Join(base, "core", "trace-"+time.Now().AddDate(0, 0, -1).Format("20060102")+".log")
+	for _, path := range []string{older, today} {
+		if err := os.WriteFile(path, []byte("trace\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-90 * time.Minute)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := pinLog(context.Background(), base, logs[0], 0, "incident"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retention deletes all but the newest error log, the pin and the exclusion win
+	cfg := MaintenanceConfig{
+		BasePath:           base,
+		DateSource:         LogDateFromName,
+		ExcludeLogs:        []string{"core/error-20250302.log"},
+		Retention:          RetentionPolicy{FilesToKeep: 1},
+		RetentionOverrides: map[string]RetentionPolicy{"trace": {ForceCompress: true, FilesToKeep: 5}},
+	}
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	for i, path := range logs {
+		_, err := os.Stat(path)
+		switch i {
+		case 0, 1:
+			if err != nil {
+				t.Errorf("%s not kept: %v", path, err)
+			}
+		default:
+			// Deleted, the newest compressed
+			if !os.IsNotExist(err) {
+				t.Errorf("%s not removed", path)
+			}
+		}
+	}
+	if r.Deleted != 2 {
+		t.Errorf("deleted %d logs, want 2", r.Deleted)
+	}
+
+	// Force-compress lifts the cutoff of the trace logs, the newest is left
+	if _, err := os.Stat(older); !os.IsNotExist(err) {
+		t.Errorf("force-compressed %s not removed", older)
+	}
+	if _, err := os.Stat(today); err != nil {
+		t.Errorf("newest trace log not kept: %v", err)
+	}
+}
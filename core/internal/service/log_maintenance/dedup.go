@@ -0,0 +1,225 @@
+package log_maintenance
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Content dedup of rotated logs: a file identical to an already archived one is
+// linked to the existing archive instead of being compressed again.
+// The index of archived hashes is bounded, the oldest entries are dropped first.
+
+const (
+	archiveIndexFile       = ".archive_index.json"
+	archiveIndexMaxEntries = 4096
+)
+
+// ArchiveLinker optional sink capability, links name to an already stored archive
+type ArchiveLinker interface {
+	Link(ctx context.Context, existing, name string) error
+}
+
+// Link hard links name to existing, falls back to a relative symlink across devices
+func (s *LocalSink) Link(ctx context.Context, existing, name string) error {
+	src, err := s.Path(existing)
+	if err != nil {
+		return err
+	}
+
+	dst, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = mkdirAll(filepath.Dir(dst), permOrDefault(s.DirPerm, DefaultDirPerm)); err != nil {
+		return err
+	}
+
+	if err = os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(dst), src)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(rel, dst)
+}
+
+type archiveIndexEntry struct {
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+}
+
+// archiveIndex hash -> archive name, in insertion order, safe for concurrent use
+type archiveIndex struct {
+	mu      sync.Mutex
+	path    string
+	perm    os.FileMode
+	entries []archiveIndexEntry
+	byHash  map[string]string
+	dirty   bool
+}
+
+func loadArchiveIndex(basePath string, perm os.FileMode) *archiveIndex {
+	idx := &archiveIndex{
+		path:   filepath.Join(basePath, archiveIndexFile),
+		perm:   perm,
+		byHash: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(idx.path)
+	if err != nil {
+		return idx
+	}
+
+	if json.Unmarshal(data, &idx.entries) != nil {
+		idx.entries = nil
+		return idx
+	}
+
+	for _, e := range idx.entries {
+		idx.byHash[e.Hash] = e.Name
+	}
+
+	return idx
+}
+
+func (idx *archiveIndex) lookup(hash string) (string, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	name, ok := idx.byHash[hash]
+	return name, ok
+}
+
+func (idx *archiveIndex) add(hash, name string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.byHash[hash]; ok {
+		return
+	}
+
+	idx.entries = append(idx.entries, archiveIndexEntry{Hash: hash, Name: name})
+	idx.byHash[hash] = name
+
+	if len(idx.entries) > archiveIndexMaxEntries {
+		for _, e := range idx.entries[:len(idx.entries)-archiveIndexMaxEntries] {
+			delete(idx.byHash, e.Hash)
+		}
+		idx.entries = append([]archiveIndexEntry(nil), idx.entries[len(idx.entries)-archiveIndexMaxEntries:]...)
+	}
+
+	idx.dirty = true
+}
+
+func (idx *archiveIndex) forget(hash string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.byHash[hash]; !ok {
+		return
+	}
+
+	delete(idx.byHash, hash)
+	for i, e := range idx.entries {
+		if e.Hash == hash {
+			idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+			break
+		}
+	}
+
+	idx.dirty = true
+}
+
+func (idx *archiveIndex) save(ctx context.Context) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.dirty {
+		return
+	}
+
+	data, err := json.Marshal(idx.entries)
+	if err == nil {
+		err = writeFile(idx.path, data, permOrDefault(idx.perm, DefaultFilePerm))
+	}
+
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to save the archive index %s: %v", idx.path, err)
+	}
+}
+
+// fileHash sha256 of the file content
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// archiveFile compresses a log file, or links it to an identical archive when one exists.
+// It returns the number of bytes newly stored
+func (m *maintenanceRun) archiveFile(ctx context.Context, path string) (int64, error) {
+	linker, canLink := m.cfg.Sink.(ArchiveLinker)
+
+	info, err := os.Stat(path)
+	if err != nil || !canLink {
+		return m.compressFile(ctx, path)
+	}
+
+	hash, err := fileHash(path)
+	if err != nil {
+		return m.compressFile(ctx, path)
+	}
+
+	destName, err := m.standardArchiveName(path, info)
+	if err != nil {
+		return 0, err
+	}
+
+	if existing, ok := m.index.lookup(hash); ok && existing != destName {
+		if exists, _ := m.cfg.Sink.Exists(ctx, existing); exists {
+			if err = linker.Link(ctx, existing, destName); err == nil {
+				if err = m.copyManifest(ctx, existing, destName); err != nil {
+					g.Log().Warningf(ctx, "Failed to copy the manifest of %s to %s: %v", existing, destName, err)
+				}
+				// The hash misses the lines appended since the log was described
+				if grownSince(path, info) {
+					return 0, fmt.Errorf("%s %w", path, errLogAppended)
+				}
+				g.Log().Debugf(ctx, "Log %s is identical to %s, linked instead of compressed", path, existing)
+				return 0, nil
+			}
+			g.Log().Warningf(ctx, "Failed to link %s to %s, compressing it: %v", destName, existing, err)
+		} else {
+			m.index.forget(hash)
+		}
+	}
+
+	written, err := m.compressFile(ctx, path)
+	if err == nil {
+		m.index.add(hash, destName)
+	}
+
+	return written, err
+}
@@ -0,0 +1,81 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveIndex(t *testing.T) {
+	base := t.TempDir()
+	ctx := context.Background()
+
+	idx := loadArchiveIndex(base, 0)
+	idx.add("h1", "a.gz")
+	idx.add("h2", "b.gz")
+	// The first archive of a hash is kept
+	idx.add("h1", "c.gz")
+
+	if name, ok := idx.lookup("h1"); !ok || name != "a.gz" {
+		t.Errorf("lookup(h1) = %q %t, want a.gz", name, ok)
+	}
+
+	idx.forget("h2")
+	if _, ok := idx.lookup("h2"); ok {
+		t.Error("forgotten hash still indexed")
+	}
+
+	idx.save(ctx)
+	loaded := loadArchiveIndex(base, 0)
+	if name, ok := loaded.lookup("h1"); !ok || name != "a.gz" || len(loaded.entries) != 1 {
+		t.Errorf("loaded %+v", loaded.entries)
+	}
+
+	// Bounded, the oldest entries are dropped first
+	for i := 0; i < archiveIndexMaxEntries+10; i++ {
+		loaded.add(fmt.Sprintf("n%d", i), fmt.Sprintf("n%d.gz", i))
+	}
+	if len(loaded.entries) != archiveIndexMaxEntries || len(loaded.byHash) != archiveIndexMaxEntries {
+		t.Fatalf("%d entries, %d hashes, want %d", len(loaded.entries), len(loaded.byHash), archiveIndexMaxEntries)
+	}
+	for _, hash := range []string{"h1", "n0", "n10"} {
+		_, ok := loaded.lookup(hash)
+		if want := hash == "n10"; ok != want {
+			t.Errorf("lookup(%s) = %t, want %t", hash, ok, want)
+		}
+	}
+
+	// An unreadable index starts empty
+	if err := os.WriteFile(filepath.Join(base, archiveIndexFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if idx := loadArchiveIndex(base, 0); len(idx.entries) != 0 || len(idx.byHash) != 0 {
+		t.Errorf("corrupt index loaded %+v", idx.entries)
+	}
+}
+
+func TestFileHash(t *testing.T) {
+	dir := t.TempDir()
+
+	hashes := make(map[string]string)
+	for name, content := range map[string]string{"a.log": "same\n", "b.log": "same\n", "c.log": "other\n"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := fileHash(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[name] = hash
+	}
+
+	if hashes["a.log"] != hashes["b.log"] || hashes["a.log"] == hashes["c.log"] {
+		t.Errorf("hashes %v", hashes)
+	}
+	if _, err := fileHash(filepath.Join(dir, "missing.log")); err == nil {
+		t.Error("hash of a missing file")
+	}
+}
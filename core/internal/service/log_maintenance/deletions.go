@@ -0,0 +1,108 @@
+package log_maintenance
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Parallel deletions of the expired standard logs. A directory with a large backlog of
+// expired logs spends the run unlinking them one by one, with DeleteWorkers above one the
+// retention deletes up to that many at a time. The decisions are taken first, in the
+// order of the logs and with the stat of each log read once for the whole group, only the
+// deletions run in parallel. The deletions of a group are all done before the next group
+// is handled, so the retention of a group never sees the logs of the previous one half
+// deleted. Each failed deletion is recorded for its log, the run goes on with the others.
+
+// deletionPool bounds the deletions in flight, a nil sem runs them inline
+type deletionPool struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	deleted atomic.Int64 // logs deleted or staged in the trash
+
+	busyMu  sync.Mutex    // guards started and busy
+	started time.Time     // start of the deletions in flight, zero when none is
+	busy    time.Duration // time spent with deletions in flight, of the batches waited
+}
+
+func newDeletionPool(workers int) *deletionPool {
+	p := &deletionPool{}
+	if workers > 1 {
+		p.sem = make(chan struct{}, workers)
+	}
+	return p
+}
+
+// startDeletion runs fn, in the background with DeleteWorkers above one. It blocks until
+// a worker is free
+func (m *maintenanceRun) startDeletion(fn func() bool) {
+	if m.deletions == nil {
+		m.deletions = newDeletionPool(m.cfg.DeleteWorkers)
+	}
+	p := m.deletions
+
+	p.busyMu.Lock()
+	if p.started.IsZero() {
+		p.started = time.Now()
+	}
+	p.busyMu.Unlock()
+
+	run := func() {
+		if fn() {
+			p.deleted.Add(1)
+		}
+	}
+
+	if p.sem == nil {
+		run()
+		return
+	}
+
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+		run()
+	}()
+}
+
+// waitDeletions waits for the deletions in flight
+func (m *maintenanceRun) waitDeletions() {
+	p := m.deletions
+	if p == nil {
+		return
+	}
+
+	p.wg.Wait()
+
+	p.busyMu.Lock()
+	if !p.started.IsZero() {
+		p.busy += time.Since(p.started)
+		p.started = time.Time{}
+	}
+	p.busyMu.Unlock()
+}
+
+// deletionStats the logs the retention deleted and their throughput, in logs per second
+// of the time spent deleting
+func (m *maintenanceRun) deletionStats() (int, float64) {
+	p := m.deletions
+	if p == nil {
+		return 0, 0
+	}
+
+	p.busyMu.Lock()
+	busy := p.busy
+	p.busyMu.Unlock()
+
+	deleted := int(p.deleted.Load())
+	if busy <= 0 {
+		return deleted, 0
+	}
+	return deleted, float64(deleted) / busy.Seconds()
+}
@@ -0,0 +1,44 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParallelDeletions(t *testing.T) {
+	base := t.TempDir()
+	for day := 0; day < 40; day++ {
+		date := time.Date(2025, 1, 1+day, 0, 0, 0, 0, time.Local)
+		newStandardLog(t, base, "access-"+date.Format("20060102")+".log", []byte("expired\n"))
+	}
+	newStandardLog(t, base, "access-20250301.log", []byte("newest\n"))
+
+	cfg := MaintenanceConfig{
+		BasePath:      base,
+		DateSource:    LogDateFromName,
+		Retention:     RetentionPolicy{MaxAge: 24 * time.Hour},
+		DeleteWorkers: 8,
+	}
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if r.Deleted != 40 || r.DeletionRate <= 0 {
+		t.Errorf("deleted %d at %f/s, want 40", r.Deleted, r.DeletionRate)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(base, "core"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	if len(left) != 1 || left[0] != "access-20250301.log" {
+		t.Errorf("left %v, the newest log only should be kept", left)
+	}
+}
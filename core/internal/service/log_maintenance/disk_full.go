@@ -0,0 +1,82 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Disk filling up in the middle of a compression. The partial archive is removed at
+// once, it only takes the space the run is short of, and the run switches to the
+// emergency cleanup: the oldest archives are deleted until the size of the log is free
+// on top of what was left, or MinFreeBytes when larger. The compression is then tried
+// once more. The cleanup runs once per run, a log still not fitting fails with
+// ErrDiskFull and is kept for the next run.
+
+// isDiskFull reports whether err comes from a full filesystem or an exceeded quota
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// localSink the local sink storing the archives, a sink embedding one included
+func localSinkOf(sink ArchiveSink) (*LocalSink, bool) {
+	if local, ok := sink.(interface{ local() *LocalSink }); ok {
+		return local.local(), true
+	}
+	return nil, false
+}
+
+func (s *LocalSink) local() *LocalSink { return s }
+
+// removePartialArchive deletes what a compression out of space left of the archive
+// name, existed tells whether an archive was already stored under name before it
+func (m *maintenanceRun) removePartialArchive(ctx context.Context, name string, existed bool) {
+	sink, ok := localSinkOf(m.cfg.Sink)
+	if !ok {
+		return
+	}
+
+	if p, err := sink.Path(name); err == nil {
+		if err = os.Remove(p + ".partial"); err != nil && !os.IsNotExist(err) {
+			g.Log().Warningf(ctx, "Failed to remove the partial archive %s.partial: %v", p, err)
+		}
+	}
+
+	if existed {
+		return
+	}
+	if err := m.deleteArchive(ctx, name); err != nil {
+		g.Log().Warningf(ctx, "Failed to remove the partial archive %s: %v", name, err)
+	}
+}
+
+// diskFullCleanup deletes the oldest archives after the compression of path ran out of
+// space, size is the size of the log. It reports whether the compression is worth
+// retrying, that is archives were deleted by this run
+func (m *maintenanceRun) diskFullCleanup(ctx context.Context, path string, size int64, cause error) bool {
+	m.diskFullMu.Lock()
+	defer m.diskFullMu.Unlock()
+
+	if m.diskFull {
+		return m.emergencyDeleted > 0
+	}
+	m.diskFull = true
+	m.emergency = true
+
+	free, err := diskFree(m.cfg.BasePath)
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to check the free space of %s: %v", m.cfg.BasePath, err)
+		free = 0
+	}
+
+	target := max(m.cfg.MinFreeBytes, free+size)
+	g.Log().Errorf(ctx, "EMERGENCY log cleanup: %s filled up while compressing %s, deleting the oldest archives: %v",
+		m.cfg.BasePath, path, cause)
+
+	m.deleteOldestArchives(ctx, free, target)
+
+	return m.emergencyDeleted > 0
+}
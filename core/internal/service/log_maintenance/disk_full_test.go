@@ -0,0 +1,105 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fullSink writes the archives in place and runs out of space in the middle of the
+// first fails of them
+type fullSink struct {
+	*LocalSink
+	fails int
+}
+
+func (s *fullSink) Put(ctx context.Context, name string, r io.Reader) error {
+	if s.fails == 0 || strings.HasSuffix(name, manifestExt) {
+		return s.LocalSink.Put(ctx, name, r)
+	}
+	s.fails--
+
+	p, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	io.CopyN(f, r, 10)
+	io.Copy(io.Discard, r)
+	return &os.PathError{Op: "write", Path: p, Err: syscall.ENOSPC}
+}
+
+func TestDiskFullDuringCompression(t *testing.T) {
+	run := func(fails int) (MaintenanceResult, string, string) {
+		base := t.TempDir()
+		dir := filepath.Join(base, "core")
+		old := []string{"access-20200101.log.gz", "access-20200102.log.gz", "access-20200103.log.gz"}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range old {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("archive"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		source := newStandardLog(t, base, "error-20200105.log", bytes.Repeat([]byte("x"), 1500))
+
+		// Every deleted archive frees 1000 bytes
+		diskFree = func(string) (int64, error) {
+			left := 0
+			for _, name := range old {
+				if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					left++
+				}
+			}
+			return int64(len(old)-left) * 1000, nil
+		}
+
+		sink := &fullSink{LocalSink: NewLocalSink(base), fails: fails}
+		r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Sink: sink})
+		return r, source, filepath.Join(dir, "error-20200105.log.gz")
+	}
+	defer func(orig func(string) (int64, error)) { diskFree = orig }(diskFree)
+
+	// The partial archive is replaced by the retry after two archives made room
+	r, source, archive := run(1)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if !r.DiskFull || !r.Emergency || r.EmergencyDeleted != 2 {
+		t.Fatalf("disk full %v, emergency %v, %d archives deleted, want 2", r.DiskFull, r.Emergency, r.EmergencyDeleted)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("compressed log kept: %v", err)
+	}
+	base := filepath.Dir(filepath.Dir(archive))
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base)})
+	if err := m.verifyArchive(context.Background(), "core/error-20200105.log.gz", newRateLimiter(0)); err != nil {
+		t.Errorf("archive of the retry: %v", err)
+	}
+
+	// Still out of space after the cleanup, the log is kept without a partial archive
+	r, source, archive = run(2)
+	if !errors.Is(r.Err(), ErrDiskFull) || !errors.Is(r.Err(), syscall.ENOSPC) || errors.Is(r.Err(), ErrCompress) {
+		t.Fatalf("failures %v, want ErrDiskFull", r.Err())
+	}
+	if r.UploadsRetried != 0 {
+		t.Errorf("%d upload retries of a full disk", r.UploadsRetried)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("log not kept: %v", err)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("partial archive left: %v", err)
+	}
+}
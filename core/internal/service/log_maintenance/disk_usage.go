@@ -0,0 +1,113 @@
+package log_maintenance
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Disk usage of the logs tree, by directory and by log group, to see where the space
+// goes before tuning the retention. Archives are the files of a known codec, the other
+// files count as uncompressed logs. A file is attributed to a group by its name without
+// the archive suffixes, rollups and files matching no group only count in their directory.
+
+// LogUsage space used by a set of logs
+type LogUsage struct {
+	Files           int       `json:"files"` // uncompressed files
+	Bytes           int64     `json:"bytes"`
+	CompressedFiles int       `json:"compressed_files"`
+	CompressedBytes int64     `json:"compressed_bytes"`
+	Oldest          time.Time `json:"oldest"` // modification times, zero without files
+	Newest          time.Time `json:"newest"`
+}
+
+// LogDiskUsageReport usage of the logs tree
+type LogDiskUsageReport struct {
+	BasePath    string               `json:"base_path"`
+	Total       LogUsage             `json:"total"`
+	Directories map[string]*LogUsage `json:"directories"` // relative to BasePath, "." for the root
+	Groups      map[string]*LogUsage `json:"groups"`
+}
+
+// LogDiskUsage returns the usage of the logs tree of the scheduled maintenance
+func LogDiskUsage(ctx context.Context) (LogDiskUsageReport, error) {
+	return logDiskUsage(ctx, DefaultService().Config())
+}
+
+// logDiskUsage computes the usage of the logs tree of cfg in a single walk
+func logDiskUsage(ctx context.Context, cfg MaintenanceConfig) (LogDiskUsageReport, error) {
+	report := LogDiskUsageReport{
+		BasePath:    cfg.BasePath,
+		Directories: make(map[string]*LogUsage),
+		Groups:      make(map[string]*LogUsage),
+	}
+
+	m := newMaintenanceRun(ctx, cfg)
+
+	err := filepath.WalkDir(cfg.BasePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable directory is left out, the rest of the tree is still measured
+			if path != cfg.BasePath {
+				g.Log().Warningf(ctx, "Failed to read %s for the log disk usage: %v", path, err)
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(cfg.BasePath, filepath.Dir(path))
+		if err != nil {
+			return nil
+		}
+
+		name, _, _, compressed := SplitArchiveName(d.Name())
+
+		usages := []*LogUsage{&report.Total, report.usage(report.Directories, filepath.ToSlash(rel))}
+		if group := m.logGroupOf(name); group != "" {
+			usages = append(usages, report.usage(report.Groups, group))
+		}
+
+		for _, u := range usages {
+			u.add(info.Size(), info.ModTime(), compressed)
+		}
+		return nil
+	})
+
+	return report, err
+}
+
+func (r *LogDiskUsageReport) usage(set map[string]*LogUsage, key string) *LogUsage {
+	u, ok := set[key]
+	if !ok {
+		u = &LogUsage{}
+		set[key] = u
+	}
+	return u
+}
+
+func (u *LogUsage) add(size int64, modTime time.Time, compressed bool) {
+	if compressed {
+		u.CompressedFiles++
+		u.CompressedBytes += size
+	} else {
+		u.Files++
+		u.Bytes += size
+	}
+
+	if u.Oldest.IsZero() || modTime.Before(u.Oldest) {
+		u.Oldest = modTime
+	}
+	if modTime.After(u.Newest) {
+		u.Newest = modTime
+	}
+}
@@ -0,0 +1,42 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogDiskUsage(t *testing.T) {
+	base := t.TempDir()
+	newStandardLog(t, base, "access-20250101.log", []byte("0123456789"))
+	newStandardLog(t, base, "access-20241231.log.gz", []byte("01234"))
+	newStandardLog(t, base, "error-20250101.log", []byte("012"))
+	if err := os.MkdirAll(filepath.Join(base, "nginx"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "nginx", "other.txt"), []byte("0"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := logDiskUsage(context.Background(), MaintenanceConfig{BasePath: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total.Files != 3 || report.Total.Bytes != 14 || report.Total.CompressedFiles != 1 || report.Total.CompressedBytes != 5 {
+		t.Errorf("unexpected total %+v", report.Total)
+	}
+	if core := report.Directories["core"]; core == nil || core.Files != 2 || core.CompressedFiles != 1 || core.Oldest.IsZero() || core.Newest.Before(core.Oldest) {
+		t.Errorf("unexpected core usage %+v", core)
+	}
+	if nginx := report.Directories["nginx"]; nginx == nil || nginx.Bytes != 1 {
+		t.Errorf("unexpected nginx usage %+v", nginx)
+	}
+	if access := report.Groups["access"]; access == nil || access.Bytes != 10 || access.CompressedBytes != 5 {
+		t.Errorf("unexpected access usage %+v", access)
+	}
+	if len(report.Groups) != 2 || report.Groups["error"].Bytes != 3 {
+		t.Errorf("unexpected groups %v", report.Groups)
+	}
+}
@@ -0,0 +1,113 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"syscall"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Emergency cleanup of a nearly full log volume. Below MinFreeBytes the oldest
+// archives are deleted before anything is compressed, compression itself needs
+// room for the new archive next to its source. A compression running out of space
+// triggers it too, see disk_full.go.
+
+// diskFree free bytes available to the process on the filesystem holding path
+var diskFree = func(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// emergencyCleanup deletes the oldest local archives until MinFreeBytes are free
+func (m *maintenanceRun) emergencyCleanup(ctx context.Context) {
+	free, err := diskFree(m.cfg.BasePath)
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to check the free space of %s: %v", m.cfg.BasePath, err)
+		return
+	}
+
+	if free >= m.cfg.MinFreeBytes {
+		return
+	}
+
+	m.emergency = true
+
+	// The staged logs go before any archive
+	if m.cfg.TrashGrace > 0 {
+		if sweep, err := m.sweepTrash(ctx, true); err == nil && sweep.Deleted > 0 {
+			m.emergencyDeleted += sweep.Deleted
+			m.emergencyFreed += sweep.Freed
+			if free, err = diskFree(m.cfg.BasePath); err != nil || free >= m.cfg.MinFreeBytes {
+				return
+			}
+		}
+	}
+
+	g.Log().Errorf(ctx, "EMERGENCY log cleanup: %d bytes free on %s, below the %d bytes threshold, deleting the oldest archives",
+		free, m.cfg.BasePath, m.cfg.MinFreeBytes)
+
+	m.deleteOldestArchives(ctx, free, m.cfg.MinFreeBytes)
+}
+
+// deleteOldestArchives deletes the oldest local archives until target bytes are free,
+// free is the space free before
+func (m *maintenanceRun) deleteOldestArchives(ctx context.Context, free, target int64) {
+	// Deleting remote archives frees nothing here
+	sink, ok := localSinkOf(m.cfg.Sink)
+	if !ok {
+		g.Log().Errorf(ctx, "EMERGENCY log cleanup: archives are not stored locally, nothing can be deleted")
+		return
+	}
+
+	archives, err := m.archivesOldestFirst(ctx, sink, func(string) bool { return true })
+	if err != nil {
+		g.Log().Errorf(ctx, "EMERGENCY log cleanup: failed to list the archives: %v", err)
+		m.fail(ErrScan, sink.Root, err)
+		return
+	}
+
+	for _, a := range archives {
+		if free >= target || ctx.Err() != nil {
+			break
+		}
+		if m.archivePinned(a.name) {
+			continue
+		}
+
+		var size int64
+		if p, err := sink.Path(a.name); err == nil {
+			if info, err := os.Stat(p); err == nil {
+				size = info.Size()
+			}
+		}
+
+		if err := m.deleteArchive(ctx, a.name); err != nil {
+			g.Log().Errorf(ctx, "EMERGENCY log cleanup: failed to delete %s: %v", a.name, err)
+			m.fail(ErrDelete, a.name, err)
+			continue
+		}
+
+		g.Log().Warningf(ctx, "EMERGENCY log cleanup: deleted %s (%d bytes)", a.name, size)
+		m.emergencyDeleted++
+		m.emergencyFreed += size
+		m.bytesReclaimed.Add(size)
+
+		if now, err := diskFree(m.cfg.BasePath); err == nil {
+			free = now
+		} else {
+			free += size
+		}
+	}
+
+	if free < target {
+		g.Log().Errorf(ctx, "EMERGENCY log cleanup: still %d bytes free on %s after deleting %d archives",
+			free, m.cfg.BasePath, m.emergencyDeleted)
+	} else {
+		g.Log().Warningf(ctx, "EMERGENCY log cleanup: %d archives deleted, %d bytes free on %s",
+			m.emergencyDeleted, free, m.cfg.BasePath)
+	}
+}
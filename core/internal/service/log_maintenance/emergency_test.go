@@ -0,0 +1,40 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmergencyCleanupDeletesOldestArchives(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "core")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"access-20200103.log.gz", "access-20200101.log.gz", "error-20200102.log.gz"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("archive"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every deleted archive frees 1000 bytes
+	defer func(orig func(string) (int64, error)) { diskFree = orig }(diskFree)
+	diskFree = func(string) (int64, error) {
+		entries, err := os.ReadDir(dir)
+		return int64(len(names)-len(entries)) * 1000, err
+	}
+
+	result := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, MinFreeBytes: 1500})
+
+	if !result.Emergency || result.EmergencyDeleted != 2 {
+		t.Fatalf("emergency %v, %d archives deleted, want 2", result.Emergency, result.EmergencyDeleted)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "access-20200103.log.gz")); err != nil {
+		t.Errorf("newest archive should be kept: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package log_maintenance
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Kinds of maintenance failures, matched with errors.Is against the errors of a run
+var (
+	ErrScan     = errors.New("log scan failed")
+	ErrCompress = errors.New("log compression failed")
+	ErrDelete   = errors.New("log deletion failed")
+	ErrVerify   = errors.New("archive verification failed")
+
+	// ErrReadOnlyFilesystem the log volume is mounted read-only, nothing was deleted nor compressed
+	ErrReadOnlyFilesystem = errors.New("log filesystem is read-only")
+
+	// ErrDiskFull the log volume ran out of space while compressing, even after the
+	// emergency cleanup. The log was kept
+	ErrDiskFull = errors.New("log filesystem is full")
+
+	// ErrLogBaseMissing the root of the logs tree does not exist or is not a directory,
+	// nothing was done
+	ErrLogBaseMissing = errors.New("log base directory is missing")
+)
+
+// MaintenanceError failure of one operation on one path. errors.Is matches both its
+// Kind and the cause, errors.As reaches the MaintenanceError or the cause
+type MaintenanceError struct {
+	Kind error // ErrScan, ErrCompress, ErrDelete, ErrVerify, ErrReadOnlyFilesystem, ErrDiskFull or ErrLogBaseMissing
+	Path string
+	Err  error
+}
+
+func (e *MaintenanceError) Error() string {
+	return fmt.Sprintf("%v: %s: %v", e.Kind, e.Path, e.Err)
+}
+
+func (e *MaintenanceError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// failureList errors accumulated by a run, safe for concurrent use
+type failureList struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *failureList) add(err error) {
+	l.mu.Lock()
+	l.errs = append(l.errs, err)
+	l.mu.Unlock()
+}
+
+func (l *failureList) list() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]error(nil), l.errs...)
+}
+
+// fail records a failed operation of the run
+func (m *maintenanceRun) fail(kind error, path string, err error) {
+	m.failures.Add(1)
+	m.failureList.add(&MaintenanceError{Kind: kind, Path: path, Err: err})
+}
+
+// Err the failures of the run joined, nil when there was none
+func (r MaintenanceResult) Err() error {
+	return errors.Join(r.Failures...)
+}
+
+// Err the failures of the verification joined, nil when there was none
+func (r VerifyResult) Err() error {
+	return errors.Join(r.Failures...)
+}
@@ -0,0 +1,87 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Operation log directories whose archive already exists. A run interrupted after storing
+// the archive leaves the directory behind, one interrupted while storing it may leave a
+// partial archive. By default the archive is verified against its manifest and the
+// directory: a complete archive means only the removal of the directory is missing, an
+// incomplete one is replaced. An archive a failed run could not delete is always
+// replaced, see discardPartialArchive.
+
+// Handling of the existing archives
+const (
+	ExistingArchivesVerify    = "verify"    // replaced when incomplete, the directory removed when complete, default
+	ExistingArchivesOverwrite = "overwrite" // always replaced
+	ExistingArchivesSkip      = "skip"      // left as they are along with their directory
+)
+
+// replaceExistingArchive reports whether the directory is archived again over its existing
+// archive. A directory found completely archived is removed
+func (m *maintenanceRun) replaceExistingArchive(ctx context.Context, sourceDir, name string) bool {
+	if m.leftPartialArchive(name) {
+		g.Log().Infof(ctx, "Archive %s of operation log directory %s was left partial by an earlier run, replacing it", name, sourceDir)
+		return true
+	}
+
+	switch m.cfg.ExistingArchives {
+	case ExistingArchivesSkip:
+		g.Log().Infof(ctx, "Archive %s of operation log directory %s already exists, skipped", name, sourceDir)
+		return false
+	case ExistingArchivesOverwrite:
+		g.Log().Infof(ctx, "Archive %s of operation log directory %s already exists, overwritten", name, sourceDir)
+		return true
+	}
+
+	if m.readOnly {
+		return false
+	}
+
+	err := m.checkExistingArchive(ctx, sourceDir, name)
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		g.Log().Warningf(ctx, "Archive %s of operation log directory %s is incomplete, replacing it: %v", name, sourceDir, err)
+		return true
+	}
+
+	size := dirSize(sourceDir)
+	g.Log().Infof(ctx, "Archive %s of operation log directory %s is complete, removing the directory left by an earlier run", name, sourceDir)
+	if err = os.RemoveAll(sourceDir); err != nil {
+		g.Log().Errorf(ctx, "Failed to delete the original operation log directory %s: %v", sourceDir, err)
+		m.fail(ErrDelete, sourceDir, err)
+		return false
+	}
+	m.bytesProcessed.Add(size)
+	m.bytesReclaimed.Add(size)
+	m.audit(ctx, AuditRemoveCompressed, sourceDir, size, operationLogRule+", the archive of an earlier run is complete", name)
+
+	return false
+}
+
+// checkExistingArchive verifies an existing archive holds the entries of its directory and
+// matches its manifest when it has one
+func (m *maintenanceRun) checkExistingArchive(ctx context.Context, sourceDir, name string) error {
+	if err := m.verifyArchive(ctx, name, newRateLimiter(0)); err != nil && !errors.Is(err, errNoManifest) {
+		return err
+	}
+
+	entries, err := archiveEntries(ctx, sourceDir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.name)
+	}
+
+	return m.verifyTarArchive(ctx, name, names)
+}
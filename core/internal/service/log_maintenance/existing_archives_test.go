@@ -0,0 +1,65 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExistingOperationLogArchive(t *testing.T) {
+	// A partial archive left by a crashed run is replaced
+	base, source := newOperationLogTree(t)
+	if err := os.WriteFile(source+".tar.gz", []byte("\x1f\x8b partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("source directory should be removed once its archive is replaced, stat err: %v", err)
+	}
+	if err := newMaintenanceRun(context.Background(), MaintenanceConfig{Sink: NewLocalSink(base)}).verifyTarArchive(context.Background(),
+		"core/operation_log/2000-01-01.tar.gz", []string{".", "a.json", "b.json", "c.json"}); err != nil {
+		t.Errorf("replaced archive: %v", err)
+	}
+
+	// A complete archive whose directory was not removed: only the removal is done
+	base, source = newOperationLogTree(t)
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, ExistingArchives: ExistingArchivesSkip})
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.json", "b.json", "c.json"} {
+		if err := os.WriteFile(filepath.Join(source, name), []byte("again"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archived, err := os.Stat(source + ".tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Skipped as it is
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, ExistingArchives: ExistingArchivesSkip})
+	if _, err = os.Stat(source); err != nil {
+		t.Errorf("source directory should be kept by the skip policy: %v", err)
+	}
+
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err = os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("source directory of a complete archive should be removed, stat err: %v", err)
+	}
+	if info, err := os.Stat(source + ".tar.gz"); err != nil || info.Size() != archived.Size() || !info.ModTime().Equal(archived.ModTime()) {
+		t.Errorf("a complete archive should be kept as it is: %v", err)
+	}
+
+	if err = validateConfig(MaintenanceConfig{ExistingArchives: "keep"}); err == nil {
+		t.Error("an unknown existing archives handling should be refused")
+	}
+}
@@ -0,0 +1,163 @@
+package log_maintenance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// External log sources: directories out of the logs tree another process exports its
+// logs to, e.g. the files journald or a systemd unit writes with its StandardOutput. Each
+// source is a log group of its own, processed with the standard logs by the same
+// retention, compression and verification, with the retention of the source. Only the
+// logs directly in the directory whose name matches the pattern of the source are
+// managed. Their archives are stored below external/<name> of BasePath, the source
+// directory is only read and cleaned. An exporter may append to a file the run has
+// already seen: a log modified within the ProtectedWindow is left alone, and a log
+// grown while it was archived is kept for the next run, which archives it again under
+// the same name.
+
+// externalDir directory of BasePath the archives of the external sources are stored in
+const externalDir = "external"
+
+// ExternalLogSource directory of logs exported by another process, managed as a group
+type ExternalLogSource struct {
+	Name    string         // group of its logs, letters, digits, '.', '_' and '-'
+	Dir     string         // absolute, out of BasePath
+	Pattern *regexp.Regexp // matched against the file name
+
+	// Retention of its logs, the Retention of the configuration where unset. A
+	// RetentionOverrides entry of its name still applies on top
+	Retention RetentionPolicy
+}
+
+// NewExternalLogSource compiles a source, pattern is matched against the file name
+func NewExternalLogSource(name, dir, pattern string, retention RetentionPolicy) (ExternalLogSource, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return ExternalLogSource{}, fmt.Errorf("external log source %s: %w", name, err)
+	}
+
+	return ExternalLogSource{Name: name, Dir: filepath.Clean(dir), Pattern: re, Retention: retention}, nil
+}
+
+// validateExternalSources rejects the sources clashing with a log group, another
+// source or the logs tree
+func validateExternalSources(cfg MaintenanceConfig) error {
+	groups := cfg.LogGroups
+	if groups == nil {
+		groups = defaultLogGroups
+	}
+	taken := map[string]bool{operationLogGroup: true}
+	for _, group := range groups {
+		taken[group.Name] = true
+	}
+
+	dirs := make(map[string]bool, len(cfg.ExternalSources))
+	for _, src := range cfg.ExternalSources {
+		if !logGroupNamePattern.MatchString(src.Name) {
+			return fmt.Errorf("invalid external log source name %q", src.Name)
+		}
+		if taken[src.Name] {
+			return fmt.Errorf("external log source %s has the name of another group", src.Name)
+		}
+		taken[src.Name] = true
+
+		if src.Pattern == nil {
+			return fmt.Errorf("external log source %s has no pattern", src.Name)
+		}
+		if src.Retention.FilesToKeep < 0 || src.Retention.MaxAge < 0 {
+			return fmt.Errorf("negative retention of external log source %s", src.Name)
+		}
+
+		dir := filepath.Clean(src.Dir)
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("directory %q of external log source %s is not absolute", src.Dir, src.Name)
+		}
+		if within(cfg.BasePath, dir) || within(dir, cfg.BasePath) {
+			return fmt.Errorf("directory %s of external log source %s overlaps the log base %s", dir, src.Name, cfg.BasePath)
+		}
+		if dirs[dir] {
+			return fmt.Errorf("directory %s of external log source %s is shared with another source", dir, src.Name)
+		}
+		dirs[dir] = true
+	}
+
+	return nil
+}
+
+// within reports whether path is dir or below it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// externalDirs the directories of the external sources
+func (m *maintenanceRun) externalDirs() []string {
+	dirs := make([]string, 0, len(m.cfg.ExternalSources))
+	for _, src := range m.cfg.ExternalSources {
+		dirs = append(dirs, filepath.Clean(src.Dir))
+	}
+	return dirs
+}
+
+// externalSourceOf the source of the directory dir, nil when dir is not one
+func (m *maintenanceRun) externalSourceOf(dir string) *ExternalLogSource {
+	dir = filepath.Clean(dir)
+	for i := range m.cfg.ExternalSources {
+		if filepath.Clean(m.cfg.ExternalSources[i].Dir) == dir {
+			return &m.cfg.ExternalSources[i]
+		}
+	}
+	return nil
+}
+
+// scanExternalDir the files of the directory of src matching its pattern
+func scanExternalDir(src *ExternalLogSource) ([]string, error) {
+	entries, err := os.ReadDir(src.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !src.Pattern.MatchString(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(filepath.Clean(src.Dir), entry.Name()))
+	}
+	return files, nil
+}
+
+// groupOfLog returns the group of a standard or external log, empty when it is not
+// managed
+func (m *maintenanceRun) groupOfLog(path string) string {
+	if src := m.externalSourceOf(filepath.Dir(path)); src != nil {
+		if src.Pattern.MatchString(filepath.Base(path)) {
+			return src.Name
+		}
+		return ""
+	}
+	return m.logGroupOf(filepath.Base(path))
+}
+
+// externalRetention the retention of the source named group, zero when there is none
+func (m *maintenanceRun) externalRetention(group string) RetentionPolicy {
+	for _, src := range m.cfg.ExternalSources {
+		if src.Name == group {
+			return src.Retention
+		}
+	}
+	return RetentionPolicy{}
+}
+
+// archivePathOf the path below BasePath the archive of path is named after: the logs of
+// an external source are archived below external/<name>, the others next to themselves
+func (m *maintenanceRun) archivePathOf(path string) string {
+	if src := m.externalSourceOf(filepath.Dir(path)); src != nil {
+		return filepath.Join(m.cfg.BasePath, externalDir, src.Name, filepath.Base(path))
+	}
+	return path
+}
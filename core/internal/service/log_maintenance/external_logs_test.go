@@ -0,0 +1,131 @@
+package log_maintenance
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// appendingSink runs appendLog once before storing the first archive, like an exporter
+// writing to a log while it is read
+type appendingSink struct {
+	*LocalSink
+	appendLog func()
+}
+
+func (s *appendingSink) Put(ctx context.Context, name string, r io.Reader) error {
+	if s.appendLog != nil && !strings.HasSuffix(name, manifestExt) {
+		s.appendLog()
+		s.appendLog = nil
+	}
+	return s.LocalSink.Put(ctx, name, r)
+}
+
+func TestExternalLogSources(t *testing.T) {
+	base, exported := t.TempDir(), t.TempDir()
+
+	write := func(name string, daysAgo int) string {
+		path := filepath.Join(exported, name)
+		if err := os.WriteFile(path, []byte(name+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().AddDate(0, 0, -daysAgo)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	oldest := write("mailserver-20250101.txt", 9)
+	older := write("mailserver-20250102.txt", 8)
+	newest := write("mailserver-20250103.txt", 7)
+	other := write("error-20250101.log", 30)
+
+	src, err := NewExternalLogSource("journal", exported, `^mailserver-.*\.txt$`, RetentionPolicy{FilesToKeep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := MaintenanceConfig{BasePath: base, ExternalSources: []ExternalLogSource{src}}
+	if err = validateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	archives := filepath.Join(base, externalDir, "journal")
+	if _, err := os.Stat(oldest); !os.IsNotExist(err) {
+		t.Errorf("log beyond the retention of the source should be deleted, stat err: %v", err)
+	}
+	for _, path := range []string{older, newest} {
+		if _, err := os.Stat(filepath.Join(archives, filepath.Base(path)+".gz")); err != nil {
+			t.Errorf("%s should be archived below the logs tree: %v", filepath.Base(path), err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("archived %s should be removed from the source, stat err: %v", filepath.Base(path), err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("log not matching the pattern of the source should be left alone: %v", err)
+	}
+
+	// A log appended to while it is archived is kept, the next run archives it whole
+	grown := write("mailserver-20250104.txt", 5)
+	cfg.Sink = &appendingSink{LocalSink: &LocalSink{Root: base}, appendLog: func() {
+		f, err := os.OpenFile(grown, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err = f.WriteString("late line\n"); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	if r = RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(grown); err != nil {
+		t.Fatalf("log appended to while archived should be kept: %v", err)
+	}
+
+	old := time.Now().AddDate(0, 0, -5)
+	if err := os.Chtimes(grown, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if r = RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(grown); !os.IsNotExist(err) {
+		t.Fatalf("log should be archived by the next run, stat err: %v", err)
+	}
+	a, err := os.Open(filepath.Join(archives, filepath.Base(grown)+".gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	zr, err := gzip.NewReader(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := io.ReadAll(zr); string(content) != filepath.Base(grown)+"\nlate line\n" {
+		t.Fatalf("archive holds %q, want the appended line too", content)
+	}
+
+	// The sources may not overlap the logs tree nor take the name of a group
+	for _, bad := range []ExternalLogSource{
+		{Name: "journal", Dir: filepath.Join(base, "journal"), Pattern: src.Pattern},
+		{Name: "error", Dir: exported, Pattern: src.Pattern},
+		{Name: "journal", Dir: "journal", Pattern: src.Pattern},
+	} {
+		if err := validateConfig(MaintenanceConfig{BasePath: base, ExternalSources: []ExternalLogSource{bad}}); err == nil {
+			t.Errorf("source %s in %s should be rejected", bad.Name, bad.Dir)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Time budget of the compression of a single standard log, so one huge log cannot take
+// the whole run. A compression exceeding FileTimeout is aborted while it reads the log:
+// the partial archive is dropped by the sink, the log is left intact and counted as
+// done, and the next run compresses it again. It is not retried within the run.
+
+// DefaultFileTimeout budget of the compression of a standard log when FileTimeout is unset
+const DefaultFileTimeout = time.Hour
+
+var errFileBudget = errors.New("compression exceeded its time budget")
+
+// isOverBudget reports whether err is an aborted compression of a log
+func isOverBudget(err error) bool {
+	return errors.Is(err, errFileBudget)
+}
+
+// fileBudget the context of the compression of one log, bounded by FileTimeout
+func (m *maintenanceRun) fileBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	budget := m.cfg.FileTimeout
+	if budget == 0 {
+		budget = DefaultFileTimeout
+	}
+	if budget < 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, budget, errFileBudget)
+}
+
+// overBudget accounts a log whose compression was aborted, it is left for the next run
+func (m *maintenanceRun) overBudget(ctx context.Context, path string) {
+	g.Log().Warningf(ctx, "Compression of %s exceeded its %s budget, left for the next run", path, m.fileTimeout())
+
+	m.mu.Lock()
+	m.overBudgetLogs = append(m.overBudgetLogs, path)
+	m.mu.Unlock()
+
+	m.fileDone(path, 0, 0)
+}
+
+func (m *maintenanceRun) fileTimeout() time.Duration {
+	if m.cfg.FileTimeout == 0 {
+		return DefaultFileTimeout
+	}
+	return m.cfg.FileTimeout
+}
+
+// ctxReader stops reading once its context is done, with the cause of the cancellation
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if c.ctx.Err() != nil {
+		return 0, context.Cause(c.ctx)
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,38 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTimeoutLeavesLog(t *testing.T) {
+	base := t.TempDir()
+	old := newStandardLog(t, base, "error-20250301.log", bytes.Repeat([]byte("line\n"), 1000))
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, FileTimeout: time.Nanosecond})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if len(r.OverBudget) != 1 || r.OverBudget[0] != old {
+		t.Fatalf("over budget %v, want %s", r.OverBudget, old)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Errorf("log over budget not kept: %v", err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(base, "core", "*.gz*")); len(left) != 0 {
+		t.Errorf("archives left: %v", left)
+	}
+	if r.FilesDone != 1 {
+		t.Errorf("files done %d, want 1", r.FilesDone)
+	}
+
+	// The next run within the budget compresses it
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	if _, err := os.Stat(old + ".gz"); err != nil || len(r.OverBudget) != 0 {
+		t.Errorf("log not compressed by the next run: %v, over budget %v", err, r.OverBudget)
+	}
+}
@@ -0,0 +1,384 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Filter chain of the archives: the stages the content goes through from the log to
+// the stored bytes, in order, e.g. ["redact", "gzip"] or ["redact", "gzip", "encrypt"].
+// Each stage is a StreamFilter, made by the factory registered under its name. A chain
+// holds exactly one compression codec, which names the archives (ArchiveExt). The
+// filters before it see the content, those after it the compressed stream.
+//
+// The archives are read back through the reverse chain: the filters after the codec are
+// unwrapped first, the codec is then sniffed as for the archives without filters, the
+// reversible filters before it come last. A filter that cannot be reversed, such as the
+// redaction, is only accepted at the start of the chain, the archives then hold its
+// output. Those filters apply to the standard logs only, the tar streams of the
+// directories skip them.
+//
+// The chain in effect reads every archive, an envelope filter added after the codec
+// must only be added along with the conversion of the existing archives. The signatures
+// are not a filter, they cover the stored bytes, see signing.go.
+
+const (
+	// FilterRedact redaction of the lines with MaintenanceConfig.Redactor, irreversible
+	FilterRedact = "redact"
+)
+
+// DefaultFilters the chain of the archives when MaintenanceConfig.Filters is empty. The
+// redaction leaves the lines as is without a Redactor
+var DefaultFilters = []string{FilterRedact, "gzip"}
+
+// StreamFilter a stage of the filter chain
+type StreamFilter interface {
+	// Name the name of the filter in MaintenanceConfig.Filters
+	Name() string
+	// Reversible reports whether Unwrap restores what was given to Wrap
+	Reversible() bool
+	// Wrap returns a writer filtering into w, Close flushes it without closing w
+	Wrap(w io.Writer) (io.WriteCloser, error)
+	// Unwrap returns a reader of what was written to Wrap, from its output r. The
+	// filters that are not reversible pass r through
+	Unwrap(r io.Reader) (io.ReadCloser, error)
+}
+
+// FilterFactory makes the filter of a configuration, it is called for every archive
+type FilterFactory func(cfg MaintenanceConfig) (StreamFilter, error)
+
+var (
+	filtersMutex sync.RWMutex
+	filters      = map[string]FilterFactory{}
+)
+
+func init() {
+	if err := RegisterFilter(FilterRedact, func(cfg MaintenanceConfig) (StreamFilter, error) {
+		return redactFilter{redactor: cfg.Redactor}, nil
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterFilter adds a filter, its name must be neither registered nor the name of a
+// codec. The codecs are filters of their name without registration
+func RegisterFilter(name string, factory FilterFactory) error {
+	if name == "" || name != strings.ToLower(name) || strings.ContainsAny(name, " ,") {
+		return fmt.Errorf("invalid filter name %q, expected lower case", name)
+	}
+	if _, ok := CodecByName(name); ok {
+		return fmt.Errorf("filter %s is the name of a codec", name)
+	}
+
+	filtersMutex.Lock()
+	defer filtersMutex.Unlock()
+
+	if _, ok := filters[name]; ok {
+		return fmt.Errorf("filter %s already registered", name)
+	}
+	filters[name] = factory
+	return nil
+}
+
+// newFilter makes the filter of a name, a registered filter or a codec
+func newFilter(name string, cfg MaintenanceConfig) (StreamFilter, error) {
+	filtersMutex.RLock()
+	factory, ok := filters[name]
+	filtersMutex.RUnlock()
+
+	if ok {
+		return factory(cfg)
+	}
+	if codec, ok := CodecByName(name); ok && name != "" {
+		return codecFilter{codec: codec, level: cfg.CompressionLevel}, nil
+	}
+	return nil, fmt.Errorf("unknown archive filter %q", name)
+}
+
+// FilterChain the filters of the archives of a configuration, see NewFilterChain
+type FilterChain struct {
+	filters []StreamFilter
+	codec   int // index of the codec filter
+	content int // filters not reversible at the start of the chain
+}
+
+// NewFilterChain makes the chain of cfg.Filters, DefaultFilters when empty. It fails
+// when a filter is unknown or named twice, when the chain has no codec or several, and
+// when a filter that is not reversible follows a reversible one: the archives could not
+// be read back
+func NewFilterChain(cfg MaintenanceConfig) (*FilterChain, error) {
+	names := cfg.Filters
+	if len(names) == 0 {
+		names = DefaultFilters
+	}
+
+	c := &FilterChain{codec: -1}
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			return nil, fmt.Errorf("archive filter %s named twice", name)
+		}
+		seen[name] = true
+
+		f, err := newFilter(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := f.(codecFilter); ok {
+			if c.codec >= 0 {
+				return nil, fmt.Errorf("archive filters %s and %s are both codecs", names[c.codec], name)
+			}
+			c.codec = i
+		}
+
+		switch {
+		case !f.Reversible() && c.content < i:
+			return nil, fmt.Errorf("archive filter %s is not reversible and follows %s, the archives could not be read back", name, names[i-1])
+		case !f.Reversible():
+			c.content++
+		}
+
+		c.filters = append(c.filters, f)
+	}
+
+	if c.codec < 0 {
+		return nil, fmt.Errorf("archive filters %s without a compression codec", strings.Join(names, ", "))
+	}
+	if cfg.Redactor != nil && !seen[FilterRedact] {
+		return nil, fmt.Errorf("a redactor is configured but the archive filters have no %s", FilterRedact)
+	}
+	if _, ok := c.Codec().(LeveledCodec); !ok && cfg.CompressionLevel != 0 {
+		return nil, fmt.Errorf("codec %s has no compression levels", c.Codec().Name())
+	}
+
+	return c, nil
+}
+
+// Codec the codec of the chain, which names the archives
+func (c *FilterChain) Codec() CompressionCodec {
+	return c.filters[c.codec].(codecFilter).codec
+}
+
+// Names the names of the filters, in order
+func (c *FilterChain) Names() []string {
+	names := make([]string, len(c.filters))
+	for i, f := range c.filters {
+		names[i] = f.Name()
+	}
+	return names
+}
+
+// withCodec the chain with another codec, for the conversion of the archives. The
+// compression level applies to the codec of the chain only
+func (c *FilterChain) withCodec(codec CompressionCodec) *FilterChain {
+	converted := *c
+	converted.filters = append([]StreamFilter(nil), c.filters...)
+	if current := c.filters[c.codec].(codecFilter); current.codec != codec {
+		converted.filters[c.codec] = codecFilter{codec: codec}
+	}
+	return &converted
+}
+
+// newCodecWriter compresses into w with the codec of the chain alone
+func (c *FilterChain) newCodecWriter(w io.Writer) (io.WriteCloser, error) {
+	return c.filters[c.codec].Wrap(w)
+}
+
+// NewWriter returns a writer of an archive into w. content tells whether the filters
+// that are not reversible apply, false for the tar streams and the content already
+// filtered. tee, when not nil, gets the content after them
+func (c *FilterChain) NewWriter(w io.Writer, content bool, tee io.Writer) (io.WriteCloser, error) {
+	// The writers are made from the stored end, and closed from the content end
+	cw := &chainWriter{}
+	wrap := func(f StreamFilter) error {
+		fw, err := f.Wrap(w)
+		if err != nil {
+			cw.Close()
+			return fmt.Errorf("archive filter %s: %w", f.Name(), err)
+		}
+		w = fw
+		cw.closers = append([]io.Closer{fw}, cw.closers...)
+		return nil
+	}
+
+	for i := len(c.filters) - 1; i >= c.content; i-- {
+		if err := wrap(c.filters[i]); err != nil {
+			return nil, err
+		}
+	}
+	if tee != nil {
+		w = io.MultiWriter(w, tee)
+	}
+	if content {
+		for i := c.content - 1; i >= 0; i-- {
+			if err := wrap(c.filters[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cw.Writer = w
+	return cw, nil
+}
+
+// NewReader reverses the chain on the stored bytes r of the archive name, it returns
+// the codec sniffed, see NewCodecReader
+func (c *FilterChain) NewReader(name string, r io.Reader) (io.ReadCloser, CompressionCodec, error) {
+	stack := &stackedReadCloser{}
+	unwrap := func(f StreamFilter) error {
+		fr, err := f.Unwrap(r)
+		if err != nil {
+			stack.Close()
+			return fmt.Errorf("archive filter %s: %w", f.Name(), err)
+		}
+		r = fr
+		stack.closers = append([]io.Closer{fr}, stack.closers...)
+		return nil
+	}
+
+	for i := len(c.filters) - 1; i > c.codec; i-- {
+		if err := unwrap(c.filters[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	reader, codec, err := NewCodecReader(name, r)
+	if err != nil {
+		stack.Close()
+		return nil, codec, err
+	}
+	r = reader
+	stack.closers = append([]io.Closer{reader}, stack.closers...)
+
+	for i := c.codec - 1; i >= c.content; i-- {
+		if err := unwrap(c.filters[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	stack.Reader = r
+	return stack, codec, nil
+}
+
+// filters the chain of the run, a configuration not validated may fail to make one
+func (m *maintenanceRun) filters() (*FilterChain, error) {
+	return NewFilterChain(m.cfg)
+}
+
+// unwrapArchive reverses the filter chain of the run on the stored bytes of an archive
+func (m *maintenanceRun) unwrapArchive(name string, r io.Reader) (io.ReadCloser, CompressionCodec, error) {
+	chain, err := m.filters()
+	if err != nil {
+		return nil, nil, err
+	}
+	return chain.NewReader(name, r)
+}
+
+// unwrapLocalArchive is unwrapArchive with the chain of the scheduled maintenance, for the
+// archives read outside of a run
+func unwrapLocalArchive(name string, r io.Reader) (io.ReadCloser, CompressionCodec, error) {
+	m := newMaintenanceRun(context.Background(), DefaultService().Config())
+	return m.unwrapArchive(name, r)
+}
+
+// chainWriter writes into the first filter of a chain, Close closes every filter
+type chainWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (c *chainWriter) Close() error {
+	var err error
+	for _, closer := range c.closers {
+		if cErr := closer.Close(); err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// codecFilter a compression codec in the chain, level 0 for the default of the codec
+type codecFilter struct {
+	codec CompressionCodec
+	level int
+}
+
+func (f codecFilter) Name() string { return f.codec.Name() }
+
+func (f codecFilter) Reversible() bool { return true }
+
+func (f codecFilter) Wrap(w io.Writer) (io.WriteCloser, error) {
+	if leveled, ok := f.codec.(LeveledCodec); ok && f.level != 0 {
+		return leveled.NewWriterLevel(w, f.level)
+	}
+	return f.codec.NewWriter(w)
+}
+
+func (f codecFilter) Unwrap(r io.Reader) (io.ReadCloser, error) { return f.codec.NewReader(r) }
+
+// redactFilter the redaction of the lines, see Redactor.Copy
+type redactFilter struct {
+	redactor *Redactor
+}
+
+func (redactFilter) Name() string { return FilterRedact }
+
+func (redactFilter) Reversible() bool { return false }
+
+func (f redactFilter) Wrap(w io.Writer) (io.WriteCloser, error) {
+	return &redactWriter{redactor: f.redactor, w: w}, nil
+}
+
+func (redactFilter) Unwrap(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+// redactWriter redacts the complete lines written, the last line is redacted on Close
+type redactWriter struct {
+	redactor *Redactor
+	w        io.Writer
+	line     []byte
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if r.redactor == nil {
+		return r.w.Write(p)
+	}
+
+	written := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.line = append(r.line, p...)
+			break
+		}
+		r.line = append(r.line, p[:i+1]...)
+		p = p[i+1:]
+		if err := r.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return written, nil
+}
+
+func (r *redactWriter) flush() error {
+	if len(r.line) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.w, r.redactor.Redact(string(r.line)))
+	r.line = r.line[:0]
+	return err
+}
+
+func (r *redactWriter) Close() error {
+	if r.redactor == nil {
+		return nil
+	}
+	return r.flush()
+}
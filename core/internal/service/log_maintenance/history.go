@@ -0,0 +1,60 @@
+package log_maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// History of the recent maintenance runs, kept next to the archive index so it
+// survives restarts without depending on the database.
+
+const (
+	historyFile    = ".maintenance_history.json"
+	historyMaxRuns = 30
+)
+
+var historyMutex sync.Mutex
+
+// MaintenanceHistory returns the recent runs of the default configuration, newest first
+func MaintenanceHistory(ctx context.Context) []MaintenanceResult {
+	return loadHistory(DefaultService().Config().BasePath)
+}
+
+func loadHistory(basePath string) []MaintenanceResult {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	runs := make([]MaintenanceResult, 0)
+
+	data, err := os.ReadFile(filepath.Join(basePath, historyFile))
+	if err == nil {
+		_ = json.Unmarshal(data, &runs)
+	}
+
+	return runs
+}
+
+// recordRun prepends a run to the history, dropping the oldest beyond historyMaxRuns
+func recordRun(ctx context.Context, basePath string, perm os.FileMode, result MaintenanceResult) {
+	runs := append([]MaintenanceResult{result}, loadHistory(basePath)...)
+	if len(runs) > historyMaxRuns {
+		runs = runs[:historyMaxRuns]
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	data, err := json.Marshal(runs)
+	if err == nil {
+		err = writeFile(filepath.Join(basePath, historyFile), data, perm)
+	}
+
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to save the maintenance history: %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package log_maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Incident holds: every log of a time window, plain or archived, of any group, kept
+// under the name of an incident until its postmortem is done. The files of a hold are
+// pinned for the runs as by PinLog, listed by PinnedLogs with the reason "incident
+// <name>", and released together by CloseIncident, the pins of PinLog are left as they
+// are. A file is held when its span overlaps the window: the day of the dated logs and
+// archives, the period of the rollups, up to its last write for the others. The logs
+// written into the window after the hold was created are added by the runs until the
+// window ends, a hold without an end takes the new logs until closed. As for the pins,
+// only the local archives can be held.
+
+const incidentsFile = ".maintenance_incidents.json"
+
+var rollupPeriodPattern = regexp.MustCompile(`-(\d{4})-(W?)(\d{2})` + regexp.QuoteMeta(rollupExt) + `$`)
+
+// IncidentHold the logs held for an incident, Files are relative to the logs tree
+type IncidentHold struct {
+	Name      string    `json:"name"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"` // zero until closed
+	CreatedAt time.Time `json:"created_at"`
+	MatchedAt time.Time `json:"matched_at"` // last match of the logs tree
+	Files     []string  `json:"files"`
+}
+
+// CreateIncidentHold holds the logs of the tree of the default configuration from since
+// to until, a zero until holds the new logs as well until the incident is closed
+func CreateIncidentHold(ctx context.Context, name string, since, until time.Time) (IncidentHold, error) {
+	return createIncidentHold(ctx, DefaultService().Config(), name, since, until)
+}
+
+// CloseIncident releases the logs held for an incident, the next run handles them again
+// unless pinned otherwise
+func CloseIncident(ctx context.Context, name string) error {
+	return closeIncident(ctx, DefaultService().Config().BasePath, name)
+}
+
+// IncidentHolds returns the open incidents and the files each holds, sorted by name
+func IncidentHolds(ctx context.Context) []IncidentHold {
+	m := newMaintenanceRun(ctx, DefaultService().Config())
+	m.matchIncidents(ctx)
+
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+	return loadIncidents(m.cfg.BasePath)
+}
+
+func createIncidentHold(ctx context.Context, cfg MaintenanceConfig, name string, since, until time.Time) (IncidentHold, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return IncidentHold{}, fmt.Errorf("empty incident name")
+	case since.IsZero():
+		return IncidentHold{}, fmt.Errorf("incident %s without a start", name)
+	case !until.IsZero() && until.Before(since):
+		return IncidentHold{}, fmt.Errorf("incident %s ends before it starts", name)
+	}
+
+	m := newMaintenanceRun(ctx, cfg)
+	hold := IncidentHold{Name: name, Since: since, Until: until, CreatedAt: timeNow()}
+
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+
+	incidents := loadIncidents(cfg.BasePath)
+	for _, incident := range incidents {
+		if incident.Name == name {
+			return IncidentHold{}, fmt.Errorf("incident %s already holds logs", name)
+		}
+	}
+
+	files, err := m.heldFiles(since, until)
+	if err != nil {
+		return IncidentHold{}, err
+	}
+	hold.Files, hold.MatchedAt = files, hold.CreatedAt
+
+	if err = saveIncidents(cfg.BasePath, append(incidents, hold)); err != nil {
+		return IncidentHold{}, err
+	}
+
+	g.Log().Infof(ctx, "Incident %s holds %d logs from %s to %s", name, len(files), since.Format(time.RFC3339), incidentEnd(hold))
+	return hold, nil
+}
+
+func closeIncident(ctx context.Context, basePath, name string) error {
+	name = strings.TrimSpace(name)
+
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+
+	incidents := loadIncidents(basePath)
+	for i, incident := range incidents {
+		if incident.Name != name {
+			continue
+		}
+		if err := saveIncidents(basePath, append(incidents[:i:i], incidents[i+1:]...)); err != nil {
+			return err
+		}
+		g.Log().Infof(ctx, "Incident %s closed, %d logs released", name, len(incident.Files))
+		return nil
+	}
+	return fmt.Errorf("no open incident %s", name)
+}
+
+// matchIncidents adds to the open incidents whose window has not ended the logs written
+// into it since they were last matched
+func (m *maintenanceRun) matchIncidents(ctx context.Context) {
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+
+	now := timeNow()
+	incidents := loadIncidents(m.cfg.BasePath)
+	changed := false
+	for i := range incidents {
+		incident := &incidents[i]
+		if !incident.Until.IsZero() && incident.MatchedAt.After(incident.Until) {
+			continue
+		}
+
+		files, err := m.heldFiles(incident.Since, incident.Until)
+		if err != nil {
+			g.Log().Warningf(ctx, "Failed to match the logs of incident %s: %v", incident.Name, err)
+			continue
+		}
+		incident.Files = mergeHeld(incident.Files, files)
+		incident.MatchedAt = now
+		changed = true
+	}
+
+	if changed {
+		if err := saveIncidents(m.cfg.BasePath, incidents); err != nil {
+			g.Log().Warningf(ctx, "Failed to save the incident holds: %v", err)
+		}
+	}
+}
+
+// heldFiles the logs, operation log days and local archives of the tree whose span
+// overlaps the window, sorted
+func (m *maintenanceRun) heldFiles(since, until time.Time) ([]string, error) {
+	base := m.cfg.BasePath
+	files := make([]string, 0)
+
+	err := filepath.WalkDir(base, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if entry != nil && entry.IsDir() && path != base {
+				return fs.SkipDir
+			}
+			return err
+		}
+
+		rel, _ := filepath.Rel(base, path)
+		rel = filepath.ToSlash(rel)
+		name := entry.Name()
+		operationLog := filepath.ToSlash(filepath.Dir(rel)) == "core/operation_log"
+
+		if entry.IsDir() {
+			switch {
+			case rel == quarantineDir || rel == corruptDir || rel == trashDir:
+				return fs.SkipDir
+			case operationLog:
+				if day, ok := dateFromName(name, m.operationLogLocation()); ok && spanOverlaps(day, day.AddDate(0, 0, 1), since, until) {
+					files = append(files, rel)
+				}
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			return nil
+		}
+
+		_, _, _, archive := SplitArchiveName(name)
+		if !archive && !rotatedLogPattern.MatchString(name) && !strings.HasSuffix(name, rollupExt) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		loc := m.location()
+		if operationLog {
+			loc = m.operationLogLocation()
+		}
+		if start, end := m.logSpan(name, info.ModTime(), loc); spanOverlaps(start, end, since, until) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+
+	sort.Strings(files)
+	return files, err
+}
+
+// logSpan the times a log or archive holds lines of, from the zero time to its last
+// write when its name has no date
+func (m *maintenanceRun) logSpan(name string, modTime time.Time, loc *time.Location) (time.Time, time.Time) {
+	if match := rollupPeriodPattern.FindStringSubmatch(name); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		n, _ := strconv.Atoi(match[3])
+		if match[2] == "" {
+			start := time.Date(year, time.Month(n), 1, 0, 0, 0, 0, loc)
+			return start, start.AddDate(0, 1, 0)
+		}
+		// The ISO week 1 holds January 4th
+		jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, loc)
+		start := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7+(n-1)*7)
+		return start, start.AddDate(0, 0, 7)
+	}
+
+	if day, ok := m.layoutDate(name); ok {
+		return day, day.AddDate(0, 0, 1)
+	}
+	if day, ok := dateFromName(name, loc); ok {
+		return day, day.AddDate(0, 0, 1)
+	}
+	return time.Time{}, modTime
+}
+
+// spanOverlaps reports whether the span from start to end overlaps the window, a zero
+// until has no end
+func spanOverlaps(start, end, since, until time.Time) bool {
+	return !end.Before(since) && (until.IsZero() || !start.After(until))
+}
+
+// mergeHeld the files of both sorted lists, a file once held stays held
+func mergeHeld(held, files []string) []string {
+	set := make(map[string]bool, len(held)+len(files))
+	merged := make([]string, 0, len(held)+len(files))
+	for _, file := range append(append([]string(nil), held...), files...) {
+		if !set[file] {
+			set[file] = true
+			merged = append(merged, file)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// incidentPins the files held by the open incidents as pins, the caller holds pinsMutex
+func incidentPins(basePath string) []LogPin {
+	var pins []LogPin
+	for _, incident := range loadIncidents(basePath) {
+		for _, file := range incident.Files {
+			pins = append(pins, LogPin{Path: file, Reason: "incident " + incident.Name, PinnedAt: incident.CreatedAt})
+		}
+	}
+	return pins
+}
+
+// incidentHolding the open incident holding a file, empty when none, the caller holds
+// pinsMutex
+func incidentHolding(basePath, rel string) string {
+	for _, pin := range incidentPins(basePath) {
+		if pin.Path == rel {
+			return strings.TrimPrefix(pin.Reason, "incident ")
+		}
+	}
+	return ""
+}
+
+// loadIncidents reads the open incidents sorted by name, the caller holds pinsMutex
+func loadIncidents(basePath string) []IncidentHold {
+	list := make([]IncidentHold, 0)
+	if data, err := os.ReadFile(filepath.Join(basePath, incidentsFile)); err == nil {
+		_ = json.Unmarshal(data, &list)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// saveIncidents writes the open incidents, the caller holds pinsMutex
+func saveIncidents(basePath string, incidents []IncidentHold) error {
+	data, err := json.Marshal(incidents)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(basePath, incidentsFile), data, DefaultFilePerm)
+}
+
+func incidentEnd(hold IncidentHold) string {
+	if hold.Until.IsZero() {
+		return "closed"
+	}
+	return hold.Until.Format(time.RFC3339)
+}
@@ -0,0 +1,80 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIncidentHolds(t *testing.T) {
+	base, _ := newOperationLogTree(t)
+	cfg := MaintenanceConfig{BasePath: base, Retention: RetentionPolicy{FilesToKeep: 1}}
+	var logs []string
+	for i := 0; i < 4; i++ {
+		logs = append(logs, newStandardLog(t, base, "error-"+time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n")))
+	}
+
+	since := time.Date(2025, 3, 1, 6, 0, 0, 0, time.Local)
+	hold, err := createIncidentHold(context.Background(), cfg, "outage", since, since.Add(30*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hold.Files) != 2 || hold.Files[0] != "core/error-20250301.log" || hold.Files[1] != "core/error-20250302.log" {
+		t.Fatalf("held %v", hold.Files)
+	}
+	if _, err := createIncidentHold(context.Background(), cfg, "outage", since, time.Time{}); err == nil {
+		t.Error("incident created twice")
+	}
+	if _, err := createIncidentHold(context.Background(), cfg, "backwards", since, since.Add(-time.Hour)); err == nil {
+		t.Error("incident ending before it starts created")
+	}
+	// An open incident takes the logs written after it was created
+	if _, err := createIncidentHold(context.Background(), cfg, "open", time.Date(2025, 3, 10, 0, 0, 0, 0, time.Local), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	late := newStandardLog(t, base, "error-20250310.log", []byte("line\n"))
+	newest := newStandardLog(t, base, "error-20250311.log", []byte("line\n"))
+
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	for _, path := range []string{logs[0], logs[1], late, newest} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("held %s not kept: %v", path, err)
+		}
+	}
+	for _, path := range []string{logs[2], logs[3]} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s out of the incidents not deleted", path)
+		}
+	}
+
+	reasons := map[string]string{}
+	for _, pin := range activePins(base) {
+		reasons[pin.Path] = pin.Reason
+	}
+	if reasons["core/error-20250301.log"] != "incident outage" || reasons["core/error-20250310.log"] != "incident open" {
+		t.Errorf("pins %v", reasons)
+	}
+	if err := unpinLog(context.Background(), base, "core/error-20250301.log"); err == nil {
+		t.Error("log held for an incident unpinned")
+	}
+
+	if err := closeIncident(context.Background(), base, "outage"); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeIncident(context.Background(), base, "outage"); err == nil {
+		t.Error("incident closed twice")
+	}
+	RunMaintenance(context.Background(), cfg)
+	for _, path := range logs[:2] {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s not deleted once the incident closed", path)
+		}
+	}
+	if _, err := os.Stat(late); err != nil {
+		t.Errorf("log held by the open incident not kept: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Operation log directories left partially removed. A run interrupted while removing a
+// directory once its archive was stored leaves part of the directory next to the complete
+// archive, which the check of the existing archives would then find incomplete and replace
+// with the remains. At the start of the run such a directory is recognized by its archive,
+// verified against its manifest, holding every entry left in the directory with the same
+// size and more, and its removal is completed. A directory with an entry the archive does
+// not hold is left to the check of the existing archives.
+
+// completeInterruptedRemovals completes the removal of the operation log directories of
+// dir left partially removed by an earlier run
+func (m *maintenanceRun) completeInterruptedRemovals(ctx context.Context, dir string) {
+	if m.cfg.ExistingArchives == ExistingArchivesSkip {
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if m.outOfTime() || ctx.Err() != nil {
+			return
+		}
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := time.ParseInLocation("2006-01-02", entry.Name(), m.operationLogLocation()); err != nil {
+			continue
+		}
+
+		sourceDir := filepath.Join(dir, entry.Name())
+		if m.pins[sourceDir] {
+			continue
+		}
+
+		name, err := m.archiveName(sourceDir, ArchiveExt(m.archiveCodec(), true))
+		if err != nil {
+			continue
+		}
+		if exists, err := m.archiveStored(ctx, name); err != nil || !exists || m.leftPartialArchive(name) {
+			continue
+		}
+
+		partial, err := m.removalInterrupted(ctx, sourceDir, name)
+		if err != nil {
+			g.Log().Debugf(ctx, "Operation log directory %s is not a removal to complete: %v", sourceDir, err)
+			continue
+		}
+		if !partial {
+			continue
+		}
+
+		size := dirSize(sourceDir)
+		g.Log().Infof(ctx, "Operation log directory %s was partially removed by an earlier run, its archive %s is complete, completing the removal", sourceDir, name)
+		if err = os.RemoveAll(sourceDir); err != nil {
+			g.Log().Errorf(ctx, "Failed to delete the partially removed operation log directory %s: %v", sourceDir, err)
+			m.fail(ErrDelete, sourceDir, err)
+			continue
+		}
+
+		m.recovered = append(m.recovered, sourceDir)
+		m.audit(ctx, AuditRemoveCompressed, sourceDir, size, operationLogRule+", completing the removal interrupted in an earlier run", name)
+		m.fileDone(sourceDir, size, size)
+	}
+}
+
+// removalInterrupted reports whether the directory is what an interrupted removal leaves
+// of the content of its archive: the archive is valid and holds every entry of the
+// directory, with the same size, and at least one more
+func (m *maintenanceRun) removalInterrupted(ctx context.Context, sourceDir, name string) (bool, error) {
+	if err := m.verifyArchive(ctx, name, newRateLimiter(0)); err != nil && !errors.Is(err, errNoManifest) {
+		return false, err
+	}
+
+	archived, err := m.archivedSizes(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	entries, err := archiveEntries(ctx, sourceDir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		size, ok := archived[entry.name]
+		if !ok {
+			return false, fmt.Errorf("%q is not in the archive %s", entry.name, name)
+		}
+		if !entry.info.IsDir() && entry.info.Size() != size {
+			return false, fmt.Errorf("%q is %d bytes, %d in the archive %s", entry.name, entry.info.Size(), size, name)
+		}
+	}
+
+	return len(entries) < len(archived), nil
+}
+
+// archivedSizes the size of each entry of a tar archive by name, the content is read to
+// the end so a truncated archive fails
+func (m *maintenanceRun) archivedSizes(ctx context.Context, name string) (map[string]int64, error) {
+	rc, err := m.openArchive(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	reader, _, err := m.unwrapArchive(name, rc)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	sizes := make(map[string]int64)
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return sizes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.Copy(io.Discard, tarReader); err != nil {
+			return nil, err
+		}
+		sizes[header.Name] = header.Size
+	}
+}
@@ -0,0 +1,62 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInterruptedRemovalCompleted(t *testing.T) {
+	base, source := newOperationLogTree(t)
+	content, err := os.ReadFile(filepath.Join(source, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	archived, err := os.Stat(source + ".tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive exists but the removal of its directory was interrupted
+	if err = os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(source, "a.json"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err = os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("the partially removed directory should be removed, stat err: %v", err)
+	}
+	if len(r.Recovered) != 1 || r.Recovered[0] != source {
+		t.Errorf("the completed removal should be reported, got %v", r.Recovered)
+	}
+	if info, err := os.Stat(source + ".tar.gz"); err != nil || info.Size() != archived.Size() || !info.ModTime().Equal(archived.ModTime()) {
+		t.Errorf("the archive should be kept as it is: %v", err)
+	}
+
+	// A file that differs from the archived one is not the remains of a removal
+	if err = os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(source, "a.json"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base), OperationLogLocation: time.Local})
+	m.completeInterruptedRemovals(context.Background(), filepath.Dir(source))
+	if _, err = os.Stat(source); err != nil {
+		t.Errorf("a directory with a changed file should be kept: %v", err)
+	}
+	if len(m.recovered) != 0 {
+		t.Errorf("no removal should be completed, got %v", m.recovered)
+	}
+}
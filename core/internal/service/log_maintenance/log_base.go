@@ -0,0 +1,50 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// The root of the logs tree is checked before anything else. A BasePath that does not
+// exist, after a fresh install or from a wrong working directory, would otherwise make
+// every directory of the run be skipped as missing and the maintenance look like it
+// ran. The run fails at once with ErrLogBaseMissing instead, or creates the directory
+// with CreateBase. A BasePath that is not a directory always fails.
+
+// checkLogBase returns an error matching ErrLogBaseMissing when BasePath is not a
+// directory, after creating it with CreateBase
+func checkLogBase(ctx context.Context, cfg MaintenanceConfig) error {
+	info, err := os.Stat(cfg.BasePath)
+	if os.IsNotExist(err) && cfg.CreateBase {
+		if err = os.MkdirAll(cfg.BasePath, cfg.DirPerm); err != nil {
+			return &MaintenanceError{Kind: ErrLogBaseMissing, Path: cfg.BasePath, Err: err}
+		}
+		g.Log().Infof(ctx, "Created the missing log base directory %s", cfg.BasePath)
+		return nil
+	}
+
+	if err == nil && !info.IsDir() {
+		err = fmt.Errorf("not a directory")
+	}
+	if err != nil {
+		return &MaintenanceError{Kind: ErrLogBaseMissing, Path: cfg.BasePath, Err: err}
+	}
+	return nil
+}
+
+// baseMissingRun the result of a run stopped by a missing BasePath
+func baseMissingRun(ctx context.Context, cfg MaintenanceConfig, err error) MaintenanceResult {
+	g.Log().Errorf(ctx, "Log maintenance did nothing: %v", err)
+
+	return MaintenanceResult{
+		StartedAt:   time.Now(),
+		Errors:      1,
+		Failures:    []error{err},
+		BaseMissing: true,
+		BasePath:    cfg.BasePath,
+	}
+}
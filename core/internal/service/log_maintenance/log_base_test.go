@@ -0,0 +1,38 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMissingLogBase(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "logs")
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	if !r.BaseMissing || !errors.Is(r.Err(), ErrLogBaseMissing) {
+		t.Fatalf("run of a missing base should fail with ErrLogBaseMissing, got %v", r.Err())
+	}
+	if _, err := os.Stat(base); !os.IsNotExist(err) {
+		t.Fatalf("missing base should not be created by default, stat err: %v", err)
+	}
+
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, CreateBase: true})
+	if r.BaseMissing || r.Errors != 0 {
+		t.Fatalf("run creating the base should succeed, got %v", r.Err())
+	}
+	if info, err := os.Stat(base); err != nil || !info.IsDir() {
+		t.Fatalf("base should be created: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "logs")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: file, CreateBase: true})
+	if !errors.Is(r.Err(), ErrLogBaseMissing) {
+		t.Fatalf("base that is a file should fail with ErrLogBaseMissing, got %v", r.Err())
+	}
+}
@@ -0,0 +1,311 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Read-through cache of the decompressed logs. An investigation opens the same archived log
+// several times in a row, searched, viewed then exported, and each open decompresses it
+// again. The content of the logs decompressed by OpenLog, SearchLogs and the restores is
+// kept in memory, the least recently used first evicted once the cache exceeds its bound.
+// An entry is keyed by the location of the log and remembers the version, modification
+// time and size, of the stored object it was decompressed from: a changed or deleted
+// object invalidates it. Only a log read to the end is kept, and none larger than the
+// bound. Objects whose version the sink cannot tell are never cached.
+
+// DefaultLogCacheBytes bound of the decompressed content kept by the cache
+const DefaultLogCacheBytes = 64 << 20
+
+// LogCacheStats counters of the cache of the decompressed logs
+type LogCacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`     // entries evicted to stay within the bound
+	Invalidations int64 `json:"invalidations"` // entries dropped as their archive changed
+	Entries       int   `json:"entries"`
+	Bytes         int64 `json:"bytes"`
+	MaxBytes      int64 `json:"max_bytes"`
+}
+
+// logVersion what identifies the content of a stored object
+type logVersion struct {
+	modTime time.Time
+	size    int64
+	md5     string
+}
+
+// logSource the stored object a log is decompressed from, an archive of a sink or a
+// local file when sink is nil
+type logSource struct {
+	sink ArchiveSink
+	name string
+}
+
+// version the current version of the source, false when it is gone or the sink cannot
+// tell it
+func (s logSource) version(ctx context.Context) (logVersion, bool) {
+	if s.sink == nil {
+		info, err := os.Stat(s.name)
+		if err != nil {
+			return logVersion{}, false
+		}
+		return logVersion{modTime: info.ModTime(), size: info.Size()}, true
+	}
+
+	var v logVersion
+	known := false
+	if ts, ok := s.sink.(ArchiveTimestamper); ok {
+		t, err := ts.ModTime(ctx, s.name)
+		if err != nil {
+			return logVersion{}, false
+		}
+		v.modTime, known = t, true
+	}
+	if st, ok := s.sink.(ArchiveStater); ok {
+		info, err := st.Stat(ctx, s.name)
+		if err != nil {
+			return logVersion{}, false
+		}
+		v.size, v.md5, known = info.Size, info.MD5, true
+	}
+	return v, known
+}
+
+// location where the source is stored, empty when it cannot be told apart from the
+// objects of other sinks
+func (s logSource) location() string {
+	switch sink := s.sink.(type) {
+	case nil:
+		return "file:" + s.name
+	case *LocalSink:
+		if p, err := sink.Path(s.name); err == nil {
+			return "file:" + p
+		}
+	case *S3Sink:
+		return "s3:" + sink.Endpoint + "/" + sink.Bucket + "/" + sink.Prefix + s.name
+	}
+	return ""
+}
+
+type logCacheEntry struct {
+	key      string
+	source   logSource
+	location string
+	version  logVersion
+	content  []byte
+}
+
+type logCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List // of *logCacheEntry, most recently used first
+	entries  map[string]*list.Element
+
+	hits, misses, evictions, invalidations int64
+}
+
+var decompressedLogs = newLogCache(DefaultLogCacheBytes)
+
+func newLogCache(maxBytes int64) *logCache {
+	return &logCache{maxBytes: maxBytes, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// LogCacheStatistics returns the counters of the cache of the decompressed logs
+func LogCacheStatistics() LogCacheStats {
+	return decompressedLogs.stats()
+}
+
+// SetLogCacheBytes bounds the decompressed content kept by the cache, 0 disables it. The
+// entries over the new bound are evicted
+func SetLogCacheBytes(n int64) {
+	c := decompressedLogs
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxBytes = max(n, 0)
+	c.evict()
+}
+
+func (c *logCache) stats() LogCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return LogCacheStats{
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Invalidations: c.invalidations,
+		Entries:       c.lru.Len(),
+		Bytes:         c.bytes,
+		MaxBytes:      c.maxBytes,
+	}
+}
+
+// get the cached content of key, provided its source was not changed since
+func (c *logCache) get(ctx context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry := elem.Value.(*logCacheEntry)
+	c.mu.Unlock()
+
+	// The source is checked outside of the lock, a sink may take a request to answer
+	current, known := entry.source.version(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !known || !sameVersion(current, entry.version) {
+		if c.entries[key] == elem {
+			c.remove(elem)
+			c.invalidations++
+		}
+		c.misses++
+		return nil, false
+	}
+
+	if c.entries[key] == elem {
+		c.lru.MoveToFront(elem)
+	}
+	c.hits++
+	return entry.content, true
+}
+
+// put caches the content of key decompressed from source at version
+func (c *logCache) put(key string, source logSource, version logVersion, content []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(content)) > c.maxBytes {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	entry := &logCacheEntry{key: key, source: source, location: source.location(), version: version, content: content}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.bytes += int64(len(content))
+	c.evict()
+}
+
+// invalidate drops the entries decompressed from the object at location
+func (c *logCache) invalidate(location string) {
+	if location == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*logCacheEntry).location == location {
+			c.remove(elem)
+			c.invalidations++
+		}
+		elem = next
+	}
+}
+
+// evict removes the least recently used entries over the bound, under mu
+func (c *logCache) evict() {
+	for c.bytes > c.maxBytes {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		c.remove(elem)
+		c.evictions++
+	}
+}
+
+// remove under mu
+func (c *logCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*logCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= int64(len(entry.content))
+}
+
+func sameVersion(a, b logVersion) bool {
+	return a.modTime.Equal(b.modTime) && a.size == b.size && a.md5 == b.md5
+}
+
+// openCached opens the decompressed content of key from the cache, else through open,
+// which returns the source the content was decompressed from. The content open returns
+// is cached once it was read to the end
+func (c *logCache) openCached(ctx context.Context, key string, open func() (io.ReadCloser, logSource, error)) (io.ReadCloser, error) {
+	if key != "" {
+		if content, ok := c.get(ctx, key); ok {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		}
+	}
+
+	rc, source, err := open()
+	if err != nil || key == "" {
+		return rc, err
+	}
+
+	// The version is read before the content, a change while it is read makes the entry
+	// stale rather than wrong
+	version, known := source.version(ctx)
+	if !known || source.location() == "" {
+		return rc, nil
+	}
+
+	c.mu.Lock()
+	limit := c.maxBytes
+	c.mu.Unlock()
+
+	return &cachingReader{ReadCloser: rc, limit: limit, done: func(content []byte) {
+		c.put(key, source, version, content)
+	}}, nil
+}
+
+// cachingReader keeps a copy of what is read, handed to done once the content was read
+// to the end and closed, unless it grew over limit
+type cachingReader struct {
+	io.ReadCloser
+	limit int64
+	done  func(content []byte)
+
+	buf      bytes.Buffer
+	overflow bool
+	eof      bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if !r.overflow && n > 0 {
+		if int64(r.buf.Len()+n) > r.limit {
+			r.overflow = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	err := r.ReadCloser.Close()
+	if err == nil && r.eof && !r.overflow {
+		r.done(r.buf.Bytes())
+	}
+	r.buf = bytes.Buffer{}
+	return err
+}
@@ -0,0 +1,100 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogCache(t *testing.T) {
+	defer func(orig *logCache) { decompressedLogs = orig }(decompressedLogs)
+	decompressedLogs = newLogCache(DefaultLogCacheBytes)
+
+	base := t.TempDir()
+	newStandardLog(t, base, "access-20250115.log", []byte("first\n"))
+	if r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, DateSource: LogDateFromName}); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base)})
+	name := "core/access-20250115.log.gz"
+	read := func() string {
+		t.Helper()
+		rc, err := m.openLog(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := read(); got != "first\n" {
+		t.Fatalf("read %q", got)
+	}
+	if got := read(); got != "first\n" {
+		t.Fatalf("cached read %q", got)
+	}
+	if s := LogCacheStatistics(); s.Hits != 1 || s.Misses != 1 || s.Entries != 1 || s.Bytes != int64(len("first\n")) {
+		t.Errorf("stats after a repeated open = %+v", s)
+	}
+
+	// A rewritten archive is decompressed again
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("second, longer\n"))
+	gz.Close()
+	path := filepath.Join(base, "core", "access-20250115.log.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "second, longer\n" {
+		t.Errorf("read %q after the archive changed", got)
+	}
+	if s := LogCacheStatistics(); s.Invalidations != 1 || s.Entries != 1 {
+		t.Errorf("stats after the archive changed = %+v", s)
+	}
+
+	// The search of a compressed log shares the cache, it is keyed by the same file
+	if lines, err := SearchLogs(path, "longer", NewRedactor(nil)); err != nil || len(lines) != 1 {
+		t.Errorf("search = %q, %v", lines, err)
+	}
+	if s := LogCacheStatistics(); s.Hits != 2 {
+		t.Errorf("the search should be served from the cache, stats = %+v", s)
+	}
+
+	// A deleted archive leaves the cache
+	if err := m.deleteArchive(context.Background(), name); err != nil {
+		t.Fatal(err)
+	}
+	if s := LogCacheStatistics(); s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("stats after the archive was deleted = %+v", s)
+	}
+
+	// A log over the bound is not kept
+	decompressedLogs = newLogCache(4)
+	large := filepath.Join(t.TempDir(), "out.log.gz")
+	if err := os.WriteFile(large, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if lines, err := SearchLogs(large, "", NewRedactor(nil)); err != nil || len(lines) != 1 {
+			t.Fatalf("search = %q, %v", lines, err)
+		}
+	}
+	if s := LogCacheStatistics(); s.Misses != 2 || s.Entries != 0 {
+		t.Errorf("stats of a log over the bound = %+v", s)
+	}
+}
@@ -0,0 +1,130 @@
+package log_maintenance
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Effective date of a log file. ModTime is reset when logs are copied or
+// restored, the date can be derived from the file name or its content instead.
+
+// Log date sources
+const (
+	LogDateFromModTime = "modtime" // file modification time, default
+	LogDateFromName    = "name"    // date in the file name, e.g. 2025-01-02.log or access-20250102.log
+	LogDateFromContent = "content" // last timestamp written in the file
+)
+
+const logDateProbeBytes = 8192
+
+// timeNow current time of the maintenance, replaced in tests
+var timeNow = time.Now
+
+var (
+	logNameDatePattern    = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})`)
+	logContentDatePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}`)
+)
+
+// effectiveDate returns the date used for retention decisions, falls back to ModTime
+// when the configured source has no usable date
+func (m *maintenanceRun) effectiveDate(path string, info os.FileInfo) time.Time {
+	if t, ok := m.dates[path]; ok {
+		return t
+	}
+
+	t := info.ModTime()
+
+	if d, ok := m.layoutDate(filepath.Base(path)); ok {
+		m.dates[path] = d
+		return d
+	}
+
+	switch m.cfg.DateSource {
+	case LogDateFromName:
+		if d, ok := dateFromName(filepath.Base(path), m.location()); ok {
+			t = d
+		}
+	case LogDateFromContent:
+		if d, ok := dateFromContent(path, m.location()); ok {
+			t = d
+		}
+	}
+
+	m.dates[path] = t
+	return t
+}
+
+// dateFromName parses the date of the file name, interpreted as midnight in loc
+func dateFromName(name string, loc *time.Location) (time.Time, bool) {
+	match := logNameDatePattern.FindStringSubmatch(name)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation("20060102", match[1]+match[2]+match[3], loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// dateFromContent returns the last timestamp of the file, the first one when
+// the tail of the file has none (e.g. a long trailing stack trace)
+func dateFromContent(path string, loc *time.Location) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	buf := make([]byte, logDateProbeBytes)
+
+	if info.Size() > logDateProbeBytes {
+		if n, err := f.ReadAt(buf, info.Size()-logDateProbeBytes); err == nil || err == io.EOF {
+			if matches := logContentDatePattern.FindAll(buf[:n], -1); len(matches) > 0 {
+				if t, ok := parseLogTimestamp(string(matches[len(matches)-1]), loc); ok {
+					return t, true
+				}
+			}
+		}
+	}
+
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return time.Time{}, false
+	}
+
+	matches := logContentDatePattern.FindAll(buf[:n], -1)
+	if len(matches) == 0 {
+		return time.Time{}, false
+	}
+
+	// Small file: the whole content was read, its last timestamp is the most accurate
+	if info.Size() <= logDateProbeBytes {
+		return parseLogTimestamp(string(matches[len(matches)-1]), loc)
+	}
+
+	return parseLogTimestamp(string(matches[0]), loc)
+}
+
+func parseLogTimestamp(s string, loc *time.Location) (time.Time, bool) {
+	if len(s) > 10 && s[10] == 'T' {
+		s = s[:10] + " " + s[11:]
+	}
+
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", s, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
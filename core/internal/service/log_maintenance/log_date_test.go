@@ -0,0 +1,219 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOperationLogCutoff(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*3600)
+
+	cases := []struct {
+		now  time.Time
+		want string
+	}{
+		{time.Date(2025, 3, 31, 10, 0, 0, 0, tokyo), "2025-02-28"},
+		{time.Date(2024, 3, 31, 10, 0, 0, 0, tokyo), "2024-02-29"},
+		{time.Date(2025, 1, 15, 0, 0, 0, 0, tokyo), "2024-12-15"},
+		{time.Date(2025, 5, 31, 23, 59, 59, 0, tokyo), "2025-04-30"},
+	}
+
+	for _, c := range cases {
+		got := operationLogCutoff(c.now)
+		if got.Format("2006-01-02 15:04:05") != c.want+" 00:00:00" || got.Location() != tokyo {
+			t.Errorf("cutoff of %s = %s, want %s midnight JST", c.now, got, c.want)
+		}
+	}
+
+	// The day after the spring-forward change, midnight of the previous month is a normal midnight
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	got := operationLogCutoff(time.Date(2025, 3, 10, 1, 0, 0, 0, ny))
+	if want := time.Date(2025, 2, 10, 0, 0, 0, 0, ny); !got.Equal(want) {
+		t.Errorf("cutoff across DST = %s, want %s", got, want)
+	}
+}
+
+func TestOperationLogsDatedInConfiguredLocation(t *testing.T) {
+	base := t.TempDir()
+	opDir := filepath.Join(base, "core", "operation_log")
+	for _, day := range []string{"2025-02-28", "2025-03-01"} {
+		if err := os.MkdirAll(filepath.Join(opDir, day), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(opDir, day, "a.json"), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// April 1st in Tokyo while it is still March 31st in UTC
+	tokyo := time.FixedZone("JST", 9*3600)
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	timeNow = func() time.Time { return time.Date(2025, 3, 31, 15, 30, 0, 0, time.UTC) }
+
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Location: tokyo, OperationLogLocation: tokyo})
+
+	if _, err := os.Stat(filepath.Join(opDir, "2025-02-28.tar.gz")); err != nil {
+		t.Errorf("directory older than a month in Tokyo should be archived: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(opDir, "2025-03-01")); err != nil {
+		t.Errorf("directory of the cutoff day should be kept: %v", err)
+	}
+}
+
+func TestOperationLogLocation(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	tokyo := time.FixedZone("JST", 9*3600)
+
+	cases := []struct {
+		name     string
+		loc      *time.Location
+		now      time.Time
+		archived []string
+		kept     []string
+	}{
+		// 00:05 on April 1st in Tokyo, the day the writer created is already April 1st
+		{"near midnight", tokyo, time.Date(2025, 3, 31, 15, 5, 0, 0, time.UTC), []string{"2025-02-28"}, []string{"2025-03-01", "2025-04-01"}},
+		// 23:30 on April 8th in New York, after the spring-forward change of March 9th
+		{"after DST start", ny, time.Date(2025, 4, 9, 3, 30, 0, 0, time.UTC), []string{"2025-03-07"}, []string{"2025-03-08", "2025-03-09"}},
+		// 00:30 on December 2nd in New York, after the fall-back change of November 2nd
+		{"after DST end", ny, time.Date(2025, 12, 2, 5, 30, 0, 0, time.UTC), []string{"2025-11-01"}, []string{"2025-11-02"}},
+	}
+
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			base := t.TempDir()
+			opDir := filepath.Join(base, "core", "operation_log")
+			for _, day := range append(append([]string{}, c.archived...), c.kept...) {
+				if err := os.MkdirAll(filepath.Join(opDir, day), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(opDir, day, "a.json"), []byte("{}"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			timeNow = func() time.Time { return c.now }
+
+			// The standard logs use another zone, the operation log days only follow theirs
+			cfg := MaintenanceConfig{BasePath: base, Location: time.UTC, OperationLogLocation: c.loc}
+			if r := RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+				t.Fatalf("unexpected failures: %v", r.Err())
+			}
+
+			for _, day := range c.archived {
+				if _, err := os.Stat(filepath.Join(opDir, day+".tar.gz")); err != nil {
+					t.Errorf("%s should be archived: %v", day, err)
+				}
+			}
+			for _, day := range c.kept {
+				if _, err := os.Stat(filepath.Join(opDir, day)); err != nil {
+					t.Errorf("%s should be kept: %v", day, err)
+				}
+			}
+		})
+	}
+}
+
+func TestEffectiveDate(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	write := func(name, content string) (string, os.FileInfo) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path, info
+	}
+
+	stamped := "2025-01-02 10:00:00 started\n2025-01-03 11:30:00 done\n"
+
+	for _, tc := range []struct {
+		name    string
+		cfg     MaintenanceConfig
+		file    string
+		content string
+		want    time.Time
+	}{
+		{"modtime by default", MaintenanceConfig{}, "access-20250102.log", stamped, modTime},
+		{"name", MaintenanceConfig{DateSource: LogDateFromName}, "access-20250102.log", "", time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"name without date", MaintenanceConfig{DateSource: LogDateFromName}, "access.log", "", modTime},
+		{"content", MaintenanceConfig{DateSource: LogDateFromContent}, "content.log", stamped, time.Date(2025, 1, 3, 11, 30, 0, 0, time.UTC)},
+		{"content without timestamp", MaintenanceConfig{DateSource: LogDateFromContent}, "plain.log", "no dates here\n", modTime},
+		// A matching layout wins over the configured source
+		{"layout", MaintenanceConfig{DateSource: LogDateFromContent, DateLayouts: []string{"2006-01-02_15"}}, "2025-01-05_07.log", stamped, time.Date(2025, 1, 5, 7, 0, 0, 0, time.UTC)},
+	} {
+		tc.cfg.Location = time.UTC
+		m := newMaintenanceRun(context.Background(), tc.cfg)
+
+		path, info := write(tc.file, tc.content)
+		if got := m.effectiveDate(path, info); !got.Equal(tc.want) {
+			t.Errorf("%s: effectiveDate = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	// The date is computed once per run
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{DateSource: LogDateFromContent, Location: time.UTC})
+	path, info := write("cached.log", stamped)
+	first := m.effectiveDate(path, info)
+	write("cached.log", "2025-03-04 00:00:00 rewritten\n")
+	if got := m.effectiveDate(path, info); !got.Equal(first) {
+		t.Errorf("cached date = %s, want %s", got, first)
+	}
+}
+
+func TestDateFromContent(t *testing.T) {
+	dir := t.TempDir()
+	tokyo := time.FixedZone("JST", 9*3600)
+
+	filler := strings.Repeat("x", logDateProbeBytes) + "\n"
+
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    time.Time
+		ok      bool
+	}{
+		{"small file, last timestamp", "2025-01-02 10:00:00 a\n2025-01-03 11:30:00 b\n", time.Date(2025, 1, 3, 11, 30, 0, 0, tokyo), true},
+		{"T separator", "2025-01-02T10:00:00+09:00 a\n", time.Date(2025, 1, 2, 10, 0, 0, 0, tokyo), true},
+		{"large file, last timestamp of the tail", "2025-01-02 10:00:00 a\n" + filler + "2025-01-04 08:00:00 b\n", time.Date(2025, 1, 4, 8, 0, 0, 0, tokyo), true},
+		// A trailing stack trace longer than the probe
+		{"large file, tail without timestamp", "2025-01-02 10:00:00 a\n2025-01-02 10:00:05 b\n" + filler, time.Date(2025, 1, 2, 10, 0, 0, 0, tokyo), true},
+		{"no timestamp", "panic: nothing to see\n", time.Time{}, false},
+		{"invalid timestamp", "2025-13-45 10:00:00 a\n", time.Time{}, false},
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(tc.name, " ", "_")+".log")
+		if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := dateFromContent(path, tokyo)
+		if ok != tc.ok || !got.Equal(tc.want) {
+			t.Errorf("%s: dateFromContent = %s %t, want %s %t", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	if _, ok := dateFromContent(filepath.Join(dir, "missing.log"), tokyo); ok {
+		t.Error("date of a missing file")
+	}
+}
@@ -0,0 +1,135 @@
+package log_maintenance
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+)
+
+// Directories of the standard logs: core, core/out and the targets of the merge groups.
+// Only the logs directly in a directory are managed by default. A directory set
+// Recursive in LogDirs is scanned with its subdirectories, their logs join the groups of
+// the directory so each group keeps its newest logs across the whole subtree, and each
+// log is archived next to itself. The subdirectories that are log directories of their
+// own, the operation logs, the quarantines and the hidden directories are left out.
+// Symbolic links to directories are not followed, and a linked log resolving out of
+// BasePath is skipped.
+
+// LogDirOptions options of a directory of the standard logs
+type LogDirOptions struct {
+	Recursive bool // its subdirectories are scanned too
+}
+
+// standardLogDirs the directories of the standard logs, absolute, followed by those of
+// the external sources
+func (m *maintenanceRun) standardLogDirs() []string {
+	dirs := []string{
+		filepath.Join(m.cfg.BasePath, "core"),
+		filepath.Join(m.cfg.BasePath, "core", "out"),
+	}
+	dirs = append(dirs, m.mergeDirs()...)
+	return append(dirs, m.externalDirs()...)
+}
+
+// logDirOptions the options of the directory dir
+func (m *maintenanceRun) logDirOptions(dir string) LogDirOptions {
+	rel, err := filepath.Rel(m.cfg.BasePath, dir)
+	if err != nil {
+		return LogDirOptions{}
+	}
+	return m.cfg.LogDirs[filepath.ToSlash(rel)]
+}
+
+// scanLogDir the *.log files of a directory of the standard logs, with those of its
+// subdirectories when it is recursive, or the logs of an external source
+func (m *maintenanceRun) scanLogDir(ctx context.Context, dir string) ([]string, error) {
+	if src := m.externalSourceOf(dir); src != nil {
+		return scanExternalDir(src)
+	}
+	if !m.logDirOptions(dir).Recursive {
+		return gfile.ScanDir(dir, "*.log", false)
+	}
+
+	excluded := map[string]bool{
+		filepath.Join(m.cfg.BasePath, "core", "operation_log"): true,
+		filepath.Join(m.cfg.BasePath, quarantineDir):           true,
+		filepath.Join(m.cfg.BasePath, corruptDir):              true,
+	}
+	for _, other := range m.standardLogDirs() {
+		if filepath.Clean(other) != filepath.Clean(dir) {
+			excluded[filepath.Clean(other)] = true
+		}
+	}
+
+	base, err := filepath.EvalSymlinks(m.cfg.BasePath)
+	if err != nil {
+		base = m.cfg.BasePath
+	}
+
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable subdirectory is left out, the rest of the tree is still managed
+			if path != dir {
+				g.Log().Warningf(ctx, "Failed to scan log directory %s: %v", path, err)
+				return nil
+			}
+			return err
+		}
+
+		if d.IsDir() {
+			if path != dir && (excluded[path] || strings.HasPrefix(d.Name(), ".")) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if ok, _ := filepath.Match("*.log", d.Name()); !ok {
+			return nil
+		}
+
+		if d.Type()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return nil
+			}
+			if rel, err := filepath.Rel(base, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				g.Log().Warningf(ctx, "Log %s links out of the logs tree to %s, skipped", path, target)
+				return nil
+			}
+			if info, err := os.Stat(target); err != nil || info.IsDir() {
+				return nil
+			}
+		}
+
+		files = append(files, path)
+		return nil
+	})
+
+	return files, err
+}
+
+// activeLogsOf the logs being written in the directories of files, see activeLogs
+func (m *maintenanceRun) activeLogsOf(ctx context.Context, dir string, files []string) map[string]bool {
+	active := m.activeLogs(ctx, dir)
+
+	seen := map[string]bool{filepath.Clean(dir): true}
+	for _, file := range files {
+		sub := filepath.Dir(file)
+		if seen[sub] {
+			continue
+		}
+		seen[sub] = true
+
+		for path := range m.activeLogs(ctx, sub) {
+			active[path] = true
+		}
+	}
+
+	return active
+}
@@ -0,0 +1,90 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecursiveLogDirs(t *testing.T) {
+	base, outside := t.TempDir(), t.TempDir()
+
+	write := func(rel string, daysAgo int) string {
+		path := filepath.Join(base, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("line\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().AddDate(0, 0, -daysAgo)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	top := write("core/error-20250301.log", 5)
+	oldest := write("core/app/error-20250228.log", 7)
+	nested := write("core/app/error-20250302.log", 4)
+	deeper := write("core/app/v2/error-20250303.log", 3)
+	out := write("core/out/error-20250304.log", 6)
+	hidden := write("core/.cache/error-20250201.log", 30)
+
+	target := filepath.Join(outside, "error-20250101.log")
+	if err := os.WriteFile(target, []byte("outside\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(base, "core", "app", "error-20250101.log")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not recursive, the nested logs are left alone
+	cfg := MaintenanceConfig{BasePath: base, Retention: RetentionPolicy{FilesToKeep: 2}}
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(top + ".gz"); err != nil {
+		t.Errorf("log %s not compressed: %v", top, err)
+	}
+	for _, path := range []string{oldest, nested, deeper, hidden} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("log %s should be left alone: %v", path, err)
+		}
+	}
+
+	// Recursive, the group keeps its 2 newest logs across the subtree and the logs are
+	// archived where they are
+	cfg.LogDirs = map[string]LogDirOptions{"core": {Recursive: true}}
+	r = RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(oldest); !os.IsNotExist(err) {
+		t.Errorf("oldest log %s beyond the retention not deleted", oldest)
+	}
+	for _, path := range []string{nested, deeper} {
+		if _, err := os.Stat(path + ".gz"); err != nil {
+			t.Errorf("nested log not compressed in place: %v", err)
+		}
+	}
+
+	// Neither the other log directories, the hidden ones nor the links out of the tree
+	for _, path := range []string{out + ".gz", hidden, link, target} {
+		if _, err := os.Lstat(path); err != nil {
+			t.Errorf("%s should be left alone: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("log of core/out kept, stat err: %v", err)
+	}
+
+	cfg.LogDirs = map[string]LogDirOptions{"../elsewhere": {Recursive: true}}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("log directory out of the tree accepted")
+	}
+}
@@ -0,0 +1,202 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Log groups: the standard logs of a directory are split into groups by file name, each
+// group is a retention domain of its own (the newest logs kept, the older compressed or
+// deleted) and the unit of the rollups. A file belongs to the first group whose pattern
+// matches its name, files matching no group are left alone. Two groups with the same
+// name, or a group whose every file is taken by an earlier one, are refused by the
+// validation of the configuration. Groups overlapping partially are allowed, the files
+// matching both are kept in the first and listed by the run.
+
+// LogGroup named set of standard logs selected by a pattern on the file name
+type LogGroup struct {
+	Name    string // part of the rollup names, letters, digits, '.', '_' and '-'
+	Pattern *regexp.Regexp
+
+	// ActiveLink optional name of a symlink in the log directory to the log being
+	// written, e.g. "current.log", see ActiveLogFunc
+	ActiveLink string
+}
+
+var logGroupNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// defaultLogGroups the groups used when MaintenanceConfig.LogGroups is unset
+var defaultLogGroups = []LogGroup{
+	{Name: "access", Pattern: regexp.MustCompile(`^access-`)},
+	{Name: "error", Pattern: regexp.MustCompile(`^error-`)},
+	{Name: "trace", Pattern: regexp.MustCompile(`^trace-`)},
+	{Name: "date", Pattern: regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\.log$`)},
+	{Name: "smtp-protocol", Pattern: regexp.MustCompile(`^smtp-protocol-`)},
+}
+
+// DefaultLogGroups returns the built-in groups: access-*, error-*, trace-*, the
+// YYYY-MM-DD.log files and the smtp-protocol-* logs of the listeners, to extend when
+// configuring LogGroups
+func DefaultLogGroups() []LogGroup {
+	return append([]LogGroup(nil), defaultLogGroups...)
+}
+
+// NewLogGroup compiles a group, pattern is matched against the file name
+func NewLogGroup(name, pattern string) (LogGroup, error) {
+	if !logGroupNamePattern.MatchString(name) {
+		return LogGroup{}, fmt.Errorf("invalid log group name %q", name)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return LogGroup{}, fmt.Errorf("log group %s: %w", name, err)
+	}
+
+	return LogGroup{Name: name, Pattern: re}, nil
+}
+
+// validLogGroups the usable configured groups, the defaults when none is configured
+func validLogGroups(ctx context.Context, groups []LogGroup) []LogGroup {
+	if len(groups) == 0 {
+		return defaultLogGroups
+	}
+
+	valid := make([]LogGroup, 0, len(groups))
+	for _, group := range groups {
+		if group.Pattern == nil || !logGroupNamePattern.MatchString(group.Name) || !validActiveLink(group.ActiveLink) {
+			g.Log().Warningf(ctx, "Invalid log group %q ignored", group.Name)
+			continue
+		}
+		if err := checkLogGroupOverlap(valid, group); err != nil {
+			g.Log().Warningf(ctx, "Log group %q ignored: %v", group.Name, err)
+			continue
+		}
+		valid = append(valid, group)
+	}
+
+	return valid
+}
+
+// checkLogGroupOverlap rejects a group named like one of the earlier groups or matching
+// no file they do not match already
+func checkLogGroupOverlap(earlier []LogGroup, group LogGroup) error {
+	prefix, anchored, _ := anchoredPrefix(group.Pattern)
+
+	for _, e := range earlier {
+		if e.Name == group.Name {
+			return fmt.Errorf("duplicate log group %q", group.Name)
+		}
+
+		if e.Pattern.String() == group.Pattern.String() {
+			return fmt.Errorf("log group %s has the pattern of %s", group.Name, e.Name)
+		}
+
+		if p, ok, all := anchoredPrefix(e.Pattern); ok && all && anchored && strings.HasPrefix(prefix, p) {
+			return fmt.Errorf("every file of log group %s matches %s first", group.Name, e.Name)
+		}
+	}
+
+	return nil
+}
+
+// anchoredPrefix the literal prefix of the names matched by re when it is anchored at
+// the start of the name. all tells whether re matches every name with the prefix
+func anchoredPrefix(re *regexp.Regexp) (prefix string, anchored, all bool) {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return "", false, false
+	}
+	parsed = parsed.Simplify()
+
+	subs := []*syntax.Regexp{parsed}
+	if parsed.Op == syntax.OpConcat {
+		subs = parsed.Sub
+	}
+	if len(subs) == 0 || subs[0].Op != syntax.OpBeginText {
+		return "", false, false
+	}
+	subs = subs[1:]
+
+	var literal strings.Builder
+	for len(subs) > 0 && subs[0].Op == syntax.OpLiteral && subs[0].Flags&syntax.FoldCase == 0 {
+		literal.WriteString(string(subs[0].Rune))
+		subs = subs[1:]
+	}
+
+	// Anything may follow the prefix: nothing more, or .*
+	all = true
+	for _, sub := range subs {
+		if sub.Op != syntax.OpStar || (sub.Sub[0].Op != syntax.OpAnyCharNotNL && sub.Sub[0].Op != syntax.OpAnyChar) {
+			all = false
+		}
+	}
+
+	return literal.String(), true, all
+}
+
+// validActiveLink reports whether the active link is unset or a plain file name
+func validActiveLink(name string) bool {
+	return name == "" || (name != "." && name != ".." && !strings.ContainsAny(name, `/\`))
+}
+
+// logGroupOf returns the group of a standard log file, empty when the file is not managed
+func (m *maintenanceRun) logGroupOf(filename string) string {
+	groups := m.logGroups
+	if groups == nil {
+		groups = defaultLogGroups
+	}
+
+	name := ""
+	var matched []string
+	for _, group := range groups {
+		if !m.matchesGroup(group, filename) {
+			continue
+		}
+		if name == "" {
+			name = group.Name
+		}
+		matched = append(matched, group.Name)
+	}
+
+	if len(matched) > 1 {
+		m.noteOverlap(filename, matched)
+	}
+	return name
+}
+
+// noteOverlap records a file matching several groups, listed at the end of the run
+func (m *maintenanceRun) noteOverlap(filename string, groups []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.overlapping == nil {
+		m.overlapping = make(map[string][]string)
+	}
+	m.overlapping[filename] = groups
+}
+
+// overlappingLogs the files that matched several groups, sorted, and their description
+// for the warning of the run
+func (m *maintenanceRun) overlappingLogs() ([]string, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]string, 0, len(m.overlapping))
+	for file := range m.overlapping {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	described := make([]string, 0, len(files))
+	for _, file := range files {
+		described = append(described, fmt.Sprintf("%s (%s)", file, strings.Join(m.overlapping[file], ", ")))
+	}
+
+	return files, strings.Join(described, "; ")
+}
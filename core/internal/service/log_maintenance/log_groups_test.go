@@ -0,0 +1,98 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCustomLogGroups(t *testing.T) {
+	base := t.TempDir()
+	smtp := newStandardLog(t, base, "smtp-in-20200101.log", []byte("smtp line\n"))
+	other := newStandardLog(t, base, "custom-20200101.log", []byte("other line\n"))
+
+	group, err := NewLogGroup("smtp-in", `^smtp-in-.*\.log$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewLogGroup("bad/name", `.*`); err == nil {
+		t.Errorf("a group name with a slash should be refused")
+	}
+	if _, err = NewLogGroup("bad", `(`); err == nil {
+		t.Errorf("an invalid pattern should be refused")
+	}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{
+		BasePath:     base,
+		LogGroups:    append(DefaultLogGroups(), group),
+		LogUnmanaged: true,
+	})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	if _, err = os.Stat(smtp + ".gz"); err != nil {
+		t.Errorf("log of the custom group should be archived: %v", err)
+	}
+	if _, err = os.Stat(other); err != nil {
+		t.Errorf("log matching no group should be left alone: %v", err)
+	}
+	if _, err = os.Stat(other + ".gz"); !os.IsNotExist(err) {
+		t.Errorf("log matching no group should not be archived, stat err: %v", err)
+	}
+}
+
+func TestOverlappingLogGroups(t *testing.T) {
+	group := func(name, pattern string) LogGroup {
+		lg, err := NewLogGroup(name, pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lg
+	}
+
+	refused := map[string][]LogGroup{
+		"duplicate name":  {group("smtp", `^smtp-`), group("smtp", `^submission-`)},
+		"same pattern":    {group("smtp", `^smtp-`), group("smtp-in", `^smtp-`)},
+		"longer prefix":   {group("smtp", `^smtp-`), group("smtp-in", `^smtp-in-.*\.log$`)},
+		"after catch-all": {group("all", `^.*`), group("access", `^access-`)},
+	}
+	for name, groups := range refused {
+		if err := validateConfig(MaintenanceConfig{LogGroups: groups}); err == nil {
+			t.Errorf("%s: overlapping groups accepted", name)
+		}
+		if valid := validLogGroups(context.Background(), groups); len(valid) != 1 || valid[0].Name != groups[0].Name {
+			t.Errorf("%s: expected the first group only, got %v", name, valid)
+		}
+	}
+
+	// A partial overlap is accepted, the files matching both are kept in the first
+	groups := append(DefaultLogGroups(), group("smtp-in", `^smtp-in-`), group("january", `-202001\d\d\.log$`))
+	if err := validateConfig(MaintenanceConfig{LogGroups: groups}); err != nil {
+		t.Fatal(err)
+	}
+
+	base := t.TempDir()
+	access := newStandardLog(t, base, "access-20200101.log", []byte("access line\n"))
+	custom := newStandardLog(t, base, "custom-20200101.log", []byte("custom line\n"))
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, LogGroups: groups})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	if len(r.Overlapping) != 1 || r.Overlapping[0] != "access-20200101.log" {
+		t.Errorf("expected the access log listed as overlapping, got %v", r.Overlapping)
+	}
+	for _, path := range []string{access, custom} {
+		if _, err := os.Stat(path + ".gz"); err != nil {
+			t.Errorf("%s should be archived: %v", filepath.Base(path), err)
+		}
+	}
+
+	m := &maintenanceRun{logGroups: groups}
+	if got := m.logGroupOf("access-20200101.log"); got != "access" {
+		t.Errorf("group of the overlapping log = %q, expected the first matching", got)
+	}
+}
@@ -0,0 +1,668 @@
+package log_maintenance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Shipping of the log lines to a central log store, next to their archival. For the log
+// groups selected, the lines appended to the standard logs are sanitized, redacted like
+// the lines shown (DisplayRedactor), parsed for their time and level and pushed in
+// batches to a Loki push API or an OTLP/HTTP logs endpoint. The offset pushed in each log
+// is saved once the store acknowledged the batch, a push interrupted before that is sent
+// again: the delivery is at least once. A log is recognized by a checksum of its start,
+// one truncated or replaced is pushed again from its start. One batch is in flight at a
+// time, a store answering 429 or 5xx is retried with a growing delay and a pass stops
+// after MaxBytesPerPass, the rest waits for the next pass.
+//
+// The pusher is its own job and never takes the lock of the maintenance runs, the
+// archival does not wait for it. A log compressed before all its lines were pushed keeps
+// its last lines unpushed, the pusher runs every minute while a log is compressed a day
+// after it was last written.
+
+// Formats of the pushed lines
+const (
+	LogPushLoki = "loki" // Loki push API, e.g. http://loki:3100/loki/api/v1/push
+	LogPushOTLP = "otlp" // OTLP/HTTP JSON, e.g. http://collector:4318/v1/logs
+)
+
+const (
+	DefaultPushBatchLines  = 1000
+	DefaultPushBatchBytes  = 1 << 20
+	DefaultPushBytesPerRun = 64 << 20
+	DefaultPushRetries     = 3
+	DefaultPushRetryDelay  = 2 * time.Second
+	DefaultPushTimeout     = 30 * time.Second
+
+	pushStateFile = ".push_state.json"
+
+	// pushHeadBytes start of a log whose checksum tells it from the log replacing it
+	pushHeadBytes = 1024
+
+	// maxPushLine longer lines are cut
+	maxPushLine = 64 << 10
+)
+
+// LogPush the shipping of the lines of some log groups to a log store
+type LogPush struct {
+	Endpoint string // URL of the push API
+	Format   string // LogPushLoki by default, or LogPushOTLP
+	Groups   []string
+
+	// Labels static labels of the lines, the Loki stream labels or the OTLP resource
+	// attributes. The group, the file and the level are added to them
+	Labels map[string]string
+	// Headers of the requests, e.g. Authorization or X-Scope-OrgID
+	Headers map[string]string
+
+	BatchLines int // lines per request, DefaultPushBatchLines when 0
+	BatchBytes int // bytes of lines per request, DefaultPushBatchBytes when 0
+
+	// MaxBytesPerPass bound of the bytes read by a pass, DefaultPushBytesPerRun when 0
+	MaxBytesPerPass int64
+
+	// Retries of a failed request (DefaultPushRetries when 0, never when negative) after
+	// RetryDelay (DefaultPushRetryDelay when unset), doubled on each retry
+	Retries    int
+	RetryDelay time.Duration
+	Timeout    time.Duration // of a request, DefaultPushTimeout when unset
+
+	Client *http.Client `json:"-"` // http.DefaultClient when nil
+}
+
+// PushResult outcome of a pass of the pusher
+type PushResult struct {
+	Files   int `json:"files"` // logs with lines pushed
+	Lines   int `json:"lines"`
+	Bytes   int `json:"bytes"`
+	Batches int `json:"batches"`
+	Retried int `json:"retried,omitempty"` // failed requests retried
+	Dropped int `json:"dropped,omitempty"` // lines of the batches the store rejected as invalid
+
+	// Partial the pass stopped at MaxBytesPerPass or on a failure, the next pass resumes
+	Partial bool   `json:"partial,omitempty"`
+	Err     string `json:"error,omitempty"`
+}
+
+// pushOffset what was pushed of a log
+type pushOffset struct {
+	Offset int64  `json:"offset"`
+	Head   string `json:"head"` // hex SHA-256 of the first Len bytes
+	Len    int64  `json:"head_len"`
+}
+
+// pushState the offsets of the logs by path
+type pushState struct {
+	Logs map[string]pushOffset `json:"logs"`
+}
+
+// pushedLine a line parsed for the store
+type pushedLine struct {
+	time  time.Time
+	level string
+	text  string
+}
+
+// pushMutex serializes the passes of the pusher, apart from runMutex
+var pushMutex sync.Mutex
+
+// PushLogs runs a pass of the pusher of the default service, a no-op unless Push is set
+func PushLogs(ctx context.Context) {
+	cfg := DefaultService().Config()
+	if cfg.Push == nil {
+		return
+	}
+
+	result := pushLogs(ctx, cfg)
+	switch {
+	case result.Err != "":
+		g.Log().Warningf(ctx, "Push of the logs to %s failed after %d lines: %s", cfg.Push.Endpoint, result.Lines, result.Err)
+	case result.Lines > 0:
+		g.Log().Debugf(ctx, "Pushed %d log lines of %d logs in %d batches", result.Lines, result.Files, result.Batches)
+	}
+}
+
+// validatePush rejects a push without endpoint, of an unknown format or without groups
+func validatePush(p *LogPush) error {
+	if p == nil {
+		return nil
+	}
+
+	u, err := url.Parse(p.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid log push endpoint %q", p.Endpoint)
+	}
+	switch p.Format {
+	case "", LogPushLoki, LogPushOTLP:
+	default:
+		return fmt.Errorf("unknown log push format %q", p.Format)
+	}
+	if len(p.Groups) == 0 {
+		return fmt.Errorf("log push without log groups")
+	}
+	if p.BatchLines < 0 || p.BatchBytes < 0 || p.MaxBytesPerPass < 0 || p.RetryDelay < 0 || p.Timeout < 0 {
+		return fmt.Errorf("negative log push limit")
+	}
+	return nil
+}
+
+func pushLogs(ctx context.Context, cfg MaintenanceConfig) PushResult {
+	pushMutex.Lock()
+	defer pushMutex.Unlock()
+
+	var result PushResult
+	if cfg.BasePath == "" {
+		return result
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+
+	p := newPusher(cfg)
+	m := newMaintenanceRun(ctx, cfg)
+
+	selected := make(map[string]bool, len(p.cfg.Groups))
+	for _, group := range p.cfg.Groups {
+		selected[group] = true
+	}
+
+	var files []string
+	for _, dir := range m.standardLogDirs() {
+		logs, err := m.scanLogDir(ctx, dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range logs {
+			if group := m.groupOfLog(file); selected[group] {
+				files = append(files, file)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	state := loadPushState(cfg.BasePath)
+
+	// The offsets of the logs gone, compressed or deleted, are forgotten
+	kept := make(map[string]pushOffset, len(files))
+	for _, file := range files {
+		if offset, ok := state.Logs[file]; ok {
+			kept[file] = offset
+		}
+	}
+	state.Logs = kept
+
+	budget := p.cfg.MaxBytesPerPass
+	for _, file := range files {
+		if ctx.Err() != nil {
+			result.Partial = true
+			break
+		}
+		if budget <= 0 {
+			result.Partial = true
+			break
+		}
+
+		read, err := p.pushLog(ctx, m.groupOfLog(file), file, &state, &result, budget)
+		budget -= read
+		if err != nil {
+			result.Partial = true
+			result.Err = err.Error()
+			break
+		}
+	}
+
+	savePushState(ctx, cfg.BasePath, cfg.FilePerm, state)
+	return result
+}
+
+// pusher the push of a pass
+type pusher struct {
+	cfg    LogPush
+	client *http.Client
+	host   string
+}
+
+func newPusher(cfg MaintenanceConfig) *pusher {
+	p := &pusher{cfg: *cfg.Push, client: cfg.Push.Client}
+	if p.cfg.Format == "" {
+		p.cfg.Format = LogPushLoki
+	}
+	if p.cfg.BatchLines <= 0 {
+		p.cfg.BatchLines = DefaultPushBatchLines
+	}
+	if p.cfg.BatchBytes <= 0 {
+		p.cfg.BatchBytes = DefaultPushBatchBytes
+	}
+	if p.cfg.MaxBytesPerPass <= 0 {
+		p.cfg.MaxBytesPerPass = DefaultPushBytesPerRun
+	}
+	if p.cfg.Retries == 0 {
+		p.cfg.Retries = DefaultPushRetries
+	}
+	if p.cfg.RetryDelay <= 0 {
+		p.cfg.RetryDelay = DefaultPushRetryDelay
+	}
+	if p.cfg.Timeout <= 0 {
+		p.cfg.Timeout = DefaultPushTimeout
+	}
+	if p.client == nil {
+		p.client = http.DefaultClient
+	}
+	p.host, _ = os.Hostname()
+	return p
+}
+
+// pushLog pushes the complete lines of a log after its saved offset, reading at most
+// budget bytes. It returns the bytes read
+func (p *pusher) pushLog(ctx context.Context, group, file string, state *pushState, result *PushResult, budget int64) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, nil
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, nil
+	}
+
+	offset := state.Logs[file]
+	if offset.Offset > info.Size() || !sameHead(f, offset) {
+		offset = pushOffset{}
+	}
+	if offset.Offset == info.Size() {
+		return 0, nil
+	}
+	if _, err = f.Seek(offset.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	labels := map[string]string{"group": group, "file": filepath.Base(file)}
+	var (
+		batch      []pushedLine
+		batchBytes int
+		pending    int64 // bytes read into the batch
+		read       int64
+		pushed     bool
+	)
+
+	// advance saves the offset after the bytes read into the batch
+	advance := func() {
+		offset.Offset += pending
+		pending = 0
+		if offset.Len < pushHeadBytes {
+			offset.Head, offset.Len = headSum(f, offset.Offset)
+		}
+		state.Logs[file] = offset
+	}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		dropped, err := p.send(ctx, labels, batch, result)
+		if err != nil {
+			return err
+		}
+		result.Batches++
+		result.Lines += len(batch) - dropped
+		result.Dropped += dropped
+		result.Bytes += batchBytes
+		pushed = true
+
+		advance()
+		batch, batchBytes = batch[:0], 0
+		return nil
+	}
+
+	reader := bufio.NewReaderSize(io.LimitReader(f, budget), 64<<10)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// A line longer than the buffer is pushed cut, the rest of it skipped
+			rest, skipErr := reader.ReadBytes('\n')
+			if skipErr != nil {
+				break
+			}
+			read += int64(len(line) + len(rest))
+			pending += int64(len(line) + len(rest))
+			batch = append(batch, parsePushedLine(string(line)))
+			batchBytes += len(line)
+		} else if err != nil {
+			// The last line is not complete yet, it is pushed once it is
+			break
+		} else {
+			read += int64(len(line))
+			pending += int64(len(line))
+			text := strings.TrimRight(string(line), "\r\n")
+			if len(text) > maxPushLine {
+				text = text[:maxPushLine]
+			}
+			if text != "" {
+				batch = append(batch, parsePushedLine(text))
+				batchBytes += len(text)
+			}
+		}
+
+		if len(batch) >= p.cfg.BatchLines || batchBytes >= p.cfg.BatchBytes {
+			if err := flush(); err != nil {
+				return read, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return read, err
+	}
+	// What is left are empty lines, skipped without request
+	if pending > 0 {
+		advance()
+	}
+	if pushed {
+		result.Files++
+	}
+	return read, nil
+}
+
+// sameHead reports whether the log still starts with the bytes the offset was saved for
+func sameHead(f *os.File, offset pushOffset) bool {
+	if offset.Len == 0 {
+		return true
+	}
+	sum, n := headSum(f, offset.Len)
+	return n == offset.Len && sum == offset.Head
+}
+
+// headSum the checksum of the first n bytes of the log, at most pushHeadBytes
+func headSum(f *os.File, n int64) (string, int64) {
+	n = min(n, pushHeadBytes)
+	buf := make([]byte, n)
+	read, _ := f.ReadAt(buf, 0)
+	sum := sha256.Sum256(buf[:read])
+	return hex.EncodeToString(sum[:]), int64(read)
+}
+
+// pushLineLayouts time layouts at the start of the lines, the GoFrame logger first
+var pushLineLayouts = []string{
+	"2006-01-02T15:04:05.000Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+}
+
+// parsePushedLine the line sanitized and redacted, with the time and level it starts with
+func parsePushedLine(text string) pushedLine {
+	text, suspicious := SanitizeLogLine(text)
+	text = DisplayRedactor().Redact(text)
+	if suspicious {
+		text = SuspiciousLogMarker + text
+	}
+
+	line := pushedLine{time: timeNow(), text: text}
+
+	fields := strings.SplitN(text, " ", 4)
+	for _, layout := range pushLineLayouts {
+		n := strings.Count(layout, " ") + 1
+		if len(fields) < n {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, strings.Join(fields[:n], " "), time.Local); err == nil {
+			line.time = t
+			if len(fields) > n {
+				line.level = pushLevel(fields[n])
+			}
+			break
+		}
+	}
+	return line
+}
+
+// pushLevel the level of a "[LEVEL]" field, empty when it is not one
+func pushLevel(field string) string {
+	if !strings.HasPrefix(field, "[") || !strings.HasSuffix(field, "]") {
+		return ""
+	}
+	switch level := strings.ToUpper(strings.Trim(field, "[]")); level {
+	case "DEBU", "DEBUG":
+		return "debug"
+	case "INFO", "NOTI", "NOTICE":
+		return "info"
+	case "WARN", "WARNING":
+		return "warn"
+	case "ERRO", "ERROR", "CRIT", "PANI", "FATA":
+		return "error"
+	default:
+		return ""
+	}
+}
+
+// send pushes a batch, retrying the failures the store may recover from. A batch the
+// store rejects as invalid is dropped, it returns its lines
+func (p *pusher) send(ctx context.Context, labels map[string]string, batch []pushedLine, result *PushResult) (int, error) {
+	var body []byte
+	var err error
+	if p.cfg.Format == LogPushOTLP {
+		body, err = p.otlpBody(labels, batch)
+	} else {
+		body, err = p.lokiBody(labels, batch)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	delay := p.cfg.RetryDelay
+	for attempt := 0; ; attempt++ {
+		status, retryAfter, err := p.post(ctx, body)
+		switch {
+		case err == nil && status/100 == 2:
+			return 0, nil
+		case err == nil && status == http.StatusBadRequest:
+			g.Log().Warningf(ctx, "Log store %s rejected a batch of %d lines of %s as invalid, dropped", p.cfg.Endpoint, len(batch), labels["file"])
+			return len(batch), nil
+		case err == nil && status != http.StatusTooManyRequests && status/100 != 5:
+			return 0, fmt.Errorf("log store %s answered %d", p.cfg.Endpoint, status)
+		}
+		if err == nil {
+			err = fmt.Errorf("log store %s answered %d", p.cfg.Endpoint, status)
+		}
+
+		if p.cfg.Retries < 0 || attempt >= p.cfg.Retries || ctx.Err() != nil {
+			return 0, err
+		}
+		result.Retried++
+
+		wait := delay
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// post sends a request body, it returns the status and the delay of a Retry-After
+func (p *pusher) post(ctx context.Context, body []byte) (int, time.Duration, error) {
+	rctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(rctx, http.MethodPost, p.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range p.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return resp.StatusCode, retryAfter, nil
+}
+
+// streamLabels the labels of the lines of a log at level
+func (p *pusher) streamLabels(labels map[string]string, level string) map[string]string {
+	stream := make(map[string]string, len(p.cfg.Labels)+4)
+	for key, value := range p.cfg.Labels {
+		stream[key] = value
+	}
+	for key, value := range labels {
+		stream[key] = value
+	}
+	if p.host != "" {
+		stream["host"] = p.host
+	}
+	if level != "" {
+		stream["level"] = level
+	}
+	return stream
+}
+
+// lokiBody the Loki push request of a batch, one stream per level
+func (p *pusher) lokiBody(labels map[string]string, batch []pushedLine) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	var streams []*stream
+	byLevel := make(map[string]*stream)
+	for _, line := range batch {
+		s, ok := byLevel[line.level]
+		if !ok {
+			s = &stream{Stream: p.streamLabels(labels, line.level)}
+			byLevel[line.level] = s
+			streams = append(streams, s)
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(line.time.UnixNano(), 10), line.text})
+	}
+
+	return json.Marshal(map[string]any{"streams": streams})
+}
+
+// otlpBody the OTLP/HTTP JSON export request of a batch
+func (p *pusher) otlpBody(labels map[string]string, batch []pushedLine) ([]byte, error) {
+	type value struct {
+		StringValue string `json:"stringValue"`
+	}
+	type attribute struct {
+		Key   string `json:"key"`
+		Value value  `json:"value"`
+	}
+	type record struct {
+		TimeUnixNano         string      `json:"timeUnixNano"`
+		ObservedTimeUnixNano string      `json:"observedTimeUnixNano"`
+		SeverityText         string      `json:"severityText,omitempty"`
+		SeverityNumber       int         `json:"severityNumber,omitempty"`
+		Body                 value       `json:"body"`
+		Attributes           []attribute `json:"attributes"`
+	}
+
+	attributes := func(m map[string]string) []attribute {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		list := make([]attribute, 0, len(keys))
+		for _, key := range keys {
+			list = append(list, attribute{Key: key, Value: value{StringValue: m[key]}})
+		}
+		return list
+	}
+
+	resource := p.streamLabels(nil, "")
+	if _, ok := resource["service.name"]; !ok {
+		resource["service.name"] = "billionmail"
+	}
+
+	observed := strconv.FormatInt(timeNow().UnixNano(), 10)
+	records := make([]record, 0, len(batch))
+	for _, line := range batch {
+		records = append(records, record{
+			TimeUnixNano:         strconv.FormatInt(line.time.UnixNano(), 10),
+			ObservedTimeUnixNano: observed,
+			SeverityText:         strings.ToUpper(line.level),
+			SeverityNumber:       otlpSeverity(line.level),
+			Body:                 value{StringValue: line.text},
+			Attributes:           attributes(map[string]string{"log.group": labels["group"], "log.file.name": labels["file"]}),
+		})
+	}
+
+	return json.Marshal(map[string]any{
+		"resourceLogs": []any{map[string]any{
+			"resource": map[string]any{"attributes": attributes(resource)},
+			"scopeLogs": []any{map[string]any{
+				"scope":      map[string]any{"name": "billionmail-log-push"},
+				"logRecords": records,
+			}},
+		}},
+	})
+}
+
+// otlpSeverity the OTLP severity number of a level
+func otlpSeverity(level string) int {
+	switch level {
+	case "debug":
+		return 5
+	case "info":
+		return 9
+	case "warn":
+		return 13
+	case "error":
+		return 17
+	default:
+		return 0
+	}
+}
+
+func loadPushState(basePath string) pushState {
+	state := pushState{Logs: make(map[string]pushOffset)}
+	if data, err := os.ReadFile(filepath.Join(basePath, pushStateFile)); err == nil {
+		_ = json.Unmarshal(data, &state)
+	}
+	if state.Logs == nil {
+		state.Logs = make(map[string]pushOffset)
+	}
+	return state
+}
+
+func savePushState(ctx context.Context, basePath string, perm os.FileMode, state pushState) {
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = writeFile(filepath.Join(basePath, pushStateFile), data, permOrDefault(perm, DefaultFilePerm))
+	}
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to save the log push state: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package log_maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogPush(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+		failures = 1
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for _, stream := range body.Streams {
+			for _, value := range stream.Values {
+				received = append(received, stream.Stream["group"]+" "+stream.Stream["level"]+" "+value[1])
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	base := t.TempDir()
+	path := newStandardLog(t, base, "access-20250115.log", []byte("2025-01-15 10:00:00.000 [INFO] first\n2025-01-15 10:00:01.000 [ERRO] password=hunter2\npartial"))
+	newStandardLog(t, base, "error-20250115.log", []byte("not pushed\n"))
+
+	cfg := MaintenanceConfig{BasePath: base, Push: &LogPush{Endpoint: server.URL, Groups: []string{"access"}, RetryDelay: time.Millisecond}}
+	if err := validatePush(cfg.Push); err != nil {
+		t.Fatal(err)
+	}
+
+	result := pushLogs(context.Background(), cfg)
+	if result.Err != "" || result.Lines != 2 || result.Retried != 1 {
+		t.Fatalf("first pass = %+v", result)
+	}
+	if len(received) != 2 || received[0] != "access info 2025-01-15 10:00:00.000 [INFO] first" || strings.Contains(received[1], "hunter2") || !strings.HasPrefix(received[1], "access error ") {
+		t.Errorf("received %q", received)
+	}
+
+	// A pass pushes only what was appended since, the partial line once complete
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(" line\n")
+	f.Close()
+
+	received = nil
+	if result = pushLogs(context.Background(), cfg); result.Lines != 1 || len(received) != 1 || !strings.HasSuffix(received[0], "partial line") {
+		t.Errorf("second pass = %+v, received %q", result, received)
+	}
+
+	// A replaced log is pushed from its start
+	if err = os.WriteFile(path, []byte("replaced content\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	received = nil
+	if result = pushLogs(context.Background(), cfg); result.Lines != 1 || len(received) != 1 || !strings.HasSuffix(received[0], "replaced content") {
+		t.Errorf("pass of the replaced log = %+v, received %q", result, received)
+	}
+
+	if err = validatePush(&LogPush{Endpoint: "ftp://example.com", Groups: []string{"access"}}); err == nil {
+		t.Error("push to a non HTTP endpoint accepted")
+	}
+}
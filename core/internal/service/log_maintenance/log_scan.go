@@ -0,0 +1,176 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Scan of every log line of the logs tree, for the searches spanning the history, e.g.
+// all the lines of a message. The plain logs, the rotated mail logs, the archives and
+// the archives inside the rollups are read in a single streaming pass, one line at a
+// time, so the size of the tree does not matter. The state files, the quarantine and the
+// operation logs, which are JSON records, are not scanned, nor the archives of a remote
+// sink.
+
+// maxScannedLine longest line scanned, the rest of a longer line is dropped
+const maxScannedLine = 1 << 20
+
+// rotatedLogPattern plain logs and their rotations, e.g. mail.log and mail.log.1
+var rotatedLogPattern = regexp.MustCompile(`\.log(?:\.\d+)?$`)
+
+// ScanLogTree calls fn with every line of the logs tree of the default configuration,
+// sanitized, and the log holding it relative to the tree. The archives inside a rollup
+// are named rollup/archive, e.g. core/access-2025-01.rollup.tar/access-20250101.log.gz.
+// An error of fn stops the scan and is returned
+func ScanLogTree(ctx context.Context, fn func(source, line string) error) error {
+	return scanLogTree(ctx, DefaultService().Config().BasePath, fn)
+}
+
+func scanLogTree(ctx context.Context, basePath string, fn func(source, line string) error) error {
+	return filepath.WalkDir(basePath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable directory is left out, not the whole scan
+			if entry != nil && entry.IsDir() && path != basePath {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, _ := filepath.Rel(basePath, path)
+		rel = filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			if rel == quarantineDir || rel == corruptDir || rel == trashDir || rel == "core/operation_log" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		switch name := entry.Name(); {
+		case strings.HasSuffix(name, rollupExt):
+			return scanRollup(ctx, path, rel, fn)
+		case rotatedLogPattern.MatchString(name):
+			return scanFile(path, rel, false, fn)
+		default:
+			if _, _, dir, ok := SplitArchiveName(name); ok && !dir {
+				return scanFile(path, rel, true, fn)
+			}
+		}
+		return nil
+	})
+}
+
+// scanFile scans a plain log or an archive, the logs removed meanwhile are skipped
+func scanFile(path, source string, archive bool, fn func(source, line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if archive {
+		rc, _, err := unwrapLocalArchive(path, f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		defer rc.Close()
+		r = rc
+	}
+
+	return scanLines(r, source, fn)
+}
+
+// scanRollup scans the archives of a rollup
+func scanRollup(ctx context.Context, path, source string, fn func(source, line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read rollup %s: %w", source, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rc, _, err := unwrapLocalArchive(header.Name, tr)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", source, header.Name, err)
+		}
+		err = scanLines(rc, source+"/"+header.Name, fn)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scanLines calls fn with the sanitized lines of r
+func scanLines(r io.Reader, source string, fn func(source, line string) error) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+
+	for {
+		line, err := readLogLine(reader)
+		if line != "" || err == nil {
+			clean, _ := SanitizeLogLine(line)
+			if fnErr := fn(source, clean); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", source, err)
+		}
+	}
+}
+
+// readLogLine reads a line without its line break, at most maxScannedLine bytes of it
+func readLogLine(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if b.Len() < maxScannedLine {
+			if room := maxScannedLine - b.Len(); len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			b.Write(chunk)
+		}
+		if err != nil {
+			return b.String(), err
+		}
+		if !isPrefix {
+			return b.String(), nil
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanLogTree(t *testing.T) {
+	base := t.TempDir()
+	newStandardLog(t, base, "error-20250301.log", []byte("plain ABC123\nother\n"))
+
+	gz := func(content string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(content))
+		zw.Close()
+		return buf.Bytes()
+	}
+	newStandardLog(t, base, "error-20250201.log.gz", gz("archived ABC123\n"))
+
+	var rollup bytes.Buffer
+	tw := tar.NewWriter(&rollup)
+	entry := gz("rolled up ABC123\x1b[2J\n")
+	tw.WriteHeader(&tar.Header{Name: "error-20250101.log.gz", Mode: 0600, Size: int64(len(entry))})
+	tw.Write(entry)
+	tw.Close()
+	newStandardLog(t, base, "error-2025-01"+rollupExt, rollup.Bytes())
+
+	mailDir := filepath.Join(base, "postfix")
+	os.MkdirAll(mailDir, 0700)
+	os.WriteFile(filepath.Join(mailDir, "mail.log.1"), []byte("postfix ABC123"), 0600)
+	os.WriteFile(filepath.Join(base, historyFile), []byte("ABC123\n"), 0600)
+
+	found := make(map[string]string)
+	err := scanLogTree(context.Background(), base, func(source, line string) error {
+		if strings.Contains(line, "ABC123") {
+			found[source] = line
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"core/error-20250301.log":                                   "plain ABC123",
+		"core/error-20250201.log.gz":                                "archived ABC123",
+		"core/error-2025-01" + rollupExt + "/error-20250101.log.gz": "rolled up ABC123",
+		"postfix/mail.log.1":                                        "postfix ABC123",
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("scanned %v, want %v", found, want)
+	}
+}
@@ -0,0 +1,111 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Checksum manifests of the stored archives: "<archive>.sha256" next to each archive,
+// in the sha256sum format, holding the hash of the stored (compressed) bytes.
+// They are written whenever an archive is stored and checked by VerifyArchives. The
+// manifests of the standard logs also hold their metadata, see LogArchiveMetadata.
+
+const manifestExt = ".sha256"
+
+func manifestName(name string) string {
+	return name + manifestExt
+}
+
+// writeManifest stores the manifest of an archive, sum is the hex sha256 of the stored
+// bytes, meta the metadata of the archived log when not nil. signed is true when the
+// archive is signed next, the manifest then records that it expects a signature
+func (m *maintenanceRun) writeManifest(ctx context.Context, name, sum string, meta *LogArchiveMetadata, signed bool) error {
+	line := fmt.Sprintf("%s  %s\n", sum, path.Base(name))
+	if meta != nil {
+		line += metadataLine(*meta)
+	}
+	if signed {
+		marker, err := m.signedMarker(ctx)
+		if err != nil {
+			return err
+		}
+		line += marker
+	}
+	return m.cfg.Sink.Put(ctx, manifestName(name), strings.NewReader(line))
+}
+
+// readManifestData returns the content of the manifest of an archive, ok is false when
+// it has none
+func (m *maintenanceRun) readManifestData(ctx context.Context, name string) (data []byte, ok bool, err error) {
+	exists, err := m.cfg.Sink.Exists(ctx, manifestName(name))
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	rc, err := m.cfg.Sink.Open(ctx, manifestName(name))
+	if err != nil {
+		return nil, false, err
+	}
+	defer rc.Close()
+
+	data, err = io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// readManifest returns the hash recorded for an archive, ok is false when it has no manifest
+func (m *maintenanceRun) readManifest(ctx context.Context, name string) (sum string, ok bool, err error) {
+	data, ok, err := m.readManifestData(ctx, name)
+	if err != nil || !ok {
+		return "", false, err
+	}
+
+	fields := bytes.Fields(data)
+	if len(fields) == 0 {
+		return "", false, &corruptionError{reason: "empty manifest " + manifestName(name)}
+	}
+
+	sum = strings.ToLower(string(fields[0]))
+	if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != 32 {
+		return "", false, &corruptionError{reason: "malformed manifest " + manifestName(name)}
+	}
+
+	return sum, true, nil
+}
+
+// copyManifest gives dst the manifest of src with its metadata, when src has one
+func (m *maintenanceRun) copyManifest(ctx context.Context, src, dst string) error {
+	sum, ok, err := m.readManifest(ctx, src)
+	if err != nil || !ok {
+		return err
+	}
+
+	var meta *LogArchiveMetadata
+	if md, ok, err := m.readMetadata(ctx, src); err == nil && ok {
+		meta = &md
+	}
+	if err = m.writeManifest(ctx, dst, sum, meta, true); err != nil {
+		return err
+	}
+	// The signature names the archive, dst is signed anew
+	return m.signArchive(ctx, dst, sum)
+}
+
+// deleteArchive removes an archive, its manifest and its signature
+func (m *maintenanceRun) deleteArchive(ctx context.Context, name string) error {
+	if err := m.cfg.Sink.Delete(ctx, name); err != nil {
+		return err
+	}
+	decompressedLogs.invalidate(logSource{sink: m.cfg.Sink, name: name}.location())
+	if err := m.cfg.Sink.Delete(ctx, manifestName(name)); err != nil {
+		return err
+	}
+	return m.cfg.Sink.Delete(ctx, signatureName(name))
+}
@@ -0,0 +1,283 @@
+package log_maintenance
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Merge groups: the rotated access logs of several services combined into one log
+// per day, ordered by the timestamps of the lines and tagged with their service.
+// The merged logs are then rotated and archived like the core access logs, the
+// merged sources are removed.
+
+// MergeGroup access logs of several services merged into Target
+type MergeGroup struct {
+	Name    string            // group name, used in the logs
+	Sources map[string]string // service name -> directory of its access-YYYYMMDD.log files
+	Target  string            // directory of the merged access-YYYYMMDD.log files
+}
+
+// mergeDirs target directories of the merge groups, absolute
+func (m *maintenanceRun) mergeDirs() []string {
+	dirs := make([]string, 0, len(m.cfg.MergeGroups))
+	for _, group := range m.cfg.MergeGroups {
+		dirs = append(dirs, m.absLogPath(group.Target))
+	}
+	return dirs
+}
+
+// absLogPath resolves a directory relative to BasePath
+func (m *maintenanceRun) absLogPath(dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(m.cfg.BasePath, dir)
+}
+
+// mergeSource one rotated access log of a service
+type mergeSource struct {
+	service string
+	path    string
+}
+
+// mergeAccessLogs merges the rotated access logs of the group older than today
+func (m *maintenanceRun) mergeAccessLogs(ctx context.Context, group MergeGroup, today time.Time) {
+	byDay := make(map[string][]mergeSource)
+
+	for service, dir := range group.Sources {
+		files, err := filepath.Glob(filepath.Join(m.absLogPath(dir), "access-*.log"))
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			date, ok := dateFromName(filepath.Base(file), m.location())
+			if !ok || !date.Before(today) {
+				continue
+			}
+			// The merge would delete it
+			if m.pins[filepath.Clean(file)] || m.excludedLog(file) {
+				continue
+			}
+			day := date.Format("20060102")
+			byDay[day] = append(byDay[day], mergeSource{service: service, path: file})
+		}
+	}
+
+	days := make([]string, 0, len(byDay))
+	for day := range byDay {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	target := m.absLogPath(group.Target)
+
+	for _, day := range days {
+		if m.outOfTime() || ctx.Err() != nil {
+			return
+		}
+
+		sources := byDay[day]
+		sort.Slice(sources, func(i, j int) bool {
+			return sources[i].service < sources[j].service
+		})
+
+		merged := filepath.Join(target, "access-"+day+".log")
+
+		size, err := m.mergeFiles(ctx, sources, merged)
+		if err != nil {
+			g.Log().Errorf(ctx, "Merging the access logs of %s into %s failed: %v", group.Name, merged, err)
+			m.fail(ErrCompress, merged, err)
+			continue
+		}
+
+		// Dated like its content, so retention treats it as a log of that day
+		if date, ok := dateFromName(filepath.Base(merged), m.location()); ok {
+			end := date.AddDate(0, 0, 1).Add(-time.Second)
+			_ = os.Chtimes(merged, end, end)
+		}
+
+		for _, src := range sources {
+			if err := os.Remove(src.path); err != nil {
+				g.Log().Warningf(ctx, "Failed to delete the merged log %s: %v", src.path, err)
+				m.fail(ErrDelete, src.path, err)
+			}
+		}
+
+		m.fileDone(merged, size, 0)
+		g.Log().Infof(ctx, "Merged %d access logs of %s into %s", len(sources), group.Name, merged)
+	}
+}
+
+// mergeFiles writes the lines of the sources ordered by timestamp into target, along with
+// the lines of a merged log of the same day left by an earlier run. It returns the size of the sources
+func (m *maintenanceRun) mergeFiles(ctx context.Context, sources []mergeSource, target string) (int64, error) {
+	if err := mkdirAll(filepath.Dir(target), m.cfg.DirPerm); err != nil {
+		return 0, err
+	}
+
+	readers := make(mergeHeap, 0, len(sources)+1)
+	var size int64
+
+	// The earlier merged log is already tagged
+	if _, err := os.Stat(target); err == nil {
+		sources = append([]mergeSource{{path: target}}, sources...)
+	}
+
+	defer func() {
+		for _, r := range readers {
+			r.file.Close()
+		}
+	}()
+
+	for i, src := range sources {
+		f, err := os.Open(src.path)
+		if err != nil {
+			return 0, err
+		}
+
+		if src.path != target {
+			if info, err := f.Stat(); err == nil {
+				size += info.Size()
+			}
+		}
+
+		r := &mergeReader{service: src.service, order: i, file: f, reader: bufio.NewReader(f), loc: m.location()}
+		if err = r.next(); err != nil && err != io.EOF {
+			f.Close()
+			return 0, err
+		}
+
+		if r.line == nil {
+			f.Close()
+			continue
+		}
+		readers = append(readers, r)
+	}
+
+	tmp := target + ".partial"
+	out, err := createFile(tmp, m.cfg.FilePerm)
+	if err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(out)
+	heap.Init(&readers)
+
+	for readers.Len() > 0 {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		r := readers[0]
+		if r.service != "" {
+			_, err = fmt.Fprintf(w, "[%s] ", r.service)
+		}
+		if err == nil {
+			_, err = w.Write(r.line)
+		}
+		if err != nil {
+			break
+		}
+		if !strings.HasSuffix(string(r.line), "\n") {
+			if err = w.WriteByte('\n'); err != nil {
+				break
+			}
+		}
+
+		if err = r.next(); err == io.EOF {
+			err = nil
+			r.file.Close()
+			heap.Pop(&readers)
+			continue
+		}
+		if err != nil {
+			break
+		}
+		heap.Fix(&readers, 0)
+	}
+
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if err == nil && m.cfg.Fsync {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return size, os.Rename(tmp, target)
+}
+
+// mergeReader current line of one source
+type mergeReader struct {
+	service string
+	order   int
+	file    *os.File
+	reader  *bufio.Reader
+	line    []byte
+	ts      time.Time // timestamp of the line, lines without one keep the previous one
+	loc     *time.Location
+}
+
+func (r *mergeReader) next() error {
+	line, err := r.reader.ReadBytes('\n')
+	if len(line) == 0 {
+		r.line = nil
+		if err == nil {
+			err = io.EOF
+		}
+		return err
+	}
+
+	r.line = line
+	if match := logContentDatePattern.Find(line); match != nil {
+		if t, ok := parseLogTimestamp(string(match), r.loc); ok {
+			r.ts = t
+		}
+	}
+
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// mergeHeap sources ordered by the timestamp of their current line, then by service
+type mergeHeap []*mergeReader
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if !h[i].ts.Equal(h[j].ts) {
+		return h[i].ts.Before(h[j].ts)
+	}
+	return h[i].order < h[j].order
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(*mergeReader)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
@@ -0,0 +1,115 @@
+package log_maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Archives left behind by a failed archiving of a directory. A sink may keep what it
+// received before the failure, e.g. a destination written in place or an object store
+// completing the upload of a truncated stream, and that partial archive would pass for
+// the archive of the directory in the next runs and block them. On failure it is deleted
+// right away, the directory is kept and the failure is recorded as a
+// *PartialArchiveError. When the deletion fails too, the archive is remembered in the
+// logs tree and the next run replaces it, whatever ExistingArchives says.
+
+const partialArchivesFile = ".maintenance_partial_archives.json"
+
+// PartialArchiveError the archiving of a directory failed, Removed reports whether what
+// the sink kept of Archive was deleted
+type PartialArchiveError struct {
+	Archive string
+	Removed bool
+	Err     error
+}
+
+func (e *PartialArchiveError) Error() string {
+	if e.Removed {
+		return fmt.Sprintf("archive %s failed, partial archive removed: %v", e.Archive, e.Err)
+	}
+	return fmt.Sprintf("archive %s failed, partial archive left to the next run: %v", e.Archive, e.Err)
+}
+
+func (e *PartialArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// partialArchive a partial archive the run could not delete
+type partialArchive struct {
+	Source   string    `json:"source"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+func loadPartialArchives(basePath string) map[string]partialArchive {
+	archives := make(map[string]partialArchive)
+	if data, err := os.ReadFile(filepath.Join(basePath, partialArchivesFile)); err == nil {
+		_ = json.Unmarshal(data, &archives)
+	}
+	return archives
+}
+
+// discardPartialArchive deletes what the sink kept of the failed archive name of source,
+// it returns the failure to record
+func (m *maintenanceRun) discardPartialArchive(ctx context.Context, source, name string, err error) error {
+	perr := &PartialArchiveError{Archive: name, Err: err}
+
+	// A cancelled or timed out run still cleans up after itself
+	if delErr := m.deleteArchive(context.WithoutCancel(ctx), name); delErr != nil {
+		g.Log().Warningf(ctx, "Failed to delete the partial archive %s, the next run replaces it: %v", name, delErr)
+		m.notePartialArchive(ctx, name, &partialArchive{Source: source, Error: err.Error(), FailedAt: timeNow()})
+	} else {
+		perr.Removed = true
+		m.notePartialArchive(ctx, name, nil)
+	}
+
+	return perr
+}
+
+// leftPartialArchive reports whether name is a partial archive left by an earlier run
+func (m *maintenanceRun) leftPartialArchive(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loadPartialArchives()
+	_, ok := m.partialArchives[name]
+	return ok
+}
+
+// notePartialArchive remembers the partial archive name, or forgets it when a is nil,
+// and saves the list when it changed
+func (m *maintenanceRun) notePartialArchive(ctx context.Context, name string, a *partialArchive) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loadPartialArchives()
+	if _, ok := m.partialArchives[name]; !ok && a == nil {
+		return
+	}
+	if a == nil {
+		delete(m.partialArchives, name)
+	} else {
+		m.partialArchives[name] = *a
+	}
+
+	data, err := json.Marshal(m.partialArchives)
+	if err == nil {
+		err = writeFile(filepath.Join(m.cfg.BasePath, partialArchivesFile), data, permOrDefault(m.cfg.FilePerm, DefaultFilePerm))
+	}
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to save the partial archives: %v", err)
+	}
+}
+
+// loadPartialArchives loads the list once per run, the caller holds m.mu
+func (m *maintenanceRun) loadPartialArchives() {
+	if m.partialArchives == nil {
+		m.partialArchives = loadPartialArchives(m.cfg.BasePath)
+	}
+}
@@ -0,0 +1,98 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inPlaceSink writes the archives straight to their name. With failAfter set it fails
+// every archive once that many bytes were written, leaving them partial, with
+// failDelete it cannot delete them either
+type inPlaceSink struct {
+	*LocalSink
+	failAfter  int64
+	failDelete bool
+}
+
+func (s *inPlaceSink) Put(ctx context.Context, name string, r io.Reader) error {
+	p, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if s.failAfter > 0 && !strings.HasSuffix(name, manifestExt) {
+		io.CopyN(f, r, s.failAfter)
+		return errors.New("connection reset by peer")
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (s *inPlaceSink) Delete(ctx context.Context, name string) error {
+	if s.failDelete {
+		return errors.New("permission denied")
+	}
+	return s.LocalSink.Delete(ctx, name)
+}
+
+func TestOperationLogPartialArchiveRetried(t *testing.T) {
+	base, source := newOperationLogTree(t)
+	sink := &inPlaceSink{LocalSink: NewLocalSink(base), failAfter: 100}
+	cfg := MaintenanceConfig{BasePath: base, Sink: sink, UploadRetries: -1}
+
+	// The partial archive is removed, the directory kept
+	r := RunMaintenance(context.Background(), cfg)
+	var perr *PartialArchiveError
+	if r.Errors != 1 || !errors.As(r.Err(), &perr) || !perr.Removed || perr.Archive != "core/operation_log/2000-01-01.tar.gz" {
+		t.Fatalf("failures %v", r.Err())
+	}
+	if !errors.Is(r.Err(), ErrCompress) {
+		t.Errorf("failure kind %v", r.Err())
+	}
+	if _, err := os.Stat(source + ".tar.gz"); !os.IsNotExist(err) {
+		t.Errorf("partial archive left, stat err: %v", err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Fatalf("source not kept: %v", err)
+	}
+
+	// A partial archive that cannot be deleted is replaced by the next run, even when
+	// the existing archives are skipped
+	sink.failDelete = true
+	r = RunMaintenance(context.Background(), cfg)
+	if !errors.As(r.Err(), &perr) || perr.Removed {
+		t.Fatalf("failures %v", r.Err())
+	}
+	if _, err := os.Stat(source + ".tar.gz"); err != nil {
+		t.Fatalf("partial archive should be left: %v", err)
+	}
+
+	sink.failAfter, sink.failDelete = 0, false
+	cfg.ExistingArchives = ExistingArchivesSkip
+	r = RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("source not removed once archived, stat err: %v", err)
+	}
+	if err := newMaintenanceRun(context.Background(), cfg).verifyArchive(context.Background(), "core/operation_log/2000-01-01.tar.gz", newRateLimiter(0)); err != nil {
+		t.Errorf("archive of the retry: %v", err)
+	}
+	if len(loadPartialArchives(base)) != 0 {
+		t.Errorf("partial archives %v, want none", loadPartialArchives(base))
+	}
+}
@@ -0,0 +1,64 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path"
+	"regexp"
+)
+
+// Date partitioning of the standard log archives. With PartitionArchives the archive of
+// a log is stored below YYYY/MM/ of the name the Namer gave it, by the effective date of
+// the log, e.g. "core/2025/01/access-20250101.log.gz", while the active logs stay flat.
+// Archives keep their unpartitioned name for OpenLog and the rollups, so enabling the
+// option leaves the archives stored before it readable where they are.
+
+// partitionDirPattern matches the YYYY/MM directories ending an archive's directory
+var partitionDirPattern = regexp.MustCompile(`(^|/)\d{4}/\d{2}$`)
+
+// standardArchiveName the sink name of the archive of a standard log, partitioned by
+// its effective date when enabled
+func (m *maintenanceRun) standardArchiveName(logPath string, info os.FileInfo) (string, error) {
+	name, err := m.archiveName(logPath, ArchiveExt(m.archiveCodec(), false))
+	if err != nil || !m.cfg.PartitionArchives {
+		return name, err
+	}
+
+	date := m.effectiveDate(logPath, info).In(m.location())
+	return path.Join(path.Dir(name), date.Format("2006/01"), path.Base(name)), nil
+}
+
+// unpartitioned the name of an archive without its YYYY/MM directories
+func unpartitioned(name string) string {
+	dir := path.Dir(name)
+	if !partitionDirPattern.MatchString(dir) {
+		return name
+	}
+	return path.Join(path.Dir(path.Dir(dir)), path.Base(name))
+}
+
+// storedArchive resolves an unpartitioned archive name to the name it is stored under,
+// in the flat layout or else in any partition of its directory
+func (m *maintenanceRun) storedArchive(ctx context.Context, name string) (string, bool, error) {
+	if exists, err := m.cfg.Sink.Exists(ctx, name); err != nil || exists {
+		return name, exists, err
+	}
+
+	lister, ok := m.cfg.Sink.(ArchiveLister)
+	if !ok {
+		return "", false, nil
+	}
+
+	names, err := lister.List(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, stored := range names {
+		if stored != name && unpartitioned(stored) == name {
+			return stored, true, nil
+		}
+	}
+
+	return "", false, nil
+}
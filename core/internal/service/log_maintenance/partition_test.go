@@ -0,0 +1,45 @@
+package log_maintenance
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartitionedArchives(t *testing.T) {
+	base := t.TempDir()
+	newStandardLog(t, base, "access-20250115.log", []byte("partitioned\n"))
+
+	cfg := MaintenanceConfig{BasePath: base, DateSource: LogDateFromName, PartitionArchives: true}
+	if r := RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	if _, err := os.Stat(filepath.Join(base, "core", "2025", "01", "access-20250115.log.gz")); err != nil {
+		t.Fatalf("archive not stored in its date partition: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "core", "access-20250115.log.gz")); !os.IsNotExist(err) {
+		t.Errorf("archive also stored flat: %v", err)
+	}
+
+	// The archive is still opened by its unpartitioned name
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base)})
+	rc, err := m.openLog(context.Background(), "core/access-20250115.log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	if data, err := io.ReadAll(rc); err != nil || string(data) != "partitioned\n" {
+		t.Errorf("read %q, %v", data, err)
+	}
+
+	if got := unpartitioned("core/2025/01/access-20250115.log.gz"); got != "core/access-20250115.log.gz" {
+		t.Errorf("unpartitioned = %s", got)
+	}
+	if got := unpartitioned("core/operation_log/2025-01-15.tar.gz"); got != "core/operation_log/2025-01-15.tar.gz" {
+		t.Errorf("unpartitioned = %s", got)
+	}
+}
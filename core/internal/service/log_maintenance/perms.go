@@ -0,0 +1,81 @@
+package log_maintenance
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Permissions of the files and directories created by log maintenance. Logs may
+// hold addresses and message metadata, so nothing is readable beyond the owner by
+// default. Modes are applied with an explicit chmod so the process umask cannot
+// loosen or tighten them.
+
+const (
+	DefaultFilePerm os.FileMode = 0600
+	DefaultDirPerm  os.FileMode = 0700
+)
+
+// permOrDefault returns perm, def when unset
+func permOrDefault(perm, def os.FileMode) os.FileMode {
+	if perm == 0 {
+		return def
+	}
+	return perm
+}
+
+// createFile creates or truncates the file at path with exactly the given mode
+func createFile(path string, perm os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// writeFile writes data to the file at path with exactly the given mode
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	f, err := createFile(path, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// mkdirAll creates dir and its missing parents, the created directories get exactly the given mode
+func mkdirAll(dir string, perm os.FileMode) error {
+	missing := make([]string, 0)
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+
+	for _, d := range missing {
+		if err := os.Chmod(d, perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
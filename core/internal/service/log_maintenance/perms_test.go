@@ -0,0 +1,55 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchivePermissions(t *testing.T) {
+	cases := []struct {
+		cfgFile, cfgDir   os.FileMode
+		wantFile, wantDir os.FileMode
+	}{
+		{0, 0, DefaultFilePerm, DefaultDirPerm},
+		// Group write would be cleared by the usual 022 umask without the explicit chmod
+		{0660, 0770, 0660, 0770},
+	}
+
+	for _, c := range cases {
+		base, source := newOperationLogTree(t)
+
+		// Archives land in a directory the run has to create
+		sink := &LocalSink{Root: filepath.Join(base, "archives"), FilePerm: c.cfgFile, DirPerm: c.cfgDir}
+		RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Sink: sink, FilePerm: c.cfgFile, DirPerm: c.cfgDir})
+
+		archive := filepath.Join(base, "archives", "core", "operation_log", filepath.Base(source)+".tar.gz")
+
+		info, err := os.Stat(archive)
+		if err != nil {
+			t.Fatalf("archive not created: %v", err)
+		}
+		if got := info.Mode().Perm(); got != c.wantFile {
+			t.Errorf("archive mode %o, want %o", got, c.wantFile)
+		}
+
+		for _, dir := range []string{"archives", "archives/core", "archives/core/operation_log"} {
+			info, err := os.Stat(filepath.Join(base, dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); got != c.wantDir {
+				t.Errorf("%s mode %o, want %o", dir, got, c.wantDir)
+			}
+		}
+
+		info, err = os.Stat(filepath.Join(base, historyFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != c.wantFile {
+			t.Errorf("history mode %o, want %o", got, c.wantFile)
+		}
+	}
+}
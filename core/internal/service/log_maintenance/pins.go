@@ -0,0 +1,232 @@
+package log_maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Pinned logs, kept as they are until the operator unpins them, e.g. the evidence of an
+// incident until it was pulled. A pinned log or operation log day is neither compressed
+// nor deleted by the runs, whatever the retention, and a pinned archive is not deleted
+// by the emergency cleanup nor the adaptive retention. The pins are kept in the logs
+// tree next to the history, so they survive restarts, and a pin may expire after a TTL.
+// The files held for an incident are pinned as well, see incidents.go.
+
+const pinsFile = ".maintenance_pins.json"
+
+var pinsMutex sync.Mutex
+
+// LogPin a pinned log, Path is relative to the logs tree, e.g. "core/error-20250102.log"
+type LogPin struct {
+	Path      string    `json:"path"`
+	Reason    string    `json:"reason,omitempty"`
+	PinnedAt  time.Time `json:"pinned_at"`
+	ExpiresAt time.Time `json:"expires_at"` // zero until unpinned
+}
+
+// Expired reports whether the pin no longer applies at now
+func (p LogPin) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// PinLog pins a log, an operation log day or an archive of the logs tree of the default
+// configuration, for ttl or until unpinned when ttl is 0. Pinning again replaces the pin
+func PinLog(ctx context.Context, path string, ttl time.Duration, reason string) (LogPin, error) {
+	return pinLog(ctx, DefaultService().Config().BasePath, path, ttl, reason)
+}
+
+// UnpinLog removes the pin of a log, the next run handles it again
+func UnpinLog(ctx context.Context, path string) error {
+	return unpinLog(ctx, DefaultService().Config().BasePath, path)
+}
+
+// PinnedLogs returns the pins in effect, the files held for the incidents included, sorted
+// by path
+func PinnedLogs(ctx context.Context) []LogPin {
+	return activePins(DefaultService().Config().BasePath)
+}
+
+func pinLog(ctx context.Context, basePath, path string, ttl time.Duration, reason string) (LogPin, error) {
+	if ttl < 0 {
+		return LogPin{}, fmt.Errorf("negative pin TTL")
+	}
+
+	rel, err := pinPath(basePath, path)
+	if err != nil {
+		return LogPin{}, err
+	}
+	if _, err := os.Stat(filepath.Join(basePath, filepath.FromSlash(rel))); err != nil {
+		return LogPin{}, err
+	}
+
+	pin := LogPin{Path: rel, Reason: strings.TrimSpace(reason), PinnedAt: timeNow()}
+	if ttl > 0 {
+		pin.ExpiresAt = pin.PinnedAt.Add(ttl)
+	}
+
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+
+	pins := loadPins(basePath)
+	pins[rel] = pin
+	if err := savePins(basePath, pins); err != nil {
+		return LogPin{}, err
+	}
+
+	g.Log().Infof(ctx, "Log %s pinned until %s: %s", rel, pinExpiry(pin), pin.Reason)
+	return pin, nil
+}
+
+func unpinLog(ctx context.Context, basePath, path string) error {
+	rel, err := pinPath(basePath, path)
+	if err != nil {
+		return err
+	}
+
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+
+	pins := loadPins(basePath)
+	if _, ok := pins[rel]; !ok {
+		if incident := incidentHolding(basePath, rel); incident != "" {
+			return fmt.Errorf("log %s is held for incident %s, close the incident to release it", rel, incident)
+		}
+		return fmt.Errorf("log %s is not pinned", rel)
+	}
+	delete(pins, rel)
+	if err := savePins(basePath, pins); err != nil {
+		return err
+	}
+
+	g.Log().Infof(ctx, "Log %s unpinned", rel)
+	return nil
+}
+
+// activePins the pins not expired and the files held for the incidents, once each,
+// sorted by path
+func activePins(basePath string) []LogPin {
+	pinsMutex.Lock()
+	defer pinsMutex.Unlock()
+
+	now := timeNow()
+	list := make([]LogPin, 0)
+	listed := make(map[string]bool)
+	for _, pin := range loadPins(basePath) {
+		if !pin.Expired(now) {
+			list = append(list, pin)
+			listed[pin.Path] = true
+		}
+	}
+	for _, pin := range incidentPins(basePath) {
+		if !listed[pin.Path] {
+			list = append(list, pin)
+			listed[pin.Path] = true
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+
+	return list
+}
+
+// loadPins reads the pins by path, the caller holds pinsMutex
+func loadPins(basePath string) map[string]LogPin {
+	var list []LogPin
+	if data, err := os.ReadFile(filepath.Join(basePath, pinsFile)); err == nil {
+		_ = json.Unmarshal(data, &list)
+	}
+
+	pins := make(map[string]LogPin, len(list))
+	for _, pin := range list {
+		pins[pin.Path] = pin
+	}
+	return pins
+}
+
+// savePins writes the pins, dropping the expired ones, the caller holds pinsMutex
+func savePins(basePath string, pins map[string]LogPin) error {
+	now := timeNow()
+	list := make([]LogPin, 0, len(pins))
+	for _, pin := range pins {
+		if !pin.Expired(now) {
+			list = append(list, pin)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(basePath, pinsFile), data, DefaultFilePerm)
+}
+
+// pinPath the slash path of a log relative to the logs tree, path is relative to it or
+// absolute within it
+func pinPath(basePath, path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", fmt.Errorf("empty log path")
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(basePath, filepath.FromSlash(path))
+	}
+
+	rel, err := filepath.Rel(basePath, filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("log %q is not in the logs tree", path)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func pinExpiry(pin LogPin) string {
+	if pin.ExpiresAt.IsZero() {
+		return "unpinned"
+	}
+	return pin.ExpiresAt.Format(time.RFC3339)
+}
+
+// loadRunPins sets the pins in effect for the run, after holding the logs written into
+// the windows of the incidents
+func (m *maintenanceRun) loadRunPins(ctx context.Context) {
+	m.matchIncidents(ctx)
+	for _, pin := range activePins(m.cfg.BasePath) {
+		if m.pins == nil {
+			m.pins = make(map[string]bool)
+		}
+		m.pins[filepath.Join(m.cfg.BasePath, filepath.FromSlash(pin.Path))] = true
+	}
+}
+
+// keepPinned reports whether path is pinned, a pinned file is accounted as done
+func (m *maintenanceRun) keepPinned(ctx context.Context, path string) bool {
+	if !m.pins[filepath.Clean(path)] {
+		return false
+	}
+
+	g.Log().Infof(ctx, "Log %s is pinned, left as is", path)
+	m.mu.Lock()
+	m.pinned = append(m.pinned, path)
+	m.mu.Unlock()
+	m.fileDone(path, 0, 0)
+	return true
+}
+
+// archivePinned reports whether an archive is pinned, only the local archives can be
+func (m *maintenanceRun) archivePinned(name string) bool {
+	sink, ok := m.cfg.Sink.(*LocalSink)
+	if !ok || len(m.pins) == 0 {
+		return false
+	}
+	path, err := sink.Path(name)
+	return err == nil && m.pins[path]
+}
@@ -0,0 +1,70 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPinnedLogsKept(t *testing.T) {
+	base, source := newOperationLogTree(t)
+	dir := filepath.Join(base, "core")
+	var logs []string
+	for i := 0; i < 4; i++ {
+		logs = append(logs, newStandardLog(t, base, "error-"+time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n")))
+	}
+
+	if _, err := pinLog(context.Background(), base, "../outside.log", 0, ""); err == nil {
+		t.Error("log outside the tree pinned")
+	}
+	if _, err := pinLog(context.Background(), base, "core/error-20250301.log", 0, "incident"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pinLog(context.Background(), base, source, 0, "incident"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pinLog(context.Background(), base, logs[1], time.Hour, "expires"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retention would delete the oldest log, the pin keeps it as is
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Retention: RetentionPolicy{FilesToKeep: 1}})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if len(r.Pinned) != 3 {
+		t.Errorf("pinned %v, want 3", r.Pinned)
+	}
+	for _, path := range []string{logs[0], logs[1], source} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("pinned %s not kept: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(logs[2]); !os.IsNotExist(err) {
+		t.Errorf("unpinned %s not deleted", logs[2])
+	}
+
+	// The pins survive, the expired ones no longer apply
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	timeNow = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	pins := activePins(base)
+	if len(pins) != 2 || pins[0].Path != "core/error-20250301.log" || pins[0].Reason != "incident" {
+		t.Fatalf("pins %+v", pins)
+	}
+
+	if err := unpinLog(context.Background(), base, "core/error-20250301.log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unpinLog(context.Background(), base, "core/error-20250301.log"); err == nil {
+		t.Error("unpinned twice")
+	}
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Retention: RetentionPolicy{FilesToKeep: 1}})
+	if left, _ := filepath.Glob(filepath.Join(dir, "error-*.log")); len(left) != 0 {
+		t.Errorf("logs left after unpinning: %v", left)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("pinned operation log day not kept: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Protected minimum: whatever the retention settings, the newest log of a group and
+// every log modified within the protected window are never deleted, and a log still
+// within the window is not compressed either, as its writer may still append to it.
+// A misconfigured retention or a clock jump then cannot wipe the logs of today.
+
+// DefaultProtectedWindow logs modified within it are never deleted
+const DefaultProtectedWindow = time.Hour
+
+// protectedWindow the configured window, a floor that cannot be turned off
+func (m *maintenanceRun) protectedWindow() time.Duration {
+	if m.cfg.ProtectedWindow <= 0 {
+		return DefaultProtectedWindow
+	}
+	return m.cfg.ProtectedWindow
+}
+
+// recentlyModified reports whether the file was modified within the protected window,
+// a modification time in the future counts as recent
+func (m *maintenanceRun) recentlyModified(info os.FileInfo) bool {
+	return timeNow().Sub(info.ModTime()) < m.protectedWindow()
+}
+
+// keepProtected reports whether the floor keeps a log the retention policy would delete,
+// newest is set for the newest log of its group. A kept log is accounted as done
+func (m *maintenanceRun) keepProtected(ctx context.Context, path string, info os.FileInfo, newest bool) bool {
+	reason := ""
+	switch {
+	case newest:
+		reason = "it is the newest log of its group"
+	case m.recentlyModified(info):
+		reason = "it was modified " + timeNow().Sub(info.ModTime()).Round(time.Second).String() + " ago, within the protected window of " + m.protectedWindow().String()
+	default:
+		return false
+	}
+
+	g.Log().Errorf(ctx, "PROTECTED LOG %s kept: the retention policy would have deleted it, but %s. Check the retention settings and the system clock", path, reason)
+
+	m.protected = append(m.protected, path)
+	m.fileDone(path, 0, 0)
+	return true
+}
@@ -0,0 +1,48 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProtectedMinimumKeepsRecentLogs(t *testing.T) {
+	base := t.TempDir()
+
+	var paths []string
+	for day := 1; day <= 32; day++ {
+		name := fmt.Sprintf("error-202001%02d.log", day)
+		paths = append(paths, newStandardLog(t, base, name, []byte(name+" line\n")))
+	}
+
+	// Dated by its name as one of the oldest, but still written to
+	if err := os.Chtimes(paths[0], time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, DateSource: LogDateFromName})
+
+	if len(r.Protected) != 1 || r.Protected[0] != paths[0] {
+		t.Fatalf("protected %v, want %s", r.Protected, paths[0])
+	}
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Fatalf("recently modified log should be kept: %v", err)
+	}
+	if _, err := os.Stat(paths[1]); !os.IsNotExist(err) {
+		t.Fatalf("old log beyond the retention should be deleted, stat err: %v", err)
+	}
+	if _, err := os.Stat(paths[31] + ".gz"); err != nil {
+		t.Fatalf("newest log should be archived: %v", err)
+	}
+
+	// Outside a shorter window the log is handled again
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, DateSource: LogDateFromName, ProtectedWindow: time.Nanosecond})
+	if len(r.Protected) != 0 {
+		t.Fatalf("protected %v outside the window", r.Protected)
+	}
+	if _, err := os.Stat(paths[0] + ".gz"); err != nil {
+		t.Fatalf("log outside the window should be archived: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Read-only log volume. After a disk error the volume is often remounted read-only and
+// every create or remove fails. A single write probe at the start of a run detects it: the
+// run then skips everything that writes or deletes and reports one ErrReadOnlyFilesystem
+// instead of a failure per file. Reads, such as the scans, searches and restores, still work.
+
+// readOnlyNotifyInterval minimum time between two notifications for the same path
+const readOnlyNotifyInterval = 24 * time.Hour
+
+// probeWritable creates and removes a file in dir, replaced by the tests
+var probeWritable = func(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-probe-*")
+	if err != nil {
+		return err
+	}
+
+	name := f.Name()
+	f.Close()
+
+	return os.Remove(name)
+}
+
+var (
+	readOnlyMu       sync.Mutex
+	readOnlyNotifier func(ctx context.Context, path string, err error)
+	readOnlyNotified = make(map[string]time.Time)
+)
+
+// OnReadOnlyFilesystem registers fn to be told when a run finds its log volume read-only.
+// It is called at most once a day per path
+func OnReadOnlyFilesystem(fn func(ctx context.Context, path string, err error)) {
+	readOnlyMu.Lock()
+	readOnlyNotifier = fn
+	readOnlyMu.Unlock()
+}
+
+// checkWritable probes BasePath, and when it is read-only marks the run, records the
+// failure and notifies. Other probe errors (e.g. a missing directory) are left to the
+// operations that follow
+func (m *maintenanceRun) checkWritable(ctx context.Context) bool {
+	err := probeWritable(m.cfg.BasePath)
+	if err == nil || !errors.Is(err, syscall.EROFS) {
+		return true
+	}
+
+	m.readOnly = true
+	m.fail(ErrReadOnlyFilesystem, m.cfg.BasePath, err)
+	g.Log().Errorf(ctx, "Log volume %s is read-only, deletion and compression skipped: %v", m.cfg.BasePath, err)
+
+	notifyReadOnly(ctx, m.cfg.BasePath, err)
+
+	return false
+}
+
+func notifyReadOnly(ctx context.Context, path string, err error) {
+	readOnlyMu.Lock()
+	fn := readOnlyNotifier
+	last, seen := readOnlyNotified[path]
+	now := timeNow()
+	if fn == nil || (seen && now.Sub(last) < readOnlyNotifyInterval) {
+		readOnlyMu.Unlock()
+		return
+	}
+	readOnlyNotified[path] = now
+	readOnlyMu.Unlock()
+
+	fn(ctx, path, err)
+}
@@ -0,0 +1,43 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestReadOnlyVolumeSkipsDestructiveSteps(t *testing.T) {
+	base, source := newOperationLogTree(t)
+
+	probe := probeWritable
+	probeWritable = func(string) error {
+		return &os.PathError{Op: "open", Path: base, Err: syscall.EROFS}
+	}
+	defer func() { probeWritable = probe }()
+
+	var notified []string
+	OnReadOnlyFilesystem(func(_ context.Context, path string, _ error) {
+		notified = append(notified, path)
+	})
+	defer OnReadOnlyFilesystem(nil)
+
+	result := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+
+	if !result.ReadOnly || result.Errors != 1 || !errors.Is(result.Err(), ErrReadOnlyFilesystem) || !errors.Is(result.Err(), syscall.EROFS) {
+		t.Fatalf("expected a single read-only failure, got %+v: %v", result, result.Err())
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source should be kept on a read-only volume: %v", err)
+	}
+	if _, err := os.Stat(source + ".tar.gz"); !os.IsNotExist(err) {
+		t.Errorf("no archive should be written on a read-only volume, stat err: %v", err)
+	}
+
+	// A second run within a day is not notified again
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+	if len(notified) != 1 || notified[0] != base {
+		t.Errorf("expected one notification for %s, got %v", base, notified)
+	}
+}
@@ -0,0 +1,222 @@
+package log_maintenance
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/os/glog"
+)
+
+// In-memory tail of the most recent log lines per component, so the admin UI
+// can show recent logs without opening files. Consecutive identical lines are
+// collapsed into a single entry with a repeat counter. The buffers share a
+// budget of content bytes, over it the oldest lines of the largest buffer are
+// evicted first.
+
+const (
+	recentLogsPerComponent = 1000
+	recentLogsMaxComponent = 64
+	recentLogsMaxLineBytes = 4096
+	recentLogsMaxBytes     = 16 << 20 // content of all the buffers
+	recentLogsDefaultName  = "core"
+)
+
+// RecentLogLine a buffered log line
+type RecentLogLine struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Content string    `json:"content"`
+	Repeat  int       `json:"repeat"` // how many times the line occurred in a row
+
+	Suspicious bool `json:"suspicious,omitempty"` // looks injected, see SanitizeLogLine
+}
+
+// recentLogRing fixed size ring buffer of log lines
+type recentLogRing struct {
+	lines []RecentLogLine
+	start int // index of the oldest line
+	size  int
+	bytes int // content of the buffered lines
+}
+
+var (
+	recentLogsMutex sync.Mutex
+	recentLogs      = make(map[string]*recentLogRing)
+	recentLogsBytes int // content of all the buffers
+	installOnce     sync.Once
+)
+
+func (r *recentLogRing) last() *RecentLogLine {
+	if r.size == 0 {
+		return nil
+	}
+	return &r.lines[(r.start+r.size-1)%len(r.lines)]
+}
+
+func (r *recentLogRing) push(line RecentLogLine) {
+	if prev := r.last(); prev != nil && prev.Level == line.Level && prev.Content == line.Content {
+		prev.Repeat++
+		prev.Time = line.Time
+		return
+	}
+
+	if r.size == len(r.lines) {
+		r.dropOldest()
+	}
+
+	r.lines[(r.start+r.size)%len(r.lines)] = line
+	r.size++
+	r.bytes += len(line.Content)
+}
+
+// dropOldest removes the oldest line
+func (r *recentLogRing) dropOldest() {
+	if r.size == 0 {
+		return
+	}
+
+	r.bytes -= len(r.lines[r.start].Content)
+	r.lines[r.start] = RecentLogLine{}
+	r.start = (r.start + 1) % len(r.lines)
+	r.size--
+}
+
+// tail returns up to n lines, oldest first
+func (r *recentLogRing) tail(n int) []RecentLogLine {
+	if n <= 0 || n > r.size {
+		n = r.size
+	}
+
+	out := make([]RecentLogLine, 0, n)
+	for i := r.size - n; i < r.size; i++ {
+		out = append(out, r.lines[(r.start+i)%len(r.lines)])
+	}
+	return out
+}
+
+// evictRecentLogs drops the oldest lines of the largest buffers until all the
+// buffers fit in recentLogsMaxBytes, the caller holds recentLogsMutex
+func evictRecentLogs() {
+	for recentLogsBytes > recentLogsMaxBytes {
+		var largest *recentLogRing
+		for _, ring := range recentLogs {
+			if largest == nil || ring.bytes > largest.bytes {
+				largest = ring
+			}
+		}
+
+		if largest == nil || largest.size == 0 {
+			return
+		}
+
+		before := largest.bytes
+		largest.dropOldest()
+		recentLogsBytes -= before - largest.bytes
+	}
+}
+
+// RecordRecentLog appends a line to the in-memory buffer of a component
+func RecordRecentLog(component, level, content string, t time.Time) {
+	if component == "" {
+		component = recentLogsDefaultName
+	}
+
+	content = strings.TrimRight(content, "\r\n")
+	if len(content) > recentLogsMaxLineBytes {
+		// Cut on a rune boundary, a split rune is not valid UTF-8
+		cut := recentLogsMaxLineBytes
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		content = content[:cut]
+	}
+
+	recentLogsMutex.Lock()
+	defer recentLogsMutex.Unlock()
+
+	ring, ok := recentLogs[component]
+	if !ok {
+		// Keep the number of buffers bounded, unknown components go to the default one
+		if len(recentLogs) >= recentLogsMaxComponent {
+			if ring, ok = recentLogs[recentLogsDefaultName]; !ok {
+				return
+			}
+		} else {
+			ring = &recentLogRing{lines: make([]RecentLogLine, recentLogsPerComponent)}
+			recentLogs[component] = ring
+		}
+	}
+
+	before := ring.bytes
+	ring.push(RecentLogLine{Time: t, Level: level, Content: content, Repeat: 1})
+	recentLogsBytes += ring.bytes - before
+
+	evictRecentLogs()
+}
+
+// RecentLogs returns the last n buffered lines of a component, oldest first, sanitized
+// and redacted with DisplayRedactor
+func RecentLogs(component string, n int) []RecentLogLine {
+	if component == "" {
+		component = recentLogsDefaultName
+	}
+
+	recentLogsMutex.Lock()
+	defer recentLogsMutex.Unlock()
+
+	ring, ok := recentLogs[component]
+	if !ok {
+		return []RecentLogLine{}
+	}
+
+	lines := ring.tail(n)
+	redactor := DisplayRedactor()
+	for i := range lines {
+		content, suspicious := SanitizeLogLine(lines[i].Content)
+		lines[i].Content = redactor.Redact(content)
+		lines[i].Suspicious = suspicious
+	}
+	return lines
+}
+
+// RecentLogComponents returns the names of the components that have buffered lines
+func RecentLogComponents() []string {
+	recentLogsMutex.Lock()
+	defer recentLogsMutex.Unlock()
+
+	names := make([]string, 0, len(recentLogs))
+	for name := range recentLogs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// InstallRecentLogsHandler hooks the default glog handler so every log line
+// is also kept in memory. The logger prefix is used as component name.
+func InstallRecentLogsHandler() {
+	installOnce.Do(func() {
+		previous := glog.GetDefaultHandler()
+
+		glog.SetDefaultHandler(func(ctx context.Context, in *glog.HandlerInput) {
+			content := in.Content
+			if len(in.Values) > 0 {
+				if content != "" {
+					content += " "
+				}
+				content += in.ValuesContent()
+			}
+
+			RecordRecentLog(strings.TrimSpace(in.Prefix), in.LevelFormat, content, in.Time)
+
+			if previous != nil {
+				previous(ctx, in)
+				return
+			}
+
+			in.Next(ctx)
+		})
+	})
+}
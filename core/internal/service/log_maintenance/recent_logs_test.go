@@ -0,0 +1,107 @@
+package log_maintenance
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRecentLogLineTruncatedOnRune(t *testing.T) {
+	component := "truncation-test"
+	// the 4096th byte is in the middle of a 3 byte rune
+	RecordRecentLog(component, "INFO", strings.Repeat("a", recentLogsMaxLineBytes-1)+strings.Repeat("€", 4), time.Now())
+
+	recent := RecentLogs(component, 0)
+	if len(recent) != 1 {
+		t.Fatalf("recent lines %d, want 1", len(recent))
+	}
+	if content := recent[0].Content; !utf8.ValidString(content) || len(content) != recentLogsMaxLineBytes-1 {
+		t.Errorf("truncated line of %d bytes, valid UTF-8 %t", len(content), utf8.ValidString(content))
+	}
+}
+
+// resetRecentLogs empties the buffers for the test, restored on cleanup
+func resetRecentLogs(t *testing.T) {
+	t.Helper()
+
+	recentLogsMutex.Lock()
+	origLogs, origBytes := recentLogs, recentLogsBytes
+	recentLogs, recentLogsBytes = make(map[string]*recentLogRing), 0
+	recentLogsMutex.Unlock()
+
+	t.Cleanup(func() {
+		recentLogsMutex.Lock()
+		recentLogs, recentLogsBytes = origLogs, origBytes
+		recentLogsMutex.Unlock()
+	})
+}
+
+func TestRecentLogsRing(t *testing.T) {
+	resetRecentLogs(t)
+	now := time.Now()
+
+	RecordRecentLog("ring", "INFO", "repeated", now)
+	RecordRecentLog("ring", "INFO", "repeated\n", now)
+	for i := 0; i < recentLogsPerComponent+5; i++ {
+		RecordRecentLog("ring", "INFO", fmt.Sprintf("line %d", i), now)
+	}
+
+	recent := RecentLogs("ring", 0)
+	if len(recent) != recentLogsPerComponent {
+		t.Fatalf("recent lines %d, want %d", len(recent), recentLogsPerComponent)
+	}
+	if recent[0].Content != "line 5" || recent[len(recent)-1].Content != fmt.Sprintf("line %d", recentLogsPerComponent+4) {
+		t.Errorf("oldest %q, newest %q", recent[0].Content, recent[len(recent)-1].Content)
+	}
+	if tail := RecentLogs("ring", 2); len(tail) != 2 || tail[1].Content != recent[len(recent)-1].Content {
+		t.Errorf("tail %+v", tail)
+	}
+
+	resetRecentLogs(t)
+	RecordRecentLog("ring", "INFO", "repeated", now)
+	RecordRecentLog("ring", "INFO", "repeated\n", now)
+	if recent := RecentLogs("ring", 0); len(recent) != 1 || recent[0].Repeat != 2 {
+		t.Errorf("repeated lines %+v", recent)
+	}
+}
+
+func TestRecentLogsByteBudget(t *testing.T) {
+	resetRecentLogs(t)
+	now := time.Now()
+
+	// Every component full of the longest lines is over the budget
+	line := strings.Repeat("x", recentLogsMaxLineBytes-8)
+	components := recentLogsMaxBytes/(recentLogsPerComponent*recentLogsMaxLineBytes) + 2
+	for c := 0; c < components; c++ {
+		for i := 0; i < recentLogsPerComponent; i++ {
+			RecordRecentLog(fmt.Sprintf("c%d", c), "INFO", fmt.Sprintf("%08d", i)+line, now)
+		}
+	}
+	// A small component is not evicted for the large ones
+	RecordRecentLog("small", "INFO", "kept", now)
+
+	recentLogsMutex.Lock()
+	total, sum := recentLogsBytes, 0
+	for _, ring := range recentLogs {
+		sum += ring.bytes
+	}
+	recentLogsMutex.Unlock()
+
+	if total > recentLogsMaxBytes || total != sum {
+		t.Fatalf("buffered %d bytes, sum of the buffers %d, budget %d", total, sum, recentLogsMaxBytes)
+	}
+	if recent := RecentLogs("small", 0); len(recent) != 1 {
+		t.Errorf("small component evicted: %+v", recent)
+	}
+
+	// The oldest lines were dropped, the newest are kept
+	recent := RecentLogs("c0", 0)
+	if len(recent) == 0 || len(recent) == recentLogsPerComponent {
+		t.Fatalf("c0 kept %d lines", len(recent))
+	}
+	if newest := recent[len(recent)-1].Content[:8]; newest != fmt.Sprintf("%08d", recentLogsPerComponent-1) {
+		t.Errorf("newest line of c0 %s", newest)
+	}
+}
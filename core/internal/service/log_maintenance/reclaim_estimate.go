@@ -0,0 +1,323 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+)
+
+// Estimate of the space a run would free, computed with the retention settings of the
+// configuration and without touching the logs tree. The standard logs the retention
+// deletes count in full, the logs and the operation log days due for compression count
+// by the size they would save. The saving is estimated per group from a sampled
+// compression: the first reclaimSampleBytes of a few of its due logs are compressed with
+// the codec of the archives and discarded. Pinned, excluded, active and protected logs
+// are left out like in a run. The compression slices, the backlog and the adaptive or
+// emergency cleanups are not simulated: the estimate is what the runs free once caught up.
+
+const (
+	reclaimSamples     = 3       // logs sampled per group
+	reclaimSampleBytes = 1 << 20 // bytes compressed per sampled log
+)
+
+// ReclaimUsage space a run would free in a set of logs
+type ReclaimUsage struct {
+	DeletedFiles    int   `json:"deleted_files"`
+	DeletedBytes    int64 `json:"deleted_bytes"`
+	CompressedFiles int   `json:"compressed_files"` // logs and operation log days
+	CompressedBytes int64 `json:"compressed_bytes"` // their size before the compression
+	SavedBytes      int64 `json:"saved_bytes"`      // estimated saving of their compression
+}
+
+// Reclaimable the bytes deleted and saved by the compression
+func (u ReclaimUsage) Reclaimable() int64 {
+	return u.DeletedBytes + u.SavedBytes
+}
+
+// ReclaimEstimate space a run would free in the logs tree
+type ReclaimEstimate struct {
+	BasePath    string                   `json:"base_path"`
+	Total       ReclaimUsage             `json:"total"`
+	Directories map[string]*ReclaimUsage `json:"directories"` // relative to BasePath
+	Groups      map[string]*ReclaimUsage `json:"groups"`      // the operation log days under operation_log
+
+	// Ratios sampled size of the archives over the size of the logs, by group, 1 when
+	// nothing could be sampled
+	Ratios map[string]float64 `json:"ratios"`
+}
+
+// reclaimAction a log a run would delete or compress
+type reclaimAction struct {
+	path   string
+	group  string
+	size   int64
+	delete bool
+}
+
+// EstimateReclaimable returns the space the scheduled maintenance would free
+func EstimateReclaimable(ctx context.Context) (ReclaimEstimate, error) {
+	return estimateReclaimable(ctx, DefaultService().Config())
+}
+
+// estimateReclaimable estimates the space a run with cfg would free
+func estimateReclaimable(ctx context.Context, cfg MaintenanceConfig) (ReclaimEstimate, error) {
+	estimate := ReclaimEstimate{
+		BasePath:    cfg.BasePath,
+		Directories: make(map[string]*ReclaimUsage),
+		Groups:      make(map[string]*ReclaimUsage),
+		Ratios:      make(map[string]float64),
+	}
+
+	if _, err := os.Stat(cfg.BasePath); err != nil {
+		return estimate, err
+	}
+
+	m := newMaintenanceRun(ctx, cfg)
+	m.loadRunPins(ctx)
+
+	var actions []reclaimAction
+	for _, dir := range m.standardLogDirs() {
+		if !gfile.Exists(dir) {
+			continue
+		}
+
+		planned, err := m.plannedStandardLogs(ctx, dir)
+		if err != nil {
+			return estimate, fmt.Errorf("failed to scan log directory %s: %w", dir, err)
+		}
+		actions = append(actions, planned...)
+	}
+	actions = append(actions, m.plannedOperationLogs(filepath.Join(cfg.BasePath, "core", "operation_log"))...)
+
+	// Samples of the logs due for compression, by group
+	samples := make(map[string][]string)
+	for _, a := range actions {
+		if !a.delete && a.size > 0 {
+			samples[a.group] = append(samples[a.group], a.path)
+		}
+	}
+	for group, paths := range samples {
+		estimate.Ratios[group] = m.sampleRatio(ctx, paths)
+	}
+
+	for _, a := range actions {
+		rel, err := filepath.Rel(cfg.BasePath, filepath.Dir(a.path))
+		if err != nil {
+			continue
+		}
+
+		saved := int64(0)
+		if !a.delete {
+			saved = int64(float64(a.size) * (1 - estimate.Ratios[a.group]))
+			saved = max(saved, 0)
+		}
+
+		for _, u := range []*ReclaimUsage{&estimate.Total, reclaimUsage(estimate.Directories, filepath.ToSlash(rel)), reclaimUsage(estimate.Groups, a.group)} {
+			if a.delete {
+				u.DeletedFiles++
+				u.DeletedBytes += a.size
+			} else {
+				u.CompressedFiles++
+				u.CompressedBytes += a.size
+				u.SavedBytes += saved
+			}
+		}
+	}
+
+	return estimate, nil
+}
+
+// reclaimUsage the usage of key, created on first use
+func reclaimUsage(usages map[string]*ReclaimUsage, key string) *ReclaimUsage {
+	u, ok := usages[key]
+	if !ok {
+		u = &ReclaimUsage{}
+		usages[key] = u
+	}
+	return u
+}
+
+// plannedStandardLogs the standard logs of dir a run would delete or compress, with the
+// decisions of processStandardLogs
+func (m *maintenanceRun) plannedStandardLogs(ctx context.Context, dir string) ([]reclaimAction, error) {
+	files, err := m.scanLogDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	active := m.activeLogsOf(ctx, dir, files)
+
+	groups := make(map[string][]string)
+	for _, file := range files {
+		if info, err := os.Lstat(file); err != nil || specialFile(info) || m.isActiveLink(file) {
+			continue
+		}
+		if group := m.groupOfLog(file); group != "" {
+			groups[group] = append(groups[group], file)
+		}
+	}
+
+	now := timeNow().In(m.location())
+	oneDayAgo := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, m.location())
+
+	var actions []reclaimAction
+	for group, files := range groups {
+		infos := make(map[string]os.FileInfo, len(files))
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				infos[file] = info
+			}
+		}
+
+		sort.Slice(files, func(i, j int) bool {
+			infoI, infoJ := infos[files[i]], infos[files[j]]
+			if infoI == nil || infoJ == nil {
+				return false
+			}
+			return m.effectiveDate(files[i], infoI).Before(m.effectiveDate(files[j], infoJ))
+		})
+
+		policy := m.retentionOf(group)
+		keepFrom := m.keepFrom(group, files, policy.FilesToKeep)
+		for i, path := range files {
+			info := infos[path]
+			if info == nil {
+				continue
+			}
+
+			file := logCandidate{
+				path:      path,
+				info:      info,
+				newest:    i == len(files)-1,
+				overCount: !m.adaptive() && i < keepFrom,
+				active:    active[filepath.Clean(path)],
+				cutoff:    oneDayAgo,
+			}
+			switch m.decideAction(file, policy) {
+			case ActionDelete:
+				actions = append(actions, reclaimAction{path: path, group: group, size: info.Size(), delete: true})
+			case ActionCompress:
+				if info.Size() > 0 {
+					actions = append(actions, reclaimAction{path: path, group: group, size: info.Size()})
+				}
+			}
+		}
+	}
+
+	return actions, nil
+}
+
+// plannedOperationLogs the operation log days of dir a run would compress
+func (m *maintenanceRun) plannedOperationLogs(dir string) []reclaimAction {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	oneMonthAgo := operationLogCutoff(timeNow().In(m.operationLogLocation()))
+	guarded := m.guardedMonths()
+
+	var actions []reclaimAction
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		date, err := time.ParseInLocation("2006-01-02", entry.Name(), m.operationLogLocation())
+		if err != nil || !date.Before(oneMonthAgo) || guarded[entry.Name()[:len("2006-01")]] {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if m.pins[path] {
+			continue
+		}
+		actions = append(actions, reclaimAction{path: path, group: operationLogGroup, size: dirSize(path)})
+	}
+
+	return actions
+}
+
+// sampleRatio the compression ratio of the first reclaimSamples logs of paths, files or
+// operation log days, 1 when none could be read
+func (m *maintenanceRun) sampleRatio(ctx context.Context, paths []string) float64 {
+	var files []string
+	for _, path := range paths {
+		if len(files) >= reclaimSamples {
+			break
+		}
+		_ = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil || len(files) >= reclaimSamples {
+				return fs.SkipAll
+			}
+			if d.Type().IsRegular() {
+				files = append(files, file)
+			}
+			return nil
+		})
+	}
+
+	var read, written int64
+	for _, file := range files {
+		r, w, err := m.sampleCompression(file)
+		if err != nil {
+			g.Log().Debugf(ctx, "Failed to sample the compression of %s: %v", file, err)
+			continue
+		}
+		read += r
+		written += w
+	}
+
+	if read == 0 {
+		return 1
+	}
+	return min(float64(written)/float64(read), 1)
+}
+
+// sampleCompression compresses the first reclaimSampleBytes of file and returns the bytes
+// read and the size of their compression
+func (m *maintenanceRun) sampleCompression(file string) (int64, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	counter := &countingWriter{}
+	chain, err := m.filters()
+	if err != nil {
+		return 0, 0, err
+	}
+	cw, err := chain.newCodecWriter(counter)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	read, err := io.Copy(cw, io.LimitReader(f, reclaimSampleBytes))
+	if err != nil {
+		cw.Close()
+		return 0, 0, err
+	}
+	if err = cw.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	return read, counter.n, nil
+}
+
+// countingWriter counts the bytes written to it and discards them
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
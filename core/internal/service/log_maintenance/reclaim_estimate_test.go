@@ -0,0 +1,60 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEstimateReclaimable(t *testing.T) {
+	base, day := newOperationLogTree(t)
+
+	content := []byte(strings.Repeat("2025-01-01 10:00:00 INFO delivered to example.com\n", 2000))
+	var logs []string
+	for _, name := range []string{"access-20200101.log", "access-20200102.log", "access-20200103.log", "access-20200104.log"} {
+		logs = append(logs, newStandardLog(t, base, name, content))
+	}
+
+	cfg := MaintenanceConfig{
+		BasePath:   base,
+		DateSource: LogDateFromName,
+		Retention:  RetentionPolicy{FilesToKeep: 2},
+	}
+
+	estimate, err := estimateReclaimable(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is touched
+	for _, path := range append(logs, day) {
+		if _, err = os.Stat(path); err != nil {
+			t.Fatalf("%s changed by the estimate: %v", path, err)
+		}
+	}
+
+	size := int64(len(content))
+	access := estimate.Groups["access"]
+	if access == nil || access.DeletedFiles != 2 || access.DeletedBytes != 2*size || access.CompressedFiles != 2 || access.CompressedBytes != 2*size {
+		t.Fatalf("unexpected access estimate %+v", access)
+	}
+	if ratio := estimate.Ratios["access"]; ratio <= 0 || ratio > 0.1 {
+		t.Errorf("sampled ratio of repeated lines = %f", ratio)
+	}
+	if ops := estimate.Groups[operationLogGroup]; ops == nil || ops.CompressedFiles != 1 || ops.CompressedBytes != 3*64*1024 {
+		t.Errorf("unexpected operation log estimate %+v", ops)
+	}
+	if core := estimate.Directories["core"]; core == nil || core.DeletedFiles != 2 {
+		t.Errorf("unexpected core estimate %+v", core)
+	}
+
+	// The run frees about the estimate
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if diff := r.BytesReclaimed - estimate.Total.Reclaimable(); diff < -size/10 || diff > size/10 {
+		t.Errorf("reclaimed %d bytes, estimated %d", r.BytesReclaimed, estimate.Total.Reclaimable())
+	}
+}
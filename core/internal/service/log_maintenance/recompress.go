@@ -0,0 +1,199 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Recompression of existing archives into another codec, e.g. gzip to zstd.
+// Each archive is decompressed, recompressed, verified against the original
+// content and only then replaces the original.
+
+// ArchiveLister optional sink capability, lists the stored archive names
+type ArchiveLister interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// ArchiveTimestamper optional sink capability, reads and preserves archive timestamps
+type ArchiveTimestamper interface {
+	ModTime(ctx context.Context, name string) (time.Time, error)
+	SetModTime(ctx context.Context, name string, t time.Time) error
+}
+
+// RecompressResult summary of a recompression
+type RecompressResult struct {
+	Converted   int   `json:"converted"`
+	Skipped     int   `json:"skipped"` // target already exists
+	Failed      int   `json:"failed"`
+	BytesBefore int64 `json:"bytes_before"`
+	BytesAfter  int64 `json:"bytes_after"`
+}
+
+// Recompress converts the archives of the default configuration from one codec to another
+func Recompress(ctx context.Context, from, to CompressionCodec) (RecompressResult, error) {
+	cfg := DefaultService().Config()
+	m := newMaintenanceRun(ctx, cfg)
+	m.index = loadArchiveIndex(cfg.BasePath, cfg.FilePerm)
+	defer m.index.save(ctx)
+
+	return m.recompress(ctx, from, to)
+}
+
+func (m *maintenanceRun) recompress(ctx context.Context, from, to CompressionCodec) (RecompressResult, error) {
+	var result RecompressResult
+
+	if from.Name() == to.Name() {
+		return result, nil
+	}
+
+	lister, ok := m.cfg.Sink.(ArchiveLister)
+	if !ok {
+		return result, fmt.Errorf("archive sink does not support listing, cannot recompress")
+	}
+
+	names, err := lister.List(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	for _, name := range names {
+		if codec, ok := CodecOf(name); !ok || codec != from || inQuarantine(name) {
+			continue
+		}
+
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+
+		if m.outOfTime() {
+			break
+		}
+
+		target := strings.TrimSuffix(name, from.Ext()) + to.Ext()
+
+		if exists, err := m.cfg.Sink.Exists(ctx, target); err != nil || exists {
+			result.Skipped++
+			continue
+		}
+
+		before, after, err := m.recompressArchive(ctx, name, target, from, to)
+		if err != nil {
+			g.Log().Errorf(ctx, "Recompression of %s to %s failed: %v", name, to.Name(), err)
+			m.fail(ErrCompress, name, err)
+			result.Failed++
+			continue
+		}
+
+		result.Converted++
+		result.BytesBefore += before
+		result.BytesAfter += after
+	}
+
+	g.Log().Infof(ctx, "Recompressed %d archives from %s to %s, %d skipped, %d failed, %d -> %d bytes",
+		result.Converted, from.Name(), to.Name(), result.Skipped, result.Failed, result.BytesBefore, result.BytesAfter)
+
+	return result, nil
+}
+
+// recompressArchive converts one archive, it returns the sizes before and after
+func (m *maintenanceRun) recompressArchive(ctx context.Context, name, target string, from, to CompressionCodec) (int64, int64, error) {
+	src, err := m.cfg.Sink.Open(ctx, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	srcCounter := &countingReader{r: src}
+
+	// The content may already be in another codec than its name tells
+	chain, err := m.filters()
+	if err != nil {
+		return 0, 0, err
+	}
+	reader, _, err := chain.NewReader(name, srcCounter)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer reader.Close()
+
+	// Hash the logical content while converting, to verify the new archive
+	hash := sha256.New()
+
+	written, err := m.putArchive(ctx, target, func(w io.Writer) error {
+		// The content is already filtered, only the codec changes
+		writer, err := chain.withCodec(to).NewWriter(w, false, nil)
+		if err != nil {
+			return err
+		}
+
+		if _, err = io.Copy(writer, io.TeeReader(reader, hash)); err != nil {
+			writer.Close()
+			return err
+		}
+
+		return writer.Close()
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err = m.verifyRecompressed(ctx, target, to, hash.Sum(nil)); err != nil {
+		if delErr := m.deleteArchive(ctx, target); delErr != nil {
+			g.Log().Warningf(ctx, "Failed to delete the unverified archive %s: %v", target, delErr)
+		}
+		return 0, 0, err
+	}
+
+	if ts, ok := m.cfg.Sink.(ArchiveTimestamper); ok {
+		if t, err := ts.ModTime(ctx, name); err == nil {
+			_ = ts.SetModTime(ctx, target, t)
+		}
+	}
+
+	if err = m.deleteArchive(ctx, name); err != nil {
+		return 0, 0, fmt.Errorf("converted but failed to delete the original: %w", err)
+	}
+
+	// The dedup index links new logs to the original name, which is gone now
+	m.index.forget(hex.EncodeToString(hash.Sum(nil)))
+
+	return srcCounter.n, written, nil
+}
+
+// verifyRecompressed checks the new archive decompresses to the expected content
+func (m *maintenanceRun) verifyRecompressed(ctx context.Context, name string, codec CompressionCodec, expected []byte) error {
+	rc, err := m.cfg.Sink.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	chain, err := m.filters()
+	if err != nil {
+		return err
+	}
+	reader, _, err := chain.withCodec(codec).NewReader(name, rc)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, reader); err != nil {
+		return fmt.Errorf("verify %s: %w", name, err)
+	}
+
+	if !bytes.Equal(hash.Sum(nil), expected) {
+		return fmt.Errorf("verify %s: content mismatch after recompression", name)
+	}
+
+	return nil
+}
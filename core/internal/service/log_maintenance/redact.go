@@ -0,0 +1,207 @@
+package log_maintenance
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redaction of the sensitive data in the log lines, shared by every path a log line can
+// leave the server through: the archives (MaintenanceConfig.Redactor), the search of the
+// output logs (SearchLogs) and the recent lines shown in the admin UI (RecentLogs).
+// A rule tagged RedactAlways applies whatever the tags enabled, the other rules only
+// when their tag is, so the credentials can never be shown while masking the addresses
+// remains a choice.
+
+// Tags of the default rules
+const (
+	RedactAlways   = "always" // credentials, never disabled
+	RedactTagEmail = "email"
+	RedactTagIP    = "ip"
+)
+
+// SASLResponsePrefix marks a client line answering an SMTP AUTH challenge, its content
+// is a credential the "smtp-auth" rule masks
+const SASLResponsePrefix = "[sasl] "
+
+// RedactRule replaces the matches of Pattern with Replacement, which may refer to the
+// submatches as in regexp.ReplaceAllString
+type RedactRule struct {
+	Name        string
+	Tag         string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// DefaultRedactRules masks the credentials, the email addresses and the IP addresses
+func DefaultRedactRules() []RedactRule {
+	return []RedactRule{
+		{
+			Name:        "password",
+			Tag:         RedactAlways,
+			Pattern:     regexp.MustCompile(`(?i)\b(pass(?:word|wd)?|pwd|secret|token|api[_-]?key)(["']?\s*[:=]\s*["']?)[^\s"'&,;]+`),
+			Replacement: "${1}${2}******",
+		},
+		{
+			Name:        "authorization",
+			Tag:         RedactAlways,
+			Pattern:     regexp.MustCompile(`(?i)\b(authorization["']?\s*[:=]\s*["']?(?:basic|bearer)\s+)[A-Za-z0-9._~+/=-]+`),
+			Replacement: "${1}******",
+		},
+		{
+			Name:        "smtp-auth",
+			Tag:         RedactAlways,
+			Pattern:     regexp.MustCompile(`(?i)(\bAUTH\s+[A-Z0-9_-]+\s+|\[sasl\]\s+)[^\s=]\S*`),
+			Replacement: "${1}******",
+		},
+		{
+			Name:        "email",
+			Tag:         RedactTagEmail,
+			Pattern:     regexp.MustCompile(`\b([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})\b`),
+			Replacement: "${1}***@${2}",
+		},
+		{
+			Name:        "ipv4",
+			Tag:         RedactTagIP,
+			Pattern:     regexp.MustCompile(`\b(\d{1,3}\.\d{1,3})\.\d{1,3}\.\d{1,3}\b`),
+			Replacement: "${1}.x.x",
+		},
+	}
+}
+
+// Redactor applies the rules whose tag is enabled, a nil Redactor leaves the lines as is
+type Redactor struct {
+	rules []RedactRule
+}
+
+// NewRedactor a redactor of the RedactAlways rules and of those tagged with one of tags
+func NewRedactor(rules []RedactRule, tags ...string) *Redactor {
+	enabled := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		enabled[tag] = true
+	}
+
+	r := &Redactor{}
+	for _, rule := range rules {
+		if rule.Pattern != nil && (rule.Tag == RedactAlways || enabled[rule.Tag]) {
+			r.rules = append(r.rules, rule)
+		}
+	}
+	return r
+}
+
+// Redact returns the line with the sensitive data masked
+func (r *Redactor) Redact(line string) string {
+	if r == nil {
+		return line
+	}
+	for _, rule := range r.rules {
+		line = rule.Pattern.ReplaceAllString(line, rule.Replacement)
+	}
+	return line
+}
+
+// Copy copies src to dst line by line with the lines redacted, the line endings are kept
+func (r *Redactor) Copy(dst io.Writer, src io.Reader) (int64, error) {
+	if r == nil {
+		return io.Copy(dst, src)
+	}
+
+	var written int64
+	reader := bufio.NewReaderSize(src, 64*1024)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			n, werr := io.WriteString(dst, r.Redact(line))
+			written += int64(n)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+var (
+	displayRedactorMutex sync.RWMutex
+	displayRedactor      = NewRedactor(DefaultRedactRules(), RedactTagEmail, RedactTagIP)
+)
+
+// DisplayRedactor the redactor of the log lines shown or searched through the API,
+// every default rule by default
+func DisplayRedactor() *Redactor {
+	displayRedactorMutex.RLock()
+	defer displayRedactorMutex.RUnlock()
+	return displayRedactor
+}
+
+// SetDisplayRedactor replaces the redactor of the log lines shown or searched, nil
+// restores the default one
+func SetDisplayRedactor(r *Redactor) {
+	if r == nil {
+		r = NewRedactor(DefaultRedactRules(), RedactTagEmail, RedactTagIP)
+	}
+
+	displayRedactorMutex.Lock()
+	displayRedactor = r
+	displayRedactorMutex.Unlock()
+}
+
+// SearchLogs returns the lines of a log file containing keyword, all of them when it is
+// empty, sanitized and redacted with r. The keyword is matched against the redacted lines,
+// so a search cannot tell whether a masked value is present. The suspicious lines are
+// prefixed with SuspiciousLogMarker. A compressed log is searched in its decompressed
+// content, served from the cache of the decompressed logs when searched recently
+func SearchLogs(path, keyword string, r *Redactor) ([]string, error) {
+	file, err := openSearchedLog(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, suspicious := SanitizeLogLine(scanner.Text())
+		line = r.Redact(line)
+		if suspicious {
+			line = SuspiciousLogMarker + line
+		}
+		if keyword == "" || strings.Contains(line, keyword) {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// openSearchedLog opens the content of a plain or compressed log file
+func openSearchedLog(path string) (io.ReadCloser, error) {
+	if _, ok := CodecOf(path); !ok {
+		return os.Open(path)
+	}
+
+	source := logSource{name: path}
+	return decompressedLogs.openCached(context.Background(), source.location(), func() (io.ReadCloser, logSource, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, source, err
+		}
+
+		reader, _, err := unwrapLocalArchive(path, file)
+		if err != nil {
+			file.Close()
+			return nil, source, err
+		}
+
+		return &stackedReadCloser{Reader: reader, closers: []io.Closer{reader, file}}, source, nil
+	})
+}
@@ -0,0 +1,254 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Restore of an archived operation log day. The YYYY-MM-DD.tar.gz (as named by the Namer) written by
+// processOperationLogs (or its recompressed form, or its volumes) is extracted into a fresh directory
+// below RestoreDir for browsing. Restored directories are temporary: those older than
+// RestoreTTL are deleted by the next restore and by every maintenance run.
+
+const (
+	DefaultRestoreTTL = 24 * time.Hour
+
+	// maxRestoredBytes bounds the extracted content of one day, a guard against
+	// decompression bombs
+	maxRestoredBytes = 8 << 30
+
+	restoreDirPrefix = "oplog-"
+)
+
+// defaultRestoreDir parent of the restored days when RestoreDir is unset
+func defaultRestoreDir() string {
+	return filepath.Join(os.TempDir(), "billionmail-log-restore")
+}
+
+// RestoreOperationLogDay extracts the archived operation logs of date (YYYY-MM-DD) of the
+// default configuration and returns the directory holding them. The directory is removed
+// once it is older than RestoreTTL
+func RestoreOperationLogDay(ctx context.Context, date string) (string, error) {
+	m := newMaintenanceRun(ctx, DefaultService().Config())
+	return m.restoreOperationLogDay(ctx, date)
+}
+
+func (m *maintenanceRun) restoreDir() string {
+	if m.cfg.RestoreDir == "" {
+		return defaultRestoreDir()
+	}
+	return m.cfg.RestoreDir
+}
+
+func (m *maintenanceRun) restoreTTL() time.Duration {
+	if m.cfg.RestoreTTL <= 0 {
+		return DefaultRestoreTTL
+	}
+	return m.cfg.RestoreTTL
+}
+
+func (m *maintenanceRun) restoreOperationLogDay(ctx context.Context, date string) (string, error) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+	}
+
+	m.cleanupRestored(ctx)
+
+	name, _, err := m.findOperationLogArchive(ctx, date)
+	if err != nil {
+		return "", err
+	}
+
+	// A day restored again, e.g. by another support session, is served from the cache of
+	// the decompressed logs
+	source := logSource{sink: m.cfg.Sink, name: name}
+	reader, err := decompressedLogs.openCached(ctx, source.location(), func() (io.ReadCloser, logSource, error) {
+		rc, err := m.openArchive(ctx, name)
+		if err != nil {
+			return nil, source, err
+		}
+
+		reader, _, err := m.unwrapArchive(name, rc)
+		if err != nil {
+			rc.Close()
+			return nil, source, fmt.Errorf("open archive %s: %w", name, err)
+		}
+
+		return &stackedReadCloser{Reader: reader, closers: []io.Closer{reader, rc}}, source, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	parent := m.restoreDir()
+	if err = mkdirAll(parent, permOrDefault(m.cfg.DirPerm, DefaultDirPerm)); err != nil {
+		return "", err
+	}
+
+	target, err := os.MkdirTemp(parent, restoreDirPrefix+date+"-")
+	if err != nil {
+		return "", err
+	}
+
+	if err = m.extractTar(ctx, tar.NewReader(reader), target); err != nil {
+		os.RemoveAll(target)
+		return "", fmt.Errorf("restore archive %s: %w", name, err)
+	}
+	// The padding after the end of the tar is read too, the content is cached once read
+	// to the end
+	_, _ = io.Copy(io.Discard, reader)
+
+	g.Log().Infof(ctx, "Operation logs of %s restored from %s to %s", date, name, target)
+	return target, nil
+}
+
+// findOperationLogArchive the stored archive of the operation logs of date, in any codec
+func (m *maintenanceRun) findOperationLogArchive(ctx context.Context, date string) (string, CompressionCodec, error) {
+	source := filepath.Join(m.cfg.BasePath, "core", "operation_log", date)
+
+	for _, codec := range Codecs() {
+		name, err := m.archiveName(source, ArchiveExt(codec, true))
+		if err != nil {
+			return "", nil, err
+		}
+
+		exists, err := m.archiveStored(ctx, name)
+		if err != nil {
+			return "", nil, err
+		}
+		if exists {
+			return name, codec, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("no archived operation logs for %s", date)
+}
+
+// extractTar writes the directories and regular files of the archive below target. Entries
+// that would land outside of target, links and special files are refused
+func (m *maintenanceRun) extractTar(ctx context.Context, tr *tar.Reader, target string) error {
+	var total int64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		dest, err := restorePath(target, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = mkdirAll(dest, permOrDefault(m.cfg.DirPerm, DefaultDirPerm)); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			total += header.Size
+			if total > maxRestoredBytes {
+				return fmt.Errorf("archive content exceeds %d bytes", int64(maxRestoredBytes))
+			}
+
+			if err = mkdirAll(filepath.Dir(dest), permOrDefault(m.cfg.DirPerm, DefaultDirPerm)); err != nil {
+				return err
+			}
+			if err = extractFile(tr, dest, header, permOrDefault(m.cfg.FilePerm, DefaultFilePerm)); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("entry %q: unsupported type %q", header.Name, header.Typeflag)
+		}
+	}
+}
+
+// restorePath resolves an entry name below target, refusing absolute names and those
+// climbing out with ".."
+func restorePath(target, name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("entry %q escapes the restore directory", name)
+	}
+
+	dest := filepath.Join(target, filepath.FromSlash(clean))
+
+	rel, err := filepath.Rel(target, dest)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry %q escapes the restore directory", name)
+	}
+
+	return dest, nil
+}
+
+// extractFile writes the content of the current entry to dest, keeping its modification time
+func extractFile(r io.Reader, dest string, header *tar.Header, perm os.FileMode) error {
+	f, err := createFile(dest, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(f, r, header.Size)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if !header.ModTime.IsZero() {
+		_ = os.Chtimes(dest, header.ModTime, header.ModTime)
+	}
+
+	return nil
+}
+
+// cleanupRestored removes the restored days older than RestoreTTL
+func (m *maintenanceRun) cleanupRestored(ctx context.Context) {
+	parent := m.restoreDir()
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+
+	cutoff := timeNow().Add(-m.restoreTTL())
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), restoreDirPrefix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		dir := filepath.Join(parent, entry.Name())
+		if err = os.RemoveAll(dir); err != nil {
+			g.Log().Warningf(ctx, "Failed to delete the restored operation logs %s: %v", dir, err)
+			continue
+		}
+
+		g.Log().Debugf(ctx, "Restored operation logs %s expired, deleted", dir)
+	}
+}
@@ -0,0 +1,66 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreOperationLogDay(t *testing.T) {
+	base, source := newOperationLogTree(t)
+	original, err := os.ReadFile(filepath.Join(source, "b.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restoreDir := t.TempDir()
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, RestoreDir: restoreDir})
+
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base), RestoreDir: restoreDir})
+
+	dir, err := m.restoreOperationLogDay(context.Background(), "2000-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel, err := filepath.Rel(restoreDir, dir); err != nil || strings.HasPrefix(rel, "..") {
+		t.Fatalf("restored outside of the restore directory: %s", dir)
+	}
+
+	restored, err := os.ReadFile(filepath.Join(dir, "b.json"))
+	if err != nil || !bytes.Equal(restored, original) {
+		t.Fatalf("restored content differs: %v", err)
+	}
+
+	if _, err = m.restoreOperationLogDay(context.Background(), "2000-01-02"); err == nil {
+		t.Error("restore of a day without archive succeeded")
+	}
+	if _, err = m.restoreOperationLogDay(context.Background(), "../../etc"); err == nil {
+		t.Error("restore of an invalid date succeeded")
+	}
+
+	// Expired restores are cleaned up
+	old := time.Now().Add(-DefaultRestoreTTL - time.Hour)
+	if err = os.Chtimes(dir, old, old); err != nil {
+		t.Fatal(err)
+	}
+	m.cleanupRestored(context.Background())
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expired restore kept: %v", err)
+	}
+}
+
+func TestRestoreRefusesEscapingEntries(t *testing.T) {
+	for _, name := range []string{"../evil.json", "a/../../evil.json", "/etc/evil.json"} {
+		if _, err := restorePath(t.TempDir(), name); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	if _, err := restorePath(t.TempDir(), "a/../b.json"); err != nil {
+		t.Errorf("a/../b.json: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package log_maintenance
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention of the standard logs: each group keeps its newest FilesToKeep logs and, with
+// a MaxAge, deletes the logs older than it instead of compressing them. A group listed in
+// RetentionOverrides uses its own values, the fields left zero there fall back to the
+// global Retention, then to the defaults.
+
+// RetentionPolicy retention of the standard logs of a group
+type RetentionPolicy struct {
+	FilesToKeep int           // newest logs kept, standardLogsKept when 0
+	MaxAge      time.Duration // logs older than it are deleted, no age limit when 0
+
+	// ForceCompress compresses the logs without waiting for the day to end, all but the
+	// newest of the group. Set in Retention it applies to every group
+	ForceCompress bool
+}
+
+// retentionOf the effective retention of a log group
+func (m *maintenanceRun) retentionOf(group string) RetentionPolicy {
+	policy := RetentionPolicy{FilesToKeep: standardLogsKept}
+
+	for _, p := range []RetentionPolicy{m.cfg.Retention, m.externalRetention(group), m.cfg.RetentionOverrides[group]} {
+		if p.FilesToKeep > 0 {
+			policy.FilesToKeep = p.FilesToKeep
+		}
+		if p.MaxAge > 0 {
+			policy.MaxAge = p.MaxAge
+		}
+		if p.ForceCompress {
+			policy.ForceCompress = true
+		}
+	}
+
+	return policy
+}
+
+// validateRetention rejects negative limits and overrides of malformed group names
+func validateRetention(cfg MaintenanceConfig) error {
+	if cfg.Retention.FilesToKeep < 0 || cfg.Retention.MaxAge < 0 {
+		return fmt.Errorf("negative retention in the configuration")
+	}
+
+	for group, p := range cfg.RetentionOverrides {
+		if !logGroupNamePattern.MatchString(group) {
+			return fmt.Errorf("retention override of invalid log group %q", group)
+		}
+		if p.FilesToKeep < 0 || p.MaxAge < 0 {
+			return fmt.Errorf("negative retention of log group %s", group)
+		}
+	}
+
+	return nil
+}
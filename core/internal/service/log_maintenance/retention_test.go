@@ -0,0 +1,71 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionOverrides(t *testing.T) {
+	base := t.TempDir()
+
+	// Effective dates from the modification times, days back from now
+	log := func(name string, days int) string {
+		path := newStandardLog(t, base, name, []byte("line\n"))
+		date := time.Now().AddDate(0, 0, -days)
+		if err := os.Chtimes(path, date, date); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	accessOld := log("access-20250101.log", 10)
+	accessRecent := log("access-20250102.log", 3)
+	errorAncient := log("error-20240101.log", 100)
+	errorOld := log("error-20250101.log", 10)
+	errorRecent := log("error-20250102.log", 3)
+
+	cfg := MaintenanceConfig{
+		BasePath:           base,
+		Retention:          RetentionPolicy{MaxAge: 7 * 24 * time.Hour},
+		RetentionOverrides: map[string]RetentionPolicy{"error": {MaxAge: 90 * 24 * time.Hour}},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	for _, path := range []string{accessOld, accessOld + ".gz", errorAncient, errorAncient + ".gz"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should be deleted by the retention, stat err: %v", filepath.Base(path), err)
+		}
+	}
+	for _, path := range []string{accessRecent, errorOld, errorRecent} {
+		if _, err := os.Stat(path + ".gz"); err != nil {
+			t.Errorf("%s should be kept and archived: %v", filepath.Base(path), err)
+		}
+	}
+
+	// Unset fields fall back to the global policy, then to the defaults
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{
+		Retention:          RetentionPolicy{FilesToKeep: 10},
+		RetentionOverrides: map[string]RetentionPolicy{"error": {MaxAge: time.Hour}},
+	})
+	if p := m.retentionOf("error"); p.FilesToKeep != 10 || p.MaxAge != time.Hour {
+		t.Errorf("error retention = %+v", p)
+	}
+	if p := newMaintenanceRun(context.Background(), MaintenanceConfig{}).retentionOf("access"); p.FilesToKeep != standardLogsKept || p.MaxAge != 0 {
+		t.Errorf("default retention = %+v", p)
+	}
+
+	cfg.RetentionOverrides["bad/name"] = RetentionPolicy{}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("an override of an invalid group name should be refused")
+	}
+}
@@ -0,0 +1,100 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Node role in a high availability setup where a primary and a standby share the log
+// volume. Only the primary deletes and compresses, two nodes doing it at once would race
+// on the same files. On a standby a run does nothing, or with StandbyVerify only checks
+// the archives without writing anything. The role comes from the Role hook, e.g. the
+// leader election of the cluster. A role that cannot be determined counts as standby.
+
+// Node roles
+const (
+	RolePrimary = "primary"
+	RoleStandby = "standby"
+)
+
+// Handling of the runs on a standby
+const (
+	StandbySkip   = "skip"   // nothing is done, default
+	StandbyVerify = "verify" // the archives are verified read-only
+)
+
+// roleConfigKey configuration key of the role used by ConfiguredRole
+const roleConfigKey = "server.role"
+
+// RoleFunc returns the role of this node, RolePrimary or RoleStandby
+type RoleFunc func(ctx context.Context) (string, error)
+
+// StaticRole a RoleFunc always returning role
+func StaticRole(role string) RoleFunc {
+	return func(context.Context) (string, error) {
+		return role, nil
+	}
+}
+
+// ConfiguredRole the role set as server.role in the configuration, RolePrimary when unset
+func ConfiguredRole(ctx context.Context) (string, error) {
+	v, err := g.Cfg().Get(ctx, roleConfigKey)
+	if err != nil {
+		return "", err
+	}
+	if v == nil || v.String() == "" {
+		return RolePrimary, nil
+	}
+	return strings.ToLower(strings.TrimSpace(v.String())), nil
+}
+
+// standbyReason why this node must not delete nor compress, empty on the primary
+func standbyReason(ctx context.Context, cfg MaintenanceConfig) string {
+	if cfg.Role == nil {
+		return ""
+	}
+
+	role, err := cfg.Role(ctx)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("the role of the node is unknown: %v", err)
+	case role == RolePrimary:
+		return ""
+	case role == RoleStandby:
+		return "the node is a standby"
+	default:
+		return fmt.Sprintf("unknown node role %q", role)
+	}
+}
+
+// standbyRun the run of a standby, the archives are verified read-only with StandbyVerify
+func standbyRun(ctx context.Context, cfg MaintenanceConfig, reason string) MaintenanceResult {
+	result := MaintenanceResult{StartedAt: time.Now(), Standby: reason}
+
+	if cfg.Standby != StandbyVerify {
+		g.Log().Infof(ctx, "Log maintenance skipped, %s", reason)
+		return result
+	}
+
+	g.Log().Infof(ctx, "Log maintenance limited to a read-only verification, %s", reason)
+
+	verification := RunVerification(ctx, VerifyConfig{
+		BasePath: cfg.BasePath,
+		Sink:     cfg.Sink,
+		FilePerm: cfg.FilePerm,
+		DirPerm:  cfg.DirPerm,
+		ReadOnly: true,
+		Signing:  cfg.Signing,
+	})
+
+	result.Verification = &verification
+	result.Errors = verification.Errors
+	result.Failures = verification.Failures
+	result.Duration = time.Since(result.StartedAt)
+
+	return result
+}
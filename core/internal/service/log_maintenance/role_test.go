@@ -0,0 +1,49 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStandbySkipsMaintenance(t *testing.T) {
+	base, source := newOperationLogTree(t)
+	standard := newStandardLog(t, base, "access-20250101.log", []byte("line\n"))
+
+	for _, role := range []RoleFunc{
+		StaticRole(RoleStandby),
+		StaticRole("observer"),
+		func(context.Context) (string, error) { return "", errors.New("no leader elected") },
+	} {
+		r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Role: role})
+		if r.Standby == "" || r.Verification != nil {
+			t.Errorf("run should be skipped on a standby: %+v", r)
+		}
+	}
+
+	for _, path := range []string{source, standard} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("%s should be left on a standby: %v", path, err)
+		}
+	}
+	if entries, _ := os.ReadDir(base); len(entries) != 1 {
+		t.Errorf("nothing should be written on a standby, found %d entries in the base", len(entries))
+	}
+
+	// The archives left by the primary are verified, without recording anything
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Role: StaticRole(RolePrimary)})
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Role: StaticRole(RoleStandby), Standby: StandbyVerify})
+	if r.Verification == nil || r.Verification.Verified != 2 || r.Errors != 0 {
+		t.Fatalf("standby verification = %+v", r.Verification)
+	}
+	if _, err := os.Stat(filepath.Join(base, verifyStateFile)); !os.IsNotExist(err) {
+		t.Errorf("the verification state should not be saved on a standby, stat err: %v", err)
+	}
+
+	if err := validateConfig(MaintenanceConfig{Standby: "panic"}); err == nil {
+		t.Error("an unknown standby handling should be refused")
+	}
+}
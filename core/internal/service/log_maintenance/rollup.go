@@ -0,0 +1,437 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Compaction of the individual archives of the access/error/date groups into
+// one uncompressed tar per period, e.g. "core/access-2025-01.rollup.tar".
+// The archives are stored as-is inside the rollup, OpenLog reads them from there.
+// On an ArchiveAppender sink the new archives are appended to the rollup, see
+// rollup_append.go, elsewhere the rollup is rewritten with them.
+
+const rollupExt = ".rollup.tar"
+
+// Rollup granularities
+const (
+	RollupMonthly = "month" // default
+	RollupWeekly  = "week"
+)
+
+// rollupTarget archives of one group and period
+type rollupTarget struct {
+	name     string // rollup archive name
+	archives []string
+}
+
+// rollupPeriod returns the period key of a date for the granularity
+func rollupPeriod(t time.Time, granularity string) string {
+	if granularity == RollupWeekly {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	}
+	return t.Format("2006-01")
+}
+
+// archiveDate date of an archive, from its name or else its timestamp in the sink
+func (m *maintenanceRun) archiveDate(ctx context.Context, name string) (time.Time, bool) {
+	if t, ok := dateFromName(path.Base(name), m.location()); ok {
+		return t, true
+	}
+
+	if ts, ok := m.cfg.Sink.(ArchiveTimestamper); ok {
+		if t, err := ts.ModTime(ctx, name); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// rollupTargets groups the archives of dir and its partitions older than cutoff by
+// rollup archive, the rollups are stored in dir itself
+func (m *maintenanceRun) rollupTargets(ctx context.Context, names []string, dir string, cutoff time.Time) []*rollupTarget {
+	targets := make(map[string]*rollupTarget)
+
+	for _, name := range names {
+		if path.Dir(unpartitioned(name)) != dir || strings.HasSuffix(name, rollupExt) {
+			continue
+		}
+
+		source, _, tarred, ok := SplitArchiveName(path.Base(name))
+		if !ok || tarred {
+			continue
+		}
+
+		group := m.logGroupOf(source)
+		if group == "" {
+			continue
+		}
+
+		date, ok := m.archiveDate(ctx, name)
+		if !ok || !date.Before(cutoff) || m.archivePinned(name) {
+			continue
+		}
+
+		rollup := path.Join(dir, group+"-"+rollupPeriod(date, m.cfg.RollupGranularity)+rollupExt)
+
+		t, ok := targets[rollup]
+		if !ok {
+			t = &rollupTarget{name: rollup}
+			targets[rollup] = t
+		}
+		t.archives = append(t.archives, name)
+	}
+
+	list := make([]*rollupTarget, 0, len(targets))
+	for _, t := range targets {
+		sort.Strings(t.archives)
+		list = append(list, t)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+
+	return list
+}
+
+// compactArchives bundles the archives older than RollupAfter into rollups
+func (m *maintenanceRun) compactArchives(ctx context.Context, dirs []string) {
+	lister, ok := m.cfg.Sink.(ArchiveLister)
+	if !ok {
+		g.Log().Warning(ctx, "Archive sink does not support listing, compaction skipped")
+		return
+	}
+
+	names, err := lister.List(ctx)
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to list the archives for compaction: %v", err)
+		m.fail(ErrScan, m.cfg.BasePath, err)
+		return
+	}
+
+	cutoff := time.Now().Add(-m.cfg.RollupAfter)
+
+	for _, dir := range dirs {
+		for _, target := range m.rollupTargets(ctx, names, dir, cutoff) {
+			if m.outOfTime() || ctx.Err() != nil {
+				return
+			}
+
+			if err := m.writeRollup(ctx, target); err != nil {
+				g.Log().Errorf(ctx, "Compaction into %s failed: %v", target.name, err)
+				m.fail(ErrCompress, target.name, err)
+			}
+		}
+	}
+}
+
+// writeRollup adds the new archives to the rollup, the archives are removed once the
+// rollup is verified
+func (m *maintenanceRun) writeRollup(ctx context.Context, target *rollupTarget) error {
+	if appender, ok := m.cfg.Sink.(ArchiveAppender); ok {
+		if appended, err := m.appendRollup(ctx, appender, target); appended || err != nil {
+			return err
+		}
+	}
+
+	return m.rewriteRollup(ctx, target)
+}
+
+// rewriteRollup rewrites the rollup with its current entries plus the new archives
+func (m *maintenanceRun) rewriteRollup(ctx context.Context, target *rollupTarget) error {
+	existing := make([]string, 0)
+
+	if exists, err := m.cfg.Sink.Exists(ctx, target.name); err != nil {
+		return err
+	} else if exists {
+		if existing, err = m.rollupEntries(ctx, target.name); err != nil {
+			return err
+		}
+	}
+
+	// Entries are keyed by base name, an archive already in the rollup is replaced
+	added := make(map[string]string, len(target.archives))
+	for _, name := range target.archives {
+		added[path.Base(name)] = name
+	}
+
+	var sizeBefore int64
+	tmpName := target.name + ".new"
+
+	written, err := m.putArchive(ctx, tmpName, func(w io.Writer) error {
+		tw := tar.NewWriter(w)
+
+		if len(existing) > 0 {
+			rc, err := m.cfg.Sink.Open(ctx, target.name)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+
+			tr := tar.NewReader(rc)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+
+				if _, replaced := added[header.Name]; replaced {
+					continue
+				}
+
+				if err = tw.WriteHeader(header); err != nil {
+					return err
+				}
+				if _, err = io.Copy(tw, tr); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, name := range target.archives {
+			if err := m.copyArchiveInto(ctx, tw, name, &sizeBefore); err != nil {
+				return err
+			}
+		}
+
+		return tw.Close()
+	})
+	if err != nil {
+		_ = m.deleteArchive(ctx, tmpName)
+		return err
+	}
+
+	// Check every entry made it into the rollup before replacing anything
+	entries, err := m.rollupEntries(ctx, tmpName)
+	if err == nil {
+		present := make(map[string]bool, len(entries))
+		for _, e := range entries {
+			present[e] = true
+		}
+		for base := range added {
+			if !present[base] {
+				err = fmt.Errorf("entry %s missing from the rollup", base)
+				break
+			}
+		}
+	}
+	if err != nil {
+		_ = m.deleteArchive(ctx, tmpName)
+		return err
+	}
+
+	if err = m.replaceArchive(ctx, tmpName, target.name); err != nil {
+		return err
+	}
+
+	for _, name := range target.archives {
+		if err := m.deleteArchive(ctx, name); err != nil {
+			g.Log().Warningf(ctx, "Failed to delete %s after compaction: %v", name, err)
+			m.fail(ErrDelete, name, err)
+		}
+	}
+
+	m.fileDone(target.name, sizeBefore, 0)
+	g.Log().Infof(ctx, "Compacted %d archives into %s (%d bytes)", len(target.archives), target.name, written)
+
+	return nil
+}
+
+// copyArchiveInto writes a stored archive as one tar entry named by its base name
+func (m *maintenanceRun) copyArchiveInto(ctx context.Context, tw *tar.Writer, name string, size *int64) error {
+	rc, err := m.cfg.Sink.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+
+	modTime := time.Now()
+	if ts, ok := m.cfg.Sink.(ArchiveTimestamper); ok {
+		if t, err := ts.ModTime(ctx, name); err == nil {
+			modTime = t
+		}
+	}
+
+	header := &tar.Header{
+		Name:    path.Base(name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	*size += int64(len(data))
+
+	return err
+}
+
+// rollupEntries names of the entries of a rollup
+func (m *maintenanceRun) rollupEntries(ctx context.Context, name string) ([]string, error) {
+	rc, err := m.cfg.Sink.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	entries := make([]string, 0)
+	tr := tar.NewReader(rc)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read rollup %s: %w", name, err)
+		}
+
+		// Reading the content checks the entry is complete
+		if _, err = io.Copy(io.Discard, tr); err != nil {
+			return nil, fmt.Errorf("read rollup %s: truncated entry %s: %w", name, header.Name, err)
+		}
+		entries = append(entries, header.Name)
+	}
+}
+
+// replaceArchive moves src over dst through the sink, with its manifest
+func (m *maintenanceRun) replaceArchive(ctx context.Context, src, dst string) error {
+	rc, err := m.cfg.Sink.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	err = m.cfg.Sink.Put(ctx, dst, rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	if err = m.copyManifest(ctx, src, dst); err != nil {
+		return err
+	}
+
+	return m.deleteArchive(ctx, src)
+}
+
+// OpenLog opens the decompressed content of an archived log of the default configuration,
+// e.g. "core/access-20250101.log.gz", whether it is stored alone, in a date partition or
+// inside a rollup
+func OpenLog(ctx context.Context, name string) (io.ReadCloser, error) {
+	m := newMaintenanceRun(ctx, DefaultService().Config())
+	return m.openLog(ctx, name)
+}
+
+func (m *maintenanceRun) openLog(ctx context.Context, name string) (io.ReadCloser, error) {
+	if _, ok := CodecOf(name); !ok {
+		return nil, fmt.Errorf("unknown archive format: %s", name)
+	}
+
+	key := logSource{sink: m.cfg.Sink, name: unpartitioned(name)}.location()
+	return decompressedLogs.openCached(ctx, key, func() (io.ReadCloser, logSource, error) {
+		raw, stored, err := m.openRaw(ctx, name)
+		if err != nil {
+			return nil, logSource{}, err
+		}
+
+		reader, _, err := m.unwrapArchive(name, raw)
+		if err != nil {
+			raw.Close()
+			return nil, logSource{}, err
+		}
+
+		return &stackedReadCloser{Reader: reader, closers: []io.Closer{reader, raw}}, logSource{sink: m.cfg.Sink, name: stored}, nil
+	})
+}
+
+// openRaw opens the stored bytes of an archive, in its directory or a date partition of
+// it, looking into the rollups of its group when needed. It returns the name of the
+// object opened, the archive or its rollup
+func (m *maintenanceRun) openRaw(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	name = unpartitioned(name)
+
+	if stored, exists, err := m.storedArchive(ctx, name); err != nil {
+		return nil, "", err
+	} else if exists {
+		rc, err := m.cfg.Sink.Open(ctx, stored)
+		return rc, stored, err
+	}
+
+	lister, ok := m.cfg.Sink.(ArchiveLister)
+	if !ok {
+		return nil, "", fmt.Errorf("archive %s not found", name)
+	}
+
+	names, err := lister.List(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, base := path.Dir(name), path.Base(name)
+	source, _, _, _ := SplitArchiveName(base)
+	prefix := path.Join(dir, m.logGroupOf(source)+"-")
+
+	for _, rollup := range names {
+		if !strings.HasPrefix(rollup, prefix) || !strings.HasSuffix(rollup, rollupExt) {
+			continue
+		}
+
+		rc, err := m.cfg.Sink.Open(ctx, rollup)
+		if err != nil {
+			continue
+		}
+
+		tr := tar.NewReader(rc)
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if header.Name == base {
+				return &stackedReadCloser{Reader: tr, closers: []io.Closer{rc}}, rollup, nil
+			}
+		}
+
+		rc.Close()
+	}
+
+	return nil, "", fmt.Errorf("archive %s not found", name)
+}
+
+// stackedReadCloser closes several layers of readers
+type stackedReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (s *stackedReadCloser) Close() error {
+	var err error
+	for _, c := range s.closers {
+		if cErr := c.Close(); err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,424 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Incremental rollups on the sinks that can write into a stored archive. The new
+// archives are appended to the rollup in place, after its last complete entry, so a run
+// writes the new archives only instead of the whole period again. The end of the last
+// entry is found from the tar headers, which skips the trailing zero blocks whatever
+// their number (tar pads to 20 blocks, Go to 2) and the tail a crashed append left.
+// The checksum of the manifest is resumed from the SHA-256 state recorded after the
+// last entry, a rollup whose state is missing or does not match its entries is hashed
+// once from the start. An archive already in the rollup is replaced by rewriting it.
+
+// rollupStatePrefix comment line of the manifest of a rollup, holding the end of its
+// last entry and the SHA-256 state of the bytes before it:
+//
+//	#rollup end=30720 sha256=<hex of the marshaled state>
+const rollupStatePrefix = "#rollup "
+
+// tarBlockSize size of the tar blocks, the entries start on a block boundary
+const tarBlockSize = 512
+
+// ArchiveAppender optional sink capability, writes into a stored archive in place
+type ArchiveAppender interface {
+	// AppendAt cuts the archive stored under name at offset and writes r after it, the
+	// archive is created when missing
+	AppendAt(ctx context.Context, name string, offset int64, r io.Reader) error
+}
+
+// AppendAt cuts the archive file at offset and writes r after it, unlike Put the file
+// is written in place and a crash leaves it cut anywhere after offset
+func (s *LocalSink) AppendAt(ctx context.Context, name string, offset int64, r io.Reader) error {
+	p, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = mkdirAll(filepath.Dir(p), permOrDefault(s.DirPerm, DefaultDirPerm)); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE, permOrDefault(s.FilePerm, DefaultFilePerm))
+	if err != nil {
+		return err
+	}
+
+	err = f.Truncate(offset)
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(f, r)
+	}
+	if err == nil && s.Fsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	return err
+}
+
+// offsetReader tracks the position in the reader, it seeks when the reader can so the
+// tar reader skips the content of the entries instead of reading it
+type offsetReader struct {
+	r   io.Reader
+	off int64
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.off += int64(n)
+	return n, err
+}
+
+func (o *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := o.r.(io.Seeker)
+	if !ok || whence != io.SeekCurrent {
+		return 0, errors.New("seek not supported")
+	}
+
+	pos, err := seeker.Seek(offset, whence)
+	if err == nil {
+		o.off = pos
+	}
+	return pos, err
+}
+
+// rollupLayout the entries of a rollup and the end of the last complete one
+type rollupLayout struct {
+	entries   []string
+	end       int64
+	truncated bool // the rollup ends in an incomplete entry or header, cut at end
+}
+
+// scanRollupLayout reads the tar headers of a rollup. The file cut in an entry or a
+// header ends the layout before it, any other malformed header is an error
+func scanRollupLayout(r io.Reader) (rollupLayout, error) {
+	var layout rollupLayout
+
+	or := &offsetReader{r: r}
+	tr := tar.NewReader(or)
+
+	// The content of an entry is only checked when the next header is read
+	pending, pendingEnd := "", int64(0)
+	for {
+		header, err := tr.Next()
+		truncated := errors.Is(err, io.ErrUnexpectedEOF)
+
+		if pending != "" && (!truncated || or.off >= pendingEnd) {
+			layout.entries = append(layout.entries, pending)
+			layout.end = pendingEnd
+		}
+
+		switch {
+		case err == io.EOF:
+			return layout, nil
+		case truncated:
+			layout.truncated = true
+			return layout, nil
+		case err != nil:
+			return rollupLayout{}, err
+		}
+
+		// The content starts at the current position, the next entry at the block
+		// following it
+		pending = header.Name
+		pendingEnd = or.off + header.Size
+		pendingEnd += (tarBlockSize - pendingEnd%tarBlockSize) % tarBlockSize
+	}
+}
+
+// readRollupLayout opens a rollup and scans its headers
+func (m *maintenanceRun) readRollupLayout(ctx context.Context, name string) (rollupLayout, error) {
+	rc, err := m.cfg.Sink.Open(ctx, name)
+	if err != nil {
+		return rollupLayout{}, err
+	}
+	defer rc.Close()
+
+	layout, err := scanRollupLayout(rc)
+	if err != nil {
+		return rollupLayout{}, fmt.Errorf("read rollup %s: %w", name, err)
+	}
+	return layout, nil
+}
+
+// readRollupState returns the end and the SHA-256 state recorded in the manifest of a
+// rollup, ok is false when it has none
+func (m *maintenanceRun) readRollupState(ctx context.Context, name string) (end int64, state []byte, ok bool) {
+	data, found, err := m.readManifestData(ctx, name)
+	if err != nil || !found {
+		return 0, nil, false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, found := strings.CutPrefix(scanner.Text(), rollupStatePrefix)
+		if !found {
+			continue
+		}
+
+		end, state = -1, nil
+		for _, field := range strings.Fields(line) {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "end":
+				end, err = strconv.ParseInt(value, 10, 64)
+			case "sha256":
+				state, err = hex.DecodeString(value)
+			}
+			if err != nil {
+				return 0, nil, false
+			}
+		}
+		return end, state, end >= 0 && state != nil
+	}
+
+	return 0, nil, false
+}
+
+// writeRollupManifest records the checksum of a rollup with the state to resume it from,
+// the rollup is signed next
+func (m *maintenanceRun) writeRollupManifest(ctx context.Context, name, sum string, end int64, state []byte) error {
+	marker, err := m.signedMarker(ctx)
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("%s  %s\n%send=%d sha256=%s\n%s", sum, path.Base(name), rollupStatePrefix, end, hex.EncodeToString(state), marker)
+	return m.cfg.Sink.Put(ctx, manifestName(name), strings.NewReader(content))
+}
+
+// rollupDigest the SHA-256 of the first end bytes of a rollup, resumed from the state
+// of its manifest when it matches or else read from the rollup
+func (m *maintenanceRun) rollupDigest(ctx context.Context, name string, end int64) (hash.Hash, error) {
+	digest := sha256.New()
+	if end == 0 {
+		return digest, nil
+	}
+
+	if stateEnd, state, ok := m.readRollupState(ctx, name); ok && stateEnd == end {
+		if err := digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err == nil {
+			return digest, nil
+		}
+		digest.Reset()
+	}
+
+	g.Log().Infof(ctx, "Rollup %s has no checksum state for its %d bytes, hashing it once", name, end)
+
+	rc, err := m.cfg.Sink.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	n, err := io.Copy(digest, io.LimitReader(rc, end))
+	if err != nil {
+		return nil, fmt.Errorf("hash rollup %s: %w", name, err)
+	}
+
+	// The last entry may lack its padding, cutting the rollup at end extends it with zeros
+	digest.Write(make([]byte, end-n))
+	return digest, nil
+}
+
+// digestState marshals the state of a digest
+func digestState(digest hash.Hash) ([]byte, error) {
+	return digest.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// appendRollup appends the archives of target to its rollup, the archives are removed
+// once the appended entries are read back. appended is false when the rollup holds one
+// of the archives already and has to be rewritten instead
+func (m *maintenanceRun) appendRollup(ctx context.Context, appender ArchiveAppender, target *rollupTarget) (appended bool, err error) {
+	var layout rollupLayout
+	if exists, err := m.cfg.Sink.Exists(ctx, target.name); err != nil {
+		return false, err
+	} else if exists {
+		if layout, err = m.readRollupLayout(ctx, target.name); err != nil {
+			return false, err
+		}
+	}
+
+	present := make(map[string]bool, len(layout.entries))
+	for _, entry := range layout.entries {
+		present[entry] = true
+	}
+	for _, name := range target.archives {
+		if present[path.Base(name)] {
+			return false, nil
+		}
+	}
+
+	if layout.truncated {
+		g.Log().Warningf(ctx, "Rollup %s ends in an incomplete entry, it is cut after its %d complete entries", target.name, len(layout.entries))
+	}
+
+	digest, err := m.rollupDigest(ctx, target.name, layout.end)
+	if err != nil {
+		return true, err
+	}
+	startState, err := digestState(digest)
+	if err != nil {
+		return true, err
+	}
+
+	var sizeBefore int64
+	var endState []byte
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		tw := tar.NewWriter(io.MultiWriter(pw, digest))
+		err := func() error {
+			for _, name := range target.archives {
+				if err := m.copyArchiveInto(ctx, tw, name, &sizeBefore); err != nil {
+					return err
+				}
+			}
+
+			// The state after the last entry, before the end marker written by Close
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			var err error
+			if endState, err = digestState(digest); err != nil {
+				return err
+			}
+			return tw.Close()
+		}()
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	counter := &countingReader{r: pr}
+	err = appender.AppendAt(ctx, target.name, layout.end, counter)
+
+	// Unblock the writer if the sink stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	if writeErr := <-done; err == nil && writeErr != nil {
+		err = writeErr
+	}
+	if err == nil {
+		err = m.checkAppended(ctx, target, layout.end)
+	}
+	if err != nil {
+		m.restoreRollup(ctx, appender, target.name, layout.end, startState)
+		return true, err
+	}
+
+	// Go ends a tar with exactly two zero blocks
+	end := layout.end + counter.n - 2*tarBlockSize
+	if err = m.writeRollupManifest(ctx, target.name, hex.EncodeToString(digest.Sum(nil)), end, endState); err != nil {
+		return true, err
+	}
+	if err = m.signArchive(ctx, target.name, hex.EncodeToString(digest.Sum(nil))); err != nil {
+		return true, err
+	}
+
+	for _, name := range target.archives {
+		if err := m.deleteArchive(ctx, name); err != nil {
+			g.Log().Warningf(ctx, "Failed to delete %s after compaction: %v", name, err)
+			m.fail(ErrDelete, name, err)
+		}
+	}
+
+	m.fileDone(target.name, sizeBefore, 0)
+	g.Log().Infof(ctx, "Appended %d archives to %s (%d bytes)", len(target.archives), target.name, counter.n)
+
+	return true, nil
+}
+
+// checkAppended reads back the entries appended to a rollup after offset
+func (m *maintenanceRun) checkAppended(ctx context.Context, target *rollupTarget, offset int64) error {
+	rc, err := m.cfg.Sink.Open(ctx, target.name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if seeker, ok := rc.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, rc, offset)
+	}
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool, len(target.archives))
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read rollup %s: %w", target.name, err)
+		}
+		if _, err = io.Copy(io.Discard, tr); err != nil {
+			return fmt.Errorf("read rollup %s: truncated entry %s: %w", target.name, header.Name, err)
+		}
+		present[header.Name] = true
+	}
+
+	for _, name := range target.archives {
+		if !present[path.Base(name)] {
+			return fmt.Errorf("entry %s missing from the rollup", path.Base(name))
+		}
+	}
+	return nil
+}
+
+// restoreRollup cuts a failed append off the rollup, a new rollup is removed. The
+// manifest and the signature are rewritten as the end marker may differ from the one cut
+func (m *maintenanceRun) restoreRollup(ctx context.Context, appender ArchiveAppender, name string, end int64, state []byte) {
+	if end == 0 {
+		if err := m.deleteArchive(ctx, name); err != nil {
+			g.Log().Warningf(ctx, "Failed to remove the incomplete rollup %s: %v", name, err)
+		}
+		return
+	}
+
+	marker := make([]byte, 2*tarBlockSize)
+	err := appender.AppendAt(ctx, name, end, bytes.NewReader(marker))
+	if err == nil {
+		digest := sha256.New()
+		if err = digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err == nil {
+			digest.Write(marker)
+			sum := hex.EncodeToString(digest.Sum(nil))
+			if err = m.writeRollupManifest(ctx, name, sum, end, state); err == nil {
+				err = m.signArchive(ctx, name, sum)
+			}
+		}
+	}
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to cut the failed append off the rollup %s: %v", name, err)
+	}
+}
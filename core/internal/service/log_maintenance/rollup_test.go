@@ -0,0 +1,106 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncrementalRollup(t *testing.T) {
+	base := t.TempDir()
+	ctx := context.Background()
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base)})
+
+	archive := func(day string) string {
+		name := "core/access-202501" + day + ".log.gz"
+		_, err := m.putArchive(ctx, name, func(w io.Writer) error {
+			zw := gzip.NewWriter(w)
+			zw.Write([]byte("access of " + day + "\n"))
+			return zw.Close()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	rollup := "core/access-2025-01" + rollupExt
+	path := filepath.Join(base, filepath.FromSlash(rollup))
+	add := func(archives ...string) {
+		t.Helper()
+		if err := m.writeRollup(ctx, &rollupTarget{name: rollup, archives: archives}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.verifyArchive(ctx, rollup, newRateLimiter(0)); err != nil {
+			t.Fatalf("rollup fails its verification: %v", err)
+		}
+	}
+
+	add(archive("01"))
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The record padding of tar after the end marker is cut by the next append
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write(make([]byte, 16*tarBlockSize))
+	f.Close()
+	add(archive("02"))
+
+	// So is an entry a crashed append left incomplete
+	layout, err := m.readRollupLayout(ctx, rollup)
+	if err != nil || len(layout.entries) != 2 {
+		t.Fatalf("layout %+v: %v", layout, err)
+	}
+	var partial bytes.Buffer
+	tw := tar.NewWriter(&partial)
+	tw.WriteHeader(&tar.Header{Name: "access-20250103.log.gz", Mode: 0644, Size: 4000})
+	tw.Write(make([]byte, 1000))
+	tw.Flush()
+	if err = m.cfg.Sink.(ArchiveAppender).AppendAt(ctx, rollup, layout.end, &partial); err != nil {
+		t.Fatal(err)
+	}
+	if cut, _ := m.readRollupLayout(ctx, rollup); !cut.truncated || cut.end != layout.end {
+		t.Fatalf("incomplete entry not detected: %+v", cut)
+	}
+	add(archive("03"))
+
+	// The rollup was appended to, not written again
+	after, err := os.Stat(path)
+	if err != nil || !os.SameFile(before, after) {
+		t.Fatalf("rollup replaced instead of appended: %v", err)
+	}
+	for _, day := range []string{"01", "02", "03"} {
+		name := "core/access-202501" + day + ".log.gz"
+		if exists, _ := m.cfg.Sink.Exists(ctx, name); exists {
+			t.Errorf("%s kept after compaction", name)
+		}
+		rc, err := m.openLog(ctx, name)
+		if err != nil {
+			t.Fatalf("%s unreadable from the rollup: %v", name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(content) != "access of "+day+"\n" {
+			t.Errorf("%s reads %q from the rollup", name, content)
+		}
+	}
+
+	// An archive already in the rollup replaces its entry
+	add(archive("02"))
+	entries, err := m.rollupEntries(ctx, rollup)
+	if err != nil || strings.Join(entries, ",") != "access-20250101.log.gz,access-20250103.log.gz,access-20250102.log.gz" {
+		t.Fatalf("entries after a replacement %v: %v", entries, err)
+	}
+	add(archive("04"))
+	if entries, _ = m.rollupEntries(ctx, rollup); len(entries) != 4 {
+		t.Errorf("entries after appending to a rewritten rollup %v", entries)
+	}
+}
@@ -0,0 +1,243 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Logs trees spread over several disks. Each root of Roots is a logs tree of its own,
+// laid out as the tree of BasePath, with its own archives, index, history and state
+// files. The run of each tree is independent: the trees are maintained in parallel and
+// a failure in one leaves the others untouched. The free space checks of the emergency
+// cleanup and the adaptive retention measure the filesystem of each tree, a full disk
+// only frees archives of its own trees.
+
+// LogRoot an additional logs tree, its zero fields fall back to the settings of the
+// configuration
+type LogRoot struct {
+	BasePath string      // root of the logs tree
+	Sink     ArchiveSink // archive destination, the local disk under BasePath when nil
+
+	// Retention and RetentionOverrides retention of the standard logs of the tree
+	Retention          RetentionPolicy
+	RetentionOverrides map[string]RetentionPolicy
+
+	// MinFreeBytes and AdaptiveHeadroom free space thresholds of the filesystem of the tree
+	MinFreeBytes     int64
+	AdaptiveHeadroom int64
+}
+
+// logTree a logs tree of a run, tenant is set on the trees of the tenants
+type logTree struct {
+	cfg    MaintenanceConfig
+	tenant string
+}
+
+// rootConfigs the configuration of each logs tree, the tree of BasePath first, then the
+// roots and the tenants
+func rootConfigs(cfg MaintenanceConfig) []logTree {
+	roots := cfg.Roots
+	cfg.Roots = nil
+
+	tenants := cfg.Tenants
+	cfg.Tenants = nil
+
+	main := cfg
+	if tenants != nil {
+		main.Sink = excludeTenants(cfg)
+	}
+
+	trees := []logTree{{cfg: main}}
+	for _, root := range roots {
+		c := cfg
+		c.BasePath = filepath.Clean(root.BasePath)
+		c.Sink = root.Sink
+		c.MergeGroups = nil
+		c.CompressionSlices = nil
+
+		if root.Retention != (RetentionPolicy{}) {
+			c.Retention = root.Retention
+		}
+		if root.RetentionOverrides != nil {
+			c.RetentionOverrides = root.RetentionOverrides
+		}
+		if root.MinFreeBytes > 0 {
+			c.MinFreeBytes = root.MinFreeBytes
+		}
+		if root.AdaptiveHeadroom > 0 {
+			c.AdaptiveHeadroom = root.AdaptiveHeadroom
+		}
+
+		trees = append(trees, logTree{cfg: c})
+	}
+
+	if tenants != nil {
+		trees = append(trees, tenantTrees(cfg, tenants)...)
+	}
+
+	return trees
+}
+
+// runRoots maintains the logs trees in parallel and sums up their results, the caller
+// holds runMutex
+func runRoots(ctx context.Context, cfg MaintenanceConfig) MaintenanceResult {
+	trees := rootConfigs(cfg)
+	results := make([]MaintenanceResult, len(trees))
+
+	progress := cfg.Progress
+	if progress != nil {
+		defer close(progress)
+	}
+
+	startedAt := time.Now()
+
+	var wg sync.WaitGroup
+	for i := range trees {
+		tree := &trees[i]
+		tree.cfg.Progress = forwardProgress(&wg, progress)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := runMaintenance(ctx, tree.cfg)
+			result.BasePath = tree.cfg.BasePath
+			result.Tenant = tree.tenant
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	result := sumResults(results)
+	result.StartedAt = startedAt
+	result.Duration = time.Since(startedAt)
+
+	g.Log().Infof(ctx, "Log maintenance of %d logs trees completed, %d bytes reclaimed", len(results), result.BytesReclaimed)
+
+	return result
+}
+
+// forwardProgress a channel for the updates of one tree, forwarded to progress until the
+// run of the tree closes it. Nil without progress
+func forwardProgress(wg *sync.WaitGroup, progress chan<- MaintenanceProgress) chan<- MaintenanceProgress {
+	if progress == nil {
+		return nil
+	}
+
+	updates := make(chan MaintenanceProgress)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for update := range updates {
+			select {
+			case progress <- update:
+			default:
+			}
+		}
+	}()
+
+	return updates
+}
+
+// sumResults the result of the trees together, the flags are set when any tree set them
+func sumResults(results []MaintenanceResult) MaintenanceResult {
+	sum := MaintenanceResult{Roots: results}
+
+	for _, r := range results {
+		sum.FilesDone += r.FilesDone
+		sum.BytesProcessed += r.BytesProcessed
+		sum.BytesReclaimed += r.BytesReclaimed
+		sum.Errors += r.Errors
+		sum.Failures = append(sum.Failures, r.Failures...)
+
+		if r.Partial {
+			sum.Partial = true
+			sum.LastFile = r.LastFile
+		}
+
+		sum.SkippedEmpty += r.SkippedEmpty
+		sum.DeletedEmpty += r.DeletedEmpty
+		sum.Locked = append(sum.Locked, r.Locked...)
+		sum.OverBudget = append(sum.OverBudget, r.OverBudget...)
+		sum.Corrupt = append(sum.Corrupt, r.Corrupt...)
+		sum.Protected = append(sum.Protected, r.Protected...)
+		sum.Overlapping = append(sum.Overlapping, r.Overlapping...)
+		sum.Pinned = append(sum.Pinned, r.Pinned...)
+
+		sum.UploadsSucceeded += r.UploadsSucceeded
+		sum.UploadsFailed += r.UploadsFailed
+		sum.UploadsRetried += r.UploadsRetried
+
+		sum.Emergency = sum.Emergency || r.Emergency
+		sum.EmergencyDeleted += r.EmergencyDeleted
+		sum.EmergencyFreed += r.EmergencyFreed
+		sum.DiskFull = sum.DiskFull || r.DiskFull
+
+		sum.ReadOnly = sum.ReadOnly || r.ReadOnly
+		if sum.Standby == "" {
+			sum.Standby = r.Standby
+		}
+
+		sum.WORMLocks = append(sum.WORMLocks, r.WORMLocks...)
+		sum.Groups = mergeGroupNames(sum.Groups, r.Groups)
+		sum.DeferredGroups = mergeGroupNames(sum.DeferredGroups, r.DeferredGroups)
+	}
+
+	return sum
+}
+
+// mergeGroupNames adds the group names missing from names
+func mergeGroupNames(names, more []string) []string {
+	for _, name := range more {
+		found := false
+		for _, n := range names {
+			if n == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateRoots rejects the roots a run cannot keep apart: a missing, relative or
+// repeated path, or a tree nested in another
+func validateRoots(cfg MaintenanceConfig) error {
+	paths := []string{filepath.Clean(cfg.BasePath)}
+
+	for _, root := range cfg.Roots {
+		if root.BasePath == "" || !filepath.IsAbs(root.BasePath) {
+			return fmt.Errorf("invalid logs root %q, an absolute path is required", root.BasePath)
+		}
+		if root.MinFreeBytes < 0 || root.AdaptiveHeadroom < 0 {
+			return fmt.Errorf("negative limit of the logs root %s", root.BasePath)
+		}
+
+		path := filepath.Clean(root.BasePath)
+		for _, other := range paths {
+			if other == "." {
+				continue
+			}
+			if path == other || strings.HasPrefix(path, other+string(filepath.Separator)) || strings.HasPrefix(other, path+string(filepath.Separator)) {
+				return fmt.Errorf("logs root %s overlaps %s", path, other)
+			}
+		}
+		paths = append(paths, path)
+	}
+
+	for _, tree := range rootConfigs(cfg)[1:] {
+		if err := validateRetention(tree.cfg); err != nil {
+			return fmt.Errorf("logs root %s: %w", tree.cfg.BasePath, err)
+		}
+	}
+
+	return nil
+}
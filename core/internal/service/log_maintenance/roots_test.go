@@ -0,0 +1,79 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceRoots(t *testing.T) {
+	base, other := t.TempDir(), t.TempDir()
+	var logs, otherLogs []string
+	for i := 0; i < 3; i++ {
+		name := "error-" + time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102") + ".log"
+		logs = append(logs, newStandardLog(t, base, name, []byte("line\n")))
+		otherLogs = append(otherLogs, newStandardLog(t, other, name, []byte("line\n")))
+	}
+
+	// Only the filesystem of the other tree is short of space
+	defer func(orig func(string) (int64, error)) { diskFree = orig }(diskFree)
+	diskFree = func(path string) (int64, error) {
+		if strings.HasPrefix(path, other) {
+			return 0, nil
+		}
+		return 1 << 40, nil
+	}
+
+	progress := make(chan MaintenanceProgress, 64)
+	r := RunMaintenance(context.Background(), MaintenanceConfig{
+		BasePath: base,
+		Roots:    []LogRoot{{BasePath: other, Retention: RetentionPolicy{FilesToKeep: 1}, MinFreeBytes: 1}},
+		Progress: progress,
+	})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	for range progress {
+	}
+
+	if len(r.Roots) != 2 || r.Roots[0].BasePath != base || r.Roots[1].BasePath != other {
+		t.Fatalf("roots %+v", r.Roots)
+	}
+	if r.Roots[0].Emergency || !r.Roots[1].Emergency || !r.Emergency {
+		t.Errorf("emergency %v %v %v, want the other tree only", r.Emergency, r.Roots[0].Emergency, r.Roots[1].Emergency)
+	}
+	if r.FilesDone != r.Roots[0].FilesDone+r.Roots[1].FilesDone {
+		t.Errorf("files done %d, roots %d + %d", r.FilesDone, r.Roots[0].FilesDone, r.Roots[1].FilesDone)
+	}
+
+	// The tree of BasePath keeps the default retention, the other one its own
+	for _, path := range logs[:2] {
+		if _, err := os.Stat(path + ".gz"); err != nil {
+			t.Errorf("log %s not compressed: %v", path, err)
+		}
+	}
+	for _, path := range otherLogs[:2] {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("log %s beyond the retention of its tree not deleted", path)
+		}
+		if _, err := os.Stat(path + ".gz"); !os.IsNotExist(err) {
+			t.Errorf("log %s of the other tree compressed", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(other, historyFile)); err != nil {
+		t.Errorf("history of the other tree: %v", err)
+	}
+
+	// Overlapping trees are refused
+	cfg := MaintenanceConfig{BasePath: base, Roots: []LogRoot{{BasePath: filepath.Join(base, "core")}}}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("nested logs root accepted")
+	}
+	cfg.Roots = []LogRoot{{BasePath: "relative"}}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("relative logs root accepted")
+	}
+}
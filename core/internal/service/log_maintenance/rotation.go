@@ -0,0 +1,39 @@
+package log_maintenance
+
+import (
+	"errors"
+	"os"
+)
+
+// Logs rotated by their writer during a run. gf's logger may rename or replace a log
+// between the scan and its compression: a log gone before it is opened is skipped, an
+// opened log is archived from its handle whatever happens to its path, and a log no
+// longer at its path once archived is left for the next run rather than deleting the
+// file that replaced it. A log written to while it was archived is left for the next run
+// too, rather than deleting the lines the archive missed.
+
+var (
+	errLogVanished = errors.New("vanished before it was opened")
+	errLogRotated  = errors.New("rotated while it was archived")
+	errLogAppended = errors.New("appended to while it was archived")
+)
+
+// openLog opens a log to archive, replaced in tests
+var openLog = os.Open
+
+// isRotated reports whether err is a log rotated away or appended to during the run
+func isRotated(err error) bool {
+	return errors.Is(err, errLogVanished) || errors.Is(err, errLogRotated) || errors.Is(err, errLogAppended)
+}
+
+// grownSince reports whether the log at path was written since it was described by info
+func grownSince(path string, info os.FileInfo) bool {
+	current, err := os.Stat(path)
+	return err == nil && (current.Size() != info.Size() || !current.ModTime().Equal(info.ModTime()))
+}
+
+// sameFileAt reports whether path still names the file described by info
+func sameFileAt(path string, info os.FileInfo) bool {
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(current, info)
+}
@@ -0,0 +1,178 @@
+package log_maintenance
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+)
+
+// Compression at rotation, opt-in with CompressOnRotation. The daily run only compresses
+// a day's log once it is past midnight, so the log of the previous day may stay
+// uncompressed for up to a day. Once the writer opened the log of a new day, the log it
+// closed is compressed right away instead. Only the log of the previous day is handled,
+// the older ones and the retention are left to the daily run, and a run and a rotation
+// compression never overlap, so no log is processed twice.
+
+// runMutex serializes the maintenance runs and the rotation compressions
+var runMutex sync.Mutex
+
+// CompressRotatedLogs compresses the logs closed by a rotation with the configuration of
+// the default service, a no-op unless CompressOnRotation is set
+func CompressRotatedLogs(ctx context.Context) {
+	s := DefaultService()
+	if !s.Config().CompressOnRotation {
+		return
+	}
+
+	if result, err := s.CompressRotated(ctx); err != nil {
+		g.Log().Warningf(ctx, "Compression of the rotated logs failed: %v", err)
+	} else if result.Archived > 0 {
+		g.Log().Infof(ctx, "Compressed %d rotated logs, %d bytes reclaimed", result.Archived, result.BytesReclaimed)
+	}
+}
+
+// CompressRotated compresses the logs of the previous day of the groups whose writer
+// already opened the log of today, whether or not CompressOnRotation is set
+func (s *Service) CompressRotated(ctx context.Context) (ArchiveResult, error) {
+	return compressRotated(ctx, s.Config())
+}
+
+func compressRotated(ctx context.Context, cfg MaintenanceConfig) (ArchiveResult, error) {
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	if cfg.BasePath == "" {
+		cfg.BasePath = public.AbsPath("../logs")
+	}
+	cfg.FilePerm = permOrDefault(cfg.FilePerm, DefaultFilePerm)
+	cfg.DirPerm = permOrDefault(cfg.DirPerm, DefaultDirPerm)
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.Sink == nil {
+		cfg.Sink = &LocalSink{Root: cfg.BasePath, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm, Fsync: cfg.Fsync, FsyncDir: cfg.Fsync}
+	}
+
+	if reason := standbyReason(ctx, cfg); reason != "" {
+		g.Log().Debugf(ctx, "Compression of the rotated logs skipped, %s", reason)
+		return ArchiveResult{}, nil
+	}
+
+	m := newMaintenanceRun(ctx, cfg)
+	m.index = loadArchiveIndex(cfg.BasePath, cfg.FilePerm)
+	defer m.index.save(ctx)
+	m.loadRunPins(ctx)
+
+	result := ArchiveResult{}
+	if !m.checkWritable(ctx) {
+		result.Failures = m.failureList.list()
+		return result, result.Err()
+	}
+
+	now := timeNow().In(cfg.Location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, cfg.Location)
+	yesterday := today.AddDate(0, 0, -1)
+
+	dirs := m.standardLogDirs()
+
+	for _, dir := range dirs {
+		if ctx.Err() != nil {
+			break
+		}
+		if gfile.Exists(dir) {
+			result.Archived += m.compressRotatedIn(ctx, dir, yesterday, today)
+		}
+	}
+
+	result.BytesProcessed = m.bytesProcessed.Load()
+	result.BytesReclaimed = m.bytesReclaimed.Load()
+	result.Failures = m.failureList.list()
+
+	return result, result.Err()
+}
+
+// compressRotatedIn compresses the logs of dir dated in [yesterday, today) of the groups
+// having a log dated today. It returns the number of logs archived
+func (m *maintenanceRun) compressRotatedIn(ctx context.Context, dir string, yesterday, today time.Time) int {
+	files, err := m.scanLogDir(ctx, dir)
+	if err != nil {
+		m.fail(ErrScan, dir, err)
+		return 0
+	}
+
+	closed := make(map[string][]string)
+	rotated := make(map[string]bool)
+	active := m.activeLogsOf(ctx, dir, files)
+
+	for _, file := range files {
+		info, err := os.Lstat(file)
+		if err != nil || specialFile(info) || m.isActiveLink(file) {
+			continue
+		}
+
+		group := m.groupOfLog(file)
+		if group == "" {
+			continue
+		}
+
+		switch date := m.effectiveDate(file, info); {
+		case active[filepath.Clean(file)]:
+			rotated[group] = true
+		case !date.Before(today):
+			rotated[group] = true
+		case !date.Before(yesterday):
+			closed[group] = append(closed[group], file)
+		}
+	}
+
+	archived := 0
+	for group, paths := range closed {
+		if !rotated[group] {
+			// Its writer may still append to the log of yesterday
+			continue
+		}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			file := logCandidate{path: path, info: info, rotated: true, cutoff: today}
+			if m.decideAction(file, m.retentionOf(group)) != ActionCompress {
+				continue
+			}
+			if info.Size() == 0 && m.cfg.EmptyLogs != EmptyLogsCompress {
+				// Left to the daily run, with the handling configured for empty logs
+				continue
+			}
+			if err = checkUnlocked(path); err != nil {
+				g.Log().Debugf(ctx, "Rotated log %s not compressed, left for the daily run: %v", path, err)
+				continue
+			}
+
+			written, err := m.upload(ctx, path, func(ctx context.Context) (int64, error) {
+				return m.archiveFile(ctx, path)
+			})
+			if err != nil {
+				if isRotated(err) {
+					continue
+				}
+				g.Log().Errorf(ctx, "Compression of the rotated log %s failed: %v", path, err)
+				m.fail(ErrCompress, path, err)
+				m.fileDone(path, 0, 0)
+				continue
+			}
+
+			m.removeStandardLog(ctx, path, info, written)
+			archived++
+		}
+	}
+
+	return archived
+}
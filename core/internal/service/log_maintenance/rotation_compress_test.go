@@ -0,0 +1,49 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompressRotated(t *testing.T) {
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	timeNow = func() time.Time { return time.Date(2025, 3, 31, 0, 5, 0, 0, time.UTC) }
+
+	base := t.TempDir()
+	closed := newStandardLog(t, base, "2025-03-30.log", []byte("closed day\n"))
+	current := newStandardLog(t, base, "2025-03-31.log", []byte("new day\n"))
+	older := newStandardLog(t, base, "2025-03-28.log", []byte("older day\n"))
+	waiting := newStandardLog(t, base, "access-2025-03-30.log", []byte("no new day yet\n"))
+
+	cfg := MaintenanceConfig{BasePath: base, DateSource: LogDateFromName, Location: time.UTC}
+
+	r, err := compressRotated(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Archived != 1 {
+		t.Errorf("archived %d logs, want 1", r.Archived)
+	}
+
+	if _, err = os.Stat(closed + ".gz"); err != nil {
+		t.Errorf("closed log should be archived: %v", err)
+	}
+	if _, err = os.Stat(closed); !os.IsNotExist(err) {
+		t.Errorf("closed log should be removed, stat err: %v", err)
+	}
+	for _, path := range []string{current, older, waiting} {
+		if _, err = os.Stat(path); err != nil {
+			t.Errorf("%s should be left to the daily run: %v", path, err)
+		}
+	}
+
+	// The daily run finds the rotated log already done
+	if res := RunMaintenance(context.Background(), cfg); res.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", res.Err())
+	}
+	if _, err = os.Stat(closed + ".gz"); err != nil {
+		t.Errorf("archive of the rotated log should be kept: %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package log_maintenance
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Sink stores the archives in an S3 compatible bucket, below Prefix. With a Lock
+// policy the archives are stored under S3 Object Lock, the bucket must have been
+// created with Object Lock enabled. Requests are signed with AWS Signature Version 4
+// and addressed path-style, Endpoint/Bucket/key, which every S3 compatible store accepts
+type S3Sink struct {
+	Endpoint  string // e.g. "https://s3.eu-west-1.amazonaws.com"
+	Region    string
+	Bucket    string
+	Prefix    string // e.g. "billionmail/logs/", prepended to the archive names
+	AccessKey string
+	SecretKey string
+
+	Lock WORMPolicy
+
+	// OpaqueETags the ETags of the bucket are not the MD5 of the objects, e.g. under
+	// SSE-KMS, only the size of the uploads is confirmed
+	OpaqueETags bool
+
+	Client *http.Client // http.DefaultClient when nil
+}
+
+// LockPolicy the lock of the archives stored in the bucket
+func (s *S3Sink) LockPolicy() WORMPolicy {
+	return s.Lock
+}
+
+// Put uploads the archive
+func (s *S3Sink) Put(ctx context.Context, name string, r io.Reader) error {
+	return s.put(ctx, name, r, nil)
+}
+
+// PutLocked uploads the archive under lock
+func (s *S3Sink) PutLocked(ctx context.Context, name string, r io.Reader, lock ObjectLock) error {
+	return s.put(ctx, name, r, &lock)
+}
+
+// put spools the archive to a temporary file first, the upload needs its size and
+// digests before the first byte is sent
+func (s *S3Sink) put(ctx context.Context, name string, r io.Reader, lock *ObjectLock) error {
+	tmp, err := os.CreateTemp("", "s3-archive-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	sha, sum := sha256.New(), md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, sha, sum), r)
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	// Required by S3 for the uploads under Object Lock
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum.Sum(nil)))
+	if lock != nil {
+		header.Set("X-Amz-Object-Lock-Mode", lock.Mode)
+		header.Set("X-Amz-Object-Lock-Retain-Until-Date", lock.RetainUntil.UTC().Format(time.RFC3339))
+		if lock.LegalHold {
+			header.Set("X-Amz-Object-Lock-Legal-Hold", "ON")
+		}
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, name, header, io.NopCloser(tmp), size, hex.EncodeToString(sha.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Exists reports whether the object exists
+func (s *S3Sink) Exists(ctx context.Context, name string) (bool, error) {
+	resp, err := s.head(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return resp != nil, nil
+}
+
+// Delete removes the object, a missing object is not an error. A locked
+// object is refused with ErrArchiveLocked: on a versioned bucket the deletion would
+// otherwise only hide it behind a delete marker
+func (s *S3Sink) Delete(ctx context.Context, name string) error {
+	lock, err := s.ArchiveLock(ctx, name)
+	if err != nil {
+		return err
+	}
+	if lock.Active(timeNow()) {
+		return fmt.Errorf("%s %w until %s", name, ErrArchiveLocked, lock.RetainUntil.Format(time.RFC3339))
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Open downloads the object
+func (s *S3Sink) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, name, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat returns the size of the object and, unless OpaqueETags, the MD5 its ETag holds.
+// The ETags of multipart uploads are not MD5s and are ignored
+func (s *S3Sink) Stat(ctx context.Context, name string) (ArchiveInfo, error) {
+	resp, err := s.head(ctx, name)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+	if resp == nil {
+		return ArchiveInfo{}, fmt.Errorf("object %s: %w", name, fs.ErrNotExist)
+	}
+
+	info := ArchiveInfo{Size: resp.ContentLength}
+	etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+	if _, err := hex.DecodeString(etag); err == nil && len(etag) == 32 && !s.OpaqueETags {
+		info.MD5 = etag
+	}
+	return info, nil
+}
+
+// ArchiveLock returns the lock of the object, from its metadata
+func (s *S3Sink) ArchiveLock(ctx context.Context, name string) (ObjectLock, error) {
+	var lock ObjectLock
+
+	resp, err := s.head(ctx, name)
+	if err != nil || resp == nil {
+		return lock, err
+	}
+
+	lock.Mode = resp.Header.Get("X-Amz-Object-Lock-Mode")
+	lock.LegalHold = strings.EqualFold(resp.Header.Get("X-Amz-Object-Lock-Legal-Hold"), "ON")
+	if until := resp.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"); until != "" {
+		if lock.RetainUntil, err = time.Parse(time.RFC3339, until); err != nil {
+			return lock, fmt.Errorf("invalid retention date %q of %s: %w", until, name, err)
+		}
+	}
+
+	return lock, nil
+}
+
+// head returns the metadata of the object, nil when it does not exist
+func (s *S3Sink) head(ctx context.Context, name string) (*http.Response, error) {
+	resp, err := s.do(ctx, http.MethodHead, name, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// emptyPayloadHash SHA-256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do sends a signed request for the object of an archive, a response other than 2xx
+// is returned as an error
+func (s *S3Sink) do(ctx context.Context, method, name string, header http.Header, body io.ReadCloser, size int64, payloadHash string) (*http.Response, error) {
+	endpoint, err := url.Parse(strings.TrimRight(s.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %w", s.Endpoint, err)
+	}
+
+	key := strings.TrimLeft(s.Prefix+name, "/")
+	endpoint.Path += "/" + s.Bucket + "/" + key
+	endpoint.RawPath = escapeS3Path(endpoint.Path)
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, payloadHash, timeNow())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &s3Error{Method: method, Key: key, StatusCode: resp.StatusCode, Status: resp.Status, Detail: strings.TrimSpace(string(detail))}
+	}
+
+	return resp, nil
+}
+
+// s3Error response of the store other than 2xx
+type s3Error struct {
+	Method     string
+	Key        string
+	StatusCode int
+	Status     string
+	Detail     string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("S3 %s %s: %s %s", e.Method, e.Key, e.Status, e.Detail)
+}
+
+// isNotFound reports a missing object
+func isNotFound(err error) bool {
+	var e *s3Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// sign adds the AWS Signature Version 4 of the request
+func (s *S3Sink) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Host and every Content-* and X-Amz-* header are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || strings.HasPrefix(lk, "content-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapeS3Path encodes every byte of the path but the unreserved ones and the slashes,
+// the encoding the signature expects
+func escapeS3Path(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
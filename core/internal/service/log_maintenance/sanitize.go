@@ -0,0 +1,84 @@
+package log_maintenance
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Neutralization of the log lines carrying attacker controlled content, e.g. the
+// subject of a message logged by postfix or an address logged by the API. A line is
+// sanitized before it is searched, shown or parsed for the metrics: the terminal escapes
+// are removed, the other control characters and the embedded line breaks are escaped,
+// so one entry is always shown as one line and cannot rewrite the screen. A line still
+// holding the timestamp of another entry after the start of its own, or escapes other
+// than the colors written by the logger, is flagged as suspicious: it is most likely a
+// fake entry injected through a logged value.
+
+// SuspiciousLogMarker prefix of the suspicious lines returned by SearchLogs, searching
+// for it finds them
+const SuspiciousLogMarker = "[suspicious] "
+
+var (
+	// Colors written by the logger itself, removed without flagging the line
+	ansiColorPattern = regexp.MustCompile("\x1b\\[[0-9;]*m")
+	// Every other CSI sequence, e.g. cursor moves or screen clears, and the OSC sequences
+	ansiEscapePattern = regexp.MustCompile("\x1b\\[[0-?]*[ -/]*[@-~]|\x1b\\][^\x07\x1b]*(?:\x07|\x1b\\\\)?|\x1b[@-_]?")
+
+	// Start of an entry: the logger format, 2025-01-02 15:04:05.000 [INFO], and the
+	// syslog formats of the mail logs, with an ISO or a BSD timestamp
+	logEntryPattern = regexp.MustCompile(
+		`\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?\s+` +
+			`(?:\[(?:DEBU|INFO|NOTI|WARN|ERRO|CRIT|PANI|FATA)\]|\S+\s+[\w./-]+\[\d+\]:)` +
+			`|\b(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)\s+\d{1,2}\s+\d{2}:\d{2}:\d{2}\s+\S+\s+[\w./-]+\[\d+\]:`)
+)
+
+// SanitizeLogLine returns the line safe to show, and whether it looks injected
+func SanitizeLogLine(line string) (string, bool) {
+	suspicious := false
+
+	// The 8 bit subjects are logged as is, they are not suspicious
+	line = strings.ToValidUTF8(line, "\uFFFD")
+
+	if strings.IndexByte(line, 0x1b) >= 0 {
+		line = ansiColorPattern.ReplaceAllString(line, "")
+		if stripped := ansiEscapePattern.ReplaceAllString(line, ""); stripped != line {
+			line = stripped
+			suspicious = true
+		}
+	}
+
+	var b strings.Builder
+	for _, r := range line {
+		switch {
+		case r == '\t':
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+			suspicious = true
+		case r == '\r':
+			b.WriteString(`\r`)
+			suspicious = true
+		case r < 0x20 || (0x7f <= r && r < 0xa0):
+			fmt.Fprintf(&b, `\x%02x`, r)
+			suspicious = true
+		case (0x202a <= r && r <= 0x202e) || (0x2066 <= r && r <= 0x2069):
+			// Bidirectional overrides, they reorder what is shown
+			fmt.Fprintf(&b, `\u%04x`, r)
+			suspicious = true
+		default:
+			b.WriteRune(r)
+		}
+	}
+	line = b.String()
+
+	// An entry starting after the start of the line
+	for _, loc := range logEntryPattern.FindAllStringIndex(line, -1) {
+		if strings.TrimSpace(line[:loc[0]]) != "" {
+			suspicious = true
+			break
+		}
+	}
+
+	return line, suspicious
+}
@@ -0,0 +1,67 @@
+package log_maintenance
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogInjectionNeutralized(t *testing.T) {
+	cases := []struct {
+		name       string
+		line       string
+		want       string
+		suspicious bool
+	}{
+		{"plain", "2025-01-02 10:00:00.000 [INFO] send done", "2025-01-02 10:00:00.000 [INFO] send done", false},
+		{"colors", "2025-01-02 10:00:00.000 \x1b[32m[INFO]\x1b[0m send done", "2025-01-02 10:00:00.000 [INFO] send done", false},
+		{"screen clear", "subject=\x1b[2J\x1b[Hall good", "subject=all good", true},
+		{"title", "subject=\x1b]0;owned\x07hi", "subject=hi", true},
+		{"carriage return", "login failed\r2025-01-02 10:00:00.000 [INFO] login ok", `login failed\r2025-01-02 10:00:00.000 [INFO] login ok`, true},
+		{"newline", "subject=hi\n2025-01-02 10:00:00.000 [INFO] admin login ok", `subject=hi\n2025-01-02 10:00:00.000 [INFO] admin login ok`, true},
+		{"embedded entry", "2025-01-02 10:00:00.000 [WARN] bad subject 2025-01-02 10:00:01.000 [INFO] admin login ok", "2025-01-02 10:00:00.000 [WARN] bad subject 2025-01-02 10:00:01.000 [INFO] admin login ok", true},
+		{"embedded syslog entry", "Jan  2 10:00:00 mx postfix/cleanup[12]: 4F: warning: header Subject: x Jan  2 10:00:01 mx postfix/smtp[13]: 5A: to=<a@b.c>, status=sent", "Jan  2 10:00:00 mx postfix/cleanup[12]: 4F: warning: header Subject: x Jan  2 10:00:01 mx postfix/smtp[13]: 5A: to=<a@b.c>, status=sent", true},
+		{"control", "a\x00b\x7fc", `a\x00b\x7fc`, true},
+		{"bidi", "invoice\u202etxt.exe", `invoice\u202etxt.exe`, true},
+		{"tab and 8 bit", "subject=\tcaf\xe9", "subject=\tcaf\uFFFD", false},
+	}
+	for _, c := range cases {
+		got, suspicious := SanitizeLogLine(c.line)
+		if got != c.want || suspicious != c.suspicious {
+			t.Errorf("%s: SanitizeLogLine = %q, %t, want %q, %t", c.name, got, suspicious, c.want, c.suspicious)
+		}
+		if strings.ContainsAny(got, "\x1b\r\n") {
+			t.Errorf("%s: escape or line break left in %q", c.name, got)
+		}
+	}
+
+	// Search: a crafted value is one line, flagged, and cannot forge a match at the
+	// start of a line
+	path := filepath.Join(t.TempDir(), "out.log")
+	content := "2025-01-02 10:00:00.000 [INFO] send done\n" +
+		"2025-01-02 10:00:01.000 [WARN] bad subject \x1b[2K2025-01-02 10:00:02.000 [INFO] admin login ok\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := SearchLogs(path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || strings.HasPrefix(lines[0], SuspiciousLogMarker) ||
+		lines[1] != SuspiciousLogMarker+"2025-01-02 10:00:01.000 [WARN] bad subject 2025-01-02 10:00:02.000 [INFO] admin login ok" {
+		t.Errorf("searched lines = %q", lines)
+	}
+	if found, _ := SearchLogs(path, SuspiciousLogMarker, nil); len(found) != 1 {
+		t.Errorf("search for the marker = %q, want the injected line", found)
+	}
+
+	// Display
+	component := "injection-test"
+	RecordRecentLog(component, "INFO", "subject=hi\n2025-01-02 10:00:00.000 [INFO] admin login ok", time.Now())
+	recent := RecentLogs(component, 0)
+	if len(recent) != 1 || !recent[0].Suspicious || strings.Contains(recent[0].Content, "\n") {
+		t.Errorf("recent lines = %+v", recent)
+	}
+}
@@ -0,0 +1,241 @@
+package log_maintenance
+
+import (
+	"billionmail-core/internal/service/public"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Self-test of the maintenance: a synthetic logs tree in a temporary directory goes
+// through compression, retention, verification and restore with the settings of the
+// scheduled run, and the outcome of each step is checked. Nothing outside of the
+// temporary directory is touched, it is removed at the end.
+
+const (
+	selfTestStandardLogs = standardLogsKept + 2
+	selfTestOperationLog = "operation.json"
+)
+
+// SelfTestStep outcome of one step of the self-test
+type SelfTestStep struct {
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Detail   string        `json:"detail,omitempty"` // the failure, empty when passed
+	Duration time.Duration `json:"duration"`
+}
+
+// SelfTestReport outcome of the self-test, Passed when every step passed
+type SelfTestReport struct {
+	StartedAt time.Time      `json:"started_at"`
+	Duration  time.Duration  `json:"duration"`
+	Passed    bool           `json:"passed"`
+	Steps     []SelfTestStep `json:"steps"`
+}
+
+// selfTest state shared by the steps
+type selfTest struct {
+	base      string
+	cfg       MaintenanceConfig
+	standard  []string // synthetic standard logs, oldest first
+	opLogDate string
+	opLogData []byte
+}
+
+// SelfTest runs the self-test with the settings of the scheduled maintenance
+func SelfTest(ctx context.Context) SelfTestReport {
+	return runSelfTest(ctx, DefaultService().Config())
+}
+
+func runSelfTest(ctx context.Context, cfg MaintenanceConfig) SelfTestReport {
+	report := SelfTestReport{StartedAt: time.Now(), Passed: true}
+
+	base, err := os.MkdirTemp("", "billionmail-log-selftest-")
+	if err != nil {
+		report.Passed = false
+		report.Steps = append(report.Steps, SelfTestStep{Name: "setup", Detail: err.Error()})
+		return report
+	}
+	defer os.RemoveAll(base)
+
+	// The settings under test on a private tree, unbounded and silent. Rollups and
+	// recompression would replace the archives checked, the built-in groups hold the logs
+	cfg.BasePath = base
+	cfg.FilePerm = permOrDefault(cfg.FilePerm, DefaultFilePerm)
+	cfg.DirPerm = permOrDefault(cfg.DirPerm, DefaultDirPerm)
+	cfg.Sink = &LocalSink{Root: base, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm, Fsync: cfg.Fsync, FsyncDir: cfg.Fsync}
+	cfg.RestoreDir = filepath.Join(base, "restore")
+	cfg.MaxRuntime = 0
+	cfg.MinFreeBytes = 0
+	cfg.RollupAfter = 0
+	cfg.RecompressTo = ""
+	cfg.LogGroups = nil
+	cfg.Progress = nil
+
+	t := &selfTest{base: base, cfg: cfg}
+
+	steps := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"setup", t.setup},
+		{"compress", t.compress},
+		{"retention", t.retention},
+		{"verify", t.verify},
+		{"restore", t.restore},
+	}
+
+	for _, step := range steps {
+		started := time.Now()
+		err := step.run(ctx)
+
+		s := SelfTestStep{Name: step.name, Passed: err == nil, Duration: time.Since(started)}
+		if err != nil {
+			s.Detail = err.Error()
+			report.Passed = false
+		}
+		report.Steps = append(report.Steps, s)
+
+		// The following steps depend on this one
+		if err != nil {
+			break
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+	return report
+}
+
+// setup writes dated standard logs old enough to be handled and an operation log day
+// older than the operation log retention
+func (t *selfTest) setup(ctx context.Context) error {
+	dir := filepath.Join(t.base, "core")
+	if err := os.MkdirAll(dir, DefaultDirPerm); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := 0; i < selfTestStandardLogs; i++ {
+		modTime := now.AddDate(0, 0, -(selfTestStandardLogs + 2 - i))
+		path := filepath.Join(dir, "error-"+modTime.Format("20060102")+".log")
+
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("self-test log line %d\n", i)), DefaultFilePerm); err != nil {
+			return err
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			return err
+		}
+		t.standard = append(t.standard, path)
+	}
+
+	location := t.cfg.OperationLogLocation
+	if location == nil {
+		location = public.OperationLogLocation()
+	}
+	t.opLogDate = operationLogCutoff(now.In(location)).AddDate(0, 0, -3).Format("2006-01-02")
+	t.opLogData = []byte(`{"type":"self-test","log":"synthetic operation log"}` + "\n")
+
+	opDir := filepath.Join(t.base, "core", "operation_log", t.opLogDate)
+	if err := os.MkdirAll(opDir, DefaultDirPerm); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(opDir, selfTestOperationLog), t.opLogData, DefaultFilePerm)
+}
+
+// compress runs the maintenance, the kept logs and the operation log day must be archived
+func (t *selfTest) compress(ctx context.Context) error {
+	if err := RunMaintenance(ctx, t.cfg).Err(); err != nil {
+		return fmt.Errorf("maintenance failed: %w", err)
+	}
+
+	m := newMaintenanceRun(ctx, t.cfg)
+	for _, path := range t.standard[selfTestStandardLogs-standardLogsKept:] {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return fmt.Errorf("log %s not removed after compression", filepath.Base(path))
+		}
+
+		name, err := m.archiveName(path, ArchiveExt(m.archiveCodec(), false))
+		if err != nil {
+			return err
+		}
+		if _, exists, err := m.storedArchive(ctx, name); err != nil || !exists {
+			return fmt.Errorf("archive of %s missing: %v", filepath.Base(path), err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(t.base, "core", "operation_log", t.opLogDate)); !os.IsNotExist(err) {
+		return fmt.Errorf("operation log day %s not removed after compression", t.opLogDate)
+	}
+
+	return nil
+}
+
+// retention the logs beyond the number kept per group are deleted without archive
+func (t *selfTest) retention(ctx context.Context) error {
+	m := newMaintenanceRun(ctx, t.cfg)
+
+	for _, path := range t.standard[:selfTestStandardLogs-standardLogsKept] {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return fmt.Errorf("log %s beyond the retention not deleted", filepath.Base(path))
+		}
+
+		name, err := m.archiveName(path, ArchiveExt(m.archiveCodec(), false))
+		if err != nil {
+			return err
+		}
+		if _, exists, err := m.storedArchive(ctx, name); err != nil || exists {
+			return fmt.Errorf("log %s beyond the retention was archived", filepath.Base(path))
+		}
+	}
+
+	return nil
+}
+
+// verify the stored archives match their manifests
+func (t *selfTest) verify(ctx context.Context) error {
+	r := RunVerification(ctx, VerifyConfig{
+		BasePath:       t.base,
+		BytesPerSecond: -1,
+		FilePerm:       t.cfg.FilePerm,
+		DirPerm:        t.cfg.DirPerm,
+	})
+
+	if err := r.Err(); err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	if r.Corrupt > 0 || r.Verified+r.Unverified == 0 {
+		return fmt.Errorf("%d archives verified, %d corrupt", r.Verified+r.Unverified, r.Corrupt)
+	}
+
+	return nil
+}
+
+// restore the operation log day comes back with its content
+func (t *selfTest) restore(ctx context.Context) error {
+	m := newMaintenanceRun(ctx, t.cfg)
+
+	dir, err := m.restoreOperationLogDay(ctx, t.opLogDate)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && d.Name() == selfTestOperationLog {
+			data, err = os.ReadFile(path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(data, t.opLogData) {
+		return fmt.Errorf("restored operation log differs from the original")
+	}
+
+	return nil
+}
@@ -0,0 +1,14 @@
+package log_maintenance
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelfTestPasses(t *testing.T) {
+	report := runSelfTest(context.Background(), MaintenanceConfig{})
+
+	if !report.Passed || len(report.Steps) != 5 {
+		t.Fatalf("self-test failed: %+v", report.Steps)
+	}
+}
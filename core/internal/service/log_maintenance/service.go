@@ -0,0 +1,271 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Service runs the maintenance with a configuration that can be replaced while the
+// server runs. A reload takes effect on the next run, a run in progress keeps the
+// configuration it started with.
+type Service struct {
+	cfg atomic.Pointer[MaintenanceConfig]
+
+	slicesMutex   sync.Mutex
+	pendingSlices []*time.Timer
+
+	watch watchdog // see watchdog.go
+}
+
+var (
+	defaultService     *Service
+	defaultServiceOnce sync.Once
+)
+
+// NewService creates a service running with cfg
+func NewService(cfg MaintenanceConfig) *Service {
+	s := &Service{}
+	s.cfg.Store(&cfg)
+	s.watch.since = timeNow()
+	return s
+}
+
+// DefaultService the service of the scheduled maintenance, created with the configuration
+// of the server (LoadConfig), DefaultConfig when it is invalid
+func DefaultService() *Service {
+	defaultServiceOnce.Do(func() {
+		cfg, err := LoadConfig(context.Background())
+		if err != nil {
+			g.Log().Errorf(context.Background(), "Invalid log maintenance configuration, the defaults are used: %v", err)
+		}
+		defaultService = NewService(cfg)
+	})
+	return defaultService
+}
+
+// Config returns the active configuration
+func (s *Service) Config() MaintenanceConfig {
+	return *s.cfg.Load()
+}
+
+// RunInterval the interval of the scheduled runs of the active configuration
+func (s *Service) RunInterval() time.Duration {
+	if interval := s.Config().RunInterval; interval > 0 {
+		return interval
+	}
+	return DefaultRunInterval
+}
+
+// RunInterval the interval of the scheduled maintenance, see timers.go
+func RunInterval() time.Duration {
+	return DefaultService().RunInterval()
+}
+
+// Run runs the maintenance with the active configuration, then schedules its
+// compression slices. The watchdog records when it starts and completes
+func (s *Service) Run(ctx context.Context) MaintenanceResult {
+	cfg := s.Config()
+
+	s.watch.started(timeNow())
+	succeeded := false
+	// A run that panics ends without success, the watchdog tells it apart from a hung one
+	defer func() { s.watch.finished(timeNow(), succeeded) }()
+
+	result := RunMaintenance(ctx, cfg)
+	succeeded = runSucceeded(result)
+	s.scheduleSlices(ctx, cfg)
+	return result
+}
+
+// Reload validates cfg and makes it the active configuration of the next runs
+func (s *Service) Reload(cfg MaintenanceConfig) error {
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+
+	old := s.cfg.Swap(&cfg)
+
+	changes := configChanges(*old, cfg)
+	if len(changes) == 0 {
+		g.Log().Info(context.Background(), "Log maintenance configuration reloaded, nothing changed")
+	} else {
+		g.Log().Infof(context.Background(), "Log maintenance configuration reloaded: %s", strings.Join(changes, ", "))
+	}
+
+	return nil
+}
+
+// validateConfig rejects the settings a run would refuse or misread
+func validateConfig(cfg MaintenanceConfig) error {
+	switch cfg.DateSource {
+	case "", LogDateFromModTime, LogDateFromName, LogDateFromContent:
+	default:
+		return fmt.Errorf("unknown date source %q", cfg.DateSource)
+	}
+
+	if err := validateDateLayouts(cfg.DateLayouts); err != nil {
+		return err
+	}
+
+	switch cfg.EmptyLogs {
+	case "", EmptyLogsSkip, EmptyLogsDelete, EmptyLogsCompress:
+	default:
+		return fmt.Errorf("unknown empty logs handling %q", cfg.EmptyLogs)
+	}
+
+	switch cfg.CorruptLogs {
+	case "", CorruptLogsQuarantine, CorruptLogsCompress, CorruptLogsSkip:
+	default:
+		return fmt.Errorf("unknown corrupt logs handling %q", cfg.CorruptLogs)
+	}
+	if cfg.CorruptThreshold < 0 || cfg.CorruptThreshold > 1 {
+		return fmt.Errorf("corrupt logs threshold %v out of [0, 1]", cfg.CorruptThreshold)
+	}
+
+	switch cfg.StrayFiles {
+	case "", StrayFilesIgnore, StrayFilesWarn, StrayFilesDelete:
+	default:
+		return fmt.Errorf("unknown stray files handling %q", cfg.StrayFiles)
+	}
+
+	switch cfg.ExistingArchives {
+	case "", ExistingArchivesVerify, ExistingArchivesOverwrite, ExistingArchivesSkip:
+	default:
+		return fmt.Errorf("unknown existing archives handling %q", cfg.ExistingArchives)
+	}
+
+	if locker, ok := cfg.Sink.(ArchiveLocker); ok {
+		if err := validateWORMPolicy(locker.LockPolicy()); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.RollupGranularity {
+	case "", RollupMonthly, RollupWeekly:
+	default:
+		return fmt.Errorf("unknown rollup granularity %q", cfg.RollupGranularity)
+	}
+
+	if cfg.RecompressTo != "" {
+		if _, ok := CodecByName(cfg.RecompressTo); !ok {
+			return fmt.Errorf("unknown recompression codec %q", cfg.RecompressTo)
+		}
+		if cfg.RecompressFrom != "" {
+			if _, ok := CodecByName(cfg.RecompressFrom); !ok {
+				return fmt.Errorf("unknown recompression codec %q", cfg.RecompressFrom)
+			}
+		}
+	}
+
+	if cfg.MaxRuntime < 0 || cfg.LockRetryDelay < 0 || cfg.UploadTimeout < 0 || cfg.UploadRetryDelay < 0 || cfg.ConfirmRetryDelay < 0 ||
+		cfg.ProtectedWindow < 0 || cfg.RollupAfter < 0 || cfg.RestoreTTL < 0 || cfg.AdaptiveMinHistory < 0 || cfg.TrashGrace < 0 || cfg.StrayFileAge < 0 ||
+		cfg.RunInterval < 0 {
+		return fmt.Errorf("negative duration in the configuration")
+	}
+
+	if cfg.MinFreeBytes < 0 || cfg.AdaptiveHeadroom < 0 || cfg.MaxConcurrentUploads < 0 || cfg.DeleteWorkers < 0 || cfg.BacklogBatch < 0 || cfg.TrashMaxBytes < 0 || cfg.StaleAfter < 0 {
+		return fmt.Errorf("negative limit in the configuration")
+	}
+
+	if err := validateRetention(cfg); err != nil {
+		return err
+	}
+
+	if err := validateExcludeLogs(cfg.ExcludeLogs); err != nil {
+		return err
+	}
+
+	if err := validateExternalSources(cfg); err != nil {
+		return err
+	}
+
+	if err := validateRoots(cfg); err != nil {
+		return err
+	}
+	if err := validateTenants(cfg); err != nil {
+		return err
+	}
+
+	for dir := range cfg.LogDirs {
+		if dir == "" || filepath.IsAbs(dir) || !filepath.IsLocal(dir) {
+			return fmt.Errorf("invalid log directory %q, a path relative to the logs tree is required", dir)
+		}
+	}
+
+	switch cfg.Standby {
+	case "", StandbySkip, StandbyVerify:
+	default:
+		return fmt.Errorf("unknown standby handling %q", cfg.Standby)
+	}
+
+	if err := validateSlices(cfg.CompressionSlices); err != nil {
+		return err
+	}
+
+	if err := validatePush(cfg.Push); err != nil {
+		return err
+	}
+
+	if chain, err := NewFilterChain(cfg); err != nil {
+		return err
+	} else if cfg.CompressionLevel != 0 {
+		// Fails on a level out of the range of the codec
+		cw, err := chain.newCodecWriter(io.Discard)
+		if err != nil {
+			return err
+		}
+		cw.Close()
+	}
+
+	for i, group := range cfg.LogGroups {
+		if group.Pattern == nil || !logGroupNamePattern.MatchString(group.Name) || !validActiveLink(group.ActiveLink) {
+			return fmt.Errorf("invalid log group %q", group.Name)
+		}
+		if err := checkLogGroupOverlap(cfg.LogGroups[:i], group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configChanges describes the fields that differ between two configurations
+func configChanges(old, cfg MaintenanceConfig) []string {
+	var changes []string
+
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(cfg)
+	for i := 0; i < ov.NumField(); i++ {
+		name := ov.Type().Field(i).Name
+		a, b := ov.Field(i), nv.Field(i)
+
+		switch a.Kind() {
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint32:
+			if a.Interface() != b.Interface() {
+				changes = append(changes, fmt.Sprintf("%s %v -> %v", name, a.Interface(), b.Interface()))
+			}
+		case reflect.Func:
+			if a.Pointer() != b.Pointer() {
+				changes = append(changes, name+" changed")
+			}
+		default:
+			if loc, ok := a.Interface().(*time.Location); ok {
+				if next := b.Interface().(*time.Location); loc.String() != next.String() {
+					changes = append(changes, fmt.Sprintf("%s %v -> %v", name, loc, next))
+				}
+			} else if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+				changes = append(changes, name+" changed")
+			}
+		}
+	}
+
+	return changes
+}
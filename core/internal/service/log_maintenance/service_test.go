@@ -0,0 +1,29 @@
+package log_maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceReload(t *testing.T) {
+	s := NewService(MaintenanceConfig{BasePath: t.TempDir(), EmptyLogs: EmptyLogsSkip})
+
+	if err := s.Reload(MaintenanceConfig{EmptyLogs: "shred"}); err == nil {
+		t.Errorf("an unknown empty logs handling should be refused")
+	}
+	if s.Config().EmptyLogs != EmptyLogsSkip {
+		t.Errorf("a refused configuration should not be applied")
+	}
+
+	cfg := s.Config()
+	cfg.EmptyLogs = EmptyLogsDelete
+	cfg.MaxRuntime = time.Hour
+	if err := s.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := configChanges(MaintenanceConfig{BasePath: cfg.BasePath, EmptyLogs: EmptyLogsSkip}, s.Config())
+	if len(changes) != 2 || changes[0] != "EmptyLogs skip -> delete" || changes[1] != "MaxRuntime 0s -> 1h0m0s" {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
@@ -0,0 +1,412 @@
+package log_maintenance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Signatures of the stored archives, the proof an archive was produced by this server and
+// was not altered since, which the checksum manifest alone cannot give. Once an archive
+// is stored and confirmed, the SHA-256 of its stored bytes is signed with the Ed25519 key
+// of the server and "<archive>.sig" written next to it, naming the key. The keys rotate:
+// a new key signs the archives stored from then on, the public keys of the retired ones
+// stay trusted to verify the archives they signed. An archive stored without signing
+// configured has no signature, VerifyArchiveSignature reports it. The manifest of an
+// archive stored signed records it, "#signed key_id=<id>" after the checksum: once
+// signing is enabled an archive that lost its signature is corrupt, not unsigned.
+
+const (
+	signatureExt = ".sig"
+
+	// signatureAlgorithm the only algorithm of the signatures
+	signatureAlgorithm = "ed25519"
+
+	// signedPrefix comment line of the manifest of an archive stored signed
+	signedPrefix = "#signed "
+)
+
+// Configuration keys of ConfiguredSigning
+const (
+	signingKeyConfigKey     = "log_maintenance.signing.key_file"
+	signingKeyIdConfigKey   = "log_maintenance.signing.key_id"
+	signingTrustedConfigKey = "log_maintenance.signing.trusted_keys_dir"
+)
+
+var errNoSignature = errors.New("no signature")
+
+// SigningFunc returns the keys of the signatures, nil when the archives are not signed
+type SigningFunc func(ctx context.Context) (*ArchiveSigning, error)
+
+// ArchiveSigning keys of the signatures of the archives
+type ArchiveSigning struct {
+	// KeyId identifier of Key recorded in the signatures, the fingerprint of its public
+	// key when empty
+	KeyId string
+	// Key signs the archives stored, they are only verified when nil
+	Key ed25519.PrivateKey
+
+	// Trusted public keys of the signatures by key identifier, the retired keys. The
+	// public key of Key is always trusted
+	Trusted map[string]ed25519.PublicKey
+}
+
+// ArchiveSignature content of a signature file
+type ArchiveSignature struct {
+	Version   int    `json:"version"`
+	Algorithm string `json:"algorithm"`
+	KeyId     string `json:"key_id"`
+	Archive   string `json:"archive"` // base name of the archive
+	SHA256    string `json:"sha256"`  // hex, of the stored bytes
+	SignedAt  int64  `json:"signed_at"`
+	Signature string `json:"signature"` // base64
+}
+
+func signatureName(name string) string {
+	return name + signatureExt
+}
+
+// KeyFingerprint the identifier of a public key without configured one
+func KeyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// keyId the identifier of the signing key
+func (s *ArchiveSigning) keyId() string {
+	if s.KeyId != "" {
+		return s.KeyId
+	}
+	return KeyFingerprint(s.Key.Public().(ed25519.PublicKey))
+}
+
+// publicKey the trusted public key of id
+func (s *ArchiveSigning) publicKey(id string) (ed25519.PublicKey, bool) {
+	if s.Key != nil && id == s.keyId() {
+		return s.Key.Public().(ed25519.PublicKey), true
+	}
+	pub, ok := s.Trusted[id]
+	return pub, ok
+}
+
+// signedMessage what the signature covers, every field but the signature itself
+func (s ArchiveSignature) signedMessage() []byte {
+	return []byte(fmt.Sprintf("billionmail-archive-signature v%d\nalgorithm=%s\nkey_id=%s\narchive=%s\nsha256=%s\nsigned_at=%d\n",
+		s.Version, s.Algorithm, s.KeyId, s.Archive, s.SHA256, s.SignedAt))
+}
+
+// sign returns the signature of the archive name whose stored bytes hash to sum
+func (s *ArchiveSigning) sign(name, sum string) ArchiveSignature {
+	sig := ArchiveSignature{
+		Version:   1,
+		Algorithm: signatureAlgorithm,
+		KeyId:     s.keyId(),
+		Archive:   path.Base(name),
+		SHA256:    sum,
+		SignedAt:  timeNow().Unix(),
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.Key, sig.signedMessage()))
+	return sig
+}
+
+// verify checks the signature of the archive name whose stored bytes hash to sum
+func (s *ArchiveSigning) verify(sig ArchiveSignature, name, sum string) error {
+	if sig.Version != 1 || sig.Algorithm != signatureAlgorithm {
+		return &corruptionError{reason: fmt.Sprintf("unsupported signature %s v%d", sig.Algorithm, sig.Version)}
+	}
+	if sig.Archive != path.Base(name) {
+		return &corruptionError{reason: fmt.Sprintf("signature of %s, not %s", sig.Archive, path.Base(name))}
+	}
+	if !strings.EqualFold(sig.SHA256, sum) {
+		return &corruptionError{reason: fmt.Sprintf("checksum %s, signed %s", sum, sig.SHA256)}
+	}
+
+	pub, ok := s.publicKey(sig.KeyId)
+	if !ok {
+		return fmt.Errorf("signing key %s is not trusted", sig.KeyId)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(pub, sig.signedMessage(), raw) {
+		return &corruptionError{reason: fmt.Sprintf("invalid signature of key %s", sig.KeyId)}
+	}
+	return nil
+}
+
+// ConfiguredSigning the keys set in the configuration: log_maintenance.signing.key_file
+// the PEM PKCS #8 Ed25519 private key, log_maintenance.signing.key_id its identifier and
+// log_maintenance.signing.trusted_keys_dir a directory of the PEM public keys of the
+// retired keys, named <key_id>.pub. Nil when no key is set
+func ConfiguredSigning(ctx context.Context) (*ArchiveSigning, error) {
+	keyFile, err := g.Cfg().Get(ctx, signingKeyConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	trustedDir, err := g.Cfg().Get(ctx, signingTrustedConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	if keyFile.String() == "" && trustedDir.String() == "" {
+		return nil, nil
+	}
+
+	s := &ArchiveSigning{}
+	if keyFile.String() != "" {
+		if s.Key, err = LoadSigningKey(keyFile.String()); err != nil {
+			return nil, err
+		}
+		if id, err := g.Cfg().Get(ctx, signingKeyIdConfigKey); err == nil {
+			s.KeyId = strings.TrimSpace(id.String())
+		}
+	}
+	if trustedDir.String() != "" {
+		if s.Trusted, err = LoadTrustedKeys(trustedDir.String()); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// LoadSigningKey reads a PEM PKCS #8 Ed25519 private key
+func LoadSigningKey(file string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", file)
+	}
+	return private, nil
+}
+
+// LoadTrustedKeys reads the PEM public keys <key_id>.pub of dir
+func LoadTrustedKeys(dir string) (map[string]ed25519.PublicKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]ed25519.PublicKey)
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".pub")
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		file := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM block", file)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an Ed25519 key", file)
+		}
+		keys[id] = public
+	}
+	return keys, nil
+}
+
+// signing the keys of the run, loaded once. A configuration that cannot be loaded fails
+// every archive stored, none is left unsigned
+func (m *maintenanceRun) signing(ctx context.Context) (*ArchiveSigning, error) {
+	m.signingOnce.Do(func() {
+		if m.cfg.Signing != nil {
+			m.signingKeys, m.signingErr = m.cfg.Signing(ctx)
+		}
+		if m.signingErr != nil {
+			g.Log().Errorf(ctx, "Failed to load the archive signing keys: %v", m.signingErr)
+		}
+	})
+	return m.signingKeys, m.signingErr
+}
+
+// signArchive writes the signature of the archive name whose stored bytes hash to sum,
+// when a signing key is configured
+func (m *maintenanceRun) signArchive(ctx context.Context, name, sum string) error {
+	s, err := m.signing(ctx)
+	if err != nil {
+		return fmt.Errorf("sign %s: %w", name, err)
+	}
+	if s == nil || s.Key == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(s.sign(name, sum))
+	if err != nil {
+		return err
+	}
+	return m.cfg.Sink.Put(ctx, signatureName(name), strings.NewReader(string(data)+"\n"))
+}
+
+// signedMarker the manifest line of an archive signed next, empty without signing key
+func (m *maintenanceRun) signedMarker(ctx context.Context) (string, error) {
+	s, err := m.signing(ctx)
+	if err != nil || s == nil || s.Key == nil {
+		return "", err
+	}
+	return fmt.Sprintf("%skey_id=%s\n", signedPrefix, s.keyId()), nil
+}
+
+// expectsSignature tells whether the manifest of an archive records it was stored signed
+func (m *maintenanceRun) expectsSignature(ctx context.Context, name string) (bool, error) {
+	data, ok, err := m.readManifestData(ctx, name)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), signedPrefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// missingSignature the error of an archive without signature, a corruption when its
+// manifest expects one
+func (m *maintenanceRun) missingSignature(ctx context.Context, name string) error {
+	expected, err := m.expectsSignature(ctx, name)
+	if err != nil {
+		return err
+	}
+	if expected {
+		return &corruptionError{reason: "missing signature " + signatureName(name)}
+	}
+	return errNoSignature
+}
+
+// readSignature the signature of an archive, errNoSignature when it has none
+func (m *maintenanceRun) readSignature(ctx context.Context, name string) (ArchiveSignature, error) {
+	var sig ArchiveSignature
+
+	exists, err := m.cfg.Sink.Exists(ctx, signatureName(name))
+	if err != nil {
+		return sig, err
+	}
+	if !exists {
+		return sig, errNoSignature
+	}
+
+	rc, err := m.cfg.Sink.Open(ctx, signatureName(name))
+	if err != nil {
+		return sig, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return sig, err
+	}
+	if err = json.Unmarshal(data, &sig); err != nil {
+		return sig, &corruptionError{reason: "malformed signature " + signatureName(name)}
+	}
+	return sig, nil
+}
+
+// verifySignature checks the signature of an archive against its stored bytes
+func (m *maintenanceRun) verifySignature(ctx context.Context, name string) (ArchiveSignature, error) {
+	s, err := m.signing(ctx)
+	if err != nil {
+		return ArchiveSignature{}, err
+	}
+
+	sig, err := m.readSignature(ctx, name)
+	if errors.Is(err, errNoSignature) {
+		return sig, m.missingSignature(ctx, name)
+	}
+	if err != nil {
+		return sig, err
+	}
+	if s == nil {
+		return sig, fmt.Errorf("no signing keys configured to verify %s", name)
+	}
+
+	rc, err := m.cfg.Sink.Open(ctx, name)
+	if err != nil {
+		return sig, err
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, rc); err != nil {
+		return sig, err
+	}
+	return sig, s.verify(sig, name, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// checkSignature checks the signature of an archive whose stored bytes were found to hash
+// to sum when keys are configured. An archive without signature passes unless its
+// manifest expects one
+func (m *maintenanceRun) checkSignature(ctx context.Context, name, sum string) error {
+	sig, err := m.readSignature(ctx, name)
+	if errors.Is(err, errNoSignature) {
+		if err = m.missingSignature(ctx, name); errors.Is(err, errNoSignature) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	s, err := m.signing(ctx)
+	if err != nil || s == nil {
+		return err
+	}
+	return s.verify(sig, name, sum)
+}
+
+// VerifyArchiveSignature checks the signature next to the archive at path, a file of the
+// logs tree of the default configuration, with the configured keys. It returns the
+// signature checked
+func VerifyArchiveSignature(path string) (ArchiveSignature, error) {
+	cfg := DefaultService().Config()
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ArchiveSignature{}, err
+	}
+	rel, err := filepath.Rel(cfg.BasePath, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ArchiveSignature{}, fmt.Errorf("%s is not in the logs directory %s", path, cfg.BasePath)
+	}
+
+	ctx := context.Background()
+	return newMaintenanceRun(ctx, cfg).verifySignature(ctx, filepath.ToSlash(rel))
+}
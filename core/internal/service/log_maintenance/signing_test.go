@@ -0,0 +1,120 @@
+package log_maintenance
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveSigning(t *testing.T) {
+	_, oldKey, _ := ed25519.GenerateKey(nil)
+	_, newKey, _ := ed25519.GenerateKey(nil)
+
+	base := t.TempDir()
+	newStandardLog(t, base, "access-20250115.log", []byte("signed\n"))
+	cfg := MaintenanceConfig{BasePath: base, DateSource: LogDateFromName, Signing: func(ctx context.Context) (*ArchiveSigning, error) {
+		return &ArchiveSigning{KeyId: "2025-01", Key: oldKey}, nil
+	}}
+	if r := RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	name := "core/access-20250115.log.gz"
+	verify := func(signing *ArchiveSigning) (ArchiveSignature, error) {
+		m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base), Signing: func(ctx context.Context) (*ArchiveSigning, error) {
+			return signing, nil
+		}})
+		return m.verifySignature(context.Background(), name)
+	}
+
+	sig, err := verify(&ArchiveSigning{KeyId: "2025-01", Key: oldKey})
+	if err != nil {
+		t.Fatalf("signature of the new archive: %v", err)
+	}
+	if sig.KeyId != "2025-01" || sig.Archive != "access-20250115.log.gz" {
+		t.Errorf("signature = %+v", sig)
+	}
+
+	// After a rotation the archives of the retired key verify with its public key only
+	rotated := &ArchiveSigning{Key: newKey, Trusted: map[string]ed25519.PublicKey{"2025-01": oldKey.Public().(ed25519.PublicKey)}}
+	if _, err = verify(rotated); err != nil {
+		t.Errorf("signature of the retired key: %v", err)
+	}
+	if _, err = verify(&ArchiveSigning{Key: newKey}); err == nil || isCorruption(err) {
+		t.Errorf("a signature of an untrusted key should fail unverified, got %v", err)
+	}
+
+	// A forged signature and an altered archive are corrupt
+	sigPath := filepath.Join(base, "core", "access-20250115.log.gz.sig")
+	data, err := os.ReadFile(sigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := strings.Replace(string(data), `"signed_at":`, `"signed_at":1`, 1)
+	if err = os.WriteFile(sigPath, []byte(forged), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verify(rotated); !isCorruption(err) {
+		t.Errorf("forged signature: %v", err)
+	}
+	if err = os.WriteFile(sigPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(base, "core", "access-20250115.log.gz"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0})
+	f.Close()
+	if _, err = verify(rotated); !isCorruption(err) {
+		t.Errorf("altered archive: %v", err)
+	}
+
+	// Without signing nothing is signed
+	newStandardLog(t, base, "access-20250116.log", []byte("unsigned\n"))
+	cfg.Signing = nil
+	if r := RunMaintenance(context.Background(), cfg); r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if _, err = os.Stat(filepath.Join(base, "core", "access-20250116.log.gz.sig")); !os.IsNotExist(err) {
+		t.Errorf("archive signed without signing configured: %v", err)
+	}
+
+	// An archive stored unsigned passes, one stored signed that lost its signature is corrupt
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base), Signing: func(ctx context.Context) (*ArchiveSigning, error) {
+		return rotated, nil
+	}})
+	unsigned := "core/access-20250116.log.gz"
+	if sum, _, err := m.readManifest(context.Background(), unsigned); err != nil {
+		t.Fatal(err)
+	} else if err = m.checkSignature(context.Background(), unsigned, sum); err != nil {
+		t.Errorf("archive stored unsigned: %v", err)
+	}
+	if _, err = m.verifySignature(context.Background(), unsigned); !errors.Is(err, errNoSignature) {
+		t.Errorf("signature of the archive stored unsigned: %v", err)
+	}
+
+	if err = os.Remove(sigPath); err != nil {
+		t.Fatal(err)
+	}
+	sum, _, err := m.readManifest(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.checkSignature(context.Background(), name, sum); !isCorruption(err) {
+		t.Errorf("archive stored signed without signature: %v", err)
+	}
+	if _, err = m.verifySignature(context.Background(), name); !isCorruption(err) {
+		t.Errorf("signature of the archive stored signed: %v", err)
+	}
+	// Even without keys to verify it
+	m = newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base)})
+	if err = m.checkSignature(context.Background(), name, sum); !isCorruption(err) {
+		t.Errorf("archive stored signed without signature nor keys: %v", err)
+	}
+}
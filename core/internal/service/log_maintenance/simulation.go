@@ -0,0 +1,250 @@
+package log_maintenance
+
+import (
+	"billionmail-core/internal/service/public"
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Simulation of the maintenance, to size the retention and see the IO a configuration
+// costs on the host. A synthetic logs tree of the shape of the scenario is written in a
+// temporary directory, then the whole maintenance runs on it with the settings under
+// test and its timing, the bytes it reclaimed and the most uploads it had in flight are
+// reported. The archives go to a local sink in the temporary directory whatever the
+// sink of the settings, and the external sources, roots, tenants and hooks of the
+// settings are left out so nothing outside of the tree is touched. The tree is removed
+// at the end, the run also serves as a regression guard of the pipeline throughput.
+
+// Defaults of an unset scenario
+const (
+	DefaultSimulationDays     = 14
+	DefaultSimulationLogBytes = 1 << 20
+)
+
+// defaultSimulationGroups groups of the synthetic logs when the scenario names none
+var defaultSimulationGroups = []string{"access", "error", "trace"}
+
+// SimulationScenario shape of the synthetic logs tree and the settings it is run with
+type SimulationScenario struct {
+	Groups   []string // groups of the standard logs, named <group>-YYYYMMDD.log, access, error and trace when empty
+	Days     int      // daily logs of each group, DefaultSimulationDays when unset
+	LogBytes int64    // size of each standard log, DefaultSimulationLogBytes when unset
+
+	// OperationLogDays days of operation logs, of OperationLogBytes each, none when unset
+	OperationLogDays  int
+	OperationLogBytes int64
+
+	// Dir parent of the temporary tree, the system temporary directory when empty. To
+	// measure the disks of the logs it should be on their volume
+	Dir string
+
+	// Config settings under test, those of the scheduled maintenance when nil
+	Config *MaintenanceConfig
+
+	// Window the maintenance window the run should complete in, MaxRuntime of the
+	// settings when unset
+	Window time.Duration
+
+	// Seed of the synthetic content, runs with the same seed write the same tree
+	Seed int64
+}
+
+// SimulationReport outcome of a simulation
+type SimulationReport struct {
+	Logs  int   `json:"logs"`  // synthetic logs written
+	Bytes int64 `json:"bytes"` // their size
+
+	Setup    time.Duration `json:"setup"`    // writing of the tree
+	Duration time.Duration `json:"duration"` // the maintenance run
+
+	// Throughput bytes processed per second of the run
+	Throughput     float64 `json:"throughput"`
+	BytesReclaimed int64   `json:"bytes_reclaimed"`
+	PeakUploads    int     `json:"peak_uploads"` // most uploads in flight at once
+
+	// Window the maintenance window, WithinWindow whether the run completed in it. Always
+	// true without a window
+	Window       time.Duration `json:"window"`
+	WithinWindow bool          `json:"within_window"`
+
+	Result MaintenanceResult `json:"result"`
+}
+
+// SimulateMaintenance runs the maintenance on the synthetic tree of scenario
+func SimulateMaintenance(ctx context.Context, scenario SimulationScenario) (SimulationReport, error) {
+	var report SimulationReport
+
+	cfg := DefaultService().Config()
+	if scenario.Config != nil {
+		cfg = *scenario.Config
+	}
+	if len(scenario.Groups) == 0 {
+		scenario.Groups = defaultSimulationGroups
+	}
+	if scenario.Days <= 0 {
+		scenario.Days = DefaultSimulationDays
+	}
+	if scenario.LogBytes <= 0 {
+		scenario.LogBytes = DefaultSimulationLogBytes
+	}
+	if scenario.OperationLogDays > 0 && scenario.OperationLogBytes <= 0 {
+		scenario.OperationLogBytes = scenario.LogBytes
+	}
+	if scenario.Window <= 0 {
+		scenario.Window = cfg.MaxRuntime
+	}
+
+	groups := make([]LogGroup, 0, len(scenario.Groups))
+	for _, name := range scenario.Groups {
+		group, err := NewLogGroup(name, "^"+regexp.QuoteMeta(name)+"-")
+		if err != nil {
+			return report, err
+		}
+		groups = append(groups, group)
+	}
+
+	base, err := os.MkdirTemp(scenario.Dir, "billionmail-log-simulation-")
+	if err != nil {
+		return report, err
+	}
+	defer os.RemoveAll(base)
+
+	// The settings under test on a private tree, unbounded so the run is measured whole
+	cfg.BasePath = base
+	cfg.CreateBase = false
+	cfg.FilePerm = permOrDefault(cfg.FilePerm, DefaultFilePerm)
+	cfg.DirPerm = permOrDefault(cfg.DirPerm, DefaultDirPerm)
+	cfg.Sink = &LocalSink{Root: base, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm, Fsync: cfg.Fsync, FsyncDir: cfg.Fsync}
+	cfg.RestoreDir = filepath.Join(base, "restore")
+	cfg.MaxRuntime = 0
+	cfg.MinFreeBytes = 0
+	cfg.LogGroups = groups
+	cfg.LogDirs = nil
+	cfg.MergeGroups = nil
+	cfg.ExternalSources = nil
+	cfg.Roots = nil
+	cfg.Tenants = nil
+	cfg.CompressionSlices = nil
+	cfg.ActiveLog = nil
+	cfg.Role = nil
+	cfg.Standby = ""
+	cfg.Audit = nil
+	cfg.Progress = nil
+
+	if err = validateConfig(cfg); err != nil {
+		return report, err
+	}
+
+	started := time.Now()
+	if err = writeSimulationTree(ctx, cfg, scenario, &report); err != nil {
+		return report, fmt.Errorf("failed to write the synthetic logs: %w", err)
+	}
+	report.Setup = time.Since(started)
+
+	result := RunMaintenance(ctx, cfg)
+
+	report.Result = result
+	report.Duration = result.Duration
+	report.BytesReclaimed = result.BytesReclaimed
+	report.PeakUploads = result.PeakUploads
+	if result.Duration > 0 {
+		report.Throughput = float64(result.BytesProcessed) / result.Duration.Seconds()
+	}
+	report.Window = scenario.Window
+	report.WithinWindow = scenario.Window <= 0 || (result.Duration <= scenario.Window && !result.Partial)
+
+	return report, result.Err()
+}
+
+// writeSimulationTree writes the dated standard logs of each group in core, all older
+// than a day, and the days of operation logs past their retention
+func writeSimulationTree(ctx context.Context, cfg MaintenanceConfig, scenario SimulationScenario, report *SimulationReport) error {
+	rng := rand.New(rand.NewSource(scenario.Seed))
+	now := time.Now()
+
+	dir := filepath.Join(cfg.BasePath, "core")
+	if err := os.MkdirAll(dir, cfg.DirPerm); err != nil {
+		return err
+	}
+
+	for day := 1; day <= scenario.Days; day++ {
+		date := now.AddDate(0, 0, -day)
+		for _, group := range scenario.Groups {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			path := filepath.Join(dir, group+"-"+date.Format("20060102")+".log")
+			if err := writeSimulationLog(path, date, scenario.LogBytes, cfg.FilePerm, rng); err != nil {
+				return err
+			}
+			report.Logs++
+			report.Bytes += scenario.LogBytes
+		}
+	}
+
+	location := cfg.OperationLogLocation
+	if location == nil {
+		location = public.OperationLogLocation()
+	}
+	cutoff := operationLogCutoff(now.In(location))
+	for day := 1; day <= scenario.OperationLogDays; day++ {
+		date := cutoff.AddDate(0, 0, -day)
+		opDir := filepath.Join(dir, "operation_log", date.Format("2006-01-02"))
+		if err := os.MkdirAll(opDir, cfg.DirPerm); err != nil {
+			return err
+		}
+		if err := writeSimulationLog(filepath.Join(opDir, "operation.json"), date, scenario.OperationLogBytes, cfg.FilePerm, rng); err != nil {
+			return err
+		}
+		report.Logs++
+		report.Bytes += scenario.OperationLogBytes
+	}
+
+	return nil
+}
+
+// simulationLevels levels of the synthetic log lines
+var simulationLevels = []string{"INFO", "INFO", "INFO", "DEBU", "WARN", "ERRO"}
+
+// writeSimulationLog writes a log of size bytes of timestamped lines with some random
+// content, compressing about like real logs, its modification time set to date
+func writeSimulationLog(path string, date time.Time, size int64, perm os.FileMode, rng *rand.Rand) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	var written int64
+	for i := 0; written < size; i++ {
+		at := day.Add(time.Duration(i) * 127 * time.Millisecond % (24 * time.Hour))
+		line := fmt.Sprintf("%s [%s] {%016x} request handled in %dms client=10.%d.%d.%d path=/api/v1/%x\n",
+			at.Format("2006-01-02 15:04:05.000"), simulationLevels[rng.Intn(len(simulationLevels))],
+			rng.Uint64(), rng.Intn(500), rng.Intn(256), rng.Intn(256), rng.Intn(256), rng.Intn(4096))
+		if rest := size - written; int64(len(line)) > rest {
+			line = line[:rest-1] + "\n"
+		}
+		n, err := w.WriteString(line)
+		written += int64(n)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(path, date, date)
+}
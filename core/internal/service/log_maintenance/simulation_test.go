@@ -0,0 +1,42 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSimulateMaintenance(t *testing.T) {
+	parent := t.TempDir()
+
+	report, err := SimulateMaintenance(context.Background(), SimulationScenario{
+		Groups:           []string{"access", "error"},
+		Days:             6,
+		LogBytes:         8192,
+		OperationLogDays: 2,
+		Dir:              parent,
+		Config:           &MaintenanceConfig{MaxConcurrentUploads: 3},
+		Window:           time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Logs != 14 || report.Bytes != 14*8192 {
+		t.Errorf("synthetic tree of %d logs, %d bytes, want 14 logs of 8192 bytes", report.Logs, report.Bytes)
+	}
+	if report.BytesReclaimed <= 0 || report.Result.FilesDone == 0 {
+		t.Errorf("simulation reclaimed %d bytes over %d files, want the old logs handled", report.BytesReclaimed, report.Result.FilesDone)
+	}
+	if report.PeakUploads < 1 || report.PeakUploads > 3 {
+		t.Errorf("peak uploads %d, want within the 3 concurrent uploads", report.PeakUploads)
+	}
+	if !report.WithinWindow || report.Throughput <= 0 {
+		t.Errorf("simulation report %+v, want the run within its window", report)
+	}
+
+	if entries, _ := os.ReadDir(parent); len(entries) != 0 {
+		t.Errorf("synthetic tree left behind: %d entries", len(entries))
+	}
+}
@@ -0,0 +1,251 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveSink destination of the archives produced by log maintenance.
+// Names are slash separated paths relative to the logs base directory,
+// e.g. "core/access-20250101.log.gz", so every sink can map them to its own
+// layout (a local directory, a bucket prefix, a remote path).
+type ArchiveSink interface {
+	// Put stores everything read from r under name, replacing any existing archive
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Exists reports whether an archive is stored under name
+	Exists(ctx context.Context, name string) (bool, error)
+	// Delete removes the archive stored under name
+	Delete(ctx context.Context, name string) error
+	// Open opens the archive stored under name for reading
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// LocalSink stores archives on the local filesystem below Root
+type LocalSink struct {
+	Root     string
+	FilePerm os.FileMode // mode of the archives, DefaultFilePerm when unset
+	DirPerm  os.FileMode // mode of the created directories, DefaultDirPerm when unset
+
+	// Fsync flushes every archive to disk before it is renamed into place, FsyncDir
+	// also flushes its directory after the rename so the new name survives a crash.
+	// Both cost a disk flush per archive, see MaintenanceConfig.Fsync
+	Fsync    bool
+	FsyncDir bool
+
+	// Exclude directories below Root, relative with slashes, left out of List: the
+	// archives there belong to other logs trees
+	Exclude []string
+}
+
+// NewLocalSink creates a sink writing next to the source logs under root
+func NewLocalSink(root string) *LocalSink {
+	return &LocalSink{Root: root, FilePerm: DefaultFilePerm, DirPerm: DefaultDirPerm}
+}
+
+// Path resolves an archive name to a path below Root
+func (s *LocalSink) Path(name string) (string, error) {
+	p := filepath.Join(s.Root, filepath.FromSlash(name))
+
+	rel, err := filepath.Rel(s.Root, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive name %q escapes the sink root", name)
+	}
+
+	return p, nil
+}
+
+// Put writes the archive to a temporary file first and renames it into place,
+// so a crash never leaves a truncated archive under the final name
+func (s *LocalSink) Put(ctx context.Context, name string, r io.Reader) error {
+	p, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = mkdirAll(filepath.Dir(p), permOrDefault(s.DirPerm, DefaultDirPerm)); err != nil {
+		return err
+	}
+
+	tmp := p + ".partial"
+	f, err := createFile(tmp, permOrDefault(s.FilePerm, DefaultFilePerm))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil && s.Fsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, p); err != nil {
+		return err
+	}
+
+	if s.FsyncDir {
+		return syncDir(filepath.Dir(p))
+	}
+
+	return nil
+}
+
+// syncDir flushes a directory, making the entries renamed into it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Exists reports whether the archive file exists
+func (s *LocalSink) Exists(ctx context.Context, name string) (bool, error) {
+	p, err := s.Path(name)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Delete removes the archive file, a missing file is not an error
+func (s *LocalSink) Delete(ctx context.Context, name string) error {
+	p, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Open opens the archive file
+func (s *LocalSink) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	p, err := s.Path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p)
+}
+
+// List returns the names of the archives below Root, in-progress files are skipped
+func (s *LocalSink) List(ctx context.Context) ([]string, error) {
+	names := make([]string, 0)
+
+	err := filepath.WalkDir(s.Root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if rel, err := filepath.Rel(s.Root, path); err == nil && s.excluded(filepath.ToSlash(rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".partial") {
+			return nil
+		}
+
+		if _, ok := CodecOf(path); !ok && !strings.HasSuffix(path, rollupExt) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			return err
+		}
+
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+
+	return names, err
+}
+
+// excluded reports whether the directory rel is one of Exclude
+func (s *LocalSink) excluded(rel string) bool {
+	for _, dir := range s.Exclude {
+		if rel == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Stat returns the size of the archive file
+func (s *LocalSink) Stat(ctx context.Context, name string) (ArchiveInfo, error) {
+	p, err := s.Path(name)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+
+	return ArchiveInfo{Size: info.Size()}, nil
+}
+
+// ModTime returns the modification time of the archive file
+func (s *LocalSink) ModTime(ctx context.Context, name string) (time.Time, error) {
+	p, err := s.Path(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+// SetModTime sets the access and modification time of the archive file
+func (s *LocalSink) SetModTime(ctx context.Context, name string, t time.Time) error {
+	p, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(p, t, t)
+}
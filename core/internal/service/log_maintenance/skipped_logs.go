@@ -0,0 +1,115 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Standard logs that are not compressed when due. Zero-byte logs would only become
+// near-empty archives, they are skipped or deleted. Logs held locked by their writer
+// are skipped instead of failing the run, retried after the standard logs are done,
+// and those still locked are reported in the result and left for the next run.
+
+// Handling of the zero-byte logs
+const (
+	EmptyLogsSkip     = "skip"     // left as they are, default
+	EmptyLogsDelete   = "delete"   // deleted once due for compression
+	EmptyLogsCompress = "compress" // compressed like any other log
+)
+
+const (
+	DefaultLockRetries    = 2
+	DefaultLockRetryDelay = 2 * time.Second
+)
+
+var errLocked = errors.New("locked by its writer")
+
+// lockedLog a standard log skipped because it was locked
+type lockedLog struct {
+	path string
+	info os.FileInfo
+}
+
+// checkUnlocked returns errLocked when another process holds an exclusive lock on path
+func checkUnlocked(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return errLocked
+		}
+		// Filesystems without flock support
+		return nil
+	}
+
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// isLocked reports whether err is a failure to access a file held by another process
+func isLocked(err error) bool {
+	return errors.Is(err, errLocked) ||
+		errors.Is(err, syscall.EWOULDBLOCK) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.ETXTBSY)
+}
+
+// retryLocked compresses the logs skipped as locked again, after a delay each round
+func (m *maintenanceRun) retryLocked(ctx context.Context) {
+	retries := m.cfg.LockRetries
+	if retries == 0 {
+		retries = DefaultLockRetries
+	}
+	delay := m.cfg.LockRetryDelay
+	if delay <= 0 {
+		delay = DefaultLockRetryDelay
+	}
+
+	for round := 0; round < retries && len(m.locked) > 0; round++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		pending := m.locked
+		m.locked = nil
+
+		for _, l := range pending {
+			if m.outOfTime() || ctx.Err() != nil {
+				m.mu.Lock()
+				m.locked = append(m.locked, l)
+				m.mu.Unlock()
+				continue
+			}
+			m.compressStandardLog(ctx, l.path, l.info)
+		}
+		m.waitUploads()
+	}
+}
+
+// lockedPaths logs still locked at the end of the run, counted as done
+func (m *maintenanceRun) lockedPaths(ctx context.Context) []string {
+	if len(m.locked) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(m.locked))
+	for _, l := range m.locked {
+		g.Log().Warningf(ctx, "Log %s is locked by its writer, left for the next run", l.path)
+		paths = append(paths, l.path)
+		m.fileDone(l.path, 0, 0)
+	}
+
+	return paths
+}
@@ -0,0 +1,45 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLockedLogSkippedAndRetried(t *testing.T) {
+	base := t.TempDir()
+	path := newStandardLog(t, base, "error-20200101.log", []byte("2020-01-01 00:00:00 line\n"))
+
+	writer, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err = syscall.Flock(int(writer.Fd()), syscall.LOCK_EX); err != nil {
+		t.Skipf("flock unsupported: %v", err)
+	}
+
+	cfg := MaintenanceConfig{BasePath: base, LockRetries: 1, LockRetryDelay: time.Millisecond}
+
+	r := RunMaintenance(context.Background(), cfg)
+	if len(r.Locked) != 1 || r.Locked[0] != path || r.Errors != 0 {
+		t.Fatalf("locked %v with %d errors, want %s and no error", r.Locked, r.Errors, path)
+	}
+	if _, err = os.Stat(path); err != nil {
+		t.Fatalf("locked log should be kept: %v", err)
+	}
+
+	if err = syscall.Flock(int(writer.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatal(err)
+	}
+
+	r = RunMaintenance(context.Background(), cfg)
+	if len(r.Locked) != 0 {
+		t.Fatalf("unlocked log still reported locked: %v", r.Locked)
+	}
+	if _, err = os.Stat(path + ".gz"); err != nil {
+		t.Fatalf("unlocked log should be archived: %v", err)
+	}
+}
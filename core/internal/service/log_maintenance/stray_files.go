@@ -0,0 +1,122 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Stray files of the log directories: the swap and backup files of the editors (.swp,
+// .swo, ~, #name#), temporary files (.tmp) and the hidden files other processes leave
+// there. They are in no log group, so retention never removes them. With StrayFiles set
+// the files directly in the standard log directories below BasePath are checked and,
+// by the policy, listed in the result with a warning or deleted once older than
+// StrayFileAge. The hidden logs (.name.log) and the files of the maintenance are never
+// stray, nor anything in the external sources.
+
+// Handling of the stray files
+const (
+	StrayFilesIgnore = "ignore" // not looked for, default
+	StrayFilesWarn   = "warn"   // listed in the result with a warning
+	StrayFilesDelete = "delete" // deleted once older than StrayFileAge, listed in the result
+)
+
+// DefaultStrayFileAge age of the stray files deleted when StrayFileAge is unset
+const DefaultStrayFileAge = 7 * 24 * time.Hour
+
+// straySuffixes name endings of the editor and temporary files
+var straySuffixes = []string{"~", ".swp", ".swo", ".swx", ".tmp"}
+
+// maintenanceFiles hidden files the maintenance writes in the logs tree
+var maintenanceFiles = map[string]bool{
+	archiveIndexFile:    true,
+	backlogFile:         true,
+	historyFile:         true,
+	incidentsFile:       true,
+	partialArchivesFile: true,
+	pinsFile:            true,
+	verifyStateFile:     true,
+}
+
+// StrayFile a stray file found in a log directory
+type StrayFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Deleted bool      `json:"deleted"` // deleted by StrayFilesDelete
+}
+
+// strayFile reports whether a file name is a stray file
+func strayFile(name string) bool {
+	if maintenanceFiles[name] || strings.HasPrefix(name, ".write-probe-") {
+		return false
+	}
+	for _, suffix := range straySuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	if len(name) > 2 && strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#") {
+		return true
+	}
+	// A hidden log is a log
+	return strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".log")
+}
+
+// handleStrayFiles applies the StrayFiles policy to the files directly in dir
+func (m *maintenanceRun) handleStrayFiles(ctx context.Context, dir string) {
+	if m.cfg.StrayFiles == "" || m.cfg.StrayFiles == StrayFilesIgnore || !within(m.cfg.BasePath, dir) || m.externalSourceOf(dir) != nil {
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	age := m.cfg.StrayFileAge
+	if age <= 0 {
+		age = DefaultStrayFileAge
+	}
+	cutoff := timeNow().Add(-age)
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strayFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		stray := StrayFile{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+
+		if m.cfg.StrayFiles == StrayFilesDelete && info.ModTime().Before(cutoff) && !m.pins[filepath.Clean(path)] {
+			rule := fmt.Sprintf("stray files are deleted after %s", age)
+			if trashed, err := m.discardLog(path); err != nil {
+				g.Log().Warningf(ctx, "Failed to delete the stray file %s: %v", path, err)
+				m.fail(ErrDelete, path, err)
+			} else {
+				stray.Deleted = true
+				action := AuditDelete
+				if trashed != "" {
+					action = AuditTrash
+				}
+				m.audit(ctx, action, path, info.Size(), rule, "")
+				g.Log().Infof(ctx, "Deleted the stray file %s", path)
+			}
+		} else {
+			g.Log().Warningf(ctx, "Stray file %s in a log directory, %d bytes", path, info.Size())
+		}
+
+		m.mu.Lock()
+		m.stray = append(m.stray, stray)
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,70 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStrayFiles(t *testing.T) {
+	base := t.TempDir()
+
+	newStandardLog(t, base, "error-20250301.log", []byte("error\n"))
+	swap := newStandardLog(t, base, ".error-20250301.log.swp", []byte("swap"))
+	backup := newStandardLog(t, base, "notes.txt~", []byte("backup"))
+	hidden := newStandardLog(t, base, ".audit.log", []byte("hidden log\n"))
+	recent := newStandardLog(t, base, "upload.tmp", []byte("in progress"))
+	if err := os.Chtimes(recent, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Warned about, left in place
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, StrayFiles: StrayFilesWarn})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if len(r.Stray) != 3 {
+		t.Fatalf("stray files %+v, want the swap, backup and temporary files", r.Stray)
+	}
+	for _, path := range []string{swap, backup, recent} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("stray file %s should be left in place by the warning: %v", path, err)
+		}
+	}
+
+	// Deleted once older than the threshold
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, StrayFiles: StrayFilesDelete, StrayFileAge: 24 * time.Hour})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	for _, path := range []string{swap, backup} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("old stray file %s should be deleted, stat err: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent stray file should be kept: %v", err)
+	}
+	deleted := 0
+	for _, s := range r.Stray {
+		if s.Deleted {
+			deleted++
+		}
+	}
+	if len(r.Stray) != 3 || deleted != 2 {
+		t.Errorf("stray files %+v, want 3 listed and 2 deleted", r.Stray)
+	}
+
+	// A hidden log is no stray file
+	if _, err := os.Stat(hidden); err != nil {
+		t.Errorf("hidden log should not be deleted: %v", err)
+	}
+	if strayFile(archiveIndexFile) || strayFile(".audit.log") || !strayFile(".lock") {
+		t.Error("the files of the maintenance and the hidden logs should not be stray")
+	}
+
+	if err := validateConfig(MaintenanceConfig{StrayFiles: "purge"}); err == nil {
+		t.Error("unknown stray files handling should be rejected")
+	}
+}
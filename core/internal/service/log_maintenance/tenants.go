@@ -0,0 +1,139 @@
+package log_maintenance
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Logs of the tenants of a shared deployment. The logs of a tenant are written below
+// tenants/<tenant>/ of BasePath, laid out as a logs tree of their own (core, core/out
+// and core/operation_log). With Tenants set, each tenant directory is maintained as a
+// separate tree like a root of Roots, with its own archives, index and history and the
+// retention of its TenantPolicy, so the policy of a tenant only ever applies to its own
+// logs. The tree of BasePath leaves the tenants directory out of its archives, its
+// emergency cleanup never deletes the archives of a tenant. The adaptive retention is
+// not applied to the tenants, their contracted retention holds whatever the free space.
+
+const tenantsDir = "tenants"
+
+// TenantPolicy retention of the logs of a tenant, its zero fields fall back to the
+// settings of the configuration
+type TenantPolicy struct {
+	Retention          RetentionPolicy
+	RetentionOverrides map[string]RetentionPolicy
+}
+
+// TenantLogPath the logs tree of a tenant below basePath
+func TenantLogPath(basePath, tenant string) string {
+	return filepath.Join(basePath, tenantsDir, tenant)
+}
+
+// validTenant reports whether name can be a tenant directory
+func validTenant(name string) bool {
+	return logGroupNamePattern.MatchString(name) && !strings.HasPrefix(name, ".")
+}
+
+// excludeTenants the sink of the tree of BasePath, leaving the tenants directory out
+// when the archives are stored locally next to the logs
+func excludeTenants(cfg MaintenanceConfig) ArchiveSink {
+	switch sink := cfg.Sink.(type) {
+	case nil:
+		return &LocalSink{
+			Root:     cfg.BasePath,
+			FilePerm: permOrDefault(cfg.FilePerm, DefaultFilePerm),
+			DirPerm:  permOrDefault(cfg.DirPerm, DefaultDirPerm),
+			Fsync:    cfg.Fsync,
+			FsyncDir: cfg.Fsync,
+			Exclude:  []string{tenantsDir},
+		}
+	case *LocalSink:
+		if filepath.Clean(sink.Root) == filepath.Clean(cfg.BasePath) {
+			local := *sink
+			local.Exclude = append(append([]string(nil), sink.Exclude...), tenantsDir)
+			return &local
+		}
+	}
+	return cfg.Sink
+}
+
+// tenantTrees the logs trees of the tenant directories found below BasePath, sorted by
+// tenant. A tenant missing from tenants gets the retention of the configuration
+func tenantTrees(cfg MaintenanceConfig, tenants map[string]TenantPolicy) []logTree {
+	entries, err := os.ReadDir(filepath.Join(cfg.BasePath, tenantsDir))
+	if err != nil {
+		return nil
+	}
+
+	var trees []logTree
+	for _, entry := range entries {
+		if !entry.IsDir() || !validTenant(entry.Name()) {
+			continue
+		}
+
+		tenant := entry.Name()
+		policy := tenants[tenant]
+
+		c := cfg
+		c.BasePath = TenantLogPath(cfg.BasePath, tenant)
+		c.Sink = nil
+		c.AdaptiveHeadroom = 0
+		c.MergeGroups = nil
+		c.CompressionSlices = nil
+
+		if policy.Retention != (RetentionPolicy{}) {
+			c.Retention = policy.Retention
+		}
+		if policy.RetentionOverrides != nil {
+			c.RetentionOverrides = policy.RetentionOverrides
+		}
+
+		trees = append(trees, logTree{cfg: c, tenant: tenant})
+	}
+
+	sort.Slice(trees, func(i, j int) bool { return trees[i].tenant < trees[j].tenant })
+	return trees
+}
+
+// validateTenants rejects malformed tenant names and negative retentions
+func validateTenants(cfg MaintenanceConfig) error {
+	for tenant, policy := range cfg.Tenants {
+		if !validTenant(tenant) {
+			return fmt.Errorf("invalid tenant %q", tenant)
+		}
+
+		c := cfg
+		c.Retention, c.RetentionOverrides = policy.Retention, policy.RetentionOverrides
+		if err := validateRetention(c); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+// TenantLogDiskUsage returns the usage of the logs tree of each tenant of the scheduled
+// maintenance, keyed by tenant, or of the one tenant given
+func TenantLogDiskUsage(ctx context.Context, tenant string) (map[string]LogDiskUsageReport, error) {
+	cfg := DefaultService().Config()
+	reports := make(map[string]LogDiskUsageReport)
+	if cfg.Tenants == nil {
+		return reports, nil
+	}
+
+	for _, tree := range tenantTrees(cfg, cfg.Tenants) {
+		if tenant != "" && tree.tenant != tenant {
+			continue
+		}
+
+		report, err := logDiskUsage(ctx, tree.cfg)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tree.tenant, err)
+		}
+		reports[tree.tenant] = report
+	}
+
+	return reports, nil
+}
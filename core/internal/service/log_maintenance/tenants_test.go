@@ -0,0 +1,81 @@
+package log_maintenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTenantLogTrees(t *testing.T) {
+	base := t.TempDir()
+	trees := map[string]string{"": base}
+	logs := make(map[string][]string)
+	for _, tenant := range []string{"acme", "globex"} {
+		trees[tenant] = TenantLogPath(base, tenant)
+	}
+	for tenant, tree := range trees {
+		for i := 0; i < 3; i++ {
+			path := newStandardLog(t, tree, "error-"+time.Date(2025, 3, 1+i, 0, 0, 0, 0, time.Local).Format("20060102")+".log", []byte("line\n"))
+			old := time.Now().AddDate(0, 0, -5+i)
+			os.Chtimes(path, old, old)
+			logs[tenant] = append(logs[tenant], path)
+		}
+		newStandardLog(t, tree, "error-20240101.log.gz", []byte("archive"))
+	}
+
+	// Only the volume of the tree of BasePath is short of space
+	defer func(orig func(string) (int64, error)) { diskFree = orig }(diskFree)
+	diskFree = func(path string) (int64, error) {
+		if path == base {
+			return 0, nil
+		}
+		return 1 << 40, nil
+	}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{
+		BasePath:     base,
+		MinFreeBytes: 1,
+		Tenants:      map[string]TenantPolicy{"acme": {Retention: RetentionPolicy{FilesToKeep: 1}}},
+	})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+	if len(r.Roots) != 3 || r.Roots[1].Tenant != "acme" || r.Roots[2].Tenant != "globex" {
+		t.Fatalf("trees %+v", r.Roots)
+	}
+
+	// The retention of acme deletes its old logs only
+	for _, path := range logs["acme"][:2] {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("log %s beyond the retention of acme not deleted", path)
+		}
+	}
+	for _, tenant := range []string{"", "globex"} {
+		for _, path := range logs[tenant][:2] {
+			if _, err := os.Stat(path + ".gz"); err != nil {
+				t.Errorf("log %s not compressed: %v", path, err)
+			}
+		}
+	}
+
+	// The emergency cleanup of BasePath never reaches the archives of the tenants
+	if _, err := os.Stat(filepath.Join(base, "core", "error-20240101.log.gz")); !os.IsNotExist(err) {
+		t.Errorf("oldest archive of BasePath not deleted by the emergency cleanup")
+	}
+	for _, tenant := range []string{"acme", "globex"} {
+		if _, err := os.Stat(filepath.Join(trees[tenant], "core", "error-20240101.log.gz")); err != nil {
+			t.Errorf("archive of tenant %s deleted: %v", tenant, err)
+		}
+	}
+
+	usage, err := logDiskUsage(context.Background(), tenantTrees(MaintenanceConfig{BasePath: base}, nil)[1].cfg)
+	if err != nil || usage.BasePath != trees["globex"] || usage.Groups["error"] == nil || usage.Groups["error"].CompressedFiles < 3 {
+		t.Errorf("disk usage of globex %+v, %v", usage, err)
+	}
+
+	if err := validateConfig(MaintenanceConfig{Tenants: map[string]TenantPolicy{"../other": {}}}); err == nil {
+		t.Error("invalid tenant accepted")
+	}
+}
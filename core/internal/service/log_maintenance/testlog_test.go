@@ -0,0 +1,3 @@
+package log_maintenance
+
+import _ "billionmail-core/internal/testlog"
@@ -0,0 +1,210 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Staged deletion of the logs. With TrashGrace set the logs the retention deletes are
+// moved below .trash/<unix time of the run>/ of BasePath at their path relative to it,
+// a rename that is atomic and leaves nothing half done when the run is interrupted. The
+// sweep deletes a staged batch for good once it is older than the grace period, and the
+// oldest staged logs while the trash exceeds TrashMaxBytes. Until then a log can be
+// recovered by moving it back. The logs out of BasePath, of the external sources, or on
+// another filesystem are deleted right away. The emergency cleanup empties the trash
+// before it deletes any archive.
+
+// trashDir staging of the deleted logs, below BasePath
+const trashDir = ".trash"
+
+// TrashSweep outcome of a sweep of the trash
+type TrashSweep struct {
+	Deleted int   `json:"deleted"` // staged logs deleted for good
+	Freed   int64 `json:"freed"`
+	Kept    int   `json:"kept"` // staged logs still in their grace period
+	Bytes   int64 `json:"bytes"`
+}
+
+// trashedFile a staged log of a batch
+type trashedFile struct {
+	path  string
+	batch int64
+	size  int64
+}
+
+// discardLog deletes a log the retention expired, or stages it in the trash. It returns
+// the staged path, empty when the log was deleted
+func (m *maintenanceRun) discardLog(path string) (string, error) {
+	if m.cfg.TrashGrace <= 0 || !within(m.cfg.BasePath, path) {
+		return "", os.Remove(path)
+	}
+
+	rel, err := filepath.Rel(m.cfg.BasePath, path)
+	if err != nil {
+		return "", os.Remove(path)
+	}
+
+	m.mu.Lock()
+	if m.trashBatch == "" {
+		m.trashBatch = strconv.FormatInt(timeNow().Unix(), 10)
+	}
+	batch := m.trashBatch
+	m.mu.Unlock()
+
+	target := filepath.Join(m.cfg.BasePath, trashDir, batch, rel)
+	if err = mkdirAll(filepath.Dir(target), permOrDefault(m.cfg.DirPerm, DefaultDirPerm)); err != nil {
+		return "", err
+	}
+	if _, err = os.Lstat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+
+	err = os.Rename(path, target)
+	if errors.Is(err, syscall.EXDEV) {
+		// A log on another filesystem cannot be staged without a copy
+		return "", os.Remove(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// SweepTrash permanently deletes the staged logs of the scheduled maintenance past
+// their grace period or beyond the size cap. Nothing is swept on a standby
+func SweepTrash(ctx context.Context) (TrashSweep, error) {
+	cfg := DefaultService().Config()
+	if cfg.TrashGrace <= 0 {
+		return TrashSweep{}, nil
+	}
+	if reason := standbyReason(ctx, cfg); reason != "" {
+		return TrashSweep{}, nil
+	}
+
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	m := newMaintenanceRun(ctx, cfg)
+	return m.sweepTrash(ctx, false)
+}
+
+// SweepLogTrash the scheduled sweep of the trash
+func SweepLogTrash(ctx context.Context) {
+	sweep, err := SweepTrash(ctx)
+	if err != nil {
+		g.Log().Warningf(ctx, "Sweep of the log trash failed: %v", err)
+	} else if sweep.Deleted > 0 {
+		g.Log().Infof(ctx, "Deleted %d staged logs from the trash, %d bytes freed", sweep.Deleted, sweep.Freed)
+	}
+}
+
+// sweepTrash deletes the batches past the grace period, then the oldest staged logs
+// beyond TrashMaxBytes. With all set the whole trash is emptied
+func (m *maintenanceRun) sweepTrash(ctx context.Context, all bool) (TrashSweep, error) {
+	var sweep TrashSweep
+
+	root := filepath.Join(m.cfg.BasePath, trashDir)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sweep, nil
+		}
+		return sweep, err
+	}
+
+	cutoff := timeNow().Add(-m.cfg.TrashGrace).Unix()
+
+	var kept []trashedFile
+	for _, entry := range entries {
+		batch, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		expired := all || batch < cutoff
+		_ = filepath.WalkDir(filepath.Join(root, entry.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			f := trashedFile{path: path, batch: batch, size: info.Size()}
+			if expired {
+				m.deleteTrashed(ctx, f, &sweep, fmt.Sprintf("staged logs are deleted after %s", m.cfg.TrashGrace))
+			} else {
+				kept = append(kept, f)
+			}
+			return nil
+		})
+		if expired {
+			removeEmptyDirs(filepath.Join(root, entry.Name()))
+		}
+	}
+
+	var total int64
+	for _, f := range kept {
+		total += f.size
+	}
+
+	if m.cfg.TrashMaxBytes > 0 && total > m.cfg.TrashMaxBytes {
+		sort.Slice(kept, func(i, j int) bool {
+			if kept[i].batch != kept[j].batch {
+				return kept[i].batch < kept[j].batch
+			}
+			return kept[i].path < kept[j].path
+		})
+
+		rule := fmt.Sprintf("the trash keeps at most %d bytes", m.cfg.TrashMaxBytes)
+		for len(kept) > 0 && total > m.cfg.TrashMaxBytes {
+			f := kept[0]
+			kept = kept[1:]
+			if m.deleteTrashed(ctx, f, &sweep, rule) {
+				total -= f.size
+			}
+		}
+		for _, entry := range entries {
+			removeEmptyDirs(filepath.Join(root, entry.Name()))
+		}
+	}
+
+	sweep.Kept = len(kept)
+	sweep.Bytes = total
+	return sweep, nil
+}
+
+// deleteTrashed deletes a staged log for good, it reports whether it was deleted
+func (m *maintenanceRun) deleteTrashed(ctx context.Context, f trashedFile, sweep *TrashSweep, rule string) bool {
+	if err := os.Remove(f.path); err != nil {
+		g.Log().Warningf(ctx, "Failed to delete the staged log %s: %v", f.path, err)
+		return false
+	}
+	sweep.Deleted++
+	sweep.Freed += f.size
+	m.audit(ctx, AuditDelete, f.path, f.size, rule, "")
+	return true
+}
+
+// removeEmptyDirs removes the empty directories of dir, dir included, deepest first
+func removeEmptyDirs(dir string) {
+	var dirs []string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+}
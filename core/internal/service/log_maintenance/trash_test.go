@@ -0,0 +1,91 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrashStaging(t *testing.T) {
+	base := t.TempDir()
+
+	var logs []string
+	for i, name := range []string{"error-20250301.log", "error-20250302.log", "error-20250303.log"} {
+		path := newStandardLog(t, base, name, []byte(name+"\n"))
+		old := time.Now().AddDate(0, 0, -10+i)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		logs = append(logs, path)
+	}
+
+	var events []AuditEvent
+	cfg := MaintenanceConfig{
+		BasePath:   base,
+		Retention:  RetentionPolicy{FilesToKeep: 2},
+		TrashGrace: 24 * time.Hour,
+		Audit:      func(_ context.Context, e AuditEvent) { events = append(events, e) },
+	}
+	r := RunMaintenance(context.Background(), cfg)
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	staged, _ := filepath.Glob(filepath.Join(base, trashDir, "*", "core", "error-20250301.log"))
+	if len(staged) != 1 {
+		t.Fatalf("log beyond the retention should be staged in the trash, got %v", staged)
+	}
+	if _, err := os.Stat(logs[0]); !os.IsNotExist(err) {
+		t.Errorf("staged log should leave its directory, stat err: %v", err)
+	}
+	if len(events) == 0 || events[0].Action != AuditTrash {
+		t.Errorf("audit events %+v, want the staging first", events)
+	}
+	if r.Trash == nil || r.Trash.Kept != 1 {
+		t.Errorf("trash sweep of the run = %+v, want the staged log kept", r.Trash)
+	}
+
+	// The grace period is over
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	timeNow = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	m := newMaintenanceRun(context.Background(), cfg)
+	sweep, err := m.sweepTrash(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sweep.Deleted != 1 || sweep.Kept != 0 {
+		t.Errorf("sweep after the grace period = %+v, want the staged log deleted", sweep)
+	}
+	if _, err := os.Stat(staged[0]); !os.IsNotExist(err) {
+		t.Errorf("staged log should be deleted after the grace period, stat err: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(base, trashDir)); len(entries) != 0 {
+		t.Errorf("empty batches should be removed, got %d entries", len(entries))
+	}
+
+	// Beyond the cap the oldest staged logs go first
+	timeNow = time.Now
+	for i, batch := range []string{"100", "200"} {
+		path := filepath.Join(base, trashDir, batch, "core", "error.log")
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte{'x'}, 100*(i+1)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.cfg.TrashGrace = 100 * 365 * 24 * time.Hour
+	m.cfg.TrashMaxBytes = 250
+	if sweep, err = m.sweepTrash(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if sweep.Deleted != 1 || sweep.Bytes != 200 {
+		t.Errorf("sweep beyond the cap = %+v, want the oldest batch deleted", sweep)
+	}
+	if _, err := os.Stat(filepath.Join(base, trashDir, "200", "core", "error.log")); err != nil {
+		t.Errorf("newest staged log should be kept: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package log_maintenance
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Concurrent archive uploads. With MaxConcurrentUploads above one the archives of the
+// standard and operation logs are stored in the sink by up to that many uploads at a
+// time, the scan waits for a free slot so a slow remote sink bounds the run rather than
+// the uplink. Every upload deletes its own source once the sink confirmed its archive.
+
+const (
+	DefaultUploadRetries    = 2
+	DefaultUploadRetryDelay = 5 * time.Second
+)
+
+// uploadPool bounds the uploads in flight, a nil sem runs them inline
+type uploadPool struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	active atomic.Int64
+	peak   atomic.Int64 // most uploads in flight at once
+}
+
+func newUploadPool(max int) *uploadPool {
+	p := &uploadPool{}
+	if max > 1 {
+		p.sem = make(chan struct{}, max)
+	}
+	return p
+}
+
+// startUpload runs fn, in the background when concurrent uploads are enabled. It blocks
+// until a slot is free
+func (m *maintenanceRun) startUpload(fn func()) {
+	p := m.uploads
+	if p == nil {
+		fn()
+		return
+	}
+	if p.sem == nil {
+		p.track(fn)
+		return
+	}
+
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+		p.track(fn)
+	}()
+}
+
+// track runs fn counted in the uploads in flight
+func (p *uploadPool) track(fn func()) {
+	active := p.active.Add(1)
+	defer p.active.Add(-1)
+
+	for {
+		peak := p.peak.Load()
+		if active <= peak || p.peak.CompareAndSwap(peak, active) {
+			break
+		}
+	}
+	fn()
+}
+
+// peakUploads the most uploads the run had in flight at once
+func (m *maintenanceRun) peakUploads() int {
+	if m.uploads == nil {
+		return 0
+	}
+	return int(m.uploads.peak.Load())
+}
+
+// waitUploads waits for the uploads in flight
+func (m *maintenanceRun) waitUploads() {
+	if m.uploads != nil {
+		m.uploads.wg.Wait()
+	}
+}
+
+// upload stores one archive through put, bounded by UploadTimeout and retried after
+// UploadRetryDelay. A failure of the source itself, such as a lock, is not retried
+func (m *maintenanceRun) upload(ctx context.Context, name string, put func(ctx context.Context) (int64, error)) (int64, error) {
+	retries := m.cfg.UploadRetries
+	if retries == 0 {
+		retries = DefaultUploadRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+	delay := m.cfg.UploadRetryDelay
+	if delay <= 0 {
+		delay = DefaultUploadRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		uctx, cancel := ctx, context.CancelFunc(func() {})
+		if m.cfg.UploadTimeout > 0 {
+			uctx, cancel = context.WithTimeout(ctx, m.cfg.UploadTimeout)
+		}
+
+		written, err := put(uctx)
+		cancel()
+
+		if err == nil {
+			m.uploadsSucceeded.Add(1)
+			return written, nil
+		}
+
+		// Retrying cannot help a locked or rotated log, nor within the budget of the log.
+		// An unconfirmed archive was already read back several times, a full disk was
+		// already cleaned up and retried
+		if isLocked(err) || isRotated(err) || isOverBudget(err) {
+			return written, err
+		}
+		if isUnconfirmed(err) || isDiskFull(err) {
+			m.uploadsFailed.Add(1)
+			return written, err
+		}
+
+		if attempt >= retries || ctx.Err() != nil {
+			m.uploadsFailed.Add(1)
+			return written, err
+		}
+
+		m.uploadsRetried.Add(1)
+		g.Log().Warningf(ctx, "Upload of %s failed, retrying in %s: %v", name, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			m.uploadsFailed.Add(1)
+			return written, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakySink fails the first upload of every archive and every upload of broken,
+// it records the peak number of uploads in flight
+type flakySink struct {
+	*LocalSink
+	broken string
+
+	mu       sync.Mutex
+	active   int
+	peak     int
+	attempts map[string]int
+}
+
+func (s *flakySink) Put(ctx context.Context, name string, r io.Reader) error {
+	if strings.HasSuffix(name, manifestExt) {
+		return s.LocalSink.Put(ctx, name, r)
+	}
+
+	s.mu.Lock()
+	s.active++
+	if s.active > s.peak {
+		s.peak = s.active
+	}
+	s.attempts[name]++
+	attempt := s.attempts[name]
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	if name == s.broken || attempt == 1 {
+		return errors.New("connection reset by peer")
+	}
+	return s.LocalSink.Put(ctx, name, r)
+}
+
+func TestConcurrentUploadsRetriedAndConfirmedBeforeDelete(t *testing.T) {
+	base := t.TempDir()
+
+	var paths []string
+	for day := 1; day <= 6; day++ {
+		name := fmt.Sprintf("error-202001%02d.log", day)
+		paths = append(paths, newStandardLog(t, base, name, []byte(name+" line\n")))
+	}
+
+	sink := &flakySink{LocalSink: NewLocalSink(base), broken: "core/error-20200103.log.gz", attempts: make(map[string]int)}
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{
+		BasePath:             base,
+		Sink:                 sink,
+		MaxConcurrentUploads: 3,
+		UploadRetries:        1,
+		UploadRetryDelay:     time.Millisecond,
+	})
+
+	if sink.peak < 2 || sink.peak > 3 {
+		t.Errorf("peak of %d uploads in flight, want 2 to 3", sink.peak)
+	}
+	if r.UploadsSucceeded != 5 || r.UploadsFailed != 1 || r.UploadsRetried != 6 {
+		t.Errorf("uploads succeeded %d failed %d retried %d, want 5, 1 and 6", r.UploadsSucceeded, r.UploadsFailed, r.UploadsRetried)
+	}
+	if r.Errors != 1 || !errors.Is(r.Err(), ErrCompress) {
+		t.Errorf("want one compression failure, got %d: %v", r.Errors, r.Err())
+	}
+
+	for _, path := range paths {
+		_, srcErr := os.Stat(path)
+		_, gzErr := os.Stat(path + ".gz")
+
+		if strings.HasSuffix(path, "error-20200103.log") {
+			if srcErr != nil || !os.IsNotExist(gzErr) {
+				t.Errorf("source of the failed upload should be kept without archive: %v, %v", srcErr, gzErr)
+			}
+			continue
+		}
+
+		if !os.IsNotExist(srcErr) || gzErr != nil {
+			t.Errorf("%s should be replaced by its archive: %v, %v", path, srcErr, gzErr)
+		}
+	}
+}
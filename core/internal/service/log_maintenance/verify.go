@@ -0,0 +1,429 @@
+package log_maintenance
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Background verification of the stored archives against their checksum manifests.
+// Archives are visited in name order with a bounded read rate, the last one visited
+// is remembered so successive runs cover the whole store. Archives failing the check
+// are moved below quarantine/ with their manifest. Archives stored before manifests
+// existed are checked by decompressing them and get a manifest when they are intact.
+
+const (
+	verifyStateFile = ".verify_state.json"
+	quarantineDir   = "quarantine"
+
+	// DefaultVerifyRate read rate of the verification, low enough to leave the disk to live traffic
+	DefaultVerifyRate int64 = 4 << 20
+)
+
+// VerifyConfig settings of a verification run
+type VerifyConfig struct {
+	BasePath string      // root of the logs tree, where the state is kept
+	Sink     ArchiveSink // archives to verify, must support listing
+
+	// BytesPerSecond read throttle, DefaultVerifyRate when unset, negative to disable
+	BytesPerSecond int64
+
+	// MaxRuntime optional bound of a run, the next run resumes after the last archive visited
+	MaxRuntime time.Duration
+
+	FilePerm os.FileMode
+	DirPerm  os.FileMode
+
+	// ReadOnly only checks the archives: no manifest is recorded, no archive quarantined
+	// and the cursor is not saved, e.g. on a standby node
+	ReadOnly bool
+
+	// Signing optional keys the signatures of the archives are checked with, see
+	// MaintenanceConfig.Signing. An archive whose signature is invalid is corrupt
+	Signing SigningFunc `json:"-"`
+}
+
+// VerifyResult outcome of a verification run
+type VerifyResult struct {
+	StartedAt   time.Time     `json:"started_at"`
+	Duration    time.Duration `json:"duration"`
+	Verified    int           `json:"verified"`   // archives matching their manifest
+	Corrupt     int           `json:"corrupt"`    // archives failing the check
+	Unverified  int           `json:"unverified"` // archives without manifest, checked by decompressing
+	Quarantined []string      `json:"quarantined,omitempty"`
+	Errors      int           `json:"errors"` // archives that could not be read or moved
+	Partial     bool          `json:"partial"`
+
+	// Failures the corrupt archives and the failed operations as *MaintenanceError, see Err
+	Failures []error `json:"-"`
+}
+
+// VerifyStatus persisted state and counters of the verification
+type VerifyStatus struct {
+	Cursor        string       `json:"cursor"`         // last archive visited, empty at the start of a pass
+	LastPassAt    time.Time    `json:"last_pass_at"`   // end of the last complete pass over the store
+	TotalVerified int64        `json:"total_verified"` // archives verified since the state was created
+	TotalCorrupt  int64        `json:"total_corrupt"`  // corrupt archives found since the state was created
+	LastRun       VerifyResult `json:"last_run"`
+}
+
+var verifyStateMutex sync.Mutex
+
+// DefaultVerifyConfig returns the configuration of the scheduled verification
+func DefaultVerifyConfig() VerifyConfig {
+	cfg := DefaultService().Config()
+
+	return VerifyConfig{
+		BasePath:       cfg.BasePath,
+		Sink:           cfg.Sink,
+		BytesPerSecond: DefaultVerifyRate,
+		MaxRuntime:     2 * time.Hour,
+		FilePerm:       cfg.FilePerm,
+		DirPerm:        cfg.DirPerm,
+		Signing:        cfg.Signing,
+	}
+}
+
+// VerifyArchives runs the scheduled verification, read-only on a standby node
+func VerifyArchives(ctx context.Context) {
+	cfg := DefaultVerifyConfig()
+	if reason := standbyReason(ctx, DefaultService().Config()); reason != "" {
+		g.Log().Debugf(ctx, "Archive verification is read-only, %s", reason)
+		cfg.ReadOnly = true
+	}
+	RunVerification(ctx, cfg)
+}
+
+// VerificationStatus returns the state of the scheduled verification
+func VerificationStatus(ctx context.Context) VerifyStatus {
+	return loadVerifyState(DefaultVerifyConfig().BasePath)
+}
+
+// RunVerification verifies the archives following the saved cursor until the end of the
+// store or MaxRuntime, whichever comes first
+func RunVerification(ctx context.Context, cfg VerifyConfig) VerifyResult {
+	result := VerifyResult{StartedAt: time.Now()}
+
+	cfg.FilePerm = permOrDefault(cfg.FilePerm, DefaultFilePerm)
+	cfg.DirPerm = permOrDefault(cfg.DirPerm, DefaultDirPerm)
+	if cfg.BytesPerSecond == 0 {
+		cfg.BytesPerSecond = DefaultVerifyRate
+	}
+	if cfg.Sink == nil {
+		cfg.Sink = &LocalSink{Root: cfg.BasePath, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm}
+	}
+
+	lister, ok := cfg.Sink.(ArchiveLister)
+	if !ok {
+		g.Log().Warning(ctx, "Archive sink does not support listing, verification skipped")
+		return result
+	}
+
+	names, err := lister.List(ctx)
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to list the archives for verification: %v", err)
+		result.Errors++
+		result.Failures = append(result.Failures, &MaintenanceError{Kind: ErrScan, Path: cfg.BasePath, Err: err})
+		return result
+	}
+	sort.Strings(names)
+
+	m := newMaintenanceRun(ctx, MaintenanceConfig{BasePath: cfg.BasePath, Sink: cfg.Sink, FilePerm: cfg.FilePerm, DirPerm: cfg.DirPerm, Signing: cfg.Signing})
+	if cfg.MaxRuntime > 0 {
+		m.deadline = result.StartedAt.Add(cfg.MaxRuntime)
+	}
+
+	// A read-only volume is still verified, without recording manifests nor quarantining
+	if cfg.ReadOnly {
+		m.readOnly = true
+	} else if !m.checkWritable(ctx) {
+		result.Errors++
+		result.Failures = append(result.Failures, m.failureList.list()...)
+	}
+
+	state := loadVerifyState(cfg.BasePath)
+	limiter := newRateLimiter(cfg.BytesPerSecond)
+
+	for _, name := range names {
+		if name <= state.Cursor || inQuarantine(name) {
+			continue
+		}
+
+		if m.outOfTime() || ctx.Err() != nil {
+			result.Partial = true
+			break
+		}
+
+		switch err := m.verifyArchive(ctx, name, limiter); {
+		case err == nil:
+			result.Verified++
+		case err == errNoManifest:
+			result.Unverified++
+		case isCorruption(err):
+			g.Log().Errorf(ctx, "Archive %s is corrupt: %v", name, err)
+			result.Corrupt++
+			result.Failures = append(result.Failures, &MaintenanceError{Kind: ErrVerify, Path: name, Err: err})
+
+			if m.readOnly {
+				break
+			}
+
+			target := path.Join(quarantineDir, name)
+			if qErr := m.quarantine(ctx, name, target); qErr != nil {
+				g.Log().Errorf(ctx, "Failed to quarantine %s: %v", name, qErr)
+				result.Errors++
+				result.Failures = append(result.Failures, &MaintenanceError{Kind: ErrVerify, Path: name, Err: qErr})
+			} else {
+				result.Quarantined = append(result.Quarantined, target)
+			}
+		default:
+			// Removed by a concurrent maintenance run, or unreadable for now
+			if exists, _ := cfg.Sink.Exists(ctx, name); exists {
+				g.Log().Warningf(ctx, "Failed to verify %s: %v", name, err)
+				result.Errors++
+				result.Failures = append(result.Failures, &MaintenanceError{Kind: ErrVerify, Path: name, Err: err})
+			}
+		}
+
+		state.Cursor = name
+	}
+
+	if !result.Partial {
+		state.Cursor = ""
+		state.LastPassAt = time.Now()
+	}
+
+	result.Duration = time.Since(result.StartedAt)
+
+	state.TotalVerified += int64(result.Verified + result.Unverified)
+	state.TotalCorrupt += int64(result.Corrupt)
+	state.LastRun = result
+	if !m.readOnly {
+		saveVerifyState(ctx, cfg.BasePath, cfg.FilePerm, state)
+	}
+
+	g.Log().Infof(ctx, "Archive verification: verified=%d unverified=%d corrupt=%d errors=%d partial=%v total_verified=%d total_corrupt=%d",
+		result.Verified, result.Unverified, result.Corrupt, result.Errors, result.Partial, state.TotalVerified, state.TotalCorrupt)
+
+	return result
+}
+
+var errNoManifest = fmt.Errorf("no manifest")
+
+// corruptionError the archive content does not match its manifest or cannot be decoded
+type corruptionError struct {
+	reason string
+}
+
+func (e *corruptionError) Error() string {
+	return e.reason
+}
+
+func isCorruption(err error) bool {
+	_, ok := err.(*corruptionError)
+	return ok
+}
+
+func inQuarantine(name string) bool {
+	return strings.HasPrefix(name, quarantineDir+"/")
+}
+
+// verifyArchive checks one archive against its manifest. Without manifest the content
+// is decoded instead and a manifest recorded unless the volume is read-only, errNoManifest
+// is returned then
+func (m *maintenanceRun) verifyArchive(ctx context.Context, name string, limiter *rateLimiter) error {
+	expected, hasManifest, err := m.readManifest(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	rc, err := m.cfg.Sink.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	reader := io.TeeReader(&throttledReader{ctx: ctx, r: rc, limiter: limiter}, hash)
+
+	if hasManifest {
+		if _, err = io.Copy(io.Discard, reader); err != nil {
+			return err
+		}
+		if sum := hex.EncodeToString(hash.Sum(nil)); sum != expected {
+			return &corruptionError{reason: fmt.Sprintf("checksum %s, manifest %s", sum, expected)}
+		}
+		return m.checkSignature(ctx, name, expected)
+	}
+
+	if err = m.decodeArchive(name, reader); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &corruptionError{reason: err.Error()}
+	}
+
+	if m.readOnly {
+		return errNoManifest
+	}
+
+	if err = m.writeManifest(ctx, name, hex.EncodeToString(hash.Sum(nil)), nil, false); err != nil {
+		return err
+	}
+
+	return errNoManifest
+}
+
+// quarantine moves an archive with its manifest and signature as they are, the manifest
+// may be the damaged part
+func (m *maintenanceRun) quarantine(ctx context.Context, name, target string) error {
+	for _, pair := range [][2]string{{name, target}, {manifestName(name), manifestName(target)}, {signatureName(name), signatureName(target)}} {
+		exists, err := m.cfg.Sink.Exists(ctx, pair[0])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		rc, err := m.cfg.Sink.Open(ctx, pair[0])
+		if err != nil {
+			return err
+		}
+
+		err = m.cfg.Sink.Put(ctx, pair[1], rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.deleteArchive(ctx, name)
+}
+
+// decodeArchive reads an archive through its filter chain or tar structure to the end
+func (m *maintenanceRun) decodeArchive(name string, r io.Reader) error {
+	if strings.HasSuffix(name, rollupExt) {
+		tr := tar.NewReader(r)
+		for {
+			if _, err := tr.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.Discard, tr); err != nil {
+				return err
+			}
+		}
+		// Consume the padding after the end marker so the whole file is hashed
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+
+	reader, _, err := m.unwrapArchive(name, r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if _, err = io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// rateLimiter bounds the read rate shared by the readers of a run
+type rateLimiter struct {
+	rate  int64
+	start time.Time
+	total int64
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{rate: bytesPerSecond, start: time.Now()}
+}
+
+// wait blocks until n more bytes fit in the rate
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	l.total += int64(n)
+	due := l.start.Add(time.Duration(float64(l.total) / float64(l.rate) * float64(time.Second)))
+
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader reads through a rate limiter
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.wait(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func loadVerifyState(basePath string) VerifyStatus {
+	verifyStateMutex.Lock()
+	defer verifyStateMutex.Unlock()
+
+	var state VerifyStatus
+
+	data, err := os.ReadFile(filepath.Join(basePath, verifyStateFile))
+	if err == nil {
+		_ = json.Unmarshal(data, &state)
+	}
+
+	return state
+}
+
+func saveVerifyState(ctx context.Context, basePath string, perm os.FileMode, state VerifyStatus) {
+	verifyStateMutex.Lock()
+	defer verifyStateMutex.Unlock()
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = writeFile(filepath.Join(basePath, verifyStateFile), data, perm)
+	}
+
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to save the verification state: %v", err)
+	}
+}
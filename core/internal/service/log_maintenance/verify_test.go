@@ -0,0 +1,56 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyArchivesQuarantinesCorrupt(t *testing.T) {
+	base, source := newOperationLogTree(t)
+	RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base})
+
+	archive := source + ".tar.gz"
+	if _, err := os.Stat(archive + manifestExt); err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+
+	cfg := VerifyConfig{BasePath: base, BytesPerSecond: -1}
+	if r := RunVerification(context.Background(), cfg); r.Verified != 1 || r.Corrupt != 0 {
+		t.Fatalf("intact archive: verified %d corrupt %d", r.Verified, r.Corrupt)
+	}
+
+	// Flip one byte in the middle of the archive
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err = os.WriteFile(archive, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := RunVerification(context.Background(), cfg)
+	if r.Corrupt != 1 || len(r.Quarantined) != 1 {
+		t.Fatalf("corrupt archive: corrupt %d quarantined %v", r.Corrupt, r.Quarantined)
+	}
+
+	var mErr *MaintenanceError
+	if err := r.Err(); !errors.Is(err, ErrVerify) || errors.Is(err, ErrDelete) || !errors.As(err, &mErr) || !isCorruption(mErr.Err) {
+		t.Errorf("unexpected verification error %v", err)
+	}
+
+	if _, err = os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("corrupt archive should be moved away, stat err: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(base, filepath.FromSlash(r.Quarantined[0]))); err != nil {
+		t.Errorf("quarantined archive missing: %v", err)
+	}
+
+	status := loadVerifyState(base)
+	if status.Cursor != "" || status.TotalVerified != 1 || status.TotalCorrupt != 1 {
+		t.Errorf("unexpected state %+v", status)
+	}
+}
@@ -0,0 +1,417 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Multi-volume archives for the sinks capping the size of one object. With
+// MaxArchiveBytes set, an operation log day whose archive could exceed the cap is stored
+// as the compressed stream of its archive cut into volumes of at most MaxArchiveBytes,
+// <name>.001, <name>.002, ..., followed by the index <name>.index listing them with their
+// size and SHA-256. The index is written last, a set without one is incomplete. Whether
+// to split is decided from the size of the tar before compression, so a day fitting
+// the cap either way is stored as a single archive. The restore and the verification of
+// a new archive read the volumes back in order as one archive. The rollups, the
+// recompression, the verification of the stored archives and the emergency cleanup only
+// take the single archives.
+
+const (
+	volumeIndexExt = ".index"
+
+	// maxVolumeIndexBytes bounds the index read back, a guard against a corrupt object
+	maxVolumeIndexBytes = 1 << 20
+
+	// tarEntryOverhead room for the header of one tar entry, with a PAX record for its
+	// long name
+	tarEntryOverhead = 3 * 512
+)
+
+// VolumeIndex index of the volumes of an archive
+type VolumeIndex struct {
+	Archive string          `json:"archive"` // the volumes joined in order are this archive
+	Size    int64           `json:"size"`
+	Volumes []ArchiveVolume `json:"volumes"`
+}
+
+// ArchiveVolume one volume of an archive
+type ArchiveVolume struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// volumeName name of the nth volume of the archive name, from 1
+func volumeName(name string, n int) string {
+	return fmt.Sprintf("%s.%03d", name, n)
+}
+
+// volumeIndexName name of the index of the volumes of the archive name
+func volumeIndexName(name string) string {
+	return name + volumeIndexExt
+}
+
+// splitArchive reports whether the archive of entries could exceed MaxArchiveBytes: its
+// tar, incompressible, plus the framing of the codec
+func (m *maintenanceRun) splitArchive(entries []dirEntry) bool {
+	if m.cfg.MaxArchiveBytes <= 0 {
+		return false
+	}
+
+	size := int64(1024) // end of the tar
+	for _, entry := range entries {
+		size += tarEntryOverhead
+		if !entry.info.IsDir() {
+			size += (entry.info.Size() + 511) / 512 * 512
+		}
+	}
+
+	return size+size/64+4096 > m.cfg.MaxArchiveBytes
+}
+
+// putArchiveOrVolumes stores what write produces under name as putLockedArchive does, or
+// as volumes when split. A set replacing a single archive deletes it, and the reverse
+func (m *maintenanceRun) putArchiveOrVolumes(ctx context.Context, name string, lock *ObjectLock, split bool, write func(w io.Writer) error) (int64, error) {
+	if !split {
+		written, err := m.putLockedArchive(ctx, name, lock, write)
+		if err == nil && m.cfg.MaxArchiveBytes > 0 {
+			if delErr := m.deleteVolumes(ctx, name); delErr != nil {
+				return written, fmt.Errorf("delete the volumes replaced by %s: %w", name, delErr)
+			}
+		}
+		return written, err
+	}
+
+	previous, err := m.readVolumeIndex(ctx, name)
+	if err != nil && !errors.Is(err, errNoVolumes) {
+		return 0, err
+	}
+
+	v := &volumeWriter{ctx: ctx, m: m, name: name, lock: lock, max: m.cfg.MaxArchiveBytes}
+	if err = write(v); err == nil {
+		err = v.close()
+	} else {
+		v.abort(err)
+	}
+	if err != nil {
+		// The volumes written so far, and the replaced set whose first volumes they took
+		// the place of
+		cleanup := context.WithoutCancel(ctx)
+		for _, vol := range v.index.Volumes {
+			_ = m.deleteArchive(cleanup, vol.Name)
+		}
+		if previous != nil {
+			_ = m.deleteVolumes(cleanup, name)
+		}
+		return v.index.Size, err
+	}
+
+	// Volumes of the replaced set beyond the new ones, and a replaced single archive
+	if previous != nil {
+		for _, vol := range previous.Volumes[min(len(v.index.Volumes), len(previous.Volumes)):] {
+			if err = m.deleteArchive(ctx, vol.Name); err != nil {
+				return v.index.Size, fmt.Errorf("delete the replaced volume %s: %w", vol.Name, err)
+			}
+		}
+	}
+	if err = m.deleteArchive(ctx, name); err != nil {
+		return v.index.Size, fmt.Errorf("delete the archive %s replaced by its volumes: %w", name, err)
+	}
+
+	return v.index.Size, nil
+}
+
+// volumeWriter cuts the stream written to it into the volumes of an archive, each one
+// stored as it fills up
+type volumeWriter struct {
+	ctx   context.Context
+	m     *maintenanceRun
+	name  string
+	lock  *ObjectLock
+	max   int64
+	index VolumeIndex
+
+	pw   *io.PipeWriter
+	hash hash.Hash
+	left int64 // bytes left in the current volume
+	done chan volumeResult
+}
+
+type volumeResult struct {
+	n   int64
+	err error
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		if v.pw == nil {
+			v.open()
+		}
+
+		n, err := v.pw.Write(p[:min(int64(len(p)), v.left)])
+		v.hash.Write(p[:n])
+		v.left -= int64(n)
+		written += n
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+
+		if v.left == 0 {
+			if err = v.finish(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// open starts storing the next volume
+func (v *volumeWriter) open() {
+	pr, pw := io.Pipe()
+	name := volumeName(v.name, len(v.index.Volumes)+1)
+
+	v.index.Volumes = append(v.index.Volumes, ArchiveVolume{Name: name})
+	v.pw, v.hash, v.left = pw, sha256.New(), v.max
+	v.done = make(chan volumeResult, 1)
+
+	go func() {
+		n, err := v.m.putLockedArchive(v.ctx, name, v.lock, func(w io.Writer) error {
+			_, err := io.Copy(w, pr)
+			return err
+		})
+
+		// Unblock the writer if the sink stopped reading early
+		pr.CloseWithError(errors.Join(err, io.ErrClosedPipe))
+		v.done <- volumeResult{n: n, err: err}
+	}()
+}
+
+// finish completes the current volume
+func (v *volumeWriter) finish() error {
+	v.pw.Close()
+	r := <-v.done
+	v.pw = nil
+
+	vol := &v.index.Volumes[len(v.index.Volumes)-1]
+	vol.Size, vol.SHA256 = r.n, hex.EncodeToString(v.hash.Sum(nil))
+	v.index.Size += r.n
+
+	return r.err
+}
+
+// abort stops the current volume after the stream failed with err
+func (v *volumeWriter) abort(err error) {
+	if v.pw != nil {
+		v.pw.CloseWithError(err)
+		<-v.done
+		v.pw = nil
+	}
+}
+
+// close completes the last volume and stores the index
+func (v *volumeWriter) close() error {
+	if v.pw != nil {
+		if err := v.finish(); err != nil {
+			return err
+		}
+	}
+
+	v.index.Archive = v.name
+	data, err := json.MarshalIndent(v.index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = v.m.putLockedArchive(v.ctx, volumeIndexName(v.name), v.lock, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	return err
+}
+
+var errNoVolumes = errors.New("no volumes")
+
+// readVolumeIndex the index of the volumes of the archive name, errNoVolumes when it is
+// not stored as volumes
+func (m *maintenanceRun) readVolumeIndex(ctx context.Context, name string) (*VolumeIndex, error) {
+	exists, err := m.cfg.Sink.Exists(ctx, volumeIndexName(name))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNoVolumes
+	}
+
+	rc, err := m.cfg.Sink.Open(ctx, volumeIndexName(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxVolumeIndexBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxVolumeIndexBytes {
+		return nil, fmt.Errorf("volume index of %s exceeds %d bytes", name, maxVolumeIndexBytes)
+	}
+
+	var index VolumeIndex
+	if err = json.NewDecoder(bytes.NewReader(data)).Decode(&index); err != nil {
+		return nil, fmt.Errorf("volume index of %s: %w", name, err)
+	}
+
+	// The volumes are named after the archive, an index never points elsewhere
+	if len(index.Volumes) == 0 {
+		return nil, fmt.Errorf("volume index of %s lists no volumes", name)
+	}
+	for i, vol := range index.Volumes {
+		if vol.Name != volumeName(name, i+1) {
+			return nil, fmt.Errorf("volume index of %s: unexpected volume %q", name, vol.Name)
+		}
+	}
+
+	return &index, nil
+}
+
+// archiveStored reports whether the archive name is stored, whole or as volumes
+func (m *maintenanceRun) archiveStored(ctx context.Context, name string) (bool, error) {
+	if exists, err := m.cfg.Sink.Exists(ctx, name); err != nil || exists {
+		return exists, err
+	}
+	return m.cfg.Sink.Exists(ctx, volumeIndexName(name))
+}
+
+// openArchive opens the archive name for reading, joining its volumes when it is stored
+// as volumes
+func (m *maintenanceRun) openArchive(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := m.cfg.Sink.Open(ctx, name)
+	if err == nil {
+		return rc, nil
+	}
+
+	index, indexErr := m.readVolumeIndex(ctx, name)
+	if errors.Is(indexErr, errNoVolumes) {
+		return nil, err
+	}
+	if indexErr != nil {
+		return nil, indexErr
+	}
+
+	return &volumeReader{ctx: ctx, sink: m.cfg.Sink, volumes: index.Volumes}, nil
+}
+
+// deleteVolumes deletes the volumes of the archive name and their index, when stored
+func (m *maintenanceRun) deleteVolumes(ctx context.Context, name string) error {
+	index, err := m.readVolumeIndex(ctx, name)
+	if errors.Is(err, errNoVolumes) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, vol := range index.Volumes {
+		if err = m.deleteArchive(ctx, vol.Name); err != nil {
+			return err
+		}
+	}
+
+	return m.deleteArchive(ctx, volumeIndexName(name))
+}
+
+// verifyVolumeLocks checks the lock of every volume of the archive name and of their index
+func (m *maintenanceRun) verifyVolumeLocks(ctx context.Context, name string, want ObjectLock) error {
+	index, err := m.readVolumeIndex(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for _, vol := range index.Volumes {
+		if err = m.verifyArchiveLock(ctx, vol.Name, want); err != nil {
+			return err
+		}
+	}
+
+	return m.verifyArchiveLock(ctx, volumeIndexName(name), want)
+}
+
+// volumeReader reads the volumes of an archive in order, checking the size and SHA-256
+// of each one against the index
+type volumeReader struct {
+	ctx     context.Context
+	sink    ArchiveSink
+	volumes []ArchiveVolume
+
+	next int
+	cur  io.ReadCloser
+	hash hash.Hash
+	n    int64
+}
+
+func (r *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if r.next == len(r.volumes) {
+				return 0, io.EOF
+			}
+
+			rc, err := r.sink.Open(r.ctx, r.volumes[r.next].Name)
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.hash, r.n = rc, sha256.New(), 0
+		}
+
+		n, err := r.cur.Read(p)
+		r.hash.Write(p[:n])
+		r.n += int64(n)
+
+		if err != io.EOF {
+			return n, err
+		}
+		if err = r.endVolume(); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// endVolume closes the volume read to its end and checks it
+func (r *volumeReader) endVolume() error {
+	vol := r.volumes[r.next]
+	r.cur.Close()
+	r.cur = nil
+	r.next++
+
+	if r.n != vol.Size {
+		return fmt.Errorf("volume %s holds %d bytes, %d expected", vol.Name, r.n, vol.Size)
+	}
+	if sum := hex.EncodeToString(r.hash.Sum(nil)); sum != vol.SHA256 {
+		return fmt.Errorf("volume %s is corrupted: SHA-256 %s, %s expected", vol.Name, sum, vol.SHA256)
+	}
+
+	return nil
+}
+
+func (r *volumeReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
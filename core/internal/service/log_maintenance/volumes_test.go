@@ -0,0 +1,106 @@
+package log_maintenance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMultiVolumeArchiveRoundTrip(t *testing.T) {
+	base, source := newOperationLogTree(t)
+
+	// Incompressible content, so the archive spans several volumes
+	original := make(map[string][]byte)
+	seed := uint32(1)
+	for _, name := range []string{"a.json", "b.json", "sub/c.json"} {
+		content := make([]byte, 96*1024)
+		for i := range content {
+			seed = seed*1664525 + 1013904223
+			content[i] = byte(seed >> 24)
+		}
+		path := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		original[name] = content
+	}
+	os.Remove(filepath.Join(source, "c.json"))
+
+	const maxBytes = 64 * 1024
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, MaxArchiveBytes: maxBytes})
+	if r.Errors != 0 {
+		t.Fatalf("run failed: %v", r.Failures)
+	}
+
+	archive := filepath.Join(base, "core", "operation_log", "2000-01-01.tar.gz")
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Fatalf("single archive stored next to the volumes: %v", err)
+	}
+
+	m := newMaintenanceRun(context.Background(), MaintenanceConfig{BasePath: base, Sink: NewLocalSink(base), RestoreDir: t.TempDir()})
+	index, err := m.readVolumeIndex(context.Background(), "core/operation_log/2000-01-01.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Volumes) < 4 {
+		t.Fatalf("expected at least 4 volumes, got %+v", index.Volumes)
+	}
+	var total int64
+	for i, vol := range index.Volumes {
+		info, err := os.Stat(fmt.Sprintf("%s.%03d", archive, i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > maxBytes || info.Size() != vol.Size {
+			t.Errorf("volume %s: %d bytes, index %d, cap %d", vol.Name, info.Size(), vol.Size, maxBytes)
+		}
+		total += info.Size()
+	}
+	if total != index.Size {
+		t.Errorf("volumes hold %d bytes, index %d", total, index.Size)
+	}
+
+	dir, err := m.restoreOperationLogDay(context.Background(), "2000-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range original {
+		restored, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(restored, content) {
+			t.Errorf("%s: restored content differs: %v", name, err)
+		}
+	}
+
+	// A damaged volume fails the restore
+	second := archive + ".002"
+	data, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err = os.WriteFile(second, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.restoreOperationLogDay(context.Background(), "2000-01-01"); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("restore of a damaged volume: %v", err)
+	}
+
+	// A day fitting the cap stays a single archive
+	base, _ = newOperationLogTree(t)
+	if r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, MaxArchiveBytes: 1 << 30}); r.Errors != 0 {
+		t.Fatalf("run failed: %v", r.Failures)
+	}
+	if _, err = os.Stat(filepath.Join(base, "core", "operation_log", "2000-01-01.tar.gz")); err != nil {
+		t.Errorf("single archive not stored: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(base, "core", "operation_log", "2000-01-01.tar.gz.index")); !os.IsNotExist(err) {
+		t.Errorf("volume index stored for a single archive: %v", err)
+	}
+}
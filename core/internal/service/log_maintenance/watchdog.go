@@ -0,0 +1,220 @@
+package log_maintenance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Watchdog of the scheduled maintenance. A scheduler that stopped, after a panic of its
+// goroutine, a missed timer or a run hung on a dead disk, lets the logs fill the disk
+// without a single error. Service.Run records when each run starts and completes, the
+// watchdog checks from a goroutine of its own that a run succeeded within StaleAfter
+// times RunInterval, otherwise MaintenanceHealth reports the maintenance stale and
+// OnStaleMaintenance is told once until a run succeeds again.
+//
+// The watchdog never takes the locks of a run and only reads the disk once, to seed the
+// last success from the history, so a hung run cannot stall it.
+
+const (
+	// DefaultRunInterval interval of the scheduled runs, see timers.go
+	DefaultRunInterval = 24 * time.Hour
+
+	// DefaultStaleAfter intervals without a successful run before the maintenance is stale
+	DefaultStaleAfter = 2
+
+	watchdogCheckInterval = 10 * time.Minute
+)
+
+// Health status of the scheduled maintenance
+const (
+	HealthOK    = "ok"
+	HealthStale = "stale"
+)
+
+// MaintenanceHealth what the watchdog knows of the scheduled maintenance
+type MaintenanceHealth struct {
+	Status      string    `json:"status"`       // HealthOK or HealthStale
+	LastSuccess time.Time `json:"last_success"` // zero before the first successful run
+	LastStarted time.Time `json:"last_started"` // zero before the first run since the start
+	Running     bool      `json:"running"`      // a run is in progress since LastStarted
+	Deadline    time.Time `json:"deadline"`     // the maintenance is stale when no run succeeded before it
+}
+
+// watchdog run times of a Service
+type watchdog struct {
+	mu          sync.Mutex
+	since       time.Time // creation of the service, the deadline counts from it before any success
+	lastSuccess time.Time
+	lastStarted time.Time
+	running     int
+	alerted     bool // OnStaleMaintenance was told of the current staleness
+
+	once sync.Once
+}
+
+var (
+	staleMu       sync.Mutex
+	staleNotifier func(ctx context.Context, health MaintenanceHealth)
+)
+
+// OnStaleMaintenance registers fn to be told when no scheduled run succeeded in time. It
+// is called once per stale period, from a goroutine of its own
+func OnStaleMaintenance(fn func(ctx context.Context, health MaintenanceHealth)) {
+	staleMu.Lock()
+	staleNotifier = fn
+	staleMu.Unlock()
+}
+
+// runSucceeded reports whether a run did its work. A run that failed on some files still
+// succeeded, the read-only and missing volumes did nothing
+func runSucceeded(result MaintenanceResult) bool {
+	return !result.ReadOnly && !result.BaseMissing
+}
+
+func (w *watchdog) started(at time.Time) {
+	w.mu.Lock()
+	w.lastStarted = at
+	w.running++
+	w.mu.Unlock()
+}
+
+func (w *watchdog) finished(at time.Time, succeeded bool) {
+	w.mu.Lock()
+	w.running--
+	if succeeded {
+		w.lastSuccess = at
+		w.alerted = false
+	}
+	w.mu.Unlock()
+}
+
+// StartWatchdog starts the watchdog of the scheduled maintenance, once
+func StartWatchdog(ctx context.Context) {
+	DefaultService().StartWatchdog(ctx)
+}
+
+// StartWatchdog seeds the last success from the history of the maintenance, then checks
+// every watchdogCheckInterval that the runs keep succeeding. It returns right away
+func (s *Service) StartWatchdog(ctx context.Context) {
+	s.watch.once.Do(func() {
+		s.seedWatchdog(loadHistory(s.Config().BasePath))
+
+		go func() {
+			ticker := time.NewTicker(watchdogCheckInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					s.checkWatchdog(ctx)
+				}
+			}
+		}()
+	})
+}
+
+// seedWatchdog takes the last success from the history, newest first, so a restart does
+// not hide a maintenance stale for days
+func (s *Service) seedWatchdog(history []MaintenanceResult) {
+	s.watch.mu.Lock()
+	defer s.watch.mu.Unlock()
+
+	for _, run := range history {
+		// The compression slices follow the daily run, they do not stand for it
+		if run.Slice != "" || !runSucceeded(run) {
+			continue
+		}
+		if end := run.StartedAt.Add(run.Duration); end.After(s.watch.lastSuccess) {
+			s.watch.lastSuccess = end
+		}
+		break
+	}
+}
+
+// Health what the watchdog knows of the scheduled runs of s
+func (s *Service) Health() MaintenanceHealth {
+	cfg := s.Config()
+	interval := s.RunInterval()
+	staleAfter := cfg.StaleAfter
+	if staleAfter <= 0 {
+		staleAfter = DefaultStaleAfter
+	}
+
+	s.watch.mu.Lock()
+	defer s.watch.mu.Unlock()
+
+	from := s.watch.lastSuccess
+	if from.IsZero() {
+		from = s.watch.since
+	}
+
+	health := MaintenanceHealth{
+		Status:      HealthOK,
+		LastSuccess: s.watch.lastSuccess,
+		LastStarted: s.watch.lastStarted,
+		Running:     s.watch.running > 0,
+		Deadline:    from.Add(time.Duration(staleAfter) * interval),
+	}
+	if timeNow().After(health.Deadline) {
+		health.Status = HealthStale
+	}
+	return health
+}
+
+// Health what the watchdog knows of the scheduled maintenance
+func Health() MaintenanceHealth {
+	return DefaultService().Health()
+}
+
+// checkWatchdog tells OnStaleMaintenance when the maintenance turned stale
+func (s *Service) checkWatchdog(ctx context.Context) {
+	health := s.Health()
+	if health.Status != HealthStale {
+		return
+	}
+
+	s.watch.mu.Lock()
+	alerted := s.watch.alerted
+	s.watch.alerted = true
+	s.watch.mu.Unlock()
+	if alerted {
+		return
+	}
+
+	if health.Running {
+		g.Log().Errorf(ctx, "Log maintenance stale: the run started at %s has not completed, the last success was at %s",
+			health.LastStarted.Format(time.RFC3339), formatWatchdogTime(health.LastSuccess))
+	} else {
+		g.Log().Errorf(ctx, "Log maintenance stale: no run succeeded since %s, the scheduler may have stopped",
+			formatWatchdogTime(health.LastSuccess))
+	}
+
+	staleMu.Lock()
+	fn := staleNotifier
+	staleMu.Unlock()
+	if fn == nil {
+		return
+	}
+
+	// A notifier stuck on its mail server must not stall the watchdog, nor kill it with a panic
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				g.Log().Errorf(ctx, "Stale log maintenance notifier panicked: %v", r)
+			}
+		}()
+		fn(ctx, health)
+	}()
+}
+
+func formatWatchdogTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,89 @@
+package log_maintenance
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWatchdog(t *testing.T) {
+	defer func(orig func() time.Time) { timeNow = orig }(timeNow)
+	now := time.Date(2025, 6, 1, 3, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+
+	alerts := make(chan MaintenanceHealth, 4)
+	OnStaleMaintenance(func(ctx context.Context, health MaintenanceHealth) { alerts <- health })
+	defer OnStaleMaintenance(nil)
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	s := NewService(MaintenanceConfig{BasePath: missing, RunInterval: time.Hour})
+	ctx := context.Background()
+
+	if h := s.Health(); h.Status != HealthOK || !h.Deadline.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("new service: %+v", h)
+	}
+
+	// A run of a missing volume does nothing, it is no success
+	s.Run(ctx)
+	now = now.Add(3 * time.Hour)
+	if h := s.Health(); h.Status != HealthStale || !h.LastSuccess.IsZero() || h.Running {
+		t.Fatalf("after a failed run: %+v", h)
+	}
+
+	s.checkWatchdog(ctx)
+	s.checkWatchdog(ctx)
+	select {
+	case h := <-alerts:
+		if h.Status != HealthStale {
+			t.Errorf("alert of %+v", h)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no stale alert")
+	}
+	select {
+	case h := <-alerts:
+		t.Fatalf("stale period alerted twice: %+v", h)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := s.Reload(MaintenanceConfig{BasePath: t.TempDir(), RunInterval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	s.Run(ctx)
+	if h := s.Health(); h.Status != HealthOK || !h.LastSuccess.Equal(now) {
+		t.Fatalf("after a successful run: %+v", h)
+	}
+
+	// A hung run turns the maintenance stale while it is still running
+	s.watch.started(now)
+	now = now.Add(2*time.Hour + time.Minute)
+	if h := s.Health(); h.Status != HealthStale || !h.Running {
+		t.Fatalf("hung run: %+v", h)
+	}
+	s.checkWatchdog(ctx)
+	select {
+	case <-alerts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no stale alert after a success")
+	}
+	s.watch.finished(now, false)
+
+	// The last success survives a restart through the history, the slices and the runs
+	// that did nothing aside
+	success := now.Add(-30 * time.Hour)
+	restarted := NewService(MaintenanceConfig{BasePath: missing, RunInterval: 12 * time.Hour})
+	restarted.seedWatchdog([]MaintenanceResult{
+		{StartedAt: now.Add(-time.Hour), Slice: "night"},
+		{StartedAt: now.Add(-2 * time.Hour), ReadOnly: true},
+		{StartedAt: success, Duration: time.Minute},
+		{StartedAt: now.Add(-40 * time.Hour)},
+	})
+	if h := restarted.Health(); h.Status != HealthStale || !h.LastSuccess.Equal(success.Add(time.Minute)) {
+		t.Fatalf("restarted: %+v", h)
+	}
+
+	if err := validateConfig(MaintenanceConfig{StaleAfter: -1}); err == nil {
+		t.Error("negative StaleAfter accepted")
+	}
+}
@@ -0,0 +1,131 @@
+package log_maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Write-once-read-many archival of the operation logs, the audit trail of the server.
+// A sink implementing ArchiveLocker stores the archive of each operation log day under
+// the object lock of its LockPolicy, e.g. S3Sink with S3 Object Lock, so it cannot be
+// altered nor deleted before the lock expires. The lock is read back once stored: an
+// archive whose lock was not applied as requested fails the verification and its source
+// is kept. The applied locks are reported in MaintenanceResult.WORMLocks. The policy is
+// set per sink, the archives of the standard logs are only locked with AllArchives.
+
+// Object lock modes
+const (
+	LockGovernance = "GOVERNANCE" // may be lifted by an account with the bypass permission
+	LockCompliance = "COMPLIANCE" // cannot be lifted by anyone until it expires
+)
+
+// ErrArchiveLocked the archive is under an object lock, it cannot be deleted yet
+var ErrArchiveLocked = errors.New("archive is locked")
+
+// WORMPolicy lock applied by a sink to the archives it stores
+type WORMPolicy struct {
+	Mode      string        // LockGovernance or LockCompliance, no lock when empty
+	Retention time.Duration // how long the archives stay locked after their upload
+	LegalHold bool          // an additional legal hold, lifted separately
+
+	// AllArchives also locks the archives of the standard logs. Their retention and
+	// rollups then fail until the locks expire
+	AllArchives bool
+}
+
+// ObjectLock a lock requested for, or applied to, an archive
+type ObjectLock struct {
+	Mode        string    `json:"mode"`
+	RetainUntil time.Time `json:"retain_until"`
+	LegalHold   bool      `json:"legal_hold"`
+}
+
+// Active reports whether the lock still prevents the deletion at t
+func (l ObjectLock) Active(t time.Time) bool {
+	return l.LegalHold || (l.Mode != "" && l.RetainUntil.After(t))
+}
+
+// covers reports whether the applied lock l is at least the requested one
+func (l ObjectLock) covers(want ObjectLock) bool {
+	if l.Mode != want.Mode || (want.LegalHold && !l.LegalHold) {
+		return false
+	}
+	// The stores keep the retention date with a precision of one second
+	return !l.RetainUntil.Before(want.RetainUntil.Truncate(time.Second))
+}
+
+// ArchiveLocker optional sink capability, stores archives under an object lock
+type ArchiveLocker interface {
+	// LockPolicy the lock of the archives stored in the sink
+	LockPolicy() WORMPolicy
+	// PutLocked stores the archive like Put, under lock
+	PutLocked(ctx context.Context, name string, r io.Reader, lock ObjectLock) error
+	// ArchiveLock returns the lock applied to an archive, zero when there is none
+	ArchiveLock(ctx context.Context, name string) (ObjectLock, error)
+}
+
+// AppliedLock lock verified on an archive of the run
+type AppliedLock struct {
+	Archive string `json:"archive"`
+	ObjectLock
+}
+
+// validateWORMPolicy rejects a policy the sinks would refuse
+func validateWORMPolicy(p WORMPolicy) error {
+	switch p.Mode {
+	case "":
+		return nil
+	case LockGovernance, LockCompliance:
+	default:
+		return fmt.Errorf("unknown object lock mode %q", p.Mode)
+	}
+
+	if p.Retention <= 0 {
+		return fmt.Errorf("object lock %s without retention", p.Mode)
+	}
+	return nil
+}
+
+// archiveLock the lock of an archive stored by the run, nil when the sink does not lock
+// it. operationLog tells the archives of the operation log days
+func (m *maintenanceRun) archiveLock(operationLog bool) *ObjectLock {
+	locker, ok := m.cfg.Sink.(ArchiveLocker)
+	if !ok {
+		return nil
+	}
+
+	policy := locker.LockPolicy()
+	if policy.Mode == "" || (!operationLog && !policy.AllArchives) {
+		return nil
+	}
+
+	return &ObjectLock{
+		Mode:        policy.Mode,
+		RetainUntil: timeNow().Add(policy.Retention).UTC(),
+		LegalHold:   policy.LegalHold,
+	}
+}
+
+// verifyArchiveLock checks the lock applied to a stored archive and records it
+func (m *maintenanceRun) verifyArchiveLock(ctx context.Context, name string, want ObjectLock) error {
+	locker := m.cfg.Sink.(ArchiveLocker)
+
+	applied, err := locker.ArchiveLock(ctx, name)
+	if err != nil {
+		return fmt.Errorf("read the lock of %s: %w", name, err)
+	}
+	if !applied.covers(want) {
+		return fmt.Errorf("archive %s locked %s until %s (legal hold %t), %s until %s (legal hold %t) requested",
+			name, applied.Mode, applied.RetainUntil.Format(time.RFC3339), applied.LegalHold,
+			want.Mode, want.RetainUntil.Format(time.RFC3339), want.LegalHold)
+	}
+
+	m.mu.Lock()
+	m.wormLocks = append(m.wormLocks, AppliedLock{Archive: name, ObjectLock: applied})
+	m.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package log_maintenance
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 minimal S3 store with Object Lock, ignoreLock simulates a bucket created
+// without Object Lock that drops the lock headers
+type fakeS3 struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	locks      map[string]http.Header
+	ignoreLock bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		sum := md5.Sum(data)
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		lock := http.Header{}
+		if !f.ignoreLock {
+			for _, h := range []string{"X-Amz-Object-Lock-Mode", "X-Amz-Object-Lock-Retain-Until-Date", "X-Amz-Object-Lock-Legal-Hold"} {
+				if v := r.Header.Get(h); v != "" {
+					lock.Set(h, v)
+				}
+			}
+		}
+		f.locks[key] = lock
+	case http.MethodHead, http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for h, v := range f.locks[key] {
+			w.Header()[h] = v
+		}
+		sum := md5.Sum(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		if f.locks[key].Get("X-Amz-Object-Lock-Mode") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestWORMOperationLogArchives(t *testing.T) {
+	store := &fakeS3{objects: make(map[string][]byte), locks: make(map[string]http.Header)}
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	sink := &S3Sink{
+		Endpoint:  srv.URL,
+		Region:    "eu-west-1",
+		Bucket:    "audit",
+		Prefix:    "logs/",
+		AccessKey: "key",
+		SecretKey: "secret",
+		Lock:      WORMPolicy{Mode: LockCompliance, Retention: 365 * 24 * time.Hour},
+	}
+
+	base, source := newOperationLogTree(t)
+	standard := newStandardLog(t, base, "access-20250102.log", []byte("line\n"))
+
+	r := RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Sink: sink, UploadRetries: -1})
+	if r.Errors != 0 {
+		t.Fatalf("unexpected failures: %v", r.Err())
+	}
+
+	if len(r.WORMLocks) != 1 || r.WORMLocks[0].Archive != "core/operation_log/2000-01-01.tar.gz" || r.WORMLocks[0].Mode != LockCompliance {
+		t.Fatalf("applied locks = %+v", r.WORMLocks)
+	}
+	if until := r.WORMLocks[0].RetainUntil; until.Before(time.Now().AddDate(0, 0, 364)) {
+		t.Errorf("operation log archive retained until %s only", until)
+	}
+	for _, path := range []string{source, standard} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed once archived, stat err: %v", path, err)
+		}
+	}
+
+	// The standard logs are not locked without AllArchives
+	if lock, err := sink.ArchiveLock(context.Background(), "core/access-20250102.log.gz"); err != nil || lock.Mode != "" {
+		t.Errorf("standard log archive lock = %+v, %v", lock, err)
+	}
+
+	if err := sink.Delete(context.Background(), "core/operation_log/2000-01-01.tar.gz"); !errors.Is(err, ErrArchiveLocked) {
+		t.Errorf("deleting a locked archive should be refused, got %v", err)
+	}
+
+	// A lock the store did not apply keeps the source
+	store.ignoreLock = true
+	base, source = newOperationLogTree(t)
+	sink.Prefix = "other/"
+
+	r = RunMaintenance(context.Background(), MaintenanceConfig{BasePath: base, Sink: sink, UploadRetries: -1})
+	if r.Errors != 1 || len(r.WORMLocks) != 0 {
+		t.Fatalf("an unlocked archive should fail: %+v", r)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("the source of an unlocked archive should be kept: %v", err)
+	}
+
+	if err := validateConfig(MaintenanceConfig{Sink: &S3Sink{Lock: WORMPolicy{Mode: LockGovernance}}}); err == nil {
+		t.Error("a lock without retention should be refused")
+	}
+}
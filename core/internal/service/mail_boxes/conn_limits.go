@@ -0,0 +1,348 @@
+package mail_boxes
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Limits of the concurrent IMAP and POP3 sessions, so that a client opening connections
+// in a loop cannot use up the dovecot processes of everyone. The limit per address of a
+// mailbox is dovecot's own mail_max_userip_connections. The limit per mailbox is checked
+// by a post-login script before the session starts: it counts the sessions of the
+// mailbox in dovecot's anvil (doveadm who) and answers a session over the limit with a
+// BYE [LIMIT] (IMAP) or -ERR [SYS/TEMP] (POP3) response. Anvil counts the running imap and
+// pop3 processes, a session ended abruptly is released when its process exits, and
+// imap_idle_notify_interval bounds how long the process of a vanished IDLE client lives.
+// A mailbox limit of 0 falls back to the default one, the limits of the mailboxes are
+// read by the script at each login and apply without a reload.
+// -----------------------------
+
+const (
+	connLimitsOptionKey = "mailbox_conn_limits"
+
+	connLimitsConfName   = "92-conn-limits.conf"
+	connLimitsScriptName = "bm-conn-limit.sh"
+	connLimitsFileName   = "bm-conn-limits.txt"
+
+	// dovecotConfDir conf.d as mounted in the dovecot container
+	dovecotConfDir = "/etc/dovecot/conf.d"
+
+	DefaultMaxSessionsPerAccount = 50
+	DefaultMaxSessionsPerIP      = 20
+)
+
+var connLimitsMutex sync.Mutex
+
+// ConnLimits default limits of the concurrent IMAP and POP3 sessions, 0 is unlimited
+type ConnLimits struct {
+	MaxPerAccount int `json:"max_per_account"`
+	MaxPerIP      int `json:"max_per_ip"` // per mailbox and client address
+}
+
+// MailboxSessions open IMAP and POP3 sessions of a mailbox
+type MailboxSessions struct {
+	Username   string         `json:"username"`
+	Sessions   int            `json:"sessions"`
+	ByProtocol map[string]int `json:"by_protocol"`
+	IPs        []string       `json:"ips"`
+	Limit      int            `json:"limit"` // effective limit of the mailbox, 0 when unlimited
+}
+
+// GetConnLimits returns the configured default limits, DefaultMaxSessionsPerAccount and
+// DefaultMaxSessionsPerIP when unset
+func GetConnLimits(ctx context.Context) ConnLimits {
+	limits := ConnLimits{MaxPerAccount: DefaultMaxSessionsPerAccount, MaxPerIP: DefaultMaxSessionsPerIP}
+	_ = public.OptionsMgrInstance.GetOption(ctx, connLimitsOptionKey, &limits)
+	return limits
+}
+
+// SetConnLimits stores the default limits and applies them to dovecot
+func SetConnLimits(ctx context.Context, limits ConnLimits) error {
+	if limits.MaxPerAccount < 0 || limits.MaxPerIP < 0 {
+		return fmt.Errorf("invalid session limits: %d per account, %d per address", limits.MaxPerAccount, limits.MaxPerIP)
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, connLimitsOptionKey, limits); err != nil {
+		return err
+	}
+
+	if err := ApplyConnLimits(ctx); err != nil {
+		return err
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Mailboxes,
+		Log:  fmt.Sprintf("Session limits set to %d per mailbox, %d per address", limits.MaxPerAccount, limits.MaxPerIP),
+	})
+
+	return nil
+}
+
+// SetMailboxConnLimits sets the maximum concurrent IMAP and POP3 sessions of a mailbox,
+// 0 restores the default limit
+func SetMailboxConnLimits(ctx context.Context, user string, max int) error {
+	user = strings.ToLower(strings.TrimSpace(user))
+	if max < 0 {
+		return fmt.Errorf("invalid session limit: %d", max)
+	}
+
+	res, err := g.DB().Model("mailbox").Ctx(ctx).
+		Where("username", user).
+		Data(g.Map{"max_connections": max, "update_time": time.Now().Unix()}).
+		Update()
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("mailbox %s not found", user)
+	}
+
+	if err = ApplyConnLimits(ctx); err != nil {
+		return err
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Mailboxes,
+		Log:  fmt.Sprintf("Session limit of mailbox %s set to %d", user, max),
+	})
+
+	return nil
+}
+
+// ApplyConnLimits writes the dovecot configuration of the limits, dovecot is reloaded
+// when the default limits changed
+func ApplyConnLimits(ctx context.Context) error {
+	connLimitsMutex.Lock()
+	defer connLimitsMutex.Unlock()
+
+	limits := GetConnLimits(ctx)
+
+	overrides, err := mailboxConnLimits(ctx)
+	if err != nil {
+		return err
+	}
+
+	confDir := filepath.Join(public.AbsPath("../conf/dovecot"), "conf.d")
+
+	if err = writeIfChanged(filepath.Join(confDir, connLimitsFileName), renderConnLimitsFile(limits, overrides), 0644); err != nil {
+		return err
+	}
+	if err = writeIfChanged(filepath.Join(confDir, connLimitsScriptName), connLimitsScript, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(confDir, connLimitsConfName)
+	conf := renderConnLimitsConf(limits)
+	if old, err := os.ReadFile(path); err == nil && string(old) == conf {
+		return nil
+	}
+	if err = os.WriteFile(path, []byte(conf), 0644); err != nil {
+		return fmt.Errorf("write %s failed: %w", connLimitsConfName, err)
+	}
+
+	if err = reloadDovecot(ctx); err != nil {
+		g.Log().Warning(ctx, "reload dovecot failed", err)
+	}
+
+	return nil
+}
+
+// writeIfChanged writes content to path unless it already holds it
+func writeIfChanged(path, content string, perm os.FileMode) error {
+	if old, err := os.ReadFile(path); err == nil && string(old) == content {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), perm); err != nil {
+		return fmt.Errorf("write %s failed: %w", filepath.Base(path), err)
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// mailboxConnLimits the session limits set on mailboxes, by username
+func mailboxConnLimits(ctx context.Context) (map[string]int, error) {
+	var rows []struct {
+		Username       string `json:"username"`
+		MaxConnections int    `json:"max_connections"`
+	}
+
+	err := g.DB().Model("mailbox").Ctx(ctx).
+		Fields("username, max_connections").
+		WhereGT("max_connections", 0).
+		Scan(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make(map[string]int, len(rows))
+	for _, row := range rows {
+		limits[strings.ToLower(row.Username)] = row.MaxConnections
+	}
+	return limits, nil
+}
+
+// renderConnLimitsFile the limits read by the post-login script, "* <default>" then one
+// "<username> <limit>" line per mailbox with its own limit
+func renderConnLimitsFile(limits ConnLimits, overrides map[string]int) string {
+	users := make([]string, 0, len(overrides))
+	for user := range overrides {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	var b strings.Builder
+	b.WriteString("# Session limits of the mailboxes, managed by BillionMail\n")
+	b.WriteString("* " + strconv.Itoa(limits.MaxPerAccount) + "\n")
+	for _, user := range users {
+		b.WriteString(user + " " + strconv.Itoa(overrides[user]) + "\n")
+	}
+	return b.String()
+}
+
+// renderConnLimitsConf the dovecot configuration of the limits
+func renderConnLimitsConf(limits ConnLimits) string {
+	var b strings.Builder
+	b.WriteString("# Session limits, managed by BillionMail. The limit per mailbox is checked by\n")
+	b.WriteString("# " + connLimitsScriptName + " with the limits of " + connLimitsFileName + "\n")
+
+	for _, proto := range []string{"imap", "pop3"} {
+		fmt.Fprintf(&b, "protocol %s {\n  mail_max_userip_connections = %d\n}\n", proto, limits.MaxPerIP)
+	}
+
+	// The IDLE sessions of vanished clients end at the next failed notification
+	b.WriteString("imap_idle_notify_interval = 1 mins\n")
+
+	for _, proto := range []string{"imap", "pop3"} {
+		fmt.Fprintf(&b, `service %[1]s {
+  executable = %[1]s bm-conn-limit-%[1]s
+}
+service bm-conn-limit-%[1]s {
+  executable = script-login %[2]s/%[3]s
+  user = $default_internal_user
+  unix_listener bm-conn-limit-%[1]s {
+  }
+}
+`, proto, dovecotConfDir, connLimitsScriptName)
+	}
+
+	// doveadm who of the post-login script asks anvil for the sessions
+	b.WriteString("service anvil {\n  unix_listener anvil {\n    group = $default_internal_group\n    mode = 0660\n  }\n}\n")
+
+	return b.String()
+}
+
+// connLimitsScript post-login script refusing the sessions of a mailbox over its limit.
+// The session being opened is not counted by anvil yet
+const connLimitsScript = `#!/bin/sh
+# Session limit of the mailboxes, managed by BillionMail
+limits=` + dovecotConfDir + `/` + connLimitsFileName + `
+
+limit=$(awk -v u="$USER" '$1 == u { print $2; found = 1; exit } $1 == "*" { d = $2 } END { if (!found) print d }' "$limits" 2>/dev/null)
+
+if [ -n "$limit" ] && [ "$limit" -gt 0 ] 2>/dev/null; then
+  sessions=$(doveadm who -1 "$USER" 2>/dev/null | awk -v u="$USER" '$1 == u' | wc -l)
+  if [ "$sessions" -ge "$limit" ]; then
+    case "$1" in
+      *pop3*) printf -- '-ERR [SYS/TEMP] Too many connections for this account\r\n' ;;
+      *) printf '* BYE [LIMIT] Too many connections for this account\r\n' ;;
+    esac
+    exit 0
+  fi
+fi
+
+exec "$@"
+`
+
+// GetMailboxSessions returns the open IMAP and POP3 sessions of every mailbox with one,
+// the mailboxes with the most sessions first
+func GetMailboxSessions(ctx context.Context) ([]MailboxSessions, error) {
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return nil, err
+	}
+	defer dk.Close()
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Dovecot, []string{"doveadm", "who", "-1"}, "root")
+	if err != nil {
+		return nil, err
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("doveadm who failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	sessions := parseDoveadmWho(res.Output)
+
+	limits := GetConnLimits(ctx)
+	overrides, err := mailboxConnLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Limit = limits.MaxPerAccount
+		if limit, ok := overrides[sessions[i].Username]; ok {
+			sessions[i].Limit = limit
+		}
+	}
+
+	return sessions, nil
+}
+
+// parseDoveadmWho the sessions by mailbox of the output of "doveadm who -1", one
+// "username proto pid ip" line per session after a header
+func parseDoveadmWho(output string) []MailboxSessions {
+	byUser := make(map[string]*MailboxSessions)
+	ips := make(map[string]map[string]bool)
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "username" {
+			continue
+		}
+
+		user := strings.ToLower(fields[0])
+		s, ok := byUser[user]
+		if !ok {
+			s = &MailboxSessions{Username: user, ByProtocol: make(map[string]int)}
+			byUser[user] = s
+			ips[user] = make(map[string]bool)
+		}
+
+		s.Sessions++
+		s.ByProtocol[fields[1]]++
+		if ip := fields[3]; !ips[user][ip] {
+			ips[user][ip] = true
+			s.IPs = append(s.IPs, ip)
+		}
+	}
+
+	list := make([]MailboxSessions, 0, len(byUser))
+	for _, s := range byUser {
+		sort.Strings(s.IPs)
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Sessions != list[j].Sessions {
+			return list[i].Sessions > list[j].Sessions
+		}
+		return list[i].Username < list[j].Username
+	})
+
+	return list
+}
@@ -0,0 +1,387 @@
+package mail_boxes
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/public"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encryption at rest of the delivered messages, opt-in per domain. Delivery (LMTP) and
+// retrieval (IMAP, POP3) are done by dovecot, so the messages are encrypted by its
+// mail_crypt plugin, which streams them through the cipher as they are saved and read.
+// Each mailbox has its own P-256 key pair derived from a master key kept by the core,
+// dovecot gets the pair of the mailbox from the user query. The private keys are stored
+// encrypted with a password derived from the master key as well, the password is only
+// written to the mail_crypt config of dovecot: a dump of the database and the maildirs
+// reads no message, the master key or the dovecot config is needed too, so keep
+// core/data and conf/dovecot out of the database backups. A compromised host reads
+// everything, as dovecot has to decrypt the messages it serves. The derivation is
+// deterministic: a lost key row is derived again from the master key. The search keeps
+// working, the full text index is built from the plaintext at save time and stored
+// apart from the messages in the dovecot index files. Disabling a domain only stops
+// encrypting the new messages, the keys are kept so the encrypted ones stay readable.
+// The messages never go through the core, so there is no EncryptAtRest/DecryptAtRest:
+// the tooling reads and re-encrypts them with doveadm, which uses the same keys.
+
+const (
+	// mailCryptMasterKeyPath master key of the mailbox keys, created on first use
+	mailCryptMasterKeyPath = "../core/data/mail_crypt_master.key"
+	mailCryptKeyInfo       = "billionmail mail_crypt mailbox key v1:"
+	mailCryptPasswordInfo  = "billionmail mail_crypt key password v1"
+	mailCryptConfName      = "90-mail-crypt.conf"
+)
+
+// dovecotUserQuery user query of dovecot, it returns the mail_crypt settings of the
+// mailboxes of the encrypting domains
+const dovecotUserQuery = `user_query = SELECT '/var/vmail/%d/%n' as home, 'maildir:/var/vmail/%d/%n' as mail, 150 AS uid, 8 AS gid, 'maildir:storage=' || m.quota AS quota, ` +
+	`CASE WHEN d.encrypt_at_rest = 1 AND k.public_key IS NOT NULL THEN '2' ELSE '0' END AS mail_crypt_save_version, ` +
+	`k.public_key AS mail_crypt_global_public_key, k.private_key AS mail_crypt_global_private_key ` +
+	`FROM mailbox m LEFT JOIN domain d ON d.domain = m.domain LEFT JOIN mailbox_crypt_keys k ON k.username = m.username ` +
+	`WHERE m.username = '%u' AND m.active = 1`
+
+var (
+	mailCryptMutex sync.Mutex
+	userQueryLine  = regexp.MustCompile(`(?m)^user_query\s*=.*$`)
+	protoPlugins   = regexp.MustCompile(`(?m)^(\s*)mail_plugins\s*=\s*(.*)$`)
+)
+
+// SetDomainEncryption enables or disables the encryption at rest of the messages
+// delivered to the mailboxes of a domain
+func SetDomainEncryption(ctx context.Context, domain string, enabled bool) error {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	count, err := g.DB().Model("domain").Ctx(ctx).Where("domain", domain).Count()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("domain %s not found", domain)
+	}
+
+	if enabled {
+		if _, err = EnsureMailboxKeys(ctx, domain); err != nil {
+			return err
+		}
+		if err = writeMailCryptConfig(); err != nil {
+			return err
+		}
+	}
+
+	value := 0
+	if enabled {
+		value = 1
+	}
+	if _, err = g.DB().Model("domain").Ctx(ctx).Where("domain", domain).Data(g.Map{"encrypt_at_rest": value}).Update(); err != nil {
+		return err
+	}
+
+	if err = reloadDovecot(ctx); err != nil {
+		g.Log().Warning(ctx, "reload dovecot failed", err)
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Domain,
+		Log:  fmt.Sprintf("Encryption at rest of domain %s set to %t", domain, enabled),
+	})
+
+	return nil
+}
+
+// DomainEncrypted reports whether the messages delivered to the domain are encrypted
+func DomainEncrypted(ctx context.Context, domain string) bool {
+	v, err := g.DB().Model("domain").Ctx(ctx).Where("domain", strings.ToLower(domain)).Value("encrypt_at_rest")
+	return err == nil && v.Int() == 1
+}
+
+// EnsureMailboxKeys creates the missing keys of the mailboxes of a domain, it returns the
+// number of keys created
+func EnsureMailboxKeys(ctx context.Context, domain string) (int, error) {
+	users, err := g.DB().Model("mailbox m").Ctx(ctx).
+		LeftJoin("mailbox_crypt_keys k", "k.username = m.username").
+		Where("m.domain", strings.ToLower(domain)).
+		WhereNull("k.username").
+		Array("m.username")
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, user := range users {
+		if err = ensureMailboxKey(ctx, user.String()); err != nil {
+			return created, err
+		}
+		created++
+	}
+
+	return created, nil
+}
+
+// ensureMailboxKeyOf creates the key of a new mailbox of an encrypting domain
+func ensureMailboxKeyOf(ctx context.Context, username, domain string) {
+	if !DomainEncrypted(ctx, domain) {
+		return
+	}
+	if err := ensureMailboxKey(ctx, username); err != nil {
+		g.Log().Warningf(ctx, "Failed to create the encryption key of %s, its messages are stored unencrypted: %v", username, err)
+	}
+}
+
+// ensureMailboxKey stores the key pair of a mailbox for dovecot
+func ensureMailboxKey(ctx context.Context, username string) error {
+	master, err := loadMasterKey()
+	if err != nil {
+		return err
+	}
+
+	priv, pub, err := mailboxKeyPair(master, username)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.DB().Model("mailbox_crypt_keys").Ctx(ctx).InsertIgnore(g.Map{
+		"username":    username,
+		"public_key":  pub,
+		"private_key": priv,
+		"create_time": time.Now().Unix(),
+	})
+	return err
+}
+
+// mailboxKeyPair derives the key pair of a mailbox, as base64 encoded PEM read by
+// mail_crypt, the private key encrypted with the key password
+func mailboxKeyPair(master []byte, username string) (priv, pub string, err error) {
+	key, err := deriveMailboxKey(master, username)
+	if err != nil {
+		return "", "", err
+	}
+
+	password, err := mailCryptKeyPassword(master)
+	if err != nil {
+		return "", "", err
+	}
+
+	// mail_crypt decrypts the traditional EC PEM, SEC1 encoded
+	raw := key.PublicKey().Bytes()
+	der, err := x509.MarshalECPrivateKey(&ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(raw[1:33]),
+			Y:     new(big.Int).SetBytes(raw[33:]),
+		},
+		D: new(big.Int).SetBytes(key.Bytes()),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	// the legacy PEM encryption, the one mail_crypt reads
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte(password), x509.PEMCipherAES256)
+	if err != nil {
+		return "", "", err
+	}
+
+	pubDer, err := x509.MarshalPKIXPublicKey(key.PublicKey())
+	if err != nil {
+		return "", "", err
+	}
+
+	priv = base64.StdEncoding.EncodeToString(pem.EncodeToMemory(block))
+	pub = base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}))
+
+	return priv, pub, nil
+}
+
+// mailCryptKeyPassword the password of the private keys, hex encoded
+func mailCryptKeyPassword(master []byte) (string, error) {
+	password := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, nil, []byte(mailCryptPasswordInfo)), password); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(password), nil
+}
+
+// deriveMailboxKey the P-256 key of a mailbox, the first HKDF output that is a valid scalar
+func deriveMailboxKey(master []byte, username string) (*ecdh.PrivateKey, error) {
+	r := hkdf.New(sha256.New, master, nil, []byte(mailCryptKeyInfo+strings.ToLower(username)))
+
+	scalar := make([]byte, 32)
+	for i := 0; i < 8; i++ {
+		if _, err := io.ReadFull(r, scalar); err != nil {
+			return nil, err
+		}
+		if key, err := ecdh.P256().NewPrivateKey(scalar); err == nil {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("no valid key derived")
+}
+
+// loadMasterKey reads the master key, it is created on first use
+func loadMasterKey() ([]byte, error) {
+	mailCryptMutex.Lock()
+	defer mailCryptMutex.Unlock()
+
+	path := public.AbsPath(mailCryptMasterKeyPath)
+
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid master key %s: %d bytes", path, len(key))
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// O_EXCL: never replace a master key, the messages encrypted with it would be lost
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Write(key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// writeMailCryptConfig loads mail_crypt for the delivery and the retrieval and points the
+// user query to the mailbox keys
+func writeMailCryptConfig() error {
+	confRoot := public.AbsPath("../conf/dovecot")
+
+	master, err := loadMasterKey()
+	if err != nil {
+		return err
+	}
+	password, err := mailCryptKeyPassword(master)
+	if err != nil {
+		return err
+	}
+
+	// Global plugins, inherited by lmtp and pop3
+	if err := ensureGlobalMailPlugin(confRoot, "mail_crypt"); err != nil {
+		return err
+	}
+	if err := ensurePop3Conf(confRoot); err != nil {
+		return err
+	}
+	// imap replaces the global plugins with its own
+	if err := inheritMailPlugins(filepath.Join(confRoot, "conf.d", "20-imap.conf")); err != nil {
+		return err
+	}
+
+	conf := "# Encryption at rest, managed by BillionMail. The keys come from the user query,\n" +
+		"# the private keys are encrypted with the password below: keep this file out of the\n" +
+		"# database backups\n" +
+		"plugin {\n" +
+		"  mail_crypt_curve = prime256v1\n" +
+		"  mail_crypt_save_version = 0\n" +
+		"  mail_crypt_global_private_key_password = " + password + "\n" +
+		"}\n"
+	path := filepath.Join(confRoot, "conf.d", mailCryptConfName)
+	if err := os.WriteFile(path, []byte(conf), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+
+	sqlConf := filepath.Join(confRoot, "conf.d", "dovecot-sql.conf.ext")
+	data, err := os.ReadFile(sqlConf)
+	if err != nil {
+		return fmt.Errorf("read dovecot-sql.conf.ext failed: %w", err)
+	}
+
+	content := userQueryLine.ReplaceAllLiteralString(string(data), dovecotUserQuery)
+	if !userQueryLine.MatchString(content) {
+		content = strings.TrimRight(content, "\n") + "\n\n" + dovecotUserQuery + "\n"
+	}
+
+	return os.WriteFile(sqlConf, []byte(content), 0644)
+}
+
+// ensureGlobalMailPlugin adds a plugin to the global mail_plugins of dovecot.conf
+func ensureGlobalMailPlugin(confRoot, plugin string) error {
+	path := filepath.Join(confRoot, "dovecot.conf")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read dovecot.conf failed: %w", err)
+	}
+	content := string(data)
+
+	mailPluginsRe := regexp.MustCompile(`(?m)^\s*mail_plugins\s*=.*$`)
+	if mailPluginsRe.MatchString(content) {
+		content = mailPluginsRe.ReplaceAllStringFunc(content, func(line string) string {
+			for _, p := range strings.Fields(strings.SplitN(line, "=", 2)[1]) {
+				if p == plugin {
+					return line
+				}
+			}
+			return line + " " + plugin
+		})
+	} else if includeIdx := strings.Index(content, "!include"); includeIdx > -1 {
+		content = content[:includeIdx] + "mail_plugins = " + plugin + "\n" + content[includeIdx:]
+	} else {
+		content = "mail_plugins = " + plugin + "\n" + content
+	}
+
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// inheritMailPlugins makes the mail_plugins of a protocol extend the global ones
+func inheritMailPlugins(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	content := protoPlugins.ReplaceAllStringFunc(string(data), func(line string) string {
+		m := protoPlugins.FindStringSubmatch(line)
+		if strings.Contains(m[2], "$mail_plugins") {
+			return line
+		}
+		return m[1] + "mail_plugins = $mail_plugins " + m[2]
+	})
+
+	return os.WriteFile(path, []byte(content), 0644)
+}
@@ -0,0 +1,165 @@
+package mail_boxes
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Auto purge of old messages in Trash/Junk like folders. Scheduled task
+// Rule: messages saved before the configured age are expunged with doveadm,
+// flagged and $Important messages are always kept
+// -----------------------------
+
+const mailboxExpiryOptionKey = "mailbox_expiry_policy"
+
+// DefaultMailboxExpiryPolicy folder name -> max age in days
+var DefaultMailboxExpiryPolicy = map[string]int{
+	"Trash": 30,
+	"Junk":  14,
+}
+
+// GetMailboxExpiryPolicy returns the configured folder ages, falls back to the default policy
+func GetMailboxExpiryPolicy(ctx context.Context) map[string]int {
+	policy := make(map[string]int)
+
+	if err := public.OptionsMgrInstance.GetOption(ctx, mailboxExpiryOptionKey, &policy); err != nil || len(policy) == 0 {
+		policy = make(map[string]int, len(DefaultMailboxExpiryPolicy))
+		for folder, days := range DefaultMailboxExpiryPolicy {
+			policy[folder] = days
+		}
+	}
+
+	return policy
+}
+
+// SetMailboxExpiryPolicy stores the folder ages, a value of 0 disables the expiry of that folder
+func SetMailboxExpiryPolicy(ctx context.Context, policy map[string]int) error {
+	for folder, days := range policy {
+		if strings.TrimSpace(folder) == "" || days < 0 {
+			return fmt.Errorf("invalid expiry policy for folder %q: %d", folder, days)
+		}
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, mailboxExpiryOptionKey, policy)
+}
+
+// ExpireMailboxMessages removes the messages of a folder saved before olderThan,
+// it returns the number of purged messages
+func ExpireMailboxMessages(ctx context.Context, user, folder string, olderThan time.Duration) (int, error) {
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return 0, err
+	}
+	defer dk.Close()
+
+	return expireMailboxMessages(ctx, dk, user, folder, olderThan)
+}
+
+func expireMailboxMessages(ctx context.Context, dk *docker.DockerAPI, user, folder string, olderThan time.Duration) (int, error) {
+	if olderThan <= 0 {
+		return 0, fmt.Errorf("invalid expiry age: %s", olderThan)
+	}
+
+	// doveadm accepts intervals in seconds with the "secs" unit
+	query := []string{"mailbox", folder, "savedbefore", strconv.FormatInt(int64(olderThan.Seconds()), 10) + "secs", "NOT", "FLAGGED", "NOT", "KEYWORD", "$Important"}
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Dovecot, append([]string{"doveadm", "search", "-u", user}, query...), "root")
+	if err != nil {
+		return 0, err
+	}
+
+	if res.ExitCode != 0 {
+		// The folder does not exist for this user
+		if strings.Contains(res.Output, "doesn't exist") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("doveadm search failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	count := 0
+	for _, line := range strings.Split(res.Output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	res, err = dk.ExecCommandByName(ctx, consts.SERVICES.Dovecot, append([]string{"doveadm", "expunge", "-u", user}, query...), "root")
+	if err != nil {
+		return 0, err
+	}
+
+	if res.ExitCode != 0 {
+		return 0, fmt.Errorf("doveadm expunge failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	return count, nil
+}
+
+// ExpireAllMailboxes applies the expiry policy on all active mailboxes,
+// it returns the purged counts per user and folder
+func ExpireAllMailboxes(ctx context.Context) (map[string]map[string]int, error) {
+	policy := GetMailboxExpiryPolicy(ctx)
+
+	users, err := g.DB().Model("mailbox").Ctx(ctx).Where("active", 1).Array("username")
+	if err != nil {
+		return nil, err
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return nil, err
+	}
+	defer dk.Close()
+
+	purged := make(map[string]map[string]int)
+	total := 0
+
+	for _, u := range users {
+		user := u.String()
+
+		for folder, days := range policy {
+			if days <= 0 {
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return purged, ctx.Err()
+			}
+
+			n, err := expireMailboxMessages(ctx, dk, user, folder, time.Duration(days)*24*time.Hour)
+			if err != nil {
+				g.Log().Warning(ctx, "Expire mailbox messages failed", user, folder, err)
+				continue
+			}
+
+			if n > 0 {
+				if purged[user] == nil {
+					purged[user] = make(map[string]int)
+				}
+				purged[user][folder] = n
+				total += n
+			}
+		}
+	}
+
+	for user, folders := range purged {
+		g.Log().Infof(ctx, "Expired messages of %s: %v", user, folders)
+	}
+
+	g.Log().Infof(ctx, "Mailbox message expiry completed, %d messages purged from %d mailboxes", total, len(purged))
+
+	return purged, nil
+}
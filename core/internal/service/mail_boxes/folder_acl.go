@@ -0,0 +1,283 @@
+package mail_boxes
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Shared mailboxes with per-folder IMAP ACLs (RFC 4314). The rights are enforced by the
+// acl plugin of dovecot, stored in the dovecot-acl file of each folder (vfile), and the
+// folders shared with a mailbox are listed to it below shared/<owner>/. The shared
+// namespace needs a separator that cannot appear in a username, so the first ACL set
+// switches the hierarchy separator of the folders from . to / (the Maildir++ layout on
+// disk is unchanged). imap_acl is not loaded: the ACLs are managed from the console only,
+// which keeps the owner of a folder out of them, the owner always keeps all its rights.
+// The grants are also kept in mailbox_folder_acl for the listing.
+// -----------------------------
+
+const (
+	sharedMailboxesConfName = "91-shared-mailboxes.conf"
+
+	// aclRightsOrder the RFC 4314 rights letters, in the order they are displayed
+	aclRightsOrder = "lrswipkxtea"
+)
+
+// aclRightNames dovecot names of the RFC 4314 rights letters, for doveadm acl
+var aclRightNames = map[byte]string{
+	'l': "lookup",
+	'r': "read",
+	's': "write-seen",
+	'w': "write",
+	'i': "insert",
+	'p': "post",
+	'k': "create",
+	'x': "delete",
+	't': "write-deleted",
+	'e': "expunge",
+	'a': "admin",
+}
+
+var sharedMailboxesMutex sync.Mutex
+
+// FolderACL rights of a grantee on a folder of a mailbox
+type FolderACL struct {
+	Owner      string `json:"owner"`
+	Folder     string `json:"folder"`
+	Grantee    string `json:"grantee"`
+	Rights     string `json:"rights"` // RFC 4314 letters, e.g. lrs for read only
+	UpdateTime int64  `json:"update_time"`
+}
+
+// NormalizeACLRights checks the RFC 4314 rights letters and returns them without
+// duplicates in aclRightsOrder. The obsolete RFC 2086 c and d are expanded to k and xte
+func NormalizeACLRights(rights string) (string, error) {
+	set := make(map[byte]bool)
+	for i := 0; i < len(rights); i++ {
+		switch c := rights[i]; {
+		case c == 'c':
+			set['k'] = true
+		case c == 'd':
+			set['x'], set['t'], set['e'] = true, true, true
+		case aclRightNames[c] != "":
+			set[c] = true
+		default:
+			return "", fmt.Errorf("unknown ACL right %q", string(c))
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(aclRightsOrder); i++ {
+		if set[aclRightsOrder[i]] {
+			b.WriteByte(aclRightsOrder[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// SetFolderACL grants rights on a folder of the mailbox owner to the mailbox grantee,
+// replacing its previous rights. Empty rights revoke them
+func SetFolderACL(ctx context.Context, owner, folder, grantee, rights string) error {
+	owner = strings.ToLower(strings.TrimSpace(owner))
+	grantee = strings.ToLower(strings.TrimSpace(grantee))
+
+	folder, err := normalizeACLFolder(folder)
+	if err != nil {
+		return err
+	}
+	if rights, err = NormalizeACLRights(rights); err != nil {
+		return err
+	}
+
+	// The rights of the owner are implicit, revoking them would lock it out of its folder
+	if owner == grantee {
+		return fmt.Errorf("the owner %s always keeps all the rights of its folders", owner)
+	}
+	for _, user := range []string{owner, grantee} {
+		count, err := g.DB().Model("mailbox").Ctx(ctx).Where("username", user).Where("deleted_time", 0).Count()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("mailbox %s not found", user)
+		}
+	}
+
+	if err = EnsureSharedMailboxes(ctx); err != nil {
+		return err
+	}
+
+	cmd := []string{"acl", "delete", "-u", owner, folder, "user=" + grantee}
+	if rights != "" {
+		cmd = []string{"acl", "set", "-u", owner, folder, "user=" + grantee}
+		for i := 0; i < len(rights); i++ {
+			cmd = append(cmd, aclRightNames[rights[i]])
+		}
+	}
+	if err = doveadm(ctx, cmd...); err != nil {
+		return err
+	}
+
+	// List the folder to the grantee, or stop listing it
+	if err = doveadm(ctx, "acl", "recalc", "-u", owner); err != nil {
+		g.Log().Warningf(ctx, "Failed to update the shared mailboxes of %s: %v", owner, err)
+	}
+
+	if rights == "" {
+		_, err = g.DB().Model("mailbox_folder_acl").Ctx(ctx).
+			Where("owner", owner).Where("folder", folder).Where("grantee", grantee).
+			Delete()
+	} else {
+		_, err = g.DB().Model("mailbox_folder_acl").Ctx(ctx).Data(g.Map{
+			"owner":       owner,
+			"folder":      folder,
+			"grantee":     grantee,
+			"rights":      rights,
+			"update_time": time.Now().Unix(),
+		}).OnConflict("owner", "folder", "grantee").Save()
+	}
+	if err != nil {
+		return err
+	}
+
+	log := fmt.Sprintf("Rights of %s on folder %s of mailbox %s set to %s", grantee, folder, owner, rights)
+	if rights == "" {
+		log = fmt.Sprintf("Rights of %s on folder %s of mailbox %s revoked", grantee, folder, owner)
+	}
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Mailboxes,
+		Log:  log,
+	})
+
+	return nil
+}
+
+// GetFolderACLs returns the rights granted on the folders of owner and those granted to
+// it on the folders of other mailboxes
+func GetFolderACLs(ctx context.Context, user string) ([]FolderACL, error) {
+	user = strings.ToLower(strings.TrimSpace(user))
+
+	acls := make([]FolderACL, 0)
+	err := g.DB().Model("mailbox_folder_acl").Ctx(ctx).
+		Where("owner = ? OR grantee = ?", user, user).
+		Order("owner, folder, grantee").
+		Scan(&acls)
+	return acls, err
+}
+
+// normalizeACLFolder checks the name of a folder of the owner, INBOX in any case is INBOX
+func normalizeACLFolder(folder string) (string, error) {
+	folder = strings.Trim(strings.TrimSpace(folder), "/")
+	if strings.EqualFold(folder, "INBOX") {
+		return "INBOX", nil
+	}
+
+	if folder == "" || strings.HasPrefix(folder, "shared/") || strings.ContainsAny(folder, "*%\r\n\x00") {
+		return "", fmt.Errorf("invalid folder %q", folder)
+	}
+	for _, part := range strings.Split(folder, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid folder %q", folder)
+		}
+	}
+	return folder, nil
+}
+
+// EnsureSharedMailboxes loads the acl plugin and the shared namespace of dovecot, dovecot
+// is reloaded when they were not yet
+func EnsureSharedMailboxes(ctx context.Context) error {
+	sharedMailboxesMutex.Lock()
+	defer sharedMailboxesMutex.Unlock()
+
+	confRoot := public.AbsPath("../conf/dovecot")
+	path := filepath.Join(confRoot, "conf.d", sharedMailboxesConfName)
+
+	if old, err := os.ReadFile(path); err == nil && string(old) == sharedMailboxesConf {
+		return nil
+	}
+
+	// Global plugins, inherited by lmtp and pop3, the deliveries apply the ACLs too
+	if err := ensureGlobalMailPlugin(confRoot, "acl"); err != nil {
+		return err
+	}
+	if err := ensurePop3Conf(confRoot); err != nil {
+		return err
+	}
+	// imap replaces the global plugins with its own
+	if err := inheritMailPlugins(filepath.Join(confRoot, "conf.d", "20-imap.conf")); err != nil {
+		return err
+	}
+
+	if err := writeIfChanged(path, sharedMailboxesConf, 0644); err != nil {
+		return err
+	}
+
+	if err := reloadDovecot(ctx); err != nil {
+		g.Log().Warning(ctx, "reload dovecot failed", err)
+	}
+
+	return nil
+}
+
+// sharedMailboxesEnabled reports whether the shared namespace is configured, the folder
+// hierarchy separator is then /
+func sharedMailboxesEnabled() bool {
+	_, err := os.Stat(filepath.Join(public.AbsPath("../conf/dovecot"), "conf.d", sharedMailboxesConfName))
+	return err == nil
+}
+
+// dovecotFolderName the IMAP name of a Maildir++ folder, e.g. Archive.2024
+func dovecotFolderName(maildirName string) string {
+	if sharedMailboxesEnabled() {
+		return strings.ReplaceAll(maildirName, ".", "/")
+	}
+	return maildirName
+}
+
+// doveadm runs doveadm with args in the dovecot container
+func doveadm(ctx context.Context, args ...string) error {
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Dovecot, append([]string{"doveadm"}, args...), "root")
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("doveadm %s failed: %s", strings.Join(args[:2], " "), strings.TrimSpace(res.Output))
+	}
+	return nil
+}
+
+// sharedMailboxesConf the dovecot configuration of the shared mailboxes. The sections
+// named like those of dovecot.conf are merged with them
+const sharedMailboxesConf = `# Shared mailboxes and folder ACLs, managed by BillionMail
+namespace inbox {
+  separator = /
+}
+namespace shared {
+  type = shared
+  separator = /
+  prefix = shared/%%u/
+  location = maildir:/var/vmail/%%d/%%n:INDEX=~/shared/%%u
+  subscriptions = no
+  list = children
+}
+plugin {
+  acl = vfile
+  acl_shared_dict = file:/var/vmail/shared-mailboxes.db
+}
+`
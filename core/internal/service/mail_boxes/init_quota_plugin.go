@@ -185,10 +185,10 @@ connect = host=pgsql dbname=%s user=%s password=%s
 
 default_pass_scheme = MD5-CRYPT
 
-user_query = SELECT '/var/vmail/%%d/%%n' as home, 'maildir:/var/vmail/%%d/%%n' as mail, 150 AS uid, 8 AS gid, 'maildir:storage=' || quota AS quota FROM mailbox WHERE username = '%%u' AND active = 1
+%s
 
 password_query = SELECT username as user, password, '/var/vmail/%%d/%%n' as userdb_home, 'maildir:/var/vmail/%%d/%%n' as userdb_mail, 150 as userdb_uid, 8 as userdb_gid FROM mailbox WHERE username = '%%u' AND active = 1
-`, dbName, dbUser, dbPass)
+`, dbName, dbUser, dbPass, dovecotUserQuery)
 
 	err := ioutil.WriteFile(path, []byte(content), 0644)
 	if err != nil {
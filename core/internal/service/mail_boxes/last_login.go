@@ -0,0 +1,207 @@
+package mail_boxes
+
+import (
+	v1 "billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/public"
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Last successful login of each mailbox. IMAP and POP3 logins are read from the dovecot
+// log, SMTP authentications from the postfix log. Both logs are followed from the last
+// read offset, and the login of a mailbox is written at most once per loginDebounce.
+// -----------------------------
+
+// loginDebounce minimum time between two writes of the last login of a mailbox
+const loginDebounce = 15 * time.Minute
+
+// Login protocols
+const (
+	LoginIMAP = "imap"
+	LoginPOP3 = "pop3"
+	LoginSMTP = "smtp"
+)
+
+var (
+	dovecotLoginPattern = regexp.MustCompile(`\b(imap|pop3)-login: Login: user=<([^>]+)>`)
+	saslLoginPattern    = regexp.MustCompile(`\bsasl_username=([^,\s]+)`)
+)
+
+// loginLog a log followed for logins
+type loginLog struct {
+	path   string
+	offset int64
+}
+
+var (
+	loginMutex   sync.Mutex
+	loginLogs    []*loginLog
+	loginWritten = make(map[string]time.Time) // last login written by mailbox
+)
+
+func loginLogPaths() []string {
+	return []string{
+		public.AbsPath("../logs/dovecot/mail.log"),
+		public.AbsPath(filepath.Join(consts.POSTFIX_MAILLOG_PATH, "mail.log")),
+	}
+}
+
+// parseLoginLine the mailbox, protocol and time of a successful login line
+func parseLoginLine(line string, now time.Time) (user, protocol string, at time.Time, ok bool) {
+	if m := dovecotLoginPattern.FindStringSubmatch(line); m != nil {
+		user, protocol = m[2], m[1]
+	} else if m = saslLoginPattern.FindStringSubmatch(line); m != nil && strings.Contains(line, "/smtpd[") {
+		user, protocol = m[1], LoginSMTP
+	} else {
+		return "", "", time.Time{}, false
+	}
+
+	at, ok = parseSyslogTime(line, now)
+	return strings.ToLower(user), protocol, at, ok
+}
+
+// parseSyslogTime the time of a syslog line, RFC 3339 or the traditional format without year
+func parseSyslogTime(line string, now time.Time) (time.Time, bool) {
+	if first, _, found := strings.Cut(line, " "); found {
+		if t, err := time.Parse(time.RFC3339, first); err == nil {
+			return t, true
+		}
+	}
+
+	if len(line) < 15 {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation(time.Stamp, line[:15], now.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	t = t.AddDate(now.Year(), 0, 0)
+	// Lines from December read in January
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
+
+// ScanLogins reads the logins logged since the previous scan and records the last
+// login of each mailbox, called by the scheduler
+func ScanLogins(ctx context.Context) {
+	loginMutex.Lock()
+	defer loginMutex.Unlock()
+
+	if loginLogs == nil {
+		for _, path := range loginLogPaths() {
+			loginLogs = append(loginLogs, &loginLog{path: path})
+		}
+	}
+
+	now := time.Now()
+	logins := make(map[string]struct {
+		protocol string
+		at       time.Time
+	})
+
+	for _, l := range loginLogs {
+		err := l.read(func(line string) {
+			user, protocol, at, ok := parseLoginLine(line, now)
+			if !ok || !at.After(logins[user].at) {
+				return
+			}
+			logins[user] = struct {
+				protocol string
+				at       time.Time
+			}{protocol, at}
+		})
+		if err != nil && !os.IsNotExist(err) {
+			g.Log().Warningf(ctx, "Failed to read the logins of %s: %v", l.path, err)
+		}
+	}
+
+	for user, login := range logins {
+		if login.at.Sub(loginWritten[user]) < loginDebounce {
+			continue
+		}
+
+		_, err := g.DB().Model("mailbox").Ctx(ctx).
+			Where("username", user).
+			Where("last_login_time < ?", login.at.Unix()).
+			Data(g.Map{
+				"last_login_time":     login.at.Unix(),
+				"last_login_protocol": login.protocol,
+			}).
+			Update()
+		if err != nil {
+			g.Log().Warningf(ctx, "Failed to record the login of %s: %v", user, err)
+			continue
+		}
+
+		loginWritten[user] = login.at
+	}
+}
+
+// read passes the lines appended since the previous read, from the start after a rotation
+func (l *loginLog) read(fn func(line string)) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < l.offset {
+		l.offset = 0
+	}
+
+	if _, err = f.Seek(l.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A partial last line is read again by the next scan
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		l.offset += int64(len(line))
+		fn(line)
+	}
+}
+
+// StaleMailboxes the active mailboxes without login for inactiveFor, including those never
+// logged in and created before that, the least recently used first
+func StaleMailboxes(ctx context.Context, inactiveFor time.Duration) ([]v1.Mailbox, error) {
+	cutoff := time.Now().Add(-inactiveFor).Unix()
+
+	var mailboxes []v1.Mailbox
+	err := g.DB().Model("mailbox").Ctx(ctx).
+		Where("active", 1).
+		Where("(last_login_time > 0 AND last_login_time < ?) OR (last_login_time = 0 AND create_time < ?)", cutoff, cutoff).
+		Order("last_login_time ASC, create_time ASC").
+		Scan(&mailboxes)
+	if err != nil {
+		return nil, err
+	}
+
+	return mailboxes, nil
+}
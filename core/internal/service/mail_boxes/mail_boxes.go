@@ -51,6 +51,7 @@ func Add(ctx context.Context, mailbox *v1.Mailbox) (err error) {
 	if e2 := ensureMaildirAndQuotaFile(ctx, mailbox); e2 != nil {
 		g.Log().Warning(ctx, "ensureMaildirAndQuotaFile failed", e2)
 	}
+	ensureMailboxKeyOf(ctx, mailbox.Username, mailbox.Domain)
 	return nil
 }
 
@@ -74,6 +75,11 @@ func Update(ctx context.Context, mailbox *v1.Mailbox) (err error) {
 	m := gconv.Map(mailbox)
 	delete(m, "create_time")
 	delete(m, "used_quota")
+	delete(m, "last_login_time")
+	delete(m, "last_login_protocol")
+	delete(m, "sending_disabled")
+	delete(m, "deleted_time")
+	delete(m, "max_connections")
 
 	var mb v1.Mailbox
 	err = g.DB().Model("mailbox").Where("username", mailbox.Username).Scan(&mb)
@@ -368,6 +374,12 @@ func BatchAdd(ctx context.Context, domain string, quota int, count int, prefix s
 		return nil, fmt.Errorf("Failed to create any mailbox")
 	}
 
+	if DomainEncrypted(ctx, domain) {
+		if _, err = EnsureMailboxKeys(ctx, domain); err != nil {
+			g.Log().Warningf(ctx, "Failed to create the encryption keys of the mailboxes of %s: %v", domain, err)
+		}
+	}
+
 	return emailList, nil
 }
 
@@ -424,6 +436,7 @@ func AddImport(ctx context.Context, mailbox *v1.Mailbox) (err error) {
 	if e2 := ensureMaildirAndQuotaFile(ctx, mailbox); e2 != nil {
 		g.Log().Warning(ctx, "AddImport ensureMaildirAndQuotaFile failed", e2)
 	}
+	ensureMailboxKeyOf(ctx, mailbox.Username, mailbox.Domain)
 	return nil
 }
 
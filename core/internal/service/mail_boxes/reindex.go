@@ -0,0 +1,315 @@
+package mail_boxes
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+)
+
+// -----------------------------
+// Throttled, resumable rebuild of the Dovecot mailbox search index.
+// Folders are indexed one by one with "doveadm index", which indexes a whole
+// folder and takes no message range. The throttle counts the messages of the
+// folders indexed since the last pause: once they reach BatchSize the walker
+// sleeps for Throttle so live search is never frozen. A folder larger than
+// BatchSize is still indexed in one go, followed by a single pause.
+// The completed folders are persisted, an interrupted run resumes after them.
+// -----------------------------
+
+// reindexCheckpointDir directory of the reindex checkpoints, replaced in tests
+var reindexCheckpointDir = "data/reindex"
+
+// ReindexOptions controls a single mailbox reindex
+type ReindexOptions struct {
+	BatchSize int                   // Messages of the folders indexed before pausing, default 500
+	Throttle  time.Duration         // Pause between batches, default 2s
+	Restart   bool                  // Ignore the persisted checkpoint and start over
+	Progress  func(ReindexProgress) // Optional progress callback
+}
+
+// ReindexProgress progress of a mailbox reindex
+type ReindexProgress struct {
+	User          string        `json:"user"`
+	Folder        string        `json:"folder"`
+	FoldersDone   int           `json:"folders_done"`
+	FoldersTotal  int           `json:"folders_total"`
+	MessagesDone  int           `json:"messages_done"`
+	MessagesTotal int           `json:"messages_total"`
+	ETA           time.Duration `json:"eta"`
+	Finished      bool          `json:"finished"`
+}
+
+// reindexCheckpoint persisted state of an unfinished reindex
+type reindexCheckpoint struct {
+	DoneFolders []string `json:"done_folders"`
+	UpdateTime  int64    `json:"update_time"`
+}
+
+// maildirFolder a Maildir++ folder to be indexed
+type maildirFolder struct {
+	name     string // Dovecot mailbox name, e.g. INBOX, Sent, Archive.2024 (Archive/2024 with the shared mailboxes)
+	messages int
+}
+
+func (o *ReindexOptions) normalize() {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 500
+	}
+
+	if o.Throttle < 0 {
+		o.Throttle = 0
+	} else if o.Throttle == 0 {
+		o.Throttle = 2 * time.Second
+	}
+}
+
+// ReindexMailbox rebuilds the search index of a single mailbox
+func ReindexMailbox(ctx context.Context, user string, opts ReindexOptions) (progress ReindexProgress, err error) {
+	opts.normalize()
+	progress.User = user
+
+	localPart, domain, ok := strings.Cut(user, "@")
+	if !ok || localPart == "" || domain == "" {
+		return progress, fmt.Errorf("invalid mailbox: %s", user)
+	}
+
+	userDir := filepath.Join(public.AbsPath("../vmail-data"), domain, localPart)
+	if !public.IsDir(userDir) {
+		return progress, fmt.Errorf("maildir of %s does not exist", user)
+	}
+
+	folders, err := listMaildirFolders(userDir)
+	if err != nil {
+		return progress, err
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return progress, err
+	}
+	defer dk.Close()
+
+	return reindexFolders(ctx, user, folders, opts, func(ctx context.Context, folder string) error {
+		_, err := dk.ExecCommandByName(ctx, consts.SERVICES.Dovecot, []string{"doveadm", "index", "-u", user, folder}, "root")
+		return err
+	})
+}
+
+// reindexFolders indexes the folders of user not completed by the checkpoint with index,
+// throttled by opts, and persists the progress after every folder
+func reindexFolders(ctx context.Context, user string, folders []maildirFolder, opts ReindexOptions, index func(ctx context.Context, folder string) error) (progress ReindexProgress, err error) {
+	progress.User = user
+
+	checkpoint := reindexCheckpoint{}
+	if opts.Restart {
+		removeReindexCheckpoint(user)
+	} else {
+		checkpoint = loadReindexCheckpoint(user)
+	}
+
+	done := make(map[string]struct{}, len(checkpoint.DoneFolders))
+	for _, name := range checkpoint.DoneFolders {
+		done[name] = struct{}{}
+	}
+
+	progress.FoldersTotal = len(folders)
+	for _, f := range folders {
+		progress.MessagesTotal += f.messages
+		if _, ok := done[f.name]; ok {
+			progress.FoldersDone++
+			progress.MessagesDone += f.messages
+		}
+	}
+
+	startTime := time.Now()
+	resumedMessages := progress.MessagesDone
+	sinceThrottle := 0
+
+	for _, f := range folders {
+		if _, ok := done[f.name]; ok {
+			continue
+		}
+
+		if err = ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		progress.Folder = f.name
+
+		if err = index(ctx, f.name); err != nil {
+			return progress, fmt.Errorf("doveadm index %s of %s failed: %w", f.name, user, err)
+		}
+
+		progress.FoldersDone++
+		progress.MessagesDone += f.messages
+		checkpoint.DoneFolders = append(checkpoint.DoneFolders, f.name)
+		saveReindexCheckpoint(user, checkpoint)
+
+		// Estimate the remaining time by the throughput of this run
+		if indexed := progress.MessagesDone - resumedMessages; indexed > 0 {
+			perMessage := time.Since(startTime) / time.Duration(indexed)
+			progress.ETA = perMessage * time.Duration(progress.MessagesTotal-progress.MessagesDone)
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+
+		sinceThrottle += f.messages
+		if sinceThrottle >= opts.BatchSize {
+			sinceThrottle = 0
+
+			select {
+			case <-ctx.Done():
+				return progress, ctx.Err()
+			case <-time.After(opts.Throttle):
+			}
+		}
+	}
+
+	removeReindexCheckpoint(user)
+
+	progress.Folder = ""
+	progress.ETA = 0
+	progress.Finished = true
+
+	if opts.Progress != nil {
+		opts.Progress(progress)
+	}
+
+	g.Log().Infof(ctx, "Reindex mailbox %s completed: %d folders, %d messages, cost %s", user, progress.FoldersTotal, progress.MessagesTotal, time.Since(startTime))
+
+	return progress, nil
+}
+
+// ReindexAll schedules the reindex of all active mailboxes with bounded concurrency
+func ReindexAll(ctx context.Context, concurrency int, opts ReindexOptions) (failed map[string]error, err error) {
+	if concurrency <= 0 {
+		concurrency = 2
+	}
+
+	users, err := g.DB().Model("mailbox").Ctx(ctx).Where("active", 1).Array("username")
+	if err != nil {
+		return nil, err
+	}
+
+	failed = make(map[string]error)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	jobs := make(chan string, len(users))
+
+	for _, u := range users {
+		jobs <- u.String()
+	}
+	close(jobs)
+
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if _, e := ReindexMailbox(ctx, user, opts); e != nil {
+					g.Log().Warning(ctx, "Reindex mailbox failed", user, e)
+					mu.Lock()
+					failed[user] = e
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return failed, ctx.Err()
+}
+
+// listMaildirFolders lists INBOX and all Maildir++ sub folders with their message count
+func listMaildirFolders(userDir string) ([]maildirFolder, error) {
+	entries, err := os.ReadDir(userDir)
+	if err != nil {
+		return nil, err
+	}
+
+	folders := []maildirFolder{{name: "INBOX", messages: countMaildirMessages(userDir)}}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), ".") && entry.Name() != "." && entry.Name() != ".." {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		folders = append(folders, maildirFolder{
+			name:     dovecotFolderName(strings.TrimPrefix(name, ".")),
+			messages: countMaildirMessages(filepath.Join(userDir, name)),
+		})
+	}
+
+	return folders, nil
+}
+
+// countMaildirMessages counts the messages in the cur and new directories
+func countMaildirMessages(dir string) (n int) {
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func reindexCheckpointPath(user string) string {
+	return public.AbsPath(reindexCheckpointDir, public.Md5(user)+".json")
+}
+
+func loadReindexCheckpoint(user string) (checkpoint reindexCheckpoint) {
+	content := gfile.GetBytes(reindexCheckpointPath(user))
+	if len(content) == 0 {
+		return
+	}
+
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		g.Log().Warning(context.Background(), "Invalid reindex checkpoint, starting over", user, err)
+		return reindexCheckpoint{}
+	}
+
+	return
+}
+
+func saveReindexCheckpoint(user string, checkpoint reindexCheckpoint) {
+	checkpoint.UpdateTime = time.Now().Unix()
+	content, _ := json.Marshal(checkpoint)
+
+	if err := gfile.PutBytes(reindexCheckpointPath(user), content); err != nil {
+		g.Log().Warning(context.Background(), "Save reindex checkpoint failed", user, err)
+	}
+}
+
+func removeReindexCheckpoint(user string) {
+	_ = os.Remove(reindexCheckpointPath(user))
+}
@@ -0,0 +1,158 @@
+package mail_boxes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// stubReindexCheckpoints keeps the reindex checkpoints of the test in a temporary directory
+func stubReindexCheckpoints(t *testing.T) {
+	t.Helper()
+
+	orig := reindexCheckpointDir
+	reindexCheckpointDir = t.TempDir()
+	t.Cleanup(func() { reindexCheckpointDir = orig })
+}
+
+// fakeIndexer records the indexed folders, failing on the folders of fail
+type fakeIndexer struct {
+	indexed []string
+	fail    map[string]bool
+}
+
+func (f *fakeIndexer) index(ctx context.Context, folder string) error {
+	if f.fail[folder] {
+		return errors.New("doveadm unavailable")
+	}
+	f.indexed = append(f.indexed, folder)
+	return nil
+}
+
+var testReindexFolders = []maildirFolder{{"INBOX", 10}, {"Archive", 20}, {"Sent", 5}}
+
+var testReindexOptions = ReindexOptions{BatchSize: 1, Throttle: -1}
+
+func TestReindexResumesFromCheckpoint(t *testing.T) {
+	stubReindexCheckpoints(t)
+	ctx := context.Background()
+	user := "a@example.com"
+
+	// Interrupted at the second folder, the first one is checkpointed
+	first := &fakeIndexer{fail: map[string]bool{"Archive": true}}
+	progress, err := reindexFolders(ctx, user, testReindexFolders, testReindexOptions, first.index)
+	if err == nil {
+		t.Fatal("failed folder not reported")
+	}
+	if progress.FoldersDone != 1 || progress.MessagesDone != 10 || progress.Finished {
+		t.Errorf("interrupted progress %+v", progress)
+	}
+	if got := loadReindexCheckpoint(user).DoneFolders; !reflect.DeepEqual(got, []string{"INBOX"}) {
+		t.Errorf("checkpoint %v, want INBOX", got)
+	}
+
+	// The next run skips the completed folder and counts it as done
+	var reported []ReindexProgress
+	opts := testReindexOptions
+	opts.Progress = func(p ReindexProgress) { reported = append(reported, p) }
+
+	second := &fakeIndexer{}
+	progress, err = reindexFolders(ctx, user, testReindexFolders, opts, second.index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(second.indexed, []string{"Archive", "Sent"}) {
+		t.Errorf("resumed run indexed %v", second.indexed)
+	}
+	if len(reported) != 3 || reported[0].FoldersDone != 2 || reported[0].MessagesDone != 30 || !reported[2].Finished {
+		t.Errorf("reported progress %+v", reported)
+	}
+	if progress.FoldersDone != 3 || progress.MessagesDone != 35 || progress.MessagesTotal != 35 || !progress.Finished {
+		t.Errorf("final progress %+v", progress)
+	}
+
+	// A completed run leaves no checkpoint behind
+	if _, err := os.Stat(reindexCheckpointPath(user)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left after completion: %v", err)
+	}
+}
+
+func TestReindexRestart(t *testing.T) {
+	stubReindexCheckpoints(t)
+	ctx := context.Background()
+	user := "a@example.com"
+
+	saveReindexCheckpoint(user, reindexCheckpoint{DoneFolders: []string{"INBOX", "Archive"}})
+
+	opts := testReindexOptions
+	opts.Restart = true
+
+	indexer := &fakeIndexer{}
+	if _, err := reindexFolders(ctx, user, testReindexFolders, opts, indexer.index); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexer.indexed, []string{"INBOX", "Archive", "Sent"}) {
+		t.Errorf("restarted run indexed %v", indexer.indexed)
+	}
+
+	// An unreadable checkpoint starts over
+	if err := os.WriteFile(reindexCheckpointPath(user), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	indexer = &fakeIndexer{}
+	if _, err := reindexFolders(ctx, user, testReindexFolders, testReindexOptions, indexer.index); err != nil {
+		t.Fatal(err)
+	}
+	if len(indexer.indexed) != 3 {
+		t.Errorf("run of an invalid checkpoint indexed %v", indexer.indexed)
+	}
+}
+
+func TestReindexCancelled(t *testing.T) {
+	stubReindexCheckpoints(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	user := "a@example.com"
+
+	indexer := &fakeIndexer{}
+	opts := testReindexOptions
+	opts.Progress = func(p ReindexProgress) { cancel() }
+
+	if _, err := reindexFolders(ctx, user, testReindexFolders, opts, indexer.index); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled run = %v", err)
+	}
+	if !reflect.DeepEqual(indexer.indexed, []string{"INBOX"}) {
+		t.Errorf("cancelled run indexed %v", indexer.indexed)
+	}
+	if got := loadReindexCheckpoint(user).DoneFolders; !reflect.DeepEqual(got, []string{"INBOX"}) {
+		t.Errorf("checkpoint %v, want INBOX", got)
+	}
+}
+
+func TestListMaildirFolders(t *testing.T) {
+	userDir := t.TempDir()
+
+	for path, messages := range map[string]int{"cur": 2, "new": 1, ".Sent/cur": 3, ".Archive.2024/new": 1, ".Drafts/tmp": 4} {
+		dir := filepath.Join(userDir, path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < messages; i++ {
+			if err := os.WriteFile(filepath.Join(dir, string(rune('a'+i))), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	folders, err := listMaildirFolders(userDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []maildirFolder{{"INBOX", 3}, {"Archive.2024", 1}, {"Drafts", 0}, {"Sent", 3}}
+	if !reflect.DeepEqual(folders, want) {
+		t.Errorf("folders %+v, want %+v", folders, want)
+	}
+}
@@ -0,0 +1,81 @@
+package mail_boxes
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Sending switch of a mailbox, to stop a compromised or abusive account from sending
+// right away without deleting it. A disabled account is refused at submission by the
+// SMTP policy service, it still receives mail and logs in.
+// -----------------------------
+
+// SetSendingEnabled enables or disables the sending of a mailbox, the change is recorded
+// in the operation log
+func SetSendingEnabled(ctx context.Context, user string, enabled bool) error {
+	user = strings.ToLower(strings.TrimSpace(user))
+
+	disabled := 1
+	if enabled {
+		disabled = 0
+	}
+
+	res, err := g.DB().Model("mailbox").Ctx(ctx).
+		Where("username", user).
+		Data(g.Map{"sending_disabled": disabled, "update_time": time.Now().Unix()}).
+		Update()
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("mailbox %s not found", user)
+	}
+
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Mailboxes,
+		Log:  "Sending of mailbox " + user + " " + state,
+	})
+
+	return nil
+}
+
+// SendingEnabled reports whether the mailbox may send, an unknown mailbox or a lookup
+// failure is left to the other checks
+func SendingEnabled(ctx context.Context, user string) bool {
+	val, err := g.DB().Model("mailbox").Ctx(ctx).
+		Where("username", strings.ToLower(strings.TrimSpace(user))).
+		Value("sending_disabled")
+	if err != nil || val == nil {
+		return true
+	}
+	return val.Int() == 0
+}
+
+// SendingDisabledMailboxes the mailboxes whose sending is disabled
+func SendingDisabledMailboxes(ctx context.Context) ([]string, error) {
+	values, err := g.DB().Model("mailbox").Ctx(ctx).
+		Fields("username").
+		Where("sending_disabled", 1).
+		OrderAsc("username").
+		Array()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(values))
+	for _, v := range values {
+		users = append(users, v.String())
+	}
+	return users, nil
+}
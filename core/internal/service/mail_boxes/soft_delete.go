@@ -0,0 +1,233 @@
+package mail_boxes
+
+import (
+	"archive/tar"
+	v1 "billionmail-core/api/mail_boxes/v1"
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/compress"
+	"billionmail-core/internal/service/public"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Soft deletion of mailboxes. A deleted mailbox is only disabled: postfix no longer
+// delivers to it and dovecot refuses its logins, its mail is kept for the grace period
+// and it can be restored meanwhile. Scheduled task: once the grace period elapsed, the
+// maildir is archived as a tar.gz below the deleted mailboxes directory, then the
+// maildir and the mailbox are removed for good.
+// -----------------------------
+
+const mailboxDeletionOptionKey = "mailbox_deletion_policy"
+
+// DefaultMailboxDeletionGraceDays days a deleted mailbox can be restored
+const DefaultMailboxDeletionGraceDays = 30
+
+// MailboxDeletionPolicy grace period of the deleted mailboxes, 0 deletes them at once
+type MailboxDeletionPolicy struct {
+	GraceDays int `json:"grace_days"`
+}
+
+// GetMailboxDeletionPolicy returns the configured policy, the default grace period when unset
+func GetMailboxDeletionPolicy(ctx context.Context) MailboxDeletionPolicy {
+	policy := MailboxDeletionPolicy{GraceDays: DefaultMailboxDeletionGraceDays}
+	_ = public.OptionsMgrInstance.GetOption(ctx, mailboxDeletionOptionKey, &policy)
+	if policy.GraceDays < 0 {
+		policy.GraceDays = DefaultMailboxDeletionGraceDays
+	}
+	return policy
+}
+
+// SetMailboxDeletionPolicy stores the policy
+func SetMailboxDeletionPolicy(ctx context.Context, policy MailboxDeletionPolicy) error {
+	if policy.GraceDays < 0 {
+		return fmt.Errorf("invalid grace period of the deleted mailboxes: %d days", policy.GraceDays)
+	}
+	return public.OptionsMgrInstance.SetOption(ctx, mailboxDeletionOptionKey, policy)
+}
+
+// grace the grace period as a duration
+func (p MailboxDeletionPolicy) grace() time.Duration {
+	return time.Duration(p.GraceDays) * 24 * time.Hour
+}
+
+// deletedMailboxesDir where the maildirs of the purged mailboxes are archived, out of the
+// domains of the vmail data
+func deletedMailboxesDir() string {
+	return filepath.Join(public.AbsPath("../vmail-data"), ".deleted")
+}
+
+// DisableMailbox soft deletes a mailbox, it no longer receives mail nor logs in and is
+// purged once the grace period elapsed
+func DisableMailbox(ctx context.Context, user string) error {
+	user = strings.ToLower(strings.TrimSpace(user))
+
+	res, err := g.DB().Model("mailbox").Ctx(ctx).
+		Where("username", user).
+		Where("deleted_time", 0).
+		Data(g.Map{"active": 0, "deleted_time": time.Now().Unix(), "update_time": time.Now().Unix()}).
+		Update()
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("mailbox %s not found or already deleted", user)
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Mailboxes,
+		Log:  fmt.Sprintf("Mailbox %s deleted, restorable for %d days", user, GetMailboxDeletionPolicy(ctx).GraceDays),
+	})
+
+	return nil
+}
+
+// RestoreMailbox enables a soft deleted mailbox again, within the grace period
+func RestoreMailbox(ctx context.Context, user string) error {
+	user = strings.ToLower(strings.TrimSpace(user))
+	cutoff := time.Now().Add(-GetMailboxDeletionPolicy(ctx).grace()).Unix()
+
+	res, err := g.DB().Model("mailbox").Ctx(ctx).
+		Where("username", user).
+		WhereGT("deleted_time", 0).
+		WhereGTE("deleted_time", cutoff).
+		Data(g.Map{"active": 1, "deleted_time": 0, "update_time": time.Now().Unix()}).
+		Update()
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("mailbox %s is not deleted or its grace period elapsed", user)
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.Mailboxes,
+		Log:  "Mailbox " + user + " restored",
+	})
+
+	return nil
+}
+
+// DeletedMailboxes the soft deleted mailboxes, the most recently deleted first
+func DeletedMailboxes(ctx context.Context, domain string) ([]v1.Mailbox, error) {
+	query := g.DB().Model("mailbox").Ctx(ctx).WhereGT("deleted_time", 0).OrderDesc("deleted_time")
+	if domain != "" {
+		query = query.Where("domain", domain)
+	}
+
+	mailboxes := make([]v1.Mailbox, 0)
+	err := query.Scan(&mailboxes)
+	return mailboxes, err
+}
+
+// PurgeDeletedMailboxes archives and removes the mailboxes deleted before the grace
+// period, it returns the number of purged mailboxes. A mailbox whose maildir could not
+// be archived is kept for the next run
+func PurgeDeletedMailboxes(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-GetMailboxDeletionPolicy(ctx).grace()).Unix()
+
+	var mailboxes []v1.Mailbox
+	err := g.DB().Model("mailbox").Ctx(ctx).
+		WhereGT("deleted_time", 0).
+		WhereLTE("deleted_time", cutoff).
+		Scan(&mailboxes)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range mailboxes {
+		if ctx.Err() != nil {
+			return purged, ctx.Err()
+		}
+
+		mb := &mailboxes[i]
+		archive, err := archiveMaildir(mb)
+		if err != nil {
+			g.Log().Warningf(ctx, "Failed to archive the maildir of the deleted mailbox %s, kept: %v", mb.Username, err)
+			continue
+		}
+
+		if err = os.RemoveAll(maildirRoot(mb)); err != nil {
+			g.Log().Warningf(ctx, "Failed to remove the maildir of the deleted mailbox %s: %v", mb.Username, err)
+			continue
+		}
+
+		if err = Delete(ctx, mb.Username); err != nil {
+			g.Log().Warningf(ctx, "Failed to remove the deleted mailbox %s: %v", mb.Username, err)
+			continue
+		}
+
+		purged++
+		_ = public.WriteLog(ctx, public.LogParams{
+			Type: consts.LOGTYPE.Mailboxes,
+			Log:  "Deleted mailbox " + mb.Username + " purged, maildir archived to " + archive,
+		})
+	}
+
+	g.Log().Infof(ctx, "Deleted mailboxes purge completed, %d of %d mailboxes purged", purged, len(mailboxes))
+
+	return purged, nil
+}
+
+// archiveMaildir archives the maildir of a mailbox and checks the archive reads back, it
+// returns the archive path, empty when the mailbox has no maildir
+func archiveMaildir(mb *v1.Mailbox) (string, error) {
+	root := maildirRoot(mb)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	dir := filepath.Join(deletedMailboxesDir(), mb.Domain)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	archive := filepath.Join(dir, fmt.Sprintf("%s-%s.tar.gz", mb.LocalPart, time.Now().Format("20060102150405")))
+	if err := compress.Gzip(archive, root); err != nil {
+		_ = os.Remove(archive)
+		return "", err
+	}
+
+	if err := checkTarGz(archive); err != nil {
+		_ = os.Remove(archive)
+		return "", fmt.Errorf("archive %s is unreadable: %w", archive, err)
+	}
+
+	return archive, nil
+}
+
+// checkTarGz reads a tar.gz archive to its end
+func checkTarGz(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package mail_service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// Attachment file attached to a message
+type Attachment struct {
+	Name        string
+	ContentType string // application/octet-stream when empty
+	Data        []byte
+}
+
+// multipartBody returns the message with the Content-Type of its multipart body, on a
+// copy of its headers, and the body: the content as the first part in its own
+// Content-Type, then the attachments base64 encoded
+func (m Message) multipartBody() (Message, string) {
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+
+	text := textproto.MIMEHeader{}
+	text.Set("Content-Type", m.Headers["Content-Type"])
+	text.Set("Content-Transfer-Encoding", "quoted-printable")
+	if part, err := w.CreatePart(text); err == nil {
+		part.Write([]byte(m.MailText()))
+	}
+
+	for _, a := range m.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"name": a.Name}))
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		header.Set("Content-Transfer-Encoding", "base64")
+		part, err := w.CreatePart(header)
+		if err != nil {
+			continue
+		}
+
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	w.Close()
+
+	subtype := strings.TrimSpace(m.MultipartType)
+	if subtype == "" {
+		subtype = "mixed"
+	}
+
+	headers := make(map[string]string, len(m.Headers))
+	for key, value := range m.Headers {
+		headers[key] = value
+	}
+	headers["Content-Type"] = "multipart/" + subtype + "; boundary=\"" + w.Boundary() + "\""
+	m.Headers = headers
+
+	return m, buf.String()
+}
@@ -0,0 +1,417 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Delivery status notifications. The operator writes the text of the success, delay and
+// failure notifications, with the variables {original_recipient}, {reason} and
+// {server_name}, and chooses after how long a delay notification is sent. The texts are
+// rendered into the bounce templates of postfix, which appends the recipients and their
+// reasons as report parts itself, and GenerateDSN builds the same notifications as RFC
+// 3464 multipart/report messages. Every notification is Auto-Submitted: auto-replied and
+// none is generated about an automatic message, so two servers never bounce at each other.
+// -----------------------------
+
+const (
+	dsnOptionKey  = "dsn_settings"
+	dsnFile       = "bounce_templates.cf"
+	dsnFromName   = "Mail Delivery System"
+	dsnBoundaryID = "dsn"
+)
+
+// DSNType kind of delivery status notification
+type DSNType string
+
+const (
+	DSNSuccess DSNType = "success"
+	DSNDelay   DSNType = "delay"
+	DSNFailure DSNType = "failure"
+)
+
+// DSNTemplate subject and text of a notification, with the variables of the notification
+type DSNTemplate struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// DSNSettings the notification texts and when the delay notifications are sent
+type DSNSettings struct {
+	Success DSNTemplate `json:"success"`
+	Delay   DSNTemplate `json:"delay"`
+	Failure DSNTemplate `json:"failure"`
+
+	// DelayWarningHours hours a message waits in the queue before the sender is warned,
+	// 0 sends no delay notification
+	DelayWarningHours int `json:"delay_warning_hours"`
+}
+
+// dsnVariablePattern the variables of the templates
+var dsnVariablePattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// dsnStatusPattern the enhanced status code of a reason, e.g. 5.1.1
+var dsnStatusPattern = regexp.MustCompile(`\b([245])\.(\d{1,3})\.(\d{1,3})\b`)
+
+// DefaultDSNSettings texts close to those of postfix, no delay notification
+func DefaultDSNSettings() DSNSettings {
+	return DSNSettings{
+		Success: DSNTemplate{
+			Subject: "Successful Mail Delivery Report",
+			Body:    "This is the mail system at host {server_name}.\n\nYour message was successfully delivered to {original_recipient}.\n",
+		},
+		Delay: DSNTemplate{
+			Subject: "Delayed Mail (still being retried)",
+			Body: "This is the mail system at host {server_name}.\n\nYour message to {original_recipient} could not be delivered yet: {reason}\n\n" +
+				"It will be retried, you do not need to send it again.\n",
+		},
+		Failure: DSNTemplate{
+			Subject: "Undelivered Mail Returned to Sender",
+			Body: "This is the mail system at host {server_name}.\n\nYour message could not be delivered to {original_recipient}: {reason}\n\n" +
+				"For further assistance, please send mail to postmaster.\n",
+		},
+	}
+}
+
+// GetDSNSettings returns the configured settings, the defaults for the texts left empty
+func GetDSNSettings(ctx context.Context) DSNSettings {
+	s := DefaultDSNSettings()
+	_ = public.OptionsMgrInstance.GetOption(ctx, dsnOptionKey, &s)
+	return s.withDefaults()
+}
+
+// SetDSNSettings validates and saves the settings, then applies them to postfix
+func SetDSNSettings(ctx context.Context, s DSNSettings) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, dsnOptionKey, s); err != nil {
+		return err
+	}
+
+	return WriteDSNTemplates(ctx)
+}
+
+func (s DSNSettings) withDefaults() DSNSettings {
+	def := DefaultDSNSettings()
+	for _, t := range []struct{ value, def *DSNTemplate }{
+		{&s.Success, &def.Success},
+		{&s.Delay, &def.Delay},
+		{&s.Failure, &def.Failure},
+	} {
+		if strings.TrimSpace(t.value.Subject) == "" {
+			t.value.Subject = t.def.Subject
+		}
+		if strings.TrimSpace(t.value.Body) == "" {
+			t.value.Body = t.def.Body
+		}
+	}
+	return s
+}
+
+func (s DSNSettings) validate() error {
+	if s.DelayWarningHours < 0 || s.DelayWarningHours > 24*30 {
+		return fmt.Errorf("invalid delay warning time %dh, must be between 0 and 720", s.DelayWarningHours)
+	}
+
+	for typ, t := range map[DSNType]DSNTemplate{DSNSuccess: s.Success, DSNDelay: s.Delay, DSNFailure: s.Failure} {
+		if strings.ContainsAny(t.Subject, "\r\n") {
+			return fmt.Errorf("the subject of the %s notification must fit on one line", typ)
+		}
+		for _, m := range dsnVariablePattern.FindAllStringSubmatch(t.Subject+t.Body, -1) {
+			if m[1] != "original_recipient" && m[1] != "reason" && m[1] != "server_name" {
+				return fmt.Errorf("unknown variable {%s} in the %s notification", m[1], typ)
+			}
+		}
+		for _, line := range strings.Split(t.Body, "\n") {
+			if strings.TrimSpace(line) == "EOF" {
+				return fmt.Errorf("the %s notification must not contain a line EOF", typ)
+			}
+		}
+	}
+
+	return nil
+}
+
+// template the template of a notification type
+func (s DSNSettings) template(typ DSNType) (DSNTemplate, bool) {
+	switch typ {
+	case DSNSuccess:
+		return s.Success, true
+	case DSNDelay:
+		return s.Delay, true
+	case DSNFailure:
+		return s.Failure, true
+	}
+	return DSNTemplate{}, false
+}
+
+// SyncDSNTemplates applies the notification settings to postfix when they were
+// configured, the postfix templates are left as they are otherwise
+func SyncDSNTemplates(ctx context.Context) error {
+	var s DSNSettings
+	if err := public.OptionsMgrInstance.GetOption(ctx, dsnOptionKey, &s); err != nil {
+		return nil
+	}
+	return WriteDSNTemplates(ctx)
+}
+
+// WriteDSNTemplates renders the texts into the bounce templates of postfix and applies
+// the delay warning time, then reloads postfix
+func WriteDSNTemplates(ctx context.Context) error {
+	s := GetDSNSettings(ctx)
+
+	if _, err := public.WriteFile(public.AbsPath(filepath.Join(consts.POSTFIX_CONF_PATH, dsnFile)), s.postfixTemplates()); err != nil {
+		return err
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	for _, p := range []string{
+		"bounce_template_file=/etc/postfix/conf/" + dsnFile,
+		fmt.Sprintf("delay_warning_time=%dh", s.DelayWarningHours),
+	} {
+		res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postconf", "-e", p}, "root")
+		if err != nil {
+			return err
+		}
+		if res.ExitCode != 0 {
+			return fmt.Errorf("postconf failed: %s", strings.TrimSpace(res.Output))
+		}
+	}
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postfix", "reload"}, "root")
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("postfix reload failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	return nil
+}
+
+// postfixTemplates the bounce template file. Postfix expands $ parameters in it and lists
+// the recipients and their reasons in the report after the text, the variables name them
+func (s DSNSettings) postfixTemplates() string {
+	render := func(text string) string {
+		return strings.NewReplacer(
+			"$", "$$",
+			"{server_name}", "$myhostname",
+			"{original_recipient}", "the recipients listed below",
+			"{reason}", "see the report below",
+		).Replace(text)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Managed by BillionMail, delivery status notification templates\n")
+
+	for _, t := range []struct {
+		name string
+		tpl  DSNTemplate
+	}{
+		{"failure_template", s.Failure},
+		{"delay_template", s.Delay},
+		{"success_template", s.Success},
+	} {
+		charset := "us-ascii"
+		if !isASCII(t.tpl.Subject + t.tpl.Body) {
+			charset = "utf-8"
+		}
+
+		fmt.Fprintf(&b, "\n%s = <<EOF\nCharset: %s\nFrom: MAILER-DAEMON (%s)\nSubject: %s\n\n%s\nEOF\n",
+			t.name, charset, dsnFromName, render(t.tpl.Subject), strings.TrimRight(render(t.tpl.Body), "\n"))
+	}
+
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateDSN builds the notification of type typ about originalMsg, a raw message, for
+// its sender. reason is the diagnostic of the delivery, its enhanced status code sets the
+// status of the report. No notification is generated about an automatic message
+func GenerateDSN(ctx context.Context, typ DSNType, originalMsg []byte, reason string) ([]byte, error) {
+	serverName := public.MustGetDockerEnv("BILLIONMAIL_HOSTNAME", "")
+	if serverName == "" {
+		serverName, _ = os.Hostname()
+	}
+	return GetDSNSettings(ctx).generate(typ, originalMsg, reason, serverName, time.Now())
+}
+
+// generate is the pure part of GenerateDSN
+func (s DSNSettings) generate(typ DSNType, originalMsg []byte, reason, serverName string, now time.Time) ([]byte, error) {
+	tpl, ok := s.template(typ)
+	if !ok {
+		return nil, fmt.Errorf("unknown notification type %q", typ)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(originalMsg))
+	if err != nil {
+		return nil, fmt.Errorf("unreadable original message: %w", err)
+	}
+
+	// Never answer an automatic message nor a bounce, that is how mail loops start
+	if auto := strings.ToLower(strings.TrimSpace(msg.Header.Get("Auto-Submitted"))); auto != "" && auto != "no" {
+		return nil, fmt.Errorf("original message is Auto-Submitted: %s, no notification", auto)
+	}
+	if rp := strings.TrimSpace(msg.Header.Get("Return-Path")); rp == "<>" {
+		return nil, fmt.Errorf("original message has a null sender, no notification")
+	}
+
+	sender := dsnAddress(msg.Header.Get("Return-Path"))
+	if sender == "" {
+		sender = dsnAddress(msg.Header.Get("From"))
+	}
+	if sender == "" {
+		return nil, fmt.Errorf("original message has no sender to notify")
+	}
+
+	recipient := ""
+	for _, h := range []string{"Original-Recipient", "X-Original-To", "Delivered-To", "To"} {
+		if recipient = dsnAddress(strings.TrimPrefix(msg.Header.Get(h), "rfc822;")); recipient != "" {
+			break
+		}
+	}
+
+	reason = strings.Join(strings.Fields(reason), " ")
+
+	vars := strings.NewReplacer("{original_recipient}", recipient, "{reason}", reason, "{server_name}", serverName)
+	subject := vars.Replace(tpl.Subject)
+	body := vars.Replace(tpl.Body)
+
+	action, status := dsnAction(typ, reason)
+	boundary := dsnBoundary()
+
+	var b strings.Builder
+	header := func(name, value string) {
+		b.WriteString(name + ": " + value + "\r\n")
+	}
+
+	header("From", mime.QEncoding.Encode("utf-8", dsnFromName)+" <MAILER-DAEMON@"+serverName+">")
+	header("To", "<"+sender+">")
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+boundary+"@"+serverName+">")
+	header("Auto-Submitted", "auto-replied")
+	header("MIME-Version", "1.0")
+	header("Content-Type", `multipart/report; report-type=delivery-status; boundary="`+boundary+`"`)
+	b.WriteString("\r\n")
+
+	// The text of the operator
+	b.WriteString("--" + boundary + "\r\n")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.TrimRight(body, "\n"), "\n", "\r\n") + "\r\n\r\n")
+
+	// The machine readable report
+	b.WriteString("--" + boundary + "\r\n")
+	header("Content-Type", "message/delivery-status")
+	b.WriteString("\r\n")
+	header("Reporting-MTA", "dns; "+serverName)
+	if date := msg.Header.Get("Date"); date != "" {
+		header("Arrival-Date", date)
+	}
+	b.WriteString("\r\n")
+	if recipient != "" {
+		header("Final-Recipient", "rfc822; "+recipient)
+		header("Original-Recipient", "rfc822; "+recipient)
+	}
+	header("Action", action)
+	header("Status", status)
+	if reason != "" {
+		header("Diagnostic-Code", "smtp; "+reason)
+	}
+	if typ == DSNDelay {
+		header("Last-Attempt-Date", now.Format(time.RFC1123Z))
+	}
+	b.WriteString("\r\n")
+
+	// The headers of the original message, not its content
+	b.WriteString("--" + boundary + "\r\n")
+	header("Content-Type", "text/rfc822-headers")
+	b.WriteString("\r\n")
+	if end := bytes.Index(originalMsg, []byte("\r\n\r\n")); end >= 0 {
+		b.Write(originalMsg[:end+2])
+	} else if end := bytes.Index(originalMsg, []byte("\n\n")); end >= 0 {
+		b.WriteString(strings.ReplaceAll(string(originalMsg[:end+1]), "\n", "\r\n"))
+	} else {
+		b.Write(originalMsg)
+	}
+	b.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return []byte(b.String()), nil
+}
+
+// dsnAction the action and status of the report, the status code of the reason when it
+// has one of the class of the notification
+func dsnAction(typ DSNType, reason string) (action, status string) {
+	action, class := "failed", "5"
+	switch typ {
+	case DSNSuccess:
+		action, class = "delivered", "2"
+	case DSNDelay:
+		action, class = "delayed", "4"
+	}
+
+	status = class + ".0.0"
+	if m := dsnStatusPattern.FindStringSubmatch(reason); m != nil && m[1] == class {
+		status = m[1] + "." + m[2] + "." + m[3]
+	}
+
+	return action, status
+}
+
+// dsnAddress the address of a header, empty when it has none
+func dsnAddress(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "<>" {
+		return ""
+	}
+	if addr, err := mail.ParseAddress(value); err == nil {
+		return addr.Address
+	}
+	if list, err := mail.ParseAddressList(value); err == nil && len(list) > 0 {
+		return list[0].Address
+	}
+	return strings.Trim(value, "<>")
+}
+
+// dsnBoundary a random MIME boundary, also the local part of the Message-ID
+func dsnBoundary() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		g.Log().Warningf(context.Background(), "Failed to generate a random notification boundary: %v", err)
+		return fmt.Sprintf("%s-%d", dsnBoundaryID, time.Now().UnixNano())
+	}
+	return dsnBoundaryID + "-" + hex.EncodeToString(buf)
+}
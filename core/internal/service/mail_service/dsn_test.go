@@ -0,0 +1,110 @@
+package mail_service
+
+import (
+	"bytes"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+const dsnOriginal = "Return-Path: <alice@example.com>\r\n" +
+	"From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.net\r\n" +
+	"Subject: hello\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"\r\n" +
+	"private body\r\n"
+
+func TestGenerateDSN(t *testing.T) {
+	s := DefaultDSNSettings()
+	s.Failure.Body = "Sorry from {server_name}, {original_recipient} refused it: {reason}\n"
+
+	raw, err := s.generate(DSNFailure, []byte(dsnOriginal), "550 5.1.1 <bob@example.net>: user unknown", "mail.example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Auto-Submitted"); got != "auto-replied" {
+		t.Errorf("Auto-Submitted %q", got)
+	}
+	if got := msg.Header.Get("To"); got != "<alice@example.com>" {
+		t.Errorf("To %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["report-type"] != "delivery-status" {
+		t.Fatalf("Content-Type %q", msg.Header.Get("Content-Type"))
+	}
+
+	var parts []string
+	var bodies []string
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		var b bytes.Buffer
+		b.ReadFrom(p)
+		parts = append(parts, p.Header.Get("Content-Type"))
+		bodies = append(bodies, b.String())
+	}
+
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "text/plain") || parts[1] != "message/delivery-status" || parts[2] != "text/rfc822-headers" {
+		t.Fatalf("parts %v", parts)
+	}
+	if !strings.Contains(bodies[0], "Sorry from mail.example.com, bob@example.net refused it: 550 5.1.1") {
+		t.Errorf("text %q", bodies[0])
+	}
+	for _, field := range []string{"Final-Recipient: rfc822; bob@example.net", "Action: failed", "Status: 5.1.1", "Diagnostic-Code: smtp; 550 5.1.1"} {
+		if !strings.Contains(bodies[1], field) {
+			t.Errorf("report misses %q: %q", field, bodies[1])
+		}
+	}
+	if strings.Contains(bodies[2], "private body") || !strings.Contains(bodies[2], "Subject: hello") {
+		t.Errorf("original headers %q", bodies[2])
+	}
+
+	// A status of another class is not taken from the reason
+	raw, _ = s.generate(DSNDelay, []byte(dsnOriginal), "451 5.1.1 later", "mail.example.com", time.Now())
+	if !bytes.Contains(raw, []byte("Status: 4.0.0")) || !bytes.Contains(raw, []byte("Action: delayed")) {
+		t.Errorf("delay report %q", raw)
+	}
+}
+
+func TestGenerateDSNNoLoop(t *testing.T) {
+	s := DefaultDSNSettings()
+	for _, msg := range []string{
+		"Auto-Submitted: auto-replied\r\n" + dsnOriginal,
+		"Return-Path: <>\r\nFrom: MAILER-DAEMON@example.com\r\n\r\nbounce\r\n",
+	} {
+		if _, err := s.generate(DSNFailure, []byte(msg), "550 5.0.0 no", "mail.example.com", time.Now()); err == nil {
+			t.Errorf("notification generated about %q", msg)
+		}
+	}
+}
+
+func TestDSNPostfixTemplates(t *testing.T) {
+	s := DefaultDSNSettings()
+	s.Failure.Body = "Costs $5 at {server_name}: {reason}\n"
+
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+	out := s.postfixTemplates()
+	if !strings.Contains(out, "failure_template = <<EOF\n") || !strings.Contains(out, "Costs $$5 at $myhostname: see the report below\nEOF\n") {
+		t.Errorf("templates %q", out)
+	}
+
+	s.Delay.Body = "{unknown}"
+	if err := s.validate(); err == nil {
+		t.Error("unknown variable accepted")
+	}
+}
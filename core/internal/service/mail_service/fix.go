@@ -61,6 +61,12 @@ endif
 		}
 	}
 
+	// outbound header privacy rules, the table must exist before postfix reloads
+	if err = WriteHeaderPrivacyChecks(ctx); err != nil {
+		g.Log().Warning(ctx, "Failed to write header privacy checks file: %v", err)
+		return
+	}
+
 	lines := make([]string, 0)
 	skipNextEmptyLine := false
 	containsHostname := false
@@ -84,7 +90,7 @@ endif
 			lines = append(lines, "compatibility_level = 3.7\n")
 			lines = append(lines, "mail_name = PostBillionMail\n")
 			lines = append(lines, "\n")
-			lines = append(lines, "smtp_header_checks = pcre:/etc/postfix/conf/header_checks\n")
+			lines = append(lines, "smtp_header_checks = pcre:/etc/postfix/conf/header_checks, pcre:/etc/postfix/conf/"+headerPrivacyFile+"\n")
 			lines = append(lines, "lmtp_header_checks = pcre:/etc/postfix/conf/header_checks\n\n")
 			return true
 		}
@@ -147,6 +153,16 @@ endif
 		return
 	}
 
+	// STARTTLS required policy of the listeners, applied with the same reload
+	if err = WriteStartTLSPolicy(ctx); err != nil {
+		g.Log().Warning(ctx, "Failed to apply the STARTTLS policy to the Postfix master configuration: %v", err)
+	}
+
+	// Protocol logging of the listeners, applied with the same reload
+	if err = WriteListenerOptions(ctx); err != nil {
+		g.Log().Warning(ctx, "Failed to apply the listener options to the Postfix master configuration: %v", err)
+	}
+
 	dk, err := docker.NewDockerAPI()
 
 	if err != nil {
@@ -0,0 +1,226 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// -----------------------------
+// Header privacy of outbound mail. Before a message leaves the server, the Received
+// lines of hops with an internal address are rewritten to a local hop and the
+// configured headers are removed. Rewriting keeps the number of Received lines, so the
+// loop detection of the next hops still counts this server. The rules are rendered into
+// a pcre table used by smtp_header_checks, SanitizeHeaders applies the same rules.
+// -----------------------------
+
+const (
+	headerPrivacyOptionKey = "header_privacy"
+	headerPrivacyFile      = "header_privacy_checks"
+)
+
+// internalAddressPattern loopback, private, link-local and unique local addresses
+const internalAddressPattern = `127(?:\.\d{1,3}){3}|10(?:\.\d{1,3}){3}|192\.168(?:\.\d{1,3}){2}|172\.(?:1[6-9]|2\d|3[01])(?:\.\d{1,3}){2}|169\.254(?:\.\d{1,3}){2}|IPv6:(?:::1|f[cd][0-9a-f]{0,2}:[0-9a-f:]*|fe80:[0-9a-f:]*)`
+
+// receivedReplacement the from clause of a rewritten Received line, the by clause is kept
+const receivedReplacement = "Received: from localhost (localhost [127.0.0.1]) ${1}"
+
+var headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+\*?$`)
+
+// HeaderPrivacy outbound header sanitization settings
+type HeaderPrivacy struct {
+	Enabled           bool     `json:"enabled"`
+	RewriteReceived   bool     `json:"rewrite_received"`   // rewrite the Received lines of internal hops
+	InternalAddresses []string `json:"internal_addresses"` // extra patterns of internal addresses or host names
+	StripHeaders      []string `json:"strip_headers"`      // header names removed, a trailing * matches a prefix
+}
+
+// headerRule one header check, action is IGNORE or REPLACE followed by the new header
+type headerRule struct {
+	pattern *regexp.Regexp
+	action  string
+}
+
+// DefaultHeaderPrivacy conservative defaults: only the internal hops and the headers
+// carrying client addresses are touched
+func DefaultHeaderPrivacy() HeaderPrivacy {
+	return HeaderPrivacy{
+		Enabled:         true,
+		RewriteReceived: true,
+		StripHeaders:    []string{"X-Originating-IP", "X-Forwarded-For", "X-Real-IP"},
+	}
+}
+
+// GetHeaderPrivacy returns the configured settings, the defaults when unset
+func GetHeaderPrivacy(ctx context.Context) HeaderPrivacy {
+	p := DefaultHeaderPrivacy()
+	_ = public.OptionsMgrInstance.GetOption(ctx, headerPrivacyOptionKey, &p)
+	return p
+}
+
+// SetHeaderPrivacy validates and saves the settings, then applies them to postfix
+func SetHeaderPrivacy(ctx context.Context, p HeaderPrivacy) error {
+	if _, err := p.rules(); err != nil {
+		return err
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, headerPrivacyOptionKey, p); err != nil {
+		return err
+	}
+
+	if err := WriteHeaderPrivacyChecks(ctx); err != nil {
+		return err
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	_, err = dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postfix", "reload"}, "root")
+	return err
+}
+
+// WriteHeaderPrivacyChecks renders the configured rules into the pcre table, empty when disabled
+func WriteHeaderPrivacyChecks(ctx context.Context) error {
+	rules, err := GetHeaderPrivacy(ctx).rules()
+	if err != nil {
+		return err
+	}
+
+	var content strings.Builder
+	content.WriteString("# Managed by BillionMail, outbound header privacy rules\n")
+	for _, rule := range rules {
+		content.WriteString(fmt.Sprintf("/%s/ %s\n", strings.ReplaceAll(rule.pattern.String(), "/", `\/`), rule.action))
+	}
+
+	_, err = public.WriteFile(public.AbsPath(filepath.Join(consts.POSTFIX_CONF_PATH, headerPrivacyFile)), content.String())
+	return err
+}
+
+// rules the header checks of the settings, in the order they apply
+func (p HeaderPrivacy) rules() ([]headerRule, error) {
+	if !p.Enabled {
+		return nil, nil
+	}
+
+	rules := make([]headerRule, 0, 2)
+
+	if p.RewriteReceived {
+		addresses := []string{internalAddressPattern}
+		for _, address := range p.InternalAddresses {
+			if _, err := regexp.Compile(address); err != nil || address == "" {
+				return nil, fmt.Errorf("invalid internal address pattern %q", address)
+			}
+			addresses = append(addresses, address)
+		}
+
+		pattern, err := regexp.Compile(`(?is)^Received:\s*from\s[^\[]*\[(?:` + strings.Join(addresses, "|") + `)\][^)]*\)?\s*(by\s.*)$`)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, headerRule{pattern: pattern, action: "REPLACE " + receivedReplacement})
+	}
+
+	if len(p.StripHeaders) > 0 {
+		names := make([]string, 0, len(p.StripHeaders))
+		for _, name := range p.StripHeaders {
+			if !headerNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("invalid header name %q", name)
+			}
+			if strings.HasSuffix(name, "*") {
+				names = append(names, regexp.QuoteMeta(strings.TrimSuffix(name, "*"))+`[^:\s]*`)
+			} else {
+				names = append(names, regexp.QuoteMeta(name))
+			}
+		}
+
+		pattern, err := regexp.Compile(`(?i)^(?:` + strings.Join(names, "|") + `)\s*:`)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, headerRule{pattern: pattern, action: "IGNORE"})
+	}
+
+	return rules, nil
+}
+
+// SanitizeHeaders applies the settings to the headers of a raw message like postfix does,
+// the first matching rule applies to each header, the body is left untouched
+func (p HeaderPrivacy) SanitizeHeaders(message []byte) ([]byte, error) {
+	rules, err := p.rules()
+	if err != nil || len(rules) == 0 {
+		return message, err
+	}
+
+	out := make([]byte, 0, len(message))
+	rest := message
+
+	// flush applies the rules to one logical header made of its physical lines
+	flush := func(lines [][]byte) {
+		if len(lines) == 0 {
+			return
+		}
+
+		var header []byte
+		for _, line := range lines {
+			header = append(header, bytes.TrimRight(line, "\r\n")...)
+			header = append(header, '\n')
+		}
+		header = header[:len(header)-1]
+
+		for _, rule := range rules {
+			match := rule.pattern.FindSubmatchIndex(header)
+			if match == nil {
+				continue
+			}
+
+			if rule.action == "IGNORE" {
+				return
+			}
+
+			replaced := rule.pattern.Expand(nil, []byte(strings.TrimPrefix(rule.action, "REPLACE ")), header, match)
+			eol := lines[len(lines)-1][len(bytes.TrimRight(lines[len(lines)-1], "\r\n")):]
+			if bytes.Equal(eol, []byte("\r\n")) {
+				replaced = bytes.ReplaceAll(replaced, []byte("\n"), eol)
+			}
+			out = append(append(out, replaced...), eol...)
+			return
+		}
+
+		for _, line := range lines {
+			out = append(out, line...)
+		}
+	}
+
+	var lines [][]byte
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+
+		// End of the headers
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			flush(lines)
+			lines = nil
+		}
+		lines = append(lines, line)
+		rest = rest[end:]
+	}
+	flush(lines)
+
+	return append(out, rest...), nil
+}
@@ -0,0 +1,79 @@
+package mail_service
+
+import (
+	"strings"
+	"testing"
+)
+
+const internalHopsMessage = "Received: from mail.example.com (mail.example.com [203.0.113.10])\r\n" +
+	"\tby relay.example.net (Postfix) with ESMTPS id 1A2B3C\r\n" +
+	"\tfor <bob@example.net>; Mon, 02 Jan 2006 15:04:07 +0000\r\n" +
+	"Received: from core (billionmail-core-1.billionmail_network [172.18.0.5])\r\n" +
+	"\tby mail.example.com (PostBillionMail) with ESMTPSA id 4D5E6F\r\n" +
+	"\tfor <bob@example.net>; Mon, 02 Jan 2006 15:04:06 +0000\r\n" +
+	"Received: from [IPv6:fd00::12] (unknown [IPv6:fd00::12])\r\n" +
+	"\tby mail.example.com (PostBillionMail) with ESMTP; Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"X-Originating-IP: [10.1.2.3]\r\n" +
+	"X-Internal-Queue: worker-7\r\n" +
+	"From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.net\r\n" +
+	"Subject: hello\r\n" +
+	"\r\n" +
+	"Received: from body [10.0.0.1] by nobody\r\n"
+
+func TestSanitizeHeadersHidesInternalHops(t *testing.T) {
+	p := DefaultHeaderPrivacy()
+	p.StripHeaders = append(p.StripHeaders, "X-Internal-*")
+
+	out, err := p.SanitizeHeaders([]byte(internalHopsMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, body, _ := strings.Cut(string(out), "\r\n\r\n")
+
+	for _, leak := range []string{"172.18.0.5", "billionmail_network", "fd00::12", "10.1.2.3", "X-Originating-IP", "X-Internal-Queue"} {
+		if strings.Contains(header, leak) {
+			t.Errorf("sanitized headers still expose %q:\n%s", leak, header)
+		}
+	}
+
+	// Loop detection of the next hops still counts every hop
+	if n := strings.Count(header, "Received:"); n != 3 {
+		t.Errorf("expected 3 Received lines, got %d:\n%s", n, header)
+	}
+	if !strings.Contains(header, "[203.0.113.10]") {
+		t.Errorf("public hops should be kept:\n%s", header)
+	}
+	if !strings.Contains(header, "Received: from localhost (localhost [127.0.0.1]) by mail.example.com (PostBillionMail) with ESMTPSA id 4D5E6F\r\n\tfor <bob@example.net>") {
+		t.Errorf("internal hop should keep its by clause:\n%s", header)
+	}
+	if strings.Contains(strings.ReplaceAll(header, "\r\n", ""), "\n") {
+		t.Errorf("line endings should stay CRLF:\n%q", header)
+	}
+	if body != "Received: from body [10.0.0.1] by nobody\r\n" {
+		t.Errorf("body should be untouched, got %q", body)
+	}
+}
+
+func TestSanitizeHeadersDisabledAndInvalid(t *testing.T) {
+	p := DefaultHeaderPrivacy()
+	p.Enabled = false
+
+	out, err := p.SanitizeHeaders([]byte(internalHopsMessage))
+	if err != nil || string(out) != internalHopsMessage {
+		t.Errorf("disabled settings should leave the message unchanged, err %v", err)
+	}
+
+	p = DefaultHeaderPrivacy()
+	p.StripHeaders = []string{"X-Bad:Header"}
+	if _, err = p.SanitizeHeaders([]byte(internalHopsMessage)); err == nil {
+		t.Errorf("an invalid header name should be refused")
+	}
+
+	p = DefaultHeaderPrivacy()
+	p.InternalAddresses = []string{"("}
+	if _, err = p.rules(); err == nil {
+		t.Errorf("an invalid address pattern should be refused")
+	}
+}
@@ -0,0 +1,462 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/service/public"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"unicode/utf8"
+)
+
+// -----------------------------
+// Transfer encoding normalization of outgoing messages, opt-in, run after the submission
+// validation. The parts whose encoding a strict receiver may refuse are re-encoded: 8-bit
+// content declared 7bit or sent as 8bit/binary, quoted-printable and base64 with lines
+// over 76 characters or broken escapes, and 8-bit text without a charset gets one. Parts
+// that are already valid are left byte for byte as they are, as are the parts it cannot
+// decode, an unknown encoding and the encapsulated messages. Signed content is never
+// touched: a message with a DKIM or ARC signature is left whole, and so are the
+// multipart/signed, multipart/encrypted and S/MIME parts.
+// -----------------------------
+
+const (
+	mimeNormalizationOptionKey = "mime_normalization"
+
+	// DefaultMIMECharset charset declared for 8-bit text without one that is not UTF-8
+	DefaultMIMECharset = "windows-1252"
+
+	maxEncodedLineLength = 76 // RFC 2045 section 6.7 and 6.8
+	maxMIMEDepth         = 20
+)
+
+// MIMENormalization transfer encoding normalization settings
+type MIMENormalization struct {
+	Enabled        bool   `json:"enabled"`
+	DefaultCharset string `json:"default_charset"` // DefaultMIMECharset when empty
+}
+
+// unlimitedHeaders parses the header blocks of the parts, the limits apply to the message
+var unlimitedHeaders = ValidationConfig{MaxHeaders: math.MaxInt32, MaxHeaderBytes: math.MaxInt32}
+
+// signedMediaTypes parts whose content is covered by a signature or encrypted
+var signedMediaTypes = map[string]bool{
+	"multipart/signed":         true,
+	"multipart/encrypted":      true,
+	"application/pkcs7-mime":   true,
+	"application/x-pkcs7-mime": true,
+}
+
+// GetMIMENormalization returns the stored settings, disabled when unset
+func GetMIMENormalization(ctx context.Context) MIMENormalization {
+	n := MIMENormalization{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, mimeNormalizationOptionKey, &n)
+	if n.DefaultCharset == "" {
+		n.DefaultCharset = DefaultMIMECharset
+	}
+	return n
+}
+
+// SetMIMENormalization stores the settings
+func SetMIMENormalization(ctx context.Context, n MIMENormalization) error {
+	if n.DefaultCharset != "" && !validToken(n.DefaultCharset) {
+		return fmt.Errorf("invalid charset %q", n.DefaultCharset)
+	}
+	return public.OptionsMgrInstance.SetOption(ctx, mimeNormalizationOptionKey, n)
+}
+
+func validToken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?=`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeMIME re-encodes the parts of a complete message, CRLF line endings, that a
+// strict receiver may refuse. It returns the message as it is and false when nothing
+// needed a change
+func NormalizeMIME(msg []byte, n MIMENormalization) ([]byte, bool) {
+	if n.DefaultCharset == "" {
+		n.DefaultCharset = DefaultMIMECharset
+	}
+
+	header, body := splitMessage(msg)
+	fields, err := parseHeaderFields(header, unlimitedHeaders)
+	if err != nil {
+		return msg, false
+	}
+
+	// Any change would break the body hash of the signature
+	if countFields(fields, "DKIM-Signature") > 0 || countFields(fields, "ARC-Message-Signature") > 0 {
+		return msg, false
+	}
+
+	fields, body, changed := normalizeEntity(fields, body, "text/plain", n, 0)
+	if !changed {
+		return msg, false
+	}
+
+	if countFields(fields, "MIME-Version") == 0 {
+		fields = append(fields, headerField{Name: "MIME-Version", Value: "1.0"})
+	}
+	return buildMessage(fields, body), true
+}
+
+// normalizeEntity normalizes a message or a body part, defaultType is its media type
+// when it declares none
+func normalizeEntity(fields []headerField, body []byte, defaultType string, n MIMENormalization, depth int) ([]headerField, []byte, bool) {
+	mediaType, params := defaultType, map[string]string{}
+	if value, ok := fieldValue(fields, "Content-Type"); ok {
+		var err error
+		if mediaType, params, err = mime.ParseMediaType(value); err != nil {
+			return fields, body, false
+		}
+	}
+
+	switch {
+	case signedMediaTypes[mediaType]:
+		return fields, body, false
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if depth >= maxMIMEDepth || params["boundary"] == "" {
+			return fields, body, false
+		}
+		partType := "text/plain"
+		if mediaType == "multipart/digest" {
+			partType = "message/rfc822"
+		}
+		body, changed := normalizeMultipart(body, params["boundary"], partType, n, depth+1)
+		return fields, body, changed
+	case strings.HasPrefix(mediaType, "message/"):
+		// An encapsulated message has its own encodings and may be signed
+		return fields, body, false
+	}
+
+	return normalizeLeaf(fields, body, mediaType, params, n)
+}
+
+// mimeDelimiter a boundary line of a multipart body, from start to end before its CRLF
+type mimeDelimiter struct {
+	start, end int
+	closing    bool
+}
+
+// normalizeMultipart normalizes the parts of a multipart body, the boundaries, the
+// preamble and the epilogue are kept. A body without its closing boundary is left as it is
+func normalizeMultipart(body []byte, boundary, partType string, n MIMENormalization, depth int) ([]byte, bool) {
+	delimiters := findDelimiters(body, boundary)
+	if len(delimiters) < 2 || !delimiters[len(delimiters)-1].closing {
+		return body, false
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(body)+len(body)/8))
+	out.Write(body[:delimiters[0].start])
+
+	changed := false
+	for i, d := range delimiters[:len(delimiters)-1] {
+		next := delimiters[i+1]
+		from, to := d.end+2, next.start-2
+		if from > to {
+			return body, false
+		}
+
+		part := body[from:to]
+		header, content := splitMessage(part)
+		fields, err := parseHeaderFields(header, unlimitedHeaders)
+		if err == nil {
+			var partChanged bool
+			if fields, content, partChanged = normalizeEntity(fields, content, partType, n, depth); partChanged {
+				part = buildMessage(fields, content)
+				changed = true
+			}
+		}
+
+		out.Write(body[d.start:d.end])
+		out.WriteString("\r\n")
+		out.Write(part)
+		out.WriteString("\r\n")
+	}
+
+	closing := delimiters[len(delimiters)-1]
+	out.Write(body[closing.start:])
+
+	if !changed {
+		return body, false
+	}
+	return out.Bytes(), true
+}
+
+// findDelimiters the boundary lines of a multipart body up to the closing one
+func findDelimiters(body []byte, boundary string) []mimeDelimiter {
+	delim := []byte("--" + boundary)
+	var delimiters []mimeDelimiter
+
+	for pos := 0; pos <= len(body); {
+		end := bytes.Index(body[pos:], []byte("\r\n"))
+		lineEnd := len(body)
+		if end >= 0 {
+			lineEnd = pos + end
+		}
+
+		if line := body[pos:lineEnd]; bytes.HasPrefix(line, delim) {
+			// Transport padding may follow the boundary
+			switch rest := bytes.TrimRight(line[len(delim):], " \t"); string(rest) {
+			case "":
+				delimiters = append(delimiters, mimeDelimiter{start: pos, end: lineEnd})
+			case "--":
+				return append(delimiters, mimeDelimiter{start: pos, end: lineEnd, closing: true})
+			}
+		}
+
+		if end < 0 {
+			break
+		}
+		pos = lineEnd + 2
+	}
+
+	return delimiters
+}
+
+// normalizeLeaf re-encodes a single part when its transfer encoding is not valid for its
+// content, and declares the charset of 8-bit text without one
+func normalizeLeaf(fields []headerField, body []byte, mediaType string, params map[string]string, n MIMENormalization) ([]headerField, []byte, bool) {
+	encodingValue, _ := fieldValue(fields, "Content-Transfer-Encoding")
+
+	var content []byte
+	switch encoding := strings.ToLower(strings.TrimSpace(encodingValue)); encoding {
+	case "", "7bit":
+		if valid7bit(body) {
+			return fields, body, false
+		}
+		content = body
+	case "8bit", "binary":
+		content = body
+	case "quoted-printable":
+		if validQuotedPrintable(body) {
+			return fields, body, false
+		}
+		content = decodeQuotedPrintable(body)
+	case "base64":
+		if validBase64(body) {
+			return fields, body, false
+		}
+		var err error
+		if content, err = decodeBase64(body); err != nil {
+			return fields, body, false
+		}
+	default:
+		return fields, body, false
+	}
+
+	text := strings.HasPrefix(mediaType, "text/")
+
+	if text && params["charset"] == "" && has8bit(content) {
+		charset := n.DefaultCharset
+		if utf8.Valid(content) {
+			charset = "utf-8"
+		}
+		params["charset"] = charset
+		fields = setField(fields, "Content-Type", mime.FormatMediaType(mediaType, params))
+	}
+
+	var encoded []byte
+	if text && !mostlyBinary(content) {
+		buf := new(bytes.Buffer)
+		w := quotedprintable.NewWriter(buf)
+		_, _ = w.Write(content)
+		_ = w.Close()
+		encoded = buf.Bytes()
+		fields = setField(fields, "Content-Transfer-Encoding", "quoted-printable")
+	} else {
+		encoded = encodeBase64Lines(content)
+		fields = setField(fields, "Content-Transfer-Encoding", "base64")
+	}
+
+	if bytes.HasSuffix(body, []byte("\r\n")) && !bytes.HasSuffix(encoded, []byte("\r\n")) {
+		encoded = append(encoded, '\r', '\n')
+	}
+
+	return fields, encoded, true
+}
+
+// fieldValue the trimmed value of the first field with the given name
+func fieldValue(fields []headerField, name string) (string, bool) {
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			return strings.TrimSpace(f.Value), true
+		}
+	}
+	return "", false
+}
+
+// setField replaces the value of the first field with the given name, or appends it
+func setField(fields []headerField, name, value string) []headerField {
+	for i, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			fields[i] = headerField{Name: f.Name, Value: value}
+			return fields
+		}
+	}
+	return append(fields, headerField{Name: name, Value: value})
+}
+
+// valid7bit reports whether a body is valid 7bit data: no 8-bit byte, no NUL and lines
+// of at most 998 characters
+func valid7bit(body []byte) bool {
+	return !has8bit(body) && bytes.IndexByte(body, 0) < 0 && maxLineLength(body) <= maxHeaderLineLength
+}
+
+// validQuotedPrintable reports whether a body is strictly valid quoted-printable
+func validQuotedPrintable(body []byte) bool {
+	for _, line := range bytes.Split(body, []byte("\r\n")) {
+		if len(line) > maxEncodedLineLength {
+			return false
+		}
+		for i := 0; i < len(line); i++ {
+			switch c := line[i]; {
+			case c == '=':
+				if i == len(line)-1 {
+					continue // soft line break
+				}
+				if i+2 >= len(line) || !isHex(line[i+1]) || !isHex(line[i+2]) {
+					return false
+				}
+				i += 2
+			case c == ' ' || c == '\t':
+				if i == len(line)-1 {
+					return false // trailing white space is lost in transit
+				}
+			case c < '!' || c > '~':
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// decodeQuotedPrintable decodes a quoted-printable body leniently, a broken escape is
+// kept as it is written
+func decodeQuotedPrintable(body []byte) []byte {
+	out := make([]byte, 0, len(body))
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '=' {
+			out = append(out, c)
+			continue
+		}
+		switch {
+		case i+2 < len(body) && body[i+1] == '\r' && body[i+2] == '\n':
+			i += 2 // soft line break
+		case i+2 < len(body) && isHex(body[i+1]) && isHex(body[i+2]):
+			out = append(out, unhex(body[i+1])<<4|unhex(body[i+2]))
+			i += 2
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// validBase64 reports whether a body is base64 with lines of at most 76 characters
+func validBase64(body []byte) bool {
+	var data []byte
+	for _, line := range bytes.Split(body, []byte("\r\n")) {
+		if len(line) > maxEncodedLineLength {
+			return false
+		}
+		data = append(data, line...)
+	}
+	_, err := base64.StdEncoding.DecodeString(string(data))
+	return err == nil
+}
+
+// decodeBase64 decodes a base64 body, ignoring the characters outside of the alphabet
+// and a missing padding. Data after the padding means a corrupted body, it fails
+func decodeBase64(body []byte) ([]byte, error) {
+	data := make([]byte, 0, len(body))
+	padded := false
+	for _, c := range body {
+		switch {
+		case c == '=':
+			padded = true
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/':
+			if padded {
+				return nil, fmt.Errorf("base64 data after the padding")
+			}
+			data = append(data, c)
+		}
+	}
+	return base64.RawStdEncoding.DecodeString(string(data))
+}
+
+// encodeBase64Lines base64 in CRLF terminated lines of 76 characters
+func encodeBase64Lines(content []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(content)
+
+	out := make([]byte, 0, len(encoded)+len(encoded)/maxEncodedLineLength*2+2)
+	for len(encoded) > maxEncodedLineLength {
+		out = append(out, encoded[:maxEncodedLineLength]...)
+		out = append(out, '\r', '\n')
+		encoded = encoded[maxEncodedLineLength:]
+	}
+	return append(out, encoded...)
+}
+
+// mostlyBinary reports whether more than half of the characters of a text would be
+// escaped by quoted-printable, base64 is then the smaller and safer encoding. The
+// characters of UTF-8 text are counted as runes
+func mostlyBinary(content []byte) bool {
+	valid := utf8.Valid(content)
+
+	escaped, total := 0, 0
+	for i := 0; i < len(content); total++ {
+		c, size := content[i], 1
+		if valid && c >= 0x80 {
+			_, size = utf8.DecodeRune(content[i:])
+		}
+		if c >= 0x80 || c < ' ' && c != '\r' && c != '\n' && c != '\t' {
+			escaped++
+		}
+		i += size
+	}
+	return escaped*2 > total
+}
+
+func has8bit(data []byte) bool {
+	for _, c := range data {
+		if c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+func maxLineLength(data []byte) int {
+	longest := 0
+	for _, line := range bytes.Split(data, []byte("\r\n")) {
+		if len(line) > longest {
+			longest = len(line)
+		}
+	}
+	return longest
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'F' || c >= 'a' && c <= 'f'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,225 @@
+package mail_service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+const mimeHeader = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.org\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"Subject: hello\r\n" +
+	"MIME-Version: 1.0\r\n"
+
+// decodedPart the decoded content of a leaf part, checking that its encoding is valid
+// for a strict receiver
+func decodedPart(t *testing.T, header mail.Header, body []byte) (string, string) {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type %q: %v", header.Get("Content-Type"), err)
+	}
+
+	encoding := strings.ToLower(header.Get("Content-Transfer-Encoding"))
+	for _, line := range strings.Split(string(body), "\r\n") {
+		limit := maxEncodedLineLength
+		if encoding == "" || encoding == "7bit" {
+			limit = maxHeaderLineLength
+		}
+		if len(line) > limit {
+			t.Errorf("%s line of %d characters", encoding, len(line))
+		}
+	}
+	if has8bit(body) {
+		t.Errorf("8-bit data left in a %s %s part", encoding, mediaType)
+	}
+
+	var r io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.ReplaceAll(body, []byte("\r\n"), nil)))
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decode %s: %v", encoding, err)
+	}
+
+	return string(content), params["charset"]
+}
+
+func normalizeOrFail(t *testing.T, msg string) *mail.Message {
+	t.Helper()
+
+	out, changed := NormalizeMIME([]byte(msg), MIMENormalization{Enabled: true})
+	if !changed {
+		t.Fatalf("message not normalized:\n%s", msg)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("normalized message unreadable: %v\n%s", err, out)
+	}
+	return parsed
+}
+
+func TestNormalizeLeafEncodings(t *testing.T) {
+	longLine := strings.Repeat("abcdefghij", 120)
+	binary := string([]byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe, 0x10, 0x80, 0x81})
+
+	cases := map[string]struct {
+		headers string
+		body    string
+		want    string
+		charset string
+		encode  string
+	}{
+		"utf-8 declared 7bit without charset": {
+			"Content-Type: text/plain\r\nContent-Transfer-Encoding: 7bit\r\n", "Grüße aus Köln\r\n",
+			"Grüße aus Köln\r\n", "utf-8", "quoted-printable"},
+		"latin-1 without any declaration": {
+			"", "Caf\xe9 cr\xe8me\r\n",
+			"Caf\xe9 cr\xe8me\r\n", DefaultMIMECharset, "quoted-printable"},
+		"8bit transfer encoding": {
+			"Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n", "<p>naïve</p>\r\n",
+			"<p>naïve</p>\r\n", "utf-8", "quoted-printable"},
+		"line over 998 characters": {
+			"Content-Type: text/plain; charset=us-ascii\r\n", longLine + "\r\n",
+			longLine + "\r\n", "us-ascii", "quoted-printable"},
+		"quoted-printable line too long": {
+			"Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n", longLine + "\r\n",
+			longLine + "\r\n", "utf-8", "quoted-printable"},
+		"quoted-printable broken escape": {
+			"Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: Quoted-Printable\r\n", "100% =E2=9C=93 at =ZZ\r\n",
+			"100% ✓ at =ZZ\r\n", "utf-8", "quoted-printable"},
+		"base64 on one line": {
+			"Content-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n", base64.StdEncoding.EncodeToString([]byte(longLine)) + "\r\n",
+			longLine, "", "base64"},
+		"base64 without padding and with junk": {
+			"Content-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n", strings.TrimRight(base64.StdEncoding.EncodeToString([]byte("hello world!?")), "=") + " *\r\n",
+			"hello world!?", "", "base64"},
+		"binary attachment sent 8bit": {
+			"Content-Type: image/png\r\nContent-Transfer-Encoding: binary\r\n", binary,
+			binary, "", "base64"},
+	}
+
+	for name, c := range cases {
+		parsed := normalizeOrFail(t, mimeHeader+c.headers+"\r\n"+c.body)
+		body, _ := io.ReadAll(parsed.Body)
+
+		if got := strings.ToLower(parsed.Header.Get("Content-Transfer-Encoding")); got != c.encode {
+			t.Errorf("%s: encoded as %q, want %s", name, got, c.encode)
+		}
+		content, charset := decodedPart(t, parsed.Header, body)
+		if content != c.want {
+			t.Errorf("%s: content %q, want %q", name, content, c.want)
+		}
+		if charset != c.charset {
+			t.Errorf("%s: charset %q, want %q", name, charset, c.charset)
+		}
+		if parsed.Header.Get("Subject") != "hello" || parsed.Header.Get("From") != "Alice <alice@example.com>" {
+			t.Errorf("%s: headers changed: %v", name, parsed.Header)
+		}
+	}
+}
+
+func TestNormalizeLeavesValidMessages(t *testing.T) {
+	messages := map[string]string{
+		"plain ascii": mimeHeader + "Content-Type: text/plain\r\n\r\nhello\r\n",
+		"quoted-printable": mimeHeader + "Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+			"Gr=C3=BC=C3=9Fe=\r\n aus K=C3=B6ln\r\n",
+		"base64": mimeHeader + "Content-Type: application/pdf\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+			"JVBERi0xLjQK\r\n",
+		"unknown encoding": mimeHeader + "Content-Type: application/octet-stream\r\nContent-Transfer-Encoding: x-uuencode\r\n\r\n" +
+			"begin 644 f\r\n\xff\xfe\r\n",
+		"dkim signed": "DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=s1; bh=x; b=y\r\n" + mimeHeader +
+			"Content-Type: text/plain\r\n\r\nGrüße\r\n",
+		"encapsulated message": mimeHeader + "Content-Type: message/rfc822\r\n\r\n" +
+			"Subject: inner\r\n\r\nGrüße " + strings.Repeat("x", 1200) + "\r\n",
+		"broken base64": mimeHeader + "Content-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+			"QUJD=RA\r\n",
+		"multipart without closing boundary": mimeHeader + "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nGrüße\r\n",
+	}
+
+	for name, msg := range messages {
+		out, changed := NormalizeMIME([]byte(msg), MIMENormalization{Enabled: true})
+		if changed || string(out) != msg {
+			t.Errorf("%s: message changed:\n%q", name, out)
+		}
+	}
+}
+
+func TestNormalizeMultipart(t *testing.T) {
+	long := strings.Repeat("0123456789", 150)
+	signed := "--s\r\nContent-Type: text/plain\r\n\r\nsigned Grüße\r\n" +
+		"--s\r\nContent-Type: application/pkcs7-signature\r\nContent-Transfer-Encoding: base64\r\n\r\n" + strings.Repeat("A", 200) + "\r\n" +
+		"--s--\r\n"
+
+	msg := mimeHeader + "Content-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n" +
+		"This is a multi-part message in MIME format.\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+		"--inner\r\nContent-Type: text/plain\r\n\r\nGrüße\r\n" +
+		"--inner\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n<p>" + long + "</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; boundary=s\r\n\r\n" + signed +
+		"--outer\r\n" +
+		"Content-Type: text/plain; charset=us-ascii\r\nContent-Disposition: attachment; filename=\"ok.txt\"\r\n\r\nalready fine\r\n" +
+		"--outer--\r\n" +
+		"epilogue\r\n"
+
+	parsed := normalizeOrFail(t, msg)
+	raw, _ := io.ReadAll(parsed.Body)
+
+	if !bytes.HasPrefix(raw, []byte("This is a multi-part message in MIME format.\r\n--outer\r\n")) || !bytes.HasSuffix(raw, []byte("--outer--\r\nepilogue\r\n")) {
+		t.Errorf("preamble or epilogue changed:\n%s", raw)
+	}
+	// The signed part is kept byte for byte
+	if !bytes.Contains(raw, []byte(signed)) {
+		t.Errorf("signed part changed:\n%s", raw)
+	}
+
+	var contents []string
+	var walk func(r *multipart.Reader)
+	walk = func(r *multipart.Reader) {
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			mediaType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			if mediaType == "multipart/alternative" {
+				walk(multipart.NewReader(p, params["boundary"]))
+				continue
+			}
+			if mediaType == "multipart/signed" {
+				continue
+			}
+			body, _ := io.ReadAll(p)
+			content, _ := decodedPart(t, mail.Header(p.Header), body)
+			contents = append(contents, content)
+		}
+	}
+	walk(multipart.NewReader(bytes.NewReader(raw), "outer"))
+
+	want := []string{"Grüße", "<p>" + long + "</p>", "already fine"}
+	if strings.Join(contents, "|") != strings.Join(want, "|") {
+		t.Errorf("parts %q, want %q", contents, want)
+	}
+	if !bytes.Contains(raw, []byte("Content-Disposition: attachment; filename=\"ok.txt\"\r\n\r\nalready fine\r\n")) {
+		t.Errorf("valid part changed:\n%s", raw)
+	}
+}
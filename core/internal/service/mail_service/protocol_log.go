@@ -0,0 +1,320 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/public"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -----------------------------
+// Protocol logging of single listeners, to debug one port without verbose logging
+// everywhere. A master.cf service with ProtocolLog runs its smtpd with -v, appended
+// below a marker line like the STARTTLS policy, and postfix logs its SMTP dialogue.
+// The command and response lines of the service are copied from the mail log into
+// core/smtp-protocol-<listener>-YYYYMMDD.log, a group of the standard logs the log
+// maintenance compresses and expires like the others. The lines are redacted with the
+// credentials rules of the shared redactor: the initial response of AUTH and the client
+// lines answering a 334 challenge never reach the file in clear. The lines are told
+// apart by the syslog name of the service, two listeners sharing one are both copied
+// to the file of the first.
+// -----------------------------
+
+const (
+	listenerOptionsKey = "listener_options"
+	protocolLogMarker  = "#  Protocol logging, managed by BillionMail"
+
+	// protocolLogReadLimit bytes of the mail log copied by one collection
+	protocolLogReadLimit = 16 << 20
+)
+
+var (
+	protocolLogOptionPattern = regexp.MustCompile(`^\s+-v$`)
+	protocolLogFilePattern   = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+	// "postfix/submission/smtpd[123]: < client.example.com[192.0.2.1]: EHLO client"
+	protocolLinePattern = regexp.MustCompile(`\s(\S+)\[(\d+)\]: ([<>]) (\S+?\[[^\]]*\](?::\d+)?): (.*)$`)
+)
+
+// ListenerOptions options of a listener, a master.cf service
+type ListenerOptions struct {
+	ProtocolLog bool `json:"protocol_log"` // copy its SMTP dialogue to a dedicated log
+}
+
+// GetListenerOptions returns the configured options, keyed by master.cf service name
+func GetListenerOptions(ctx context.Context) map[string]ListenerOptions {
+	options := make(map[string]ListenerOptions)
+	_ = public.OptionsMgrInstance.GetOption(ctx, listenerOptionsKey, &options)
+	return options
+}
+
+// SetListenerOptions validates and saves the options, then applies them to postfix
+func SetListenerOptions(ctx context.Context, options map[string]ListenerOptions) error {
+	master, err := public.ReadFile(public.AbsPath(consts.POSTFIX_MASTER_CONF))
+	if err != nil {
+		return fmt.Errorf("failed to read postfix master config: %v", err)
+	}
+
+	if _, err = RenderListenerOptions(master, options); err != nil {
+		return err
+	}
+
+	if err = public.OptionsMgrInstance.SetOption(ctx, listenerOptionsKey, options); err != nil {
+		return err
+	}
+
+	if err = WriteListenerOptions(ctx); err != nil {
+		return err
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	_, err = dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postfix", "reload"}, "root")
+	return err
+}
+
+// WriteListenerOptions renders the configured options into master.cf
+func WriteListenerOptions(ctx context.Context) error {
+	master, err := public.ReadFile(public.AbsPath(consts.POSTFIX_MASTER_CONF))
+	if err != nil {
+		return fmt.Errorf("failed to read postfix master config: %v", err)
+	}
+
+	content, err := RenderListenerOptions(master, GetListenerOptions(ctx))
+	if err != nil {
+		return err
+	}
+
+	if content == master {
+		return nil
+	}
+
+	_, err = public.WriteFile(public.AbsPath(consts.POSTFIX_MASTER_CONF), content)
+	return err
+}
+
+// RenderListenerOptions returns master.cf with the options applied, the options of a
+// previous render are replaced. A listener missing from master.cf is an error
+func RenderListenerOptions(master string, options map[string]ListenerOptions) (string, error) {
+	lines := strings.Split(master, "\n")
+
+	// Drop the options of the previous render
+	kept := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		if lines[i] != protocolLogMarker {
+			kept = append(kept, lines[i])
+			continue
+		}
+		for i+1 < len(lines) && protocolLogOptionPattern.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+	lines = kept
+
+	for _, listener := range protocolLogListeners(options) {
+		_, end, ok := masterService(lines, listener)
+		if !ok {
+			return "", fmt.Errorf("listener %q not found in the postfix master config", listener)
+		}
+
+		lines = append(lines[:end], append([]string{protocolLogMarker, "  -v"}, lines[end:]...)...)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// protocolLogListeners the listeners with ProtocolLog, sorted
+func protocolLogListeners(options map[string]ListenerOptions) []string {
+	var listeners []string
+	for listener, o := range options {
+		if o.ProtocolLog {
+			listeners = append(listeners, listener)
+		}
+	}
+	sort.Strings(listeners)
+	return listeners
+}
+
+// protocolLogTags the log tag of the smtpd processes of each listener, e.g.
+// "postfix/submission/smtpd" for submission, from its syslog_name and command
+func protocolLogTags(master string, listeners []string) map[string]string {
+	lines := strings.Split(master, "\n")
+
+	tags := make(map[string]string, len(listeners))
+	for _, listener := range listeners {
+		start, end, ok := masterService(lines, listener)
+		if !ok {
+			continue
+		}
+
+		command := "smtpd"
+		if fields := strings.Fields(lines[start]); len(fields) >= 8 {
+			command = fields[7]
+		}
+
+		name := masterServiceOptions(lines[start:end])["syslog_name"]
+		name = strings.ReplaceAll(name, "${multi_instance_name?{$multi_instance_name}:{postfix}}", "postfix")
+		name = strings.ReplaceAll(name, "$service_name", listener)
+		if name == "" {
+			name = "postfix"
+		}
+
+		tag := name + "/" + command
+		if _, taken := tags[tag]; !taken {
+			tags[tag] = listener
+		}
+	}
+
+	return tags
+}
+
+// protocolLogCollector position of the copy in the mail log
+type protocolLogCollector struct {
+	mutex   sync.Mutex
+	started bool
+	offset  int64
+
+	// sessions answered with a 334 challenge, their next client line is a credential
+	challenged map[string]bool
+}
+
+var protocolLogs = &protocolLogCollector{challenged: make(map[string]bool)}
+
+// CollectProtocolLogs copies the new protocol lines of the mail log to the logs of their
+// listener. The first collection starts at the end of the mail log, a mail log shorter
+// than the position was rotated and is read from its start
+func CollectProtocolLogs(ctx context.Context) error {
+	master, err := public.ReadFile(public.AbsPath(consts.POSTFIX_MASTER_CONF))
+	if err != nil {
+		return fmt.Errorf("failed to read postfix master config: %v", err)
+	}
+
+	tags := protocolLogTags(master, protocolLogListeners(GetListenerOptions(ctx)))
+	dir := filepath.Join(log_maintenance.DefaultService().Config().BasePath, "core")
+	maillog := public.AbsPath(filepath.Join(consts.POSTFIX_MAILLOG_PATH, "mail.log"))
+
+	return protocolLogs.collect(maillog, dir, tags, time.Now())
+}
+
+// collect copies the lines of the processes tagged in tags from maillog to dir
+func (c *protocolLogCollector) collect(maillog, dir string, tags map[string]string, now time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	f, err := os.Open(maillog)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !c.started:
+		c.started, c.offset = true, info.Size()
+	case info.Size() < c.offset:
+		c.offset = 0
+		c.challenged = make(map[string]bool)
+	}
+
+	if len(tags) == 0 || info.Size() == c.offset {
+		c.offset = info.Size()
+		return nil
+	}
+
+	if _, err = f.Seek(c.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	redactor := log_maintenance.NewRedactor(log_maintenance.DefaultRedactRules())
+	writers := make(map[string]*os.File)
+	defer func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}()
+
+	reader := bufio.NewReaderSize(io.LimitReader(f, protocolLogReadLimit), 64*1024)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A partial last line is read again with its end by the next collection
+			break
+		}
+		c.offset += int64(len(line))
+
+		listener, entry, ok := c.protocolEntry(strings.TrimRight(line, "\r\n"), tags)
+		if !ok {
+			continue
+		}
+
+		w, ok := writers[listener]
+		if !ok {
+			name := fmt.Sprintf("smtp-protocol-%s-%s.log", protocolLogFilePattern.ReplaceAllString(listener, "_"), now.Format("20060102"))
+			if w, err = os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+				return err
+			}
+			writers[listener] = w
+		}
+
+		if _, err = io.WriteString(w, redactor.Redact(entry)+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// protocolEntry the listener and the log entry of a protocol line, the client lines
+// answering a challenge are marked as a SASL response for the redactor
+func (c *protocolLogCollector) protocolEntry(line string, tags map[string]string) (listener, entry string, ok bool) {
+	match := protocolLinePattern.FindStringSubmatchIndex(line)
+	if match == nil {
+		return "", "", false
+	}
+
+	listener, ok = tags[line[match[2]:match[3]]]
+	if !ok {
+		return "", "", false
+	}
+
+	session := line[match[4]:match[5]] + " " + line[match[8]:match[9]]
+	direction, content := line[match[6]:match[7]], line[match[10]:match[11]]
+
+	if direction == ">" {
+		if strings.HasPrefix(content, "334 ") || content == "334" {
+			c.challenged[session] = true
+		} else {
+			delete(c.challenged, session)
+		}
+		return listener, line, true
+	}
+
+	if c.challenged[session] {
+		delete(c.challenged, session)
+		return listener, line[:match[10]] + log_maintenance.SASLResponsePrefix + content, true
+	}
+
+	return listener, line, true
+}
@@ -0,0 +1,109 @@
+package mail_service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderListenerOptions(t *testing.T) {
+	options := map[string]ListenerOptions{"submission": {ProtocolLog: true}, "smtp": {}}
+
+	rendered, err := RenderListenerOptions(masterConf, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(rendered, "\n")
+	start, end, _ := masterService(lines, "submission")
+	if !strings.Contains(strings.Join(lines[start:end], "\n"), protocolLogMarker+"\n  -v") {
+		t.Errorf("submission not verbose:\n%s", rendered)
+	}
+	if smtp := effectiveOptions(t, rendered, "smtp"); len(smtp) != 0 || strings.Count(rendered, "  -v") != 1 {
+		t.Errorf("listener without protocol logging changed:\n%s", rendered)
+	}
+
+	// A render replaces the previous one, disabling restores the original
+	again, err := RenderListenerOptions(rendered, options)
+	if err != nil || again != rendered {
+		t.Errorf("render not idempotent (%v):\n%s", err, again)
+	}
+	if off, _ := RenderListenerOptions(rendered, nil); off != masterConf {
+		t.Errorf("disabled render differs from the original:\n%s", off)
+	}
+
+	if _, err = RenderListenerOptions(masterConf, map[string]ListenerOptions{"missing": {ProtocolLog: true}}); err == nil {
+		t.Error("unknown listener accepted")
+	}
+}
+
+func TestCollectProtocolLogs(t *testing.T) {
+	master := strings.Replace(masterConf, "  -o smtpd_tls_security_level=may\n", "  -o syslog_name=postfix/$service_name\n", 1)
+	tags := protocolLogTags(master, []string{"submission", "smtp"})
+	if tags["postfix/submission/smtpd"] != "submission" || tags["postfix/smtpd"] != "smtp" {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	dir := t.TempDir()
+	maillog := filepath.Join(dir, "mail.log")
+	if err := os.WriteFile(maillog, []byte("Oct 14 10:00:00 mx postfix/submission/smtpd[12]: < c.example.com[192.0.2.1]: EHLO before\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &protocolLogCollector{challenged: make(map[string]bool)}
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+	// The first collection starts at the end of the mail log
+	if err := c.collect(maillog, dir, map[string]string{"postfix/submission/smtpd": "submission"}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	dialogue := []string{
+		"postfix/submission/smtpd[12]: < c.example.com[192.0.2.1]: AUTH PLAIN AGFsaWNlAHNlY3JldA==",
+		"postfix/submission/smtpd[12]: > c.example.com[192.0.2.1]: 235 2.7.0 Authentication successful",
+		"postfix/submission/smtpd[13]: < d.example.com[192.0.2.2]: AUTH LOGIN",
+		"postfix/submission/smtpd[13]: > d.example.com[192.0.2.2]: 334 VXNlcm5hbWU6",
+		"postfix/submission/smtpd[13]: < d.example.com[192.0.2.2]: Ym9i",
+		"postfix/submission/smtpd[13]: > d.example.com[192.0.2.2]: 334 UGFzc3dvcmQ6",
+		"postfix/submission/smtpd[13]: < d.example.com[192.0.2.2]: aHVudGVyMg==",
+		"postfix/submission/smtpd[13]: > d.example.com[192.0.2.2]: 235 2.7.0 Authentication successful",
+		"postfix/submission/smtpd[13]: < d.example.com[192.0.2.2]: MAIL FROM:<bob@example.com>",
+		"postfix/smtpd[14]: < e.example.com[192.0.2.3]: EHLO other",
+		"postfix/submission/smtpd[12]: connect from c.example.com[192.0.2.1]",
+	}
+	f, err := os.OpenFile(maillog, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range dialogue {
+		f.WriteString("Oct 14 10:00:01 mx " + line + "\n")
+	}
+	f.WriteString("Oct 14 10:00:02 mx postfix/submission/smtpd[12]: < c.example.com[192.0.2.1]: QU")
+	f.Close()
+
+	if err = c.collect(maillog, dir, map[string]string{"postfix/submission/smtpd": "submission"}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "smtp-protocol-submission-20261014.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged := string(content)
+
+	for _, secret := range []string{"AGFsaWNlAHNlY3JldA==", "Ym9i", "aHVudGVyMg==", "before", "EHLO other", "connect from", "QU"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("%q copied to the protocol log:\n%s", secret, logged)
+		}
+	}
+	if n := strings.Count(logged, "\n"); n != 9 {
+		t.Errorf("expected the 9 lines of the submission dialogue, got %d:\n%s", n, logged)
+	}
+	for _, kept := range []string{"AUTH PLAIN ******", "[sasl] ******", "334 VXNlcm5hbWU6", "MAIL FROM:<bob@example.com>"} {
+		if !strings.Contains(logged, kept) {
+			t.Errorf("%q missing from the protocol log:\n%s", kept, logged)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/maillog_stat"
+	"billionmail-core/internal/service/public"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Retry schedule of the deferred outbound mail. The n-th retry of a message waits
+// InitialDelay * Multiplier^(n-1), bounded by MaxDelay, and a message still undelivered
+// MaxLifetime after its arrival is bounced. The bounds are applied to postfix as its
+// backoff and queue lifetime parameters. Postfix itself doubles the wait between
+// attempts, so ApplyRetrySchedule flushes the messages whose retry is due earlier
+// under the configured multiplier.
+// -----------------------------
+
+const (
+	retryPolicyOptionKey = "outbound_retry_policy"
+	retryFlushBatch      = 100 // messages flushed per schedule run
+)
+
+// RetryPolicy retry schedule of the deferred messages, durations in seconds
+type RetryPolicy struct {
+	InitialDelay int     `json:"initial_delay"` // wait before the first retry
+	Multiplier   float64 `json:"multiplier"`    // growth of the wait between retries
+	MaxDelay     int     `json:"max_delay"`     // longest wait between retries
+	MaxLifetime  int     `json:"max_lifetime"`  // bounced when undelivered after it
+}
+
+// DefaultRetryPolicy the postfix defaults
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialDelay: 300,
+		Multiplier:   2,
+		MaxDelay:     4000,
+		MaxLifetime:  5 * 86400,
+	}
+}
+
+// GetRetryPolicy returns the configured schedule, the defaults when unset
+func GetRetryPolicy(ctx context.Context) RetryPolicy {
+	p := DefaultRetryPolicy()
+	_ = public.OptionsMgrInstance.GetOption(ctx, retryPolicyOptionKey, &p)
+	return p
+}
+
+// SetRetryPolicy validates and saves the schedule, then applies it to postfix
+func SetRetryPolicy(ctx context.Context, p RetryPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, retryPolicyOptionKey, p); err != nil {
+		return err
+	}
+
+	return WriteRetryPolicy(ctx)
+}
+
+func (p RetryPolicy) validate() error {
+	if p.InitialDelay <= 0 || p.MaxDelay < p.InitialDelay {
+		return fmt.Errorf("invalid retry delays: initial %ds, max %ds", p.InitialDelay, p.MaxDelay)
+	}
+	if p.Multiplier < 1 || p.Multiplier > 10 {
+		return fmt.Errorf("invalid retry multiplier %g, must be between 1 and 10", p.Multiplier)
+	}
+	if p.MaxLifetime < p.InitialDelay {
+		return fmt.Errorf("invalid max lifetime %ds, shorter than the first retry", p.MaxLifetime)
+	}
+	return nil
+}
+
+// postconf the postfix parameters of the schedule
+func (p RetryPolicy) postconf() []string {
+	runDelay := p.InitialDelay
+	if runDelay > 300 {
+		runDelay = 300
+	}
+
+	return []string{
+		fmt.Sprintf("minimal_backoff_time=%ds", p.InitialDelay),
+		fmt.Sprintf("maximal_backoff_time=%ds", p.MaxDelay),
+		fmt.Sprintf("queue_run_delay=%ds", runDelay),
+		fmt.Sprintf("maximal_queue_lifetime=%ds", p.MaxLifetime),
+		fmt.Sprintf("bounce_queue_lifetime=%ds", p.MaxLifetime),
+	}
+}
+
+// SyncRetryPolicy applies the schedule to postfix when one was configured, the postfix
+// parameters are left as they are otherwise
+func SyncRetryPolicy(ctx context.Context) error {
+	var p RetryPolicy
+	if err := public.OptionsMgrInstance.GetOption(ctx, retryPolicyOptionKey, &p); err != nil {
+		return nil
+	}
+	return WriteRetryPolicy(ctx)
+}
+
+// WriteRetryPolicy applies the configured schedule to postfix and reloads it
+func WriteRetryPolicy(ctx context.Context) error {
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	for _, s := range GetRetryPolicy(ctx).postconf() {
+		res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postconf", "-e", s}, "root")
+		if err != nil {
+			return err
+		}
+		if res.ExitCode != 0 {
+			return fmt.Errorf("postconf failed: %s", strings.TrimSpace(res.Output))
+		}
+	}
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postfix", "reload"}, "root")
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("postfix reload failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	return nil
+}
+
+// Delay the wait before retry n, the first retry being 1
+func (p RetryPolicy) Delay(n int) time.Duration {
+	if n < 1 {
+		n = 1
+	}
+
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(n-1))
+	if delay > float64(p.MaxDelay) || math.IsInf(delay, 0) {
+		delay = float64(p.MaxDelay)
+	}
+
+	return time.Duration(delay) * time.Second
+}
+
+// NextAttempt the time of the next attempt of a message deferred attempts times, the last
+// one at last, at arrival when unknown. When it falls past the lifetime the message is
+// bounced at expiry instead
+func (p RetryPolicy) NextAttempt(arrival, last time.Time, attempts int) (next time.Time, bounce bool) {
+	if last.Before(arrival) {
+		last = arrival
+	}
+
+	next = last.Add(p.Delay(attempts))
+
+	if expiry := arrival.Add(time.Duration(p.MaxLifetime) * time.Second); !next.Before(expiry) {
+		return expiry, true
+	}
+
+	return next, false
+}
+
+// ApplyRetrySchedule flushes the deferred messages whose retry is due under the configured
+// schedule, postfix retries the others on its own
+func ApplyRetrySchedule(ctx context.Context) {
+	p := GetRetryPolicy(ctx)
+	if p.Multiplier >= 2 {
+		// Postfix doubles the wait itself, it never retries later than the schedule
+		return
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return
+	}
+	defer dk.Close()
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postqueue", "-j"}, "root")
+	if err != nil || res == nil || res.ExitCode != 0 {
+		return
+	}
+
+	arrivals := make(map[string]time.Time)
+	for _, line := range strings.Split(res.Output, "\n") {
+		var item struct {
+			QueueName   string `json:"queue_name"`
+			QueueID     string `json:"queue_id"`
+			ArrivalTime int64  `json:"arrival_time"`
+		}
+		if json.Unmarshal([]byte(line), &item) != nil || item.QueueName != "deferred" {
+			continue
+		}
+		arrivals[item.QueueID] = time.Unix(item.ArrivalTime, 0)
+	}
+	if len(arrivals) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(arrivals))
+	for id := range arrivals {
+		ids = append(ids, id)
+	}
+
+	attempts, err := maillog_stat.LatestQueueAttempts(ctx, ids)
+	if err != nil {
+		g.Log().Warning(ctx, "Failed to load the delivery attempts:", err)
+		return
+	}
+
+	now := time.Now()
+	flushed := 0
+	for id, arrival := range arrivals {
+		a, ok := attempts[id]
+		if !ok {
+			continue
+		}
+
+		next, _ := p.NextAttempt(arrival, time.Unix(a.Last.Time, 0), a.Attempts)
+		if next.After(now) {
+			continue
+		}
+
+		if res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postqueue", "-i", id}, "root"); err != nil || res.ExitCode != 0 {
+			continue
+		}
+
+		if flushed++; flushed >= retryFlushBatch {
+			break
+		}
+	}
+
+	if flushed > 0 {
+		g.Log().Debugf(ctx, "Retry schedule flushed %d deferred messages", flushed)
+	}
+}
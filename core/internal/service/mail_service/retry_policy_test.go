@@ -0,0 +1,35 @@
+package mail_service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicySchedule(t *testing.T) {
+	p := RetryPolicy{InitialDelay: 60, Multiplier: 1.5, MaxDelay: 300, MaxLifetime: 3600}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for n, want := range map[int]time.Duration{1: 60 * time.Second, 2: 90 * time.Second, 3: 135 * time.Second, 10: 300 * time.Second} {
+		if got := p.Delay(n); got != want {
+			t.Errorf("delay of retry %d = %s, want %s", n, got, want)
+		}
+	}
+
+	arrival := time.Unix(1700000000, 0)
+
+	next, bounce := p.NextAttempt(arrival, time.Time{}, 0)
+	if bounce || !next.Equal(arrival.Add(time.Minute)) {
+		t.Errorf("first retry at %s (bounce %t)", next, bounce)
+	}
+
+	next, bounce = p.NextAttempt(arrival, arrival.Add(59*time.Minute), 12)
+	if !bounce || !next.Equal(arrival.Add(time.Hour)) {
+		t.Errorf("retry past the lifetime at %s (bounce %t), want the bounce at expiry", next, bounce)
+	}
+
+	if err := (RetryPolicy{InitialDelay: 60, Multiplier: 0.5, MaxDelay: 300, MaxLifetime: 3600}).validate(); err == nil {
+		t.Error("a shrinking schedule is accepted")
+	}
+}
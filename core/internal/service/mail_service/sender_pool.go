@@ -0,0 +1,208 @@
+package mail_service
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -----------------------------
+// Reuse of the authenticated SMTP connections of a sender. A connection delivers one
+// message after the other to recipients of the same domain, up to MaxMessagesPerConn
+// messages, and waits idle for the next message up to IdleTimeout. A connection whose
+// send failed is closed, the next message opens a fresh one.
+// -----------------------------
+
+const (
+	DefaultPoolMaxIdle            = 4
+	DefaultPoolMaxMessagesPerConn = 100
+	DefaultPoolIdleTimeout        = 30 * time.Second
+)
+
+// SenderPoolConfig limits of the pooled connections, 0 uses the defaults
+type SenderPoolConfig struct {
+	MaxIdle            int           // idle connections kept per sender and recipient domain
+	MaxMessagesPerConn int           // messages sent on a connection before it is closed
+	IdleTimeout        time.Duration // idle time after which a connection is closed
+}
+
+// SenderPoolStats counters of a pool
+type SenderPoolStats struct {
+	Idle      int   `json:"idle"`      // connections waiting for a message
+	InUse     int   `json:"in_use"`    // connections sending a message
+	Opened    int64 `json:"opened"`    // connections opened
+	Reused    int64 `json:"reused"`    // messages sent on an already opened connection
+	Retired   int64 `json:"retired"`   // connections closed at the message or idle limit
+	Fallbacks int64 `json:"fallbacks"` // connections closed after a failed send
+}
+
+// pooledSender a connection of the pool
+type pooledSender struct {
+	sender   *EmailSender
+	key      string
+	sent     int
+	lastUsed time.Time
+}
+
+// SenderPool pool of SMTP connections by sender and recipient domain
+type SenderPool struct {
+	cfg SenderPoolConfig
+
+	mutex sync.Mutex
+	idle  map[string][]*pooledSender
+	inUse map[*EmailSender]*pooledSender
+
+	opened    atomic.Int64
+	reused    atomic.Int64
+	retired   atomic.Int64
+	fallbacks atomic.Int64
+
+	newSender func(email string) (*EmailSender, error)
+}
+
+// NewSenderPool creates an empty pool
+func NewSenderPool(cfg SenderPoolConfig) *SenderPool {
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = DefaultPoolMaxIdle
+	}
+	if cfg.MaxMessagesPerConn <= 0 {
+		cfg.MaxMessagesPerConn = DefaultPoolMaxMessagesPerConn
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultPoolIdleTimeout
+	}
+
+	return &SenderPool{
+		cfg:       cfg,
+		idle:      make(map[string][]*pooledSender),
+		inUse:     make(map[*EmailSender]*pooledSender),
+		newSender: NewEmailSenderWithLocal,
+	}
+}
+
+func senderPoolKey(email, recipient string) string {
+	domain := recipient
+	if i := strings.LastIndex(recipient, "@"); i >= 0 {
+		domain = recipient[i+1:]
+	}
+	return strings.ToLower(email) + "|" + strings.ToLower(domain)
+}
+
+// Get returns a sender of email for a recipient, an idle connection to the same domain
+// when there is one. The sender goes back to the pool with Put
+func (p *SenderPool) Get(email, recipient string) (*EmailSender, error) {
+	key := senderPoolKey(email, recipient)
+	now := time.Now()
+
+	p.mutex.Lock()
+	var expired []*pooledSender
+	for conns := p.idle[key]; len(conns) > 0; conns = p.idle[key] {
+		ps := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+
+		if now.Sub(ps.lastUsed) > p.cfg.IdleTimeout {
+			expired = append(expired, ps)
+			continue
+		}
+
+		p.inUse[ps.sender] = ps
+		p.mutex.Unlock()
+
+		p.closeAll(expired)
+		p.reused.Add(1)
+		return ps.sender, nil
+	}
+	if len(p.idle[key]) == 0 {
+		delete(p.idle, key)
+	}
+	p.mutex.Unlock()
+
+	p.closeAll(expired)
+
+	sender, err := p.newSender(email)
+	if err != nil {
+		return nil, err
+	}
+	p.opened.Add(1)
+
+	p.mutex.Lock()
+	p.inUse[sender] = &pooledSender{sender: sender, key: key}
+	p.mutex.Unlock()
+
+	return sender, nil
+}
+
+// Put returns a sender obtained with Get with the outcome of its send. The connection is
+// kept for the next message unless the send failed or a limit is reached
+func (p *SenderPool) Put(sender *EmailSender, sendErr error) {
+	p.mutex.Lock()
+	ps, ok := p.inUse[sender]
+	delete(p.inUse, sender)
+
+	if !ok {
+		p.mutex.Unlock()
+		sender.Close()
+		return
+	}
+
+	if sendErr != nil {
+		p.mutex.Unlock()
+		p.fallbacks.Add(1)
+		sender.Close()
+		return
+	}
+
+	ps.sent++
+	ps.lastUsed = time.Now()
+
+	if ps.sent >= p.cfg.MaxMessagesPerConn || len(p.idle[ps.key]) >= p.cfg.MaxIdle {
+		p.mutex.Unlock()
+		p.retired.Add(1)
+		sender.Close()
+		return
+	}
+
+	p.idle[ps.key] = append(p.idle[ps.key], ps)
+	p.mutex.Unlock()
+}
+
+// Close closes the idle connections, the pool stays usable
+func (p *SenderPool) Close() {
+	p.mutex.Lock()
+	var conns []*pooledSender
+	for key, idle := range p.idle {
+		conns = append(conns, idle...)
+		delete(p.idle, key)
+	}
+	p.mutex.Unlock()
+
+	p.closeAll(conns)
+}
+
+// Stats returns the counters of the pool
+func (p *SenderPool) Stats() SenderPoolStats {
+	p.mutex.Lock()
+	idle := 0
+	for _, conns := range p.idle {
+		idle += len(conns)
+	}
+	inUse := len(p.inUse)
+	p.mutex.Unlock()
+
+	return SenderPoolStats{
+		Idle:      idle,
+		InUse:     inUse,
+		Opened:    p.opened.Load(),
+		Reused:    p.reused.Load(),
+		Retired:   p.retired.Load(),
+		Fallbacks: p.fallbacks.Load(),
+	}
+}
+
+func (p *SenderPool) closeAll(conns []*pooledSender) {
+	for _, ps := range conns {
+		p.retired.Add(1)
+		ps.sender.Close()
+	}
+}
@@ -25,6 +25,15 @@ type Message struct {
 	Title   string
 	Content string
 	Headers map[string]string
+
+	// Attachments sent after the content in a multipart body of MultipartType,
+	// "mixed" when empty
+	Attachments   []Attachment
+	MultipartType string
+
+	// ReturnPath envelope sender of the message, the address of the sender when empty,
+	// see VERPReturnPath
+	ReturnPath string
 }
 
 // MailTitle get title of email
@@ -98,6 +107,7 @@ type EmailSender struct {
 	client    *smtp.Client // persistent SMTP client connection
 	mutex     sync.Mutex   // mutex for thread safety
 	connected bool         // connection status
+	trace     *smtpTrace   // transcript of the connection, nil when it is not traced
 }
 
 type customAuth struct {
@@ -214,7 +224,15 @@ func (e *EmailSender) connectWithSSL() error {
 		return fmt.Errorf("TLS dial: %w", err)
 	}
 
-	client, err := smtp.NewClient(conn, e.Host)
+	var nc net.Conn = conn
+	e.trace = nil
+	if tracingEnabled() {
+		e.trace = newSMTPTrace(conn)
+		e.trace.tlsNegotiated(conn.ConnectionState())
+		nc = e.trace.wrap(conn)
+	}
+
+	client, err := smtp.NewClient(nc, e.Host)
 	if err != nil {
 		conn.Close()
 		return fmt.Errorf("new SMTP client: %w", err)
@@ -233,11 +251,24 @@ func (e *EmailSender) connectWithSSL() error {
 
 // connectPlain establishes a plain SMTP connection
 func (e *EmailSender) connectPlain() error {
-	client, err := smtp.Dial(net.JoinHostPort(e.Host, e.Port))
+	conn, err := net.Dial("tcp", net.JoinHostPort(e.Host, e.Port))
 	if err != nil {
 		return fmt.Errorf("SMTP dial: %w", err)
 	}
 
+	var nc net.Conn = conn
+	e.trace = nil
+	if tracingEnabled() {
+		e.trace = newSMTPTrace(conn)
+		nc = e.trace.wrap(conn)
+	}
+
+	client, err := smtp.NewClient(nc, e.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP dial: %w", err)
+	}
+
 	// Check if STARTTLS is needed
 	if e.Port == "587" {
 		if err = client.StartTLS(&tls.Config{
@@ -248,6 +279,9 @@ func (e *EmailSender) connectPlain() error {
 			client.Close()
 			return fmt.Errorf("SMTP STARTTLS: %w", err)
 		}
+		if state, ok := client.TLSConnectionState(); ok {
+			e.trace.tlsNegotiated(state)
+		}
 	}
 
 	var auth smtp.Auth
@@ -285,6 +319,7 @@ func (e *EmailSender) Disconnect() error {
 
 	e.connected = false
 	e.client = nil
+	e.trace = nil
 	return err
 }
 
@@ -312,6 +347,16 @@ func (e *EmailSender) Send(message Message, recipients []string) error {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
+	// A connection opened before a matching trace was enabled is reopened, so the
+	// trace covers its setup too
+	if e.connected && e.client != nil && e.trace == nil && tracingEnabled() {
+		if _, ok := matchTrace(e.Email, recipients, ""); ok {
+			_ = e.client.Close()
+			e.connected = false
+			e.client = nil
+		}
+	}
+
 	// Make sure we have a connection
 	if !e.connected || e.client == nil {
 		e.mutex.Unlock()
@@ -324,6 +369,13 @@ func (e *EmailSender) Send(message Message, recipients []string) error {
 
 	// Try to send the message, with reconnect on failure
 	err := e.doSend(message, recipients)
+
+	// A malformed message fails the same way on a new connection
+	var submissionErr *SubmissionError
+	if errors.As(err, &submissionErr) {
+		return err
+	}
+
 	if err != nil {
 		// Connection might be stale, try to reconnect once
 		g.Log().Debug(context.Background(), "SMTP send failed, attempting reconnection")
@@ -383,21 +435,38 @@ func (e *EmailSender) doSend(message Message, recipients []string) error {
 		delete(message.Headers, "From")
 	}
 
+	// A message with attachments is sent as a multipart body, its parts are encoded
+	// each
+	body := message.MailText()
+	transferEncoding := "Content-Transfer-Encoding: quoted-printable\r\n"
+	if len(message.Attachments) > 0 {
+		message, body = message.multipartBody()
+		transferEncoding = ""
+	}
+
 	// Build email message with headers
 	headerString := fmt.Sprintf("From: %s\r\n", from) +
 		fmt.Sprintf("To: %s\r\n", strings.Join(recipients, ",")) +
 		fmt.Sprintf("Subject: %s\r\n", message.MailTitle()) +
 		"MIME-Version: 1.0\r\n" +
-		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		transferEncoding +
 		"X-Mailer: BillionMail\r\n" +
 		message.MailHeader() +
 		"\r\n" +
-		message.MailText() +
+		body +
 		"\r\n"
 
-	msg := []byte(headerString)
+	msg, err := ValidateSubmission([]byte(headerString), GetValidationConfig(context.Background()))
+	if err != nil {
+		return fmt.Errorf("submission rejected: %w", err)
+	}
 
-	var err error
+	if normalization := GetMIMENormalization(context.Background()); normalization.Enabled {
+		msg, _ = NormalizeMIME(msg, normalization)
+	}
+
+	trace := e.trace.begin(e.Email, recipients)
+	defer func() { trace.end(err) }()
 
 	defer func() {
 		// Reset the connection state if sending fails
@@ -410,14 +479,22 @@ func (e *EmailSender) doSend(message Message, recipients []string) error {
 
 	// Set the sender
 	// MAIL FROM
-	if err = e.client.Mail(e.Email); err != nil {
+	mailFrom := e.Email
+	if message.ReturnPath != "" {
+		mailFrom = message.ReturnPath
+	}
+	err = e.client.Mail(mailFrom)
+	trace.command("MAIL FROM:<"+mailFrom+">", err)
+	if err != nil {
 		return fmt.Errorf("SMTP mail: %w", err)
 	}
 
 	// Set the recipients
 	// RCPT TO
 	for _, to := range recipients {
-		if err = e.client.Rcpt(to); err != nil {
+		err = e.client.Rcpt(to)
+		trace.command("RCPT TO:<"+to+">", err)
+		if err != nil {
 			return fmt.Errorf("SMTP rcpt: %w", err)
 		}
 	}
@@ -425,6 +502,7 @@ func (e *EmailSender) doSend(message Message, recipients []string) error {
 	// Get a writer for the message body
 	var w io.WriteCloser
 	w, err = e.client.Data()
+	trace.command("DATA", err)
 	if err != nil {
 		return fmt.Errorf("SMTP data: %w", err)
 	}
@@ -435,7 +513,9 @@ func (e *EmailSender) doSend(message Message, recipients []string) error {
 	}
 
 	// Close the writer
-	if err = w.Close(); err != nil {
+	err = w.Close()
+	trace.command(fmt.Sprintf("<%d bytes> .", len(msg)), err)
+	if err != nil {
 		return fmt.Errorf("SMTP close writer: %w", err)
 	}
 
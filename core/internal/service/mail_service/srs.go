@@ -0,0 +1,274 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// -----------------------------
+// Sender Rewriting Scheme (SRS) for forwarded mail.
+// The envelope sender of a forwarded message is rewritten into an address of the
+// forwarding domain so SPF passes at the next hop, bounces to that address are
+// decoded back to the original sender.
+//   SRS0=HHHH=TT=orig-domain=orig-local@forwarding-domain
+//   SRS1=HHHH=first-forwarder==HHHH=TT=orig-domain=orig-local@forwarding-domain
+// HHHH is a truncated HMAC, TT a day counter used for the validity window.
+// -----------------------------
+
+const (
+	srsSecretsOptionKey = "srs_secrets"
+	srsMaxSecrets       = 3                   // current secret + previous ones still accepted
+	srsDefaultMaxAge    = 21 * 24 * time.Hour // validity window of a rewritten address
+	srsTimeBase32       = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	srsTimeSlots        = 1024 // 2 base32 characters
+	srsHashLength       = 4
+)
+
+// errSRSUnavailable the secrets could not be loaded, the lookup should be retried
+var errSRSUnavailable = errors.New("SRS secrets unavailable")
+
+// loadSRS the rewriter of the stored secrets, replaceable in tests
+var loadSRS = defaultSRS
+
+// SRS rewriting settings, the first secret signs, all secrets verify
+type SRS struct {
+	Secrets []string
+	MaxAge  time.Duration
+	now     func() time.Time
+}
+
+// NewSRS creates a rewriter with the given secrets, newest first
+func NewSRS(secrets []string, maxAge time.Duration) *SRS {
+	if maxAge <= 0 {
+		maxAge = srsDefaultMaxAge
+	}
+	return &SRS{Secrets: secrets, MaxAge: maxAge, now: time.Now}
+}
+
+// GetSRSSecrets returns the stored secrets, a first secret is generated when none exists
+func GetSRSSecrets(ctx context.Context) ([]string, error) {
+	var secrets []string
+	_ = public.OptionsMgrInstance.GetOption(ctx, srsSecretsOptionKey, &secrets)
+
+	if len(secrets) > 0 {
+		return secrets, nil
+	}
+
+	return RotateSRSSecret(ctx)
+}
+
+// RotateSRSSecret generates a new signing secret, the previous ones are kept
+// for verification so addresses issued before the rotation keep working
+func RotateSRSSecret(ctx context.Context) ([]string, error) {
+	var secrets []string
+	_ = public.OptionsMgrInstance.GetOption(ctx, srsSecretsOptionKey, &secrets)
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	secrets = append([]string{hex.EncodeToString(buf)}, secrets...)
+	if len(secrets) > srsMaxSecrets {
+		secrets = secrets[:srsMaxSecrets]
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, srsSecretsOptionKey, secrets); err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// SRSForward rewrites the envelope sender of a message forwarded by forwardingDomain
+func SRSForward(sender, forwardingDomain string) (string, error) {
+	s, err := loadSRS()
+	if err != nil {
+		return "", err
+	}
+	return s.Forward(sender, forwardingDomain)
+}
+
+// SRSReverse decodes a rewritten address back to the address bounces must go to
+func SRSReverse(address string) (string, error) {
+	s, err := loadSRS()
+	if err != nil {
+		return "", err
+	}
+	return s.Reverse(address)
+}
+
+func defaultSRS() (*SRS, error) {
+	secrets, err := GetSRSSecrets(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSRSUnavailable, err)
+	}
+	return NewSRS(secrets, srsDefaultMaxAge), nil
+}
+
+// Forward rewrites sender into an address of forwardingDomain
+func (s *SRS) Forward(sender, forwardingDomain string) (string, error) {
+	if len(s.Secrets) == 0 {
+		return "", fmt.Errorf("no SRS secret configured")
+	}
+
+	forwardingDomain = strings.ToLower(strings.TrimSpace(forwardingDomain))
+	if forwardingDomain == "" {
+		return "", fmt.Errorf("empty forwarding domain")
+	}
+
+	// Null sender (bounces) must not be rewritten
+	if sender == "" || sender == "<>" {
+		return sender, nil
+	}
+
+	local, domain, ok := splitAddress(sender)
+	if !ok {
+		return "", fmt.Errorf("invalid sender address: %s", sender)
+	}
+
+	// Already rewritten by us, nothing to do
+	if strings.EqualFold(domain, forwardingDomain) && isSRSLocal(local) {
+		return sender, nil
+	}
+
+	upper := strings.ToUpper(local)
+
+	// SRS0 of another forwarder: wrap it in SRS1 keeping the first forwarder
+	if strings.HasPrefix(upper, "SRS0") && len(local) > 4 && isSRSSeparator(local[4]) {
+		rest := local[5:]
+		hash := s.hash(s.Secrets[0], domain, rest)
+		return fmt.Sprintf("SRS1=%s=%s==%s@%s", hash, domain, rest, forwardingDomain), nil
+	}
+
+	// SRS1 of another forwarder: replace its signature, keep the first forwarder
+	if strings.HasPrefix(upper, "SRS1") && len(local) > 4 && isSRSSeparator(local[4]) {
+		parts := strings.SplitN(local[5:], "=", 3)
+		if len(parts) == 3 {
+			first, rest := parts[1], strings.TrimPrefix(parts[2], "=")
+			hash := s.hash(s.Secrets[0], first, rest)
+			return fmt.Sprintf("SRS1=%s=%s==%s@%s", hash, first, rest, forwardingDomain), nil
+		}
+	}
+
+	ts := s.timestamp(s.now())
+	hash := s.hash(s.Secrets[0], ts, domain, local)
+
+	return fmt.Sprintf("SRS0=%s=%s=%s=%s@%s", hash, ts, domain, local, forwardingDomain), nil
+}
+
+// Reverse decodes a rewritten address, the signature and validity window are checked
+func (s *SRS) Reverse(address string) (string, error) {
+	local, _, ok := splitAddress(address)
+	if !ok || !isSRSLocal(local) {
+		return "", fmt.Errorf("not an SRS address: %s", address)
+	}
+
+	upper := strings.ToUpper(local)
+
+	if strings.HasPrefix(upper, "SRS1") {
+		// SRS1=HHHH=first-forwarder==rest -> SRS0=rest@first-forwarder
+		parts := strings.SplitN(local[5:], "=", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[2], "=") {
+			return "", fmt.Errorf("malformed SRS1 address: %s", address)
+		}
+
+		hash, first, rest := parts[0], parts[1], parts[2][1:]
+		if !s.verify(hash, first, rest) {
+			return "", fmt.Errorf("invalid SRS signature: %s", address)
+		}
+
+		return fmt.Sprintf("SRS0=%s@%s", rest, first), nil
+	}
+
+	// SRS0=HHHH=TT=domain=local
+	parts := strings.SplitN(local[5:], "=", 4)
+	if len(parts) != 4 {
+		return "", fmt.Errorf("malformed SRS0 address: %s", address)
+	}
+
+	hash, ts, domain, origLocal := parts[0], parts[1], parts[2], parts[3]
+	if !s.verify(hash, ts, domain, origLocal) {
+		return "", fmt.Errorf("invalid SRS signature: %s", address)
+	}
+
+	if err := s.checkTimestamp(ts); err != nil {
+		return "", err
+	}
+
+	return origLocal + "@" + domain, nil
+}
+
+// hash truncated HMAC-SHA1 of the lowercased parts
+func (s *SRS) hash(secret string, parts ...string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	for _, p := range parts {
+		mac.Write([]byte(strings.ToLower(p)))
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))[:srsHashLength]
+}
+
+// verify accepts signatures of every known secret, hashes are case insensitive
+// because some MTAs lowercase local parts
+func (s *SRS) verify(hash string, parts ...string) bool {
+	for _, secret := range s.Secrets {
+		if strings.EqualFold(hash, s.hash(secret, parts...)) {
+			return true
+		}
+	}
+	return false
+}
+
+// timestamp day counter encoded in 2 base32 characters
+func (s *SRS) timestamp(t time.Time) string {
+	days := int(t.Unix()/86400) % srsTimeSlots
+	return string([]byte{srsTimeBase32[days>>5], srsTimeBase32[days&31]})
+}
+
+func (s *SRS) checkTimestamp(ts string) error {
+	if len(ts) != 2 {
+		return fmt.Errorf("malformed SRS timestamp: %s", ts)
+	}
+
+	hi := strings.IndexByte(srsTimeBase32, strings.ToUpper(ts)[0])
+	lo := strings.IndexByte(srsTimeBase32, strings.ToUpper(ts)[1])
+	if hi < 0 || lo < 0 {
+		return fmt.Errorf("malformed SRS timestamp: %s", ts)
+	}
+
+	today := int(s.now().Unix()/86400) % srsTimeSlots
+	age := (today - (hi<<5 | lo) + srsTimeSlots) % srsTimeSlots
+
+	if time.Duration(age)*24*time.Hour > s.MaxAge {
+		return fmt.Errorf("SRS address expired %d days ago", age)
+	}
+
+	return nil
+}
+
+func splitAddress(address string) (local, domain string, ok bool) {
+	address = strings.Trim(strings.TrimSpace(address), "<>")
+	i := strings.LastIndex(address, "@")
+	if i <= 0 || i == len(address)-1 {
+		return "", "", false
+	}
+	return address[:i], address[i+1:], true
+}
+
+func isSRSLocal(local string) bool {
+	upper := strings.ToUpper(local)
+	return len(local) > 4 && (strings.HasPrefix(upper, "SRS0") || strings.HasPrefix(upper, "SRS1")) && isSRSSeparator(local[4])
+}
+
+func isSRSSeparator(c byte) bool {
+	return c == '=' || c == '-' || c == '+'
+}
@@ -0,0 +1,354 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// SRS in postfix. The core serves the tcp_table(5) lookups of two canonical maps of
+// postfix: the sender map rewrites the envelope sender of the mail received for a
+// domain with SRS enabled into an address of that domain, the recipient map decodes
+// the rewritten addresses the bounces come back to. The canonical maps do not know the
+// recipient, the policy service notes the domain of the first accepted recipient of the
+// sender: postfix applies the maps in cleanup, which it only opens once the first
+// recipient is accepted (smtpd_delay_open). The maps are configured while a domain has
+// SRS enabled, postfix then defers the mail it receives when the core is unreachable.
+// -----------------------------
+
+const (
+	srsDomainsOptionKey = "srs_domains"
+
+	SRSSenderListenAddr    = ":10041"
+	SRSRecipientListenAddr = ":10042"
+	srsSenderMapAddr       = "tcp:core:10041" // addresses of the maps as seen by postfix
+	srsRecipientMapAddr    = "tcp:core:10042"
+
+	srsNoteTTL          = time.Minute // a sender noted at RCPT is rewritten within it
+	srsTableIdleTimeout = 5 * time.Minute
+)
+
+// srsNote domain a sender was received for
+type srsNote struct {
+	domain  string
+	expires time.Time
+}
+
+var (
+	srsNotesMutex sync.Mutex
+	srsNotes      = make(map[string]srsNote)
+	srsTablesOnce sync.Once
+)
+
+// GetSRSDomains returns the domains with SRS enabled
+func GetSRSDomains(ctx context.Context) []string {
+	domains := make([]string, 0)
+	_ = public.OptionsMgrInstance.GetOption(ctx, srsDomainsOptionKey, &domains)
+	return domains
+}
+
+// SetSRSDomain enables or disables SRS for the mail received by a domain, and applies
+// the canonical maps to postfix
+func SetSRSDomain(ctx context.Context, domain string, enabled bool) error {
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	if domain == "" || strings.Contains(domain, "@") {
+		return fmt.Errorf("invalid SRS domain %q", domain)
+	}
+
+	domains := make([]string, 0)
+	for _, d := range GetSRSDomains(ctx) {
+		if d != domain {
+			domains = append(domains, d)
+		}
+	}
+	if enabled {
+		domains = append(domains, domain)
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, srsDomainsOptionKey, domains); err != nil {
+		return err
+	}
+
+	return WriteSRSConfig(ctx)
+}
+
+// NoteSRSRecipient notes that sender is received for recipient, its envelope sender is
+// rewritten when the domain of recipient has SRS enabled
+func NoteSRSRecipient(ctx context.Context, sender, recipient string) {
+	sender = strings.ToLower(strings.Trim(strings.TrimSpace(sender), "<>"))
+	_, senderDomain, ok := splitAddress(sender)
+	if !ok {
+		return
+	}
+
+	_, domain, ok := splitAddress(strings.ToLower(recipient))
+	if !ok || domain == senderDomain || !srsDomainEnabled(GetSRSDomains(ctx), domain) {
+		return
+	}
+
+	noteSRSSender(sender, domain, time.Now())
+}
+
+func srsDomainEnabled(domains []string, domain string) bool {
+	for _, d := range domains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// noteSRSSender keeps the first domain noted within the TTL, the cleanup of the message
+// may already have rewritten the sender into it
+func noteSRSSender(sender, domain string, now time.Time) {
+	srsNotesMutex.Lock()
+	defer srsNotesMutex.Unlock()
+
+	for s, n := range srsNotes {
+		if now.After(n.expires) {
+			delete(srsNotes, s)
+		}
+	}
+
+	if _, ok := srsNotes[sender]; !ok {
+		srsNotes[sender] = srsNote{domain: domain, expires: now.Add(srsNoteTTL)}
+	}
+}
+
+// notedSRSDomain the domain sender was received for, empty when not noted
+func notedSRSDomain(sender string, now time.Time) string {
+	srsNotesMutex.Lock()
+	defer srsNotesMutex.Unlock()
+
+	n, ok := srsNotes[strings.ToLower(sender)]
+	if !ok || now.After(n.expires) {
+		return ""
+	}
+	return n.domain
+}
+
+// srsSenderLookup the rewritten envelope sender, found is false to keep it
+func srsSenderLookup(sender string, now time.Time) (rewritten string, found bool, err error) {
+	domain := notedSRSDomain(sender, now)
+	if domain == "" {
+		return "", false, nil
+	}
+
+	rewritten, err = SRSForward(sender, domain)
+	if err != nil {
+		return "", false, unavailableSRS(err)
+	}
+	return rewritten, !strings.EqualFold(rewritten, sender), nil
+}
+
+// srsRecipientLookup the original sender of a rewritten address. The signature proves
+// the address was issued here, the addresses of a domain whose SRS was disabled since
+// are still decoded
+func srsRecipientLookup(address string) (original string, found bool, err error) {
+	local, _, ok := splitAddress(address)
+	if !ok || !isSRSLocal(local) {
+		return "", false, nil
+	}
+
+	original, err = SRSReverse(address)
+	if err != nil {
+		return "", false, unavailableSRS(err)
+	}
+	return original, true, nil
+}
+
+// unavailableSRS keeps the failures to load the secrets, the other errors are addresses
+// that are not rewritten
+func unavailableSRS(err error) error {
+	if errors.Is(err, errSRSUnavailable) {
+		return err
+	}
+	return nil
+}
+
+// srsLookup lookup of a canonical map, err reports a temporary failure
+type srsLookup func(key string) (value string, found bool, err error)
+
+// StartSRSTables listens for the lookups of the canonical maps, it is a no-op when
+// already started
+func StartSRSTables(ctx context.Context) {
+	srsTablesOnce.Do(func() {
+		tables := []struct {
+			addr   string
+			lookup srsLookup
+		}{
+			{SRSSenderListenAddr, func(key string) (string, bool, error) { return srsSenderLookup(key, time.Now()) }},
+			{SRSRecipientListenAddr, srsRecipientLookup},
+		}
+
+		for _, t := range tables {
+			ln, err := net.Listen("tcp", t.addr)
+			if err != nil {
+				g.Log().Warning(ctx, "Start SRS table failed: ", err)
+				continue
+			}
+
+			g.Log().Infof(ctx, "SRS table listening on %s", t.addr)
+
+			go func(ln net.Listener, lookup srsLookup) {
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						g.Log().Warning(ctx, "SRS table accept failed: ", err)
+						time.Sleep(time.Second)
+						continue
+					}
+
+					go serveSRSTable(ctx, conn, lookup)
+				}
+			}(ln, t.lookup)
+		}
+	})
+}
+
+// serveSRSTable answers the "get <key>" requests of one postfix connection
+func serveSRSTable(ctx context.Context, conn net.Conn, lookup srsLookup) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(srsTableIdleTimeout))
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		if _, err = conn.Write([]byte(srsTableReply(ctx, strings.TrimRight(line, "\r\n"), lookup))); err != nil {
+			return
+		}
+	}
+}
+
+// srsTableReply the reply to one request: 200 with the value, 500 when not found, 400
+// on a failure, postfix then defers the message
+func srsTableReply(ctx context.Context, request string, lookup srsLookup) string {
+	cmd, key, _ := strings.Cut(request, " ")
+	if cmd != "get" || key == "" {
+		return "400 unsupported request\n"
+	}
+
+	key, err := tableDecode(key)
+	if err != nil {
+		return "400 malformed key\n"
+	}
+
+	value, found, err := lookup(key)
+	if err != nil {
+		g.Log().Warningf(ctx, "SRS lookup of %s failed: %v", key, err)
+		return "400 " + tableEncode(err.Error()) + "\n"
+	}
+	if !found {
+		return "500 not found\n"
+	}
+
+	return "200 " + tableEncode(value) + "\n"
+}
+
+// tableEncode encodes the whitespace, control characters and % of a tcp_table value
+func tableEncode(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c == '%' || c >= 0x7f {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// tableDecode decodes the %XX sequences of a tcp_table key
+func tableDecode(s string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		var c byte
+		if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+			return "", fmt.Errorf("malformed escape in %q", s)
+		}
+		_, _ = fmt.Sscanf(s[i+1:i+3], "%02X", &c)
+		sb.WriteByte(c)
+		i += 2
+	}
+	return sb.String(), nil
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// srsPostconf the postfix parameters of the canonical maps, removed when no domain has
+// SRS enabled
+func srsPostconf(enabled bool) (args []string) {
+	if !enabled {
+		return []string{"-X", "sender_canonical_maps", "sender_canonical_classes", "recipient_canonical_maps", "recipient_canonical_classes"}
+	}
+
+	return []string{"-e",
+		"sender_canonical_maps=" + srsSenderMapAddr,
+		"sender_canonical_classes=envelope_sender",
+		"recipient_canonical_maps=" + srsRecipientMapAddr,
+		"recipient_canonical_classes=envelope_recipient",
+	}
+}
+
+// SyncSRSConfig applies the canonical maps to postfix when SRS was configured, the
+// postfix parameters are left as they are otherwise
+func SyncSRSConfig(ctx context.Context) error {
+	var domains []string
+	if err := public.OptionsMgrInstance.GetOption(ctx, srsDomainsOptionKey, &domains); err != nil {
+		return nil
+	}
+	return WriteSRSConfig(ctx)
+}
+
+// WriteSRSConfig applies the canonical maps to postfix and reloads it
+func WriteSRSConfig(ctx context.Context) error {
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	cmd := append([]string{"postconf"}, srsPostconf(len(GetSRSDomains(ctx)) > 0)...)
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, cmd, "root")
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("postconf failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	res, err = dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postfix", "reload"}, "root")
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("postfix reload failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	return nil
+}
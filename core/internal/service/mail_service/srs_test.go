@@ -0,0 +1,192 @@
+package mail_service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedSRS a rewriter at a fixed time
+func fixedSRS(secrets []string, now time.Time) *SRS {
+	s := NewSRS(secrets, 0)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestSRSRoundTrip(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := fixedSRS([]string{"secret"}, now)
+
+	for _, sender := range []string{"alice@example.org", "Bob.Smith@Example.ORG", "a=b@example.org", "x+tag@example.org"} {
+		rewritten, err := s.Forward(sender, "Forward.example.com")
+		if err != nil {
+			t.Fatalf("%s: %v", sender, err)
+		}
+		if !strings.HasPrefix(rewritten, "SRS0=") || !strings.HasSuffix(rewritten, "@forward.example.com") {
+			t.Errorf("%s: unexpected rewritten address %s", sender, rewritten)
+		}
+
+		// Already rewritten by us
+		if again, _ := s.Forward(rewritten, "forward.example.com"); again != rewritten {
+			t.Errorf("%s: rewritten twice to %s", sender, again)
+		}
+
+		// Some MTAs lowercase the local part
+		for _, address := range []string{rewritten, strings.ToLower(rewritten)} {
+			original, err := s.Reverse(address)
+			if err != nil {
+				t.Errorf("%s: reverse of %s: %v", sender, address, err)
+			} else if !strings.EqualFold(original, sender) {
+				t.Errorf("%s: reversed to %s", sender, original)
+			}
+		}
+	}
+
+	if null, _ := s.Forward("<>", "forward.example.com"); null != "<>" {
+		t.Errorf("null sender rewritten to %s", null)
+	}
+
+	// Tampered signature
+	rewritten, _ := s.Forward("alice@example.org", "forward.example.com")
+	if _, err := s.Reverse(strings.Replace(rewritten, "alice", "mallory", 1)); err == nil {
+		t.Error("tampered address reversed")
+	}
+}
+
+func TestSRSChainedForwarders(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	first := fixedSRS([]string{"first"}, now)
+	second := fixedSRS([]string{"second"}, now)
+
+	hop1, _ := first.Forward("alice@example.org", "first.example")
+	hop2, err := second.Forward(hop1, "second.example")
+	if err != nil || !strings.HasPrefix(hop2, "SRS1=") || !strings.HasSuffix(hop2, "@second.example") {
+		t.Fatalf("SRS1 wrapping of %s: %s, %v", hop1, hop2, err)
+	}
+
+	// The second forwarder bounces to the first one, which bounces to the sender
+	back, err := second.Reverse(hop2)
+	if err != nil || !strings.EqualFold(back, hop1) {
+		t.Fatalf("reverse of %s: %s, %v, want %s", hop2, back, err, hop1)
+	}
+	if original, err := first.Reverse(back); err != nil || original != "alice@example.org" {
+		t.Errorf("reverse of %s: %s, %v", back, original, err)
+	}
+}
+
+func TestSRSExpiry(t *testing.T) {
+	issued := time.Unix(1700000000, 0)
+	rewritten, _ := fixedSRS([]string{"secret"}, issued).Forward("alice@example.org", "forward.example.com")
+
+	for _, tc := range []struct {
+		age   time.Duration
+		valid bool
+	}{
+		{0, true},
+		{20 * 24 * time.Hour, true},
+		{srsDefaultMaxAge, true},
+		{srsDefaultMaxAge + 24*time.Hour, false},
+	} {
+		_, err := fixedSRS([]string{"secret"}, issued.Add(tc.age)).Reverse(rewritten)
+		if (err == nil) != tc.valid {
+			t.Errorf("after %s: %v, want valid %t", tc.age, err, tc.valid)
+		}
+	}
+}
+
+func TestSRSSecretRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rewritten, _ := fixedSRS([]string{"old"}, now).Forward("alice@example.org", "forward.example.com")
+
+	// The previous secrets still verify, the newest signs
+	rotated := fixedSRS([]string{"new", "old"}, now)
+	if _, err := rotated.Reverse(rewritten); err != nil {
+		t.Errorf("address of the previous secret rejected: %v", err)
+	}
+	if fresh, _ := rotated.Forward("alice@example.org", "forward.example.com"); fresh == rewritten {
+		t.Error("rewritten with the previous secret")
+	}
+
+	// Dropped once more than srsMaxSecrets rotations happened
+	if _, err := fixedSRS([]string{"s3", "s2", "new"}, now).Reverse(rewritten); err == nil {
+		t.Error("address of a dropped secret accepted")
+	}
+}
+
+func TestSRSTableLookups(t *testing.T) {
+	now := time.Now()
+	s := fixedSRS([]string{"secret"}, now)
+
+	defer func(orig func() (*SRS, error)) { loadSRS = orig }(loadSRS)
+	loadSRS = func() (*SRS, error) { return s, nil }
+
+	ctx := context.Background()
+	sender := "alice@example.org"
+
+	// Not received for a domain with SRS enabled
+	if reply := srsTableReply(ctx, "get "+sender, wrapSenderLookup(now)); reply != "500 not found\n" {
+		t.Errorf("sender not noted: %q", reply)
+	}
+
+	noteSRSSender(sender, "forward.example.com", now)
+	noteSRSSender(sender, "other.example.com", now)
+
+	reply := srsTableReply(ctx, "get "+sender, wrapSenderLookup(now))
+	rewritten, ok := strings.CutPrefix(strings.TrimSuffix(reply, "\n"), "200 ")
+	if !ok || !strings.HasSuffix(rewritten, "@forward.example.com") {
+		t.Fatalf("sender lookup: %q", reply)
+	}
+
+	if reply := srsTableReply(ctx, "get "+sender, wrapSenderLookup(now.Add(2*srsNoteTTL))); reply != "500 not found\n" {
+		t.Errorf("expired note still rewritten: %q", reply)
+	}
+
+	if reply := srsTableReply(ctx, "get "+rewritten, srsRecipientLookup); reply != "200 "+sender+"\n" {
+		t.Errorf("recipient lookup of %s: %q", rewritten, reply)
+	}
+	for _, key := range []string{"bob@forward.example.com", "@forward.example.com", "SRS0=xxxx=AA=example.org=bob@forward.example.com"} {
+		if reply := srsTableReply(ctx, "get "+key, srsRecipientLookup); reply != "500 not found\n" {
+			t.Errorf("recipient lookup of %s: %q", key, reply)
+		}
+	}
+
+	if reply := srsTableReply(ctx, "put a b", srsRecipientLookup); !strings.HasPrefix(reply, "400 ") {
+		t.Errorf("unsupported request: %q", reply)
+	}
+
+	// Secrets unavailable, postfix retries later
+	loadSRS = func() (*SRS, error) { return nil, errSRSUnavailable }
+	if reply := srsTableReply(ctx, "get "+rewritten, srsRecipientLookup); !strings.HasPrefix(reply, "400 ") {
+		t.Errorf("lookup without secrets: %q", reply)
+	}
+}
+
+func wrapSenderLookup(now time.Time) srsLookup {
+	return func(key string) (string, bool, error) { return srsSenderLookup(key, now) }
+}
+
+func TestSRSTableEncoding(t *testing.T) {
+	for _, s := range []string{"plain@example.org", "a b%c\td@example.org"} {
+		decoded, err := tableDecode(tableEncode(s))
+		if err != nil || decoded != s {
+			t.Errorf("%q: decoded %q, %v", s, decoded, err)
+		}
+	}
+	if enc := tableEncode("a b%"); enc != "a%20b%25" {
+		t.Errorf("encoded %q", enc)
+	}
+	if _, err := tableDecode("bad%2"); err == nil {
+		t.Error("truncated escape accepted")
+	}
+}
+
+func TestUnavailableSRS(t *testing.T) {
+	if err := unavailableSRS(errors.New("invalid sender address")); err != nil {
+		t.Errorf("address error reported as a failure: %v", err)
+	}
+	if err := unavailableSRS(errSRSUnavailable); err == nil {
+		t.Error("unavailable secrets not reported")
+	}
+}
@@ -0,0 +1,189 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// -----------------------------
+// STARTTLS required policy of the listeners of postfix. On the configured master.cf
+// services AUTH is refused until STARTTLS completed (smtpd_tls_auth_only), with
+// RequireForMail every transaction command is answered "530 5.7.0 Must issue a STARTTLS
+// command first" as well (smtpd_tls_security_level=encrypt). The options are appended to
+// the end of the service so they override its earlier ones, below a marker line that
+// identifies them on the next render. The policy only tightens a listener, the options
+// of its own are left as they are. Implicit TLS services (smtpd_tls_wrappermode, the
+// port 465) are always encrypted and never changed.
+// -----------------------------
+
+const (
+	startTLSPolicyOptionKey = "starttls_policy"
+	startTLSPolicyMarker    = "#  STARTTLS required policy, managed by BillionMail"
+)
+
+var (
+	masterOptionPattern  = regexp.MustCompile(`^\s+-o\s+([a-z0-9_]+)=(.*)$`)
+	managedOptionPattern = regexp.MustCompile(`^\s+-o\s+smtpd_tls_(?:auth_only|security_level)=`)
+)
+
+// StartTLSPolicy STARTTLS enforcement settings
+type StartTLSPolicy struct {
+	Enabled        bool     `json:"enabled"`
+	Listeners      []string `json:"listeners"`        // master.cf service names, e.g. submission
+	RequireForMail bool     `json:"require_for_mail"` // also refuse MAIL before STARTTLS
+}
+
+// DefaultStartTLSPolicy disabled, the submission port when enabled
+func DefaultStartTLSPolicy() StartTLSPolicy {
+	return StartTLSPolicy{Listeners: []string{"submission"}}
+}
+
+// GetStartTLSPolicy returns the configured settings, the defaults when unset
+func GetStartTLSPolicy(ctx context.Context) StartTLSPolicy {
+	p := DefaultStartTLSPolicy()
+	_ = public.OptionsMgrInstance.GetOption(ctx, startTLSPolicyOptionKey, &p)
+	return p
+}
+
+// SetStartTLSPolicy validates and saves the settings, then applies them to postfix
+func SetStartTLSPolicy(ctx context.Context, p StartTLSPolicy) error {
+	master, err := public.ReadFile(public.AbsPath(consts.POSTFIX_MASTER_CONF))
+	if err != nil {
+		return fmt.Errorf("failed to read postfix master config: %v", err)
+	}
+
+	if _, err = p.Render(master); err != nil {
+		return err
+	}
+
+	if err = public.OptionsMgrInstance.SetOption(ctx, startTLSPolicyOptionKey, p); err != nil {
+		return err
+	}
+
+	if err = WriteStartTLSPolicy(ctx); err != nil {
+		return err
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	_, err = dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postfix", "reload"}, "root")
+	return err
+}
+
+// WriteStartTLSPolicy renders the configured policy into master.cf
+func WriteStartTLSPolicy(ctx context.Context) error {
+	master, err := public.ReadFile(public.AbsPath(consts.POSTFIX_MASTER_CONF))
+	if err != nil {
+		return fmt.Errorf("failed to read postfix master config: %v", err)
+	}
+
+	content, err := GetStartTLSPolicy(ctx).Render(master)
+	if err != nil {
+		return err
+	}
+
+	if content == master {
+		return nil
+	}
+
+	_, err = public.WriteFile(public.AbsPath(consts.POSTFIX_MASTER_CONF), content)
+	return err
+}
+
+// Render returns master.cf with the options of the policy, the options of a previous
+// render are replaced. A listener missing from master.cf is an error
+func (p StartTLSPolicy) Render(master string) (string, error) {
+	lines := strings.Split(master, "\n")
+
+	// Drop the options of the previous render
+	kept := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		if lines[i] != startTLSPolicyMarker {
+			kept = append(kept, lines[i])
+			continue
+		}
+		for i+1 < len(lines) && managedOptionPattern.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+	lines = kept
+
+	if !p.Enabled {
+		return strings.Join(lines, "\n"), nil
+	}
+
+	options := []string{startTLSPolicyMarker, "  -o smtpd_tls_auth_only=yes"}
+	if p.RequireForMail {
+		options = append(options, "  -o smtpd_tls_security_level=encrypt")
+	}
+
+	seen := make(map[string]bool, len(p.Listeners))
+	for _, listener := range p.Listeners {
+		if seen[listener] {
+			continue
+		}
+		seen[listener] = true
+
+		start, end, ok := masterService(lines, listener)
+		if !ok {
+			return "", fmt.Errorf("listener %q not found in the postfix master config", listener)
+		}
+
+		// Implicit TLS, nothing is ever sent in clear text
+		if masterServiceOptions(lines[start:end])["smtpd_tls_wrappermode"] == "yes" {
+			continue
+		}
+
+		lines = append(lines[:end], append(append([]string(nil), options...), lines[end:]...)...)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// masterService locates a service of master.cf, end is the line following its last
+// option, comment lines between its options are part of it
+func masterService(lines []string, name string) (start, end int, ok bool) {
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(line, "#") || line[0] == ' ' || line[0] == '\t' || fields[0] != name || fields[1] != "inet" {
+			continue
+		}
+
+		end = i + 1
+		for j := i + 1; j < len(lines); j++ {
+			next := lines[j]
+			if next == "" || strings.HasPrefix(next, "#") {
+				continue
+			}
+			if next[0] != ' ' && next[0] != '\t' {
+				break
+			}
+			end = j + 1
+		}
+
+		return i, end, true
+	}
+
+	return 0, 0, false
+}
+
+// masterServiceOptions the effective -o options of a service, a later option overrides
+// an earlier one like postfix does
+func masterServiceOptions(service []string) map[string]string {
+	options := make(map[string]string)
+	for _, line := range service {
+		if match := masterOptionPattern.FindStringSubmatch(line); match != nil {
+			options[match[1]] = strings.TrimSpace(match[2])
+		}
+	}
+	return options
+}
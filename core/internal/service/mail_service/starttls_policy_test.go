@@ -0,0 +1,80 @@
+package mail_service
+
+import (
+	"strings"
+	"testing"
+)
+
+const masterConf = `smtp      inet  n       -       n       -       -       smtpd
+submission inet n       -       n       -       -       smtpd
+  -o smtpd_client_restrictions=permit_mynetworks,permit_sasl_authenticated,reject
+  -o smtpd_tls_security_level=may
+#  -o smtpd_tls_auth_only=yes
+  -o milter_macro_daemon_name=ORIGINATING
+smtps    inet  n       -       n       -       -       smtpd
+  -o smtpd_tls_wrappermode=yes
+  -o milter_macro_daemon_name=ORIGINATING
+
+pickup    unix  n       -       n       60      1       pickup
+`
+
+// effectiveOptions the options postfix applies to a service of the rendered master.cf
+func effectiveOptions(t *testing.T, master, service string) map[string]string {
+	t.Helper()
+
+	lines := strings.Split(master, "\n")
+	start, end, ok := masterService(lines, service)
+	if !ok {
+		t.Fatalf("service %s missing from:\n%s", service, master)
+	}
+	return masterServiceOptions(lines[start:end])
+}
+
+func TestStartTLSPolicyRefusesPlaintextAuth(t *testing.T) {
+	p := StartTLSPolicy{Enabled: true, Listeners: []string{"submission", "smtps"}}
+
+	rendered, err := p.Render(masterConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// AUTH is refused before STARTTLS, MAIL is left to the listener's own setting
+	submission := effectiveOptions(t, rendered, "submission")
+	if submission["smtpd_tls_auth_only"] != "yes" {
+		t.Errorf("plaintext AUTH still allowed on submission:\n%s", rendered)
+	}
+	if submission["smtpd_tls_security_level"] != "may" || submission["milter_macro_daemon_name"] != "ORIGINATING" {
+		t.Errorf("own options of submission changed: %v", submission)
+	}
+
+	// Implicit TLS and unlisted services are untouched
+	if smtps := effectiveOptions(t, rendered, "smtps"); len(smtps) != 2 {
+		t.Errorf("implicit TLS service changed: %v", smtps)
+	}
+	if smtp := effectiveOptions(t, rendered, "smtp"); len(smtp) != 0 {
+		t.Errorf("unlisted service changed: %v", smtp)
+	}
+
+	// Requiring STARTTLS for MAIL overrides the listener's security level
+	p.RequireForMail = true
+	again, err := p.Render(rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := effectiveOptions(t, again, "submission")["smtpd_tls_security_level"]; got != "encrypt" {
+		t.Errorf("smtpd_tls_security_level = %q, want encrypt", got)
+	}
+	if strings.Count(again, startTLSPolicyMarker) != 1 {
+		t.Errorf("options of the previous render not replaced:\n%s", again)
+	}
+
+	// Disabling restores the original configuration
+	p.Enabled = false
+	if restored, err := p.Render(again); err != nil || restored != masterConf {
+		t.Errorf("disabled policy left:\n%s (%v)", restored, err)
+	}
+
+	if _, err = (StartTLSPolicy{Enabled: true, Listeners: []string{"submissions"}}).Render(masterConf); err == nil {
+		t.Error("expected an error for an unknown listener")
+	}
+}
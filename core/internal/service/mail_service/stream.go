@@ -0,0 +1,69 @@
+package mail_service
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// SendStream sends the complete message, headers included, that write produces to
+// recipients. The message is streamed to the server as it is written, so it is neither
+// validated nor retried on a new connection: it cannot be produced twice
+func (e *EmailSender) SendStream(recipients []string, write func(w io.Writer) error) (err error) {
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipients specified")
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.connected || e.client == nil {
+		e.mutex.Unlock()
+		if err := e.Connect(); err != nil {
+			e.mutex.Lock()
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		e.mutex.Lock()
+	}
+
+	defer func() {
+		if err == nil || e.client == nil {
+			return
+		}
+		// A connection left mid-message is dropped, the next send reconnects
+		if resetErr := e.client.Reset(); resetErr != nil {
+			g.Log().Warning(context.Background(), "SMTP reset: ", resetErr)
+			_ = e.client.Close()
+			e.connected = false
+			e.client = nil
+		}
+	}()
+
+	if err = e.client.Mail(e.Email); err != nil {
+		return fmt.Errorf("SMTP mail: %w", err)
+	}
+	for _, to := range recipients {
+		if err = e.client.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP rcpt: %w", err)
+		}
+	}
+
+	w, err := e.client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP data: %w", err)
+	}
+	if err = write(w); err != nil {
+		// Closing the data would deliver the partial message, the connection is dropped
+		_ = e.client.Close()
+		e.connected = false
+		e.client = nil
+		return fmt.Errorf("SMTP write: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("SMTP close writer: %w", err)
+	}
+
+	return nil
+}
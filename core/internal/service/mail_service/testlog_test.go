@@ -0,0 +1,3 @@
+package mail_service
+
+import _ "billionmail-core/internal/testlog"
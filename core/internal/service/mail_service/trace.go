@@ -0,0 +1,440 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/util/grand"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/glog"
+)
+
+// -----------------------------
+// Deliverability tracing. While a trace matches the sender, a recipient or the server
+// address of a message, the SMTP conversation of its connection (greeting, EHLO, TLS
+// negotiation, every command and reply) is written to the dedicated trace log
+// logs/core/trace-YYYYMMDD.log, which log maintenance rotates like the other logs.
+// Traces expire after their TTL. Without an active trace connections are not wrapped
+// at all, so tracing costs nothing when it is off.
+// -----------------------------
+
+const (
+	DefaultTraceTTL = 30 * time.Minute
+	MaxTraceTTL     = 24 * time.Hour
+
+	// traceMaxPreamble lines of the connection setup kept until a message of the
+	// connection turns out to match
+	traceMaxPreamble = 200
+	traceMaxLine     = 1024
+)
+
+// TraceMatch selects the traced messages. Sender and Recipient are case-insensitive
+// glob patterns such as "*@example.com", IP a glob or a CIDR of the server address.
+// Empty fields match anything, at least one must be set
+type TraceMatch struct {
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	IP        string `json:"ip"`
+}
+
+// ActiveTrace a trace enabled with EnableTrace
+type ActiveTrace struct {
+	Id        string     `json:"id"`
+	Match     TraceMatch `json:"match"`
+	ExpiresAt time.Time  `json:"expires_at"`
+
+	ipNet *net.IPNet
+	timer *time.Timer
+}
+
+var (
+	traceCount atomic.Int32 // active traces, read on every send
+	traceMutex sync.Mutex
+	traces     = make(map[string]*ActiveTrace)
+
+	traceLogOnce sync.Once
+	traceLogger  *glog.Logger
+)
+
+// EnableTrace traces the messages matching match for ttl (DefaultTraceTTL when unset,
+// at most MaxTraceTTL), the trace is disabled automatically afterwards
+func EnableTrace(match TraceMatch, ttl time.Duration) (ActiveTrace, error) {
+	match.Sender = strings.ToLower(strings.TrimSpace(match.Sender))
+	match.Recipient = strings.ToLower(strings.TrimSpace(match.Recipient))
+	match.IP = strings.TrimSpace(match.IP)
+
+	if match.Sender == "" && match.Recipient == "" && match.IP == "" {
+		return ActiveTrace{}, errors.New("a trace needs a sender, recipient or IP pattern")
+	}
+
+	trace := &ActiveTrace{Id: grand.S(12), Match: match}
+
+	for _, pattern := range []string{match.Sender, match.Recipient} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return ActiveTrace{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	if strings.Contains(match.IP, "/") {
+		_, ipNet, err := net.ParseCIDR(match.IP)
+		if err != nil {
+			return ActiveTrace{}, fmt.Errorf("invalid IP range %q: %w", match.IP, err)
+		}
+		trace.ipNet = ipNet
+	} else if _, err := path.Match(match.IP, ""); err != nil {
+		return ActiveTrace{}, fmt.Errorf("invalid IP pattern %q: %w", match.IP, err)
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultTraceTTL
+	}
+	if ttl > MaxTraceTTL {
+		ttl = MaxTraceTTL
+	}
+	trace.ExpiresAt = time.Now().Add(ttl)
+
+	traceMutex.Lock()
+	traces[trace.Id] = trace
+	traceCount.Add(1)
+	trace.timer = time.AfterFunc(ttl, func() { DisableTrace(trace.Id) })
+	traceMutex.Unlock()
+
+	g.Log().Infof(context.Background(), "Deliverability trace %s enabled until %s: sender %q, recipient %q, ip %q",
+		trace.Id, trace.ExpiresAt.Format(time.RFC3339), match.Sender, match.Recipient, match.IP)
+
+	return *trace, nil
+}
+
+// DisableTrace stops a trace, it reports whether the trace was active
+func DisableTrace(id string) bool {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	trace, ok := traces[id]
+	if !ok {
+		return false
+	}
+
+	trace.timer.Stop()
+	delete(traces, id)
+	traceCount.Add(-1)
+
+	g.Log().Infof(context.Background(), "Deliverability trace %s disabled", id)
+	return true
+}
+
+// ActiveTraces the enabled traces, the soonest to expire first
+func ActiveTraces() []ActiveTrace {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	list := make([]ActiveTrace, 0, len(traces))
+	for _, trace := range traces {
+		list = append(list, ActiveTrace{Id: trace.Id, Match: trace.Match, ExpiresAt: trace.ExpiresAt})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ExpiresAt.Before(list[j].ExpiresAt) })
+	return list
+}
+
+// tracingEnabled cheap check done before anything else of the tracing
+func tracingEnabled() bool {
+	return traceCount.Load() > 0
+}
+
+// matchTrace the id of a trace matching the message, an empty ip is not checked
+func matchTrace(sender string, recipients []string, ip string) (string, bool) {
+	if !tracingEnabled() {
+		return "", false
+	}
+
+	sender = strings.ToLower(sender)
+
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	for _, trace := range traces {
+		if trace.matches(sender, recipients, ip) {
+			return trace.Id, true
+		}
+	}
+
+	return "", false
+}
+
+func (t *ActiveTrace) matches(sender string, recipients []string, ip string) bool {
+	if t.Match.Sender != "" {
+		if ok, _ := path.Match(t.Match.Sender, sender); !ok {
+			return false
+		}
+	}
+
+	if t.Match.Recipient != "" {
+		found := false
+		for _, r := range recipients {
+			if ok, _ := path.Match(t.Match.Recipient, strings.ToLower(r)); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if t.Match.IP != "" && ip != "" {
+		if t.ipNet != nil {
+			parsed := net.ParseIP(ip)
+			return parsed != nil && t.ipNet.Contains(parsed)
+		}
+		if ok, _ := path.Match(t.Match.IP, ip); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// traceLog the logger of the trace log, next to the core logs handled by log maintenance
+func traceLog() *glog.Logger {
+	traceLogOnce.Do(func() {
+		traceLogger = glog.New()
+		if err := traceLogger.SetPath(filepath.Join(public.AbsPath("../logs"), "core")); err != nil {
+			g.Log().Warningf(context.Background(), "Trace log directory unavailable, traces go to the main log: %v", err)
+			traceLogger = g.Log()
+			return
+		}
+		traceLogger.SetFile("trace-{Ymd}.log")
+		traceLogger.SetStdoutPrint(false)
+	})
+	return traceLogger
+}
+
+// writeTrace appends a line to the trace log, replaceable in tests
+var writeTrace = func(id, conn, text string) {
+	traceLog().Infof(context.Background(), "[%s] %s %s", id, conn, text)
+}
+
+// smtpTrace transcript of one traced SMTP connection. The setup of the connection is
+// kept until a message shows whether it matches a trace, then the lines of a matching
+// message are written as they happen and those of the others are dropped
+type smtpTrace struct {
+	mu sync.Mutex
+
+	conn     string // "local -> remote"
+	remoteIP string
+
+	preamble []string
+	started  bool   // a message was sent, the preamble is complete
+	flushed  bool   // the preamble was written
+	traceId  string // trace of the message in progress, empty when it is not traced
+
+	startTLS  bool // STARTTLS was sent, the wire is encrypted once the server accepts it
+	encrypted bool // the bytes on the wire are TLS, only commands are recorded
+	redact    bool // inside an AUTH exchange
+	partial   [2][]byte
+}
+
+func newSMTPTrace(conn net.Conn) *smtpTrace {
+	t := &smtpTrace{conn: conn.LocalAddr().String() + " -> " + conn.RemoteAddr().String()}
+	if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+		t.remoteIP = host
+	}
+	return t
+}
+
+// wrap records the traffic read and written through conn
+func (t *smtpTrace) wrap(conn net.Conn) net.Conn {
+	return &tracedConn{Conn: conn, trace: t}
+}
+
+// tracedConn connection whose traffic is recorded into a trace
+type tracedConn struct {
+	net.Conn
+	trace *smtpTrace
+}
+
+func (c *tracedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.trace.record(0, p[:n])
+	return n, err
+}
+
+func (c *tracedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.trace.record(1, p[:n])
+	return n, err
+}
+
+// record splits the traffic of one direction (0 server, 1 client) into lines
+func (t *smtpTrace) record(dir int, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.encrypted {
+		return
+	}
+
+	buf := append(t.partial[dir], data...)
+	for {
+		i := strings.IndexByte(string(buf), '\n')
+		if i < 0 {
+			break
+		}
+		t.line(dir, strings.TrimRight(string(buf[:i]), "\r"))
+		buf = buf[i+1:]
+
+		if t.encrypted {
+			t.partial = [2][]byte{}
+			return
+		}
+	}
+
+	if len(buf) > traceMaxLine {
+		buf = buf[:traceMaxLine]
+	}
+	t.partial[dir] = append([]byte(nil), buf...)
+}
+
+// line records one protocol line, credentials of AUTH are never written. Called with mu held
+func (t *smtpTrace) line(dir int, text string) {
+	if len(text) > traceMaxLine {
+		text = text[:traceMaxLine] + "..."
+	}
+
+	prefix := "S: "
+	if dir == 1 {
+		prefix = "C: "
+
+		if fields := strings.Fields(text); len(fields) > 0 && strings.EqualFold(fields[0], "AUTH") {
+			t.redact = true
+			if len(fields) > 2 {
+				text = fields[0] + " " + fields[1] + " <redacted>"
+			}
+		} else if t.redact {
+			text = "<redacted>"
+		} else if strings.EqualFold(text, "STARTTLS") {
+			t.startTLS = true
+		}
+	} else {
+		if t.redact && !strings.HasPrefix(text, "334") {
+			t.redact = false
+		}
+		if t.startTLS {
+			t.startTLS = false
+			t.encrypted = strings.HasPrefix(text, "220")
+		}
+	}
+
+	t.add(prefix + text)
+}
+
+// add writes the line of a traced message or keeps it in the preamble. Called with mu held
+func (t *smtpTrace) add(text string) {
+	switch {
+	case t.traceId != "":
+		writeTrace(t.traceId, t.conn, text)
+	case !t.started && len(t.preamble) < traceMaxPreamble:
+		t.preamble = append(t.preamble, text)
+	}
+}
+
+// note records an event that is not a protocol line
+func (t *smtpTrace) note(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.add("-- " + fmt.Sprintf(format, args...))
+}
+
+// tlsNegotiated records the negotiated TLS parameters
+func (t *smtpTrace) tlsNegotiated(state tls.ConnectionState) {
+	t.note("TLS negotiated: %s, cipher %s, server name %q, ALPN %q, resumed %v",
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), state.ServerName, state.NegotiatedProtocol, state.DidResume)
+}
+
+// begin starts a message, it returns the trace when the message matches one
+func (t *smtpTrace) begin(sender string, recipients []string) *smtpTrace {
+	if t == nil {
+		return nil
+	}
+
+	id, ok := matchTrace(sender, recipients, t.remoteIP)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.started = true
+	if !ok {
+		return nil
+	}
+
+	t.traceId = id
+	if !t.flushed {
+		t.flushed = true
+		for _, text := range t.preamble {
+			t.add(text)
+		}
+		t.preamble = nil
+	}
+	t.add(fmt.Sprintf("-- message from %s to %s", sender, strings.Join(recipients, ",")))
+
+	return t
+}
+
+// command records a command and its outcome when the wire cannot be read
+func (t *smtpTrace) command(text string, err error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.encrypted {
+		return
+	}
+
+	t.add("C: " + text)
+	if err != nil {
+		t.add("S: " + err.Error())
+	} else {
+		t.add("S: OK")
+	}
+}
+
+// end finishes the message
+func (t *smtpTrace) end(err error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		t.add("-- message failed: " + err.Error())
+	} else {
+		t.add("-- message accepted")
+	}
+	t.traceId = ""
+}
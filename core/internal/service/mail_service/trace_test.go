@@ -0,0 +1,98 @@
+package mail_service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceTranscript(t *testing.T) {
+	var written []string
+	defer func(orig func(id, conn, text string)) { writeTrace = orig }(writeTrace)
+	writeTrace = func(id, conn, text string) { written = append(written, text) }
+
+	if tracingEnabled() {
+		t.Fatal("tracing enabled without traces")
+	}
+	if _, err := EnableTrace(TraceMatch{}, time.Minute); err == nil {
+		t.Error("trace without pattern accepted")
+	}
+
+	trace, err := EnableTrace(TraceMatch{Recipient: "*@Example.org", IP: "10.0.0.0/8"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DisableTrace(trace.Id)
+
+	st := &smtpTrace{conn: "test", remoteIP: "10.1.2.3"}
+
+	st.record(0, []byte("220 mx.example.org ESMTP\r\n"))
+	st.record(1, []byte("EHLO localhost\r\n"))
+	st.record(0, []byte("250-mx.example.org\r\n250 AUTH PLAIN\r\n"))
+	st.record(1, []byte("AUTH PLAIN AGFsaWNlAHNlY3JldA==\r\n"))
+	st.record(0, []byte("235 2.7.0 Authentication successful\r\n"))
+
+	// A message that matches no trace is not written
+	if st.begin("alice@example.com", []string{"carol@example.net"}) != nil {
+		t.Fatal("unmatched message traced")
+	}
+	st.record(1, []byte("MAIL FROM:<alice@example.com>\r\n"))
+	st.end(nil)
+
+	if len(written) != 0 {
+		t.Fatalf("lines written for an untraced message: %v", written)
+	}
+
+	matched := st.begin("alice@example.com", []string{"Bob@example.org"})
+	if matched == nil {
+		t.Fatal("matching message not traced")
+	}
+	st.record(1, []byte("MAIL FROM:<alice@example.com>\r\n"))
+	st.record(0, []byte("250 2.1.0 Ok\r\n"))
+	matched.end(nil)
+
+	transcript := strings.Join(written, "\n")
+	for _, want := range []string{"S: 220 mx.example.org ESMTP", "C: EHLO localhost", "C: AUTH PLAIN <redacted>", "S: 250 2.1.0 Ok", "-- message accepted"} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("transcript misses %q:\n%s", want, transcript)
+		}
+	}
+	if strings.Contains(transcript, "AGFsaWNl") {
+		t.Errorf("credentials written to the trace:\n%s", transcript)
+	}
+
+	// The wire is encrypted once STARTTLS is accepted, only commands are recorded then
+	st.record(1, []byte("STARTTLS\r\n"))
+	st.record(0, []byte("220 2.0.0 Ready to start TLS\r\n\x16\x03\x01"))
+	if !st.encrypted {
+		t.Fatal("STARTTLS not detected")
+	}
+
+	written = nil
+	matched = st.begin("alice@example.com", []string{"bob@example.org"})
+	st.record(1, []byte("\x17\x03\x03garbage\r\n"))
+	matched.command("RCPT TO:<bob@example.org>", nil)
+	matched.end(nil)
+
+	if transcript = strings.Join(written, "\n"); strings.Contains(transcript, "garbage") || !strings.Contains(transcript, "C: RCPT TO:<bob@example.org>") {
+		t.Errorf("encrypted transcript:\n%s", transcript)
+	}
+
+	// Other servers are not traced, the trace expires
+	if _, ok := matchTrace("alice@example.com", []string{"bob@example.org"}, "192.168.1.1"); ok {
+		t.Error("server outside of the range traced")
+	}
+
+	if !DisableTrace(trace.Id) || tracingEnabled() || len(ActiveTraces()) != 0 {
+		t.Error("trace not disabled")
+	}
+
+	short, err := EnableTrace(TraceMatch{Sender: "*"}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if DisableTrace(short.Id) || tracingEnabled() {
+		t.Error("trace not expired")
+	}
+}
@@ -0,0 +1,377 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/service/public"
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// -----------------------------
+// Submission validation of outgoing messages, run before the message is handed
+// to postfix. Malformed messages are either rejected with a 5xx naming the
+// offending field, or repaired where a safe fix exists (line endings, missing
+// Date, unparseable display names) when the mode is ValidationModeFixup.
+// -----------------------------
+
+const (
+	validationOptionKey = "submission_validation"
+
+	ValidationModeReject = "reject"
+	ValidationModeFixup  = "fixup"
+
+	maxHeaderLineLength = 998 // RFC 5322 section 2.1.1
+)
+
+// ValidationConfig strictness and limits of the submission validator
+type ValidationConfig struct {
+	Mode           string   `json:"mode"`             // ValidationModeReject or ValidationModeFixup
+	MaxHeaders     int      `json:"max_headers"`      // maximum number of header fields
+	MaxHeaderBytes int      `json:"max_header_bytes"` // maximum size of the header block
+	Required       []string `json:"required"`         // header fields that must be present
+}
+
+// DefaultValidationConfig returns the configuration used when none is stored
+func DefaultValidationConfig() ValidationConfig {
+	return ValidationConfig{
+		Mode:           ValidationModeFixup,
+		MaxHeaders:     100,
+		MaxHeaderBytes: 64 * 1024,
+		Required:       []string{"From", "Date"},
+	}
+}
+
+// GetValidationConfig returns the stored configuration, missing values use the defaults
+func GetValidationConfig(ctx context.Context) ValidationConfig {
+	cfg := DefaultValidationConfig()
+	_ = public.OptionsMgrInstance.GetOption(ctx, validationOptionKey, &cfg)
+
+	def := DefaultValidationConfig()
+	if cfg.Mode != ValidationModeReject {
+		cfg.Mode = ValidationModeFixup
+	}
+	if cfg.MaxHeaders <= 0 {
+		cfg.MaxHeaders = def.MaxHeaders
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		cfg.MaxHeaderBytes = def.MaxHeaderBytes
+	}
+	if len(cfg.Required) == 0 {
+		cfg.Required = def.Required
+	}
+
+	return cfg
+}
+
+// SetValidationConfig stores the configuration
+func SetValidationConfig(ctx context.Context, cfg ValidationConfig) error {
+	if cfg.Mode != ValidationModeReject && cfg.Mode != ValidationModeFixup {
+		return fmt.Errorf("invalid validation mode %q", cfg.Mode)
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, validationOptionKey, cfg)
+}
+
+// SubmissionError rejection of a malformed message
+type SubmissionError struct {
+	Code     int    // SMTP reply code
+	Enhanced string // enhanced status code, e.g. 5.6.0
+	Field    string // offending header field, or "body"
+	Reason   string
+}
+
+func (e *SubmissionError) Error() string {
+	return fmt.Sprintf("%d %s %s: %s", e.Code, e.Enhanced, e.Field, e.Reason)
+}
+
+func submissionError(code int, enhanced, field, format string, args ...any) *SubmissionError {
+	return &SubmissionError{Code: code, Enhanced: enhanced, Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// headerField one header field, Raw holds the folded lines as written
+type headerField struct {
+	Name  string
+	Value string // unfolded value
+	Raw   string
+}
+
+// addressHeaders header fields holding address lists
+var addressHeaders = map[string]bool{
+	"from": true, "sender": true, "reply-to": true, "to": true, "cc": true, "bcc": true,
+}
+
+// ValidateSubmission checks a complete message and returns it, repaired when the mode allows.
+// The returned error is a *SubmissionError for malformed messages
+func ValidateSubmission(msg []byte, cfg ValidationConfig) ([]byte, error) {
+	fixup := cfg.Mode == ValidationModeFixup
+	changed := false
+
+	// Line endings
+	if where, line := findBareLineEnding(msg); where != "" {
+		if !fixup {
+			return nil, submissionError(550, "5.6.0", lineEndingField(msg, line), "bare %s at line %d", where, line)
+		}
+		msg = normalizeLineEndings(msg)
+		changed = true
+	}
+
+	header, body := splitMessage(msg)
+
+	fields, err := parseHeaderFields(header, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Required fields
+	for _, name := range cfg.Required {
+		n := countFields(fields, name)
+
+		switch {
+		case n == 0 && fixup && strings.EqualFold(name, "Date"):
+			fields = append(fields, headerField{Name: "Date", Value: time.Now().Format(time.RFC1123Z)})
+			changed = true
+		case n == 0:
+			return nil, submissionError(550, "5.6.0", name, "required header is missing")
+		case n > 1 && (strings.EqualFold(name, "From") || strings.EqualFold(name, "Date")):
+			return nil, submissionError(550, "5.6.0", name, "header appears %d times", n)
+		}
+	}
+
+	// Addresses
+	for i, f := range fields {
+		key := strings.ToLower(f.Name)
+		if !addressHeaders[key] {
+			continue
+		}
+
+		value := strings.TrimSpace(f.Value)
+		if value == "" && key == "bcc" {
+			continue
+		}
+
+		if _, err := mail.ParseAddressList(value); err == nil {
+			continue
+		}
+
+		enhanced := "5.1.3"
+		if key == "from" || key == "sender" {
+			enhanced = "5.1.7"
+		}
+
+		repaired, ok := repairAddressList(value)
+		if !fixup || !ok {
+			return nil, submissionError(553, enhanced, f.Name, "invalid address %q", value)
+		}
+
+		fields[i] = headerField{Name: f.Name, Value: repaired}
+		changed = true
+	}
+
+	if !changed {
+		return msg, nil
+	}
+
+	return buildMessage(fields, body), nil
+}
+
+// findBareLineEnding returns "CR" or "LF" and the line number of the first line ending not written as CRLF
+func findBareLineEnding(msg []byte) (string, int) {
+	line := 1
+
+	for i, c := range msg {
+		switch c {
+		case '\r':
+			if i+1 >= len(msg) || msg[i+1] != '\n' {
+				return "CR", line
+			}
+		case '\n':
+			if i == 0 || msg[i-1] != '\r' {
+				return "LF", line
+			}
+			line++
+		}
+	}
+
+	return "", 0
+}
+
+// lineEndingField names the part of the message holding the given line
+func lineEndingField(msg []byte, line int) string {
+	lines := bytes.Split(normalizeLineEndings(msg), []byte("\r\n"))
+
+	for i := 0; i < len(lines) && i < line-1; i++ {
+		if len(lines[i]) == 0 {
+			return "body"
+		}
+	}
+
+	if line-1 < len(lines) {
+		if name, _, ok := strings.Cut(string(lines[line-1]), ":"); ok && !strings.ContainsAny(name, " \t") {
+			return name
+		}
+	}
+
+	return "header"
+}
+
+// normalizeLineEndings rewrites bare CR and bare LF to CRLF
+func normalizeLineEndings(msg []byte) []byte {
+	out := make([]byte, 0, len(msg)+16)
+
+	for i := 0; i < len(msg); i++ {
+		switch msg[i] {
+		case '\r':
+			out = append(out, '\r', '\n')
+			if i+1 < len(msg) && msg[i+1] == '\n' {
+				i++
+			}
+		case '\n':
+			out = append(out, '\r', '\n')
+		default:
+			out = append(out, msg[i])
+		}
+	}
+
+	return out
+}
+
+// splitMessage splits at the first empty line, the body keeps everything after it
+func splitMessage(msg []byte) (header, body []byte) {
+	if bytes.HasPrefix(msg, []byte("\r\n")) {
+		return nil, msg[2:]
+	}
+
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		return msg[:i+2], msg[i+4:]
+	}
+
+	return msg, nil
+}
+
+// parseHeaderFields parses the header block and enforces the size limits
+func parseHeaderFields(header []byte, cfg ValidationConfig) ([]headerField, error) {
+	if len(header) > cfg.MaxHeaderBytes {
+		return nil, submissionError(552, "5.3.4", "header", "header size %d exceeds %d bytes", len(header), cfg.MaxHeaderBytes)
+	}
+
+	fields := make([]headerField, 0)
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(header), "\r\n"), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		if len(line) > maxHeaderLineLength {
+			name, _, _ := strings.Cut(line, ":")
+			return nil, submissionError(552, "5.3.4", name, "header line of %d characters exceeds %d", len(line), maxHeaderLineLength)
+		}
+
+		// Folded continuation of the previous field
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(fields) == 0 {
+				return nil, submissionError(550, "5.6.0", "header", "continuation line without a header field")
+			}
+			last := &fields[len(fields)-1]
+			last.Value += line
+			last.Raw += "\r\n" + line
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, submissionError(550, "5.6.0", "header", "malformed header line %q", truncate(line, 64))
+		}
+
+		fields = append(fields, headerField{Name: name, Value: value, Raw: line})
+
+		if len(fields) > cfg.MaxHeaders {
+			return nil, submissionError(552, "5.3.4", "header", "more than %d header fields", cfg.MaxHeaders)
+		}
+	}
+
+	return fields, nil
+}
+
+// countFields number of fields with the given name
+func countFields(fields []headerField, name string) int {
+	n := 0
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			n++
+		}
+	}
+	return n
+}
+
+// repairAddressList rebuilds an address list whose display names need quoting,
+// e.g. `Smith, John <john@example.com>`. It fails when an address itself is invalid
+func repairAddressList(value string) (string, bool) {
+	parts := make([]string, 0)
+
+	rest := value
+	for strings.TrimSpace(rest) != "" {
+		open := strings.Index(rest, "<")
+		closing := strings.Index(rest, ">")
+
+		// Plain addresses without angle brackets
+		if open < 0 || closing < open {
+			for _, a := range strings.Split(rest, ",") {
+				a = strings.TrimSpace(a)
+				if a == "" {
+					continue
+				}
+				addr, err := mail.ParseAddress(a)
+				if err != nil {
+					return "", false
+				}
+				parts = append(parts, addr.String())
+			}
+			break
+		}
+
+		name := strings.Trim(strings.TrimSpace(strings.TrimLeft(rest[:open], ", ")), `"`)
+		addr, err := mail.ParseAddress("<" + rest[open+1:closing] + ">")
+		if err != nil {
+			return "", false
+		}
+		addr.Name = name
+		parts = append(parts, addr.String())
+
+		rest = rest[closing+1:]
+	}
+
+	if len(parts) == 0 {
+		return "", false
+	}
+
+	return strings.Join(parts, ", "), true
+}
+
+// buildMessage writes the header fields and body back into a message
+func buildMessage(fields []headerField, body []byte) []byte {
+	buf := new(bytes.Buffer)
+
+	for _, f := range fields {
+		if f.Raw != "" {
+			buf.WriteString(f.Raw)
+		} else {
+			buf.WriteString(f.Name + ": " + strings.TrimSpace(f.Value))
+		}
+		buf.WriteString("\r\n")
+	}
+
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	return buf.Bytes()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,119 @@
+package mail_service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const validMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.org\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"Subject: hello\r\n" +
+	"\r\n" +
+	"body line\r\n"
+
+func rejectConfig() ValidationConfig {
+	cfg := DefaultValidationConfig()
+	cfg.Mode = ValidationModeReject
+	return cfg
+}
+
+func expectRejection(t *testing.T, msg string, cfg ValidationConfig, code int, field string) {
+	t.Helper()
+
+	_, err := ValidateSubmission([]byte(msg), cfg)
+
+	var subErr *SubmissionError
+	if !errors.As(err, &subErr) {
+		t.Fatalf("expected a submission error, got %v", err)
+	}
+
+	if subErr.Code != code || subErr.Field != field {
+		t.Fatalf("expected %d on %s, got %v", code, field, subErr)
+	}
+}
+
+func TestValidMessageUnchanged(t *testing.T) {
+	for _, cfg := range []ValidationConfig{rejectConfig(), DefaultValidationConfig()} {
+		out, err := ValidateSubmission([]byte(validMessage), cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", cfg.Mode, err)
+		}
+		if string(out) != validMessage {
+			t.Fatalf("%s: message changed:\n%q", cfg.Mode, out)
+		}
+	}
+}
+
+func TestRejectMalformed(t *testing.T) {
+	cases := map[string]struct {
+		msg   string
+		code  int
+		field string
+	}{
+		"bare LF in header": {strings.Replace(validMessage, "To: bob@example.org\r\n", "To: bob@example.org\n", 1), 550, "To"},
+		"bare LF in body":   {validMessage + "second\nthird\r\n", 550, "body"},
+		"bare CR":           {strings.Replace(validMessage, "body line", "body\rline", 1), 550, "body"},
+		"missing From":      {strings.Replace(validMessage, "From: Alice <alice@example.com>\r\n", "", 1), 550, "From"},
+		"missing Date":      {strings.Replace(validMessage, "Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n", "", 1), 550, "Date"},
+		"duplicate From":    {"From: a@example.com\r\n" + validMessage, 550, "From"},
+		"invalid From":      {strings.Replace(validMessage, "Alice <alice@example.com>", "alice@", 1), 553, "From"},
+		"unquoted comma":    {strings.Replace(validMessage, "Alice <alice@example.com>", "Smith, Alice <alice@example.com>", 1), 553, "From"},
+		"invalid To":        {strings.Replace(validMessage, "bob@example.org", "bob at example", 1), 553, "To"},
+		"no colon":          {"Garbage line\r\n" + validMessage, 550, "header"},
+		"long header line":  {"X-Long: " + strings.Repeat("a", 1000) + "\r\n" + validMessage, 552, "X-Long"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			expectRejection(t, c.msg, rejectConfig(), c.code, c.field)
+		})
+	}
+}
+
+func TestHeaderLimits(t *testing.T) {
+	cfg := rejectConfig()
+	cfg.MaxHeaders = 5
+
+	extra := strings.Repeat("X-Extra: 1\r\n", 2)
+	expectRejection(t, extra+validMessage, cfg, 552, "header")
+
+	cfg = rejectConfig()
+	cfg.MaxHeaderBytes = 64
+	expectRejection(t, validMessage, cfg, 552, "header")
+}
+
+func TestFixup(t *testing.T) {
+	cfg := DefaultValidationConfig()
+
+	msg := "From: Smith, Alice <alice@example.com>\n" +
+		"To: bob@example.org\n" +
+		"Subject: hello\n" +
+		"\n" +
+		"line one\nline two\r"
+
+	out, err := ValidateSubmission([]byte(msg), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := string(out)
+
+	if where, _ := findBareLineEnding(out); where != "" {
+		t.Fatalf("bare %s left in %q", where, s)
+	}
+	if !strings.HasPrefix(s, `From: "Smith, Alice" <alice@example.com>`+"\r\n") {
+		t.Fatalf("From not repaired: %q", s)
+	}
+	if !strings.Contains(s, "\r\nDate: ") {
+		t.Fatalf("Date not added: %q", s)
+	}
+	if !strings.HasSuffix(s, "\r\n\r\nline one\r\nline two\r\n") {
+		t.Fatalf("body not preserved: %q", s)
+	}
+
+	// Problems without a safe fix are still rejected
+	expectRejection(t, strings.Replace(validMessage, "From: Alice <alice@example.com>\r\n", "", 1), cfg, 550, "From")
+	expectRejection(t, strings.Replace(validMessage, "bob@example.org", "bob at example", 1), cfg, 553, "To")
+}
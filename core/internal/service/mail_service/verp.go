@@ -0,0 +1,251 @@
+package mail_service
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------
+// Variable Envelope Return Path (VERP) of the campaigns.
+// The envelope sender of each campaign message encodes its campaign and recipient, so a
+// bounce returned to it is attributed to that recipient without guessing from the DSN.
+//   <prefix>+<task>-<hash>-<recipient>@<return-domain>
+// The recipient is lowercased, its @ becomes =, the bytes other than letters, digits,
+// - and the inner dots are escaped as _XX. The hash is a truncated HMAC with the SRS
+// secrets, a bounce cannot be attributed to a recipient it was not sent to, and the
+// addresses issued before a rotation keep decoding. The local part may exceed the 64
+// octets of RFC 5321 with long recipients, which the MTAs accept in practice.
+// -----------------------------
+
+const (
+	verpOptionKey = "verp_settings"
+
+	// DefaultVERPPrefix local part the tags are appended to
+	DefaultVERPPrefix = "bounces"
+
+	verpHashLength = 8
+)
+
+var verpPrefixPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// VERPSettings return path of the campaign messages
+type VERPSettings struct {
+	Enabled bool   `json:"enabled"`
+	Domain  string `json:"domain"` // domain of the return path, its SPF record must authorize this server
+	Prefix  string `json:"prefix"` // DefaultVERPPrefix when empty
+}
+
+// GetVERPSettings returns the stored settings, VERP disabled when unset
+func GetVERPSettings(ctx context.Context) VERPSettings {
+	s := VERPSettings{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, verpOptionKey, &s)
+	return s.withDefaults()
+}
+
+// SaveVERPSettings validates the format of the settings and stores them. The domain is
+// not checked against DNS here, see batch_mail.SetVERPSettings
+func SaveVERPSettings(ctx context.Context, s VERPSettings) error {
+	s = s.withDefaults()
+	if err := s.validate(); err != nil {
+		return err
+	}
+	return public.OptionsMgrInstance.SetOption(ctx, verpOptionKey, s)
+}
+
+func (s VERPSettings) withDefaults() VERPSettings {
+	s.Domain = strings.ToLower(strings.TrimSpace(s.Domain))
+	s.Prefix = strings.ToLower(strings.TrimSpace(s.Prefix))
+	if s.Prefix == "" {
+		s.Prefix = DefaultVERPPrefix
+	}
+	return s
+}
+
+func (s VERPSettings) validate() error {
+	if !verpPrefixPattern.MatchString(s.Prefix) {
+		return fmt.Errorf("invalid VERP prefix %q", s.Prefix)
+	}
+	if s.Enabled && (s.Domain == "" || strings.ContainsAny(s.Domain, "@ ")) {
+		return fmt.Errorf("invalid return-path domain %q", s.Domain)
+	}
+	return nil
+}
+
+// BounceAddress the address the bounces are delivered to, prefix@domain
+func (s VERPSettings) BounceAddress() string {
+	return s.Prefix + "@" + s.Domain
+}
+
+// VERPReturnPath the envelope sender of the message of a campaign to recipient, empty
+// when VERP is disabled
+func VERPReturnPath(ctx context.Context, taskId int, recipient string) (string, error) {
+	s := GetVERPSettings(ctx)
+	if !s.Enabled {
+		return "", nil
+	}
+
+	secrets, err := GetSRSSecrets(ctx)
+	if err != nil {
+		return "", err
+	}
+	return NewVERP(s.Prefix, s.Domain, secrets).Encode(taskId, recipient)
+}
+
+// VERPDecode the campaign and recipient of a return path issued by VERPReturnPath
+func VERPDecode(ctx context.Context, address string) (taskId int, recipient string, err error) {
+	s := GetVERPSettings(ctx)
+	if !s.Enabled {
+		return 0, "", fmt.Errorf("VERP is disabled")
+	}
+
+	secrets, err := GetSRSSecrets(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	return NewVERP(s.Prefix, s.Domain, secrets).Decode(address)
+}
+
+// VERP encoding of the return paths, the first secret signs, all secrets verify
+type VERP struct {
+	Prefix  string
+	Domain  string
+	Secrets []string
+}
+
+// NewVERP creates an encoder of the return paths prefix+...@domain
+func NewVERP(prefix, domain string, secrets []string) *VERP {
+	return &VERP{
+		Prefix:  strings.ToLower(strings.TrimSpace(prefix)),
+		Domain:  strings.ToLower(strings.TrimSpace(domain)),
+		Secrets: secrets,
+	}
+}
+
+// Encode the return path of the message of campaign taskId to recipient
+func (v *VERP) Encode(taskId int, recipient string) (string, error) {
+	if len(v.Secrets) == 0 {
+		return "", fmt.Errorf("no VERP secret configured")
+	}
+	if taskId <= 0 {
+		return "", fmt.Errorf("invalid campaign %d", taskId)
+	}
+
+	recipient = strings.ToLower(strings.Trim(strings.TrimSpace(recipient), "<>"))
+	if _, _, ok := splitAddress(recipient); !ok {
+		return "", fmt.Errorf("invalid recipient address: %s", recipient)
+	}
+
+	hash := v.hash(v.Secrets[0], taskId, recipient)
+	return fmt.Sprintf("%s+%d-%s-%s@%s", v.Prefix, taskId, hash, verpEncode(recipient), v.Domain), nil
+}
+
+// Decode the campaign and recipient of a return path, the signature is checked
+func (v *VERP) Decode(address string) (taskId int, recipient string, err error) {
+	local, domain, ok := splitAddress(address)
+	if !ok || !strings.EqualFold(domain, v.Domain) {
+		return 0, "", fmt.Errorf("not a VERP address: %s", address)
+	}
+
+	prefix, tag, ok := strings.Cut(strings.ToLower(local), "+")
+	if !ok || prefix != v.Prefix {
+		return 0, "", fmt.Errorf("not a VERP address: %s", address)
+	}
+
+	parts := strings.SplitN(tag, "-", 3)
+	if len(parts) != 3 {
+		return 0, "", fmt.Errorf("malformed VERP address: %s", address)
+	}
+
+	taskId, err = strconv.Atoi(parts[0])
+	if err != nil || taskId <= 0 {
+		return 0, "", fmt.Errorf("malformed VERP address: %s", address)
+	}
+
+	recipient, err = verpDecode(parts[2])
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed VERP address: %s: %w", address, err)
+	}
+
+	if !v.verify(parts[1], taskId, recipient) {
+		return 0, "", fmt.Errorf("invalid VERP signature: %s", address)
+	}
+
+	return taskId, recipient, nil
+}
+
+// hash truncated HMAC-SHA256 of the campaign and recipient, apart from the SRS hashes
+// made with the same secrets
+func (v *VERP) hash(secret string, taskId int, recipient string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("verp\x00" + strconv.Itoa(taskId) + "\x00" + recipient))
+	return hex.EncodeToString(mac.Sum(nil))[:verpHashLength]
+}
+
+func (v *VERP) verify(hash string, taskId int, recipient string) bool {
+	for _, secret := range v.Secrets {
+		if hmac.Equal([]byte(strings.ToLower(hash)), []byte(v.hash(secret, taskId, recipient))) {
+			return true
+		}
+	}
+	return false
+}
+
+// verpEncode the recipient as a fragment of a dot-atom local part
+func verpEncode(recipient string) string {
+	var b strings.Builder
+	for i := 0; i < len(recipient); i++ {
+		c := recipient[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-':
+			b.WriteByte(c)
+		case c == '@':
+			b.WriteByte('=')
+		case c == '.' && i > 0 && i < len(recipient)-1 && verpAtomByte(recipient[i-1]) && verpAtomByte(recipient[i+1]):
+			// A dot-atom has no leading, trailing nor consecutive dots
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+func verpAtomByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-'
+}
+
+// verpDecode reverses verpEncode
+func verpDecode(encoded string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(encoded); i++ {
+		switch c := encoded[i]; c {
+		case '=':
+			b.WriteByte('@')
+		case '_':
+			if i+2 >= len(encoded) {
+				return "", fmt.Errorf("truncated escape")
+			}
+			n, err := strconv.ParseUint(encoded[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape %q", encoded[i:i+3])
+			}
+			b.WriteByte(byte(n))
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	recipient := b.String()
+	if _, _, ok := splitAddress(recipient); !ok {
+		return "", fmt.Errorf("invalid recipient %q", recipient)
+	}
+	return recipient, nil
+}
@@ -0,0 +1,128 @@
+package mail_service
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestVERPRoundTrip(t *testing.T) {
+	v := NewVERP("bounces", "return.example.com", []string{"secret"})
+
+	recipients := map[string]string{
+		"plain":             "alice@example.org",
+		"uppercase":         "Alice.Smith@Example.ORG",
+		"plus tag":          "bob+news@example.org",
+		"equal sign":        "a=b@example.org",
+		"underscore":        "first_last@example.org",
+		"hyphens":           "x-y--z@mail-host.example.org",
+		"leading dot":       ".dot@example.org",
+		"consecutive dots":  "a..b@example.org",
+		"quoted local part": `"john doe"@example.org`,
+		"quoted at":         `"a@b"@example.org`,
+		"specials":          "o'neil!#$%&*/?^`{|}~@example.org",
+		"utf-8":             "josé@exämple.org",
+	}
+
+	for name, recipient := range recipients {
+		address, err := v.Encode(42, recipient)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		local, domain, _ := strings.Cut(address, "@")
+		if domain != "return.example.com" || !strings.HasPrefix(local, "bounces+42-") {
+			t.Errorf("%s: unexpected return path %s", name, address)
+		}
+		// The return path is a plain dot-atom address
+		if parsed, err := mail.ParseAddress("<" + address + ">"); err != nil || parsed.Address != address {
+			t.Errorf("%s: %s is not a dot-atom address: %v", name, address, err)
+		}
+
+		task, decoded, err := v.Decode(address)
+		if err != nil {
+			t.Fatalf("%s: decode %s: %v", name, address, err)
+		}
+		if task != 42 || decoded != strings.ToLower(recipient) {
+			t.Errorf("%s: decoded %d %q, want 42 %q", name, task, decoded, strings.ToLower(recipient))
+		}
+
+		// Some MTAs change the case of the local parts
+		if _, decoded, err = v.Decode(strings.ToUpper(address)); err != nil || decoded != strings.ToLower(recipient) {
+			t.Errorf("%s: uppercased %s decoded %q: %v", name, address, decoded, err)
+		}
+	}
+}
+
+func TestVERPRejectsForgedAddresses(t *testing.T) {
+	v := NewVERP("bounces", "return.example.com", []string{"secret"})
+
+	address, err := v.Encode(7, "alice@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := strings.Replace(address, "alice", "carol", 1)
+	otherTask := strings.Replace(address, "+7-", "+8-", 1)
+
+	for name, a := range map[string]string{
+		"other recipient": forged,
+		"other campaign":  otherTask,
+		"other domain":    strings.Replace(address, "return.example.com", "example.net", 1),
+		"other prefix":    strings.Replace(address, "bounces+", "errors+", 1),
+		"no tag":          "bounces@return.example.com",
+		"truncated":       strings.TrimSuffix(address, "g@return.example.com") + "_6@return.example.com",
+		"bad escape":      "bounces+7-00000000-alice_zz=example.org@return.example.com",
+		"no recipient":    "bounces+7-00000000-@return.example.com",
+	} {
+		if _, _, err := v.Decode(a); err == nil {
+			t.Errorf("%s: %s accepted", name, a)
+		}
+	}
+
+	if _, err := v.Encode(0, "alice@example.org"); err == nil {
+		t.Error("campaign 0 encoded")
+	}
+	if _, err := v.Encode(7, "not an address"); err == nil {
+		t.Error("invalid recipient encoded")
+	}
+	if _, err := NewVERP("bounces", "return.example.com", nil).Encode(7, "alice@example.org"); err == nil {
+		t.Error("encoded without a secret")
+	}
+}
+
+func TestVERPSecretRotation(t *testing.T) {
+	old := NewVERP("bounces", "return.example.com", []string{"old"})
+	address, err := old.Encode(3, "alice@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := NewVERP("bounces", "return.example.com", []string{"new", "old"})
+	if task, recipient, err := rotated.Decode(address); err != nil || task != 3 || recipient != "alice@example.org" {
+		t.Errorf("address of the previous secret decoded %d %q: %v", task, recipient, err)
+	}
+
+	dropped := NewVERP("bounces", "return.example.com", []string{"new"})
+	if _, _, err := dropped.Decode(address); err == nil {
+		t.Error("address of a dropped secret accepted")
+	}
+}
+
+func TestVERPSettingsValidation(t *testing.T) {
+	for _, s := range []VERPSettings{
+		{Enabled: true},
+		{Enabled: true, Domain: "bounce@example.com"},
+		{Enabled: true, Domain: "example.com", Prefix: "bounces+x"},
+		{Enabled: true, Domain: "example.com", Prefix: ".bounces"},
+	} {
+		if err := s.withDefaults().validate(); err == nil {
+			t.Errorf("%+v accepted", s)
+		}
+	}
+
+	s := VERPSettings{Enabled: true, Domain: " Return.Example.com "}.withDefaults()
+	if err := s.validate(); err != nil || s.BounceAddress() != "bounces@return.example.com" {
+		t.Errorf("%+v: %v", s, err)
+	}
+}
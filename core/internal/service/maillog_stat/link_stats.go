@@ -0,0 +1,123 @@
+package maillog_stat
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gogf/gf/v2/database/gdb"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// trackingParams query parameters added by analytics and ad platforms, two links that only
+// differ by them are the same link in the statistics
+var trackingParams = map[string]bool{
+	"gclid": true, "dclid": true, "fbclid": true, "msclkid": true, "yclid": true, "igshid": true,
+	"mc_cid": true, "mc_eid": true, "_hsenc": true, "_hsmi": true, "mkt_tok": true,
+}
+
+// LinkPositionStat clicks of one link of the message
+type LinkPositionStat struct {
+	Link           int `json:"link"` // position in the message from 1, 0 for the clicks recorded without it
+	Clicks         int `json:"clicks"`
+	UniqueClickers int `json:"unique_clickers"`
+}
+
+// LinkClickStat clicks of one URL of a campaign, with the positions it appears at
+type LinkClickStat struct {
+	Url            string             `json:"url"`
+	Clicks         int                `json:"clicks"`
+	UniqueClickers int                `json:"unique_clickers"`
+	Positions      []LinkPositionStat `json:"positions"`
+}
+
+// NormalizeTrackedURL the URL in the form links are compared in: lowercase scheme and host,
+// no default port nor fragment, without the utm_* and click id parameters and with the
+// other parameters sorted
+func NormalizeTrackedURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	query := u.Query()
+	for key := range query {
+		if k := strings.ToLower(key); strings.HasPrefix(k, "utm_") || trackingParams[k] {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// CampaignLinkStats click counts of each URL of a campaign, most clicked first
+func CampaignLinkStats(ctx context.Context, campaignID int) ([]LinkClickStat, error) {
+	model := func() *gdb.Model {
+		return g.DB().Model("mailstat_clicked").Ctx(ctx).
+			Where("campaign_id", campaignID).
+			WhereNot("normalized_url", "")
+	}
+
+	urls, err := model().
+		Fields("normalized_url AS url, count(*) AS clicks, count(DISTINCT recipient) AS unique_clickers").
+		Group("normalized_url").
+		All()
+	if err != nil {
+		return nil, err
+	}
+
+	positions, err := model().
+		Fields("normalized_url AS url, link_index AS link, count(*) AS clicks, count(DISTINCT recipient) AS unique_clickers").
+		Group("normalized_url, link_index").
+		Order("link_index").
+		All()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]LinkClickStat, 0, len(urls))
+	byUrl := make(map[string]int, len(urls))
+	for _, row := range urls {
+		byUrl[row["url"].String()] = len(stats)
+		stats = append(stats, LinkClickStat{
+			Url:            row["url"].String(),
+			Clicks:         row["clicks"].Int(),
+			UniqueClickers: row["unique_clickers"].Int(),
+			Positions:      make([]LinkPositionStat, 0, 1),
+		})
+	}
+
+	for _, row := range positions {
+		i, ok := byUrl[row["url"].String()]
+		if !ok {
+			continue
+		}
+		stats[i].Positions = append(stats[i].Positions, LinkPositionStat{
+			Link:           row["link"].Int(),
+			Clicks:         row["clicks"].Int(),
+			UniqueClickers: row["unique_clickers"].Int(),
+		})
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Clicks != stats[j].Clicks {
+			return stats[i].Clicks > stats[j].Clicks
+		}
+		return stats[i].Url < stats[j].Url
+	})
+
+	return stats, nil
+}
@@ -2,6 +2,7 @@ package maillog_stat
 
 import (
 	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/log_maintenance"
 	"billionmail-core/internal/service/public"
 	"context"
 	"errors"
@@ -155,6 +156,7 @@ type MaillogStat struct {
 	messageIDPattern    *regexp.Regexp
 	mailRemovedPattern  *regexp.Regexp
 	mailSenderPattern   *regexp.Regexp
+	tlsSessions         *tlsSessionTracker
 }
 
 // NewMaillogStat creates a new mail log statistics analyzer
@@ -211,6 +213,7 @@ func (ms *MaillogStat) resetData() {
 	ms.bounced = 0
 	ms.deferred = 0
 	ms.deferredTotal = 0
+	ms.tlsSessions = newTLSSessionTracker()
 }
 
 // initIgnoreRelays initializes ignored relays
@@ -360,6 +363,10 @@ func (ms *MaillogStat) Analysis(ctx context.Context) (<-chan MailRecorfContract,
 			default:
 				record, stop := ms.analyzeLine(line)
 
+				for _, s := range ms.tlsSessions.take() {
+					recordChan <- s
+				}
+
 				if stop {
 					return false
 				}
@@ -387,6 +394,10 @@ func (ms *MaillogStat) Analysis(ctx context.Context) (<-chan MailRecorfContract,
 			return true
 		})
 
+		for _, s := range ms.tlsSessions.flush() {
+			recordChan <- s
+		}
+
 		g.Log().Debug(ctx, "Total scanned lines:", n)
 
 		if err != nil {
@@ -397,9 +408,13 @@ func (ms *MaillogStat) Analysis(ctx context.Context) (<-chan MailRecorfContract,
 	return recordChan, nil
 }
 
-// analyzeLine analyzes single log line
+// analyzeLine analyzes single log line, the lines looking injected are not counted
 func (ms *MaillogStat) analyzeLine(line string) (record MailRecorfContract, stop bool) {
-	line = strings.TrimSpace(line)
+	line, suspicious := log_maintenance.SanitizeLogLine(strings.TrimSpace(line))
+	if suspicious {
+		return
+	}
+
 	logTime := ms.parseLogTimeMillis(line)
 
 	if logTime < 1 {
@@ -415,6 +430,10 @@ func (ms *MaillogStat) analyzeLine(line string) (record MailRecorfContract, stop
 		return
 	}
 
+	if strings.Contains(line, "/smtp[") {
+		ms.tlsSessions.observe(ms, line, logTime)
+	}
+
 	// Analyze different types of logs
 	if strings.Contains(line, "postfix/lmtp[") {
 		record = ms.analyzeReceiveMail(line, logTime)
@@ -653,6 +672,7 @@ func (ms *MaillogStat) AnalysisAndSaveToDatabase(ctx context.Context) error {
 	senderRecords := make([]*MailSender, 0, 256)
 	removedRecords := make([]*MailRemoved, 0, 256)
 	deferredRecords := make([]*MailDeferredRecord, 0, 256)
+	tlsSessionRecords := make([]*MailTLSSession, 0, 256)
 
 	existsPostfixMessageIdsForSends := make(map[string]struct{}, 256)
 	existsPostfixMessageIdsForReceives := make(map[string]struct{}, 256)
@@ -698,6 +718,10 @@ func (ms *MaillogStat) AnalysisAndSaveToDatabase(ctx context.Context) error {
 			r.Relay = public.SanitizeUTF8(r.Relay)
 			r.Dsn = public.SanitizeUTF8(r.Dsn)
 			deferredRecords = append(deferredRecords, r)
+		case *MailTLSSession:
+			r.Detail = public.SanitizeUTF8(r.Detail)
+			r.MxHost = public.SanitizeUTF8(r.MxHost)
+			tlsSessionRecords = append(tlsSessionRecords, r)
 		default:
 			continue
 		}
@@ -770,6 +794,26 @@ func (ms *MaillogStat) AnalysisAndSaveToDatabase(ctx context.Context) error {
 		if err != nil {
 			g.Log().Error(context.Background(), err.Error())
 		}
+
+		seen := make(map[string]struct{}, len(deferredRecords))
+		deferredIds := make([]string, 0, len(deferredRecords))
+		for _, r := range deferredRecords {
+			if _, ok := seen[r.GetPostfixMessageID()]; !ok {
+				seen[r.GetPostfixMessageID()] = struct{}{}
+				deferredIds = append(deferredIds, r.GetPostfixMessageID())
+			}
+		}
+		if err = pruneQueueAttempts(context.Background(), deferredIds); err != nil {
+			g.Log().Error(context.Background(), err.Error())
+		}
+	}
+
+	if len(tlsSessionRecords) > 0 {
+		g.Log().Debug(context.Background(), "AnalysisAndSaveToDatabase: tlsSessionRecords", len(tlsSessionRecords))
+		_, err = g.DB().Model("mailstat_tls_sessions").Batch(5000).InsertIgnore(tlsSessionRecords)
+		if err != nil {
+			g.Log().Error(context.Background(), err.Error())
+		}
 	}
 
 	return nil
@@ -0,0 +1,117 @@
+package maillog_stat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeLineSkipsInjectedEntries(t *testing.T) {
+	ms := NewMaillogStat("/nonexistent", 0, 0, false)
+	ms.currentYear = 2025
+
+	sent := "Jan  2 10:00:01 mx postfix/smtp[13]: 5A1B2C: to=<bob@example.com>, relay=mx.example.com[192.0.2.1]:25, delay=1.2, delays=0.1/0/0.5/0.6, dsn=2.0.0, status=sent (250 ok)"
+	record, _ := ms.analyzeLine(sent)
+	if _, ok := record.(*MailSendRecord); !ok {
+		t.Fatalf("analyzeLine(%q) = %#v, want a send record", sent, record)
+	}
+
+	for _, line := range []string{
+		// A subject carrying a fake delivery
+		"Jan  2 10:00:00 mx postfix/cleanup[12]: 4F: warning: header Subject: hi " + sent,
+		// A line break turned into a fake entry
+		"Jan  2 10:00:00 mx postfix/cleanup[12]: 4F: warning: header Subject: hi\r" + sent,
+		"Jan  2 10:00:00 mx postfix/cleanup[12]: 4F: warning: header Subject: \x1b[1A" + sent,
+	} {
+		if record, _ := ms.analyzeLine(line); record != nil {
+			t.Errorf("analyzeLine(%q) = %#v, want the injected line skipped", line, record)
+		}
+	}
+}
+
+func TestTraceIdsLearnQueueIds(t *testing.T) {
+	ids := &traceIds{queue: make(map[string]bool), message: make(map[string]bool)}
+	ids.add("", "abc.123@example.com")
+
+	lines := []string{
+		"Mar  1 10:00:00 mail postfix/smtpd[10]: 4TQ1X2Y3Z4: client=unknown[192.0.2.1]",
+		"Mar  1 10:00:01 mail postfix/cleanup[11]: 5AB2C3D4E5: message-id=<abc.123@example.com>",
+		"Mar  1 10:00:02 mail postfix/qmgr[12]: 5AB2C3D4E5: from=<a@example.com>, size=100",
+		"Mar  1 10:00:03 mail postfix/qmgr[12]: 6ZZ2C3D4E5: from=<b@example.com>, size=100",
+	}
+
+	var matched []int
+	for i, line := range lines {
+		if ids.matches(line) {
+			matched = append(matched, i)
+		}
+	}
+
+	if len(matched) != 2 || matched[0] != 1 || matched[1] != 2 {
+		t.Errorf("matched lines %v, want 1 and 2", matched)
+	}
+	if !ids.queue["5AB2C3D4E5"] {
+		t.Errorf("queue id not learned: %v", ids.queue)
+	}
+}
+
+func TestAnalysisRecordsTLSSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	lines := []string{
+		// Verified session delivering two recipients, then a message reusing it
+		"Jan  2 10:00:00 mx postfix/smtp[20]: Verified TLS connection established to mx.example.com[192.0.2.1]:25: TLSv1.3 with cipher TLS_AES_256_GCM_SHA384",
+		"Jan  2 10:00:01 mx postfix/smtp[20]: 5A1: to=<a@example.com>, relay=mx.example.com[192.0.2.1]:25, delay=1, delays=0/0/0.5/0.5, dsn=2.0.0, status=sent (250 ok)",
+		"Jan  2 10:00:01 mx postfix/smtp[20]: 5A1: to=<b@example.com>, relay=mx.example.com[192.0.2.1]:25, delay=1, delays=0/0/0.5/0.5, dsn=2.0.0, status=sent (250 ok)",
+		"Jan  2 10:00:02 mx postfix/smtp[20]: 5A2: to=<c@example.com>, relay=mx.example.com[192.0.2.1]:25, conn_use=2, delay=1, delays=0/0/0/1, dsn=2.0.0, status=sent (250 ok)",
+		// Expired certificate with TLS required
+		"Jan  2 10:01:00 mx postfix/smtp[21]: server certificate verification failed for mx.example.net[192.0.2.2]:25: num=10:certificate has expired",
+		"Jan  2 10:01:00 mx postfix/smtp[21]: Untrusted TLS connection established to mx.example.net[192.0.2.2]:25: TLSv1.2 with cipher ECDHE-RSA-AES256-GCM-SHA384",
+		"Jan  2 10:01:01 mx postfix/smtp[21]: 5B1: to=<d@example.net>, relay=mx.example.net[192.0.2.2]:25, delay=1, delays=0/0/1/0, dsn=4.7.5, status=deferred (Server certificate not verified)",
+		// STARTTLS not offered, then a plain delivery not recorded
+		"Jan  2 10:02:00 mx postfix/smtp[21]: 5C1: to=<e@example.org>, relay=mx.example.org[192.0.2.3]:25, delay=1, delays=0/0/1/0, dsn=4.7.4, status=deferred (TLS is required, but was not offered by host mx.example.org[192.0.2.3])",
+		"Jan  2 10:03:00 mx postfix/smtp[21]: 5D1: to=<f@example.com>, relay=mx.plain.example[192.0.2.4]:25, delay=1, delays=0/0/1/0, dsn=2.0.0, status=sent (250 ok)",
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ms := NewMaillogStat(path, 0, 0, false)
+	records, err := ms.Analysis(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]*MailTLSSession)
+	for record := range records {
+		if s, ok := record.(*MailTLSSession); ok {
+			if _, dup := got[s.PolicyDomain]; dup {
+				t.Errorf("second session of %s: %+v", s.PolicyDomain, s)
+			}
+			got[s.PolicyDomain] = s
+		}
+	}
+
+	want := map[string]string{
+		"example.com": TLSResultSuccess,
+		"example.net": TLSResultCertificateExpired,
+		"example.org": TLSResultStarttlsNotSupported,
+	}
+	if len(got) != len(want) {
+		t.Errorf("sessions %v, want %v", got, want)
+	}
+	for domain, result := range want {
+		s := got[domain]
+		if s == nil {
+			t.Errorf("no session of %s", domain)
+			continue
+		}
+		if s.Result != result {
+			t.Errorf("result of %s = %q, want %q", domain, s.Result, result)
+		}
+	}
+	if s := got["example.com"]; s != nil && (s.MxHost != "mx.example.com" || s.ReceivingIP != "192.0.2.1" || s.PostfixMessageID != "5A2") {
+		t.Errorf("session of example.com = %+v", s)
+	}
+}
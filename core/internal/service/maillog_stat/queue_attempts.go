@@ -0,0 +1,144 @@
+package maillog_stat
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Delivery attempt history of queued messages, built from the deferred records
+// of the mail log. The number of stored attempts per message is capped.
+// -----------------------------
+
+const maxAttemptsPerMessage = 50
+
+// Reply of the remote server, "said: 450 ..." or a reply with an enhanced status code
+var smtpReplyCodePattern = regexp.MustCompile(`said: ([245]\d{2})\b|\b([245]\d{2})[ -][245]\.\d{1,3}\.\d{1,3}\b`)
+
+// QueueAttempt one delivery attempt of a queued message
+type QueueAttempt struct {
+	Time  int64  `json:"time"`  // unix timestamp
+	Relay string `json:"relay"` // target MX, e.g. mx.example.com[192.0.2.1]:25
+	Code  int    `json:"code"`  // SMTP reply code, 0 when the attempt had no SMTP reply (e.g. connection timeout)
+	Dsn   string `json:"dsn"`
+	Error string `json:"error"` // response or error text
+}
+
+// QueueAttempts returns the delivery attempts of a queued message, oldest first
+func QueueAttempts(ctx context.Context, postfixMessageID string) ([]QueueAttempt, error) {
+	var rows []struct {
+		LogTime     int64  `json:"log_time"`
+		Relay       string `json:"relay"`
+		Dsn         string `json:"dsn"`
+		Description string `json:"description"`
+	}
+
+	err := g.DB().Model("mailstat_deferred_mails").
+		Fields("log_time, relay, dsn, description").
+		Where("postfix_message_id", postfixMessageID).
+		Order("log_time_millis asc, id asc").
+		Scan(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := make([]QueueAttempt, 0, len(rows))
+	for _, r := range rows {
+		attempts = append(attempts, QueueAttempt{
+			Time:  r.LogTime,
+			Relay: r.Relay,
+			Code:  smtpReplyCode(r.Description),
+			Dsn:   r.Dsn,
+			Error: r.Description,
+		})
+	}
+
+	return attempts, nil
+}
+
+// QueueAttemptSummary number of attempts and the last one of a message
+type QueueAttemptSummary struct {
+	Attempts int
+	Last     QueueAttempt
+}
+
+// LatestQueueAttempts returns the attempt summary of each given message, messages without attempts are absent
+func LatestQueueAttempts(ctx context.Context, postfixMessageIDs []string) (map[string]QueueAttemptSummary, error) {
+	summaries := make(map[string]QueueAttemptSummary)
+	if len(postfixMessageIDs) == 0 {
+		return summaries, nil
+	}
+
+	var rows []struct {
+		PostfixMessageId string `json:"postfix_message_id"`
+		Attempts         int    `json:"attempts"`
+		LogTime          int64  `json:"log_time"`
+		Relay            string `json:"relay"`
+		Dsn              string `json:"dsn"`
+		Description      string `json:"description"`
+	}
+
+	err := g.DB().GetScan(ctx, &rows, `
+		SELECT postfix_message_id, attempts, log_time, relay, dsn, description FROM (
+			SELECT postfix_message_id, log_time, relay, dsn, description,
+				COUNT(*) OVER (PARTITION BY postfix_message_id) AS attempts,
+				ROW_NUMBER() OVER (PARTITION BY postfix_message_id ORDER BY log_time_millis DESC, id DESC) AS rn
+			FROM mailstat_deferred_mails
+			WHERE postfix_message_id IN(?)
+		) t WHERE rn = 1`, postfixMessageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		summaries[r.PostfixMessageId] = QueueAttemptSummary{
+			Attempts: r.Attempts,
+			Last: QueueAttempt{
+				Time:  r.LogTime,
+				Relay: r.Relay,
+				Code:  smtpReplyCode(r.Description),
+				Dsn:   r.Dsn,
+				Error: r.Description,
+			},
+		}
+	}
+
+	return summaries, nil
+}
+
+// pruneQueueAttempts keeps the latest maxAttemptsPerMessage attempts of the given messages
+func pruneQueueAttempts(ctx context.Context, postfixMessageIDs []string) error {
+	if len(postfixMessageIDs) == 0 {
+		return nil
+	}
+
+	_, err := g.DB().Exec(ctx, `
+		DELETE FROM mailstat_deferred_mails WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY postfix_message_id ORDER BY log_time_millis DESC, id DESC) AS rn
+				FROM mailstat_deferred_mails
+				WHERE postfix_message_id IN(?)
+			) t WHERE rn > ?
+		)`, postfixMessageIDs, maxAttemptsPerMessage)
+
+	return err
+}
+
+// smtpReplyCode extracts the SMTP reply code of the remote server from a delivery description
+func smtpReplyCode(description string) int {
+	match := smtpReplyCodePattern.FindStringSubmatch(description)
+	if match == nil {
+		return 0
+	}
+
+	code := match[1]
+	if code == "" {
+		code = match[2]
+	}
+
+	n, _ := strconv.Atoi(code)
+	return n
+}
@@ -0,0 +1,3 @@
+package maillog_stat
+
+import _ "billionmail-core/internal/testlog"
@@ -0,0 +1,216 @@
+package maillog_stat
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Outbound TLS sessions for the SMTP TLS reports (RFC 8460). A session is the
+// deliveries of a postfix/smtp process over one connection: the recipients of a queue
+// ID and the later queue IDs reusing the connection (conn_use=). Its result is
+// "success" when TLS was negotiated and no TLS failure deferred or bounced it, else the
+// result-type of the failure. The log is read in reverse, so the deliveries of a
+// session are seen before its TLS lines and a session is complete when an earlier
+// delivery of the process does not belong to it. The sessions without TLS and without
+// a TLS failure, like plain deliveries to a host not offering STARTTLS, are not
+// recorded.
+
+// TLS-RPT result types of the failed sessions
+const (
+	TLSResultSuccess                 = "success"
+	TLSResultStarttlsNotSupported    = "starttls-not-supported"
+	TLSResultCertificateHostMismatch = "certificate-host-mismatch"
+	TLSResultCertificateExpired      = "certificate-expired"
+	TLSResultCertificateNotTrusted   = "certificate-not-trusted"
+	TLSResultValidationFailure       = "validation-failure"
+	TLSResultTLSAInvalid             = "tlsa-invalid"
+	TLSResultDNSSECInvalid           = "dnssec-invalid"
+)
+
+var (
+	smtpProcessPattern     = regexp.MustCompile(`postfix(?:/[^/\[\s]+)*/smtp\[(\d+)]:`)
+	tlsEstablishedPattern  = regexp.MustCompile(`(?:Verified|Trusted|Untrusted|Anonymous) TLS connection established to ([^\[\s]+)\[([^\]]+)]`)
+	tlsVerifyFailedPattern = regexp.MustCompile(`server certificate verification failed for ([^\[\s]+)\[([^\]]+)](?::\d+)?: num=\d+:(.+)$`)
+	tlsRelayPattern        = regexp.MustCompile(`^([^\[]+)\[([^\]]+)]`)
+)
+
+// MailTLSSession outbound session of a postfix/smtp process
+type MailTLSSession struct {
+	MailRecord
+	PolicyDomain string `json:"policy_domain"` // domain of the recipients
+	MxHost       string `json:"mx_host"`
+	ReceivingIP  string `json:"receiving_ip"`
+	Result       string `json:"result"` // "success" or the result-type of the failure
+	Detail       string `json:"detail"` // description of the failure
+
+	relay       string
+	queueID     string // queue ID of the earliest delivery seen
+	reused      bool   // the earliest delivery seen reused the connection
+	established bool   // TLS was negotiated
+}
+
+// tlsSessionTracker collects the sessions of the lines read in reverse
+type tlsSessionTracker struct {
+	pending map[string]*MailTLSSession // by pid of the smtp process
+	done    []*MailTLSSession
+}
+
+func newTLSSessionTracker() *tlsSessionTracker {
+	return &tlsSessionTracker{pending: make(map[string]*MailTLSSession)}
+}
+
+// observe follows a log line of a postfix/smtp process
+func (t *tlsSessionTracker) observe(ms *MaillogStat, line string, logTime int64) {
+	process := smtpProcessPattern.FindStringSubmatch(line)
+	if process == nil {
+		return
+	}
+	pid := process[1]
+
+	if strings.Contains(line, "to=<") && strings.Contains(line, "status=") {
+		t.delivery(ms, pid, line, logTime)
+		return
+	}
+
+	s := t.pending[pid]
+	if s == nil {
+		return
+	}
+
+	if m := tlsEstablishedPattern.FindStringSubmatch(line); m != nil {
+		if strings.EqualFold(m[1], s.MxHost) {
+			s.established = true
+		}
+		return
+	}
+
+	// The verification failure details a certificate failure of the delivery, with the
+	// opportunistic TLS of no policy an untrusted certificate is not a failure
+	if m := tlsVerifyFailedPattern.FindStringSubmatch(line); m != nil && strings.EqualFold(m[1], s.MxHost) {
+		if s.Result == TLSResultCertificateNotTrusted {
+			s.Result = certificateResult(m[3])
+			s.Detail = strings.TrimSpace(m[3])
+		}
+	}
+}
+
+// delivery follows a delivery line, completing the pending session of the process when
+// the delivery is not part of it
+func (t *tlsSessionTracker) delivery(ms *MaillogStat, pid, line string, logTime int64) {
+	queue := ms.messageIDPattern.FindStringSubmatch(line)
+	recipient := ms.recipientPattern.FindStringSubmatch(line)
+	relay := ms.relayPattern.FindStringSubmatch(line)
+	if queue == nil || recipient == nil || relay == nil {
+		return
+	}
+	reused := strings.Contains(line, "conn_use=")
+
+	s := t.pending[pid]
+	if s != nil && (s.established || s.relay != relay[1] || (s.queueID != queue[1] && !s.reused)) {
+		t.finish(s)
+		s = nil
+	}
+
+	result, detail := "", ""
+	if desc := ms.descriptionPattern.FindStringSubmatch(line); desc != nil {
+		result = tlsFailureResult(desc[1])
+		if result != "" {
+			detail = desc[1]
+		}
+	}
+
+	if s == nil {
+		s = &MailTLSSession{
+			MailRecord: MailRecord{
+				PostfixMessageID: queue[1],
+				LogTimeMillis:    logTime,
+			},
+			relay: relay[1],
+		}
+		if at := strings.LastIndex(recipient[1], "@"); at >= 0 {
+			s.PolicyDomain = strings.ToLower(recipient[1][at+1:])
+		}
+		if host := tlsRelayPattern.FindStringSubmatch(relay[1]); host != nil {
+			s.MxHost = strings.ToLower(host[1])
+			s.ReceivingIP = host[2]
+		}
+		t.pending[pid] = s
+	}
+
+	s.queueID = queue[1]
+	s.reused = reused
+	if s.Result == "" && result != "" {
+		s.Result = result
+		s.Detail = detail
+	}
+}
+
+// finish records a pending session when it is a TLS session
+func (t *tlsSessionTracker) finish(s *MailTLSSession) {
+	for pid, p := range t.pending {
+		if p == s {
+			delete(t.pending, pid)
+		}
+	}
+
+	if s.Result == "" {
+		if !s.established {
+			return
+		}
+		s.Result = TLSResultSuccess
+	}
+	if s.PolicyDomain == "" {
+		return
+	}
+	t.done = append(t.done, s)
+}
+
+// take returns the sessions completed since the last call
+func (t *tlsSessionTracker) take() []*MailTLSSession {
+	done := t.done
+	t.done = nil
+	return done
+}
+
+// flush completes the pending sessions at the end of the log
+func (t *tlsSessionTracker) flush() []*MailTLSSession {
+	for _, s := range t.pending {
+		t.finish(s)
+	}
+	return t.take()
+}
+
+// tlsFailureResult result-type of the TLS failure a delivery description reports, empty
+// when it reports none
+func tlsFailureResult(desc string) string {
+	d := strings.ToLower(desc)
+	switch {
+	case strings.Contains(d, "tls is required, but was not offered"),
+		strings.Contains(d, "tls is required, but our tls engine is unavailable"):
+		return TLSResultStarttlsNotSupported
+	case strings.Contains(d, "tlsa"):
+		return TLSResultTLSAInvalid
+	case strings.Contains(d, "dnssec"):
+		return TLSResultDNSSECInvalid
+	case strings.Contains(d, "server certificate not verified"),
+		strings.Contains(d, "server certificate not trusted"):
+		return TLSResultCertificateNotTrusted
+	case strings.Contains(d, "cannot start tls"),
+		strings.Contains(d, "tls handshake"),
+		strings.Contains(d, "lost connection after starttls"):
+		return TLSResultValidationFailure
+	}
+	return ""
+}
+
+// certificateResult result-type of a certificate verification failure reason
+func certificateResult(reason string) string {
+	r := strings.ToLower(reason)
+	switch {
+	case strings.Contains(r, "expired"), strings.Contains(r, "not yet valid"):
+		return TLSResultCertificateExpired
+	case strings.Contains(r, "hostname mismatch"), strings.Contains(r, "subject name"):
+		return TLSResultCertificateHostMismatch
+	}
+	return TLSResultCertificateNotTrusted
+}
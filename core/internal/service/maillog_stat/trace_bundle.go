@@ -0,0 +1,236 @@
+package maillog_stat
+
+import (
+	"archive/zip"
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/log_maintenance"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Support bundle of a message: everything known about it in one zip to attach to a
+// ticket. The trace id is its Message-ID or a postfix queue id, both lead to the other
+// through the mail statistics. The bundle holds every log line of the logs tree naming
+// one of the ids, the delivery records and attempts of its queue ids, and the
+// authentication results and spam score of the copies still queued. The lines are
+// streamed from the logs to the bundle, a long trace is never held in memory. A queue id
+// only found in the logs, e.g. of a message the statistics did not reach yet, applies
+// to the lines scanned after it.
+// -----------------------------
+
+// cleanupMessageIdPattern the line of postfix/cleanup binding a queue id to a Message-ID
+var cleanupMessageIdPattern = regexp.MustCompile(`\b([0-9A-Za-z]{5,32}): message-id=<([^>]+)>`)
+
+// TraceBundleManifest summary of a support bundle, trace.json in the bundle
+type TraceBundleManifest struct {
+	TraceID     string         `json:"trace_id"`
+	QueueIDs    []string       `json:"queue_ids"`
+	MessageIDs  []string       `json:"message_ids"`
+	GeneratedAt int64          `json:"generated_at"`
+	LogLines    int            `json:"log_lines"`
+	Sources     map[string]int `json:"sources"` // matching lines by log
+	Errors      []string       `json:"errors,omitempty"`
+}
+
+// TraceMessageAuth authentication results of a queued copy of the message
+type TraceMessageAuth struct {
+	QueueID   string              `json:"queue_id"`
+	Auth      inbound.MessageAuth `json:"auth"`
+	SpamScore *float64            `json:"spam_score"`
+}
+
+// traceIds the ids of a traced message, the lines naming any of them belong to it
+type traceIds struct {
+	queue   map[string]bool
+	message map[string]bool
+}
+
+func (t *traceIds) add(queueID, messageID string) {
+	if queueID != "" {
+		t.queue[queueID] = true
+	}
+	if messageID != "" {
+		t.message[messageID] = true
+	}
+}
+
+// matches reports whether the line names one of the ids, learning the queue id bound to
+// a known Message-ID on the way
+func (t *traceIds) matches(line string) bool {
+	if m := cleanupMessageIdPattern.FindStringSubmatch(line); m != nil && t.message[m[2]] {
+		t.queue[m[1]] = true
+		return true
+	}
+
+	for id := range t.message {
+		if strings.Contains(line, id) {
+			return true
+		}
+	}
+	for id := range t.queue {
+		if strings.Contains(line, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedIds(ids map[string]bool) []string {
+	list := make([]string, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// resolveTraceIds the queue ids and Message-IDs of a trace id, from the mail statistics
+func resolveTraceIds(ctx context.Context, traceID string) (*traceIds, error) {
+	ids := &traceIds{queue: make(map[string]bool), message: make(map[string]bool)}
+
+	var rows []struct {
+		PostfixMessageId string `json:"postfix_message_id"`
+		MessageId        string `json:"message_id"`
+	}
+	err := g.DB().Model("mailstat_message_ids").Ctx(ctx).
+		Fields("postfix_message_id, message_id").
+		Where("message_id = ? OR postfix_message_id = ?", traceID, traceID).
+		Scan(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		ids.add(r.PostfixMessageId, r.MessageId)
+	}
+	if len(rows) == 0 {
+		// Not in the statistics yet, the logs may still know it
+		if queueIdPattern.MatchString(traceID) {
+			ids.add(traceID, "")
+		} else {
+			ids.add("", traceID)
+		}
+	}
+
+	return ids, nil
+}
+
+// queueIdPattern a postfix queue id, short or long format
+var queueIdPattern = regexp.MustCompile(`^[0-9A-Za-z]{5,32}$`)
+
+// ExportTraceBundle writes the support bundle of the message traceID to w, a zip
+func ExportTraceBundle(ctx context.Context, traceID string, w io.Writer) error {
+	traceID = strings.Trim(strings.TrimSpace(traceID), "<>")
+	if traceID == "" {
+		return fmt.Errorf("empty trace id")
+	}
+
+	ids, err := resolveTraceIds(ctx, traceID)
+	if err != nil {
+		return err
+	}
+
+	manifest := TraceBundleManifest{
+		TraceID:     traceID,
+		GeneratedAt: time.Now().Unix(),
+		Sources:     make(map[string]int),
+	}
+
+	zw := zip.NewWriter(w)
+
+	// The log lines first, the queue ids they reveal complete the records
+	logs, err := zw.Create("logs.txt")
+	if err != nil {
+		return err
+	}
+	err = log_maintenance.ScanLogTree(ctx, func(source, line string) error {
+		if !ids.matches(line) {
+			return nil
+		}
+		manifest.LogLines++
+		manifest.Sources[source]++
+		_, err := io.WriteString(logs, source+": "+line+"\n")
+		return err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		// What was scanned is still worth sending
+		manifest.Errors = append(manifest.Errors, "logs: "+err.Error())
+	}
+
+	manifest.QueueIDs = sortedIds(ids.queue)
+	manifest.MessageIDs = sortedIds(ids.message)
+
+	records := []struct {
+		name  string
+		table string
+	}{
+		{"senders.json", "mailstat_senders"},
+		{"deliveries.json", "mailstat_send_mails"},
+		{"receptions.json", "mailstat_receive_mails"},
+	}
+	for _, rec := range records {
+		var rows []map[string]interface{}
+		if len(manifest.QueueIDs) > 0 {
+			result, err := g.DB().Model(rec.table).Ctx(ctx).WhereIn("postfix_message_id", manifest.QueueIDs).Order("log_time_millis asc").All()
+			if err != nil {
+				manifest.Errors = append(manifest.Errors, rec.table+": "+err.Error())
+			}
+			rows = result.List()
+		}
+		if err := writeBundleJSON(zw, rec.name, rows); err != nil {
+			return err
+		}
+	}
+
+	attempts := make(map[string][]QueueAttempt)
+	auths := make([]TraceMessageAuth, 0)
+	for _, id := range manifest.QueueIDs {
+		list, err := QueueAttempts(ctx, id)
+		if err != nil {
+			manifest.Errors = append(manifest.Errors, "attempts of "+id+": "+err.Error())
+		} else if len(list) > 0 {
+			attempts[id] = list
+		}
+
+		// Only the copies still queued can be inspected
+		if view, err := inbound.InspectMessage(ctx, id); err == nil {
+			auths = append(auths, TraceMessageAuth{QueueID: id, Auth: view.Auth, SpamScore: view.SpamScore})
+		}
+	}
+	if err := writeBundleJSON(zw, "queue_attempts.json", attempts); err != nil {
+		return err
+	}
+	if err := writeBundleJSON(zw, "auth.json", auths); err != nil {
+		return err
+	}
+
+	if err := writeBundleJSON(zw, "trace.json", manifest); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// writeBundleJSON adds an indented JSON file to the bundle
+func writeBundleJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
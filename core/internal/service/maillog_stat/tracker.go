@@ -24,6 +24,8 @@ func CampaignEventHandler(r *ghttp.Request, encStr string) {
 		Type       string `json:"type" v:"required|in:open,click"`
 		CampaignId int    `json:"campaign_id" v:"required|min:1"`
 		Url        string `json:"url" v:"url"`
+		Link       int    `json:"link"` // position of the clicked link in the message, from 1
+		Preview    bool   `json:"preview"`
 	}{}
 	err := Decrypt(encStr, &data)
 	if err != nil {
@@ -39,6 +41,16 @@ func CampaignEventHandler(r *ghttp.Request, encStr string) {
 		return
 	}
 
+	// Events of campaign previews are not recorded
+	if data.Preview {
+		if data.Type == "click" {
+			r.Response.RedirectTo(data.Url)
+			return
+		}
+		writeTrackingPixel(r)
+		return
+	}
+
 	curTimeMillis := time.Now().UnixMilli()
 
 	postfixMessageID, err := SearchPostfixMessageIdByMessageId(data.MessageId)
@@ -79,18 +91,7 @@ func CampaignEventHandler(r *ghttp.Request, encStr string) {
 		// Update contact activity when email is opened (user interaction)
 		contact_activity.UpdateActivityByEmailAndGroup(data.Recipient, groupId)
 
-		// Create a 1x1 transparent PNG image
-		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
-		// Set the pixel to transparent
-		img.Set(0, 0, color.Transparent)
-		r.Response.Header().Set("Content-Type", "image/png")
-
-		err = png.Encode(r.Response.BufferWriter, img)
-
-		if err != nil {
-			g.Log().Error(ctx, "Failed to encode PNG: ", err)
-		}
-
+		writeTrackingPixel(r)
 		return
 	case "click":
 		_, err = g.DB().Model("mailstat_clicked").Insert(g.Map{
@@ -99,6 +100,9 @@ func CampaignEventHandler(r *ghttp.Request, encStr string) {
 			"recipient":          data.Recipient,
 			"message_id":         data.MessageId,
 			"postfix_message_id": postfixMessageID,
+			"url":                data.Url,
+			"link_index":         data.Link,
+			"normalized_url":     NormalizeTrackedURL(data.Url),
 		})
 
 		if err != nil {
@@ -133,6 +137,18 @@ func CampaignEventHandler(r *ghttp.Request, encStr string) {
 	r.Response.Write("Success")
 }
 
+// writeTrackingPixel responds with a 1x1 transparent PNG image
+func writeTrackingPixel(r *ghttp.Request) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	// Set the pixel to transparent
+	img.Set(0, 0, color.Transparent)
+	r.Response.Header().Set("Content-Type", "image/png")
+
+	if err := png.Encode(r.Response.BufferWriter, img); err != nil {
+		g.Log().Error(r.GetCtx(), "Failed to encode PNG: ", err)
+	}
+}
+
 type MailTracker struct {
 	originalMailHTML string
 	modified         bool
@@ -142,6 +158,8 @@ type MailTracker struct {
 	recipient        string
 	baseURL          string
 	hrefPattern      *regexp.Regexp
+	preview          bool
+	links            int // tracked links so far
 }
 
 func NewMailTracker(mailHTML string, campaignID int, messageID, recipient, baseURL string) *MailTracker {
@@ -157,8 +175,14 @@ func NewMailTracker(mailHTML string, campaignID int, messageID, recipient, baseU
 	}
 }
 
+// SetPreview marks the tracking links as preview ones, their events are not recorded
+func (t *MailTracker) SetPreview(preview bool) {
+	t.preview = preview
+}
+
 // TrackLinks handles the tracking of links in the email HTML
 func (t *MailTracker) TrackLinks() {
+	t.links = 0
 	t.mailHTML = t.hrefPattern.ReplaceAllStringFunc(t.mailHTML, func(s string) string {
 		matches := t.hrefPattern.FindStringSubmatch(s)
 		if len(matches) < 2 {
@@ -171,13 +195,19 @@ func (t *MailTracker) TrackLinks() {
 			return s
 		}
 
-		return fmt.Sprintf(`href="%s"`, t.GetTrackingURL(matches[1]))
+		t.links++
+		return fmt.Sprintf(`href="%s"`, t.linkTrackingURL(matches[1], t.links))
 	})
 
 	t.modified = t.originalMailHTML != t.mailHTML
 }
 
 func (t *MailTracker) GetTrackingURL(url string) string {
+	return t.linkTrackingURL(url, 0)
+}
+
+// linkTrackingURL tracking URL of the link at a position of the message, 0 when unknown
+func (t *MailTracker) linkTrackingURL(url string, link int) string {
 	data := map[string]interface{}{
 		"type":        "click",
 		"campaign_id": t.campaignID,
@@ -185,6 +215,12 @@ func (t *MailTracker) GetTrackingURL(url string) string {
 		"message_id":  t.messageID,
 		"url":         url,
 	}
+	if link > 0 {
+		data["link"] = link
+	}
+	if t.preview {
+		data["preview"] = true
+	}
 
 	return fmt.Sprintf("%s/%s", t.baseURL, Encrypt(data))
 }
@@ -217,6 +253,9 @@ func (t *MailTracker) GetTrackingPixel() string {
 		"recipient":   t.recipient,
 		"message_id":  t.messageID,
 	}
+	if t.preview {
+		data["preview"] = true
+	}
 
 	return fmt.Sprintf("%s/%s", t.baseURL, Encrypt(data))
 }
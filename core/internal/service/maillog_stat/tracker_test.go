@@ -343,3 +343,46 @@ func TestMailTracker_AppendTrackingPixel(t *testing.T) {
 		})
 	}
 }
+
+func TestMailTracker_LinkPositions(t *testing.T) {
+	tracker := NewMailTracker(`<a href="https://example.com/a">A</a><a href="https://example.com/b">B</a><a href="https://example.com/a">A</a>`,
+		123, "abc123", "test@example.com", "https://track.example.com")
+	tracker.TrackLinks()
+
+	matches := tracker.hrefPattern.FindAllStringSubmatch(tracker.GetHTML(), -1)
+	if len(matches) != 3 {
+		t.Fatalf("expected 3 tracked links, got %d", len(matches))
+	}
+
+	for i, m := range matches {
+		data := struct {
+			Url  string `json:"url"`
+			Link int    `json:"link"`
+		}{}
+		if err := Decrypt(strings.TrimPrefix(m[1], "https://track.example.com/pmta/"), &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Link != i+1 {
+			t.Errorf("link %d recorded as position %d (%s)", i+1, data.Link, data.Url)
+		}
+	}
+}
+
+func TestNormalizeTrackedURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://Example.com", "https://example.com/"},
+		{"https://example.com:443/page?utm_source=mail&utm_campaign=x", "https://example.com/page"},
+		{"https://example.com/page?id=2&fbclid=abc&a=1#top", "https://example.com/page?a=1&id=2"},
+		{"http://example.com:8080/p?GCLID=1&q=go", "http://example.com:8080/p?q=go"},
+		{"mailto:user@example.com", "mailto:user@example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeTrackedURL(tt.raw); got != tt.want {
+			t.Errorf("NormalizeTrackedURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -750,6 +750,8 @@ func (m *ConfigManager) updateMasterCf(ctx context.Context, configs []map[string
 	}
 	contentStr := string(content)
 
+	heloNames := GetHeloNames(ctx)
+
 	var blockBuilder strings.Builder
 	blockBuilder.WriteString(masterCfBlockMarkerStart + "\n")
 
@@ -763,6 +765,9 @@ func (m *ConfigManager) updateMasterCf(ctx context.Context, configs []map[string
 
 		blockBuilder.WriteString(fmt.Sprintf("%-10s unix  -       -       n       -       -       smtp\n", serviceName))
 		blockBuilder.WriteString(fmt.Sprintf("    -o smtp_bind_address=%s\n", postfixIP))
+		if heloName := heloNameOf(config, heloNames); heloName != "" {
+			blockBuilder.WriteString(fmt.Sprintf("    -o smtp_helo_name=%s\n", heloName))
+		}
 
 		//g.Log().Debugf(ctx, "Generating Postfix service %s for domain %s (ID=%d), binding IP: %s",
 		//	domain, config["id"], serviceName, postfixIP)
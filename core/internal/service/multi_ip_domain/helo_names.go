@@ -0,0 +1,144 @@
+package multi_ip_domain
+
+import (
+	"billionmail-core/internal/service/dns_resolver"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// HELO names of the outbound IPs: the strict receivers check that the name a client
+// greets with resolves to the IP it connects from, and that the IP resolves back to the
+// name (FCrDNS). Each IP of the pool has its own reverse DNS, so it may be given its own
+// HELO name. The pool IPs are the master.cf transports bound to them, the name of an IP
+// is set with -o smtp_helo_name on each of its transports, an IP without one greets
+// with myhostname. The outbound connections have no banner of their own: the banner is
+// the greeting of the sessions received, which reach the address of the server, not
+// the pool.
+
+const heloNamesOptionKey = "multi_ip_helo_names"
+
+// GetHeloNames the HELO names of the outbound IPs, by IP
+func GetHeloNames(ctx context.Context) map[string]string {
+	names := map[string]string{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, heloNamesOptionKey, &names)
+	return names
+}
+
+// SetHeloName validates and saves the HELO name of an outbound IP, an empty name
+// removes it. It is applied to Postfix with the configurations of the pool
+func SetHeloName(ctx context.Context, outboundIP, heloName string) error {
+	outboundIP = strings.TrimSpace(outboundIP)
+	heloName = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(heloName), "."))
+
+	if !isValidIP(outboundIP) {
+		return gerror.New("invalid IP address format")
+	}
+	if heloName != "" && (!public.IsValidHostname(heloName) || !strings.Contains(heloName, ".")) {
+		return gerror.Newf("invalid HELO name %s, expected a fully qualified host name", heloName)
+	}
+
+	names := GetHeloNames(ctx)
+	if heloName == "" {
+		delete(names, outboundIP)
+	} else {
+		names[outboundIP] = heloName
+	}
+	if err := public.OptionsMgrInstance.SetOption(ctx, heloNamesOptionKey, names); err != nil {
+		return err
+	}
+
+	// the transports of the IP are written again by the next apply
+	_, err := g.DB().Model("bm_multi_ip_domain").Ctx(ctx).
+		Where("outbound_ip", outboundIP).
+		Where("active", 1).
+		Data(g.Map{"status": "pending"}).
+		Update()
+	return err
+}
+
+// heloNameOf the HELO name of the transport of a configuration, empty for myhostname
+func heloNameOf(config map[string]interface{}, names map[string]string) string {
+	return names[gconv.String(config["outbound_ip"])]
+}
+
+// checkHeloName the mismatch of a HELO name with the DNS of its IP, empty when the
+// name resolves to the IP and the IP back to the name
+func checkHeloName(heloName, outboundIP string, ptrNames []string, forwardIPs []net.IP) string {
+	reverse := false
+	for i, name := range ptrNames {
+		ptrNames[i] = strings.TrimSuffix(name, ".")
+		if strings.EqualFold(ptrNames[i], heloName) {
+			reverse = true
+		}
+	}
+
+	forward := false
+	for _, ip := range forwardIPs {
+		if ip.String() == outboundIP {
+			forward = true
+			break
+		}
+	}
+
+	switch {
+	case !reverse && len(ptrNames) == 0:
+		return fmt.Sprintf("%s has no reverse DNS, expected %s", outboundIP, heloName)
+	case !reverse:
+		return fmt.Sprintf("the reverse DNS of %s is %s, not its HELO name %s", outboundIP, strings.Join(ptrNames, ", "), heloName)
+	case !forward:
+		return fmt.Sprintf("the HELO name %s of %s does not resolve to it", heloName, outboundIP)
+	}
+	return ""
+}
+
+// ValidateHeloNames checks the HELO names of the pool IPs against their DNS, logs and
+// returns the mismatches. Nothing is changed, the receivers decide what a mismatch costs
+func ValidateHeloNames(ctx context.Context) []string {
+	names := GetHeloNames(ctx)
+
+	ips, err := g.DB().Model("bm_multi_ip_domain").Ctx(ctx).
+		Where("active", 1).
+		Distinct().
+		Array("outbound_ip")
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to list the outbound IPs to check their HELO names: %v", err)
+		return nil
+	}
+
+	var warnings []string
+	pool := make(map[string]bool, len(ips))
+	for _, v := range ips {
+		ip := v.String()
+		pool[ip] = true
+		heloName := names[ip]
+		if heloName == "" {
+			continue
+		}
+
+		ptrNames, _ := dns_resolver.Default().LookupAddr(ctx, ip)
+		forwardIPs, _ := dns_resolver.Default().LookupIP(ctx, heloName)
+		if problem := checkHeloName(heloName, ip, ptrNames, forwardIPs); problem != "" {
+			warnings = append(warnings, problem)
+		}
+	}
+
+	for ip, heloName := range names {
+		if !pool[ip] {
+			warnings = append(warnings, fmt.Sprintf("HELO name %s is set for %s, which is not in the outbound IP pool", heloName, ip))
+		}
+	}
+
+	sort.Strings(warnings)
+	for _, warning := range warnings {
+		g.Log().Warningf(ctx, "Outbound HELO name mismatch: %s", warning)
+	}
+	return warnings
+}
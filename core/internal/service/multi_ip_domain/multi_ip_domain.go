@@ -2,6 +2,7 @@ package multi_ip_domain
 
 import (
 	v1 "billionmail-core/api/domains/v1"
+	"billionmail-core/internal/service/dns_resolver"
 	docker "billionmail-core/internal/service/dockerapi"
 	"billionmail-core/internal/service/public"
 	"context"
@@ -10,7 +11,6 @@ import (
 	"github.com/gogf/gf/v2/errors/gerror"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/util/gconv"
-	"net"
 	"regexp"
 	"sort"
 	"strconv"
@@ -34,6 +34,11 @@ func (s *MultiIPDomainService) GetConfigs(ctx context.Context) ([]v1.MultiIPDoma
 		Where("active", 1).
 		OrderAsc("id").
 		Scan(&dbConfigs)
+
+	heloNames := GetHeloNames(ctx)
+	for i := range dbConfigs {
+		dbConfigs[i].HeloName = heloNames[dbConfigs[i].OutboundIP]
+	}
 	return dbConfigs, err
 }
 
@@ -195,7 +200,7 @@ func (s *MultiIPDomainService) ValidateDNSRecords(ctx context.Context, domain, o
 	formattedDomain := public.FormatMX(domain)
 
 	// 1. Validate A record (required)
-	ips, err := net.LookupIP(formattedDomain)
+	ips, err := dns_resolver.Default().LookupIP(ctx, formattedDomain)
 	if err != nil {
 		return false, gerror.Newf("failed to query A record for domain '%s': %v. Please ensure the domain is correctly resolved.", domain, err)
 	}
@@ -212,7 +217,7 @@ func (s *MultiIPDomainService) ValidateDNSRecords(ctx context.Context, domain, o
 	}
 
 	// 2. Validate SPF record (recommended)
-	txtRecords, err := net.LookupTXT(domain)
+	txtRecords, err := dns_resolver.Default().LookupTXT(ctx, domain)
 	if err != nil {
 		return true, gerror.Newf("failed to query TXT record for domain '%s': %v", domain, err)
 	}
@@ -0,0 +1,49 @@
+package ops_digest
+
+import (
+	"billionmail-core/internal/model/entity"
+	"billionmail-core/internal/service/batch_mail"
+	"billionmail-core/internal/service/mail_service"
+	"context"
+	"fmt"
+	"html"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// InstallCircuitBreakerAlert sends an alert to the digest recipients when the campaign
+// circuit breaker pauses a campaign
+func InstallCircuitBreakerAlert() {
+	batch_mail.OnCircuitBreak(sendCircuitBreakerAlert)
+}
+
+func sendCircuitBreakerAlert(ctx context.Context, task *entity.EmailTask, reason string) {
+	cfg := GetConfig(ctx)
+	if len(cfg.Recipients) == 0 {
+		return
+	}
+
+	fromAddress := fmt.Sprintf("noreply@%s", defaultSendDomain())
+
+	sender, err := mail_service.NewEmailSenderWithLocal(fromAddress)
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to send the circuit breaker alert: %v", err)
+		return
+	}
+	defer sender.Close()
+
+	subject := fmt.Sprintf("[Operations Alert] Campaign %s paused", task.TaskName)
+	body := fmt.Sprintf("<h2>Campaign paused by the circuit breaker</h2>"+
+		"<p>The campaign <b>%s</b> (task %d, subject <i>%s</i>, sent by %s) was paused: %s.</p>"+
+		"<p>Check the recipient list before resuming the campaign, mailbox providers throttle or block senders with high bounce or complaint rates.</p>",
+		html.EscapeString(task.TaskName), task.Id, html.EscapeString(task.Subject), html.EscapeString(task.Addresser), html.EscapeString(reason))
+
+	for _, recipient := range cfg.Recipients {
+		msg := mail_service.NewMessage(subject, body)
+		msg.SetRealName("Operations Digest")
+
+		if err := sender.Send(msg, []string{recipient}); err != nil {
+			g.Log().Errorf(ctx, "Failed to send the circuit breaker alert to %s: %v", recipient, err)
+		}
+	}
+}
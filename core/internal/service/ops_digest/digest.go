@@ -0,0 +1,393 @@
+package ops_digest
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/domains"
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/mail_boxes"
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"billionmail-core/internal/service/smtp_policy"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Periodic operations digest for operators who do not watch the dashboards:
+// recent log maintenance runs, postfix queue depth, suppression list growth and
+// certificates close to expiry, sent to the configured admin addresses.
+// -----------------------------
+
+const (
+	configOptionKey   = "operations_digest_config"
+	lastSentOptionKey = "operations_digest_last_sent"
+
+	defaultIntervalHours   = 24 * 7
+	defaultMaintenanceRuns = 7
+	certExpiryWarnDays     = 30
+)
+
+// Config recipients and cadence of the digest
+type Config struct {
+	Enabled         bool     `json:"enabled"`
+	Recipients      []string `json:"recipients"`
+	IntervalHours   int      `json:"interval_hours"`   // time between two digests
+	MaintenanceRuns int      `json:"maintenance_runs"` // number of recent maintenance runs listed
+}
+
+// GetConfig returns the stored configuration, missing values use the defaults
+func GetConfig(ctx context.Context) Config {
+	cfg := Config{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, configOptionKey, &cfg)
+
+	if cfg.IntervalHours <= 0 {
+		cfg.IntervalHours = defaultIntervalHours
+	}
+	if cfg.MaintenanceRuns <= 0 {
+		cfg.MaintenanceRuns = defaultMaintenanceRuns
+	}
+
+	return cfg
+}
+
+// SetConfig stores the configuration
+func SetConfig(ctx context.Context, cfg Config) error {
+	for _, r := range cfg.Recipients {
+		if !strings.Contains(r, "@") {
+			return fmt.Errorf("invalid recipient email format: %s", r)
+		}
+	}
+
+	if cfg.Enabled && len(cfg.Recipients) == 0 {
+		return fmt.Errorf("at least one recipient is required")
+	}
+
+	if cfg.IntervalHours < 0 || cfg.MaintenanceRuns < 0 {
+		return fmt.Errorf("interval and maintenance runs must not be negative")
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, configOptionKey, cfg)
+}
+
+// Digest collected content of one digest
+type Digest struct {
+	Since        time.Time
+	Maintenance  []log_maintenance.MaintenanceResult
+	Queue        QueueStats
+	QueueErr     string
+	Backpressure smtp_policy.BackpressureState
+	Suppressed   int // recipients currently suppressed
+	NewSuppress  int // recipients suppressed since the previous digest
+	Certificates []CertExpiry
+
+	SendingDisabled []string // mailboxes whose sending is disabled
+}
+
+// QueueStats number of messages per postfix queue
+type QueueStats struct {
+	Total    int            `json:"total"`
+	ByQueue  map[string]int `json:"by_queue"`
+	Deferred int            `json:"deferred"`
+}
+
+// CertExpiry certificate expiring within certExpiryWarnDays
+type CertExpiry struct {
+	Name     string
+	Expires  time.Time
+	DaysLeft int
+}
+
+// CheckOperationsDigest sends the digest when it is enabled and due, called by the scheduler
+func CheckOperationsDigest(ctx context.Context) {
+	cfg := GetConfig(ctx)
+	if !cfg.Enabled || len(cfg.Recipients) == 0 {
+		return
+	}
+
+	var lastSent int64
+	_ = public.OptionsMgrInstance.GetOption(ctx, lastSentOptionKey, &lastSent)
+
+	if time.Since(time.Unix(lastSent, 0)) < time.Duration(cfg.IntervalHours)*time.Hour {
+		return
+	}
+
+	if err := SendOperationsDigest(ctx); err != nil {
+		g.Log().Warning(ctx, "Send operations digest failed: ", err)
+	}
+}
+
+// SendOperationsDigest composes the digest and sends it to the configured recipients
+func SendOperationsDigest(ctx context.Context) error {
+	cfg := GetConfig(ctx)
+	if len(cfg.Recipients) == 0 {
+		return fmt.Errorf("no digest recipients configured")
+	}
+
+	var lastSent int64
+	_ = public.OptionsMgrInstance.GetOption(ctx, lastSentOptionKey, &lastSent)
+
+	since := time.Unix(lastSent, 0)
+	if lastSent == 0 {
+		since = time.Now().Add(-time.Duration(cfg.IntervalHours) * time.Hour)
+	}
+
+	digest := Collect(ctx, since, cfg.MaintenanceRuns)
+
+	fromAddress := fmt.Sprintf("noreply@%s", defaultSendDomain())
+
+	sender, err := mail_service.NewEmailSenderWithLocal(fromAddress)
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+
+	subject := fmt.Sprintf("[Operations Digest] %s", time.Now().Format("2006-01-02"))
+	if digest.hasWarnings() {
+		subject += " - attention needed"
+	}
+
+	var failed []string
+	for _, recipient := range cfg.Recipients {
+		msg := mail_service.NewMessage(subject, digest.HTML())
+		msg.SetRealName("Operations Digest")
+
+		if err := sender.Send(msg, []string{recipient}); err != nil {
+			g.Log().Errorf(ctx, "Failed to send operations digest to %s: %v", recipient, err)
+			failed = append(failed, recipient)
+		}
+	}
+
+	if len(failed) == len(cfg.Recipients) {
+		return fmt.Errorf("failed to send the digest to %v", failed)
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, lastSentOptionKey, time.Now().Unix())
+}
+
+// Collect gathers the digest content, sections that cannot be read are left empty
+func Collect(ctx context.Context, since time.Time, maintenanceRuns int) Digest {
+	d := Digest{Since: since}
+
+	d.Maintenance = log_maintenance.MaintenanceHistory(ctx)
+	if len(d.Maintenance) > maintenanceRuns {
+		d.Maintenance = d.Maintenance[:maintenanceRuns]
+	}
+
+	if q, err := GetQueueStats(ctx); err == nil {
+		d.Queue = q
+	} else {
+		d.QueueErr = err.Error()
+	}
+
+	d.Backpressure = smtp_policy.GetBackpressureState(ctx)
+
+	d.Suppressed, _ = g.DB().Model("abnormal_recipient").Where("count >= ?", 3).Count()
+	d.NewSuppress, _ = g.DB().Model("abnormal_recipient").Where("count >= ?", 3).Where("create_time >= ?", since.Unix()).Count()
+
+	d.Certificates = expiringCertificates(ctx)
+
+	d.SendingDisabled, _ = mail_boxes.SendingDisabledMailboxes(ctx)
+
+	return d
+}
+
+// GetQueueStats counts the postfix queue by queue name
+func GetQueueStats(ctx context.Context) (QueueStats, error) {
+	stats := QueueStats{ByQueue: make(map[string]int)}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return stats, err
+	}
+	defer dk.Close()
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postqueue", "-j"}, "root")
+	if err != nil {
+		return stats, err
+	}
+
+	if res.ExitCode != 0 {
+		return stats, fmt.Errorf("postqueue failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	// One JSON object per queued message
+	for _, line := range strings.Split(res.Output, "\n") {
+		var item struct {
+			QueueName string `json:"queue_name"`
+		}
+
+		if json.Unmarshal([]byte(strings.TrimSpace(line)), &item) != nil || item.QueueName == "" {
+			continue
+		}
+
+		stats.Total++
+		stats.ByQueue[item.QueueName]++
+	}
+
+	stats.Deferred = stats.ByQueue["deferred"]
+
+	return stats, nil
+}
+
+// expiringCertificates console and domain certificates expiring soon
+func expiringCertificates(ctx context.Context) []CertExpiry {
+	list := make([]CertExpiry, 0)
+	now := time.Now()
+
+	add := func(name string, endtime int64) {
+		if endtime <= 0 {
+			return
+		}
+		expires := time.Unix(endtime, 0)
+		if days := int(expires.Sub(now).Hours() / 24); days <= certExpiryWarnDays {
+			list = append(list, CertExpiry{Name: name, Expires: expires, DaysLeft: days})
+		}
+	}
+
+	if info, err := domains.GetConsoleSSLInfo(); err == nil {
+		add("Console", int64(info.Endtime))
+	}
+
+	rows, err := g.DB().Model("domain").Fields("domain").All()
+	if err == nil {
+		crt := mail_service.NewCertificate()
+		defer crt.Close()
+
+		for _, row := range rows {
+			domain := public.FormatMX(row["domain"].String())
+			if info, err := crt.GetSSLInfo(domain); err == nil {
+				add(domain, int64(info.Endtime))
+			}
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Expires.Before(list[j].Expires)
+	})
+
+	return list
+}
+
+func defaultSendDomain() string {
+	val, err := g.DB().Model("bm_options").Where("name", "default_sender_domain").Value("value")
+	if err == nil && val != nil && val.String() != "" {
+		return val.String()
+	}
+
+	return public.MustGetDockerEnv("BILLIONMAIL_HOSTNAME", "localhost")
+}
+
+// hasWarnings reports whether something in the digest needs the operator
+func (d Digest) hasWarnings() bool {
+	if d.QueueErr != "" || d.Queue.Deferred > 0 || d.Backpressure.Engaged || len(d.Certificates) > 0 || len(d.SendingDisabled) > 0 {
+		return true
+	}
+
+	for _, r := range d.Maintenance {
+		if r.Errors > 0 || r.Partial {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HTML renders the digest as the email body
+func (d Digest) HTML() string {
+	b := &strings.Builder{}
+
+	b.WriteString(`<html><body style="font-family: 'Segoe UI', Tahoma, sans-serif; color: #1f2937;">`)
+	fmt.Fprintf(b, "<h2>Operations digest</h2><p>Period since %s</p>", d.Since.Format("2006-01-02 15:04"))
+
+	// Maintenance
+	b.WriteString("<h3>Log maintenance</h3>")
+	if len(d.Maintenance) == 0 {
+		b.WriteString("<p>No maintenance runs recorded.</p>")
+	} else {
+		b.WriteString(`<table border="1" cellpadding="4" cellspacing="0"><tr><th>Started</th><th>Duration</th><th>Files</th><th>Reclaimed</th><th>Errors</th><th>Status</th></tr>`)
+		for _, r := range d.Maintenance {
+			status := "complete"
+			if r.Partial {
+				status = "partial"
+			}
+			fmt.Fprintf(b, "<tr><td>%s</td><td>%s</td><td>%d</td><td>%s</td><td>%d</td><td>%s</td></tr>",
+				r.StartedAt.Format("2006-01-02 15:04"), r.Duration.Round(time.Second), r.FilesDone, formatSize(r.BytesReclaimed), r.Errors, status)
+		}
+		b.WriteString("</table>")
+	}
+
+	// Queue
+	b.WriteString("<h3>Mail queue</h3>")
+	if d.QueueErr != "" {
+		fmt.Fprintf(b, "<p>Queue status unavailable: %s</p>", html.EscapeString(d.QueueErr))
+	} else {
+		fmt.Fprintf(b, "<p>%d messages queued, %d deferred", d.Queue.Total, d.Queue.Deferred)
+		names := make([]string, 0, len(d.Queue.ByQueue))
+		for name := range d.Queue.ByQueue {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(b, ", %s: %d", html.EscapeString(name), d.Queue.ByQueue[name])
+		}
+		b.WriteString("</p>")
+	}
+	if bp := d.Backpressure; bp.Engaged {
+		fmt.Fprintf(b, "<p><b>Submission backpressure engaged</b> since %s, submissions are deferred until the queue drains to %d messages. %d recipients deferred.</p>",
+			time.Unix(bp.EngagedSince, 0).Format("2006-01-02 15:04"), bp.ResumeQueueDepth, bp.Deferred)
+	} else if bp.MaxQueueDepth > 0 && bp.Deferred > 0 {
+		fmt.Fprintf(b, "<p>Submission backpressure released, %d recipients deferred since the service started.</p>", bp.Deferred)
+	}
+
+	// Suppression
+	b.WriteString("<h3>Suppression list</h3>")
+	fmt.Fprintf(b, "<p>%d recipients suppressed, %d added in this period</p>", d.Suppressed, d.NewSuppress)
+
+	// Certificates
+	b.WriteString("<h3>Certificates</h3>")
+	if len(d.Certificates) == 0 {
+		fmt.Fprintf(b, "<p>No certificate expires within %d days.</p>", certExpiryWarnDays)
+	} else {
+		b.WriteString("<ul>")
+		for _, c := range d.Certificates {
+			fmt.Fprintf(b, "<li>%s expires %s (%d days left)</li>", html.EscapeString(c.Name), c.Expires.Format("2006-01-02"), c.DaysLeft)
+		}
+		b.WriteString("</ul>")
+	}
+
+	// Mailboxes with sending disabled, left over from an incident otherwise
+	if len(d.SendingDisabled) > 0 {
+		b.WriteString("<h3>Sending disabled</h3><ul>")
+		for _, user := range d.SendingDisabled {
+			fmt.Fprintf(b, "<li>%s</li>", html.EscapeString(user))
+		}
+		b.WriteString("</ul>")
+	}
+
+	b.WriteString(`<p style="color: #9ca3af; font-size: 12px;">This is an automated notification.</p></body></html>`)
+
+	return b.String()
+}
+
+func formatSize(v int64) string {
+	if v < 1024 {
+		return fmt.Sprintf("%dB", v)
+	}
+	kb := float64(v) / 1024
+	if kb < 1024 {
+		return fmt.Sprintf("%.2fKB", kb)
+	}
+	mb := kb / 1024
+	if mb < 1024 {
+		return fmt.Sprintf("%.2fMB", mb)
+	}
+	return fmt.Sprintf("%.2fGB", mb/1024)
+}
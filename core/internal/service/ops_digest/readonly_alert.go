@@ -0,0 +1,48 @@
+package ops_digest
+
+import (
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/mail_service"
+	"context"
+	"fmt"
+	"html"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// InstallReadOnlyAlert sends an alert to the digest recipients when the log maintenance
+// finds the log volume read-only, without waiting for the next digest
+func InstallReadOnlyAlert() {
+	log_maintenance.OnReadOnlyFilesystem(sendReadOnlyAlert)
+}
+
+func sendReadOnlyAlert(ctx context.Context, path string, cause error) {
+	cfg := GetConfig(ctx)
+	if len(cfg.Recipients) == 0 {
+		return
+	}
+
+	fromAddress := fmt.Sprintf("noreply@%s", defaultSendDomain())
+
+	sender, err := mail_service.NewEmailSenderWithLocal(fromAddress)
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to send the read-only log volume alert: %v", err)
+		return
+	}
+	defer sender.Close()
+
+	subject := fmt.Sprintf("[Operations Alert] Log volume %s is read-only", path)
+	body := fmt.Sprintf("<h2>Log volume is read-only</h2>"+
+		"<p>The log maintenance could not write to <code>%s</code>: %s</p>"+
+		"<p>No log was compressed nor deleted. This usually follows a disk error, check the disk and remount the volume read-write.</p>",
+		html.EscapeString(path), html.EscapeString(cause.Error()))
+
+	for _, recipient := range cfg.Recipients {
+		msg := mail_service.NewMessage(subject, body)
+		msg.SetRealName("Operations Digest")
+
+		if err := sender.Send(msg, []string{recipient}); err != nil {
+			g.Log().Errorf(ctx, "Failed to send the read-only log volume alert to %s: %v", recipient, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ops_digest
+
+import (
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/mail_service"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// InstallStaleMaintenanceAlert sends an alert to the digest recipients when the watchdog
+// finds that no scheduled log maintenance succeeded in time
+func InstallStaleMaintenanceAlert() {
+	log_maintenance.OnStaleMaintenance(sendStaleMaintenanceAlert)
+}
+
+func sendStaleMaintenanceAlert(ctx context.Context, health log_maintenance.MaintenanceHealth) {
+	cfg := GetConfig(ctx)
+	if len(cfg.Recipients) == 0 {
+		return
+	}
+
+	fromAddress := fmt.Sprintf("noreply@%s", defaultSendDomain())
+
+	sender, err := mail_service.NewEmailSenderWithLocal(fromAddress)
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to send the stale log maintenance alert: %v", err)
+		return
+	}
+	defer sender.Close()
+
+	lastSuccess := "never since the server started"
+	if !health.LastSuccess.IsZero() {
+		lastSuccess = health.LastSuccess.Format("2006-01-02 15:04:05")
+	}
+
+	cause := "No run was started, the scheduler may have stopped. Restart the core service."
+	if health.Running {
+		cause = fmt.Sprintf("The run started at %s has not completed, it is most likely hung on the log volume. Check the disk, then restart the core service.",
+			health.LastStarted.Format("2006-01-02 15:04:05"))
+	}
+
+	subject := "[Operations Alert] Log maintenance stopped running"
+	body := fmt.Sprintf("<h2>Log maintenance stopped running</h2>"+
+		"<p>No scheduled log maintenance succeeded before %s, the last success was %s. The logs are neither compressed nor deleted and keep filling the disk.</p>"+
+		"<p>%s</p>",
+		health.Deadline.Format("2006-01-02 15:04:05"), lastSuccess, cause)
+
+	for _, recipient := range cfg.Recipients {
+		msg := mail_service.NewMessage(subject, body)
+		msg.SetRealName("Operations Digest")
+
+		if err := sender.Send(msg, []string{recipient}); err != nil {
+			g.Log().Errorf(ctx, "Failed to send the stale log maintenance alert to %s: %v", recipient, err)
+		}
+	}
+}
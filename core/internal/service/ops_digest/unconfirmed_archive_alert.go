@@ -0,0 +1,48 @@
+package ops_digest
+
+import (
+	"billionmail-core/internal/service/log_maintenance"
+	"billionmail-core/internal/service/mail_service"
+	"context"
+	"fmt"
+	"html"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// InstallUnconfirmedArchiveAlert sends an alert to the digest recipients when the log
+// maintenance cannot confirm an archive in its sink and keeps the source log
+func InstallUnconfirmedArchiveAlert() {
+	log_maintenance.OnUnconfirmedArchive(sendUnconfirmedArchiveAlert)
+}
+
+func sendUnconfirmedArchiveAlert(ctx context.Context, name string, cause error) {
+	cfg := GetConfig(ctx)
+	if len(cfg.Recipients) == 0 {
+		return
+	}
+
+	fromAddress := fmt.Sprintf("noreply@%s", defaultSendDomain())
+
+	sender, err := mail_service.NewEmailSenderWithLocal(fromAddress)
+	if err != nil {
+		g.Log().Errorf(ctx, "Failed to send the unconfirmed archive alert: %v", err)
+		return
+	}
+	defer sender.Close()
+
+	subject := fmt.Sprintf("[Operations Alert] Log archive %s not confirmed", name)
+	body := fmt.Sprintf("<h2>Log archive not confirmed</h2>"+
+		"<p>The log maintenance stored <code>%s</code> but could not read it back from the archive destination: %s</p>"+
+		"<p>The source log was kept and is archived again by the next run. Check that the archive destination is reachable and complete.</p>",
+		html.EscapeString(name), html.EscapeString(cause.Error()))
+
+	for _, recipient := range cfg.Recipients {
+		msg := mail_service.NewMessage(subject, body)
+		msg.SetRealName("Operations Digest")
+
+		if err := sender.Send(msg, []string{recipient}); err != nil {
+			g.Log().Errorf(ctx, "Failed to send the unconfirmed archive alert to %s: %v", recipient, err)
+		}
+	}
+}
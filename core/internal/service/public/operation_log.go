@@ -8,18 +8,57 @@ import (
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gfile"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
 const snapshotBase = "../logs/core/operation_log"
 
+// operationLogTimezoneKey configuration key of the time zone of the operation log days
+const operationLogTimezoneKey = "server.operationLogTimezone"
+
+var (
+	operationLogLocationOnce sync.Once
+	operationLogLocation     *time.Location
+)
+
+// OperationLogLocation time zone in which the day directories of the operation logs are
+// named, server.operationLogTimezone of the configuration or the local time zone. The
+// log maintenance dates the directories in the same zone, so none is archived a day
+// early or late
+func OperationLogLocation() *time.Location {
+	operationLogLocationOnce.Do(func() {
+		operationLogLocation = time.Local
+
+		v, err := g.Cfg().Get(context.Background(), operationLogTimezoneKey)
+		if err != nil || v == nil || v.String() == "" {
+			return
+		}
+
+		loc, err := time.LoadLocation(v.String())
+		if err != nil {
+			g.Log().Warningf(context.Background(), "Invalid %s %q, using the local time zone: %v", operationLogTimezoneKey, v.String(), err)
+			return
+		}
+		operationLogLocation = loc
+	})
+
+	return operationLogLocation
+}
+
+// OperationLogDay name of the day directory of the operation logs written at t
+func OperationLogDay(t time.Time) string {
+	return t.In(OperationLogLocation()).Format("2006-01-02")
+}
+
 func writeSnapshotFile(logType string, userID int64, data interface{}) (string, error) {
-	dateDir := time.Now().Format("2006-01-02")
-	dir := filepath.Join(AbsPath(snapshotBase), dateDir)
+	// A single instant, a write at midnight lands in the day of its file name
+	now := time.Now().In(OperationLogLocation())
+	dir := filepath.Join(AbsPath(snapshotBase), OperationLogDay(now))
 	if err := gfile.Mkdir(dir); err != nil {
 		return "", err
 	}
-	ts := time.Now().Format("20060102_150405")
+	ts := now.Format("20060102_150405")
 	filename := fmt.Sprintf("%s_%d_%s_%s.json", logType, userID, ts, RandomStr(5))
 	path := filepath.Join(dir, filename)
 	content, _ := json.MarshalIndent(data, "", "  ")
@@ -194,11 +194,17 @@ func (s *JWTService) JWTAuthMiddleware(r *ghttp.Request) {
 		r.URL.Path == "/api/get_validate_code" ||
 		r.URL.Path == "/api/unsubscribe" ||
 		r.URL.Path == "/api/unsubscribe_new" ||
+		r.URL.Path == "/api/preferences" ||
+		r.URL.Path == "/api/preferences/update" ||
 		r.URL.Path == "/api/languages/set" ||
 		r.URL.Path == "/api/languages/get" ||
 		r.URL.Path == "/api/batch_mail/api/send" ||
 		r.URL.Path == "/api/batch_mail/api/batch_send" ||
+		r.URL.Path == "/api/batch_mail/api/personalized_send" ||
+		r.URL.Path == "/api/batch_mail/api/transactional_send" ||
 		r.URL.Path == "/api/subscribe/submit" ||
+		r.URL.Path == "/api/abnormal_recipient/bounce_webhook" ||
+		r.URL.Path == "/api/abnormal_recipient/suppression_webhook" ||
 		r.URL.Path == "/api/subscribe/confirm" {
 		r.Middleware.Next()
 		return
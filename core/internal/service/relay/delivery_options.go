@@ -0,0 +1,410 @@
+package relay
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+)
+
+// -----------------------------
+// Delivery options of the recipient domains, for the receivers needing longer timeouts
+// or TLS quirks, e.g. TLS 1.0 for a legacy system or no certificate check for an
+// internal relay. The default options apply to every domain, those of a domain override
+// them field by field, a zero field inherits. Postfix does the delivery: the default
+// options are rendered to main.cf, the timeouts of a domain to a clone of the smtp
+// service in master.cf the domain is routed through, its TLS settings to the TLS policy
+// table. A domain routed through a smarthost keeps the TLS mode of its transport rule,
+// only its timeouts apply.
+// -----------------------------
+
+const deliveryOptionsOptionKey = "domain_delivery_options"
+
+// TLS protocol versions of the delivery options, oldest first
+var deliveryTLSVersions = []string{"TLSv1", "TLSv1.1", "TLSv1.2", "TLSv1.3"}
+
+// deliveryTimeoutMax longest timeout accepted, in seconds
+const deliveryTimeoutMax = 3600
+
+var deliveryDomainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+// DeliveryOptions connection settings of the delivery to a recipient domain, the zero
+// fields inherit
+type DeliveryOptions struct {
+	ConnectTimeout int    `json:"connect_timeout"` // seconds to establish the connection
+	ReadTimeout    int    `json:"read_timeout"`    // seconds waiting for each response of the receiver
+	WriteTimeout   int    `json:"write_timeout"`   // seconds to transmit the message content
+	MinTLSVersion  string `json:"min_tls_version"` // e.g. TLSv1.2
+	MaxTLSVersion  string `json:"max_tls_version"`
+	TLSVerify      string `json:"tls_verify"` // TransportTLS* mode, encrypt skips the certificate check
+}
+
+// DeliverySettings default and per-domain delivery options
+type DeliverySettings struct {
+	Default DeliveryOptions            `json:"default"`
+	Domains map[string]DeliveryOptions `json:"domains"`
+}
+
+// IsZero reports whether the options set nothing
+func (o DeliveryOptions) IsZero() bool {
+	return o == DeliveryOptions{}
+}
+
+// hasTimeouts reports whether the options set a timeout
+func (o DeliveryOptions) hasTimeouts() bool {
+	return o.ConnectTimeout > 0 || o.ReadTimeout > 0 || o.WriteTimeout > 0
+}
+
+// hasTLS reports whether the options set a TLS setting
+func (o DeliveryOptions) hasTLS() bool {
+	return o.MinTLSVersion != "" || o.MaxTLSVersion != "" || o.TLSVerify != ""
+}
+
+// inherit the options with their zero fields taken from parent
+func (o DeliveryOptions) inherit(parent DeliveryOptions) DeliveryOptions {
+	if o.ConnectTimeout == 0 {
+		o.ConnectTimeout = parent.ConnectTimeout
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = parent.ReadTimeout
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = parent.WriteTimeout
+	}
+	if o.MinTLSVersion == "" {
+		o.MinTLSVersion = parent.MinTLSVersion
+	}
+	if o.MaxTLSVersion == "" {
+		o.MaxTLSVersion = parent.MaxTLSVersion
+	}
+	if o.TLSVerify == "" {
+		o.TLSVerify = parent.TLSVerify
+	}
+	return o
+}
+
+// String the options set, for the logs
+func (o DeliveryOptions) String() string {
+	var parts []string
+	if o.ConnectTimeout > 0 {
+		parts = append(parts, fmt.Sprintf("connect timeout %ds", o.ConnectTimeout))
+	}
+	if o.ReadTimeout > 0 {
+		parts = append(parts, fmt.Sprintf("read timeout %ds", o.ReadTimeout))
+	}
+	if o.WriteTimeout > 0 {
+		parts = append(parts, fmt.Sprintf("write timeout %ds", o.WriteTimeout))
+	}
+	if o.MinTLSVersion != "" {
+		parts = append(parts, "TLS >= "+o.MinTLSVersion)
+	}
+	if o.MaxTLSVersion != "" {
+		parts = append(parts, "TLS <= "+o.MaxTLSVersion)
+	}
+	if o.TLSVerify != "" {
+		parts = append(parts, "TLS "+o.TLSVerify)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (o DeliveryOptions) validate() error {
+	for _, timeout := range []int{o.ConnectTimeout, o.ReadTimeout, o.WriteTimeout} {
+		if timeout < 0 || timeout > deliveryTimeoutMax {
+			return gerror.Newf("invalid timeout %d, expected at most %d seconds", timeout, deliveryTimeoutMax)
+		}
+	}
+
+	min, max := tlsVersionIndex(o.MinTLSVersion), tlsVersionIndex(o.MaxTLSVersion)
+	if min < 0 || max < 0 {
+		return gerror.Newf("invalid TLS version, expected one of %s", strings.Join(deliveryTLSVersions, ", "))
+	}
+	if o.MinTLSVersion != "" && o.MaxTLSVersion != "" && min > max {
+		return gerror.Newf("minimum TLS version %s above the maximum %s", o.MinTLSVersion, o.MaxTLSVersion)
+	}
+
+	switch o.TLSVerify {
+	case "", TransportTLSMay, TransportTLSEncrypt, TransportTLSVerify:
+	case TransportTLSNone:
+		if o.MinTLSVersion != "" || o.MaxTLSVersion != "" {
+			return gerror.New("TLS versions set along with TLS disabled")
+		}
+	default:
+		return gerror.Newf("invalid TLS verify policy: %s", o.TLSVerify)
+	}
+
+	return nil
+}
+
+// tlsVersionIndex the rank of a TLS version, 0 when empty and -1 when unknown
+func tlsVersionIndex(version string) int {
+	if version == "" {
+		return 0
+	}
+	for i, v := range deliveryTLSVersions {
+		if v == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalize canonical spelling of the TLS settings, e.g. tlsv1.2 or 1.2 for TLSv1.2
+func (o DeliveryOptions) normalize() DeliveryOptions {
+	for _, v := range []*string{&o.MinTLSVersion, &o.MaxTLSVersion} {
+		s := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(*v)), "tlsv")
+		if s != "" {
+			*v = "TLSv" + s
+		}
+		if *v == "TLSv1.0" {
+			*v = "TLSv1"
+		}
+	}
+	o.TLSVerify = strings.ToLower(strings.TrimSpace(o.TLSVerify))
+	return o
+}
+
+// GetDeliverySettings returns the configured delivery options
+func GetDeliverySettings(ctx context.Context) DeliverySettings {
+	s := DeliverySettings{}
+	_ = public.OptionsMgrInstance.GetOption(ctx, deliveryOptionsOptionKey, &s)
+	if s.Domains == nil {
+		s.Domains = make(map[string]DeliveryOptions)
+	}
+	return s
+}
+
+// DomainDeliveryOptions the effective options of the delivery to a recipient domain
+func DomainDeliveryOptions(ctx context.Context, domain string) DeliveryOptions {
+	s := GetDeliverySettings(ctx)
+	return s.Domains[normalizeDeliveryDomain(domain)].inherit(s.Default)
+}
+
+// SetDomainDeliveryOptions validates and stores the options of a recipient domain, zero
+// options remove them, then they are synced to postfix
+func SetDomainDeliveryOptions(ctx context.Context, domain string, opts DeliveryOptions) error {
+	domain = normalizeDeliveryDomain(domain)
+	if !deliveryDomainPattern.MatchString(domain) {
+		return gerror.Newf("invalid recipient domain: %q", domain)
+	}
+
+	opts = opts.normalize()
+	if err := opts.validate(); err != nil {
+		return gerror.Newf("delivery options of %s: %v", domain, err)
+	}
+
+	s := GetDeliverySettings(ctx)
+	if opts.IsZero() {
+		delete(s.Domains, domain)
+	} else {
+		s.Domains[domain] = opts
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, deliveryOptionsOptionKey, s); err != nil {
+		return err
+	}
+
+	return SyncTransportMapToPostfix(ctx)
+}
+
+// SetDefaultDeliveryOptions validates and stores the options of every recipient domain,
+// then they are synced to postfix
+func SetDefaultDeliveryOptions(ctx context.Context, opts DeliveryOptions) error {
+	opts = opts.normalize()
+	if err := opts.validate(); err != nil {
+		return gerror.Newf("default delivery options: %v", err)
+	}
+
+	s := GetDeliverySettings(ctx)
+	s.Default = opts
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, deliveryOptionsOptionKey, s); err != nil {
+		return err
+	}
+
+	return SyncTransportMapToPostfix(ctx)
+}
+
+func normalizeDeliveryDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
+
+// deliveryServiceName the master.cf service delivering with the timeouts of o, shared by
+// the domains with the same timeouts
+func deliveryServiceName(o DeliveryOptions) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d/%d/%d", o.ConnectTimeout, o.ReadTimeout, o.WriteTimeout)))
+	return "delivery_" + hex.EncodeToString(sum[:4])
+}
+
+// timeoutParams the postfix smtp client parameters of the timeouts of o
+func (o DeliveryOptions) timeoutParams() []string {
+	var params []string
+	if o.ConnectTimeout > 0 {
+		params = append(params, fmt.Sprintf("smtp_connect_timeout=%ds", o.ConnectTimeout))
+	}
+	if o.ReadTimeout > 0 {
+		for _, name := range []string{"helo", "mail", "rcpt", "data_init", "data_done", "rset", "quit"} {
+			params = append(params, fmt.Sprintf("smtp_%s_timeout=%ds", name, o.ReadTimeout))
+		}
+	}
+	if o.WriteTimeout > 0 {
+		params = append(params, fmt.Sprintf("smtp_data_xfer_timeout=%ds", o.WriteTimeout))
+	}
+	return params
+}
+
+// tlsProtocols the postfix protocols list of the TLS versions of o, empty when unset
+func (o DeliveryOptions) tlsProtocols() string {
+	var protocols []string
+	if o.MinTLSVersion != "" {
+		protocols = append(protocols, ">="+o.MinTLSVersion)
+	}
+	if o.MaxTLSVersion != "" {
+		protocols = append(protocols, "<="+o.MaxTLSVersion)
+	}
+	return strings.Join(protocols, ":")
+}
+
+// tlsPolicy the smtp_tls_policy_maps entry of the TLS settings of o
+func (o DeliveryOptions) tlsPolicy() string {
+	level := o.TLSVerify
+	if level == "" {
+		level = TransportTLSMay
+	}
+
+	policy := level
+	if protocols := o.tlsProtocols(); protocols != "" && level != TransportTLSNone {
+		policy += " protocols=" + protocols
+	}
+	return policy
+}
+
+// defaultParams the main.cf parameters of the default options
+func (o DeliveryOptions) defaultParams() []string {
+	params := o.timeoutParams()
+	if protocols := o.tlsProtocols(); protocols != "" {
+		params = append(params, "smtp_tls_protocols="+protocols, "smtp_tls_mandatory_protocols="+protocols)
+	}
+	if o.TLSVerify != "" {
+		params = append(params, "smtp_tls_security_level="+o.TLSVerify)
+	}
+	return params
+}
+
+// deliveryRender the postfix configuration of the delivery options
+type deliveryRender struct {
+	transport []string            // transport map lines of the domains with timeouts
+	tls       []string            // TLS policy lines of the domains with TLS settings
+	services  map[string][]string // master.cf services by name, with their parameters
+	main      []string            // main.cf parameters of the default options
+	replaced  map[string]bool     // exact transport rules replaced by a domain line
+}
+
+// renderDeliveryOptions renders the options of the domains, routed by rules. The
+// domains of the internal transports keep their route
+func renderDeliveryOptions(ctx context.Context, s DeliverySettings, rules []TransportRule, internal map[string]string) deliveryRender {
+	r := deliveryRender{services: make(map[string][]string), main: s.Default.defaultParams(), replaced: make(map[string]bool)}
+
+	domains := make([]string, 0, len(s.Domains))
+	for domain := range s.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		opts := s.Domains[domain]
+		if _, ok := internal[domain]; ok {
+			g.Log().Warningf(ctx, "Delivery options of %s ignored, the domain is routed to an internal service", domain)
+			continue
+		}
+
+		route := resolveTransport(rules, domain)
+		applied := opts
+
+		if opts.hasTimeouts() {
+			effective := opts.inherit(s.Default)
+			name := deliveryServiceName(effective)
+			r.services[name] = effective.timeoutParams()
+
+			nexthop := ""
+			if route.Kind == TransportSmarthost {
+				nexthop = route.Nexthop()
+			}
+			// The exact domain wins over the wildcard rules, an exact rule is replaced
+			r.transport = append(r.transport, fmt.Sprintf("%s %s:%s", domain, name, nexthop))
+			if route.Rule == domain {
+				r.replaced[domain] = true
+			}
+		}
+
+		if opts.hasTLS() {
+			if route.Kind == TransportSmarthost {
+				g.Log().Warningf(ctx, "TLS delivery options of %s ignored, the domain is routed through the smarthost %s with TLS %s", domain, route.Nexthop(), route.TLS)
+				applied.MinTLSVersion, applied.MaxTLSVersion, applied.TLSVerify = "", "", ""
+			} else {
+				r.tls = append(r.tls, fmt.Sprintf("%s %s", domain, opts.inherit(s.Default).tlsPolicy()))
+			}
+		}
+
+		if !applied.IsZero() {
+			g.Log().Infof(ctx, "Delivery options of %s applied: %s", domain, applied)
+		}
+	}
+
+	if !s.Default.IsZero() {
+		g.Log().Infof(ctx, "Default delivery options applied: %s", s.Default)
+	}
+
+	return r
+}
+
+// writeDeliveryServices (re)writes the block of the delivery services at the end of master.cf
+func writeDeliveryServices(masterCfPath string, services map[string][]string) error {
+	beginMarker := "# BEGIN BILLIONMAIL DELIVERY OPTIONS - DO NOT EDIT THIS MARKER"
+	endMarker := "# END BILLIONMAIL DELIVERY OPTIONS - DO NOT EDIT THIS MARKER"
+
+	content := gfile.GetContents(masterCfPath)
+
+	if b, e := strings.Index(content, beginMarker), strings.Index(content, endMarker); b != -1 && e > b {
+		content = content[:b] + strings.TrimPrefix(content[e+len(endMarker):], "\n")
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var block strings.Builder
+	block.WriteString(beginMarker + "\n")
+	for _, name := range names {
+		block.WriteString(fmt.Sprintf("%s unix - - n - - smtp\n", name))
+		for _, param := range services[name] {
+			block.WriteString("  -o " + param + "\n")
+		}
+	}
+	block.WriteString(endMarker + "\n")
+
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	if err := gfile.PutContents(masterCfPath, content+block.String()); err != nil {
+		return gerror.Newf("Failed to write to file %s: %v", masterCfPath, err)
+	}
+
+	return nil
+}
+
+// masterCfPath the master.cf of postfix
+func masterCfPath() string {
+	return path.Join(postfixConfigDir, "master.cf")
+}
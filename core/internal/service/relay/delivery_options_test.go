@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeliveryOptionsValidation(t *testing.T) {
+	valid := DeliveryOptions{ConnectTimeout: 120, MinTLSVersion: "tlsv1.0", MaxTLSVersion: "1.2", TLSVerify: "Encrypt"}.normalize()
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if valid.MinTLSVersion != "TLSv1" || valid.MaxTLSVersion != "TLSv1.2" || valid.TLSVerify != TransportTLSEncrypt {
+		t.Errorf("normalized options %+v", valid)
+	}
+
+	for name, o := range map[string]DeliveryOptions{
+		"negative timeout":      {ReadTimeout: -1},
+		"timeout too long":      {WriteTimeout: deliveryTimeoutMax + 1},
+		"unknown TLS version":   {MinTLSVersion: "SSLv3"},
+		"min above max":         {MinTLSVersion: "TLSv1.3", MaxTLSVersion: "TLSv1.2"},
+		"versions without TLS":  {MinTLSVersion: "TLSv1", TLSVerify: TransportTLSNone},
+		"unknown verify policy": {TLSVerify: "dane"},
+	} {
+		if err := o.normalize().validate(); err == nil {
+			t.Errorf("%s: %+v accepted", name, o)
+		}
+	}
+}
+
+func TestRenderDeliveryOptions(t *testing.T) {
+	s := DeliverySettings{
+		Default: DeliveryOptions{ConnectTimeout: 45},
+		Domains: map[string]DeliveryOptions{
+			"legacy.example":       {ReadTimeout: 600, MinTLSVersion: "TLSv1", TLSVerify: TransportTLSMay},
+			"internal.example":     {TLSVerify: TransportTLSEncrypt},
+			"slow.example":         {ReadTimeout: 600},
+			"eu.relayed.example":   {WriteTimeout: 900, MinTLSVersion: "TLSv1.2"},
+			"relayed.example":      {ConnectTimeout: 90},
+			"internal.service.lan": {ConnectTimeout: 5},
+		},
+	}
+	rules := []TransportRule{
+		{Domain: "relayed.example", Host: "smarthost.test", Port: 587},
+		{Domain: "*.relayed.example", Host: "wildcard.test", Port: 25},
+	}
+
+	r := renderDeliveryOptions(context.Background(), s, rules, map[string]string{"internal.service.lan": "smtp:[core]:10026"})
+
+	// The domains with the same timeouts share their service
+	legacy := deliveryServiceName(DeliveryOptions{ConnectTimeout: 45, ReadTimeout: 600})
+	want := []string{
+		"eu.relayed.example " + deliveryServiceName(DeliveryOptions{ConnectTimeout: 45, WriteTimeout: 900}) + ":[wildcard.test]:25",
+		"legacy.example " + legacy + ":",
+		"relayed.example " + deliveryServiceName(DeliveryOptions{ConnectTimeout: 90}) + ":[smarthost.test]:587",
+		"slow.example " + legacy + ":",
+	}
+	if strings.Join(r.transport, "\n") != strings.Join(want, "\n") {
+		t.Errorf("transport lines:\n%s\nwant:\n%s", strings.Join(r.transport, "\n"), strings.Join(want, "\n"))
+	}
+	if len(r.services) != 3 || !strings.Contains(strings.Join(r.services[legacy], " "), "smtp_connect_timeout=45s smtp_helo_timeout=600s") {
+		t.Errorf("services %v", r.services)
+	}
+	if !r.replaced["relayed.example"] || r.replaced["*.relayed.example"] {
+		t.Errorf("replaced rules %v", r.replaced)
+	}
+
+	// The TLS settings of the domains routed through a smarthost are those of the rule
+	wantTLS := []string{"internal.example encrypt", "legacy.example may protocols=>=TLSv1"}
+	if strings.Join(r.tls, "\n") != strings.Join(wantTLS, "\n") {
+		t.Errorf("TLS policy lines %q, want %q", r.tls, wantTLS)
+	}
+	if strings.Join(r.main, " ") != "smtp_connect_timeout=45s" {
+		t.Errorf("main.cf parameters %v", r.main)
+	}
+}
+
+func TestWriteDeliveryServices(t *testing.T) {
+	master := filepath.Join(t.TempDir(), "master.cf")
+	if err := os.WriteFile(master, []byte("smtp inet n - n - - smtpd\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	services := map[string][]string{"delivery_b": {"smtp_connect_timeout=90s"}, "delivery_a": {"smtp_data_xfer_timeout=900s"}}
+	for i := 0; i < 2; i++ {
+		if err := writeDeliveryServices(master, services); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, _ := os.ReadFile(master)
+	want := "smtp inet n - n - - smtpd\n" +
+		"# BEGIN BILLIONMAIL DELIVERY OPTIONS - DO NOT EDIT THIS MARKER\n" +
+		"delivery_a unix - - n - - smtp\n  -o smtp_data_xfer_timeout=900s\n" +
+		"delivery_b unix - - n - - smtp\n  -o smtp_connect_timeout=90s\n" +
+		"# END BILLIONMAIL DELIVERY OPTIONS - DO NOT EDIT THIS MARKER\n"
+	if string(content) != want {
+		t.Errorf("master.cf:\n%s\nwant:\n%s", content, want)
+	}
+}
@@ -0,0 +1,3 @@
+package relay
+
+import _ "billionmail-core/internal/testlog"
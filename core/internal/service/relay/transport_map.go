@@ -0,0 +1,413 @@
+package relay
+
+import (
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gfile"
+)
+
+// -----------------------------
+// Recipient domain routing (transport map).
+// Rules route recipient domains through a smarthost instead of direct MX delivery.
+// Precedence: exact domain, then the most specific "*.parent" wildcard, then the "*" default.
+// Postfix does the actual delivery, so the rules are rendered to transport_maps,
+// smtp_sasl_password_maps and smtp_tls_policy_maps files.
+// -----------------------------
+
+const transportMapOptionKey = "recipient_transport_map"
+
+var (
+	recipientTransportFile  = "/conf/recipient_transport"
+	recipientSaslPasswdFile = "/conf/recipient_sasl_passwd"
+	recipientTlsPolicyFile  = "/conf/recipient_tls_policy"
+)
+
+var (
+	internalTransportsMutex sync.Mutex
+	internalTransports      = make(map[string]string)
+)
+
+// Transport kinds
+const (
+	TransportDirect    = "direct"
+	TransportSmarthost = "smarthost"
+)
+
+// Smarthost TLS modes, same values as the postfix TLS policy
+const (
+	TransportTLSNone    = "none"
+	TransportTLSMay     = "may"
+	TransportTLSEncrypt = "encrypt"
+	TransportTLSVerify  = "verify"
+)
+
+// TransportRule configured route of a recipient domain
+type TransportRule struct {
+	Domain   string `json:"domain"` // example.com, *.example.com or * for the default route
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"` // encrypted with the relay encryption key
+	TLS      string `json:"tls"`
+}
+
+// Transport resolved route of a recipient domain
+type Transport struct {
+	Kind     string `json:"kind"`
+	Rule     string `json:"rule,omitempty"` // domain pattern of the matched rule
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"-"`
+	TLS      string `json:"tls,omitempty"`
+}
+
+// Nexthop postfix nexthop notation of the smarthost
+func (t Transport) Nexthop() string {
+	return fmt.Sprintf("[%s]:%d", t.Host, t.Port)
+}
+
+// RegisterTransport routes an internal domain of a service, e.g. to a listener of the
+// core, transport is the postfix notation like "smtp:[core]:10026". The internal routes
+// are rendered before the rules
+func RegisterTransport(domain, transport string) {
+	internalTransportsMutex.Lock()
+	defer internalTransportsMutex.Unlock()
+
+	internalTransports[domain] = transport
+}
+
+// UnregisterTransport removes the internal route of domain
+func UnregisterTransport(domain string) {
+	internalTransportsMutex.Lock()
+	defer internalTransportsMutex.Unlock()
+
+	delete(internalTransports, domain)
+}
+
+// GetTransportRules returns the configured rules
+func GetTransportRules(ctx context.Context) []TransportRule {
+	rules := make([]TransportRule, 0)
+	_ = public.OptionsMgrInstance.GetOption(ctx, transportMapOptionKey, &rules)
+	return rules
+}
+
+// SetTransportRules validates and stores the rules, plain passwords are encrypted
+// before storing, then the rules are synced to postfix
+func SetTransportRules(ctx context.Context, rules []TransportRule, plainPasswords map[string]string) error {
+	seen := make(map[string]bool, len(rules))
+
+	for i := range rules {
+		r := &rules[i]
+		r.Domain = strings.ToLower(strings.TrimSpace(r.Domain))
+		r.Host = strings.TrimSpace(r.Host)
+
+		if err := validateTransportRule(*r); err != nil {
+			return err
+		}
+
+		if seen[r.Domain] {
+			return gerror.Newf("duplicate transport rule for %s", r.Domain)
+		}
+		seen[r.Domain] = true
+
+		if pass, ok := plainPasswords[r.Domain]; ok && pass != "" {
+			encrypted, err := EncryptPassword(ctx, pass)
+			if err != nil {
+				return err
+			}
+			r.Password = encrypted
+		}
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, transportMapOptionKey, rules); err != nil {
+		return err
+	}
+
+	return SyncTransportMapToPostfix(ctx)
+}
+
+func validateTransportRule(r TransportRule) error {
+	d := r.Domain
+	if d == "" || (d != "*" && strings.Contains(strings.TrimPrefix(d, "*."), "*")) {
+		return gerror.Newf("invalid transport rule domain: %q", r.Domain)
+	}
+
+	if r.Host == "" {
+		return gerror.Newf("transport rule %s has no smarthost", r.Domain)
+	}
+
+	if r.Port <= 0 || r.Port > 65535 {
+		return gerror.Newf("transport rule %s has an invalid port: %d", r.Domain, r.Port)
+	}
+
+	switch r.TLS {
+	case "", TransportTLSNone, TransportTLSMay, TransportTLSEncrypt, TransportTLSVerify:
+	default:
+		return gerror.Newf("transport rule %s has an invalid TLS mode: %s", r.Domain, r.TLS)
+	}
+
+	return nil
+}
+
+// ResolveTransport returns the route of a recipient domain, direct MX delivery when no rule matches
+func ResolveTransport(ctx context.Context, recipientDomain string) (Transport, error) {
+	t := resolveTransport(GetTransportRules(ctx), recipientDomain)
+
+	if t.Kind == TransportSmarthost && t.Password != "" {
+		pass, err := DecryptPassword(ctx, t.Password)
+		if err != nil {
+			return Transport{}, err
+		}
+		t.Password = pass
+	}
+
+	return t, nil
+}
+
+// resolveTransport picks the matching rule by precedence, the password is left encrypted
+func resolveTransport(rules []TransportRule, recipientDomain string) Transport {
+	domain := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(recipientDomain), "."))
+
+	var best *TransportRule
+	bestScore := -1
+
+	for i := range rules {
+		score := transportRuleScore(rules[i].Domain, domain)
+		if score > bestScore {
+			best, bestScore = &rules[i], score
+		}
+	}
+
+	if best == nil || bestScore < 0 {
+		return Transport{Kind: TransportDirect}
+	}
+
+	return best.transport()
+}
+
+// transport smarthost route of the rule, the password is left encrypted
+func (r TransportRule) transport() Transport {
+	tls := r.TLS
+	if tls == "" {
+		tls = TransportTLSMay
+	}
+
+	return Transport{
+		Kind:     TransportSmarthost,
+		Rule:     r.Domain,
+		Host:     r.Host,
+		Port:     r.Port,
+		Username: r.Username,
+		Password: r.Password,
+		TLS:      tls,
+	}
+}
+
+// transportRuleScore ranks how specifically a pattern matches a domain, -1 when it does not match.
+// The default rule scores 0, wildcards score by the length of their suffix, an exact match wins
+func transportRuleScore(pattern, domain string) int {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+
+	switch {
+	case pattern == "*":
+		return 0
+	case strings.HasPrefix(pattern, "*."):
+		suffix := pattern[1:]
+		if strings.HasSuffix(domain, suffix) && len(domain) > len(suffix) {
+			return len(suffix)
+		}
+		return -1
+	case pattern == domain:
+		return 1 << 16
+	}
+
+	return -1
+}
+
+// SyncTransportMapToPostfix renders the rules to postfix lookup tables and reloads postfix
+func SyncTransportMapToPostfix(ctx context.Context) error {
+	if !gfile.Exists(postfixConfigDir) {
+		return gerror.New("Postfix configuration directory does not exist : " + postfixConfigDir)
+	}
+
+	rules := GetTransportRules(ctx)
+
+	// Postfix applies the same precedence by itself, sorting only keeps the generated files stable
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Domain < rules[j].Domain
+	})
+
+	var transport, sasl, tls strings.Builder
+	transport.WriteString("# Generated by BillionMail, do not edit\n")
+	sasl.WriteString("# Generated by BillionMail, do not edit\n")
+	tls.WriteString("# Generated by BillionMail, do not edit\n")
+
+	internalTransportsMutex.Lock()
+	internal := make(map[string]string, len(internalTransports))
+	for domain, route := range internalTransports {
+		internal[domain] = route
+	}
+	internalTransportsMutex.Unlock()
+
+	internalDomains := make([]string, 0, len(internal))
+	for domain := range internal {
+		internalDomains = append(internalDomains, domain)
+	}
+	sort.Strings(internalDomains)
+	for _, domain := range internalDomains {
+		transport.WriteString(fmt.Sprintf("%s %s\n", domain, internal[domain]))
+	}
+
+	delivery := renderDeliveryOptions(ctx, GetDeliverySettings(ctx), rules, internal)
+	for _, line := range delivery.transport {
+		transport.WriteString(line + "\n")
+	}
+	for _, line := range delivery.tls {
+		tls.WriteString(line + "\n")
+	}
+
+	for _, r := range rules {
+		t := r.transport()
+
+		// postfix notation of a subdomain wildcard is ".example.com"
+		key := r.Domain
+		if strings.HasPrefix(key, "*.") {
+			key = key[1:]
+		}
+
+		// The domain line of its delivery options routes it to the smarthost already
+		if !delivery.replaced[r.Domain] {
+			transport.WriteString(fmt.Sprintf("%s relay:%s\n", key, t.Nexthop()))
+		}
+		tls.WriteString(fmt.Sprintf("%s %s\n", t.Nexthop(), t.TLS))
+
+		if r.Username != "" {
+			pass, err := DecryptPassword(ctx, r.Password)
+			if err != nil {
+				g.Log().Warningf(ctx, "Decryption of the smarthost password of %s failed, skipping credentials: %v", r.Domain, err)
+				continue
+			}
+			sasl.WriteString(fmt.Sprintf("%s %s:%s\n", t.Nexthop(), r.Username, pass))
+		}
+	}
+
+	files := map[string]string{
+		recipientTransportFile:  transport.String(),
+		recipientSaslPasswdFile: sasl.String(),
+		recipientTlsPolicyFile:  tls.String(),
+	}
+
+	for name, content := range files {
+		if err := gfile.PutContents(path.Join(postfixConfigDir, name), content); err != nil {
+			return gerror.Newf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := writeTransportMapConfig(path.Join(postfixConfigDir, mainCfFile), delivery.main); err != nil {
+		return err
+	}
+
+	if gfile.Exists(masterCfPath()) {
+		if err := writeDeliveryServices(masterCfPath(), delivery.services); err != nil {
+			return err
+		}
+	}
+
+	return reloadTransportMaps(ctx)
+}
+
+// writeTransportMapConfig (re)writes the transport map block at the end of main.cf,
+// its smtp_sasl_password_maps includes the relay tables so it can safely override them.
+// params are the parameters of the default delivery options
+func writeTransportMapConfig(cfPath string, params []string) error {
+	beginMarker := "# BEGIN RECIPIENT TRANSPORT CONFIGURATION - DO NOT EDIT THIS MARKER"
+	endMarker := "# END RECIPIENT TRANSPORT CONFIGURATION - DO NOT EDIT THIS MARKER"
+
+	content := gfile.GetContents(cfPath)
+
+	if b, e := strings.Index(content, beginMarker), strings.Index(content, endMarker); b != -1 && e > b {
+		content = content[:b] + strings.TrimPrefix(content[e+len(endMarker):], "\n")
+	}
+
+	block := fmt.Sprintf(`%s
+transport_maps = hash:/etc/postfix%s
+smtp_sasl_auth_enable = yes
+smtp_sasl_security_options = noanonymous
+smtp_sasl_password_maps = hash:/etc/postfix/conf/sasl_passwd_primary, hash:/etc/postfix/conf/sasl_passwd, hash:/etc/postfix%s
+smtp_tls_policy_maps = hash:/etc/postfix%s
+%s%s
+`, beginMarker, recipientTransportFile, recipientSaslPasswdFile, recipientTlsPolicyFile, mainCfParams(params), endMarker)
+
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	if err := gfile.PutContents(cfPath, content+block); err != nil {
+		return gerror.Newf("Failed to write to file %s: %v", cfPath, err)
+	}
+
+	return nil
+}
+
+// mainCfParams the main.cf lines of name=value parameters
+func mainCfParams(params []string) string {
+	var b strings.Builder
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		b.WriteString(fmt.Sprintf("%s = %s\n", name, value))
+	}
+	return b.String()
+}
+
+func reloadTransportMaps(ctx context.Context) error {
+	// The relay tables are referenced by smtp_sasl_password_maps, make sure they exist
+	for _, name := range []string{saslPasswdPrimaryFile, saslPasswdFile} {
+		p := path.Join(postfixConfigDir, name)
+		if !gfile.Exists(p) {
+			if err := gfile.PutContents(p, ""); err != nil {
+				return gerror.Newf("Failed to create %s: %v", name, err)
+			}
+		}
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return gerror.Wrap(err, "Failed to connect to Docker service")
+	}
+	defer dk.Close()
+
+	cmdsToRun := [][]string{
+		{"postmap", "/etc/postfix/conf/sasl_passwd_primary"},
+		{"postmap", "/etc/postfix/conf/sasl_passwd"},
+		{"postmap", "/etc/postfix" + recipientTransportFile},
+		{"postmap", "/etc/postfix" + recipientSaslPasswdFile},
+		{"postmap", "/etc/postfix" + recipientTlsPolicyFile},
+		{"postfix", "reload"},
+	}
+
+	for _, cmd := range cmdsToRun {
+		cmdStr := strings.Join(cmd, " ")
+
+		result, err := dk.ExecCommandByName(ctx, postfixContainerName, cmd, "root")
+		if err != nil {
+			return gerror.Newf("Failed to execute command: %v, Command: %s", err, cmdStr)
+		}
+
+		if result.ExitCode != 0 {
+			return gerror.Newf("Command execution failed, Exit code: %d, Output: %s, Command: %s",
+				result.ExitCode, result.Output, cmdStr)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package relay
+
+import "testing"
+
+func TestResolveTransportPrecedence(t *testing.T) {
+	rules := []TransportRule{
+		{Domain: "*", Host: "default.relay", Port: 25},
+		{Domain: "*.example.com", Host: "wildcard.relay", Port: 587},
+		{Domain: "*.eu.example.com", Host: "eu.relay", Port: 587},
+		{Domain: "example.com", Host: "exact.relay", Port: 465, TLS: TransportTLSEncrypt},
+	}
+
+	tests := []struct {
+		name   string
+		domain string
+		rules  []TransportRule
+		want   string // expected smarthost, empty for direct delivery
+	}{
+		{name: "Exact domain wins over wildcard and default", domain: "example.com", rules: rules, want: "exact.relay"},
+		{name: "Exact match is case insensitive", domain: "EXAMPLE.com.", rules: rules, want: "exact.relay"},
+		{name: "Wildcard matches subdomains", domain: "mail.example.com", rules: rules, want: "wildcard.relay"},
+		{name: "Most specific wildcard wins", domain: "mx.eu.example.com", rules: rules, want: "eu.relay"},
+		{name: "Wildcard does not match lookalike domains", domain: "badexample.com", rules: rules, want: "default.relay"},
+		{name: "Default route for other domains", domain: "other.org", rules: rules, want: "default.relay"},
+		{name: "Direct delivery without default", domain: "other.org", rules: rules[1:], want: ""},
+		{name: "Direct delivery without rules", domain: "example.com", rules: nil, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveTransport(tt.rules, tt.domain)
+
+			if tt.want == "" {
+				if got.Kind != TransportDirect {
+					t.Errorf("resolveTransport(%q) = %+v, want direct delivery", tt.domain, got)
+				}
+				return
+			}
+
+			if got.Kind != TransportSmarthost || got.Host != tt.want {
+				t.Errorf("resolveTransport(%q) = %+v, want smarthost %s", tt.domain, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTransportDefaults(t *testing.T) {
+	got := resolveTransport([]TransportRule{{Domain: "example.com", Host: "relay.test", Port: 2525}}, "example.com")
+
+	if got.TLS != TransportTLSMay {
+		t.Errorf("TLS = %q, want %q", got.TLS, TransportTLSMay)
+	}
+
+	if got.Nexthop() != "[relay.test]:2525" {
+		t.Errorf("Nexthop() = %q, want [relay.test]:2525", got.Nexthop())
+	}
+}
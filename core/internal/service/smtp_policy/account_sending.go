@@ -0,0 +1,37 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/mail_boxes"
+	"context"
+	"fmt"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Sending switch of the accounts, an authenticated user whose sending is disabled is
+// refused at the first recipient. Receiving and logging in are not affected.
+// -----------------------------
+
+// sendingEnabled the sending switch of an account, replaced in the tests
+var sendingEnabled = mail_boxes.SendingEnabled
+
+func init() {
+	RegisterCheck("account_sending", checkAccountSending)
+}
+
+// checkAccountSending policy check, applied to authenticated sessions before the other
+// sender checks so a disabled account consumes no quota
+func checkAccountSending(ctx context.Context, req PolicyRequest) string {
+	if req.Stage() != "RCPT" {
+		return ActionDunno
+	}
+
+	user := req.Get("sasl_username")
+	if user == "" || sendingEnabled(ctx, user) {
+		return ActionDunno
+	}
+
+	g.Log().Warningf(ctx, "Rejected submission of %s, sending disabled", user)
+	return fmt.Sprintf("550 5.7.1 Sending disabled for account %s", user)
+}
@@ -0,0 +1,31 @@
+package smtp_policy
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckAccountSending(t *testing.T) {
+	orig := sendingEnabled
+	sendingEnabled = func(ctx context.Context, user string) bool { return user != "disabled@example.com" }
+	t.Cleanup(func() { sendingEnabled = orig })
+
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		req  PolicyRequest
+		want string
+	}{
+		{"enabled", PolicyRequest{"protocol_state": "RCPT", "sasl_username": "alice@example.com"}, ActionDunno},
+		{"disabled", PolicyRequest{"protocol_state": "RCPT", "sasl_username": "disabled@example.com"}, "550 5.7.1 Sending disabled for account disabled@example.com"},
+		// Refused at RCPT already
+		{"end of message", PolicyRequest{"protocol_state": "END-OF-MESSAGE", "sasl_username": "disabled@example.com"}, ActionDunno},
+		// Receiving is not affected
+		{"unauthenticated", PolicyRequest{"protocol_state": "RCPT", "recipient": "disabled@example.com"}, ActionDunno},
+	} {
+		if got := checkAccountSending(ctx, tc.req); got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
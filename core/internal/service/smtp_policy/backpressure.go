@@ -0,0 +1,253 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Backpressure of the submissions on the outbound queue. Once the postfix queue holds
+// more than MaxQueueDepth messages, delivery is not keeping up and the recipients of
+// the authenticated submissions are deferred with a 4xx until the queue drains below
+// ResumeQueueDepth, the senders retry later. The depth is read in the background at
+// most every backpressureRefresh, a query never waits for it. While the depth cannot be
+// read the submissions are accepted, as postfix does when this service is down.
+// -----------------------------
+
+const backpressureOptionKey = "submission_backpressure"
+
+const (
+	backpressureRefresh = 30 * time.Second
+	queueDepthTimeout   = 20 * time.Second
+)
+
+// BackpressureConfig queue depths engaging and releasing the backpressure, a
+// MaxQueueDepth of 0 disables it
+type BackpressureConfig struct {
+	MaxQueueDepth    int `json:"max_queue_depth"`
+	ResumeQueueDepth int `json:"resume_queue_depth"` // 80% of MaxQueueDepth when 0
+}
+
+// resume the depth releasing the backpressure
+func (c BackpressureConfig) resume() int {
+	if c.ResumeQueueDepth > 0 {
+		return c.ResumeQueueDepth
+	}
+	return c.MaxQueueDepth * 4 / 5
+}
+
+// BackpressureState current state of the backpressure
+type BackpressureState struct {
+	BackpressureConfig
+	Engaged      bool   `json:"engaged"`
+	QueueDepth   int    `json:"queue_depth"`
+	CheckedAt    int64  `json:"checked_at"`              // time of the last depth reading
+	EngagedSince int64  `json:"engaged_since,omitempty"` // time the backpressure engaged
+	Deferred     int64  `json:"deferred"`                // recipients deferred since start
+	Error        string `json:"error,omitempty"`         // failure of the last depth reading
+}
+
+type backpressureMonitor struct {
+	mutex      sync.Mutex
+	loaded     bool
+	cfg        BackpressureConfig
+	state      BackpressureState
+	refreshing bool
+}
+
+var backpressure = &backpressureMonitor{}
+
+func init() {
+	RegisterCheck("backpressure", checkBackpressure)
+}
+
+// QueueDepth counts the messages waiting for delivery in the postfix queue, the held
+// messages are not
+func QueueDepth(ctx context.Context) (int, error) {
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return 0, err
+	}
+	defer dk.Close()
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postqueue", "-j"}, "root")
+	if err != nil {
+		return 0, err
+	}
+	if res.ExitCode != 0 {
+		return 0, fmt.Errorf("postqueue failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	// One JSON object per queued message
+	depth := 0
+	for _, line := range strings.Split(res.Output, "\n") {
+		var item struct {
+			QueueName string `json:"queue_name"`
+		}
+		if json.Unmarshal([]byte(strings.TrimSpace(line)), &item) != nil || item.QueueName == "" || item.QueueName == "hold" {
+			continue
+		}
+		depth++
+	}
+
+	return depth, nil
+}
+
+// GetBackpressureConfig returns the configured depths
+func GetBackpressureConfig(ctx context.Context) BackpressureConfig {
+	b := backpressure
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.load(ctx)
+	return b.cfg
+}
+
+// SetBackpressureConfig sets the depths, the state is evaluated again at the next reading
+func SetBackpressureConfig(ctx context.Context, cfg BackpressureConfig) error {
+	if cfg.MaxQueueDepth < 0 || cfg.ResumeQueueDepth < 0 {
+		return fmt.Errorf("queue depths must not be negative")
+	}
+	if cfg.MaxQueueDepth > 0 && cfg.ResumeQueueDepth >= cfg.MaxQueueDepth {
+		return fmt.Errorf("resume depth %d must be below the max depth %d", cfg.ResumeQueueDepth, cfg.MaxQueueDepth)
+	}
+
+	b := backpressure
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, backpressureOptionKey, cfg); err != nil {
+		return err
+	}
+
+	b.cfg, b.loaded = cfg, true
+	b.state.CheckedAt = 0
+	if cfg.MaxQueueDepth == 0 {
+		b.release(ctx)
+	}
+
+	return nil
+}
+
+// GetBackpressureState returns the state as of the last reading and starts a new one when it is due
+func GetBackpressureState(ctx context.Context) BackpressureState {
+	b := backpressure
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.load(ctx)
+	b.refreshIfDue(ctx)
+
+	state := b.state
+	state.BackpressureConfig = b.cfg
+	if b.cfg.MaxQueueDepth > 0 {
+		state.ResumeQueueDepth = b.cfg.resume()
+	}
+	return state
+}
+
+// load reads the configuration from the options once, the caller holds the mutex
+func (b *backpressureMonitor) load(ctx context.Context) {
+	if b.loaded {
+		return
+	}
+
+	cfg := BackpressureConfig{}
+	if err := getOption(ctx, backpressureOptionKey, &cfg); err == nil {
+		b.cfg = cfg
+	}
+
+	b.loaded = true
+}
+
+// refreshIfDue starts reading the depth when the last reading is too old, the caller
+// holds the mutex
+func (b *backpressureMonitor) refreshIfDue(ctx context.Context) {
+	if b.cfg.MaxQueueDepth == 0 || b.refreshing || time.Since(time.Unix(b.state.CheckedAt, 0)) < backpressureRefresh {
+		return
+	}
+
+	b.refreshing = true
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), queueDepthTimeout)
+		defer cancel()
+
+		depth, err := QueueDepth(ctx)
+
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		b.refreshing = false
+		b.update(ctx, depth, err)
+	}()
+}
+
+// update applies a depth reading, the caller holds the mutex
+func (b *backpressureMonitor) update(ctx context.Context, depth int, err error) {
+	b.state.CheckedAt = time.Now().Unix()
+
+	if err != nil {
+		g.Log().Warning(ctx, "Read outbound queue depth failed: ", err)
+		b.state.Error = err.Error()
+		b.release(ctx)
+		return
+	}
+
+	b.state.Error = ""
+	b.state.QueueDepth = depth
+
+	switch {
+	case b.cfg.MaxQueueDepth == 0:
+		b.release(ctx)
+	case !b.state.Engaged && depth > b.cfg.MaxQueueDepth:
+		b.state.Engaged = true
+		b.state.EngagedSince = b.state.CheckedAt
+		g.Log().Warningf(ctx, "Submission backpressure engaged, %d messages queued above the %d max", depth, b.cfg.MaxQueueDepth)
+	case b.state.Engaged && depth <= b.cfg.resume():
+		b.release(ctx)
+	}
+}
+
+// release lets the submissions through again, the caller holds the mutex
+func (b *backpressureMonitor) release(ctx context.Context) {
+	if !b.state.Engaged {
+		return
+	}
+
+	g.Log().Infof(ctx, "Submission backpressure released after %s, %d messages queued",
+		time.Since(time.Unix(b.state.EngagedSince, 0)).Round(time.Second), b.state.QueueDepth)
+	b.state.Engaged = false
+	b.state.EngagedSince = 0
+}
+
+// checkBackpressure policy check, defers the recipients of the authenticated submissions
+// while the backpressure is engaged
+func checkBackpressure(ctx context.Context, req PolicyRequest) string {
+	if req.Stage() != "RCPT" || req.Get("sasl_username") == "" {
+		return ActionDunno
+	}
+
+	b := backpressure
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.load(ctx)
+	b.refreshIfDue(ctx)
+
+	if !b.state.Engaged {
+		return ActionDunno
+	}
+
+	b.state.Deferred++
+	return fmt.Sprintf("451 4.3.2 Outbound queue backlog of %d messages, try again later", b.state.QueueDepth)
+}
@@ -0,0 +1,109 @@
+package smtp_policy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubBackpressure replaces the monitor of the checks with one of cfg, read just now
+func stubBackpressure(t *testing.T, cfg BackpressureConfig) *backpressureMonitor {
+	t.Helper()
+
+	b := &backpressureMonitor{loaded: true, cfg: cfg}
+	b.state.CheckedAt = time.Now().Unix()
+
+	orig := backpressure
+	backpressure = b
+	t.Cleanup(func() { backpressure = orig })
+
+	return b
+}
+
+func TestBackpressureResume(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  BackpressureConfig
+		want int
+	}{
+		{BackpressureConfig{MaxQueueDepth: 1000}, 800},
+		{BackpressureConfig{MaxQueueDepth: 1000, ResumeQueueDepth: 100}, 100},
+		{BackpressureConfig{MaxQueueDepth: 4}, 3},
+	} {
+		if got := tc.cfg.resume(); got != tc.want {
+			t.Errorf("resume of %+v = %d, want %d", tc.cfg, got, tc.want)
+		}
+	}
+}
+
+func TestBackpressureUpdate(t *testing.T) {
+	ctx := context.Background()
+	b := stubBackpressure(t, BackpressureConfig{MaxQueueDepth: 100, ResumeQueueDepth: 50})
+
+	for i, tc := range []struct {
+		depth   int
+		err     error
+		engaged bool
+	}{
+		{100, nil, false},
+		{101, nil, true},
+		// Engaged until the queue drains to the resume depth
+		{80, nil, true},
+		{50, nil, false},
+		{80, nil, false},
+		{150, nil, true},
+		// Accepted while the depth cannot be read
+		{0, errors.New("postqueue failed"), false},
+	} {
+		b.update(ctx, tc.depth, tc.err)
+		if b.state.Engaged != tc.engaged {
+			t.Errorf("reading %d (depth %d, %v): engaged %t, want %t", i, tc.depth, tc.err, b.state.Engaged, tc.engaged)
+		}
+	}
+
+	if b.state.Error != "postqueue failed" || b.state.EngagedSince != 0 {
+		t.Errorf("state after a failed reading = %+v", b.state)
+	}
+}
+
+func TestCheckBackpressure(t *testing.T) {
+	ctx := context.Background()
+	b := stubBackpressure(t, BackpressureConfig{MaxQueueDepth: 100})
+	b.update(ctx, 120, nil)
+
+	submission := PolicyRequest{"protocol_state": "RCPT", "sasl_username": "alice@example.com"}
+	if got := checkBackpressure(ctx, submission); !strings.HasPrefix(got, "451 4.3.2 Outbound queue backlog of 120 messages") {
+		t.Errorf("submission while engaged = %q", got)
+	}
+
+	// Inbound mail and the end of the message are not deferred
+	for _, req := range []PolicyRequest{
+		{"protocol_state": "RCPT", "recipient": "me@example.com"},
+		{"protocol_state": "END-OF-MESSAGE", "sasl_username": "alice@example.com"},
+	} {
+		if got := checkBackpressure(ctx, req); got != ActionDunno {
+			t.Errorf("checkBackpressure(%v) = %q", req, got)
+		}
+	}
+
+	b.update(ctx, 10, nil)
+	if got := checkBackpressure(ctx, submission); got != ActionDunno {
+		t.Errorf("submission once released = %q", got)
+	}
+	if b.state.Deferred != 1 {
+		t.Errorf("deferred %d, want 1", b.state.Deferred)
+	}
+}
+
+func TestSetBackpressureConfigValidation(t *testing.T) {
+	for _, cfg := range []BackpressureConfig{
+		{MaxQueueDepth: -1},
+		{MaxQueueDepth: 100, ResumeQueueDepth: -1},
+		{MaxQueueDepth: 100, ResumeQueueDepth: 100},
+	} {
+		if err := SetBackpressureConfig(context.Background(), cfg); err == nil {
+			t.Errorf("SetBackpressureConfig(%+v) accepted", cfg)
+		}
+	}
+}
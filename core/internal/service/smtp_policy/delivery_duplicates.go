@@ -0,0 +1,45 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Duplicate deliveries of the inbound mail. The sieve script dovecot runs before the
+// scripts of the user queries this service at the DELIVERY stage, which postfix never
+// sends, with the recipient and the Message-ID of each LMTP delivery. A duplicate is
+// answered DISCARD, the script then discards it. The delivery goes on when the core
+// is unreachable or the check fails.
+// -----------------------------
+
+// StageDelivery protocol state of the queries of the sieve duplicate check
+const StageDelivery = "DELIVERY"
+
+// ActionDiscard answer of a duplicate delivery
+const ActionDiscard = "DISCARD"
+
+func init() {
+	RegisterCheck("duplicate_delivery", checkDuplicateDelivery)
+}
+
+// checkDuplicateDelivery suppresses the redelivery of a message to an opted-in mailbox
+func checkDuplicateDelivery(ctx context.Context, req PolicyRequest) string {
+	if req.Stage() != StageDelivery {
+		return ActionDunno
+	}
+
+	duplicate, err := inbound.IsDuplicateDelivery(ctx, req.Get("recipient"), req.Get("message_id"))
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to check the delivery of %s to %s: %v", req.Get("message_id"), req.Get("recipient"), err)
+		return ActionDunno
+	}
+	if !duplicate {
+		return ActionDunno
+	}
+
+	g.Log().Infof(ctx, "Suppressed the duplicate delivery of %s to %s", req.Get("message_id"), req.Get("recipient"))
+	return ActionDiscard + " Duplicate delivery"
+}
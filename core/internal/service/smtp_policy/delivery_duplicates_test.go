@@ -0,0 +1,22 @@
+package smtp_policy
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckDuplicateDeliveryStage(t *testing.T) {
+	ctx := context.Background()
+
+	// Only the queries of the sieve script are checked
+	req := PolicyRequest{"protocol_state": "RCPT", "recipient": "a@example.com", "message_id": "<1@example.com>"}
+	if got := checkDuplicateDelivery(ctx, req); got != ActionDunno {
+		t.Errorf("checkDuplicateDelivery at RCPT = %q, want %q", got, ActionDunno)
+	}
+
+	// Never a duplicate without Message-ID
+	req = PolicyRequest{"protocol_state": StageDelivery, "recipient": "a@example.com"}
+	if got := checkDuplicateDelivery(ctx, req); got != ActionDunno {
+		t.Errorf("checkDuplicateDelivery without Message-ID = %q, want %q", got, ActionDunno)
+	}
+}
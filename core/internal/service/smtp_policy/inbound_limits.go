@@ -0,0 +1,261 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Inbound message size and recipient count limits of unauthenticated sessions. Each
+// recipient is checked at RCPT against the limits of its account, else of its domain,
+// else the defaults, with the declared SIZE and the recipients accepted so far. The
+// smallest size limit of the recipients is checked again at the end of the message
+// against the real size. A field left at 0 inherits the next level.
+// -----------------------------
+
+const inboundLimitsOptionKey = "inbound_limits"
+
+// Built-in limits used when the defaults are not configured
+const (
+	DefaultInboundMaxSize       int64 = 50 << 20
+	DefaultInboundMaxRecipients       = 1000
+)
+
+// inboundMessageTTL how long the recipients of a transaction are remembered between RCPT
+// and the end of the message
+const inboundMessageTTL = time.Hour
+
+// InboundLimit caps of one level, 0 inherits the next level
+type InboundLimit struct {
+	MaxSize       int64 `json:"max_size"`       // bytes
+	MaxRecipients int   `json:"max_recipients"` // recipients per message
+}
+
+// InboundLimits configured defaults and overrides by recipient domain and account
+type InboundLimits struct {
+	Default  InboundLimit            `json:"default"`
+	Domains  map[string]InboundLimit `json:"domains"`
+	Accounts map[string]InboundLimit `json:"accounts"`
+}
+
+// inboundMessage recipients of one transaction seen at RCPT
+type inboundMessage struct {
+	recipients int
+	maxSize    int64  // smallest size limit of the recipients
+	sizeOf     string // recipient or domain whose limit is maxSize
+	seen       time.Time
+}
+
+var (
+	inboundMutex    sync.Mutex
+	inboundMessages = make(map[string]*inboundMessage)
+	inboundSwept    time.Time
+)
+
+func init() {
+	RegisterCheck("inbound_limits", checkInboundLimits)
+}
+
+// GetInboundLimits returns the configured limits
+func GetInboundLimits(ctx context.Context) InboundLimits {
+	limits := InboundLimits{}
+	_ = getOption(ctx, inboundLimitsOptionKey, &limits)
+	return limits
+}
+
+// SetInboundLimits replaces the configured limits
+func SetInboundLimits(ctx context.Context, limits InboundLimits) error {
+	normalize := func(kind string, in map[string]InboundLimit, address bool) (map[string]InboundLimit, error) {
+		out := make(map[string]InboundLimit, len(in))
+		for k, v := range in {
+			k = strings.ToLower(strings.TrimSpace(k))
+			if k == "" || strings.Contains(k, "@") != address {
+				return nil, fmt.Errorf("invalid %s %q", kind, k)
+			}
+			if v.MaxSize < 0 || v.MaxRecipients < 0 {
+				return nil, fmt.Errorf("invalid limits for %s", k)
+			}
+			out[k] = v
+		}
+		return out, nil
+	}
+
+	if limits.Default.MaxSize < 0 || limits.Default.MaxRecipients < 0 {
+		return fmt.Errorf("invalid default limits")
+	}
+
+	var err error
+	if limits.Domains, err = normalize("domain", limits.Domains, false); err != nil {
+		return err
+	}
+	if limits.Accounts, err = normalize("account", limits.Accounts, true); err != nil {
+		return err
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, inboundLimitsOptionKey, limits)
+}
+
+// effective limits of a recipient address or domain, with the address or domain whose
+// override set each of them
+func (l InboundLimits) effective(target string) (limit InboundLimit, sizeOf, recipientsOf string) {
+	target = strings.ToLower(target)
+	domain := target
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		domain = target[i+1:]
+	}
+
+	levels := []struct {
+		name  string
+		limit InboundLimit
+	}{
+		{target, l.Accounts[target]},
+		{domain, l.Domains[domain]},
+		{"", l.Default},
+		{"", InboundLimit{MaxSize: DefaultInboundMaxSize, MaxRecipients: DefaultInboundMaxRecipients}},
+	}
+
+	for _, level := range levels {
+		if limit.MaxSize == 0 && level.limit.MaxSize > 0 {
+			limit.MaxSize, sizeOf = level.limit.MaxSize, level.name
+		}
+		if limit.MaxRecipients == 0 && level.limit.MaxRecipients > 0 {
+			limit.MaxRecipients, recipientsOf = level.limit.MaxRecipients, level.name
+		}
+	}
+
+	return limit, sizeOf, recipientsOf
+}
+
+// limitScope describes where a limit comes from in the rejections
+func limitScope(name string) string {
+	if name == "" {
+		return "the server"
+	}
+	return name
+}
+
+// CheckInboundLimits checks a message of size bytes (0 when unknown) with recipientCount
+// recipients against the limits of domain, a recipient domain or address. The error is
+// the SMTP rejection
+func CheckInboundLimits(ctx context.Context, domain string, recipientCount int, size int64) error {
+	return GetInboundLimits(ctx).check(domain, recipientCount, size)
+}
+
+func (l InboundLimits) check(target string, recipientCount int, size int64) error {
+	limit, sizeOf, recipientsOf := l.effective(target)
+
+	if size > limit.MaxSize {
+		return fmt.Errorf("552 5.3.4 Message size %d exceeds the limit of %d bytes of %s", size, limit.MaxSize, limitScope(sizeOf))
+	}
+
+	if recipientCount > limit.MaxRecipients {
+		return fmt.Errorf("550 5.5.3 Too many recipients (%d), the limit of %s is %d", recipientCount, limitScope(recipientsOf), limit.MaxRecipients)
+	}
+
+	return nil
+}
+
+// checkInboundLimits policy check, at RCPT for each recipient and at the end of the message
+// for the real size
+func checkInboundLimits(ctx context.Context, req PolicyRequest) string {
+	if req.Get("sasl_username") != "" {
+		return ActionDunno
+	}
+
+	switch req.Stage() {
+	case "RCPT":
+		return checkInboundRecipient(ctx, req)
+	case "END-OF-MESSAGE":
+		return checkInboundMessage(ctx, req)
+	}
+
+	return ActionDunno
+}
+
+func checkInboundRecipient(ctx context.Context, req PolicyRequest) string {
+	recipient := strings.ToLower(req.Get("recipient"))
+	if recipient == "" {
+		return ActionDunno
+	}
+
+	size, _ := strconv.ParseInt(req.Get("size"), 10, 64)
+	instance := req.Get("instance")
+	limits := GetInboundLimits(ctx)
+
+	inboundMutex.Lock()
+	defer inboundMutex.Unlock()
+
+	sweepInboundMessages()
+
+	msg := inboundMessages[instance]
+	if msg == nil {
+		msg = &inboundMessage{}
+	}
+
+	if err := limits.check(recipient, msg.recipients+1, size); err != nil {
+		g.Log().Warningf(ctx, "Rejected recipient %s from %s: %v", recipient, req.Get("client_address"), err)
+		return err.Error()
+	}
+
+	limit, sizeOf, _ := limits.effective(recipient)
+	if msg.maxSize == 0 || limit.MaxSize < msg.maxSize {
+		msg.maxSize, msg.sizeOf = limit.MaxSize, sizeOf
+	}
+	msg.recipients++
+	msg.seen = time.Now()
+
+	if instance != "" {
+		inboundMessages[instance] = msg
+	}
+
+	return ActionDunno
+}
+
+func checkInboundMessage(ctx context.Context, req PolicyRequest) string {
+	size, _ := strconv.ParseInt(req.Get("size"), 10, 64)
+	instance := req.Get("instance")
+
+	inboundMutex.Lock()
+	msg := inboundMessages[instance]
+	delete(inboundMessages, instance)
+	inboundMutex.Unlock()
+
+	// Recipients not seen at RCPT, e.g. after a restart: the lone recipient or the defaults
+	if msg == nil {
+		count, _ := strconv.Atoi(req.Get("recipient_count"))
+		if err := CheckInboundLimits(ctx, req.Get("recipient"), count, size); err != nil {
+			return err.Error()
+		}
+		return ActionDunno
+	}
+
+	if size > msg.maxSize {
+		err := fmt.Errorf("552 5.3.4 Message size %d exceeds the limit of %d bytes of %s", size, msg.maxSize, limitScope(msg.sizeOf))
+		g.Log().Warningf(ctx, "Rejected message from %s: %v", req.Get("client_address"), err)
+		return err.Error()
+	}
+
+	return ActionDunno
+}
+
+// sweepInboundMessages forgets the transactions that never ended, the caller holds the mutex
+func sweepInboundMessages() {
+	now := time.Now()
+	if now.Sub(inboundSwept) < time.Minute {
+		return
+	}
+	inboundSwept = now
+
+	for instance, msg := range inboundMessages {
+		if now.Sub(msg.seen) > inboundMessageTTL {
+			delete(inboundMessages, instance)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package smtp_policy
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+var testInboundLimits = InboundLimits{
+	Default:  InboundLimit{MaxSize: 10 << 20},
+	Domains:  map[string]InboundLimit{"example.com": {MaxSize: 5 << 20, MaxRecipients: 50}},
+	Accounts: map[string]InboundLimit{"big@example.com": {MaxSize: 20 << 20}, "list@example.com": {MaxRecipients: 500}},
+}
+
+func TestInboundLimitsEffective(t *testing.T) {
+	for _, tc := range []struct {
+		target               string
+		want                 InboundLimit
+		sizeOf, recipientsOf string
+	}{
+		{"big@example.com", InboundLimit{20 << 20, 50}, "big@example.com", "example.com"},
+		{"List@Example.com", InboundLimit{5 << 20, 500}, "example.com", "list@example.com"},
+		{"other@example.com", InboundLimit{5 << 20, 50}, "example.com", "example.com"},
+		{"example.com", InboundLimit{5 << 20, 50}, "example.com", "example.com"},
+		// The defaults, then the built-in limits
+		{"a@other.example", InboundLimit{10 << 20, DefaultInboundMaxRecipients}, "", ""},
+	} {
+		got, sizeOf, recipientsOf := testInboundLimits.effective(tc.target)
+		if got != tc.want || sizeOf != tc.sizeOf || recipientsOf != tc.recipientsOf {
+			t.Errorf("effective(%q) = %+v of %q and %q, want %+v of %q and %q",
+				tc.target, got, sizeOf, recipientsOf, tc.want, tc.sizeOf, tc.recipientsOf)
+		}
+	}
+
+	if got, _, _ := (InboundLimits{}).effective("a@example.com"); got != (InboundLimit{DefaultInboundMaxSize, DefaultInboundMaxRecipients}) {
+		t.Errorf("built-in limits = %+v", got)
+	}
+}
+
+func TestCheckInboundLimits(t *testing.T) {
+	stubOptions(t, map[string]interface{}{inboundLimitsOptionKey: testInboundLimits})
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		target     string
+		recipients int
+		size       int64
+		want       string // prefix of the rejection, empty when accepted
+	}{
+		{"a@example.com", 50, 5 << 20, ""},
+		{"a@example.com", 51, 0, "550 5.5.3 Too many recipients (51), the limit of example.com is 50"},
+		{"a@example.com", 1, 5<<20 + 1, "552 5.3.4 Message size 5242881 exceeds the limit of 5242880 bytes of example.com"},
+		{"big@example.com", 1, 20 << 20, ""},
+		{"a@other.example", 1, 10<<20 + 1, "552 5.3.4 Message size 10485761 exceeds the limit of 10485760 bytes of the server"},
+		// The size is checked first
+		{"a@example.com", 100, 6 << 20, "552 "},
+	} {
+		err := CheckInboundLimits(ctx, tc.target, tc.recipients, tc.size)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("CheckInboundLimits(%q, %d, %d) = %v", tc.target, tc.recipients, tc.size, err)
+		case tc.want != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.want)):
+			t.Errorf("CheckInboundLimits(%q, %d, %d) = %v, want %q", tc.target, tc.recipients, tc.size, err, tc.want)
+		}
+	}
+}
+
+func TestCheckInboundLimitsTransaction(t *testing.T) {
+	stubOptions(t, map[string]interface{}{inboundLimitsOptionKey: InboundLimits{
+		Domains:  map[string]InboundLimit{"example.com": {MaxRecipients: 2}},
+		Accounts: map[string]InboundLimit{"small@example.com": {MaxSize: 1000}},
+	}})
+	ctx := context.Background()
+
+	rcpt := func(instance, recipient string) PolicyRequest {
+		return PolicyRequest{"protocol_state": "RCPT", "instance": instance, "recipient": recipient, "size": "500"}
+	}
+	eom := func(instance, size string) PolicyRequest {
+		return PolicyRequest{"protocol_state": "END-OF-MESSAGE", "instance": instance, "size": size}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		req      PolicyRequest
+		rejected bool
+	}{
+		{"first recipient", rcpt("t1", "a@example.com"), false},
+		{"smaller limit", rcpt("t1", "small@example.com"), false},
+		{"over the recipient count", rcpt("t1", "c@example.com"), true},
+		// The smallest size limit of the recipients applies to the whole message
+		{"over the size of a recipient", eom("t1", "2000"), true},
+		{"other transaction", rcpt("t2", "a@example.com"), false},
+		{"within the size", eom("t2", "2000"), false},
+		// Not seen at RCPT, checked against the lone recipient
+		{"unknown transaction", PolicyRequest{"protocol_state": "END-OF-MESSAGE", "instance": "t3", "recipient": "small@example.com", "recipient_count": "1", "size": "2000"}, true},
+		{"authenticated", PolicyRequest{"protocol_state": "RCPT", "sasl_username": "a@example.com", "instance": "t1", "recipient": "d@example.com"}, false},
+	} {
+		got := checkInboundLimits(ctx, tc.req)
+		if rejected := got != ActionDunno; rejected != tc.rejected {
+			t.Errorf("%s: %q, want rejected %t", tc.name, got, tc.rejected)
+		}
+	}
+
+	// The transactions are forgotten at the end of the message
+	inboundMutex.Lock()
+	defer inboundMutex.Unlock()
+	if _, ok := inboundMessages["t1"]; ok {
+		t.Error("ended transaction still remembered")
+	}
+}
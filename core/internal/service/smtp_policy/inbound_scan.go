@@ -0,0 +1,174 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Policy of the inbound messages scanned by rspamd. The inbound policy hook of rspamd
+// queries this service at the SCAN stage, which postfix never sends, with the
+// authentication results and the spam score of the message. The DMARC policy of the
+// From domain is applied for each recipient domain and the disposition, when enabled,
+// to the whole message. The strictest decision is the one of the message as the milter
+// cannot split it: rspamd rejects it, holds it in the quarantine or tags it as spam.
+// -----------------------------
+
+// StageScan protocol state of the queries of the inbound policy hook
+const StageScan = "SCAN"
+
+// ActionTag answer of a scanned message tagged as spam, only understood by the hook
+const ActionTag = "TAG"
+
+func init() {
+	RegisterCheck("inbound_scan", checkInboundScan)
+}
+
+// checkInboundScan applies the DMARC policy and the disposition to a scanned message
+func checkInboundScan(ctx context.Context, req PolicyRequest) string {
+	if req.Stage() != StageScan {
+		return ActionDunno
+	}
+
+	action, reason, detail := inbound.DispositionAccept, "", ""
+
+	result := scannedDMARCResult(req)
+	evaluated := false
+	if result.HeaderFrom != "" {
+		if published, found := inbound.LookupDMARCPolicy(ctx, result.HeaderFrom, req.Get("header_from_org")); found {
+			evaluated = true
+
+			for _, domain := range recipientDomains(req) {
+				result.RecipientDomain = domain
+				d := inbound.ApplyDMARCPolicy(ctx, result, published)
+				if a := dmarcDisposition(d.Action); dispositionSeverity(a) > dispositionSeverity(action) {
+					action, reason, detail = a, QuarantineReasonDMARC, d.Reason
+				}
+			}
+		}
+	}
+
+	if inbound.GetDispositionConfig(ctx).Enabled {
+		// A From domain without a DMARC policy is not a DMARC failure
+		if !evaluated {
+			result = inbound.DMARCResult{SourceIP: result.SourceIP, SourceHost: result.SourceHost}
+		}
+
+		d := inbound.EvaluateInboundDisposition(ctx, req.Get("spf"), scannedDKIMResults(req), result, scannedSpamScore(req))
+		if dispositionSeverity(d.Action) > dispositionSeverity(action) {
+			action, reason, detail = d.Action, QuarantineReasonDisposition, strings.Join(d.Factors, "; ")
+		}
+	}
+
+	switch action {
+	case inbound.DispositionReject:
+		if reason == QuarantineReasonDMARC {
+			return "REJECT 5.7.1 Rejected by the DMARC policy of " + result.HeaderFrom
+		}
+		return "REJECT 5.7.1 Rejected as spam"
+	case inbound.DispositionQuarantine:
+		return holdScanned(ctx, req, reason, detail)
+	case inbound.DispositionTag:
+		return ActionTag + " " + detail
+	}
+
+	return ActionDunno
+}
+
+// scannedDMARCResult the alignment of a scanned message, as evaluated by rspamd
+func scannedDMARCResult(req PolicyRequest) inbound.DMARCResult {
+	return inbound.DMARCResult{
+		HeaderFrom:  strings.ToLower(strings.TrimSuffix(req.Get("header_from"), ".")),
+		SourceIP:    req.Get("client_address"),
+		SourceHost:  req.Get("client_name"),
+		SPFAligned:  req.Get("spf_aligned") == "true",
+		DKIMAligned: req.Get("dkim_aligned") == "true",
+	}
+}
+
+// recipientDomains the distinct domains of the recipients of a scanned message
+func recipientDomains(req PolicyRequest) []string {
+	domains := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, rcpt := range strings.Split(req.Get("recipients"), ",") {
+		_, domain, ok := strings.Cut(strings.TrimSpace(rcpt), "@")
+		domain = strings.ToLower(domain)
+		if !ok || domain == "" || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+
+	return domains
+}
+
+// scannedDKIMResults the DKIM results of a scanned message, "domain:result" pairs
+func scannedDKIMResults(req PolicyRequest) []inbound.DKIMResult {
+	results := make([]inbound.DKIMResult, 0)
+
+	for _, pair := range strings.Split(req.Get("dkim"), ",") {
+		domain, result, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || domain == "" {
+			continue
+		}
+		results = append(results, inbound.DKIMResult{Domain: strings.ToLower(domain), Result: result})
+	}
+
+	return results
+}
+
+// scannedSpamScore the score of rspamd, nil when missing
+func scannedSpamScore(req PolicyRequest) *float64 {
+	score, err := strconv.ParseFloat(req.Get("spam_score"), 64)
+	if err != nil {
+		return nil
+	}
+	return &score
+}
+
+// dmarcDisposition the disposition of a DMARC decision
+func dmarcDisposition(action inbound.DMARCAction) inbound.DispositionAction {
+	switch action {
+	case inbound.DMARCActionReject:
+		return inbound.DispositionReject
+	case inbound.DMARCActionQuarantine:
+		return inbound.DispositionQuarantine
+	}
+	return inbound.DispositionAccept
+}
+
+func dispositionSeverity(action inbound.DispositionAction) int {
+	switch action {
+	case inbound.DispositionReject:
+		return 3
+	case inbound.DispositionQuarantine:
+		return 2
+	case inbound.DispositionTag:
+		return 1
+	}
+	return 0
+}
+
+// holdScanned records a scanned message held by rspamd, the milter puts it in the hold
+// queue on the HOLD answer
+func holdScanned(ctx context.Context, req PolicyRequest, reason, detail string) string {
+	err := recordQuarantine(ctx, QuarantinedMessage{
+		QueueId:       req.Get("queue_id"),
+		Sender:        strings.ToLower(req.Get("sender")),
+		Recipients:    strings.ToLower(req.Get("recipients")),
+		ClientAddress: req.Get("client_address"),
+		Reason:        reason,
+		Detail:        detail,
+	})
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to record the quarantined message %s: %v", req.Get("queue_id"), err)
+	}
+
+	return "HOLD " + detail
+}
@@ -0,0 +1,88 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/inbound"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRecipientDomains(t *testing.T) {
+	req := PolicyRequest{"recipients": "a@Example.com, b@example.com,c@other.example,invalid,"}
+
+	want := []string{"example.com", "other.example"}
+	if got := recipientDomains(req); !reflect.DeepEqual(got, want) {
+		t.Errorf("recipientDomains = %v, want %v", got, want)
+	}
+}
+
+func TestScannedDMARCResult(t *testing.T) {
+	req := PolicyRequest{
+		"header_from":    "News.Example.com.",
+		"client_address": "192.0.2.1",
+		"client_name":    "mx.example.com",
+		"spf_aligned":    "false",
+		"dkim_aligned":   "true",
+	}
+
+	r := scannedDMARCResult(req)
+	if r.HeaderFrom != "news.example.com" || r.SourceIP != "192.0.2.1" || r.SourceHost != "mx.example.com" {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r.SPFAligned || !r.DKIMAligned || !r.Pass() {
+		t.Errorf("unexpected alignment %+v", r)
+	}
+}
+
+func TestCheckInboundScanStage(t *testing.T) {
+	// Only the queries of the rspamd hook are scanned
+	req := PolicyRequest{"protocol_state": "RCPT", "header_from": "example.com"}
+	if got := checkInboundScan(context.Background(), req); got != ActionDunno {
+		t.Errorf("checkInboundScan at RCPT = %q, want %q", got, ActionDunno)
+	}
+}
+
+func TestScannedDKIMResults(t *testing.T) {
+	req := PolicyRequest{"dkim": "Example.com:pass, forwarder.example:fail,:pass,broken"}
+
+	want := []inbound.DKIMResult{
+		{Domain: "example.com", Result: "pass"},
+		{Domain: "forwarder.example", Result: "fail"},
+	}
+	if got := scannedDKIMResults(req); !reflect.DeepEqual(got, want) {
+		t.Errorf("scannedDKIMResults = %+v, want %+v", got, want)
+	}
+}
+
+func TestScannedSpamScore(t *testing.T) {
+	if s := scannedSpamScore(PolicyRequest{"spam_score": "7.50"}); s == nil || *s != 7.5 {
+		t.Errorf("scannedSpamScore = %v, want 7.5", s)
+	}
+	if s := scannedSpamScore(PolicyRequest{}); s != nil {
+		t.Errorf("missing score = %v, want nil", *s)
+	}
+}
+
+func TestDispositionSeverity(t *testing.T) {
+	order := []inbound.DispositionAction{
+		inbound.DispositionAccept,
+		inbound.DispositionTag,
+		inbound.DispositionQuarantine,
+		inbound.DispositionReject,
+	}
+	for i := 1; i < len(order); i++ {
+		if dispositionSeverity(order[i]) <= dispositionSeverity(order[i-1]) {
+			t.Errorf("%s not stricter than %s", order[i], order[i-1])
+		}
+	}
+
+	for action, want := range map[inbound.DMARCAction]inbound.DispositionAction{
+		inbound.DMARCActionAccept:     inbound.DispositionAccept,
+		inbound.DMARCActionQuarantine: inbound.DispositionQuarantine,
+		inbound.DMARCActionReject:     inbound.DispositionReject,
+	} {
+		if got := dmarcDisposition(action); got != want {
+			t.Errorf("dmarcDisposition(%s) = %s, want %s", action, got, want)
+		}
+	}
+}
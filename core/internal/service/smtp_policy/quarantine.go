@@ -0,0 +1,159 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Quarantine store: inbound messages put in the postfix hold queue by a policy check,
+// with the reason of the hold. A released message continues its delivery, a deleted
+// one is removed from the queue.
+// -----------------------------
+
+// Quarantine statuses
+const (
+	QuarantineHeld     = "held"
+	QuarantineReleased = "released"
+	QuarantineDeleted  = "deleted"
+	QuarantineReplayed = "replayed"
+)
+
+// Quarantine reasons
+const (
+	QuarantineReasonFirstContact = "first_contact"
+	QuarantineReasonDMARC        = "dmarc"
+	QuarantineReasonDisposition  = "disposition"
+)
+
+// QuarantinedMessage a held message
+type QuarantinedMessage struct {
+	Id            int64  `json:"id"`
+	QueueId       string `json:"queue_id"`
+	Sender        string `json:"sender"`
+	Recipients    string `json:"recipients"` // comma separated
+	ClientAddress string `json:"client_address"`
+	Reason        string `json:"reason"`
+	Detail        string `json:"detail"`
+	Status        string `json:"status"`
+	CreateTime    int64  `json:"create_time"`
+	UpdateTime    int64  `json:"update_time"`
+}
+
+// quarantineMessage records a message held by a policy check
+func quarantineMessage(ctx context.Context, msg QuarantinedMessage) error {
+	now := time.Now().Unix()
+
+	_, err := g.DB().Model("bm_quarantine").Ctx(ctx).Data(g.Map{
+		"queue_id":       msg.QueueId,
+		"sender":         msg.Sender,
+		"recipients":     msg.Recipients,
+		"client_address": msg.ClientAddress,
+		"reason":         msg.Reason,
+		"detail":         msg.Detail,
+		"status":         QuarantineHeld,
+		"create_time":    now,
+		"update_time":    now,
+	}).Insert()
+	return err
+}
+
+// ListQuarantine the quarantined messages with a status, all when empty, newest first
+func ListQuarantine(ctx context.Context, status string) ([]QuarantinedMessage, error) {
+	model := g.DB().Model("bm_quarantine").Ctx(ctx)
+	if status != "" {
+		model = model.Where("status", status)
+	}
+
+	var list []QuarantinedMessage
+	err := model.OrderDesc("id").Scan(&list)
+	return list, err
+}
+
+// ReleaseQuarantined releases a held message from the hold queue. A message held as a
+// first contact makes its sender trusted by its recipients
+func ReleaseQuarantined(ctx context.Context, id int64) error {
+	msg, err := heldMessage(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err = postsuper(ctx, "-H", msg.QueueId); err != nil {
+		return err
+	}
+
+	if msg.Reason == QuarantineReasonFirstContact {
+		for _, recipient := range strings.Split(msg.Recipients, ",") {
+			if err := TrustSender(ctx, msg.Sender, recipient); err != nil {
+				g.Log().Warningf(ctx, "Failed to trust sender %s for %s: %v", msg.Sender, recipient, err)
+			}
+		}
+	}
+
+	return setQuarantineStatus(ctx, id, QuarantineReleased)
+}
+
+// DeleteQuarantined removes a held message from the queue
+func DeleteQuarantined(ctx context.Context, id int64) error {
+	msg, err := heldMessage(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err = postsuper(ctx, "-d", msg.QueueId); err != nil {
+		return err
+	}
+
+	return setQuarantineStatus(ctx, id, QuarantineDeleted)
+}
+
+func heldMessage(ctx context.Context, id int64) (*QuarantinedMessage, error) {
+	var msg *QuarantinedMessage
+	if err := g.DB().Model("bm_quarantine").Ctx(ctx).Where("id", id).Scan(&msg); err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("quarantined message %d not found", id)
+	}
+	if msg.Status != QuarantineHeld {
+		return nil, fmt.Errorf("quarantined message %d is already %s", id, msg.Status)
+	}
+	return msg, nil
+}
+
+func setQuarantineStatus(ctx context.Context, id int64, status string) error {
+	_, err := g.DB().Model("bm_quarantine").Ctx(ctx).
+		Where("id", id).
+		Data(g.Map{"status": status, "update_time": time.Now().Unix()}).
+		Update()
+	return err
+}
+
+// postsuper runs postsuper on a queue file of the postfix container
+func postsuper(ctx context.Context, flag, queueId string) error {
+	if queueId == "" {
+		return fmt.Errorf("message without queue id")
+	}
+
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postsuper", flag, queueId}, "root")
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("postsuper %s %s failed: %s", flag, queueId, strings.TrimSpace(res.Output))
+	}
+
+	return nil
+}
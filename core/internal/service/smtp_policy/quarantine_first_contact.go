@@ -0,0 +1,302 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Hold of first contacts. The first message of a sender never seen by a local recipient
+// of an opted-in domain is either held in the quarantine for review, or deferred
+// for a short delay like greylisting. A released message, or a retry after the delay,
+// makes the sender trusted by the recipient, its next messages are delivered at once.
+// -----------------------------
+
+const firstContactOptionKey = "first_contact_hold"
+
+// First contact modes
+const (
+	FirstContactHold  = "hold"  // held in the quarantine until released
+	FirstContactDelay = "delay" // deferred until the delay has passed
+)
+
+const defaultFirstContactDelay = 5 * time.Minute
+
+// FirstContactConfig opt-in domains and trusted senders
+type FirstContactConfig struct {
+	Domains      []string `json:"domains"`       // recipient domains the hold applies to
+	Whitelist    []string `json:"whitelist"`     // sender addresses, or @domain for a whole domain
+	Mode         string   `json:"mode"`          // hold (default) or delay
+	DelaySeconds int      `json:"delay_seconds"` // delay mode, 300 when unset
+}
+
+// firstContactMessage first contact recipients of one transaction in hold mode
+type firstContactMessage struct {
+	recipients []string
+	seen       time.Time
+}
+
+// Records of the contacts and the quarantine, replaced in the tests
+var (
+	isFirstContact   = IsFirstContact
+	recordContact    = seeContact
+	trustContact     = TrustSender
+	recordQuarantine = quarantineMessage
+)
+
+var (
+	firstContactMutex    sync.Mutex
+	firstContactMessages = make(map[string]*firstContactMessage)
+	firstContactSwept    time.Time
+)
+
+func init() {
+	// Registered after the inbound limits, a message they reject is not held
+	RegisterCheck("first_contact", checkFirstContact)
+}
+
+// GetFirstContactConfig returns the configured settings, no domain opted in when unset
+func GetFirstContactConfig(ctx context.Context) FirstContactConfig {
+	cfg := FirstContactConfig{}
+	_ = getOption(ctx, firstContactOptionKey, &cfg)
+	return cfg
+}
+
+// SetFirstContactConfig validates and saves the settings
+func SetFirstContactConfig(ctx context.Context, cfg FirstContactConfig) error {
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = FirstContactHold
+	case FirstContactHold, FirstContactDelay:
+	default:
+		return fmt.Errorf("invalid first contact mode %q", cfg.Mode)
+	}
+
+	if cfg.DelaySeconds < 0 {
+		return fmt.Errorf("invalid first contact delay %d", cfg.DelaySeconds)
+	}
+
+	for i, domain := range cfg.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || strings.Contains(domain, "@") {
+			return fmt.Errorf("invalid domain %q", domain)
+		}
+		cfg.Domains[i] = domain
+	}
+
+	for i, sender := range cfg.Whitelist {
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if !strings.Contains(sender, "@") {
+			return fmt.Errorf("invalid whitelisted sender %q", sender)
+		}
+		cfg.Whitelist[i] = sender
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, firstContactOptionKey, cfg)
+}
+
+// applies reports whether a message from sender to recipient is subject to the hold
+func (c FirstContactConfig) applies(sender, recipient string) bool {
+	_, recipientDomain, ok := strings.Cut(recipient, "@")
+	if !ok || sender == "" {
+		return false
+	}
+
+	optedIn := false
+	for _, domain := range c.Domains {
+		if domain == recipientDomain {
+			optedIn = true
+			break
+		}
+	}
+	if !optedIn {
+		return false
+	}
+
+	i := strings.LastIndex(sender, "@")
+	for _, trusted := range c.Whitelist {
+		if trusted == sender || (i >= 0 && trusted == sender[i:]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c FirstContactConfig) delay() time.Duration {
+	if c.DelaySeconds > 0 {
+		return time.Duration(c.DelaySeconds) * time.Second
+	}
+	return defaultFirstContactDelay
+}
+
+// IsFirstContact reports whether recipient never accepted a message from sender
+func IsFirstContact(ctx context.Context, sender, recipient string) (bool, error) {
+	count, err := g.DB().Model("bm_first_contacts").Ctx(ctx).
+		Where("sender", strings.ToLower(sender)).
+		Where("recipient", strings.ToLower(recipient)).
+		Where("trusted", true).
+		Count()
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// TrustSender records that recipient accepts the messages of sender
+func TrustSender(ctx context.Context, sender, recipient string) error {
+	now := time.Now().Unix()
+	_, err := g.DB().Exec(ctx, `INSERT INTO bm_first_contacts (sender, recipient, trusted, first_seen, last_seen)
+		VALUES (?, ?, TRUE, ?, ?)
+		ON CONFLICT (sender, recipient) DO UPDATE SET trusted = TRUE, last_seen = EXCLUDED.last_seen`,
+		strings.ToLower(sender), strings.ToLower(strings.TrimSpace(recipient)), now, now)
+	return err
+}
+
+// seeContact records a message of sender to recipient, returns when the sender was first seen
+func seeContact(ctx context.Context, sender, recipient string) (time.Time, error) {
+	now := time.Now().Unix()
+	row, err := g.DB().GetOne(ctx, `INSERT INTO bm_first_contacts (sender, recipient, first_seen, last_seen)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (sender, recipient) DO UPDATE SET last_seen = EXCLUDED.last_seen
+		RETURNING first_seen`,
+		sender, recipient, now, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(row["first_seen"].Int64(), 0), nil
+}
+
+// checkFirstContact policy check of unauthenticated mail, at RCPT in delay mode and to
+// collect the recipients in hold mode, at the end of the message to hold it
+func checkFirstContact(ctx context.Context, req PolicyRequest) string {
+	if req.Get("sasl_username") != "" {
+		return ActionDunno
+	}
+
+	switch req.Stage() {
+	case "RCPT":
+		return checkFirstContactRecipient(ctx, req)
+	case "END-OF-MESSAGE":
+		return holdFirstContact(ctx, req)
+	}
+
+	return ActionDunno
+}
+
+func checkFirstContactRecipient(ctx context.Context, req PolicyRequest) string {
+	sender := strings.ToLower(req.Get("sender"))
+	recipient := strings.ToLower(req.Get("recipient"))
+
+	cfg := GetFirstContactConfig(ctx)
+	if !cfg.applies(sender, recipient) {
+		return ActionDunno
+	}
+
+	first, err := isFirstContact(ctx, sender, recipient)
+	if err != nil {
+		g.Log().Warningf(ctx, "Failed to look up the first contact of %s to %s: %v", sender, recipient, err)
+		return ActionDunno
+	}
+	if !first {
+		return ActionDunno
+	}
+
+	if cfg.Mode == FirstContactDelay {
+		firstSeen, err := recordContact(ctx, sender, recipient)
+		if err != nil {
+			g.Log().Warningf(ctx, "Failed to record the first contact of %s to %s: %v", sender, recipient, err)
+			return ActionDunno
+		}
+
+		if time.Since(firstSeen) < cfg.delay() {
+			return "DEFER_IF_PERMIT 4.7.1 First message from this sender, please try again later"
+		}
+
+		if err := trustContact(ctx, sender, recipient); err != nil {
+			g.Log().Warningf(ctx, "Failed to trust sender %s for %s: %v", sender, recipient, err)
+		}
+		return ActionDunno
+	}
+
+	instance := req.Get("instance")
+	if instance == "" {
+		return ActionDunno
+	}
+
+	firstContactMutex.Lock()
+	defer firstContactMutex.Unlock()
+
+	sweepFirstContactMessages()
+
+	msg := firstContactMessages[instance]
+	if msg == nil {
+		msg = &firstContactMessage{}
+		firstContactMessages[instance] = msg
+	}
+	msg.recipients = append(msg.recipients, recipient)
+	msg.seen = time.Now()
+
+	return ActionDunno
+}
+
+// holdFirstContact holds a message with first contact recipients and records it in the quarantine
+func holdFirstContact(ctx context.Context, req PolicyRequest) string {
+	instance := req.Get("instance")
+
+	firstContactMutex.Lock()
+	msg := firstContactMessages[instance]
+	delete(firstContactMessages, instance)
+	firstContactMutex.Unlock()
+
+	if msg == nil || len(msg.recipients) == 0 {
+		return ActionDunno
+	}
+
+	sender := strings.ToLower(req.Get("sender"))
+	for _, recipient := range msg.recipients {
+		if _, err := recordContact(ctx, sender, recipient); err != nil {
+			g.Log().Warningf(ctx, "Failed to record the first contact of %s to %s: %v", sender, recipient, err)
+		}
+	}
+
+	sort.Strings(msg.recipients)
+	err := recordQuarantine(ctx, QuarantinedMessage{
+		QueueId:       req.Get("queue_id"),
+		Sender:        sender,
+		Recipients:    strings.Join(msg.recipients, ","),
+		ClientAddress: req.Get("client_address"),
+		Reason:        QuarantineReasonFirstContact,
+		Detail:        fmt.Sprintf("first message of %s to %s", sender, strings.Join(msg.recipients, ", ")),
+	})
+	if err != nil {
+		// Without a quarantine record nobody could release it
+		g.Log().Warningf(ctx, "Failed to quarantine the first contact of %s: %v", sender, err)
+		return ActionDunno
+	}
+
+	g.Log().Infof(ctx, "Held the first message of %s to %s", sender, strings.Join(msg.recipients, ", "))
+	return "HOLD first contact of " + sender
+}
+
+// sweepFirstContactMessages forgets the transactions that never ended, the caller holds the mutex
+func sweepFirstContactMessages() {
+	now := time.Now()
+	if now.Sub(firstContactSwept) < time.Minute {
+		return
+	}
+	firstContactSwept = now
+
+	for instance, msg := range firstContactMessages {
+		if now.Sub(msg.seen) > inboundMessageTTL {
+			delete(firstContactMessages, instance)
+		}
+	}
+}
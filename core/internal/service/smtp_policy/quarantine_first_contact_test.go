@@ -0,0 +1,154 @@
+package smtp_policy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeContacts the contacts table and the quarantine of the first contact checks
+type fakeContacts struct {
+	firstSeen   map[string]time.Time // by sender and recipient
+	trusted     map[string]bool
+	quarantined []QuarantinedMessage
+}
+
+func stubContacts(t *testing.T) *fakeContacts {
+	t.Helper()
+
+	f := &fakeContacts{firstSeen: make(map[string]time.Time), trusted: make(map[string]bool)}
+
+	origFirst, origRecord, origTrust, origQuarantine := isFirstContact, recordContact, trustContact, recordQuarantine
+	isFirstContact = func(ctx context.Context, sender, recipient string) (bool, error) {
+		return !f.trusted[sender+" "+recipient], nil
+	}
+	recordContact = func(ctx context.Context, sender, recipient string) (time.Time, error) {
+		if _, ok := f.firstSeen[sender+" "+recipient]; !ok {
+			f.firstSeen[sender+" "+recipient] = time.Now()
+		}
+		return f.firstSeen[sender+" "+recipient], nil
+	}
+	trustContact = func(ctx context.Context, sender, recipient string) error {
+		f.trusted[sender+" "+recipient] = true
+		return nil
+	}
+	recordQuarantine = func(ctx context.Context, msg QuarantinedMessage) error {
+		f.quarantined = append(f.quarantined, msg)
+		return nil
+	}
+	t.Cleanup(func() {
+		isFirstContact, recordContact, trustContact, recordQuarantine = origFirst, origRecord, origTrust, origQuarantine
+	})
+
+	return f
+}
+
+func TestFirstContactApplies(t *testing.T) {
+	cfg := FirstContactConfig{
+		Domains:   []string{"example.com"},
+		Whitelist: []string{"boss@partner.example", "@trusted.example"},
+	}
+
+	for _, tc := range []struct {
+		sender, recipient string
+		applies           bool
+	}{
+		{"a@stranger.example", "me@example.com", true},
+		{"a@stranger.example", "me@other.example", false},
+		{"", "me@example.com", false},
+		{"a@stranger.example", "me", false},
+		{"boss@partner.example", "me@example.com", false},
+		{"intern@partner.example", "me@example.com", true},
+		{"anyone@trusted.example", "me@example.com", false},
+		{"a@sub.trusted.example", "me@example.com", true},
+	} {
+		if got := cfg.applies(tc.sender, tc.recipient); got != tc.applies {
+			t.Errorf("applies(%q, %q) = %t, want %t", tc.sender, tc.recipient, got, tc.applies)
+		}
+	}
+
+	if d := (FirstContactConfig{}).delay(); d != defaultFirstContactDelay {
+		t.Errorf("default delay = %s", d)
+	}
+	if d := (FirstContactConfig{DelaySeconds: 60}).delay(); d != time.Minute {
+		t.Errorf("delay = %s", d)
+	}
+}
+
+func TestFirstContactDelay(t *testing.T) {
+	stubOptions(t, map[string]interface{}{firstContactOptionKey: FirstContactConfig{
+		Domains:      []string{"example.com"},
+		Mode:         FirstContactDelay,
+		DelaySeconds: 60,
+	}})
+	contacts := stubContacts(t)
+	ctx := context.Background()
+
+	req := PolicyRequest{"protocol_state": "RCPT", "sender": "A@stranger.example", "recipient": "me@example.com"}
+
+	if got := checkFirstContact(ctx, req); !strings.HasPrefix(got, "DEFER_IF_PERMIT 4.7.1 ") {
+		t.Fatalf("first message = %q, want deferred", got)
+	}
+	if got := checkFirstContact(ctx, req); !strings.HasPrefix(got, "DEFER_IF_PERMIT ") {
+		t.Errorf("retry within the delay = %q, want deferred", got)
+	}
+
+	// A retry after the delay is accepted and trusts the sender
+	contacts.firstSeen["a@stranger.example me@example.com"] = time.Now().Add(-2 * time.Minute)
+	if got := checkFirstContact(ctx, req); got != ActionDunno {
+		t.Errorf("retry after the delay = %q", got)
+	}
+	if !contacts.trusted["a@stranger.example me@example.com"] {
+		t.Error("sender not trusted after the delay")
+	}
+	if first, _ := isFirstContact(ctx, "a@stranger.example", "me@example.com"); first {
+		t.Error("trusted sender still a first contact")
+	}
+
+	// A recipient whose domain is not opted in
+	other := PolicyRequest{"protocol_state": "RCPT", "sender": "a@stranger.example", "recipient": "me@other.example"}
+	if got := checkFirstContact(ctx, other); got != ActionDunno {
+		t.Errorf("recipient not opted in = %q", got)
+	}
+}
+
+func TestFirstContactHold(t *testing.T) {
+	stubOptions(t, map[string]interface{}{firstContactOptionKey: FirstContactConfig{Domains: []string{"example.com"}}})
+	contacts := stubContacts(t)
+	contacts.trusted["a@stranger.example known@example.com"] = true
+	ctx := context.Background()
+
+	for _, recipient := range []string{"me@example.com", "known@example.com", "you@example.com"} {
+		req := PolicyRequest{"protocol_state": "RCPT", "instance": "h1", "sender": "a@stranger.example", "recipient": recipient}
+		if got := checkFirstContact(ctx, req); got != ActionDunno {
+			t.Errorf("RCPT %s = %q, the hold answers at the end of the message", recipient, got)
+		}
+	}
+
+	eom := PolicyRequest{"protocol_state": "END-OF-MESSAGE", "instance": "h1", "queue_id": "ABC123", "sender": "a@stranger.example"}
+	if got := checkFirstContact(ctx, eom); got != "HOLD first contact of a@stranger.example" {
+		t.Errorf("end of message = %q, want held", got)
+	}
+
+	if len(contacts.quarantined) != 1 {
+		t.Fatalf("quarantined %+v", contacts.quarantined)
+	}
+	msg := contacts.quarantined[0]
+	if msg.QueueId != "ABC123" || msg.Reason != QuarantineReasonFirstContact || msg.Recipients != "me@example.com,you@example.com" {
+		t.Errorf("quarantined %+v", msg)
+	}
+
+	// Once ended the transaction is not held again
+	if got := checkFirstContact(ctx, eom); got != ActionDunno {
+		t.Errorf("end of message again = %q", got)
+	}
+
+	// Authenticated submissions are never held
+	auth := PolicyRequest{"protocol_state": "RCPT", "instance": "h2", "sasl_username": "u@example.com", "sender": "u@example.com", "recipient": "me@example.com"}
+	checkFirstContact(ctx, auth)
+	auth["protocol_state"] = "END-OF-MESSAGE"
+	if got := checkFirstContact(ctx, auth); got != ActionDunno {
+		t.Errorf("authenticated submission = %q", got)
+	}
+}
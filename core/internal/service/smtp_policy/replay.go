@@ -0,0 +1,229 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/consts"
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Replay of quarantined messages. The raw message is read from the hold queue and
+// submitted again to the local postfix over SMTP, so it goes through the normal
+// pipeline as a new message, then the held copy is removed. With SkipFilters the policy
+// checks let the resubmission through, it is not held again by the check that caught
+// it the first time.
+// -----------------------------
+
+const (
+	replayAddr      = "localhost:25"
+	replayBypassTTL = 5 * time.Minute
+)
+
+// ReplayOptions settings of a replay
+type ReplayOptions struct {
+	SkipFilters bool `json:"skip_filters"` // bypass the policy checks for the resubmission
+}
+
+// ReplayFilter selection of the held messages of a batch replay, the empty fields match all
+type ReplayFilter struct {
+	Reason    string `json:"reason"`
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Since     int64  `json:"since"` // held at or after, unix time
+	Until     int64  `json:"until"` // held before, unix time
+}
+
+// ReplayResult outcome of a batch replay
+type ReplayResult struct {
+	Replayed []int64          `json:"replayed"`
+	Failed   map[int64]string `json:"failed"`
+}
+
+// replayBypass a resubmission let through the policy checks
+type replayBypass struct {
+	recipients map[string]bool
+	expires    time.Time
+}
+
+var (
+	replayMutex    sync.Mutex
+	replayBypasses = make(map[string]*replayBypass) // by sender
+)
+
+// ReplayMessage submits a held message again through the delivery path and removes the
+// held copy. The replay is recorded in the operation log
+func ReplayMessage(ctx context.Context, id int64, opts ReplayOptions) error {
+	msg, err := heldMessage(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	raw, err := inbound.QueuedMessage(ctx, msg.QueueId)
+	if err != nil {
+		return fmt.Errorf("read held message %s: %w", msg.QueueId, err)
+	}
+
+	recipients := strings.Split(msg.Recipients, ",")
+
+	if opts.SkipFilters {
+		allowReplay(msg.Sender, recipients)
+		defer forgetReplay(msg.Sender)
+	}
+
+	if err = submitRaw(ctx, msg.Sender, recipients, raw); err != nil {
+		return fmt.Errorf("resubmit held message %s: %w", msg.QueueId, err)
+	}
+
+	// Delivered again as a new message, the held copy must not be delivered as well
+	if err = postsuper(ctx, "-d", msg.QueueId); err != nil {
+		g.Log().Warningf(ctx, "Failed to remove the replayed message %s from the hold queue: %v", msg.QueueId, err)
+	}
+
+	if err = setQuarantineStatus(ctx, id, QuarantineReplayed); err != nil {
+		return err
+	}
+
+	_ = public.WriteLog(ctx, public.LogParams{
+		Type: consts.LOGTYPE.PostfixQueue,
+		Log:  fmt.Sprintf("Replayed quarantined message %s from %s to %s (skip filters: %t)", msg.QueueId, msg.Sender, msg.Recipients, opts.SkipFilters),
+	})
+
+	return nil
+}
+
+// ReplayQuarantined replays every held message matching the filter, oldest first
+func ReplayQuarantined(ctx context.Context, filter ReplayFilter, opts ReplayOptions) (ReplayResult, error) {
+	result := ReplayResult{Replayed: make([]int64, 0), Failed: make(map[int64]string)}
+
+	model := g.DB().Model("bm_quarantine").Ctx(ctx).Fields("id").Where("status", QuarantineHeld)
+	if filter.Reason != "" {
+		model = model.Where("reason", filter.Reason)
+	}
+	if filter.Sender != "" {
+		model = model.Where("sender", strings.ToLower(filter.Sender))
+	}
+	if filter.Recipient != "" {
+		model = model.Where("(',' || recipients || ',') LIKE ?", "%,"+strings.ToLower(filter.Recipient)+",%")
+	}
+	if filter.Since > 0 {
+		model = model.WhereGTE("create_time", filter.Since)
+	}
+	if filter.Until > 0 {
+		model = model.WhereLT("create_time", filter.Until)
+	}
+
+	ids, err := model.OrderAsc("id").Array()
+	if err != nil {
+		return result, err
+	}
+
+	for _, v := range ids {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		id := v.Int64()
+		if err := ReplayMessage(ctx, id, opts); err != nil {
+			result.Failed[id] = err.Error()
+			continue
+		}
+		result.Replayed = append(result.Replayed, id)
+	}
+
+	return result, nil
+}
+
+// submitRaw sends a raw message to the local postfix, unchanged
+func submitRaw(ctx context.Context, sender string, recipients []string, raw []byte) error {
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", replayAddr)
+	if err != nil {
+		return err
+	}
+
+	host, _, _ := net.SplitHostPort(replayAddr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if err = client.Mail(sender); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err = client.Rcpt(strings.TrimSpace(recipient)); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(raw); err != nil {
+		w.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// allowReplay lets the next transaction of sender to recipients through the policy checks
+func allowReplay(sender string, recipients []string) {
+	bypass := &replayBypass{recipients: make(map[string]bool, len(recipients)), expires: time.Now().Add(replayBypassTTL)}
+	for _, recipient := range recipients {
+		bypass.recipients[strings.ToLower(strings.TrimSpace(recipient))] = true
+	}
+
+	replayMutex.Lock()
+	replayBypasses[strings.ToLower(sender)] = bypass
+	replayMutex.Unlock()
+}
+
+func forgetReplay(sender string) {
+	replayMutex.Lock()
+	delete(replayBypasses, strings.ToLower(sender))
+	replayMutex.Unlock()
+}
+
+// replayBypassed reports whether the request belongs to a replay skipping the filters,
+// only resubmissions from the local host qualify
+func replayBypassed(req PolicyRequest) bool {
+	if ip := net.ParseIP(req.Get("client_address")); ip == nil || !ip.IsLoopback() {
+		return false
+	}
+
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+
+	if len(replayBypasses) == 0 {
+		return false
+	}
+
+	sender := strings.ToLower(req.Get("sender"))
+	bypass := replayBypasses[sender]
+	if bypass == nil {
+		return false
+	}
+	if time.Now().After(bypass.expires) {
+		delete(replayBypasses, sender)
+		return false
+	}
+
+	recipient := strings.ToLower(req.Get("recipient"))
+	return recipient == "" || bypass.recipients[recipient]
+}
@@ -0,0 +1,60 @@
+package smtp_policy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplayBypassed(t *testing.T) {
+	allowReplay("Held@Stranger.example", []string{"me@example.com", " You@example.com"})
+	t.Cleanup(func() { forgetReplay("held@stranger.example") })
+
+	for _, tc := range []struct {
+		name     string
+		req      PolicyRequest
+		bypassed bool
+	}{
+		{"replayed recipient", PolicyRequest{"client_address": "127.0.0.1", "sender": "held@stranger.example", "recipient": "me@example.com"}, true},
+		{"case of the recipient", PolicyRequest{"client_address": "::1", "sender": "held@stranger.example", "recipient": "you@Example.com"}, true},
+		{"end of message", PolicyRequest{"client_address": "127.0.0.1", "sender": "held@stranger.example"}, true},
+		{"other recipient", PolicyRequest{"client_address": "127.0.0.1", "sender": "held@stranger.example", "recipient": "boss@example.com"}, false},
+		{"other sender", PolicyRequest{"client_address": "127.0.0.1", "sender": "other@stranger.example", "recipient": "me@example.com"}, false},
+		// Only the resubmissions of the local host
+		{"remote client", PolicyRequest{"client_address": "192.0.2.1", "sender": "held@stranger.example", "recipient": "me@example.com"}, false},
+		{"no client", PolicyRequest{"sender": "held@stranger.example", "recipient": "me@example.com"}, false},
+	} {
+		if got := replayBypassed(tc.req); got != tc.bypassed {
+			t.Errorf("%s: bypassed %t, want %t", tc.name, got, tc.bypassed)
+		}
+	}
+
+	// The bypass skips every check
+	req := PolicyRequest{"protocol_state": "RCPT", "client_address": "127.0.0.1", "sender": "held@stranger.example", "recipient": "me@example.com"}
+	if got := Evaluate(context.Background(), req); got != ActionDunno {
+		t.Errorf("Evaluate of a replay = %q", got)
+	}
+
+	forgetReplay("held@stranger.example")
+	if replayBypassed(PolicyRequest{"client_address": "127.0.0.1", "sender": "held@stranger.example", "recipient": "me@example.com"}) {
+		t.Error("bypass still active once the replay is done")
+	}
+}
+
+func TestReplayBypassExpires(t *testing.T) {
+	allowReplay("late@stranger.example", []string{"me@example.com"})
+
+	replayMutex.Lock()
+	replayBypasses["late@stranger.example"].expires = time.Now().Add(-time.Second)
+	replayMutex.Unlock()
+
+	if replayBypassed(PolicyRequest{"client_address": "127.0.0.1", "sender": "late@stranger.example", "recipient": "me@example.com"}) {
+		t.Error("expired bypass applied")
+	}
+
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+	if _, ok := replayBypasses["late@stranger.example"]; ok {
+		t.Error("expired bypass not removed")
+	}
+}
@@ -0,0 +1,148 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/mail_service"
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Sending identity authorization, an authenticated user may only use its own
+// address, the aliases delivering to it, or identities delegated to it.
+// Delegations are addresses or "@domain" wildcards. The VERP return path of a
+// campaign is accepted as envelope sender of its addresser.
+// -----------------------------
+
+const senderDelegationsOptionKey = "sender_delegations"
+
+// Lookups of AuthorizeSender, replaced in the tests
+var (
+	decodeReturnPath = mail_service.VERPDecode
+	lookupCampaign   = campaignOf
+	lookupAlias      = aliasDeliversTo
+)
+
+func init() {
+	RegisterCheck("sender_identity", checkSenderIdentity)
+}
+
+// GetSenderDelegations returns the delegated identities of every user
+func GetSenderDelegations(ctx context.Context) map[string][]string {
+	delegations := make(map[string][]string)
+	_ = getOption(ctx, senderDelegationsOptionKey, &delegations)
+	return delegations
+}
+
+// SetSenderDelegations replaces the identities delegated to a user, an empty list removes them
+func SetSenderDelegations(ctx context.Context, user string, senders []string) error {
+	user = strings.ToLower(strings.TrimSpace(user))
+	if user == "" {
+		return fmt.Errorf("empty user")
+	}
+
+	list := make([]string, 0, len(senders))
+	for _, s := range senders {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "@") {
+			return fmt.Errorf("invalid delegated sender %q, expected an address or @domain", s)
+		}
+		list = append(list, s)
+	}
+
+	delegations := GetSenderDelegations(ctx)
+	if len(list) == 0 {
+		delete(delegations, user)
+	} else {
+		delegations[user] = list
+	}
+
+	return public.OptionsMgrInstance.SetOption(ctx, senderDelegationsOptionKey, delegations)
+}
+
+// AuthorizeSender checks that the authenticated user may send as from
+func AuthorizeSender(ctx context.Context, authUser, from string) error {
+	authUser = strings.ToLower(strings.TrimSpace(authUser))
+	from = strings.ToLower(strings.Trim(strings.TrimSpace(from), "<>"))
+
+	// Null sender of bounces and the user's own address
+	if from == "" || from == authUser {
+		return nil
+	}
+
+	if senderDelegated(from, GetSenderDelegations(ctx)[authUser]) {
+		return nil
+	}
+
+	// The return path of a campaign message of the user, signed by this server
+	if taskId, _, err := decodeReturnPath(ctx, from); err == nil && lookupCampaign(ctx, taskId, authUser) {
+		return nil
+	}
+
+	if lookupAlias(ctx, from, authUser) {
+		return nil
+	}
+
+	return fmt.Errorf("553 5.7.1 Sender address %s not owned by user %s", from, authUser)
+}
+
+// senderDelegated reports whether from matches one of the delegated identities
+func senderDelegated(from string, delegated []string) bool {
+	for _, d := range delegated {
+		if d == from {
+			return true
+		}
+		if strings.HasPrefix(d, "@") && strings.HasSuffix(from, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasDeliversTo reports whether the active alias address forwards to user
+func aliasDeliversTo(ctx context.Context, address, user string) bool {
+	val, err := g.DB().Model("alias").Where("address", address).Where("active", 1).Value("goto")
+	if err != nil || val == nil {
+		return false
+	}
+
+	for _, target := range strings.Split(val.String(), ",") {
+		if strings.EqualFold(strings.TrimSpace(target), user) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// campaignOf reports whether user is the addresser of the campaign taskId
+func campaignOf(ctx context.Context, taskId int, user string) bool {
+	val, err := g.DB().Model("email_tasks").Ctx(ctx).Where("id", taskId).Value("addresser")
+	return err == nil && val != nil && strings.EqualFold(strings.TrimSpace(val.String()), user)
+}
+
+// checkSenderIdentity policy check, applied to the envelope sender of authenticated sessions.
+// It is registered before the rate limit so a rejected message consumes no quota
+func checkSenderIdentity(ctx context.Context, req PolicyRequest) string {
+	if req.Stage() != "END-OF-MESSAGE" {
+		return ActionDunno
+	}
+
+	user := req.Get("sasl_username")
+	if user == "" {
+		return ActionDunno
+	}
+
+	if err := AuthorizeSender(ctx, user, req.Get("sender")); err != nil {
+		g.Log().Warningf(ctx, "Rejected spoofed sender: %v", err)
+		return err.Error()
+	}
+
+	return ActionDunno
+}
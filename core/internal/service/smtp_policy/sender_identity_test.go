@@ -0,0 +1,97 @@
+package smtp_policy
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// stubSenderLookups serves the aliases, by address, and the addressers of the campaigns,
+// by task id, of AuthorizeSender. The return paths "bounce-<id>@example.com" decode to
+// their campaign
+func stubSenderLookups(t *testing.T, aliases map[string]string, campaigns map[int]string) {
+	t.Helper()
+
+	origDecode, origCampaign, origAlias := decodeReturnPath, lookupCampaign, lookupAlias
+	decodeReturnPath = func(ctx context.Context, address string) (int, string, error) {
+		id, ok := strings.CutPrefix(strings.TrimSuffix(address, "@example.com"), "bounce-")
+		if taskId, err := strconv.Atoi(id); ok && err == nil {
+			return taskId, "r@example.org", nil
+		}
+		return 0, "", errors.New("not a return path")
+	}
+	lookupCampaign = func(ctx context.Context, taskId int, user string) bool {
+		return strings.EqualFold(campaigns[taskId], user)
+	}
+	lookupAlias = func(ctx context.Context, address, user string) bool {
+		return strings.EqualFold(aliases[address], user)
+	}
+	t.Cleanup(func() { decodeReturnPath, lookupCampaign, lookupAlias = origDecode, origCampaign, origAlias })
+}
+
+func TestAuthorizeSender(t *testing.T) {
+	stubOptions(t, map[string]interface{}{
+		senderDelegationsOptionKey: map[string][]string{
+			"alice@example.com": {"team@example.com", "@shop.example.com"},
+		},
+	})
+	stubSenderLookups(t,
+		map[string]string{"info@example.com": "alice@example.com"},
+		map[int]string{7: "alice@example.com", 8: "bob@example.com"})
+
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		user, from string
+		allowed    bool
+	}{
+		{"alice@example.com", "", true},
+		{"alice@example.com", "<>", true},
+		{"alice@example.com", "alice@example.com", true},
+		{"Alice@Example.com", "<ALICE@example.com>", true},
+		{"alice@example.com", "team@example.com", true},
+		{"alice@example.com", "orders@shop.example.com", true},
+		{"alice@example.com", "info@example.com", true},
+		{"alice@example.com", "bounce-7@example.com", true},
+		// The return path of the campaign of another user
+		{"alice@example.com", "bounce-8@example.com", false},
+		// A suffix of the delegated domain only
+		{"alice@example.com", "mallory@evilshop.example.com", false},
+		{"alice@example.com", "bob@example.com", false},
+		{"bob@example.com", "team@example.com", false},
+		{"bob@example.com", "info@example.com", false},
+	} {
+		err := AuthorizeSender(ctx, tc.user, tc.from)
+		if (err == nil) != tc.allowed {
+			t.Errorf("AuthorizeSender(%q, %q) = %v, want allowed %t", tc.user, tc.from, err, tc.allowed)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "553 5.7.1 ") {
+			t.Errorf("AuthorizeSender(%q, %q) = %q, want a 553 rejection", tc.user, tc.from, err)
+		}
+	}
+}
+
+func TestCheckSenderIdentity(t *testing.T) {
+	stubOptions(t, nil)
+	stubSenderLookups(t, nil, nil)
+
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		req  PolicyRequest
+		want string
+	}{
+		{"at RCPT", PolicyRequest{"protocol_state": "RCPT", "sasl_username": "alice@example.com", "sender": "bob@example.com"}, ActionDunno},
+		{"unauthenticated", PolicyRequest{"protocol_state": "END-OF-MESSAGE", "sender": "bob@example.com"}, ActionDunno},
+		{"own address", PolicyRequest{"protocol_state": "END-OF-MESSAGE", "sasl_username": "alice@example.com", "sender": "alice@example.com"}, ActionDunno},
+		{"spoofed", PolicyRequest{"protocol_state": "END-OF-MESSAGE", "sasl_username": "alice@example.com", "sender": "bob@example.com"},
+			"553 5.7.1 Sender address bob@example.com not owned by user alice@example.com"},
+	} {
+		if got := checkSenderIdentity(ctx, tc.req); got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/public"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -----------------------------
+// Per authenticated sender outbound rate limit, separate from the per-domain warmup
+// and per-campaign limits. Each sender has a messages and a bytes token bucket
+// refilled every minute, a message exceeding either bucket is deferred with a 4xx.
+// -----------------------------
+
+const senderRateOptionKey = "sender_rate_limits"
+
+// SenderRate configured limit of a sender, 0 means unlimited
+type SenderRate struct {
+	MsgsPerMin  int   `json:"msgs_per_min"`
+	BytesPerMin int64 `json:"bytes_per_min"`
+}
+
+// SenderRateUsage consumption of a sender in the current window
+type SenderRateUsage struct {
+	Sender      string `json:"sender"`
+	MsgsPerMin  int    `json:"msgs_per_min"`
+	BytesPerMin int64  `json:"bytes_per_min"`
+	MsgsUsed    int    `json:"msgs_used"`  // messages within the last minute
+	BytesUsed   int64  `json:"bytes_used"` // bytes within the last minute
+	Accepted    int64  `json:"accepted"`   // messages accepted since start
+	Deferred    int64  `json:"deferred"`   // messages deferred since start
+}
+
+// senderBucket token buckets of one sender
+type senderBucket struct {
+	msgs     float64
+	bytes    float64
+	updated  time.Time
+	accepted int64
+	deferred int64
+}
+
+type senderRateLimiter struct {
+	mutex   sync.Mutex
+	loaded  bool
+	limits  map[string]SenderRate
+	buckets map[string]*senderBucket
+	now     func() time.Time
+}
+
+var senderRates = &senderRateLimiter{
+	limits:  make(map[string]SenderRate),
+	buckets: make(map[string]*senderBucket),
+	now:     time.Now,
+}
+
+func init() {
+	RegisterCheck("sender_rate", checkSenderRate)
+}
+
+// SetSenderRate sets the limit of an authenticated sender, both values 0 remove the limit
+func SetSenderRate(ctx context.Context, sender string, msgsPerMin int, bytesPerMin int64) error {
+	sender = strings.ToLower(strings.TrimSpace(sender))
+	if sender == "" {
+		return fmt.Errorf("empty sender")
+	}
+
+	if msgsPerMin < 0 || bytesPerMin < 0 {
+		return fmt.Errorf("invalid rate for %s: %d msgs, %d bytes per minute", sender, msgsPerMin, bytesPerMin)
+	}
+
+	l := senderRates
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.load(ctx)
+
+	limits := make(map[string]SenderRate, len(l.limits)+1)
+	for k, v := range l.limits {
+		limits[k] = v
+	}
+
+	if msgsPerMin == 0 && bytesPerMin == 0 {
+		delete(limits, sender)
+	} else {
+		limits[sender] = SenderRate{MsgsPerMin: msgsPerMin, BytesPerMin: bytesPerMin}
+	}
+
+	if err := public.OptionsMgrInstance.SetOption(ctx, senderRateOptionKey, limits); err != nil {
+		return err
+	}
+
+	l.limits = limits
+	delete(l.buckets, sender)
+
+	return nil
+}
+
+// GetSenderRates returns the configured limits
+func GetSenderRates(ctx context.Context) map[string]SenderRate {
+	l := senderRates
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.load(ctx)
+
+	out := make(map[string]SenderRate, len(l.limits))
+	for k, v := range l.limits {
+		out[k] = v
+	}
+	return out
+}
+
+// GetSenderRateUsage returns the consumption of every limited sender
+func GetSenderRateUsage(ctx context.Context) []SenderRateUsage {
+	l := senderRates
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.load(ctx)
+
+	now := l.now()
+	list := make([]SenderRateUsage, 0, len(l.limits))
+
+	for sender, rate := range l.limits {
+		u := SenderRateUsage{Sender: sender, MsgsPerMin: rate.MsgsPerMin, BytesPerMin: rate.BytesPerMin}
+
+		if b, ok := l.buckets[sender]; ok {
+			l.refill(b, rate, now)
+			if rate.MsgsPerMin > 0 {
+				u.MsgsUsed = rate.MsgsPerMin - int(b.msgs)
+			}
+			if rate.BytesPerMin > 0 {
+				u.BytesUsed = rate.BytesPerMin - int64(b.bytes)
+			}
+			u.Accepted, u.Deferred = b.accepted, b.deferred
+		}
+
+		list = append(list, u)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Sender < list[j].Sender
+	})
+
+	return list
+}
+
+// load reads the limits from the options once, the caller holds the mutex
+func (l *senderRateLimiter) load(ctx context.Context) {
+	if l.loaded {
+		return
+	}
+
+	limits := make(map[string]SenderRate)
+	if err := getOption(ctx, senderRateOptionKey, &limits); err == nil {
+		l.limits = limits
+	}
+
+	l.loaded = true
+}
+
+// refill adds the tokens earned since the last update, buckets hold at most one minute of quota
+func (l *senderRateLimiter) refill(b *senderBucket, rate SenderRate, now time.Time) {
+	elapsed := now.Sub(b.updated).Minutes()
+	if elapsed <= 0 {
+		return
+	}
+
+	b.msgs = min(float64(rate.MsgsPerMin), b.msgs+elapsed*float64(rate.MsgsPerMin))
+	b.bytes = min(float64(rate.BytesPerMin), b.bytes+elapsed*float64(rate.BytesPerMin))
+	b.updated = now
+}
+
+// allow consumes one message of size bytes from the buckets of the sender
+func (l *senderRateLimiter) allow(ctx context.Context, sender string, size int64) (bool, SenderRate) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.load(ctx)
+
+	rate, ok := l.limits[sender]
+	if !ok {
+		return true, rate
+	}
+
+	now := l.now()
+
+	b, ok := l.buckets[sender]
+	if !ok {
+		b = &senderBucket{msgs: float64(rate.MsgsPerMin), bytes: float64(rate.BytesPerMin), updated: now}
+		l.buckets[sender] = b
+	}
+
+	l.refill(b, rate, now)
+
+	// A message larger than the whole byte quota passes when the bucket is full,
+	// otherwise it could never be sent
+	cost := float64(size)
+	if rate.BytesPerMin > 0 && cost > float64(rate.BytesPerMin) {
+		cost = float64(rate.BytesPerMin)
+	}
+
+	if (rate.MsgsPerMin > 0 && b.msgs < 1) || (rate.BytesPerMin > 0 && b.bytes < cost) {
+		b.deferred++
+		return false, rate
+	}
+
+	if rate.MsgsPerMin > 0 {
+		b.msgs--
+	}
+	if rate.BytesPerMin > 0 {
+		b.bytes -= cost
+	}
+	b.accepted++
+
+	return true, rate
+}
+
+// checkSenderRate policy check, applied at the end of the message when the size is known
+func checkSenderRate(ctx context.Context, req PolicyRequest) string {
+	if req.Stage() != "END-OF-MESSAGE" {
+		return ActionDunno
+	}
+
+	sender := strings.ToLower(req.Get("sasl_username"))
+	if sender == "" {
+		return ActionDunno
+	}
+
+	size, _ := strconv.ParseInt(req.Get("size"), 10, 64)
+
+	if ok, rate := senderRates.allow(ctx, sender, size); !ok {
+		return fmt.Sprintf("451 4.7.1 Sending rate of %s exceeded (%d messages, %d bytes per minute), try again later", sender, rate.MsgsPerMin, rate.BytesPerMin)
+	}
+
+	return ActionDunno
+}
@@ -0,0 +1,175 @@
+package smtp_policy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubSenderRates replaces the limiter of the checks with one of limits at the time of now
+func stubSenderRates(t *testing.T, limits map[string]SenderRate, now *time.Time) *senderRateLimiter {
+	t.Helper()
+
+	l := &senderRateLimiter{
+		loaded:  true,
+		limits:  limits,
+		buckets: make(map[string]*senderBucket),
+		now:     func() time.Time { return *now },
+	}
+
+	orig := senderRates
+	senderRates = l
+	t.Cleanup(func() { senderRates = orig })
+
+	return l
+}
+
+func TestSenderRateAllow(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+	type step struct {
+		after time.Duration // since the start
+		size  int64
+		allow bool
+	}
+
+	for _, tc := range []struct {
+		name  string
+		rate  SenderRate
+		steps []step
+	}{
+		{
+			name: "messages",
+			rate: SenderRate{MsgsPerMin: 2},
+			steps: []step{
+				{0, 100, true},
+				{0, 100, true},
+				{0, 100, false},
+				// Half a minute earns one message
+				{30 * time.Second, 100, true},
+				{30 * time.Second, 100, false},
+			},
+		},
+		{
+			name: "bytes",
+			rate: SenderRate{BytesPerMin: 1000},
+			steps: []step{
+				{0, 600, true},
+				{0, 600, false},
+				{0, 400, true},
+				{15 * time.Second, 300, false},
+				{30 * time.Second, 500, true},
+			},
+		},
+		{
+			name: "larger than the byte quota",
+			rate: SenderRate{BytesPerMin: 1000},
+			steps: []step{
+				// Passes on a full bucket, empties it
+				{0, 5000, true},
+				{0, 1, false},
+				{time.Minute, 5000, true},
+			},
+		},
+		{
+			name: "both",
+			rate: SenderRate{MsgsPerMin: 10, BytesPerMin: 1000},
+			steps: []step{
+				{0, 900, true},
+				// The messages left do not help the bytes
+				{0, 200, false},
+				{time.Minute, 200, true},
+			},
+		},
+		{
+			name: "refill capped to one minute",
+			rate: SenderRate{MsgsPerMin: 1},
+			steps: []step{
+				{0, 1, true},
+				{time.Hour, 1, true},
+				{time.Hour, 1, false},
+			},
+		},
+	} {
+		now := start
+		l := stubSenderRates(t, map[string]SenderRate{"a@example.com": tc.rate}, &now)
+
+		for i, s := range tc.steps {
+			now = start.Add(s.after)
+			if ok, _ := l.allow(ctx, "a@example.com", s.size); ok != s.allow {
+				t.Errorf("%s: step %d (%s, %d bytes): allowed %t, want %t", tc.name, i, s.after, s.size, ok, s.allow)
+			}
+		}
+
+		// Senders without limit are never deferred
+		if ok, _ := l.allow(ctx, "b@example.com", 1<<30); !ok {
+			t.Errorf("%s: sender without limit deferred", tc.name)
+		}
+	}
+}
+
+func TestSenderRateUsage(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	l := stubSenderRates(t, map[string]SenderRate{
+		"b@example.com": {MsgsPerMin: 2, BytesPerMin: 1000},
+		"a@example.com": {MsgsPerMin: 5},
+	}, &now)
+
+	for i := 0; i < 3; i++ {
+		l.allow(ctx, "b@example.com", 300)
+	}
+
+	usage := GetSenderRateUsage(ctx)
+	if len(usage) != 2 || usage[0].Sender != "a@example.com" || usage[1].Sender != "b@example.com" {
+		t.Fatalf("usage = %+v", usage)
+	}
+	if u := usage[0]; u.MsgsUsed != 0 || u.Accepted != 0 || u.Deferred != 0 {
+		t.Errorf("usage of an idle sender = %+v", u)
+	}
+	if u := usage[1]; u.MsgsUsed != 2 || u.BytesUsed != 600 || u.Accepted != 2 || u.Deferred != 1 {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestCheckSenderRate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	stubSenderRates(t, map[string]SenderRate{"a@example.com": {MsgsPerMin: 1}}, &now)
+
+	eom := PolicyRequest{"protocol_state": "END-OF-MESSAGE", "sasl_username": "A@example.com", "size": "100"}
+
+	for _, tc := range []struct {
+		name     string
+		req      PolicyRequest
+		deferred bool
+	}{
+		{"at RCPT", PolicyRequest{"protocol_state": "RCPT", "sasl_username": "a@example.com"}, false},
+		{"unauthenticated", PolicyRequest{"protocol_state": "END-OF-MESSAGE", "size": "100"}, false},
+		{"first message", eom, false},
+		{"over the rate", eom, true},
+	} {
+		got := checkSenderRate(ctx, tc.req)
+		if deferred := strings.HasPrefix(got, "451 4.7.1 "); deferred != tc.deferred {
+			t.Errorf("%s: %q, want deferred %t", tc.name, got, tc.deferred)
+		}
+	}
+}
+
+func TestSetSenderRateValidation(t *testing.T) {
+	for _, tc := range []struct {
+		sender string
+		msgs   int
+		bytes  int64
+	}{
+		{" ", 1, 0},
+		{"a@example.com", -1, 0},
+		{"a@example.com", 0, -1},
+	} {
+		if err := SetSenderRate(context.Background(), tc.sender, tc.msgs, tc.bytes); err == nil {
+			t.Errorf("SetSenderRate(%q, %d, %d) accepted", tc.sender, tc.msgs, tc.bytes)
+		}
+	}
+}
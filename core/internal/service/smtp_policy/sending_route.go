@@ -0,0 +1,89 @@
+package smtp_policy
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// -----------------------------
+// Sending routes of the submissions made by this service, e.g. of a campaign sent from a
+// chosen outbound IP. The route of a message is registered before it is submitted, at
+// the end of the message the policy service sends it through the transport of the route
+// instead of the route of its sender domain. A route matches the authenticated user, the
+// sender and the only recipient of the transaction.
+// -----------------------------
+
+type routeKey struct {
+	user      string
+	sender    string
+	recipient string
+}
+
+type sendingRoute struct {
+	transport string
+	id        uint64
+}
+
+var (
+	routesMutex sync.Mutex
+	routes      = make(map[routeKey]sendingRoute)
+	routeSeq    uint64
+)
+
+func init() {
+	RegisterCheck("sending_route", checkSendingRoute)
+}
+
+// RouteSubmission sends the message user submits from sender to recipient through the
+// master.cf transport until release is called. A later route of the same message
+// replaces it
+func RouteSubmission(user, sender, recipient, transport string) (release func()) {
+	key := routeKey{
+		user:      strings.ToLower(strings.TrimSpace(user)),
+		sender:    strings.ToLower(strings.TrimSpace(sender)),
+		recipient: strings.ToLower(strings.TrimSpace(recipient)),
+	}
+
+	routesMutex.Lock()
+	routeSeq++
+	id := routeSeq
+	routes[key] = sendingRoute{transport: transport, id: id}
+	routesMutex.Unlock()
+
+	return func() {
+		routesMutex.Lock()
+		defer routesMutex.Unlock()
+		if routes[key].id == id {
+			delete(routes, key)
+		}
+	}
+}
+
+// checkSendingRoute routes the single recipient messages of a registered submission
+func checkSendingRoute(ctx context.Context, req PolicyRequest) string {
+	if req.Stage() != "END-OF-MESSAGE" {
+		return ActionDunno
+	}
+
+	// The recipient is only known at the end of a single recipient message
+	key := routeKey{
+		user:      strings.ToLower(req.Get("sasl_username")),
+		sender:    strings.ToLower(req.Get("sender")),
+		recipient: strings.ToLower(req.Get("recipient")),
+	}
+	if key.user == "" || key.recipient == "" {
+		return ActionDunno
+	}
+
+	routesMutex.Lock()
+	route, ok := routes[key]
+	routesMutex.Unlock()
+
+	if !ok {
+		return ActionDunno
+	}
+
+	// Without next hop the transport delivers to the domain of the recipient
+	return "FILTER " + route.transport + ":"
+}
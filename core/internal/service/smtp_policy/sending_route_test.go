@@ -0,0 +1,47 @@
+package smtp_policy
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSendingRoute(t *testing.T) {
+	ctx := context.Background()
+
+	eom := func(user, sender, recipient string) PolicyRequest {
+		return PolicyRequest{"protocol_state": "END-OF-MESSAGE", "sasl_username": user, "sender": sender, "recipient": recipient}
+	}
+
+	release := RouteSubmission("Sender@example.com", "news@example.com", " Bob@example.org ", "smtp-ip2")
+
+	for _, tc := range []struct {
+		name string
+		req  PolicyRequest
+		want string
+	}{
+		{"routed", eom("sender@example.com", "News@example.com", "bob@example.org"), "FILTER smtp-ip2:"},
+		{"at RCPT", PolicyRequest{"protocol_state": "RCPT", "sasl_username": "sender@example.com", "sender": "news@example.com", "recipient": "bob@example.org"}, ActionDunno},
+		{"other recipient", eom("sender@example.com", "news@example.com", "carol@example.org"), ActionDunno},
+		{"other user", eom("other@example.com", "news@example.com", "bob@example.org"), ActionDunno},
+		// Several recipients, postfix sends no recipient at the end of the message
+		{"no recipient", eom("sender@example.com", "news@example.com", ""), ActionDunno},
+		{"unauthenticated", eom("", "news@example.com", "bob@example.org"), ActionDunno},
+	} {
+		if got := checkSendingRoute(ctx, tc.req); got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// A later route of the same message replaces it, the release of the first one
+	// leaves it in place
+	releaseLater := RouteSubmission("sender@example.com", "news@example.com", "bob@example.org", "smtp-ip3")
+	release()
+	if got := checkSendingRoute(ctx, eom("sender@example.com", "news@example.com", "bob@example.org")); got != "FILTER smtp-ip3:" {
+		t.Errorf("route after the release of the replaced one = %q", got)
+	}
+
+	releaseLater()
+	if got := checkSendingRoute(ctx, eom("sender@example.com", "news@example.com", "bob@example.org")); got != ActionDunno {
+		t.Errorf("released route = %q", got)
+	}
+}
@@ -0,0 +1,236 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/consts"
+	docker "billionmail-core/internal/service/dockerapi"
+	"billionmail-core/internal/service/public"
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// -----------------------------
+// Postfix policy delegation service (http://www.postfix.org/SMTPD_POLICY_README.html).
+// Postfix sends the attributes of each SMTP transaction stage, registered checks
+// decide whether the transaction continues. The first non DUNNO action wins.
+// -----------------------------
+
+const (
+	PolicyListenAddr  = ":10040"
+	PolicyServiceAddr = "inet:core:10040" // address of this service as seen by postfix
+	policyIdleTimeout = 5 * time.Minute
+)
+
+// Policy actions
+const (
+	ActionDunno = "DUNNO"
+)
+
+// PolicyRequest attributes sent by postfix for one policy query
+type PolicyRequest map[string]string
+
+// Get returns an attribute, empty when absent
+func (r PolicyRequest) Get(name string) string {
+	return r[name]
+}
+
+// Stage protocol state of the query, e.g. RCPT or END-OF-MESSAGE
+func (r PolicyRequest) Stage() string {
+	return r["protocol_state"]
+}
+
+// PolicyCheck returns the action for the request, ActionDunno (or empty) to let the next check decide
+type PolicyCheck func(ctx context.Context, req PolicyRequest) string
+
+// getOption reads the settings of the checks, replaced in the tests
+var getOption = public.OptionsMgrInstance.GetOption
+
+var (
+	checksMutex sync.RWMutex
+	checks      []namedCheck
+	startOnce   sync.Once
+)
+
+type namedCheck struct {
+	name  string
+	check PolicyCheck
+}
+
+// RegisterCheck adds a policy check, checks run in registration order
+func RegisterCheck(name string, check PolicyCheck) {
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+
+	checks = append(checks, namedCheck{name: name, check: check})
+}
+
+// Evaluate runs the registered checks on the request
+func Evaluate(ctx context.Context, req PolicyRequest) string {
+	checksMutex.RLock()
+	list := append([]namedCheck(nil), checks...)
+	checksMutex.RUnlock()
+
+	if replayBypassed(req) {
+		return ActionDunno
+	}
+
+	for _, c := range list {
+		action := c.check(ctx, req)
+		if action != "" && action != ActionDunno {
+			g.Log().Debugf(ctx, "Policy check %s: %s", c.name, action)
+			return action
+		}
+	}
+
+	return ActionDunno
+}
+
+// Start listens for postfix policy queries, it is a no-op when already started
+func Start(ctx context.Context) {
+	startOnce.Do(func() {
+		ln, err := net.Listen("tcp", PolicyListenAddr)
+		if err != nil {
+			g.Log().Warning(ctx, "Start SMTP policy service failed: ", err)
+			return
+		}
+
+		g.Log().Infof(ctx, "SMTP policy service listening on %s", PolicyListenAddr)
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					g.Log().Warning(ctx, "SMTP policy service accept failed: ", err)
+					time.Sleep(time.Second)
+					continue
+				}
+
+				go serveConn(ctx, conn)
+			}
+		}()
+	})
+}
+
+// serveConn handles the queries of one postfix connection, postfix reuses connections
+func serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(policyIdleTimeout))
+
+		req, err := readRequest(reader)
+		if err != nil {
+			return
+		}
+
+		action := Evaluate(ctx, req)
+
+		if _, err = fmt.Fprintf(conn, "action=%s\n\n", action); err != nil {
+			return
+		}
+	}
+}
+
+// readRequest reads "name=value" lines up to the terminating empty line
+func readRequest(reader *bufio.Reader) (PolicyRequest, error) {
+	req := make(PolicyRequest)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(req) == 0 {
+				continue
+			}
+			return req, nil
+		}
+
+		if k, v, ok := strings.Cut(line, "="); ok {
+			req[k] = v
+		}
+	}
+}
+
+// SyncPostfixPolicyConfig points the postfix recipient and end-of-data restrictions to this service.
+// Postfix falls back to DUNNO when the service is unreachable, so mail is never
+// blocked by an outage of the core service.
+func SyncPostfixPolicyConfig(ctx context.Context) error {
+	dk, err := docker.NewDockerAPI()
+	if err != nil {
+		return err
+	}
+	defer dk.Close()
+
+	// The RCPT stage is added to the existing recipient restrictions
+	res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postconf", "-h", "smtpd_recipient_restrictions"}, "root")
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("postconf failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	settings := []string{
+		"smtpd_end_of_data_restrictions=check_policy_service " + PolicyServiceAddr,
+		"smtpd_recipient_restrictions=" + withPolicyService(strings.TrimSpace(res.Output)),
+		"smtpd_policy_service_default_action=DUNNO",
+		"smtpd_policy_service_timeout=10s",
+	}
+
+	for _, s := range settings {
+		res, err := dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postconf", "-e", s}, "root")
+		if err != nil {
+			return err
+		}
+
+		if res.ExitCode != 0 {
+			return fmt.Errorf("postconf failed: %s", strings.TrimSpace(res.Output))
+		}
+	}
+
+	res, err = dk.ExecCommandByName(ctx, consts.SERVICES.Postfix, []string{"postfix", "reload"}, "root")
+	if err != nil {
+		return err
+	}
+
+	if res.ExitCode != 0 {
+		return fmt.Errorf("postfix reload failed: %s", strings.TrimSpace(res.Output))
+	}
+
+	g.Log().Infof(ctx, "Postfix policy service configured at %s", PolicyServiceAddr)
+
+	return nil
+}
+
+// withPolicyService adds the policy service to the recipient restrictions, after
+// reject_unauth_destination so relay attempts are refused before reaching it
+func withPolicyService(restrictions string) string {
+	check := "check_policy_service " + PolicyServiceAddr
+	if strings.Contains(restrictions, check) {
+		return restrictions
+	}
+	if restrictions == "" {
+		return check
+	}
+
+	if loc := unauthDestinationPattern.FindStringIndex(restrictions); loc != nil {
+		return restrictions[:loc[1]] + ", " + check + restrictions[loc[1]:]
+	}
+
+	return check + ", " + restrictions
+}
+
+var unauthDestinationPattern = regexp.MustCompile(`\breject_unauth_destination\b`)
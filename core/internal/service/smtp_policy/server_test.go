@@ -0,0 +1,112 @@
+package smtp_policy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubOptions serves the settings of the checks from values by option key, the options
+// not set are not found
+func stubOptions(t *testing.T, values map[string]interface{}) {
+	t.Helper()
+
+	orig := getOption
+	getOption = func(ctx context.Context, key string, ptr interface{}) error {
+		v, ok := values[key]
+		if !ok {
+			return errors.New("option not found")
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, ptr)
+	}
+	t.Cleanup(func() { getOption = orig })
+}
+
+func TestReadRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  []PolicyRequest
+	}{
+		{
+			name:  "single",
+			input: "request=smtpd_access_policy\nprotocol_state=RCPT\nsender=a@example.com\n\n",
+			want:  []PolicyRequest{{"request": "smtpd_access_policy", "protocol_state": "RCPT", "sender": "a@example.com"}},
+		},
+		{
+			name:  "crlf and value with =",
+			input: "protocol_state=END-OF-MESSAGE\r\nccert_subject=CN=mx\r\n\r\n",
+			want:  []PolicyRequest{{"protocol_state": "END-OF-MESSAGE", "ccert_subject": "CN=mx"}},
+		},
+		{
+			name:  "reused connection",
+			input: "protocol_state=RCPT\n\n\nprotocol_state=END-OF-MESSAGE\n\n",
+			want:  []PolicyRequest{{"protocol_state": "RCPT"}, {"protocol_state": "END-OF-MESSAGE"}},
+		},
+		{
+			name:  "malformed line ignored",
+			input: "garbage\nsender=\n\n",
+			want:  []PolicyRequest{{"sender": ""}},
+		},
+	} {
+		reader := bufio.NewReader(strings.NewReader(tc.input))
+
+		got := make([]PolicyRequest, 0)
+		for {
+			req, err := readRequest(reader)
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("%s: %v", tc.name, err)
+				}
+				break
+			}
+			got = append(got, req)
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: requests = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// A request cut off by the connection
+	if _, err := readRequest(bufio.NewReader(strings.NewReader("protocol_state=RCPT\n"))); err == nil {
+		t.Error("truncated request accepted")
+	}
+}
+
+func TestWithPolicyService(t *testing.T) {
+	check := "check_policy_service " + PolicyServiceAddr
+
+	for _, tc := range []struct {
+		restrictions string
+		want         string
+	}{
+		{"", check},
+		{
+			"permit_mynetworks, permit_sasl_authenticated, reject_unauth_destination",
+			"permit_mynetworks, permit_sasl_authenticated, reject_unauth_destination, " + check,
+		},
+		{
+			"permit_mynetworks, reject_unauth_destination, reject_unknown_recipient_domain",
+			"permit_mynetworks, reject_unauth_destination, " + check + ", reject_unknown_recipient_domain",
+		},
+		{"permit_mynetworks", check + ", permit_mynetworks"},
+		// Not the prefix of another restriction
+		{"reject_unauth_destination_x", check + ", reject_unauth_destination_x"},
+		// Already configured
+		{"reject_unauth_destination, " + check, "reject_unauth_destination, " + check},
+	} {
+		if got := withPolicyService(tc.restrictions); got != tc.want {
+			t.Errorf("withPolicyService(%q) = %q, want %q", tc.restrictions, got, tc.want)
+		}
+	}
+}
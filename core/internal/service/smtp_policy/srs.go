@@ -0,0 +1,27 @@
+package smtp_policy
+
+import (
+	"billionmail-core/internal/service/mail_service"
+	"context"
+)
+
+// -----------------------------
+// Recipient domain of the SRS rewriting. The canonical maps of postfix only see the
+// envelope sender, the recipients of the received mail are noted here for the SRS
+// table of the core, see mail_service.NoteSRSRecipient.
+// -----------------------------
+
+func init() {
+	RegisterCheck("srs", checkSRS)
+}
+
+// checkSRS notes the recipients of the received mail, it never decides
+func checkSRS(ctx context.Context, req PolicyRequest) string {
+	if req.Stage() != "RCPT" || req.Get("sasl_username") != "" {
+		return ActionDunno
+	}
+
+	mail_service.NoteSRSRecipient(ctx, req.Get("sender"), req.Get("recipient"))
+
+	return ActionDunno
+}
@@ -7,12 +7,17 @@ import (
 	"billionmail-core/internal/service/collect"
 	"billionmail-core/internal/service/domains"
 	"billionmail-core/internal/service/fail2ban"
+	"billionmail-core/internal/service/inbound"
+	"billionmail-core/internal/service/journal"
 	"billionmail-core/internal/service/log_maintenance"
 	"billionmail-core/internal/service/mail_boxes"
 	"billionmail-core/internal/service/mail_service"
 	"billionmail-core/internal/service/maillog_stat"
 	"billionmail-core/internal/service/multi_ip_domain"
+	"billionmail-core/internal/service/ops_digest"
 	"billionmail-core/internal/service/relay"
+	"billionmail-core/internal/service/smtp_policy"
+	"billionmail-core/internal/service/tls_report"
 	"billionmail-core/internal/service/warmup"
 	"context"
 	"time"
@@ -89,15 +94,47 @@ func Start(ctx context.Context) (err error) {
 	// Fix Postfix main configuration and Rspamd DKIM signing config
 	gtimer.AddOnce(5*time.Second, func() {
 		mail_service.FixPostfixMainConfig(ctx)
+		// Applied by the rspamd restart of the DKIM signing fix
+		if err := inbound.WriteARCSigningConfig(); err != nil {
+			g.Log().Warning(ctx, "Failed to write ARC signing config: ", err)
+		}
+		if err := inbound.WriteInboundPolicyConfig(ctx); err != nil {
+			g.Log().Warning(ctx, "Failed to write inbound policy config: ", err)
+		}
 		mail_service.FixRspamdDKIMSigningConfig(ctx)
 		mail_service.FixDovecotSSLConfig(ctx)
 	})
 
+	// Retry schedule of the deferred outbound mail
+	gtimer.AddOnce(5*time.Second, func() {
+		if err := mail_service.SyncRetryPolicy(ctx); err != nil {
+			g.Log().Warning(ctx, "SyncRetryPolicy failed: ", err)
+		}
+		if err := mail_service.SyncDSNTemplates(ctx); err != nil {
+			g.Log().Warning(ctx, "SyncDSNTemplates failed: ", err)
+		}
+	})
+	gtimer.Add(1*time.Minute, func() {
+		mail_service.ApplyRetrySchedule(ctx)
+	})
+
+	// SMTP dialogue of the listeners with protocol logging, copied to their own logs
+	gtimer.Add(30*time.Second, func() {
+		if err := mail_service.CollectProtocolLogs(ctx); err != nil {
+			g.Log().Warning(ctx, "CollectProtocolLogs failed: ", err)
+		}
+	})
+
 	// ========== Mail task processing: one executor per task ==========
 	gtimer.Add(5*time.Second, func() {
 		batch_mail.ProcessEmailTasks(ctx)
 	})
 
+	// Pause the campaigns whose bounce or complaint rate is past the circuit breaker thresholds
+	gtimer.Add(1*time.Minute, func() {
+		batch_mail.CheckCircuitBreakers(ctx)
+	})
+
 	// Idle actuators are cleaned every 10 minutes
 	gtimer.Add(10*time.Minute, func() {
 		batch_mail.CleanupIdleExecutors()
@@ -128,6 +165,44 @@ func Start(ctx context.Context) (err error) {
 		warmup.SenderIpMailProvider().PeriodicTaskForProviders(ctx)
 	})
 
+	// Postfix policy service (per-sender rate limits)
+	gtimer.AddOnce(800*time.Millisecond, func() {
+		smtp_policy.Start(ctx)
+	})
+
+	gtimer.AddOnce(5*time.Second, func() {
+		if err := smtp_policy.SyncPostfixPolicyConfig(ctx); err != nil {
+			g.Log().Warning(ctx, "SyncPostfixPolicyConfig failed: ", err)
+		}
+	})
+
+	// SRS canonical maps of the forwarded mail
+	gtimer.AddOnce(800*time.Millisecond, func() {
+		mail_service.StartSRSTables(ctx)
+	})
+
+	gtimer.AddOnce(5*time.Second, func() {
+		if err := mail_service.SyncSRSConfig(ctx); err != nil {
+			g.Log().Warning(ctx, "SyncSRSConfig failed: ", err)
+		}
+	})
+
+	// Mail journal receiver
+	gtimer.AddOnce(800*time.Millisecond, func() {
+		journal.Start(ctx)
+	})
+
+	gtimer.AddOnce(5*time.Second, func() {
+		if err := journal.SyncJournalToPostfix(ctx); err != nil {
+			g.Log().Warning(ctx, "SyncJournalToPostfix failed: ", err)
+		}
+	})
+
+	// VERP bounce receiver of the campaigns, its route is rendered by the transport map sync above
+	gtimer.AddOnce(800*time.Millisecond, func() {
+		batch_mail.StartVERPReceiver(ctx)
+	})
+
 	// fail2ban access logs detection
 	gtimer.AddOnce(800*time.Millisecond, fail2ban.NewAccessLogDetection().Start)
 
@@ -153,6 +228,11 @@ func Start(ctx context.Context) (err error) {
 		multi_ip_domain.ReapplyAllIptablesRules(ctx)
 	})
 
+	// warn of the outbound IPs whose HELO name does not match their reverse DNS
+	gtimer.AddOnce(10*time.Second, func() {
+		multi_ip_domain.ValidateHeloNames(ctx)
+	})
+
 	// Updated: Used space in the mailbox
 	gtimer.AddOnce(20*time.Second, func() {
 		mail_boxes.UpdateMailboxesUsedSpace()
@@ -162,6 +242,11 @@ func Start(ctx context.Context) (err error) {
 		mail_boxes.UpdateMailboxesUsedSpace()
 	})
 
+	// Record the last login of the mailboxes from the mail logs
+	gtimer.Add(1*time.Minute, func() {
+		mail_boxes.ScanLogins(ctx)
+	})
+
 	// Check the email quota and the alert for exceeding the quota
 	gtimer.AddOnce(1*time.Minute, func() {
 		mail_boxes.CheckMailboxesQuotaAlerts(ctx)
@@ -189,8 +274,55 @@ func Start(ctx context.Context) (err error) {
 		domains.CheckDomainsBlacklist(ctx)
 	})
 
-	gtimer.Add(24*time.Hour, func() {
+	// The interval of the log maintenance is configured, log_maintenance.run_interval
+	gtimer.Add(log_maintenance.RunInterval(), func() {
 		log_maintenance.CompressAndCleanupLogs(ctx)
+
+		// Purge old messages in Trash/Junk
+		if _, err := mail_boxes.ExpireAllMailboxes(ctx); err != nil {
+			g.Log().Warning(ctx, "ExpireAllMailboxes failed: ", err)
+		}
+
+		// Purge the deleted mailboxes past their grace period
+		if _, err := mail_boxes.PurgeDeletedMailboxes(ctx); err != nil {
+			g.Log().Warning(ctx, "PurgeDeletedMailboxes failed: ", err)
+		}
+	})
+
+	// Watch that the daily maintenance above keeps succeeding, from a goroutine of its own
+	log_maintenance.StartWatchdog(ctx)
+
+	// Delete the logs staged in the trash past their grace period, when enabled
+	gtimer.Add(time.Hour, func() {
+		log_maintenance.SweepLogTrash(ctx)
+	})
+
+	// Compress the log closed at midnight right away when enabled
+	gtimer.Add(1*time.Minute, func() {
+		log_maintenance.CompressRotatedLogs(ctx)
+	})
+
+	// Ship the new log lines to the central log store when enabled
+	gtimer.Add(1*time.Minute, func() {
+		log_maintenance.PushLogs(ctx)
+	})
+
+	// Re-verify the stored archives for bit rot, offset from the maintenance run
+	gtimer.AddOnce(12*time.Hour, func() {
+		log_maintenance.VerifyArchives(ctx)
+		gtimer.Add(24*time.Hour, func() {
+			log_maintenance.VerifyArchives(ctx)
+		})
+	})
+
+	// Send the operations digest when due, the cadence is configured
+	gtimer.Add(time.Hour, func() {
+		ops_digest.CheckOperationsDigest(ctx)
+	})
+
+	// Deliver the SMTP TLS reports of the previous day
+	gtimer.Add(time.Hour, func() {
+		tls_report.CheckTLSRPTReports(ctx)
 	})
 
 	g.Log().Debug(ctx, "All timers started successfully")
@@ -0,0 +1,160 @@
+package tls_report
+
+import (
+	"billionmail-core/internal/service/dns_resolver"
+	"billionmail-core/internal/service/mail_service"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// postTimeout bound of the POST of a report to an https rua
+const postTimeout = 30 * time.Second
+
+// lookupRua the report URIs of the TLS-RPT record of domain, none when it publishes
+// no valid record
+func lookupRua(ctx context.Context, domain string) ([]string, error) {
+	records, err := dns_resolver.Default().LookupTXT(ctx, "_smtp._tls."+domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	for _, record := range records {
+		if rua, ok := parseTLSRPTRecord(record); ok {
+			if found != nil {
+				// More than one record, the RFC has them all ignored
+				return nil, nil
+			}
+			found = rua
+		}
+	}
+	return found, nil
+}
+
+// parseTLSRPTRecord the mailto: and https: URIs of the rua of a TLS-RPT record
+func parseTLSRPTRecord(record string) ([]string, bool) {
+	fields := strings.Split(record, ";")
+	if strings.TrimSpace(fields[0]) != "v=TLSRPTv1" {
+		return nil, false
+	}
+
+	var rua []string
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || strings.TrimSpace(key) != "rua" {
+			continue
+		}
+		for _, uri := range strings.Split(value, ",") {
+			uri = strings.TrimSpace(uri)
+			lower := strings.ToLower(uri)
+			if strings.HasPrefix(lower, "mailto:") || strings.HasPrefix(lower, "https://") {
+				rua = append(rua, uri)
+			}
+		}
+	}
+	return rua, len(rua) > 0
+}
+
+// reportSender delivers the reports, sharing a mail connection between them
+type reportSender struct {
+	submitter string
+	mail      *mail_service.EmailSender
+	mailErr   error
+}
+
+func newReportSender(submitter string) *reportSender {
+	return &reportSender{submitter: submitter}
+}
+
+func (s *reportSender) close() {
+	if s.mail != nil {
+		s.mail.Close()
+	}
+}
+
+// deliver delivers the gzipped payload of report to a rua URI
+func (s *reportSender) deliver(ctx context.Context, uri string, report Report, payload []byte) error {
+	if strings.HasPrefix(strings.ToLower(uri), "https://") {
+		return postReport(ctx, uri, payload)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Opaque == "" {
+		return fmt.Errorf("invalid mailto URI")
+	}
+	rcpt, err := url.PathUnescape(u.Opaque)
+	if err != nil {
+		return fmt.Errorf("invalid mailto URI: %w", err)
+	}
+
+	return s.mailReport(rcpt, report, payload)
+}
+
+// mailReport mails the report to rcpt as a multipart/report
+func (s *reportSender) mailReport(rcpt string, report Report, payload []byte) error {
+	if s.mail == nil && s.mailErr == nil {
+		s.mail, s.mailErr = mail_service.NewEmailSenderWithLocal(fmt.Sprintf("noreply@%s", s.submitter))
+	}
+	if s.mailErr != nil {
+		return s.mailErr
+	}
+
+	policy := report.Policies[0]
+	domain := policy.Policy.PolicyDomain
+	subject := fmt.Sprintf("Report Domain: %s Submitter: %s Report-ID: <%s>", domain, s.submitter, report.ReportID)
+	content := fmt.Sprintf("This is an aggregate TLS report from %s for %s, %s to %s.\r\n\r\nSuccessful sessions: %d\r\nFailed sessions: %d\r\n",
+		s.submitter, domain, report.DateRange.StartDatetime, report.DateRange.EndDatetime,
+		policy.Summary.TotalSuccessfulSessionCount, policy.Summary.TotalFailureSessionCount)
+
+	msg := mail_service.NewMessage(subject, content)
+	msg.SetRealName(s.submitter + " TLS reports")
+	msg.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	msg.SetHeader("TLS-Report-Domain", domain)
+	msg.SetHeader("TLS-Report-Submitter", s.submitter)
+	msg.MultipartType = `report; report-type="tlsrpt"`
+	msg.Attachments = []mail_service.Attachment{{
+		Name:        reportFileName(s.submitter, report),
+		ContentType: "application/tlsrpt+gzip",
+		Data:        payload,
+	}}
+
+	return s.mail.Send(msg, []string{rcpt})
+}
+
+// reportFileName name of the report attachment:
+// submitter!policy-domain!begin-timestamp!end-timestamp.json.gz
+func reportFileName(submitter string, report Report) string {
+	begin, _ := time.Parse(time.RFC3339, report.DateRange.StartDatetime)
+	end, _ := time.Parse(time.RFC3339, report.DateRange.EndDatetime)
+	return fmt.Sprintf("%s!%s!%d!%d.json.gz", submitter, report.Policies[0].Policy.PolicyDomain, begin.Unix(), end.Unix())
+}
+
+// postReport POSTs the payload to an https rua
+func postReport(ctx context.Context, uri string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, postTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/tlsrpt+gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
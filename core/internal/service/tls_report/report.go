@@ -0,0 +1,267 @@
+package tls_report
+
+import (
+	"billionmail-core/internal/service/compress"
+	"billionmail-core/internal/service/maillog_stat"
+	"billionmail-core/internal/service/public"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// SMTP TLS reports (RFC 8460) of the outbound sessions. The sessions the mail log
+// analysis records in mailstat_tls_sessions are aggregated per recipient domain over a
+// UTC day into a JSON report, gzipped and delivered to the rua of the TXT record
+// _smtp._tls.<domain>: by mail as a multipart/report attachment for a mailto: address,
+// by a POST for an https: one. Only the domains publishing the record get a report,
+// which is the opt-in of the RFC. The sessions are not checked against MTA-STS, so
+// their policy is no-policy-found, tlsa when postfix reported a DANE failure.
+
+// lastReportDayOptionKey the UTC day of the last reports generated, as YYYY-MM-DD
+const lastReportDayOptionKey = "tls_report_last_day"
+
+// TLS-RPT policy types
+const (
+	policyTypeTLSA     = "tlsa"
+	policyTypeNoPolicy = "no-policy-found"
+)
+
+// Report SMTP TLS report of a policy domain
+type Report struct {
+	OrganizationName string          `json:"organization-name"`
+	DateRange        ReportDateRange `json:"date-range"`
+	ContactInfo      string          `json:"contact-info"`
+	ReportID         string          `json:"report-id"`
+	Policies         []ReportPolicy  `json:"policies"`
+}
+
+// ReportDateRange period the report covers
+type ReportDateRange struct {
+	StartDatetime string `json:"start-datetime"`
+	EndDatetime   string `json:"end-datetime"`
+}
+
+// ReportPolicy sessions under a policy
+type ReportPolicy struct {
+	Policy         PolicyDescriptor `json:"policy"`
+	Summary        PolicySummary    `json:"summary"`
+	FailureDetails []FailureDetail  `json:"failure-details,omitempty"`
+}
+
+// PolicyDescriptor policy applied to the sessions
+type PolicyDescriptor struct {
+	PolicyType   string `json:"policy-type"`
+	PolicyDomain string `json:"policy-domain"`
+}
+
+// PolicySummary session counts of a policy
+type PolicySummary struct {
+	TotalSuccessfulSessionCount int `json:"total-successful-session-count"`
+	TotalFailureSessionCount    int `json:"total-failure-session-count"`
+}
+
+// FailureDetail failed sessions of a result type to a receiving MX
+type FailureDetail struct {
+	ResultType          string `json:"result-type"`
+	ReceivingMxHostname string `json:"receiving-mx-hostname,omitempty"`
+	ReceivingIP         string `json:"receiving-ip,omitempty"`
+	FailedSessionCount  int    `json:"failed-session-count"`
+}
+
+// ReportDelivery outcome of the report of a policy domain
+type ReportDelivery struct {
+	PolicyDomain string   `json:"policy_domain"`
+	ReportID     string   `json:"report_id"`
+	Successful   int      `json:"successful"`
+	Failed       int      `json:"failed"`
+	Delivered    []string `json:"delivered"` // rua the report was delivered to
+	Errors       []string `json:"errors"`
+}
+
+// sessionCount sessions of a domain to an MX with a result
+type sessionCount struct {
+	PolicyDomain string `json:"policy_domain"`
+	MxHost       string `json:"mx_host"`
+	ReceivingIp  string `json:"receiving_ip"`
+	Result       string `json:"result"`
+	Sessions     int    `json:"sessions"`
+}
+
+// CheckTLSRPTReports generates the reports of the previous UTC day once it is over
+func CheckTLSRPTReports(ctx context.Context) {
+	day := time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+
+	var last string
+	_ = public.OptionsMgrInstance.GetOption(ctx, lastReportDayOptionKey, &last)
+	if last >= day {
+		return
+	}
+
+	// The day is marked first, a failed delivery is not retried with a second report
+	if err := public.OptionsMgrInstance.SetOption(ctx, lastReportDayOptionKey, day); err != nil {
+		g.Log().Warning(ctx, "Save TLS report day failed: ", err)
+		return
+	}
+
+	start, _ := time.Parse(time.DateOnly, day)
+	deliveries, err := GenerateTLSRPTReports(ctx, start)
+	if err != nil {
+		g.Log().Warning(ctx, "Generate TLS reports failed: ", err)
+		return
+	}
+
+	for _, d := range deliveries {
+		if len(d.Errors) > 0 {
+			g.Log().Warningf(ctx, "TLS report %s: %s", d.ReportID, strings.Join(d.Errors, "; "))
+		}
+	}
+}
+
+// GenerateTLSRPTReports aggregates the sessions of the UTC day of day per policy domain
+// and delivers the report of each domain publishing a TLS-RPT record
+func GenerateTLSRPTReports(ctx context.Context, day time.Time) ([]ReportDelivery, error) {
+	y, m, d := day.UTC().Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+
+	var counts []sessionCount
+	err := g.DB().Model("mailstat_tls_sessions").
+		Fields("policy_domain, mx_host, receiving_ip, result, count(*) as sessions").
+		Where("log_time >= ?", start.Unix()).
+		Where("log_time < ?", end.Unix()).
+		Group("policy_domain, mx_host, receiving_ip, result").
+		Scan(&counts)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query TLS sessions: %w", err)
+	}
+
+	submitter := submitterDomain()
+	reports := buildReports(counts, start, end, submitter)
+
+	sender := newReportSender(submitter)
+	defer sender.close()
+
+	deliveries := make([]ReportDelivery, 0, len(reports))
+	for _, report := range reports {
+		domain := report.Policies[0].Policy.PolicyDomain
+		delivery := ReportDelivery{
+			PolicyDomain: domain,
+			ReportID:     report.ReportID,
+			Successful:   report.Policies[0].Summary.TotalSuccessfulSessionCount,
+			Failed:       report.Policies[0].Summary.TotalFailureSessionCount,
+		}
+
+		rua, err := lookupRua(ctx, domain)
+		if err != nil || len(rua) == 0 {
+			// No report is wanted
+			continue
+		}
+
+		payload, err := reportPayload(report)
+		if err != nil {
+			delivery.Errors = append(delivery.Errors, err.Error())
+			deliveries = append(deliveries, delivery)
+			continue
+		}
+
+		for _, uri := range rua {
+			if err = sender.deliver(ctx, uri, report, payload); err != nil {
+				delivery.Errors = append(delivery.Errors, fmt.Sprintf("%s: %v", uri, err))
+				continue
+			}
+			delivery.Delivered = append(delivery.Delivered, uri)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// buildReports the report of each policy domain of counts, sorted by domain
+func buildReports(counts []sessionCount, start, end time.Time, submitter string) []Report {
+	byDomain := make(map[string]*ReportPolicy)
+	var domains []string
+
+	for _, c := range counts {
+		if c.PolicyDomain == "" || c.Sessions <= 0 {
+			continue
+		}
+
+		p, ok := byDomain[c.PolicyDomain]
+		if !ok {
+			p = &ReportPolicy{Policy: PolicyDescriptor{PolicyType: policyTypeNoPolicy, PolicyDomain: c.PolicyDomain}}
+			byDomain[c.PolicyDomain] = p
+			domains = append(domains, c.PolicyDomain)
+		}
+
+		if c.Result == maillog_stat.TLSResultSuccess {
+			p.Summary.TotalSuccessfulSessionCount += c.Sessions
+			continue
+		}
+
+		p.Summary.TotalFailureSessionCount += c.Sessions
+		if c.Result == maillog_stat.TLSResultTLSAInvalid || c.Result == maillog_stat.TLSResultDNSSECInvalid {
+			p.Policy.PolicyType = policyTypeTLSA
+		}
+		p.FailureDetails = append(p.FailureDetails, FailureDetail{
+			ResultType:          c.Result,
+			ReceivingMxHostname: c.MxHost,
+			ReceivingIP:         c.ReceivingIp,
+			FailedSessionCount:  c.Sessions,
+		})
+	}
+
+	sort.Strings(domains)
+	reports := make([]Report, 0, len(domains))
+	for _, domain := range domains {
+		p := byDomain[domain]
+		sort.Slice(p.FailureDetails, func(i, j int) bool {
+			a, b := p.FailureDetails[i], p.FailureDetails[j]
+			if a.ResultType != b.ResultType {
+				return a.ResultType < b.ResultType
+			}
+			if a.ReceivingMxHostname != b.ReceivingMxHostname {
+				return a.ReceivingMxHostname < b.ReceivingMxHostname
+			}
+			return a.ReceivingIP < b.ReceivingIP
+		})
+
+		reports = append(reports, Report{
+			OrganizationName: submitter,
+			DateRange: ReportDateRange{
+				StartDatetime: start.Format(time.RFC3339),
+				EndDatetime:   end.Add(-time.Second).Format(time.RFC3339),
+			},
+			ContactInfo: "postmaster@" + submitter,
+			// Stable for the day, a receiver sees a redelivery as the same report
+			ReportID: fmt.Sprintf("%s_%s@%s", start.Format(time.RFC3339), domain, submitter),
+			Policies: []ReportPolicy{*p},
+		})
+	}
+
+	return reports
+}
+
+// reportPayload the gzipped JSON of a report
+func reportPayload(report Report) ([]byte, error) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	return compress.Compress(data)
+}
+
+// submitterDomain domain the reports are submitted from
+func submitterDomain() string {
+	val, err := g.DB().Model("bm_options").Where("name", "default_sender_domain").Value("value")
+	if err == nil && val != nil && val.String() != "" {
+		return val.String()
+	}
+
+	return public.MustGetDockerEnv("BILLIONMAIL_HOSTNAME", "localhost")
+}
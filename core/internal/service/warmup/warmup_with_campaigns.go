@@ -15,6 +15,18 @@ type CampaignWarmupAssociation struct {
 	EstimatedSeconds int64 `json:"estimated_seconds"`
 }
 
+// providerGroups lists the mail provider groups that have their own sending limits.
+var providerGroups = []string{
+	consts.MailProviderGroupGmail,
+	consts.MailProviderGroupYahoo,
+	consts.MailProviderGroupOutlook,
+	consts.MailProviderGroupApple,
+	consts.MailProviderGroupProton,
+	consts.MailProviderGroupZoho,
+	consts.MailProviderGroupAmazon,
+	consts.MailProviderGroupOther,
+}
+
 // WarmupCampaignService manages the association between warmup processes and marketing campaigns.
 type WarmupCampaignService struct {
 	ipWarmupService *SenderIpWarmupService
@@ -116,16 +128,6 @@ func (s *WarmupCampaignService) CalculateEstimatedTime(ctx context.Context, task
 
 	// Calculate the total hourly sending rate
 	totalHourlyRate := 0
-	providerGroups := []string{
-		consts.MailProviderGroupGmail,
-		consts.MailProviderGroupYahoo,
-		consts.MailProviderGroupOutlook,
-		consts.MailProviderGroupApple,
-		consts.MailProviderGroupProton,
-		consts.MailProviderGroupZoho,
-		consts.MailProviderGroupAmazon,
-		consts.MailProviderGroupOther,
-	}
 
 	for _, group := range providerGroups {
 		dailyLimit, hourlyLimit, err := s.providerService.GetAdjustedSendingLimitsForProvider(ctx, senderIp, group)
@@ -144,6 +146,27 @@ func (s *WarmupCampaignService) CalculateEstimatedTime(ctx context.Context, task
 	return estimatedSeconds, nil
 }
 
+// DailyCapacity returns the number of messages the IP may send today across all provider groups while it is warming up.
+// warming is false when the IP has no warmup in progress, its sending is then not limited by the warmup.
+func (s *WarmupCampaignService) DailyCapacity(ctx context.Context, senderIp string) (capacity int, warming bool, err error) {
+	var warmupStatus *entity.SenderIpWarmup
+	err = g.DB().Model("bm_sender_ip_warmup").Ctx(ctx).Where("sender_ip", senderIp).Scan(&warmupStatus)
+	if err != nil || warmupStatus == nil || warmupStatus.Period == 0 || warmupStatus.Progress >= 100 {
+		return 0, false, err
+	}
+
+	for _, group := range providerGroups {
+		dailyLimit, _, err := s.providerService.GetAdjustedSendingLimitsForProvider(ctx, senderIp, group)
+		if err != nil {
+			g.Log().Warningf(ctx, "DailyCapacity: unable to get limits for group %s, IP %s: %v", group, senderIp, err)
+			continue
+		}
+		capacity += dailyLimit
+	}
+
+	return capacity, true, nil
+}
+
 // GetWarmupStatusForCampaign retrieves the warmup status for a given marketing task.
 func (s *WarmupCampaignService) GetWarmupStatusForCampaign(ctx context.Context, taskId int64) (*entity.SenderIpWarmup, error) {
 	var link *entity.CampaignWarmup
@@ -0,0 +1,19 @@
+// Package testlog keeps the logs of the tests out of the source tree. The paths of the
+// logger and of the server logs in the configuration are relative to the working
+// directory, which go test sets to the directory of the package tested, so the tests of
+// the packages that log import testlog for its side effect: their lines go to the
+// standard output only, shown by go test on failure or with -v.
+package testlog
+
+import (
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gcfg"
+)
+
+func init() {
+	// Before the first g.Log() or g.Server(), which create the paths of the configuration
+	if adapter, ok := g.Cfg().GetAdapter().(*gcfg.AdapterFile); ok {
+		_ = adapter.Set("logger.path", "")
+		_ = adapter.Set("server.logPath", "")
+	}
+}